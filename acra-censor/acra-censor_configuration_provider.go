@@ -38,6 +38,11 @@ type Config struct {
 		Tables   []string
 		Patterns []string
 		Filepath string
+		// Applications restricts handler to connections of these applications from startup parameters
+		Applications []string
+		// Databases restricts handler to connections which work with these databases (schemas in MySQL) selected on
+		// connection or by USE statement
+		Databases []string
 	}
 	IgnoreParseError bool `yaml:"ignore_parse_error"`
 }
@@ -60,7 +65,7 @@ func (acraCensor *AcraCensor) LoadConfiguration(configuration []byte) error {
 			if err != nil {
 				return err
 			}
-			acraCensor.AddHandler(whitelistHandler)
+			acraCensor.addScopedHandler(whitelistHandler, handlerConfiguration.Applications, handlerConfiguration.Databases)
 			break
 		case BlacklistConfigStr:
 			blacklistHandler := handlers.NewBlacklistHandler()
@@ -70,7 +75,7 @@ func (acraCensor *AcraCensor) LoadConfiguration(configuration []byte) error {
 			if err != nil {
 				return err
 			}
-			acraCensor.AddHandler(blacklistHandler)
+			acraCensor.addScopedHandler(blacklistHandler, handlerConfiguration.Applications, handlerConfiguration.Databases)
 			break
 		case QueryCaptureConfigStr:
 			if strings.EqualFold(handlerConfiguration.Filepath, "") {
@@ -80,12 +85,12 @@ func (acraCensor *AcraCensor) LoadConfiguration(configuration []byte) error {
 			if err != nil {
 				return err
 			}
-			acraCensor.AddHandler(queryCaptureHandler)
+			acraCensor.addScopedHandler(queryCaptureHandler, handlerConfiguration.Applications, handlerConfiguration.Databases)
 			break
 		case QueryIgnoreConfigStr:
 			queryIgnoreHandler := handlers.NewQueryIgnoreHandler()
 			queryIgnoreHandler.AddQueries(handlerConfiguration.Queries)
-			acraCensor.AddHandler(queryIgnoreHandler)
+			acraCensor.addScopedHandler(queryIgnoreHandler, handlerConfiguration.Applications, handlerConfiguration.Databases)
 			break
 		default:
 			break
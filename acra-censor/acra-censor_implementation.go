@@ -26,14 +26,17 @@ const ServiceName = "acra-censor"
 
 // AcraCensor describes censor data: query handler, logger and reaction on parsing errors.
 type AcraCensor struct {
-	handlers         []QueryHandlerInterface
+	handlers []QueryHandlerInterface
+	// scopes restrict handlers to connections of some applications or databases, handlers without scope are applied
+	// to all queries
+	scopes           map[QueryHandlerInterface]handlerScope
 	ignoreParseError bool
 	logger           *log.Entry
 }
 
 // NewAcraCensor creates new censor object.
 func NewAcraCensor() *AcraCensor {
-	acraCensor := &AcraCensor{}
+	acraCensor := &AcraCensor{scopes: make(map[QueryHandlerInterface]handlerScope)}
 	acraCensor.logger = log.WithField("service", ServiceName)
 	acraCensor.ignoreParseError = false
 	return acraCensor
@@ -51,6 +54,48 @@ func (acraCensor *AcraCensor) RemoveHandler(handler QueryHandlerInterface) {
 			acraCensor.handlers = append(acraCensor.handlers[:index], acraCensor.handlers[index+1:]...)
 		}
 	}
+	delete(acraCensor.scopes, handler)
+}
+
+// handlerScope is set of applications and databases which connections are checked by handler, nil set doesn't
+// restrict handler
+type handlerScope struct {
+	applications map[string]bool
+	databases    map[string]bool
+}
+
+// newScopeSet returns set of values or nil if values are empty
+func newScopeSet(values []string) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
+// addScopedHandler adds handler applied only to queries of connections of applications which work with databases.
+// Handler without applications and databases is applied to all queries
+func (acraCensor *AcraCensor) addScopedHandler(handler QueryHandlerInterface, applications, databases []string) {
+	acraCensor.AddHandler(handler)
+	if len(applications) == 0 && len(databases) == 0 {
+		return
+	}
+	acraCensor.scopes[handler] = handlerScope{applications: newScopeSet(applications), databases: newScopeSet(databases)}
+}
+
+// isApplied returns true if handler should check query of connection
+func (acraCensor *AcraCensor) isApplied(handler QueryHandlerInterface, connection ConnectionInfo) bool {
+	scope, ok := acraCensor.scopes[handler]
+	if !ok {
+		return true
+	}
+	if scope.applications != nil && !scope.applications[connection.Application] {
+		return false
+	}
+	return scope.databases == nil || scope.databases[connection.Database]
 }
 
 // ReleaseAll stops all handlers.
@@ -62,17 +107,36 @@ func (acraCensor *AcraCensor) ReleaseAll() {
 	}
 }
 
-// HandleQuery processes every query through each handler.
+// HandleQuery processes every query through each handler which isn't restricted to some applications or databases.
 func (acraCensor *AcraCensor) HandleQuery(query string) error {
+	return acraCensor.HandleConnectionQuery(ConnectionInfo{}, query)
+}
+
+// HandleConnectionQuery processes query of connection through each handler applied to connection's application and
+// database.
+func (acraCensor *AcraCensor) HandleConnectionQuery(connection ConnectionInfo, query string) error {
 	if len(acraCensor.handlers) == 0 {
 		// no handlers, AcraCensor won't work
 		return nil
 	}
+	logger := acraCensor.logger
+	if connection.ClientID != nil {
+		logger = logger.WithField("client_id", string(connection.ClientID))
+	}
+	if connection.Application != "" {
+		logger = logger.WithField("application", connection.Application)
+	}
+	if connection.Database != "" {
+		logger = logger.WithField("database", connection.Database)
+	}
 	normalizedQuery, queryWithHiddenValues, err := handlers.NormalizeAndRedactSQLQuery(query)
 	if err == handlers.ErrQuerySyntaxError && acraCensor.ignoreParseError {
-		acraCensor.logger.WithError(err).Infof("Parsing error on query (first %v symbols): %s", handlers.LogQueryLength, handlers.TrimStringToN(queryWithHiddenValues, handlers.LogQueryLength))
+		logger.WithError(err).Infof("Parsing error on query (first %v symbols): %s", handlers.LogQueryLength, handlers.TrimStringToN(queryWithHiddenValues, handlers.LogQueryLength))
 	}
 	for _, handler := range acraCensor.handlers {
+		if !acraCensor.isApplied(handler, connection) {
+			continue
+		}
 		// in QueryCapture Handler use only redacted queries
 		if queryCaptureHandler, ok := handler.(*handlers.QueryCaptureHandler); ok {
 			queryCaptureHandler.CheckQuery(queryWithHiddenValues)
@@ -84,15 +148,15 @@ func (acraCensor *AcraCensor) HandleQuery(query string) error {
 			if err == handlers.ErrQuerySyntaxError && acraCensor.ignoreParseError {
 				continue
 			}
-			acraCensor.logger.Errorf("Forbidden query: '%s'", queryWithHiddenValues)
+			logger.Errorf("Forbidden query: '%s'", queryWithHiddenValues)
 			return err
 		}
 		//we don't have errors so allow query
 		if !continueHandling {
-			acraCensor.logger.Infof("Allowed query: '%s'", queryWithHiddenValues)
+			logger.Infof("Allowed query: '%s'", queryWithHiddenValues)
 			return nil
 		}
 	}
-	acraCensor.logger.Infof("Allowed query: '%s'", queryWithHiddenValues)
+	logger.Infof("Allowed query: '%s'", queryWithHiddenValues)
 	return nil
 }
@@ -28,9 +28,20 @@ type QueryHandlerInterface interface {
 	Release()
 }
 
+// ConnectionInfo describes client's connection which sent query. Handlers may be applied only to connections of
+// some applications or to queries to some databases
+type ConnectionInfo struct {
+	ClientID []byte
+	// Application is name of client's application from startup parameters of connection, may be empty
+	Application string
+	// Database is current database (schema in MySQL) of connection, may be empty
+	Database string
+}
+
 // AcraCensorInterface describes main AcraCensor methods: adding and removing query handlers and processing query
 type AcraCensorInterface interface {
 	HandleQuery(sqlQuery string) error
+	HandleConnectionQuery(connection ConnectionInfo, sqlQuery string) error
 	AddHandler(handler QueryHandlerInterface)
 	RemoveHandler(handler QueryHandlerInterface)
 	ReleaseAll()
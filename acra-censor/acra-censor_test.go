@@ -1507,3 +1507,80 @@ func TestIgnoringQueryParseErrors(t *testing.T) {
 	// check when censor with two handlers and each one will return query parse error
 	checkHandler([]QueryHandlerInterface{whitelist, blacklist}, nil)
 }
+func TestApplicationScopedHandlers(t *testing.T) {
+	configuration := `handlers:
+  - handler: blacklist
+    applications:
+      - admin-cli
+    tables:
+      - users
+  - handler: blacklist
+    tables:
+      - secrets
+`
+	acraCensor := NewAcraCensor()
+	defer acraCensor.ReleaseAll()
+	if err := acraCensor.LoadConfiguration([]byte(configuration)); err != nil {
+		t.Fatal(err)
+	}
+	adminCLI := ConnectionInfo{ClientID: []byte("client"), Application: "admin-cli"}
+	reporting := ConnectionInfo{ClientID: []byte("client"), Application: "reporting-service"}
+	if err := acraCensor.HandleConnectionQuery(adminCLI, "SELECT * FROM users"); err != handlers.ErrAccessToForbiddenTableBlacklist {
+		t.Fatalf("Expected blocked query of admin-cli, took %v", err)
+	}
+	if err := acraCensor.HandleConnectionQuery(reporting, "SELECT * FROM users"); err != nil {
+		t.Fatalf("Expected allowed query of other application, took %v", err)
+	}
+	if err := acraCensor.HandleQuery("SELECT * FROM users"); err != nil {
+		t.Fatalf("Expected allowed query of unknown application, took %v", err)
+	}
+	// handler without applications is applied to all connections
+	for _, connection := range []ConnectionInfo{adminCLI, reporting, {}} {
+		if err := acraCensor.HandleConnectionQuery(connection, "SELECT * FROM secrets"); err != handlers.ErrAccessToForbiddenTableBlacklist {
+			t.Fatalf("Expected blocked query of %q, took %v", connection.Application, err)
+		}
+	}
+}
+
+func TestDatabaseScopedHandlers(t *testing.T) {
+	configuration := `handlers:
+  - handler: blacklist
+    databases:
+      - payments
+    tables:
+      - users
+  - handler: blacklist
+    applications:
+      - reporting-service
+    databases:
+      - analytics
+    tables:
+      - events
+`
+	acraCensor := NewAcraCensor()
+	defer acraCensor.ReleaseAll()
+	if err := acraCensor.LoadConfiguration([]byte(configuration)); err != nil {
+		t.Fatal(err)
+	}
+	payments := ConnectionInfo{Application: "reporting-service", Database: "payments"}
+	analytics := ConnectionInfo{Application: "reporting-service", Database: "analytics"}
+	otherAnalytics := ConnectionInfo{Application: "admin-cli", Database: "analytics"}
+	if err := acraCensor.HandleConnectionQuery(payments, "SELECT * FROM users"); err != handlers.ErrAccessToForbiddenTableBlacklist {
+		t.Fatalf("Expected blocked query to payments, took %v", err)
+	}
+	if err := acraCensor.HandleConnectionQuery(analytics, "SELECT * FROM users"); err != nil {
+		t.Fatalf("Expected allowed query to other database, took %v", err)
+	}
+	if err := acraCensor.HandleQuery("SELECT * FROM users"); err != nil {
+		t.Fatalf("Expected allowed query to unknown database, took %v", err)
+	}
+	// both application and database should match
+	if err := acraCensor.HandleConnectionQuery(analytics, "SELECT * FROM events"); err != handlers.ErrAccessToForbiddenTableBlacklist {
+		t.Fatalf("Expected blocked query of reporting-service to analytics, took %v", err)
+	}
+	for _, connection := range []ConnectionInfo{payments, otherAnalytics} {
+		if err := acraCensor.HandleConnectionQuery(connection, "SELECT * FROM events"); err != nil {
+			t.Fatalf("Expected allowed query of %q to %q, took %v", connection.Application, connection.Database, err)
+		}
+	}
+}
@@ -25,6 +25,7 @@ package handlers
 
 import (
 	"errors"
+	"github.com/cossacklabs/acra/sqldialect"
 	log "github.com/sirupsen/logrus"
 	"github.com/xwb1989/sqlparser"
 	"github.com/xwb1989/sqlparser/dependency/querypb"
@@ -90,23 +91,23 @@ func NormalizeAndRedactSQLQuery(sql string) (normalizedQuery string, redactedQue
 	// sometimes queries might have ; at the end, that should be stripped
 	sqlStripped = strings.TrimSuffix(sqlStripped, ";")
 
-	stmt, err := sqlparser.Parse(sqlStripped)
+	stmt, extensions, err := sqldialect.Parse(sqlStripped)
 	if err != nil {
 		return "", "", err
 	}
 
-	normalizedQ := sqlparser.String(stmt)
+	normalizedQ := extensions.Wrap(sqlparser.String(stmt))
 
 	// redact and mask VALUES
 	sqlparser.Normalize(stmt, bv, ValuePlaceholder)
-	redactedQ := sqlparser.String(stmt)
+	redactedQ := extensions.Wrap(sqlparser.String(stmt))
 
 	return normalizedQ, redactedQ, nil
 }
 
 func checkPatternsMatching(patterns [][]sqlparser.SQLNode, query string) (bool, error) {
 	var queryNodes []sqlparser.SQLNode
-	statement, err := sqlparser.Parse(query)
+	statement, _, err := sqldialect.Parse(query)
 	if err != nil {
 		log.WithError(err).Errorln("Can't parse query")
 		return false, ErrQuerySyntaxError
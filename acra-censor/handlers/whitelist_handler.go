@@ -18,6 +18,7 @@ package handlers
 
 import (
 	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/sqldialect"
 	"github.com/xwb1989/sqlparser"
 	"strings"
 
@@ -55,7 +56,7 @@ func (handler *WhitelistHandler) CheckQuery(query string) (bool, error) {
 	}
 	//Check tables
 	if len(handler.tables) != 0 {
-		parsedQuery, err := sqlparser.Parse(query)
+		parsedQuery, _, err := sqldialect.Parse(query)
 		if err != nil {
 			handler.logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCensorQueryParseError).WithError(ErrQuerySyntaxError).Errorln("Query has been blocked by whitelist [tables]. Parsing error")
 			return false, ErrQuerySyntaxError
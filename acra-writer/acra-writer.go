@@ -16,7 +16,8 @@ limitations under the License.
 
 // Package acrawriter provides public function CreateAcrastruct for generating
 // acrastruct in your applications for encrypting on client-side and inserting
-// to database.
+// to database. StreamWriter and StreamReader encrypt data of any size as it is written or read, producing
+// AcraStream which is decrypted with base.NewStreamDecryptor.
 //
 // https://github.com/cossacklabs/acra/wiki/AcraConnector-and-AcraWriter
 package acrawriter
@@ -26,6 +27,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/acra/utils"
 	"github.com/cossacklabs/themis/gothemis/cell"
 	"github.com/cossacklabs/themis/gothemis/keys"
@@ -76,3 +78,16 @@ func CreateAcrastruct(data []byte, acraPublic *keys.PublicKey, context []byte) (
 	output = append(output, encryptedData...)
 	return output, nil
 }
+
+// CreateRowSecureCell encrypts data of one row into Secure Cell in seal mode with key derived from symmetric key of
+// client or zone and rowContext (for example table name and primary key), so each row is encrypted with its own key.
+// AcraTranslator decrypts it with the same rowContext passed as row_context
+func CreateRowSecureCell(data, symmetricKey, rowContext, context []byte) ([]byte, error) {
+	rowKey, err := keystore.DeriveRowKey(symmetricKey, rowContext)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.FillSlice(byte(0), rowKey)
+	encrypted, _, err := cell.New(rowKey, cell.CELL_MODE_SEAL).Protect(data, context)
+	return encrypted, err
+}
@@ -21,6 +21,7 @@ import (
 	"encoding/binary"
 	"github.com/cossacklabs/acra/acra-writer"
 	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/themis/gothemis/cell"
 	"github.com/cossacklabs/themis/gothemis/keys"
 	"github.com/cossacklabs/themis/gothemis/message"
@@ -70,3 +71,29 @@ func TestCreateAcrastruct(t *testing.T) {
 		t.Fatal("Decrypted data not equal to original data")
 	}
 }
+
+func TestCreateRowSecureCell(t *testing.T) {
+	symmetricKey := []byte("symmetric key of client")
+	data := []byte("some data")
+	encrypted, err := acrawriter.CreateRowSecureCell(data, symmetricKey, []byte("users:1"), []byte("context"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rowKey, err := keystore.DeriveRowKey(symmetricKey, []byte("users:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := cell.New(rowKey, cell.CELL_MODE_SEAL).Unprotect(encrypted, nil, []byte("context"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatal("Decrypted data differs from encrypted")
+	}
+	if _, err := cell.New(symmetricKey, cell.CELL_MODE_SEAL).Unprotect(encrypted, nil, []byte("context")); err == nil {
+		t.Fatal("Row is encrypted with symmetric key instead of row key")
+	}
+	if _, err := acrawriter.CreateRowSecureCell(data, symmetricKey, nil, nil); err != keystore.ErrEmptyRowContext {
+		t.Fatalf("Expected ErrEmptyRowContext, took %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acrawriter
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/utils"
+	"github.com/cossacklabs/themis/gothemis/cell"
+	"github.com/cossacklabs/themis/gothemis/keys"
+	"github.com/cossacklabs/themis/gothemis/message"
+)
+
+// Errors returned by stream encryptors
+var (
+	ErrStreamClosed        = errors.New("write to closed stream")
+	ErrInvalidStreamChunks = errors.New("chunk size should be in range from 1 to base.MaxStreamChunkSize")
+)
+
+// streamEncryptor generates stream header and encrypts chunks with one symmetric key
+type streamEncryptor struct {
+	header    []byte
+	scell     *cell.SecureCell
+	symmetric []byte
+	context   []byte
+	index     uint64
+}
+
+func newStreamEncryptor(acraPublic *keys.PublicKey, context []byte) (*streamEncryptor, error) {
+	randomKeyPair, err := keys.New(keys.KEYTYPE_EC)
+	if err != nil {
+		return nil, err
+	}
+	randomKey := make([]byte, base.SymmetricKeySize)
+	if _, err := rand.Read(randomKey); err != nil {
+		return nil, err
+	}
+	smessage := message.New(randomKeyPair.Private, acraPublic)
+	encryptedKey, err := smessage.Wrap(randomKey)
+	if err != nil {
+		return nil, err
+	}
+	utils.FillSlice('0', randomKeyPair.Private.Value)
+
+	header := make([]byte, 0, base.GetStreamHeaderLength())
+	header = append(header, base.StreamTagBegin...)
+	header = append(header, randomKeyPair.Public.Value...)
+	header = append(header, encryptedKey...)
+	return &streamEncryptor{
+		header:    header,
+		scell:     cell.New(randomKey, cell.CELL_MODE_SEAL),
+		symmetric: randomKey,
+		context:   context,
+	}, nil
+}
+
+// encryptChunk returns encrypted chunk with length prefix
+func (encryptor *streamEncryptor) encryptChunk(data []byte, final bool) ([]byte, error) {
+	chunk := make([]byte, 1+len(data))
+	chunk[0] = base.StreamChunkFlagNext
+	if final {
+		chunk[0] = base.StreamChunkFlagFinal
+	}
+	copy(chunk[1:], data)
+	encrypted, _, err := encryptor.scell.Protect(chunk, base.StreamChunkContext(encryptor.context, encryptor.index))
+	utils.FillSlice('0', chunk)
+	if err != nil {
+		return nil, err
+	}
+	encryptor.index++
+	if final {
+		utils.FillSlice('0', encryptor.symmetric)
+	}
+	output := make([]byte, base.StreamChunkLengthSize, base.StreamChunkLengthSize+len(encrypted))
+	binary.LittleEndian.PutUint32(output, uint32(len(encrypted)))
+	return append(output, encrypted...), nil
+}
+
+func validateChunkSize(chunkSize int) (int, error) {
+	if chunkSize == 0 {
+		return base.DefaultStreamChunkSize, nil
+	}
+	if chunkSize < 0 || chunkSize > base.MaxStreamChunkSize {
+		return 0, ErrInvalidStreamChunks
+	}
+	return chunkSize, nil
+}
+
+// StreamWriter encrypts data as it is written and writes AcraStream to underlying writer. Close should be called to
+// write final chunk, without it stream can't be decrypted
+type StreamWriter struct {
+	output    io.Writer
+	encryptor *streamEncryptor
+	buffer    []byte
+	chunkSize int
+	closed    bool
+}
+
+// NewStreamWriter writes header of AcraStream encrypted with acraPublic key and context (optional) to output and
+// returns writer of data. chunkSize is max size of data in one chunk, base.DefaultStreamChunkSize used if it's 0
+func NewStreamWriter(output io.Writer, acraPublic *keys.PublicKey, context []byte, chunkSize int) (*StreamWriter, error) {
+	chunkSize, err := validateChunkSize(chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	encryptor, err := newStreamEncryptor(acraPublic, context)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := output.Write(encryptor.header); err != nil {
+		return nil, err
+	}
+	return &StreamWriter{output: output, encryptor: encryptor, buffer: make([]byte, 0, chunkSize), chunkSize: chunkSize}, nil
+}
+
+// Write buffers data and writes encrypted chunks when more than chunk size is buffered
+func (writer *StreamWriter) Write(p []byte) (int, error) {
+	if writer.closed {
+		return 0, ErrStreamClosed
+	}
+	written := 0
+	for len(p) > 0 {
+		// buffered chunk isn't last because more data is written
+		if len(writer.buffer) == writer.chunkSize {
+			if err := writer.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := writer.chunkSize - len(writer.buffer)
+		if n > len(p) {
+			n = len(p)
+		}
+		writer.buffer = append(writer.buffer, p[:n]...)
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (writer *StreamWriter) flush(final bool) error {
+	chunk, err := writer.encryptor.encryptChunk(writer.buffer, final)
+	utils.FillSlice('0', writer.buffer)
+	writer.buffer = writer.buffer[:0]
+	if err != nil {
+		return err
+	}
+	_, err = writer.output.Write(chunk)
+	return err
+}
+
+// Close writes final chunk with buffered data. Underlying writer isn't closed
+func (writer *StreamWriter) Close() error {
+	if writer.closed {
+		return ErrStreamClosed
+	}
+	writer.closed = true
+	return writer.flush(true)
+}
+
+// StreamReader reads data from underlying reader and returns it encrypted as AcraStream, for example to send
+// encrypted upload as body of request without loading it into memory
+type StreamReader struct {
+	input     io.Reader
+	encryptor *streamEncryptor
+	chunkSize int
+	buffer    []byte
+	output    []byte
+	finished  bool
+	err       error
+}
+
+// NewStreamReader returns reader of AcraStream with data read from input encrypted with acraPublic key and context
+// (optional). chunkSize is max size of data in one chunk, base.DefaultStreamChunkSize used if it's 0
+func NewStreamReader(input io.Reader, acraPublic *keys.PublicKey, context []byte, chunkSize int) (*StreamReader, error) {
+	chunkSize, err := validateChunkSize(chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	encryptor, err := newStreamEncryptor(acraPublic, context)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamReader{
+		input:     input,
+		encryptor: encryptor,
+		chunkSize: chunkSize,
+		// one byte more than chunk to know whether buffered chunk is last
+		buffer: make([]byte, 0, chunkSize+1),
+		output: encryptor.header,
+	}, nil
+}
+
+// Read returns encrypted stream
+func (reader *StreamReader) Read(p []byte) (int, error) {
+	for len(reader.output) == 0 {
+		if reader.err != nil {
+			return 0, reader.err
+		}
+		if reader.finished {
+			return 0, io.EOF
+		}
+		reader.err = reader.nextChunk()
+	}
+	n := copy(p, reader.output)
+	reader.output = reader.output[n:]
+	return n, nil
+}
+
+// nextChunk reads data from input until more than chunk size is buffered or input ends and encrypts chunk
+func (reader *StreamReader) nextChunk() error {
+	for len(reader.buffer) <= reader.chunkSize {
+		n, err := reader.input.Read(reader.buffer[len(reader.buffer):cap(reader.buffer)])
+		reader.buffer = reader.buffer[:len(reader.buffer)+n]
+		if err == io.EOF {
+			reader.finished = true
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	size := len(reader.buffer)
+	if !reader.finished {
+		size = reader.chunkSize
+	}
+	chunk, err := reader.encryptor.encryptChunk(reader.buffer[:size], reader.finished)
+	if err != nil {
+		return err
+	}
+	rest := copy(reader.buffer, reader.buffer[size:])
+	utils.FillSlice('0', reader.buffer[rest:])
+	reader.buffer = reader.buffer[:rest]
+	reader.output = chunk
+	return nil
+}
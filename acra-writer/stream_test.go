@@ -0,0 +1,198 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acrawriter_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/cossacklabs/acra/acra-writer"
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/themis/gothemis/keys"
+)
+
+// oneByteReader returns data by one byte to test reads of partial chunks
+type oneByteReader struct {
+	data []byte
+}
+
+func (reader *oneByteReader) Read(p []byte) (int, error) {
+	if len(reader.data) == 0 {
+		return 0, io.EOF
+	}
+	p[0] = reader.data[0]
+	reader.data = reader.data[1:]
+	return 1, nil
+}
+
+func decryptStream(t *testing.T, stream []byte, privateKey *keys.PrivateKey, context []byte) ([]byte, error) {
+	decryptor, err := base.NewStreamDecryptor(bytes.NewReader(stream), privateKey, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ioutil.ReadAll(decryptor)
+}
+
+func TestStreamWriterAndReader(t *testing.T) {
+	keypair, err := keys.New(keys.KEYTYPE_EC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	context := []byte("zone")
+	chunkSize := 16
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, chunkSize * 5, 1000} {
+		data := make([]byte, size)
+		if _, err := rand.Read(data); err != nil {
+			t.Fatal(err)
+		}
+
+		output := &bytes.Buffer{}
+		writer, err := acrawriter.NewStreamWriter(output, keypair.Public, context, chunkSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// write by parts of different sizes
+		for rest, part := data, 1; len(rest) > 0; part++ {
+			if part > len(rest) {
+				part = len(rest)
+			}
+			if n, err := writer.Write(rest[:part]); err != nil || n != part {
+				t.Fatalf("Incorrect write: %d, %v", n, err)
+			}
+			rest = rest[part:]
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write([]byte("data")); err != acrawriter.ErrStreamClosed {
+			t.Fatalf("Expected ErrStreamClosed, took %v", err)
+		}
+		decrypted, err := decryptStream(t, output.Bytes(), keypair.Private, context)
+		if err != nil {
+			t.Fatalf("Can't decrypt stream of %d bytes: %v", size, err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatalf("Incorrect decrypted stream of %d bytes", size)
+		}
+
+		reader, err := acrawriter.NewStreamReader(&oneByteReader{data: data}, keypair.Public, context, chunkSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stream) != output.Len() {
+			t.Fatalf("Length of streams from reader and writer differ: %d and %d", len(stream), output.Len())
+		}
+		decrypted, err = decryptStream(t, stream, keypair.Private, context)
+		if err != nil {
+			t.Fatalf("Can't decrypt stream of %d bytes from reader: %v", size, err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatalf("Incorrect decrypted stream of %d bytes from reader", size)
+		}
+	}
+}
+
+func TestStreamDecryptionErrors(t *testing.T) {
+	keypair, err := keys.New(keys.KEYTYPE_EC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunkSize := 8
+	data := []byte("some data longer than one chunk")
+	output := &bytes.Buffer{}
+	writer, err := acrawriter.NewStreamWriter(output, keypair.Public, nil, chunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write(data)
+	writer.Close()
+	stream := output.Bytes()
+
+	if _, err := decryptStream(t, stream, keypair.Private, []byte("other context")); err != base.ErrInvalidStream {
+		t.Fatalf("Expected ErrInvalidStream with other context, took %v", err)
+	}
+	header := base.GetStreamHeaderLength()
+	// all chunks except last have the same length
+	encryptedChunkLength := base.StreamChunkLengthSize + int(binary.LittleEndian.Uint32(stream[header:]))
+	truncated := stream[:header+encryptedChunkLength*(len(data)/chunkSize)]
+	if _, err := decryptStream(t, truncated, keypair.Private, nil); err != base.ErrTruncatedStream {
+		t.Fatalf("Expected ErrTruncatedStream without last chunk, took %v", err)
+	}
+	// swap first and second chunks
+	reordered := append([]byte{}, stream[:header]...)
+	reordered = append(reordered, stream[header+encryptedChunkLength:header+encryptedChunkLength*2]...)
+	reordered = append(reordered, stream[header:header+encryptedChunkLength]...)
+	reordered = append(reordered, stream[header+encryptedChunkLength*2:]...)
+	if _, err := decryptStream(t, reordered, keypair.Private, nil); err != base.ErrInvalidStream {
+		t.Fatalf("Expected ErrInvalidStream with reordered chunks, took %v", err)
+	}
+	acraStruct, err := acrawriter.CreateAcrastruct(data, keypair.Public, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := base.NewStreamDecryptor(bytes.NewReader(acraStruct), keypair.Private, nil); err != base.ErrInvalidStream {
+		t.Fatalf("Expected ErrInvalidStream for AcraStruct, took %v", err)
+	}
+	if _, err := acrawriter.NewStreamWriter(output, keypair.Public, nil, base.MaxStreamChunkSize+1); err != acrawriter.ErrInvalidStreamChunks {
+		t.Fatalf("Expected ErrInvalidStreamChunks, took %v", err)
+	}
+}
+
+func TestRotatedStreamDecryptor(t *testing.T) {
+	previous, err := keys.New(keys.KEYTYPE_EC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := keys.New(keys.KEYTYPE_EC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("data encrypted before rotation")
+	output := &bytes.Buffer{}
+	writer, err := acrawriter.NewStreamWriter(output, previous.Public, nil, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write(data)
+	writer.Close()
+
+	decryptor, err := base.NewRotatedStreamDecryptor(bytes.NewReader(output.Bytes()), []*keys.PrivateKey{current.Private, previous.Private}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := ioutil.ReadAll(decryptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatal("Incorrect decrypted stream")
+	}
+	if _, err := base.NewRotatedStreamDecryptor(bytes.NewReader(output.Bytes()), []*keys.PrivateKey{current.Private}, nil); err == nil {
+		t.Fatal("Stream decrypted with other key")
+	}
+	if _, err := base.NewRotatedStreamDecryptor(bytes.NewReader(nil), []*keys.PrivateKey{current.Private}, nil); err != base.ErrInvalidStream {
+		t.Fatalf("Expected ErrInvalidStream for empty input, took %v", err)
+	}
+}
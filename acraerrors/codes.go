@@ -0,0 +1,159 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acraerrors
+
+// Codes of errors splitted by groups and packages. Codes are never reused, removed errors keep their codes reserved.
+const (
+	// CodeUnknown is code of errors which aren't registered
+	CodeUnknown Code = 0
+
+	// keystore
+	CodeInvalidKeyName                 Code = 1000
+	CodeUnsupportedKeyPurpose          Code = 1001
+	CodeUnknownBackendType             Code = 1002
+	CodeKeyRevoked                     Code = 1003
+	CodeInvalidRevocationListSignature Code = 1004
+	CodeInvalidRevocationList          Code = 1005
+	CodeMemoryLockNotSupported         Code = 1006
+	CodeInvalidClientID                Code = 1007
+	CodeEmptyMasterKey                 Code = 1008
+	CodeMasterKeyIncorrectLength       Code = 1009
+	CodeInvalidKeyEnvironment          Code = 1010
+	CodeKeyExpired                     Code = 1011
+	CodeInvalidKeyTTL                  Code = 1012
+	CodeEmptyRowContext                Code = 1013
+	CodeNoPreviousMasterKey            Code = 1014
+	CodeInvalidMasterKeyID             Code = 1015
+	CodeUnknownMasterKeyID             Code = 1016
+	CodeInvalidMasterKeyList           Code = 1017
+
+	// keystore/filesystem
+	CodeRedisAddressRequired         Code = 1100
+	CodeRedisInvalidReply            Code = 1101
+	CodeInvalidReplicationSignature  Code = 1102
+	CodeInvalidReplicatedKey         Code = 1103
+	CodeEtcdEndpointsRequired        Code = 1104
+	CodeEtcdInvalidResponse          Code = 1105
+	CodeEtcdLockTimeout              Code = 1106
+	CodeUnsupportedFilesystemOptions Code = 1107
+
+	// keystore/pkcs11
+	CodeInvalidWrappedKey  Code = 1200
+	CodeCantLoadHSMModule  Code = 1201
+	CodeHSMKeyNotFound     Code = 1202
+	CodeHSMKeyNotUnique    Code = 1203
+	CodeHSMModuleFinalized Code = 1204
+
+	// keystore/kms
+	CodeNoAWSCredentials  Code = 1300
+	CodeNoAWSRegion       Code = 1301
+	CodeEmptyAccessToken  Code = 1302
+	CodeInvalidAzureKeyID Code = 1303
+
+	// keystore/escrow
+	CodeInvalidThreshold          Code = 1400
+	CodeEmptySecret               Code = 1401
+	CodeInvalidShares             Code = 1402
+	CodeNoCustodians              Code = 1403
+	CodeDuplicatedCustodian       Code = 1404
+	CodeUnknownCustodian          Code = 1405
+	CodeNotEnoughShares           Code = 1406
+	CodeKeyFingerprintInvalid     Code = 1407
+	CodeUnsupportedEscrowVersion  Code = 1408
+	CodeEscrowEnvironmentMismatch Code = 1409
+	CodeInvalidMasterKeyShare     Code = 1410
+	CodeMasterKeyShareMismatch    Code = 1411
+	CodeAlreadyUnsealed           Code = 1412
+
+	// network
+	CodeInvalidClientCertificatesConfig Code = 2000
+	CodeUnsupportedListener             Code = 2001
+	CodeNoNegotiationTransports         Code = 2002
+	CodeUnknownTransport                Code = 2003
+	CodeNegotiationOnClientSide         Code = 2004
+	CodeInvalidIPFilterConfig           Code = 2005
+	CodePrefetchReaderClosed            Code = 2006
+	CodeEmptyTLSConfig                  Code = 2007
+	CodeInvalidComparatorSecrets        Code = 2008
+	CodeComparatorNoMatch               Code = 2009
+	CodeComparatorProtocol              Code = 2010
+	CodeComparatorRequiresTLS           Code = 2011
+
+	// decryptor/base
+	CodeFakeAcraStruct                 Code = 3000
+	CodePoisonRecord                   Code = 3001
+	CodeInvalidPassthroughTablesConfig Code = 3002
+	CodeIncorrectAcraStructLength      Code = 3003
+	CodeIncorrectAcraStructDataLength  Code = 3004
+	CodeNoPrivateKeys                  Code = 3005
+	CodeUnknownQueryDirective          Code = 3006
+	CodeInvalidZoneDirective           Code = 3007
+	CodeDecryptionQueueFull            Code = 3008
+	CodeDecryptionWaitTimeout          Code = 3009
+	CodeInvalidStream                  Code = 3010
+	CodeTruncatedStream                Code = 3011
+
+	// decryptor/mysql
+	CodeMySQLMalformedPacket             Code = 3100
+	CodeMySQLUnsupportedClientProtocol   Code = 3101
+	CodeUnknownLocalInfileMode           Code = 3102
+	CodeLocalInfileTooLarge              Code = 3103
+	CodeEmptyClientPacket                Code = 3104
+	CodePacketHasNotExtendedCapabilities Code = 3105
+	CodeMySQLSSLRequired                 Code = 3106
+	CodeMySQLDBSSLNotSupported           Code = 3107
+
+	// decryptor/postgresql
+	CodePostgreSQLUnsupportedProtocolVersion Code = 3200
+	CodePostgreSQLShortRead                  Code = 3201
+	CodePostgreSQLMalformedPacket            Code = 3202
+	CodePostgreSQLSSLRequired                Code = 3203
+	CodePostgreSQLDBDeniedSSL                Code = 3204
+	CodePostgreSQLUnknownDBTLSMode           Code = 3205
+	CodePostgreSQLUnexpectedSSLResponse      Code = 3206
+
+	// decryptor/replay
+	CodeUnsupportedReplayFormat Code = 3300
+	CodeMalformedReplayStream   Code = 3301
+	CodeReplayNoZoneID          Code = 3302
+
+	// decryptor/mongodb
+	CodeMongoDBMalformedMessage Code = 3400
+	CodeMongoDBMessageTooLarge  Code = 3401
+	CodeMalformedBSON           Code = 3402
+
+	// decryptor/cassandra
+	CodeCassandraMalformedFrame Code = 3500
+	CodeCassandraFrameTooLarge  Code = 3501
+
+	// decryptor/mssql
+	CodeMSSQLMalformedPacket       Code = 3600
+	CodeMSSQLMessageTooLarge       Code = 3601
+	CodeMSSQLUnexpectedPacket      Code = 3602
+	CodeMSSQLUnsupportedEncryption Code = 3603
+	CodeMSSQLDBEncryptionRequired  Code = 3604
+	CodeMSSQLDBEncryptionDenied    Code = 3605
+	CodeMSSQLMalformedTokenStream  Code = 3606
+	CodeMSSQLUnsupportedDataType   Code = 3607
+	CodeMSSQLValueTooLarge         Code = 3608
+	CodeMSSQLColumnEncryption      Code = 3609
+
+	// objectstore
+	CodeUnsupportedObjectStorage Code = 4000
+	CodeObjectNotFound           Code = 4001
+	CodeInvalidObjectName        Code = 4002
+)
@@ -0,0 +1,151 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package acraerrors contains registry of errors returned by Acra packages. Each exported error has stable code which
+// doesn't change between releases, so services embedding Acra packages and tools processing logs may branch on code
+// of error instead of matching its message. Errors are compared directly with registered variables, errors wrapped
+// with Wrap or by other error which returns it with Cause keep code of wrapped error.
+package acraerrors
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Code is stable code of Acra error
+type Code int
+
+// Error is error with stable code registered in registry
+type Error struct {
+	code    Code
+	message string
+}
+
+// Error returns message of error
+func (err *Error) Error() string {
+	return err.message
+}
+
+// Code returns stable code of error
+func (err *Error) Code() Code {
+	return err.code
+}
+
+// registry maps codes to registered errors
+var registry = struct {
+	lock   sync.RWMutex
+	errors map[Code]*Error
+}{errors: make(map[Code]*Error)}
+
+// New registers and returns error with code and message. Errors are created once as exported variables of packages,
+// so New panics if code is already registered like registration of same flag twice
+func New(code Code, message string) error {
+	if code == CodeUnknown {
+		panic("acraerrors: can't register error with unknown code")
+	}
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	if registered, ok := registry.errors[code]; ok {
+		panic(fmt.Sprintf("acraerrors: code %d of %q is already registered for %q", code, message, registered.message))
+	}
+	err := &Error{code: code, message: message}
+	registry.errors[code] = err
+	return err
+}
+
+// causer is implemented by errors which wrap another error, like errors of github.com/pkg/errors
+type causer interface {
+	Cause() error
+}
+
+// wrappedError adds context to error and returns wrapped error as cause
+type wrappedError struct {
+	message string
+	cause   error
+}
+
+// Error returns message with message of wrapped error
+func (err *wrappedError) Error() string {
+	return err.message + ": " + err.cause.Error()
+}
+
+// Cause returns wrapped error
+func (err *wrappedError) Cause() error {
+	return err.cause
+}
+
+// Wrap returns error with message which keeps err as cause, so CodeOf returns code of err
+func Wrap(err error, message string) error {
+	if err == nil {
+		return nil
+	}
+	return &wrappedError{message: message, cause: err}
+}
+
+// Cause returns last error in chain of wrapped errors
+func Cause(err error) error {
+	for err != nil {
+		wrapped, ok := err.(causer)
+		if !ok {
+			break
+		}
+		err = wrapped.Cause()
+	}
+	return err
+}
+
+// CodeOf returns code of first registered error in chain of wrapped errors or CodeUnknown if chain has no one
+func CodeOf(err error) Code {
+	for err != nil {
+		if acraErr, ok := err.(*Error); ok {
+			return acraErr.code
+		}
+		wrapped, ok := err.(causer)
+		if !ok {
+			break
+		}
+		err = wrapped.Cause()
+	}
+	return CodeUnknown
+}
+
+// Lookup returns registered error with code
+func Lookup(code Code) (error, bool) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	err, ok := registry.errors[code]
+	if !ok {
+		return nil, false
+	}
+	return err, true
+}
+
+// Registered returns all registered errors ordered by code
+func Registered() []error {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	codes := make([]int, 0, len(registry.errors))
+	for code := range registry.errors {
+		codes = append(codes, int(code))
+	}
+	sort.Ints(codes)
+	registered := make([]error, 0, len(codes))
+	for _, code := range codes {
+		registered = append(registered, registry.errors[Code(code)])
+	}
+	return registered
+}
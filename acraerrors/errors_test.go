@@ -0,0 +1,93 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acraerrors_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/decryptor/mysql"
+	"github.com/cossacklabs/acra/decryptor/postgresql"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/filesystem"
+	"github.com/cossacklabs/acra/network"
+)
+
+func TestRegistry(t *testing.T) {
+	// errors of all packages are registered on import without conflicts of codes
+	for err, code := range map[error]acraerrors.Code{
+		keystore.ErrKeyRevoked:                   acraerrors.CodeKeyRevoked,
+		keystore.ErrMasterKeyIncorrectLength:     acraerrors.CodeMasterKeyIncorrectLength,
+		filesystem.ErrRedisAddressRequired:       acraerrors.CodeRedisAddressRequired,
+		network.ErrEmptyTLSConfig:                acraerrors.CodeEmptyTLSConfig,
+		base.ErrPoisonRecord:                     acraerrors.CodePoisonRecord,
+		mysql.ErrSSLRequired:                     acraerrors.CodeMySQLSSLRequired,
+		postgresql.ErrSSLRequired:                acraerrors.CodePostgreSQLSSLRequired,
+		postgresql.ErrUnsupportedProtocolVersion: acraerrors.CodePostgreSQLUnsupportedProtocolVersion,
+	} {
+		if acraerrors.CodeOf(err) != code {
+			t.Fatalf("Incorrect code %v of %q", acraerrors.CodeOf(err), err)
+		}
+		registered, ok := acraerrors.Lookup(code)
+		if !ok || registered != err {
+			t.Fatalf("Error with code %v isn't registered", code)
+		}
+	}
+	registered := acraerrors.Registered()
+	for i := 1; i < len(registered); i++ {
+		if acraerrors.CodeOf(registered[i-1]) >= acraerrors.CodeOf(registered[i]) {
+			t.Fatal("Registered errors aren't ordered by code")
+		}
+	}
+	if _, ok := acraerrors.Lookup(acraerrors.CodeUnknown); ok {
+		t.Fatal("Unexpected error with unknown code")
+	}
+}
+
+func TestWrappedError(t *testing.T) {
+	wrapped := acraerrors.Wrap(acraerrors.Wrap(keystore.ErrKeyRevoked, "can't read key"), "can't decrypt")
+	if acraerrors.Cause(wrapped) != keystore.ErrKeyRevoked {
+		t.Fatal("Wrapped error doesn't match registered one")
+	}
+	if wrapped.Error() != "can't decrypt: can't read key: "+keystore.ErrKeyRevoked.Error() {
+		t.Fatalf("Unexpected message of wrapped error: %s", wrapped)
+	}
+	if acraerrors.CodeOf(wrapped) != acraerrors.CodeKeyRevoked {
+		t.Fatal("Wrapped error doesn't keep code")
+	}
+	if acraerrors.CodeOf(fmt.Errorf("can't read key: %v", keystore.ErrKeyRevoked)) != acraerrors.CodeUnknown {
+		t.Fatal("Unexpected code of error formatted without cause")
+	}
+	if acraerrors.Wrap(nil, "no error") != nil {
+		t.Fatal("Wrapped nil isn't nil")
+	}
+	if acraerrors.CodeOf(errors.New("unregistered")) != acraerrors.CodeUnknown || acraerrors.CodeOf(nil) != acraerrors.CodeUnknown {
+		t.Fatal("Unexpected code of unregistered error")
+	}
+}
+
+func TestDuplicatedCode(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected panic on duplicated code")
+		}
+	}()
+	acraerrors.New(acraerrors.CodeKeyRevoked, "duplicated")
+}
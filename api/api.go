@@ -0,0 +1,229 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package api describes versioned HTTP API of AcraServer: paths of endpoints, types of requests and responses and
+// OpenAPI document which AcraServer serves at runtime.
+package api
+
+import (
+	"time"
+)
+
+//go:generate go run generate/main.go
+
+// Paths of endpoints of HTTP API v1
+const (
+	VersionPrefix      = "/v1/"
+	PathOpenAPI        = "/v1/openapi.yaml"
+	PathZones          = "/v1/zones"
+	PathZoneRotate     = "/v1/zones/rotate"
+	PathZonesBatch     = "/v1/zones/batch"
+	PathKeys           = "/v1/keys"
+	PathKeyExpirations = "/v1/keys/expirations"
+	PathPublicKey      = "/v1/keys/public"
+	PathKeystoreReset  = "/v1/keystore/reset"
+	PathAuthData       = "/v1/auth_data"
+	PathConfig         = "/v1/config"
+	PathConnections    = "/v1/connections"
+	PathConnectionZone = "/v1/connections/zone"
+	PathDrain          = "/v1/drain"
+	PathPayloadStats   = "/v1/stats/payload"
+	PathStatementStats = "/v1/stats/statements"
+	PathLogLevels      = "/v1/logging/levels"
+	PathSchemaValidate = "/v1/schema/validate"
+	PathStandbyState   = "/v1/standby/state"
+	PathUnseal         = "/v1/unseal"
+)
+
+// Error is body of responses with error status
+type Error struct {
+	Error string `json:"error"`
+}
+
+// Zone is generated zone
+type Zone struct {
+	ID        string `json:"id"`
+	PublicKey []byte `json:"public_key"`
+}
+
+// ZonesBatchRequest is body of request to generate several zones at once
+type ZonesBatchRequest struct {
+	Count int `json:"count"`
+}
+
+// ZonesBatch is batch of generated zones
+type ZonesBatch struct {
+	Zones []Zone `json:"zones"`
+	// QuotaLeft is count of zones caller may generate till the end of quota period, -1 if count isn't limited
+	QuotaLeft int `json:"quota_left"`
+}
+
+// Key describes key stored in keystore without its value
+type Key struct {
+	Name    string `json:"name"`
+	Purpose string `json:"purpose"`
+	ID      string `json:"id,omitempty"`
+	Public  bool   `json:"public"`
+	// Fingerprint is hex encoded SHA-256 hash of public key
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	ModifiedAt  time.Time `json:"modified_at"`
+	AgeSeconds  int64     `json:"age_seconds"`
+	// CreatedAt is time of generation or import of key if keystore stores it
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	// ExpiresAt is end of lifetime set for key, absent if key doesn't expire
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// KeyExpiration describes key which expires or is already expired
+type KeyExpiration struct {
+	Name      string    `json:"name"`
+	Purpose   string    `json:"purpose"`
+	ID        string    `json:"id,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// DaysLeft is count of whole days left before expiration, negative if key is expired
+	DaysLeft int `json:"days_left"`
+}
+
+// ZoneKey describes public key of zone
+type ZoneKey struct {
+	ID          string    `json:"id"`
+	Fingerprint string    `json:"fingerprint"`
+	ModifiedAt  time.Time `json:"modified_at"`
+	AgeSeconds  int64     `json:"age_seconds"`
+}
+
+// Config is part of AcraServer configuration editable through API
+type Config struct {
+	DbHost           string `json:"db_host"`
+	DbPort           int    `json:"db_port"`
+	ConnectorAPIPort int    `json:"incoming_connection_api_port"`
+	Debug            bool   `json:"debug"`
+	ScriptOnPoison   string `json:"poison_run_script_file"`
+	StopOnPoison     bool   `json:"poison_shutdown_enable"`
+	WithZone         bool   `json:"zonemode_enable"`
+}
+
+// Connection describes active client connection
+type Connection struct {
+	ID             uint64    `json:"id"`
+	ClientID       string    `json:"client_id"`
+	RemoteAddress  string    `json:"remote_address"`
+	StartedAt      time.Time `json:"started_at"`
+	BytesIn        int64     `json:"bytes_in"`
+	BytesOut       int64     `json:"bytes_out"`
+	Queries        int64     `json:"queries"`
+	Rows           int64     `json:"rows"`
+	Decryptions    int64     `json:"decryptions"`
+	EncryptedBytes int64     `json:"encrypted_bytes"`
+	PlaintextBytes int64     `json:"plaintext_bytes"`
+	// Application is name of client's application from startup parameters
+	Application string `json:"application,omitempty"`
+	// Tags is startup parameters of PostgreSQL connection or connection attributes of MySQL
+	Tags map[string]string `json:"tags,omitempty"`
+	// SessionZoneID is zone set for all queries of connection by session_zone directive or API
+	SessionZoneID string `json:"session_zone_id,omitempty"`
+	AgeSeconds    int64  `json:"age_seconds"`
+	// CurrentQueryFingerprint is fingerprint of oldest query forwarded to database which response isn't finished yet,
+	// same as fingerprint of statement in StatementStats
+	CurrentQueryFingerprint string     `json:"current_query_fingerprint,omitempty"`
+	CurrentQueryStartedAt   *time.Time `json:"current_query_started_at,omitempty"`
+}
+
+// ConnectionZone sets zone used to decrypt results of all following queries of active connection
+type ConnectionZone struct {
+	ConnectionID uint64 `json:"connection_id"`
+	ZoneID       string `json:"zone_id"`
+}
+
+// PayloadStats is aggregated sizes of client's values of table before and after decryption
+type PayloadStats struct {
+	ClientID       string `json:"client_id"`
+	Table          string `json:"table"`
+	Values         int64  `json:"values"`
+	EncryptedBytes int64  `json:"encrypted_bytes"`
+	PlaintextBytes int64  `json:"plaintext_bytes"`
+}
+
+// StatementStats is aggregated executions of client's normalized statement. Literals of statement are replaced with ?
+// and fingerprint identifies statement regardless of values
+type StatementStats struct {
+	ClientID    string  `json:"client_id"`
+	Fingerprint string  `json:"fingerprint"`
+	Query       string  `json:"query"`
+	Calls       int64   `json:"calls"`
+	Rows        int64   `json:"rows"`
+	TotalTimeMs float64 `json:"total_time_ms"`
+	MinTimeMs   float64 `json:"min_time_ms"`
+	MaxTimeMs   float64 `json:"max_time_ms"`
+	MeanTimeMs  float64 `json:"mean_time_ms"`
+}
+
+// LogLevels is log levels overridden for connections of client ids and from ip addresses
+type LogLevels struct {
+	Clients   map[string]string `json:"clients"`
+	Addresses map[string]string `json:"addresses"`
+}
+
+// LogLevelOverride raises log level of connections of client id or from ip address
+type LogLevelOverride struct {
+	ClientID string `json:"client_id,omitempty"`
+	Address  string `json:"address,omitempty"`
+	// Level is one of debug, info, warning
+	Level string `json:"level"`
+}
+
+// SchemaWarning describes column from encryptor configuration which doesn't exist in database or has unsuitable type.
+// Column is empty if whole table doesn't exist
+type SchemaWarning struct {
+	Table   string `json:"table"`
+	Column  string `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// SchemaValidation is result of comparison of encryptor configuration with introspected database schema
+type SchemaValidation struct {
+	Warnings []SchemaWarning `json:"warnings"`
+}
+
+// HandshakeFailures is count of consecutive failed transport handshakes of source address or client id and its ban
+type HandshakeFailures struct {
+	Key         string    `json:"key"`
+	Count       int       `json:"count"`
+	LastFailure time.Time `json:"last_failure"`
+	BannedUntil time.Time `json:"banned_until"`
+}
+
+// StandbyState is security state and cached keys of active AcraServer which warm standby AcraServer copies, so
+// failover doesn't reset bans and doesn't start with cold keystore cache
+type StandbyState struct {
+	// CachedKeys is names of keys in keystore cache
+	CachedKeys        []string            `json:"cached_keys"`
+	HandshakeFailures []HandshakeFailures `json:"handshake_failures"`
+}
+
+// UnsealRequest is share of master key generated by acra-keymaker --split_master_key
+type UnsealRequest struct {
+	Share string `json:"share"`
+}
+
+// UnsealStatus is progress of master key reconstruction from shares
+type UnsealStatus struct {
+	Sealed bool `json:"sealed"`
+	// Threshold is count of shares required to unseal, 0 until first share is added
+	Threshold int `json:"threshold"`
+	// Progress is count of shares added since master key was sealed or since last rejected combination of shares
+	Progress int `json:"progress"`
+}
@@ -0,0 +1,33 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+// TestOpenAPIDocumentGenerated checks that openapi.go was regenerated after changes of openapi.yaml
+func TestOpenAPIDocumentGenerated(t *testing.T) {
+	document, err := ioutil.ReadFile("openapi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(document) != OpenAPIDocument {
+		t.Fatal("openapi.go is outdated, run 'go generate ./api'")
+	}
+}
@@ -0,0 +1,383 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package client implements client of AcraServer HTTP API v1 described by api.OpenAPIDocument.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/cossacklabs/acra/api"
+)
+
+// StatusError returned if API responded with unexpected status
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (err *StatusError) Error() string {
+	if err.Message != "" {
+		return fmt.Sprintf("unexpected status %d: %s", err.StatusCode, err.Message)
+	}
+	return fmt.Sprintf("unexpected status %d", err.StatusCode)
+}
+
+// Client sends requests to HTTP API of AcraServer or AcraConnector
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewClient returns client of API at baseURL like "http://127.0.0.1:9191". Uses http.DefaultClient if httpClient is nil
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{baseURL: strings.TrimRight(baseURL, "/"), httpClient: httpClient}
+}
+
+// do sends request and returns body of response with expected status
+func (client *Client) do(method, path string, body io.Reader, expectedStatus int) ([]byte, error) {
+	request, err := http.NewRequest(method, client.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	response, err := client.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != expectedStatus {
+		apiError := api.Error{}
+		json.Unmarshal(data, &apiError)
+		return nil, &StatusError{StatusCode: response.StatusCode, Message: apiError.Error}
+	}
+	return data, nil
+}
+
+// GetOpenAPI returns OpenAPI document served by AcraServer
+func (client *Client) GetOpenAPI() ([]byte, error) {
+	return client.do(http.MethodGet, api.PathOpenAPI, nil, http.StatusOK)
+}
+
+// CreateZone generates new zone
+func (client *Client) CreateZone() (*api.Zone, error) {
+	data, err := client.do(http.MethodPost, api.PathZones, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	zone := &api.Zone{}
+	if err := json.Unmarshal(data, zone); err != nil {
+		return nil, err
+	}
+	return zone, nil
+}
+
+// CreateZones generates count zones at once, count is limited by quota of caller
+func (client *Client) CreateZones(count int) (*api.ZonesBatch, error) {
+	body, err := json.Marshal(&api.ZonesBatchRequest{Count: count})
+	if err != nil {
+		return nil, err
+	}
+	data, err := client.do(http.MethodPost, api.PathZonesBatch, bytes.NewReader(body), http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	batch := &api.ZonesBatch{}
+	if err := json.Unmarshal(data, batch); err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// ListZones returns zones with public keys in keystore
+func (client *Client) ListZones() ([]api.ZoneKey, error) {
+	data, err := client.do(http.MethodGet, api.PathZones, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	var zones []api.ZoneKey
+	if err := json.Unmarshal(data, &zones); err != nil {
+		return nil, err
+	}
+	return zones, nil
+}
+
+// RotateZoneKey generates new key pair of zone and returns zone with new public key. Data encrypted with previous
+// key should be re-encrypted with acra-rotate
+func (client *Client) RotateZoneKey(zoneID string) (*api.Zone, error) {
+	data, err := client.do(http.MethodPost, api.PathZoneRotate+"?zone_id="+url.QueryEscape(zoneID), nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	zone := &api.Zone{}
+	if err := json.Unmarshal(data, zone); err != nil {
+		return nil, err
+	}
+	return zone, nil
+}
+
+// ListKeys returns keys stored in keystore without their values
+func (client *Client) ListKeys() ([]api.Key, error) {
+	data, err := client.do(http.MethodGet, api.PathKeys, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	var keys []api.Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// GetKeyExpirations returns expired keys and keys which expire in withinDays days ordered by expiration
+func (client *Client) GetKeyExpirations(withinDays int) ([]api.KeyExpiration, error) {
+	query := url.Values{}
+	query.Set("within_days", strconv.Itoa(withinDays))
+	data, err := client.do(http.MethodGet, api.PathKeyExpirations+"?"+query.Encode(), nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	var expirations []api.KeyExpiration
+	if err := json.Unmarshal(data, &expirations); err != nil {
+		return nil, err
+	}
+	return expirations, nil
+}
+
+// GetPublicKey returns public key by name from ListKeys
+func (client *Client) GetPublicKey(name string) ([]byte, error) {
+	return client.do(http.MethodGet, api.PathPublicKey+"?name="+url.QueryEscape(name), nil, http.StatusOK)
+}
+
+// ResetKeystore clears cache of keystore
+func (client *Client) ResetKeystore() error {
+	_, err := client.do(http.MethodPost, api.PathKeystoreReset, nil, http.StatusNoContent)
+	return err
+}
+
+// GetAuthData returns users of AcraWebConfig in format of acra-authmanager
+func (client *Client) GetAuthData() ([]byte, error) {
+	return client.do(http.MethodGet, api.PathAuthData, nil, http.StatusOK)
+}
+
+// GetConfig returns configuration editable through API
+func (client *Client) GetConfig() (*api.Config, error) {
+	data, err := client.do(http.MethodGet, api.PathConfig, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	config := &api.Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// SetConfig saves configuration, AcraServer restarts after that
+func (client *Client) SetConfig(config *api.Config) error {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	_, err = client.do(http.MethodPut, api.PathConfig, bytes.NewReader(data), http.StatusAccepted)
+	return err
+}
+
+// GetConnections returns counters of active client connections
+func (client *Client) GetConnections() ([]api.Connection, error) {
+	data, err := client.do(http.MethodGet, api.PathConnections, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	var connections []api.Connection
+	if err := json.Unmarshal(data, &connections); err != nil {
+		return nil, err
+	}
+	return connections, nil
+}
+
+// TerminateConnection closes active client connection and its connection to database
+func (client *Client) TerminateConnection(connectionID uint64) error {
+	query := url.Values{}
+	query.Set("connection_id", strconv.FormatUint(connectionID, 10))
+	_, err := client.do(http.MethodDelete, api.PathConnections+"?"+query.Encode(), nil, http.StatusNoContent)
+	return err
+}
+
+// SetConnectionZone sets zone used to decrypt results of all following queries of active connection
+func (client *Client) SetConnectionZone(connectionID uint64, zoneID string) error {
+	body, err := json.Marshal(api.ConnectionZone{ConnectionID: connectionID, ZoneID: zoneID})
+	if err != nil {
+		return err
+	}
+	_, err = client.do(http.MethodPut, api.PathConnectionZone, bytes.NewReader(body), http.StatusNoContent)
+	return err
+}
+
+// ResetConnectionZone resets zone of active connection, zone will be taken from queries again
+func (client *Client) ResetConnectionZone(connectionID uint64) error {
+	query := url.Values{}
+	query.Set("connection_id", strconv.FormatUint(connectionID, 10))
+	_, err := client.do(http.MethodDelete, api.PathConnectionZone+"?"+query.Encode(), nil, http.StatusNoContent)
+	return err
+}
+
+// GetPayloadStats returns sizes of decrypted values aggregated per client and table
+func (client *Client) GetPayloadStats() ([]api.PayloadStats, error) {
+	data, err := client.do(http.MethodGet, api.PathPayloadStats, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	var stats []api.PayloadStats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// GetStatementStats returns executions of normalized statements aggregated per client, the most expensive first
+func (client *Client) GetStatementStats() ([]api.StatementStats, error) {
+	data, err := client.do(http.MethodGet, api.PathStatementStats, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	var stats []api.StatementStats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// ResetStatementStats removes aggregated executions of statements
+func (client *Client) ResetStatementStats() error {
+	_, err := client.do(http.MethodDelete, api.PathStatementStats, nil, http.StatusNoContent)
+	return err
+}
+
+// GetLogLevels returns log levels overridden for client ids and ip addresses
+func (client *Client) GetLogLevels() (*api.LogLevels, error) {
+	data, err := client.do(http.MethodGet, api.PathLogLevels, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	levels := &api.LogLevels{}
+	if err := json.Unmarshal(data, levels); err != nil {
+		return nil, err
+	}
+	return levels, nil
+}
+
+// SetLogLevel raises log level of connections of client id or from ip address
+func (client *Client) SetLogLevel(override *api.LogLevelOverride) error {
+	body, err := json.Marshal(override)
+	if err != nil {
+		return err
+	}
+	_, err = client.do(http.MethodPut, api.PathLogLevels, bytes.NewReader(body), http.StatusNoContent)
+	return err
+}
+
+// RemoveLogLevel returns log level of connections of client id or from ip address to global level
+func (client *Client) RemoveLogLevel(clientID, address string) error {
+	query := url.Values{}
+	if clientID != "" {
+		query.Set("client_id", clientID)
+	}
+	if address != "" {
+		query.Set("address", address)
+	}
+	_, err := client.do(http.MethodDelete, api.PathLogLevels+"?"+query.Encode(), nil, http.StatusNoContent)
+	return err
+}
+
+// ValidateSchema asks AcraServer to introspect database schema and returns mismatches with encryptor configuration
+func (client *Client) ValidateSchema() (*api.SchemaValidation, error) {
+	data, err := client.do(http.MethodPost, api.PathSchemaValidate, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	validation := &api.SchemaValidation{}
+	if err := json.Unmarshal(data, validation); err != nil {
+		return nil, err
+	}
+	return validation, nil
+}
+
+// Drain asks AcraServer to stop accepting connections and shut down after active connections are closed
+func (client *Client) Drain() error {
+	_, err := client.do(http.MethodPost, api.PathDrain, nil, http.StatusAccepted)
+	return err
+}
+
+// GetStandbyState returns state of AcraServer which warm standby AcraServer copies
+func (client *Client) GetStandbyState() (*api.StandbyState, error) {
+	data, err := client.do(http.MethodGet, api.PathStandbyState, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	state := &api.StandbyState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// GetUnsealStatus returns progress of master key reconstruction served by unseal API
+func (client *Client) GetUnsealStatus() (*api.UnsealStatus, error) {
+	data, err := client.do(http.MethodGet, api.PathUnseal, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	status := &api.UnsealStatus{}
+	if err := json.Unmarshal(data, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// Unseal sends share of master key to unseal API and returns progress of master key reconstruction
+func (client *Client) Unseal(share string) (*api.UnsealStatus, error) {
+	body, err := json.Marshal(&api.UnsealRequest{Share: share})
+	if err != nil {
+		return nil, err
+	}
+	data, err := client.do(http.MethodPost, api.PathUnseal, bytes.NewReader(body), http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	status := &api.UnsealStatus{}
+	if err := json.Unmarshal(data, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
@@ -0,0 +1,292 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/cossacklabs/acra/api"
+)
+
+func TestClient(t *testing.T) {
+	var savedConfig api.Config
+	var savedLogLevel api.LogLevelOverride
+	var savedConnectionZone api.ConnectionZone
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method + " " + request.URL.Path {
+		case "GET " + api.PathOpenAPI:
+			writer.Write([]byte(api.OpenAPIDocument))
+		case "POST " + api.PathZones:
+			writer.Write([]byte(`{"id": "DDDDDDDDzone", "public_key": "cHVibGlj"}`))
+		case "POST " + api.PathZonesBatch:
+			var batchRequest api.ZonesBatchRequest
+			json.NewDecoder(request.Body).Decode(&batchRequest)
+			if batchRequest.Count > 2 {
+				writer.WriteHeader(http.StatusTooManyRequests)
+				writer.Write([]byte(`{"error": "quota of generated zones exceeded"}`))
+				return
+			}
+			writer.Write([]byte(`{"zones": [{"id": "DDDDDDDDzone1", "public_key": "cHVibGlj"}, {"id": "DDDDDDDDzone2", "public_key": "cHVibGlj"}], "quota_left": 8}`))
+		case "POST " + api.PathKeystoreReset:
+			writer.WriteHeader(http.StatusNoContent)
+		case "GET " + api.PathConfig:
+			json.NewEncoder(writer).Encode(api.Config{DbHost: "localhost", DbPort: 5432})
+		case "PUT " + api.PathConfig:
+			body, _ := ioutil.ReadAll(request.Body)
+			json.Unmarshal(body, &savedConfig)
+			writer.WriteHeader(http.StatusAccepted)
+		case "GET " + api.PathZones:
+			writer.Write([]byte(`[{"id": "DDDDDDDDzone", "fingerprint": "abcd", "age_seconds": 10}]`))
+		case "POST " + api.PathZoneRotate:
+			writer.Write([]byte(`{"id": "` + request.URL.Query().Get("zone_id") + `", "public_key": "bmV3"}`))
+		case "GET " + api.PathKeys:
+			writer.Write([]byte(`[{"name": "client_storage.pub", "purpose": "storage", "id": "client", "public": true}]`))
+		case "GET " + api.PathKeyExpirations:
+			if request.URL.Query().Get("within_days") != "7" {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			writer.Write([]byte(`[{"name": "client_hmac", "purpose": "hmac", "id": "client", "expires_at": "2020-01-01T00:00:00Z", "days_left": -3}]`))
+		case "GET " + api.PathPublicKey:
+			writer.Write([]byte("key " + request.URL.Query().Get("name")))
+		case "GET " + api.PathConnections:
+			writer.Write([]byte(`[{"id": 1, "client_id": "client", "queries": 2, "current_query_fingerprint": "9d5e3b1c2a4f6e70"}]`))
+		case "DELETE " + api.PathConnections:
+			if request.URL.Query().Get("connection_id") != "1" {
+				writer.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writer.WriteHeader(http.StatusNoContent)
+		case "PUT " + api.PathConnectionZone:
+			json.NewDecoder(request.Body).Decode(&savedConnectionZone)
+			writer.WriteHeader(http.StatusNoContent)
+		case "DELETE " + api.PathConnectionZone:
+			if request.URL.Query().Get("connection_id") != "1" {
+				writer.WriteHeader(http.StatusNotFound)
+				writer.Write([]byte(`{"error": "connection not found"}`))
+				return
+			}
+			savedConnectionZone = api.ConnectionZone{}
+			writer.WriteHeader(http.StatusNoContent)
+		case "GET " + api.PathPayloadStats:
+			writer.Write([]byte(`[{"client_id": "client", "table": "test", "values": 1, "encrypted_bytes": 100, "plaintext_bytes": 4}]`))
+		case "GET " + api.PathStatementStats:
+			writer.Write([]byte(`[{"client_id": "client", "fingerprint": "abcd", "query": "select * from t where id = ?", "calls": 3, "total_time_ms": 1.5}]`))
+		case "DELETE " + api.PathStatementStats:
+			writer.WriteHeader(http.StatusNoContent)
+		case "GET " + api.PathLogLevels:
+			writer.Write([]byte(`{"clients": {"client": "debug"}, "addresses": {}}`))
+		case "PUT " + api.PathLogLevels:
+			json.NewDecoder(request.Body).Decode(&savedLogLevel)
+			writer.WriteHeader(http.StatusNoContent)
+		case "DELETE " + api.PathLogLevels:
+			savedLogLevel = api.LogLevelOverride{Address: request.URL.Query().Get("address")}
+			writer.WriteHeader(http.StatusNoContent)
+		case "POST " + api.PathSchemaValidate:
+			writer.Write([]byte(`{"warnings": [{"table": "users", "column": "email", "message": "column doesn't exist"}]}`))
+		case "GET " + api.PathStandbyState:
+			writer.Write([]byte(`{"cached_keys": ["client_storage"], "handshake_failures": [{"key": "10.0.0.1", "count": 3}]}`))
+		case "GET " + api.PathUnseal:
+			writer.Write([]byte(`{"sealed": true, "threshold": 0, "progress": 0}`))
+		case "POST " + api.PathUnseal:
+			unsealRequest := api.UnsealRequest{}
+			json.NewDecoder(request.Body).Decode(&unsealRequest)
+			if unsealRequest.Share == "" {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			writer.Write([]byte(`{"sealed": true, "threshold": 3, "progress": 1}`))
+		default:
+			writer.WriteHeader(http.StatusInternalServerError)
+			writer.Write([]byte(`{"error": "can't load auth data"}`))
+		}
+	}))
+	defer server.Close()
+	client := NewClient(server.URL+"/", nil)
+
+	document, err := client.GetOpenAPI()
+	if err != nil || len(document) == 0 {
+		t.Fatalf("can't get OpenAPI document: %v", err)
+	}
+	zone, err := client.CreateZone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zone.ID != "DDDDDDDDzone" || string(zone.PublicKey) != "public" {
+		t.Fatalf("incorrect zone %v", zone)
+	}
+	batch, err := client.CreateZones(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch.Zones) != 2 || batch.Zones[1].ID != "DDDDDDDDzone2" || batch.QuotaLeft != 8 {
+		t.Fatalf("incorrect batch of zones %v", batch)
+	}
+	if _, err := client.CreateZones(3); err == nil || err.(*StatusError).StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected quota error, took %v", err)
+	}
+	if err := client.ResetKeystore(); err != nil {
+		t.Fatal(err)
+	}
+	config, err := client.GetConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.DbHost != "localhost" || config.DbPort != 5432 {
+		t.Fatalf("incorrect config %v", config)
+	}
+	config.Debug = true
+	if err := client.SetConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(savedConfig, *config) {
+		t.Fatalf("incorrect saved config %v", savedConfig)
+	}
+	connections, err := client.GetConnections()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(connections) != 1 || connections[0].ClientID != "client" || connections[0].Queries != 2 || connections[0].CurrentQueryFingerprint == "" {
+		t.Fatalf("incorrect connections %v", connections)
+	}
+	if err := client.TerminateConnection(1); err != nil {
+		t.Fatal(err)
+	}
+	err = client.TerminateConnection(2)
+	if statusError, ok := err.(*StatusError); !ok || statusError.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected not found error, took %v", err)
+	}
+	payloadStats, err := client.GetPayloadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payloadStats) != 1 || payloadStats[0].Table != "test" || payloadStats[0].EncryptedBytes != 100 || payloadStats[0].PlaintextBytes != 4 {
+		t.Fatalf("incorrect payload stats %v", payloadStats)
+	}
+	statementStats, err := client.GetStatementStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statementStats) != 1 || statementStats[0].Fingerprint != "abcd" || statementStats[0].Calls != 3 || statementStats[0].TotalTimeMs != 1.5 {
+		t.Fatalf("incorrect statement stats %v", statementStats)
+	}
+	if err := client.ResetStatementStats(); err != nil {
+		t.Fatal(err)
+	}
+	zones, err := client.ListZones()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zones) != 1 || zones[0].ID != "DDDDDDDDzone" || zones[0].AgeSeconds != 10 {
+		t.Fatalf("incorrect zones %v", zones)
+	}
+	zone, err = client.RotateZoneKey("DDDDDDDDzone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zone.ID != "DDDDDDDDzone" || string(zone.PublicKey) != "new" {
+		t.Fatalf("incorrect rotated zone %v", zone)
+	}
+	keys, err := client.ListKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Name != "client_storage.pub" || !keys[0].Public {
+		t.Fatalf("incorrect keys %v", keys)
+	}
+	expirations, err := client.GetKeyExpirations(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expirations) != 1 || expirations[0].Purpose != "hmac" || expirations[0].DaysLeft != -3 || expirations[0].ExpiresAt.Year() != 2020 {
+		t.Fatalf("incorrect key expirations %v", expirations)
+	}
+	publicKey, err := client.GetPublicKey("client_storage.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(publicKey) != "key client_storage.pub" {
+		t.Fatalf("incorrect public key %s", publicKey)
+	}
+	logLevels, err := client.GetLogLevels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logLevels.Clients["client"] != "debug" {
+		t.Fatalf("incorrect log levels %v", logLevels)
+	}
+	if err := client.SetLogLevel(&api.LogLevelOverride{ClientID: "client", Level: "debug"}); err != nil {
+		t.Fatal(err)
+	}
+	if savedLogLevel.ClientID != "client" || savedLogLevel.Level != "debug" {
+		t.Fatalf("incorrect saved log level %v", savedLogLevel)
+	}
+	if err := client.RemoveLogLevel("", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if savedLogLevel.Address != "10.0.0.1" {
+		t.Fatalf("incorrect removed log level %v", savedLogLevel)
+	}
+	if err := client.SetConnectionZone(1, "DDDDDDDDzone"); err != nil {
+		t.Fatal(err)
+	}
+	if savedConnectionZone.ConnectionID != 1 || savedConnectionZone.ZoneID != "DDDDDDDDzone" {
+		t.Fatalf("incorrect saved connection zone %v", savedConnectionZone)
+	}
+	if err := client.ResetConnectionZone(1); err != nil {
+		t.Fatal(err)
+	}
+	if savedConnectionZone.ZoneID != "" {
+		t.Fatalf("connection zone wasn't reset %v", savedConnectionZone)
+	}
+	err = client.ResetConnectionZone(2)
+	if statusError, ok := err.(*StatusError); !ok || statusError.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected not found error, took %v", err)
+	}
+	validation, err := client.ValidateSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(validation.Warnings) != 1 || validation.Warnings[0].Column != "email" {
+		t.Fatalf("incorrect schema validation %v", validation)
+	}
+	standbyState, err := client.GetStandbyState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(standbyState.CachedKeys) != 1 || len(standbyState.HandshakeFailures) != 1 || standbyState.HandshakeFailures[0].Count != 3 {
+		t.Fatalf("incorrect standby state %v", standbyState)
+	}
+	unsealStatus, err := client.GetUnsealStatus()
+	if err != nil || !unsealStatus.Sealed {
+		t.Fatalf("incorrect unseal status %v, %v", unsealStatus, err)
+	}
+	unsealStatus, err = client.Unseal("c2hhcmU=")
+	if err != nil || unsealStatus.Threshold != 3 || unsealStatus.Progress != 1 {
+		t.Fatalf("incorrect unseal status %v, %v", unsealStatus, err)
+	}
+	_, err = client.GetAuthData()
+	statusError, ok := err.(*StatusError)
+	if !ok || statusError.StatusCode != http.StatusInternalServerError || statusError.Message != "can't load auth data" {
+		t.Fatalf("expected StatusError, took %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command generate writes openapi.yaml to openapi.go as string constant, so document is compiled into binaries.
+// Runs from directory of api package with 'go generate ./api' after changes of openapi.yaml
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	documentPath = "openapi.yaml"
+	outputPath   = "openapi.go"
+)
+
+func main() {
+	document, err := ioutil.ReadFile(documentPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var output bytes.Buffer
+	output.WriteString("// Code generated by api/generate from openapi.yaml; DO NOT EDIT.\n\n")
+	output.WriteString("package api\n\n")
+	output.WriteString("// OpenAPIDocument is OpenAPI 3 definition of HTTP API\n")
+	// raw string literal can't contain backquotes, they are concatenated as interpreted literals
+	output.WriteString("const OpenAPIDocument = `" + strings.Replace(string(document), "`", "` + \"`\" + `", -1) + "`\n")
+	if err := ioutil.WriteFile(outputPath, output.Bytes(), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
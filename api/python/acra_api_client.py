@@ -0,0 +1,172 @@
+# Copyright 2018, Cossack Labs Limited
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Client of AcraServer HTTP API v1 described by api/openapi.yaml.
+
+Uses only standard library:
+
+    client = AcraAPIClient('http://127.0.0.1:9191')
+    zone = client.create_zone()
+    print(zone['id'], zone['public_key'])
+"""
+import base64
+import json
+from urllib.error import HTTPError
+from urllib.parse import urlencode
+from urllib.request import Request, urlopen
+
+__all__ = ('AcraAPIClient', 'AcraAPIError')
+
+
+class AcraAPIError(Exception):
+    """Raised when API responded with unexpected status."""
+
+    def __init__(self, status, message=''):
+        super().__init__('unexpected status {}: {}'.format(status, message))
+        self.status = status
+        self.message = message
+
+
+class AcraAPIClient(object):
+    def __init__(self, base_url, timeout=10):
+        self.base_url = base_url.rstrip('/')
+        self.timeout = timeout
+
+    def _request(self, method, path, expected_status, body=None):
+        headers = {}
+        data = None
+        if body is not None:
+            data = json.dumps(body).encode('utf-8')
+            headers['Content-Type'] = 'application/json'
+        request = Request(self.base_url + path, data=data, headers=headers,
+                          method=method)
+        try:
+            with urlopen(request, timeout=self.timeout) as response:
+                status, content = response.status, response.read()
+        except HTTPError as error:
+            status, content = error.code, error.read()
+        if status != expected_status:
+            try:
+                message = json.loads(content.decode('utf-8')).get('error', '')
+            except ValueError:
+                message = ''
+            raise AcraAPIError(status, message)
+        return content
+
+    def get_openapi(self):
+        """Return OpenAPI document served by AcraServer."""
+        return self._request('GET', '/v1/openapi.yaml', 200).decode('utf-8')
+
+    def create_zone(self):
+        """Generate new zone, return dict with id and public_key (bytes)."""
+        zone = json.loads(self._request('POST', '/v1/zones', 200).decode('utf-8'))
+        zone['public_key'] = base64.b64decode(zone['public_key'])
+        return zone
+
+    def create_zones(self, count):
+        """Generate count zones at once, return list of dicts with id and public_key (bytes) and quota left."""
+        batch = json.loads(self._request('POST', '/v1/zones/batch', 200, body={'count': count}).decode('utf-8'))
+        for zone in batch['zones']:
+            zone['public_key'] = base64.b64decode(zone['public_key'])
+        return batch['zones'], batch['quota_left']
+
+    def reset_keystore(self):
+        """Clear cache of keystore."""
+        self._request('POST', '/v1/keystore/reset', 204)
+
+    def get_key_expirations(self, within_days=30):
+        """Return expired keys and keys which expire in within_days days ordered by expiration."""
+        return json.loads(self._request('GET', '/v1/keys/expirations?' + urlencode({'within_days': within_days}), 200).decode('utf-8'))
+
+    def get_auth_data(self):
+        """Return users of AcraWebConfig in format of acra-authmanager."""
+        return self._request('GET', '/v1/auth_data', 200).decode('utf-8')
+
+    def get_config(self):
+        """Return configuration editable through API."""
+        return json.loads(self._request('GET', '/v1/config', 200).decode('utf-8'))
+
+    def set_config(self, config):
+        """Save configuration, AcraServer restarts after that."""
+        self._request('PUT', '/v1/config', 202, body=config)
+
+    def get_connections(self):
+        """Return counters of active client connections."""
+        return json.loads(self._request('GET', '/v1/connections', 200).decode('utf-8'))
+
+    def terminate_connection(self, connection_id):
+        """Close active client connection and its connection to database."""
+        self._request('DELETE', '/v1/connections?' + urlencode({'connection_id': connection_id}), 204)
+
+    def set_connection_zone(self, connection_id, zone_id):
+        """Set zone used to decrypt results of all following queries of active connection."""
+        self._request('PUT', '/v1/connections/zone', 204, body={'connection_id': connection_id, 'zone_id': zone_id})
+
+    def reset_connection_zone(self, connection_id):
+        """Reset zone of active connection, zone will be taken from queries again."""
+        self._request('DELETE', '/v1/connections/zone?' + urlencode({'connection_id': connection_id}), 204)
+
+    def get_payload_stats(self):
+        """Return sizes of decrypted values aggregated per client and table."""
+        return json.loads(self._request('GET', '/v1/stats/payload', 200).decode('utf-8'))
+
+    def get_statement_stats(self):
+        """Return executions of normalized statements aggregated per client, the most expensive first."""
+        return json.loads(self._request('GET', '/v1/stats/statements', 200).decode('utf-8'))
+
+    def reset_statement_stats(self):
+        """Remove aggregated executions of statements."""
+        self._request('DELETE', '/v1/stats/statements', 204)
+
+    def get_log_levels(self):
+        """Return log levels overridden for client ids and ip addresses."""
+        return json.loads(self._request('GET', '/v1/logging/levels', 200).decode('utf-8'))
+
+    def set_log_level(self, level, client_id=None, address=None):
+        """Raise log level of connections of client id or from ip address."""
+        override = {'level': level}
+        if client_id is not None:
+            override['client_id'] = client_id
+        if address is not None:
+            override['address'] = address
+        self._request('PUT', '/v1/logging/levels', 204, body=override)
+
+    def remove_log_level(self, client_id=None, address=None):
+        """Return log level of connections of client id or from ip address to global level."""
+        query = {}
+        if client_id is not None:
+            query['client_id'] = client_id
+        if address is not None:
+            query['address'] = address
+        self._request('DELETE', '/v1/logging/levels?' + urlencode(query), 204)
+
+    def validate_schema(self):
+        """Return list of mismatches between encryptor configuration and database schema."""
+        return json.loads(self._request('POST', '/v1/schema/validate', 200).decode('utf-8'))['warnings']
+
+    def drain(self):
+        """Stop accepting connections and shut down after active ones are closed."""
+        self._request('POST', '/v1/drain', 202)
+
+    def get_standby_state(self):
+        """Return cached keys and handshake bans copied by warm standby AcraServer."""
+        return json.loads(self._request('GET', '/v1/standby/state', 200).decode('utf-8'))
+
+    def get_unseal_status(self):
+        """Return progress of master key reconstruction served by unseal API."""
+        return json.loads(self._request('GET', '/v1/unseal', 200).decode('utf-8'))
+
+    def unseal(self, share):
+        """Add share of master key and return progress of its reconstruction."""
+        return json.loads(self._request('POST', '/v1/unseal', 200,
+                                        body={'share': share}).decode('utf-8'))
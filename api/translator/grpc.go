@@ -0,0 +1,120 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package translator
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/cossacklabs/acra/cmd/acra-translator/grpc_api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCClient decrypts AcraStructs with gRPC API of AcraTranslator using pool of connections
+type GRPCClient struct {
+	connections []*grpc.ClientConn
+	readers     []grpc_api.ReaderClient
+	next        uint32
+	options     *options
+}
+
+// DialGRPC connects to AcraTranslator (or AcraConnector in front of it) at address like "127.0.0.1:9696".
+// dialOptions are used for each connection of pool, connection without transport security used if none specified
+func DialGRPC(address string, dialOptions []grpc.DialOption, optionList ...Option) (*GRPCClient, error) {
+	options := newOptions(optionList)
+	if len(dialOptions) == 0 {
+		dialOptions = []grpc.DialOption{grpc.WithInsecure()}
+	}
+	connections := make([]*grpc.ClientConn, 0, options.poolSize)
+	for i := 0; i < options.poolSize; i++ {
+		connection, err := grpc.Dial(address, dialOptions...)
+		if err != nil {
+			for _, opened := range connections {
+				opened.Close()
+			}
+			return nil, err
+		}
+		connections = append(connections, connection)
+	}
+	return NewGRPCClient(connections, optionList...), nil
+}
+
+// NewGRPCClient returns client which sends requests over connections in round-robin order. connections shouldn't be empty
+func NewGRPCClient(connections []*grpc.ClientConn, optionList ...Option) *GRPCClient {
+	readers := make([]grpc_api.ReaderClient, len(connections))
+	for i, connection := range connections {
+		readers[i] = grpc_api.NewReaderClient(connection)
+	}
+	return &GRPCClient{connections: connections, readers: readers, options: newOptions(optionList)}
+}
+
+// Close closes all connections of pool
+func (client *GRPCClient) Close() error {
+	var closeErr error
+	for _, connection := range client.connections {
+		if err := connection.Close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+	return closeErr
+}
+
+// Decrypt decrypts AcraStruct of request, retrying on overload and unavailable connection
+func (client *GRPCClient) Decrypt(ctx context.Context, request *DecryptRequest) ([]byte, error) {
+	grpcRequest := &grpc_api.DecryptRequest{ClientId: request.ClientID, ZoneId: request.ZoneID, Acrastruct: request.AcraStruct}
+	var data []byte
+	err := client.options.retryPolicy.do(ctx, func() error {
+		reader := client.readers[int(atomic.AddUint32(&client.next, 1)-1)%len(client.readers)]
+		attemptCtx, cancel := context.WithTimeout(ctx, client.options.timeout)
+		defer cancel()
+		response, err := reader.Decrypt(attemptCtx, grpcRequest)
+		if err != nil {
+			return grpcError(err)
+		}
+		data = response.Data
+		return nil
+	})
+	return data, err
+}
+
+// grpcError converts error of gRPC call to Error. AcraTranslator returns errors of grpc_api package as messages
+func grpcError(err error) error {
+	grpcStatus, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	translatorError := &Error{Message: grpcStatus.Message()}
+	switch {
+	case grpcStatus.Code() == codes.Unavailable:
+		translatorError.Kind = ErrUnavailable
+	case grpcStatus.Code() == codes.ResourceExhausted || grpcStatus.Message() == grpc_api.ErrOverloaded.Error():
+		translatorError.Kind = ErrOverloaded
+	case grpcStatus.Code() == codes.Unauthenticated || grpcStatus.Code() == codes.PermissionDenied:
+		translatorError.Kind = ErrUnauthorized
+	case grpcStatus.Code() == codes.InvalidArgument || grpcStatus.Message() == grpc_api.ErrClientIDRequired.Error():
+		translatorError.Kind = ErrBadRequest
+	case grpcStatus.Message() == grpc_api.ErrCantDecrypt.Error():
+		translatorError.Kind = ErrDecryptionFailed
+	case grpcStatus.Code() == codes.DeadlineExceeded || grpcStatus.Code() == codes.Canceled:
+		return err
+	default:
+		translatorError.Kind = ErrUnexpectedResponse
+	}
+	return translatorError
+}
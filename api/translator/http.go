@@ -0,0 +1,184 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package translator
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/cossacklabs/acra/cmd/acra-translator/common"
+)
+
+// maxErrorMessageLength limits size of error message read from response
+const maxErrorMessageLength = 1024
+
+// HTTPClient decrypts AcraStructs with HTTP API of AcraTranslator
+type HTTPClient struct {
+	baseURL    string
+	httpClient *http.Client
+	options    *options
+}
+
+// NewHTTPClient returns client of AcraTranslator (or AcraConnector in front of it) at baseURL like
+// "http://127.0.0.1:9494"
+func NewHTTPClient(baseURL string, optionList ...Option) *HTTPClient {
+	options := newOptions(optionList)
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		MaxIdleConns:        options.poolSize,
+		MaxIdleConnsPerHost: options.poolSize,
+	}
+	return &HTTPClient{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Transport: transport, Timeout: options.timeout},
+		options:    options,
+	}
+}
+
+// Decrypt decrypts AcraStruct of request, retrying on overload and network errors
+func (client *HTTPClient) Decrypt(ctx context.Context, request *DecryptRequest) ([]byte, error) {
+	query := url.Values{}
+	if len(request.ZoneID) != 0 {
+		query.Set("zone_id", string(request.ZoneID))
+	}
+	return client.postWithRetries(ctx, "/v1/decrypt", query, request.AcraStruct)
+}
+
+// DecryptSecureMessage decrypts Secure Message encrypted by peer for client with storage key pair of client
+func (client *HTTPClient) DecryptSecureMessage(ctx context.Context, peerID, secureMessage []byte) ([]byte, error) {
+	return client.postWithRetries(ctx, "/v1/securemessage_decrypt", url.Values{"peer_id": {string(peerID)}}, secureMessage)
+}
+
+// VerifySecureMessage verifies Secure Message signed by peer and returns signed data
+func (client *HTTPClient) VerifySecureMessage(ctx context.Context, peerID, secureMessage []byte) ([]byte, error) {
+	return client.postWithRetries(ctx, "/v1/securemessage_verify", url.Values{"peer_id": {string(peerID)}}, secureMessage)
+}
+
+// DecryptSecureCell decrypts Secure Cell in seal mode encrypted with symmetric key of client and context
+func (client *HTTPClient) DecryptSecureCell(ctx context.Context, secureCell, cellContext []byte) ([]byte, error) {
+	query := url.Values{}
+	if len(cellContext) != 0 {
+		query.Set("context", string(cellContext))
+	}
+	return client.postWithRetries(ctx, "/v1/securecell_decrypt", query, secureCell)
+}
+
+// DecryptRowSecureCell decrypts Secure Cell in seal mode encrypted with key of row derived from symmetric key of
+// client (or of zoneID if it isn't empty) and rowContext, like cells created by acrawriter.CreateRowSecureCell
+func (client *HTTPClient) DecryptRowSecureCell(ctx context.Context, secureCell, cellContext, zoneID, rowContext []byte) ([]byte, error) {
+	query := url.Values{"row_context": {string(rowContext)}}
+	if len(cellContext) != 0 {
+		query.Set("context", string(cellContext))
+	}
+	if len(zoneID) != 0 {
+		query.Set("zone_id", string(zoneID))
+	}
+	return client.postWithRetries(ctx, "/v1/securecell_decrypt", query, secureCell)
+}
+
+// GetObject returns content of object from bucket of object storage proxied by AcraTranslator with AcraStreams and
+// AcraStructs decrypted with keys of client (or of zoneID if it isn't empty). Content is streamed as it is decrypted
+// and should be closed by caller. Request isn't retried and timeout of client limits whole reading of content
+func (client *HTTPClient) GetObject(ctx context.Context, bucket, key string, zoneID []byte) (io.ReadCloser, error) {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	requestURL := client.baseURL + "/v1/objects/" + url.PathEscape(bucket) + "/" + strings.Join(segments, "/")
+	if len(zoneID) != 0 {
+		requestURL += "?" + url.Values{"zone_id": {string(zoneID)}}.Encode()
+	}
+	httpRequest, err := http.NewRequest(http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpRequest = httpRequest.WithContext(ctx)
+	if client.options.hmacSecret != nil {
+		common.SignRequest(httpRequest, client.options.hmacClient, client.options.hmacSecret, nil)
+	}
+	response, err := client.httpClient.Do(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusOK {
+		return response.Body, nil
+	}
+	defer response.Body.Close()
+	message, _ := ioutil.ReadAll(io.LimitReader(response.Body, maxErrorMessageLength))
+	return nil, &Error{Kind: httpStatusErrorKind(response.StatusCode), StatusCode: response.StatusCode, Message: string(message)}
+}
+
+// postWithRetries sends request with post, retrying on overload and network errors
+func (client *HTTPClient) postWithRetries(ctx context.Context, path string, query url.Values, body []byte) ([]byte, error) {
+	var data []byte
+	err := client.options.retryPolicy.do(ctx, func() error {
+		var err error
+		data, err = client.post(ctx, path, query, body)
+		return err
+	})
+	return data, err
+}
+
+// post sends body to endpoint of AcraTranslator and returns body of successful response
+func (client *HTTPClient) post(ctx context.Context, path string, query url.Values, body []byte) ([]byte, error) {
+	requestURL := client.baseURL + path
+	if len(query) != 0 {
+		requestURL += "?" + query.Encode()
+	}
+	httpRequest, err := http.NewRequest(http.MethodPost, requestURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpRequest = httpRequest.WithContext(ctx)
+	httpRequest.Header.Set("Content-Type", "application/octet-stream")
+	if client.options.hmacSecret != nil {
+		common.SignRequest(httpRequest, client.options.hmacClient, client.options.hmacSecret, body)
+	}
+	response, err := client.httpClient.Do(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusOK {
+		return ioutil.ReadAll(response.Body)
+	}
+	message, _ := ioutil.ReadAll(io.LimitReader(response.Body, maxErrorMessageLength))
+	return nil, &Error{Kind: httpStatusErrorKind(response.StatusCode), StatusCode: response.StatusCode, Message: string(message)}
+}
+
+// httpStatusErrorKind maps status of response of AcraTranslator to kind of error
+func httpStatusErrorKind(status int) error {
+	switch status {
+	case http.StatusBadRequest, http.StatusMethodNotAllowed, http.StatusRequestEntityTooLarge:
+		return ErrBadRequest
+	case http.StatusNotFound:
+		return ErrObjectNotFound
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusUnprocessableEntity:
+		return ErrDecryptionFailed
+	case http.StatusServiceUnavailable:
+		return ErrOverloaded
+	default:
+		return ErrUnexpectedResponse
+	}
+}
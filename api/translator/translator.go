@@ -0,0 +1,220 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package translator implements Go client of AcraTranslator HTTP and gRPC API. Clients retry requests rejected
+// because of overload or failed by network errors, keep pool of connections and return typed errors, so
+// applications don't need own wrappers around API.
+package translator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Kinds of errors returned by clients. Use ErrorKind or errors.Is to check kind of returned error
+var (
+	ErrBadRequest         = errors.New("request rejected as malformed")
+	ErrUnauthorized       = errors.New("request isn't authorized")
+	ErrDecryptionFailed   = errors.New("can't decrypt AcraStruct")
+	ErrOverloaded         = errors.New("too many simultaneous decryptions")
+	ErrUnavailable        = errors.New("translator is unavailable")
+	ErrObjectNotFound     = errors.New("object not found")
+	ErrUnexpectedResponse = errors.New("unexpected response")
+)
+
+// Error is error returned by AcraTranslator
+type Error struct {
+	// Kind is one of Err* errors of package
+	Kind error
+	// StatusCode is HTTP status of response, zero for gRPC
+	StatusCode int
+	Message    string
+}
+
+func (err *Error) Error() string {
+	if err.Message != "" {
+		return fmt.Sprintf("%s: %s", err.Kind, err.Message)
+	}
+	return err.Kind.Error()
+}
+
+// Cause returns kind of error, so acraerrors.Cause returns it too
+func (err *Error) Cause() error {
+	return err.Kind
+}
+
+// ErrorKind returns kind of error returned by AcraTranslator or err as is for other errors
+func ErrorKind(err error) error {
+	if translatorError, ok := err.(*Error); ok {
+		return translatorError.Kind
+	}
+	return err
+}
+
+// isRetryable returns true for overloaded or unavailable translator and network errors
+func isRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if translatorError, ok := err.(*Error); ok {
+		return translatorError.Kind == ErrOverloaded || translatorError.Kind == ErrUnavailable
+	}
+	_, isNetError := err.(net.Error)
+	return isNetError
+}
+
+// DecryptRequest is AcraStruct to decrypt with client id or zone id
+type DecryptRequest struct {
+	// ClientID used to decrypt AcraStruct without zone. Sent only with gRPC, over HTTP AcraTranslator takes client id
+	// from connection or signature of request
+	ClientID   []byte
+	ZoneID     []byte
+	AcraStruct []byte
+}
+
+// Decryptor decrypts AcraStructs with AcraTranslator
+type Decryptor interface {
+	Decrypt(ctx context.Context, request *DecryptRequest) ([]byte, error)
+}
+
+// RetryPolicy describes how many times and with which delays requests are repeated
+type RetryPolicy struct {
+	// MaxAttempts is count of attempts including first one, 1 disables retries
+	MaxAttempts int
+	// InitialBackoff is delay before second attempt, each next delay doubles up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy used by clients if other wasn't specified
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond * 100, MaxBackoff: time.Second * 2}
+
+// do calls function until it succeeds, returns not retryable error, attempts end or context is done
+func (policy RetryPolicy) do(ctx context.Context, function func() error) error {
+	backoff := policy.InitialBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = function()
+		if !isRetryable(err) || attempt >= policy.MaxAttempts {
+			return err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+// DefaultPoolSize is count of connections kept by clients if other wasn't specified
+const DefaultPoolSize = 4
+
+type options struct {
+	retryPolicy RetryPolicy
+	poolSize    int
+	hmacClient  []byte
+	hmacSecret  []byte
+	timeout     time.Duration
+}
+
+// Option configures clients
+type Option func(*options)
+
+// WithRetryPolicy sets policy of repeating failed requests
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(options *options) {
+		options.retryPolicy = policy
+	}
+}
+
+// WithPoolSize sets count of idle HTTP connections or gRPC connections kept by client
+func WithPoolSize(size int) Option {
+	return func(options *options) {
+		if size > 0 {
+			options.poolSize = size
+		}
+	}
+}
+
+// WithHMACSecret signs HTTP requests with shared secret of client id, AcraTranslator decrypts AcraStructs of signed
+// requests with client id of signature
+func WithHMACSecret(clientID, secret []byte) Option {
+	return func(options *options) {
+		options.hmacClient = clientID
+		options.hmacSecret = secret
+	}
+}
+
+// WithTimeout sets timeout of each attempt of request
+func WithTimeout(timeout time.Duration) Option {
+	return func(options *options) {
+		options.timeout = timeout
+	}
+}
+
+func newOptions(optionList []Option) *options {
+	result := &options{retryPolicy: DefaultRetryPolicy, poolSize: DefaultPoolSize, timeout: time.Second * 10}
+	for _, option := range optionList {
+		option(result)
+	}
+	return result
+}
+
+// DecryptResult is result of decryption of one AcraStruct of batch
+type DecryptResult struct {
+	Data []byte
+	Err  error
+}
+
+// DecryptBatch decrypts requests using up to concurrency simultaneous requests and returns results in the same order
+// as requests. Errors of separate requests don't stop processing of other ones
+func DecryptBatch(ctx context.Context, decryptor Decryptor, requests []*DecryptRequest, concurrency int) []DecryptResult {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	results := make([]DecryptResult, len(requests))
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+	for i := 0; i < concurrency && i < len(requests); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				if err := ctx.Err(); err != nil {
+					results[index].Err = err
+					continue
+				}
+				results[index].Data, results[index].Err = decryptor.Decrypt(ctx, requests[index])
+			}
+		}()
+	}
+	for i := range requests {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package translator
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/cmd/acra-translator/common"
+	"github.com/cossacklabs/acra/cmd/acra-translator/grpc_api"
+	netContext "golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+var testRetryPolicy = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond * 2}
+
+func TestHTTPClient(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	authenticator, err := common.NewHMACAuthenticator([]byte("clients:\n  - client_id: client\n    secret: "+base64.StdEncoding.EncodeToString(secret)), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var overloadedRequests int32
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == http.MethodGet && strings.HasPrefix(request.URL.Path, "/v1/objects/") {
+			if request.URL.Path == "/v1/objects/bucket/missing" {
+				writer.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writer.Write([]byte(request.URL.EscapedPath() + "?" + request.URL.RawQuery))
+			return
+		}
+		if request.Method != http.MethodPost {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if request.URL.Path != "/v1/decrypt" {
+			body, _ := ioutil.ReadAll(request.Body)
+			writer.Write(append([]byte(request.URL.Path+"?"+request.URL.RawQuery+":"), body...))
+			return
+		}
+		body, _ := ioutil.ReadAll(request.Body)
+		if common.IsSigned(request) {
+			if _, err := authenticator.Authenticate(request, body); err != nil {
+				writer.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		switch string(body) {
+		case "overloaded":
+			atomic.AddInt32(&overloadedRequests, 1)
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			writer.Write([]byte("Too many simultaneous decryptions, try later"))
+		case "invalid":
+			writer.WriteHeader(http.StatusUnprocessableEntity)
+			writer.Write([]byte("Can't decrypt AcraStruct"))
+		default:
+			writer.Write(append([]byte(request.URL.Query().Get("zone_id")+":"), body...))
+		}
+	}))
+	defer server.Close()
+
+	client := NewHTTPClient(server.URL+"/", WithRetryPolicy(testRetryPolicy))
+	data, err := client.Decrypt(context.Background(), &DecryptRequest{ZoneID: []byte("zone"), AcraStruct: []byte("data")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "zone:data" {
+		t.Fatalf("Incorrect decrypted data %s", data)
+	}
+
+	_, err = client.Decrypt(context.Background(), &DecryptRequest{AcraStruct: []byte("invalid")})
+	if ErrorKind(err) != ErrDecryptionFailed || err.(*Error).StatusCode != http.StatusUnprocessableEntity || err.(*Error).Message != "Can't decrypt AcraStruct" {
+		t.Fatalf("Expected decryption error, took %v", err)
+	}
+
+	_, err = client.Decrypt(context.Background(), &DecryptRequest{AcraStruct: []byte("overloaded")})
+	if ErrorKind(err) != ErrOverloaded {
+		t.Fatalf("Expected overloaded error, took %v", err)
+	}
+	if overloadedRequests != int32(testRetryPolicy.MaxAttempts) {
+		t.Fatalf("Expected %d attempts, took %d", testRetryPolicy.MaxAttempts, overloadedRequests)
+	}
+
+	data, err = client.DecryptSecureMessage(context.Background(), []byte("peer"), []byte("data"))
+	if err != nil || string(data) != "/v1/securemessage_decrypt?peer_id=peer:data" {
+		t.Fatalf("Incorrect Secure Message request %s, %v", data, err)
+	}
+	data, err = client.VerifySecureMessage(context.Background(), []byte("peer"), []byte("data"))
+	if err != nil || string(data) != "/v1/securemessage_verify?peer_id=peer:data" {
+		t.Fatalf("Incorrect Secure Message request %s, %v", data, err)
+	}
+	data, err = client.DecryptSecureCell(context.Background(), []byte("data"), []byte("some context"))
+	if err != nil || string(data) != "/v1/securecell_decrypt?context=some+context:data" {
+		t.Fatalf("Incorrect Secure Cell request %s, %v", data, err)
+	}
+	data, err = client.DecryptRowSecureCell(context.Background(), []byte("data"), nil, []byte("zone"), []byte("users:1"))
+	if err != nil || string(data) != "/v1/securecell_decrypt?row_context=users%3A1&zone_id=zone:data" {
+		t.Fatalf("Incorrect row Secure Cell request %s, %v", data, err)
+	}
+
+	object, err := client.GetObject(context.Background(), "bucket", "dir/some file.bin", []byte("zone"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadAll(object)
+	object.Close()
+	if err != nil || string(data) != "/v1/objects/bucket/dir/some%20file.bin?zone_id=zone" {
+		t.Fatalf("Incorrect object request %s, %v", data, err)
+	}
+	if _, err := client.GetObject(context.Background(), "bucket", "missing", nil); ErrorKind(err) != ErrObjectNotFound {
+		t.Fatalf("Expected object not found error, took %v", err)
+	}
+
+	signedClient := NewHTTPClient(server.URL, WithHMACSecret([]byte("client"), secret))
+	if _, err := signedClient.Decrypt(context.Background(), &DecryptRequest{AcraStruct: []byte("data")}); err != nil {
+		t.Fatal(err)
+	}
+	wrongClient := NewHTTPClient(server.URL, WithHMACSecret([]byte("client"), []byte("fedcba9876543210")))
+	if _, err := wrongClient.Decrypt(context.Background(), &DecryptRequest{AcraStruct: []byte("data")}); ErrorKind(err) != ErrUnauthorized {
+		t.Fatalf("Expected unauthorized error, took %v", err)
+	}
+}
+
+type testReaderServer struct {
+	requests int32
+}
+
+func (server *testReaderServer) Decrypt(ctx netContext.Context, request *grpc_api.DecryptRequest) (*grpc_api.DecryptResponse, error) {
+	atomic.AddInt32(&server.requests, 1)
+	switch {
+	case len(request.ClientId) == 0:
+		return nil, grpc_api.ErrClientIDRequired
+	case bytes.Equal(request.Acrastruct, []byte("overloaded")):
+		return nil, grpc_api.ErrOverloaded
+	case bytes.Equal(request.Acrastruct, []byte("invalid")):
+		return nil, grpc_api.ErrCantDecrypt
+	}
+	return &grpc_api.DecryptResponse{Data: append(append(request.ClientId, ':'), request.Acrastruct...)}, nil
+}
+
+func TestGRPCClient(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	readerServer := &testReaderServer{}
+	server := grpc.NewServer()
+	grpc_api.RegisterReaderServer(server, readerServer)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	client, err := DialGRPC(listener.Addr().String(), nil, WithPoolSize(2), WithRetryPolicy(testRetryPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if len(client.connections) != 2 {
+		t.Fatalf("Expected pool of 2 connections, took %d", len(client.connections))
+	}
+
+	requests := []*DecryptRequest{
+		{ClientID: []byte("client"), AcraStruct: []byte("first")},
+		{AcraStruct: []byte("without client")},
+		{ClientID: []byte("client"), AcraStruct: []byte("invalid")},
+		{ClientID: []byte("client"), AcraStruct: []byte("second")},
+	}
+	results := DecryptBatch(context.Background(), client, requests, 2)
+	if string(results[0].Data) != "client:first" || results[0].Err != nil {
+		t.Fatalf("Incorrect first result %v", results[0])
+	}
+	if ErrorKind(results[1].Err) != ErrBadRequest {
+		t.Fatalf("Expected bad request error, took %v", results[1].Err)
+	}
+	if ErrorKind(results[2].Err) != ErrDecryptionFailed {
+		t.Fatalf("Expected decryption error, took %v", results[2].Err)
+	}
+	if string(results[3].Data) != "client:second" || results[3].Err != nil {
+		t.Fatalf("Incorrect last result %v", results[3])
+	}
+
+	atomic.StoreInt32(&readerServer.requests, 0)
+	_, err = client.Decrypt(context.Background(), &DecryptRequest{ClientID: []byte("client"), AcraStruct: []byte("overloaded")})
+	if ErrorKind(err) != ErrOverloaded {
+		t.Fatalf("Expected overloaded error, took %v", err)
+	}
+	if readerServer.requests != int32(testRetryPolicy.MaxAttempts) {
+		t.Fatalf("Expected %d attempts, took %d", testRetryPolicy.MaxAttempts, readerServer.requests)
+	}
+}
+
+func TestDecryptBatchCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results := DecryptBatch(ctx, NewHTTPClient("http://127.0.0.1:1"), []*DecryptRequest{{}, {}}, 0)
+	for _, result := range results {
+		if result.Err != context.Canceled {
+			t.Fatalf("Expected cancelled context error, took %v", result.Err)
+		}
+	}
+}
@@ -15,7 +15,9 @@ limitations under the License.
 */
 
 // Package main is entry point for AcraAddZone utility. AcraAddZone allows to generate Zone data (ID and public key)
-// that should be used to create AcraStructs.
+// that should be used to create AcraStructs. In bulk mode AcraAddZone creates zones for all external ids from CSV/JSON
+// manifest and stores mapping between external ids and zones in registry, so repeated invocations return already
+// created zones instead of new ones.
 // Zones are the way to cryptographically compartmentalise records in an already-encrypted environment.
 // Zones rely on different private keys on the server side. The idea behind Zones is very simple
 // (yet quite specific to some use-cases): when we store sensitive data, it's frequently related to users /
@@ -39,6 +41,7 @@ import (
 	"github.com/cossacklabs/themis/gothemis/keys"
 	log "github.com/sirupsen/logrus"
 	"os"
+	"path/filepath"
 )
 
 // Constants used by AcraAddZone util.
@@ -51,6 +54,11 @@ var (
 func main() {
 	outputDir := flag.String("keys_output_dir", keystore.DefaultKeyDirShort, "Folder where will be saved generated zone keys")
 	fsKeystore := flag.Bool("fs_keystore_enable", true, "Use filesystem key store")
+	zoneIDGeneratorLoader := cmd.RegisterZoneIDGeneratorFlags()
+	manifestPath := flag.String("manifest_file", "", "Path to CSV (external id in first column) or JSON ([{\"external_id\": \"id\"}]) manifest to create zones for all external ids")
+	manifestFormat := flag.String("manifest_format", "", "Format of manifest: csv or json (detected by file extension by default)")
+	registryPath := flag.String("registry_file", "", "Path to registry of zones created for external ids (<keys_output_dir>/"+DefaultRegistryFilename+" by default)")
+	outputPath := flag.String("output_file", "", "Path to file where will be saved JSON with created zones in bulk mode (stdout by default)")
 
 	logging.SetLogLevel(logging.LOG_VERBOSE)
 
@@ -62,6 +70,11 @@ func main() {
 	//LoadFromConfig(DEFAULT_CONFIG_PATH)
 	//iniflags.Parse()
 
+	if err := zoneIDGeneratorLoader.SetIDGenerator(); err != nil {
+		log.WithError(err).Errorln("can't set format of zone ids")
+		os.Exit(1)
+	}
+
 	output, err := utils.AbsPath(*outputDir)
 	if err != nil {
 		log.WithError(err).Errorln("can't get absolute path for output dir")
@@ -87,6 +100,16 @@ func main() {
 	} else {
 		panic("No more supported keystores")
 	}
+	if *manifestPath != "" {
+		if *registryPath == "" {
+			*registryPath = filepath.Join(output, DefaultRegistryFilename)
+		}
+		if err := AddZonesFromManifest(*manifestPath, *manifestFormat, *registryPath, *outputPath, os.Stdout, keyStore); err != nil {
+			log.WithError(err).Errorln("can't add zones from manifest")
+			os.Exit(1)
+		}
+		return
+	}
 	id, publicKey, err := keyStore.GenerateZoneKey()
 	if err != nil {
 		log.WithError(err).Errorln("can't add zone")
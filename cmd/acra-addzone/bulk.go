@@ -0,0 +1,216 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/utils"
+)
+
+// Supported formats of manifest with external ids
+const (
+	ManifestFormatCSV  = "csv"
+	ManifestFormatJSON = "json"
+)
+
+// DefaultRegistryFilename is name of file in keys directory which stores zones created in bulk mode
+const DefaultRegistryFilename = "zones_registry.json"
+
+// Errors returned in bulk mode
+var (
+	ErrUnsupportedManifestFormat = errors.New("unsupported manifest format, use csv or json")
+	ErrEmptyExternalID           = errors.New("manifest contains empty external id")
+)
+
+// MissingZoneKeyError returned if zone is registered for external id but its private key isn't found in keystore
+type MissingZoneKeyError struct {
+	ExternalID string
+}
+
+func (err *MissingZoneKeyError) Error() string {
+	return "zone registered for external id " + err.ExternalID + " but its private key not found in keystore"
+}
+
+// ZoneData describes zone created for external id. Public key is encoded with base64 in json
+type ZoneData struct {
+	ExternalID string `json:"external_id"`
+	ID         string `json:"id"`
+	PublicKey  []byte `json:"public_key"`
+}
+
+// ZoneRegistry maps external ids to created zones to don't create new zone on repeated invocations with same id
+type ZoneRegistry map[string]*ZoneData
+
+// GetManifestFormat returns format of manifest by file extension if format wasn't passed explicitly
+func GetManifestFormat(path, format string) string {
+	if format != "" {
+		return strings.ToLower(format)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return ManifestFormatJSON
+	}
+	return ManifestFormatCSV
+}
+
+// ParseManifest returns unique external ids from manifest. CSV manifest contains external id in first column of
+// each row without header, JSON manifest is array of objects [{"external_id": "id1"}, {"external_id": "id2"}]
+func ParseManifest(data []byte, format string) ([]string, error) {
+	var externalIDs []string
+	switch format {
+	case ManifestFormatCSV:
+		reader := csv.NewReader(bytes.NewReader(data))
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			externalIDs = append(externalIDs, strings.TrimSpace(record[0]))
+		}
+	case ManifestFormatJSON:
+		var records []*ZoneData
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			externalIDs = append(externalIDs, strings.TrimSpace(record.ExternalID))
+		}
+	default:
+		return nil, ErrUnsupportedManifestFormat
+	}
+	uniqueIDs := make([]string, 0, len(externalIDs))
+	seen := make(map[string]bool, len(externalIDs))
+	for _, externalID := range externalIDs {
+		if externalID == "" {
+			return nil, ErrEmptyExternalID
+		}
+		if !seen[externalID] {
+			seen[externalID] = true
+			uniqueIDs = append(uniqueIDs, externalID)
+		}
+	}
+	return uniqueIDs, nil
+}
+
+// LoadZoneRegistry reads registry from file or returns empty registry if file doesn't exist
+func LoadZoneRegistry(path string) (ZoneRegistry, error) {
+	registry := ZoneRegistry{}
+	exists, err := utils.FileExists(path)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return registry, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &registry); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// Save writes registry to temporary file and replaces old registry with it
+func (registry ZoneRegistry) Save(path string) error {
+	data, err := json.MarshalIndent(registry, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// AddZones returns zones for all external ids. Zones already registered for external ids are returned as is,
+// new zones are generated and added to registry. Registry contains all generated zones even if error was returned
+func AddZones(externalIDs []string, registry ZoneRegistry, keyStore keystore.KeyStore) ([]*ZoneData, error) {
+	zones := make([]*ZoneData, 0, len(externalIDs))
+	for _, externalID := range externalIDs {
+		if zoneData, ok := registry[externalID]; ok {
+			if !keyStore.HasZonePrivateKey([]byte(zoneData.ID)) {
+				return nil, &MissingZoneKeyError{ExternalID: externalID}
+			}
+			zones = append(zones, zoneData)
+			continue
+		}
+		id, publicKey, err := keyStore.GenerateZoneKey()
+		if err != nil {
+			return nil, acraerrors.Wrap(err, "can't generate zone for "+externalID)
+		}
+		zoneData := &ZoneData{ExternalID: externalID, ID: string(id), PublicKey: publicKey}
+		registry[externalID] = zoneData
+		zones = append(zones, zoneData)
+	}
+	return zones, nil
+}
+
+// AddZonesFromManifest creates zones for all external ids from manifest and writes them as JSON array to outputPath
+// or to stdout if outputPath is empty. Registry is saved even on error to don't lose zones generated before failure.
+// Returned errors are wrapped with step which failed, original error is returned by acraerrors.Cause
+func AddZonesFromManifest(manifestPath, manifestFormat, registryPath, outputPath string, stdout io.Writer, keyStore keystore.KeyStore) error {
+	manifest, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return acraerrors.Wrap(err, "can't read manifest")
+	}
+	externalIDs, err := ParseManifest(manifest, GetManifestFormat(manifestPath, manifestFormat))
+	if err != nil {
+		return acraerrors.Wrap(err, "can't parse manifest")
+	}
+	registry, err := LoadZoneRegistry(registryPath)
+	if err != nil {
+		return acraerrors.Wrap(err, "can't load zones registry")
+	}
+	zones, addErr := AddZones(externalIDs, registry, keyStore)
+	if err := registry.Save(registryPath); err != nil {
+		return acraerrors.Wrap(err, "can't save zones registry")
+	}
+	if addErr != nil {
+		return acraerrors.Wrap(addErr, "can't add zones")
+	}
+	jsonOutput, err := json.Marshal(zones)
+	if err != nil {
+		return acraerrors.Wrap(err, "can't encode to json")
+	}
+	if outputPath == "" {
+		_, err = fmt.Fprintln(stdout, string(jsonOutput))
+		return err
+	}
+	if err := ioutil.WriteFile(outputPath, jsonOutput, 0644); err != nil {
+		return acraerrors.Wrap(err, "can't write output")
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/filesystem"
+)
+
+func TestAddZonesFromManifest(t *testing.T) {
+	directory, err := ioutil.TempDir("", "acra_addzone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	encryptor, err := keystore.NewSCellKeyEncryptor([]byte("some key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keysDirectory := filepath.Join(directory, "keys")
+	keyStore, err := filesystem.NewFilesystemKeyStore(keysDirectory, encryptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestPath := filepath.Join(directory, "manifest.csv")
+	if err := ioutil.WriteFile(manifestPath, []byte("id1,name1\nid2\nid1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	registryPath := filepath.Join(directory, DefaultRegistryFilename)
+	outputPath := filepath.Join(directory, "output.json")
+
+	if err := AddZonesFromManifest(manifestPath, "", registryPath, outputPath, nil, keyStore); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var zones []*ZoneData
+	if err := json.Unmarshal(data, &zones); err != nil {
+		t.Fatal(err)
+	}
+	if len(zones) != 2 || zones[0].ExternalID != "id1" || zones[1].ExternalID != "id2" {
+		t.Fatalf("Expected zones of id1 and id2, took %s", data)
+	}
+	for _, zoneData := range zones {
+		if !keyStore.HasZonePrivateKey([]byte(zoneData.ID)) {
+			t.Fatalf("Expected generated key of zone %v", zoneData.ID)
+		}
+	}
+
+	// repeated invocation outputs same zones to stdout
+	stdout := &bytes.Buffer{}
+	if err := AddZonesFromManifest(manifestPath, "", registryPath, "", stdout, keyStore); err != nil {
+		t.Fatal(err)
+	}
+	var repeatedZones []*ZoneData
+	if err := json.Unmarshal(stdout.Bytes(), &repeatedZones); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(zones, repeatedZones) {
+		t.Fatalf("Expected same zones, took %s", stdout.Bytes())
+	}
+
+	// errors are wrapped with context
+	if err := AddZonesFromManifest(manifestPath, "xml", registryPath, outputPath, nil, keyStore); acraerrors.Cause(err) != ErrUnsupportedManifestFormat {
+		t.Fatalf("Expected %v, took %v", ErrUnsupportedManifestFormat, err)
+	}
+	if err := AddZonesFromManifest(filepath.Join(directory, "unknown.csv"), "", registryPath, outputPath, nil, keyStore); !os.IsNotExist(acraerrors.Cause(err)) {
+		t.Fatalf("Expected not exist error, took %v", err)
+	}
+	if err := os.RemoveAll(keysDirectory); err != nil {
+		t.Fatal(err)
+	}
+	keyStore, err = filesystem.NewFilesystemKeyStore(keysDirectory, encryptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddZonesFromManifest(manifestPath, "", registryPath, outputPath, nil, keyStore)
+	if _, ok := acraerrors.Cause(err).(*MissingZoneKeyError); !ok {
+		t.Fatalf("Expected MissingZoneKeyError, took %v", err)
+	}
+}
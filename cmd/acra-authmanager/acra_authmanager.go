@@ -16,26 +16,39 @@ limitations under the License.
 
 // Package main is entry point for AcraAuthManager. AcraAuthManager is part of AcraWebconfig HTTP server.
 // AcraAuthManager allows to generate users/passwords for accessing AcraWebconfig and updating config of AcraServer.
+// With api_enable it runs authenticated HTTP API, so users may be managed by automation instead of running CLI on host.
 //
 // https://github.com/cossacklabs/acra/wiki/AcraWebConfig
 package main
 
 import (
+	"crypto/rand"
+	"crypto/tls"
 	"errors"
 	"flag"
 	"fmt"
 	"github.com/cossacklabs/acra/cmd"
+	"github.com/cossacklabs/acra/httpauth"
 	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/acra/keystore/filesystem"
 	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/network"
 	"github.com/cossacklabs/acra/utils"
 	"github.com/cossacklabs/themis/gothemis/cell"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
 	"os"
+	"sort"
 	"strings"
 )
 
+// Errors returned on changes of users
+var (
+	ErrUserNotFound    = errors.New("user not found in file")
+	ErrEmptyPassword   = errors.New("passwords is empty")
+	ErrInvalidUserName = errors.New("user name must be non empty and can't contain ':', line breaks, leading or trailing spaces")
+)
+
 // HashedPasswords stores username:hashed_password map
 type HashedPasswords map[string]string
 
@@ -70,18 +83,35 @@ func (hp HashedPasswords) WriteToFile(file string, keystore *filesystem.Filesyst
 	if err != nil {
 		return err
 	}
+	return hp.writeToFileWithKey(file, key)
+}
+
+// writeToFileWithKey encrypts names and password hashes with key and replaces file atomically with temporary file,
+// so readers never see partially written file
+func (hp HashedPasswords) writeToFileWithKey(file string, key []byte) error {
 	SecureCell := cell.New(key, cell.CELL_MODE_SEAL)
 	crypted, _, err := SecureCell.Protect(hp.Bytes(), nil)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(file, crypted, 0600)
+	tmpFile := file + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, crypted, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile, file); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	return nil
 }
 
 // SetPassword sets hashed password to user name
 func (hp HashedPasswords) SetPassword(name, password string) (err error) {
 	if len(password) == 0 {
-		return errors.New("passwords is empty")
+		return ErrEmptyPassword
+	}
+	if name == "" || name != strings.TrimSpace(name) || strings.ContainsAny(name, AuthFieldSeparator+LineSeparator+"\r") {
+		return ErrInvalidUserName
 	}
 	salt := cmd.RandomStringBytes(SaltLength)
 	argon2Params := cmd.InitArgon2Params()
@@ -147,7 +177,7 @@ func removeUser(file, user string, keystore *filesystem.FilesystemKeyStore) erro
 	}
 	_, ok := passwords[user]
 	if !ok {
-		return errors.New("user not found in file")
+		return ErrUserNotFound
 	}
 	delete(passwords, user)
 	return passwords.WriteToFile(file, keystore)
@@ -169,21 +199,80 @@ func setPassword(file, name, password string, keystore *filesystem.FilesystemKey
 	return passwords.WriteToFile(file, keystore)
 }
 
+// listUsers returns sorted names of users from auth file, no users if file doesn't exist yet
+func listUsers(file string, keystore *filesystem.FilesystemKeyStore) ([]string, error) {
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	passwords, err := parseHtpasswdFile(file, keystore)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]string, 0, len(passwords))
+	for name := range passwords {
+		users = append(users, name)
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+// rotateAuthKey generates new key of auth file and encrypts users' hashes with it. File is replaced atomically before
+// key is switched, and previous file is restored if new key can't be saved, so file always may be decrypted with
+// stored key. AcraServer and AcraWebconfig read file with key from same keystore, so they use new key after keystore
+// reset or restart
+func rotateAuthKey(file string, keyStore *filesystem.FilesystemKeyStore) error {
+	previous, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	passwords := HashedPasswords(map[string]string{})
+	if err == nil {
+		passwords, err = parseHtpasswdFile(file, keyStore)
+		if err != nil {
+			return err
+		}
+	}
+	newKey := make([]byte, keystore.BasicAuthKeyLength)
+	if _, err := rand.Read(newKey); err != nil {
+		return err
+	}
+	if len(passwords) == 0 {
+		return keyStore.SaveAuthKey(newKey)
+	}
+	if err := passwords.writeToFileWithKey(file, newKey); err != nil {
+		return err
+	}
+	if err := keyStore.SaveAuthKey(newKey); err != nil {
+		if restoreErr := ioutil.WriteFile(file, previous, 0600); restoreErr != nil {
+			log.WithError(restoreErr).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorGeneral).Errorln("Can't restore auth file encrypted with previous key")
+		}
+		return err
+	}
+	return nil
+}
+
 func main() {
 	set := flag.Bool("set", false, "Add/update password for user")
 	remove := flag.Bool("remove", false, "Remove user")
+	rotate := flag.Bool("rotate", false, "Generate new key of auth file and re-encrypt hashes of users with it")
 	user := flag.String("user", "", "User")
 	password := flag.String("password", "", "Password")
 	filePath := flag.String("file", cmd.DEFAULT_ACRA_AUTH_PATH, "Auth file")
 	keysDir := flag.String("keys_dir", keystore.DefaultKeyDirShort, "Folder from which will be loaded keys")
 	debug := flag.Bool("d", false, "Turn on debug logging")
+	apiEnable := flag.Bool("api_enable", false, "Run HTTP API which manages users instead of single command, requests are authenticated with http_auth_providers_config_file")
+	apiConnectionString := flag.String("incoming_connection_api_string", network.BuildConnectionString(cmd.DEFAULT_ACRA_CONNECTION_PROTOCOL, cmd.DEFAULT_ACRAWEBCONFIG_HOST, cmd.DEFAULT_ACRAAUTHMANAGER_API_PORT, ""), "Connection string of HTTP API like tcp://x.x.x.x:yyyy or unix:///path/to/socket")
+	authProvidersConfigFile := flag.String("http_auth_providers_config_file", "", "Path to YAML file with authentication providers (static, ldap, oidc, mtls) which requests to HTTP API should pass")
+	tlsCert := flag.String("tls_cert", "", "Path to TLS certificate of HTTP API, API is served without TLS if empty")
+	tlsKey := flag.String("tls_key", "", "Path to private key of TLS certificate of HTTP API")
+	tlsCA := flag.String("tls_ca", "", "Path to root certificate which verifies client certificates for mtls authentication provider")
 
 	if err := cmd.Parse(DEFAULT_CONFIG_PATH, SERVICE_NAME); err != nil {
 		log.WithError(err).Errorln("can't parse cmd arguments")
 		os.Exit(1)
 	}
 
-	flags := []*bool{set, remove}
+	flags := []*bool{set, remove, rotate}
 
 	if *debug {
 		logging.SetLogLevel(logging.LOG_DEBUG)
@@ -212,12 +301,51 @@ func main() {
 		if *o {
 			n++
 			if n > 1 {
-				log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongParam).Errorln("Too many options, use one of --set or --remove")
+				log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongParam).Errorln("Too many options, use one of --set, --remove or --rotate")
 				os.Exit(1)
 			}
 		}
 	}
 
+	if *apiEnable {
+		if *authProvidersConfigFile == "" {
+			log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongParam).Errorln("HTTP API requires http_auth_providers_config_file")
+			os.Exit(1)
+		}
+		authProvidersConfig, err := ioutil.ReadFile(*authProvidersConfigFile)
+		if err != nil {
+			log.WithError(err).Errorln("Can't read http_auth_providers_config_file")
+			os.Exit(1)
+		}
+		authProvider, err := httpauth.LoadProviders(authProvidersConfig)
+		if err != nil {
+			log.WithError(err).Errorln("Can't load authentication providers")
+			os.Exit(1)
+		}
+		var tlsConfig *tls.Config
+		if *tlsCert != "" {
+			tlsConfig, err = network.NewTLSConfig("", *tlsCA, *tlsKey, *tlsCert, tls.VerifyClientCertIfGiven)
+			if err != nil {
+				log.WithError(err).Errorln("Can't create TLS config of HTTP API")
+				os.Exit(1)
+			}
+		}
+		api := newUsersAPI(*filePath, keyStore)
+		if err := api.ListenAndServe(*apiConnectionString, tlsConfig, authProvider); err != nil {
+			log.WithError(err).Errorln("HTTP API stopped")
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *rotate {
+		if err := rotateAuthKey(*filePath, keyStore); err != nil {
+			log.WithError(err).Errorln("Rotation of auth key failed")
+			os.Exit(1)
+		}
+		return
+	}
+
 	if *user == "" {
 		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongParam).Errorln("Empty user name/login")
 		flag.Usage()
@@ -0,0 +1,169 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cossacklabs/acra/api"
+	"github.com/cossacklabs/acra/httpauth"
+	"github.com/cossacklabs/acra/keystore/filesystem"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/network"
+	log "github.com/sirupsen/logrus"
+)
+
+// Paths of endpoints of HTTP API of AcraAuthManager
+const (
+	PathUsers         = "/v1/users"
+	PathRotateAuthKey = "/v1/auth_key/rotate"
+)
+
+// apiTimeout limits reading of request and writing of response of HTTP API
+const apiTimeout = 10 * time.Second
+
+// UserPassword adds new user or changes password of existing user
+type UserPassword struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// Users is list of users' names from auth file
+type Users struct {
+	Users []string `json:"users"`
+}
+
+// usersAPI manages users of auth file with HTTP API, changes of file are serialized
+type usersAPI struct {
+	lock     sync.Mutex
+	file     string
+	keystore *filesystem.FilesystemKeyStore
+}
+
+func newUsersAPI(file string, keystore *filesystem.FilesystemKeyStore) *usersAPI {
+	return &usersAPI{file: file, keystore: keystore}
+}
+
+// ListenAndServe serves HTTP API on connectionString, with TLS if tlsConfig isn't nil. Every request should be
+// authenticated by authProvider
+func (usersAPI *usersAPI) ListenAndServe(connectionString string, tlsConfig *tls.Config, authProvider httpauth.Provider) error {
+	listener, err := network.Listen(connectionString)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	log.WithField("connection_string", connectionString).Infoln("Start HTTP API")
+	server := &http.Server{Handler: usersAPI.handler(authProvider), ReadTimeout: apiTimeout, WriteTimeout: apiTimeout}
+	return server.Serve(listener)
+}
+
+// handler returns handler of all endpoints of HTTP API, every request should be authenticated by authProvider
+func (usersAPI *usersAPI) handler(authProvider httpauth.Provider) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(PathUsers, httpauth.Handler(authProvider, usersAPI.handleUsers))
+	mux.HandleFunc(PathRotateAuthKey, httpauth.Handler(authProvider, usersAPI.handleRotateAuthKey))
+	return mux
+}
+
+// writeJSON writes value in JSON with status
+func writeJSON(writer http.ResponseWriter, status int, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	if err := json.NewEncoder(writer).Encode(value); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorGeneral).Warningln("Can't write response")
+	}
+}
+
+// writeError writes error message in JSON with status
+func writeError(writer http.ResponseWriter, status int, message string) {
+	writeJSON(writer, status, api.Error{Error: message})
+}
+
+func (usersAPI *usersAPI) handleUsers(writer http.ResponseWriter, request *http.Request) {
+	usersAPI.lock.Lock()
+	defer usersAPI.lock.Unlock()
+	logger := log.WithField("remote_address", request.RemoteAddr)
+	switch request.Method {
+	case http.MethodGet:
+		users, err := listUsers(usersAPI.file, usersAPI.keystore)
+		if err != nil {
+			logger.WithError(err).Errorln("Can't read users")
+			writeError(writer, http.StatusInternalServerError, "can't read users")
+			return
+		}
+		writeJSON(writer, http.StatusOK, Users{Users: users})
+	case http.MethodPut:
+		var userPassword UserPassword
+		if err := json.NewDecoder(request.Body).Decode(&userPassword); err != nil {
+			writeError(writer, http.StatusBadRequest, "expected user and password in JSON")
+			return
+		}
+		err := setPassword(usersAPI.file, userPassword.User, userPassword.Password, usersAPI.keystore)
+		switch err {
+		case nil:
+		case ErrEmptyPassword, ErrInvalidUserName:
+			writeError(writer, http.StatusBadRequest, err.Error())
+			return
+		default:
+			logger.WithError(err).Errorln("Can't set password")
+			writeError(writer, http.StatusInternalServerError, "can't set password")
+			return
+		}
+		logger.WithField("user", userPassword.User).Infoln("Password of user set")
+		writer.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		user := request.URL.Query().Get("user")
+		err := removeUser(usersAPI.file, user, usersAPI.keystore)
+		switch err {
+		case nil:
+		case ErrUserNotFound:
+			writeError(writer, http.StatusNotFound, err.Error())
+			return
+		default:
+			logger.WithError(err).Errorln("Can't remove user")
+			writeError(writer, http.StatusInternalServerError, "can't remove user")
+			return
+		}
+		logger.WithField("user", user).Infoln("User removed")
+		writer.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(writer, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (usersAPI *usersAPI) handleRotateAuthKey(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writeError(writer, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	usersAPI.lock.Lock()
+	defer usersAPI.lock.Unlock()
+	logger := log.WithField("remote_address", request.RemoteAddr)
+	if err := rotateAuthKey(usersAPI.file, usersAPI.keystore); err != nil {
+		logger.WithError(err).Errorln("Can't rotate auth key")
+		writeError(writer, http.StatusInternalServerError, "can't rotate auth key")
+		return
+	}
+	logger.Infoln("Auth key rotated")
+	writer.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cossacklabs/acra/httpauth"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/filesystem"
+)
+
+// testAuthProvider accepts requests with test token only
+type testAuthProvider struct{}
+
+func (testAuthProvider) Authenticate(request *http.Request) (*httpauth.Identity, error) {
+	if request.Header.Get("Authorization") != "Bearer test" {
+		return nil, httpauth.ErrNoCredentials
+	}
+	return &httpauth.Identity{Name: "test", Provider: "test"}, nil
+}
+
+func newTestUsersAPI(t *testing.T) (*usersAPI, string) {
+	directory, err := ioutil.TempDir("", "authmanager_api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptor, err := keystore.NewSCellKeyEncryptor([]byte("some key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := filesystem.NewFilesystemKeyStore(filepath.Join(directory, "keys"), encryptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newUsersAPI(filepath.Join(directory, "auth.keys"), store), directory
+}
+
+func sendTestRequest(t *testing.T, server *httptest.Server, method, path, body string) (int, []byte) {
+	request, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Authorization", "Bearer test")
+	response, err := server.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response.StatusCode, data
+}
+
+func TestUsersAPI(t *testing.T) {
+	usersAPI, directory := newTestUsersAPI(t)
+	defer os.RemoveAll(directory)
+	server := httptest.NewServer(usersAPI.handler(testAuthProvider{}))
+	defer server.Close()
+
+	response, err := server.Client().Get(server.URL + PathUsers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected %v for unauthenticated request, took %v", http.StatusUnauthorized, response.StatusCode)
+	}
+
+	testcases := []struct {
+		Method string
+		Path   string
+		Body   string
+		Status int
+		Users  []string
+	}{
+		// no users while file doesn't exist
+		{http.MethodGet, PathUsers, "", http.StatusOK, []string{}},
+		{http.MethodPut, PathUsers, "not json", http.StatusBadRequest, nil},
+		{http.MethodPut, PathUsers, `{"user": "user1", "password": ""}`, http.StatusBadRequest, nil},
+		{http.MethodPut, PathUsers, `{"user": "user:1", "password": "password"}`, http.StatusBadRequest, nil},
+		{http.MethodPut, PathUsers, `{"user": "user1", "password": "password"}`, http.StatusNoContent, nil},
+		{http.MethodPut, PathUsers, `{"user": "user2", "password": "password"}`, http.StatusNoContent, nil},
+		{http.MethodGet, PathUsers, "", http.StatusOK, []string{"user1", "user2"}},
+		{http.MethodDelete, PathUsers + "?user=unknown", "", http.StatusNotFound, nil},
+		{http.MethodDelete, PathUsers + "?user=user1", "", http.StatusNoContent, nil},
+		{http.MethodGet, PathUsers, "", http.StatusOK, []string{"user2"}},
+		{http.MethodPost, PathUsers, "", http.StatusMethodNotAllowed, nil},
+		{http.MethodGet, PathRotateAuthKey, "", http.StatusMethodNotAllowed, nil},
+		{http.MethodPost, PathRotateAuthKey, "", http.StatusNoContent, nil},
+		// users are decrypted with rotated key
+		{http.MethodGet, PathUsers, "", http.StatusOK, []string{"user2"}},
+	}
+	for i, tcase := range testcases {
+		status, body := sendTestRequest(t, server, tcase.Method, tcase.Path, tcase.Body)
+		if status != tcase.Status {
+			t.Fatalf("[%d] Expected status %v, took %v (%s)", i, tcase.Status, status, body)
+		}
+		if tcase.Users == nil {
+			continue
+		}
+		var users Users
+		if err := json.Unmarshal(body, &users); err != nil {
+			t.Fatalf("[%d] %v", i, err)
+		}
+		if !reflect.DeepEqual(users.Users, tcase.Users) {
+			t.Fatalf("[%d] Expected users %v, took %v", i, tcase.Users, users.Users)
+		}
+	}
+}
+
+func TestRotateAuthKey(t *testing.T) {
+	usersAPI, directory := newTestUsersAPI(t)
+	defer os.RemoveAll(directory)
+	if err := setPassword(usersAPI.file, "user", "password", usersAPI.keystore); err != nil {
+		t.Fatal(err)
+	}
+	previousKey, err := usersAPI.keystore.GetAuthKey(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousData, err := ioutil.ReadFile(usersAPI.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rotateAuthKey(usersAPI.file, usersAPI.keystore); err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := usersAPI.keystore.GetAuthKey(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(previousKey, newKey) {
+		t.Fatal("Expected new auth key after rotation")
+	}
+	newData, err := ioutil.ReadFile(usersAPI.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(previousData, newData) {
+		t.Fatal("Expected auth file encrypted with new key")
+	}
+	if _, err := os.Stat(usersAPI.file + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("Expected removed temporary file, took %v", err)
+	}
+	users, err := listUsers(usersAPI.file, usersAPI.keystore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(users, []string{"user"}) {
+		t.Fatalf("Expected users [user], took %v", users)
+	}
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package main is entry point for AcraBackfill utility. AcraBackfill calculates hashes of searchable columns
+// for rows which were stored before searchable columns were configured for AcraServer. The utility selects
+// values of searchable columns described in encryptor config, decrypts AcraStructs, calculates hashes using
+// client's HMAC key and updates hash columns of each row by its id.
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"io/ioutil"
+	"os"
+
+	"github.com/cossacklabs/acra/cmd"
+	"github.com/cossacklabs/acra/encryptor"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/filesystem"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/utils"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+// Constants used by AcraBackfill
+var (
+	// DEFAULT_CONFIG_PATH relative path to config which will be parsed as default
+	DEFAULT_CONFIG_PATH = utils.GetConfigPathByName("acra-backfill")
+	SERVICE_NAME        = "acra-backfill"
+)
+
+// backfillColumn calculates hashes for all values of searchable column and updates hash column for each row
+func backfillColumn(db *sql.DB, dialect *sqlDialect, tableName, idColumn string, column *encryptor.SearchableColumn, clientID []byte, keystorage keystore.KeyStore) (int, error) {
+	selectQuery := dialect.SelectQuery(tableName, idColumn, column.Column)
+	updateQuery := dialect.UpdateQuery(tableName, column.HashColumn, idColumn)
+	updateStatement, err := db.Prepare(updateQuery)
+	if err != nil {
+		return 0, err
+	}
+	defer updateStatement.Close()
+
+	rows, err := db.Query(selectQuery)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var id interface{}
+	var value []byte
+	updated := 0
+	for i := 0; rows.Next(); i++ {
+		if err := rows.Scan(&id, &value); err != nil {
+			return updated, err
+		}
+		var hash sql.NullString
+		if value != nil {
+			hash.String, err = encryptor.CalculateSearchableHash(value, clientID, keystorage)
+			if err != nil {
+				log.WithError(err).Errorf("Can't calculate hash for row with number %v", i)
+				continue
+			}
+			hash.Valid = true
+		}
+		if _, err := updateStatement.Exec(hash, id); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, rows.Err()
+}
+
+func main() {
+	keysDir := flag.String("keys_dir", keystore.DefaultKeyDirShort, "Folder from which the keys will be loaded")
+	clientID := flag.String("client_id", "", "Client ID whose keys will be used to decrypt data and calculate hashes")
+	connectionString := flag.String("connection_string", "", "Connection string for db")
+	encryptorConfigPath := flag.String("encryptor_config_file", "", "Path to Encryptor configuration file with searchable columns")
+	tableName := flag.String("table", "", "Process only this table from Encryptor configuration (all tables by default)")
+	idColumn := flag.String("id_column", "id", "Column with unique row id used to update rows")
+	useMysql := flag.Bool("mysql_enable", false, "Handle MySQL connections")
+	usePostgresql := flag.Bool("postgresql_enable", false, "Handle Postgresql connections")
+
+	logging.SetLogLevel(logging.LOG_VERBOSE)
+
+	err := cmd.Parse(DEFAULT_CONFIG_PATH, SERVICE_NAME)
+	if err != nil {
+		log.WithError(err).Errorln("Can't parse args")
+		os.Exit(1)
+	}
+
+	twoDrivers := *useMysql && *usePostgresql
+	noDrivers := !(*useMysql || *usePostgresql)
+	if twoDrivers || noDrivers {
+		log.Errorln("You must pass only --mysql_enable or --postgresql_enable (one required)")
+		os.Exit(1)
+	}
+	dbDriverName := "postgres"
+	dialect := postgresqlDialect
+	if *useMysql {
+		dbDriverName = "mysql"
+		dialect = mysqlDialect
+	}
+
+	cmd.ValidateClientID(*clientID)
+
+	if *connectionString == "" {
+		log.Errorln("Connection_string arg is missing")
+		os.Exit(1)
+	}
+	if *encryptorConfigPath == "" {
+		log.Errorln("Encryptor_config_file arg is missing")
+		os.Exit(1)
+	}
+	configuration, err := ioutil.ReadFile(*encryptorConfigPath)
+	if err != nil {
+		log.WithError(err).Errorln("Can't read encryptor config")
+		os.Exit(1)
+	}
+	encryptorConfig, err := encryptor.LoadConfig(configuration)
+	if err != nil {
+		log.WithError(err).Errorln("Can't parse encryptor config")
+		os.Exit(1)
+	}
+	schemas := encryptorConfig.Schemas
+	if *tableName != "" {
+		schema := encryptorConfig.GetTableSchema(*tableName)
+		if schema == nil {
+			log.Errorf("Table %s isn't described in encryptor config", *tableName)
+			os.Exit(1)
+		}
+		schemas = []*encryptor.TableSchema{schema}
+	}
+
+	absKeysDir, err := utils.AbsPath(*keysDir)
+	if err != nil {
+		log.WithError(err).Errorln("Can't get absolute path for keys_dir")
+		os.Exit(1)
+	}
+	masterKey, err := keystore.GetMasterKeyFromEnvironment()
+	if err != nil {
+		log.WithError(err).Errorln("Can't load master key")
+		os.Exit(1)
+	}
+	scellEncryptor, err := keystore.NewSCellKeyEncryptor(masterKey)
+	if err != nil {
+		log.WithError(err).Errorln("Can't init scell encryptor")
+		os.Exit(1)
+	}
+	keystorage, err := filesystem.NewFilesystemKeyStore(absKeysDir, scellEncryptor)
+	if err != nil {
+		log.WithError(err).Errorln("Can't create key store")
+		os.Exit(1)
+	}
+
+	db, err := sql.Open(dbDriverName, *connectionString)
+	if err != nil {
+		log.WithError(err).Errorln("Can't connect to db")
+		os.Exit(1)
+	}
+	defer db.Close()
+	err = db.Ping()
+	if err != nil {
+		log.WithError(err).Errorln("Can't connect to db")
+		os.Exit(1)
+	}
+
+	for _, schema := range schemas {
+		for _, column := range schema.Searchable {
+			updated, err := backfillColumn(db, dialect, schema.TableName, *idColumn, column, []byte(*clientID), keystorage)
+			if err != nil {
+				log.WithError(err).Errorf("Can't backfill hashes of column %s.%s", schema.TableName, column.Column)
+				os.Exit(1)
+			}
+			log.Infof("Updated %v hashes of column %s.%s", updated, schema.TableName, column.Column)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// sqlDialect quotes identifiers and numbers placeholders in queries of AcraBackfill according to database, so names
+// of tables and columns from encryptor config can't change meaning of query
+type sqlDialect struct {
+	quote       string
+	placeholder func(index int) string
+}
+
+var (
+	postgresqlDialect = &sqlDialect{quote: `"`, placeholder: func(index int) string { return "$" + strconv.Itoa(index) }}
+	mysqlDialect      = &sqlDialect{quote: "`", placeholder: func(int) string { return "?" }}
+)
+
+// QuoteIdentifier quotes each part of qualified name like schema.table and escapes quotes inside of them by doubling
+func (dialect *sqlDialect) QuoteIdentifier(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = dialect.quote + strings.Replace(part, dialect.quote, dialect.quote+dialect.quote, -1) + dialect.quote
+	}
+	return strings.Join(parts, ".")
+}
+
+// SelectQuery returns query which selects values of columns from table
+func (dialect *sqlDialect) SelectQuery(table string, columns ...string) string {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = dialect.QuoteIdentifier(column)
+	}
+	return "SELECT " + strings.Join(quoted, ", ") + " FROM " + dialect.QuoteIdentifier(table)
+}
+
+// UpdateQuery returns query which sets value of column in row with id, value and id are passed as parameters
+func (dialect *sqlDialect) UpdateQuery(table, column, idColumn string) string {
+	return "UPDATE " + dialect.QuoteIdentifier(table) + " SET " + dialect.QuoteIdentifier(column) + "=" + dialect.placeholder(1) +
+		" WHERE " + dialect.QuoteIdentifier(idColumn) + "=" + dialect.placeholder(2)
+}
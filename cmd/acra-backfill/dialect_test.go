@@ -0,0 +1,53 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestDialectQueries(t *testing.T) {
+	testcases := []struct {
+		dialect     *sqlDialect
+		table       string
+		column      string
+		selectQuery string
+		updateQuery string
+	}{
+		{postgresqlDialect, "public.users", "email_hash",
+			`SELECT "id", "email_hash" FROM "public"."users"`,
+			`UPDATE "public"."users" SET "email_hash"=$1 WHERE "id"=$2`},
+		{mysqlDialect, "users", "email_hash",
+			"SELECT `id`, `email_hash` FROM `users`",
+			"UPDATE `users` SET `email_hash`=? WHERE `id`=?"},
+		// quotes inside of names can't close identifier
+		{postgresqlDialect, `users"; DROP TABLE users; --`, "email",
+			`SELECT "id", "email" FROM "users""; DROP TABLE users; --"`,
+			`UPDATE "users""; DROP TABLE users; --" SET "email"=$1 WHERE "id"=$2`},
+		{mysqlDialect, "users` WHERE 1=1; --", "email",
+			"SELECT `id`, `email` FROM `users`` WHERE 1=1; --`",
+			"UPDATE `users`` WHERE 1=1; --` SET `email`=? WHERE `id`=?"},
+	}
+	for i, testcase := range testcases {
+		if query := testcase.dialect.SelectQuery(testcase.table, "id", testcase.column); query != testcase.selectQuery {
+			t.Errorf("[%d] Expected %v, took %v", i, testcase.selectQuery, query)
+		}
+		if query := testcase.dialect.UpdateQuery(testcase.table, testcase.column, "id"); query != testcase.updateQuery {
+			t.Errorf("[%d] Expected %v, took %v", i, testcase.updateQuery, query)
+		}
+	}
+}
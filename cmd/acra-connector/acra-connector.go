@@ -171,8 +171,58 @@ type Config struct {
 	ConnectionWrapper        network.ConnectionWrapper
 }
 
+// loadKeyStore initializes keystore and checks that it contains transport keys of AcraConnector and its peer
+// openKeyStore returns keystore encrypted with master key from environment
+func openKeyStore(keysDir, clientID string, connectorMode connector_mode.ConnectorMode) *filesystem.ConnectorFileSystemKeyStore {
+	log.Infof("Initializing keystore...")
+	masterKey, err := keystore.GetMasterKeyFromEnvironment()
+	if err != nil {
+		log.WithError(err).Errorln("can't load master key")
+		os.Exit(1)
+	}
+	scellEncryptor, err := keystore.NewSCellKeyEncryptor(masterKey)
+	if err != nil {
+		log.WithError(err).Errorln("can't init scell encryptor")
+		os.Exit(1)
+	}
+	keyStore, err := filesystem.NewConnectorFileSystemKeyStore(keysDir, []byte(clientID), scellEncryptor, connectorMode)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantInitKeyStore).
+			Errorln("Can't initialize keystore")
+		os.Exit(1)
+	}
+	log.Infof("Keystore init OK")
+	return keyStore
+}
+
+func loadKeyStore(keysDir, clientID string, connectorMode connector_mode.ConnectorMode) *filesystem.ConnectorFileSystemKeyStore {
+	keyStore := openKeyStore(keysDir, clientID, connectorMode)
+
+	// --------- check keys -----------
+	cmd.ValidateClientID(clientID)
+
+	log.Infof("Reading transport keys...")
+
+	exists, err := keyStore.CheckIfPrivateKeyExists([]byte(clientID))
+	if !exists || err != nil {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorf("Configuration error: can't check that AcraConnector private key exists, got error - %v", err)
+		os.Exit(1)
+	}
+	log.Infof("Client id = %v, and client key is OK", clientID)
+
+	_, err = keyStore.GetPeerPublicKey([]byte(clientID))
+	if err != nil {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorf("Configuration error: can't check that %s public key exists, got error - %v", connectorMode, err)
+		os.Exit(1)
+	}
+	log.Infof("%v public key is OK", connectorMode)
+	return keyStore
+}
+
 func main() {
-	loggingFormat := flag.String("logging_format", "plaintext", "Logging format: plaintext, json or CEF")
+	loggingFormat := flag.String("logging_format", "plaintext", "Logging format: plaintext, json, CEF or GELF")
 	logging.CustomizeLogging(*loggingFormat, SERVICE_NAME)
 	log.Infof("Starting service %v", SERVICE_NAME)
 
@@ -194,10 +244,13 @@ func main() {
 	tlsAcraserverSNI := flag.String("tls_acraserver_sni", "", "Expected Server Name (SNI) from AcraServer")
 	tlsAuthType := flag.Int("tls_auth", int(tls.RequireAndVerifyClientCert), "Set authentication mode that will be used in TLS connection with AcraServer/AcraTranslator. Values in range 0-4 that set auth type (https://golang.org/pkg/crypto/tls/#ClientAuthType). Default is tls.RequireAndVerifyClientCert")
 	noEncryptionTransport := flag.Bool("acraserver_transport_encryption_disable", false, "Use raw transport (tcp/unix socket) between acraserver and acraproxy/client (don't use this flag if you not connect to database with ssl/tls")
+	secureComparatorEnable := flag.Bool("acraserver_secure_comparator_enable", false, "Authenticate to AcraServer with Themis Secure Comparator by secret shared with AcraServer (imported to keystore with 'acra-keys import --key_purpose comparator_secret') instead of Secure Session keys. Requires acraserver_tls_transport_enable")
 	connectionString := flag.String("incoming_connection_string", network.BuildConnectionString(cmd.DEFAULT_ACRACONNECTOR_CONNECTION_PROTOCOL, cmd.DEFAULT_ACRACONNECTOR_HOST, cmd.DEFAULT_ACRACONNECTOR_PORT, ""), "Connection string like tcp://x.x.x.x:yyyy or unix:///path/to/socket")
 	connectionAPIString := flag.String("incoming_connection_api_string", network.BuildConnectionString(cmd.DEFAULT_ACRACONNECTOR_CONNECTION_PROTOCOL, cmd.DEFAULT_ACRACONNECTOR_HOST, cmd.DEFAULT_ACRACONNECTOR_API_PORT, ""), "Connection string like tcp://x.x.x.x:yyyy or unix:///path/to/socket")
 	acraServerConnectionString := flag.String("acraserver_connection_string", "", "Connection string to AcraServer like tcp://x.x.x.x:yyyy or unix:///path/to/socket")
 	acraServerAPIConnectionString := flag.String("acraserver_api_connection_string", "", "Connection string to Acra's API like tcp://x.x.x.x:yyyy or unix:///path/to/socket")
+	ipFilterConfig := flag.String("incoming_connection_ip_filter_file", "", "Path to configuration file with IP addresses and CIDR networks allowed or denied to connect to AcraConnector")
+	ipFilterReloadInterval := flag.Int("incoming_connection_ip_filter_reload_interval", cmd.DEFAULT_IP_FILTER_RELOAD_INTERVAL, "Time (in seconds) between checks of incoming_connection_ip_filter_file for changes. 0 - don't reload")
 	prometheusAddress := flag.String("prometheus_metrics_address", "", "URL of Prometheus server for AcraConnector to upload stats and metrics (upload address is <URL>/metrics)")
 
 	connectorModeString := flag.String("mode", "AcraServer", "Expected mode of connection. Possible values are: AcraServer or AcraTranslator. Corresponded connection host/port/string/session_id will be used.")
@@ -208,6 +261,11 @@ func main() {
 
 	verbose := flag.Bool("v", false, "Log to stderr all INFO, WARNING and ERROR logs")
 	debug := flag.Bool("d", false, "Log everything to stderr")
+	logSampleEvery := flag.Int("log_sample_every", 1, "Log only every Nth debug or info event of same category (event code or message), 1 logs all events")
+	logSampleRateLimit := flag.Int("log_sample_rate_limit", 0, "Max count of debug or info events of same category logged per second, 0 is unlimited")
+	loggingRemoteAddress := flag.String("logging_remote_address", "", "Address like tcp://host:port or udp://host:port of Graylog (with GELF logging format) or Logstash (with json logging format) to send logs to in addition to stderr")
+	loggingRemoteBufferSize := flag.Int("logging_remote_buffer_size", logging.DefaultRemoteBufferSize, "Count of log messages buffered while remote log output is unavailable, newer messages are dropped")
+	loggingEventCodesMapFile := flag.String("logging_event_codes_map_file", "", "Path to YAML file which maps Acra event codes to custom event codes of SIEM taxonomy like '584: TLS-FAILURE'. Mapped codes are used as signature ID of CEF logs and added as vendor_code field to JSON and GELF logs")
 
 	err := cmd.Parse(DEFAULT_CONFIG_PATH, SERVICE_NAME)
 	if err != nil {
@@ -218,6 +276,21 @@ func main() {
 
 	// if log format was overridden
 	logging.CustomizeLogging(*loggingFormat, SERVICE_NAME)
+	logging.SetLogSampling(*logSampleEvery, *logSampleRateLimit)
+	if *loggingRemoteAddress != "" {
+		if _, err := logging.SetLogRemoteOutput(*loggingRemoteAddress, *loggingFormat, *loggingRemoteBufferSize); err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't configure remote log output")
+			os.Exit(1)
+		}
+	}
+	if *loggingEventCodesMapFile != "" {
+		if err := logging.LoadEventCodeMappingFromFile(*loggingEventCodesMapFile); err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't load mapping of event codes")
+			os.Exit(1)
+		}
+	}
 	log.Infof("Validating service configuration...")
 
 	if err := checkDependencies(); err != nil {
@@ -288,50 +361,28 @@ func main() {
 		log.Infof("Disabling user check, because OS is not Linux")
 	}
 
-	// --------- keystore  -----------
-	log.Infof("Initializing keystore...")
-	masterKey, err := keystore.GetMasterKeyFromEnvironment()
-	if err != nil {
-		log.WithError(err).Errorln("can't load master key")
-		os.Exit(1)
-	}
-	scellEncryptor, err := keystore.NewSCellKeyEncryptor(masterKey)
-	if err != nil {
-		log.WithError(err).Errorln("can't init scell encryptor")
-		os.Exit(1)
-	}
-	keyStore, err := filesystem.NewConnectorFileSystemKeyStore(*keysDir, []byte(*clientID), scellEncryptor, connectorMode)
-	if err != nil {
-		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantInitKeyStore).
-			Errorln("Can't initialize keystore")
-		os.Exit(1)
-	}
-	log.Infof("Keystore init OK")
-
-	// --------- check keys -----------
-	cmd.ValidateClientID(*clientID)
-
-	log.Infof("Reading transport keys...")
-
-	exists, err := keyStore.CheckIfPrivateKeyExists([]byte(*clientID))
-	if !exists || err != nil {
-		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
-			Errorf("Configuration error: can't check that AcraConnector private key exists, got error - %v", err)
-		os.Exit(1)
-	}
-	log.Infof("Client id = %v, and client key is OK", *clientID)
-
-	_, err = keyStore.GetPeerPublicKey([]byte(*clientID))
-	if err != nil {
-		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
-			Errorf("Configuration error: can't check that %s public key exists, got error - %v", connectorMode, err)
-		os.Exit(1)
+	useSecureComparator := connectorMode == connector_mode.AcraServerMode && *secureComparatorEnable
+	var keyStore *filesystem.ConnectorFileSystemKeyStore
+	if useSecureComparator {
+		cmd.ValidateClientID(*clientID)
+		keyStore = openKeyStore(*keysDir, *clientID, connectorMode)
+		log.Infof("Skip reading transport keys, AcraConnector authenticates with secure comparator secret")
+	} else {
+		keyStore = loadKeyStore(*keysDir, *clientID, connectorMode)
 	}
-	log.Infof("%v public key is OK", connectorMode)
 
 	// --------- Config  -----------
 	log.Infof("Configuring transport...")
 	config := &Config{KeyStore: keyStore, KeysDir: *keysDir, ClientID: []byte(*clientID), OutgoingConnectionString: outgoingConnectionString, IncomingConnectionString: *connectionString, OutgoingServiceID: []byte(outgoingSecureSessionID), DisableUserCheck: *disableUserCheck}
+	var ipFilter *network.IPFilter
+	if *ipFilterConfig != "" {
+		ipFilter, err = network.NewIPFilterFromFile(*ipFilterConfig, time.Duration(*ipFilterReloadInterval)*time.Second)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't load ip filter config")
+			os.Exit(1)
+		}
+	}
 	listener, err := network.Listen(*connectionString)
 	if err != nil {
 		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantStartListenConnections).
@@ -362,14 +413,42 @@ func main() {
 	}
 
 	if connectorMode == connector_mode.AcraServerMode {
+		var tlsConfig *tls.Config
 		if *useTLS {
-			log.Infof("Selecting transport: use TLS transport wrapper")
-			tlsConfig, err := network.NewTLSConfig(network.SNIOrHostname(*tlsAcraserverSNI, *acraServerHost), *tlsCA, *tlsKey, *tlsCert, tls.ClientAuthType(*tlsAuthType))
+			tlsConfig, err = network.NewTLSConfig(network.SNIOrHostname(*tlsAcraserverSNI, *acraServerHost), *tlsCA, *tlsKey, *tlsCert, tls.ClientAuthType(*tlsAuthType))
 			if err != nil {
 				log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTransportConfiguration).
 					Errorln("Configuration error: can't get config for TLS")
 				os.Exit(1)
 			}
+		}
+		if useSecureComparator {
+			if !*useTLS {
+				log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+					Errorln("Configuration error: secure comparator doesn't encrypt data, you must set <acraserver_tls_transport_enable> to use it")
+				os.Exit(1)
+			}
+			log.Infof("Selecting transport: use secure comparator authentication over TLS")
+			secret, err := keyStore.GetComparatorSecret([]byte(*clientID))
+			if err != nil {
+				log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantReadKeys).
+					Errorln("Can't read secure comparator secret from keystore")
+				os.Exit(1)
+			}
+			tlsWrapper, err := network.NewTLSConnectionWrapper(nil, tlsConfig)
+			if err != nil {
+				log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTransportConfiguration).
+					Errorln("Configuration error: can't initialize TLS connection wrapper")
+				os.Exit(1)
+			}
+			config.ConnectionWrapper, err = network.NewSecureComparatorClientWrapper(tlsWrapper, secret)
+			if err != nil {
+				log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTransportConfiguration).
+					Errorln("Configuration error: can't initialize secure comparator connection wrapper")
+				os.Exit(1)
+			}
+		} else if *useTLS {
+			log.Infof("Selecting transport: use TLS transport wrapper")
 			config.ConnectionWrapper, err = network.NewTLSConnectionWrapper(nil, tlsConfig)
 			if err != nil {
 				log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTransportConfiguration).
@@ -409,6 +488,9 @@ func main() {
 							Errorf("System error: can't accept new connection")
 						continue
 					}
+					if !ipFilter.AcceptConnection(connection) {
+						continue
+					}
 					connectionCounter.WithLabelValues(apiConnectionType).Inc()
 					// unix socket and value == '@'
 					if len(connection.RemoteAddr().String()) == 1 {
@@ -452,6 +534,9 @@ func main() {
 				Errorln("System error: сan't accept new connection")
 			os.Exit(1)
 		}
+		if !ipFilter.AcceptConnection(connection) {
+			continue
+		}
 		connectionCounter.WithLabelValues(dbConnectionType).Inc()
 		// unix socket and value == '@'
 		if len(connection.RemoteAddr().String()) == 1 {
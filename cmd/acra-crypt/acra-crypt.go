@@ -0,0 +1,185 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package main is entry point for AcraCrypt utility. AcraCrypt encrypts files into AcraStreams with storage public key
+// of client or zone public key and decrypts them with private keys from keystore. Files are encrypted and decrypted
+// as they are read, so files of any size are processed with constant memory, and files of directories are processed
+// by parallel workers, so batch ETL jobs may use Acra keys without embedding AcraWriter.
+//
+// https://github.com/cossacklabs/acra/wiki/Key-Management
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/cossacklabs/acra/cmd"
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/keystore"
+	// registers filesystem, redis and etcd keystore backends
+	_ "github.com/cossacklabs/acra/keystore/filesystem"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// Constants used by AcraCrypt
+var (
+	// DEFAULT_CONFIG_PATH relative path to config which will be parsed as default
+	DEFAULT_CONFIG_PATH = utils.GetConfigPathByName("acra-crypt")
+	SERVICE_NAME        = "acra-crypt"
+)
+
+// Commands of AcraCrypt
+const (
+	commandEncrypt = "encrypt"
+	commandDecrypt = "decrypt"
+)
+
+// stdio is value of input or output which means stdin or stdout
+const stdio = "-"
+
+// usage prints commands and flags
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %-12s %s\n", commandEncrypt, "Encrypt input file or files of input directory into AcraStreams")
+	fmt.Fprintf(os.Stderr, "  %-12s %s\n", commandDecrypt, "Decrypt AcraStreams from input file or files of input directory")
+	fmt.Fprintf(os.Stderr, "  %-12s %s\n\nFlags:\n", cmd.ConfigCommandName, "Generate, diff or validate configuration file")
+	flag.PrintDefaults()
+}
+
+func main() {
+	keysDir := flag.String("keys_dir", keystore.DefaultKeyDirShort, "Folder with private keys")
+	keysPublicDir := flag.String("keys_dir_public", "", "Folder with public keys, keys_dir is used if empty")
+	keystoreType := flag.String("keystore_type", keystore.DefaultBackendType, fmt.Sprintf("Type of keystore which stores keys, one of: %s", strings.Join(keystore.BackendTypes(), ", ")))
+	keystoreOptions := flag.String("keystore_options", "", "Comma separated options of keystore specific for keystore_type like 'address=127.0.0.1:6379,db=1'")
+	clientID := flag.String("client_id", "", "Client ID whose storage keys encrypt and decrypt data")
+	zoneID := flag.String("zone_id", "", "Zone ID whose keys encrypt and decrypt data instead of client's keys")
+	input := flag.String("input", stdio, "Path to file or directory with files to process, '-' reads stdin")
+	output := flag.String("output", stdio, "Path to output file or directory where processed files are written with the same relative paths, '-' writes to stdout")
+	chunkSize := flag.Int("chunk_size", base.DefaultStreamChunkSize, "Max size in bytes of data in one chunk of encrypted AcraStream")
+	workers := flag.Int("workers", 0, "Count of files of input directory processed in parallel, count of CPUs if 0")
+	masterKeyLoader := cmd.RegisterMasterKeyLoaderFlags()
+	hsmLoader := cmd.RegisterHSMFlags()
+	flag.Usage = usage
+
+	logging.SetLogLevel(logging.LOG_VERBOSE)
+
+	// command precedes flags, config command is handled by cmd.Parse
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		usage()
+		os.Exit(1)
+	}
+	commandName := os.Args[1]
+	if commandName != cmd.ConfigCommandName {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+	err := cmd.Parse(DEFAULT_CONFIG_PATH, SERVICE_NAME)
+	if err != nil {
+		log.WithError(err).Errorln("Can't parse args")
+		os.Exit(1)
+	}
+	if commandName != commandEncrypt && commandName != commandDecrypt {
+		log.Errorf("Unknown command %s", commandName)
+		usage()
+		os.Exit(1)
+	}
+	if (*clientID == "") == (*zoneID == "") {
+		log.Errorln("Pass one of client_id or zone_id")
+		os.Exit(1)
+	}
+	if *chunkSize <= 0 || *chunkSize > base.MaxStreamChunkSize {
+		log.Errorf("chunk_size should be positive and not greater than %d", base.MaxStreamChunkSize)
+		os.Exit(1)
+	}
+
+	keyEncryptor, err := hsmLoader.NewKeyEncryptor(masterKeyLoader.LoadMasterKey)
+	if err != nil {
+		log.WithError(err).Errorln("Can't init encryptor of keys")
+		os.Exit(1)
+	}
+	backendOptions, err := keystore.ParseBackendOptions(*keystoreOptions)
+	if err != nil {
+		log.WithError(err).Errorln("Can't parse keystore_options")
+		os.Exit(1)
+	}
+	keyStore, err := keystore.NewBackend(*keystoreType, keystore.BackendParams{
+		PrivateKeysDir: *keysDir,
+		PublicKeysDir:  *keysPublicDir,
+		Encryptor:      keyEncryptor,
+		CacheSize:      keystore.NO_CACHE,
+		Options:        backendOptions,
+	})
+	if err != nil {
+		log.WithError(err).Errorln("Can't initialise keystore")
+		os.Exit(1)
+	}
+
+	crypter := &crypter{keyStore: keyStore, id: []byte(*clientID), chunkSize: *chunkSize}
+	if *zoneID != "" {
+		crypter.id, crypter.zone = []byte(*zoneID), true
+	}
+	process := crypter.decrypt
+	if commandName == commandEncrypt {
+		if err := crypter.loadPublicKey(); err != nil {
+			log.WithError(err).Errorln("Can't load public key")
+			os.Exit(1)
+		}
+		process = crypter.encrypt
+	}
+
+	if *input == stdio || *output == stdio {
+		if err := processStdio(process, *input, *output); err != nil {
+			log.WithError(err).Errorf("Can't %s data", commandName)
+			os.Exit(1)
+		}
+		return
+	}
+	jobs, err := collectJobs(*input, *output)
+	if err != nil {
+		log.WithError(err).Errorln("Can't read input")
+		os.Exit(1)
+	}
+	if *workers <= 0 {
+		*workers = runtime.NumCPU()
+	}
+	processed, err := processFiles(process, jobs, *workers)
+	log.WithFields(log.Fields{"processed": processed, "total": len(jobs)}).Infof("Finished %s", commandName)
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// processStdio processes single input file or stdin and writes result to output file or stdout
+func processStdio(process func(io.Writer, io.Reader) error, inputPath, outputPath string) error {
+	var input io.Reader = os.Stdin
+	if inputPath != stdio {
+		file, err := os.Open(inputPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+	if outputPath != stdio {
+		return writeOutput(process, input, outputPath)
+	}
+	return process(os.Stdout, input)
+}
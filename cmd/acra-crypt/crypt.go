@@ -0,0 +1,242 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cossacklabs/acra/acra-writer"
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/utils"
+	"github.com/cossacklabs/themis/gothemis/keys"
+	log "github.com/sirupsen/logrus"
+)
+
+// Errors returned by AcraCrypt
+var (
+	ErrUnsupportedKeystore = errors.New("keystore can't read public keys")
+	ErrOutputInsideInput   = errors.New("output directory can't be inside of input directory")
+	ErrFilesFailed         = errors.New("some files weren't processed")
+)
+
+// crypter encrypts data into AcraStreams with public key of client or zone and decrypts them with its private keys
+type crypter struct {
+	keyStore keystore.KeyStore
+	// id is client id or zone id if zone is true
+	id        []byte
+	zone      bool
+	chunkSize int
+	// publicKey is loaded once before encryption of all files
+	publicKey *keys.PublicKey
+}
+
+// context returns context of AcraStreams, zone id for streams encrypted with zone
+func (crypter *crypter) context() []byte {
+	if crypter.zone {
+		return crypter.id
+	}
+	return nil
+}
+
+// loadPublicKey reads public key used for encryption from keystore which lists keys
+func (crypter *crypter) loadPublicKey() error {
+	lister, ok := crypter.keyStore.(keystore.KeyLister)
+	if !ok {
+		return ErrUnsupportedKeystore
+	}
+	purpose := keystore.KeyPurposeStorage
+	if crypter.zone {
+		purpose = keystore.KeyPurposeZone
+	}
+	keyList, err := lister.ListKeys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keyList {
+		if !key.Public || key.Purpose != purpose || key.ID != string(crypter.id) {
+			continue
+		}
+		publicKey, err := lister.GetPublicKeyByName(key.Name)
+		if err != nil {
+			return err
+		}
+		crypter.publicKey = &keys.PublicKey{Value: publicKey}
+		return nil
+	}
+	return &os.PathError{Op: "read", Path: fmt.Sprintf("public key %s of %s", purpose, crypter.id), Err: os.ErrNotExist}
+}
+
+// privateKeys returns current and previous private keys of client or zone which should be zeroed after usage
+func (crypter *crypter) privateKeys() ([]*keys.PrivateKey, error) {
+	if crypter.zone {
+		return crypter.keyStore.GetZonePrivateKeys(crypter.id)
+	}
+	return crypter.keyStore.GetServerDecryptionPrivateKeys(crypter.id)
+}
+
+// encrypt writes data read from input to output as AcraStream
+func (crypter *crypter) encrypt(output io.Writer, input io.Reader) error {
+	writer, err := acrawriter.NewStreamWriter(output, crypter.publicKey, crypter.context(), crypter.chunkSize)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writer, input); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// decrypt writes data of AcraStream read from input to output. Private keys are tried from current to oldest, so
+// streams encrypted before rotation of keys are decrypted too
+func (crypter *crypter) decrypt(output io.Writer, input io.Reader) error {
+	privateKeys, err := crypter.privateKeys()
+	if err != nil {
+		return err
+	}
+	decryptor, err := base.NewRotatedStreamDecryptor(input, privateKeys, crypter.context())
+	for _, privateKey := range privateKeys {
+		utils.FillSlice(byte(0), privateKey.Value)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(output, decryptor)
+	return err
+}
+
+// fileJob is file processed by one of workers
+type fileJob struct {
+	input  string
+	output string
+}
+
+// collectJobs returns files of input directory with paths of their outputs with the same relative paths in output
+// directory, or input file itself if input isn't directory
+func collectJobs(input, output string) ([]fileJob, error) {
+	info, err := os.Stat(input)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []fileJob{{input: input, output: output}}, nil
+	}
+	absInput, err := filepath.Abs(input)
+	if err != nil {
+		return nil, err
+	}
+	absOutput, err := filepath.Abs(output)
+	if err != nil {
+		return nil, err
+	}
+	if absOutput == absInput || strings.HasPrefix(absOutput, absInput+string(filepath.Separator)) {
+		return nil, ErrOutputInsideInput
+	}
+	var jobs []fileJob
+	err = filepath.Walk(input, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		relative, err := filepath.Rel(input, path)
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, fileJob{input: path, output: filepath.Join(output, relative)})
+		return nil
+	})
+	return jobs, err
+}
+
+// processFile processes input file of job into its output
+func processFile(process func(io.Writer, io.Reader) error, job fileJob) error {
+	input, err := os.Open(job.input)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	return writeOutput(process, input, job.output)
+}
+
+// writeOutput processes input to temporary file renamed to outputPath on success, so interrupted or failed job
+// doesn't leave partial output
+func writeOutput(process func(io.Writer, io.Reader) error, input io.Reader, outputPath string) error {
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0700); err != nil {
+		return err
+	}
+	output, err := ioutil.TempFile(filepath.Dir(outputPath), "."+filepath.Base(outputPath)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(output.Name())
+	err = process(output, input)
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(output.Name(), outputPath)
+}
+
+// processFiles processes jobs with count of parallel workers and returns count of processed files. Failed files are
+// logged and skipped, ErrFilesFailed returned if any file failed
+func processFiles(process func(io.Writer, io.Reader) error, jobs []fileJob, workers int) (int, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	jobsCh := make(chan fileJob)
+	var lock sync.Mutex
+	processed, failed := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobsCh {
+				err := processFile(process, job)
+				lock.Lock()
+				if err != nil {
+					failed++
+					log.WithError(err).WithField("file", job.input).Errorln("Can't process file")
+				} else {
+					processed++
+					log.WithFields(log.Fields{"file": job.input, "output": job.output}).Debugln("Processed file")
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	for _, job := range jobs {
+		jobsCh <- job
+	}
+	close(jobsCh)
+	wg.Wait()
+	if failed > 0 {
+		return processed, ErrFilesFailed
+	}
+	return processed, nil
+}
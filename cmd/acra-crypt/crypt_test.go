@@ -0,0 +1,219 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var errTestProcess = errors.New("test process error")
+
+// testProcess copies input in upper case and fails on input "fail" after writing part of output
+func testProcess(output io.Writer, input io.Reader) error {
+	data, err := ioutil.ReadAll(input)
+	if err != nil {
+		return err
+	}
+	output.Write(bytes.ToUpper(data))
+	if string(data) == "fail" {
+		return errTestProcess
+	}
+	return nil
+}
+
+func writeTestFiles(t *testing.T, directory string, files map[string]string) {
+	for name, data := range files {
+		path := filepath.Join(directory, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCollectJobs(t *testing.T) {
+	directory, err := ioutil.TempDir("", "acra_crypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	input := filepath.Join(directory, "input")
+	output := filepath.Join(directory, "output")
+	writeTestFiles(t, input, map[string]string{"a": "a", "b/c": "c", "b/d/e": "e"})
+	if err := os.Symlink(filepath.Join(input, "a"), filepath.Join(input, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := collectJobs(input, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].input < jobs[j].input })
+	// files keep relative paths in output, not regular files are skipped
+	expectedJobs := []fileJob{
+		{input: filepath.Join(input, "a"), output: filepath.Join(output, "a")},
+		{input: filepath.Join(input, "b/c"), output: filepath.Join(output, "b/c")},
+		{input: filepath.Join(input, "b/d/e"), output: filepath.Join(output, "b/d/e")},
+	}
+	if !reflect.DeepEqual(jobs, expectedJobs) {
+		t.Fatalf("Expected %v, took %v", expectedJobs, jobs)
+	}
+
+	jobs, err = collectJobs(filepath.Join(input, "a"), filepath.Join(output, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedJobs = []fileJob{{input: filepath.Join(input, "a"), output: filepath.Join(output, "a")}}
+	if !reflect.DeepEqual(jobs, expectedJobs) {
+		t.Fatalf("Expected %v, took %v", expectedJobs, jobs)
+	}
+
+	testcases := []struct {
+		Input  string
+		Output string
+	}{
+		{input, input},
+		{input, input + "/"},
+		{input, filepath.Join(input, "b")},
+		{input, filepath.Join(input, "output")},
+		{filepath.Join(directory, "input/b/.."), filepath.Join(input, "output")},
+	}
+	for i, tcase := range testcases {
+		if _, err := collectJobs(tcase.Input, tcase.Output); err != ErrOutputInsideInput {
+			t.Errorf("[%d] Expected %v, took %v", i, ErrOutputInsideInput, err)
+		}
+	}
+	// output directory with same prefix isn't inside of input
+	if _, err := collectJobs(input, input+"_output"); err != nil {
+		t.Fatalf("Expected output next to input, took %v", err)
+	}
+	if _, err := collectJobs(filepath.Join(directory, "unknown"), output); !os.IsNotExist(err) {
+		t.Fatalf("Expected not exist error, took %v", err)
+	}
+}
+
+func TestWriteOutput(t *testing.T) {
+	directory, err := ioutil.TempDir("", "acra_crypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	outputPath := filepath.Join(directory, "a/b/output")
+
+	if err := writeOutput(testProcess, bytes.NewReader([]byte("data")), outputPath); err != nil {
+		t.Fatal(err)
+	}
+	// existing output is replaced
+	if err := writeOutput(testProcess, bytes.NewReader([]byte("new data")), outputPath); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "NEW DATA" {
+		t.Fatalf("Expected NEW DATA, took %s", data)
+	}
+	// failed process keeps previous output and doesn't leave temporary file
+	if err := writeOutput(testProcess, bytes.NewReader([]byte("fail")), outputPath); err != errTestProcess {
+		t.Fatalf("Expected %v, took %v", errTestProcess, err)
+	}
+	data, err = ioutil.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "NEW DATA" {
+		t.Fatalf("Expected previous output, took %s", data)
+	}
+	files, err := ioutil.ReadDir(filepath.Dir(outputPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Expected only output file, took %v files", len(files))
+	}
+	// output path which is directory can't be replaced
+	if err := writeOutput(testProcess, bytes.NewReader([]byte("data")), filepath.Join(directory, "a")); err == nil {
+		t.Fatal("Expected error on output to directory")
+	}
+}
+
+func TestProcessFiles(t *testing.T) {
+	directory, err := ioutil.TempDir("", "acra_crypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	input := filepath.Join(directory, "input")
+	output := filepath.Join(directory, "output")
+	files := map[string]string{"1": "one", "2": "two", "dir/3": "three", "dir/4": "four"}
+	writeTestFiles(t, input, files)
+	jobs, err := collectJobs(input, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{0, 1, 3} {
+		os.RemoveAll(output)
+		count, err := processFiles(testProcess, jobs, workers)
+		if err != nil {
+			t.Fatalf("[%d] %v", workers, err)
+		}
+		if count != len(files) {
+			t.Fatalf("[%d] Expected %v processed files, took %v", workers, len(files), count)
+		}
+		for name, data := range files {
+			outputData, err := ioutil.ReadFile(filepath.Join(output, name))
+			if err != nil {
+				t.Fatalf("[%d] %v", workers, err)
+			}
+			if !bytes.Equal(outputData, bytes.ToUpper([]byte(data))) {
+				t.Fatalf("[%d] Unexpected output of %v: %s", workers, name, outputData)
+			}
+		}
+	}
+
+	// failed files are skipped, other files are processed
+	os.RemoveAll(output)
+	writeTestFiles(t, input, map[string]string{"dir/5": "fail"})
+	jobs, err = collectJobs(input, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs = append(jobs, fileJob{input: filepath.Join(input, "unknown"), output: filepath.Join(output, "unknown")})
+	count, err := processFiles(testProcess, jobs, 2)
+	if err != ErrFilesFailed {
+		t.Fatalf("Expected %v, took %v", ErrFilesFailed, err)
+	}
+	if count != len(files) {
+		t.Fatalf("Expected %v processed files, took %v", len(files), count)
+	}
+	for _, name := range []string{"dir/5", "unknown"} {
+		if _, err := os.Stat(filepath.Join(output, name)); !os.IsNotExist(err) {
+			t.Fatalf("Expected no output of failed file %v, took %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package main is entry point for AcraKeyEscrow utility. AcraKeyEscrow exports private keys from keystore for
+// regulatory escrow with split knowledge: key is divided into shares wrapped to public keys of custodians, and any
+// threshold of custodians can recover key back into keystore. Plaintext key is never written outside of keystore.
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/cossacklabs/acra/cmd"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/escrow"
+	"github.com/cossacklabs/acra/keystore/filesystem"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// Constants used by AcraKeyEscrow
+var (
+	// DEFAULT_CONFIG_PATH relative path to config which will be parsed as default
+	DEFAULT_CONFIG_PATH = utils.GetConfigPathByName("acra-keyescrow")
+	SERVICE_NAME        = "acra-keyescrow"
+)
+
+// ErrInvalidCustodianKeys returned if custodians' keys aren't passed as comma separated <name>:<path> pairs
+var ErrInvalidCustodianKeys = errors.New("custodians' keys must be comma separated list of <name>:<path to key>")
+
+// parseCustodianKeys returns paths to keys by custodians' names in order of value
+func parseCustodianKeys(value string) ([]string, map[string]string, error) {
+	var names []string
+	paths := make(map[string]string)
+	for _, item := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(item), ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, nil, ErrInvalidCustodianKeys
+		}
+		if _, ok := paths[parts[0]]; ok {
+			return nil, nil, escrow.ErrDuplicatedCustodian
+		}
+		names = append(names, parts[0])
+		paths[parts[0]] = parts[1]
+	}
+	return names, paths, nil
+}
+
+func exportKey(keyExporter keystore.KeyExporter, purpose, id, custodianKeys string, threshold int, escrowFile string) error {
+	names, paths, err := parseCustodianKeys(custodianKeys)
+	if err != nil {
+		return err
+	}
+	custodians := make([]escrow.Custodian, 0, len(names))
+	for _, name := range names {
+		publicKey, err := utils.LoadPublicKey(paths[name])
+		if err != nil {
+			log.WithError(err).Errorf("Can't load public key of custodian %s", name)
+			return err
+		}
+		custodians = append(custodians, escrow.Custodian{Name: name, PublicKey: publicKey})
+	}
+	key, err := keyExporter.ExportPrivateKey(purpose, []byte(id))
+	if err != nil {
+		log.WithError(err).Errorln("Can't read key from keystore")
+		return err
+	}
+	pkg, err := escrow.Export(purpose, []byte(id), key, custodians, threshold)
+	utils.FillSlice(byte(0), key)
+	if err != nil {
+		log.WithError(err).Errorln("Can't split key into shares")
+		return err
+	}
+	pkg.Environment = keystore.GetKeyEnvironment()
+	data, err := pkg.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(escrowFile, data, 0600); err != nil {
+		log.WithError(err).Errorln("Can't write escrow package")
+		return err
+	}
+	log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeKeyEscrowExport, "purpose": purpose, "id": id,
+		"custodians": names, "threshold": threshold, "key_fingerprint": pkg.KeyFingerprint}).Infoln("Key exported to escrow")
+	return nil
+}
+
+func recoverKey(keyExporter keystore.KeyExporter, custodianKeys, escrowFile string) error {
+	data, err := ioutil.ReadFile(escrowFile)
+	if err != nil {
+		log.WithError(err).Errorln("Can't read escrow package")
+		return err
+	}
+	pkg, err := escrow.Load(data)
+	if err != nil {
+		log.WithError(err).Errorln("Can't parse escrow package")
+		return err
+	}
+	if environment := keystore.GetKeyEnvironment(); pkg.Environment != environment {
+		log.WithError(escrow.ErrEnvironmentMismatch).Errorf("Key was exported from environment '%s' but keystore has environment '%s'", pkg.Environment, environment)
+		return escrow.ErrEnvironmentMismatch
+	}
+	names, paths, err := parseCustodianKeys(custodianKeys)
+	if err != nil {
+		return err
+	}
+	shares := make([][]byte, 0, len(names))
+	defer func() {
+		for _, share := range shares {
+			utils.FillSlice(byte(0), share)
+		}
+	}()
+	for _, name := range names {
+		privateKey, err := utils.LoadPrivateKey(paths[name])
+		if err != nil {
+			log.WithError(err).Errorf("Can't load private key of custodian %s", name)
+			return err
+		}
+		share, err := pkg.UnwrapShare(name, privateKey)
+		utils.FillSlice(byte(0), privateKey.Value)
+		if err != nil {
+			log.WithError(err).Errorf("Can't unwrap share of custodian %s", name)
+			return err
+		}
+		shares = append(shares, share)
+	}
+	key, err := pkg.Recover(shares)
+	if err != nil {
+		log.WithError(err).Errorln("Can't recover key from shares")
+		return err
+	}
+	err = keyExporter.ImportPrivateKey(pkg.Purpose, []byte(pkg.ID), key)
+	utils.FillSlice(byte(0), key)
+	if err != nil {
+		log.WithError(err).Errorln("Can't save recovered key to keystore")
+		return err
+	}
+	log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeKeyEscrowRecover, "purpose": pkg.Purpose, "id": pkg.ID,
+		"custodians": names, "key_fingerprint": pkg.KeyFingerprint}).Infoln("Key recovered from escrow")
+	return nil
+}
+
+func main() {
+	keysDir := flag.String("keys_dir", keystore.DefaultKeyDirShort, "Folder from which the keys will be loaded or where recovered key will be saved")
+	recoverMode := flag.Bool("recover", false, "Recover key from escrow package into keystore instead of export")
+	purpose := flag.String("key_purpose", keystore.KeyPurposeStorage, "Purpose of exported key: zone, storage, server_transport, translator_transport, connector_transport, hmac or poison")
+	id := flag.String("id", "", "Client ID or Zone ID of exported key")
+	custodianKeys := flag.String("custodians", "", "Comma separated list of <name>:<path> of custodians' public keys on export or private keys on recover")
+	threshold := flag.Int("threshold", 2, "Count of custodians required to recover exported key")
+	escrowFile := flag.String("escrow_file", "", "Path to escrow package written on export and read on recover")
+
+	logging.SetLogLevel(logging.LOG_VERBOSE)
+
+	err := cmd.Parse(DEFAULT_CONFIG_PATH, SERVICE_NAME)
+	if err != nil {
+		log.WithError(err).Errorln("Can't parse args")
+		os.Exit(1)
+	}
+	if *escrowFile == "" {
+		log.Errorln("Escrow_file arg is missing")
+		os.Exit(1)
+	}
+	if *custodianKeys == "" {
+		log.Errorln("Custodians arg is missing")
+		os.Exit(1)
+	}
+
+	absKeysDir, err := utils.AbsPath(*keysDir)
+	if err != nil {
+		log.WithError(err).Errorln("Can't get absolute path for keys_dir")
+		os.Exit(1)
+	}
+	masterKey, err := keystore.GetMasterKeyFromEnvironment()
+	if err != nil {
+		log.WithError(err).Errorln("Can't load master key")
+		os.Exit(1)
+	}
+	scellEncryptor, err := keystore.NewSCellKeyEncryptor(masterKey)
+	if err != nil {
+		log.WithError(err).Errorln("Can't init scell encryptor")
+		os.Exit(1)
+	}
+	keystorage, err := filesystem.NewFilesystemKeyStore(absKeysDir, scellEncryptor)
+	if err != nil {
+		log.WithError(err).Errorln("Can't create key store")
+		os.Exit(1)
+	}
+
+	if *recoverMode {
+		err = recoverKey(keystorage, *custodianKeys, *escrowFile)
+	} else {
+		err = exportKey(keystorage, *purpose, *id, *custodianKeys, *threshold, *escrowFile)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
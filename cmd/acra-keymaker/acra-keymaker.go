@@ -16,15 +16,17 @@ limitations under the License.
 
 // Package main is entry point for AcraKeymaker utility. AcraKeymaker generates key pairs for transport and storage keys
 // and writes it to default keys folder. Private keys are encrypted using Themis SecureCell and ACRA_MASTER_KEY,
-// public keys are plaintext.
+// public keys are plaintext. AcraKeymaker is deprecated in favor of AcraKeys which manages whole lifecycle of keys.
 //
 // https://github.com/cossacklabs/acra/wiki/Key-Management
 package main
 
 import (
 	"flag"
+	"fmt"
 	"github.com/cossacklabs/acra/cmd"
 	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/escrow"
 	"github.com/cossacklabs/acra/keystore/filesystem"
 	"github.com/cossacklabs/acra/logging"
 	"github.com/cossacklabs/acra/utils"
@@ -47,9 +49,15 @@ func main() {
 	acraTranslator := flag.Bool("generate_acratranslator_keys", false, "Create keypair for AcraTranslator only")
 	dataKeys := flag.Bool("generate_acrawriter_keys", false, "Create keypair for data encryption/decryption")
 	basicauth := flag.Bool("generate_acrawebconfig_keys", false, "Create symmetric key for AcraWebconfig's basic auth db")
+	hmacKey := flag.Bool("generate_hmac_key", false, "Create symmetric key for calculating hashes of searchable columns")
+	secureCellKey := flag.Bool("generate_symmetric_key", false, "Create symmetric key for Secure Cell encryption used by AcraTranslator's securecell endpoints")
 	outputDir := flag.String("keys_output_dir", keystore.DefaultKeyDirShort, "Folder where will be saved keys")
 	outputPublicKey := flag.String("keys_public_output_dir", keystore.DefaultKeyDirShort, "Folder where will be saved public key")
 	masterKey := flag.String("generate_master_key", "", "Generate new random master key and save to file")
+	splitMasterKey := flag.String("split_master_key", "", "Split master key from file written by generate_master_key into shares for shamir master_key_provider, each share is saved to <file>.share.<number>")
+	sharesCount := flag.Int("master_key_shares_count", 5, "Count of shares which split_master_key generates")
+	sharesThreshold := flag.Int("master_key_shares_threshold", 3, "Count of shares required to reconstruct master key split with split_master_key")
+	hsmLoader := cmd.RegisterHSMFlags()
 
 	logging.SetLogLevel(logging.LOG_VERBOSE)
 
@@ -59,6 +67,7 @@ func main() {
 		os.Exit(1)
 	}
 
+	log.Warningln("acra-keymaker is deprecated, use acra-keys generate to manage keys of all purposes")
 	cmd.ValidateClientID(*clientID)
 
 	if *masterKey != "" {
@@ -72,25 +81,44 @@ func main() {
 		os.Exit(0)
 	}
 
-	symmetricKey, err := keystore.GetMasterKeyFromEnvironment()
+	if *splitMasterKey != "" {
+		key, err := ioutil.ReadFile(*splitMasterKey)
+		if err != nil {
+			log.WithError(err).Errorln("Can't read master key")
+			os.Exit(1)
+		}
+		shares, err := escrow.SplitMasterKey(key, *sharesCount, *sharesThreshold)
+		utils.FillSlice(byte(0), key)
+		if err != nil {
+			log.WithError(err).Errorln("Can't split master key into shares")
+			os.Exit(1)
+		}
+		for i, share := range shares {
+			path := fmt.Sprintf("%s.share.%d", *splitMasterKey, i+1)
+			if err := ioutil.WriteFile(path, []byte(share+"\n"), 0600); err != nil {
+				log.WithError(err).Errorf("Can't write share to %s", path)
+				os.Exit(1)
+			}
+		}
+		log.Infof("Master key split into %d shares, %d of them are required to unseal master key. Hand shares to different operators and remove %s",
+			*sharesCount, *sharesThreshold, *splitMasterKey)
+		os.Exit(0)
+	}
+
+	keyEncryptor, err := hsmLoader.NewKeyEncryptor(keystore.GetMasterKeyFromEnvironment)
 	if err != nil {
 		if err == keystore.ErrEmptyMasterKey {
 			log.Infof("You must pass master key via %v environment variable", keystore.AcraMasterKeyVarName)
 			os.Exit(1)
 		}
-		log.WithError(err).Errorln("Can't load master key")
-		os.Exit(1)
-	}
-	scellEncryptor, err := keystore.NewSCellKeyEncryptor(symmetricKey)
-	if err != nil {
-		log.WithError(err).Errorln("Can't init scell encryptor")
+		log.WithError(err).Errorln("Can't init encryptor of keys")
 		os.Exit(1)
 	}
 	var store keystore.KeyStore
 	if *outputPublicKey != *outputDir {
-		store, err = filesystem.NewFilesystemKeyStoreTwoPath(*outputDir, *outputPublicKey, scellEncryptor)
+		store, err = filesystem.NewFilesystemKeyStoreTwoPath(*outputDir, *outputPublicKey, keyEncryptor)
 	} else {
-		store, err = filesystem.NewFilesystemKeyStore(*outputDir, scellEncryptor)
+		store, err = filesystem.NewFilesystemKeyStore(*outputDir, keyEncryptor)
 	}
 	if err != nil {
 		panic(err)
@@ -121,6 +149,16 @@ func main() {
 		if err != nil {
 			panic(err)
 		}
+	} else if *hmacKey {
+		err = store.GenerateHMACSecretKey([]byte(*clientID))
+		if err != nil {
+			panic(err)
+		}
+	} else if *secureCellKey {
+		err = store.(keystore.SymmetricKeyStore).GenerateSymmetricKey([]byte(*clientID))
+		if err != nil {
+			panic(err)
+		}
 	} else {
 		err = store.GenerateConnectorKeys([]byte(*clientID))
 		if err != nil {
@@ -0,0 +1,133 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package main is entry point for AcraKeys utility. AcraKeys manages lifecycle of keys in keystore with subcommands:
+// generate, list, destroy, export, import and read-public, so operators can script key management of all purposes with
+// one tool instead of separate flags of AcraKeymaker. To rotate master key operators set new key to ACRA_MASTER_KEY and
+// old one to ACRA_PREVIOUS_MASTER_KEY, services rewrap keys on access and rewrap command finishes migration. With IDs of
+// master keys in ACRA_MASTER_KEY_ID and ACRA_MASTER_KEYS rotation is gradual: rewrap command with rewrap_limit migrates
+// keys in batches and master-keys command shows when old master key isn't used anymore.
+//
+// https://github.com/cossacklabs/acra/wiki/Key-Management
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cossacklabs/acra/cmd"
+	"github.com/cossacklabs/acra/keystore"
+	// registers filesystem, redis and etcd keystore backends
+	_ "github.com/cossacklabs/acra/keystore/filesystem"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// Constants used by AcraKeys
+var (
+	// DEFAULT_CONFIG_PATH relative path to config which will be parsed as default
+	DEFAULT_CONFIG_PATH = utils.GetConfigPathByName("acra-keys")
+	SERVICE_NAME        = "acra-keys"
+)
+
+// usage prints subcommands and flags
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, command := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", command.name, command.description)
+	}
+	fmt.Fprintf(os.Stderr, "  %-12s %s\n\nFlags:\n", cmd.ConfigCommandName, "Generate, diff or validate configuration file")
+	flag.PrintDefaults()
+}
+
+func main() {
+	keysDir := flag.String("keys_dir", keystore.DefaultKeyDirShort, "Folder with private keys")
+	keysPublicDir := flag.String("keys_dir_public", "", "Folder with public keys, keys_dir is used if empty")
+	keystoreType := flag.String("keystore_type", keystore.DefaultBackendType, fmt.Sprintf("Type of keystore which stores keys, one of: %s", strings.Join(keystore.BackendTypes(), ", ")))
+	keystoreOptions := flag.String("keystore_options", "", "Comma separated options of keystore specific for keystore_type like 'address=127.0.0.1:6379,db=1'")
+	purpose := flag.String("key_purpose", keystore.KeyPurposeStorage, fmt.Sprintf("Purpose of key, one of: %s", strings.Join(keyPurposes, ", ")))
+	id := flag.String("id", "", "Client ID or Zone ID of key, new zone is generated by generate command for zone purpose")
+	keyFile := flag.String("key_file", "", "Path to file with plaintext key written by export and read by import, read-public writes public key to stdout if empty")
+	revocationList := flag.String("revocation_list_file", "", "Path to revocation list of client and zone ids updated by revoke and unrevoke commands")
+	revocationSigningKey := flag.String("revocation_signing_key", "", "Path to private key which signs revocation_list_file, generated with public key in file with .pub suffix if doesn't exist")
+	jsonOutput := flag.Bool("json", false, "Print output of list command in JSON")
+	rewrapLimit := flag.Int("rewrap_limit", 0, "Max count of keys rewrapped by rewrap command, so huge keystore is rewrapped in batches, all keys are rewrapped if 0")
+	ttlDays := flag.Int("ttl_days", 0, "Lifetime of key in days since its creation set by set-ttl command or after generate command, key doesn't expire if 0")
+	masterKeyLoader := cmd.RegisterMasterKeyLoaderFlags()
+	hsmLoader := cmd.RegisterHSMFlags()
+	zoneIDGeneratorLoader := cmd.RegisterZoneIDGeneratorFlags()
+	flag.Usage = usage
+
+	logging.SetLogLevel(logging.LOG_VERBOSE)
+
+	// subcommand precedes flags, config command is handled by cmd.Parse
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		usage()
+		os.Exit(1)
+	}
+	commandName := os.Args[1]
+	if commandName != cmd.ConfigCommandName {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+	err := cmd.Parse(DEFAULT_CONFIG_PATH, SERVICE_NAME)
+	if err != nil {
+		log.WithError(err).Errorln("Can't parse args")
+		os.Exit(1)
+	}
+	command, ok := findCommand(commandName)
+	if !ok {
+		log.Errorf("Unknown command %s", commandName)
+		usage()
+		os.Exit(1)
+	}
+	if err := zoneIDGeneratorLoader.SetIDGenerator(); err != nil {
+		log.WithError(err).Errorln("Can't set format of zone ids")
+		os.Exit(1)
+	}
+
+	keyEncryptor, err := hsmLoader.NewKeyEncryptor(masterKeyLoader.LoadMasterKey)
+	if err != nil {
+		log.WithError(err).Errorln("Can't init encryptor of keys")
+		os.Exit(1)
+	}
+	backendOptions, err := keystore.ParseBackendOptions(*keystoreOptions)
+	if err != nil {
+		log.WithError(err).Errorln("Can't parse keystore_options")
+		os.Exit(1)
+	}
+	keyStore, err := keystore.NewBackend(*keystoreType, keystore.BackendParams{
+		PrivateKeysDir: *keysDir,
+		PublicKeysDir:  *keysPublicDir,
+		Encryptor:      keyEncryptor,
+		CacheSize:      keystore.NO_CACHE,
+		Options:        backendOptions,
+	})
+	if err != nil {
+		log.WithError(err).Errorln("Can't initialise keystore")
+		os.Exit(1)
+	}
+
+	params := commandParams{keyStore: keyStore, purpose: *purpose, id: []byte(*id), keyFile: *keyFile, json: *jsonOutput, output: os.Stdout,
+		revocationList: *revocationList, revocationSigningKey: *revocationSigningKey, ttlDays: *ttlDays, rewrapLimit: *rewrapLimit}
+	if err := command.run(params); err != nil {
+		log.WithError(err).WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeErrorCantManageKeys, "command": command.name}).
+			Errorln("Can't execute command")
+		os.Exit(1)
+	}
+}
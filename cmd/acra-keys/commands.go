@@ -0,0 +1,359 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cossacklabs/acra/api"
+	"github.com/cossacklabs/acra/cmd"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/utils"
+	"github.com/cossacklabs/acra/zone"
+	"github.com/cossacklabs/themis/gothemis/keys"
+	log "github.com/sirupsen/logrus"
+)
+
+// keyPurposes are purposes of keys accepted by key_purpose flag
+var keyPurposes = []string{keystore.KeyPurposeStorage, keystore.KeyPurposeZone, keystore.KeyPurposeServerTransport,
+	keystore.KeyPurposeTranslatorTransport, keystore.KeyPurposeConnectorTransport, keystore.KeyPurposeHMAC,
+	keystore.KeyPurposeSymmetric, keystore.KeyPurposePoison, keystore.KeyPurposeAuth, keystore.KeyPurposeComparatorSecret}
+
+// Errors returned by commands
+var (
+	ErrUnsupportedKeystore = errors.New("keystore doesn't support command")
+	ErrKeyFileRequired     = errors.New("key_file is required")
+	ErrInvalidRewrapLimit  = errors.New("rewrap_limit can't be negative")
+)
+
+// commandParams are arguments of commands parsed from flags
+type commandParams struct {
+	keyStore keystore.KeyStore
+	purpose  string
+	id       []byte
+	keyFile  string
+	json     bool
+	output   io.Writer
+	// ttlDays is lifetime of generated key or key updated by set-ttl command, 0 means key doesn't expire
+	ttlDays int
+	// revocationList and revocationSigningKey are paths to signed revocation list and private key which signs it
+	revocationList       string
+	revocationSigningKey string
+	// rewrapLimit is max count of keys rewrapped by rewrap command, 0 means all keys
+	rewrapLimit int
+}
+
+// command is subcommand of AcraKeys
+type command struct {
+	name        string
+	description string
+	run         func(params commandParams) error
+}
+
+var commands = []command{
+	{"generate", "Generate new key of key_purpose for id, replaced key is kept as previous version", generateKey},
+	{"list", "List keys stored in keystore", listKeys},
+	{"set-ttl", "Set lifetime of key of key_purpose for id to ttl_days since its creation, 0 removes expiration", setKeyTTL},
+	{"destroy", "Remove key of key_purpose for id with its previous versions and public key", destroyKey},
+	{"export", "Write plaintext private or symmetric key of key_purpose for id to key_file", exportKey},
+	{"import", "Save plaintext private or symmetric key from key_file as key of key_purpose for id", importKey},
+	{"read-public", "Write public key of key_purpose for id to key_file or stdout", readPublicKey},
+	{"revoke", "Add client id or zone id (zone key_purpose) to signed revocation_list_file, so AcraServer refuses to use its keys", revokeID},
+	{"unrevoke", "Remove client id or zone id (zone key_purpose) from signed revocation_list_file", unrevokeID},
+	{"rewrap", "Encrypt keys encrypted with previous master key from ACRA_PREVIOUS_MASTER_KEY or ACRA_MASTER_KEYS with current master key, at most rewrap_limit keys if set", rewrapKeys},
+	{"master-keys", "Count stored keys by ID of master key which encrypted them", countKeysByMasterKey},
+}
+
+// findCommand returns command by name
+func findCommand(name string) (command, bool) {
+	for _, command := range commands {
+		if command.name == name {
+			return command, true
+		}
+	}
+	return command{}, false
+}
+
+// generateKey generates key with purpose, id of new zone and its public key are printed in JSON like AcraAddZone does
+func generateKey(params commandParams) error {
+	store := params.keyStore
+	switch params.purpose {
+	case keystore.KeyPurposeZone:
+		id, publicKey, err := store.GenerateZoneKey()
+		if err != nil {
+			return err
+		}
+		data, err := zone.ZoneDataToJSON(id, &keys.PublicKey{Value: publicKey})
+		if err != nil {
+			return err
+		}
+		if params.ttlDays > 0 {
+			params.id = id
+			if err := setKeyTTL(params); err != nil {
+				return err
+			}
+		}
+		_, err = fmt.Fprintln(params.output, string(data))
+		return err
+	case keystore.KeyPurposePoison:
+		_, err := store.GetPoisonKeyPair()
+		return err
+	case keystore.KeyPurposeAuth:
+		_, err := store.GetAuthKey(true)
+		return err
+	}
+	if !keystore.ValidateID(params.id) {
+		return keystore.ErrInvalidClientID
+	}
+	var err error
+	switch params.purpose {
+	case keystore.KeyPurposeStorage:
+		err = store.GenerateDataEncryptionKeys(params.id)
+	case keystore.KeyPurposeServerTransport:
+		err = store.GenerateServerKeys(params.id)
+	case keystore.KeyPurposeTranslatorTransport:
+		err = store.GenerateTranslatorKeys(params.id)
+	case keystore.KeyPurposeConnectorTransport:
+		err = store.GenerateConnectorKeys(params.id)
+	case keystore.KeyPurposeHMAC:
+		err = store.GenerateHMACSecretKey(params.id)
+	case keystore.KeyPurposeSymmetric:
+		symmetricKeyStore, ok := store.(keystore.SymmetricKeyStore)
+		if !ok {
+			return ErrUnsupportedKeystore
+		}
+		err = symmetricKeyStore.GenerateSymmetricKey(params.id)
+	case keystore.KeyPurposeComparatorSecret:
+		comparatorKeyStore, ok := store.(keystore.ComparatorSecretKeyStore)
+		if !ok {
+			return ErrUnsupportedKeystore
+		}
+		err = comparatorKeyStore.GenerateComparatorSecret(params.id)
+	default:
+		return keystore.ErrUnsupportedKeyPurpose
+	}
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"purpose": params.purpose, "id": string(params.id)}).Infoln("Generated key")
+	if params.ttlDays > 0 {
+		return setKeyTTL(params)
+	}
+	return nil
+}
+
+// setKeyTTL stores lifetime of key with purpose, AcraServer warns about key and may refuse to encrypt with it after
+// expiration
+func setKeyTTL(params commandParams) error {
+	setter, ok := params.keyStore.(keystore.KeyTTLSetter)
+	if !ok {
+		return ErrUnsupportedKeystore
+	}
+	if params.ttlDays < 0 {
+		return keystore.ErrInvalidKeyTTL
+	}
+	if err := setter.SetKeyTTL(params.purpose, params.id, time.Duration(params.ttlDays)*24*time.Hour); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"purpose": params.purpose, "id": string(params.id), "ttl_days": params.ttlDays}).Infoln("Set key lifetime")
+	return nil
+}
+
+// rewrapKeys encrypts all keys with current master key, so previous master key may be removed after rotation
+func rewrapKeys(params commandParams) error {
+	if params.rewrapLimit < 0 {
+		return ErrInvalidRewrapLimit
+	}
+	var rewrapped int
+	var err error
+	if params.rewrapLimit > 0 {
+		rewrapper, ok := params.keyStore.(keystore.LimitedMasterKeyRewrapper)
+		if !ok {
+			return ErrUnsupportedKeystore
+		}
+		rewrapped, err = rewrapper.RewrapKeysLimit(params.rewrapLimit)
+	} else {
+		rewrapper, ok := params.keyStore.(keystore.MasterKeyRewrapper)
+		if !ok {
+			return ErrUnsupportedKeystore
+		}
+		rewrapped, err = rewrapper.RewrapKeys()
+	}
+	if err != nil {
+		return err
+	}
+	log.WithField("count", rewrapped).Infoln("Rewrapped keys with current master key")
+	return nil
+}
+
+// masterKeyUsage is count of stored keys encrypted with master key printed by master-keys command
+type masterKeyUsage struct {
+	ID   string `json:"id"`
+	Keys int    `json:"keys"`
+}
+
+// countKeysByMasterKey prints table or JSON with count of keys encrypted with every master key, so operators see when
+// old master key may be removed after rotation. Keys stored without ID of master key are printed with empty ID
+func countKeysByMasterKey(params commandParams) error {
+	counter, ok := params.keyStore.(keystore.MasterKeyUsageCounter)
+	if !ok {
+		return ErrUnsupportedKeystore
+	}
+	counts, err := counter.CountKeysByMasterKey()
+	if err != nil {
+		return err
+	}
+	usage := make([]masterKeyUsage, 0, len(counts))
+	for id, count := range counts {
+		usage = append(usage, masterKeyUsage{ID: id, Keys: count})
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].ID < usage[j].ID })
+	if params.json {
+		encoder := json.NewEncoder(params.output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(usage)
+	}
+	writer := tabwriter.NewWriter(params.output, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "MASTER KEY ID\tKEYS")
+	for _, item := range usage {
+		id := item.ID
+		if id == keystore.LegacyMasterKeyID {
+			id = "-"
+		}
+		fmt.Fprintf(writer, "%s\t%d\n", id, item.Keys)
+	}
+	return writer.Flush()
+}
+
+// listKeys prints table or JSON of stored keys ordered by name
+func listKeys(params commandParams) error {
+	lister, ok := params.keyStore.(keystore.KeyLister)
+	if !ok {
+		return ErrUnsupportedKeystore
+	}
+	keyList, err := lister.ListKeys()
+	if err != nil {
+		return err
+	}
+	if params.json {
+		result := make([]api.Key, 0, len(keyList))
+		for _, key := range keyList {
+			result = append(result, cmd.NewAPIKey(key))
+		}
+		encoder := json.NewEncoder(params.output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+	writer := tabwriter.NewWriter(params.output, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "NAME\tPURPOSE\tID\tPUBLIC\tMODIFIED\tEXPIRES\tFINGERPRINT")
+	for _, key := range keyList {
+		expires := "-"
+		if !key.ExpiresAt.IsZero() {
+			expires = key.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%t\t%s\t%s\t%s\n", key.Name, key.Purpose, key.ID, key.Public,
+			key.ModifiedAt.UTC().Format(time.RFC3339), expires, key.Fingerprint)
+	}
+	return writer.Flush()
+}
+
+// destroyKey removes key with purpose, its previous versions and public key
+func destroyKey(params commandParams) error {
+	destroyer, ok := params.keyStore.(keystore.KeyDestroyer)
+	if !ok {
+		return ErrUnsupportedKeystore
+	}
+	return destroyer.DestroyKey(params.purpose, params.id)
+}
+
+// exportKey writes plaintext key to new key_file readable only by owner
+func exportKey(params commandParams) error {
+	exporter, ok := params.keyStore.(keystore.KeyExporter)
+	if !ok {
+		return ErrUnsupportedKeystore
+	}
+	if params.keyFile == "" {
+		return ErrKeyFileRequired
+	}
+	key, err := exporter.ExportPrivateKey(params.purpose, params.id)
+	if err != nil {
+		return err
+	}
+	defer utils.FillSlice(byte(0), key)
+	// don't overwrite existing files with plaintext keys
+	file, err := os.OpenFile(params.keyFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(key)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// importKey saves plaintext key from key_file into keystore overwriting existing key
+func importKey(params commandParams) error {
+	exporter, ok := params.keyStore.(keystore.KeyExporter)
+	if !ok {
+		return ErrUnsupportedKeystore
+	}
+	if params.keyFile == "" {
+		return ErrKeyFileRequired
+	}
+	key, err := ioutil.ReadFile(params.keyFile)
+	if err != nil {
+		return err
+	}
+	defer utils.FillSlice(byte(0), key)
+	return exporter.ImportPrivateKey(params.purpose, params.id, key)
+}
+
+// readPublicKey writes public key with purpose to key_file or output
+func readPublicKey(params commandParams) error {
+	lister, ok := params.keyStore.(keystore.KeyLister)
+	if !ok {
+		return ErrUnsupportedKeystore
+	}
+	keyList, err := lister.ListKeys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keyList {
+		if !key.Public || key.Purpose != params.purpose || key.ID != string(params.id) {
+			continue
+		}
+		publicKey, err := lister.GetPublicKeyByName(key.Name)
+		if err != nil {
+			return err
+		}
+		if params.keyFile != "" {
+			return ioutil.WriteFile(params.keyFile, publicKey, 0644)
+		}
+		_, err = params.output.Write(publicKey)
+		return err
+	}
+	return &os.PathError{Op: "read", Path: fmt.Sprintf("public key %s of %s", params.purpose, params.id), Err: os.ErrNotExist}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/utils"
+	"github.com/cossacklabs/themis/gothemis/keys"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrRevocationFilesRequired returned if revocation commands are called without revocation list or signing key
+var ErrRevocationFilesRequired = errors.New("revocation_list_file and revocation_signing_key are required")
+
+// loadRevocationSigningKey reads key pair which signs revocation list or generates it if private key doesn't exist.
+// Public key is stored next to private key with .pub suffix
+func loadRevocationSigningKey(path string) (*keys.Keypair, error) {
+	publicPath := path + ".pub"
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		keypair, err := keys.New(keys.KEYTYPE_EC)
+		if err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(path, keypair.Private.Value, 0600); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(publicPath, keypair.Public.Value, 0644); err != nil {
+			return nil, err
+		}
+		log.WithField("public_key", publicPath).Infoln("Generated key pair to sign revocation list, pass public key to AcraServer")
+		return keypair, nil
+	}
+	privateKey, err := utils.LoadPrivateKey(path)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := utils.LoadPublicKey(publicPath)
+	if err != nil {
+		return nil, err
+	}
+	return &keys.Keypair{Private: privateKey, Public: publicKey}, nil
+}
+
+// updateRevocationList verifies current revocation list, applies update to its ids of key purpose and writes it
+// signed again. Missing list file is created
+func updateRevocationList(params commandParams, update func(ids []string, id string) []string) error {
+	if params.revocationList == "" || params.revocationSigningKey == "" {
+		return ErrRevocationFilesRequired
+	}
+	if !keystore.ValidateID(params.id) {
+		return keystore.ErrInvalidClientID
+	}
+	keypair, err := loadRevocationSigningKey(params.revocationSigningKey)
+	if err != nil {
+		return err
+	}
+	config := &keystore.RevocationConfig{}
+	signed, err := ioutil.ReadFile(params.revocationList)
+	if err == nil {
+		config, err = keystore.VerifyRevocationConfig(signed, keypair.Public)
+		if err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if params.purpose == keystore.KeyPurposeZone {
+		config.Zones = update(config.Zones, string(params.id))
+	} else {
+		config.Clients = update(config.Clients, string(params.id))
+	}
+	signed, err = keystore.SignRevocationConfig(config, keypair.Private)
+	if err != nil {
+		return err
+	}
+	// AcraServer reloads file at any moment, so it's replaced atomically
+	temporaryFile, err := ioutil.TempFile(filepath.Dir(params.revocationList), filepath.Base(params.revocationList))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporaryFile.Name())
+	_, err = temporaryFile.Write(signed)
+	if closeErr := temporaryFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temporaryFile.Name(), 0644)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporaryFile.Name(), params.revocationList)
+}
+
+// revokeID adds client id or zone id (zone key purpose) to revocation list
+func revokeID(params commandParams) error {
+	err := updateRevocationList(params, func(ids []string, id string) []string {
+		for _, revoked := range ids {
+			if revoked == id {
+				return ids
+			}
+		}
+		return append(ids, id)
+	})
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeKeyRevoked, "id": string(params.id)}).Infoln("Revoked id")
+	return nil
+}
+
+// unrevokeID removes client id or zone id (zone key purpose) from revocation list
+func unrevokeID(params commandParams) error {
+	err := updateRevocationList(params, func(ids []string, id string) []string {
+		kept := ids[:0]
+		for _, revoked := range ids {
+			if revoked != id {
+				kept = append(kept, revoked)
+			}
+		}
+		return kept
+	})
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeKeyRevoked, "id": string(params.id)}).Infoln("Removed id from revocation list")
+	return nil
+}
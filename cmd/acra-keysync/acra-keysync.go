@@ -0,0 +1,150 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package main is entry point for AcraKeySync utility. AcraKeySync replicates keys between keystores of different
+// regions: on primary it exports signed log of keys changed since given time, on replica it verifies the log with
+// shared master key and atomically writes new and rotated keys. Private keys stay encrypted with master key in the
+// log, and invalid or tampered log doesn't change replica's keystore.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/cossacklabs/acra/cmd"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/filesystem"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// Constants used by AcraKeySync
+var (
+	// DEFAULT_CONFIG_PATH relative path to config which will be parsed as default
+	DEFAULT_CONFIG_PATH = utils.GetConfigPathByName("acra-keysync")
+	SERVICE_NAME        = "acra-keysync"
+)
+
+func exportLog(keyStore *filesystem.FilesystemKeyStore, since, logFile string) error {
+	var sinceTime time.Time
+	if since != "" {
+		var err error
+		sinceTime, err = time.Parse(time.RFC3339, since)
+		if err != nil {
+			log.WithError(err).Errorln("Can't parse since, expected time in RFC3339 format like 2006-01-02T15:04:05Z")
+			return err
+		}
+	}
+	replicationLog, err := keyStore.ExportReplicationLog(sinceTime)
+	if err != nil {
+		log.WithError(err).Errorln("Can't export replication log")
+		return err
+	}
+	data, err := json.Marshal(replicationLog)
+	if err != nil {
+		log.WithError(err).Errorln("Can't encode replication log")
+		return err
+	}
+	if err := ioutil.WriteFile(logFile, data, 0600); err != nil {
+		log.WithError(err).Errorln("Can't write replication log")
+		return err
+	}
+	log.WithFields(log.Fields{"keys": len(replicationLog.Changes), "created_at": replicationLog.CreatedAt.Format(time.RFC3339)}).
+		Infoln("Replication log exported, pass created_at as since of next export")
+	return nil
+}
+
+func applyLog(keyStore *filesystem.FilesystemKeyStore, logFile string) error {
+	data, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		log.WithError(err).Errorln("Can't read replication log")
+		return err
+	}
+	replicationLog := &filesystem.ReplicationLog{}
+	if err := json.Unmarshal(data, replicationLog); err != nil {
+		log.WithError(err).Errorln("Can't parse replication log")
+		return err
+	}
+	result, err := keyStore.ApplyReplicationLog(replicationLog)
+	if err != nil {
+		log.WithError(err).Errorln("Can't apply replication log")
+		return err
+	}
+	log.WithFields(log.Fields{"applied": result.Applied, "skipped": len(result.Skipped)}).Infoln("Replication log applied")
+	return nil
+}
+
+func main() {
+	keysDir := flag.String("keys_dir", keystore.DefaultKeyDirShort, "Folder with private keys of keystore")
+	keysPublicDir := flag.String("keys_dir_public", "", "Folder with public keys of keystore (keys_dir by default)")
+	applyMode := flag.Bool("apply", false, "Apply replication log to replica's keystore instead of export from primary")
+	logFile := flag.String("replication_log_file", "", "Path to replication log written on export and read on apply")
+	since := flag.String("since", "", "Export only keys changed after time in RFC3339 format (all keys by default)")
+
+	logging.SetLogLevel(logging.LOG_VERBOSE)
+
+	err := cmd.Parse(DEFAULT_CONFIG_PATH, SERVICE_NAME)
+	if err != nil {
+		log.WithError(err).Errorln("Can't parse args")
+		os.Exit(1)
+	}
+	if *logFile == "" {
+		log.Errorln("Replication_log_file arg is missing")
+		os.Exit(1)
+	}
+
+	absKeysDir, err := utils.AbsPath(*keysDir)
+	if err != nil {
+		log.WithError(err).Errorln("Can't get absolute path for keys_dir")
+		os.Exit(1)
+	}
+	absKeysPublicDir := absKeysDir
+	if *keysPublicDir != "" {
+		absKeysPublicDir, err = utils.AbsPath(*keysPublicDir)
+		if err != nil {
+			log.WithError(err).Errorln("Can't get absolute path for keys_dir_public")
+			os.Exit(1)
+		}
+	}
+	masterKey, err := keystore.GetMasterKeyFromEnvironment()
+	if err != nil {
+		log.WithError(err).Errorln("Can't load master key")
+		os.Exit(1)
+	}
+	scellEncryptor, err := keystore.NewSCellKeyEncryptor(masterKey)
+	if err != nil {
+		log.WithError(err).Errorln("Can't init scell encryptor")
+		os.Exit(1)
+	}
+	keyStore, err := filesystem.NewFilesystemKeyStoreTwoPath(absKeysDir, absKeysPublicDir, scellEncryptor)
+	if err != nil {
+		log.WithError(err).Errorln("Can't create key store")
+		os.Exit(1)
+	}
+
+	if *applyMode {
+		err = applyLog(keyStore, *logFile)
+	} else {
+		err = exportLog(keyStore, *since, *logFile)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
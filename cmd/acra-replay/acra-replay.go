@@ -0,0 +1,120 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package main is entry point for AcraReplay utility. AcraReplay reads recorded database response stream or dump file,
+// decrypts AcraStructs found in it without live database and writes plaintext output. It is useful for incident
+// forensics and data recovery when database or AcraServer are unavailable.
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+
+	"github.com/cossacklabs/acra/cmd"
+	"github.com/cossacklabs/acra/decryptor/replay"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/filesystem"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// Constants used by AcraReplay
+var (
+	// DEFAULT_CONFIG_PATH relative path to config which will be parsed as default
+	DEFAULT_CONFIG_PATH = utils.GetConfigPathByName("acra-replay")
+	SERVICE_NAME        = "acra-replay"
+)
+
+// stdioPath is value of input and output flags which means stdin and stdout
+const stdioPath = "-"
+
+func main() {
+	keysDir := flag.String("keys_dir", keystore.DefaultKeyDirShort, "Folder from which the keys will be loaded")
+	clientID := flag.String("client_id", "", "Client ID whose keys will be used to decrypt AcraStructs")
+	withZone := flag.Bool("zonemode_enable", false, "Decrypt AcraStructs with keys of zone which id precedes them in data")
+	inputPath := flag.String("input", stdioPath, "Path to recorded stream or dump file ('-' for stdin)")
+	outputPath := flag.String("output", stdioPath, "Path to file for output with decrypted data ('-' for stdout)")
+	format := flag.String("format", replay.FormatRaw, "Format of input: 'raw' for AcraStructs inlined as is, 'hex' for hex encoded values of pg_dump/mysqldump, 'postgresql' or 'mysql' for recorded stream of database responses")
+
+	logging.SetLogLevel(logging.LOG_VERBOSE)
+
+	err := cmd.Parse(DEFAULT_CONFIG_PATH, SERVICE_NAME)
+	if err != nil {
+		log.WithError(err).Errorln("Can't parse args")
+		os.Exit(1)
+	}
+
+	if !*withZone {
+		cmd.ValidateClientID(*clientID)
+	}
+
+	absKeysDir, err := utils.AbsPath(*keysDir)
+	if err != nil {
+		log.WithError(err).Errorln("Can't get absolute path for keys_dir")
+		os.Exit(1)
+	}
+	masterKey, err := keystore.GetMasterKeyFromEnvironment()
+	if err != nil {
+		log.WithError(err).Errorln("Can't load master key")
+		os.Exit(1)
+	}
+	scellEncryptor, err := keystore.NewSCellKeyEncryptor(masterKey)
+	if err != nil {
+		log.WithError(err).Errorln("Can't init scell encryptor")
+		os.Exit(1)
+	}
+	keystorage, err := filesystem.NewFilesystemKeyStore(absKeysDir, scellEncryptor)
+	if err != nil {
+		log.WithError(err).Errorln("Can't create key store")
+		os.Exit(1)
+	}
+
+	var input []byte
+	if *inputPath == stdioPath {
+		input, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		input, err = ioutil.ReadFile(*inputPath)
+	}
+	if err != nil {
+		log.WithError(err).Errorln("Can't read input")
+		os.Exit(1)
+	}
+
+	replayer := replay.NewReplayer(keystorage, []byte(*clientID))
+	replayer.SetWithZone(*withZone)
+	output, err := replayer.Replay(*format, input)
+	if err != nil {
+		log.WithError(err).WithField("format", *format).Errorln("Can't process input")
+		os.Exit(1)
+	}
+
+	if *outputPath == stdioPath {
+		_, err = os.Stdout.Write(output)
+	} else {
+		err = ioutil.WriteFile(*outputPath, output, 0600)
+	}
+	if err != nil {
+		log.WithError(err).Errorln("Can't write output")
+		os.Exit(1)
+	}
+	stats := replayer.Stats()
+	log.Infof("Decrypted %v AcraStructs, failed to decrypt %v", stats.Decrypted, stats.Failed)
+	if stats.Failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -31,6 +31,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/cossacklabs/acra/cmd"
 	"github.com/cossacklabs/acra/decryptor/base"
@@ -53,10 +54,19 @@ var (
 	SERVICE_NAME        = "acra-rollback"
 )
 
+// metricsPusher pushes metrics of job if pushgateway or remote-write url specified
+var metricsPusher *cmd.MetricsPusher
+
+// exit pushes outcome of job and exits with code
+func exit(code int) {
+	metricsPusher.Finish(code == 0)
+	os.Exit(code)
+}
+
 // ErrorExit prints error and exits.
 func ErrorExit(msg string, err error) {
 	fmt.Println(utils.ErrorMessage(msg, err))
-	os.Exit(1)
+	exit(1)
 }
 
 // BinaryEncoder encodes binary to string
@@ -168,7 +178,7 @@ func (ex *WriteToFileExecutor) Execute(data []byte) {
 	}
 	if n != len(outputSQL) {
 		fmt.Println("Incorrect write count")
-		os.Exit(1)
+		exit(1)
 	}
 	n, err = ex.writer.Write(NEWLINE)
 	if err != nil {
@@ -176,7 +186,7 @@ func (ex *WriteToFileExecutor) Execute(data []byte) {
 	}
 	if n != 1 {
 		fmt.Println("Incorrect write count")
-		os.Exit(1)
+		exit(1)
 	}
 }
 
@@ -199,6 +209,9 @@ func main() {
 	escapeFormat := flag.Bool("escape", false, "Escape bytea format")
 	useMysql := flag.Bool("mysql_enable", false, "Handle MySQL connections")
 	usePostgresql := flag.Bool("postgresql_enable", false, "Handle Postgresql connections")
+	pushgatewayURL := flag.String("prometheus_pushgateway_url", "", "URL of Prometheus Pushgateway to push metrics of job to")
+	remoteWriteURL := flag.String("prometheus_remote_write_url", "", "URL of endpoint which supports Prometheus remote-write protocol to push metrics of job to")
+	pushInterval := flag.Int("prometheus_push_interval", 10, "Interval in seconds between pushes of job progress metrics")
 
 	logging.SetLogLevel(logging.LOG_VERBOSE)
 
@@ -208,11 +221,20 @@ func main() {
 		os.Exit(1)
 	}
 
+	metricsPusher, err = cmd.NewMetricsPusher(SERVICE_NAME, *pushgatewayURL, *remoteWriteURL)
+	if err != nil {
+		log.WithError(err).Errorln("Can't initialize metrics pushing")
+		os.Exit(1)
+	}
+	metricsPusher.Start(time.Duration(*pushInterval) * time.Second)
+	// called last after all executors flushed their output, does nothing if job already finished with error
+	defer metricsPusher.Finish(true)
+
 	twoDrivers := *useMysql && *usePostgresql
 	noDrivers := !(*useMysql || *usePostgresql)
 	if twoDrivers || noDrivers {
 		log.Errorln("You must pass only --mysql_enable or --postgresql_enable (one required)")
-		os.Exit(1)
+		exit(1)
 	}
 	if *useMysql {
 		PLACEHOLDER = "?"
@@ -220,7 +242,7 @@ func main() {
 
 	if !strings.Contains(*sqlInsert, PLACEHOLDER) {
 		log.Errorln("SQL INSERT statement doesn't contain any placeholders")
-		os.Exit(1)
+		exit(1)
 	}
 
 	dbDriverName := "postgres"
@@ -235,56 +257,56 @@ func main() {
 
 	if *connectionString == "" {
 		log.Errorln("Connection_string arg is missing")
-		os.Exit(1)
+		exit(1)
 	}
 
 	if *sqlSelect == "" {
 		log.Errorln("Sql_select arg is missing")
-		os.Exit(1)
+		exit(1)
 	}
 	if *sqlInsert == "" {
 		log.Errorln("Sql_insert arg is missing")
-		os.Exit(1)
+		exit(1)
 	}
 	absKeysDir, err := utils.AbsPath(*keysDir)
 	if err != nil {
 		log.WithError(err).Errorln("Can't get absolute path for keys_dir")
-		os.Exit(1)
+		exit(1)
 	}
 	if *outputFile == "" && !*execute {
 		log.Errorln("Output_file missing or execute flag")
-		os.Exit(1)
+		exit(1)
 	}
 	masterKey, err := keystore.GetMasterKeyFromEnvironment()
 	if err != nil {
 		log.WithError(err).Errorln("Can't load master key")
-		os.Exit(1)
+		exit(1)
 	}
 	scellEncryptor, err := keystore.NewSCellKeyEncryptor(masterKey)
 	if err != nil {
 		log.WithError(err).Errorln("Can't init scell encryptor")
-		os.Exit(1)
+		exit(1)
 	}
 	keystorage, err := filesystem.NewFilesystemKeyStore(absKeysDir, scellEncryptor)
 	if err != nil {
 		log.WithError(err).Errorln("Can't create key store")
-		os.Exit(1)
+		exit(1)
 	}
 	db, err := sql.Open(dbDriverName, *connectionString)
 	if err != nil {
 		log.WithError(err).Errorln("Can't connect to db")
-		os.Exit(1)
+		exit(1)
 	}
 	defer db.Close()
 	err = db.Ping()
 	if err != nil {
 		log.WithError(err).Errorln("Can't connect to db")
-		os.Exit(1)
+		exit(1)
 	}
 	rows, err := db.Query(*sqlSelect)
 	if err != nil {
 		log.WithError(err).Errorf("Error with select query '%v'", *sqlSelect)
-		os.Exit(1)
+		exit(1)
 	}
 	defer rows.Close()
 
@@ -309,7 +331,7 @@ func main() {
 	}
 
 	var data, zone []byte
-	var privateKey *keys.PrivateKey
+	var privateKeys []*keys.PrivateKey
 
 	for i := 0; rows.Next(); i++ {
 		if *withZone {
@@ -317,9 +339,10 @@ func main() {
 			if err != nil {
 				ErrorExit("Can't read zone & data from row %v", err)
 			}
-			privateKey, err = keystorage.GetZonePrivateKey(zone)
+			privateKeys, err = keystorage.GetZonePrivateKeys(zone)
 			if err != nil {
 				log.WithError(err).Errorf("Can't get zone private key for row with number %v", i)
+				rowsCounter.WithLabelValues(rowStatusFailed).Inc()
 				continue
 			}
 		} else {
@@ -327,20 +350,23 @@ func main() {
 			if err != nil {
 				ErrorExit("Can't read data from row", err)
 			}
-			privateKey, err = keystorage.GetServerDecryptionPrivateKey([]byte(*clientID))
+			privateKeys, err = keystorage.GetServerDecryptionPrivateKeys([]byte(*clientID))
 			if err != nil {
 				log.WithError(err).Errorf("Can't get private key for row with number %v", i)
+				rowsCounter.WithLabelValues(rowStatusFailed).Inc()
 				continue
 			}
 		}
-		decrypted, err := base.DecryptAcrastruct(data, privateKey, zone)
+		decrypted, err := base.DecryptRotatedAcrastruct(data, privateKeys, zone)
 		if err != nil {
 			log.WithError(err).Errorln("Can't decrypt acrastruct in row with number %v", i)
+			rowsCounter.WithLabelValues(rowStatusFailed).Inc()
 			continue
 		}
 		for e := executors.Front(); e != nil; e = e.Next() {
 			executor := e.Value.(Executor)
 			executor.Execute(decrypted)
 		}
+		rowsCounter.WithLabelValues(rowStatusDecrypted).Inc()
 	}
 }
@@ -0,0 +1,37 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+const (
+	rowStatusLabel     = "status"
+	rowStatusDecrypted = "decrypted"
+	rowStatusFailed    = "failed"
+)
+
+var (
+	rowsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "acrarollback_rows_total",
+			Help: "number of processed rows",
+		}, []string{rowStatusLabel})
+)
+
+func init() {
+	prometheus.MustRegister(rowsCounter)
+}
@@ -15,7 +15,8 @@ limitations under the License.
 */
 
 // Package main is entry point for acra-rotate. Acra-rotate provide console utility to rotate private/zone keys and re-encrypt
-// data stored in database or as files
+// data stored in database or as files. Previous versions of rotated keys are kept in keystore, so AcraServer decrypts
+// data which wasn't re-encrypted yet
 package main
 
 import (
@@ -25,8 +26,12 @@ import (
 	"github.com/cossacklabs/acra/keystore/filesystem"
 	"github.com/cossacklabs/acra/logging"
 	"github.com/cossacklabs/acra/utils"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
 	log "github.com/sirupsen/logrus"
 	"os"
+	"strings"
+	"time"
 )
 
 // Constants used by AcraRotate
@@ -36,11 +41,20 @@ var (
 	ServiceName       = "acra-rotate"
 )
 
+// metricsPusher pushes metrics of job if pushgateway or remote-write url specified
+var metricsPusher *cmd.MetricsPusher
+
+// exit pushes outcome of job and exits with code
+func exit(code int) {
+	metricsPusher.Finish(code == 0)
+	os.Exit(code)
+}
+
 func initKeyStore(dirPath string) (keystore.KeyStore, error) {
 	absKeysDir, err := utils.AbsPath(dirPath)
 	if err != nil {
 		log.WithError(err).Errorln("Can't get absolute path for keys_dir")
-		os.Exit(1)
+		exit(1)
 	}
 	masterKey, err := keystore.GetMasterKeyFromEnvironment()
 	if err != nil {
@@ -63,6 +77,17 @@ func initKeyStore(dirPath string) (keystore.KeyStore, error) {
 func main() {
 	keysDir := flag.String("keys_dir", keystore.DefaultKeyDirShort, "Folder from which the keys will be loaded")
 	fileMapConfig := flag.String("file_map_config", "", "Path to file with map of <ZoneId>: <FilePaths> in json format {\"zone_id1\": [\"filepath1\", \"filepath2\"], \"zone_id2\": [\"filepath1\", \"filepath2\"]}")
+	zoneID := flag.String("zone_id", "", "Zone ID whose key will be rotated")
+	clientID := flag.String("client_id", "", "Client ID whose storage keys will be rotated")
+	connectionString := flag.String("connection_string", "", "Connection string for db with AcraStructs which will be re-encrypted with rotated key")
+	sqlSelect := flag.String("sql_select", "", "Query to fetch AcraStructs for re-encryption, AcraStruct should be first column and other columns are passed to sql_update")
+	sqlUpdate := flag.String("sql_update", "", "Query to store re-encrypted AcraStruct with placeholders (pg: $n, mysql: ?), first is AcraStruct and next are other columns of sql_select")
+	useMysql := flag.Bool("mysql_enable", false, "Handle MySQL connections")
+	usePostgresql := flag.Bool("postgresql_enable", false, "Handle Postgresql connections")
+	dryRun := flag.Bool("dry_run", false, "Check that AcraStructs can be re-encrypted without saving rotated key and AcraStructs")
+	pushgatewayURL := flag.String("prometheus_pushgateway_url", "", "URL of Prometheus Pushgateway to push metrics of job to")
+	remoteWriteURL := flag.String("prometheus_remote_write_url", "", "URL of endpoint which supports Prometheus remote-write protocol to push metrics of job to")
+	pushInterval := flag.Int("prometheus_push_interval", 10, "Interval in seconds between pushes of job progress metrics")
 
 	logging.SetLogLevel(logging.LOG_VERBOSE)
 
@@ -72,11 +97,56 @@ func main() {
 		os.Exit(1)
 	}
 
-	keystorage, err := initKeyStore(*keysDir)
+	metricsPusher, err = cmd.NewMetricsPusher(ServiceName, *pushgatewayURL, *remoteWriteURL)
 	if err != nil {
+		log.WithError(err).Errorln("Can't initialize metrics pushing")
 		os.Exit(1)
 	}
+	metricsPusher.Start(time.Duration(*pushInterval) * time.Second)
+
+	if *zoneID != "" && *clientID != "" {
+		log.Errorln("You must pass only --zone_id or --client_id")
+		exit(1)
+	}
+	if *clientID != "" {
+		cmd.ValidateClientID(*clientID)
+	}
+	rotationParams := keyRotationParams{zoneID: *zoneID, clientID: *clientID, connection: *connectionString,
+		selectQuery: *sqlSelect, updateQuery: *sqlUpdate, dryRun: *dryRun}
+	if *connectionString != "" {
+		if *zoneID == "" && *clientID == "" {
+			log.Errorln("Re-encryption of database requires --zone_id or --client_id")
+			exit(1)
+		}
+		if *useMysql == *usePostgresql {
+			log.Errorln("You must pass only --mysql_enable or --postgresql_enable (one required)")
+			exit(1)
+		}
+		placeholder := "$1"
+		rotationParams.dbDriverName = "postgres"
+		if *useMysql {
+			placeholder = "?"
+			rotationParams.dbDriverName = "mysql"
+		}
+		if *sqlSelect == "" {
+			log.Errorln("Sql_select arg is missing")
+			exit(1)
+		}
+		if !strings.Contains(*sqlUpdate, placeholder) {
+			log.Errorln("SQL UPDATE statement doesn't contain any placeholders")
+			exit(1)
+		}
+	}
+
+	keystorage, err := initKeyStore(*keysDir)
+	if err != nil {
+		exit(1)
+	}
 	if *fileMapConfig != "" {
 		runFileRotation(*fileMapConfig, keystorage)
 	}
+	if *zoneID != "" || *clientID != "" {
+		runKeyRotation(rotationParams, keystorage)
+	}
+	exit(0)
 }
@@ -0,0 +1,223 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/cossacklabs/acra/acra-writer"
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/utils"
+	"github.com/cossacklabs/themis/gothemis/keys"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrEmptySelectResult returned if select query doesn't return column with AcraStruct
+var ErrEmptySelectResult = errors.New("select query should return AcraStruct as first column")
+
+// KeyRotateResult stores new public key and amount of AcraStructs re-encrypted with it
+type KeyRotateResult struct {
+	ZoneID       string `json:"zone_id,omitempty"`
+	ClientID     string `json:"client_id,omitempty"`
+	NewPublicKey []byte `json:"new_public_key"`
+	Rotated      int    `json:"rotated"`
+	Failed       int    `json:"failed"`
+	DryRun       bool   `json:"dry_run"`
+}
+
+// keyRotator generates new version of zone key or client storage keys and re-encrypts AcraStructs with it
+type keyRotator struct {
+	keystore     keystore.KeyStore
+	id           []byte
+	withZone     bool
+	dryRun       bool
+	newPublicKey *keys.PublicKey
+	privateKeys  []*keys.PrivateKey
+}
+
+func newKeyRotator(keyStore keystore.KeyStore, id []byte, withZone, dryRun bool) *keyRotator {
+	return &keyRotator{keystore: keyStore, id: id, withZone: withZone, dryRun: dryRun}
+}
+
+// rotate generates new key version and loads all versions of private key to decrypt AcraStructs. New key is kept only
+// in memory in dry run
+func (rotator *keyRotator) rotate() error {
+	var newPublicKey []byte
+	var err error
+	switch {
+	case rotator.dryRun:
+		keypair, err := keys.New(keys.KEYTYPE_EC)
+		if err != nil {
+			return err
+		}
+		utils.FillSlice(byte(0), keypair.Private.Value)
+		newPublicKey = keypair.Public.Value
+	case rotator.withZone:
+		newPublicKey, err = rotator.keystore.RotateZoneKey(rotator.id)
+	default:
+		newPublicKey, err = rotator.keystore.RotateStorageKeys(rotator.id)
+	}
+	if err != nil {
+		return err
+	}
+	rotator.newPublicKey = &keys.PublicKey{Value: newPublicKey}
+	if rotator.withZone {
+		rotator.privateKeys, err = rotator.keystore.GetZonePrivateKeys(rotator.id)
+	} else {
+		rotator.privateKeys, err = rotator.keystore.GetServerDecryptionPrivateKeys(rotator.id)
+	}
+	return err
+}
+
+// context returns context of AcraStructs which is zone id in zone mode
+func (rotator *keyRotator) context() []byte {
+	if rotator.withZone {
+		return rotator.id
+	}
+	return nil
+}
+
+// reencrypt decrypts AcraStruct with any version of private key and encrypts data with new public key
+func (rotator *keyRotator) reencrypt(acraStruct []byte) ([]byte, error) {
+	decrypted, err := base.DecryptRotatedAcrastruct(acraStruct, rotator.privateKeys, rotator.context())
+	if err != nil {
+		return nil, err
+	}
+	defer utils.FillSlice(byte(0), decrypted)
+	return acrawriter.CreateAcrastruct(decrypted, rotator.newPublicKey, rotator.context())
+}
+
+// Close zeroes loaded private keys
+func (rotator *keyRotator) Close() {
+	for _, privateKey := range rotator.privateKeys {
+		utils.FillSlice(byte(0), privateKey.Value)
+	}
+}
+
+// rotateDatabase re-encrypts AcraStructs returned by selectQuery as first column and stores them with updateQuery
+// which takes re-encrypted AcraStruct and other selected columns as parameters. Rows aren't updated in dry run
+func rotateDatabase(db *sql.DB, selectQuery, updateQuery string, rotator *keyRotator, result *KeyRotateResult) error {
+	var updateStatement *sql.Stmt
+	if !rotator.dryRun {
+		var err error
+		updateStatement, err = db.Prepare(updateQuery)
+		if err != nil {
+			return err
+		}
+		defer updateStatement.Close()
+	}
+	rows, err := db.Query(selectQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		return ErrEmptySelectResult
+	}
+	var acraStruct []byte
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	pointers[0] = &acraStruct
+	for i := 1; i < len(columns); i++ {
+		pointers[i] = &values[i]
+	}
+	for i := 0; rows.Next(); i++ {
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+		rotated, err := rotator.reencrypt(acraStruct)
+		if err != nil {
+			log.WithError(err).Errorf("Can't re-encrypt AcraStruct in row with number %v", i)
+			rowsCounter.WithLabelValues(rowStatusFailed).Inc()
+			result.Failed++
+			continue
+		}
+		if updateStatement != nil {
+			values[0] = rotated
+			if _, err := updateStatement.Exec(values...); err != nil {
+				log.WithError(err).Errorf("Can't update row with number %v", i)
+				return err
+			}
+		}
+		rowsCounter.WithLabelValues(rowStatusRotated).Inc()
+		result.Rotated++
+	}
+	return rows.Err()
+}
+
+// keyRotationParams are settings of rotation of zone key or client storage keys
+type keyRotationParams struct {
+	zoneID       string
+	clientID     string
+	dbDriverName string
+	connection   string
+	selectQuery  string
+	updateQuery  string
+	dryRun       bool
+}
+
+// runKeyRotation generates new version of key and re-encrypts AcraStructs stored in database if connection is
+// configured, prints result in json format
+func runKeyRotation(params keyRotationParams, keyStore keystore.KeyStore) {
+	id, withZone := []byte(params.clientID), false
+	logger := log.WithField("client_id", params.clientID)
+	if params.zoneID != "" {
+		id, withZone = []byte(params.zoneID), true
+		logger = log.WithField("zone_id", params.zoneID)
+	}
+	rotator := newKeyRotator(keyStore, id, withZone, params.dryRun)
+	defer rotator.Close()
+	if err := rotator.rotate(); err != nil {
+		logger.WithError(err).Errorln("Can't rotate key")
+		exit(1)
+	}
+	if withZone {
+		rotatedZonesCounter.Inc()
+	}
+	result := &KeyRotateResult{ZoneID: params.zoneID, ClientID: params.clientID, NewPublicKey: rotator.newPublicKey.Value, DryRun: params.dryRun}
+	if params.connection != "" {
+		db, err := sql.Open(params.dbDriverName, params.connection)
+		if err != nil {
+			logger.WithError(err).Errorln("Can't connect to db")
+			exit(1)
+		}
+		defer db.Close()
+		if err := db.Ping(); err != nil {
+			logger.WithError(err).Errorln("Can't connect to db")
+			exit(1)
+		}
+		if err := rotateDatabase(db, params.selectQuery, params.updateQuery, rotator, result); err != nil {
+			logger.WithError(err).Errorln("Can't re-encrypt AcraStructs stored in database")
+			exit(1)
+		}
+		logger.Infof("Re-encrypted %v AcraStructs, failed %v", result.Rotated, result.Failed)
+	}
+	jsonOutput, err := json.Marshal(result)
+	if err != nil {
+		logger.WithError(err).Errorln("Can't encode result to json format")
+		exit(1)
+	}
+	fmt.Println(string(jsonOutput))
+}
@@ -52,9 +52,10 @@ func rotateFiles(fileMap ZoneIDFileMap, keyStore keystore.KeyStore) (ZoneRotateR
 	for zoneID, paths := range fileMap {
 		logger := log.WithField("zone_id", zoneID)
 		binZoneID := []byte(zoneID)
-		privateKey, err := keyStore.GetZonePrivateKey(binZoneID)
+		// load all versions to re-encrypt files which weren't rotated with previous key
+		privateKeys, err := keyStore.GetZonePrivateKeys(binZoneID)
 		if err != nil {
-			logger.WithError(err).Errorln("Can't load private key of zone")
+			logger.WithError(err).Errorln("Can't load private keys of zone")
 			return nil, err
 		}
 		newPublicKey, err := keyStore.RotateZoneKey(binZoneID)
@@ -71,7 +72,7 @@ func rotateFiles(fileMap ZoneIDFileMap, keyStore keystore.KeyStore) (ZoneRotateR
 				fileLogger.WithError(err).Errorf("Can't read file %s", path)
 				return nil, err
 			}
-			decrypted, err := base.DecryptAcrastruct(acraStruct, privateKey, binZoneID)
+			decrypted, err := base.DecryptRotatedAcrastruct(acraStruct, privateKeys, binZoneID)
 			if err != nil {
 				fileLogger.WithError(err).Errorln("Can't decrypt AcraStruct")
 				return nil, err
@@ -90,9 +91,11 @@ func rotateFiles(fileMap ZoneIDFileMap, keyStore keystore.KeyStore) (ZoneRotateR
 				fileLogger.WithError(err).Errorln("Can't write rotated AcraStruct with zone")
 				return nil, err
 			}
+			rotatedFilesCounter.Inc()
 			fileLogger.Infof("Finish rotate file")
 		}
 		output[zoneID] = result
+		rotatedZonesCounter.Inc()
 		logger.Infoln("Finish rotate zone")
 	}
 	return output, nil
@@ -103,17 +106,17 @@ func runFileRotation(fileMapConfigPath string, keystorage keystore.KeyStore) {
 	fileMap, err := loadFileMap(fileMapConfigPath)
 	if err != nil {
 		log.WithError(err).Errorln("Can't load config with map <ZoneId>: <FilePath>")
-		os.Exit(1)
+		exit(1)
 	}
 	result, err := rotateFiles(fileMap, keystorage)
 	if err != nil {
 		log.WithError(err).Errorln("Can't rotate files")
-		os.Exit(1)
+		exit(1)
 	}
 	jsonOutput, err := json.Marshal(result)
 	if err != nil {
 		log.WithError(err).Errorln("Can't encode result to json format")
-		os.Exit(1)
+		exit(1)
 	}
 	fmt.Println(string(jsonOutput))
 }
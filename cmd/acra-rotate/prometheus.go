@@ -0,0 +1,51 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+const (
+	rowStatusLabel   = "status"
+	rowStatusRotated = "rotated"
+	rowStatusFailed  = "failed"
+)
+
+var (
+	rotatedZonesCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "acrarotate_rotated_zones_total",
+			Help: "number of zones with rotated keys",
+		})
+
+	rotatedFilesCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "acrarotate_rotated_files_total",
+			Help: "number of files re-encrypted with rotated zone keys",
+		})
+
+	rowsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "acrarotate_rows_total",
+			Help: "number of processed database rows with AcraStructs",
+		}, []string{rowStatusLabel})
+)
+
+func init() {
+	prometheus.MustRegister(rotatedZonesCounter)
+	prometheus.MustRegister(rotatedFilesCounter)
+	prometheus.MustRegister(rowsCounter)
+}
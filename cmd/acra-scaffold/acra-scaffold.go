@@ -0,0 +1,145 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package main is entry point for AcraScaffold utility. AcraScaffold connects to the database, reads tables and
+// types of their columns, selects columns which probably store sensitive data by their names and types and writes
+// starter Encryptor configuration for AcraServer. Generated configuration should be reviewed before usage: columns
+// should be altered to binary types and companion hash columns of searchable columns should be created.
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/cossacklabs/acra/cmd"
+	"github.com/cossacklabs/acra/encryptor"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/utils"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Constants used by AcraScaffold
+var (
+	// DEFAULT_CONFIG_PATH relative path to config which will be parsed as default
+	DEFAULT_CONFIG_PATH = utils.GetConfigPathByName("acra-scaffold")
+	SERVICE_NAME        = "acra-scaffold"
+)
+
+// renderConfig returns Encryptor configuration in YAML with comments which describe why columns were selected
+func renderConfig(sensitiveColumns []encryptor.SensitiveColumn) ([]byte, error) {
+	data, err := yaml.Marshal(encryptor.ScaffoldConfig(sensitiveColumns))
+	if err != nil {
+		return nil, err
+	}
+	output := &bytes.Buffer{}
+	output.WriteString("# Encryptor configuration generated by acra-scaffold, review it before usage.\n")
+	output.WriteString("# Encrypted columns should have binary type, searchable columns need companion hash columns.\n")
+	output.WriteString("# Selected columns:\n")
+	for _, column := range sensitiveColumns {
+		fmt.Fprintf(output, "#   %s.%s (%s): %s\n", column.Table, column.Column, column.Type, column.Reason)
+	}
+	output.Write(data)
+	return output.Bytes(), nil
+}
+
+func main() {
+	connectionString := flag.String("connection_string", "", "Connection string for db")
+	tables := flag.String("tables", "", "Comma separated list of tables to inspect (all tables of current schema by default)")
+	columnPatterns := flag.String("column_patterns", "", "Comma separated list of additional substrings of names of sensitive columns")
+	outputPath := flag.String("output_file", "", "Path to file where Encryptor configuration will be written (stdout by default)")
+	useMysql := flag.Bool("mysql_enable", false, "Handle MySQL connections")
+	usePostgresql := flag.Bool("postgresql_enable", false, "Handle Postgresql connections")
+
+	logging.SetLogLevel(logging.LOG_VERBOSE)
+
+	err := cmd.Parse(DEFAULT_CONFIG_PATH, SERVICE_NAME)
+	if err != nil {
+		log.WithError(err).Errorln("Can't parse args")
+		os.Exit(1)
+	}
+
+	twoDrivers := *useMysql && *usePostgresql
+	noDrivers := !(*useMysql || *usePostgresql)
+	if twoDrivers || noDrivers {
+		log.Errorln("You must pass only --mysql_enable or --postgresql_enable (one required)")
+		os.Exit(1)
+	}
+	dbDriverName := "postgres"
+	if *useMysql {
+		dbDriverName = "mysql"
+	}
+	if *connectionString == "" {
+		log.Errorln("Connection_string arg is missing")
+		os.Exit(1)
+	}
+
+	db, err := sql.Open(dbDriverName, *connectionString)
+	if err != nil {
+		log.WithError(err).Errorln("Can't connect to db")
+		os.Exit(1)
+	}
+	defer db.Close()
+	schema, err := encryptor.IntrospectSchema(db, *useMysql)
+	if err != nil {
+		log.WithError(err).Errorln("Can't read schema of db")
+		os.Exit(1)
+	}
+	if *tables != "" {
+		filtered := encryptor.DatabaseSchema{}
+		for _, table := range strings.Split(*tables, ",") {
+			table = strings.ToLower(strings.TrimSpace(table))
+			columns, ok := schema[table]
+			if !ok {
+				log.Errorf("Table %s doesn't exist", table)
+				os.Exit(1)
+			}
+			filtered[table] = columns
+		}
+		schema = filtered
+	}
+
+	patterns := append([]encryptor.SensitiveColumnPattern{}, encryptor.DefaultSensitiveColumnPatterns...)
+	if *columnPatterns != "" {
+		for _, substring := range strings.Split(*columnPatterns, ",") {
+			if substring = strings.TrimSpace(substring); substring != "" {
+				patterns = append(patterns, encryptor.SensitiveColumnPattern{Substring: substring})
+			}
+		}
+	}
+	sensitiveColumns := encryptor.FindSensitiveColumns(schema, patterns)
+	log.Infof("Found %d sensitive columns in %d tables", len(sensitiveColumns), len(schema))
+	config, err := renderConfig(sensitiveColumns)
+	if err != nil {
+		log.WithError(err).Errorln("Can't encode encryptor config")
+		os.Exit(1)
+	}
+	if *outputPath == "" {
+		os.Stdout.Write(config)
+		return
+	}
+	if err := ioutil.WriteFile(*outputPath, config, 0600); err != nil {
+		log.WithError(err).Errorln("Can't write encryptor config")
+		os.Exit(1)
+	}
+}
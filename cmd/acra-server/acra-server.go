@@ -25,7 +25,8 @@ limitations under the License.
 // AcraServer will replace the AcraStruct with the decrypted payload, change the packet's length, and return
 // the answer to the application via AcraConnector.
 // If AcraServer detects a poison record within the AcraStruct's decryption stream, AcraServer will either
-// shut down the decryption, run an alarm script, or do both, depending on the pre-set parameters.
+// shut down the decryption, run an alarm script, suspend decryption for configured period keeping connections alive,
+// or combine these actions, depending on the pre-set parameters.
 //
 // https://github.com/cossacklabs/acra/wiki/How-AcraServer-works
 package main
@@ -34,18 +35,27 @@ import (
 	"crypto/tls"
 	"errors"
 	"flag"
+	"fmt"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"runtime"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/cossacklabs/acra/cmd"
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/decryptor/mysql"
+	"github.com/cossacklabs/acra/decryptor/postgresql"
 	"github.com/cossacklabs/acra/keystore"
+	// registers filesystem keystore backend
 	"github.com/cossacklabs/acra/keystore/filesystem"
 	"github.com/cossacklabs/acra/logging"
 	"github.com/cossacklabs/acra/network"
 	"github.com/cossacklabs/acra/utils"
+	"github.com/cossacklabs/acra/zone"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -64,6 +74,12 @@ var TestOnly = "false"
 // Constants used by AcraServer.
 const (
 	DEFAULT_ACRASERVER_WAIT_TIMEOUT = 10
+	DEFAULT_HANDSHAKE_BAN_DURATION  = 1
+	DEFAULT_HANDSHAKE_MAX_BAN       = 300
+	DEFAULT_HANDSHAKE_TIMEOUT       = 10
+	DEFAULT_DB_STARTUP_TIMEOUT      = 30
+	DEFAULT_ZONES_BATCH_MAX_COUNT   = 100
+	DEFAULT_ZONES_QUOTA_PERIOD      = 3600
 	GRACEFUL_ENV                    = "GRACEFUL_RESTART"
 	DESCRIPTOR_ACRA                 = 3
 	DESCRIPTOR_API                  = 4
@@ -73,12 +89,16 @@ const (
 // DEFAULT_CONFIG_PATH relative path to config which will be parsed as default
 var DEFAULT_CONFIG_PATH = utils.GetConfigPathByName(SERVICE_NAME)
 
+// canceledConnectionsCloseTimeout is time given to connections canceled after shutdown timeout to stop processing and
+// close connections to database
+const canceledConnectionsCloseTimeout = time.Second
+
 // ErrWaitTimeout error indicates that server was shutdown and waited N seconds while shutting down all connections.
 var ErrWaitTimeout = errors.New("timeout")
 
 func main() {
 	config := NewConfig()
-	loggingFormat := flag.String("logging_format", "plaintext", "Logging format: plaintext, json or CEF")
+	loggingFormat := flag.String("logging_format", "plaintext", "Logging format: plaintext, json, CEF or GELF")
 	logging.CustomizeLogging(*loggingFormat, SERVICE_NAME)
 	log.Infof("Starting service %v", SERVICE_NAME)
 
@@ -92,7 +112,50 @@ func main() {
 	apiPort := flag.Int("incoming_connection_api_port", cmd.DEFAULT_ACRASERVER_API_PORT, "Port for AcraServer for HTTP API")
 
 	keysDir := flag.String("keys_dir", keystore.DefaultKeyDirShort, "Folder from which will be loaded keys")
+	maxConcurrentDecryptions := flag.Int("max_concurrent_decryptions", 0, "Max count of simultaneous AcraStruct decryptions. 0 - without limits")
+	decryptionQueueSize := flag.Int("decryption_queue_size", cmd.DEFAULT_DECRYPTION_QUEUE_SIZE, "Max count of decryptions which wait for free slot when max_concurrent_decryptions reached, other decryptions are rejected")
+	decryptionQueueTimeout := flag.Int("decryption_queue_timeout", cmd.DEFAULT_DECRYPTION_QUEUE_TIMEOUT, "Max time (in milliseconds) to wait for free slot for decryption, after timeout decryption is rejected. 0 - wait without timeout")
+	decryptionLatencyBudget := flag.Int("decryption_latency_budget", 0, "Budget (in milliseconds) of average time which decryptions wait in queue of max_concurrent_decryptions. When it's exceeded, values of decryption_latency_budget_columns are returned encrypted until decryption_latency_budget_cooldown ends, with event code 660 and metrics. 0 - turn off")
+	decryptionLatencyBudgetColumns := flag.String("decryption_latency_budget_columns", "", "Comma-separated list of low-sensitivity columns as 'table.column' or 'column' of any table, returned encrypted while decryption_latency_budget is exceeded. PostgreSQL results match only 'column'")
+	decryptionLatencyBudgetCooldown := flag.Int("decryption_latency_budget_cooldown", cmd.DEFAULT_DECRYPTION_LATENCY_COOLDOWN, "Time (in milliseconds) during which low-sensitivity columns are returned encrypted after decryption_latency_budget was exceeded")
+	gomaxprocs := flag.Int("gomaxprocs", 0, "Max count of OS threads which execute Go code simultaneously (GOMAXPROCS). 0 - use value from environment or count of CPUs")
+	cpuAffinity := flag.String("cpu_affinity", "", "List of CPUs on which AcraServer process may run, e.g. '0-3,8'. Empty - any CPU (Linux only)")
+	connectionCPUAffinity := flag.String("incoming_connection_cpu_affinity", "", "List of CPUs to which pool of workers of each listener of connections from AcraConnector is pinned, e.g. '0-3'. Empty - connections are served by goroutines on any CPU (Linux only)")
+	apiConnectionCPUAffinity := flag.String("incoming_connection_api_cpu_affinity", "", "List of CPUs to which pool of workers of API listener is pinned, e.g. '4'. Empty - API connections are served by goroutines on any CPU (Linux only)")
+	connectionWorkers := flag.Int("incoming_connection_workers", cmd.DEFAULT_CONNECTION_WORKERS, "Count of workers in pool of each listener pinned with incoming_connection_cpu_affinity. Each connection takes 2 workers, next connections wait for free ones")
+	apiConnectionWorkers := flag.Int("incoming_connection_api_workers", cmd.DEFAULT_API_CONNECTION_WORKERS, "Count of workers in pool of API listener pinned with incoming_connection_api_cpu_affinity. Each connection takes 1 worker, next connections wait for free ones")
+	dlpSampleRate := flag.Float64("dlp_sample_rate", 0, "Fraction (0..1) of rows of query results scanned by dlp_detectors for sensitive data stored in plaintext, detections are logged with event code 650 and counted in metrics. 0 - turn off")
+	dlpDetectorNames := flag.String("dlp_detectors", base.DLPDetectorCreditCard+","+base.DLPDetectorSSN, "Comma-separated list of DLP detectors used for sampled rows: credit_card (card numbers which pass Luhn check), ssn (US social security numbers)")
+	lengthAudit := flag.Bool("decryption_length_audit_enable", false, "Check lengths of packets and fields of each data row rewritten after decryption before sending it to client. Malformed rows are logged with details and sent as they were received from database")
+	dbConnectRetries := flag.Int("db_connect_retries", 0, "Count of retries of failed connection to database for new client's connection, e.g. while database restarts")
+	dbConnectRetryInterval := flag.Int("db_connect_retry_interval", 100, "Interval in milliseconds before first retry of connection to database, doubles before each next retry")
+	statementStatsMaxCount := flag.Int("statement_stats_max_count", base.DefaultStatementStatsMaxCount, "Max count of normalized SQL statements (distinct per client) which execution count, rows and time are exported via HTTP API and prometheus metrics. Least executed statements are evicted to track new ones. 0 - turn off tracking")
+	dbReadPipelineSize := flag.Int("db_read_pipeline_size", 0, fmt.Sprintf("Count of chunks (%d bytes each) which AcraServer reads from database in background while previous rows are decrypted. 0 - read only after processing of previous data", network.DefaultPrefetchChunkSize))
+	dbReadSpillMaxSize := flag.Int("db_read_spill_max_size", 0, "Max size (in MB) of data read ahead from database per connection which is kept in encrypted temporary file when db_read_pipeline_size chunks are waiting for processing, e.g. while huge results are exported. 0 - wait for processing instead")
+	dbReadSpillDir := flag.String("db_read_spill_dir", "", "Directory for encrypted temporary files of db_read_spill_max_size, default directory for temporary files if empty")
+	ipFilterConfig := flag.String("incoming_connection_ip_filter_file", "", "Path to configuration file with IP addresses and CIDR networks allowed or denied to connect to AcraServer")
+	ipFilterReloadInterval := flag.Int("incoming_connection_ip_filter_reload_interval", cmd.DEFAULT_IP_FILTER_RELOAD_INTERVAL, "Time (in seconds) between checks of incoming_connection_ip_filter_file for changes. 0 - don't reload")
+	revocationListFile := flag.String("revocation_list_file", "", "Path to revocation list of client and zone ids signed by acra-keys revoke. Keys of revoked ids aren't used for decryption and Secure Session handshakes")
+	revocationListPublicKey := flag.String("revocation_list_public_key", "", "Path to public key which verifies signature of revocation_list_file")
+	revocationListReloadInterval := flag.Int("revocation_list_reload_interval", cmd.DEFAULT_REVOCATION_LIST_RELOAD_INTERVAL, "Time (in seconds) between checks of revocation_list_file for changes. 0 - don't reload")
+	standbyActiveAPIURL := flag.String("standby_active_api_url", "", "URL of HTTP API of active AcraServer (like http://10.0.0.1:9090) which state this AcraServer copies as warm standby: keys loaded to cache and bans of failed handshakes. Empty - not a standby")
+	standbySyncInterval := flag.Int("standby_sync_interval", cmd.DEFAULT_STANDBY_SYNC_INTERVAL, "Time (in seconds) between syncs of warm standby AcraServer with active one")
+	standbyClientID := flag.String("standby_client_id", "", "Client ID with which warm standby AcraServer authenticates to active one like AcraConnector, over TLS with acraconnector_tls_transport_enable or Secure Session otherwise")
+	standbyKeysDir := flag.String("standby_keys_dir", "", "Folder with Secure Session keys of standby_client_id: private key of client and public key of active AcraServer. Empty - keys_dir")
+	keystoreAuditLogPath := flag.String("keystore_audit_log_path", "", "Path to file where every load of private keys is appended (client/zone id, purpose, time and connection). Empty - don't audit key access")
+	handshakeBanThreshold := flag.Int("handshake_failures_ban_threshold", 0, "Count of consecutive failed transport handshakes from one source address after which it is banned. 0 - turn off bans")
+	handshakeBanDuration := flag.Int("handshake_ban_duration", DEFAULT_HANDSHAKE_BAN_DURATION, "Time (in seconds) of first ban after failed handshakes, each next failure doubles it")
+	handshakeMaxBanDuration := flag.Int("handshake_max_ban_duration", DEFAULT_HANDSHAKE_MAX_BAN, "Maximal time (in seconds) of ban after failed handshakes")
+	handshakeTimeout := flag.Int("incoming_connection_handshake_timeout", DEFAULT_HANDSHAKE_TIMEOUT, "Time (in seconds) to complete transport handshake (Secure Session or TLS) of incoming connection, stalled connections are dropped. 0 - no limit")
+	dbStartupTimeout := flag.Int("db_startup_timeout", DEFAULT_DB_STARTUP_TIMEOUT, "Time (in seconds) for client and database to complete startup phase (SSL negotiation and authentication) after connection to database, stalled connections are dropped. 0 - no limit")
+	keystoreType := flag.String("keystore_type", keystore.DefaultBackendType, fmt.Sprintf("Type of keystore which stores keys, one of: %s", strings.Join(keystore.BackendTypes(), ", ")))
+	keystoreOptions := flag.String("keystore_options", "", "Comma separated options of keystore specific for keystore_type like 'address=127.0.0.1:6379,db=1'")
+	masterKeyLoader := cmd.RegisterMasterKeyLoaderFlags()
+	hsmLoader := cmd.RegisterHSMFlags()
+	zoneIDGeneratorLoader := cmd.RegisterZoneIDGeneratorFlags()
 	keysCacheSize := flag.Int("keystore_cache_size", keystore.INFINITE_CACHE_SIZE, "Count of keys that will be stored in in-memory LRU cache in encrypted form. 0 - no limits, -1 - turn off cache")
+	keysCacheEphemeralKey := flag.Bool("keystore_cache_ephemeral_key", false, "Additionally encrypt keys in cache with random key generated on start and locked in memory, so they can't be decrypted from memory dumps with master key")
+	keysCacheWatchInterval := flag.Int("keystore_cache_watch_interval", 0, "Interval in seconds between checks of keys changed in keystore by other services or tools to remove them from cache, 0 - turn off checks. Use /v1/keystore/reset API call to clear cache on demand")
 
 	pgHexFormat := flag.Bool("pgsql_hex_bytea", false, "Hex format for Postgresql bytea data (default)")
 	pgEscapeFormat := flag.Bool("pgsql_escape_bytea", false, "Escape format for Postgresql bytea data")
@@ -104,10 +167,13 @@ func main() {
 
 	debugServer := flag.Bool("ds", false, "Turn on http debug server")
 	closeConnectionTimeout := flag.Int("incoming_connection_close_timeout", DEFAULT_ACRASERVER_WAIT_TIMEOUT, "Time that AcraServer will wait (in seconds) on restart before closing all connections")
+	lifecycleHookScripts := flag.String("lifecycle_hook_scripts", "", "Comma-separated list of stage=path pairs of scripts run at stages pre-listen, post-listen, pre-drain and post-shutdown. Stage is passed in ACRA_LIFECYCLE_STAGE environment variable")
+	lifecycleHookTimeout := flag.Int("lifecycle_hook_timeout", cmd.DEFAULT_LIFECYCLE_HOOK_TIMEOUT, "Time (in seconds) given to each lifecycle hook to finish, hook is killed after it. 0 - no limit")
 
 	detectPoisonRecords := flag.Bool("poison_detect_enable", true, "Turn on poison record detection, if server shutdown is disabled, AcraServer logs the poison record detection and returns decrypted data")
 	stopOnPoison := flag.Bool("poison_shutdown_enable", false, "On detecting poison record: log about poison record detection, stop and shutdown")
 	scriptOnPoison := flag.String("poison_run_script_file", "", "On detecting poison record: log about poison record detection, execute script, return decrypted data")
+	poisonContainmentPeriod := flag.Int("poison_containment_period", 0, "On detecting poison record: keep connections alive but return data without decryption for this period (in seconds), each detection prolongs it. 0 - turn off")
 
 	withZone := flag.Bool("zonemode_enable", false, "Turn on zone mode")
 	enableHTTPAPI := flag.Bool("http_api_enable", false, "Enable HTTP API")
@@ -117,19 +183,57 @@ func main() {
 	tlsCert := flag.String("tls_cert", "", "Path to tls certificate")
 	tlsCA := flag.String("tls_ca", "", "Path to root certificate which will be used with system root certificates to validate Postgresql's and AcraConnector's certificate")
 	tlsDbSNI := flag.String("tls_db_sni", "", "Expected Server Name (SNI) from Postgresql")
+	dbRequireSSL := flag.Bool("db_require_ssl", false, "Refuse connections which can't be switched to TLS on both sides: clients which don't request SSL and databases which don't support it. Requires tls_key and tls_cert")
+	dbTLSMode := flag.String("db_tls_mode", "", "Mode of TLS between AcraServer and PostgreSQL or MSSQL like sslmode of libpq: disable, require (without verification of certificate), verify-ca (certificate signed by tls_ca or system CA) or verify-full (also matches tls_db_sni or db_host). tls_cert/tls_key are presented if database requests client certificate. AcraServer negotiates TLS with database itself and answers SSLRequest of client. Empty - switch connection to database to TLS only when client requests SSL")
+	tlsDbClientCertificates := flag.String("tls_db_client_certificates_config_file", "", "Path to configuration file with client certificates and keys used in TLS connections to database instead of tls_cert/tls_key for specific client IDs")
+	tlsCipherSuites := flag.String("tls_cipher_suites", "", "Comma-separated list of TLS cipher suites (like TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) allowed in connections with AcraConnector and database. TLS 1.3 suites aren't configurable. Empty - default suites of Go")
+	tlsCertificateReload := flag.Bool("tls_certificate_reload_enable", false, "Reload tls_cert and tls_key when files change, new connections with AcraConnector and database use rotated certificate without restart")
 	tlsAuthType := flag.Int("tls_auth", int(tls.RequireAndVerifyClientCert), "Set authentication mode that will be used in TLS connection with Postgresql. Values in range 0-4 that set auth type (https://golang.org/pkg/crypto/tls/#ClientAuthType). Default is tls.RequireAndVerifyClientCert")
 	noEncryptionTransport := flag.Bool("acraconnector_transport_encryption_disable", false, "Use raw transport (tcp/unix socket) between AcraServer and AcraConnector/client (don't use this flag if you not connect to database with ssl/tls")
 	clientID := flag.String("client_id", "", "Expected client ID of AcraConnector in mode without encryption")
+	transportNegotiation := flag.Bool("acraconnector_transport_negotiation_enable", false, "Detect transport of each connection from AcraConnector: Secure Session, TLS (if tls_key and tls_cert set) or raw (if acraconnector_transport_negotiation_raw_enable)")
+	useSecureComparator := flag.Bool("acraconnector_secure_comparator_enable", false, "Authenticate AcraConnectors with Themis Secure Comparator by secrets from keystore (generated by 'acra-keys generate --key_purpose comparator_secret') instead of Secure Session keys. Requires acraconnector_tls_transport_enable")
+	transportNegotiationRaw := flag.Bool("acraconnector_transport_negotiation_raw_enable", false, "Accept connections without encryption with client_id when transport negotiation used")
 	acraConnectionString := flag.String("incoming_connection_string", network.BuildConnectionString(cmd.DEFAULT_ACRA_CONNECTION_PROTOCOL, cmd.DEFAULT_ACRA_HOST, cmd.DEFAULT_ACRASERVER_PORT, ""), "Connection string like tcp://x.x.x.x:yyyy or unix:///path/to/socket")
+	transportListenersString := flag.String("incoming_connection_transport_listeners", "", "Comma separated list of additional listeners of connections from AcraConnector with own transport like 'tls=tcp://0.0.0.0:9494,secure_session=tcp://0.0.0.0:9495'. Transport is one of secure_session, tls, raw, auto, secure_comparator")
 	acraAPIConnectionString := flag.String("incoming_connection_api_string", network.BuildConnectionString(cmd.DEFAULT_ACRA_CONNECTION_PROTOCOL, cmd.DEFAULT_ACRA_HOST, cmd.DEFAULT_ACRASERVER_API_PORT, ""), "Connection string for api like tcp://x.x.x.x:yyyy or unix:///path/to/socket")
+	zonesBatchMaxCount := flag.Int("http_api_zones_batch_max_count", DEFAULT_ZONES_BATCH_MAX_COUNT, "Max count of zones generated by one batch request of HTTP API")
+	zonesQuota := flag.Int("http_api_zones_quota", 0, "Max count of zones generated by one HTTP API caller (authenticated identity or source address) per http_api_zones_quota_period. 0 - unlimited")
+	zonesQuotaPeriod := flag.Int("http_api_zones_quota_period", DEFAULT_ZONES_QUOTA_PERIOD, "Time (in seconds) after which quota of generated zones of caller is restored")
+	zonesRateLimit := flag.Float64("http_api_zones_rate_limit", 0, "Max count of zone generation requests per second from one HTTP API caller. 0 - unlimited")
+	httpAPIAuthProviders := flag.String("http_api_auth_providers_config_file", "", "Path to YAML file with authentication providers (static, ldap, oidc, mtls) which HTTP API requests should pass")
 	authPath = flag.String("auth_keys", cmd.DEFAULT_ACRA_AUTH_PATH, "Path to basic auth passwords. To add user, use: `./acra-authmanager --set --user <user> --pwd <pwd>`")
 
 	useMysql := flag.Bool("mysql_enable", false, "Handle MySQL connections")
+	mysqlLocalInfile := flag.String("mysql_local_infile", mysql.LocalInfileDeny, fmt.Sprintf("Handling of LOAD DATA LOCAL INFILE: %s - forward uploaded file as is, %s - send error to client, %s - allow uploads only into tables without columns encrypted by AcraServer and limit their size with mysql_local_infile_max_size", mysql.LocalInfileAllow, mysql.LocalInfileDeny, mysql.LocalInfileScan))
+	mysqlLocalInfileMaxSize := flag.Int("mysql_local_infile_max_size", 0, "Max size (in MB) of file uploaded by LOAD DATA LOCAL INFILE in scan mode, connection is closed and statement is rolled back on exceeding. 0 - without limit")
 	usePostgresql := flag.Bool("postgresql_enable", false, "Handle Postgresql connections (default true)")
+	useCassandra := flag.Bool("cassandra_enable", false, "Handle Cassandra connections (CQL native protocol v3 and v4), AcraStructs are decrypted from blob and text columns of rows")
+	useMSSQL := flag.Bool("mssql_enable", false, "Handle Microsoft SQL Server connections (TDS protocol 7.2-7.4), AcraStructs are decrypted from varbinary and image columns of result sets. TLS is negotiated on pre-login with tls_key/tls_cert for clients and according to db_tls_mode for database")
+	useMongoDB := flag.Bool("mongodb_enable", false, "Handle MongoDB connections (OP_MSG wire protocol), AcraStructs are decrypted from whole string and binary values of documents in replies")
 	censorConfig := flag.String("acracensor_config_file", "", "Path to AcraCensor configuration file")
+	encryptorConfig := flag.String("encryptor_config_file", "", "Path to Encryptor configuration file with searchable columns which hashes will be calculated on INSERT/UPDATE queries")
+	scanConfiguredColumns := flag.Bool("acrastruct_scan_configured_columns_enable", false, "Search AcraStructs only in columns configured as encrypted or searchable in encryptor_config_file, other columns of results are returned as is (requires encryptor_config_file)")
+	consistentWrites := flag.Bool("encryptor_consistent_writes_enable", false, "Reject INSERT/UPDATE queries to tables from encryptor_config_file which AcraServer can't rewrite to write encrypted columns with their hash and index columns in the same statement, instead of sending them as is (requires encryptor_config_file)")
+	schemaConnectionString := flag.String("encryptor_schema_connection_string", "", "Connection string of database which schema (tables and types of columns) is introspected at startup and on HTTP API request to warn about columns from encryptor_config_file which don't exist or have unsuitable types (requires encryptor_config_file)")
+	passthroughTablesConfig := flag.String("passthrough_tables_config_file", "", "Path to configuration file with tables which never contain encrypted data. Queries which use only these tables are forwarded without AcraCensor checks and their results aren't decrypted")
+	queryZoneConfig := flag.String("query_zone_config_file", "", "Path to configuration file which maps values of tenant column in WHERE clause of queries to zone ids. Used to infer zone of query's result when zone ids aren't stored with data (requires zonemode_enable)")
+	queryDirectivesClientIDs := flag.String("query_directives_client_ids", "", "Comma separated list of trusted client ids which may override zone and decryption per query with SQL comments like /* acra: zone=<zone id>, skip_decrypt */ or per connection with /* acra: session_zone=<zone id> */")
 
 	verbose := flag.Bool("v", false, "Log to stderr all INFO, WARNING and ERROR logs")
 	debug := flag.Bool("d", false, "Log everything to stderr")
+	logSampleEvery := flag.Int("log_sample_every", 1, "Log only every Nth debug or info event of same category (event code or message), 1 logs all events")
+	logSampleRateLimit := flag.Int("log_sample_rate_limit", 0, "Max count of debug or info events of same category logged per second, 0 is unlimited")
+	loggingRemoteAddress := flag.String("logging_remote_address", "", "Address like tcp://host:port or udp://host:port of Graylog (with GELF logging format) or Logstash (with json logging format) to send logs to in addition to stderr")
+	loggingRemoteBufferSize := flag.Int("logging_remote_buffer_size", logging.DefaultRemoteBufferSize, "Count of log messages buffered while remote log output is unavailable, newer messages are dropped")
+	loggingEventCodesMapFile := flag.String("logging_event_codes_map_file", "", "Path to YAML file which maps Acra event codes to custom event codes of SIEM taxonomy like '584: TLS-FAILURE'. Mapped codes are used as signature ID of CEF logs and added as vendor_code field to JSON and GELF logs")
+
+	selfCheckEnable := flag.Bool("self_check_enable", false, "Run startup self-check of keystore, master key, TLS certificate, database connectivity and AcraCensor configuration, log PASS/FAIL report and exit on fatal issues")
+	selfCheckTLSExpiryWarnDays := flag.Int("self_check_tls_expiry_warning_days", 30, "Startup self-check warns about TLS certificate which expires in less than this count of days")
+	expiryCheckInterval := flag.Int("expiry_check_interval", 3600, "Interval in seconds between checks of TLS certificate and keys expiration, 0 checks only on start")
+	expiryWarningDays := flag.String("expiry_warning_days", "30,7,1", "Comma separated days before expiration of TLS certificate or key at which warning is logged")
+	keysMaxLifetimeDays := flag.Int("keys_max_lifetime_days", 0, "Days after creation (last modification for keys without stored creation time) when key without lifetime set by acra-keys should be rotated, only keys with set lifetime are checked if 0")
+	keysRefuseExpired := flag.Bool("keys_refuse_expired", false, "Reject INSERT/UPDATE queries to tables with encrypted columns if client's HMAC key expired")
 
 	err := cmd.Parse(DEFAULT_CONFIG_PATH, SERVICE_NAME)
 	if err != nil {
@@ -140,6 +244,21 @@ func main() {
 
 	// if log format was overridden
 	logging.CustomizeLogging(*loggingFormat, SERVICE_NAME)
+	logging.SetLogSampling(*logSampleEvery, *logSampleRateLimit)
+	if *loggingRemoteAddress != "" {
+		if _, err := logging.SetLogRemoteOutput(*loggingRemoteAddress, *loggingFormat, *loggingRemoteBufferSize); err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't configure remote log output")
+			os.Exit(1)
+		}
+	}
+	if *loggingEventCodesMapFile != "" {
+		if err := logging.LoadEventCodeMappingFromFile(*loggingEventCodesMapFile); err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't load mapping of event codes")
+			os.Exit(1)
+		}
+	}
 
 	log.Infof("Validating service configuration...")
 	cmd.ValidateClientID(*secureSessionID)
@@ -158,6 +277,27 @@ func main() {
 		return
 	}
 
+	if *selfCheckEnable {
+		loadMasterKey := masterKeyLoader.LoadMasterKey
+		if hsmLoader.Enabled() {
+			loadMasterKey = nil
+		}
+		report := runSelfCheck(selfCheckParams{
+			loadMasterKey:     loadMasterKey,
+			keysDir:           *keysDir,
+			keystoreType:      *keystoreType,
+			tlsCertPath:       *tlsCert,
+			tlsCAPath:         *tlsCA,
+			tlsExpiryWarnDays: *selfCheckTLSExpiryWarnDays,
+			dbHost:            *dbHost,
+			dbPort:            *dbPort,
+			censorConfigPath:  *censorConfig,
+		})
+		if !report.Passed() {
+			os.Exit(1)
+		}
+	}
+
 	if err := config.SetMySQL(*useMysql); err != nil {
 		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
 			Errorln("Can't set MySQL support")
@@ -168,6 +308,36 @@ func main() {
 			Errorln("Can't set PostgreSQL support")
 		os.Exit(1)
 	}
+	if err := config.SetMongoDB(*useMongoDB); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't set MongoDB support")
+		os.Exit(1)
+	}
+	if err := config.SetCassandra(*useCassandra); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't set Cassandra support")
+		os.Exit(1)
+	}
+	if *useCassandra && *encryptorConfig != "" {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("encryptor_config_file isn't supported with cassandra_enable")
+		os.Exit(1)
+	}
+	if err := config.SetMSSQL(*useMSSQL); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't set MSSQL support")
+		os.Exit(1)
+	}
+	if *useMSSQL && *encryptorConfig != "" {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("encryptor_config_file isn't supported with mssql_enable")
+		os.Exit(1)
+	}
+	if *useMongoDB && (*censorConfig != "" || *encryptorConfig != "") {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("acracensor_config_file and encryptor_config_file process SQL queries and aren't supported with mongodb_enable")
+		os.Exit(1)
+	}
 
 	if err := config.SetCensor(*censorConfig); err != nil {
 		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCensorSetupError).
@@ -175,6 +345,141 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := config.SetEncryptorConfig(*encryptorConfig); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorEncryptorSetupError).
+			Errorln("Can't setup encryptor")
+		os.Exit(1)
+	}
+	if *scanConfiguredColumns && *encryptorConfig == "" {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("acrastruct_scan_configured_columns_enable requires encryptor_config_file")
+		os.Exit(1)
+	}
+	config.SetScanConfiguredColumns(*scanConfiguredColumns)
+	if *consistentWrites && *encryptorConfig == "" {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("encryptor_consistent_writes_enable requires encryptor_config_file")
+		os.Exit(1)
+	}
+	config.SetConsistentWrites(*consistentWrites)
+	if *schemaConnectionString != "" && *encryptorConfig == "" {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("encryptor_schema_connection_string requires encryptor_config_file")
+		os.Exit(1)
+	}
+	config.SetSchemaConnectionString(*schemaConnectionString)
+	if *schemaConnectionString != "" {
+		go func() {
+			if warnings, err := config.ValidateEncryptorSchema(); err != nil {
+				log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorEncryptorCantReadSchema).
+					Errorln("Can't introspect database schema")
+			} else {
+				logSchemaWarnings(warnings)
+			}
+		}()
+	}
+	config.SetDBReadPipelineSize(*dbReadPipelineSize)
+	if *dbReadSpillMaxSize < 0 || (*dbReadSpillMaxSize > 0 && *dbReadPipelineSize <= 0) {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("db_read_spill_max_size can't be negative and requires positive db_read_pipeline_size")
+		os.Exit(1)
+	}
+	if *dbReadSpillMaxSize > 0 {
+		config.SetDBReadSpill(&network.SpillConfig{Directory: *dbReadSpillDir, MaxSize: int64(*dbReadSpillMaxSize) * 1024 * 1024})
+	}
+	if *statementStatsMaxCount < 0 {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("statement_stats_max_count can't be negative")
+		os.Exit(1)
+	}
+	config.SetStatementStatsMaxCount(*statementStatsMaxCount)
+	if *dbConnectRetries < 0 || *dbConnectRetryInterval < 0 {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("db_connect_retries and db_connect_retry_interval can't be negative")
+		os.Exit(1)
+	}
+	config.SetDBConnectRetries(*dbConnectRetries, time.Duration(*dbConnectRetryInterval)*time.Millisecond)
+	config.SetLengthAudit(*lengthAudit)
+	if *dlpSampleRate < 0 || *dlpSampleRate > 1 {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("dlp_sample_rate should be in range 0..1")
+		os.Exit(1)
+	}
+	if *dlpSampleRate > 0 {
+		detectors, err := base.NewDLPDetectors(*dlpDetectorNames)
+		if err != nil || len(detectors) == 0 {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("dlp_detectors should be non-empty list of known detectors")
+			os.Exit(1)
+		}
+		config.SetDLPSampler(base.NewDLPSampler(*dlpSampleRate, detectors))
+	}
+	if err := config.SetMySQLLocalInfile(*mysqlLocalInfile, int64(*mysqlLocalInfileMaxSize)*1024*1024); err != nil || *mysqlLocalInfileMaxSize < 0 {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorf("mysql_local_infile should be one of %s and mysql_local_infile_max_size can't be negative", strings.Join(mysql.LocalInfileModes, ", "))
+		os.Exit(1)
+	}
+	if *handshakeBanThreshold < 0 || *handshakeBanDuration <= 0 || *handshakeMaxBanDuration <= 0 {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("handshake_failures_ban_threshold can't be negative, handshake_ban_duration and handshake_max_ban_duration should be positive")
+		os.Exit(1)
+	}
+	if *handshakeBanThreshold > 0 {
+		config.SetHandshakeLimiter(network.NewHandshakeLimiter(*handshakeBanThreshold,
+			time.Duration(*handshakeBanDuration)*time.Second, time.Duration(*handshakeMaxBanDuration)*time.Second))
+	}
+	if *handshakeTimeout < 0 || *dbStartupTimeout < 0 {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("incoming_connection_handshake_timeout and db_startup_timeout can't be negative")
+		os.Exit(1)
+	}
+	config.SetHandshakeTimeout(time.Duration(*handshakeTimeout) * time.Second)
+	config.SetDBStartupTimeout(time.Duration(*dbStartupTimeout) * time.Second)
+	config.SetCloseConnectionTimeout(time.Duration(*closeConnectionTimeout) * time.Second)
+	if *lifecycleHookTimeout < 0 {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("lifecycle_hook_timeout can't be negative")
+		os.Exit(1)
+	}
+	lifecycle := cmd.NewLifecycle(time.Duration(*lifecycleHookTimeout) * time.Second)
+	if err := cmd.RegisterLifecycleScripts(lifecycle, *lifecycleHookScripts); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't parse lifecycle_hook_scripts")
+		os.Exit(1)
+	}
+	config.SetLifecycle(lifecycle)
+	if *zonesBatchMaxCount <= 0 || *zonesQuota < 0 || *zonesQuotaPeriod <= 0 || *zonesRateLimit < 0 {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("http_api_zones_batch_max_count and http_api_zones_quota_period should be positive, http_api_zones_quota and http_api_zones_rate_limit can't be negative")
+		os.Exit(1)
+	}
+	config.SetZonesBatchMaxCount(*zonesBatchMaxCount)
+	if *zonesQuota > 0 || *zonesRateLimit > 0 {
+		config.SetZoneGenerationQuota(zone.NewGenerationQuota(*zonesQuota, time.Duration(*zonesQuotaPeriod)*time.Second, *zonesRateLimit))
+	}
+	if err := config.SetIPFilterConfig(*ipFilterConfig, time.Duration(*ipFilterReloadInterval)*time.Second); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't load ip filter config")
+		os.Exit(1)
+	}
+
+	if *queryZoneConfig != "" && !*withZone {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("query_zone_config_file requires zonemode_enable")
+		os.Exit(1)
+	}
+	if err := config.SetQueryZoneConfig(*queryZoneConfig); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't load query zone config")
+		os.Exit(1)
+	}
+	config.SetQueryDirectivesClientIDs(*queryDirectivesClientIDs)
+	if err := config.SetPassthroughTablesConfig(*passthroughTablesConfig); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't load passthrough tables config")
+		os.Exit(1)
+	}
+
 	// now it's stub as default values
 	config.SetDetectPoisonRecords(*detectPoisonRecords)
 	config.SetStopOnPoison(*stopOnPoison)
@@ -195,6 +500,82 @@ func main() {
 	config.SetEnableHTTPAPI(*enableHTTPAPI)
 	config.SetConfigPath(DEFAULT_CONFIG_PATH)
 	config.SetDebug(*debug)
+	if *gomaxprocs > 0 {
+		runtime.GOMAXPROCS(*gomaxprocs)
+	}
+	if *cpuAffinity != "" {
+		cpus, err := cmd.ParseCPUList(*cpuAffinity)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongParam).
+				Errorln("Can't parse cpu_affinity")
+			os.Exit(1)
+		}
+		if err := cmd.SetProcessAffinity(cpus); err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongParam).
+				Errorln("Can't set CPU affinity of process")
+			os.Exit(1)
+		}
+	}
+	for _, affinity := range []struct {
+		name   string
+		value  string
+		setter func([]int)
+	}{
+		{"incoming_connection_cpu_affinity", *connectionCPUAffinity, config.SetConnectionCPUs},
+		{"incoming_connection_api_cpu_affinity", *apiConnectionCPUAffinity, config.SetAPIConnectionCPUs},
+	} {
+		if affinity.value == "" {
+			continue
+		}
+		cpus, err := cmd.ParseCPUList(affinity.value)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongParam).
+				Errorf("Can't parse %s", affinity.name)
+			os.Exit(1)
+		}
+		affinity.setter(cpus)
+	}
+	// data connection is served by 2 workers which proxy requests and responses
+	if *connectionWorkers < 2 || *apiConnectionWorkers < 1 {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongParam).
+			Errorln("incoming_connection_workers should be at least 2 and incoming_connection_api_workers at least 1")
+		os.Exit(1)
+	}
+	config.SetConnectionWorkers(*connectionWorkers)
+	config.SetAPIConnectionWorkers(*apiConnectionWorkers)
+	cmd.LogTopologyReport()
+	if *maxConcurrentDecryptions > 0 {
+		base.SetDecryptionLimiter(base.NewDecryptionLimiter(*maxConcurrentDecryptions, *decryptionQueueSize, time.Duration(*decryptionQueueTimeout)*time.Millisecond))
+	}
+	if *decryptionLatencyBudget < 0 || *decryptionLatencyBudgetCooldown <= 0 || (*decryptionLatencyBudget > 0 && *maxConcurrentDecryptions <= 0) {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("decryption_latency_budget can't be negative and requires max_concurrent_decryptions, decryption_latency_budget_cooldown should be positive")
+		os.Exit(1)
+	}
+	if *decryptionLatencyBudget > 0 {
+		latencyBudget, err := base.NewLatencyBudget(time.Duration(*decryptionLatencyBudget)*time.Millisecond,
+			time.Duration(*decryptionLatencyBudgetCooldown)*time.Millisecond, *decryptionLatencyBudgetColumns)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("decryption_latency_budget_columns should be non-empty list of columns")
+			os.Exit(1)
+		}
+		base.SetLatencyBudget(latencyBudget)
+	}
+	if *poisonContainmentPeriod < 0 || (*poisonContainmentPeriod > 0 && !*detectPoisonRecords) {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("poison_containment_period can't be negative and requires poison_detect_enable")
+		os.Exit(1)
+	}
+	if *poisonContainmentPeriod > 0 {
+		base.SetPoisonContainment(base.NewPoisonContainment(time.Duration(*poisonContainmentPeriod) * time.Second))
+	}
+
+	if err := zoneIDGeneratorLoader.SetIDGenerator(); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't set format of zone ids")
+		os.Exit(1)
+	}
 
 	if *pgHexFormat || !*pgEscapeFormat {
 		config.SetByteaFormat(HEX_BYTEA_FORMAT)
@@ -203,33 +584,140 @@ func main() {
 	}
 
 	log.Infof("Initialising keystore...")
-	masterKey, err := keystore.GetMasterKeyFromEnvironment()
+	keyEncryptor, err := hsmLoader.NewKeyEncryptor(masterKeyLoader.LoadMasterKey)
 	if err != nil {
-		log.WithError(err).Errorln("can't load master key")
+		log.WithError(err).Errorln("can't init encryptor of keys")
 		os.Exit(1)
 	}
-	scellEncryptor, err := keystore.NewSCellKeyEncryptor(masterKey)
+	backendOptions, err := keystore.ParseBackendOptions(*keystoreOptions)
 	if err != nil {
-		log.WithError(err).Errorln("can't init scell encryptor")
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't parse keystore_options")
 		os.Exit(1)
 	}
-	keyStore, err := filesystem.NewFileSystemKeyStoreWithCacheSize(*keysDir, scellEncryptor, *keysCacheSize)
+	keyStore, err := keystore.NewBackend(*keystoreType, keystore.BackendParams{
+		PrivateKeysDir:    *keysDir,
+		Encryptor:         keyEncryptor,
+		CacheSize:         *keysCacheSize,
+		EphemeralCacheKey: *keysCacheEphemeralKey,
+		Options:           backendOptions,
+	})
 	if err != nil {
 		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantInitKeyStore).
 			Errorln("Can't initialise keystore")
 		os.Exit(1)
 	}
 	log.Infof("Keystore init OK")
+	if *keysCacheWatchInterval > 0 && *keysCacheSize != keystore.NO_CACHE {
+		filesystemKeyStore, ok := keyStore.(*filesystem.FilesystemKeyStore)
+		if !ok {
+			log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorf("keystore_cache_watch_interval isn't supported by keystore_type %s", *keystoreType)
+			os.Exit(1)
+		}
+		cacheWatcher, err := filesystem.NewCacheWatcher(filesystemKeyStore)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantReadKeys).
+				Errorln("Can't read keys to watch changes")
+			os.Exit(1)
+		}
+		cacheWatcher.Start(time.Duration(*keysCacheWatchInterval) * time.Second)
+	}
+	// cache is shared with active/standby AcraServer before keystore wrapped with revocation list and audit
+	cacheWarmer, _ := keyStore.(keystore.CacheWarmer)
+	config.SetKeyCacheWarmer(cacheWarmer)
+	if *revocationListFile != "" {
+		publicKey, err := utils.LoadPublicKey(*revocationListPublicKey)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't load revocation_list_public_key")
+			os.Exit(1)
+		}
+		revocationList, err := keystore.NewRevocationListFromFile(*revocationListFile, publicKey, time.Duration(*revocationListReloadInterval)*time.Second)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't load revocation list")
+			os.Exit(1)
+		}
+		keyStore = keystore.NewRevocationKeyStore(keyStore, revocationList)
+		log.Infof("Revocation list loaded")
+	}
+	if *keystoreAuditLogPath != "" {
+		auditLog, err := keystore.NewKeyAccessAuditLog(*keystoreAuditLogPath)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't open keystore_audit_log_path")
+			os.Exit(1)
+		}
+		keyStore = keystore.NewAuditKeyStore(keyStore, auditLog)
+		log.Infof("Key access audit log enabled")
+	}
+
+	warningDays, err := cmd.ParseExpiryWarningDays(*expiryWarningDays)
+	if err != nil || *expiryCheckInterval < 0 || *keysMaxLifetimeDays < 0 {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("expiry_warning_days should be list of days like 30,7,1, expiry_check_interval and keys_max_lifetime_days can't be negative")
+		os.Exit(1)
+	}
+	var expiryMonitor *cmd.ExpiryMonitor
+	keyLister, _ := keyStore.(keystore.KeyLister)
+	if *tlsCert != "" || keyLister != nil {
+		var certificates []string
+		if *tlsCert != "" {
+			certificates = append(certificates, *tlsCert)
+		}
+		expiryMonitor = cmd.NewExpiryMonitor(certificates, keyLister, time.Duration(*keysMaxLifetimeDays)*24*time.Hour, warningDays)
+		expiryMonitor.Start(time.Duration(*expiryCheckInterval) * time.Second)
+	}
+	if *keysRefuseExpired && keyLister == nil {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("keys_refuse_expired requires keystore which lists keys")
+		os.Exit(1)
+	}
+	config.SetExpiryMonitor(expiryMonitor)
+	config.SetRefuseExpiredKeys(*keysRefuseExpired)
 
 	log.Infof("Configuring transport...")
+	pgDBTLSMode, err := postgresql.ParseDBTLSMode(*dbTLSMode)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't parse db_tls_mode")
+		os.Exit(1)
+	}
+	if pgDBTLSMode.Negotiated() && !config.UsePostgreSQL() && !config.UseMSSQL() {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("db_tls_mode is supported only with PostgreSQL and MSSQL")
+		os.Exit(1)
+	}
+	config.SetDBTLSMode(pgDBTLSMode)
 	var tlsConfig *tls.Config
-	if *useTLS || *tlsKey != "" {
+	if *useTLS || *tlsKey != "" || pgDBTLSMode.RequiresTLS() {
 		tlsConfig, err = network.NewTLSConfig(network.SNIOrHostname(*tlsDbSNI, *dbHost), *tlsCA, *tlsKey, *tlsCert, tls.ClientAuthType(*tlsAuthType))
 		if err != nil {
 			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTransportConfiguration).
 				Errorln("Configuration error: can't get config for TLS")
 			os.Exit(1)
 		}
+		tlsConfig.CipherSuites, err = network.ParseCipherSuites(*tlsCipherSuites)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't parse tls_cipher_suites")
+			os.Exit(1)
+		}
+		if *tlsCertificateReload {
+			if *tlsCert == "" || *tlsKey == "" {
+				log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+					Errorln("tls_certificate_reload_enable requires tls_cert and tls_key")
+				os.Exit(1)
+			}
+			reloader, err := network.NewCertificateReloader(*tlsCert, *tlsKey)
+			if err != nil {
+				log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTransportConfiguration).
+					Errorln("Configuration error: can't load TLS certificate")
+				os.Exit(1)
+			}
+			reloader.Apply(tlsConfig)
+		}
 		// need for testing with mysql docker container that always generate new certificates
 		if TestOnly == TEST_MODE {
 			tlsConfig.InsecureSkipVerify = true
@@ -238,7 +726,64 @@ func main() {
 		}
 	}
 	config.SetTLSConfig(tlsConfig)
-	if *useTLS {
+	if *dbRequireSSL && tlsConfig == nil {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("db_require_ssl requires TLS configuration with tls_key and tls_cert")
+		os.Exit(1)
+	}
+	if *dbRequireSSL && pgDBTLSMode == postgresql.DBTLSModeDisable {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("db_require_ssl can't be used with db_tls_mode=disable")
+		os.Exit(1)
+	}
+	config.SetDBRequireSSL(*dbRequireSSL)
+	if *tlsDbClientCertificates != "" && tlsConfig == nil {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("tls_db_client_certificates_config_file requires TLS configuration with tls_key and tls_cert")
+		os.Exit(1)
+	}
+	if err := config.SetDBClientCertificatesConfig(*tlsDbClientCertificates); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't load client certificates for TLS connections to database")
+		os.Exit(1)
+	}
+	if err := config.SetHTTPAPIAuthProvidersConfig(*httpAPIAuthProviders); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't load authentication providers for HTTP API")
+		os.Exit(1)
+	}
+	if *transportNegotiationRaw && *clientID == "" && !*withZone {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTransportConfiguration).
+			Errorln("Configuration error: without zone mode you must set <client_id> to negotiate raw transport")
+		os.Exit(1)
+	}
+	if *useSecureComparator {
+		if *transportNegotiation {
+			log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("acraconnector_secure_comparator_enable can't be used with acraconnector_transport_negotiation_enable")
+			os.Exit(1)
+		}
+		if !*useTLS {
+			log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Configuration error: secure comparator doesn't encrypt data, you must set <acraconnector_tls_transport_enable> to use it")
+			os.Exit(1)
+		}
+		log.Infof("Selecting transport: use secure comparator authentication over TLS")
+		config.ConnectionWrapper, err = NewSecureComparatorConnectionWrapper(keyStore, tlsConfig)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTransportConfiguration).
+				Errorln("Configuration error: can't initialise secure comparator connection wrapper")
+			os.Exit(1)
+		}
+	} else if *transportNegotiation {
+		log.Infof("Selecting transport: use transport negotiation")
+		config.ConnectionWrapper, err = NewNegotiationConnectionWrapper(keyStore, tlsConfig, []byte(*clientID), *transportNegotiationRaw)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTransportConfiguration).
+				Errorln("Configuration error: can't initialise transport negotiation")
+			os.Exit(1)
+		}
+	} else if *useTLS {
 		log.Println("Selecting transport: use TLS transport wrapper")
 		config.ConnectionWrapper, err = network.NewTLSConnectionWrapper([]byte(*clientID), tlsConfig)
 		if err != nil {
@@ -263,6 +808,79 @@ func main() {
 			os.Exit(1)
 		}
 	}
+	transportListeners, err := ParseTransportListeners(*transportListenersString)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't parse incoming_connection_transport_listeners")
+		os.Exit(1)
+	}
+	for _, transportListener := range transportListeners {
+		switch transportListener.Transport {
+		case TransportTLS:
+			if tlsConfig == nil {
+				log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTransportConfiguration).
+					Errorln("Configuration error: you must set <tls_key> and <tls_cert> to use TLS transport listener")
+				os.Exit(1)
+			}
+			transportListener.ConnectionWrapper, err = network.NewTLSConnectionWrapper([]byte(*clientID), tlsConfig)
+		case TransportRaw:
+			if *clientID == "" && !*withZone {
+				log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTransportConfiguration).
+					Errorln("Configuration error: without zone mode you must set <client_id> to use raw transport listener")
+				os.Exit(1)
+			}
+			transportListener.ConnectionWrapper = &network.RawConnectionWrapper{ClientID: []byte(*clientID)}
+		case TransportSecureSession:
+			transportListener.ConnectionWrapper, err = network.NewSecureSessionConnectionWrapper(keyStore)
+		case TransportAuto:
+			transportListener.ConnectionWrapper, err = NewNegotiationConnectionWrapper(keyStore, tlsConfig, []byte(*clientID), *transportNegotiationRaw)
+		case TransportSecureComparator:
+			if tlsConfig == nil {
+				log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTransportConfiguration).
+					Errorln("Configuration error: you must set <tls_key> and <tls_cert> to use secure comparator transport listener")
+				os.Exit(1)
+			}
+			transportListener.ConnectionWrapper, err = NewSecureComparatorConnectionWrapper(keyStore, tlsConfig)
+		}
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTransportConfiguration).
+				Errorf("Configuration error: can't initialise connection wrapper for %s transport listener", transportListener.Transport)
+			os.Exit(1)
+		}
+		log.Infof("Selecting transport: use %s transport wrapper for %s", transportListener.Transport, transportListener.ConnectionString)
+	}
+	config.SetTransportListeners(transportListeners)
+
+	if *standbyActiveAPIURL != "" {
+		if *standbySyncInterval <= 0 {
+			log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("standby_sync_interval should be positive")
+			os.Exit(1)
+		}
+		// state of active AcraServer contains bans of handshakes, so it's accepted only from authenticated peer
+		if *noEncryptionTransport {
+			log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Configuration error: warm standby can't authenticate active AcraServer with acraconnector_transport_encryption_disable")
+			os.Exit(1)
+		}
+		cmd.ValidateClientID(*standbyClientID)
+		if *standbyKeysDir == "" {
+			*standbyKeysDir = *keysDir
+		}
+		var standbyTLSConfig *tls.Config
+		if *useTLS {
+			standbyTLSConfig = tlsConfig
+		}
+		standbyWrapper, err := NewStandbyConnectionWrapper(*standbyActiveAPIURL, []byte(*standbyClientID), *standbyKeysDir, keyEncryptor, standbyTLSConfig)
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTransportConfiguration).
+				Errorln("Configuration error: can't initialise connection wrapper of warm standby")
+			os.Exit(1)
+		}
+		standbySync := cmd.NewStandbySync(*standbyActiveAPIURL, []byte(*standbyClientID), standbyWrapper, cacheWarmer, config.GetHandshakeLimiter())
+		standbySync.Start(time.Duration(*standbySyncInterval) * time.Second)
+		log.Infof("Started sync of warm standby with active AcraServer")
+	}
 
 	log.Debugf("Registering process signal handlers")
 	sigHandlerSIGTERM, err := cmd.NewSignalHandler([]os.Signal{os.Interrupt, syscall.SIGTERM})
@@ -309,37 +927,54 @@ func main() {
 	}
 
 	if *prometheusAddress != "" {
+		prometheus.MustRegister(newDrainCollector(server))
+		if server.statementStats != nil {
+			prometheus.MustRegister(server.statementStats)
+		}
+		if expiryMonitor != nil {
+			prometheus.MustRegister(expiryMonitor)
+		}
 		prometheusListener, err := cmd.RunPrometheusHTTPHandler(*prometheusAddress)
 		if err != nil {
 			panic(err)
 		}
 		log.Infof("Configured to send metrics and stats to `prometheus_metrics_address`")
+		// forked process listens same address so close listener on restart. On shutdown keep it to export drain
+		// metrics until process exits
 		sigHandlerSIGHUP.AddListener(prometheusListener)
-		sigHandlerSIGTERM.AddListener(prometheusListener)
 	}
 
 	go sigHandlerSIGTERM.Register()
 	sigHandlerSIGTERM.AddCallback(func() {
 		log.Infof("Received incoming SIGTERM or SIGINT signal")
+		lifecycle.Run(cmd.LifecyclePreDrain)
 		log.Debugf("Stop accepting new connections, waiting until current connections close")
+		server.StartDrain()
 		// Stop accepting new connections
 		server.StopListeners()
 		// Wait a maximum of N seconds for existing connections to finish
 		err := server.WaitWithTimeout(time.Duration(*closeConnectionTimeout) * time.Second)
 		if err == ErrWaitTimeout {
 			log.Warningf("Server shutdown Timeout: %d active connections will be cut", server.ConnectionsCounter())
+			server.CancelConnections()
+			// let canceled connections close connections to database
+			server.WaitWithTimeout(canceledConnectionsCloseTimeout)
 			server.Close()
+			lifecycle.Run(cmd.LifecyclePostShutdown)
 			os.Exit(1)
 		}
 		server.Close()
+		lifecycle.Run(cmd.LifecyclePostShutdown)
 		log.Infof("Server graceful shutdown completed, bye PID: %v", os.Getpid())
 		os.Exit(0)
 	})
 
 	sigHandlerSIGHUP.AddCallback(func() {
 		log.Infof("Received incoming SIGHUP signal")
+		lifecycle.Run(cmd.LifecyclePreDrain)
 		log.Debugf("Stop accepting new connections, waiting until current connections close")
 
+		server.StartDrain()
 		// Stop accepting requests
 		server.StopListeners()
 
@@ -358,11 +993,18 @@ func main() {
 			}
 		}
 
+		var fdTransportListeners []uintptr
+		fdTransportListeners, err = server.TransportListenersFileDescriptors()
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantGetFileDescriptor).
+				Fatalln("System error: failed to get transport listener socket file descriptor:", err)
+		}
+
 		// Set env flag for forked process
 		os.Setenv(GRACEFUL_ENV, "true")
 		execSpec := &syscall.ProcAttr{
 			Env:   os.Environ(),
-			Files: []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd(), fdACRA, fdAPI},
+			Files: append([]uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd(), fdACRA, fdAPI}, fdTransportListeners...),
 		}
 
 		log.Debugf("Forking new process of %s", SERVICE_NAME)
@@ -375,15 +1017,24 @@ func main() {
 		}
 		log.Infof("%s process forked to PID: %v", SERVICE_NAME, fork)
 
+		// Only listeners are handed over to the forked process. Active connections stay in this process because their
+		// state can't be transferred: themis doesn't export state of established Secure Session, crypto/tls doesn't
+		// serialize state of TLS connections and database proxies keep buffered data and protocol state (prepared
+		// statements, result formats, pending responses) between packets.
 		// Wait a maximum of N seconds for existing connections to finish
 		err = server.WaitWithTimeout(time.Duration(*closeConnectionTimeout) * time.Second)
 		if err == ErrWaitTimeout {
 			log.Warningf("Server shutdown Timeout: %d active connections will be cut", server.ConnectionsCounter())
+			server.CancelConnections()
+			// let canceled connections close connections to database
+			server.WaitWithTimeout(canceledConnectionsCloseTimeout)
+			lifecycle.Run(cmd.LifecyclePostShutdown)
 			os.Exit(0)
 		}
 		log.Infof("Server graceful restart completed, bye PID: %v", os.Getpid())
 
 		// Stop the old server, all the connections have been closed and the new one is running
+		lifecycle.Run(cmd.LifecyclePostShutdown)
 		os.Exit(0)
 	})
 
@@ -400,6 +1051,14 @@ func main() {
 		logging.SetLogLevel(logging.LOG_DISCARD)
 	}
 
+	if err := lifecycle.Run(cmd.LifecyclePreListen); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantStartService).
+			Errorln("Can't start listening connections: pre-listen lifecycle hook failed")
+		os.Exit(1)
+	}
+	for i := range transportListeners {
+		go server.StartTransportListener(i, os.Getenv(GRACEFUL_ENV) == "true")
+	}
 	if os.Getenv(GRACEFUL_ENV) == "true" {
 		if *withZone || *enableHTTPAPI {
 			go server.StartCommandsFromFileDescriptor(DESCRIPTOR_API)
@@ -0,0 +1,189 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/cossacklabs/acra/api"
+	"github.com/cossacklabs/acra/cmd"
+	"github.com/cossacklabs/acra/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// apiV1Endpoint handles requests to one path of HTTP API v1 with allowed method
+type apiV1Endpoint struct {
+	method  string
+	handler func(clientSession *ClientCommandsSession, req *http.Request) *http.Response
+}
+
+var apiV1Endpoints = map[string][]apiV1Endpoint{
+	api.PathOpenAPI: {{http.MethodGet, func(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+		return apiV1Response(req, http.StatusOK, "application/yaml", []byte(api.OpenAPIDocument))
+	}}},
+	api.PathZones: {
+		{http.MethodPost, createZoneV1},
+		{http.MethodGet, listZonesV1},
+	},
+	api.PathZonesBatch:     {{http.MethodPost, createZonesBatchV1}},
+	api.PathZoneRotate:     {{http.MethodPost, rotateZoneKeyV1}},
+	api.PathKeys:           {{http.MethodGet, listKeysV1}},
+	api.PathKeyExpirations: {{http.MethodGet, listKeyExpirationsV1}},
+	api.PathPublicKey:      {{http.MethodGet, getPublicKeyV1}},
+	api.PathKeystoreReset: {{http.MethodPost, func(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+		clientSession.resetKeyStorage()
+		return apiV1Response(req, http.StatusNoContent, "", nil)
+	}}},
+	api.PathAuthData: {{http.MethodGet, func(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+		authData, err := clientSession.loadAuthData()
+		if err != nil {
+			return apiV1Error(req, http.StatusInternalServerError, "can't load auth data")
+		}
+		return apiV1Response(req, http.StatusOK, "text/plain", authData)
+	}}},
+	api.PathConfig: {
+		{http.MethodGet, func(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+			config, err := clientSession.getConfig()
+			if err != nil {
+				return apiV1Error(req, http.StatusInternalServerError, "can't encode config")
+			}
+			return apiV1Response(req, http.StatusOK, "application/json", config)
+		}},
+		{http.MethodPut, func(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+			if req.Body == nil {
+				return apiV1Error(req, http.StatusBadRequest, "expected config in request body")
+			}
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil || !json.Valid(body) {
+				return apiV1Error(req, http.StatusBadRequest, "expected config in JSON")
+			}
+			if err := clientSession.setConfig(bytes.NewReader(body)); err != nil {
+				return apiV1Error(req, http.StatusInternalServerError, "can't save config")
+			}
+			return apiV1Response(req, http.StatusAccepted, "", nil)
+		}},
+	},
+	api.PathConnections: {{http.MethodGet, func(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+		connections, err := clientSession.getConnections()
+		if err != nil {
+			return apiV1Error(req, http.StatusInternalServerError, "can't encode connections")
+		}
+		return apiV1Response(req, http.StatusOK, "application/json", connections)
+	}}, {http.MethodDelete, terminateConnectionV1}},
+	api.PathConnectionZone: {
+		{http.MethodPut, setConnectionZoneV1},
+		{http.MethodDelete, removeConnectionZoneV1},
+	},
+	api.PathPayloadStats: {{http.MethodGet, func(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+		stats, err := clientSession.getPayloadStats()
+		if err != nil {
+			return apiV1Error(req, http.StatusInternalServerError, "can't encode payload stats")
+		}
+		return apiV1Response(req, http.StatusOK, "application/json", stats)
+	}}},
+	api.PathStatementStats: {
+		{http.MethodGet, func(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+			stats, err := clientSession.getStatementStats()
+			if err == ErrStatementStatsDisabled {
+				return apiV1Error(req, http.StatusConflict, "statement stats are turned off by statement_stats_max_count")
+			}
+			if err != nil {
+				return apiV1Error(req, http.StatusInternalServerError, "can't encode statement stats")
+			}
+			return apiV1Response(req, http.StatusOK, "application/json", stats)
+		}},
+		{http.MethodDelete, func(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+			if err := clientSession.Server.ResetStatementStats(); err != nil {
+				return apiV1Error(req, http.StatusConflict, "statement stats are turned off by statement_stats_max_count")
+			}
+			return apiV1Response(req, http.StatusNoContent, "", nil)
+		}},
+	},
+	api.PathLogLevels: {
+		{http.MethodGet, getLogLevelsV1},
+		{http.MethodPut, setLogLevelV1},
+		{http.MethodDelete, removeLogLevelV1},
+	},
+	api.PathSchemaValidate: {{http.MethodPost, validateSchemaV1}},
+	api.PathStandbyState: {{http.MethodGet, func(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+		config := clientSession.config
+		return apiV1JSON(req, cmd.NewStandbyState(config.GetKeyCacheWarmer(), config.GetHandshakeLimiter()))
+	}}},
+	api.PathDrain: {{http.MethodPost, func(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+		clientSession.drain()
+		return apiV1Response(req, http.StatusAccepted, "", nil)
+	}}},
+}
+
+// apiV1Response returns response with body of contentType, connection is closed after response
+func apiV1Response(req *http.Request, status int, contentType string, body []byte) *http.Response {
+	response := &http.Response{
+		StatusCode:    status,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Request:       req,
+		Header:        http.Header{},
+		Close:         true,
+		ContentLength: int64(len(body)),
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+	}
+	if contentType != "" {
+		response.Header.Set("Content-Type", contentType)
+	}
+	return response
+}
+
+// apiV1Error returns response with status and api.Error in body
+func apiV1Error(req *http.Request, status int, message string) *http.Response {
+	body, _ := json.Marshal(api.Error{Error: message})
+	return apiV1Response(req, status, "application/json", body)
+}
+
+// routeV1Request returns response of endpoint which handles request
+func (clientSession *ClientCommandsSession) routeV1Request(req *http.Request) *http.Response {
+	endpoints, ok := apiV1Endpoints[req.URL.Path]
+	if !ok {
+		return apiV1Error(req, http.StatusNotFound, "unknown endpoint")
+	}
+	allowed := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		if endpoint.method == req.Method {
+			return endpoint.handler(clientSession, req)
+		}
+		allowed = append(allowed, endpoint.method)
+	}
+	response := apiV1Error(req, http.StatusMethodNotAllowed, "method not allowed")
+	for _, method := range allowed {
+		response.Header.Add("Allow", method)
+	}
+	return response
+}
+
+// handleV1Request handles request to HTTP API v1 and returns serialized response
+func (clientSession *ClientCommandsSession) handleV1Request(req *http.Request) string {
+	log.Debugf("Got %s %s request", req.Method, req.URL.Path)
+	response := clientSession.routeV1Request(req)
+	output := &bytes.Buffer{}
+	if err := response.Write(output); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorGeneral).Errorln("Can't serialize API response")
+		return Response500Error
+	}
+	return output.String()
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/cossacklabs/acra/api"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/zone"
+	log "github.com/sirupsen/logrus"
+)
+
+// updateConnectionZone sets or resets (if zoneID is nil) zone of connection's session and returns error response if
+// it can't be changed
+func updateConnectionZone(clientSession *ClientCommandsSession, req *http.Request, connectionID uint64, zoneID []byte) *http.Response {
+	if !clientSession.config.GetWithZone() {
+		return apiV1Error(req, http.StatusConflict, "zones are turned off by zonemode_enable")
+	}
+	if err := clientSession.Server.SetConnectionZone(connectionID, zoneID); err == ErrConnectionNotFound {
+		return apiV1Error(req, http.StatusNotFound, "connection not found")
+	}
+	log.WithFields(log.Fields{"connection_id": connectionID, "zone_id": string(zoneID)}).Infoln("Zone of connection changed")
+	return apiV1Response(req, http.StatusNoContent, "", nil)
+}
+
+func setConnectionZoneV1(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+	if req.Body == nil {
+		return apiV1Error(req, http.StatusBadRequest, "expected connection zone in request body")
+	}
+	var connectionZone api.ConnectionZone
+	if err := json.NewDecoder(req.Body).Decode(&connectionZone); err != nil {
+		return apiV1Error(req, http.StatusBadRequest, "expected connection zone in JSON")
+	}
+	if !zone.ValidateZoneID([]byte(connectionZone.ZoneID)) {
+		return apiV1Error(req, http.StatusBadRequest, "invalid zone id")
+	}
+	return updateConnectionZone(clientSession, req, connectionZone.ConnectionID, []byte(connectionZone.ZoneID))
+}
+
+func removeConnectionZoneV1(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+	connectionID, err := strconv.ParseUint(req.URL.Query().Get("connection_id"), 10, 64)
+	if err != nil {
+		return apiV1Error(req, http.StatusBadRequest, "expected connection_id")
+	}
+	return updateConnectionZone(clientSession, req, connectionID, nil)
+}
+
+// terminateConnectionV1 cancels context of active connection, so connection to client and database is closed and
+// pending decryptions are stopped
+func terminateConnectionV1(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+	connectionID, err := strconv.ParseUint(req.URL.Query().Get("connection_id"), 10, 64)
+	if err != nil {
+		return apiV1Error(req, http.StatusBadRequest, "expected connection_id")
+	}
+	if err := clientSession.Server.CancelConnection(connectionID); err == ErrConnectionNotFound {
+		return apiV1Error(req, http.StatusNotFound, "connection not found")
+	}
+	log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeConnectionTerminated, "connection_id": connectionID}).
+		Warningln("Connection terminated with HTTP API")
+	return apiV1Response(req, http.StatusNoContent, "", nil)
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cossacklabs/acra/api"
+	"github.com/cossacklabs/acra/cmd"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/zone"
+	"github.com/cossacklabs/themis/gothemis/keys"
+	log "github.com/sirupsen/logrus"
+)
+
+// listKeys returns keys from keystore or error response if keystore can't list keys
+func (clientSession *ClientCommandsSession) listKeys(req *http.Request) ([]keystore.KeyInfo, *http.Response) {
+	lister, ok := clientSession.keystorage.(keystore.KeyLister)
+	if !ok {
+		return nil, apiV1Error(req, http.StatusNotImplemented, "keystore doesn't support listing of keys")
+	}
+	keyList, err := lister.ListKeys()
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorGeneral).Errorln("Can't list keys")
+		return nil, apiV1Error(req, http.StatusInternalServerError, "can't list keys")
+	}
+	return keyList, nil
+}
+
+func ageSeconds(modifiedAt time.Time) int64 {
+	return int64(time.Since(modifiedAt) / time.Second)
+}
+
+func apiV1JSON(req *http.Request, value interface{}) *http.Response {
+	body, err := json.Marshal(value)
+	if err != nil {
+		return apiV1Error(req, http.StatusInternalServerError, "can't encode response")
+	}
+	return apiV1Response(req, http.StatusOK, "application/json", body)
+}
+
+func listZonesV1(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+	keyList, errResponse := clientSession.listKeys(req)
+	if errResponse != nil {
+		return errResponse
+	}
+	zones := make([]api.ZoneKey, 0)
+	for _, key := range keyList {
+		if key.Purpose == keystore.KeyPurposeZone && key.Public {
+			zones = append(zones, api.ZoneKey{ID: key.ID, Fingerprint: key.Fingerprint, ModifiedAt: key.ModifiedAt, AgeSeconds: ageSeconds(key.ModifiedAt)})
+		}
+	}
+	return apiV1JSON(req, zones)
+}
+
+func listKeysV1(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+	keyList, errResponse := clientSession.listKeys(req)
+	if errResponse != nil {
+		return errResponse
+	}
+	result := make([]api.Key, 0, len(keyList))
+	for _, key := range keyList {
+		result = append(result, cmd.NewAPIKey(key))
+	}
+	return apiV1JSON(req, result)
+}
+
+// defaultKeyExpirationsWithinDays is count of days ahead in which expirations are listed if within_days isn't passed
+const defaultKeyExpirationsWithinDays = 30
+
+// listKeyExpirationsV1 returns expired keys and keys which expire in within_days days ordered by expiration
+func listKeyExpirationsV1(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+	withinDays := defaultKeyExpirationsWithinDays
+	if value := req.URL.Query().Get("within_days"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 0 {
+			return apiV1Error(req, http.StatusBadRequest, "within_days should be non-negative count of days")
+		}
+		withinDays = days
+	}
+	monitor := clientSession.config.GetExpiryMonitor()
+	if monitor == nil {
+		return apiV1JSON(req, []api.KeyExpiration{})
+	}
+	return apiV1JSON(req, monitor.KeyExpirations(withinDays))
+}
+
+func getPublicKeyV1(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+	lister, ok := clientSession.keystorage.(keystore.KeyLister)
+	if !ok {
+		return apiV1Error(req, http.StatusNotImplemented, "keystore doesn't support listing of keys")
+	}
+	publicKey, err := lister.GetPublicKeyByName(req.URL.Query().Get("name"))
+	switch {
+	case err == keystore.ErrInvalidKeyName:
+		return apiV1Error(req, http.StatusBadRequest, err.Error())
+	case os.IsNotExist(err):
+		return apiV1Error(req, http.StatusNotFound, "public key not found")
+	case err != nil:
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorGeneral).Errorln("Can't read public key")
+		return apiV1Error(req, http.StatusInternalServerError, "can't read public key")
+	}
+	return apiV1Response(req, http.StatusOK, "application/octet-stream", publicKey)
+}
+
+func rotateZoneKeyV1(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+	zoneID := []byte(req.URL.Query().Get("zone_id"))
+	if !keystore.ValidateID(zoneID) {
+		return apiV1Error(req, http.StatusBadRequest, "invalid zone id")
+	}
+	if !clientSession.keystorage.HasZonePrivateKey(zoneID) {
+		return apiV1Error(req, http.StatusNotFound, "zone not found")
+	}
+	publicKey, err := clientSession.keystorage.RotateZoneKey(zoneID)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantGenerateZone).Errorln("Can't rotate zone key")
+		return apiV1Error(req, http.StatusInternalServerError, "can't rotate zone key")
+	}
+	log.WithField("zone_id", string(zoneID)).Warningln("Zone key rotated, data encrypted with previous key should be re-encrypted with acra-rotate")
+	zoneData, err := zone.ZoneDataToJSON(zoneID, &keys.PublicKey{Value: publicKey})
+	if err != nil {
+		return apiV1Error(req, http.StatusInternalServerError, "can't encode zone")
+	}
+	return apiV1Response(req, http.StatusOK, "application/json", zoneData)
+}
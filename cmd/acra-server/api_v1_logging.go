@@ -0,0 +1,92 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/cossacklabs/acra/api"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+func getLogLevelsV1(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+	clients, ips := logging.GetLogLevelOverrides()
+	result := api.LogLevels{Clients: make(map[string]string, len(clients)), Addresses: make(map[string]string, len(ips))}
+	for clientID, level := range clients {
+		result.Clients[clientID] = level.String()
+	}
+	for ip, level := range ips {
+		result.Addresses[ip] = level.String()
+	}
+	return apiV1JSON(req, result)
+}
+
+// validateLogLevelTarget returns error response if exactly one of valid client id or ip address isn't passed
+func validateLogLevelTarget(req *http.Request, clientID, address string) *http.Response {
+	if (clientID == "") == (address == "") {
+		return apiV1Error(req, http.StatusBadRequest, "expected client_id or address")
+	}
+	if clientID != "" && !keystore.ValidateID([]byte(clientID)) {
+		return apiV1Error(req, http.StatusBadRequest, "invalid client id")
+	}
+	if address != "" && net.ParseIP(address) == nil {
+		return apiV1Error(req, http.StatusBadRequest, "invalid ip address")
+	}
+	return nil
+}
+
+func setLogLevelV1(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+	if req.Body == nil {
+		return apiV1Error(req, http.StatusBadRequest, "expected log level override in request body")
+	}
+	var override api.LogLevelOverride
+	if err := json.NewDecoder(req.Body).Decode(&override); err != nil {
+		return apiV1Error(req, http.StatusBadRequest, "expected log level override in JSON")
+	}
+	if errResponse := validateLogLevelTarget(req, override.ClientID, override.Address); errResponse != nil {
+		return errResponse
+	}
+	level, err := log.ParseLevel(override.Level)
+	if err != nil || level < log.WarnLevel || level > log.DebugLevel {
+		return apiV1Error(req, http.StatusBadRequest, "level must be one of debug, info, warning")
+	}
+	if override.ClientID != "" {
+		logging.SetClientLogLevel(override.ClientID, level)
+	} else {
+		logging.SetIPLogLevel(override.Address, level)
+	}
+	log.WithFields(log.Fields{"client_id": override.ClientID, "address": override.Address, "level": level.String()}).Infoln("Log level overridden")
+	return apiV1Response(req, http.StatusNoContent, "", nil)
+}
+
+func removeLogLevelV1(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+	clientID, address := req.URL.Query().Get("client_id"), req.URL.Query().Get("address")
+	if errResponse := validateLogLevelTarget(req, clientID, address); errResponse != nil {
+		return errResponse
+	}
+	if clientID != "" {
+		logging.RemoveClientLogLevel(clientID)
+	} else {
+		logging.RemoveIPLogLevel(address)
+	}
+	log.WithFields(log.Fields{"client_id": clientID, "address": address}).Infoln("Log level override removed")
+	return apiV1Response(req, http.StatusNoContent, "", nil)
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/cossacklabs/acra/api"
+	"github.com/cossacklabs/acra/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+func validateSchemaV1(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+	warnings, err := clientSession.config.ValidateEncryptorSchema()
+	if err == ErrSchemaIntrospectionDisabled {
+		return apiV1Error(req, http.StatusConflict, "schema introspection requires encryptor_config_file and encryptor_schema_connection_string")
+	}
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorEncryptorCantReadSchema).
+			Errorln("Can't introspect database schema")
+		return apiV1Error(req, http.StatusBadGateway, "can't introspect database schema")
+	}
+	logSchemaWarnings(warnings)
+	validation := api.SchemaValidation{Warnings: make([]api.SchemaWarning, 0, len(warnings))}
+	for _, warning := range warnings {
+		validation.Warnings = append(validation.Warnings, api.SchemaWarning{Table: warning.Table, Column: warning.Column, Message: warning.Message})
+	}
+	return apiV1JSON(req, validation)
+}
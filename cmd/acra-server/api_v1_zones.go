@@ -0,0 +1,94 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/cossacklabs/acra/api"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/zone"
+	log "github.com/sirupsen/logrus"
+)
+
+// caller returns name of caller used to count quota of zones: authenticated identity or source address of connection
+func (clientSession *ClientCommandsSession) caller() string {
+	if clientSession.identity != "" {
+		return "identity:" + clientSession.identity
+	}
+	address := clientSession.connection.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	return "address:" + address
+}
+
+// reserveZones reserves count zones in quota of caller. Returns quota left and error response if caller exceeded
+// quota or rate limit
+func (clientSession *ClientCommandsSession) reserveZones(req *http.Request, count int) (int, *http.Response) {
+	caller := clientSession.caller()
+	left, err := clientSession.config.GetZoneGenerationQuota().Reserve(caller, count)
+	if err == zone.ErrZoneRateLimited || err == zone.ErrZoneQuotaExceeded {
+		log.WithError(err).WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeErrorZoneQuotaExceeded, "caller": caller}).
+			Warningf("Rejected request to generate %d zones", count)
+		return left, apiV1Error(req, http.StatusTooManyRequests, err.Error())
+	}
+	return left, nil
+}
+
+func createZoneV1(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+	if _, errResponse := clientSession.reserveZones(req, 1); errResponse != nil {
+		return errResponse
+	}
+	zoneData, err := clientSession.generateZone()
+	if err != nil {
+		clientSession.config.GetZoneGenerationQuota().Release(clientSession.caller(), 1)
+		return apiV1Error(req, http.StatusInternalServerError, "can't generate zone")
+	}
+	return apiV1Response(req, http.StatusOK, "application/json", zoneData)
+}
+
+func createZonesBatchV1(clientSession *ClientCommandsSession, req *http.Request) *http.Response {
+	if req.Body == nil {
+		return apiV1Error(req, http.StatusBadRequest, "expected count of zones in request body")
+	}
+	var batchRequest api.ZonesBatchRequest
+	if err := json.NewDecoder(req.Body).Decode(&batchRequest); err != nil {
+		return apiV1Error(req, http.StatusBadRequest, "expected count of zones in JSON")
+	}
+	if batchRequest.Count <= 0 || batchRequest.Count > clientSession.config.GetZonesBatchMaxCount() {
+		return apiV1Error(req, http.StatusBadRequest, "count of zones should be positive and not greater than http_api_zones_batch_max_count")
+	}
+	left, errResponse := clientSession.reserveZones(req, batchRequest.Count)
+	if errResponse != nil {
+		return errResponse
+	}
+	batch := api.ZonesBatch{Zones: make([]api.Zone, 0, batchRequest.Count), QuotaLeft: left}
+	for i := 0; i < batchRequest.Count; i++ {
+		id, publicKey, err := clientSession.keystorage.GenerateZoneKey()
+		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantGenerateZone).Errorln("Can't generate zone key")
+			clientSession.config.GetZoneGenerationQuota().Release(clientSession.caller(), batchRequest.Count-i)
+			return apiV1Error(req, http.StatusInternalServerError, "can't generate zone")
+		}
+		batch.Zones = append(batch.Zones, api.Zone{ID: string(id), PublicKey: publicKey})
+	}
+	log.WithFields(log.Fields{"caller": clientSession.caller(), "count": batchRequest.Count}).Infoln("Generated batch of zones")
+	return apiV1JSON(req, batch)
+}
@@ -18,8 +18,11 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
+	"io"
 	"net"
 	"net/http"
+	"strings"
 
 	"github.com/cossacklabs/acra/logging"
 	log "github.com/sirupsen/logrus"
@@ -30,6 +33,7 @@ import (
 	"fmt"
 	"syscall"
 
+	"github.com/cossacklabs/acra/api"
 	"github.com/cossacklabs/acra/cmd"
 	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/acra/utils"
@@ -38,9 +42,10 @@ import (
 	"github.com/cossacklabs/themis/gothemis/keys"
 )
 
-// HTTP 500 response
+// HTTP error responses
 const (
 	Response500Error = "HTTP/1.1 500 Server error\r\n\r\n\r\n\r\n"
+	Response401Error = "HTTP/1.1 401 Unauthorized\r\n\r\n\r\n\r\n"
 )
 
 // ClientCommandsSession handles Secure Session for client commands API
@@ -48,6 +53,8 @@ type ClientCommandsSession struct {
 	ClientSession
 	Server   *SServer
 	keystore keystore.KeyStore
+	// identity is name of caller authenticated by HTTP API auth providers, empty if requests aren't authenticated
+	identity string
 }
 
 // NewClientCommandsSession returns new ClientCommandsSession
@@ -75,6 +82,27 @@ func (clientSession *ClientCommandsSession) close() {
 	log.Debugln("All connections closed")
 }
 
+// authenticate returns true if request passed configured authentication providers or providers aren't configured
+func (clientSession *ClientCommandsSession) authenticate(req *http.Request) bool {
+	provider := clientSession.config.GetHTTPAPIAuthProvider()
+	if provider == nil {
+		return true
+	}
+	if tlsConnection, ok := clientSession.connection.(*tls.Conn); ok {
+		state := tlsConnection.ConnectionState()
+		req.TLS = &state
+	}
+	identity, err := provider.Authenticate(req)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorHTTPAPIUnauthorized).
+			Warningf("Rejected unauthenticated API request to %v", req.URL.Path)
+		return false
+	}
+	log.WithField("identity", identity.Name).Debugf("API request authenticated by %v provider", identity.Provider)
+	clientSession.identity = identity.Name
+	return true
+}
+
 // HandleSession gets, parses and executes each client HTTP request, writes response to the connection
 func (clientSession *ClientCommandsSession) HandleSession() {
 	reader := bufio.NewReader(clientSession.connection)
@@ -87,100 +115,208 @@ func (clientSession *ClientCommandsSession) HandleSession() {
 		clientSession.close()
 		return
 	}
-	response := "HTTP/1.1 404 Not Found\r\n\r\nincorrect request\r\n\r\n"
-
 	log.Debugf("Incoming API request to %v", req.URL.Path)
 
+	if !clientSession.authenticate(req) {
+		if _, err := clientSession.connection.Write([]byte(Response401Error)); err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorGeneral).Errorln("Can't send response to acra-connector")
+		}
+		clientSession.close()
+		return
+	}
+
+	var response string
+	if strings.HasPrefix(req.URL.Path, api.VersionPrefix) {
+		response = clientSession.handleV1Request(req)
+	} else {
+		response = clientSession.handleLegacyRequest(req)
+	}
+
+	_, err = clientSession.connection.Write([]byte(response))
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorGeneral).Errorln("Can't send data with secure session to acra-connector")
+		return
+	}
+	clientSession.close()
+}
+
+// handleLegacyRequest handles requests to unversioned endpoints which are kept for compatibility with previous versions
+func (clientSession *ClientCommandsSession) handleLegacyRequest(req *http.Request) string {
+	response := "HTTP/1.1 404 Not Found\r\n\r\nincorrect request\r\n\r\n"
 	switch req.URL.Path {
 	case "/getNewZone":
 		log.Debugln("Got /getNewZone request")
-		id, publicKey, err := clientSession.keystorage.GenerateZoneKey()
-		if err != nil {
-			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantGenerateZone).Errorln("Can't generate zone key")
-		} else {
-			zoneData, err := zone.ZoneDataToJSON(id, &keys.PublicKey{Value: publicKey})
-			if err != nil {
-				log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantGenerateZone).WithError(err).Errorln("Can't create json with zone key")
-			} else {
-				log.Debugln("Handled request correctly")
-				response = fmt.Sprintf("HTTP/1.1 200 OK Found\r\n\r\n%s\r\n\r\n", string(zoneData))
-			}
+		if _, errResponse := clientSession.reserveZones(req, 1); errResponse != nil {
+			return "HTTP/1.1 429 Too Many Requests\r\n\r\n\r\n\r\n"
+		}
+		zoneData, err := clientSession.generateZone()
+		if err == nil {
+			log.Debugln("Handled request correctly")
+			response = fmt.Sprintf("HTTP/1.1 200 OK Found\r\n\r\n%s\r\n\r\n", string(zoneData))
 		}
 	case "/resetKeyStorage":
 		log.Debugln("Got /resetKeyStorage request")
-		clientSession.keystorage.Reset()
+		clientSession.resetKeyStorage()
 		response = "HTTP/1.1 200 OK Found\r\n\r\n"
-		log.Debugln("Cleared key storage cache")
 	case "/loadAuthData":
-		response = Response500Error
-		key, err := clientSession.keystore.GetAuthKey(false)
-		if err != nil {
-			log.WithError(err).Error("loadAuthData: keystore.GetAuthKey()")
-			response = Response500Error
-			break
-		}
-		authDataCrypted, err := getAuthDataFromFile(*authPath)
-		if err != nil {
-			log.Warningf("%v\n", utils.ErrorMessage("loadAuthData: no auth data", err))
-			response = Response500Error
-			break
-		}
-		SecureCell := cell.New(key, cell.CELL_MODE_SEAL)
-		authData, err := SecureCell.Unprotect(authDataCrypted, nil, nil)
+		authData, err := clientSession.loadAuthData()
 		if err != nil {
-			log.WithError(err).Error("loadAuthData: SecureCell.Unprotect")
-
-			break
+			return Response500Error
 		}
 		response = fmt.Sprintf("HTTP/1.1 200 OK Found\r\n\r\n%s\r\n\r\n", authData)
 	case "/getConfig":
 		log.Debugln("Got /getConfig request")
-		jsonOutput, err := clientSession.config.ToJSON()
+		jsonOutput, err := clientSession.getConfig()
 		if err != nil {
-			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorGeneral).
-				Warningln("Can't convert config to JSON")
-			response = Response500Error
-		} else {
-			log.Debugln("Handled request correctly")
-			log.Debugln(string(jsonOutput))
-			response = fmt.Sprintf("HTTP/1.1 200 OK Found\r\n\r\n%s\r\n\r\n", string(jsonOutput))
+			return Response500Error
 		}
+		log.Debugln("Handled request correctly")
+		response = fmt.Sprintf("HTTP/1.1 200 OK Found\r\n\r\n%s\r\n\r\n", string(jsonOutput))
 	case "/setConfig":
 		log.Debugln("Got /setConfig request")
-		decoder := json.NewDecoder(req.Body)
-		var configFromUI UIEditableConfig
-		err := decoder.Decode(&configFromUI)
-		if err != nil {
-			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorGeneral).
-				Warningln("Can't convert config from incoming")
-			response = Response500Error
-			return
+		if err := clientSession.setConfig(req.Body); err != nil {
+			return Response500Error
 		}
-		// set config values
-		flag.Set("db_host", configFromUI.DbHost)
-		flag.Set("db_port", fmt.Sprintf("%v", configFromUI.DbPort))
-		flag.Set("incoming_connection_api_port", fmt.Sprintf("%v", configFromUI.ConnectorAPIPort))
-		flag.Set("d", fmt.Sprintf("%v", configFromUI.Debug))
-		flag.Set("poison_run_script_file", fmt.Sprintf("%v", configFromUI.ScriptOnPoison))
-		flag.Set("poison_shutdown_enable", fmt.Sprintf("%v", configFromUI.StopOnPoison))
-		flag.Set("zonemode_enable", fmt.Sprintf("%v", configFromUI.WithZone))
-
-		err = cmd.DumpConfig(clientSession.Server.config.GetConfigPath(), SERVICE_NAME, false)
+		response = "HTTP/1.1 200 OK Found\r\n\r\n"
+	case "/getConnections":
+		log.Debugln("Got /getConnections request")
+		jsonOutput, err := clientSession.getConnections()
 		if err != nil {
-			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantDumpConfig).
-				Errorln("DumpConfig failed")
-			response = Response500Error
-			return
-
+			return Response500Error
 		}
-		log.Debugln("Handled request correctly, restarting server")
-		clientSession.Server.restartSignalsChannel <- syscall.SIGHUP
+		log.Debugln("Handled request correctly")
+		response = fmt.Sprintf("HTTP/1.1 200 OK Found\r\n\r\n%s\r\n\r\n", string(jsonOutput))
+	case "/drain":
+		log.Debugln("Got /drain request")
+		clientSession.drain()
+		response = "HTTP/1.1 200 OK\r\n\r\n"
 	}
+	return response
+}
 
-	_, err = clientSession.connection.Write([]byte(response))
+// generateZone generates new zone and returns its id and public key in JSON
+func (clientSession *ClientCommandsSession) generateZone() ([]byte, error) {
+	id, publicKey, err := clientSession.keystorage.GenerateZoneKey()
 	if err != nil {
-		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorGeneral).Errorln("Can't send data with secure session to acra-connector")
-		return
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantGenerateZone).Errorln("Can't generate zone key")
+		return nil, err
 	}
-	clientSession.close()
+	zoneData, err := zone.ZoneDataToJSON(id, &keys.PublicKey{Value: publicKey})
+	if err != nil {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantGenerateZone).WithError(err).Errorln("Can't create json with zone key")
+		return nil, err
+	}
+	return zoneData, nil
+}
+
+// resetKeyStorage clears cache of keystore
+func (clientSession *ClientCommandsSession) resetKeyStorage() {
+	clientSession.keystorage.Reset()
+	log.Debugln("Cleared key storage cache")
+}
+
+// loadAuthData returns decrypted users of AcraWebConfig
+func (clientSession *ClientCommandsSession) loadAuthData() ([]byte, error) {
+	key, err := clientSession.keystore.GetAuthKey(false)
+	if err != nil {
+		log.WithError(err).Error("loadAuthData: keystore.GetAuthKey()")
+		return nil, err
+	}
+	authDataCrypted, err := getAuthDataFromFile(*authPath)
+	if err != nil {
+		log.Warningf("%v\n", utils.ErrorMessage("loadAuthData: no auth data", err))
+		return nil, err
+	}
+	SecureCell := cell.New(key, cell.CELL_MODE_SEAL)
+	authData, err := SecureCell.Unprotect(authDataCrypted, nil, nil)
+	if err != nil {
+		log.WithError(err).Error("loadAuthData: SecureCell.Unprotect")
+		return nil, err
+	}
+	return authData, nil
+}
+
+// getConfig returns part of configuration editable by AcraWebConfig in JSON
+func (clientSession *ClientCommandsSession) getConfig() ([]byte, error) {
+	jsonOutput, err := clientSession.config.ToJSON()
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorGeneral).
+			Warningln("Can't convert config to JSON")
+		return nil, err
+	}
+	log.Debugln(string(jsonOutput))
+	return jsonOutput, nil
+}
+
+// setConfig saves configuration from JSON body to config file and restarts server
+func (clientSession *ClientCommandsSession) setConfig(body io.Reader) error {
+	decoder := json.NewDecoder(body)
+	var configFromUI UIEditableConfig
+	err := decoder.Decode(&configFromUI)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorGeneral).
+			Warningln("Can't convert config from incoming")
+		return err
+	}
+	// set config values
+	flag.Set("db_host", configFromUI.DbHost)
+	flag.Set("db_port", fmt.Sprintf("%v", configFromUI.DbPort))
+	flag.Set("incoming_connection_api_port", fmt.Sprintf("%v", configFromUI.ConnectorAPIPort))
+	flag.Set("d", fmt.Sprintf("%v", configFromUI.Debug))
+	flag.Set("poison_run_script_file", fmt.Sprintf("%v", configFromUI.ScriptOnPoison))
+	flag.Set("poison_shutdown_enable", fmt.Sprintf("%v", configFromUI.StopOnPoison))
+	flag.Set("zonemode_enable", fmt.Sprintf("%v", configFromUI.WithZone))
+
+	err = cmd.DumpConfig(clientSession.Server.config.GetConfigPath(), SERVICE_NAME, false)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantDumpConfig).
+			Errorln("DumpConfig failed")
+		return err
+	}
+	log.Debugln("Handled request correctly, restarting server")
+	clientSession.Server.restartSignalsChannel <- syscall.SIGHUP
+	return nil
+}
+
+// getConnections returns counters of active client connections in JSON
+func (clientSession *ClientCommandsSession) getConnections() ([]byte, error) {
+	jsonOutput, err := json.Marshal(clientSession.Server.GetConnectionsStats())
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorGeneral).
+			Warningln("Can't convert connections stats to JSON")
+		return nil, err
+	}
+	return jsonOutput, nil
+}
+
+// getPayloadStats returns sizes of decrypted values aggregated per client and table in JSON
+func (clientSession *ClientCommandsSession) getPayloadStats() ([]byte, error) {
+	jsonOutput, err := json.Marshal(clientSession.Server.GetPayloadStats())
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorGeneral).
+			Warningln("Can't convert payload stats to JSON")
+		return nil, err
+	}
+	return jsonOutput, nil
+}
+
+// getStatementStats returns executions of normalized statements aggregated per client in JSON
+func (clientSession *ClientCommandsSession) getStatementStats() ([]byte, error) {
+	stats, err := clientSession.Server.GetStatementStats()
+	if err != nil {
+		return nil, err
+	}
+	jsonOutput, err := json.Marshal(stats)
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorGeneral).
+			Warningln("Can't convert statement stats to JSON")
+		return nil, err
+	}
+	return jsonOutput, nil
+}
+
+// drain stops accepting new connections and waits for active connections in background without stopping process
+func (clientSession *ClientCommandsSession) drain() {
+	// server waits for this connection too so response will be sent before this connection is canceled by timeout
+	go clientSession.Server.Drain(clientSession.Server.config.GetCloseConnectionTimeout())
 }
@@ -17,16 +17,25 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/cossacklabs/acra/cmd"
 	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/decryptor/cassandra"
+	"github.com/cossacklabs/acra/decryptor/mongodb"
+	"github.com/cossacklabs/acra/decryptor/mssql"
 	"github.com/cossacklabs/acra/decryptor/mysql"
 	"github.com/cossacklabs/acra/decryptor/postgresql"
+	"github.com/cossacklabs/acra/encryptor"
 	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/network"
 	"io"
 )
 
@@ -37,6 +46,12 @@ type ClientSession struct {
 	connection     net.Conn
 	connectionToDb net.Conn
 	Server         *SServer
+	// connectionStats accumulates counters of connection shown in HTTP API, may be nil
+	connectionStats *base.ConnectionStats
+	// sessionZone is zone of client's session set by session_zone directives or HTTP API, may be nil
+	sessionZone *base.SessionZone
+	// workers of listener's pool which run proxying of connection, nil if proxying runs in new goroutines
+	workers *cmd.WorkerPool
 }
 
 // NewClientSession creates new ClientSession object.
@@ -44,9 +59,11 @@ func NewClientSession(keystorage keystore.KeyStore, config *Config, connection n
 	return &ClientSession{connection: connection, keystorage: keystorage, config: config}, nil
 }
 
-// ConnectToDb connects to the database via tcp using Host and Port from config.
-func (clientSession *ClientSession) ConnectToDb() error {
-	conn, err := net.Dial("tcp", fmt.Sprintf("%v:%v", clientSession.config.GetDBHost(), clientSession.config.GetDBPort()))
+// ConnectToDb connects to the database via tcp using Host and Port from config. Failed connection retried as configured
+// because nothing was sent to database yet, e.g. while database restarts. Retries are stopped when ctx is done
+func (clientSession *ClientSession) ConnectToDb(ctx context.Context) error {
+	retries, interval := clientSession.config.GetDBConnectRetries()
+	conn, err := network.DialContextWithRetries(ctx, "tcp", fmt.Sprintf("%v:%v", clientSession.config.GetDBHost(), clientSession.config.GetDBPort()), retries, interval)
 	if err != nil {
 		return err
 	}
@@ -54,6 +71,46 @@ func (clientSession *ClientSession) ConnectToDb() error {
 	return nil
 }
 
+// notifyDBUnavailable sends error of database protocol to client before closing its connection, so client gets error
+// about unavailable database instead of abruptly closed connection
+func (clientSession *ClientSession) notifyDBUnavailable(logger *log.Entry) {
+	var errorMessage []byte
+	if clientSession.config.UseMongoDB() || clientSession.config.UseCassandra() || clientSession.config.UseMSSQL() {
+		// client starts conversation in MongoDB, CQL and TDS protocols, so there is no message which client expects
+		// instead of it
+		return
+	} else if clientSession.config.UseMySQL() {
+		// database sends the first packet in MySQL protocol so client expects error with zero sequence number
+		// and without capabilities negotiated yet
+		packet := mysql.NewMysqlPacket()
+		packet.SetData(mysql.NewCantConnectError(false))
+		errorMessage = packet.Dump()
+	} else {
+		errorMessage = postgresql.NewPgFatalError(postgresql.UnableToConnectCode, "can't connect to database")
+	}
+	if _, err := clientSession.connection.Write(errorMessage); err != nil {
+		logger.WithError(err).Debugln("Can't send error about unavailable database to client")
+	}
+}
+
+// watchStartup cancels connection if client and database don't finish startup phase in configured time. Returns nil
+// if time isn't limited, otherwise function which stops watching
+func (clientSession *ClientSession) watchStartup(cancel context.CancelFunc, logger *log.Entry) func() {
+	timeout := clientSession.config.GetDBStartupTimeout()
+	if timeout == 0 {
+		return nil
+	}
+	timer := time.AfterFunc(timeout, func() {
+		handshakeTimeoutsCounter.WithLabelValues(dbStartupStage).Inc()
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorDBStartupTimeout).
+			Warningf("Startup phase with database wasn't completed in %v, drop connection", timeout)
+		cancel()
+	})
+	return func() {
+		timer.Stop()
+	}
+}
+
 func (clientSession *ClientSession) close() {
 	log.Debugln("Close acra-connector connection")
 
@@ -71,67 +128,226 @@ func (clientSession *ClientSession) close() {
 	log.Debugln("All connections closed")
 }
 
+// negotiatePostgreSQLTLS switches connections with client and database to TLS before proxying if AcraServer
+// negotiates TLS with database itself instead of forwarding client's SSLRequest
+func (clientSession *ClientSession) negotiatePostgreSQLTLS(clientID []byte, logger *log.Entry) error {
+	mode := clientSession.config.GetDBTLSMode()
+	if !mode.Negotiated() {
+		return nil
+	}
+	var deadline time.Time
+	if timeout := clientSession.config.GetDBStartupTimeout(); timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	clientConnection, dbConnection, err := postgresql.NegotiateTLS(clientSession.connection, clientSession.connectionToDb, mode,
+		clientSession.config.GetTLSConfig(), clientSession.config.GetTLSConfigForClientID(clientID), deadline, logger)
+	if err != nil {
+		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTLSHandshakeFailed).
+			Errorln("Can't negotiate TLS with client and database")
+		return err
+	}
+	clientSession.connection = clientConnection
+	clientSession.connectionToDb = dbConnection
+	return nil
+}
+
+// negotiateMSSQLTLS exchanges pre-login messages of client and database and switches connections to TLS according
+// to encryption supported by client and db_tls_mode
+func (clientSession *ClientSession) negotiateMSSQLTLS(clientID []byte, logger *log.Entry) error {
+	var deadline time.Time
+	if timeout := clientSession.config.GetDBStartupTimeout(); timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	mode := clientSession.config.GetDBTLSMode()
+	var dbConfig *tls.Config
+	if config := clientSession.config.GetTLSConfigForClientID(clientID); config != nil && mode != postgresql.DBTLSModeDisable {
+		dbConfig = postgresql.NewDBTLSConfig(mode, config)
+	}
+	clientConnection, dbConnection, err := mssql.NegotiateTLS(clientSession.connection, clientSession.connectionToDb,
+		clientSession.config.GetTLSConfig(), dbConfig, mode.RequiresTLS(), deadline, logger)
+	if err != nil {
+		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTLSHandshakeFailed).
+			Errorln("Can't negotiate TLS with client and database on pre-login")
+		return err
+	}
+	clientSession.connection = clientConnection
+	clientSession.connectionToDb = dbConnection
+	return nil
+}
+
 // HandleClientConnection handles Acra-connector connections from client to db and decrypt responses from db to client.
-// If any error occurred – ends processing.
-func (clientSession *ClientSession) HandleClientConnection(clientID []byte, decryptorImpl base.Decryptor) {
-	log.Infof("Handle client's connection")
+// If any error occurred or ctx is done (e.g. session killed or drain deadline passed) – ends processing.
+func (clientSession *ClientSession) HandleClientConnection(ctx context.Context, clientID []byte, decryptorImpl base.Decryptor) {
+	logger := logging.NewClientLogger(clientID, clientSession.connection.RemoteAddr().String())
+	defer logging.ReleaseClientLogger(logger)
+	logger.Infof("Handle client's connection")
 	clientProxyErrorCh := make(chan error, 1)
 	dbProxyErrorCh := make(chan error, 1)
 
-	log.Debugf("Connecting to db")
-	err := clientSession.ConnectToDb()
+	logger.Debugf("Connecting to db")
+	err := clientSession.ConnectToDb(ctx)
 	if err != nil {
-		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantConnectToDB).
+		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantConnectToDB).
 			Errorln("Can't connect to db")
+		clientSession.notifyDBUnavailable(logger)
 
-		log.Debugln("Close connection with acra-connector")
+		logger.Debugln("Close connection with acra-connector")
 		err = clientSession.connection.Close()
 		if err != nil {
-			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantCloseConnectionToService).
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantCloseConnectionToService).
 				Errorln("Error with closing connection to acra-connector")
 		}
 		return
 	}
+	// stalled startup cancels connection the same way as killed session
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	startupFinished := clientSession.watchStartup(cancel, logger)
+	if startupFinished != nil {
+		defer startupFinished()
+	}
+	var queryEncryptor encryptor.QueryEncryptor
+	var deterministicEncryptor *encryptor.DeterministicEncryptor
+	if encryptorConfig := clientSession.config.GetEncryptorConfig(); encryptorConfig != nil {
+		if encryptorConfig.HasDeterministicColumns() {
+			deterministicEncryptor = encryptor.NewDeterministicEncryptor(clientSession.keystorage, clientID)
+		}
+		searchableEncryptor, err := encryptor.NewSearchableQueryEncryptor(encryptorConfig, clientSession.keystorage, clientID)
+		if err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorEncryptorSetupError).
+				Errorln("Can't initialize query encryptor")
+			return
+		}
+		searchableEncryptor.SetConsistentWrites(clientSession.config.GetConsistentWrites())
+		searchableEncryptor.SetKeyExpiryChecker(clientSession.config.GetKeyExpiryChecker())
+		queryEncryptor = searchableEncryptor
+	}
 	var pgProxy *postgresql.PgProxy
-	if clientSession.config.UseMySQL() {
-		log.Debugln("MySQL connection")
-		handler, err := mysql.NewMysqlHandler(clientID, decryptorImpl, clientSession.connectionToDb, clientSession.connection, clientSession.config.GetTLSConfig(), clientSession.config.censor)
+	// proxies of requests from client and responses from database which run until connection closes
+	var clientProxy, dbProxy func()
+	if clientSession.config.UseMongoDB() {
+		logger.Debugln("MongoDB connection")
+		mongoProxy := mongodb.NewProxy(clientSession.connection, clientSession.connectionToDb, decryptorImpl)
+		mongoProxy.SetLogger(logger)
+		mongoProxy.SetConnectionStats(clientSession.connectionStats)
+		mongoProxy.SetStartupCallback(startupFinished)
+		clientProxy = func() { mongoProxy.ProxyClientRequests(clientProxyErrorCh) }
+		dbProxy = func() { mongoProxy.DecryptReplies(ctx, dbProxyErrorCh) }
+	} else if clientSession.config.UseCassandra() {
+		logger.Debugln("Cassandra connection")
+		cassandraProxy := cassandra.NewProxy(clientSession.connection, clientSession.connectionToDb, decryptorImpl, clientSession.config.censor)
+		cassandraProxy.SetLogger(logger)
+		cassandraProxy.SetConnectionStats(clientSession.connectionStats)
+		cassandraProxy.SetStartupCallback(startupFinished)
+		clientProxy = func() { cassandraProxy.ProxyClientRequests(clientProxyErrorCh) }
+		dbProxy = func() { cassandraProxy.DecryptReplies(ctx, dbProxyErrorCh) }
+	} else if clientSession.config.UseMSSQL() {
+		logger.Debugln("MSSQL connection")
+		if err := clientSession.negotiateMSSQLTLS(clientID, logger); err != nil {
+			clientSession.close()
+			return
+		}
+		mssqlProxy := mssql.NewProxy(clientSession.connection, clientSession.connectionToDb, decryptorImpl, clientSession.config.censor)
+		mssqlProxy.SetLogger(logger)
+		mssqlProxy.SetConnectionStats(clientSession.connectionStats)
+		mssqlProxy.SetStartupCallback(startupFinished)
+		clientProxy = func() { mssqlProxy.ProxyClientRequests(clientProxyErrorCh) }
+		dbProxy = func() { mssqlProxy.DecryptReplies(ctx, dbProxyErrorCh) }
+	} else if clientSession.config.UseMySQL() {
+		logger.Debugln("MySQL connection")
+		handler, err := mysql.NewMysqlHandler(clientID, decryptorImpl, clientSession.connectionToDb, clientSession.connection, clientSession.config.GetTLSConfigForClientID(clientID), clientSession.config.censor, queryEncryptor)
 		if err != nil {
-			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantInitDecryptor).
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantInitDecryptor).
 				Errorln("Can't initialize mysql handler")
 			return
 		}
-		go handler.ClientToDbConnector(clientProxyErrorCh)
-		go handler.DbToClientConnector(dbProxyErrorCh)
+		handler.SetLogger(logger)
+		handler.AllowQueryDirectives(clientSession.config.IsQueryDirectivesAllowed(clientID))
+		handler.SetPassthroughTables(clientSession.config.GetPassthroughTables())
+		handler.SetConnectionStats(clientSession.connectionStats)
+		handler.SetSessionZone(clientSession.sessionZone)
+		handler.SetDeterministicEncryptor(deterministicEncryptor)
+		handler.SetLengthAudit(clientSession.config.GetLengthAudit())
+		handler.SetDLPSampler(clientSession.config.GetDLPSampler())
+		handler.SetRequireSSL(clientSession.config.GetDBRequireSSL())
+		handler.SetLocalInfilePolicy(clientSession.config.GetMySQLLocalInfilePolicy())
+		handler.SetStartupCallback(startupFinished)
+		handler.SetDBReadPipelineSize(clientSession.config.GetDBReadPipelineSize())
+		handler.SetDBReadSpill(clientSession.config.GetDBReadSpill())
+		if clientSession.config.GetScanConfiguredColumns() {
+			handler.SetEncryptedColumns(clientSession.config.GetEncryptorConfig())
+		}
+		if zoneResolver := clientSession.config.GetQueryZoneResolver(); zoneResolver != nil {
+			handler.SetQueryZoneResolver(zoneResolver)
+		}
+		clientProxy = func() { handler.ClientToDbConnector(clientProxyErrorCh) }
+		dbProxy = func() { handler.DbToClientConnector(dbProxyErrorCh) }
 	} else {
-		pgProxy, err = postgresql.NewPgProxy(clientSession.connection, clientSession.connectionToDb)
+		if err := clientSession.negotiatePostgreSQLTLS(clientID, logger); err != nil {
+			clientSession.close()
+			return
+		}
+		pgProxy, err = postgresql.NewPgProxy(clientSession.connection, clientSession.connectionToDb, queryEncryptor)
 		if err != nil {
-			log.WithError(err).Errorln("can't initialize postgresql proxy")
+			logger.WithError(err).Errorln("can't initialize postgresql proxy")
 			return
 		}
-		log.Debugln("PostgreSQL connection")
-		go pgProxy.PgProxyClientRequests(clientSession.config.censor, clientSession.connectionToDb, clientSession.connection, clientProxyErrorCh)
-		go pgProxy.PgDecryptStream(clientSession.config.censor, decryptorImpl, clientSession.config.GetTLSConfig(), clientSession.connectionToDb, clientSession.connection, dbProxyErrorCh)
+		pgProxy.SetLogger(logger)
+		pgProxy.AllowQueryDirectives(clientSession.config.IsQueryDirectivesAllowed(clientID))
+		pgProxy.SetPassthroughTables(clientSession.config.GetPassthroughTables())
+		pgProxy.SetConnectionStats(clientSession.connectionStats)
+		pgProxy.SetSessionZone(clientSession.sessionZone)
+		pgProxy.SetDeterministicEncryptor(deterministicEncryptor)
+		pgProxy.SetLengthAudit(clientSession.config.GetLengthAudit())
+		pgProxy.SetDLPSampler(clientSession.config.GetDLPSampler())
+		pgProxy.SetRequireSSL(clientSession.config.GetDBRequireSSL())
+		pgProxy.SetStartupCallback(startupFinished)
+		if clientSession.config.GetScanConfiguredColumns() {
+			pgProxy.SetEncryptedColumns(clientSession.config.GetEncryptorConfig())
+		}
+		pgProxy.SetDBReadPipelineSize(clientSession.config.GetDBReadPipelineSize())
+		pgProxy.SetDBReadSpill(clientSession.config.GetDBReadSpill())
+		if zoneResolver := clientSession.config.GetQueryZoneResolver(); zoneResolver != nil {
+			pgProxy.SetQueryZoneResolver(zoneResolver)
+		}
+		logger.Debugln("PostgreSQL connection")
+		clientProxy = func() {
+			pgProxy.PgProxyClientRequests(clientSession.config.censor, clientSession.connectionToDb, clientSession.connection, clientProxyErrorCh)
+		}
+		dbProxy = func() {
+			pgProxy.PgDecryptStream(ctx, clientSession.config.censor, decryptorImpl, clientSession.config.GetTLSConfigForClientID(clientID), clientSession.connectionToDb, clientSession.connection, dbProxyErrorCh)
+		}
 	}
-	var channelToWait chan error
+	if err := clientSession.workers.Go(ctx, clientProxy, dbProxy); err != nil {
+		logger.WithError(err).Infoln("Connection canceled while waiting for free workers")
+		clientSession.close()
+		return
+	}
+	var channelsToWait []chan error
 	for {
 		select {
 		case err = <-dbProxyErrorCh:
-			log.WithError(err).Debugln("error from db proxy")
-			channelToWait = clientProxyErrorCh
+			logger.WithError(err).Debugln("error from db proxy")
+			channelsToWait = []chan error{clientProxyErrorCh}
 			break
 		case err = <-clientProxyErrorCh:
-			channelToWait = dbProxyErrorCh
-			log.WithError(err).Debugln("error from client proxy")
+			channelsToWait = []chan error{dbProxyErrorCh}
+			logger.WithError(err).Debugln("error from client proxy")
 			break
+		case <-ctx.Done():
+			err = ctx.Err()
+			// both proxies are stopped by closing connections
+			channelsToWait = []chan error{clientProxyErrorCh, dbProxyErrorCh}
 		}
 
-		if err == io.EOF {
-			log.Debugln("EOF connection closed")
+		if err == context.Canceled || err == context.DeadlineExceeded {
+			logger.WithError(err).Infoln("Connection canceled")
+		} else if err == io.EOF {
+			logger.Debugln("EOF connection closed")
 		} else if netErr, ok := err.(net.Error); ok {
 			if netErr.Timeout() {
-				log.Debugln("Network timeout")
-				if clientSession.config.UseMySQL() {
+				logger.Debugln("Network timeout")
+				if !clientSession.config.UsePostgreSQL() {
 					break
 				} else {
 					pgProxy.TLSCh <- true
@@ -140,19 +356,21 @@ func (clientSession *ClientSession) HandleClientConnection(clientID []byte, decr
 					continue
 				}
 			}
-			log.WithError(netErr).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantHandleSecureSession).
+			logger.WithError(netErr).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantHandleSecureSession).
 				Errorln("Network error")
 		} else if opErr, ok := err.(*net.OpError); ok {
-			log.WithError(opErr).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantHandleSecureSession).Errorln("Network error")
+			logger.WithError(opErr).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantHandleSecureSession).Errorln("Network error")
 		} else {
-			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantHandleSecureSession).Errorln("Unexpected error")
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantHandleSecureSession).Errorln("Unexpected error")
 		}
 		break
 	}
-	log.Infof("Closing client's connection")
+	logger.Infof("Closing client's connection")
 	clientSession.close()
 
-	// wait second error from closed second connection
-	log.WithError(<-channelToWait).Debugln("second proxy goroutine stopped")
-	log.Infoln("Finished processing client's connection")
+	// wait errors of proxies from closed connections
+	for _, channel := range channelsToWait {
+		logger.WithError(<-channel).Debugln("proxy goroutine stopped")
+	}
+	logger.Infoln("Finished processing client's connection")
 }
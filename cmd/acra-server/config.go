@@ -22,8 +22,19 @@ import (
 	"errors"
 
 	"github.com/cossacklabs/acra/acra-censor"
+	"github.com/cossacklabs/acra/api"
+	"github.com/cossacklabs/acra/cmd"
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/decryptor/mysql"
+	"github.com/cossacklabs/acra/decryptor/postgresql"
+	"github.com/cossacklabs/acra/encryptor"
+	"github.com/cossacklabs/acra/httpauth"
+	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/acra/network"
+	"github.com/cossacklabs/acra/zone"
 	"io/ioutil"
+	"strings"
+	"time"
 )
 
 // Possible bytea formats
@@ -55,22 +66,52 @@ type Config struct {
 	ConnectionWrapper       network.ConnectionWrapper
 	mysql                   bool
 	postgresql              bool
+	mongodb                 bool
+	cassandra               bool
+	mssql                   bool
 	configPath              string
 	debug                   bool
 	censor                  acracensor.AcraCensorInterface
+	encryptorConfig         *encryptor.Config
+	scanConfiguredColumns   bool
+	consistentWrites        bool
+	dbReadPipelineSize      int
+	dbReadSpill             *network.SpillConfig
+	dbConnectRetries        int
+	dbConnectRetryInterval  time.Duration
+	lengthAudit             bool
+	dlpSampler              *base.DLPSampler
+	localInfilePolicy       mysql.LocalInfilePolicy
+	dbRequireSSL            bool
+	dbTLSMode               postgresql.DBTLSMode
+	statementStatsMaxCount  int
+	ipFilter                *network.IPFilter
+	transportListeners      []*TransportListener
+	handshakeLimiter        *network.HandshakeLimiter
+	handshakeTimeout        time.Duration
+	dbStartupTimeout        time.Duration
+	closeConnectionTimeout  time.Duration
+	keyCacheWarmer          keystore.CacheWarmer
+	expiryMonitor           *cmd.ExpiryMonitor
+	refuseExpiredKeys       bool
+	zoneGenerationQuota     *zone.GenerationQuota
+	zonesBatchMaxCount      int
+	schemaConnectionString  string
+	dbClientCertificates    *network.ClientCertificates
+	httpAPIAuthProvider     httpauth.Provider
+	queryDirectivesClients  map[string]bool
+	queryZoneResolver       *zone.QueryZoneResolver
+	passthroughTables       *base.PassthroughTables
+	connectionCPUs          []int
+	apiConnectionCPUs       []int
+	connectionWorkers       int
+	apiConnectionWorkers    int
 	tlsConfig               *tls.Config
+	lifecycle               *cmd.Lifecycle
 }
 
 // UIEditableConfig describes which parts of AcraServer configuration can be changed from AcraWebconfig page
-type UIEditableConfig struct {
-	DbHost           string `json:"db_host"`
-	DbPort           int    `json:"db_port"`
-	ConnectorAPIPort int    `json:"incoming_connection_api_port"`
-	Debug            bool   `json:"debug"`
-	ScriptOnPoison   string `json:"poison_run_script_file"`
-	StopOnPoison     bool   `json:"poison_shutdown_enable"`
-	WithZone         bool   `json:"zonemode_enable"`
-}
+type UIEditableConfig = api.Config
 
 // NewConfig returns new Config object
 func NewConfig() *Config {
@@ -104,15 +145,146 @@ func (config *Config) GetCensor() acracensor.AcraCensorInterface {
 	return config.censor
 }
 
-// SetMySQL sets that AcraServer should connect to MySQL database
-func (config *Config) SetMySQL(useMySQL bool) error {
-	if config.postgresql && useMySQL {
+// SetEncryptorConfig loads configuration of searchable columns which hashes AcraServer calculates on queries
+func (config *Config) SetEncryptorConfig(encryptorConfigPath string) error {
+	//skip if flag not specified
+	if encryptorConfigPath == "" {
+		return nil
+	}
+	configuration, err := ioutil.ReadFile(encryptorConfigPath)
+	if err != nil {
+		return err
+	}
+	encryptorConfig, err := encryptor.LoadConfig(configuration)
+	if err != nil {
+		return err
+	}
+	config.encryptorConfig = encryptorConfig
+	return nil
+}
+
+// GetEncryptorConfig returns configuration of searchable columns or nil if it wasn't set
+func (config *Config) GetEncryptorConfig() *encryptor.Config {
+	return config.encryptorConfig
+}
+
+// SetQueryDirectivesClientIDs sets comma separated list of trusted client ids which may override zone and decryption
+// per query with SQL comments
+func (config *Config) SetQueryDirectivesClientIDs(clientIDs string) {
+	config.queryDirectivesClients = make(map[string]bool)
+	for _, clientID := range strings.Split(clientIDs, ",") {
+		clientID = strings.TrimSpace(clientID)
+		if clientID != "" {
+			config.queryDirectivesClients[clientID] = true
+		}
+	}
+}
+
+// SetQueryZoneConfig loads configuration of tenants which zones will be inferred from queries
+func (config *Config) SetQueryZoneConfig(queryZoneConfigPath string) error {
+	if queryZoneConfigPath == "" {
+		return nil
+	}
+	configuration, err := ioutil.ReadFile(queryZoneConfigPath)
+	if err != nil {
+		return err
+	}
+	queryZoneConfig, err := zone.LoadQueryZoneConfig(configuration)
+	if err != nil {
+		return err
+	}
+	config.queryZoneResolver = zone.NewQueryZoneResolver(queryZoneConfig)
+	return nil
+}
+
+// SetPassthroughTablesConfig loads configuration of tables which never contain encrypted data
+func (config *Config) SetPassthroughTablesConfig(passthroughTablesConfigPath string) error {
+	if passthroughTablesConfigPath == "" {
+		return nil
+	}
+	configuration, err := ioutil.ReadFile(passthroughTablesConfigPath)
+	if err != nil {
+		return err
+	}
+	config.passthroughTables, err = base.LoadPassthroughTables(configuration)
+	return err
+}
+
+// GetPassthroughTables returns tables which queries are processed without decryption and AcraCensor or nil if they
+// weren't configured
+func (config *Config) GetPassthroughTables() *base.PassthroughTables {
+	return config.passthroughTables
+}
+
+// GetQueryZoneResolver returns resolver of zones from queries or nil if it wasn't configured
+func (config *Config) GetQueryZoneResolver() *zone.QueryZoneResolver {
+	return config.queryZoneResolver
+}
+
+// SetConnectionCPUs sets CPUs on which goroutines of data connections may run, empty means any CPU
+func (config *Config) SetConnectionCPUs(cpus []int) {
+	config.connectionCPUs = cpus
+}
+
+// GetConnectionCPUs returns CPUs on which goroutines of data connections may run
+func (config *Config) GetConnectionCPUs() []int {
+	return config.connectionCPUs
+}
+
+// SetAPIConnectionCPUs sets CPUs on which goroutines of API connections may run, empty means any CPU
+func (config *Config) SetAPIConnectionCPUs(cpus []int) {
+	config.apiConnectionCPUs = cpus
+}
+
+// GetAPIConnectionCPUs returns CPUs on which goroutines of API connections may run
+func (config *Config) GetAPIConnectionCPUs() []int {
+	return config.apiConnectionCPUs
+}
+
+// SetConnectionWorkers sets count of workers pinned to CPUs of data connections in pool of each listener
+func (config *Config) SetConnectionWorkers(count int) {
+	config.connectionWorkers = count
+}
+
+// GetConnectionWorkers returns count of workers pinned to CPUs of data connections in pool of each listener
+func (config *Config) GetConnectionWorkers() int {
+	return config.connectionWorkers
+}
+
+// SetAPIConnectionWorkers sets count of workers pinned to CPUs of API connections in pool of each API listener
+func (config *Config) SetAPIConnectionWorkers(count int) {
+	config.apiConnectionWorkers = count
+}
+
+// GetAPIConnectionWorkers returns count of workers pinned to CPUs of API connections in pool of each API listener
+func (config *Config) GetAPIConnectionWorkers() int {
+	return config.apiConnectionWorkers
+}
+
+// IsQueryDirectivesAllowed returns true if client may override zone and decryption per query with SQL comments
+func (config *Config) IsQueryDirectivesAllowed(clientID []byte) bool {
+	return config.queryDirectivesClients[string(clientID)]
+}
+
+// setDatabase sets flag of database if flag of other database isn't set
+func (config *Config) setDatabase(database *bool, use bool) error {
+	if use && !*database && config.hasDatabase() {
 		return ErrTwoDBSetup
 	}
-	config.mysql = useMySQL
+	*database = use
 	return nil
 }
 
+// hasDatabase returns true if some database was set explicitly
+func (config *Config) hasDatabase() bool {
+	return config.mysql || config.postgresql || config.mongodb || config.cassandra || config.mssql
+}
+
+// SetMySQL sets that AcraServer should connect to MySQL database
+func (config *Config) SetMySQL(useMySQL bool) error {
+	return config.setDatabase(&config.mysql, useMySQL)
+}
+
 // UseMySQL returns if AcraServer should connect to MySQL database
 func (config *Config) UseMySQL() bool {
 	return config.mysql
@@ -120,8 +292,8 @@ func (config *Config) UseMySQL() bool {
 
 // UsePostgreSQL returns if AcraServer should connect to PostgreSQL database
 func (config *Config) UsePostgreSQL() bool {
-	// default true if two settings is false
-	if !(config.mysql || config.postgresql) {
+	// default true if other settings are false
+	if !config.hasDatabase() {
 		return true
 	}
 	return config.postgresql
@@ -129,11 +301,37 @@ func (config *Config) UsePostgreSQL() bool {
 
 // SetPostgresql sets that AcraServer should connect to PostgreSQL database
 func (config *Config) SetPostgresql(usePostgresql bool) error {
-	if config.mysql && usePostgresql {
-		return ErrTwoDBSetup
-	}
-	config.postgresql = usePostgresql
-	return nil
+	return config.setDatabase(&config.postgresql, usePostgresql)
+}
+
+// SetMongoDB sets that AcraServer should connect to MongoDB database
+func (config *Config) SetMongoDB(useMongoDB bool) error {
+	return config.setDatabase(&config.mongodb, useMongoDB)
+}
+
+// UseMongoDB returns if AcraServer should connect to MongoDB database
+func (config *Config) UseMongoDB() bool {
+	return config.mongodb
+}
+
+// SetCassandra sets that AcraServer should connect to Cassandra database
+func (config *Config) SetCassandra(useCassandra bool) error {
+	return config.setDatabase(&config.cassandra, useCassandra)
+}
+
+// UseCassandra returns if AcraServer should connect to Cassandra database
+func (config *Config) UseCassandra() bool {
+	return config.cassandra
+}
+
+// SetMSSQL sets that AcraServer should connect to Microsoft SQL Server database
+func (config *Config) SetMSSQL(useMSSQL bool) error {
+	return config.setDatabase(&config.mssql, useMSSQL)
+}
+
+// UseMSSQL returns if AcraServer should connect to Microsoft SQL Server database
+func (config *Config) UseMSSQL() bool {
+	return config.mssql
 }
 
 // GetTLSServerKeyPath returns path to TLS server certificate's key
@@ -317,6 +515,267 @@ func (config *Config) SetServerID(serverID []byte) error {
 	return nil
 }
 
+// SetScanConfiguredColumns sets whether AcraStructs are searched only in columns from encryptor config
+func (config *Config) SetScanConfiguredColumns(value bool) {
+	config.scanConfiguredColumns = value
+}
+
+// GetScanConfiguredColumns returns true if AcraStructs are searched only in columns from encryptor config
+func (config *Config) GetScanConfiguredColumns() bool {
+	return config.scanConfiguredColumns
+}
+
+// SetConsistentWrites sets whether writes which can't update encrypted columns together with their hash and index
+// columns are rejected
+func (config *Config) SetConsistentWrites(enable bool) {
+	config.consistentWrites = enable
+}
+
+// GetConsistentWrites returns true if writes which can't update companion columns in the same statement are rejected
+func (config *Config) GetConsistentWrites() bool {
+	return config.consistentWrites
+}
+
+// SetDBReadPipelineSize sets count of chunks read from database ahead while previous rows are decrypted
+func (config *Config) SetDBReadPipelineSize(size int) {
+	config.dbReadPipelineSize = size
+}
+
+// GetDBReadPipelineSize returns count of chunks read from database ahead, 0 if reading ahead turned off
+func (config *Config) GetDBReadPipelineSize() int {
+	return config.dbReadPipelineSize
+}
+
+// SetDBReadSpill sets configuration of encrypted temporary files for data read ahead from database when pipeline is full
+func (config *Config) SetDBReadSpill(spill *network.SpillConfig) {
+	config.dbReadSpill = spill
+}
+
+// GetDBReadSpill returns configuration of temporary files for data read ahead, nil if spilling turned off
+func (config *Config) GetDBReadSpill() *network.SpillConfig {
+	return config.dbReadSpill
+}
+
+// SetDBConnectRetries sets count of retries of failed connection to database and interval before first retry which
+// doubles before each next one
+func (config *Config) SetDBConnectRetries(retries int, interval time.Duration) {
+	config.dbConnectRetries = retries
+	config.dbConnectRetryInterval = interval
+}
+
+// GetDBConnectRetries returns count of retries of failed connection to database and interval before first retry
+func (config *Config) GetDBConnectRetries() (int, time.Duration) {
+	return config.dbConnectRetries, config.dbConnectRetryInterval
+}
+
+// SetLengthAudit sets whether lengths of data rows rewritten after decryption are checked before sending to client
+func (config *Config) SetLengthAudit(enable bool) {
+	config.lengthAudit = enable
+}
+
+// GetLengthAudit returns true if lengths of rewritten data rows are checked before sending to client
+func (config *Config) GetLengthAudit() bool {
+	return config.lengthAudit
+}
+
+// SetDLPSampler sets sampler which scans fraction of query results for sensitive data stored in plaintext
+func (config *Config) SetDLPSampler(sampler *base.DLPSampler) {
+	config.dlpSampler = sampler
+}
+
+// GetDLPSampler returns sampler of query results for DLP scanning or nil if scanning is turned off
+func (config *Config) GetDLPSampler() *base.DLPSampler {
+	return config.dlpSampler
+}
+
+// SetMySQLLocalInfile sets handling of LOAD DATA LOCAL INFILE of MySQL clients, maxSize limits size of uploaded
+// file in scan mode
+func (config *Config) SetMySQLLocalInfile(mode string, maxSize int64) error {
+	if err := mysql.ValidateLocalInfileMode(mode); err != nil {
+		return err
+	}
+	config.localInfilePolicy = mysql.LocalInfilePolicy{Mode: mode, MaxSize: maxSize}
+	return nil
+}
+
+// GetMySQLLocalInfilePolicy returns handling of LOAD DATA LOCAL INFILE with tables which have encrypted columns
+func (config *Config) GetMySQLLocalInfilePolicy() mysql.LocalInfilePolicy {
+	policy := config.localInfilePolicy
+	if config.encryptorConfig != nil {
+		policy.EncryptedTables = config.encryptorConfig
+	}
+	return policy
+}
+
+// SetDBRequireSSL sets whether connections which can't be switched to TLS between client, AcraServer and database
+// are refused
+func (config *Config) SetDBRequireSSL(require bool) {
+	config.dbRequireSSL = require
+}
+
+// GetDBRequireSSL returns true if connections which can't be switched to TLS are refused
+func (config *Config) GetDBRequireSSL() bool {
+	return config.dbRequireSSL
+}
+
+// SetDBTLSMode sets how connections to PostgreSQL are switched to TLS and how certificate of database is verified
+func (config *Config) SetDBTLSMode(mode postgresql.DBTLSMode) {
+	config.dbTLSMode = mode
+}
+
+// GetDBTLSMode returns mode of TLS with PostgreSQL, postgresql.DBTLSModeClient if TLS is used only on client's request
+func (config *Config) GetDBTLSMode() postgresql.DBTLSMode {
+	return config.dbTLSMode
+}
+
+// SetStatementStatsMaxCount sets max count of normalized statements tracked for query analytics, 0 turns tracking off
+func (config *Config) SetStatementStatsMaxCount(count int) {
+	config.statementStatsMaxCount = count
+}
+
+// GetStatementStatsMaxCount returns max count of tracked normalized statements, 0 if tracking is turned off
+func (config *Config) GetStatementStatsMaxCount() int {
+	return config.statementStatsMaxCount
+}
+
+// SetIPFilterConfig loads addresses allowed to connect and reloads them every reloadInterval if it's not 0
+func (config *Config) SetIPFilterConfig(ipFilterConfigPath string, reloadInterval time.Duration) error {
+	if ipFilterConfigPath == "" {
+		return nil
+	}
+	ipFilter, err := network.NewIPFilterFromFile(ipFilterConfigPath, reloadInterval)
+	if err != nil {
+		return err
+	}
+	config.ipFilter = ipFilter
+	return nil
+}
+
+// SetTransportListeners sets additional listeners of connections from AcraConnector with own transport wrappers
+func (config *Config) SetTransportListeners(listeners []*TransportListener) {
+	config.transportListeners = listeners
+}
+
+// GetTransportListeners returns additional listeners of connections from AcraConnector
+func (config *Config) GetTransportListeners() []*TransportListener {
+	return config.transportListeners
+}
+
+// SetHandshakeLimiter sets limiter of failed handshakes, nil turns off bans
+func (config *Config) SetHandshakeLimiter(limiter *network.HandshakeLimiter) {
+	config.handshakeLimiter = limiter
+}
+
+// GetHandshakeLimiter returns limiter of failed handshakes or nil if bans turned off
+func (config *Config) GetHandshakeLimiter() *network.HandshakeLimiter {
+	return config.handshakeLimiter
+}
+
+// SetHandshakeTimeout sets time to complete transport handshake of incoming connection, 0 turns off limit
+func (config *Config) SetHandshakeTimeout(timeout time.Duration) {
+	config.handshakeTimeout = timeout
+}
+
+// GetHandshakeTimeout returns time to complete transport handshake of incoming connection or 0 if it's not limited
+func (config *Config) GetHandshakeTimeout() time.Duration {
+	return config.handshakeTimeout
+}
+
+// SetDBStartupTimeout sets time to complete startup phase of database protocol, 0 turns off limit
+func (config *Config) SetDBStartupTimeout(timeout time.Duration) {
+	config.dbStartupTimeout = timeout
+}
+
+// GetDBStartupTimeout returns time to complete startup phase of database protocol or 0 if it's not limited
+func (config *Config) GetDBStartupTimeout() time.Duration {
+	return config.dbStartupTimeout
+}
+
+// SetCloseConnectionTimeout sets time to wait for active connections on drain before they are canceled
+func (config *Config) SetCloseConnectionTimeout(timeout time.Duration) {
+	config.closeConnectionTimeout = timeout
+}
+
+// GetCloseConnectionTimeout returns time to wait for active connections on drain before they are canceled
+func (config *Config) GetCloseConnectionTimeout() time.Duration {
+	return config.closeConnectionTimeout
+}
+
+// SetKeyCacheWarmer sets keystore which cached keys are shared with warm standby AcraServer, nil if keystore doesn't
+// support it
+func (config *Config) SetKeyCacheWarmer(cacheWarmer keystore.CacheWarmer) {
+	config.keyCacheWarmer = cacheWarmer
+}
+
+// GetKeyCacheWarmer returns keystore which cached keys are shared with warm standby AcraServer or nil
+func (config *Config) GetKeyCacheWarmer() keystore.CacheWarmer {
+	return config.keyCacheWarmer
+}
+
+// SetExpiryMonitor sets monitor of TLS certificate and keys expiration, nil if expiration isn't checked
+func (config *Config) SetExpiryMonitor(monitor *cmd.ExpiryMonitor) {
+	config.expiryMonitor = monitor
+}
+
+// GetExpiryMonitor returns monitor of TLS certificate and keys expiration or nil if expiration isn't checked
+func (config *Config) GetExpiryMonitor() *cmd.ExpiryMonitor {
+	return config.expiryMonitor
+}
+
+// SetRefuseExpiredKeys sets whether writes of values encrypted with expired keys are rejected
+func (config *Config) SetRefuseExpiredKeys(refuse bool) {
+	config.refuseExpiredKeys = refuse
+}
+
+// GetKeyExpiryChecker returns checker of keys expiration used to reject writes with expired keys or nil if writes
+// aren't rejected
+func (config *Config) GetKeyExpiryChecker() keystore.KeyExpiryChecker {
+	if !config.refuseExpiredKeys || config.expiryMonitor == nil {
+		return nil
+	}
+	return config.expiryMonitor
+}
+
+// SetZoneGenerationQuota sets quota of zones generated by HTTP API callers, nil turns off quotas
+func (config *Config) SetZoneGenerationQuota(quota *zone.GenerationQuota) {
+	config.zoneGenerationQuota = quota
+}
+
+// GetZoneGenerationQuota returns quota of zones generated by HTTP API callers or nil if quotas turned off
+func (config *Config) GetZoneGenerationQuota() *zone.GenerationQuota {
+	return config.zoneGenerationQuota
+}
+
+// SetZonesBatchMaxCount sets max count of zones generated by one batch request of HTTP API
+func (config *Config) SetZonesBatchMaxCount(count int) {
+	config.zonesBatchMaxCount = count
+}
+
+// GetZonesBatchMaxCount returns max count of zones generated by one batch request of HTTP API
+func (config *Config) GetZonesBatchMaxCount() int {
+	if config.zonesBatchMaxCount <= 0 {
+		return DEFAULT_ZONES_BATCH_MAX_COUNT
+	}
+	return config.zonesBatchMaxCount
+}
+
+// SetSchemaConnectionString sets connection string of database which schema AcraServer introspects to validate
+// encryptor configuration
+func (config *Config) SetSchemaConnectionString(connectionString string) {
+	config.schemaConnectionString = connectionString
+}
+
+// GetSchemaConnectionString returns connection string of database used for schema introspection or empty string if
+// introspection turned off
+func (config *Config) GetSchemaConnectionString() string {
+	return config.schemaConnectionString
+}
+
+// GetIPFilter returns filter of incoming connections or nil if all addresses allowed
+func (config *Config) GetIPFilter() *network.IPFilter {
+	return config.ipFilter
+}
+
 // GetWholeMatch returns if AcraServer assumes that whole database cell has one AcraStruct
 func (config *Config) GetWholeMatch() bool {
 	return config.wholeMatch
@@ -370,3 +829,50 @@ func (config *Config) SetTLSConfig(tlsConfig *tls.Config) {
 func (config *Config) GetTLSConfig() *tls.Config {
 	return config.tlsConfig
 }
+
+// SetDBClientCertificatesConfig loads certificates which are used in TLS connections to database for specific client ids
+func (config *Config) SetDBClientCertificatesConfig(dbClientCertificatesConfigPath string) error {
+	if dbClientCertificatesConfigPath == "" {
+		return nil
+	}
+	configuration, err := ioutil.ReadFile(dbClientCertificatesConfigPath)
+	if err != nil {
+		return err
+	}
+	config.dbClientCertificates, err = network.LoadClientCertificates(configuration)
+	return err
+}
+
+// GetTLSConfigForClientID returns TLS config which presents to database certificate configured for clientID or
+// default TLS config if clientID has no own certificate
+func (config *Config) GetTLSConfigForClientID(clientID []byte) *tls.Config {
+	return config.dbClientCertificates.ConfigForClientID(config.tlsConfig, clientID)
+}
+
+// SetHTTPAPIAuthProvidersConfig loads authentication providers of HTTP API requests from configuration file
+func (config *Config) SetHTTPAPIAuthProvidersConfig(providersConfigPath string) error {
+	if providersConfigPath == "" {
+		return nil
+	}
+	configuration, err := ioutil.ReadFile(providersConfigPath)
+	if err != nil {
+		return err
+	}
+	config.httpAPIAuthProvider, err = httpauth.LoadProviders(configuration)
+	return err
+}
+
+// GetHTTPAPIAuthProvider returns provider which authenticates HTTP API requests or nil if requests aren't authenticated
+func (config *Config) GetHTTPAPIAuthProvider() httpauth.Provider {
+	return config.httpAPIAuthProvider
+}
+
+// SetLifecycle sets hooks run before listening, after listening, before drain and after shutdown
+func (config *Config) SetLifecycle(lifecycle *cmd.Lifecycle) {
+	config.lifecycle = lifecycle
+}
+
+// GetLifecycle returns lifecycle hooks or nil if they aren't configured
+func (config *Config) GetLifecycle() *cmd.Lifecycle {
+	return config.lifecycle
+}
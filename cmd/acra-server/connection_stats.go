@@ -0,0 +1,115 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+)
+
+// registerConnectionStats creates counters for new client connection and adds them to list of active connections with
+// cancel of connection's context and zone of its session
+func (server *SServer) registerConnectionStats(clientID []byte, remoteAddress string, cancel context.CancelFunc, sessionZone *base.SessionZone) *base.ConnectionStats {
+	server.connectionStatsMutex.Lock()
+	defer server.connectionStatsMutex.Unlock()
+	server.lastConnectionID++
+	stats := base.NewConnectionStats(server.lastConnectionID, clientID, remoteAddress)
+	stats.Payload = server.payloadStats
+	stats.Statements = server.statementStats
+	server.connectionStats[stats.ID] = stats
+	server.connectionCancels[stats.ID] = cancel
+	server.connectionZones[stats.ID] = sessionZone
+	return stats
+}
+
+// unregisterConnectionStats removes counters of closed connection
+func (server *SServer) unregisterConnectionStats(stats *base.ConnectionStats) {
+	server.connectionStatsMutex.Lock()
+	defer server.connectionStatsMutex.Unlock()
+	delete(server.connectionStats, stats.ID)
+	delete(server.connectionCancels, stats.ID)
+	delete(server.connectionZones, stats.ID)
+}
+
+// GetConnectionsStats returns counters of active client connections ordered by connection id
+func (server *SServer) GetConnectionsStats() []base.ConnectionStatsSnapshot {
+	server.connectionStatsMutex.Lock()
+	snapshots := make([]base.ConnectionStatsSnapshot, 0, len(server.connectionStats))
+	for id, stats := range server.connectionStats {
+		snapshot := stats.Snapshot()
+		snapshot.SessionZoneID = string(server.connectionZones[id].Get())
+		snapshots = append(snapshots, snapshot)
+	}
+	server.connectionStatsMutex.Unlock()
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].ID < snapshots[j].ID })
+	return snapshots
+}
+
+// GetPayloadStats returns sizes of decrypted values aggregated per client and table since server start
+func (server *SServer) GetPayloadStats() []base.PayloadStatsSnapshot {
+	return server.payloadStats.Snapshot()
+}
+
+// ErrConnectionNotFound returned if there is no active client connection with requested id
+var ErrConnectionNotFound = errors.New("connection not found")
+
+// SetConnectionZone sets zone used to decrypt results of all following queries of active connection, nil resets zone
+func (server *SServer) SetConnectionZone(connectionID uint64, zoneID []byte) error {
+	server.connectionStatsMutex.Lock()
+	sessionZone, ok := server.connectionZones[connectionID]
+	server.connectionStatsMutex.Unlock()
+	if !ok {
+		return ErrConnectionNotFound
+	}
+	sessionZone.Set(zoneID)
+	return nil
+}
+
+// CancelConnection cancels context of active client connection, so connection is closed and its decryptions are stopped
+func (server *SServer) CancelConnection(connectionID uint64) error {
+	server.connectionStatsMutex.Lock()
+	cancel, ok := server.connectionCancels[connectionID]
+	server.connectionStatsMutex.Unlock()
+	if !ok {
+		return ErrConnectionNotFound
+	}
+	cancel()
+	return nil
+}
+
+// ErrStatementStatsDisabled returned if statements aren't tracked because statement_stats_max_count is 0
+var ErrStatementStatsDisabled = errors.New("statement stats are turned off")
+
+// GetStatementStats returns executions of normalized statements aggregated per client since server start or last reset
+func (server *SServer) GetStatementStats() ([]base.StatementStatsSnapshot, error) {
+	if server.statementStats == nil {
+		return nil, ErrStatementStatsDisabled
+	}
+	return server.statementStats.Snapshot(), nil
+}
+
+// ResetStatementStats removes all aggregated executions of statements
+func (server *SServer) ResetStatementStats() error {
+	if server.statementStats == nil {
+		return ErrStatementStatsDisabled
+	}
+	server.statementStats.Reset()
+	return nil
+}
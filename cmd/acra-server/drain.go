@@ -0,0 +1,183 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net"
+	"time"
+
+	"github.com/cossacklabs/acra/network"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// StartDrain marks that server stopped accepting new connections and waits for current connections. Returns false if
+// drain was already started
+func (server *SServer) StartDrain() bool {
+	server.drainMutex.Lock()
+	defer server.drainMutex.Unlock()
+	if !server.drainStartedAt.IsZero() {
+		return false
+	}
+	server.drainStartedAt = time.Now()
+	return true
+}
+
+// Drain stops accepting new connections and waits for current connections, connections which aren't closed in timeout
+// are canceled. API listener stops accepting after client connections are closed. Process isn't stopped, so it still exports metrics and may be stopped later with signal. Returns
+// ErrWaitTimeout if connections were canceled and nil if all connections were closed or drain was already started
+func (server *SServer) Drain(timeout time.Duration) error {
+	if !server.StartDrain() {
+		return nil
+	}
+	// API listener keeps accepting, so drain can be observed until connection listeners are drained
+	server.stopConnectionListeners()
+	defer stopListener(server.listenerAPI)
+	err := server.waitClientConnections(timeout)
+	if err == ErrWaitTimeout {
+		log.Warningf("Drain timeout: %d active connections will be cut", server.cmACRA.Count())
+		server.CancelConnections()
+		// let canceled connections close connections to database
+		server.waitClientConnections(canceledConnectionsCloseTimeout)
+		return err
+	}
+	log.Infoln("Drain completed, all connections closed")
+	return nil
+}
+
+// stopConnectionListeners stops accepting of new client connections by all listeners except API listener
+func (server *SServer) stopConnectionListeners() {
+	for _, listener := range server.listeners {
+		if listener != server.listenerAPI {
+			stopListener(listener)
+		}
+	}
+}
+
+// stopListener stops accepting of new connections by listener, nil listener is skipped
+func stopListener(listener net.Listener) {
+	if listener == nil {
+		return
+	}
+	deadlineListener, err := network.CastListenerToDeadline(listener)
+	if err != nil {
+		log.WithError(err).Warningln("Can't cast listener")
+		return
+	}
+	if err := stopAcceptConnections(deadlineListener); err != nil {
+		log.WithError(err).Warningln("Can't set deadline for listener")
+	}
+}
+
+// waitClientConnections waits until client connections are closed, returns ErrWaitTimeout if they aren't closed in
+// timeout
+func (server *SServer) waitClientConnections(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	wait := make(chan struct{})
+	go func() {
+		server.cmACRA.Wait()
+		close(wait)
+	}()
+	select {
+	case <-timer.C:
+		return ErrWaitTimeout
+	case <-wait:
+		return nil
+	}
+}
+
+// GetDrainDuration returns how long server drains connections and false if drain wasn't started
+func (server *SServer) GetDrainDuration() (time.Duration, bool) {
+	server.drainMutex.Lock()
+	defer server.drainMutex.Unlock()
+	if server.drainStartedAt.IsZero() {
+		return 0, false
+	}
+	return time.Since(server.drainStartedAt), true
+}
+
+var connectionAgeBuckets = []float64{0.1, 0.2, 0.5, 1, 10, 60, 3600, 86400}
+
+// drainCollector exports state of drain and ages of connections which are still open at the time of scraping
+type drainCollector struct {
+	server             *SServer
+	drainingDesc       *prometheus.Desc
+	drainDurationDesc  *prometheus.Desc
+	connectionsDesc    *prometheus.Desc
+	oldestAgeDesc      *prometheus.Desc
+	connectionsAgeDesc *prometheus.Desc
+}
+
+func newDrainCollector(server *SServer) *drainCollector {
+	return &drainCollector{
+		server: server,
+		drainingDesc: prometheus.NewDesc("acraserver_draining",
+			"1 if server stopped accepting new connections and waits for current connections, 0 otherwise", nil, nil),
+		drainDurationDesc: prometheus.NewDesc("acraserver_drain_duration_seconds",
+			"Time since start of drain", nil, nil),
+		connectionsDesc: prometheus.NewDesc("acraserver_active_connections",
+			"Number of connections which are still open", []string{connectionTypeLabel}, nil),
+		oldestAgeDesc: prometheus.NewDesc("acraserver_oldest_connection_age_seconds",
+			"Age of the oldest open connection", []string{connectionTypeLabel}, nil),
+		connectionsAgeDesc: prometheus.NewDesc("acraserver_connections_age_seconds",
+			"Ages of open connections", []string{connectionTypeLabel}, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (collector *drainCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.drainingDesc
+	ch <- collector.drainDurationDesc
+	ch <- collector.connectionsDesc
+	ch <- collector.oldestAgeDesc
+	ch <- collector.connectionsAgeDesc
+}
+
+// Collect implements prometheus.Collector
+func (collector *drainCollector) Collect(ch chan<- prometheus.Metric) {
+	drainDuration, draining := collector.server.GetDrainDuration()
+	drainingValue := 0.0
+	if draining {
+		drainingValue = 1
+	}
+	ch <- prometheus.MustNewConstMetric(collector.drainingDesc, prometheus.GaugeValue, drainingValue)
+	ch <- prometheus.MustNewConstMetric(collector.drainDurationDesc, prometheus.GaugeValue, drainDuration.Seconds())
+	collector.collectConnections(ch, dbConnectionType, collector.server.cmACRA)
+	collector.collectConnections(ch, apiConnectionType, collector.server.cmAPI)
+}
+
+func (collector *drainCollector) collectConnections(ch chan<- prometheus.Metric, connectionType string, manager *network.ConnectionManager) {
+	ages := manager.GetConnectionsAges()
+	var oldest, sum float64
+	buckets := make(map[float64]uint64, len(connectionAgeBuckets))
+	for _, age := range ages {
+		seconds := age.Seconds()
+		sum += seconds
+		if seconds > oldest {
+			oldest = seconds
+		}
+		for _, bucket := range connectionAgeBuckets {
+			if seconds <= bucket {
+				buckets[bucket]++
+			}
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(collector.connectionsDesc, prometheus.GaugeValue, float64(len(ages)), connectionType)
+	ch <- prometheus.MustNewConstMetric(collector.oldestAgeDesc, prometheus.GaugeValue, oldest, connectionType)
+	ch <- prometheus.MustNewConstHistogram(collector.connectionsAgeDesc, uint64(len(ages)), sum, buckets, connectionType)
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestDrainServer(t *testing.T) (*SServer, net.Listener) {
+	server, err := NewServer(NewConfig(), nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.addListener(listener)
+	return server, listener
+}
+
+func TestDrainWaitsConnections(t *testing.T) {
+	server, listener := newTestDrainServer(t)
+	defer listener.Close()
+	connection, _ := net.Pipe()
+	server.cmACRA.AddConnection(connection)
+
+	result := make(chan error, 1)
+	go func() {
+		result <- server.Drain(time.Minute)
+	}()
+	// drain doesn't complete while connection is active
+	select {
+	case err := <-result:
+		t.Fatalf("Drain completed with active connection: %v", err)
+	case <-time.After(time.Millisecond * 100):
+	}
+	if _, draining := server.GetDrainDuration(); !draining {
+		t.Fatal("Expected started drain")
+	}
+	if _, err := listener.Accept(); err == nil {
+		t.Fatal("Expected stopped accepting of new connections")
+	} else if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+		t.Fatalf("Expected timeout error, took %v", err)
+	}
+	// repeated drain doesn't wait again
+	if err := server.Drain(time.Minute); err != nil {
+		t.Fatalf("Expected nil on repeated drain, took %v", err)
+	}
+
+	server.cmACRA.RemoveConnection(connection)
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("Expected nil after closing of connections, took %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Drain didn't complete after closing of connections")
+	}
+	if server.ctx.Err() != nil {
+		t.Fatal("Expected not canceled connections after drain")
+	}
+}
+
+func TestDrainCancelsConnectionsAfterTimeout(t *testing.T) {
+	server, listener := newTestDrainServer(t)
+	defer listener.Close()
+	connection, _ := net.Pipe()
+	server.cmACRA.AddConnection(connection)
+	go func() {
+		// connection is closed after it's canceled
+		<-server.ctx.Done()
+		server.cmACRA.RemoveConnection(connection)
+	}()
+	if err := server.Drain(time.Millisecond * 100); err != ErrWaitTimeout {
+		t.Fatalf("Expected %v, took %v", ErrWaitTimeout, err)
+	}
+	if server.ctx.Err() == nil {
+		t.Fatal("Expected canceled connections after drain timeout")
+	}
+	if server.ConnectionsCounter() != 0 {
+		t.Fatalf("Expected closed connections, took %v", server.ConnectionsCounter())
+	}
+}
+
+func TestDrainKeepsAPIListenerUntilConnectionsClosed(t *testing.T) {
+	server, listener := newTestDrainServer(t)
+	defer listener.Close()
+	apiListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer apiListener.Close()
+	server.listenerAPI = apiListener
+	server.addListener(apiListener)
+	connection, _ := net.Pipe()
+	server.cmACRA.AddConnection(connection)
+
+	result := make(chan error, 1)
+	go func() {
+		result <- server.Drain(time.Minute)
+	}()
+	apiConnection, err := net.Dial("tcp", apiListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer apiConnection.Close()
+	if _, err := apiListener.Accept(); err != nil {
+		t.Fatalf("API listener should accept connections while drain waits, took %v", err)
+	}
+
+	server.cmACRA.RemoveConnection(connection)
+	if err := <-result; err != nil {
+		t.Fatalf("Expected nil after closing of connections, took %v", err)
+	}
+	if _, err := apiListener.Accept(); err == nil {
+		t.Fatal("Expected stopped accepting of API connections after drain")
+	}
+}
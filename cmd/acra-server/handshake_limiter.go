@@ -0,0 +1,98 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/network"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrHandshakeBanned returned if connection rejected because its source address banned after failed handshakes
+var ErrHandshakeBanned = errors.New("source address banned after failed handshakes")
+
+// handshakeIPKey returns key of source address for HandshakeLimiter or empty string if address has no IP
+func handshakeIPKey(address net.Addr) string {
+	if _, ok := address.(*net.TCPAddr); !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(address.String())
+	if err != nil {
+		return ""
+	}
+	return ipBanType + ":" + host
+}
+
+// addHandshakeFailure registers failed handshake of key and logs if key became banned
+func addHandshakeFailure(limiter *network.HandshakeLimiter, key, banType string, logger *log.Entry) {
+	if key == "" {
+		return
+	}
+	if ban := limiter.AddFailure(key); ban > 0 {
+		handshakeBansCounter.WithLabelValues(banType).Inc()
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeWarningHandshakeBanning).
+			Warningf("Too many failed handshakes, %s banned for %v", banType, ban)
+	}
+}
+
+// wrapServerConnection wraps connection with connectionWrapper. Connections from banned source addresses are rejected
+// before handshake. Failed handshakes are counted only against source address: client id becomes known only after
+// successful handshake authenticated it, so peer can't get legitimate client banned by failing handshakes with its id
+func (server *SServer) wrapServerConnection(connection net.Conn, connectionWrapper network.ConnectionWrapper) (net.Conn, []byte, error) {
+	limiter := server.config.GetHandshakeLimiter()
+	ipKey := handshakeIPKey(connection.RemoteAddr())
+	logger := log.WithField("remote_address", connection.RemoteAddr().String())
+	if ipKey != "" && limiter.IsBanned(ipKey) {
+		handshakeRejectedCounter.WithLabelValues(ipBanType).Inc()
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorHandshakeBanned).
+			Warningln("Rejected connection from banned source address")
+		return connection, nil, ErrHandshakeBanned
+	}
+	// stalled handshakes are dropped, so slow clients can't hold goroutines and connections indefinitely
+	timeout := server.config.GetHandshakeTimeout()
+	if timeout > 0 {
+		if err := connection.SetDeadline(time.Now().Add(timeout)); err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorDecryptorCantSetDeadlineToClientConnection).
+				Warningln("Can't set deadline of transport handshake")
+		}
+	}
+	wrappedConnection, clientID, err := connectionWrapper.WrapServer(connection)
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			handshakeTimeoutsCounter.WithLabelValues(transportStage).Inc()
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorHandshakeTimeout).
+				Warningf("Transport handshake wasn't completed in %v, drop connection", timeout)
+		}
+		handshakeFailuresCounter.Inc()
+		addHandshakeFailure(limiter, ipKey, ipBanType, logger)
+		return wrappedConnection, nil, err
+	}
+	if timeout > 0 {
+		if err := connection.SetDeadline(time.Time{}); err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorDecryptorCantSetDeadlineToClientConnection).
+				Warningln("Can't reset deadline after transport handshake")
+		}
+	}
+	if ipKey != "" {
+		limiter.AddSuccess(ipKey)
+	}
+	return wrappedConnection, clientID, nil
+}
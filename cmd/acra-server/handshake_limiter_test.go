@@ -0,0 +1,72 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/network"
+)
+
+// claimingConnectionWrapper fails handshake after peer claimed client id
+type claimingConnectionWrapper struct {
+	network.RawConnectionWrapper
+}
+
+func (*claimingConnectionWrapper) WrapServer(conn net.Conn) (net.Conn, []byte, error) {
+	return conn, []byte("claimed client"), errors.New("handshake failed")
+}
+
+// tcpPipeConn is end of net.Pipe with TCP remote address
+type tcpPipeConn struct {
+	net.Conn
+}
+
+func (tcpPipeConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5432}
+}
+
+func TestWrapServerConnectionCountsFailuresByAddress(t *testing.T) {
+	config := NewConfig()
+	limiter := network.NewHandshakeLimiter(1, time.Minute, time.Minute)
+	config.SetHandshakeLimiter(limiter)
+	server, err := NewServer(config, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, serverConn := net.Pipe()
+	defer client.Close()
+	connection := tcpPipeConn{serverConn}
+	defer connection.Close()
+
+	_, clientID, err := server.wrapServerConnection(connection, &claimingConnectionWrapper{})
+	if err == nil {
+		t.Fatal("Expected handshake error")
+	}
+	if clientID != nil {
+		t.Fatal("Unauthenticated client id returned with error")
+	}
+	if !limiter.IsBanned(handshakeIPKey(connection.RemoteAddr())) {
+		t.Fatal("Source address should be banned after failed handshake")
+	}
+	if _, _, err := server.wrapServerConnection(connection, &claimingConnectionWrapper{}); err != ErrHandshakeBanned {
+		t.Fatalf("Expected ErrHandshakeBanned, took %v", err)
+	}
+}
@@ -17,12 +17,15 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"net"
 	url_ "net/url"
 	"os"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/cossacklabs/acra/cmd"
 	"github.com/cossacklabs/acra/decryptor/base"
 	"github.com/cossacklabs/acra/decryptor/mysql"
 	pg "github.com/cossacklabs/acra/decryptor/postgresql"
@@ -49,10 +52,34 @@ type SServer struct {
 	errorSignalChannel    chan os.Signal
 	restartSignalsChannel chan os.Signal
 	connectionsToClose    map[net.Conn]struct{}
+	drainMutex            sync.Mutex
+	drainStartedAt        time.Time
+	connectionStatsMutex  sync.Mutex
+	connectionStats       map[uint64]*base.ConnectionStats
+	// cancels of contexts of active client connections by connection id
+	connectionCancels map[uint64]context.CancelFunc
+	// zones of sessions of active client connections by connection id
+	connectionZones  map[uint64]*base.SessionZone
+	lastConnectionID uint64
+	// ctx is parent of contexts of all client connections, cancelConnections cancels it
+	ctx               context.Context
+	cancelConnections context.CancelFunc
+	// sizes of decrypted values aggregated over all connections
+	payloadStats *base.PayloadStats
+	// executions of normalized statements aggregated over all connections, nil if tracking is turned off
+	statementStats *base.StatementStats
+	// additional listeners with own transport wrappers in same order as in config
+	transportListenersMutex sync.Mutex
+	transportListeners      []net.Listener
 }
 
 // NewServer creates new SServer.
 func NewServer(config *Config, keystorage keystore.KeyStore, errorChan chan os.Signal, restarChan chan os.Signal) (server *SServer, err error) {
+	var statementStats *base.StatementStats
+	if config.GetStatementStatsMaxCount() > 0 {
+		statementStats = base.NewStatementStats(config.GetStatementStatsMaxCount())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
 	return &SServer{
 		config:                config,
 		keystorage:            keystorage,
@@ -61,6 +88,14 @@ func NewServer(config *Config, keystorage keystore.KeyStore, errorChan chan os.S
 		errorSignalChannel:    errorChan,
 		restartSignalsChannel: restarChan,
 		connectionsToClose:    make(map[net.Conn]struct{}),
+		connectionStats:       make(map[uint64]*base.ConnectionStats),
+		connectionCancels:     make(map[uint64]context.CancelFunc),
+		connectionZones:       make(map[uint64]*base.SessionZone),
+		ctx:                   ctx,
+		cancelConnections:     cancel,
+		payloadStats:          base.NewPayloadStats(),
+		statementStats:        statementStats,
+		transportListeners:    make([]net.Listener, len(config.GetTransportListeners())),
 	}, nil
 }
 
@@ -109,7 +144,16 @@ func (server *SServer) addListener(listener net.Listener) {
 	server.listeners = append(server.listeners, listener)
 }
 
-func (server *SServer) getDecryptor(clientID []byte) base.Decryptor {
+// connectionKeyStore returns keystore which records key loads on behalf of connection if key access audit is enabled
+func (server *SServer) connectionKeyStore(clientID []byte, connection net.Conn) keystore.KeyStore {
+	if auditKeyStore, ok := server.keystorage.(keystore.ConnectionAuditKeyStore); ok {
+		return auditKeyStore.WithConnection(clientID, connection.RemoteAddr().String())
+	}
+	return server.keystorage
+}
+
+// getDecryptor returns decryptor of client connection which stops decryptions when ctx of connection is done
+func (server *SServer) getDecryptor(ctx context.Context, clientID []byte, keystorage keystore.KeyStore) base.Decryptor {
 	var dataDecryptor base.DataDecryptor
 	var matcherPool *zone.MatcherPool
 	if server.config.GetByteaFormat() == HEX_BYTEA_FORMAT {
@@ -122,22 +166,28 @@ func (server *SServer) getDecryptor(clientID []byte) base.Decryptor {
 	pgDecryptorImpl := pg.NewPgDecryptor(clientID, dataDecryptor)
 	pgDecryptorImpl.SetWithZone(server.config.GetWithZone())
 	pgDecryptorImpl.SetWholeMatch(server.config.GetWholeMatch())
-	pgDecryptorImpl.SetKeyStore(server.keystorage)
-	zoneMatcher := zone.NewZoneMatcher(matcherPool, server.keystorage)
+	pgDecryptorImpl.SetKeyStore(keystorage)
+	zoneMatcher := zone.NewZoneMatcher(matcherPool, keystorage)
 	pgDecryptorImpl.SetZoneMatcher(zoneMatcher)
 
 	poisonCallbackStorage := base.NewPoisonCallbackStorage()
 	if server.config.GetScriptOnPoison() != "" {
 		poisonCallbackStorage.AddCallback(base.NewExecuteScriptCallback(server.config.GetScriptOnPoison()))
 	}
+	if containment := base.GetPoisonContainment(); containment != nil {
+		poisonCallbackStorage.AddCallback(containment)
+	}
 	// must be last
 	if server.config.GetStopOnPoison() {
 		poisonCallbackStorage.AddCallback(&base.StopCallback{})
 	}
 	pgDecryptorImpl.SetPoisonCallbackStorage(poisonCallbackStorage)
 	var decryptor base.Decryptor = pgDecryptorImpl
-	if server.config.UseMySQL() {
-		decryptor = mysql.NewMySQLDecryptor(clientID, pgDecryptorImpl, server.keystorage)
+	// MongoDB, Cassandra and MSSQL store AcraStructs as binary values same as MySQL
+	if server.config.UseMySQL() || server.config.UseMongoDB() || server.config.UseCassandra() || server.config.UseMSSQL() {
+		mysqlDecryptor := mysql.NewMySQLDecryptor(clientID, pgDecryptorImpl, keystorage)
+		mysqlDecryptor.SetContext(ctx)
+		decryptor = mysqlDecryptor
 	}
 	decryptor.TurnOnPoisonRecordCheck(server.config.DetectPoisonRecords())
 	return decryptor
@@ -147,14 +197,20 @@ func (server *SServer) getDecryptor(clientID []byte) base.Decryptor {
 handle new connection by initializing secure session, starting proxy request
 to db and decrypting responses from db
 */
-func (server *SServer) handleConnection(connection net.Conn) {
+func (server *SServer) handleConnection(connection net.Conn, workers *cmd.WorkerPool) {
+	server.handleConnectionWithWrapper(connection, server.config.ConnectionWrapper, workers)
+}
+
+// handleConnectionWithWrapper handles connection from AcraConnector which uses transport of connectionWrapper. Proxying
+// of connection runs on workers of listener's pool if it isn't nil
+func (server *SServer) handleConnectionWithWrapper(connection net.Conn, connectionWrapper network.ConnectionWrapper, workers *cmd.WorkerPool) {
 	connectionCounter.WithLabelValues(dbConnectionType).Inc()
 	timer := prometheus.NewTimer(prometheus.ObserverFunc(connectionProcessingTimeHistogram.WithLabelValues(dbConnectionType).Observe))
 	defer timer.ObserveDuration()
-	server.cmACRA.Incr()
-	defer server.cmACRA.Done()
+	server.cmACRA.AddConnection(connection)
+	defer server.cmACRA.RemoveConnection(connection)
 	log.Infof("Handle new connection")
-	wrappedConnection, clientID, err := server.config.ConnectionWrapper.WrapServer(connection)
+	wrappedConnection, clientID, err := server.wrapServerConnection(connection, connectionWrapper)
 	if err != nil {
 		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantWrapConnection).
 			Errorln("Can't wrap connection from acra-connector")
@@ -164,8 +220,10 @@ func (server *SServer) handleConnection(connection net.Conn) {
 		}
 		return
 	}
-	clientSession, err := NewClientSession(server.keystorage, server.config, connection)
+	keystorage := server.connectionKeyStore(clientID, connection)
+	clientSession, err := NewClientSession(keystorage, server.config, connection)
 	clientSession.Server = server
+	clientSession.workers = workers
 	if err != nil {
 		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantInitClientSession).
 			Errorln("Can't initialize client session")
@@ -175,11 +233,51 @@ func (server *SServer) handleConnection(connection net.Conn) {
 		}
 		return
 	}
-	clientSession.connection = wrappedConnection
-	decryptor := server.getDecryptor(clientID)
-	clientSession.HandleClientConnection(clientID, decryptor)
+	ctx, cancel := context.WithCancel(server.ctx)
+	defer cancel()
+	sessionZone := &base.SessionZone{}
+	connectionStats := server.registerConnectionStats(clientID, connection.RemoteAddr().String(), cancel, sessionZone)
+	defer server.unregisterConnectionStats(connectionStats)
+	clientSession.connectionStats = connectionStats
+	clientSession.sessionZone = sessionZone
+	clientSession.connection = connectionStats.WrapConnection(wrappedConnection)
+	decryptor := server.getDecryptor(ctx, clientID, keystorage)
+	clientSession.HandleClientConnection(ctx, clientID, decryptor)
+}
+
+// newWorkerPool returns pool of count workers pinned to cpus for new listener or nil if cpus aren't set
+func newWorkerPool(cpus []int, count int) *cmd.WorkerPool {
+	if len(cpus) == 0 {
+		return nil
+	}
+	return cmd.NewWorkerPool(cpus, count)
 }
 
+// handleOnWorkers returns connection handler which runs handler on worker of pool. Connection is closed if server is
+// stopped while it waits for free worker
+func (server *SServer) handleOnWorkers(workers *cmd.WorkerPool, handler func(net.Conn)) func(net.Conn) {
+	if workers == nil {
+		return handler
+	}
+	return func(connection net.Conn) {
+		done := make(chan struct{})
+		err := workers.Go(server.ctx, func() {
+			defer close(done)
+			handler(connection)
+		})
+		if err != nil {
+			log.WithError(err).Warningln("Can't handle connection on workers")
+			if closeErr := connection.Close(); closeErr != nil {
+				log.WithError(closeErr).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantCloseConnection).
+					Errorln("Can't close connection")
+			}
+			return
+		}
+		<-done
+	}
+}
+
+// start accepts connections from listener and handles each connection in new goroutine
 func (server *SServer) start(listener net.Listener, connectionHandler func(net.Conn), logger *log.Entry) {
 	logger.Infof("Start listening connections")
 	for {
@@ -194,6 +292,9 @@ func (server *SServer) start(listener net.Listener, connectionHandler func(net.C
 				Errorln("Can't accept new connection")
 			continue
 		}
+		if !server.config.GetIPFilter().AcceptConnection(connection) {
+			continue
+		}
 		// unix socket and value == '@'
 		if len(connection.RemoteAddr().String()) == 1 {
 			logger.Infof("Got new connection to AcraServer: %v", connection.LocalAddr())
@@ -221,7 +322,11 @@ func (server *SServer) Start() {
 	}
 	server.listenerACRA = listener
 	server.addListener(listener)
-	server.start(listener, server.handleConnection, logger)
+	go server.config.GetLifecycle().Run(cmd.LifecyclePostListen)
+	workers := newWorkerPool(server.config.GetConnectionCPUs(), server.config.GetConnectionWorkers())
+	server.start(listener, func(connection net.Conn) {
+		server.handleConnection(connection, workers)
+	}, logger)
 }
 
 // StartFromFileDescriptor starts listening Acra data connections from file descriptor.
@@ -249,7 +354,11 @@ func (server *SServer) StartFromFileDescriptor(fd uintptr) {
 	}
 	server.listenerACRA = listenerWithFileDescriptor
 	server.addListener(listenerWithFileDescriptor)
-	server.start(listenerWithFileDescriptor, server.handleConnection, logger)
+	go server.config.GetLifecycle().Run(cmd.LifecyclePostListen)
+	workers := newWorkerPool(server.config.GetConnectionCPUs(), server.config.GetConnectionWorkers())
+	server.start(listenerWithFileDescriptor, func(connection net.Conn) {
+		server.handleConnection(connection, workers)
+	}, logger)
 }
 
 // stopAcceptConnections stop accepting by setting deadline and then background code that call Accept will took error and
@@ -300,6 +409,12 @@ func (server *SServer) WaitConnections(duration time.Duration) {
 	}
 }
 
+// CancelConnections cancels contexts of all client connections, so they are closed and their decryptions and
+// connecting to database are stopped. Connections accepted after call are canceled immediately
+func (server *SServer) CancelConnections() {
+	server.cancelConnections()
+}
+
 // WaitWithTimeout waits until connection complete or stops them after duration time.
 func (server *SServer) WaitWithTimeout(duration time.Duration) error {
 	timeout := time.NewTimer(duration)
@@ -319,7 +434,7 @@ func (server *SServer) WaitWithTimeout(duration time.Duration) error {
 
 // ConnectionsCounter counts number of active data and API connections.
 func (server *SServer) ConnectionsCounter() int {
-	return server.cmACRA.Counter + server.cmAPI.Counter
+	return server.cmACRA.Count() + server.cmAPI.Count()
 }
 
 /*
@@ -330,10 +445,10 @@ func (server *SServer) handleCommandsConnection(connection net.Conn) {
 	connectionCounter.WithLabelValues(apiConnectionType).Inc()
 	timer := prometheus.NewTimer(prometheus.ObserverFunc(connectionProcessingTimeHistogram.WithLabelValues(apiConnectionType).Observe))
 	defer timer.ObserveDuration()
-	server.cmAPI.Incr()
-	defer server.cmAPI.Done()
+	server.cmAPI.AddConnection(connection)
+	defer server.cmAPI.RemoveConnection(connection)
 	log.Infof("Handle commands connection")
-	clientSession, err := NewClientCommandsSession(server.keystorage, server.config, connection)
+	clientSession, err := NewClientCommandsSession(server.connectionKeyStore(nil, connection), server.config, connection)
 	clientSession.Server = server
 	if err != nil {
 		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantStartConnection).
@@ -341,7 +456,7 @@ func (server *SServer) handleCommandsConnection(connection net.Conn) {
 		return
 	}
 
-	wrappedConnection, _, err := server.config.ConnectionWrapper.WrapServer(connection)
+	wrappedConnection, _, err := server.wrapServerConnection(connection, server.config.ConnectionWrapper)
 	if err != nil {
 		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantWrapConnection).
 			Errorln("Can't wrap API connection")
@@ -363,7 +478,8 @@ func (server *SServer) StartCommands() {
 	}
 	server.listenerAPI = listener
 	server.addListener(listener)
-	server.start(listener, server.handleCommandsConnection, logger)
+	workers := newWorkerPool(server.config.GetAPIConnectionCPUs(), server.config.GetAPIConnectionWorkers())
+	server.start(listener, server.handleOnWorkers(workers, server.handleCommandsConnection), logger)
 }
 
 // StartCommandsFromFileDescriptor starts listening commands connections from file descriptor.
@@ -390,5 +506,6 @@ func (server *SServer) StartCommandsFromFileDescriptor(fd uintptr) {
 	}
 	server.listenerAPI = listenerWithFileDescriptor
 	server.addListener(listenerWithFileDescriptor)
-	server.start(listenerWithFileDescriptor, server.handleCommandsConnection, logger)
+	workers := newWorkerPool(server.config.GetAPIConnectionCPUs(), server.config.GetAPIConnectionWorkers())
+	server.start(listenerWithFileDescriptor, server.handleOnWorkers(workers, server.handleCommandsConnection), logger)
 }
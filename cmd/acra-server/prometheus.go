@@ -21,6 +21,11 @@ const (
 	connectionTypeLabel = "connection_type"
 	apiConnectionType   = "api"
 	dbConnectionType    = "db"
+	banTypeLabel        = "ban_type"
+	ipBanType           = "ip"
+	stageLabel          = "stage"
+	transportStage      = "transport"
+	dbStartupStage      = "db_startup"
 )
 
 var (
@@ -35,9 +40,37 @@ var (
 		Help:    "Time of connection processing",
 		Buckets: []float64{0.1, 0.2, 0.5, 1, 10, 60, 3600, 86400},
 	}, []string{connectionTypeLabel})
+
+	handshakeFailuresCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "acraserver_handshake_failures_total",
+			Help: "number of failed transport handshakes",
+		})
+
+	handshakeBansCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "acraserver_handshake_bans_total",
+			Help: "number of bans of source addresses after failed handshakes",
+		}, []string{banTypeLabel})
+
+	handshakeRejectedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "acraserver_handshake_rejected_connections_total",
+			Help: "number of connections rejected because source address banned",
+		}, []string{banTypeLabel})
+
+	handshakeTimeoutsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "acraserver_handshake_timeouts_total",
+			Help: "number of connections dropped because transport handshake or database startup wasn't completed in time",
+		}, []string{stageLabel})
 )
 
 func init() {
 	prometheus.MustRegister(connectionCounter)
 	prometheus.MustRegister(connectionProcessingTimeHistogram)
+	prometheus.MustRegister(handshakeFailuresCounter)
+	prometheus.MustRegister(handshakeBansCounter)
+	prometheus.MustRegister(handshakeRejectedCounter)
+	prometheus.MustRegister(handshakeTimeoutsCounter)
 }
@@ -0,0 +1,65 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/cossacklabs/acra/encryptor"
+	"github.com/cossacklabs/acra/logging"
+	_ "github.com/go-sql-driver/mysql" // driver used to introspect schema of MySQL
+	_ "github.com/lib/pq"              // driver used to introspect schema of PostgreSQL
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrSchemaIntrospectionDisabled returned when schema introspection requested without connection string or encryptor
+// configuration
+var ErrSchemaIntrospectionDisabled = errors.New("schema introspection isn't configured")
+
+// ValidateEncryptorSchema reads schema of database and returns warnings about columns from encryptor configuration
+// which don't exist or have unsuitable types
+func (config *Config) ValidateEncryptorSchema() ([]encryptor.SchemaWarning, error) {
+	if config.GetSchemaConnectionString() == "" || config.GetEncryptorConfig() == nil {
+		return nil, ErrSchemaIntrospectionDisabled
+	}
+	driverName := "postgres"
+	if config.UseMySQL() {
+		driverName = "mysql"
+	}
+	db, err := sql.Open(driverName, config.GetSchemaConnectionString())
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	schema, err := encryptor.IntrospectSchema(db, config.UseMySQL())
+	if err != nil {
+		return nil, err
+	}
+	return config.GetEncryptorConfig().ValidateSchema(schema), nil
+}
+
+// logSchemaWarnings logs mismatches between encryptor configuration and database schema
+func logSchemaWarnings(warnings []encryptor.SchemaWarning) {
+	for _, warning := range warnings {
+		log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeWarningEncryptorSchemaMismatch,
+			"table": warning.Table, "column": warning.Column}).Warningln("Encryptor configuration doesn't match database schema: " + warning.Message)
+	}
+	if len(warnings) == 0 {
+		log.Infoln("Encryptor configuration matches database schema")
+	}
+}
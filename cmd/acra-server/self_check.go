@@ -0,0 +1,93 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/cossacklabs/acra/acra-censor"
+	"github.com/cossacklabs/acra/cmd"
+	"github.com/cossacklabs/acra/keystore"
+)
+
+// selfCheckDBTimeout limits time of connection to database during startup self-check
+const selfCheckDBTimeout = 5 * time.Second
+
+// selfCheckParams holds configuration verified by startup self-check
+type selfCheckParams struct {
+	// loadMasterKey is nil if master key isn't used
+	loadMasterKey     func() ([]byte, error)
+	keysDir           string
+	keystoreType      string
+	tlsCertPath       string
+	tlsCAPath         string
+	tlsExpiryWarnDays int
+	dbHost            string
+	dbPort            int
+	censorConfigPath  string
+}
+
+// checkCensorConfig loads AcraCensor configuration to verify that it is valid
+func checkCensorConfig(censorConfigPath string) (string, error) {
+	if censorConfigPath == "" {
+		return "", cmd.ErrSelfCheckSkipped
+	}
+	configuration, err := ioutil.ReadFile(censorConfigPath)
+	if err != nil {
+		return "", err
+	}
+	if err := acracensor.NewAcraCensor().LoadConfiguration(configuration); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s loaded", censorConfigPath), nil
+}
+
+// runSelfCheck verifies keystore, master key, TLS certificates, connectivity to database and AcraCensor configuration
+// and logs single report. Unavailable database doesn't stop AcraServer because connections to it are opened per client
+func runSelfCheck(params selfCheckParams) *cmd.SelfCheckReport {
+	checks := []cmd.SelfCheck{
+		{Name: "master_key", Fatal: true, Run: func() (string, error) {
+			if params.loadMasterKey == nil {
+				// keys are encrypted in HSM without master key
+				return "", cmd.ErrSelfCheckSkipped
+			}
+			return cmd.CheckMasterKey(params.loadMasterKey)
+		}},
+		{Name: "keystore", Fatal: true, Run: func() (string, error) {
+			if params.keystoreType != keystore.DefaultBackendType {
+				return "", cmd.ErrSelfCheckSkipped
+			}
+			return cmd.CheckKeysDirReadWrite(params.keysDir)
+		}},
+		{Name: "tls_certificate", Fatal: true, Run: func() (string, error) {
+			return cmd.CheckTLSCertificate(params.tlsCertPath, params.tlsCAPath, params.tlsExpiryWarnDays)
+		}},
+		{Name: "database", Fatal: false, Run: func() (string, error) {
+			return cmd.CheckTCPConnectivity(net.JoinHostPort(params.dbHost, strconv.Itoa(params.dbPort)), selfCheckDBTimeout)
+		}},
+		{Name: "censor_config", Fatal: true, Run: func() (string, error) {
+			return checkCensorConfig(params.censorConfigPath)
+		}},
+	}
+	report := cmd.RunSelfChecks(checks)
+	report.Log()
+	return report
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"net/url"
+
+	"github.com/cossacklabs/acra/cmd/acra-connector/connector-mode"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/filesystem"
+	"github.com/cossacklabs/acra/network"
+)
+
+// NewStandbyConnectionWrapper returns wrapper of connections of warm standby AcraServer to HTTP API of active one at
+// activeAPIURL. Standby authenticates as AcraConnector with clientID and checks identity of active AcraServer: with
+// TLS certificates of tlsConfig if it isn't nil, otherwise with Secure Session keys of clientID from keysDir
+func NewStandbyConnectionWrapper(activeAPIURL string, clientID []byte, keysDir string, encryptor keystore.KeyEncryptor, tlsConfig *tls.Config) (network.ConnectionWrapper, error) {
+	if tlsConfig != nil {
+		activeURL, err := url.Parse(activeAPIURL)
+		if err != nil {
+			return nil, err
+		}
+		clientConfig := tlsConfig.Clone()
+		clientConfig.ServerName = activeURL.Hostname()
+		return network.NewTLSConnectionWrapper(clientID, clientConfig)
+	}
+	keyStore, err := filesystem.NewConnectorFileSystemKeyStore(keysDir, clientID, encryptor, connector_mode.AcraServerMode)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := keyStore.GetPrivateKey(clientID); err != nil {
+		return nil, err
+	}
+	if _, err := keyStore.GetPeerPublicKey(clientID); err != nil {
+		return nil, err
+	}
+	return network.NewSecureSessionConnectionWrapper(keyStore)
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/network"
+	log "github.com/sirupsen/logrus"
+)
+
+// Transports which may be used by additional listeners
+const (
+	TransportSecureSession = "secure_session"
+	TransportTLS           = "tls"
+	TransportRaw           = "raw"
+	TransportAuto          = "auto"
+	// TransportSecureComparator authenticates connectors over TLS with secrets from keystore
+	TransportSecureComparator = "secure_comparator"
+)
+
+// DESCRIPTOR_TRANSPORT_LISTENERS is file descriptor of first additional listener passed to forked process, next
+// listeners use next descriptors in same order as they configured
+const DESCRIPTOR_TRANSPORT_LISTENERS = DESCRIPTOR_API + 1
+
+// ErrInvalidTransportListener returned if additional listener has unknown transport or empty connection string
+var ErrInvalidTransportListener = errors.New("invalid transport listener, expected <transport>=<connection string> where transport is one of secure_session, tls, raw, auto, secure_comparator")
+
+// ErrDuplicatedTransportListener returned if several additional listeners use same connection string
+var ErrDuplicatedTransportListener = errors.New("several transport listeners use same connection string")
+
+// ErrComparatorSecretsNotSupported returned if keystore doesn't store secrets shared with AcraConnectors
+var ErrComparatorSecretsNotSupported = errors.New("keystore doesn't support secure comparator secrets")
+
+// ErrTransportListenerNotStarted returned if file descriptor requested for additional listener which isn't listening yet
+var ErrTransportListenerNotStarted = errors.New("transport listener isn't started")
+
+// TransportListener is additional listener of connections from AcraConnector which uses own transport wrapper. Allows
+// to serve connectors with different transports by one AcraServer, for example during migration from Secure Session to TLS
+type TransportListener struct {
+	Transport         string
+	ConnectionString  string
+	ConnectionWrapper network.ConnectionWrapper
+}
+
+// ParseTransportListeners parses comma separated list of <transport>=<connection string> items. Connection wrappers
+// should be set by caller
+func ParseTransportListeners(value string) ([]*TransportListener, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var listeners []*TransportListener
+	connectionStrings := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, ErrInvalidTransportListener
+		}
+		switch parts[0] {
+		case TransportSecureSession, TransportTLS, TransportRaw, TransportAuto, TransportSecureComparator:
+		default:
+			return nil, ErrInvalidTransportListener
+		}
+		if connectionStrings[parts[1]] {
+			return nil, ErrDuplicatedTransportListener
+		}
+		connectionStrings[parts[1]] = true
+		listeners = append(listeners, &TransportListener{Transport: parts[0], ConnectionString: parts[1]})
+	}
+	return listeners, nil
+}
+
+// NewNegotiationConnectionWrapper returns wrapper which detects transport of each connection. TLS is negotiated if
+// tlsConfig set, raw transport with clientID if withRaw is true
+func NewNegotiationConnectionWrapper(keyStore keystore.SecureSessionKeyStore, tlsConfig *tls.Config, clientID []byte, withRaw bool) (network.ConnectionWrapper, error) {
+	secureSessionWrapper, err := network.NewSecureSessionConnectionWrapper(keyStore)
+	if err != nil {
+		return nil, err
+	}
+	var tlsWrapper, rawWrapper network.ConnectionWrapper
+	if tlsConfig != nil {
+		tlsWrapper, err = network.NewTLSConnectionWrapper(clientID, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if withRaw {
+		rawWrapper = &network.RawConnectionWrapper{ClientID: clientID}
+	}
+	return network.NewNegotiationConnectionWrapper(tlsWrapper, secureSessionWrapper, rawWrapper)
+}
+
+// NewSecureComparatorConnectionWrapper returns wrapper which authenticates connectors with Secure Comparator by
+// secrets from keystore. Comparison runs inside TLS connection, so tlsConfig is required
+func NewSecureComparatorConnectionWrapper(keyStore keystore.KeyStore, tlsConfig *tls.Config) (network.ConnectionWrapper, error) {
+	secrets, ok := keyStore.(network.ComparatorSecretStore)
+	if !ok {
+		return nil, ErrComparatorSecretsNotSupported
+	}
+	if tlsConfig == nil {
+		return nil, network.ErrComparatorRequiresTLS
+	}
+	transportWrapper, err := network.NewTLSConnectionWrapper(nil, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return network.NewSecureComparatorServerWrapper(transportWrapper, secrets)
+}
+
+// StartTransportListener starts listening connections from AcraConnector for additional listener with index from
+// configuration. Listener is taken from file descriptor if fromDescriptor is true
+func (server *SServer) StartTransportListener(index int, fromDescriptor bool) {
+	transportListener := server.config.GetTransportListeners()[index]
+	logger := log.WithFields(log.Fields{"connection_string": transportListener.ConnectionString, "transport": transportListener.Transport, "from_descriptor": fromDescriptor})
+	var listener net.Listener
+	var err error
+	if fromDescriptor {
+		fd := uintptr(DESCRIPTOR_TRANSPORT_LISTENERS + index)
+		file := os.NewFile(fd, "/tmp/acra-server_transport_listener")
+		if file == nil {
+			logger.Errorln("Can't create new file from descriptor for transport listener")
+			server.errorSignalChannel <- syscall.SIGTERM
+			return
+		}
+		listener, err = net.FileListener(file)
+		if err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantOpenFileByDescriptor).
+				Errorln("System error: can't start listen for file descriptor")
+			server.errorSignalChannel <- syscall.SIGTERM
+			return
+		}
+	} else {
+		listener, err = network.Listen(transportListener.ConnectionString)
+		if err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantStartListenConnections).
+				Errorln("Can't start listen connections")
+			server.errorSignalChannel <- syscall.SIGTERM
+			return
+		}
+	}
+	server.transportListenersMutex.Lock()
+	server.transportListeners[index] = listener
+	server.transportListenersMutex.Unlock()
+	server.addListener(listener)
+	workers := newWorkerPool(server.config.GetConnectionCPUs(), server.config.GetConnectionWorkers())
+	server.start(listener, func(connection net.Conn) {
+		server.handleConnectionWithWrapper(connection, transportListener.ConnectionWrapper, workers)
+	}, logger)
+}
+
+// TransportListenersFileDescriptors returns file descriptors of additional listeners in same order as they configured
+func (server *SServer) TransportListenersFileDescriptors() ([]uintptr, error) {
+	server.transportListenersMutex.Lock()
+	defer server.transportListenersMutex.Unlock()
+	descriptors := make([]uintptr, 0, len(server.transportListeners))
+	for _, listener := range server.transportListeners {
+		if listener == nil {
+			return nil, ErrTransportListenerNotStarted
+		}
+		fd, err := network.ListenerFileDescriptor(listener)
+		if err != nil {
+			return nil, err
+		}
+		descriptors = append(descriptors, fd)
+	}
+	return descriptors, nil
+}
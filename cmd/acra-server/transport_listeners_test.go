@@ -0,0 +1,65 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTransportListeners(t *testing.T) {
+	testcases := []struct {
+		Value     string
+		Listeners []*TransportListener
+		Err       error
+	}{
+		{"", nil, nil},
+		{"tls=tcp://0.0.0.0:9494", []*TransportListener{{Transport: TransportTLS, ConnectionString: "tcp://0.0.0.0:9494"}}, nil},
+		{"tls=tcp://0.0.0.0:9494, secure_session=tcp://0.0.0.0:9495,raw=unix:///tmp/acra.sock,auto=tcp://0.0.0.0:9496,secure_comparator=tcp://0.0.0.0:9497",
+			[]*TransportListener{
+				{Transport: TransportTLS, ConnectionString: "tcp://0.0.0.0:9494"},
+				{Transport: TransportSecureSession, ConnectionString: "tcp://0.0.0.0:9495"},
+				{Transport: TransportRaw, ConnectionString: "unix:///tmp/acra.sock"},
+				{Transport: TransportAuto, ConnectionString: "tcp://0.0.0.0:9496"},
+				{Transport: TransportSecureComparator, ConnectionString: "tcp://0.0.0.0:9497"},
+			}, nil},
+		// same transport on different addresses is allowed
+		{"tls=tcp://0.0.0.0:9494,tls=tcp://0.0.0.0:9495", []*TransportListener{
+			{Transport: TransportTLS, ConnectionString: "tcp://0.0.0.0:9494"},
+			{Transport: TransportTLS, ConnectionString: "tcp://0.0.0.0:9495"},
+		}, nil},
+		{"tls=tcp://0.0.0.0:9494,raw=tcp://0.0.0.0:9494", nil, ErrDuplicatedTransportListener},
+		{"tls=tcp://0.0.0.0:9494,tls=tcp://0.0.0.0:9494", nil, ErrDuplicatedTransportListener},
+		{"tls", nil, ErrInvalidTransportListener},
+		{"tls=", nil, ErrInvalidTransportListener},
+		{"=tcp://0.0.0.0:9494", nil, ErrInvalidTransportListener},
+		{"unknown=tcp://0.0.0.0:9494", nil, ErrInvalidTransportListener},
+		{"TLS=tcp://0.0.0.0:9494", nil, ErrInvalidTransportListener},
+		{"tls=tcp://0.0.0.0:9494,", nil, ErrInvalidTransportListener},
+		{",tls=tcp://0.0.0.0:9494", nil, ErrInvalidTransportListener},
+	}
+	for i, tcase := range testcases {
+		listeners, err := ParseTransportListeners(tcase.Value)
+		if err != tcase.Err {
+			t.Errorf("[%d] Expected error %v, took %v", i, tcase.Err, err)
+			continue
+		}
+		if !reflect.DeepEqual(listeners, tcase.Listeners) {
+			t.Errorf("[%d] Expected %v, took %v", i, tcase.Listeners, listeners)
+		}
+	}
+}
@@ -17,7 +17,8 @@ limitations under the License.
 // Package main is entry point for AcraTranslator service. AcraTranslator is a lightweight server that receives
 // AcraStructs and returns the decrypted data. This element of Acra is necessary in the use-cases
 // when an application stores encrypted data as separate blobs (files that are not in a database - i.e.
-// in the S3 bucket, local file storage, etc.).
+// in the S3 bucket, local file storage, etc.). With object_storage_type AcraTranslator fetches objects from S3 or GCS
+// itself and returns them with decrypted AcraStreams and AcraStructs to authenticated clients.
 package main
 
 import (
@@ -29,6 +30,7 @@ import (
 	"time"
 
 	"github.com/cossacklabs/acra/cmd"
+	"github.com/cossacklabs/acra/decryptor/base"
 	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/acra/keystore/filesystem"
 	"github.com/cossacklabs/acra/logging"
@@ -39,8 +41,12 @@ import (
 
 // Constants handy for AcraTranslator.
 const (
-	SERVICE_NAME         = "acra-translator"
-	DEFAULT_WAIT_TIMEOUT = 10
+	SERVICE_NAME                      = "acra-translator"
+	DEFAULT_WAIT_TIMEOUT              = 10
+	DEFAULT_DECRYPTION_CACHE_MAX_SIZE = 16 * 1024 * 1024
+	DEFAULT_HMAC_MAX_CLOCK_SKEW       = 300
+	DEFAULT_OBJECT_MAX_SIZE           = 64 * 1024 * 1024
+	DEFAULT_MAX_BODY_SIZE             = 64 * 1024 * 1024
 )
 
 // DEFAULT_CONFIG_PATH relative path to config which will be parsed as default
@@ -48,7 +54,7 @@ var DEFAULT_CONFIG_PATH = utils.GetConfigPathByName(SERVICE_NAME)
 
 func main() {
 	config := NewConfig()
-	loggingFormat := flag.String("logging_format", "plaintext", "Logging format: plaintext, json or CEF")
+	loggingFormat := flag.String("logging_format", "plaintext", "Logging format: plaintext, json, CEF or GELF")
 	logging.CustomizeLogging(*loggingFormat, SERVICE_NAME)
 	log.Infof("Starting service %v", SERVICE_NAME)
 
@@ -56,6 +62,8 @@ func main() {
 	incomingConnectionGRPCString := flag.String("incoming_connection_grpc_string", "", "Default option: connection string for gRPC transport like grpc://0.0.0.0:9696")
 
 	keysDir := flag.String("keys_dir", keystore.DefaultKeyDirShort, "Folder from which will be loaded keys")
+	masterKeyLoader := cmd.RegisterMasterKeyLoaderFlags()
+	hsmLoader := cmd.RegisterHSMFlags()
 	keysCacheSize := flag.Int("keystore_cache_size", keystore.INFINITE_CACHE_SIZE, "Count of keys that will be stored in in-memory LRU cache in encrypted form. 0 - no limits, -1 - turn off cache")
 
 	secureSessionID := flag.String("securesession_id", "acra_translator", "Id that will be sent in secure session")
@@ -64,10 +72,32 @@ func main() {
 	stopOnPoison := flag.Bool("poison_shutdown_enable", false, "On detecting poison record: log about poison record detection, stop and shutdown")
 	scriptOnPoison := flag.String("poison_run_script_file", "", "On detecting poison record: log about poison record detection, execute script, return decrypted data")
 
+	decryptionCacheTTL := flag.Int("decryption_cache_ttl", 0, "Time (in seconds) to store decrypted AcraStructs in memory to don't decrypt same AcraStructs repeatedly. 0 - turn off cache")
+	decryptionCacheMaxSize := flag.Int("decryption_cache_max_size", DEFAULT_DECRYPTION_CACHE_MAX_SIZE, "Max size (in bytes) of decrypted data stored in cache, less recently used values are removed first")
+	maxConcurrentDecryptions := flag.Int("max_concurrent_decryptions", 0, "Max count of simultaneous AcraStruct decryptions. 0 - without limits")
+	decryptionQueueSize := flag.Int("decryption_queue_size", cmd.DEFAULT_DECRYPTION_QUEUE_SIZE, "Max count of decryptions which wait for free slot when max_concurrent_decryptions reached, other decryptions are rejected")
+	decryptionQueueTimeout := flag.Int("decryption_queue_timeout", cmd.DEFAULT_DECRYPTION_QUEUE_TIMEOUT, "Max time (in milliseconds) to wait for free slot for decryption, after timeout decryption is rejected. 0 - wait without timeout")
+
+	auditLogFile := flag.String("audit_log_file", "", "Path to file where records about each request (client ID, operation, zone, hash of AcraStruct, status, latency) are appended in JSON format. Empty - turn off audit")
+	hmacSecretsFile := flag.String("http_hmac_secrets_file", "", "Path to configuration file with shared secrets of clients which sign HTTP requests with HMAC. Signed requests are processed on behalf of client ID from signature")
+	hmacMaxClockSkew := flag.Int("http_hmac_max_clock_skew", DEFAULT_HMAC_MAX_CLOCK_SKEW, "Max difference (in seconds) between timestamp of signed HTTP request and time of AcraTranslator")
+	httpAuthProviders := flag.String("http_auth_providers_config_file", "", "Path to YAML file with authentication providers (static, ldap, oidc, mtls) which HTTP requests should pass")
+	hmacOnly := flag.Bool("http_hmac_only", false, "Accept HTTP connections without Secure Session and reject HTTP requests without valid HMAC signature")
+	objectStorageType := flag.String("object_storage_type", "", "Type of object storage (s3 or gcs) which objects are returned by HTTP API /v1/objects/<bucket>/<key> with decrypted AcraStreams and AcraStructs. Credentials are taken from environment variables like AWS_ACCESS_KEY_ID or GOOGLE_OAUTH_ACCESS_TOKEN. Empty - turn off")
+	objectStorageEndpoint := flag.String("object_storage_endpoint", "", "URL of object storage API, e.g. of S3 compatible storage. Empty - use endpoint of cloud")
+	objectStorageRegion := flag.String("object_storage_region", "", "Region of S3 bucket, overrides AWS_REGION environment variable")
+	objectMaxSize := flag.Int("object_max_size", DEFAULT_OBJECT_MAX_SIZE, "Max size (in bytes) of object with AcraStructs which is decrypted in memory, AcraStreams are decrypted as they are read without limits. 0 - without limits")
+	contentEncodings := flag.String("content_encodings", "", "Comma separated list of encodings (gzip, zstd) of HTTP request and response bodies and gRPC messages in order of preference. Responses are encoded with first encoding accepted by client. Empty - turn off")
+	maxBodySize := flag.Int("max_body_size", DEFAULT_MAX_BODY_SIZE, "Max size (in bytes) of HTTP request body before and after decoding and of decompressed gRPC message. 0 - without limits for HTTP requests and default limit of gRPC")
 	closeConnectionTimeout := flag.Int("incoming_connection_close_timeout", DEFAULT_WAIT_TIMEOUT, "Time that AcraTranslator will wait (in seconds) on stop signal before closing all connections")
 
 	verbose := flag.Bool("v", false, "Log to stderr all INFO, WARNING and ERROR logs")
 	debug := flag.Bool("d", false, "Log everything to stderr")
+	logSampleEvery := flag.Int("log_sample_every", 1, "Log only every Nth debug or info event of same category (event code or message), 1 logs all events")
+	logSampleRateLimit := flag.Int("log_sample_rate_limit", 0, "Max count of debug or info events of same category logged per second, 0 is unlimited")
+	loggingRemoteAddress := flag.String("logging_remote_address", "", "Address like tcp://host:port or udp://host:port of Graylog (with GELF logging format) or Logstash (with json logging format) to send logs to in addition to stderr")
+	loggingRemoteBufferSize := flag.Int("logging_remote_buffer_size", logging.DefaultRemoteBufferSize, "Count of log messages buffered while remote log output is unavailable, newer messages are dropped")
+	loggingEventCodesMapFile := flag.String("logging_event_codes_map_file", "", "Path to YAML file which maps Acra event codes to custom event codes of SIEM taxonomy like '584: TLS-FAILURE'. Mapped codes are used as signature ID of CEF logs and added as vendor_code field to JSON and GELF logs")
 
 	err := cmd.Parse(DEFAULT_CONFIG_PATH, SERVICE_NAME)
 	if err != nil {
@@ -78,6 +108,21 @@ func main() {
 
 	// if log format was overridden
 	logging.CustomizeLogging(*loggingFormat, SERVICE_NAME)
+	logging.SetLogSampling(*logSampleEvery, *logSampleRateLimit)
+	if *loggingRemoteAddress != "" {
+		if _, err := logging.SetLogRemoteOutput(*loggingRemoteAddress, *loggingFormat, *loggingRemoteBufferSize); err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't configure remote log output")
+			os.Exit(1)
+		}
+	}
+	if *loggingEventCodesMapFile != "" {
+		if err := logging.LoadEventCodeMappingFromFile(*loggingEventCodesMapFile); err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't load mapping of event codes")
+			os.Exit(1)
+		}
+	}
 
 	log.Infof("Validating service configuration...")
 	cmd.ValidateClientID(*secureSessionID)
@@ -97,19 +142,57 @@ func main() {
 	config.SetIncomingConnectionGRPCString(*incomingConnectionGRPCString)
 	config.SetConfigPath(DEFAULT_CONFIG_PATH)
 	config.SetDebug(*debug)
-
-	log.Infof("Initialising keystore...")
-	masterKey, err := keystore.GetMasterKeyFromEnvironment()
-	if err != nil {
-		log.WithError(err).Errorln("can't load master key")
+	config.SetDecryptionCacheTTL(time.Duration(*decryptionCacheTTL) * time.Second)
+	config.SetDecryptionCacheMaxSize(*decryptionCacheMaxSize)
+	if *hmacOnly && *hmacSecretsFile == "" {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("http_hmac_only requires http_hmac_secrets_file")
+		os.Exit(1)
+	}
+	if err := config.SetHMACSecretsFile(*hmacSecretsFile, time.Duration(*hmacMaxClockSkew)*time.Second); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't load HMAC secrets")
+		os.Exit(1)
+	}
+	config.SetHMACRequired(*hmacOnly)
+	if err := config.SetHTTPAuthProvidersFile(*httpAuthProviders); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't load authentication providers for HTTP requests")
+		os.Exit(1)
+	}
+	if err := config.SetObjectStorage(*objectStorageType, *objectStorageEndpoint, *objectStorageRegion); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't configure object storage")
 		os.Exit(1)
 	}
-	scellEncryptor, err := keystore.NewSCellKeyEncryptor(masterKey)
+	config.SetObjectMaxSize(int64(*objectMaxSize))
+	if err := config.SetContentEncodings(*contentEncodings); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't parse content_encodings")
+		os.Exit(1)
+	}
+	if *maxBodySize < 0 {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("max_body_size can't be negative")
+		os.Exit(1)
+	}
+	config.SetMaxBodySize(int64(*maxBodySize))
+	if err := config.SetAuditLogFile(*auditLogFile); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+			Errorln("Can't open audit log file")
+		os.Exit(1)
+	}
+	if *maxConcurrentDecryptions > 0 {
+		base.SetDecryptionLimiter(base.NewDecryptionLimiter(*maxConcurrentDecryptions, *decryptionQueueSize, time.Duration(*decryptionQueueTimeout)*time.Millisecond))
+	}
+
+	log.Infof("Initialising keystore...")
+	keyEncryptor, err := hsmLoader.NewKeyEncryptor(masterKeyLoader.LoadMasterKey)
 	if err != nil {
-		log.WithError(err).Errorln("can't init scell encryptor")
+		log.WithError(err).Errorln("can't init encryptor of keys")
 		os.Exit(1)
 	}
-	keyStore, err := filesystem.NewTranslatorFileSystemKeyStore(*keysDir, scellEncryptor, *keysCacheSize)
+	keyStore, err := filesystem.NewTranslatorFileSystemKeyStore(*keysDir, keyEncryptor, *keysCacheSize)
 	if err != nil {
 		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantInitKeyStore).
 			Errorln("Can't initialise keystore")
@@ -119,8 +202,13 @@ func main() {
 
 	// --------- Config  -----------
 	log.Infof("Configuring transport...")
-	log.Infof("Selecting transport: use Secure Session transport wrapper")
-	config.ConnectionWrapper, err = network.NewSecureSessionConnectionWrapper(keyStore)
+	if *hmacOnly {
+		log.Infof("Selecting transport: use raw transport wrapper for HTTP requests signed with HMAC")
+		config.ConnectionWrapper = &network.RawConnectionWrapper{}
+	} else {
+		log.Infof("Selecting transport: use Secure Session transport wrapper")
+		config.ConnectionWrapper, err = network.NewSecureSessionConnectionWrapper(keyStore)
+	}
 	if err != nil {
 		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTransportConfiguration).
 			Errorln("Configuration error: can't initialize secure session connection wrapper")
@@ -0,0 +1,103 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cossacklabs/acra/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// Operations and statuses of requests stored in audit log
+const (
+	AuditOperationDecrypt = "decrypt"
+
+	AuditStatusOK              = "ok"
+	AuditStatusBadRequest      = "bad_request"
+	AuditStatusUnauthorized    = "unauthorized"
+	AuditStatusOverloaded      = "overloaded"
+	AuditStatusDecryptionError = "decryption_error"
+	AuditStatusPoisonRecord    = "poison_record"
+)
+
+// AuditRecord describes one request to AcraTranslator. Ciphertext is stored only as SHA-256 hash which allows to
+// match records with data in database without disclosing it
+type AuditRecord struct {
+	Time           time.Time `json:"time"`
+	Translator     string    `json:"translator"`
+	ClientID       string    `json:"client_id"`
+	Operation      string    `json:"operation"`
+	ZoneID         string    `json:"zone_id,omitempty"`
+	CiphertextHash string    `json:"ciphertext_sha256,omitempty"`
+	Status         string    `json:"status"`
+	Latency        float64   `json:"latency_seconds"`
+}
+
+// AuditLog writes AuditRecords as JSON lines. Safe for concurrent use, all methods are safe to call on nil AuditLog
+type AuditLog struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewAuditLog returns AuditLog which writes records to writer
+func NewAuditLog(writer io.Writer) *AuditLog {
+	return &AuditLog{encoder: json.NewEncoder(writer)}
+}
+
+// NewAuditLogFile returns AuditLog which appends records to file, file is created if doesn't exist
+func NewAuditLogFile(path string) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewAuditLog(file), nil
+}
+
+// Add writes record about request which started at startTime
+func (auditLog *AuditLog) Add(translator string, clientID []byte, operation string, zoneID, ciphertext []byte, status string, startTime time.Time) {
+	if auditLog == nil {
+		return
+	}
+	now := time.Now()
+	record := &AuditRecord{
+		Time:       now,
+		Translator: translator,
+		ClientID:   string(clientID),
+		Operation:  operation,
+		ZoneID:     string(zoneID),
+		Status:     status,
+		Latency:    now.Sub(startTime).Seconds(),
+	}
+	if len(ciphertext) != 0 {
+		hash := sha256.Sum256(ciphertext)
+		record.CiphertextHash = hex.EncodeToString(hash[:])
+	}
+	auditLog.mutex.Lock()
+	err := auditLog.encoder.Encode(record)
+	auditLog.mutex.Unlock()
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantWriteAuditLog).
+			Errorln("Can't write audit record")
+	}
+}
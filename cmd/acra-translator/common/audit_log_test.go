@@ -0,0 +1,58 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	output := &bytes.Buffer{}
+	auditLog := NewAuditLog(output)
+	acraStruct := []byte("acrastruct")
+	auditLog.Add("http", []byte("client"), AuditOperationDecrypt, []byte("zone"), acraStruct, AuditStatusOK, time.Now().Add(-time.Second))
+	auditLog.Add("grpc", []byte("client"), AuditOperationDecrypt, nil, nil, AuditStatusBadRequest, time.Now())
+
+	decoder := json.NewDecoder(output)
+	record := &AuditRecord{}
+	if err := decoder.Decode(record); err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256(acraStruct)
+	if record.Translator != "http" || record.ClientID != "client" || record.ZoneID != "zone" || record.Status != AuditStatusOK ||
+		record.Operation != AuditOperationDecrypt || record.CiphertextHash != hex.EncodeToString(hash[:]) {
+		t.Fatalf("Incorrect audit record %+v", record)
+	}
+	if record.Latency < 1 {
+		t.Fatalf("Incorrect latency %v", record.Latency)
+	}
+	record = &AuditRecord{}
+	if err := decoder.Decode(record); err != nil {
+		t.Fatal(err)
+	}
+	if record.CiphertextHash != "" || record.ZoneID != "" || record.Status != AuditStatusBadRequest {
+		t.Fatalf("Incorrect audit record %+v", record)
+	}
+
+	var nilAuditLog *AuditLog
+	nilAuditLog.Add("http", nil, AuditOperationDecrypt, nil, nil, AuditStatusOK, time.Now())
+}
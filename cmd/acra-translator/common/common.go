@@ -19,7 +19,9 @@ package common
 
 import (
 	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/httpauth"
 	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/objectstore"
 )
 
 // TranslatorData connects KeyStorage and Poison records settings for HTTP and gRPC decryptors.
@@ -27,4 +29,22 @@ type TranslatorData struct {
 	Keystorage            keystore.KeyStore
 	PoisonRecordCallbacks *base.PoisonCallbackStorage
 	CheckPoisonRecords    bool
+	// DecryptionCache stores decrypted AcraStructs, nil if caching turned off
+	DecryptionCache *DecryptionCache
+	// AuditLog stores records about each request, nil if audit turned off
+	AuditLog *AuditLog
+	// HMACAuthenticator verifies signed HTTP requests, nil if signatures aren't used
+	HMACAuthenticator *HMACAuthenticator
+	// HMACRequired rejects HTTP requests without valid signature
+	HMACRequired bool
+	// AuthProvider authenticates HTTP requests, nil if requests aren't authenticated by providers
+	AuthProvider httpauth.Provider
+	// ObjectStorage returns objects decrypted by HTTP API, nil if objects aren't proxied
+	ObjectStorage objectstore.Storage
+	// ObjectMaxSize limits size of objects with AcraStructs which are decrypted in memory, 0 means without limits
+	ObjectMaxSize int64
+	// ContentEncodings are encodings of bodies in order of preference, nil if encoded bodies aren't accepted
+	ContentEncodings []ContentEncoding
+	// MaxBodySize limits size of request body before and after decoding, 0 means without limits
+	MaxBodySize int64
 }
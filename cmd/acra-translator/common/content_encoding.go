@@ -0,0 +1,190 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Names of content encodings supported by AcraTranslator
+const (
+	ContentEncodingGzip     = "gzip"
+	ContentEncodingZstd     = "zstd"
+	ContentEncodingIdentity = "identity"
+)
+
+// Errors returned on processing of encoded bodies
+var (
+	ErrUnknownContentEncoding = errors.New("unknown content encoding, expected comma separated list of gzip, zstd")
+	ErrBodyTooLarge           = errors.New("body of request is larger than max size of body")
+)
+
+// ContentEncoding compresses and decompresses bodies of requests and responses
+type ContentEncoding interface {
+	// Name returns name of encoding used in Content-Encoding and Accept-Encoding headers
+	Name() string
+	NewReader(reader io.Reader) (io.ReadCloser, error)
+	NewWriter(writer io.Writer) (io.WriteCloser, error)
+}
+
+var (
+	contentEncodings     = map[string]ContentEncoding{}
+	contentEncodingsLock sync.RWMutex
+)
+
+// RegisterContentEncoding adds encoding which may be turned on by name. Encoding with same name is replaced
+func RegisterContentEncoding(encoding ContentEncoding) {
+	contentEncodingsLock.Lock()
+	contentEncodings[strings.ToLower(encoding.Name())] = encoding
+	contentEncodingsLock.Unlock()
+}
+
+// GetContentEncoding returns registered encoding with name or nil
+func GetContentEncoding(name string) ContentEncoding {
+	contentEncodingsLock.RLock()
+	defer contentEncodingsLock.RUnlock()
+	return contentEncodings[strings.ToLower(name)]
+}
+
+func init() {
+	RegisterContentEncoding(gzipEncoding{})
+	RegisterContentEncoding(zstdEncoding{})
+}
+
+type gzipEncoding struct{}
+
+func (gzipEncoding) Name() string { return ContentEncodingGzip }
+
+func (gzipEncoding) NewReader(reader io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(reader)
+}
+
+func (gzipEncoding) NewWriter(writer io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(writer), nil
+}
+
+type zstdEncoding struct{}
+
+func (zstdEncoding) Name() string { return ContentEncodingZstd }
+
+func (zstdEncoding) NewReader(reader io.Reader) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return decoder.IOReadCloser(), nil
+}
+
+func (zstdEncoding) NewWriter(writer io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(writer, zstd.WithEncoderConcurrency(1))
+}
+
+// ParseContentEncodings returns registered encodings from comma separated list of names in order of preference
+func ParseContentEncodings(value string) ([]ContentEncoding, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var encodings []ContentEncoding
+	for _, name := range strings.Split(value, ",") {
+		encoding := GetContentEncoding(strings.TrimSpace(name))
+		if encoding == nil {
+			return nil, ErrUnknownContentEncoding
+		}
+		encodings = append(encodings, encoding)
+	}
+	return encodings, nil
+}
+
+// FindContentEncoding returns encoding from encodings with name or nil
+func FindContentEncoding(encodings []ContentEncoding, name string) ContentEncoding {
+	for _, encoding := range encodings {
+		if strings.EqualFold(encoding.Name(), name) {
+			return encoding
+		}
+	}
+	return nil
+}
+
+// NegotiateContentEncoding returns first encoding from encodings accepted by value of Accept-Encoding header or nil
+// if response should be sent as is. Encodings with zero quality aren't accepted, "*" accepts any encoding
+func NegotiateContentEncoding(encodings []ContentEncoding, acceptEncoding string) ContentEncoding {
+	accepted := map[string]bool{}
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		parts := strings.Split(item, ";")
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		if name == "" {
+			continue
+		}
+		accepted[name] = true
+		for _, parameter := range parts[1:] {
+			parameter = strings.TrimSpace(parameter)
+			if strings.HasPrefix(parameter, "q=") {
+				if quality, err := strconv.ParseFloat(parameter[2:], 64); err == nil && quality == 0 {
+					accepted[name] = false
+				}
+			}
+		}
+	}
+	for _, encoding := range encodings {
+		name := strings.ToLower(encoding.Name())
+		if isAccepted, ok := accepted[name]; ok {
+			if isAccepted {
+				return encoding
+			}
+			continue
+		}
+		if accepted["*"] {
+			return encoding
+		}
+	}
+	return nil
+}
+
+// DecodeBody returns body decoded by encoding. Returns ErrBodyTooLarge if decoded body is larger than maxSize,
+// 0 means without limits
+func DecodeBody(encoding ContentEncoding, body io.Reader, maxSize int64) ([]byte, error) {
+	reader, err := encoding.NewReader(body)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ReadLimited(reader, maxSize)
+}
+
+// ReadLimited reads all data from reader. Returns ErrBodyTooLarge if data is larger than maxSize, 0 means without
+// limits
+func ReadLimited(reader io.Reader, maxSize int64) ([]byte, error) {
+	if maxSize <= 0 {
+		return ioutil.ReadAll(reader)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(reader, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, ErrBodyTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"testing"
+)
+
+func encodeTestData(t *testing.T, encoding ContentEncoding, data []byte) []byte {
+	buffer := &bytes.Buffer{}
+	writer, err := encoding.NewWriter(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestParseContentEncodings(t *testing.T) {
+	encodings, err := ParseContentEncodings("zstd, GZIP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encodings) != 2 || encodings[0].Name() != ContentEncodingZstd || encodings[1].Name() != ContentEncodingGzip {
+		t.Fatalf("Unexpected encodings %v", encodings)
+	}
+	if encodings, err := ParseContentEncodings(""); err != nil || encodings != nil {
+		t.Fatal("Expected no encodings for empty value")
+	}
+	if _, err := ParseContentEncodings("gzip,br"); err != ErrUnknownContentEncoding {
+		t.Fatalf("Expected ErrUnknownContentEncoding, took %v", err)
+	}
+	if FindContentEncoding(encodings, "Gzip") != encodings[1] || FindContentEncoding(encodings, "br") != nil {
+		t.Fatal("Incorrect encoding found by name")
+	}
+}
+
+func TestNegotiateContentEncoding(t *testing.T) {
+	encodings, err := ParseContentEncodings("zstd,gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"", ""},
+		{"gzip, deflate", ContentEncodingGzip},
+		{"gzip;q=0.5, zstd;q=0.1", ContentEncodingZstd},
+		{"zstd;q=0, gzip", ContentEncodingGzip},
+		{"*", ContentEncodingZstd},
+		{"*, zstd;q=0", ContentEncodingGzip},
+		{"br, identity", ""},
+	}
+	for _, testCase := range testCases {
+		encoding := NegotiateContentEncoding(encodings, testCase.acceptEncoding)
+		name := ""
+		if encoding != nil {
+			name = encoding.Name()
+		}
+		if name != testCase.expected {
+			t.Fatalf("Expected %q for %q, took %q", testCase.expected, testCase.acceptEncoding, name)
+		}
+	}
+}
+
+func TestDecodeBody(t *testing.T) {
+	data := bytes.Repeat([]byte("AcraStruct "), 100)
+	for _, name := range []string{ContentEncodingGzip, ContentEncodingZstd} {
+		encoding := GetContentEncoding(name)
+		encoded := encodeTestData(t, encoding, data)
+		decoded, err := DecodeBody(encoding, bytes.NewReader(encoded), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Fatalf("Incorrect data decoded by %s", name)
+		}
+		// compressed body smaller than limit may be decoded to larger body
+		if _, err := DecodeBody(encoding, bytes.NewReader(encoded), int64(len(data)-1)); err != ErrBodyTooLarge {
+			t.Fatalf("Expected ErrBodyTooLarge for %s, took %v", name, err)
+		}
+		if _, err := DecodeBody(encoding, bytes.NewReader(data), 0); err == nil {
+			t.Fatalf("Expected error on decoding of not encoded data by %s", name)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/cossacklabs/acra/utils"
+	"github.com/cossacklabs/themis/gothemis/keys"
+	"github.com/golang/groupcache/lru"
+)
+
+type cachedValue struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// DecryptionCache stores decrypted data of AcraStructs in memory for short time to don't decrypt same AcraStructs
+// repeatedly. Values are removed after TTL or when total size of cached data exceeds maxSize (less recently used
+// first) and zeroed on removing. Values are cached per private keys used for decryption, so callers should load keys
+// before lookup and values aren't returned after keys were rotated or revoked.
+type DecryptionCache struct {
+	mutex   sync.Mutex
+	lru     *lru.Cache
+	ttl     time.Duration
+	maxSize int
+	size    int
+}
+
+// NewDecryptionCache returns new DecryptionCache which stores values for ttl and not more than maxSize bytes of data
+func NewDecryptionCache(ttl time.Duration, maxSize int) *DecryptionCache {
+	cache := &DecryptionCache{lru: lru.New(0), ttl: ttl, maxSize: maxSize}
+	cache.lru.OnEvicted = cache.onEvicted
+	return cache
+}
+
+// onEvicted updates size of cached data and zeroes removed value
+func (cache *DecryptionCache) onEvicted(key lru.Key, value interface{}) {
+	data := value.(*cachedValue).data
+	cache.size -= len(data)
+	utils.FillSlice(byte(0), data)
+}
+
+// getCacheKey returns hash of AcraStruct with client id, zone id and private keys which were used to decrypt it
+func getCacheKey(acraStruct, clientID, zoneID []byte, privateKeys []*keys.PrivateKey) string {
+	values := [][]byte{clientID, zoneID, acraStruct}
+	for _, privateKey := range privateKeys {
+		values = append(values, privateKey.Value)
+	}
+	hash := sha256.New()
+	for _, value := range values {
+		length := make([]byte, 8)
+		binary.BigEndian.PutUint64(length, uint64(len(value)))
+		hash.Write(length)
+		hash.Write(value)
+	}
+	return string(hash.Sum(nil))
+}
+
+// Get returns copy of decrypted data of AcraStruct if it was cached with same private keys and not expired
+func (cache *DecryptionCache) Get(acraStruct, clientID, zoneID []byte, privateKeys []*keys.PrivateKey) ([]byte, bool) {
+	key := getCacheKey(acraStruct, clientID, zoneID, privateKeys)
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	value, ok := cache.lru.Get(key)
+	if !ok {
+		return nil, false
+	}
+	cached := value.(*cachedValue)
+	if time.Now().After(cached.expiresAt) {
+		cache.lru.Remove(key)
+		return nil, false
+	}
+	return append([]byte{}, cached.data...), true
+}
+
+// Add stores copy of decrypted data of AcraStruct decrypted with privateKeys. Data bigger than cache's max size isn't
+// stored
+func (cache *DecryptionCache) Add(acraStruct, clientID, zoneID []byte, privateKeys []*keys.PrivateKey, data []byte) {
+	if len(data) > cache.maxSize {
+		return
+	}
+	key := getCacheKey(acraStruct, clientID, zoneID, privateKeys)
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.lru.Remove(key)
+	for cache.size+len(data) > cache.maxSize {
+		cache.lru.RemoveOldest()
+	}
+	cache.lru.Add(key, &cachedValue{data: append([]byte{}, data...), expiresAt: time.Now().Add(cache.ttl)})
+	cache.size += len(data)
+}
+
+// Clear removes and zeroes all cached values
+func (cache *DecryptionCache) Clear() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.lru.Clear()
+	cache.size = 0
+}
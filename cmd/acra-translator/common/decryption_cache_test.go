@@ -0,0 +1,88 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/themis/gothemis/keys"
+)
+
+func TestDecryptionCache(t *testing.T) {
+	cache := NewDecryptionCache(time.Minute, 10)
+	acraStruct := []byte("acrastruct")
+	clientID := []byte("client")
+	data := []byte("data")
+	privateKeys := []*keys.PrivateKey{{Value: []byte("current key")}, {Value: []byte("previous key")}}
+
+	cache.Add(acraStruct, clientID, nil, privateKeys, data)
+	cached, ok := cache.Get(acraStruct, clientID, nil, privateKeys)
+	if !ok || !bytes.Equal(cached, data) {
+		t.Fatal("Expected cached data")
+	}
+	// returned value must be copy
+	cached[0] = 0
+	if cached, _ := cache.Get(acraStruct, clientID, nil, privateKeys); !bytes.Equal(cached, data) {
+		t.Fatal("Cached data was changed")
+	}
+	if _, ok := cache.Get(acraStruct, []byte("other client"), nil, privateKeys); ok {
+		t.Fatal("Data cached for other client")
+	}
+	if _, ok := cache.Get(acraStruct, clientID, []byte("zone"), privateKeys); ok {
+		t.Fatal("Data cached for other zone")
+	}
+	// data isn't returned after rotation or revocation of keys
+	rotatedKeys := []*keys.PrivateKey{{Value: []byte("new key")}, {Value: []byte("current key")}, {Value: []byte("previous key")}}
+	if _, ok := cache.Get(acraStruct, clientID, nil, rotatedKeys); ok {
+		t.Fatal("Data cached for rotated keys")
+	}
+	if _, ok := cache.Get(acraStruct, clientID, nil, privateKeys[:1]); ok {
+		t.Fatal("Data cached for revoked previous key")
+	}
+
+	// less recently used value removed when size exceeds max size
+	cache.Add([]byte("second"), clientID, nil, privateKeys, []byte("second"))
+	if cache.size != 10 {
+		t.Fatalf("Incorrect cache size %v", cache.size)
+	}
+	cache.Add([]byte("third"), clientID, nil, privateKeys, []byte("third"))
+	if _, ok := cache.Get(acraStruct, clientID, nil, privateKeys); ok {
+		t.Fatal("Expected removed value")
+	}
+	if _, ok := cache.Get([]byte("third"), clientID, nil, privateKeys); !ok {
+		t.Fatal("Expected cached value")
+	}
+	// too big value isn't cached
+	cache.Add([]byte("big"), clientID, nil, privateKeys, make([]byte, 11))
+	if _, ok := cache.Get([]byte("big"), clientID, nil, privateKeys); ok {
+		t.Fatal("Value bigger than max size was cached")
+	}
+
+	cache.Clear()
+	if cache.size != 0 {
+		t.Fatal("Cache wasn't cleared")
+	}
+
+	expiringCache := NewDecryptionCache(time.Millisecond, 10)
+	expiringCache.Add(acraStruct, clientID, nil, privateKeys, data)
+	time.Sleep(time.Millisecond * 5)
+	if _, ok := expiringCache.Get(acraStruct, clientID, nil, privateKeys); ok {
+		t.Fatal("Expected expired value")
+	}
+}
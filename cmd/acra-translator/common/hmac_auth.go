@@ -0,0 +1,166 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Headers of HTTP requests signed with HMAC
+const (
+	HMACClientIDHeader  = "X-Acra-Client-Id"
+	HMACTimestampHeader = "X-Acra-Timestamp"
+	HMACSignatureHeader = "X-Acra-Signature"
+)
+
+// minHMACSecretLength is minimal length of shared secret in bytes
+const minHMACSecretLength = 16
+
+// Errors returned by HMACAuthenticator
+var (
+	ErrInvalidHMACSecretsConfig = errors.New("invalid HMAC secrets configuration, expected client_id and base64 encoded secret of at least 16 bytes for each client")
+	ErrHMACMissingHeaders       = errors.New("request isn't signed")
+	ErrHMACUnknownClientID      = errors.New("unknown client id of signed request")
+	ErrHMACInvalidTimestamp     = errors.New("timestamp of signed request is invalid or out of allowed clock skew")
+	ErrHMACInvalidSignature     = errors.New("invalid signature of request")
+	ErrHMACReplayedRequest      = errors.New("signed request was already processed")
+)
+
+// HMACClientSecret is shared secret of client id
+type HMACClientSecret struct {
+	ClientID string `yaml:"client_id"`
+	Secret   string `yaml:"secret"`
+}
+
+// HMACSecretsConfig lists shared secrets of clients which sign requests
+type HMACSecretsConfig struct {
+	Clients []HMACClientSecret `yaml:"clients"`
+}
+
+// HMACAuthenticator authenticates HTTP requests signed by clients with shared secrets. Signature is HMAC-SHA256 of
+// method, request URI, timestamp and SHA-256 of body (see SignRequest). Requests with timestamp out of allowed clock
+// skew and repeated signatures are rejected
+type HMACAuthenticator struct {
+	secrets   map[string][]byte
+	maxSkew   time.Duration
+	mutex     sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewHMACAuthenticator parses secrets configuration in YAML format
+func NewHMACAuthenticator(data []byte, maxSkew time.Duration) (*HMACAuthenticator, error) {
+	config := &HMACSecretsConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	secrets := make(map[string][]byte, len(config.Clients))
+	for _, client := range config.Clients {
+		secret, err := base64.StdEncoding.DecodeString(client.Secret)
+		if err != nil || client.ClientID == "" || len(secret) < minHMACSecretLength {
+			return nil, ErrInvalidHMACSecretsConfig
+		}
+		if _, ok := secrets[client.ClientID]; ok {
+			return nil, ErrInvalidHMACSecretsConfig
+		}
+		secrets[client.ClientID] = secret
+	}
+	return &HMACAuthenticator{secrets: secrets, maxSkew: maxSkew, seen: make(map[string]time.Time), lastSweep: time.Now(), now: time.Now}, nil
+}
+
+// HMACSignature returns hex encoded signature of request parts
+func HMACSignature(secret []byte, method, requestURI string, timestamp int64, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + requestURI + "\n" + strconv.FormatInt(timestamp, 10) + "\n"))
+	mac.Write([]byte(hex.EncodeToString(bodyHash[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest sets headers with client id, current time and signature of request with body
+func SignRequest(request *http.Request, clientID, secret, body []byte) {
+	timestamp := time.Now().Unix()
+	request.Header.Set(HMACClientIDHeader, string(clientID))
+	request.Header.Set(HMACTimestampHeader, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(HMACSignatureHeader, HMACSignature(secret, request.Method, request.URL.RequestURI(), timestamp, body))
+}
+
+// IsSigned returns true if request has signature header
+func IsSigned(request *http.Request) bool {
+	return request.Header.Get(HMACSignatureHeader) != ""
+}
+
+// Authenticate verifies signature of request with body and returns client id which signed it
+func (authenticator *HMACAuthenticator) Authenticate(request *http.Request, body []byte) ([]byte, error) {
+	clientID := request.Header.Get(HMACClientIDHeader)
+	timestampValue := request.Header.Get(HMACTimestampHeader)
+	signature := request.Header.Get(HMACSignatureHeader)
+	if clientID == "" || timestampValue == "" || signature == "" {
+		return nil, ErrHMACMissingHeaders
+	}
+	secret, ok := authenticator.secrets[clientID]
+	if !ok {
+		return nil, ErrHMACUnknownClientID
+	}
+	timestamp, err := strconv.ParseInt(timestampValue, 10, 64)
+	if err != nil {
+		return nil, ErrHMACInvalidTimestamp
+	}
+	now := authenticator.now()
+	skew := now.Sub(time.Unix(timestamp, 0))
+	if skew > authenticator.maxSkew || skew < -authenticator.maxSkew {
+		return nil, ErrHMACInvalidTimestamp
+	}
+	expected := HMACSignature(secret, request.Method, request.URL.RequestURI(), timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return nil, ErrHMACInvalidSignature
+	}
+	if !authenticator.markSeen(signature, time.Unix(timestamp, 0).Add(authenticator.maxSkew), now) {
+		return nil, ErrHMACReplayedRequest
+	}
+	return []byte(clientID), nil
+}
+
+// markSeen remembers signature until it expires and returns false if it was already seen
+func (authenticator *HMACAuthenticator) markSeen(signature string, expiresAt, now time.Time) bool {
+	authenticator.mutex.Lock()
+	defer authenticator.mutex.Unlock()
+	if now.Sub(authenticator.lastSweep) > authenticator.maxSkew {
+		authenticator.lastSweep = now
+		for seenSignature, seenExpiresAt := range authenticator.seen {
+			if now.After(seenExpiresAt) {
+				delete(authenticator.seen, seenSignature)
+			}
+		}
+	}
+	if _, ok := authenticator.seen[signature]; ok {
+		return false
+	}
+	authenticator.seen[signature] = expiresAt
+	return true
+}
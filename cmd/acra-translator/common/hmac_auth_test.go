@@ -0,0 +1,89 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHMACAuthenticator(t *testing.T) {
+	// base64 of "0123456789abcdef"
+	authenticator, err := NewHMACAuthenticator([]byte("clients:\n  - client_id: client1\n    secret: MDEyMzQ1Njc4OWFiY2RlZg==\n"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("0123456789abcdef")
+	body := []byte("acrastruct")
+	newRequest := func() *http.Request {
+		request, err := http.NewRequest(http.MethodPost, "http://localhost/v1/decrypt?zone_id=zone", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return request
+	}
+
+	request := newRequest()
+	if IsSigned(request) {
+		t.Fatal("Request without signature detected as signed")
+	}
+	if _, err := authenticator.Authenticate(request, body); err != ErrHMACMissingHeaders {
+		t.Fatalf("Expected ErrHMACMissingHeaders, took %v", err)
+	}
+
+	SignRequest(request, []byte("client1"), secret, body)
+	clientID, err := authenticator.Authenticate(request, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(clientID, []byte("client1")) {
+		t.Fatal("Incorrect client id")
+	}
+	if _, err := authenticator.Authenticate(request, body); err != ErrHMACReplayedRequest {
+		t.Fatalf("Expected ErrHMACReplayedRequest, took %v", err)
+	}
+
+	request = newRequest()
+	SignRequest(request, []byte("client1"), secret, body)
+	if _, err := authenticator.Authenticate(request, []byte("other body")); err != ErrHMACInvalidSignature {
+		t.Fatalf("Expected ErrHMACInvalidSignature, took %v", err)
+	}
+
+	request = newRequest()
+	SignRequest(request, []byte("client2"), secret, body)
+	if _, err := authenticator.Authenticate(request, body); err != ErrHMACUnknownClientID {
+		t.Fatalf("Expected ErrHMACUnknownClientID, took %v", err)
+	}
+
+	request = newRequest()
+	timestamp := time.Now().Add(-time.Hour).Unix()
+	request.Header.Set(HMACClientIDHeader, "client1")
+	request.Header.Set(HMACTimestampHeader, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(HMACSignatureHeader, HMACSignature(secret, request.Method, request.URL.RequestURI(), timestamp, body))
+	if _, err := authenticator.Authenticate(request, body); err != ErrHMACInvalidTimestamp {
+		t.Fatalf("Expected ErrHMACInvalidTimestamp, took %v", err)
+	}
+
+	for _, config := range []string{"clients:\n  - client_id: client1\n    secret: c2hvcnQ=\n", "clients:\n  - secret: MDEyMzQ1Njc4OWFiY2RlZg==\n"} {
+		if _, err := NewHMACAuthenticator([]byte(config), time.Minute); err != ErrInvalidHMACSecretsConfig {
+			t.Fatalf("Expected ErrInvalidHMACSecretsConfig, took %v", err)
+		}
+	}
+}
@@ -17,7 +17,13 @@ limitations under the License.
 package main
 
 import (
+	"io/ioutil"
+	"time"
+
+	"github.com/cossacklabs/acra/cmd/acra-translator/common"
+	"github.com/cossacklabs/acra/httpauth"
 	"github.com/cossacklabs/acra/network"
+	"github.com/cossacklabs/acra/objectstore"
 )
 
 // AcraTranslatorConfig stores keys, poison record settings, connection attributes.
@@ -32,6 +38,16 @@ type AcraTranslatorConfig struct {
 	ConnectionWrapper            network.ConnectionWrapper
 	configPath                   string
 	debug                        bool
+	decryptionCacheTTL           time.Duration
+	decryptionCacheMaxSize       int
+	auditLog                     *common.AuditLog
+	hmacAuthenticator            *common.HMACAuthenticator
+	httpAuthProvider             httpauth.Provider
+	hmacRequired                 bool
+	objectStorage                objectstore.Storage
+	objectMaxSize                int64
+	contentEncodings             []common.ContentEncoding
+	maxBodySize                  int64
 }
 
 // NewConfig creates new AcraTranslatorConfig.
@@ -128,3 +144,145 @@ func (a *AcraTranslatorConfig) Debug() bool {
 func (a *AcraTranslatorConfig) SetDebug(debug bool) {
 	a.debug = debug
 }
+
+// DecryptionCacheTTL returns time to store decrypted AcraStructs in cache, 0 means that cache turned off.
+func (a *AcraTranslatorConfig) DecryptionCacheTTL() time.Duration {
+	return a.decryptionCacheTTL
+}
+
+// SetDecryptionCacheTTL sets time to store decrypted AcraStructs in cache.
+func (a *AcraTranslatorConfig) SetDecryptionCacheTTL(ttl time.Duration) {
+	a.decryptionCacheTTL = ttl
+}
+
+// DecryptionCacheMaxSize returns max size in bytes of decrypted data stored in cache.
+func (a *AcraTranslatorConfig) DecryptionCacheMaxSize() int {
+	return a.decryptionCacheMaxSize
+}
+
+// SetDecryptionCacheMaxSize sets max size in bytes of decrypted data stored in cache.
+func (a *AcraTranslatorConfig) SetDecryptionCacheMaxSize(maxSize int) {
+	a.decryptionCacheMaxSize = maxSize
+}
+
+// AuditLog returns log of requests or nil if audit turned off
+func (a *AcraTranslatorConfig) AuditLog() *common.AuditLog {
+	return a.auditLog
+}
+
+// SetAuditLogFile opens file to append records about requests, empty path turns off audit
+func (a *AcraTranslatorConfig) SetAuditLogFile(path string) error {
+	if path == "" {
+		a.auditLog = nil
+		return nil
+	}
+	auditLog, err := common.NewAuditLogFile(path)
+	if err != nil {
+		return err
+	}
+	a.auditLog = auditLog
+	return nil
+}
+
+// HMACAuthenticator returns verifier of signed HTTP requests or nil if signatures aren't used
+func (a *AcraTranslatorConfig) HMACAuthenticator() *common.HMACAuthenticator {
+	return a.hmacAuthenticator
+}
+
+// SetHMACSecretsFile loads shared secrets of clients which sign HTTP requests, empty path turns off signatures
+func (a *AcraTranslatorConfig) SetHMACSecretsFile(path string, maxClockSkew time.Duration) error {
+	if path == "" {
+		a.hmacAuthenticator = nil
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	a.hmacAuthenticator, err = common.NewHMACAuthenticator(data, maxClockSkew)
+	return err
+}
+
+// HMACRequired returns true if HTTP requests without valid signature are rejected
+func (a *AcraTranslatorConfig) HMACRequired() bool {
+	return a.hmacRequired
+}
+
+// SetHMACRequired sets whether HTTP requests without valid signature are rejected
+func (a *AcraTranslatorConfig) SetHMACRequired(required bool) {
+	a.hmacRequired = required
+}
+
+// ObjectStorage returns storage of objects decrypted by HTTP API or nil if objects aren't proxied
+func (a *AcraTranslatorConfig) ObjectStorage() objectstore.Storage {
+	return a.objectStorage
+}
+
+// SetObjectStorage creates client of object storage of storageType, empty type turns off proxying of objects
+func (a *AcraTranslatorConfig) SetObjectStorage(storageType, endpoint, region string) error {
+	if storageType == "" {
+		a.objectStorage = nil
+		return nil
+	}
+	storage, err := objectstore.NewStorage(storageType, endpoint, region)
+	if err != nil {
+		return err
+	}
+	a.objectStorage = storage
+	return nil
+}
+
+// ObjectMaxSize returns max size in bytes of objects with AcraStructs decrypted in memory, 0 means without limits
+func (a *AcraTranslatorConfig) ObjectMaxSize() int64 {
+	return a.objectMaxSize
+}
+
+// SetObjectMaxSize sets max size in bytes of objects with AcraStructs decrypted in memory
+func (a *AcraTranslatorConfig) SetObjectMaxSize(maxSize int64) {
+	a.objectMaxSize = maxSize
+}
+
+// ContentEncodings returns encodings of request and response bodies in order of preference
+func (a *AcraTranslatorConfig) ContentEncodings() []common.ContentEncoding {
+	return a.contentEncodings
+}
+
+// SetContentEncodings sets encodings of request and response bodies from comma separated list of names, empty value
+// turns off encodings
+func (a *AcraTranslatorConfig) SetContentEncodings(value string) error {
+	encodings, err := common.ParseContentEncodings(value)
+	if err != nil {
+		return err
+	}
+	a.contentEncodings = encodings
+	return nil
+}
+
+// MaxBodySize returns max size in bytes of request body before and after decoding, 0 means without limits
+func (a *AcraTranslatorConfig) MaxBodySize() int64 {
+	return a.maxBodySize
+}
+
+// SetMaxBodySize sets max size in bytes of request body before and after decoding
+func (a *AcraTranslatorConfig) SetMaxBodySize(maxSize int64) {
+	a.maxBodySize = maxSize
+}
+
+// HTTPAuthProvider returns provider which authenticates HTTP requests or nil if providers aren't configured
+func (a *AcraTranslatorConfig) HTTPAuthProvider() httpauth.Provider {
+	return a.httpAuthProvider
+}
+
+// SetHTTPAuthProvidersFile loads authentication providers of HTTP requests, empty path turns off providers
+func (a *AcraTranslatorConfig) SetHTTPAuthProvidersFile(path string) error {
+	if path == "" {
+		a.httpAuthProvider = nil
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	a.httpAuthProvider, err = httpauth.LoadProviders(data)
+	return err
+}
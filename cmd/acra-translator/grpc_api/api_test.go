@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/cossacklabs/acra/acra-writer"
 	"github.com/cossacklabs/acra/cmd/acra-translator/common"
@@ -22,6 +23,10 @@ func (*testKeystore) RotateZoneKey(zoneID []byte) ([]byte, error) {
 	panic("implement me")
 }
 
+func (*testKeystore) RotateStorageKeys(id []byte) ([]byte, error) {
+	panic("implement me")
+}
+
 func (*testKeystore) GetPrivateKey(id []byte) (*keys.PrivateKey, error) {
 	panic("implement me")
 }
@@ -51,6 +56,22 @@ func (keystore *testKeystore) GetServerDecryptionPrivateKey(id []byte) (*keys.Pr
 	return nil, ErrKeyNotFound
 }
 
+func (keystore *testKeystore) GetZonePrivateKeys(id []byte) ([]*keys.PrivateKey, error) {
+	privateKey, err := keystore.GetZonePrivateKey(id)
+	if err != nil {
+		return nil, err
+	}
+	return []*keys.PrivateKey{privateKey}, nil
+}
+
+func (keystore *testKeystore) GetServerDecryptionPrivateKeys(id []byte) ([]*keys.PrivateKey, error) {
+	privateKey, err := keystore.GetServerDecryptionPrivateKey(id)
+	if err != nil {
+		return nil, err
+	}
+	return []*keys.PrivateKey{privateKey}, nil
+}
+
 func (*testKeystore) GenerateZoneKey() ([]byte, []byte, error) {
 	panic("implement me")
 }
@@ -82,6 +103,14 @@ func (*testKeystore) GetAuthKey(remove bool) ([]byte, error) {
 	panic("implement me")
 }
 
+func (*testKeystore) GenerateHMACSecretKey(id []byte) error {
+	panic("implement me")
+}
+
+func (*testKeystore) GetHMACSecretKey(id []byte) ([]byte, error) {
+	panic("implement me")
+}
+
 func (*testKeystore) Reset() {
 	panic("implement me")
 }
@@ -197,3 +226,56 @@ func TestDecryptGRPCService_Decrypt(t *testing.T) {
 		t.Fatal("Poison record callback was called")
 	}
 }
+
+func TestDecryptGRPCService_DecryptionCache(t *testing.T) {
+	ctx := context.Background()
+	keypair, err := keys.New(keys.KEYTYPE_EC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientID := []byte("test client")
+	data := []byte("data")
+	keystore := &testKeystore{PrivateKey: keypair.Private}
+	translatorData := &common.TranslatorData{Keystorage: keystore, DecryptionCache: common.NewDecryptionCache(time.Minute, 1024)}
+	service, err := NewDecryptGRPCService(translatorData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acrastruct, err := acrawriter.CreateAcrastruct(data, keypair.Public, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := service.Decrypt(ctx, &DecryptRequest{ClientId: clientID, Acrastruct: acrastruct})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(response.Data, data) {
+		t.Fatal("response data not equal to initial data")
+	}
+
+	// cached data isn't returned after revocation of key
+	keystore.PrivateKey = nil
+	if _, err := service.Decrypt(ctx, &DecryptRequest{ClientId: clientID, Acrastruct: acrastruct}); err != ErrCantDecrypt {
+		t.Fatalf("Expected %v after revocation of key, took %v", ErrCantDecrypt, err)
+	}
+
+	// cached data isn't returned after rotation of key without previous versions
+	newKeypair, err := keys.New(keys.KEYTYPE_EC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keystore.PrivateKey = newKeypair.Private
+	if _, err := service.Decrypt(ctx, &DecryptRequest{ClientId: clientID, Acrastruct: acrastruct}); err != ErrCantDecrypt {
+		t.Fatalf("Expected %v after rotation of key, took %v", ErrCantDecrypt, err)
+	}
+
+	// cached data is returned with the same key
+	keystore.PrivateKey = keypair.Private
+	response, err = service.Decrypt(ctx, &DecryptRequest{ClientId: clientID, Acrastruct: acrastruct})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(response.Data, data) {
+		t.Fatal("response data not equal to initial data")
+	}
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grpc_api
+
+import (
+	"io"
+
+	"github.com/cossacklabs/acra/cmd/acra-translator/common"
+	"google.golang.org/grpc/encoding"
+)
+
+// compressor adapts content encoding of AcraTranslator to compressor of gRPC messages
+type compressor struct {
+	common.ContentEncoding
+}
+
+// Compress returns writer which compresses data written to w
+func (c compressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return c.NewWriter(w)
+}
+
+// Decompress returns reader which decompresses data read from r
+func (c compressor) Decompress(r io.Reader) (io.Reader, error) {
+	return c.NewReader(r)
+}
+
+// RegisterCompressors registers encodings as compressors of gRPC messages, so clients may compress requests with
+// them and get responses compressed same way. Size of decompressed message is limited by max size of received message
+// of gRPC server. Should be called before gRPC server started
+func RegisterCompressors(encodings []common.ContentEncoding) {
+	for _, contentEncoding := range encodings {
+		encoding.RegisterCompressor(compressor{contentEncoding})
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"github.com/cossacklabs/acra/utils"
 	"github.com/cossacklabs/themis/gothemis/keys"
 	"github.com/sirupsen/logrus"
+	"time"
 )
 
 // DecryptGRPCService represents decryptor for decrypting AcraStructs from gRPC requests.
@@ -44,30 +45,60 @@ func NewDecryptGRPCService(data *common.TranslatorData) (*DecryptGRPCService, er
 var (
 	ErrCantDecrypt      = errors.New("can't decrypt data")
 	ErrClientIDRequired = errors.New("clientID is empty")
+	ErrOverloaded       = errors.New("too many simultaneous decryptions")
 )
 
 // Decrypt decrypts AcraStruct from gRPC request and returns decrypted data or error.
 func (service *DecryptGRPCService) Decrypt(ctx context.Context, request *DecryptRequest) (*DecryptResponse, error) {
-	var privateKey *keys.PrivateKey
+	var privateKeys []*keys.PrivateKey
 	var err error
 	var decryptionContext []byte
 	logger := logrus.WithFields(logrus.Fields{"client_id": string(request.ClientId), "zone_id": string(request.ZoneId), "translator": "grpc"})
+	startTime := time.Now()
+	auditStatus := common.AuditStatusBadRequest
+	defer func() {
+		service.TranslatorData.AuditLog.Add("grpc", request.ClientId, common.AuditOperationDecrypt, request.ZoneId, request.Acrastruct, auditStatus, startTime)
+	}()
 	if len(request.ClientId) == 0 {
 		logrus.Errorln("GRPC request without ClientID not allowed")
 		return nil, ErrClientIDRequired
 	}
+	auditStatus = common.AuditStatusDecryptionError
+	// keys are loaded before lookup in cache, so cached data isn't returned after keys were revoked or rotated
 	if len(request.ZoneId) != 0 {
-		privateKey, err = service.TranslatorData.Keystorage.GetZonePrivateKey(request.ZoneId)
+		privateKeys, err = service.TranslatorData.Keystorage.GetZonePrivateKeys(request.ZoneId)
 		decryptionContext = request.ZoneId
 	} else {
-		privateKey, err = service.TranslatorData.Keystorage.GetServerDecryptionPrivateKey(request.ClientId)
+		privateKeys, err = service.TranslatorData.Keystorage.GetServerDecryptionPrivateKeys(request.ClientId)
 	}
 	if err != nil {
 		logger.WithError(err).Errorln("Can't load private key for decryption")
 		return nil, ErrCantDecrypt
 	}
-	data, decryptErr := base.DecryptAcrastruct(request.Acrastruct, privateKey, decryptionContext)
-	utils.FillSlice(byte(0), privateKey.Value)
+	defer func() {
+		for _, privateKey := range privateKeys {
+			utils.FillSlice(byte(0), privateKey.Value)
+		}
+	}()
+	if service.TranslatorData.DecryptionCache != nil {
+		if data, ok := service.TranslatorData.DecryptionCache.Get(request.Acrastruct, request.ClientId, request.ZoneId, privateKeys); ok {
+			auditStatus = common.AuditStatusOK
+			logger.Debugln("Load decrypted AcraStruct from cache")
+			return &DecryptResponse{Data: data}, nil
+		}
+	}
+	limiter := base.GetDecryptionLimiter()
+	if err := limiter.AcquireContext(ctx); err != nil {
+		if err == ctx.Err() {
+			logger.WithError(err).Debugln("Request canceled while waiting for decryption")
+			return nil, err
+		}
+		auditStatus = common.AuditStatusOverloaded
+		logger.WithError(err).Warningln("Can't decrypt AcraStruct, limit of simultaneous decryptions exceeded")
+		return nil, ErrOverloaded
+	}
+	defer limiter.Release()
+	data, decryptErr := base.DecryptRotatedAcrastruct(request.Acrastruct, privateKeys, decryptionContext)
 	if decryptErr != nil {
 		logger.WithError(decryptErr).Errorln("Can't decrypt AcraStruct")
 		if service.TranslatorData.CheckPoisonRecords {
@@ -77,6 +108,7 @@ func (service *DecryptGRPCService) Decrypt(ctx context.Context, request *Decrypt
 				return nil, ErrCantDecrypt
 			}
 			if poisoned {
+				auditStatus = common.AuditStatusPoisonRecord
 				logger.Errorln("Recognized poison record")
 				if service.TranslatorData.PoisonRecordCallbacks.HasCallbacks() {
 					if err := service.TranslatorData.PoisonRecordCallbacks.Call(); err != nil {
@@ -89,5 +121,9 @@ func (service *DecryptGRPCService) Decrypt(ctx context.Context, request *Decrypt
 		}
 		return nil, ErrCantDecrypt
 	}
+	if service.TranslatorData.DecryptionCache != nil {
+		service.TranslatorData.DecryptionCache.Add(request.Acrastruct, request.ClientId, request.ZoneId, privateKeys, data)
+	}
+	auditStatus = common.AuditStatusOK
 	return &DecryptResponse{Data: data}, nil
 }
@@ -0,0 +1,98 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package http_api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/cossacklabs/acra/cmd/acra-translator/common"
+)
+
+// minEncodedResponseSize is size of response body from which encoding saves more than it costs
+const minEncodedResponseSize = 1024
+
+// acceptedEncodings returns value of Accept-Encoding header with encodings of request bodies
+func (decryptor *HTTPConnectionsDecryptor) acceptedEncodings() string {
+	names := make([]string, 0, len(decryptor.TranslatorData.ContentEncodings)+1)
+	for _, encoding := range decryptor.TranslatorData.ContentEncodings {
+		names = append(names, encoding.Name())
+	}
+	if len(names) == 0 {
+		names = append(names, common.ContentEncodingIdentity)
+	}
+	return strings.Join(names, ", ")
+}
+
+// decodeBody returns body decoded according to Content-Encoding header of request. Returns response which should be
+// sent to client if encoding isn't supported or body can't be decoded
+func (decryptor *HTTPConnectionsDecryptor) decodeBody(request *http.Request, body []byte) ([]byte, *http.Response, error) {
+	name := strings.TrimSpace(request.Header.Get("Content-Encoding"))
+	if name == "" || strings.EqualFold(name, common.ContentEncodingIdentity) {
+		return body, nil, nil
+	}
+	encoding := common.FindContentEncoding(decryptor.TranslatorData.ContentEncodings, name)
+	if encoding == nil {
+		response := responseWithMessage(request, http.StatusUnsupportedMediaType, "Content-Encoding of request body isn't supported")
+		response.Header.Set("Accept-Encoding", decryptor.acceptedEncodings())
+		return nil, response, common.ErrUnknownContentEncoding
+	}
+	decoded, err := common.DecodeBody(encoding, bytes.NewReader(body), decryptor.TranslatorData.MaxBodySize)
+	if err == common.ErrBodyTooLarge {
+		return nil, responseWithMessage(request, http.StatusRequestEntityTooLarge, "Decoded body of request is too large"), err
+	}
+	if err != nil {
+		return nil, responseWithMessage(request, http.StatusBadRequest, "Can't decode body of request"), err
+	}
+	return decoded, nil, nil
+}
+
+// encodeResponse encodes body of response with first encoding accepted by client. Body is encoded as it is sent, so
+// streamed bodies aren't loaded into memory
+func (decryptor *HTTPConnectionsDecryptor) encodeResponse(response *http.Response) {
+	if response.Request == nil || response.Body == nil || response.Header.Get("Content-Encoding") != "" {
+		return
+	}
+	if response.ContentLength >= 0 && response.ContentLength < minEncodedResponseSize {
+		return
+	}
+	encoding := common.NegotiateContentEncoding(decryptor.TranslatorData.ContentEncodings, response.Request.Header.Get("Accept-Encoding"))
+	if encoding == nil {
+		return
+	}
+	body := response.Body
+	reader, writer := io.Pipe()
+	encoder, err := encoding.NewWriter(writer)
+	if err != nil {
+		return
+	}
+	go func() {
+		_, err := io.Copy(encoder, body)
+		if closeErr := encoder.Close(); err == nil {
+			err = closeErr
+		}
+		body.Close()
+		writer.CloseWithError(err)
+	}()
+	response.Body = reader
+	response.ContentLength = -1
+	response.Header.Set("Content-Encoding", encoding.Name())
+	response.Header.Add("Vary", "Accept-Encoding")
+	response.Header.Del("Content-Length")
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package http_api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/cossacklabs/acra/cmd/acra-translator/common"
+	"github.com/cossacklabs/acra/decryptor/base"
+	log "github.com/sirupsen/logrus"
+)
+
+func gzipTestData(t *testing.T, data []byte) []byte {
+	buffer := &bytes.Buffer{}
+	writer := gzip.NewWriter(buffer)
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestHTTPEncodedRequests(t *testing.T) {
+	encodings, err := common.ParseContentEncodings("gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	translatorData := &common.TranslatorData{Keystorage: &testKeystore{}, PoisonRecordCallbacks: base.NewPoisonCallbackStorage(),
+		ContentEncodings: encodings, MaxBodySize: 100}
+	decryptor, err := NewHTTPConnectionsDecryptor(translatorData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := log.NewEntry(log.StandardLogger())
+	newRequest := func(encoding string, body []byte) *http.Request {
+		request := &http.Request{Method: http.MethodPost, Header: http.Header{}, Body: ioutil.NopCloser(bytes.NewReader(body))}
+		request.URL, _ = url.Parse("http://smth.com/v1/decrypt?zone_id=somezoneid")
+		if encoding != "" {
+			request.Header.Set("Content-Encoding", encoding)
+		}
+		return request
+	}
+
+	// decoded body is processed as AcraStruct
+	response := decryptor.ParseRequestPrepareResponse(logger, newRequest("gzip", gzipTestData(t, []byte("not AcraStruct"))), []byte("client"))
+	if response.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected StatusUnprocessableEntity for decoded body, got %s", response.Status)
+	}
+	response = decryptor.ParseRequestPrepareResponse(logger, newRequest("zstd", []byte("not AcraStruct")), []byte("client"))
+	if response.StatusCode != http.StatusUnsupportedMediaType || response.Header.Get("Accept-Encoding") != "gzip" {
+		t.Fatalf("Expected StatusUnsupportedMediaType for not enabled encoding, got %s", response.Status)
+	}
+	response = decryptor.ParseRequestPrepareResponse(logger, newRequest("gzip", []byte("not gzip")), []byte("client"))
+	if response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected StatusBadRequest for malformed encoded body, got %s", response.Status)
+	}
+	response = decryptor.ParseRequestPrepareResponse(logger, newRequest("gzip", gzipTestData(t, bytes.Repeat([]byte{0}, 101))), []byte("client"))
+	if response.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected StatusRequestEntityTooLarge for too large decoded body, got %s", response.Status)
+	}
+	response = decryptor.ParseRequestPrepareResponse(logger, newRequest("", bytes.Repeat([]byte{0}, 101)), []byte("client"))
+	if response.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected StatusRequestEntityTooLarge for too large body, got %s", response.Status)
+	}
+}
+
+func TestHTTPEncodeResponse(t *testing.T) {
+	encodings, err := common.ParseContentEncodings("zstd,gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptor, err := NewHTTPConnectionsDecryptor(&common.TranslatorData{ContentEncodings: encodings})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("plaintext "), minEncodedResponseSize)
+	request := &http.Request{Header: http.Header{}}
+	request.Header.Set("Accept-Encoding", "gzip")
+	newResponse := func(data []byte) *http.Response {
+		response := emptyResponseWithStatus(request, http.StatusOK)
+		response.Body = ioutil.NopCloser(bytes.NewReader(data))
+		response.ContentLength = int64(len(data))
+		return response
+	}
+
+	response := newResponse(data)
+	decryptor.encodeResponse(response)
+	if response.Header.Get("Content-Encoding") != "gzip" || response.ContentLength != -1 {
+		t.Fatalf("Expected response encoded by gzip, took headers %v", response.Header)
+	}
+	reader, err := gzip.NewReader(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, data) {
+		t.Fatal("Incorrect encoded body of response")
+	}
+
+	// small responses and responses for clients which don't accept encodings are sent as is
+	response = newResponse(data[:minEncodedResponseSize-1])
+	decryptor.encodeResponse(response)
+	if response.Header.Get("Content-Encoding") != "" {
+		t.Fatal("Expected small response without encoding")
+	}
+	request.Header.Del("Accept-Encoding")
+	response = newResponse(data)
+	decryptor.encodeResponse(response)
+	if response.Header.Get("Content-Encoding") != "" {
+		t.Fatal("Expected response without encoding for client which doesn't accept encodings")
+	}
+}
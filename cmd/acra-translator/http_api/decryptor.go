@@ -20,10 +20,12 @@ limitations under the License.
 package http_api
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"github.com/cossacklabs/acra/cmd/acra-translator/common"
 	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/httpauth"
 	"github.com/cossacklabs/acra/logging"
 	"github.com/cossacklabs/acra/utils"
 	"github.com/cossacklabs/themis/gothemis/keys"
@@ -33,6 +35,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 )
 
 // HTTPConnectionsDecryptor object for decrypting AcraStructs from HTTP requests.
@@ -45,15 +48,22 @@ func NewHTTPConnectionsDecryptor(data *common.TranslatorData) (*HTTPConnectionsD
 	return &HTTPConnectionsDecryptor{TranslatorData: data}, nil
 }
 
-// SendResponse sends HTTP response to connection using Buffer.
+// SendResponse sends HTTP response to connection using buffered writer, so streamed bodies aren't loaded into memory.
 func (decryptor *HTTPConnectionsDecryptor) SendResponse(logger *log.Entry, response *http.Response, connection net.Conn) {
-	outBuffer := &bytes.Buffer{}
+	if len(decryptor.TranslatorData.ContentEncodings) != 0 {
+		decryptor.encodeResponse(response)
+	}
+	outBuffer := bufio.NewWriter(connection)
 	err := response.Write(outBuffer)
+	// body isn't closed by Write if headers weren't written
+	if response.Body != nil {
+		response.Body.Close()
+	}
 	if err != nil {
 		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantReturnResponse).
 			Warningln("Can't write response to buffer")
 	}
-	_, err = outBuffer.WriteTo(connection)
+	err = outBuffer.Flush()
 	if err != nil {
 		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantReturnResponse).
 			Warningln("Can't write response to buffer")
@@ -63,6 +73,7 @@ func (decryptor *HTTPConnectionsDecryptor) SendResponse(logger *log.Entry, respo
 // ParseRequestPrepareResponse parses HTTP request to find AcraStruct and ZoneID, then decrypts AcraStruct.
 // Returns HTTP response with appropriate status code, headers, decrypted AcraStruct or error message.
 func (decryptor *HTTPConnectionsDecryptor) ParseRequestPrepareResponse(logger *log.Entry, request *http.Request, clientID []byte) *http.Response {
+	startTime := time.Now()
 	requestLogger := logger.WithFields(log.Fields{"client_id": string(clientID), "translator": "http"})
 	if request == nil || request.URL == nil {
 		return emptyResponseWithStatus(request, http.StatusBadRequest)
@@ -70,6 +81,11 @@ func (decryptor *HTTPConnectionsDecryptor) ParseRequestPrepareResponse(logger *l
 
 	requestLogger.Debugf("Incoming API request to %v", request.URL.Path)
 
+	// objects are requested with GET and their keys may contain slashes
+	if strings.HasPrefix(request.URL.Path, objectsPathPrefix) {
+		return decryptor.processObjectRequest(requestLogger, request, clientID)
+	}
+
 	if request.Method != http.MethodPost {
 		msg := fmt.Sprintf("HTTP method is not allowed, expected POST, got %s", request.Method)
 		requestLogger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorMethodNotAllowed).Warningf(msg)
@@ -97,7 +113,11 @@ func (decryptor *HTTPConnectionsDecryptor) ParseRequestPrepareResponse(logger *l
 
 	switch endpoint {
 	case "decrypt":
-		var zoneID []byte
+		var zoneID, acraStruct []byte
+		auditStatus := common.AuditStatusBadRequest
+		defer func() {
+			decryptor.TranslatorData.AuditLog.Add("http", clientID, common.AuditOperationDecrypt, zoneID, acraStruct, auditStatus, startTime)
+		}()
 
 		// optional zone_id
 		query, ok := request.URL.Query()["zone_id"]
@@ -106,33 +126,31 @@ func (decryptor *HTTPConnectionsDecryptor) ParseRequestPrepareResponse(logger *l
 			requestLogger = requestLogger.WithField("zone_id", query[0])
 		}
 
+		var response *http.Response
+		requestLogger, clientID, acraStruct, auditStatus, response = decryptor.readAuthenticatedRequest(requestLogger, request, clientID, "AcraStruct")
+		if response != nil {
+			return response
+		}
+
 		if zoneID == nil && clientID == nil {
 			msg := fmt.Sprintf("HTTP request doesn't have a ZoneID, connection doesn't have a ClientID, expected to get one of them. Send ZoneID in request URL")
 			requestLogger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantZoneIDMissing).Warningln(msg)
 			return responseWithMessage(request, http.StatusBadRequest, msg)
 		}
 
-		if request.Body == nil {
-			msg := fmt.Sprintf("HTTP request doesn't have a body, expected to get AcraStruct")
-			requestLogger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantParseRequestBody).Warningln(msg)
-			return responseWithMessage(request, http.StatusBadRequest, msg)
-		}
-
-		acraStruct, err := ioutil.ReadAll(request.Body)
-		defer request.Body.Close()
-
-		if acraStruct == nil || err != nil {
-			msg := fmt.Sprintf("Can't parse body from HTTP request, expected to get AcraStruct")
-			requestLogger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantParseRequestBody).Warningln(msg)
-			return responseWithMessage(request, http.StatusBadRequest, msg)
-		}
-
 		decryptedStruct, err := decryptor.decryptAcraStruct(logger, acraStruct, zoneID, clientID)
 
+		if err == base.ErrDecryptionQueueFull || err == base.ErrDecryptionWaitTimeout {
+			auditStatus = common.AuditStatusOverloaded
+			msg := "Too many simultaneous decryptions, try later"
+			requestLogger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantDecryptAcraStruct).Warningln(msg)
+			return responseWithMessage(request, http.StatusServiceUnavailable, msg)
+		}
 		if err != nil {
+			auditStatus = common.AuditStatusDecryptionError
 			msg := fmt.Sprintf("Can't decrypt AcraStruct")
 			requestLogger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantDecryptAcraStruct).Warningln(msg)
-			response := responseWithMessage(request, http.StatusUnprocessableEntity, msg)
+			response = responseWithMessage(request, http.StatusUnprocessableEntity, msg)
 			if decryptor.TranslatorData.CheckPoisonRecords {
 				// check poison records
 				poisoned, err := base.CheckPoisonRecord(acraStruct, decryptor.TranslatorData.Keystorage)
@@ -141,6 +159,7 @@ func (decryptor *HTTPConnectionsDecryptor) ParseRequestPrepareResponse(logger *l
 					return response
 				}
 				if poisoned {
+					auditStatus = common.AuditStatusPoisonRecord
 					requestLogger.Errorln("Recognized poison record")
 					if decryptor.TranslatorData.PoisonRecordCallbacks.HasCallbacks() {
 						if err := decryptor.TranslatorData.PoisonRecordCallbacks.Call(); err != nil {
@@ -153,13 +172,16 @@ func (decryptor *HTTPConnectionsDecryptor) ParseRequestPrepareResponse(logger *l
 			return response
 		}
 
+		auditStatus = common.AuditStatusOK
 		requestLogger.Infoln("Decrypted AcraStruct")
 
-		response := emptyResponseWithStatus(request, http.StatusOK)
+		response = emptyResponseWithStatus(request, http.StatusOK)
 		response.Header.Set("Content-Type", "application/octet-stream")
 		response.Body = ioutil.NopCloser(bytes.NewReader(decryptedStruct))
 		response.ContentLength = int64(len(decryptedStruct))
 		return response
+	case endpointSecureMessageDecrypt, endpointSecureMessageVerify, endpointSecureCellDecrypt:
+		return decryptor.processThemisRequest(requestLogger, request, clientID, endpoint)
 	default:
 		msg := "HTTP endpoint not supported"
 		requestLogger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorEndpointNotSupported).
@@ -173,32 +195,112 @@ func (decryptor *HTTPConnectionsDecryptor) ParseRequestPrepareResponse(logger *l
 	return responseWithMessage(request, http.StatusBadRequest, msg)
 }
 
-func (decryptor *HTTPConnectionsDecryptor) decryptAcraStruct(logger *log.Entry, acraStruct []byte, zoneID []byte, clientID []byte) ([]byte, error) {
-	var err error
-	var privateKey *keys.PrivateKey
-	var decryptionContext []byte
+// readAuthenticatedRequest authenticates request by configured providers, reads its body and checks signature of
+// signed request. Returns logger of request, client id from signature or connection, body and audit status. Returns
+// response which should be sent to client if request is rejected
+func (decryptor *HTTPConnectionsDecryptor) readAuthenticatedRequest(requestLogger *log.Entry, request *http.Request, clientID []byte, payload string) (*log.Entry, []byte, []byte, string, *http.Response) {
+	if provider := decryptor.TranslatorData.AuthProvider; provider != nil {
+		identity, err := provider.Authenticate(request)
+		if err != nil {
+			msg := "Can't authenticate HTTP request"
+			requestLogger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorUnauthorizedRequest).Warningln(msg)
+			response := responseWithMessage(request, http.StatusUnauthorized, msg)
+			if challenger, ok := provider.(httpauth.Challenger); ok && challenger.Challenge() != "" {
+				response.Header.Set("WWW-Authenticate", challenger.Challenge())
+			}
+			return requestLogger, clientID, nil, common.AuditStatusUnauthorized, response
+		}
+		requestLogger = requestLogger.WithField("identity", identity.Name)
+	}
+
+	if request.Body == nil {
+		msg := fmt.Sprintf("HTTP request doesn't have a body, expected to get %s", payload)
+		requestLogger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantParseRequestBody).Warningln(msg)
+		return requestLogger, clientID, nil, common.AuditStatusBadRequest, responseWithMessage(request, http.StatusBadRequest, msg)
+	}
 
+	body, err := common.ReadLimited(request.Body, decryptor.TranslatorData.MaxBodySize)
+	request.Body.Close()
+
+	if err == common.ErrBodyTooLarge {
+		msg := fmt.Sprintf("Body of HTTP request is too large, expected to get %s", payload)
+		requestLogger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantParseRequestBody).Warningln(msg)
+		return requestLogger, clientID, nil, common.AuditStatusBadRequest, responseWithMessage(request, http.StatusRequestEntityTooLarge, msg)
+	}
+	if body == nil || err != nil {
+		msg := fmt.Sprintf("Can't parse body from HTTP request, expected to get %s", payload)
+		requestLogger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantParseRequestBody).Warningln(msg)
+		return requestLogger, clientID, nil, common.AuditStatusBadRequest, responseWithMessage(request, http.StatusBadRequest, msg)
+	}
+
+	// signed requests are authenticated by client id from signature instead of client id of connection
+	if authenticator := decryptor.TranslatorData.HMACAuthenticator; authenticator != nil && (decryptor.TranslatorData.HMACRequired || common.IsSigned(request)) {
+		clientID, err = authenticator.Authenticate(request, body)
+		if err != nil {
+			msg := "Can't authenticate signed HTTP request"
+			requestLogger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorUnauthorizedRequest).Warningln(msg)
+			return requestLogger, clientID, body, common.AuditStatusUnauthorized, responseWithMessage(request, http.StatusUnauthorized, msg)
+		}
+		requestLogger = requestLogger.WithField("client_id", string(clientID))
+	}
+
+	// signature covers body as it was sent, so body is decoded after authentication
+	body, response, err := decryptor.decodeBody(request, body)
+	if err != nil {
+		requestLogger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantParseRequestBody).
+			Warningln("Can't decode body of HTTP request")
+		return requestLogger, clientID, nil, common.AuditStatusBadRequest, response
+	}
+	return requestLogger, clientID, body, common.AuditStatusBadRequest, nil
+}
+
+// privateKeys returns all versions of private keys of zone if zoneID isn't empty or of client otherwise with context
+// of decryption
+func (decryptor *HTTPConnectionsDecryptor) privateKeys(zoneID, clientID []byte) ([]*keys.PrivateKey, []byte, error) {
 	if len(zoneID) != 0 {
-		privateKey, err = decryptor.TranslatorData.Keystorage.GetZonePrivateKey(zoneID)
-		decryptionContext = zoneID
-	} else {
-		privateKey, err = decryptor.TranslatorData.Keystorage.GetServerDecryptionPrivateKey(clientID)
+		privateKeys, err := decryptor.TranslatorData.Keystorage.GetZonePrivateKeys(zoneID)
+		return privateKeys, zoneID, err
 	}
+	privateKeys, err := decryptor.TranslatorData.Keystorage.GetServerDecryptionPrivateKeys(clientID)
+	return privateKeys, nil, err
+}
 
+func (decryptor *HTTPConnectionsDecryptor) decryptAcraStruct(logger *log.Entry, acraStruct []byte, zoneID []byte, clientID []byte) ([]byte, error) {
+	// keys are loaded before lookup in cache, so cached data isn't returned after keys were revoked or rotated
+	privateKeys, decryptionContext, err := decryptor.privateKeys(zoneID, clientID)
 	if err != nil {
 		logger.Errorln("Can't load private key to decrypt AcraStruct")
 		return nil, err
 	}
+	// zeroing private keys
+	defer func() {
+		for _, privateKey := range privateKeys {
+			utils.FillSlice(byte(0), privateKey.Value)
+		}
+	}()
 
-	// decrypt
-	decryptedStruct, err := base.DecryptAcrastruct(acraStruct, privateKey, decryptionContext)
-	// zeroing private key
-	utils.FillSlice(byte(0), privateKey.Value)
+	if decryptor.TranslatorData.DecryptionCache != nil {
+		if decryptedStruct, ok := decryptor.TranslatorData.DecryptionCache.Get(acraStruct, clientID, zoneID, privateKeys); ok {
+			logger.Debugln("Load decrypted AcraStruct from cache")
+			return decryptedStruct, nil
+		}
+	}
 
+	limiter := base.GetDecryptionLimiter()
+	if err := limiter.Acquire(); err != nil {
+		return nil, err
+	}
+	defer limiter.Release()
+
+	// decrypt
+	decryptedStruct, err := base.DecryptRotatedAcrastruct(acraStruct, privateKeys, decryptionContext)
 	if err != nil {
 		return nil, err
 	}
 
+	if decryptor.TranslatorData.DecryptionCache != nil {
+		decryptor.TranslatorData.DecryptionCache.Add(acraStruct, clientID, zoneID, privateKeys, decryptedStruct)
+	}
 	return decryptedStruct, nil
 }
 
@@ -6,8 +6,12 @@ import (
 	"github.com/cossacklabs/acra/acra-writer"
 	"github.com/cossacklabs/acra/cmd/acra-translator/common"
 	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/httpauth"
+	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/acra/poison"
+	"github.com/cossacklabs/themis/gothemis/cell"
 	"github.com/cossacklabs/themis/gothemis/keys"
+	"github.com/cossacklabs/themis/gothemis/message"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
 	"net/http"
@@ -75,6 +79,13 @@ func TestHTTPResponseStatus(t *testing.T) {
 	if res.StatusCode != http.StatusUnprocessableEntity {
 		t.Fatalf("If Request Bad ZoneID and ClientID -> Status code should be StatusUnprocessableEntity, got %s\n", res.Status)
 	}
+
+	translatorData.AuthProvider = httpauth.NewMTLSProvider(nil)
+	request.Body = ioutil.NopCloser(bytes.NewBufferString("bla bla bla body"))
+	res = httpConnectionsDecryptor.ParseRequestPrepareResponse(logger, &request, []byte("asdf"))
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("If Request isn't authenticated by provider -> Status code should be StatusUnauthorized, got %s\n", res.Status)
+	}
 }
 
 func TestHTTPDecryptionAndResponse(t *testing.T) {
@@ -302,3 +313,87 @@ func TestHTTPDecryptionAcraStruct(t *testing.T) {
 		t.Fatal("Decrypted acrastruct is not equal to initial data")
 	}
 }
+
+func TestHTTPThemisEndpoints(t *testing.T) {
+	keyStore := &testKeystore{SymmetricKey: []byte("some symmetric key for secure cell")}
+	translatorData := &common.TranslatorData{Keystorage: keyStore, PoisonRecordCallbacks: base.NewPoisonCallbackStorage()}
+	httpConnectionsDecryptor, err := NewHTTPConnectionsDecryptor(translatorData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := log.NewEntry(log.StandardLogger())
+	clientKeypair, err := keys.New(keys.KEYTYPE_EC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerKeypair, err := keys.New(keys.KEYTYPE_EC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyStore.PrivateKey = clientKeypair.Private
+	keyStore.PeerPublicKey = peerKeypair.Public
+	clientID := []byte("some client id")
+	data := []byte("some data")
+
+	encrypted, err := message.New(peerKeypair.Private, clientKeypair.Public).Wrap(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := message.New(peerKeypair.Private, nil).Sign(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, _, err := cell.New(keyStore.SymmetricKey, cell.CELL_MODE_SEAL).Protect(data, []byte("some context"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rowKey, err := keystore.DeriveRowKey(keyStore.SymmetricKey, []byte("users:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealedRow, _, err := cell.New(rowKey, cell.CELL_MODE_SEAL).Protect(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		url    string
+		body   []byte
+		status int
+	}{
+		{"http://smth.com/v1/securemessage_decrypt?peer_id=some_peer", encrypted, http.StatusOK},
+		{"http://smth.com/v1/securemessage_verify?peer_id=some_peer", signed, http.StatusOK},
+		{"http://smth.com/v1/securecell_decrypt?context=some+context", sealed, http.StatusOK},
+		{"http://smth.com/v1/securecell_decrypt?row_context=users%3A1", sealedRow, http.StatusOK},
+		{"http://smth.com/v1/securecell_decrypt?row_context=users%3A1&zone_id=DDDDDDDDzone", sealedRow, http.StatusOK},
+		// key of other row
+		{"http://smth.com/v1/securecell_decrypt?row_context=users%3A2", sealedRow, http.StatusUnprocessableEntity},
+		// row key isn't symmetric key
+		{"http://smth.com/v1/securecell_decrypt", sealedRow, http.StatusUnprocessableEntity},
+		// without peer id
+		{"http://smth.com/v1/securemessage_decrypt", encrypted, http.StatusBadRequest},
+		// signed message isn't encrypted
+		{"http://smth.com/v1/securemessage_decrypt?peer_id=some_peer", signed, http.StatusUnprocessableEntity},
+		// incorrect context
+		{"http://smth.com/v1/securecell_decrypt", sealed, http.StatusUnprocessableEntity},
+	}
+	for i, testCase := range testCases {
+		request := http.Request{Method: http.MethodPost}
+		request.URL, _ = url.Parse(testCase.url)
+		request.Body = ioutil.NopCloser(bytes.NewReader(testCase.body))
+		res := httpConnectionsDecryptor.ParseRequestPrepareResponse(logger, &request, clientID)
+		if res.StatusCode != testCase.status {
+			t.Fatalf("[%d] Expected status %v, took %v", i, testCase.status, res.Status)
+		}
+		if res.StatusCode != http.StatusOK {
+			continue
+		}
+		payload, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(payload, data) {
+			t.Fatalf("[%d] Payload is not equal to initial data", i)
+		}
+	}
+}
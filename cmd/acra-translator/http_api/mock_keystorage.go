@@ -24,14 +24,19 @@ import (
 type testKeystore struct {
 	PrivateKey    *keys.PrivateKey
 	PoisonKeyPair *keys.Keypair
+	PeerPublicKey *keys.PublicKey
+	SymmetricKey  []byte
 }
 
 func (*testKeystore) GetPrivateKey(id []byte) (*keys.PrivateKey, error) {
 	panic("implement me")
 }
 
-func (*testKeystore) GetPeerPublicKey(id []byte) (*keys.PublicKey, error) {
-	panic("implement me")
+func (keystore *testKeystore) GetPeerPublicKey(id []byte) (*keys.PublicKey, error) {
+	if keystore.PeerPublicKey != nil {
+		return keystore.PeerPublicKey, nil
+	}
+	return nil, ErrKeyNotFound
 }
 
 // ErrKeyNotFound indicates error when decryption key is not found.
@@ -41,6 +46,10 @@ func (*testKeystore) RotateZoneKey(zoneID []byte) ([]byte, error) {
 	panic("implement me")
 }
 
+func (*testKeystore) RotateStorageKeys(id []byte) ([]byte, error) {
+	panic("implement me")
+}
+
 func (keystore *testKeystore) GetZonePrivateKey(id []byte) (*keys.PrivateKey, error) {
 	if keystore.PrivateKey != nil {
 		copied := make([]byte, len(keystore.PrivateKey.Value))
@@ -64,6 +73,22 @@ func (keystore *testKeystore) GetServerDecryptionPrivateKey(id []byte) (*keys.Pr
 	return nil, ErrKeyNotFound
 }
 
+func (keystore *testKeystore) GetZonePrivateKeys(id []byte) ([]*keys.PrivateKey, error) {
+	privateKey, err := keystore.GetZonePrivateKey(id)
+	if err != nil {
+		return nil, err
+	}
+	return []*keys.PrivateKey{privateKey}, nil
+}
+
+func (keystore *testKeystore) GetServerDecryptionPrivateKeys(id []byte) ([]*keys.PrivateKey, error) {
+	privateKey, err := keystore.GetServerDecryptionPrivateKey(id)
+	if err != nil {
+		return nil, err
+	}
+	return []*keys.PrivateKey{privateKey}, nil
+}
+
 func (*testKeystore) GenerateZoneKey() ([]byte, []byte, error) {
 	panic("implement me")
 }
@@ -98,6 +123,25 @@ func (*testKeystore) GetAuthKey(remove bool) ([]byte, error) {
 	panic("implement me")
 }
 
+func (*testKeystore) GenerateHMACSecretKey(id []byte) error {
+	panic("implement me")
+}
+
+func (*testKeystore) GetHMACSecretKey(id []byte) ([]byte, error) {
+	panic("implement me")
+}
+
+func (*testKeystore) GenerateSymmetricKey(id []byte) error {
+	panic("implement me")
+}
+
+func (keystore *testKeystore) GetSymmetricKey(id []byte) ([]byte, error) {
+	if keystore.SymmetricKey != nil {
+		return append([]byte{}, keystore.SymmetricKey...), nil
+	}
+	return nil, ErrKeyNotFound
+}
+
 func (*testKeystore) Reset() {
 	panic("implement me")
 }
@@ -0,0 +1,223 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package http_api
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cossacklabs/acra/cmd/acra-translator/common"
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/decryptor/replay"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/objectstore"
+	"github.com/cossacklabs/acra/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// endpointObjects returns objects of object storage with decrypted content: /v1/objects/<bucket>/<key>
+const endpointObjects = "objects"
+
+const objectsPathPrefix = "/v1/" + endpointObjects + "/"
+
+// Errors returned on decryption of objects
+var (
+	ErrObjectTooLarge    = errors.New("object with AcraStructs is larger than max size of object")
+	ErrCantDecryptObject = errors.New("object contains AcraStructs which can't be decrypted")
+)
+
+// decryptedObject is body of response which decrypts object as it is read and closes object after
+type decryptedObject struct {
+	io.Reader
+	object io.Closer
+}
+
+// Close closes body of object
+func (object *decryptedObject) Close() error {
+	return object.object.Close()
+}
+
+// processObjectRequest authenticates request, fetches object from object storage and returns response with decrypted
+// content of object. Status of response is sent before content, so errors of streamed decryption are reported only
+// by incomplete response
+func (decryptor *HTTPConnectionsDecryptor) processObjectRequest(requestLogger *log.Entry, request *http.Request, clientID []byte) *http.Response {
+	startTime := time.Now()
+	var zoneID, name []byte
+	auditStatus := common.AuditStatusBadRequest
+	defer func() {
+		decryptor.TranslatorData.AuditLog.Add("http", clientID, endpointObjects, zoneID, name, auditStatus, startTime)
+	}()
+
+	if request.Method != http.MethodGet {
+		msg := fmt.Sprintf("HTTP method is not allowed, expected GET, got %s", request.Method)
+		requestLogger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorMethodNotAllowed).Warningln(msg)
+		return responseWithMessage(request, http.StatusMethodNotAllowed, msg)
+	}
+	storage := decryptor.TranslatorData.ObjectStorage
+	if storage == nil {
+		msg := "Object storage isn't configured"
+		requestLogger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorEndpointNotSupported).Warningln(msg)
+		return responseWithMessage(request, http.StatusBadRequest, msg)
+	}
+	name = []byte(strings.TrimPrefix(request.URL.Path, objectsPathPrefix))
+	parts := strings.SplitN(string(name), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		msg := fmt.Sprintf("Malformed URL, expected %s<bucket>/<key>, got %s", objectsPathPrefix, request.URL.Path)
+		requestLogger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorMalformedURL).Warningln(msg)
+		return responseWithMessage(request, http.StatusBadRequest, msg)
+	}
+	bucket, key := parts[0], parts[1]
+	requestLogger = requestLogger.WithFields(log.Fields{"bucket": bucket, "key": key})
+	if value := request.URL.Query().Get("zone_id"); value != "" {
+		zoneID = []byte(value)
+		requestLogger = requestLogger.WithField("zone_id", value)
+	}
+
+	var response *http.Response
+	requestLogger, clientID, _, auditStatus, response = decryptor.readAuthenticatedRequest(requestLogger, request, clientID, "empty body")
+	if response != nil {
+		return response
+	}
+	if len(zoneID) == 0 && len(clientID) == 0 {
+		msg := "HTTP request doesn't have a ZoneID, connection doesn't have a ClientID, expected to get one of them. Send ZoneID in request URL"
+		requestLogger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantZoneIDMissing).Warningln(msg)
+		return responseWithMessage(request, http.StatusBadRequest, msg)
+	}
+
+	object, err := storage.GetObject(request.Context(), bucket, key)
+	if err == objectstore.ErrObjectNotFound {
+		msg := "Object not found"
+		requestLogger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantFetchObject).Warningln(msg)
+		return responseWithMessage(request, http.StatusNotFound, msg)
+	}
+	if err != nil {
+		msg := "Can't fetch object from object storage"
+		requestLogger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantFetchObject).Warningln(msg)
+		return responseWithMessage(request, http.StatusBadGateway, msg)
+	}
+
+	body, contentLength, err := decryptor.decryptObject(requestLogger, object, zoneID, clientID)
+	if err == base.ErrDecryptionQueueFull || err == base.ErrDecryptionWaitTimeout {
+		auditStatus = common.AuditStatusOverloaded
+		msg := "Too many simultaneous decryptions, try later"
+		requestLogger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantDecryptObject).Warningln(msg)
+		return responseWithMessage(request, http.StatusServiceUnavailable, msg)
+	}
+	if err == ErrObjectTooLarge {
+		msg := "Object is too large to decrypt AcraStructs"
+		requestLogger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantDecryptObject).Warningln(msg)
+		return responseWithMessage(request, http.StatusRequestEntityTooLarge, msg)
+	}
+	if err != nil {
+		auditStatus = common.AuditStatusDecryptionError
+		msg := "Can't decrypt object"
+		requestLogger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantDecryptObject).Warningln(msg)
+		return responseWithMessage(request, http.StatusUnprocessableEntity, msg)
+	}
+
+	auditStatus = common.AuditStatusOK
+	requestLogger.Infoln("Decrypting object")
+	response = emptyResponseWithStatus(request, http.StatusOK)
+	response.Header.Set("Content-Type", "application/octet-stream")
+	response.Body = body
+	response.ContentLength = contentLength
+	if contentLength < 0 {
+		response.TransferEncoding = []string{"chunked"}
+	}
+	return response
+}
+
+// decryptObject returns decrypted content of object and its length. AcraStream is decrypted as it is read and its
+// length is unknown (-1). Other objects are read into memory up to ObjectMaxSize and returned with all AcraStructs
+// replaced by plaintext. Body of object is closed on error
+func (decryptor *HTTPConnectionsDecryptor) decryptObject(logger *log.Entry, object *objectstore.Object, zoneID, clientID []byte) (io.ReadCloser, int64, error) {
+	reader := bufio.NewReader(object.Body)
+	// short objects aren't AcraStreams and are processed below
+	prefix, _ := reader.Peek(len(base.StreamTagBegin))
+	if bytes.Equal(prefix, base.StreamTagBegin) {
+		streamDecryptor, err := decryptor.newStreamDecryptor(reader, zoneID, clientID)
+		if err != nil {
+			object.Body.Close()
+			return nil, 0, err
+		}
+		// first chunk is decrypted before response is sent, so incorrect zone or keys are reported by its status
+		first := make([]byte, 1)
+		n, err := streamDecryptor.Read(first)
+		if err != nil && err != io.EOF {
+			object.Body.Close()
+			return nil, 0, err
+		}
+		return &decryptedObject{Reader: io.MultiReader(bytes.NewReader(first[:n]), streamDecryptor), object: object.Body}, -1, nil
+	}
+
+	defer object.Body.Close()
+	maxSize := decryptor.TranslatorData.ObjectMaxSize
+	var data []byte
+	var err error
+	if maxSize > 0 {
+		data, err = ioutil.ReadAll(io.LimitReader(reader, maxSize+1))
+		if err == nil && int64(len(data)) > maxSize {
+			err = ErrObjectTooLarge
+		}
+	} else {
+		data, err = ioutil.ReadAll(reader)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	limiter := base.GetDecryptionLimiter()
+	if err := limiter.Acquire(); err != nil {
+		return nil, 0, err
+	}
+	defer limiter.Release()
+	replayer := replay.NewReplayer(decryptor.TranslatorData.Keystorage, clientID)
+	if len(zoneID) != 0 {
+		replayer.SetZoneID(zoneID)
+	}
+	plaintext := replayer.ReplaceRaw(data)
+	stats := replayer.Stats()
+	logger.WithFields(log.Fields{"decrypted": stats.Decrypted, "failed": stats.Failed}).Debugln("Replaced AcraStructs of object")
+	if stats.Failed > 0 {
+		return nil, 0, ErrCantDecryptObject
+	}
+	return ioutil.NopCloser(bytes.NewReader(plaintext)), int64(len(plaintext)), nil
+}
+
+// newStreamDecryptor unwraps key of AcraStream read from reader with private keys of zone or client
+func (decryptor *HTTPConnectionsDecryptor) newStreamDecryptor(reader io.Reader, zoneID, clientID []byte) (*base.StreamDecryptor, error) {
+	limiter := base.GetDecryptionLimiter()
+	if err := limiter.Acquire(); err != nil {
+		return nil, err
+	}
+	defer limiter.Release()
+	privateKeys, decryptionContext, err := decryptor.privateKeys(zoneID, clientID)
+	if err != nil {
+		return nil, err
+	}
+	streamDecryptor, err := base.NewRotatedStreamDecryptor(reader, privateKeys, decryptionContext)
+	for _, privateKey := range privateKeys {
+		utils.FillSlice(byte(0), privateKey.Value)
+	}
+	return streamDecryptor, err
+}
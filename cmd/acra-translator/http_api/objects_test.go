@@ -0,0 +1,149 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package http_api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cossacklabs/acra/acra-writer"
+	"github.com/cossacklabs/acra/cmd/acra-translator/common"
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/objectstore"
+	"github.com/cossacklabs/themis/gothemis/keys"
+	log "github.com/sirupsen/logrus"
+)
+
+// testObjectStorage stores objects by bucket and key joined with slash
+type testObjectStorage map[string][]byte
+
+func (storage testObjectStorage) GetObject(ctx context.Context, bucket, key string) (*objectstore.Object, error) {
+	data, ok := storage[bucket+"/"+key]
+	if !ok {
+		return nil, objectstore.ErrObjectNotFound
+	}
+	return &objectstore.Object{Body: ioutil.NopCloser(bytes.NewReader(data)), Size: int64(len(data))}, nil
+}
+
+// sendObjectRequest returns response to request as it is read by HTTP client
+func sendObjectRequest(t *testing.T, decryptor *HTTPConnectionsDecryptor, method, target string, clientID []byte) (*http.Response, []byte) {
+	logger := log.NewEntry(log.StandardLogger())
+	response := decryptor.ParseRequestPrepareResponse(logger, httptest.NewRequest(method, target, nil), clientID)
+	server, client := net.Pipe()
+	go func() {
+		decryptor.SendResponse(logger, response, server)
+		server.Close()
+	}()
+	received, err := http.ReadResponse(bufio.NewReader(client), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(received.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return received, body
+}
+
+func TestHTTPObjectEndpoint(t *testing.T) {
+	keypair, err := keys.New(keys.KEYTYPE_EC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientID := []byte("client")
+	zoneID := []byte("zone")
+	data := []byte("some data of object")
+
+	stream := &bytes.Buffer{}
+	writer, err := acrawriter.NewStreamWriter(stream, keypair.Public, nil, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write(data)
+	writer.Close()
+	acraStruct, err := acrawriter.CreateAcrastruct(data, keypair.Public, zoneID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKeypair, err := keys.New(keys.KEYTYPE_EC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherAcraStruct, err := acrawriter.CreateAcrastruct(data, otherKeypair.Public, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := testObjectStorage{
+		"bucket/dir/stream.bin": stream.Bytes(),
+		"bucket/inline.csv":     append(append([]byte("id,value\n1,"), acraStruct...), '\n'),
+		"bucket/other.bin":      otherAcraStruct,
+	}
+	translatorData := &common.TranslatorData{Keystorage: &testKeystore{PrivateKey: keypair.Private},
+		PoisonRecordCallbacks: base.NewPoisonCallbackStorage()}
+	decryptor, err := NewHTTPConnectionsDecryptor(translatorData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response, _ := sendObjectRequest(t, decryptor, http.MethodGet, "/v1/objects/bucket/dir/stream.bin", clientID); response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected StatusBadRequest without object storage, took %v", response.Status)
+	}
+	translatorData.ObjectStorage = storage
+
+	response, body := sendObjectRequest(t, decryptor, http.MethodGet, "/v1/objects/bucket/dir/stream.bin", clientID)
+	if response.StatusCode != http.StatusOK || !bytes.Equal(body, data) {
+		t.Fatalf("Unexpected response for AcraStream %v: %q", response.Status, body)
+	}
+	response, body = sendObjectRequest(t, decryptor, http.MethodGet, "/v1/objects/bucket/inline.csv?zone_id=zone", clientID)
+	if expected := append([]byte("id,value\n1,"), append(data, '\n')...); response.StatusCode != http.StatusOK || !bytes.Equal(body, expected) {
+		t.Fatalf("Unexpected response for object with AcraStruct %v: %q", response.Status, body)
+	}
+
+	testcases := []struct {
+		method   string
+		target   string
+		clientID []byte
+		status   int
+	}{
+		{http.MethodPost, "/v1/objects/bucket/dir/stream.bin", clientID, http.StatusMethodNotAllowed},
+		{http.MethodGet, "/v1/objects/bucket", clientID, http.StatusBadRequest},
+		{http.MethodGet, "/v1/objects/bucket/dir/stream.bin", nil, http.StatusBadRequest},
+		{http.MethodGet, "/v1/objects/bucket/missing", clientID, http.StatusNotFound},
+		{http.MethodGet, "/v1/objects/bucket/dir/stream.bin?zone_id=zone", clientID, http.StatusUnprocessableEntity},
+		{http.MethodGet, "/v1/objects/bucket/other.bin", clientID, http.StatusUnprocessableEntity},
+	}
+	for _, testcase := range testcases {
+		if response, _ := sendObjectRequest(t, decryptor, testcase.method, testcase.target, testcase.clientID); response.StatusCode != testcase.status {
+			t.Fatalf("Expected %v for %s %s, took %v", testcase.status, testcase.method, testcase.target, response.Status)
+		}
+	}
+
+	translatorData.ObjectMaxSize = 10
+	if response, _ := sendObjectRequest(t, decryptor, http.MethodGet, "/v1/objects/bucket/inline.csv?zone_id=zone", clientID); response.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected StatusRequestEntityTooLarge, took %v", response.Status)
+	}
+	// AcraStreams aren't limited
+	if response, body := sendObjectRequest(t, decryptor, http.MethodGet, "/v1/objects/bucket/dir/stream.bin", clientID); response.StatusCode != http.StatusOK || !bytes.Equal(body, data) {
+		t.Fatalf("Unexpected response for AcraStream with max size %v: %q", response.Status, body)
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package http_api
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/cossacklabs/acra/cmd/acra-translator/common"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/utils"
+	"github.com/cossacklabs/themis/gothemis/cell"
+	"github.com/cossacklabs/themis/gothemis/message"
+	log "github.com/sirupsen/logrus"
+)
+
+// Endpoints which process payloads of Themis primitives with keys from keystore
+const (
+	// Secure Message encrypted by peer for client, decrypted with client's storage private key and peer's public key
+	endpointSecureMessageDecrypt = "securemessage_decrypt"
+	// Secure Message signed by peer, verified with peer's public key
+	endpointSecureMessageVerify = "securemessage_verify"
+	// Secure Cell in seal mode, decrypted with symmetric key of client or zone (zone_id) and optional context. Key of
+	// row derived from symmetric key is used if row_context passed
+	endpointSecureCellDecrypt = "securecell_decrypt"
+)
+
+// ErrSymmetricKeysNotSupported returned if keystore doesn't store symmetric keys for Secure Cell
+var ErrSymmetricKeysNotSupported = errors.New("keystore doesn't support symmetric keys")
+
+// processThemisRequest authenticates request, unwraps Secure Message or Secure Cell from body of request and returns
+// response with payload
+func (decryptor *HTTPConnectionsDecryptor) processThemisRequest(requestLogger *log.Entry, request *http.Request, clientID []byte, endpoint string) *http.Response {
+	startTime := time.Now()
+	var body []byte
+	auditStatus := common.AuditStatusBadRequest
+	defer func() {
+		decryptor.TranslatorData.AuditLog.Add("http", clientID, endpoint, nil, body, auditStatus, startTime)
+	}()
+
+	var peerID, zoneID, rowContext []byte
+	if endpoint == endpointSecureCellDecrypt {
+		if value := request.URL.Query().Get("zone_id"); value != "" {
+			zoneID = []byte(value)
+			requestLogger = requestLogger.WithField("zone_id", value)
+		}
+		rowContext = []byte(request.URL.Query().Get("row_context"))
+	} else {
+		peerID = []byte(request.URL.Query().Get("peer_id"))
+		if !keystore.ValidateID(peerID) {
+			msg := "HTTP request doesn't have valid peer_id, expected client id of message's sender in request URL"
+			requestLogger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorMalformedURL).Warningln(msg)
+			return responseWithMessage(request, http.StatusBadRequest, msg)
+		}
+		requestLogger = requestLogger.WithField("peer_id", string(peerID))
+	}
+
+	var response *http.Response
+	requestLogger, clientID, body, auditStatus, response = decryptor.readAuthenticatedRequest(requestLogger, request, clientID, "Themis payload")
+	if response != nil {
+		return response
+	}
+	if endpoint != endpointSecureMessageVerify && len(clientID) == 0 && len(zoneID) == 0 {
+		msg := "Connection doesn't have a ClientID, expected to get it from connection or signature of request"
+		requestLogger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantZoneIDMissing).Warningln(msg)
+		return responseWithMessage(request, http.StatusBadRequest, msg)
+	}
+
+	var payload []byte
+	var err error
+	switch endpoint {
+	case endpointSecureMessageDecrypt:
+		payload, err = decryptor.decryptSecureMessage(body, clientID, peerID)
+	case endpointSecureMessageVerify:
+		payload, err = decryptor.verifySecureMessage(body, peerID)
+	case endpointSecureCellDecrypt:
+		keyID := clientID
+		if len(zoneID) != 0 {
+			keyID = zoneID
+		}
+		payload, err = decryptor.decryptSecureCell(body, keyID, []byte(request.URL.Query().Get("context")), rowContext)
+	}
+	if err != nil {
+		auditStatus = common.AuditStatusDecryptionError
+		msg := "Can't process Themis payload"
+		requestLogger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantUnwrapThemisPayload).Warningln(msg)
+		return responseWithMessage(request, http.StatusUnprocessableEntity, msg)
+	}
+
+	auditStatus = common.AuditStatusOK
+	requestLogger.Infof("Processed Themis payload by %s", endpoint)
+	response = emptyResponseWithStatus(request, http.StatusOK)
+	response.Header.Set("Content-Type", "application/octet-stream")
+	response.Body = ioutil.NopCloser(bytes.NewReader(payload))
+	response.ContentLength = int64(len(payload))
+	return response
+}
+
+// decryptSecureMessage decrypts Secure Message sent by peer with client's storage private key
+func (decryptor *HTTPConnectionsDecryptor) decryptSecureMessage(data, clientID, peerID []byte) ([]byte, error) {
+	peerPublicKey, err := decryptor.TranslatorData.Keystorage.GetPeerPublicKey(peerID)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := decryptor.TranslatorData.Keystorage.GetServerDecryptionPrivateKey(clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.FillSlice(byte(0), privateKey.Value)
+	return message.New(privateKey, peerPublicKey).Unwrap(data)
+}
+
+// verifySecureMessage verifies signature of Secure Message with peer's public key and returns signed payload
+func (decryptor *HTTPConnectionsDecryptor) verifySecureMessage(data, peerID []byte) ([]byte, error) {
+	peerPublicKey, err := decryptor.TranslatorData.Keystorage.GetPeerPublicKey(peerID)
+	if err != nil {
+		return nil, err
+	}
+	return message.New(nil, peerPublicKey).Verify(data)
+}
+
+// decryptSecureCell decrypts Secure Cell in seal mode with symmetric key of client or zone id or with key of row
+// derived from it if rowContext isn't empty
+func (decryptor *HTTPConnectionsDecryptor) decryptSecureCell(data, id, context, rowContext []byte) ([]byte, error) {
+	symmetricKeyStore, ok := decryptor.TranslatorData.Keystorage.(keystore.SymmetricKeyStore)
+	if !ok {
+		return nil, ErrSymmetricKeysNotSupported
+	}
+	var key []byte
+	var err error
+	if len(rowContext) != 0 {
+		key, err = keystore.GetRowKey(symmetricKeyStore, id, rowContext)
+	} else {
+		key, err = symmetricKeyStore.GetSymmetricKey(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer utils.FillSlice(byte(0), key)
+	return cell.New(key, cell.CELL_MODE_SEAL).Unprotect(data, nil, context)
+}
@@ -204,6 +204,17 @@ func (server *ReaderServer) Start(parentContext context.Context) {
 		}
 	}
 	decryptorData := &common.TranslatorData{Keystorage: server.keystorage, PoisonRecordCallbacks: poisonCallbacks, CheckPoisonRecords: server.config.detectPoisonRecords}
+	if server.config.DecryptionCacheTTL() > 0 && server.config.DecryptionCacheMaxSize() > 0 {
+		decryptorData.DecryptionCache = common.NewDecryptionCache(server.config.DecryptionCacheTTL(), server.config.DecryptionCacheMaxSize())
+	}
+	decryptorData.AuditLog = server.config.AuditLog()
+	decryptorData.HMACAuthenticator = server.config.HMACAuthenticator()
+	decryptorData.HMACRequired = server.config.HMACRequired()
+	decryptorData.AuthProvider = server.config.HTTPAuthProvider()
+	decryptorData.ObjectStorage = server.config.ObjectStorage()
+	decryptorData.ObjectMaxSize = server.config.ObjectMaxSize()
+	decryptorData.ContentEncodings = server.config.ContentEncodings()
+	decryptorData.MaxBodySize = server.config.MaxBodySize()
 	if server.config.incomingConnectionHTTPString != "" {
 		go func() {
 			httpContext := logging.SetLoggerToContext(parentContext, logger.WithField(CONNECTION_TYPE_KEY, HTTP_CONNECTION_TYPE))
@@ -231,7 +242,12 @@ func (server *ReaderServer) Start(parentContext context.Context) {
 					Errorln("Can't create secure session listener")
 				return
 			}
-			grpcServer := grpc.NewServer()
+			grpc_api.RegisterCompressors(decryptorData.ContentEncodings)
+			var options []grpc.ServerOption
+			if decryptorData.MaxBodySize > 0 {
+				options = append(options, grpc.MaxRecvMsgSize(int(decryptorData.MaxBodySize)))
+			}
+			grpcServer := grpc.NewServer(options...)
 			service, err := grpc_api.NewDecryptGRPCService(decryptorData)
 			if err != nil {
 				grpcLogger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTranslatorCantHandleGRPCConnection).
@@ -24,13 +24,14 @@ package main
 
 import (
 	"bytes"
-	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"github.com/cossacklabs/acra/api"
 	"github.com/cossacklabs/acra/cmd"
+	"github.com/cossacklabs/acra/httpauth"
 	"github.com/cossacklabs/acra/logging"
 	"github.com/cossacklabs/acra/utils"
 	log "github.com/sirupsen/logrus"
@@ -97,6 +98,9 @@ const (
 
 var authUsers = make(map[string]cmd.UserAuth)
 
+// authProvider authenticates requests, uses authUsers loaded from AcraServer if providers aren't configured
+var authProvider httpauth.Provider
+
 func check(e error) {
 	if e != nil {
 		log.Error(e)
@@ -170,7 +174,7 @@ func SubmitSettings(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	req, err := http.NewRequest("POST", fmt.Sprintf("http://%v:%v/setConfig", *destinationHost, *destinationPort), bytes.NewBuffer(jsonToServer))
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://%v:%v%s", *destinationHost, *destinationPort, api.PathConfig), bytes.NewBuffer(jsonToServer))
 	if err != nil {
 		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantSetNewConfig).
 			Errorln("/setConfig http.NewRequest failed")
@@ -220,7 +224,7 @@ func index(w http.ResponseWriter, r *http.Request) {
 	var netClient = &http.Client{
 		Timeout: time.Second * HTTP_TIMEOUT,
 	}
-	serverResponse, err := netClient.Get(fmt.Sprintf("http://%v:%v/getConfig", *destinationHost, *destinationPort))
+	serverResponse, err := netClient.Get(fmt.Sprintf("http://%v:%v%s", *destinationHost, *destinationPort, api.PathConfig))
 	if err != nil {
 		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantGetCurrentConfig).
 			Errorln("AcraServer API error")
@@ -271,37 +275,11 @@ func index(w http.ResponseWriter, r *http.Request) {
 
 // basicAuthHandler check if user is authenticated to access AcraWebconfig page
 func basicAuthHandler(handler http.HandlerFunc) http.HandlerFunc {
-	var realm = "AcraWebConfig"
-
 	return func(w http.ResponseWriter, r *http.Request) {
 		if *authMode == "auth_on" ||
 			(*authMode == "auth_off_local" && *host != "127.0.0.1" && *host != "localhost") {
-
-			user, pass, basicOk := r.BasicAuth()
-
-			if _, ok := authUsers[user]; !ok {
-				log.Warningf("BasicAuth: unknown user '%v'", user)
-				basicOk = false
-			}
-
-			var newHash []byte
-			var authUserData cmd.UserAuth
-			var err error
-			if basicOk {
-				authUserData = authUsers[user]
-				newHash, err = cmd.HashArgon2(pass, authUserData.Salt, authUserData.Argon2Params)
-				if err != nil {
-					log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantHashPassword).
-						Error("Error while hashing user password")
-					basicOk = false
-				}
-			}
-			if !basicOk || subtle.ConstantTimeCompare(newHash, authUserData.Hash) != 1 {
-				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%v"`, realm))
-				w.WriteHeader(http.StatusUnauthorized)
-				w.Write([]byte(http.StatusText(http.StatusUnauthorized)))
-				return
-			}
+			httpauth.Handler(authProvider, handler)(w, r)
+			return
 		}
 		handler(w, r)
 	}
@@ -362,7 +340,7 @@ func loadAuthData() (err error) {
 	var netClient = &http.Client{
 		Timeout: time.Second * HTTP_TIMEOUT,
 	}
-	serverResponse, err := netClient.Get(fmt.Sprintf("http://%v:%v/loadAuthData", *destinationHost, *destinationPort))
+	serverResponse, err := netClient.Get(fmt.Sprintf("http://%v:%v%s", *destinationHost, *destinationPort, api.PathAuthData))
 	if err != nil {
 		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantGetAuthData).
 			Error("Error while getting auth data from AcraServer")
@@ -387,7 +365,7 @@ func loadAuthData() (err error) {
 func main() {
 	host = flag.String("incoming_connection_host", cmd.DEFAULT_ACRAWEBCONFIG_HOST, "Host for AcraWebconfig HTTP endpoint")
 	port = flag.Int("incoming_connection_port", cmd.DEFAULT_ACRAWEBCONFIG_PORT, "Port for AcraWebconfig HTTP endpoint")
-	loggingFormat := flag.String("logging_format", "plaintext", "Logging format: plaintext, json or CEF")
+	loggingFormat := flag.String("logging_format", "plaintext", "Logging format: plaintext, json, CEF or GELF")
 	logging.CustomizeLogging(*loggingFormat, SERVICE_NAME)
 	log.Infof("Starting service %v", SERVICE_NAME)
 	destinationHost = flag.String("destination_host", "localhost", "Host for AcraServer HTTP endpoint or AcraConnector")
@@ -395,6 +373,7 @@ func main() {
 	staticPath = flag.String("static_path", cmd.DEFAULT_ACRAWEBCONFIG_STATIC, "Path to static content")
 	debug = flag.Bool("d", false, "Turn on debug logging")
 	authMode = flag.String("http_auth_mode", cmd.DEFAULT_ACRAWEBCONFIG_AUTH_MODE, "Mode for basic auth. Possible values: auth_on|auth_off_local|auth_off")
+	authProvidersConfigFile := flag.String("http_auth_providers_config_file", "", "Path to YAML file with authentication providers (static, ldap, oidc, mtls) used instead of users from AcraServer")
 	err := cmd.Parse(DEFAULT_CONFIG_PATH, SERVICE_NAME)
 	if err != nil {
 		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantReadServiceConfig).
@@ -422,9 +401,25 @@ func main() {
 		log.Warningf("HTTP Basic Auth is turned off")
 	} else {
 		log.Infof("HTTP Basic Auth mode: %v", *authMode)
-		err = loadAuthData()
-		if err != nil {
-			os.Exit(1)
+		if *authProvidersConfigFile != "" {
+			providersConfig, err := ioutil.ReadFile(*authProvidersConfigFile)
+			if err != nil {
+				log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+					Errorln("Can't read authentication providers configuration")
+				os.Exit(1)
+			}
+			authProvider, err = httpauth.LoadProviders(providersConfig)
+			if err != nil {
+				log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+					Errorln("Can't load authentication providers")
+				os.Exit(1)
+			}
+		} else {
+			err = loadAuthData()
+			if err != nil {
+				os.Exit(1)
+			}
+			authProvider = httpauth.NewStaticProvider(authUsers, "AcraWebConfig")
 		}
 	}
 
@@ -433,6 +428,10 @@ func main() {
 	http.HandleFunc("/", basicAuthHandler(index))
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(*staticPath))))
 	http.HandleFunc("/acra-server/submit_setting", basicAuthHandler(SubmitSettings))
+	http.HandleFunc("/acra-server/zones", basicAuthHandler(ListZones))
+	http.HandleFunc("/acra-server/zones/rotate", basicAuthHandler(RotateZoneKey))
+	http.HandleFunc("/acra-server/keys", basicAuthHandler(ListKeys))
+	http.HandleFunc("/acra-server/keys/public", basicAuthHandler(DownloadPublicKey))
 	log.Infof("AcraWebconfig is listening @ %s:%d with PID %d", *host, *port, os.Getpid())
 	err = http.ListenAndServe(fmt.Sprintf("%s:%d", *host, *port), nil)
 	check(err)
@@ -0,0 +1,118 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/cossacklabs/acra/api/client"
+	"github.com/cossacklabs/acra/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// newAPIClient returns client of AcraServer HTTP API
+func newAPIClient() *client.Client {
+	return client.NewClient(fmt.Sprintf("http://%v:%v", *destinationHost, *destinationPort), &http.Client{Timeout: time.Second * HTTP_TIMEOUT})
+}
+
+// writeAPIError writes error of request to AcraServer API with same status or 502 if AcraServer isn't available
+func writeAPIError(w http.ResponseWriter, err error, message string) {
+	log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantManageKeys).Errorln(message)
+	if statusError, ok := err.(*client.StatusError); ok {
+		http.Error(w, statusError.Message, statusError.StatusCode)
+		return
+	}
+	http.Error(w, message, http.StatusBadGateway)
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantManageKeys).Errorln("Can't write response")
+	}
+}
+
+// ListZones returns zones of AcraServer with fingerprints and ages of their keys
+func ListZones(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	zones, err := newAPIClient().ListZones()
+	if err != nil {
+		writeAPIError(w, err, "Can't list zones")
+		return
+	}
+	writeJSON(w, zones)
+}
+
+// ListKeys returns keys of AcraServer keystore with fingerprints and ages
+func ListKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	keys, err := newAPIClient().ListKeys()
+	if err != nil {
+		writeAPIError(w, err, "Can't list keys")
+		return
+	}
+	writeJSON(w, keys)
+}
+
+// DownloadPublicKey returns public key by name as attachment
+func DownloadPublicKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	publicKey, err := newAPIClient().GetPublicKey(name)
+	if err != nil {
+		writeAPIError(w, err, "Can't get public key")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(name)))
+	w.Write(publicKey)
+}
+
+// RotateZoneKey generates new key pair for zone from form field zone_id
+func RotateZoneKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorRequestMethodNotAllowed).
+			Errorln("Invalid request method")
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	zoneID := r.Form.Get("zone_id")
+	zone, err := newAPIClient().RotateZoneKey(zoneID)
+	if err != nil {
+		writeAPIError(w, err, "Can't rotate zone key")
+		return
+	}
+	log.WithField("zone_id", zoneID).Infoln("Zone key rotated")
+	writeJSON(w, zone)
+}
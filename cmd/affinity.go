@@ -0,0 +1,192 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Errors returned on CPU affinity setup
+var (
+	ErrInvalidCPUList       = errors.New("invalid CPU list, expected list like 0-3,8,10-11")
+	ErrAffinityNotSupported = errors.New("CPU affinity isn't supported on this platform")
+)
+
+// numaNodesPattern matches sysfs directories of NUMA nodes
+const numaNodesPattern = "/sys/devices/system/node/node[0-9]*"
+
+// ParseCPUList parses list of CPUs in format used by taskset and sysfs: "0-3,8,10-11". Empty list returns nil
+func ParseCPUList(list string) ([]int, error) {
+	list = strings.TrimSpace(list)
+	if list == "" {
+		return nil, nil
+	}
+	uniqueCPUs := make(map[int]bool)
+	for _, part := range strings.Split(list, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil || first < 0 {
+			return nil, ErrInvalidCPUList
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil || last < first {
+				return nil, ErrInvalidCPUList
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			uniqueCPUs[cpu] = true
+		}
+	}
+	cpus := make([]int, 0, len(uniqueCPUs))
+	for cpu := range uniqueCPUs {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// SetProcessAffinity restricts all threads of process to run only on cpus
+func SetProcessAffinity(cpus []int) error {
+	return setProcessAffinity(cpus)
+}
+
+// ErrWorkerPoolTooSmall returned if functions which should run together need more workers than pool has
+var ErrWorkerPoolTooSmall = errors.New("count of functions exceeds count of workers in pool")
+
+// WorkerPool runs functions on fixed count of worker goroutines locked to own OS threads which may run only on CPUs
+// of pool. Each listener gets own pool, so connections of listener are served by fixed set of threads pinned to CPUs
+// (e.g. of one NUMA node) instead of OS thread per connection
+type WorkerPool struct {
+	tasks chan func()
+	// slots limits count of running functions by count of workers
+	slots chan struct{}
+	// acquireLock serializes taking of slots by groups of functions, so groups don't hold parts of pool waiting for
+	// each other
+	acquireLock chan struct{}
+}
+
+// NewWorkerPool starts count workers which may run only on cpus
+func NewWorkerPool(cpus []int, count int) *WorkerPool {
+	pool := &WorkerPool{tasks: make(chan func(), count), slots: make(chan struct{}, count), acquireLock: make(chan struct{}, 1)}
+	for i := 0; i < count; i++ {
+		go pool.work(cpus)
+	}
+	return pool
+}
+
+// work locks worker to own OS thread with affinity of cpus and runs functions until pool is closed. Thread stays locked
+// when worker exits, so runtime terminates it instead of reusing it with restricted affinity
+func (pool *WorkerPool) work(cpus []int) {
+	runtime.LockOSThread()
+	if len(cpus) != 0 {
+		if err := setThreadAffinity(cpus); err != nil {
+			log.WithError(err).Warningln("Can't set CPU affinity of worker")
+		}
+	}
+	for task := range pool.tasks {
+		task()
+		<-pool.slots
+	}
+}
+
+// Go runs functions at the same time on free workers of pool, it waits until enough workers are free or ctx is done.
+// Nil pool runs functions in new goroutines
+func (pool *WorkerPool) Go(ctx context.Context, functions ...func()) error {
+	if pool == nil {
+		for _, function := range functions {
+			go function()
+		}
+		return nil
+	}
+	if len(functions) > cap(pool.slots) {
+		return ErrWorkerPoolTooSmall
+	}
+	select {
+	case pool.acquireLock <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-pool.acquireLock }()
+	for acquired := 0; acquired < len(functions); acquired++ {
+		select {
+		case pool.slots <- struct{}{}:
+		case <-ctx.Done():
+			for ; acquired > 0; acquired-- {
+				<-pool.slots
+			}
+			return ctx.Err()
+		}
+	}
+	for _, function := range functions {
+		pool.tasks <- function
+	}
+	return nil
+}
+
+// Close stops workers after they finish running functions
+func (pool *WorkerPool) Close() {
+	close(pool.tasks)
+}
+
+// LogTopologyReport logs CPUs available for process, GOMAXPROCS and CPUs of NUMA nodes to help tune affinity
+func LogTopologyReport() {
+	fields := log.Fields{"num_cpu": runtime.NumCPU(), "gomaxprocs": runtime.GOMAXPROCS(0)}
+	if cpus, err := getProcessAffinity(); err == nil {
+		fields["process_cpus"] = formatCPUList(cpus)
+	}
+	log.WithFields(fields).Infoln("CPU topology")
+	nodes, _ := filepath.Glob(numaNodesPattern)
+	for _, node := range nodes {
+		cpuList, err := ioutil.ReadFile(filepath.Join(node, "cpulist"))
+		if err != nil {
+			continue
+		}
+		log.WithFields(log.Fields{"numa_node": filepath.Base(node), "cpus": strings.TrimSpace(string(cpuList))}).Infoln("NUMA node")
+	}
+	if len(nodes) > 1 {
+		log.Infoln("Host has several NUMA nodes, pin listeners to CPUs of one node to avoid cross-node memory traffic")
+	}
+}
+
+// formatCPUList formats sorted cpus as list like "0-3,8"
+func formatCPUList(cpus []int) string {
+	var parts []string
+	for i := 0; i < len(cpus); {
+		j := i
+		for j+1 < len(cpus) && cpus[j+1] == cpus[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(cpus[i]))
+		} else {
+			parts = append(parts, strconv.Itoa(cpus[i])+"-"+strconv.Itoa(cpus[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
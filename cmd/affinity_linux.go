@@ -0,0 +1,91 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"io/ioutil"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// cpuMask is bitmask of CPUs passed to sched_setaffinity and sched_getaffinity, fits up to 1024 CPUs like cpu_set_t
+// of glibc
+type cpuMask [16]uint64
+
+func newCPUMask(cpus []int) *cpuMask {
+	mask := &cpuMask{}
+	for _, cpu := range cpus {
+		if cpu/64 < len(mask) {
+			mask[cpu/64] |= 1 << uint(cpu%64)
+		}
+	}
+	return mask
+}
+
+// schedSetaffinity sets affinity of thread with tid, 0 means current thread
+func schedSetaffinity(tid int, mask *cpuMask) error {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, uintptr(tid), unsafe.Sizeof(*mask), uintptr(unsafe.Pointer(mask)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// setThreadAffinity sets affinity of current OS thread
+func setThreadAffinity(cpus []int) error {
+	return schedSetaffinity(0, newCPUMask(cpus))
+}
+
+// setProcessAffinity sets affinity of all existing threads of process. New threads inherit affinity of threads
+// which create them
+func setProcessAffinity(cpus []int) error {
+	tasks, err := ioutil.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	mask := newCPUMask(cpus)
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if err := schedSetaffinity(tid, mask); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getProcessAffinity returns CPUs on which current thread may run
+func getProcessAffinity() ([]int, error) {
+	mask := &cpuMask{}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_GETAFFINITY, 0, unsafe.Sizeof(*mask), uintptr(unsafe.Pointer(mask)))
+	if errno != 0 {
+		return nil, errno
+	}
+	var cpus []int
+	for cpu := 0; cpu < len(mask)*64; cpu++ {
+		if mask[cpu/64]&(1<<uint(cpu%64)) != 0 {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
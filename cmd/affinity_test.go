@@ -0,0 +1,164 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCPUList(t *testing.T) {
+	testcases := []struct {
+		List string
+		CPUs []int
+		Err  error
+	}{
+		{"", nil, nil},
+		{"  ", nil, nil},
+		{"0", []int{0}, nil},
+		{"0-3", []int{0, 1, 2, 3}, nil},
+		{"0-3,8,10-11", []int{0, 1, 2, 3, 8, 10, 11}, nil},
+		{" 2 , 0 ", []int{0, 2}, nil},
+		{"3-3", []int{3}, nil},
+		// duplicates and overlapping ranges are merged and sorted
+		{"1,1,0", []int{0, 1}, nil},
+		{"0-2,1-3", []int{0, 1, 2, 3}, nil},
+		{"a", nil, ErrInvalidCPUList},
+		{"-1", nil, ErrInvalidCPUList},
+		{"3-1", nil, ErrInvalidCPUList},
+		{"0-", nil, ErrInvalidCPUList},
+		{"0-a", nil, ErrInvalidCPUList},
+		{"0,,1", nil, ErrInvalidCPUList},
+		{"0-1-2", nil, ErrInvalidCPUList},
+	}
+	for i, tcase := range testcases {
+		cpus, err := ParseCPUList(tcase.List)
+		if err != tcase.Err {
+			t.Errorf("[%d] Expected error %v, took %v", i, tcase.Err, err)
+			continue
+		}
+		if !reflect.DeepEqual(cpus, tcase.CPUs) {
+			t.Errorf("[%d] Expected %v, took %v", i, tcase.CPUs, cpus)
+		}
+	}
+}
+
+func TestFormatCPUList(t *testing.T) {
+	testcases := []struct {
+		CPUs []int
+		List string
+	}{
+		{nil, ""},
+		{[]int{0}, "0"},
+		{[]int{0, 1, 2, 3, 8, 10, 11}, "0-3,8,10-11"},
+	}
+	for i, tcase := range testcases {
+		if list := formatCPUList(tcase.CPUs); list != tcase.List {
+			t.Errorf("[%d] Expected %v, took %v", i, tcase.List, list)
+		}
+		cpus, err := ParseCPUList(tcase.List)
+		if err != nil {
+			t.Fatalf("[%d] %v", i, err)
+		}
+		if len(tcase.CPUs) != 0 && !reflect.DeepEqual(cpus, tcase.CPUs) {
+			t.Errorf("[%d] Expected %v after parsing, took %v", i, tcase.CPUs, cpus)
+		}
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+	cpus, err := getProcessAffinity()
+	if err == nil {
+		cpus = cpus[:1]
+	} else {
+		// platforms without affinity run workers on any CPU
+		cpus = nil
+	}
+	pool := NewWorkerPool(cpus, 2)
+	defer pool.Close()
+	ctx := context.Background()
+	const timeout = time.Second
+
+	// functions of one group run at the same time, so each one may wait for another
+	first, second := make(chan struct{}), make(chan struct{})
+	affinities := make(chan []int, 2)
+	reportAffinity := func() {
+		affinity, _ := getProcessAffinity()
+		affinities <- affinity
+	}
+	err = pool.Go(ctx, func() {
+		close(first)
+		<-second
+		reportAffinity()
+	}, func() {
+		close(second)
+		<-first
+		reportAffinity()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case affinity := <-affinities:
+			if cpus != nil && !reflect.DeepEqual(affinity, cpus) {
+				t.Fatalf("Expected affinity %v of worker, took %v", cpus, affinity)
+			}
+		case <-time.After(timeout):
+			t.Fatal("Functions of group weren't run together")
+		}
+	}
+
+	if err := pool.Go(ctx, func() {}, func() {}, func() {}); err != ErrWorkerPoolTooSmall {
+		t.Fatalf("Expected %v, took %v", ErrWorkerPoolTooSmall, err)
+	}
+
+	// busy pool waits for free workers until context is done
+	release := make(chan struct{})
+	if err := pool.Go(ctx, func() { <-release }, func() { <-release }); err != nil {
+		t.Fatal(err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, time.Millisecond*50)
+	defer cancel()
+	if err := pool.Go(waitCtx, func() {}); err != context.DeadlineExceeded {
+		t.Fatalf("Expected %v, took %v", context.DeadlineExceeded, err)
+	}
+	close(release)
+	finished := make(chan struct{})
+	if err := pool.Go(ctx, func() { close(finished) }); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-finished:
+	case <-time.After(timeout):
+		t.Fatal("Function wasn't run after workers were freed")
+	}
+
+	// nil pool runs functions in new goroutines
+	var nilPool *WorkerPool
+	finished = make(chan struct{})
+	if err := nilPool.Go(ctx, func() { close(finished) }); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-finished:
+	case <-time.After(timeout):
+		t.Fatal("Function wasn't run by nil pool")
+	}
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	flag_ "flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
+
+	"github.com/cossacklabs/acra/utils"
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigCommandName is first argument which runs config command instead of service: "<service> config generate"
+// prints config with defaults and "<service> config diff <file>" compares file with defaults
+const ConfigCommandName = "config"
+
+// ErrUnknownConfigCommand returned if config command has unknown subcommand or wrong arguments
+var ErrUnknownConfigCommand = errors.New("usage: config generate | config diff <config file>")
+
+// GenerateConfig writes YAML config with all options registered in flagSet, their descriptions and default values
+func GenerateConfig(output io.Writer, flagSet *flag_.FlagSet, serviceName string) {
+	fmt.Fprintf(output, "# Configuration of %s %s with default values\n", serviceName, utils.VERSION)
+	fmt.Fprintf(output, "# Generated with '%s %s generate'\n\n", serviceName, ConfigCommandName)
+	generateYaml(flagSet, output, true)
+}
+
+// normalize returns value as flag prints it, so values written differently in YAML (10 and "10", 1m and 60s) are equal
+func (option OptionSchema) normalize(value interface{}) string {
+	text := fmt.Sprintf("%v", value)
+	if option.value == nil {
+		return text
+	}
+	valueType := reflect.TypeOf(option.value)
+	if valueType.Kind() != reflect.Ptr {
+		return text
+	}
+	newValue, ok := reflect.New(valueType.Elem()).Interface().(flag_.Value)
+	if !ok || newValue.Set(text) != nil {
+		return text
+	}
+	return newValue.String()
+}
+
+// DiffConfig writes options of YAML config which differ from defaults: "~" for changed values, "+" for options not
+// known by service and "-" for options missing in config, which will use defaults
+func DiffConfig(output io.Writer, schema *ConfigSchema, data []byte) error {
+	yamlConfig := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &yamlConfig); err != nil {
+		return err
+	}
+	lines := topLevelKeyLines(data)
+	names := make([]string, 0, len(schema.Options)+len(yamlConfig))
+	for name := range schema.Options {
+		names = append(names, name)
+	}
+	for name := range yamlConfig {
+		if _, ok := schema.Options[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		option, known := schema.Options[name]
+		value, inConfig := yamlConfig[name]
+		switch {
+		case !known:
+			message := "unknown option"
+			if deprecated, ok := schema.Deprecated[name]; ok {
+				message = "deprecated: " + deprecated
+			} else if newName, ok := schema.Renamed[name]; ok {
+				message = fmt.Sprintf("deprecated: renamed to '%s'", newName)
+			}
+			fmt.Fprintf(output, "+ %s: %v (line %d, %s)\n", name, value, lines[name], message)
+		case !inConfig:
+			fmt.Fprintf(output, "- %s (default: %s)\n", name, option.Default)
+		case value != nil && option.normalize(value) != option.normalize(option.Default):
+			fmt.Fprintf(output, "~ %s: %v (line %d, default: %s)\n", name, value, lines[name], option.Default)
+		}
+	}
+	return nil
+}
+
+// RunConfigCommand runs config subcommand with args for service with options registered in flagSet
+func RunConfigCommand(output io.Writer, flagSet *flag_.FlagSet, serviceName string, args []string) error {
+	if len(args) == 0 {
+		return ErrUnknownConfigCommand
+	}
+	switch {
+	case args[0] == "generate" && len(args) == 1:
+		GenerateConfig(output, flagSet, serviceName)
+		return nil
+	case args[0] == "diff" && len(args) == 2:
+		data, err := ioutil.ReadFile(args[1])
+		if err != nil {
+			return err
+		}
+		return DiffConfig(output, GenerateConfigSchema(flagSet), data)
+	}
+	return ErrUnknownConfigCommand
+}
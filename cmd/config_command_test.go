@@ -0,0 +1,99 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	flag_ "flag"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/utils"
+)
+
+func testConfigFlagSet() *flag_.FlagSet {
+	flagSet := flag_.NewFlagSet("test", flag_.ContinueOnError)
+	flagSet.String("db_host", "", "Host of database")
+	flagSet.Int("db_port", 5432, "Port of database")
+	flagSet.Bool("d", false, "Turn on debug logging")
+	flagSet.Duration("timeout", time.Minute, "Timeout")
+	return flagSet
+}
+
+func TestGenerateConfig(t *testing.T) {
+	output := &bytes.Buffer{}
+	if err := RunConfigCommand(output, testConfigFlagSet(), "acra-test", []string{"generate"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "# Configuration of acra-test " + utils.VERSION + " with default values\n" +
+		"# Generated with 'acra-test config generate'\n\n" +
+		"# Turn on debug logging\nd: false\n\n" +
+		"# Host of database\ndb_host: \n\n" +
+		"# Port of database\ndb_port: 5432\n\n" +
+		"# Timeout\ntimeout: 1m0s\n\n"
+	if output.String() != expected {
+		t.Fatalf("incorrect config:\n%s\nexpected:\n%s", output.String(), expected)
+	}
+	// generated config is valid and equal to defaults
+	if err := GenerateConfigSchema(testConfigFlagSet()).Validate(output.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	diff := &bytes.Buffer{}
+	if err := DiffConfig(diff, GenerateConfigSchema(testConfigFlagSet()), output.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if diff.Len() != 0 {
+		t.Fatalf("expected empty diff, took %s", diff.String())
+	}
+}
+
+func TestDiffConfig(t *testing.T) {
+	file, err := ioutil.TempFile("", "config_diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString("db_host: localhost\nd: false\ntimeout: 60s\ndb_prot: 3306\n"); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	output := &bytes.Buffer{}
+	if err := RunConfigCommand(output, testConfigFlagSet(), "acra-test", []string{"diff", file.Name()}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "~ db_host: localhost (line 1, default: )\n" +
+		"- db_port (default: 5432)\n" +
+		"+ db_prot: 3306 (line 4, unknown option)\n"
+	if output.String() != expected {
+		t.Fatalf("incorrect diff:\n%s\nexpected:\n%s", output.String(), expected)
+	}
+}
+
+func TestRunConfigCommandInvalidArgs(t *testing.T) {
+	for _, args := range [][]string{nil, {"unknown"}, {"generate", "extra"}, {"diff"}} {
+		if err := RunConfigCommand(&bytes.Buffer{}, testConfigFlagSet(), "acra-test", args); err != ErrUnknownConfigCommand {
+			t.Errorf("expected ErrUnknownConfigCommand for %v, took %v", args, err)
+		}
+	}
+	if err := RunConfigCommand(&bytes.Buffer{}, testConfigFlagSet(), "acra-test", []string{"diff", "/nonexistent/config.yaml"}); !strings.Contains(err.Error(), "no such file") {
+		t.Errorf("expected error for missing file, took %v", err)
+	}
+}
@@ -0,0 +1,305 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	flag_ "flag"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Types of options in config schema
+const (
+	OptionTypeBool     = "bool"
+	OptionTypeInt      = "int"
+	OptionTypeUint     = "uint"
+	OptionTypeFloat    = "float"
+	OptionTypeDuration = "duration"
+	OptionTypeString   = "string"
+)
+
+// OptionSchema describes one option of service configuration
+type OptionSchema struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Default string `json:"default"`
+	Usage   string `json:"usage"`
+	value   flag_.Value
+}
+
+// ConfigSchema describes all options which service accepts in YAML config. Generated from registered CLI flags, so
+// config and CLI always accept same options
+type ConfigSchema struct {
+	Options    map[string]OptionSchema `json:"options"`
+	Deprecated map[string]string       `json:"deprecated"`
+	// Renamed maps old names of options to new ones
+	Renamed map[string]string `json:"renamed"`
+}
+
+var deprecatedOptions = make(map[string]string)
+
+// RegisterDeprecatedOption marks removed option with name. Configs which still use it are rejected with message
+// which explains what to use instead
+func RegisterDeprecatedOption(name, message string) {
+	deprecatedOptions[name] = message
+}
+
+var renamedOptions = make(map[string]string)
+
+// RegisterRenamedOption maps old name of option to its new name. Old name is still accepted in CLI arguments and
+// configs with deprecation warning, or rejected if strict_flags is set
+func RegisterRenamedOption(oldName, newName string) {
+	renamedOptions[oldName] = newName
+}
+
+func optionType(value flag_.Value) string {
+	getter, ok := value.(flag_.Getter)
+	if !ok {
+		return OptionTypeString
+	}
+	switch getter.Get().(type) {
+	case bool:
+		return OptionTypeBool
+	case int, int64:
+		return OptionTypeInt
+	case uint, uint64:
+		return OptionTypeUint
+	case float64:
+		return OptionTypeFloat
+	case time.Duration:
+		return OptionTypeDuration
+	}
+	return OptionTypeString
+}
+
+// GenerateConfigSchema returns schema of options registered in flagSet
+func GenerateConfigSchema(flagSet *flag_.FlagSet) *ConfigSchema {
+	schema := &ConfigSchema{Options: make(map[string]OptionSchema), Deprecated: make(map[string]string), Renamed: make(map[string]string)}
+	flagSet.VisitAll(func(flag *flag_.Flag) {
+		schema.Options[flag.Name] = OptionSchema{Name: flag.Name, Type: optionType(flag.Value), Default: flag.DefValue, Usage: flag.Usage, value: flag.Value}
+	})
+	for name, message := range deprecatedOptions {
+		if _, ok := schema.Options[name]; !ok {
+			schema.Deprecated[name] = message
+		}
+	}
+	for oldName, newName := range renamedOptions {
+		_, oldRegistered := schema.Options[oldName]
+		if _, ok := schema.Options[newName]; ok && !oldRegistered {
+			schema.Renamed[oldName] = newName
+		}
+	}
+	return schema
+}
+
+// ConfigError describes problem with one option of config
+type ConfigError struct {
+	Line    int
+	Option  string
+	Message string
+}
+
+func (err ConfigError) Error() string {
+	if err.Line > 0 {
+		return fmt.Sprintf("line %d: option '%s': %s", err.Line, err.Option, err.Message)
+	}
+	return fmt.Sprintf("option '%s': %s", err.Option, err.Message)
+}
+
+// ConfigErrors is list of all problems found in config
+type ConfigErrors []ConfigError
+
+func (errs ConfigErrors) Error() string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return "invalid config: " + strings.Join(messages, "; ")
+}
+
+// topLevelKeyLines returns line numbers of top level keys of YAML document. yaml.v2 doesn't expose positions of
+// parsed values, configs are flat, so keys are found by lines without indentation
+func topLevelKeyLines(data []byte) map[string]int {
+	lines := make(map[string]int)
+	for i, line := range strings.Split(string(data), "\n") {
+		if line == "" || line[0] == ' ' || line[0] == '\t' || line[0] == '#' || line[0] == '-' {
+			continue
+		}
+		separator := strings.Index(line, ":")
+		if separator <= 0 {
+			continue
+		}
+		key := strings.Trim(strings.TrimSpace(line[:separator]), `"'`)
+		if _, ok := lines[key]; !ok {
+			lines[key] = i + 1
+		}
+	}
+	return lines
+}
+
+// editDistance returns Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// suggest returns known option closest to misspelled name or empty string if no option is close enough
+func (schema *ConfigSchema) suggest(name string) string {
+	best, bestDistance := "", len(name)/3+1
+	for option := range schema.Options {
+		if distance := editDistance(name, option); distance < bestDistance || (distance == bestDistance && option < best) {
+			best, bestDistance = option, distance
+		}
+	}
+	return best
+}
+
+// validateValue checks that value can be set to option the same way as CLI does
+func (option OptionSchema) validateValue(value interface{}) error {
+	switch value.(type) {
+	case map[interface{}]interface{}, []interface{}:
+		return fmt.Errorf("expected %s, took nested value", option.Type)
+	}
+	if option.Type == OptionTypeBool {
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("expected bool, took '%v'", value)
+		}
+		return nil
+	}
+	if option.value == nil {
+		return nil
+	}
+	// set value to new instance of same type to not change current value of flag
+	valueType := reflect.TypeOf(option.value)
+	if valueType.Kind() != reflect.Ptr {
+		return nil
+	}
+	newValue, ok := reflect.New(valueType.Elem()).Interface().(flag_.Value)
+	if !ok {
+		return nil
+	}
+	if err := newValue.Set(fmt.Sprintf("%v", value)); err != nil {
+		return fmt.Errorf("expected %s, took '%v'", option.Type, value)
+	}
+	return nil
+}
+
+// Validate checks that YAML config uses only known options with values of expected types. Returns ConfigErrors
+// with all found problems
+func (schema *ConfigSchema) Validate(data []byte) error {
+	yamlConfig := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &yamlConfig); err != nil {
+		return err
+	}
+	lines := topLevelKeyLines(data)
+	names := make([]string, 0, len(yamlConfig))
+	for name := range yamlConfig {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if lines[names[i]] != lines[names[j]] {
+			return lines[names[i]] < lines[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	var errs ConfigErrors
+	for _, name := range names {
+		value := yamlConfig[name]
+		option, known := schema.Options[name]
+		switch {
+		case !known:
+			if newName, renamed := schema.Renamed[name]; renamed {
+				if _, ok := yamlConfig[newName]; ok {
+					errs = append(errs, ConfigError{Line: lines[name], Option: name, Message: fmt.Sprintf("renamed to '%s' which is also set", newName)})
+				} else if value != nil {
+					if err := schema.Options[newName].validateValue(value); err != nil {
+						errs = append(errs, ConfigError{Line: lines[name], Option: name, Message: err.Error()})
+					}
+				}
+				continue
+			}
+			if message, deprecated := schema.Deprecated[name]; deprecated {
+				errs = append(errs, ConfigError{Line: lines[name], Option: name, Message: "deprecated: " + message})
+				continue
+			}
+			message := "unknown option"
+			if suggestion := schema.suggest(name); suggestion != "" {
+				message = fmt.Sprintf("unknown option, did you mean '%s'?", suggestion)
+			}
+			errs = append(errs, ConfigError{Line: lines[name], Option: name, Message: message})
+		case value != nil:
+			if err := option.validateValue(value); err != nil {
+				errs = append(errs, ConfigError{Line: lines[name], Option: name, Message: err.Error()})
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ValidateArgs rejects deprecated options passed as CLI arguments with message what to use instead
+func (schema *ConfigSchema) ValidateArgs(args []string) error {
+	var errs ConfigErrors
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		if message, deprecated := schema.Deprecated[name]; deprecated {
+			errs = append(errs, ConfigError{Option: name, Message: "deprecated: " + message})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
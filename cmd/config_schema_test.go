@@ -0,0 +1,112 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	flag_ "flag"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSchema() *ConfigSchema {
+	flagSet := flag_.NewFlagSet("test", flag_.ContinueOnError)
+	flagSet.String("db_host", "", "host")
+	flagSet.Int("db_port", 5432, "port")
+	flagSet.Uint("cache_size", 0, "size")
+	flagSet.Bool("d", false, "debug")
+	flagSet.Duration("timeout", time.Second, "timeout")
+	flagSet.Float64("ratio", 0.5, "ratio")
+	RegisterDeprecatedOption("old_db_host", "use db_host")
+	RegisterDeprecatedOption("db_port", "still registered, so not deprecated")
+	defer func() {
+		delete(deprecatedOptions, "old_db_host")
+		delete(deprecatedOptions, "db_port")
+	}()
+	return GenerateConfigSchema(flagSet)
+}
+
+func TestGenerateConfigSchema(t *testing.T) {
+	schema := testSchema()
+	expected := map[string]string{"db_host": OptionTypeString, "db_port": OptionTypeInt, "cache_size": OptionTypeUint,
+		"d": OptionTypeBool, "timeout": OptionTypeDuration, "ratio": OptionTypeFloat}
+	if len(schema.Options) != len(expected) {
+		t.Fatalf("expected %d options, took %d", len(expected), len(schema.Options))
+	}
+	for name, optionType := range expected {
+		if schema.Options[name].Type != optionType {
+			t.Errorf("option %s: expected type %s, took %s", name, optionType, schema.Options[name].Type)
+		}
+	}
+	if schema.Options["db_port"].Default != "5432" {
+		t.Errorf("incorrect default %s", schema.Options["db_port"].Default)
+	}
+	if len(schema.Deprecated) != 1 || schema.Deprecated["old_db_host"] != "use db_host" {
+		t.Errorf("incorrect deprecated options %v", schema.Deprecated)
+	}
+}
+
+func TestConfigSchemaValidate(t *testing.T) {
+	schema := testSchema()
+	valid := "# comment\ndb_host: localhost\ndb_port: 3306\ncache_size:\nd: true\ntimeout: 1m\nratio: 1\n"
+	if err := schema.Validate([]byte(valid)); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := "db_host: localhost\ndb_prot: 3306\n\nold_db_host: localhost\nd: 1\ndb_port: port\ntimeout: 10\ncache_size: -1\nratio:\n  - 1\nunrelated_option: 1\n"
+	err := schema.Validate([]byte(invalid))
+	errs, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatalf("expected ConfigErrors, took %v", err)
+	}
+	expected := []ConfigError{
+		{Line: 2, Option: "db_prot", Message: "unknown option, did you mean 'db_port'?"},
+		{Line: 4, Option: "old_db_host", Message: "deprecated: use db_host"},
+		{Line: 5, Option: "d", Message: "expected bool, took '1'"},
+		{Line: 6, Option: "db_port", Message: "expected int, took 'port'"},
+		{Line: 7, Option: "timeout", Message: "expected duration, took '10'"},
+		{Line: 8, Option: "cache_size", Message: "expected uint, took '-1'"},
+		{Line: 9, Option: "ratio", Message: "expected float, took nested value"},
+		{Line: 11, Option: "unrelated_option", Message: "unknown option"},
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, took %v", len(expected), errs)
+	}
+	for i := range expected {
+		if errs[i] != expected[i] {
+			t.Errorf("expected error %v, took %v", expected[i], errs[i])
+		}
+	}
+	if !strings.Contains(err.Error(), "line 2: option 'db_prot': unknown option, did you mean 'db_port'?") {
+		t.Errorf("incorrect message %s", err.Error())
+	}
+
+	if err := schema.Validate([]byte("db_host: [")); err == nil {
+		t.Fatal("expected error for invalid YAML")
+	}
+}
+
+func TestConfigSchemaValidateArgs(t *testing.T) {
+	schema := testSchema()
+	if err := schema.ValidateArgs([]string{"--db_host=localhost", "-d", "--", "--old_db_host"}); err != nil {
+		t.Fatal(err)
+	}
+	err := schema.ValidateArgs([]string{"--db_host", "localhost", "-old_db_host=localhost"})
+	if err == nil || err.Error() != "invalid config: option 'old_db_host': deprecated: use db_host" {
+		t.Fatalf("expected error for deprecated option, took %v", err)
+	}
+}
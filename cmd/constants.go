@@ -31,6 +31,7 @@ const (
 	DEFAULT_ACRAWEBCONFIG_PORT                = 8000
 	DEFAULT_ACRAWEBCONFIG_STATIC              = "cmd/acra-webconfig/static"
 	DEFAULT_ACRAWEBCONFIG_AUTH_MODE           = "auth_on"
+	DEFAULT_ACRAAUTHMANAGER_API_PORT          = 9797
 	ACRAWEBCONFIG_AUTH_ARGON2_LENGTH          = 32
 	ACRAWEBCONFIG_AUTH_ARGON2_MEMORY          = 8 * 1024
 	ACRAWEBCONFIG_AUTH_ARGON2_TIME            = 3
@@ -39,4 +40,11 @@ const (
 	DEFAULT_ACRATRANSLATOR_HTTP_PORT          = 9595
 	DEFAULT_ACRATRANSLATOR_GRPC_HOST          = "0.0.0.0"
 	DEFAULT_ACRATRANSLATOR_GRPC_PORT          = 9696
+	DEFAULT_DECRYPTION_QUEUE_SIZE             = 100
+	DEFAULT_DECRYPTION_QUEUE_TIMEOUT          = 1000
+	DEFAULT_DECRYPTION_LATENCY_COOLDOWN       = 5000
+	DEFAULT_IP_FILTER_RELOAD_INTERVAL         = 10
+	DEFAULT_REVOCATION_LIST_RELOAD_INTERVAL   = 10
+	DEFAULT_CONNECTION_WORKERS                = 256
+	DEFAULT_API_CONNECTION_WORKERS            = 16
 )
@@ -0,0 +1,339 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cossacklabs/acra/api"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultExpiryWarningDays are thresholds in days before expiration at which ExpiryMonitor warns
+var DefaultExpiryWarningDays = []int{30, 7, 1}
+
+// NewAPIKey returns description of key returned by HTTP API and printed by AcraKeys
+func NewAPIKey(key keystore.KeyInfo) api.Key {
+	result := api.Key{Name: key.Name, Purpose: key.Purpose, ID: key.ID, Public: key.Public, Fingerprint: key.Fingerprint,
+		ModifiedAt: key.ModifiedAt, AgeSeconds: int64(time.Since(key.ModifiedAt) / time.Second)}
+	if !key.CreatedAt.IsZero() {
+		createdAt := key.CreatedAt
+		result.CreatedAt = &createdAt
+	}
+	if !key.ExpiresAt.IsZero() {
+		expiresAt := key.ExpiresAt
+		result.ExpiresAt = &expiresAt
+	}
+	return result
+}
+
+// LoadCertificateChain reads PEM encoded certificates from file, leaf certificate goes first
+func LoadCertificateChain(path string) ([]*x509.Certificate, error) {
+	certPem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var chain []*x509.Certificate
+	for block, rest := pem.Decode(certPem); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return chain, nil
+}
+
+// chainExpiresAt returns time when the first certificate of chain expires
+func chainExpiresAt(chain []*x509.Certificate) time.Time {
+	expiresAt := chain[0].NotAfter
+	for _, cert := range chain[1:] {
+		if cert.NotAfter.Before(expiresAt) {
+			expiresAt = cert.NotAfter
+		}
+	}
+	return expiresAt
+}
+
+// daysBefore returns count of whole days left until expiresAt, negative if already expired
+func daysBefore(expiresAt, now time.Time) int {
+	return int(math.Floor(expiresAt.Sub(now).Hours() / 24))
+}
+
+// ParseExpiryWarningDays parses comma separated list of days like "30,7,1"
+func ParseExpiryWarningDays(value string) ([]int, error) {
+	var days []int
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		day, err := strconv.Atoi(part)
+		if err != nil || day < 0 {
+			return nil, fmt.Errorf("invalid count of days %q", part)
+		}
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+// ExpiryMonitor periodically checks expiration of TLS certificates and lifetime of keys, exports days left before
+// expiration as metrics and logs warning once expiration crosses each of configured thresholds. Key expires at
+// expiration stored in keystore or, if keystore doesn't store it, after keyLifetime since creation of key (last
+// modification of its file for keys without metadata)
+type ExpiryMonitor struct {
+	certificates []string
+	keys         keystore.KeyLister
+	keyLifetime  time.Duration
+	warningDays  []int
+
+	certificateExpiry *prometheus.GaugeVec
+	keyExpiry         *prometheus.GaugeVec
+
+	lock sync.Mutex
+	// warnedDays stores the lowest threshold already reported for certificate or key
+	warnedDays map[string]int
+	stop       chan struct{}
+	// keyExpirations are keys which expire ordered by expiration, expiredKeys are purposes and ids of expired keys,
+	// both are updated on each check
+	keyExpirations []api.KeyExpiration
+	expiredKeys    map[string]bool
+}
+
+// NewExpiryMonitor returns monitor of certificates from files and keys of keystore. Keys are ignored if keys is nil,
+// only keys with lifetime stored in keystore are checked if keyLifetime isn't positive
+func NewExpiryMonitor(certificates []string, keys keystore.KeyLister, keyLifetime time.Duration, warningDays []int) *ExpiryMonitor {
+	thresholds := append([]int{}, warningDays...)
+	sort.Sort(sort.Reverse(sort.IntSlice(thresholds)))
+	return &ExpiryMonitor{
+		certificates: certificates,
+		keys:         keys,
+		keyLifetime:  keyLifetime,
+		warningDays:  thresholds,
+		certificateExpiry: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "acra_tls_certificate_expiry_days",
+			Help: "days left before expiration of TLS certificate chain",
+		}, []string{"path"}),
+		keyExpiry: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "acra_key_expiry_days",
+			Help: "days left before expiration of the oldest key of purpose",
+		}, []string{"purpose"}),
+		warnedDays:  make(map[string]int),
+		expiredKeys: make(map[string]bool),
+	}
+}
+
+// keyExpiresAt returns expiration of key and false if key doesn't expire
+func (monitor *ExpiryMonitor) keyExpiresAt(key keystore.KeyInfo) (time.Time, bool) {
+	if !key.ExpiresAt.IsZero() {
+		return key.ExpiresAt, true
+	}
+	if monitor.keyLifetime <= 0 {
+		return time.Time{}, false
+	}
+	createdAt := key.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = key.ModifiedAt
+	}
+	return createdAt.Add(monitor.keyLifetime), true
+}
+
+func expiredKeyName(purpose string, id []byte) string {
+	return purpose + "/" + string(id)
+}
+
+// IsKeyExpired returns true if key with purpose of client or zone id was expired on last check
+func (monitor *ExpiryMonitor) IsKeyExpired(purpose string, id []byte) bool {
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+	return monitor.expiredKeys[expiredKeyName(purpose, id)]
+}
+
+// KeyExpirations returns keys which were expired or expire in withinDays days on last check ordered by expiration
+func (monitor *ExpiryMonitor) KeyExpirations(withinDays int) []api.KeyExpiration {
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+	result := make([]api.KeyExpiration, 0, len(monitor.keyExpirations))
+	for _, expiration := range monitor.keyExpirations {
+		if expiration.DaysLeft <= withinDays {
+			result = append(result, expiration)
+		}
+	}
+	return result
+}
+
+// Describe implements prometheus.Collector
+func (monitor *ExpiryMonitor) Describe(ch chan<- *prometheus.Desc) {
+	monitor.certificateExpiry.Describe(ch)
+	monitor.keyExpiry.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (monitor *ExpiryMonitor) Collect(ch chan<- prometheus.Metric) {
+	monitor.certificateExpiry.Collect(ch)
+	monitor.keyExpiry.Collect(ch)
+}
+
+// threshold returns the lowest threshold which days reached or -1 if days is above all thresholds
+func (monitor *ExpiryMonitor) threshold(days int) int {
+	reached := -1
+	for _, threshold := range monitor.warningDays {
+		if days <= threshold {
+			reached = threshold
+		}
+	}
+	return reached
+}
+
+// report logs expiration of item if it crossed new threshold since last check
+func (monitor *ExpiryMonitor) report(item string, days int, expiresAt time.Time, eventCode int, logger *log.Entry) {
+	threshold := monitor.threshold(days)
+	if days < 0 {
+		threshold = -2
+	}
+	warned, ok := monitor.warnedDays[item]
+	if threshold == -1 {
+		// renewed or rotated
+		delete(monitor.warnedDays, item)
+		return
+	}
+	if ok && warned <= threshold {
+		return
+	}
+	monitor.warnedDays[item] = threshold
+	logger = logger.WithFields(log.Fields{"days_left": days, "expires_at": expiresAt.UTC().Format(time.RFC3339)}).
+		WithField(logging.FieldKeyEventCode, eventCode)
+	if days < 0 {
+		logger.Errorln("Expired")
+		return
+	}
+	logger.Warningln("Expires soon, schedule rotation")
+}
+
+// Check verifies expiration of all certificates and keys once
+func (monitor *ExpiryMonitor) Check() {
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+	now := time.Now()
+	for _, path := range monitor.certificates {
+		logger := log.WithField("certificate", path)
+		chain, err := LoadCertificateChain(path)
+		if err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTLSCantLoadCertificates).
+				Warningln("Can't load certificate to check expiration")
+			continue
+		}
+		expiresAt := chainExpiresAt(chain)
+		days := daysBefore(expiresAt, now)
+		monitor.certificateExpiry.WithLabelValues(path).Set(float64(days))
+		monitor.report("certificate:"+path, days, expiresAt, logging.EventCodeWarningCertificateExpiresSoon, logger)
+	}
+	if monitor.keys == nil {
+		return
+	}
+	keys, err := monitor.keys.ListKeys()
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantReadKeys).
+			Warningln("Can't list keys to check expiration")
+		return
+	}
+	oldest := make(map[string]int)
+	monitor.keyExpirations = monitor.keyExpirations[:0]
+	monitor.expiredKeys = make(map[string]bool)
+	for _, key := range keys {
+		if key.Purpose == keystore.KeyPurposeAuth {
+			continue
+		}
+		expiresAt, ok := monitor.keyExpiresAt(key)
+		if !ok {
+			continue
+		}
+		days := daysBefore(expiresAt, now)
+		monitor.keyExpirations = append(monitor.keyExpirations, api.KeyExpiration{
+			Name: key.Name, Purpose: key.Purpose, ID: key.ID, ExpiresAt: expiresAt, DaysLeft: days})
+		if !expiresAt.After(now) {
+			monitor.expiredKeys[expiredKeyName(key.Purpose, []byte(key.ID))] = true
+		}
+		if current, ok := oldest[key.Purpose]; !ok || days < current {
+			oldest[key.Purpose] = days
+		}
+		logger := log.WithFields(log.Fields{"key": key.Name, "purpose": key.Purpose})
+		monitor.report("key:"+key.Name, days, expiresAt, logging.EventCodeWarningKeyExpiresSoon, logger)
+	}
+	sort.SliceStable(monitor.keyExpirations, func(i, j int) bool {
+		return monitor.keyExpirations[i].ExpiresAt.Before(monitor.keyExpirations[j].ExpiresAt)
+	})
+	monitor.keyExpiry.Reset()
+	for purpose, days := range oldest {
+		monitor.keyExpiry.WithLabelValues(purpose).Set(float64(days))
+	}
+}
+
+// Start checks expiration immediately and then every interval in background until Stop called
+func (monitor *ExpiryMonitor) Start(interval time.Duration) {
+	monitor.Check()
+	if interval <= 0 {
+		return
+	}
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+	if monitor.stop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	monitor.stop = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				monitor.Check()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops periodic checks
+func (monitor *ExpiryMonitor) Stop() {
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+	if monitor.stop != nil {
+		close(monitor.stop)
+		monitor.stop = nil
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type testKeyLister struct {
+	keys []keystore.KeyInfo
+}
+
+func (lister *testKeyLister) ListKeys() ([]keystore.KeyInfo, error) {
+	return lister.keys, nil
+}
+
+func (lister *testKeyLister) GetPublicKeyByName(name string) ([]byte, error) {
+	return nil, nil
+}
+
+func gaugeValues(t *testing.T, collector prometheus.Collector, name string) map[string]float64 {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			values[metric.GetLabel()[0].GetValue()] = metric.GetGauge().GetValue()
+		}
+	}
+	return values
+}
+
+func TestParseExpiryWarningDays(t *testing.T) {
+	days, err := ParseExpiryWarningDays("30, 7,1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 3 || days[0] != 30 || days[1] != 7 || days[2] != 1 {
+		t.Fatalf("Unexpected days %v", days)
+	}
+	for _, value := range []string{"30,a", "-1"} {
+		if _, err := ParseExpiryWarningDays(value); err == nil {
+			t.Fatalf("Expected error for %s", value)
+		}
+	}
+}
+
+func TestExpiryMonitorCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "expiry_monitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath := writeSelfSignedCertificate(t, dir, time.Now().Add(time.Hour*24*10+time.Hour))
+	monitor := NewExpiryMonitor([]string{certPath}, nil, 0, []int{1, 30, 7})
+	monitor.Check()
+	if days := gaugeValues(t, monitor, "acra_tls_certificate_expiry_days")[certPath]; days != 10 {
+		t.Fatalf("Expected 10 days before expiration, took %v", days)
+	}
+	if warned := monitor.warnedDays["certificate:"+certPath]; warned != 30 {
+		t.Fatalf("Expected warning at threshold 30, took %v", warned)
+	}
+
+	// renewed certificate clears warning
+	certPath2 := writeSelfSignedCertificate(t, dir, time.Now().Add(time.Hour*24*100))
+	if err := os.Rename(certPath2, certPath); err != nil {
+		t.Fatal(err)
+	}
+	monitor.Check()
+	if _, ok := monitor.warnedDays["certificate:"+certPath]; ok {
+		t.Fatal("Expected cleared warning after renewal")
+	}
+}
+
+func TestExpiryMonitorKeys(t *testing.T) {
+	now := time.Now()
+	lister := &testKeyLister{keys: []keystore.KeyInfo{
+		{Name: "client_storage", Purpose: keystore.KeyPurposeStorage, ID: "client", ModifiedAt: now.Add(-time.Hour * 24 * 85)},
+		{Name: "client2_storage", Purpose: keystore.KeyPurposeStorage, ID: "client2", ModifiedAt: now.Add(-time.Hour * 24 * 10)},
+		{Name: "zone_zone", Purpose: keystore.KeyPurposeZone, ID: "zone", ModifiedAt: now.Add(-time.Hour*24*100 + time.Hour)},
+		{Name: "auth_key", Purpose: keystore.KeyPurposeAuth, ModifiedAt: now.Add(-time.Hour * 24 * 1000)},
+		{Name: "client_hmac", Purpose: keystore.KeyPurposeHMAC, ID: "client", ModifiedAt: now.Add(-time.Hour * 24 * 10),
+			CreatedAt: now.Add(-time.Hour * 24 * 10), ExpiresAt: now.Add(-time.Hour)},
+	}}
+	// only keys with stored lifetime are checked without default lifetime
+	monitor := NewExpiryMonitor(nil, lister, 0, DefaultExpiryWarningDays)
+	monitor.Check()
+	if values := gaugeValues(t, monitor, "acra_key_expiry_days"); len(values) != 1 || values[keystore.KeyPurposeHMAC] != -1 {
+		t.Fatalf("Unexpected expiration of keys without lifetime %v", values)
+	}
+	if !monitor.IsKeyExpired(keystore.KeyPurposeHMAC, []byte("client")) || monitor.IsKeyExpired(keystore.KeyPurposeZone, []byte("zone")) {
+		t.Fatal("Unexpected expired keys without lifetime")
+	}
+	monitor = NewExpiryMonitor(nil, lister, time.Hour*24*90, DefaultExpiryWarningDays)
+	monitor.Check()
+	values := gaugeValues(t, monitor, "acra_key_expiry_days")
+	if len(values) != 3 || values[keystore.KeyPurposeStorage] != 4 || values[keystore.KeyPurposeZone] != -10 || values[keystore.KeyPurposeHMAC] != -1 {
+		t.Fatalf("Unexpected expiration of keys %v", values)
+	}
+	if !monitor.IsKeyExpired(keystore.KeyPurposeZone, []byte("zone")) || monitor.IsKeyExpired(keystore.KeyPurposeStorage, []byte("client")) {
+		t.Fatal("Unexpected expired keys")
+	}
+	expirations := monitor.KeyExpirations(5)
+	if len(expirations) != 3 || expirations[0].Name != "zone_zone" || expirations[1].Name != "client_hmac" ||
+		expirations[2].Name != "client_storage" || expirations[2].DaysLeft != 4 {
+		t.Fatalf("Unexpected key expirations %v", expirations)
+	}
+	expectedWarnings := map[string]int{"key:client_storage": 7, "key:zone_zone": -2, "key:client_hmac": -2}
+	if len(monitor.warnedDays) != len(expectedWarnings) {
+		t.Fatalf("Unexpected warnings %v", monitor.warnedDays)
+	}
+	for item, threshold := range expectedWarnings {
+		if monitor.warnedDays[item] != threshold {
+			t.Fatalf("Unexpected warnings %v", monitor.warnedDays)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	flag_ "flag"
+	"io/ioutil"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/pkcs11"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultHSMKeyLabel is label of AES key in HSM used to encrypt keys of keystore
+const DefaultHSMKeyLabel = "acra_master_key"
+
+// ErrNoHSMPin returned if PKCS#11 module specified without file with PIN
+var ErrNoHSMPin = errors.New("hsm_pin_file should be specified with hsm_pkcs11_module")
+
+// ErrNoMasterKeyID returned if other master keys are configured while ID of current master key isn't set
+var ErrNoMasterKeyID = errors.New(keystore.AcraMasterKeyIDVarName + " should be specified with " + keystore.AcraMasterKeysVarName)
+
+// HSMKeyEncryptorLoader creates encryptor of keystore which wraps keys in HSM with PKCS#11 module if configured with
+// flags, otherwise encryptor with master key
+type HSMKeyEncryptorLoader struct {
+	module   *string
+	slot     *uint
+	pinFile  *string
+	keyLabel *string
+}
+
+// RegisterHSMFlags registers flags of HSM in default flag set
+func RegisterHSMFlags() *HSMKeyEncryptorLoader {
+	return RegisterHSMFlagsWithFlagSet(flag_.CommandLine)
+}
+
+// RegisterHSMFlagsWithFlagSet registers flags of HSM in flagSet
+func RegisterHSMFlagsWithFlagSet(flagSet *flag_.FlagSet) *HSMKeyEncryptorLoader {
+	return &HSMKeyEncryptorLoader{
+		module:   flagSet.String("hsm_pkcs11_module", "", "Path to PKCS#11 module of HSM which encrypts keys of keystore with AES key instead of master key"),
+		slot:     flagSet.Uint("hsm_slot", 0, "ID of HSM slot with token which stores AES key"),
+		pinFile:  flagSet.String("hsm_pin_file", "", "Path to file with PIN of HSM user"),
+		keyLabel: flagSet.String("hsm_key_label", DefaultHSMKeyLabel, "Label of AES key in HSM used to encrypt keys of keystore"),
+	}
+}
+
+// Enabled returns true if PKCS#11 module configured
+func (loader *HSMKeyEncryptorLoader) Enabled() bool {
+	return *loader.module != ""
+}
+
+// NewKeyEncryptor returns encryptor which wraps keys in HSM if PKCS#11 module configured, otherwise Secure Cell
+// encryptor with master key from loadMasterKey. Master key isn't loaded if HSM is used. If previous master key set in
+// ACRA_PREVIOUS_MASTER_KEY then keys encrypted with it are decrypted and rewrapped with current encryptor. If ID of
+// current encryptor set in ACRA_MASTER_KEY_ID then it's recorded in encrypted keys and keys recorded as encrypted with
+// master keys from ACRA_MASTER_KEYS are decrypted with them and rewrapped
+func (loader *HSMKeyEncryptorLoader) NewKeyEncryptor(loadMasterKey func() ([]byte, error)) (keystore.KeyEncryptor, error) {
+	encryptor, err := loader.newCurrentKeyEncryptor(loadMasterKey)
+	if err != nil {
+		return nil, err
+	}
+	masterKeyID, err := keystore.GetMasterKeyIDFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	masterKeys, err := keystore.GetMasterKeysFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	if masterKeyID == "" && len(masterKeys) > 0 {
+		return nil, ErrNoMasterKeyID
+	}
+	previousMasterKey, err := keystore.GetPreviousMasterKeyFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	var previous keystore.KeyEncryptor
+	if previousMasterKey != nil {
+		previous, err = keystore.NewSCellKeyEncryptor(previousMasterKey)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("Use previous master key from %s to rewrap keys", keystore.AcraPreviousMasterKeyVarName)
+	}
+	if masterKeyID == "" {
+		if previous == nil {
+			return encryptor, nil
+		}
+		return keystore.NewRotatingKeyEncryptor(encryptor, previous), nil
+	}
+	// keys stored before master key IDs were configured are encrypted with previous or the same master key
+	encryptors := map[string]keystore.KeyEncryptor{masterKeyID: encryptor, keystore.LegacyMasterKeyID: encryptor}
+	if previous != nil {
+		encryptors[keystore.LegacyMasterKeyID] = previous
+	}
+	for id, masterKey := range masterKeys {
+		if id == masterKeyID {
+			return nil, keystore.ErrInvalidMasterKeyList
+		}
+		encryptors[id], err = keystore.NewSCellKeyEncryptor(masterKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	log.WithFields(log.Fields{"master_key_id": masterKeyID, "other_master_keys": len(masterKeys)}).Infoln("Use master key IDs to encrypt keys")
+	return keystore.NewMasterKeyIDEncryptor(masterKeyID, encryptors)
+}
+
+func (loader *HSMKeyEncryptorLoader) newCurrentKeyEncryptor(loadMasterKey func() ([]byte, error)) (keystore.KeyEncryptor, error) {
+	if !loader.Enabled() {
+		masterKey, err := loadMasterKey()
+		if err != nil {
+			return nil, err
+		}
+		encryptor, err := keystore.NewSCellKeyEncryptor(masterKey)
+		if err != nil {
+			return nil, err
+		}
+		return encryptor, nil
+	}
+	if *loader.pinFile == "" {
+		return nil, ErrNoHSMPin
+	}
+	pin, err := ioutil.ReadFile(*loader.pinFile)
+	if err != nil {
+		return nil, err
+	}
+	encryptor, err := pkcs11.NewKeyEncryptor(*loader.module, *loader.slot, bytes.TrimRight(pin, "\r\n"), *loader.keyLabel)
+	if err != nil {
+		return nil, err
+	}
+	return encryptor, nil
+}
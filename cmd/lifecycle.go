@@ -0,0 +1,177 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cossacklabs/acra/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// LifecycleStage is point of service's lifetime where registered hooks are run
+type LifecycleStage string
+
+// Stages of service's lifetime
+const (
+	// LifecyclePreListen runs after configuration is loaded and before listeners are created
+	LifecyclePreListen LifecycleStage = "pre-listen"
+	// LifecyclePostListen runs after main listener accepts connections
+	LifecyclePostListen LifecycleStage = "post-listen"
+	// LifecyclePreDrain runs on shutdown or restart before service stops accepting new connections
+	LifecyclePreDrain LifecycleStage = "pre-drain"
+	// LifecyclePostShutdown runs after all connections closed, right before process exits
+	LifecyclePostShutdown LifecycleStage = "post-shutdown"
+)
+
+// LifecycleStages lists all stages in order in which they are run
+var LifecycleStages = []LifecycleStage{LifecyclePreListen, LifecyclePostListen, LifecyclePreDrain, LifecyclePostShutdown}
+
+// DEFAULT_LIFECYCLE_HOOK_TIMEOUT is default time in seconds given to each lifecycle hook
+const DEFAULT_LIFECYCLE_HOOK_TIMEOUT = 30
+
+// LifecycleScriptStageEnv is environment variable with name of stage passed to lifecycle scripts
+const LifecycleScriptStageEnv = "ACRA_LIFECYCLE_STAGE"
+
+// ErrUnknownLifecycleStage returned for stage not listed in LifecycleStages
+var ErrUnknownLifecycleStage = errors.New("unknown lifecycle stage")
+
+// LifecycleHook is custom action run at stage of service's lifetime. ctx is canceled when hook's timeout expires
+type LifecycleHook func(ctx context.Context, stage LifecycleStage) error
+
+type namedLifecycleHook struct {
+	name string
+	hook LifecycleHook
+}
+
+// Lifecycle stores hooks registered for stages of service's lifetime and runs them. Each stage runs only once, so
+// hooks aren't repeated when shutdown is triggered several times
+type Lifecycle struct {
+	lock    sync.Mutex
+	hooks   map[LifecycleStage][]namedLifecycleHook
+	ran     map[LifecycleStage]bool
+	timeout time.Duration
+}
+
+// NewLifecycle returns Lifecycle which gives each hook timeout to finish, 0 means no limit
+func NewLifecycle(timeout time.Duration) *Lifecycle {
+	return &Lifecycle{
+		hooks:   make(map[LifecycleStage][]namedLifecycleHook),
+		ran:     make(map[LifecycleStage]bool),
+		timeout: timeout,
+	}
+}
+
+// IsValidLifecycleStage returns true if stage is one of LifecycleStages
+func IsValidLifecycleStage(stage LifecycleStage) bool {
+	for _, known := range LifecycleStages {
+		if stage == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Register adds hook with name used in logs to end of hooks of stage
+func (lifecycle *Lifecycle) Register(stage LifecycleStage, name string, hook LifecycleHook) error {
+	if !IsValidLifecycleStage(stage) {
+		return ErrUnknownLifecycleStage
+	}
+	lifecycle.lock.Lock()
+	defer lifecycle.lock.Unlock()
+	lifecycle.hooks[stage] = append(lifecycle.hooks[stage], namedLifecycleHook{name: name, hook: hook})
+	return nil
+}
+
+// Run runs all hooks of stage in order of registration. Failed hook doesn't stop next ones, all failures are logged
+// and first error is returned. Stage that already ran is skipped. Nil Lifecycle runs nothing
+func (lifecycle *Lifecycle) Run(stage LifecycleStage) error {
+	if lifecycle == nil {
+		return nil
+	}
+	lifecycle.lock.Lock()
+	if lifecycle.ran[stage] {
+		lifecycle.lock.Unlock()
+		return nil
+	}
+	lifecycle.ran[stage] = true
+	hooks := lifecycle.hooks[stage]
+	lifecycle.lock.Unlock()
+
+	var firstErr error
+	for _, hook := range hooks {
+		logger := log.WithFields(log.Fields{"stage": stage, "hook": hook.name})
+		logger.Debugln("Run lifecycle hook")
+		if err := lifecycle.runHook(stage, hook.hook); err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorLifecycleHookFailed).
+				Errorln("Lifecycle hook failed")
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (lifecycle *Lifecycle) runHook(stage LifecycleStage, hook LifecycleHook) error {
+	ctx := context.Background()
+	if lifecycle.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, lifecycle.timeout)
+		defer cancel()
+	}
+	return hook(ctx, stage)
+}
+
+// NewScriptLifecycleHook returns hook which runs executable at path and waits until it exits. Name of stage is passed
+// in ACRA_LIFECYCLE_STAGE environment variable. Script is killed when hook's timeout expires
+func NewScriptLifecycleHook(path string) LifecycleHook {
+	return func(ctx context.Context, stage LifecycleStage) error {
+		command := exec.CommandContext(ctx, path)
+		command.Env = append(os.Environ(), fmt.Sprintf("%s=%s", LifecycleScriptStageEnv, stage))
+		command.Stdout = os.Stdout
+		command.Stderr = os.Stderr
+		return command.Run()
+	}
+}
+
+// RegisterLifecycleScripts parses value in format "stage=path,stage=path" and registers script hook for each pair
+func RegisterLifecycleScripts(lifecycle *Lifecycle, value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return fmt.Errorf("invalid lifecycle hook script '%s', expected stage=path", pair)
+		}
+		stage := LifecycleStage(strings.TrimSpace(parts[0]))
+		path := strings.TrimSpace(parts[1])
+		if err := lifecycle.Register(stage, path, NewScriptLifecycleHook(path)); err != nil {
+			return fmt.Errorf("%v '%s'", err, stage)
+		}
+	}
+	return nil
+}
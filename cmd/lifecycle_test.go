@@ -0,0 +1,119 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLifecycleRun(t *testing.T) {
+	lifecycle := NewLifecycle(time.Second)
+	var calls []string
+	testErr := errors.New("test error")
+	hook := func(name string, err error) LifecycleHook {
+		return func(ctx context.Context, stage LifecycleStage) error {
+			calls = append(calls, name+":"+string(stage))
+			return err
+		}
+	}
+	if err := lifecycle.Register(LifecyclePreDrain, "first", hook("first", testErr)); err != nil {
+		t.Fatal(err)
+	}
+	if err := lifecycle.Register(LifecyclePreDrain, "second", hook("second", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := lifecycle.Register(LifecyclePostShutdown, "third", hook("third", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := lifecycle.Register("unknown", "fourth", hook("fourth", nil)); err != ErrUnknownLifecycleStage {
+		t.Fatalf("Expected ErrUnknownLifecycleStage, took %v", err)
+	}
+	// failed hook doesn't stop next ones and its error is returned
+	if err := lifecycle.Run(LifecyclePreDrain); err != testErr {
+		t.Fatalf("Expected test error, took %v", err)
+	}
+	// stage runs only once
+	if err := lifecycle.Run(LifecyclePreDrain); err != nil {
+		t.Fatal(err)
+	}
+	if err := lifecycle.Run(LifecyclePreListen); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"first:pre-drain", "second:pre-drain"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("Expected %v, took %v", expected, calls)
+	}
+	var nilLifecycle *Lifecycle
+	if err := nilLifecycle.Run(LifecyclePreListen); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLifecycleHookTimeout(t *testing.T) {
+	lifecycle := NewLifecycle(time.Millisecond * 10)
+	lifecycle.Register(LifecyclePostListen, "slow", func(ctx context.Context, stage LifecycleStage) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err := lifecycle.Run(LifecyclePostListen); err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded, took %v", err)
+	}
+}
+
+func TestRegisterLifecycleScripts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lifecycle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "output")
+	script := filepath.Join(dir, "hook.sh")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\necho $"+LifecycleScriptStageEnv+" >> "+output+"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	lifecycle := NewLifecycle(time.Second * 5)
+	if err := RegisterLifecycleScripts(lifecycle, "pre-listen="+script+", post-shutdown="+script); err != nil {
+		t.Fatal(err)
+	}
+	if err := lifecycle.Run(LifecyclePreListen); err != nil {
+		t.Fatal(err)
+	}
+	if err := lifecycle.Run(LifecyclePostShutdown); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Fields(string(data)); !reflect.DeepEqual(lines, []string{"pre-listen", "post-shutdown"}) {
+		t.Fatalf("Unexpected stages passed to script: %v", lines)
+	}
+
+	for _, value := range []string{"pre-listen", "pre-listen=", "on-start=" + script} {
+		if err := RegisterLifecycleScripts(NewLifecycle(0), value); err == nil {
+			t.Fatalf("Expected error for '%s'", value)
+		}
+	}
+}
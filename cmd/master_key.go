@@ -0,0 +1,191 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/base64"
+	"errors"
+	flag_ "flag"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/escrow"
+	"github.com/cossacklabs/acra/keystore/kms"
+	log "github.com/sirupsen/logrus"
+)
+
+// Sources of master key selected with master_key_provider flag
+const (
+	MasterKeyProviderEnv   = "env"
+	MasterKeyProviderAWS   = "aws_kms"
+	MasterKeyProviderGCP   = "gcp_kms"
+	MasterKeyProviderAzure = "azure_kv"
+	// MasterKeyProviderShamir reconstructs master key from shares supplied with files or unseal API
+	MasterKeyProviderShamir = "shamir"
+)
+
+// Errors of master key configuration
+var (
+	ErrNoEncryptedMasterKey     = errors.New("master_key_kms_encrypted_key_file should be specified with master_key_kms_key_id")
+	ErrNoKMSKeyID               = errors.New("master_key_kms_key_id should be specified to load master key with KMS")
+	ErrUnknownMasterKeyProvider = errors.New("unknown master_key_provider, should be one of env, aws_kms, gcp_kms, azure_kv, shamir")
+	ErrNoMasterKeyShares        = errors.New("master_key_share_files or master_key_unseal_api should be specified to unseal master key with shamir master_key_provider")
+)
+
+// MasterKeyLoader loads master key from ACRA_MASTER_KEY environment variable, decrypts master key with key of AWS
+// KMS, Google Cloud KMS or Azure Key Vault or reconstructs it from shares if configured with flags
+type MasterKeyLoader struct {
+	provider         *string
+	kmsKeyID         *string
+	encryptedKeyFile *string
+	kmsRegion        *string
+	kmsEndpoint      *string
+	azureAlgorithm   *string
+	shareFiles       *string
+	unsealAPI        *string
+	// unsealer is created once, so shares added with unseal API are kept between loads of master key
+	unsealerOnce sync.Once
+	unsealer     *escrow.Unsealer
+	unsealerErr  error
+}
+
+// RegisterMasterKeyLoaderFlags registers flags of master key source in default flag set
+func RegisterMasterKeyLoaderFlags() *MasterKeyLoader {
+	return RegisterMasterKeyLoaderFlagsWithFlagSet(flag_.CommandLine)
+}
+
+// RegisterMasterKeyLoaderFlagsWithFlagSet registers flags of master key source in flagSet
+func RegisterMasterKeyLoaderFlagsWithFlagSet(flagSet *flag_.FlagSet) *MasterKeyLoader {
+	return &MasterKeyLoader{
+		provider:         flagSet.String("master_key_provider", "", "Source of master key: env ("+keystore.AcraMasterKeyVarName+" environment variable), aws_kms, gcp_kms, azure_kv, shamir (N of M shares from master_key_share_files and master_key_unseal_api). Empty - aws_kms if master_key_kms_key_id specified, env otherwise"),
+		kmsKeyID:         flagSet.String("master_key_kms_key_id", "", "Key which decrypts master key from master_key_kms_encrypted_key_file: ID, ARN or alias of AWS KMS key, resource name of Google Cloud KMS key (projects/../cryptoKeys/..) or URL of Azure Key Vault key"),
+		encryptedKeyFile: flagSet.String("master_key_kms_encrypted_key_file", "", "Path to master key encrypted with KMS key (raw or base64 encoded)"),
+		kmsRegion:        flagSet.String("master_key_kms_region", "", "AWS region of KMS key, AWS_REGION or AWS_DEFAULT_REGION environment variable is used if empty"),
+		kmsEndpoint:      flagSet.String("master_key_kms_endpoint", "", "URL of AWS KMS or Google Cloud KMS endpoint, default endpoint of cloud is used if empty"),
+		azureAlgorithm:   flagSet.String("master_key_azure_kv_algorithm", kms.AzureDefaultAlgorithm, "Algorithm of Azure Key Vault key used to encrypt master key"),
+		shareFiles:       flagSet.String("master_key_share_files", "", "Comma separated paths to files with shares of master key generated by 'acra-keymaker --split_master_key', one share per line"),
+		unsealAPI:        flagSet.String("master_key_unseal_api", "", "Connection string like unix:///var/run/acra-unseal.sock where unseal HTTP API accepts shares of master key until it's reconstructed. API isn't authenticated, so only unix socket or loopback address is accepted"),
+	}
+}
+
+// readEncryptedMasterKey reads encrypted master key as written by AWS CLI in base64 or as raw bytes
+func readEncryptedMasterKey(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data))); err == nil {
+		return decoded, nil
+	}
+	return data, nil
+}
+
+// Provider returns loader of master key from source configured with flags
+func (loader *MasterKeyLoader) Provider() (keystore.MasterKeyLoader, error) {
+	provider := *loader.provider
+	if provider == "" {
+		provider = MasterKeyProviderEnv
+		if *loader.kmsKeyID != "" {
+			provider = MasterKeyProviderAWS
+		}
+	}
+	var decrypter keystore.KMSDecrypter
+	switch provider {
+	case MasterKeyProviderEnv:
+		return keystore.EnvironmentMasterKeyLoader{}, nil
+	case MasterKeyProviderAWS:
+		client, err := kms.NewAWSClientFromEnvironment(*loader.kmsRegion, *loader.kmsEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		decrypter = client
+	case MasterKeyProviderGCP:
+		decrypter = kms.NewGCPClientFromEnvironment(*loader.kmsEndpoint)
+	case MasterKeyProviderAzure:
+		decrypter = kms.NewAzureClientFromEnvironment(*loader.azureAlgorithm)
+	case MasterKeyProviderShamir:
+		loader.unsealerOnce.Do(func() {
+			loader.unsealer, loader.unsealerErr = loader.newUnsealer()
+		})
+		return loader.unsealer, loader.unsealerErr
+	default:
+		return nil, ErrUnknownMasterKeyProvider
+	}
+	if *loader.kmsKeyID == "" {
+		return nil, ErrNoKMSKeyID
+	}
+	if *loader.encryptedKeyFile == "" {
+		return nil, ErrNoEncryptedMasterKey
+	}
+	encryptedKey, err := readEncryptedMasterKey(*loader.encryptedKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return keystore.NewKMSMasterKeyLoader(decrypter, *loader.kmsKeyID, encryptedKey), nil
+}
+
+// newUnsealer returns unsealer with shares read from master_key_share_files and starts unseal API if it's configured
+// and shares from files aren't enough
+func (loader *MasterKeyLoader) newUnsealer() (*escrow.Unsealer, error) {
+	if *loader.shareFiles == "" && *loader.unsealAPI == "" {
+		return nil, ErrNoMasterKeyShares
+	}
+	unsealer := escrow.NewUnsealer()
+	if *loader.shareFiles != "" {
+		for _, path := range strings.Split(*loader.shareFiles, ",") {
+			data, err := ioutil.ReadFile(strings.TrimSpace(path))
+			if err != nil {
+				return nil, err
+			}
+			for _, share := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+				if strings.TrimSpace(share) == "" {
+					continue
+				}
+				if err := unsealer.AddShare(share); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if !unsealer.Status().Sealed {
+		return unsealer, nil
+	}
+	if *loader.unsealAPI == "" {
+		return nil, escrow.ErrNotEnoughShares
+	}
+	listener, err := ServeUnsealAPI(*loader.unsealAPI, unsealer)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-unsealer.Unsealed()
+		listener.Close()
+	}()
+	log.WithField("connection_string", *loader.unsealAPI).Infoln("Master key is sealed, waiting for shares from unseal API")
+	return unsealer, nil
+}
+
+// LoadMasterKey returns master key from source configured with flags
+func (loader *MasterKeyLoader) LoadMasterKey() ([]byte, error) {
+	provider, err := loader.Provider()
+	if err != nil {
+		return nil, err
+	}
+	return provider.LoadMasterKey()
+}
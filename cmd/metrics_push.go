@@ -0,0 +1,189 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrNoMetricsPushTarget returned if neither Pushgateway nor remote-write url was specified
+var ErrNoMetricsPushTarget = errors.New("pushgateway or remote-write url should be specified")
+
+// MetricsPusher pushes metrics of short-lived tools to Prometheus Pushgateway and/or remote-write endpoint. Metrics
+// pushed periodically while job works to track its progress and once more with job outcome after job finished.
+// All methods may be called on nil MetricsPusher and do nothing, so tools may use it unconditionally.
+type MetricsPusher struct {
+	job         string
+	gatherers   prometheus.Gatherers
+	pushgateway *push.Pusher
+	remoteWrite *RemoteWriteClient
+	startTime   time.Time
+
+	duration           prometheus.Gauge
+	success            prometheus.Gauge
+	lastCompletionTime prometheus.Gauge
+	lastSuccessTime    prometheus.Gauge
+
+	lock     sync.Mutex
+	finished bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewMetricsPusher returns MetricsPusher for job which pushes metrics registered in default prometheus registry
+// to pushgatewayURL and remoteWriteURL. Returns nil without error if both urls are empty
+func NewMetricsPusher(job, pushgatewayURL, remoteWriteURL string) (*MetricsPusher, error) {
+	if pushgatewayURL == "" && remoteWriteURL == "" {
+		return nil, nil
+	}
+	return newMetricsPusher(job, pushgatewayURL, remoteWriteURL, prometheus.DefaultGatherer)
+}
+
+func newMetricsPusher(job, pushgatewayURL, remoteWriteURL string, gatherer prometheus.Gatherer) (*MetricsPusher, error) {
+	if pushgatewayURL == "" && remoteWriteURL == "" {
+		return nil, ErrNoMetricsPushTarget
+	}
+	pusher := &MetricsPusher{
+		job:       job,
+		startTime: time.Now(),
+		duration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "acra_job_duration_seconds",
+			Help: "duration of last job run",
+		}),
+		success: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "acra_job_success",
+			Help: "1 if last job run finished successfully, 0 if job failed or still works",
+		}),
+		lastCompletionTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "acra_job_last_completion_timestamp_seconds",
+			Help: "unix time of last job completion",
+		}),
+		lastSuccessTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "acra_job_last_success_timestamp_seconds",
+			Help: "unix time of last successful job completion",
+		}),
+	}
+	// last success time registered only after success to not override value pushed by previous successful run
+	jobRegistry := prometheus.NewRegistry()
+	jobRegistry.MustRegister(pusher.duration, pusher.success, pusher.lastCompletionTime)
+	pusher.gatherers = prometheus.Gatherers{gatherer, jobRegistry}
+	if pushgatewayURL != "" {
+		pusher.pushgateway = push.New(pushgatewayURL, job).Gatherer(pusher.gatherers)
+	}
+	if remoteWriteURL != "" {
+		pusher.remoteWrite = NewRemoteWriteClient(remoteWriteURL, nil)
+	}
+	return pusher, nil
+}
+
+// Push pushes current values of metrics to all configured targets
+func (pusher *MetricsPusher) Push() error {
+	if pusher == nil {
+		return nil
+	}
+	pusher.duration.Set(time.Since(pusher.startTime).Seconds())
+	var pushErr error
+	if pusher.pushgateway != nil {
+		// Add replaces only pushed metrics of job so last success time of previous runs stays untouched
+		if err := pusher.pushgateway.Add(); err != nil {
+			log.WithError(err).Warningln("Can't push metrics to pushgateway")
+			pushErr = err
+		}
+	}
+	if pusher.remoteWrite != nil {
+		families, err := pusher.gatherers.Gather()
+		if err != nil {
+			log.WithError(err).Warningln("Can't gather metrics")
+			return err
+		}
+		if err := pusher.remoteWrite.Write(families, map[string]string{"job": pusher.job}, time.Now()); err != nil {
+			log.WithError(err).Warningln("Can't push metrics to remote-write endpoint")
+			pushErr = err
+		}
+	}
+	return pushErr
+}
+
+// Start pushes metrics every interval in background until Finish called
+func (pusher *MetricsPusher) Start(interval time.Duration) {
+	if pusher == nil || interval <= 0 {
+		return
+	}
+	pusher.lock.Lock()
+	defer pusher.lock.Unlock()
+	if pusher.stop != nil || pusher.finished {
+		return
+	}
+	pusher.stop = make(chan struct{})
+	pusher.done = make(chan struct{})
+	go func() {
+		defer close(pusher.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				pusher.Push()
+			case <-pusher.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Finish stops periodic pushing, sets job outcome metrics and pushes them. Subsequent calls do nothing
+func (pusher *MetricsPusher) Finish(success bool) error {
+	if pusher == nil {
+		return nil
+	}
+	pusher.lock.Lock()
+	defer pusher.lock.Unlock()
+	if pusher.finished {
+		return nil
+	}
+	pusher.finished = true
+	if pusher.stop != nil {
+		close(pusher.stop)
+		<-pusher.done
+	}
+	now := float64(time.Now().UnixNano()) / float64(time.Second)
+	pusher.lastCompletionTime.Set(now)
+	if success {
+		pusher.success.Set(1)
+		pusher.lastSuccessTime.Set(now)
+		successGatherer := gathererOf(pusher.lastSuccessTime)
+		pusher.gatherers = append(pusher.gatherers, successGatherer)
+		if pusher.pushgateway != nil {
+			pusher.pushgateway.Gatherer(successGatherer)
+		}
+	} else {
+		pusher.success.Set(0)
+	}
+	return pusher.Push()
+}
+
+func gathererOf(collectors ...prometheus.Collector) prometheus.Gatherer {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors...)
+	return registry
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// decodeWriteRequest returns values of series by their labels in "name{label=value,...}" format from snappy-compressed
+// WriteRequest
+func decodeWriteRequest(t *testing.T, body []byte) map[string]float64 {
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := &remoteWriteRequest{}
+	if err := proto.Unmarshal(data, request); err != nil {
+		t.Fatal(err)
+	}
+	series := map[string]float64{}
+	for _, timeSeries := range request.Timeseries {
+		var name string
+		var labels []string
+		for _, label := range timeSeries.Labels {
+			if label.Name == "__name__" {
+				name = label.Value
+				continue
+			}
+			labels = append(labels, label.Name+"="+label.Value)
+		}
+		if len(timeSeries.Samples) != 1 || timeSeries.Samples[0].Timestamp == 0 {
+			t.Fatalf("Expected one sample with timestamp, took %v", timeSeries.Samples)
+		}
+		series[name+"{"+strings.Join(labels, ",")+"}"] = timeSeries.Samples[0].Value
+	}
+	return series
+}
+
+func TestRemoteWriteClient(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_rows_total", Help: "test"}, []string{"status"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_seconds", Help: "test", Buckets: []float64{1}})
+	registry.MustRegister(counter, histogram)
+	counter.WithLabelValues("decrypted").Add(3)
+	histogram.Observe(0.5)
+	histogram.Observe(2)
+
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("Incorrect headers %v", r.Header)
+		}
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewRemoteWriteClient(server.URL, nil).Write(families, map[string]string{"job": "test"}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	series := decodeWriteRequest(t, body)
+	expected := map[string]float64{
+		"test_rows_total{job=test,status=decrypted}": 3,
+		"test_seconds_bucket{job=test,le=1}":         1,
+		"test_seconds_bucket{job=test,le=+Inf}":      2,
+		"test_seconds_sum{job=test}":                 2.5,
+		"test_seconds_count{job=test}":               2,
+	}
+	if len(series) != len(expected) {
+		t.Fatalf("Incorrect series %v", series)
+	}
+	for name, value := range expected {
+		if series[name] != value {
+			t.Errorf("Incorrect value of %s: %v, expected %v", name, series[name], value)
+		}
+	}
+
+	failServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer failServer.Close()
+	if err := NewRemoteWriteClient(failServer.URL, nil).Write(families, nil, time.Now()); err == nil {
+		t.Fatal("Expected error on unsuccessful status code")
+	}
+}
+
+func TestMetricsPusher(t *testing.T) {
+	if pusher, err := NewMetricsPusher("test", "", ""); pusher != nil || err != nil {
+		t.Fatal("Expected nil pusher without urls")
+	}
+	var nilPusher *MetricsPusher
+	nilPusher.Start(time.Millisecond)
+	if err := nilPusher.Finish(true); err != nil {
+		t.Fatal(err)
+	}
+
+	lock := sync.Mutex{}
+	var pushgatewayBodies []string
+	pushgateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/metrics/job/test" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		lock.Lock()
+		pushgatewayBodies = append(pushgatewayBodies, string(body))
+		lock.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer pushgateway.Close()
+	var lastRemoteWrite map[string]float64
+	remoteWrite := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		lock.Lock()
+		lastRemoteWrite = decodeWriteRequest(t, body)
+		lock.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer remoteWrite.Close()
+
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_files_total", Help: "test"})
+	registry.MustRegister(counter)
+	counter.Inc()
+
+	pusher, err := newMetricsPusher("test", pushgateway.URL, remoteWrite.URL, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pusher.Start(time.Millisecond * 10)
+	time.Sleep(time.Millisecond * 50)
+	if err := pusher.Finish(true); err != nil {
+		t.Fatal(err)
+	}
+	// second call shouldn't push anything
+	if err := pusher.Finish(false); err != nil {
+		t.Fatal(err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(pushgatewayBodies) < 2 {
+		t.Fatalf("Expected progress and final pushes, took %d", len(pushgatewayBodies))
+	}
+	if strings.Contains(pushgatewayBodies[0], "acra_job_last_success_timestamp_seconds") {
+		t.Fatal("Progress push shouldn't contain last success time")
+	}
+	if !strings.Contains(pushgatewayBodies[len(pushgatewayBodies)-1], "acra_job_last_success_timestamp_seconds") {
+		t.Fatal("Final push should contain last success time")
+	}
+	if lastRemoteWrite["test_files_total{job=test}"] != 1 || lastRemoteWrite["acra_job_success{job=test}"] != 1 {
+		t.Fatalf("Incorrect remote-write series %v", lastRemoteWrite)
+	}
+	if lastRemoteWrite["acra_job_last_success_timestamp_seconds{job=test}"] == 0 {
+		t.Fatal("Expected last success time in final remote-write push")
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// remoteWriteTimeout used for requests to remote-write endpoint if http client wasn't specified
+const remoteWriteTimeout = time.Second * 10
+
+// RemoteWriteClient sends metrics to endpoints which support Prometheus remote-write protocol
+type RemoteWriteClient struct {
+	url    string
+	client *http.Client
+}
+
+// NewRemoteWriteClient returns RemoteWriteClient which sends metrics to url with client or default client with timeout
+func NewRemoteWriteClient(url string, client *http.Client) *RemoteWriteClient {
+	if client == nil {
+		client = &http.Client{Timeout: remoteWriteTimeout}
+	}
+	return &RemoteWriteClient{url: url, client: client}
+}
+
+// Write converts metric families to time series with extraLabels and sample timestamp and sends them
+func (remoteWrite *RemoteWriteClient) Write(families []*dto.MetricFamily, extraLabels map[string]string, timestamp time.Time) error {
+	data, err := proto.Marshal(newWriteRequest(families, extraLabels, timestamp.UnixNano()/int64(time.Millisecond)))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, remoteWrite.url, bytes.NewReader(snappy.Encode(nil, data)))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Encoding", "snappy")
+	request.Header.Set("Content-Type", "application/x-protobuf")
+	request.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	response, err := remoteWrite.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status code of remote-write endpoint: %d", response.StatusCode)
+	}
+	return nil
+}
+
+// remoteWriteRequest is WriteRequest message of remote-write protocol
+type remoteWriteRequest struct {
+	Timeseries []*remoteWriteSeries `protobuf:"bytes,1,rep,name=timeseries,proto3"`
+}
+
+func (m *remoteWriteRequest) Reset()         { *m = remoteWriteRequest{} }
+func (m *remoteWriteRequest) String() string { return proto.CompactTextString(m) }
+func (*remoteWriteRequest) ProtoMessage()    {}
+
+// remoteWriteSeries is TimeSeries message of remote-write protocol
+type remoteWriteSeries struct {
+	Labels  []*remoteWriteLabel  `protobuf:"bytes,1,rep,name=labels,proto3"`
+	Samples []*remoteWriteSample `protobuf:"bytes,2,rep,name=samples,proto3"`
+}
+
+func (m *remoteWriteSeries) Reset()         { *m = remoteWriteSeries{} }
+func (m *remoteWriteSeries) String() string { return proto.CompactTextString(m) }
+func (*remoteWriteSeries) ProtoMessage()    {}
+
+// remoteWriteLabel is Label message of remote-write protocol
+type remoteWriteLabel struct {
+	Name  string `protobuf:"bytes,1,opt,name=name,proto3"`
+	Value string `protobuf:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *remoteWriteLabel) Reset()         { *m = remoteWriteLabel{} }
+func (m *remoteWriteLabel) String() string { return proto.CompactTextString(m) }
+func (*remoteWriteLabel) ProtoMessage()    {}
+
+// remoteWriteSample is Sample message of remote-write protocol with timestamp in milliseconds
+type remoteWriteSample struct {
+	Value     float64 `protobuf:"fixed64,1,opt,name=value,proto3"`
+	Timestamp int64   `protobuf:"varint,2,opt,name=timestamp,proto3"`
+}
+
+func (m *remoteWriteSample) Reset()         { *m = remoteWriteSample{} }
+func (m *remoteWriteSample) String() string { return proto.CompactTextString(m) }
+func (*remoteWriteSample) ProtoMessage()    {}
+
+// newSeries returns series with name, labels of metric, extra labels and additional label pairs sorted by name
+func newSeries(name string, metric *dto.Metric, extraLabels map[string]string, value float64, labelPairs ...string) *remoteWriteSeries {
+	labels := []*remoteWriteLabel{{Name: "__name__", Value: name}}
+	for _, pair := range metric.GetLabel() {
+		labels = append(labels, &remoteWriteLabel{Name: pair.GetName(), Value: pair.GetValue()})
+	}
+	for labelName, labelValue := range extraLabels {
+		labels = append(labels, &remoteWriteLabel{Name: labelName, Value: labelValue})
+	}
+	for i := 0; i+1 < len(labelPairs); i += 2 {
+		labels = append(labels, &remoteWriteLabel{Name: labelPairs[i], Value: labelPairs[i+1]})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	return &remoteWriteSeries{Labels: labels, Samples: []*remoteWriteSample{{Value: value}}}
+}
+
+// familySeries converts metric family to series in the same way as prometheus does on scraping
+func familySeries(family *dto.MetricFamily, extraLabels map[string]string) []*remoteWriteSeries {
+	var series []*remoteWriteSeries
+	name := family.GetName()
+	for _, metric := range family.GetMetric() {
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			series = append(series, newSeries(name, metric, extraLabels, metric.GetCounter().GetValue()))
+		case dto.MetricType_GAUGE:
+			series = append(series, newSeries(name, metric, extraLabels, metric.GetGauge().GetValue()))
+		case dto.MetricType_UNTYPED:
+			series = append(series, newSeries(name, metric, extraLabels, metric.GetUntyped().GetValue()))
+		case dto.MetricType_SUMMARY:
+			summary := metric.GetSummary()
+			for _, quantile := range summary.GetQuantile() {
+				series = append(series, newSeries(name, metric, extraLabels, quantile.GetValue(),
+					"quantile", strconv.FormatFloat(quantile.GetQuantile(), 'g', -1, 64)))
+			}
+			series = append(series, newSeries(name+"_sum", metric, extraLabels, summary.GetSampleSum()))
+			series = append(series, newSeries(name+"_count", metric, extraLabels, float64(summary.GetSampleCount())))
+		case dto.MetricType_HISTOGRAM:
+			histogram := metric.GetHistogram()
+			for _, bucket := range histogram.GetBucket() {
+				series = append(series, newSeries(name+"_bucket", metric, extraLabels, float64(bucket.GetCumulativeCount()),
+					"le", strconv.FormatFloat(bucket.GetUpperBound(), 'g', -1, 64)))
+			}
+			series = append(series, newSeries(name+"_bucket", metric, extraLabels, float64(histogram.GetSampleCount()), "le", "+Inf"))
+			series = append(series, newSeries(name+"_sum", metric, extraLabels, histogram.GetSampleSum()))
+			series = append(series, newSeries(name+"_count", metric, extraLabels, float64(histogram.GetSampleCount())))
+		}
+	}
+	return series
+}
+
+// newWriteRequest converts metric families to WriteRequest message of remote-write protocol with samples at timestampMs
+func newWriteRequest(families []*dto.MetricFamily, extraLabels map[string]string, timestampMs int64) *remoteWriteRequest {
+	request := &remoteWriteRequest{}
+	for _, family := range families {
+		for _, series := range familySeries(family, extraLabels) {
+			series.Samples[0].Timestamp = timestampMs
+			request.Timeseries = append(request.Timeseries, series)
+		}
+	}
+	return request
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cossacklabs/acra/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// Sources of options with deprecated names
+const (
+	RenamedOptionSourceCLI    = "cli"
+	RenamedOptionSourceConfig = "config"
+)
+
+// RenamedOption is usage of deprecated name of option found in CLI arguments or config
+type RenamedOption struct {
+	OldName string
+	NewName string
+	Source  string
+}
+
+// MigrateArgs returns CLI arguments where deprecated names of options are replaced with new ones and list of replaced
+// options. Arguments after "--" are left as is
+func (schema *ConfigSchema) MigrateArgs(args []string) ([]string, []RenamedOption) {
+	var renamed []RenamedOption
+	migrated := make([]string, len(args))
+	copy(migrated, args)
+	for i, arg := range migrated {
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name := strings.TrimLeft(arg, "-")
+		prefix := arg[:len(arg)-len(name)]
+		value := ""
+		if separator := strings.Index(name, "="); separator >= 0 {
+			name, value = name[:separator], name[separator:]
+		}
+		if newName, ok := schema.Renamed[name]; ok {
+			migrated[i] = prefix + newName + value
+			renamed = append(renamed, RenamedOption{OldName: name, NewName: newName, Source: RenamedOptionSourceCLI})
+		}
+	}
+	return migrated, renamed
+}
+
+// MigrateConfig moves values of options with deprecated names in parsed YAML config to new names and returns list of
+// moved options. Values of new names which are already set aren't overwritten
+func (schema *ConfigSchema) MigrateConfig(yamlConfig map[string]interface{}) []RenamedOption {
+	var renamed []RenamedOption
+	for name, value := range yamlConfig {
+		newName, ok := schema.Renamed[name]
+		if !ok {
+			continue
+		}
+		if _, ok := yamlConfig[newName]; !ok {
+			yamlConfig[newName] = value
+		}
+		delete(yamlConfig, name)
+		renamed = append(renamed, RenamedOption{OldName: name, NewName: newName, Source: RenamedOptionSourceConfig})
+	}
+	sort.Slice(renamed, func(i, j int) bool {
+		return renamed[i].OldName < renamed[j].OldName
+	})
+	return renamed
+}
+
+// CheckRenamedOptions logs warning for each option set with deprecated name. If strict is true then returns
+// ConfigErrors with all such options, so service refuses to start until CLI arguments and configs are updated
+func CheckRenamedOptions(renamed []RenamedOption, strict bool) error {
+	var errs ConfigErrors
+	for _, option := range renamed {
+		log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeWarningDeprecatedOption, "option": option.OldName,
+			"new_option": option.NewName, "source": option.Source}).Warningln("Option is set with deprecated name, use new name instead")
+		errs = append(errs, ConfigError{Option: option.OldName, Message: fmt.Sprintf("deprecated: renamed to '%s'", option.NewName)})
+	}
+	if strict && len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	flag_ "flag"
+	"reflect"
+	"testing"
+)
+
+func testRenamedSchema() *ConfigSchema {
+	flagSet := flag_.NewFlagSet("test", flag_.ContinueOnError)
+	flagSet.String("db_host", "", "host")
+	flagSet.Int("db_port", 5432, "port")
+	flagSet.Bool("tls_enable", false, "tls")
+	RegisterRenamedOption("dbhost", "db_host")
+	RegisterRenamedOption("dbport", "db_port")
+	RegisterRenamedOption("tls", "tls_enable")
+	RegisterRenamedOption("removed", "unknown_option")
+	defer func() {
+		for _, name := range []string{"dbhost", "dbport", "tls", "removed"} {
+			delete(renamedOptions, name)
+		}
+	}()
+	return GenerateConfigSchema(flagSet)
+}
+
+func TestGenerateConfigSchemaRenamed(t *testing.T) {
+	schema := testRenamedSchema()
+	expected := map[string]string{"dbhost": "db_host", "dbport": "db_port", "tls": "tls_enable"}
+	if !reflect.DeepEqual(schema.Renamed, expected) {
+		t.Fatalf("incorrect renamed options %v", schema.Renamed)
+	}
+}
+
+func TestMigrateArgs(t *testing.T) {
+	schema := testRenamedSchema()
+	args := []string{"--dbhost=localhost", "-dbport", "3306", "--tls", "--db_host=127.0.0.1", "--", "--dbport=1"}
+	migrated, renamed := schema.MigrateArgs(args)
+	expectedArgs := []string{"--db_host=localhost", "-db_port", "3306", "--tls_enable", "--db_host=127.0.0.1", "--", "--dbport=1"}
+	if !reflect.DeepEqual(migrated, expectedArgs) {
+		t.Fatalf("incorrect migrated args %v", migrated)
+	}
+	if args[0] != "--dbhost=localhost" {
+		t.Fatal("original args shouldn't be changed")
+	}
+	expectedRenamed := []RenamedOption{
+		{OldName: "dbhost", NewName: "db_host", Source: RenamedOptionSourceCLI},
+		{OldName: "dbport", NewName: "db_port", Source: RenamedOptionSourceCLI},
+		{OldName: "tls", NewName: "tls_enable", Source: RenamedOptionSourceCLI},
+	}
+	if !reflect.DeepEqual(renamed, expectedRenamed) {
+		t.Fatalf("incorrect renamed options %v", renamed)
+	}
+}
+
+func TestMigrateConfig(t *testing.T) {
+	schema := testRenamedSchema()
+	if err := schema.Validate([]byte("dbhost: localhost\ndbport: 3306\n")); err != nil {
+		t.Fatal(err)
+	}
+	err := schema.Validate([]byte("dbhost: localhost\ndb_host: 127.0.0.1\ndbport: port\n"))
+	errs, ok := err.(ConfigErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("expected 2 errors, took %v", err)
+	}
+	if errs[0].Message != "renamed to 'db_host' which is also set" || errs[1].Message != "expected int, took 'port'" {
+		t.Fatalf("incorrect errors %v", errs)
+	}
+
+	yamlConfig := map[string]interface{}{"dbport": 3306, "tls": true, "db_host": "localhost"}
+	renamed := schema.MigrateConfig(yamlConfig)
+	expectedConfig := map[string]interface{}{"db_port": 3306, "tls_enable": true, "db_host": "localhost"}
+	if !reflect.DeepEqual(yamlConfig, expectedConfig) {
+		t.Fatalf("incorrect migrated config %v", yamlConfig)
+	}
+	expectedRenamed := []RenamedOption{
+		{OldName: "dbport", NewName: "db_port", Source: RenamedOptionSourceConfig},
+		{OldName: "tls", NewName: "tls_enable", Source: RenamedOptionSourceConfig},
+	}
+	if !reflect.DeepEqual(renamed, expectedRenamed) {
+		t.Fatalf("incorrect renamed options %v", renamed)
+	}
+}
+
+func TestCheckRenamedOptions(t *testing.T) {
+	renamed := []RenamedOption{{OldName: "dbhost", NewName: "db_host", Source: RenamedOptionSourceCLI}}
+	if err := CheckRenamedOptions(renamed, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckRenamedOptions(nil, true); err != nil {
+		t.Fatal(err)
+	}
+	err := CheckRenamedOptions(renamed, true)
+	if err == nil || err.Error() != "invalid config: option 'dbhost': deprecated: renamed to 'db_host'" {
+		t.Fatalf("expected error for renamed option, took %v", err)
+	}
+}
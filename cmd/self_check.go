@@ -0,0 +1,226 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cossacklabs/acra/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// SelfCheckStatus is result of one startup self-check
+type SelfCheckStatus string
+
+// Statuses of startup self-checks
+const (
+	SelfCheckPass SelfCheckStatus = "PASS"
+	SelfCheckWarn SelfCheckStatus = "WARN"
+	SelfCheckFail SelfCheckStatus = "FAIL"
+	SelfCheckSkip SelfCheckStatus = "SKIP"
+)
+
+// ErrSelfCheckSkipped returned by self-check which isn't applicable for current configuration
+var ErrSelfCheckSkipped = errors.New("check isn't applicable for current configuration")
+
+// SelfCheckWarning wraps error of check which doesn't prevent service from work
+type SelfCheckWarning struct {
+	Err error
+}
+
+func (w SelfCheckWarning) Error() string {
+	return w.Err.Error()
+}
+
+// SelfCheck is one step of startup self-diagnostics. Fatal checks that fail stop the service
+type SelfCheck struct {
+	Name  string
+	Fatal bool
+	// Run returns details of successful check or error. SelfCheckWarning marks non-critical problem, ErrSelfCheckSkipped marks check as skipped
+	Run func() (string, error)
+}
+
+// SelfCheckResult is outcome of SelfCheck
+type SelfCheckResult struct {
+	Name    string
+	Status  SelfCheckStatus
+	Fatal   bool
+	Details string
+}
+
+// SelfCheckReport is outcome of all startup self-checks
+type SelfCheckReport struct {
+	Results []SelfCheckResult
+}
+
+// Passed returns false if any fatal check failed
+func (report *SelfCheckReport) Passed() bool {
+	for _, result := range report.Results {
+		if result.Fatal && result.Status == SelfCheckFail {
+			return false
+		}
+	}
+	return true
+}
+
+// RunSelfChecks runs all checks in order and collects their results
+func RunSelfChecks(checks []SelfCheck) *SelfCheckReport {
+	report := &SelfCheckReport{Results: make([]SelfCheckResult, 0, len(checks))}
+	for _, check := range checks {
+		result := SelfCheckResult{Name: check.Name, Fatal: check.Fatal, Status: SelfCheckPass}
+		details, err := check.Run()
+		switch err.(type) {
+		case nil:
+			result.Details = details
+		case SelfCheckWarning:
+			result.Status = SelfCheckWarn
+			result.Details = err.Error()
+		default:
+			if err == ErrSelfCheckSkipped {
+				result.Status = SelfCheckSkip
+			} else {
+				result.Status = SelfCheckFail
+			}
+			result.Details = err.Error()
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report
+}
+
+// Log logs result of every check and single PASS/FAIL summary
+func (report *SelfCheckReport) Log() {
+	counts := map[SelfCheckStatus]int{}
+	for _, result := range report.Results {
+		counts[result.Status]++
+		entry := log.WithFields(log.Fields{"check": result.Name, "status": result.Status, "fatal": result.Fatal, "details": result.Details})
+		switch result.Status {
+		case SelfCheckFail:
+			entry.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorSelfCheckFailed).Errorln("Self-check failed")
+		case SelfCheckWarn:
+			entry.WithField(logging.FieldKeyEventCode, logging.EventCodeWarningSelfCheck).Warningln("Self-check passed with warning")
+		default:
+			entry.Infoln("Self-check")
+		}
+	}
+	summary := SelfCheckPass
+	if !report.Passed() {
+		summary = SelfCheckFail
+	}
+	entry := log.WithFields(log.Fields{"result": summary, "passed": counts[SelfCheckPass], "warnings": counts[SelfCheckWarn],
+		"failed": counts[SelfCheckFail], "skipped": counts[SelfCheckSkip]})
+	if summary == SelfCheckFail {
+		entry.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorSelfCheckFailed).Errorln("Self-check summary")
+		return
+	}
+	entry.Infoln("Self-check summary")
+}
+
+// CheckMasterKey validates master key returned by loadMasterKey
+func CheckMasterKey(loadMasterKey func() ([]byte, error)) (string, error) {
+	if _, err := loadMasterKey(); err != nil {
+		return "", err
+	}
+	return "master key loaded", nil
+}
+
+// CheckKeysDirReadWrite verifies that keys directory may be listed and that temporary file may be written, read and removed in it
+func CheckKeysDirReadWrite(keysDir string) (string, error) {
+	files, err := ioutil.ReadDir(keysDir)
+	if err != nil {
+		return "", err
+	}
+	testData := []byte("acra self-check")
+	testFile, err := ioutil.TempFile(keysDir, ".self_check")
+	if err != nil {
+		return "", SelfCheckWarning{fmt.Errorf("keys directory is read-only: %v", err)}
+	}
+	defer os.Remove(testFile.Name())
+	_, err = testFile.Write(testData)
+	if closeErr := testFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", SelfCheckWarning{fmt.Errorf("can't write to keys directory: %v", err)}
+	}
+	readData, err := ioutil.ReadFile(testFile.Name())
+	if err != nil {
+		return "", err
+	}
+	if string(readData) != string(testData) {
+		return "", fmt.Errorf("read data differs from written to %s", filepath.Base(testFile.Name()))
+	}
+	return fmt.Sprintf("%d entries, read/write OK", len(files)), nil
+}
+
+// CheckTLSCertificate verifies certificate chain from certPath against system roots and CA from caPath and reports
+// days before expiration. Expired or invalid certificate fails check, certificate which expires within warningDays causes warning
+func CheckTLSCertificate(certPath, caPath string, warningDays int) (string, error) {
+	if certPath == "" {
+		return "", ErrSelfCheckSkipped
+	}
+	chain, err := LoadCertificateChain(certPath)
+	if err != nil {
+		return "", err
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil || roots == nil {
+		roots = x509.NewCertPool()
+	}
+	if caPath != "" {
+		caPem, err := ioutil.ReadFile(caPath)
+		if err != nil {
+			return "", err
+		}
+		if !roots.AppendCertsFromPEM(caPem) {
+			return "", fmt.Errorf("can't add CA certificate from %s", caPath)
+		}
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	now := time.Now()
+	_, verifyErr := chain[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, CurrentTime: now, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	days := daysBefore(chainExpiresAt(chain), now)
+	if verifyErr != nil {
+		return "", fmt.Errorf("certificate %s: %v", chain[0].Subject.CommonName, verifyErr)
+	}
+	details := fmt.Sprintf("certificate %s valid, chain of %d, expires in %d days", chain[0].Subject.CommonName, len(chain), days)
+	if days < warningDays {
+		return "", SelfCheckWarning{errors.New(details)}
+	}
+	return details, nil
+}
+
+// CheckTCPConnectivity verifies that address accepts TCP connections
+func CheckTCPConnectivity(address string, timeout time.Duration) (string, error) {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return "", err
+	}
+	conn.Close()
+	return fmt.Sprintf("%s reachable in %s", address, time.Since(start).Round(time.Millisecond)), nil
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunSelfChecks(t *testing.T) {
+	checks := []SelfCheck{
+		{Name: "pass", Fatal: true, Run: func() (string, error) { return "ok", nil }},
+		{Name: "warn", Fatal: true, Run: func() (string, error) { return "", SelfCheckWarning{errors.New("soon")} }},
+		{Name: "skip", Fatal: true, Run: func() (string, error) { return "", ErrSelfCheckSkipped }},
+		{Name: "non-fatal", Fatal: false, Run: func() (string, error) { return "", errors.New("unreachable") }},
+	}
+	report := RunSelfChecks(checks)
+	expected := []SelfCheckStatus{SelfCheckPass, SelfCheckWarn, SelfCheckSkip, SelfCheckFail}
+	for i, result := range report.Results {
+		if result.Status != expected[i] {
+			t.Errorf("check %s: expected %s, took %s", result.Name, expected[i], result.Status)
+		}
+	}
+	if !report.Passed() {
+		t.Fatal("Expected passed report without fatal failures")
+	}
+	report = RunSelfChecks(append(checks, SelfCheck{Name: "fatal", Fatal: true, Run: func() (string, error) { return "", errors.New("broken") }}))
+	if report.Passed() {
+		t.Fatal("Expected failed report with fatal failure")
+	}
+}
+
+func TestCheckKeysDirReadWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "self_check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := CheckKeysDirReadWrite(dir); err != nil {
+		t.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatal("Self-check left temporary file in keys directory")
+	}
+	if _, err := CheckKeysDirReadWrite(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("Expected error for missing directory")
+	}
+}
+
+func writeSelfSignedCertificate(t *testing.T, dir string, notAfter time.Time) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "acra-test"},
+		NotBefore:             notAfter.Add(-time.Hour * 24 * 365),
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, err := ioutil.TempFile(dir, "cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer certFile.Close()
+	if err := pem.Encode(certFile, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+		t.Fatal(err)
+	}
+	return certFile.Name()
+}
+
+func TestCheckTLSCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "self_check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := CheckTLSCertificate("", "", 30); err != ErrSelfCheckSkipped {
+		t.Fatalf("Expected skipped check without certificate, took %v", err)
+	}
+	validCert := writeSelfSignedCertificate(t, dir, time.Now().Add(time.Hour*24*100))
+	if _, err := CheckTLSCertificate(validCert, validCert, 30); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CheckTLSCertificate(validCert, "", 30); err == nil {
+		t.Fatal("Expected error for certificate signed by unknown authority")
+	}
+	if _, err := CheckTLSCertificate(validCert, validCert, 200); err == nil {
+		t.Fatal("Expected warning for certificate which expires soon")
+	} else if _, ok := err.(SelfCheckWarning); !ok {
+		t.Fatalf("Expected warning, took %v", err)
+	}
+	expiredCert := writeSelfSignedCertificate(t, dir, time.Now().Add(-time.Hour))
+	if _, err := CheckTLSCertificate(expiredCert, expiredCert, 30); err == nil {
+		t.Fatal("Expected error for expired certificate")
+	} else if _, ok := err.(SelfCheckWarning); ok {
+		t.Fatal("Expected failure for expired certificate, took warning")
+	}
+}
+
+func TestCheckTCPConnectivity(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	if _, err := CheckTCPConnectivity(address, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	if _, err := CheckTCPConnectivity(address, time.Second); err == nil {
+		t.Fatal("Expected error for closed port")
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cossacklabs/acra/api"
+	"github.com/cossacklabs/acra/api/client"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/network"
+	log "github.com/sirupsen/logrus"
+)
+
+// DEFAULT_STANDBY_SYNC_INTERVAL is default time in seconds between syncs of warm standby AcraServer with active one
+const DEFAULT_STANDBY_SYNC_INTERVAL = 5
+
+// NewStandbyState returns cached keys of cacheWarmer and failed handshakes of limiter, both may be nil
+func NewStandbyState(cacheWarmer keystore.CacheWarmer, limiter *network.HandshakeLimiter) *api.StandbyState {
+	state := &api.StandbyState{CachedKeys: make([]string, 0), HandshakeFailures: make([]api.HandshakeFailures, 0)}
+	if cacheWarmer != nil {
+		state.CachedKeys = append(state.CachedKeys, cacheWarmer.CachedKeys()...)
+	}
+	for _, failures := range limiter.State() {
+		state.HandshakeFailures = append(state.HandshakeFailures, api.HandshakeFailures{
+			Key: failures.Key, Count: failures.Count, LastFailure: failures.LastFailure, BannedUntil: failures.BannedUntil})
+	}
+	return state
+}
+
+// StandbySync periodically copies state of active AcraServer to warm standby AcraServer: loads keys cached by active
+// one into keystore cache and merges bans of failed handshakes, so failover doesn't reset security state and doesn't
+// start with cold cache
+type StandbySync struct {
+	client      *client.Client
+	cacheWarmer keystore.CacheWarmer
+	limiter     *network.HandshakeLimiter
+	lock        sync.Mutex
+	stop        chan struct{}
+}
+
+// NewStandbySync returns sync with active AcraServer which serves HTTP API at activeAPIURL. Connections to active
+// AcraServer are wrapped by connectionWrapper as connections of client with clientID, so state is imported only from
+// peer which passed transport handshake. cacheWarmer and limiter may be nil if standby doesn't cache keys or doesn't
+// ban failed handshakes
+func NewStandbySync(activeAPIURL string, clientID []byte, connectionWrapper network.ConnectionWrapper, cacheWarmer keystore.CacheWarmer, limiter *network.HandshakeLimiter) *StandbySync {
+	dial := func(networkName, address string) (net.Conn, error) {
+		connection, err := net.Dial(networkName, address)
+		if err != nil {
+			return nil, err
+		}
+		wrappedConnection, err := connectionWrapper.WrapClient(clientID, connection)
+		if err != nil {
+			connection.Close()
+			return nil, err
+		}
+		return wrappedConnection, nil
+	}
+	// API of AcraServer serves one request per connection
+	httpClient := &http.Client{Transport: &http.Transport{Dial: dial, DisableKeepAlives: true}}
+	return &StandbySync{client: client.NewClient(activeAPIURL, httpClient), cacheWarmer: cacheWarmer, limiter: limiter}
+}
+
+// Sync copies state of active AcraServer once
+func (standby *StandbySync) Sync() error {
+	state, err := standby.client.GetStandbyState()
+	if err != nil {
+		return err
+	}
+	loaded := 0
+	if standby.cacheWarmer != nil {
+		loaded = standby.cacheWarmer.WarmUpCache(state.CachedKeys)
+	}
+	failures := make([]network.HandshakeFailureState, 0, len(state.HandshakeFailures))
+	for _, failure := range state.HandshakeFailures {
+		failures = append(failures, network.HandshakeFailureState{
+			Key: failure.Key, Count: failure.Count, LastFailure: failure.LastFailure, BannedUntil: failure.BannedUntil})
+	}
+	standby.limiter.Merge(failures)
+	log.WithFields(log.Fields{"loaded_keys": loaded, "handshake_failures": len(failures)}).Debugln("Synced state of active AcraServer")
+	return nil
+}
+
+// Start syncs state immediately and then every interval in background
+func (standby *StandbySync) Start(interval time.Duration) {
+	standby.syncAndLog()
+	standby.lock.Lock()
+	defer standby.lock.Unlock()
+	if standby.stop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	standby.stop = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				standby.syncAndLog()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops background syncs
+func (standby *StandbySync) Stop() {
+	standby.lock.Lock()
+	defer standby.lock.Unlock()
+	if standby.stop != nil {
+		close(standby.stop)
+		standby.stop = nil
+	}
+}
+
+func (standby *StandbySync) syncAndLog() {
+	if err := standby.Sync(); err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantSyncStandbyState).
+			Warningln("Can't sync state of active AcraServer")
+	}
+}
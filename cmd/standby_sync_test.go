@@ -0,0 +1,118 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/api"
+	"github.com/cossacklabs/acra/network"
+)
+
+// testCacheWarmer remembers names of warmed up keys
+type testCacheWarmer struct {
+	keys []string
+}
+
+func (warmer *testCacheWarmer) CachedKeys() []string {
+	return warmer.keys
+}
+
+func (warmer *testCacheWarmer) WarmUpCache(names []string) int {
+	warmer.keys = append(warmer.keys, names...)
+	return len(names)
+}
+
+// failedHandshakeWrapper rejects peer on handshake and remembers client id which it was called with
+type failedHandshakeWrapper struct {
+	network.RawConnectionWrapper
+	clientID []byte
+}
+
+var errTestHandshake = errors.New("peer isn't authenticated")
+
+func (wrapper *failedHandshakeWrapper) WrapClient(id []byte, conn net.Conn) (net.Conn, error) {
+	wrapper.clientID = id
+	return conn, errTestHandshake
+}
+
+func TestStandbySync(t *testing.T) {
+	activeWarmer := &testCacheWarmer{keys: []string{"client_storage", "client_storage.pub"}}
+	activeLimiter := network.NewHandshakeLimiter(1, time.Minute, time.Minute)
+	activeLimiter.AddFailure("10.0.0.1")
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != api.PathStandbyState {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(writer).Encode(NewStandbyState(activeWarmer, activeLimiter))
+	}))
+	defer server.Close()
+
+	standbyWarmer := &testCacheWarmer{}
+	standbyLimiter := network.NewHandshakeLimiter(1, time.Minute, time.Minute)
+	standby := NewStandbySync(server.URL, nil, &network.RawConnectionWrapper{}, standbyWarmer, standbyLimiter)
+	if err := standby.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(standbyWarmer.keys, activeWarmer.keys) {
+		t.Fatalf("Incorrect warmed up keys %v", standbyWarmer.keys)
+	}
+	if !standbyLimiter.IsBanned("10.0.0.1") {
+		t.Fatal("Ban of active AcraServer wasn't copied")
+	}
+
+	// standby without cache and bans only checks availability of active AcraServer
+	if err := NewStandbySync(server.URL, nil, &network.RawConnectionWrapper{}, nil, nil).Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewStandbySync(server.URL+"/unknown", nil, &network.RawConnectionWrapper{}, nil, nil).Sync(); err == nil {
+		t.Fatal("Expected error from unavailable state")
+	}
+	state := NewStandbyState(nil, nil)
+	if state.CachedKeys == nil || state.HandshakeFailures == nil {
+		t.Fatal("Empty state should be encoded with empty lists")
+	}
+}
+
+func TestStandbySyncUnauthenticatedPeer(t *testing.T) {
+	activeLimiter := network.NewHandshakeLimiter(1, time.Minute, time.Minute)
+	activeLimiter.AddFailure("10.0.0.1")
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		json.NewEncoder(writer).Encode(NewStandbyState(nil, activeLimiter))
+	}))
+	defer server.Close()
+
+	wrapper := &failedHandshakeWrapper{}
+	standbyLimiter := network.NewHandshakeLimiter(1, time.Minute, time.Minute)
+	if err := NewStandbySync(server.URL, []byte("standby"), wrapper, nil, standbyLimiter).Sync(); err == nil {
+		t.Fatal("Expected error from peer which failed handshake")
+	}
+	if string(wrapper.clientID) != "standby" {
+		t.Fatalf("Connection wasn't wrapped as client, took client id %s", wrapper.clientID)
+	}
+	if standbyLimiter.IsBanned("10.0.0.1") {
+		t.Fatal("Ban was imported from unauthenticated peer")
+	}
+}
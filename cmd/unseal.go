@@ -0,0 +1,135 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	url_ "net/url"
+	"time"
+
+	"github.com/cossacklabs/acra/api"
+	"github.com/cossacklabs/acra/keystore/escrow"
+	"github.com/cossacklabs/acra/network"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxUnsealRequestSize limits body of unseal request, share of master key is much smaller
+const maxUnsealRequestSize = 4096
+
+// unsealAPITimeout limits reading of request and writing of response of unseal HTTP API
+const unsealAPITimeout = 10 * time.Second
+
+// ErrUnsealAPINotLocal returned if unseal HTTP API is configured to listen address reachable from other hosts
+var ErrUnsealAPINotLocal = errors.New("unseal HTTP API isn't authenticated and listens only unix socket or loopback address")
+
+// ValidateUnsealAPIAddress returns ErrUnsealAPINotLocal if connectionString isn't unix socket or tcp address of
+// loopback interface, so shares of master key can't be submitted from other hosts
+func ValidateUnsealAPIAddress(connectionString string) error {
+	url, err := url_.Parse(connectionString)
+	if err != nil {
+		return err
+	}
+	if url.Scheme == "unix" {
+		return nil
+	}
+	host := url.Hostname()
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return ErrUnsealAPINotLocal
+}
+
+// NewUnsealHandler returns handler of unseal HTTP API which returns status of unsealer on GET request and adds share
+// of master key from api.UnsealRequest on POST request
+func NewUnsealHandler(unsealer *escrow.Unsealer) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(api.PathUnseal, func(writer http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			body, err := ioutil.ReadAll(http.MaxBytesReader(writer, req.Body, maxUnsealRequestSize))
+			if err != nil {
+				writeUnsealError(writer, http.StatusBadRequest, "can't read request")
+				return
+			}
+			request := api.UnsealRequest{}
+			if err := json.Unmarshal(body, &request); err != nil {
+				writeUnsealError(writer, http.StatusBadRequest, "invalid json")
+				return
+			}
+			if err := unsealer.AddShare(request.Share); err != nil {
+				log.WithError(err).Warningln("Share of master key rejected")
+				status := http.StatusBadRequest
+				if err == escrow.ErrAlreadyUnsealed {
+					status = http.StatusConflict
+				}
+				writeUnsealError(writer, status, err.Error())
+				return
+			}
+			log.Infoln("Accepted share of master key")
+		default:
+			writer.Header().Add("Allow", http.MethodGet)
+			writer.Header().Add("Allow", http.MethodPost)
+			writeUnsealError(writer, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		status := unsealer.Status()
+		writeUnsealResponse(writer, http.StatusOK, api.UnsealStatus{Sealed: status.Sealed, Threshold: status.Threshold, Progress: status.Progress})
+	})
+	return mux
+}
+
+func writeUnsealResponse(writer http.ResponseWriter, status int, value interface{}) {
+	body, _ := json.Marshal(value)
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	writer.Write(body)
+}
+
+func writeUnsealError(writer http.ResponseWriter, status int, message string) {
+	writeUnsealResponse(writer, status, api.Error{Error: message})
+}
+
+// ServeUnsealAPI serves unseal HTTP API at connectionString in background until returned listener is closed. Only unix
+// socket and loopback addresses are accepted
+func ServeUnsealAPI(connectionString string, unsealer *escrow.Unsealer) (net.Listener, error) {
+	if err := ValidateUnsealAPIAddress(connectionString); err != nil {
+		return nil, err
+	}
+	listener, err := network.Listen(connectionString)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		server := &http.Server{Handler: NewUnsealHandler(unsealer), ReadTimeout: unsealAPITimeout, WriteTimeout: unsealAPITimeout}
+		err := server.Serve(listener)
+		// listener is closed after unsealing
+		if !unsealer.Status().Sealed {
+			log.Infoln("Master key unsealed, unseal HTTP API stopped")
+			return
+		}
+		log.WithError(err).Errorln("Error from unseal HTTP API")
+	}()
+	return listener, nil
+}
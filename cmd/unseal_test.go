@@ -0,0 +1,105 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cossacklabs/acra/api/client"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/keystore/escrow"
+)
+
+func TestUnsealHandler(t *testing.T) {
+	masterKey, err := keystore.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, err := escrow.SplitMasterKey(masterKey, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsealer := escrow.NewUnsealer()
+	server := httptest.NewServer(NewUnsealHandler(unsealer))
+	defer server.Close()
+	apiClient := client.NewClient(server.URL, nil)
+
+	status, err := apiClient.GetUnsealStatus()
+	if err != nil || !status.Sealed || status.Progress != 0 {
+		t.Fatalf("unexpected status %v, %v", status, err)
+	}
+	_, err = apiClient.Unseal("invalid")
+	if statusError, ok := err.(*client.StatusError); !ok || statusError.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected bad request, took %v", err)
+	}
+	status, err = apiClient.Unseal(shares[2])
+	if err != nil || !status.Sealed || status.Threshold != 2 || status.Progress != 1 {
+		t.Fatalf("unexpected status %v, %v", status, err)
+	}
+	status, err = apiClient.Unseal(shares[0])
+	if err != nil || status.Sealed {
+		t.Fatalf("unexpected status %v, %v", status, err)
+	}
+	select {
+	case <-unsealer.Unsealed():
+	default:
+		t.Fatal("unsealer wasn't unsealed")
+	}
+	_, err = apiClient.Unseal(shares[1])
+	if statusError, ok := err.(*client.StatusError); !ok || statusError.StatusCode != http.StatusConflict {
+		t.Fatalf("expected conflict, took %v", err)
+	}
+}
+
+func TestServeUnsealAPIAddress(t *testing.T) {
+	testcases := []struct {
+		connectionString string
+		err              error
+	}{
+		{"unix:///var/run/acra-unseal.sock", nil},
+		{"tcp://127.0.0.1:9495", nil},
+		{"tcp://localhost:9495", nil},
+		{"http://[::1]:9495", nil},
+		{"tcp://0.0.0.0:9495", ErrUnsealAPINotLocal},
+		{"tcp://:9495", ErrUnsealAPINotLocal},
+		{"tcp://10.0.0.1:9495", ErrUnsealAPINotLocal},
+		{"tcp://acra.example.com:9495", ErrUnsealAPINotLocal},
+	}
+	for i, testcase := range testcases {
+		if err := ValidateUnsealAPIAddress(testcase.connectionString); err != testcase.err {
+			t.Errorf("[%d] Expected %v for %s, took %v", i, testcase.err, testcase.connectionString, err)
+		}
+	}
+	if _, err := ServeUnsealAPI("tcp://0.0.0.0:0", escrow.NewUnsealer()); err != ErrUnsealAPINotLocal {
+		t.Fatalf("Expected ErrUnsealAPINotLocal, took %v", err)
+	}
+	directory, err := ioutil.TempDir("", "unseal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	listener, err := ServeUnsealAPI("unix://"+filepath.Join(directory, "unseal.sock"), escrow.NewUnsealer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+}
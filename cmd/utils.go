@@ -42,6 +42,8 @@ import (
 var (
 	config     = flag_.String("config_file", "", "path to config")
 	dumpconfig = flag_.Bool("dump_config", false, "dump config")
+	// strictFlags turns deprecation warnings about renamed options into errors
+	strictFlags = flag_.Bool("strict_flags", false, "Refuse to start if options are set with deprecated names instead of mapping them to new names with warning")
 )
 
 func init() {
@@ -172,7 +174,11 @@ func PrintDefaults() {
 
 // GenerateYaml generates YAML file from CLI params
 func GenerateYaml(output io.Writer, useDefault bool) {
-	flag_.CommandLine.VisitAll(func(flag *flag_.Flag) {
+	generateYaml(flag_.CommandLine, output, useDefault)
+}
+
+func generateYaml(flagSet *flag_.FlagSet, output io.Writer, useDefault bool) {
+	flagSet.VisitAll(func(flag *flag_.Flag) {
 		var s string
 		if useDefault {
 			s = fmt.Sprintf("# %v\n%v: %v\n", flag.Usage, flag.Name, flag.DefValue)
@@ -242,8 +248,20 @@ func DumpConfig(configPath, serviceName string, useDefault bool) error {
 func Parse(configPath, serviceName string) error {
 	/*load from yaml config and cli. if dumpconfig option pass than generate config and exit*/
 	log.Debugf("Parsing config from path %v", configPath)
+	if len(os.Args) > 1 && os.Args[1] == ConfigCommandName {
+		if err := RunConfigCommand(os.Stdout, flag_.CommandLine, serviceName, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	schema := GenerateConfigSchema(flag_.CommandLine)
+	if err := schema.ValidateArgs(os.Args[1:]); err != nil {
+		return err
+	}
+	cliArgs, renamed := schema.MigrateArgs(os.Args[1:])
 	// first parse using bultin flag
-	err := flag_.CommandLine.Parse(os.Args[1:])
+	err := flag_.CommandLine.Parse(cliArgs)
 	if err != nil {
 		return err
 	}
@@ -268,11 +286,16 @@ func Parse(configPath, serviceName string) error {
 			if err != nil {
 				return err
 			}
+			// reject unknown and misspelled options instead of silently ignoring them
+			if err := schema.Validate(data); err != nil {
+				return fmt.Errorf("%s: %v", configPath, err)
+			}
 			yamlConfig := map[string]interface{}{}
 			err = yaml.Unmarshal([]byte(data), &yamlConfig)
 			if err != nil {
 				return err
 			}
+			renamed = append(renamed, schema.MigrateConfig(yamlConfig)...)
 			setArgs := make(map[string]bool)
 			flag_.Visit(func(flag *flag_.Flag) {
 				setArgs[flag.Name] = true
@@ -296,6 +319,10 @@ func Parse(configPath, serviceName string) error {
 	if err != nil {
 		return err
 	}
+	// strict_flags may be set in config, so it's checked after all options are parsed
+	if err := CheckRenamedOptions(renamed, *strictFlags); err != nil {
+		return err
+	}
 	if *dumpconfig {
 		DumpConfig(configPath, serviceName, true)
 		os.Exit(0)
@@ -0,0 +1,49 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	flag_ "flag"
+	"fmt"
+	"strings"
+
+	"github.com/cossacklabs/acra/zone"
+)
+
+// ZoneIDGeneratorLoader configures format of ids of new zones with flags
+type ZoneIDGeneratorLoader struct {
+	format *string
+	prefix *string
+}
+
+// RegisterZoneIDGeneratorFlags registers flags of zone id format in default flag set
+func RegisterZoneIDGeneratorFlags() *ZoneIDGeneratorLoader {
+	return &ZoneIDGeneratorLoader{
+		format: flag_.String("zone_id_format", zone.DefaultIDFormat, fmt.Sprintf("Format of ids of new zones, one of: %s", strings.Join(zone.IDFormats(), ", "))),
+		prefix: flag_.String("zone_id_prefix", "", "Tenant prefix of ids of new zones for zone_id_format=prefixed"),
+	}
+}
+
+// SetIDGenerator sets generator of zone ids configured by flags for all new zones
+func (loader *ZoneIDGeneratorLoader) SetIDGenerator() error {
+	generator, err := zone.NewIDGenerator(*loader.format, zone.IDGeneratorParams{Prefix: *loader.prefix})
+	if err != nil {
+		return err
+	}
+	zone.SetIDGenerator(generator)
+	return nil
+}
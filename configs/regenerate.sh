@@ -1,11 +1,5 @@
 #!/usr/bin/env bash
-go run ./cmd/acra-server/*.go --dump_config
-go run ./cmd/acra-connector/*.go --dump_config
-go run ./cmd/acra-translator/*.go --dump_config
-go run ./cmd/acra-addzone/*.go --dump_config
-go run ./cmd/acra-webconfig/*.go --dump_config
-go run ./cmd/acra-rollback/*.go --dump_config
-go run ./cmd/acra-keymaker/*.go --dump_config
-go run ./cmd/acra-poisonrecordmaker/*.go --dump_config
-go run ./cmd/acra-authmanager/*.go --dump_config
-go run ./cmd/acra-rotate/*.go --dump_config
+for service in acra-server acra-connector acra-translator acra-addzone acra-webconfig acra-rollback acra-backfill acra-replay acra-keyescrow \
+    acra-keymaker acra-poisonrecordmaker acra-authmanager acra-rotate acra-scaffold acra-keysync acra-keys acra-crypt; do
+    go run ./cmd/${service}/*.go config generate > configs/${service}.yaml
+done
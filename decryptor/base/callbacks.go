@@ -18,6 +18,7 @@ package base
 
 import (
 	"container/list"
+	"github.com/cossacklabs/acra/logging"
 	log "github.com/sirupsen/logrus"
 	"os"
 	"os/exec"
@@ -33,7 +34,7 @@ type StopCallback struct{}
 
 // Call exists service with log
 func (*StopCallback) Call() error {
-	log.Warningln("detected poison record, exit")
+	log.WithField(logging.FieldKeyEventCode, logging.EventCodePoisonRecordCallback).Warningln("detected poison record, exit")
 	os.Exit(1)
 	log.Errorln("executed code after os.Exit")
 	return nil
@@ -51,7 +52,8 @@ func NewExecuteScriptCallback(path string) *ExecuteScriptCallback {
 
 // Call runs from scriptPath on detecting poison record
 func (callback *ExecuteScriptCallback) Call() error {
-	log.Warningf("detected poison record, run script - %v", callback.scriptPath)
+	log.WithField(logging.FieldKeyEventCode, logging.EventCodePoisonRecordCallback).
+		Warningf("detected poison record, run script - %v", callback.scriptPath)
 	err := exec.Command(callback.scriptPath).Start()
 	if err != nil {
 		return err
@@ -0,0 +1,278 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Limits of tags saved from startup parameters of client, which are controlled by client and shouldn't take much memory
+const (
+	MaxConnectionTags      = 32
+	MaxConnectionTagLength = 256
+)
+
+// ConnectionStats accumulates traffic and processing counters of one client connection. Counters are updated from
+// client and database goroutines concurrently. All methods are safe to call on nil ConnectionStats
+type ConnectionStats struct {
+	// counters first to keep 64-bit alignment for atomic operations on 32-bit platforms
+	bytesIn     int64
+	bytesOut    int64
+	queries     int64
+	rows        int64
+	decryptions int64
+	// sizes of decrypted values before and after decryption
+	encryptedBytes int64
+	plaintextBytes int64
+	// rows of oldest pending statement
+	statementRows int64
+
+	ID            uint64
+	ClientID      []byte
+	RemoteAddress string
+	StartedAt     time.Time
+	// Payload aggregates sizes of decrypted values of all connections, may be nil
+	Payload *PayloadStats
+	// Statements aggregates executions of statements of all connections, may be nil
+	Statements *StatementStats
+
+	// statements forwarded to database which responses aren't finished yet in order of sending
+	pendingMutex      sync.Mutex
+	pendingStatements []pendingStatement
+
+	// application and other startup parameters of client which distinguish its connections with same client id
+	tagsMutex          sync.Mutex
+	application        string
+	tags               map[string]string
+	applicationQueries prometheus.Counter
+}
+
+type pendingStatement struct {
+	// query is normalized only when it's accounted or shown, so tracking of current query costs nothing per query
+	query     string
+	startedAt time.Time
+}
+
+// ConnectionStatsSnapshot is state of ConnectionStats at some moment
+type ConnectionStatsSnapshot struct {
+	ID             uint64            `json:"id"`
+	ClientID       string            `json:"client_id"`
+	RemoteAddress  string            `json:"remote_address"`
+	StartedAt      time.Time         `json:"started_at"`
+	BytesIn        int64             `json:"bytes_in"`
+	BytesOut       int64             `json:"bytes_out"`
+	Queries        int64             `json:"queries"`
+	Rows           int64             `json:"rows"`
+	Decryptions    int64             `json:"decryptions"`
+	EncryptedBytes int64             `json:"encrypted_bytes"`
+	PlaintextBytes int64             `json:"plaintext_bytes"`
+	Application    string            `json:"application,omitempty"`
+	Tags           map[string]string `json:"tags,omitempty"`
+	// SessionZoneID is zone of connection's session, counters don't track it, so it's set by owner of session
+	SessionZoneID string `json:"session_zone_id,omitempty"`
+	AgeSeconds    int64  `json:"age_seconds"`
+	// CurrentQueryFingerprint is fingerprint of oldest query forwarded to database which response isn't finished yet
+	CurrentQueryFingerprint string     `json:"current_query_fingerprint,omitempty"`
+	CurrentQueryStartedAt   *time.Time `json:"current_query_started_at,omitempty"`
+}
+
+// NewConnectionStats returns new ConnectionStats for connection from remoteAddress
+func NewConnectionStats(id uint64, clientID []byte, remoteAddress string) *ConnectionStats {
+	return &ConnectionStats{ID: id, ClientID: clientID, RemoteAddress: remoteAddress, StartedAt: time.Now()}
+}
+
+// AddQuery increases count of queries sent by client
+func (stats *ConnectionStats) AddQuery() {
+	if stats != nil {
+		atomic.AddInt64(&stats.queries, 1)
+		stats.tagsMutex.Lock()
+		counter := stats.applicationQueries
+		stats.tagsMutex.Unlock()
+		if counter != nil {
+			counter.Inc()
+		}
+	}
+}
+
+// SetTags saves name of client's application and other startup parameters sent by client on connection. Count and
+// length of tags are limited by MaxConnectionTags and MaxConnectionTagLength
+func (stats *ConnectionStats) SetTags(application string, tags map[string]string) {
+	if stats == nil {
+		return
+	}
+	limited := make(map[string]string, len(tags))
+	for name, value := range tags {
+		if len(limited) == MaxConnectionTags {
+			break
+		}
+		limited[truncateTag(name)] = truncateTag(value)
+	}
+	application = truncateTag(application)
+	labels := []string{string(stats.ClientID), ApplicationLabelValue(application)}
+	ApplicationConnectionsCounter.WithLabelValues(labels...).Inc()
+	stats.tagsMutex.Lock()
+	stats.application = application
+	stats.tags = limited
+	stats.applicationQueries = ApplicationQueriesCounter.WithLabelValues(labels...)
+	stats.tagsMutex.Unlock()
+}
+
+// Application returns name of client's application from startup parameters or empty string if client didn't send it
+func (stats *ConnectionStats) Application() string {
+	if stats == nil {
+		return ""
+	}
+	stats.tagsMutex.Lock()
+	defer stats.tagsMutex.Unlock()
+	return stats.application
+}
+
+func truncateTag(value string) string {
+	if len(value) > MaxConnectionTagLength {
+		return value[:MaxConnectionTagLength]
+	}
+	return value
+}
+
+// AddRow increases count of rows returned to client
+func (stats *ConnectionStats) AddRow() {
+	if stats != nil {
+		atomic.AddInt64(&stats.rows, 1)
+		atomic.AddInt64(&stats.statementRows, 1)
+	}
+}
+
+// StartStatement starts time measurement of query forwarded to database. Empty query marks request which response
+// should be skipped, e.g. execution of prepared statement without text
+func (stats *ConnectionStats) StartStatement(query string) {
+	if stats == nil {
+		return
+	}
+	statement := pendingStatement{query: query, startedAt: time.Now()}
+	stats.pendingMutex.Lock()
+	stats.pendingStatements = append(stats.pendingStatements, statement)
+	stats.pendingMutex.Unlock()
+}
+
+// EndStatement accounts oldest pending statement which response is finished. Responses without started statement
+// (e.g. of prepared statements) are ignored. Statement isn't accounted if statements aren't tracked
+func (stats *ConnectionStats) EndStatement() {
+	if stats == nil {
+		return
+	}
+	rows := atomic.SwapInt64(&stats.statementRows, 0)
+	stats.pendingMutex.Lock()
+	if len(stats.pendingStatements) == 0 {
+		stats.pendingMutex.Unlock()
+		return
+	}
+	statement := stats.pendingStatements[0]
+	stats.pendingStatements = stats.pendingStatements[1:]
+	stats.pendingMutex.Unlock()
+	if statement.query != "" && stats.Statements != nil {
+		stats.Statements.Add(stats.ClientID, NormalizeStatement(statement.query), rows, time.Since(statement.startedAt))
+	}
+}
+
+// AddDecryption increases count of values decrypted for client
+func (stats *ConnectionStats) AddDecryption() {
+	if stats != nil {
+		atomic.AddInt64(&stats.decryptions, 1)
+	}
+}
+
+// AddDecryptedPayload increases count of decrypted values and accounts size of value of table before and after
+// decryption. table is empty if database doesn't send table of columns
+func (stats *ConnectionStats) AddDecryptedPayload(table string, encryptedSize, plaintextSize int) {
+	if stats != nil {
+		atomic.AddInt64(&stats.decryptions, 1)
+		atomic.AddInt64(&stats.encryptedBytes, int64(encryptedSize))
+		atomic.AddInt64(&stats.plaintextBytes, int64(plaintextSize))
+		stats.Payload.Add(stats.ClientID, table, encryptedSize, plaintextSize)
+	}
+}
+
+// currentStatement returns oldest pending statement with text
+func (stats *ConnectionStats) currentStatement() (pendingStatement, bool) {
+	stats.pendingMutex.Lock()
+	defer stats.pendingMutex.Unlock()
+	for _, statement := range stats.pendingStatements {
+		if statement.query != "" {
+			return statement, true
+		}
+	}
+	return pendingStatement{}, false
+}
+
+// Snapshot returns current values of counters
+func (stats *ConnectionStats) Snapshot() ConnectionStatsSnapshot {
+	stats.tagsMutex.Lock()
+	application, tags := stats.application, stats.tags
+	stats.tagsMutex.Unlock()
+	snapshot := ConnectionStatsSnapshot{
+		ID:             stats.ID,
+		ClientID:       string(stats.ClientID),
+		RemoteAddress:  stats.RemoteAddress,
+		StartedAt:      stats.StartedAt,
+		BytesIn:        atomic.LoadInt64(&stats.bytesIn),
+		BytesOut:       atomic.LoadInt64(&stats.bytesOut),
+		Queries:        atomic.LoadInt64(&stats.queries),
+		Rows:           atomic.LoadInt64(&stats.rows),
+		Decryptions:    atomic.LoadInt64(&stats.decryptions),
+		EncryptedBytes: atomic.LoadInt64(&stats.encryptedBytes),
+		PlaintextBytes: atomic.LoadInt64(&stats.plaintextBytes),
+		Application:    application,
+		Tags:           tags,
+		AgeSeconds:     int64(time.Since(stats.StartedAt).Seconds()),
+	}
+	if statement, ok := stats.currentStatement(); ok {
+		snapshot.CurrentQueryFingerprint = StatementFingerprint(NormalizeStatement(statement.query))
+		startedAt := statement.startedAt
+		snapshot.CurrentQueryStartedAt = &startedAt
+	}
+	return snapshot
+}
+
+// WrapConnection returns connection which counts bytes read from client as incoming and written to client as outgoing
+func (stats *ConnectionStats) WrapConnection(connection net.Conn) net.Conn {
+	if stats == nil {
+		return connection
+	}
+	return &statsConnection{Conn: connection, stats: stats}
+}
+
+type statsConnection struct {
+	net.Conn
+	stats *ConnectionStats
+}
+
+func (connection *statsConnection) Read(b []byte) (int, error) {
+	n, err := connection.Conn.Read(b)
+	atomic.AddInt64(&connection.stats.bytesIn, int64(n))
+	return n, err
+}
+
+func (connection *statsConnection) Write(b []byte) (int, error) {
+	n, err := connection.Conn.Write(b)
+	atomic.AddInt64(&connection.stats.bytesOut, int64(n))
+	return n, err
+}
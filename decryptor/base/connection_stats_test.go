@@ -0,0 +1,120 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base_test
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+)
+
+func TestConnectionStats(t *testing.T) {
+	stats := base.NewConnectionStats(1, []byte("client"), "127.0.0.1:1234")
+	client, server := net.Pipe()
+	defer client.Close()
+	connection := stats.WrapConnection(server)
+	defer connection.Close()
+
+	go client.Write([]byte("query"))
+	if _, err := io.ReadFull(connection, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	go io.ReadFull(client, make([]byte, 3))
+	if _, err := connection.Write([]byte("row")); err != nil {
+		t.Fatal(err)
+	}
+	stats.AddQuery()
+	stats.AddRow()
+	stats.AddRow()
+	stats.AddDecryption()
+	stats.Payload = base.NewPayloadStats()
+	stats.AddDecryptedPayload("table", 100, 4)
+
+	snapshot := stats.Snapshot()
+	if snapshot.ID != 1 || snapshot.ClientID != "client" || snapshot.RemoteAddress != "127.0.0.1:1234" {
+		t.Fatalf("Incorrect connection info: %+v", snapshot)
+	}
+	if snapshot.BytesIn != 5 || snapshot.BytesOut != 3 || snapshot.Queries != 1 || snapshot.Rows != 2 || snapshot.Decryptions != 2 ||
+		snapshot.EncryptedBytes != 100 || snapshot.PlaintextBytes != 4 {
+		t.Fatalf("Incorrect counters: %+v", snapshot)
+	}
+
+	var nilStats *base.ConnectionStats
+	nilStats.AddQuery()
+	nilStats.AddDecryptedPayload("table", 100, 4)
+	if nilStats.WrapConnection(server) != server {
+		t.Fatal("nil ConnectionStats shouldn't wrap connection")
+	}
+}
+
+func TestConnectionStatsTags(t *testing.T) {
+	stats := base.NewConnectionStats(1, []byte("client"), "127.0.0.1:1234")
+	tags := map[string]string{"application_name": "reporting-service", "long": strings.Repeat("a", base.MaxConnectionTagLength+1)}
+	stats.SetTags("reporting-service", tags)
+	snapshot := stats.Snapshot()
+	if snapshot.Application != "reporting-service" || stats.Application() != "reporting-service" {
+		t.Fatalf("Incorrect application: %+v", snapshot)
+	}
+	if len(snapshot.Tags) != 2 || snapshot.Tags["application_name"] != "reporting-service" || len(snapshot.Tags["long"]) != base.MaxConnectionTagLength {
+		t.Fatalf("Incorrect tags: %+v", snapshot.Tags)
+	}
+
+	tags = make(map[string]string)
+	for i := 0; i < base.MaxConnectionTags+1; i++ {
+		tags[strconv.Itoa(i)] = "value"
+	}
+	stats.SetTags("", tags)
+	if snapshot := stats.Snapshot(); len(snapshot.Tags) != base.MaxConnectionTags || snapshot.Application != "" {
+		t.Fatalf("Tags weren't limited: %+v", snapshot)
+	}
+
+	var nilStats *base.ConnectionStats
+	nilStats.SetTags("application", tags)
+	if nilStats.Application() != "" {
+		t.Fatal("Expected empty application of nil ConnectionStats")
+	}
+}
+
+func TestConnectionStatsCurrentQuery(t *testing.T) {
+	// statements aren't aggregated but current query is tracked
+	stats := base.NewConnectionStats(1, []byte("client"), "127.0.0.1:1234")
+	if snapshot := stats.Snapshot(); snapshot.CurrentQueryFingerprint != "" || snapshot.CurrentQueryStartedAt != nil {
+		t.Fatalf("Expected no current query: %+v", snapshot)
+	}
+	stats.StartStatement("")
+	stats.StartStatement("select * from t where id = 1")
+	stats.StartStatement("select 1")
+	snapshot := stats.Snapshot()
+	expected := base.StatementFingerprint(base.NormalizeStatement("select * from t where id = 2"))
+	if snapshot.CurrentQueryFingerprint != expected || snapshot.CurrentQueryStartedAt == nil {
+		t.Fatalf("Incorrect current query: %+v", snapshot)
+	}
+	stats.EndStatement()
+	stats.EndStatement()
+	snapshot = stats.Snapshot()
+	if snapshot.CurrentQueryFingerprint != base.StatementFingerprint(base.NormalizeStatement("select 1")) {
+		t.Fatalf("Incorrect current query: %+v", snapshot)
+	}
+	stats.EndStatement()
+	if snapshot := stats.Snapshot(); snapshot.CurrentQueryFingerprint != "" {
+		t.Fatalf("Expected no current query after response: %+v", snapshot)
+	}
+}
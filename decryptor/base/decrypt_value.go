@@ -0,0 +1,100 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/zone"
+	log "github.com/sirupsen/logrus"
+)
+
+// DecryptValue decrypts value of column by proxies of protocols which send values separately (Cassandra, MSSQL,
+// MongoDB) and returns decrypted value and true if value is AcraStruct. In zone mode value which isn't AcraStruct is
+// matched as zone id of next value. table may be empty if protocol doesn't send it, stats may be nil
+func DecryptValue(ctx context.Context, decryptor Decryptor, stats *ConnectionStats, table, column string, value []byte, logger *log.Entry) ([]byte, bool, error) {
+	if decryptor.IsWithZone() && !decryptor.IsMatchedZone() {
+		if len(value) >= zone.ZoneIDBlockLength {
+			decryptor.MatchZoneBlock(value)
+		}
+		return value, false, nil
+	}
+	if len(value) < KeyBlockLength {
+		return value, false, nil
+	}
+	if index, _ := decryptor.BeginTagIndex(value); index != 0 {
+		return value, false, nil
+	}
+	defer decryptor.ResetZoneMatch()
+	logger = logger.WithFields(log.Fields{"table": table, "column": column})
+	if GetPoisonContainment().IsActive() {
+		logger.Debugln("Decryption suspended after detection of poison record, leave data as is")
+		return value, false, nil
+	}
+	if GetLatencyBudget().ServeCiphertext(table, column) {
+		logger.Debugln("Leave value of low-sensitivity column encrypted, decryption latency budget exceeded")
+		return value, false, nil
+	}
+	limiter := GetDecryptionLimiter()
+	if err := limiter.AcquireContext(ctx); err != nil {
+		if err == ctx.Err() {
+			return value, false, err
+		}
+		logger.WithError(err).Warningln("Can't decrypt AcraStruct, limit of simultaneous decryptions exceeded")
+		return value, false, nil
+	}
+	decryptor.Reset()
+	decrypted, err := decryptor.DecryptBlock(value)
+	limiter.Release()
+	if err != nil {
+		AcrastructDecryptionCounter.WithLabelValues(DecryptionTypeFail).Inc()
+		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorDecryptorCantDecryptBinary).
+			Warningln("Can't decrypt AcraStruct")
+		return value, false, handlePoisonRecord(decryptor, value, logger)
+	}
+	AcrastructDecryptionCounter.WithLabelValues(DecryptionTypeSuccess).Inc()
+	stats.AddDecryptedPayload(table, len(value), len(decrypted))
+	return decrypted, true, nil
+}
+
+// handlePoisonRecord calls callbacks of poison records if value which wasn't decrypted is poison record
+func handlePoisonRecord(decryptor Decryptor, value []byte, logger *log.Entry) error {
+	if !decryptor.IsPoisonRecordCheckOn() {
+		return nil
+	}
+	decryptor.Reset()
+	skippedBegin, err := decryptor.SkipBeginInBlock(value)
+	if err != nil {
+		return nil
+	}
+	poisoned, err := decryptor.CheckPoisonRecord(bytes.NewReader(skippedBegin))
+	if err != nil {
+		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorDecryptorCantHandleRecognizedPoisonRecord).
+			Errorln("Can't check on poison record")
+		return err
+	}
+	if !poisoned {
+		return nil
+	}
+	logger.WithField(logging.FieldKeyEventCode, logging.EventCodePoisonRecordDetected).Warningln("Recognized poison record")
+	if callbacks := decryptor.GetPoisonCallbackStorage(); callbacks.HasCallbacks() {
+		return callbacks.Call()
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// testValueDecryptor decrypts values which start with TAG_BEGIN by removing tag
+type testValueDecryptor struct {
+	Decryptor
+}
+
+func (*testValueDecryptor) IsWithZone() bool            { return false }
+func (*testValueDecryptor) Reset()                      {}
+func (*testValueDecryptor) ResetZoneMatch()             {}
+func (*testValueDecryptor) IsPoisonRecordCheckOn() bool { return false }
+func (*testValueDecryptor) BeginTagIndex(block []byte) (int, int) {
+	return bytes.Index(block, TAG_BEGIN), len(TAG_BEGIN)
+}
+func (*testValueDecryptor) DecryptBlock(block []byte) ([]byte, error) {
+	return block[len(TAG_BEGIN):], nil
+}
+
+func TestDecryptValueLatencyBudget(t *testing.T) {
+	budget, err := NewLatencyBudget(time.Millisecond, time.Minute, "ssn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	budget.Observe(time.Hour)
+	SetLatencyBudget(budget)
+	defer SetLatencyBudget(nil)
+
+	value := append(append([]byte{}, TAG_BEGIN...), bytes.Repeat([]byte("a"), KeyBlockLength)...)
+	logger := log.NewEntry(log.StandardLogger())
+	testcases := []struct {
+		table     string
+		column    string
+		decrypted bool
+	}{
+		{"users", "ssn", false},
+		// MongoDB doesn't send table
+		{"", "ssn", false},
+		{"users", "email", true},
+	}
+	for _, tcase := range testcases {
+		result, decrypted, err := DecryptValue(context.Background(), &testValueDecryptor{}, nil, tcase.table, tcase.column, value, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decrypted != tcase.decrypted {
+			t.Fatalf("%s.%s: expected decrypted %v, took %v", tcase.table, tcase.column, tcase.decrypted, decrypted)
+		}
+		if !decrypted && !bytes.Equal(result, value) {
+			t.Fatalf("%s.%s: value changed without decryption", tcase.table, tcase.column)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"context"
+	"time"
+
+	"github.com/cossacklabs/acra/acraerrors"
+)
+
+// Errors returned when DecryptionLimiter sheds load
+var (
+	ErrDecryptionQueueFull   = acraerrors.New(acraerrors.CodeDecryptionQueueFull, "too many AcraStructs wait for decryption")
+	ErrDecryptionWaitTimeout = acraerrors.New(acraerrors.CodeDecryptionWaitTimeout, "timeout on waiting for decryption")
+)
+
+// DecryptionLimiter limits count of simultaneous decryptions. Decryptions over limit wait in bounded queue and are
+// rejected if queue is full or waiting took more than timeout. nil DecryptionLimiter doesn't limit anything
+type DecryptionLimiter struct {
+	slots       chan struct{}
+	admission   chan struct{}
+	waitTimeout time.Duration
+}
+
+// NewDecryptionLimiter returns limiter which allows maxConcurrent simultaneous decryptions and queueSize waiting
+// decryptions. waitTimeout == 0 means waiting without timeout
+func NewDecryptionLimiter(maxConcurrent, queueSize int, waitTimeout time.Duration) *DecryptionLimiter {
+	return &DecryptionLimiter{
+		slots:       make(chan struct{}, maxConcurrent),
+		admission:   make(chan struct{}, maxConcurrent+queueSize),
+		waitTimeout: waitTimeout,
+	}
+}
+
+// Acquire waits free slot for decryption. Release must be called after decryption if Acquire returned nil
+func (limiter *DecryptionLimiter) Acquire() error {
+	return limiter.AcquireContext(context.Background())
+}
+
+// AcquireContext waits free slot for decryption until ctx is done. Error of ctx is returned if it's already done, so
+// decryptions of canceled connections and requests don't load keys even if decryptions aren't limited. Release must
+// be called after decryption if AcquireContext returned nil
+func (limiter *DecryptionLimiter) AcquireContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if limiter == nil {
+		return nil
+	}
+	select {
+	case limiter.admission <- struct{}{}:
+	default:
+		DecryptionLimiterRejectedCounter.WithLabelValues(DecryptionRejectQueueFull).Inc()
+		return ErrDecryptionQueueFull
+	}
+	var timeout <-chan time.Time
+	if limiter.waitTimeout != 0 {
+		timer := time.NewTimer(limiter.waitTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	// time of waiting in queue is accounted by latency budget to serve low-sensitivity columns encrypted on overload
+	startWaiting := time.Now()
+	select {
+	case limiter.slots <- struct{}{}:
+		GetLatencyBudget().Observe(time.Since(startWaiting))
+		return nil
+	case <-timeout:
+		<-limiter.admission
+		GetLatencyBudget().Observe(time.Since(startWaiting))
+		DecryptionLimiterRejectedCounter.WithLabelValues(DecryptionRejectTimeout).Inc()
+		return ErrDecryptionWaitTimeout
+	case <-ctx.Done():
+		<-limiter.admission
+		return ctx.Err()
+	}
+}
+
+// Release frees slot taken by Acquire
+func (limiter *DecryptionLimiter) Release() {
+	if limiter == nil {
+		return
+	}
+	<-limiter.slots
+	<-limiter.admission
+}
+
+// decryptionLimiter used by all decryptors of process
+var decryptionLimiter *DecryptionLimiter
+
+// SetDecryptionLimiter sets limiter for all decryptions of process, nil turns off limits
+func SetDecryptionLimiter(limiter *DecryptionLimiter) {
+	decryptionLimiter = limiter
+}
+
+// GetDecryptionLimiter returns limiter for all decryptions of process or nil if decryptions aren't limited
+func GetDecryptionLimiter() *DecryptionLimiter {
+	return decryptionLimiter
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package base_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+)
+
+func TestDecryptionLimiter(t *testing.T) {
+	var nilLimiter *base.DecryptionLimiter
+	if err := nilLimiter.Acquire(); err != nil {
+		t.Fatal("nil limiter must not limit decryptions")
+	}
+	nilLimiter.Release()
+
+	limiter := base.NewDecryptionLimiter(1, 1, time.Millisecond*10)
+	if err := limiter.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	// second decryption waits in queue and rejected by timeout
+	if err := limiter.Acquire(); err != base.ErrDecryptionWaitTimeout {
+		t.Fatalf("Expected ErrDecryptionWaitTimeout, took %v", err)
+	}
+
+	if err := base.NewDecryptionLimiter(0, 0, 0).Acquire(); err != base.ErrDecryptionQueueFull {
+		t.Fatalf("Expected ErrDecryptionQueueFull, took %v", err)
+	}
+
+	waitResult := make(chan error)
+	queuedLimiter := base.NewDecryptionLimiter(1, 1, 0)
+	if err := queuedLimiter.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		waitResult <- queuedLimiter.Acquire()
+	}()
+	// wait until second decryption takes place in queue
+	time.Sleep(time.Millisecond * 10)
+	if err := queuedLimiter.Acquire(); err != base.ErrDecryptionQueueFull {
+		t.Fatalf("Expected ErrDecryptionQueueFull, took %v", err)
+	}
+	queuedLimiter.Release()
+	if err := <-waitResult; err != nil {
+		t.Fatalf("Expected acquired slot after release, took %v", err)
+	}
+	queuedLimiter.Release()
+}
+
+func TestDecryptionLimiterContext(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	var nilLimiter *base.DecryptionLimiter
+	if err := nilLimiter.AcquireContext(canceled); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, took %v", err)
+	}
+
+	limiter := base.NewDecryptionLimiter(1, 1, 0)
+	if err := limiter.AcquireContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	waitResult := make(chan error)
+	go func() {
+		waitResult <- limiter.AcquireContext(ctx)
+	}()
+	// wait until second decryption takes place in queue
+	time.Sleep(time.Millisecond * 10)
+	cancel()
+	if err := <-waitResult; err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, took %v", err)
+	}
+	// canceled decryption left queue, so next one waits in queue instead of rejection
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if err := limiter.AcquireContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded, took %v", err)
+	}
+	limiter.Release()
+}
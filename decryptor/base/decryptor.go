@@ -21,8 +21,9 @@ limitations under the License.
 package base
 
 import (
-	"errors"
+	"bytes"
 	"fmt"
+	"github.com/cossacklabs/acra/acraerrors"
 	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/acra/zone"
 	"github.com/cossacklabs/themis/gothemis/keys"
@@ -31,8 +32,8 @@ import (
 
 // Errors show errors while recognizing of valid AcraStructs.
 var (
-	ErrFakeAcraStruct = errors.New("fake acra struct")
-	ErrPoisonRecord   = errors.New("poison record detected")
+	ErrFakeAcraStruct = acraerrors.New(acraerrors.CodeFakeAcraStruct, "fake acra struct")
+	ErrPoisonRecord   = acraerrors.New(acraerrors.CodePoisonRecord, "poison record detected")
 )
 
 /*
@@ -104,6 +105,8 @@ type Decryptor interface {
 	// return private key for current connected client for decrypting symmetric
 	// key with secure message
 	GetPrivateKey() (*keys.PrivateKey, error)
+	// return all versions of private key from current to oldest to decrypt AcraStructs encrypted before key rotation
+	GetPrivateKeys() ([]*keys.PrivateKey, error)
 	TurnOnPoisonRecordCheck(bool)
 	IsPoisonRecordCheckOn() bool
 	// register storage of callbacks for detected poison records
@@ -129,6 +132,35 @@ type Decryptor interface {
 	MatchZoneInBlock([]byte)
 }
 
+// ReadSymmetricKeyRotated decrypts symmetric key of AcraStruct with privateKey and, if it fails, with previous versions
+// of rotated private key. On success reader is positioned after symmetric key block
+func ReadSymmetricKeyRotated(decryptor Decryptor, privateKey *keys.PrivateKey, reader *bytes.Reader) ([]byte, error) {
+	start, err := reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	symmetricKey, _, err := decryptor.ReadSymmetricKey(privateKey, reader)
+	if err == nil {
+		return symmetricKey, nil
+	}
+	privateKeys, keysErr := decryptor.GetPrivateKeys()
+	if keysErr != nil {
+		return nil, err
+	}
+	for _, previousKey := range privateKeys {
+		if bytes.Equal(previousKey.Value, privateKey.Value) {
+			continue
+		}
+		if _, err := reader.Seek(start, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if symmetricKey, _, keyErr := decryptor.ReadSymmetricKey(previousKey, reader); keyErr == nil {
+			return symmetricKey, nil
+		}
+	}
+	return nil, err
+}
+
 // CheckReadWrite check that n == expectedN and err != nil
 func CheckReadWrite(n, expectedN int, err error) error {
 	if err != nil {
@@ -0,0 +1,206 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"errors"
+	"math/rand"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cossacklabs/acra/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// DLPDetector finds sensitive data of some kind in plaintext value
+type DLPDetector interface {
+	Name() string
+	Detect(value []byte) bool
+}
+
+// RegexpDLPDetector detects values which contain match of pattern accepted by validator
+type RegexpDLPDetector struct {
+	name     string
+	pattern  *regexp.Regexp
+	validate func(match []byte) bool
+}
+
+// NewRegexpDLPDetector returns detector with name which matches pattern. validate checks each match to filter false
+// positives, nil accepts all matches
+func NewRegexpDLPDetector(name string, pattern *regexp.Regexp, validate func(match []byte) bool) *RegexpDLPDetector {
+	return &RegexpDLPDetector{name: name, pattern: pattern, validate: validate}
+}
+
+// Name returns name of detector used in logs and metrics
+func (detector *RegexpDLPDetector) Name() string {
+	return detector.name
+}
+
+// Detect returns true if value contains valid match of pattern
+func (detector *RegexpDLPDetector) Detect(value []byte) bool {
+	for _, match := range detector.pattern.FindAll(value, -1) {
+		if detector.validate == nil || detector.validate(match) {
+			return true
+		}
+	}
+	return false
+}
+
+// Names of built-in DLP detectors
+const (
+	DLPDetectorCreditCard = "credit_card"
+	DLPDetectorSSN        = "ssn"
+)
+
+var (
+	creditCardPattern = regexp.MustCompile(`\b(?:\d{13,19}|\d{4}(?:[ -]\d{4}){3}|\d{4}[ -]\d{6}[ -]\d{4,5})\b`)
+	ssnPattern        = regexp.MustCompile(`\b(\d{3})-(\d{2})-(\d{4})\b`)
+)
+
+// NewCreditCardDetector returns detector of payment card numbers which pass Luhn check: 13-19 digits without
+// separators, four groups of 4 digits or groups of 4-6-5 digits separated by spaces or dashes
+func NewCreditCardDetector() DLPDetector {
+	return NewRegexpDLPDetector(DLPDetectorCreditCard, creditCardPattern, isLuhnValid)
+}
+
+// NewSSNDetector returns detector of US social security numbers in format AAA-GG-SSSS without numbers which are never
+// assigned: area 000, 666 or 9xx, group 00 or serial 0000
+func NewSSNDetector() DLPDetector {
+	return NewRegexpDLPDetector(DLPDetectorSSN, ssnPattern, func(match []byte) bool {
+		parts := ssnPattern.FindSubmatch(match)
+		area, group, serial := string(parts[1]), string(parts[2]), string(parts[3])
+		return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+	})
+}
+
+// isLuhnValid returns true if digits of number pass Luhn checksum, other characters are ignored
+func isLuhnValid(number []byte) bool {
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		if number[i] < '0' || number[i] > '9' {
+			continue
+		}
+		digit := int(number[i] - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// ErrUnknownDLPDetector returned for name of detector which isn't registered
+var ErrUnknownDLPDetector = errors.New("unknown DLP detector")
+
+var dlpDetectors = struct {
+	sync.Mutex
+	factories map[string]func() DLPDetector
+}{factories: map[string]func() DLPDetector{
+	DLPDetectorCreditCard: NewCreditCardDetector,
+	DLPDetectorSSN:        NewSSNDetector,
+}}
+
+// RegisterDLPDetector registers factory of detector available by name in NewDLPDetectors
+func RegisterDLPDetector(name string, factory func() DLPDetector) {
+	dlpDetectors.Lock()
+	defer dlpDetectors.Unlock()
+	dlpDetectors.factories[name] = factory
+}
+
+// NewDLPDetectors returns detectors registered with comma-separated names
+func NewDLPDetectors(names string) ([]DLPDetector, error) {
+	dlpDetectors.Lock()
+	defer dlpDetectors.Unlock()
+	var detectors []DLPDetector
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		factory, ok := dlpDetectors.factories[name]
+		if !ok {
+			log.WithField("detector", name).Errorln("Unknown DLP detector")
+			return nil, ErrUnknownDLPDetector
+		}
+		detectors = append(detectors, factory())
+	}
+	return detectors, nil
+}
+
+// DLPDetectionsCounter counts values of sampled results in which sensitive data was detected
+var DLPDetectionsCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "acraserver_dlp_detections_total",
+		Help: "number of plaintext values of sampled results in which DLP detector found sensitive data",
+	}, []string{"detector", PayloadTableLabel})
+
+func init() {
+	prometheus.MustRegister(DLPDetectionsCounter)
+}
+
+// DLPSampler samples fraction of rows of query results and scans their plaintext values with detectors to find
+// sensitive data which is stored outside of encrypted columns. Values are scanned as received from database, so
+// AcraStructs don't match and only data stored in plaintext is reported. Values are never logged
+type DLPSampler struct {
+	rate      float64
+	detectors []DLPDetector
+	lock      sync.Mutex
+	random    *rand.Rand
+}
+
+// NewDLPSampler returns sampler which scans rate (0..1) of rows with detectors
+func NewDLPSampler(rate float64, detectors []DLPDetector) *DLPSampler {
+	return &DLPSampler{rate: rate, detectors: detectors, random: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Sample returns true if next row should be scanned. Nil sampler never samples
+func (sampler *DLPSampler) Sample() bool {
+	if sampler == nil || sampler.rate <= 0 || len(sampler.detectors) == 0 {
+		return false
+	}
+	if sampler.rate >= 1 {
+		return true
+	}
+	sampler.lock.Lock()
+	defer sampler.lock.Unlock()
+	return sampler.random.Float64() < sampler.rate
+}
+
+// Scan runs detectors on value of column of table (empty if unknown) and reports each detection. Returns names of
+// detectors which found sensitive data
+func (sampler *DLPSampler) Scan(value []byte, table, column string, logger *log.Entry) []string {
+	var detected []string
+	for _, detector := range sampler.detectors {
+		if !detector.Detect(value) {
+			continue
+		}
+		detected = append(detected, detector.Name())
+		DLPDetectionsCounter.WithLabelValues(detector.Name(), table).Inc()
+		logger.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeWarningDLPSensitiveDataDetected,
+			"detector": detector.Name(), "table": table, "column": column, "value_length": len(value)}).
+			Warningln("Sensitive data detected in plaintext value of query result")
+	}
+	return detected
+}
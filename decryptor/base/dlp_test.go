@@ -0,0 +1,99 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestDLPDetectors(t *testing.T) {
+	creditCard := NewCreditCardDetector()
+	for _, value := range []string{"4111111111111111", "card: 4111 1111 1111 1111.", "5500-0000-0000-0004"} {
+		if !creditCard.Detect([]byte(value)) {
+			t.Fatalf("Card number not detected in '%s'", value)
+		}
+	}
+	for _, value := range []string{"4111111111111112", "order 123456", "41111111111111110000000", ""} {
+		if creditCard.Detect([]byte(value)) {
+			t.Fatalf("Unexpected card number in '%s'", value)
+		}
+	}
+	ssn := NewSSNDetector()
+	for _, value := range []string{"123-45-6789", "ssn=078-05-1120;"} {
+		if !ssn.Detect([]byte(value)) {
+			t.Fatalf("SSN not detected in '%s'", value)
+		}
+	}
+	for _, value := range []string{"000-12-3456", "666-12-3456", "912-34-5678", "123-00-4567", "123-45-0000", "1123-45-6789", "2020-01-02"} {
+		if ssn.Detect([]byte(value)) {
+			t.Fatalf("Unexpected SSN in '%s'", value)
+		}
+	}
+}
+
+func TestNewDLPDetectors(t *testing.T) {
+	RegisterDLPDetector("test_email", func() DLPDetector {
+		return NewRegexpDLPDetector("test_email", regexp.MustCompile(`\S+@\S+`), nil)
+	})
+	detectors, err := NewDLPDetectors("credit_card, ssn,test_email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, detector := range detectors {
+		names = append(names, detector.Name())
+	}
+	if !reflect.DeepEqual(names, []string{DLPDetectorCreditCard, DLPDetectorSSN, "test_email"}) {
+		t.Fatalf("Unexpected detectors %v", names)
+	}
+	if _, err := NewDLPDetectors("ssn,passport"); err != ErrUnknownDLPDetector {
+		t.Fatalf("Expected ErrUnknownDLPDetector, took %v", err)
+	}
+}
+
+func TestDLPSampler(t *testing.T) {
+	var nilSampler *DLPSampler
+	if nilSampler.Sample() {
+		t.Fatal("Nil sampler sampled row")
+	}
+	detectors := []DLPDetector{NewCreditCardDetector(), NewSSNDetector()}
+	if NewDLPSampler(0, detectors).Sample() || !NewDLPSampler(1, detectors).Sample() {
+		t.Fatal("Unexpected sampling with rate 0 or 1")
+	}
+	sampler := NewDLPSampler(0.5, detectors)
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		if sampler.Sample() {
+			sampled++
+		}
+	}
+	if sampled < 350 || sampled > 650 {
+		t.Fatalf("Sampled %d of 1000 rows with rate 0.5", sampled)
+	}
+	logger := log.NewEntry(log.StandardLogger())
+	detected := sampler.Scan([]byte("4111111111111111 123-45-6789"), "users", "notes", logger)
+	if !reflect.DeepEqual(detected, []string{DLPDetectorCreditCard, DLPDetectorSSN}) {
+		t.Fatalf("Unexpected detections %v", detected)
+	}
+	if detected := sampler.Scan([]byte("plain text"), "users", "notes", logger); len(detected) != 0 {
+		t.Fatalf("Unexpected detections %v", detected)
+	}
+}
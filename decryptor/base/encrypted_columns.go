@@ -0,0 +1,80 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"github.com/cossacklabs/acra/sqldialect"
+	"github.com/xwb1989/sqlparser"
+)
+
+// EncryptedColumns decides which columns of query's result may contain AcraStructs, so other columns aren't scanned
+// for begin tag. Zones are still matched in all columns
+type EncryptedColumns interface {
+	// IsEncryptedColumn returns true if column may contain AcraStructs. table is empty if database doesn't send it
+	IsEncryptedColumn(table, column string) bool
+}
+
+// HasNamedResultColumns returns true if query is SELECT which result columns are named as columns of tables: select
+// expressions are stars or columns without aliases and only tables are selected from. Databases which don't send
+// original names of columns with results (PostgreSQL) name other columns with aliases or by expressions, so such
+// columns can't be matched with configuration of encrypted columns and should be scanned
+func HasNamedResultColumns(query string) bool {
+	statement, _, err := sqldialect.Parse(query)
+	if err != nil {
+		return false
+	}
+	selectStatement, ok := statement.(*sqlparser.Select)
+	if !ok {
+		return false
+	}
+	for _, expr := range selectStatement.SelectExprs {
+		switch expr := expr.(type) {
+		case *sqlparser.StarExpr:
+		case *sqlparser.AliasedExpr:
+			column, ok := expr.Expr.(*sqlparser.ColName)
+			if !ok || (!expr.As.IsEmpty() && !expr.As.Equal(column.Name)) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return isTablesOnly(selectStatement.From)
+}
+
+// isTablesOnly returns true if FROM clause contains only tables and their joins without subqueries
+func isTablesOnly(tables sqlparser.TableExprs) bool {
+	for _, table := range tables {
+		switch table := table.(type) {
+		case *sqlparser.AliasedTableExpr:
+			if _, ok := table.Expr.(sqlparser.TableName); !ok {
+				return false
+			}
+		case *sqlparser.JoinTableExpr:
+			if !isTablesOnly(sqlparser.TableExprs{table.LeftExpr, table.RightExpr}) {
+				return false
+			}
+		case *sqlparser.ParenTableExpr:
+			if !isTablesOnly(table.Exprs) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"testing"
+)
+
+func TestHasNamedResultColumns(t *testing.T) {
+	testcases := []struct {
+		query string
+		named bool
+	}{
+		{"SELECT secret, id FROM users", true},
+		{"SELECT * FROM users WHERE id = 1", true},
+		{"SELECT users.*, orders.secret FROM users JOIN orders ON users.id = orders.user_id", true},
+		{"SELECT u.secret FROM users AS u", true},
+		{"SELECT secret AS secret FROM users", true},
+		{"SELECT secret AS s FROM users", false},
+		{"SELECT id AS secret FROM users", false},
+		{"SELECT CONCAT(secret, '') FROM users", false},
+		{"SELECT s FROM (SELECT secret AS s FROM users) AS t", false},
+		{"SELECT secret FROM users UNION SELECT comment FROM orders", false},
+		{"UPDATE users SET secret = 'a'", false},
+		{"not a query", false},
+	}
+	for i, testcase := range testcases {
+		if named := HasNamedResultColumns(testcase.query); named != testcase.named {
+			t.Errorf("[%d] Expected %v for %s, took %v", i, testcase.named, testcase.query, named)
+		}
+	}
+}
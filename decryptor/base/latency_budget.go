@@ -0,0 +1,162 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cossacklabs/acra/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrInvalidLatencyBudgetColumn returned if list of low-sensitivity columns contains empty name
+var ErrInvalidLatencyBudgetColumn = errors.New("invalid column in list of low-sensitivity columns")
+
+// latencySmoothing is weight of new observation in smoothed latency, smaller weight ignores short spikes
+const latencySmoothing = 5
+
+// LatencyBudgetOpenGauge is 1 while values of low-sensitivity columns are served encrypted due to exceeded budget
+var LatencyBudgetOpenGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "acraserver_decryption_latency_budget_open",
+		Help: "1 if decryption queue latency exceeded budget and low-sensitivity columns are served encrypted, 0 otherwise",
+	})
+
+// LatencyBudgetSkippedCounter counts values served encrypted because decryption queue latency exceeded budget
+var LatencyBudgetSkippedCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "acraserver_decryption_latency_budget_skipped_total",
+		Help: "number of values of low-sensitivity columns served encrypted because decryption queue latency exceeded budget",
+	}, []string{PayloadTableLabel, "column"})
+
+func init() {
+	prometheus.MustRegister(LatencyBudgetOpenGauge, LatencyBudgetSkippedCounter)
+}
+
+// LatencyBudget is circuit which keeps result streams from stalling on overloaded decryption queue. It tracks
+// smoothed time which decryptions wait for free slot of DecryptionLimiter and opens when it exceeds budget. While
+// circuit is open, values of low-sensitivity columns are served as is (encrypted) without waiting in queue, so slots
+// stay for other columns. Circuit closes after cooldown and opens again if latency is still over budget.
+// nil LatencyBudget never opens
+type LatencyBudget struct {
+	budget    time.Duration
+	cooldown  time.Duration
+	columns   map[string]bool
+	lock      sync.Mutex
+	average   time.Duration
+	openUntil time.Time
+}
+
+// NewLatencyBudget returns closed circuit for budget of queue latency. columns is comma-separated list of
+// low-sensitivity columns as "table.column" or "column" for column of any table. PostgreSQL doesn't send tables of
+// result columns, so only "column" form matches its results
+func NewLatencyBudget(budget, cooldown time.Duration, columns string) (*LatencyBudget, error) {
+	latencyBudget := &LatencyBudget{budget: budget, cooldown: cooldown, columns: make(map[string]bool)}
+	for _, column := range strings.Split(columns, ",") {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if column == "" || strings.HasPrefix(column, ".") || strings.HasSuffix(column, ".") {
+			return nil, ErrInvalidLatencyBudgetColumn
+		}
+		latencyBudget.columns[column] = true
+	}
+	return latencyBudget, nil
+}
+
+// Observe accounts time which decryption waited in queue and opens circuit if smoothed latency exceeds budget
+func (latencyBudget *LatencyBudget) Observe(latency time.Duration) {
+	if latencyBudget == nil {
+		return
+	}
+	latencyBudget.lock.Lock()
+	defer latencyBudget.lock.Unlock()
+	latencyBudget.average += (latency - latencyBudget.average) / latencySmoothing
+	if latencyBudget.average <= latencyBudget.budget || latencyBudget.isOpen() {
+		return
+	}
+	latencyBudget.openUntil = time.Now().Add(latencyBudget.cooldown)
+	LatencyBudgetOpenGauge.Set(1)
+	log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeWarningDecryptionLatencyBudgetExceeded,
+		"latency": latencyBudget.average.String(), "budget": latencyBudget.budget.String(),
+		"until": latencyBudget.openUntil.Format(time.RFC3339)}).
+		Warningln("Decryption queue latency exceeded budget, low-sensitivity columns are served encrypted")
+}
+
+// isOpen returns true while cooldown lasts and closes circuit after it. Smoothed latency is reset to budget on
+// closing, so next observation decides whether circuit opens again. Should be called under lock
+func (latencyBudget *LatencyBudget) isOpen() bool {
+	if latencyBudget.openUntil.IsZero() {
+		return false
+	}
+	if time.Now().Before(latencyBudget.openUntil) {
+		return true
+	}
+	latencyBudget.openUntil = time.Time{}
+	latencyBudget.average = latencyBudget.budget
+	LatencyBudgetOpenGauge.Set(0)
+	log.Infoln("Decryption latency budget cooldown finished, low-sensitivity columns are decrypted again")
+	return false
+}
+
+// IsOpen returns true if decryption queue latency exceeded budget and cooldown didn't finish yet
+func (latencyBudget *LatencyBudget) IsOpen() bool {
+	if latencyBudget == nil {
+		return false
+	}
+	latencyBudget.lock.Lock()
+	defer latencyBudget.lock.Unlock()
+	return latencyBudget.isOpen()
+}
+
+// IsLowSensitivity returns true if column of table is configured as low-sensitivity. table may be empty if protocol
+// doesn't send it
+func (latencyBudget *LatencyBudget) IsLowSensitivity(table, column string) bool {
+	if latencyBudget == nil || column == "" {
+		return false
+	}
+	column = strings.ToLower(column)
+	if latencyBudget.columns[column] {
+		return true
+	}
+	return table != "" && latencyBudget.columns[strings.ToLower(table)+"."+column]
+}
+
+// ServeCiphertext returns true if value of column should be served as is (encrypted) instead of decryption because
+// circuit is open and column is low-sensitivity. Such values are counted in metrics
+func (latencyBudget *LatencyBudget) ServeCiphertext(table, column string) bool {
+	if !latencyBudget.IsLowSensitivity(table, column) || !latencyBudget.IsOpen() {
+		return false
+	}
+	LatencyBudgetSkippedCounter.WithLabelValues(table, column).Inc()
+	return true
+}
+
+// latencyBudget used by all decryptors of process
+var latencyBudget *LatencyBudget
+
+// SetLatencyBudget sets latency budget for all decryptions of process, nil turns it off
+func SetLatencyBudget(budget *LatencyBudget) {
+	latencyBudget = budget
+}
+
+// GetLatencyBudget returns latency budget for all decryptions of process or nil if it's turned off
+func GetLatencyBudget() *LatencyBudget {
+	return latencyBudget
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+)
+
+func TestLatencyBudget(t *testing.T) {
+	var nilBudget *base.LatencyBudget
+	nilBudget.Observe(time.Hour)
+	if nilBudget.IsOpen() || nilBudget.ServeCiphertext("users", "comment") {
+		t.Fatal("nil latency budget must not open")
+	}
+
+	if _, err := base.NewLatencyBudget(time.Millisecond, time.Second, "users.comment,,nickname"); err != base.ErrInvalidLatencyBudgetColumn {
+		t.Fatalf("Expected ErrInvalidLatencyBudgetColumn, took %v", err)
+	}
+	budget, err := base.NewLatencyBudget(time.Millisecond, time.Millisecond*50, "Users.Comment, nickname")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		table, column  string
+		lowSensitivity bool
+	}{
+		{"users", "comment", true},
+		{"orders", "comment", false},
+		{"", "comment", false},
+		{"orders", "NickName", true},
+		{"", "nickname", true},
+		{"users", "email", false},
+	}
+	for _, testCase := range testCases {
+		if budget.IsLowSensitivity(testCase.table, testCase.column) != testCase.lowSensitivity {
+			t.Fatalf("Incorrect sensitivity of %s.%s", testCase.table, testCase.column)
+		}
+		if budget.ServeCiphertext(testCase.table, testCase.column) {
+			t.Fatal("Closed latency budget must not serve ciphertext")
+		}
+	}
+
+	// short waits keep circuit closed
+	budget.Observe(time.Microsecond)
+	if budget.IsOpen() {
+		t.Fatal("Latency budget opened under budget")
+	}
+	budget.Observe(time.Millisecond * 100)
+	if !budget.IsOpen() {
+		t.Fatal("Latency budget didn't open over budget")
+	}
+	for _, testCase := range testCases {
+		if budget.ServeCiphertext(testCase.table, testCase.column) != testCase.lowSensitivity {
+			t.Fatalf("Incorrect decision for %s.%s of open latency budget", testCase.table, testCase.column)
+		}
+	}
+	time.Sleep(time.Millisecond * 60)
+	if budget.IsOpen() {
+		t.Fatal("Latency budget didn't close after cooldown")
+	}
+	// latency after cooldown under budget keeps circuit closed
+	budget.Observe(0)
+	if budget.IsOpen() {
+		t.Fatal("Latency budget opened again under budget")
+	}
+}
+
+func TestLatencyBudgetObservesDecryptionQueue(t *testing.T) {
+	budget, err := base.NewLatencyBudget(time.Millisecond, time.Second, "comment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base.SetLatencyBudget(budget)
+	defer base.SetLatencyBudget(nil)
+
+	limiter := base.NewDecryptionLimiter(1, 1, time.Millisecond*20)
+	if err := limiter.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	defer limiter.Release()
+	if budget.IsOpen() {
+		t.Fatal("Latency budget opened without waiting in queue")
+	}
+	if err := limiter.Acquire(); err != base.ErrDecryptionWaitTimeout {
+		t.Fatalf("Expected ErrDecryptionWaitTimeout, took %v", err)
+	}
+	if !budget.IsOpen() {
+		t.Fatal("Latency budget didn't open after long waiting in queue")
+	}
+}
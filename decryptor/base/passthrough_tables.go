@@ -0,0 +1,117 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"strings"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/sqldialect"
+	"github.com/xwb1989/sqlparser"
+	"gopkg.in/yaml.v2"
+)
+
+// ErrInvalidPassthroughTablesConfig returned if configuration contains empty or malformed table names
+var ErrInvalidPassthroughTablesConfig = acraerrors.New(acraerrors.CodeInvalidPassthroughTablesConfig, "invalid passthrough tables configuration")
+
+// passthroughAnyTable used in configuration as table name to mark all tables of database as passthrough
+const passthroughAnyTable = "*"
+
+// PassthroughTablesConfig lists tables which never contain encrypted data. Each item is "table" (table in any
+// database), "database.table" or "database.*" (all tables of database)
+type PassthroughTablesConfig struct {
+	Tables []string `yaml:"tables"`
+}
+
+// PassthroughTables decides whether query works only with tables which never contain encrypted data. Such queries
+// are forwarded to database without AcraCensor checks and their results are returned without searching AcraStructs
+type PassthroughTables struct {
+	tables    map[string]bool
+	databases map[string]bool
+}
+
+// LoadPassthroughTables parses configuration of passthrough tables in YAML format
+func LoadPassthroughTables(data []byte) (*PassthroughTables, error) {
+	config := &PassthroughTablesConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	tables := &PassthroughTables{tables: make(map[string]bool), databases: make(map[string]bool)}
+	for _, name := range config.Tables {
+		parts := strings.Split(strings.ToLower(strings.TrimSpace(name)), ".")
+		for _, part := range parts {
+			if part == "" {
+				return nil, ErrInvalidPassthroughTablesConfig
+			}
+		}
+		switch {
+		case len(parts) == 1 && parts[0] != passthroughAnyTable:
+			tables.tables[parts[0]] = true
+		case len(parts) == 2 && parts[1] == passthroughAnyTable:
+			tables.databases[parts[0]] = true
+		case len(parts) == 2 && parts[0] != passthroughAnyTable:
+			tables.tables[parts[0]+"."+parts[1]] = true
+		default:
+			return nil, ErrInvalidPassthroughTablesConfig
+		}
+	}
+	return tables, nil
+}
+
+// isPassthroughTable returns true if table configured as passthrough. database is empty for unqualified table names
+// and then only tables configured without database match
+func (tables *PassthroughTables) isPassthroughTable(database, table string) bool {
+	database, table = strings.ToLower(database), strings.ToLower(table)
+	if tables.tables[table] {
+		return true
+	}
+	if database == "" {
+		return false
+	}
+	return tables.databases[database] || tables.tables[database+"."+table]
+}
+
+// IsPassthrough returns true if query uses at least one table and all used tables are passthrough. Queries which
+// can't be parsed aren't passthrough. Safe to call on nil PassthroughTables
+func (tables *PassthroughTables) IsPassthrough(query string) bool {
+	if tables == nil {
+		return false
+	}
+	statement, _, err := sqldialect.Parse(query)
+	if err != nil {
+		return false
+	}
+	hasTables := false
+	passthrough := true
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if !passthrough {
+			return false, nil
+		}
+		// qualifiers of columns may be aliases, tables are checked where they are declared
+		if _, ok := node.(*sqlparser.ColName); ok {
+			return false, nil
+		}
+		tableName, ok := node.(sqlparser.TableName)
+		if !ok || tableName.IsEmpty() {
+			return true, nil
+		}
+		hasTables = true
+		passthrough = tables.isPassthroughTable(tableName.Qualifier.String(), tableName.Name.String())
+		return false, nil
+	}, statement)
+	return hasTables && passthrough
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base_test
+
+import (
+	"testing"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+)
+
+func TestLoadPassthroughTables(t *testing.T) {
+	invalidConfigs := []string{
+		"tables: ['']",
+		"tables: ['db.']",
+		"tables: ['*']",
+		"tables: ['*.table']",
+		"tables: ['a.b.c']",
+	}
+	for _, config := range invalidConfigs {
+		if _, err := base.LoadPassthroughTables([]byte(config)); err != base.ErrInvalidPassthroughTablesConfig {
+			t.Errorf("Expected ErrInvalidPassthroughTablesConfig for <%s>, took %v", config, err)
+		}
+	}
+}
+
+func TestPassthroughTables(t *testing.T) {
+	tables, err := base.LoadPassthroughTables([]byte("tables: [Metrics, logs.events, telemetry.*]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testcases := []struct {
+		query       string
+		passthrough bool
+	}{
+		{"select * from metrics", true},
+		{"SELECT m.value FROM METRICS m WHERE m.id = 1", true},
+		{"select * from other.metrics", true},
+		{"insert into logs.events (id) values (1)", true},
+		{"update telemetry.samples set value = 1", true},
+		{"select * from telemetry.samples join metrics on samples.id = metrics.id", true},
+		{"select * from events", false},
+		{"select * from samples", false},
+		{"select * from metrics join users on metrics.id = users.id", false},
+		{"select * from metrics where id in (select id from users)", false},
+		{"select 1", false},
+		{"not a query", false},
+	}
+	for _, testcase := range testcases {
+		if result := tables.IsPassthrough(testcase.query); result != testcase.passthrough {
+			t.Errorf("Query <%s>: expected %v, took %v", testcase.query, testcase.passthrough, result)
+		}
+	}
+	var nilTables *base.PassthroughTables
+	if nilTables.IsPassthrough("select * from metrics") {
+		t.Fatal("nil PassthroughTables shouldn't mark queries as passthrough")
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"sort"
+	"sync"
+)
+
+// payloadStatsKey identifies aggregated payload sizes. table is empty if database doesn't send table of columns
+type payloadStatsKey struct {
+	clientID string
+	table    string
+}
+
+type payloadStatsEntry struct {
+	values         int64
+	encryptedBytes int64
+	plaintextBytes int64
+}
+
+// PayloadStats aggregates sizes of encrypted values and their plaintext per client and table to estimate storage
+// overhead of encryption. Safe for concurrent use, all methods are safe to call on nil PayloadStats
+type PayloadStats struct {
+	mutex   sync.Mutex
+	entries map[payloadStatsKey]*payloadStatsEntry
+}
+
+// PayloadStatsSnapshot is aggregated payload sizes of client's values stored in table at some moment
+type PayloadStatsSnapshot struct {
+	ClientID       string `json:"client_id"`
+	Table          string `json:"table"`
+	Values         int64  `json:"values"`
+	EncryptedBytes int64  `json:"encrypted_bytes"`
+	PlaintextBytes int64  `json:"plaintext_bytes"`
+}
+
+// NewPayloadStats returns empty PayloadStats
+func NewPayloadStats() *PayloadStats {
+	return &PayloadStats{entries: make(map[payloadStatsKey]*payloadStatsEntry)}
+}
+
+// Add accounts value of client's table which took encryptedSize bytes before decryption and plaintextSize after
+func (stats *PayloadStats) Add(clientID []byte, table string, encryptedSize, plaintextSize int) {
+	if stats == nil {
+		return
+	}
+	key := payloadStatsKey{clientID: string(clientID), table: table}
+	stats.mutex.Lock()
+	entry, ok := stats.entries[key]
+	if !ok {
+		entry = &payloadStatsEntry{}
+		stats.entries[key] = entry
+	}
+	entry.values++
+	entry.encryptedBytes += int64(encryptedSize)
+	entry.plaintextBytes += int64(plaintextSize)
+	stats.mutex.Unlock()
+	PayloadBytesCounter.WithLabelValues(PayloadTypeEncrypted, key.clientID, table).Add(float64(encryptedSize))
+	PayloadBytesCounter.WithLabelValues(PayloadTypePlaintext, key.clientID, table).Add(float64(plaintextSize))
+}
+
+// Snapshot returns aggregated sizes ordered by client id and table
+func (stats *PayloadStats) Snapshot() []PayloadStatsSnapshot {
+	if stats == nil {
+		return nil
+	}
+	stats.mutex.Lock()
+	snapshots := make([]PayloadStatsSnapshot, 0, len(stats.entries))
+	for key, entry := range stats.entries {
+		snapshots = append(snapshots, PayloadStatsSnapshot{
+			ClientID:       key.clientID,
+			Table:          key.table,
+			Values:         entry.values,
+			EncryptedBytes: entry.encryptedBytes,
+			PlaintextBytes: entry.plaintextBytes,
+		})
+	}
+	stats.mutex.Unlock()
+	sort.Slice(snapshots, func(i, j int) bool {
+		if snapshots[i].ClientID != snapshots[j].ClientID {
+			return snapshots[i].ClientID < snapshots[j].ClientID
+		}
+		return snapshots[i].Table < snapshots[j].Table
+	})
+	return snapshots
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+)
+
+func TestPayloadStats(t *testing.T) {
+	stats := base.NewPayloadStats()
+	stats.Add([]byte("client2"), "", 90, 10)
+	stats.Add([]byte("client1"), "users", 100, 4)
+	stats.Add([]byte("client1"), "users", 110, 14)
+	stats.Add([]byte("client1"), "orders", 80, 1)
+
+	expected := []base.PayloadStatsSnapshot{
+		{ClientID: "client1", Table: "orders", Values: 1, EncryptedBytes: 80, PlaintextBytes: 1},
+		{ClientID: "client1", Table: "users", Values: 2, EncryptedBytes: 210, PlaintextBytes: 18},
+		{ClientID: "client2", Table: "", Values: 1, EncryptedBytes: 90, PlaintextBytes: 10},
+	}
+	if snapshot := stats.Snapshot(); !reflect.DeepEqual(snapshot, expected) {
+		t.Fatalf("Incorrect snapshot: %+v", snapshot)
+	}
+
+	var nilStats *base.PayloadStats
+	nilStats.Add([]byte("client"), "users", 1, 1)
+	if nilStats.Snapshot() != nil {
+		t.Fatal("Expected nil snapshot of nil PayloadStats")
+	}
+}
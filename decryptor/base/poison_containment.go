@@ -0,0 +1,77 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/cossacklabs/acra/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// PoisonContainment suspends decryption for period after detection of poison record. Connections stay alive but
+// clients get data as is (encrypted) instead of decrypted data until period ends. Each detection prolongs
+// containment. nil PoisonContainment never suspends decryption
+type PoisonContainment struct {
+	period time.Duration
+	// until stores end of containment as unix time in nanoseconds
+	until int64
+}
+
+// NewPoisonContainment returns containment which suspends decryption for period after each activation
+func NewPoisonContainment(period time.Duration) *PoisonContainment {
+	return &PoisonContainment{period: period}
+}
+
+// Activate suspends decryption for period since now
+func (containment *PoisonContainment) Activate() {
+	if containment == nil {
+		return
+	}
+	until := time.Now().Add(containment.period)
+	atomic.StoreInt64(&containment.until, until.UnixNano())
+	log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodePoisonRecordCallback, "until": until.Format(time.RFC3339)}).
+		Warningln("Detected poison record, decryption suspended, clients get encrypted data")
+}
+
+// IsActive returns true if decryption is suspended
+func (containment *PoisonContainment) IsActive() bool {
+	if containment == nil {
+		return false
+	}
+	return time.Now().UnixNano() < atomic.LoadInt64(&containment.until)
+}
+
+// Call activates containment on detecting poison record
+func (containment *PoisonContainment) Call() error {
+	containment.Activate()
+	return nil
+}
+
+// poisonContainment used by all decryptors of process
+var poisonContainment *PoisonContainment
+
+// SetPoisonContainment sets containment for all decryptions of process, nil turns it off
+func SetPoisonContainment(containment *PoisonContainment) {
+	poisonContainment = containment
+}
+
+// GetPoisonContainment returns containment for all decryptions of process or nil if it's turned off
+func GetPoisonContainment() *PoisonContainment {
+	return poisonContainment
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPoisonContainment(t *testing.T) {
+	var nilContainment *PoisonContainment
+	nilContainment.Activate()
+	if nilContainment.IsActive() {
+		t.Fatal("nil containment shouldn't suspend decryption")
+	}
+
+	containment := NewPoisonContainment(time.Millisecond * 50)
+	if containment.IsActive() {
+		t.Fatal("Containment shouldn't be active before detection of poison record")
+	}
+	storage := NewPoisonCallbackStorage()
+	storage.AddCallback(containment)
+	if err := storage.Call(); err != nil {
+		t.Fatal(err)
+	}
+	if !containment.IsActive() {
+		t.Fatal("Containment should be active after detection of poison record")
+	}
+	time.Sleep(time.Millisecond * 60)
+	if containment.IsActive() {
+		t.Fatal("Containment should end after period")
+	}
+}
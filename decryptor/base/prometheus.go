@@ -1,11 +1,19 @@
 package base
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
 
 const (
 	DecryptionTypeLabel   = "status"
 	DecryptionTypeSuccess = "success"
 	DecryptionTypeFail    = "fail"
+
+	DecryptionRejectReasonLabel = "reason"
+	DecryptionRejectQueueFull   = "queue_full"
+	DecryptionRejectTimeout     = "timeout"
 )
 const (
 	DecryptionModeLabel  = "mode"
@@ -13,6 +21,22 @@ const (
 	DecryptionModeInline = "inlinecell"
 )
 
+const (
+	PayloadTypeLabel     = "payload"
+	PayloadTypeEncrypted = "encrypted"
+	PayloadTypePlaintext = "plaintext"
+	PayloadClientIDLabel = "client_id"
+	PayloadTableLabel    = "table"
+	ApplicationLabel     = "application"
+)
+
+// MaxApplicationLabelValues limits count of distinct names of applications used as label values because names are
+// sent by clients. Other names are exported as OtherApplicationLabelValue
+const (
+	MaxApplicationLabelValues  = 100
+	OtherApplicationLabelValue = "other"
+)
+
 const (
 	DecryptionDBLabel      = "db"
 	DecryptionDBPostgresql = "postgresql"
@@ -26,12 +50,36 @@ var (
 			Help: "number of AcraStruct decryptions",
 		}, []string{DecryptionTypeLabel})
 
+	DecryptionLimiterRejectedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "acra_decryptions_rejected_total",
+			Help: "number of decryptions rejected by limit of simultaneous decryptions",
+		}, []string{DecryptionRejectReasonLabel})
+
 	ResponseProcessingTimeHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "acraserver_response_processing_seconds_bucket",
 		Help:    "Time of response processing",
 		Buckets: []float64{0.000001, 0.00001, 0.00002, 0.00003, 0.00004, 0.00005, 0.00006, 0.00007, 0.00008, 0.00009, 0.0001, 0.0005, 0.001, 0.005, 0.01, 1},
 	}, []string{DecryptionDBLabel, DecryptionModeLabel})
 
+	PayloadBytesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "acraserver_payload_bytes_total",
+			Help: "size of decrypted values before (encrypted) and after (plaintext) decryption",
+		}, []string{PayloadTypeLabel, PayloadClientIDLabel, PayloadTableLabel})
+
+	ApplicationConnectionsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "acraserver_application_connections_total",
+			Help: "number of client connections per client id and application from startup parameters",
+		}, []string{PayloadClientIDLabel, ApplicationLabel})
+
+	ApplicationQueriesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "acraserver_application_queries_total",
+			Help: "number of queries per client id and application from startup parameters",
+		}, []string{PayloadClientIDLabel, ApplicationLabel})
+
 	RequestProcessingTimeHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "acraserver_request_processing_seconds_bucket",
 		Help:    "Time of response processing",
@@ -41,6 +89,30 @@ var (
 
 func init() {
 	prometheus.MustRegister(AcrastructDecryptionCounter)
+	prometheus.MustRegister(DecryptionLimiterRejectedCounter)
 	prometheus.MustRegister(ResponseProcessingTimeHistogram)
 	prometheus.MustRegister(RequestProcessingTimeHistogram)
+	prometheus.MustRegister(PayloadBytesCounter)
+	prometheus.MustRegister(ApplicationConnectionsCounter)
+	prometheus.MustRegister(ApplicationQueriesCounter)
+}
+
+var applicationLabelValues = struct {
+	sync.Mutex
+	values map[string]struct{}
+}{values: make(map[string]struct{})}
+
+// ApplicationLabelValue returns application as label value if count of distinct applications is less than
+// MaxApplicationLabelValues, otherwise OtherApplicationLabelValue
+func ApplicationLabelValue(application string) string {
+	applicationLabelValues.Lock()
+	defer applicationLabelValues.Unlock()
+	if _, ok := applicationLabelValues.values[application]; ok {
+		return application
+	}
+	if len(applicationLabelValues.values) >= MaxApplicationLabelValues {
+		return OtherApplicationLabelValue
+	}
+	applicationLabelValues.values[application] = struct{}{}
+	return application
 }
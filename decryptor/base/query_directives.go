@@ -0,0 +1,166 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/zone"
+	log "github.com/sirupsen/logrus"
+)
+
+// Names of directives supported in SQL comments
+const (
+	QueryDirectiveZone        = "zone"
+	QueryDirectiveSkipDecrypt = "skip_decrypt"
+	QueryDirectiveSessionZone = "session_zone"
+)
+
+// Errors returned on parsing SQL comment directives
+var (
+	ErrUnknownQueryDirective = acraerrors.New(acraerrors.CodeUnknownQueryDirective, "unknown query directive")
+	ErrInvalidZoneDirective  = acraerrors.New(acraerrors.CodeInvalidZoneDirective, "invalid zone id in query directive")
+)
+
+// queryDirectivesRegexp matches comments like /* acra: zone=<zone id>, skip_decrypt */ or /* acra: session_zone=<zone id> */
+var queryDirectivesRegexp = regexp.MustCompile(`(?is)/\*\s*acra:(.*?)\*/`)
+
+// QueryDirectives stores per-query overrides of decryption behavior passed by trusted clients in SQL comments
+type QueryDirectives struct {
+	// ZoneID used to decrypt all AcraStructs of query's result instead of zone id matched in data
+	ZoneID []byte
+	// SkipDecryption means that query's result should be returned to client as is
+	SkipDecryption bool
+	// UpdateSessionZone means that query changes zone of connection's session to SessionZoneID for this and all
+	// following queries, empty SessionZoneID resets zone of session
+	UpdateSessionZone bool
+	SessionZoneID     []byte
+}
+
+// ParseQueryDirectives finds comments /* acra: ... */ in query and returns parsed directives or nil if query has no
+// directives. Several directives are separated by commas: /* acra: zone=<zone id>, skip_decrypt */. Directive
+// session_zone=<zone id> sets zone of session and session_zone= without zone id resets it
+func ParseQueryDirectives(query string) (*QueryDirectives, error) {
+	matches := queryDirectivesRegexp.FindAllStringSubmatch(query, -1)
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	directives := &QueryDirectives{}
+	for _, match := range matches {
+		for _, directive := range strings.Split(match[1], ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, value := directive, ""
+			if index := strings.Index(directive, "="); index != -1 {
+				name, value = strings.TrimSpace(directive[:index]), strings.TrimSpace(directive[index+1:])
+			}
+			switch strings.ToLower(name) {
+			case QueryDirectiveZone:
+				zoneID := []byte(value)
+				if !zone.ValidateZoneID(zoneID) {
+					return nil, ErrInvalidZoneDirective
+				}
+				directives.ZoneID = zoneID
+			case QueryDirectiveSkipDecrypt:
+				if value != "" {
+					return nil, ErrUnknownQueryDirective
+				}
+				directives.SkipDecryption = true
+			case QueryDirectiveSessionZone:
+				zoneID := []byte(value)
+				if value != "" && !zone.ValidateZoneID(zoneID) {
+					return nil, ErrInvalidZoneDirective
+				}
+				directives.UpdateSessionZone = true
+				directives.SessionZoneID = nil
+				if value != "" {
+					directives.SessionZoneID = zoneID
+				}
+			default:
+				return nil, ErrUnknownQueryDirective
+			}
+		}
+	}
+	return directives, nil
+}
+
+// QueryZoneResolver infers zone id of query's result from query itself
+type QueryZoneResolver interface {
+	// GetZoneID returns zone id or nil if zone can't be inferred from query
+	GetZoneID(query string) ([]byte, error)
+}
+
+// GetQueryDirectives returns directives from SQL comments of query if allowComments is true and updates zone of
+// session's connection if comments change it. If comments don't override zone then zone of session is used or, if
+// it isn't set and zoneResolver isn't nil, zone will be inferred from query. Errors are logged and ignored, so
+// query will be processed as usual. Returns nil if query has nothing to override
+func GetQueryDirectives(query string, allowComments bool, session *SessionZone, zoneResolver QueryZoneResolver, logger *log.Entry) *QueryDirectives {
+	var directives *QueryDirectives
+	if allowComments {
+		var err error
+		directives, err = ParseQueryDirectives(query)
+		if err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorDecryptorInvalidQueryDirectives).
+				Errorln("Can't parse query directives, query will be processed without them")
+		}
+	}
+	if directives != nil && directives.UpdateSessionZone {
+		session.Set(directives.SessionZoneID)
+		logger.WithField("zone_id", string(directives.SessionZoneID)).Infoln("Zone of session changed by query directive")
+	}
+	if directives != nil && directives.ZoneID != nil {
+		return directives
+	}
+	if sessionZone := session.Get(); sessionZone != nil {
+		if directives == nil {
+			directives = &QueryDirectives{}
+		}
+		directives.ZoneID = sessionZone
+		return directives
+	}
+	if zoneResolver == nil {
+		return directives
+	}
+	zoneID, err := zoneResolver.GetZoneID(query)
+	if err != nil {
+		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorDecryptorCantInferZone).
+			Errorln("Can't infer zone from query")
+		return directives
+	}
+	if zoneID == nil {
+		return directives
+	}
+	if directives == nil {
+		directives = &QueryDirectives{}
+	}
+	directives.ZoneID = zoneID
+	return directives
+}
+
+// ApplyZone marks zone id from directives as matched for decryptor which works in zone mode. Should be called before
+// decryption of each block because decryptors reset matched zone after successful decryption
+func (directives *QueryDirectives) ApplyZone(decryptor Decryptor) {
+	if directives == nil || directives.ZoneID == nil || !decryptor.IsWithZone() {
+		return
+	}
+	decryptor.GetZoneMatcher().SetMatched(directives.ZoneID)
+}
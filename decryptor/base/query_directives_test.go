@@ -0,0 +1,97 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package base_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestParseQueryDirectives(t *testing.T) {
+	zoneID := []byte("DDDDDDDDHCzqZAZNbBvybWLR")
+
+	directives, err := base.ParseQueryDirectives("select data from test_table")
+	if err != nil || directives != nil {
+		t.Fatalf("Expected nil directives for query without comments, took %v, %v", directives, err)
+	}
+
+	directives, err = base.ParseQueryDirectives("/* acra: zone=DDDDDDDDHCzqZAZNbBvybWLR, skip_decrypt */ select data from test_table")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(directives.ZoneID, zoneID) || !directives.SkipDecryption {
+		t.Fatalf("Incorrect parsed directives: %v", directives)
+	}
+
+	directives, err = base.ParseQueryDirectives("select data from test_table /*ACRA: zone = DDDDDDDDHCzqZAZNbBvybWLR*/ /* other comment */")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(directives.ZoneID, zoneID) || directives.SkipDecryption {
+		t.Fatalf("Incorrect parsed directives: %v", directives)
+	}
+
+	invalidQueries := map[string]error{
+		"/* acra: zone=short */ select 1":                                base.ErrInvalidZoneDirective,
+		"/* acra: zone=XXXXXXXXHCzqZAZNbBvybWLR */ select 1":             base.ErrInvalidZoneDirective,
+		"/* acra: unknown */ select 1":                                   base.ErrUnknownQueryDirective,
+		"/* acra: skip_decrypt=false */ select 1":                        base.ErrUnknownQueryDirective,
+		"/* acra: skip_decrypt */ /* acra: zone= */ select data from t1": base.ErrInvalidZoneDirective,
+		"/* acra: session_zone=short */ select 1":                        base.ErrInvalidZoneDirective,
+	}
+	for query, expectedErr := range invalidQueries {
+		if _, err := base.ParseQueryDirectives(query); err != expectedErr {
+			t.Errorf("Expected %v on query <%s>, took %v", expectedErr, query, err)
+		}
+	}
+}
+
+func TestSessionZoneDirectives(t *testing.T) {
+	zoneID := []byte("DDDDDDDDHCzqZAZNbBvybWLR")
+	queryZoneID := []byte("DDDDDDDDqeXnqpxNQqSvEMLS")
+	logger := log.NewEntry(log.StandardLogger())
+	session := &base.SessionZone{}
+
+	if directives := base.GetQueryDirectives("select 1", true, session, nil, logger); directives != nil {
+		t.Fatalf("Expected nil directives without session zone, took %v", directives)
+	}
+	directives := base.GetQueryDirectives("/* acra: session_zone=DDDDDDDDHCzqZAZNbBvybWLR */ select 1", true, session, nil, logger)
+	if !bytes.Equal(directives.ZoneID, zoneID) || !bytes.Equal(session.Get(), zoneID) {
+		t.Fatalf("Session zone wasn't set, took %v", directives)
+	}
+	// session zone is applied to following queries
+	directives = base.GetQueryDirectives("select 1", true, session, nil, logger)
+	if !bytes.Equal(directives.ZoneID, zoneID) {
+		t.Fatalf("Session zone wasn't applied, took %v", directives)
+	}
+	// zone of query overrides zone of session
+	directives = base.GetQueryDirectives("/* acra: zone=DDDDDDDDqeXnqpxNQqSvEMLS */ select 1", true, session, nil, logger)
+	if !bytes.Equal(directives.ZoneID, queryZoneID) || !bytes.Equal(session.Get(), zoneID) {
+		t.Fatalf("Zone of query wasn't applied, took %v", directives)
+	}
+	// comments of untrusted clients are ignored
+	base.GetQueryDirectives("/* acra: session_zone= */ select 1", false, session, nil, logger)
+	if !bytes.Equal(session.Get(), zoneID) {
+		t.Fatal("Session zone was reset by untrusted client")
+	}
+	base.GetQueryDirectives("/* acra: session_zone= */ select 1", true, session, nil, logger)
+	if session.Get() != nil {
+		t.Fatal("Session zone wasn't reset")
+	}
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"sync"
+)
+
+// SessionZone is zone set for all following queries of client's connection by trusted middleware which pools
+// connections across tenants, with session_zone directive or HTTP API. Zero value has no zone, nil SessionZone ignores
+// changes
+type SessionZone struct {
+	lock   sync.Mutex
+	zoneID []byte
+}
+
+// Set sets zone used to decrypt results of all following queries of connection, nil resets zone
+func (session *SessionZone) Set(zoneID []byte) {
+	if session == nil {
+		return
+	}
+	session.lock.Lock()
+	session.zoneID = append([]byte(nil), zoneID...)
+	if len(zoneID) == 0 {
+		session.zoneID = nil
+	}
+	session.lock.Unlock()
+}
+
+// Get returns zone of connection's session or nil if it isn't set
+func (session *SessionZone) Get() []byte {
+	if session == nil {
+		return nil
+	}
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	return session.zoneID
+}
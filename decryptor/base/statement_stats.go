@@ -0,0 +1,331 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultStatementStatsMaxCount is count of distinct statements tracked by default
+const DefaultStatementStatsMaxCount = 5000
+
+type statementTokenKind int
+
+const (
+	tokenWord statementTokenKind = iota
+	tokenPlaceholder
+	tokenOperator
+	tokenPunctuation
+)
+
+type statementToken struct {
+	kind statementTokenKind
+	text string
+}
+
+const operatorBytes = "+-*/<>=~!@#%^&|:"
+
+func isStatementWordByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// tokenizeStatement splits query to words, placeholders (literals, numbers and parameters), operators and
+// punctuation. Comments and whitespaces are skipped
+func tokenizeStatement(query string) []statementToken {
+	var tokens []statementToken
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+		case strings.HasPrefix(query[i:], "--"):
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end + 4
+			}
+		case c == '\'':
+			// string literal with '' and backslash escapes
+			for i++; i < len(query); i++ {
+				if query[i] == '\\' {
+					i++
+				} else if query[i] == '\'' {
+					if i+1 < len(query) && query[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			i++
+			tokens = append(tokens, statementToken{tokenPlaceholder, "?"})
+		case c == '"' || c == '`':
+			// quoted identifier is kept as is
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				end = len(query)
+			} else {
+				end += i + 2
+			}
+			tokens = append(tokens, statementToken{tokenWord, query[i:end]})
+			i = end
+		case c == '?' || c == '$' && i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9':
+			// placeholder of MySQL or positional parameter of PostgreSQL
+			for i++; i < len(query) && query[i] >= '0' && query[i] <= '9'; i++ {
+			}
+			tokens = append(tokens, statementToken{tokenPlaceholder, "?"})
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9':
+			for ; i < len(query) && (isStatementWordByte(query[i]) || query[i] == '.'); i++ {
+			}
+			tokens = append(tokens, statementToken{tokenPlaceholder, "?"})
+		case strings.IndexByte("bBeEnNxX", c) >= 0 && i+1 < len(query) && query[i+1] == '\'':
+			// prefix of bit, escape, national or hex string literal
+			i++
+		case isStatementWordByte(c):
+			start := i
+			for ; i < len(query) && isStatementWordByte(query[i]); i++ {
+			}
+			tokens = append(tokens, statementToken{tokenWord, strings.ToLower(query[start:i])})
+		case strings.IndexByte(operatorBytes, c) >= 0:
+			start := i
+			for ; i < len(query) && strings.IndexByte(operatorBytes, query[i]) >= 0 && !strings.HasPrefix(query[i:], "--") && !strings.HasPrefix(query[i:], "/*"); i++ {
+			}
+			tokens = append(tokens, statementToken{tokenOperator, query[start:i]})
+		default:
+			tokens = append(tokens, statementToken{tokenPunctuation, string(c)})
+			i++
+		}
+	}
+	return tokens
+}
+
+// NormalizeStatement returns query with literals, numbers and parameters replaced with ?, lists of them collapsed to
+// one ?, comments and trailing semicolons removed, whitespaces made canonical and words in lower case. Statements
+// which differ only in values have same normalized form. Doesn't parse SQL, so works same for any dialect and for
+// queries with syntax errors
+func NormalizeStatement(query string) string {
+	tokens := tokenizeStatement(query)
+	for len(tokens) > 0 && tokens[len(tokens)-1].text == ";" {
+		tokens = tokens[:len(tokens)-1]
+	}
+	output := make([]byte, 0, len(query))
+	var previous *statementToken
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		// "?, ?, ?" is written as single "?"
+		if token.kind == tokenPlaceholder && previous != nil && previous.kind == tokenPlaceholder {
+			continue
+		}
+		if token.text == "," && previous != nil && previous.kind == tokenPlaceholder && i+1 < len(tokens) && tokens[i+1].kind == tokenPlaceholder {
+			continue
+		}
+		if previous != nil && !(previous.text == "(" || previous.text == "." || token.text == ")" || token.text == "," || token.text == "." || token.text == ";") {
+			output = append(output, ' ')
+		}
+		output = append(output, token.text...)
+		previous = &tokens[i]
+	}
+	return string(output)
+}
+
+// StatementFingerprint returns id of normalized statement, same for queries which differ only in values
+func StatementFingerprint(normalized string) string {
+	hash := fnv.New64a()
+	hash.Write([]byte(normalized))
+	return fmt.Sprintf("%016x", hash.Sum64())
+}
+
+type statementStatsKey struct {
+	clientID    string
+	fingerprint string
+}
+
+type statementStatsEntry struct {
+	query     string
+	calls     int64
+	rows      int64
+	totalTime time.Duration
+	minTime   time.Duration
+	maxTime   time.Duration
+}
+
+// StatementStats aggregates count of executions, rows and time of responses per client and normalized statement like
+// pg_stat_statements does in database. Count of tracked statements is limited, least executed statement is evicted to
+// track new one. Safe for concurrent use, all methods are safe to call on nil StatementStats
+type StatementStats struct {
+	mutex    sync.Mutex
+	maxCount int
+	entries  map[statementStatsKey]*statementStatsEntry
+	evicted  int64
+
+	callsDesc     *prometheus.Desc
+	timeDesc      *prometheus.Desc
+	rowsDesc      *prometheus.Desc
+	evictionsDesc *prometheus.Desc
+}
+
+// StatementStatsSnapshot is aggregated executions of client's statement at some moment
+type StatementStatsSnapshot struct {
+	ClientID    string  `json:"client_id"`
+	Fingerprint string  `json:"fingerprint"`
+	Query       string  `json:"query"`
+	Calls       int64   `json:"calls"`
+	Rows        int64   `json:"rows"`
+	TotalTimeMs float64 `json:"total_time_ms"`
+	MinTimeMs   float64 `json:"min_time_ms"`
+	MaxTimeMs   float64 `json:"max_time_ms"`
+	MeanTimeMs  float64 `json:"mean_time_ms"`
+}
+
+// NewStatementStats returns StatementStats which tracks up to maxCount statements
+func NewStatementStats(maxCount int) *StatementStats {
+	labels := []string{PayloadClientIDLabel, "fingerprint"}
+	return &StatementStats{
+		maxCount: maxCount,
+		entries:  make(map[statementStatsKey]*statementStatsEntry),
+		callsDesc: prometheus.NewDesc("acraserver_statement_calls_total",
+			"Number of executions of normalized statement", labels, nil),
+		timeDesc: prometheus.NewDesc("acraserver_statement_time_seconds_total",
+			"Time between forwarding of statement to database and end of its response", labels, nil),
+		rowsDesc: prometheus.NewDesc("acraserver_statement_rows_total",
+			"Number of rows returned by statement", labels, nil),
+		evictionsDesc: prometheus.NewDesc("acraserver_statement_evictions_total",
+			"Number of statements evicted from tracking to track new ones", nil, nil),
+	}
+}
+
+// Add accounts execution of client's statement with normalized query which returned rows and took duration
+func (stats *StatementStats) Add(clientID []byte, normalized string, rows int64, duration time.Duration) {
+	if stats == nil {
+		return
+	}
+	key := statementStatsKey{clientID: string(clientID), fingerprint: StatementFingerprint(normalized)}
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	entry, ok := stats.entries[key]
+	if !ok {
+		if len(stats.entries) >= stats.maxCount {
+			stats.evictLeastCalled()
+		}
+		entry = &statementStatsEntry{query: normalized, minTime: duration}
+		stats.entries[key] = entry
+	}
+	entry.calls++
+	entry.rows += rows
+	entry.totalTime += duration
+	if duration < entry.minTime {
+		entry.minTime = duration
+	}
+	if duration > entry.maxTime {
+		entry.maxTime = duration
+	}
+}
+
+func (stats *StatementStats) evictLeastCalled() {
+	var leastKey statementStatsKey
+	var leastCalls int64 = -1
+	for key, entry := range stats.entries {
+		if leastCalls < 0 || entry.calls < leastCalls {
+			leastKey, leastCalls = key, entry.calls
+		}
+	}
+	if leastCalls >= 0 {
+		delete(stats.entries, leastKey)
+		stats.evicted++
+	}
+}
+
+func milliseconds(duration time.Duration) float64 {
+	return float64(duration) / float64(time.Millisecond)
+}
+
+// Snapshot returns aggregated statements ordered by total time, the most expensive first
+func (stats *StatementStats) Snapshot() []StatementStatsSnapshot {
+	if stats == nil {
+		return nil
+	}
+	stats.mutex.Lock()
+	snapshots := make([]StatementStatsSnapshot, 0, len(stats.entries))
+	for key, entry := range stats.entries {
+		snapshots = append(snapshots, StatementStatsSnapshot{
+			ClientID:    key.clientID,
+			Fingerprint: key.fingerprint,
+			Query:       entry.query,
+			Calls:       entry.calls,
+			Rows:        entry.rows,
+			TotalTimeMs: milliseconds(entry.totalTime),
+			MinTimeMs:   milliseconds(entry.minTime),
+			MaxTimeMs:   milliseconds(entry.maxTime),
+			MeanTimeMs:  milliseconds(entry.totalTime) / float64(entry.calls),
+		})
+	}
+	stats.mutex.Unlock()
+	sort.Slice(snapshots, func(i, j int) bool {
+		if snapshots[i].TotalTimeMs != snapshots[j].TotalTimeMs {
+			return snapshots[i].TotalTimeMs > snapshots[j].TotalTimeMs
+		}
+		if snapshots[i].ClientID != snapshots[j].ClientID {
+			return snapshots[i].ClientID < snapshots[j].ClientID
+		}
+		return snapshots[i].Fingerprint < snapshots[j].Fingerprint
+	})
+	return snapshots
+}
+
+// Reset removes all tracked statements
+func (stats *StatementStats) Reset() {
+	if stats == nil {
+		return
+	}
+	stats.mutex.Lock()
+	stats.entries = make(map[statementStatsKey]*statementStatsEntry)
+	stats.mutex.Unlock()
+}
+
+// Describe implements prometheus.Collector
+func (stats *StatementStats) Describe(ch chan<- *prometheus.Desc) {
+	ch <- stats.callsDesc
+	ch <- stats.timeDesc
+	ch <- stats.rowsDesc
+	ch <- stats.evictionsDesc
+}
+
+// Collect implements prometheus.Collector. Metrics are exported only for tracked statements, so count of label values
+// is limited by max count of statements
+func (stats *StatementStats) Collect(ch chan<- prometheus.Metric) {
+	stats.mutex.Lock()
+	evicted := stats.evicted
+	stats.mutex.Unlock()
+	for _, snapshot := range stats.Snapshot() {
+		ch <- prometheus.MustNewConstMetric(stats.callsDesc, prometheus.CounterValue, float64(snapshot.Calls), snapshot.ClientID, snapshot.Fingerprint)
+		ch <- prometheus.MustNewConstMetric(stats.timeDesc, prometheus.CounterValue, snapshot.TotalTimeMs/1000, snapshot.ClientID, snapshot.Fingerprint)
+		ch <- prometheus.MustNewConstMetric(stats.rowsDesc, prometheus.CounterValue, float64(snapshot.Rows), snapshot.ClientID, snapshot.Fingerprint)
+	}
+	ch <- prometheus.MustNewConstMetric(stats.evictionsDesc, prometheus.CounterValue, float64(evicted))
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+)
+
+func TestNormalizeStatement(t *testing.T) {
+	testcases := []struct {
+		query      string
+		normalized string
+	}{
+		{"SELECT * FROM users WHERE id = 1", "select * from users where id = ?"},
+		{"select *   from users\n where id=42;", "select * from users where id = ?"},
+		{"SELECT name FROM users WHERE email = 'a@b.c' AND age > 3.5e2", "select name from users where email = ? and age > ?"},
+		{"INSERT INTO t(a, b) VALUES ($1, $2)", "insert into t (a, b) values (?)"},
+		{"INSERT INTO t(a, b) VALUES (?, ?)", "insert into t (a, b) values (?)"},
+		{"SELECT \"Name\" FROM public.t WHERE id IN (1, 2, 3)", "select \"Name\" from public.t where id in (?)"},
+		{"select 'it''s' from t", "select ? from t"},
+		{"select x'ff', E'\\x00' from t", "select ? from t"},
+	}
+	for _, testcase := range testcases {
+		if normalized := base.NormalizeStatement(testcase.query); normalized != testcase.normalized {
+			t.Errorf("Query %q normalized to %q, expected %q", testcase.query, normalized, testcase.normalized)
+		}
+	}
+	if base.StatementFingerprint(base.NormalizeStatement("select 1")) != base.StatementFingerprint(base.NormalizeStatement("SELECT 2")) {
+		t.Fatal("Expected same fingerprint of statements with different literals")
+	}
+}
+
+func TestStatementStats(t *testing.T) {
+	stats := base.NewStatementStats(2)
+	stats.Add([]byte("client"), "select ?", 1, time.Millisecond)
+	stats.Add([]byte("client"), "select ?", 3, 3*time.Millisecond)
+	stats.Add([]byte("client"), "select * from t", 10, 10*time.Millisecond)
+
+	snapshot := stats.Snapshot()
+	if len(snapshot) != 2 {
+		t.Fatalf("Expected 2 statements, took %+v", snapshot)
+	}
+	if snapshot[0].Query != "select * from t" || snapshot[0].Calls != 1 || snapshot[0].Rows != 10 {
+		t.Fatalf("Incorrect most expensive statement: %+v", snapshot[0])
+	}
+	selected := snapshot[1]
+	if selected.Calls != 2 || selected.Rows != 4 || selected.TotalTimeMs != 4 || selected.MinTimeMs != 1 || selected.MaxTimeMs != 3 || selected.MeanTimeMs != 2 {
+		t.Fatalf("Incorrect aggregated statement: %+v", selected)
+	}
+
+	// least executed statement evicted to track new one
+	stats.Add([]byte("client"), "delete from t", 0, time.Millisecond)
+	for _, statement := range stats.Snapshot() {
+		if statement.Query == "select * from t" {
+			t.Fatal("Expected eviction of least executed statement")
+		}
+	}
+
+	stats.Reset()
+	if snapshot := stats.Snapshot(); len(snapshot) != 0 {
+		t.Fatalf("Expected empty snapshot after reset, took %+v", snapshot)
+	}
+}
+
+func TestConnectionStatsStatements(t *testing.T) {
+	stats := base.NewConnectionStats(1, []byte("client"), "127.0.0.1:1234")
+	stats.Statements = base.NewStatementStats(base.DefaultStatementStatsMaxCount)
+
+	stats.StartStatement("select * from t where id = 1")
+	// prepared statement without text isn't accounted
+	stats.StartStatement("")
+	stats.AddRow()
+	stats.AddRow()
+	stats.EndStatement()
+	stats.AddRow()
+	stats.EndStatement()
+	// response without started statement is ignored
+	stats.EndStatement()
+
+	snapshot := stats.Statements.Snapshot()
+	if len(snapshot) != 1 {
+		t.Fatalf("Expected 1 statement, took %+v", snapshot)
+	}
+	if snapshot[0].ClientID != "client" || snapshot[0].Query != "select * from t where id = ?" || snapshot[0].Calls != 1 || snapshot[0].Rows != 2 {
+		t.Fatalf("Incorrect statement: %+v", snapshot[0])
+	}
+}
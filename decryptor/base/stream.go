@@ -0,0 +1,192 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package base
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/utils"
+	"github.com/cossacklabs/themis/gothemis/cell"
+	"github.com/cossacklabs/themis/gothemis/keys"
+	"github.com/cossacklabs/themis/gothemis/message"
+)
+
+/*
+AcraStream is container for data encrypted as it is written, so data of any size may be encrypted without loading it
+into memory. Symmetric key is wrapped once per stream in the same way as in AcraStruct, data is split into chunks and
+each chunk is encrypted separately with Themis SecureCell in Seal mode:
+
+	StreamTagBegin | ephemeral public key | wrapped symmetric key | chunk | chunk | ... | final chunk
+	chunk = length of encrypted chunk (uint32 little endian) | SecureCell(flag | data, context | chunk index)
+
+Chunk index (uint64 big endian) appended to context of each chunk and flag of last chunk is StreamChunkFlagFinal,
+so reordered, removed or truncated chunks fail decryption.
+*/
+
+// StreamTagBegin begins AcraStream. It differs from TAG_BEGIN so streams aren't decrypted as AcraStructs
+var StreamTagBegin = []byte{TagSymbol, TagSymbol, TagSymbol, TagSymbol, 'S', 'T', 'R', 1}
+
+// Parameters of AcraStream chunks
+const (
+	StreamChunkLengthSize = 4
+	// MaxStreamChunkSize limits size of data in one chunk
+	MaxStreamChunkSize = 16 * 1024 * 1024
+	// DefaultStreamChunkSize used if chunk size wasn't specified
+	DefaultStreamChunkSize = 64 * 1024
+	// maxStreamChunkOverhead is more than SecureCell adds to encrypted data
+	maxStreamChunkOverhead = 1024
+
+	StreamChunkFlagNext  byte = 0
+	StreamChunkFlagFinal byte = 1
+)
+
+// Errors returned on decryption of AcraStream
+var (
+	ErrInvalidStream   = acraerrors.New(acraerrors.CodeInvalidStream, "data isn't AcraStream or corrupted")
+	ErrTruncatedStream = acraerrors.New(acraerrors.CodeTruncatedStream, "AcraStream ended before final chunk")
+)
+
+// GetStreamHeaderLength returns length of AcraStream header before chunks
+func GetStreamHeaderLength() int {
+	return len(StreamTagBegin) + KeyBlockLength
+}
+
+// StreamChunkContext returns context of chunk with index
+func StreamChunkContext(context []byte, index uint64) []byte {
+	chunkContext := make([]byte, len(context)+8)
+	copy(chunkContext, context)
+	binary.BigEndian.PutUint64(chunkContext[len(context):], index)
+	return chunkContext
+}
+
+// StreamDecryptor reads AcraStream from underlying reader and returns decrypted data
+type StreamDecryptor struct {
+	input     io.Reader
+	scell     *cell.SecureCell
+	symmetric []byte
+	context   []byte
+	index     uint64
+	plaintext []byte
+	final     bool
+	err       error
+}
+
+// NewStreamDecryptor reads header of AcraStream from input and unwraps symmetric key with privateKey. context should
+// be the same as used on encryption, zone id for streams encrypted with zone
+func NewStreamDecryptor(input io.Reader, privateKey *keys.PrivateKey, context []byte) (*StreamDecryptor, error) {
+	header := make([]byte, GetStreamHeaderLength())
+	if _, err := io.ReadFull(input, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrInvalidStream
+		}
+		return nil, err
+	}
+	if !bytes.Equal(header[:len(StreamTagBegin)], StreamTagBegin) {
+		return nil, ErrInvalidStream
+	}
+	keyBlock := header[len(StreamTagBegin):]
+	smessage := message.New(privateKey, &keys.PublicKey{Value: keyBlock[:PublicKeyLength]})
+	symmetricKey, err := smessage.Unwrap(keyBlock[PublicKeyLength:])
+	if err != nil {
+		return nil, err
+	}
+	return &StreamDecryptor{
+		input:     input,
+		scell:     cell.New(symmetricKey, cell.CELL_MODE_SEAL),
+		symmetric: symmetricKey,
+		context:   context,
+	}, nil
+}
+
+// NewRotatedStreamDecryptor reads header of AcraStream from input and unwraps symmetric key trying all versions of
+// rotated private key. Returns error of last key if all keys failed
+func NewRotatedStreamDecryptor(input io.Reader, privateKeys []*keys.PrivateKey, context []byte) (*StreamDecryptor, error) {
+	header := make([]byte, GetStreamHeaderLength())
+	if _, err := io.ReadFull(input, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrInvalidStream
+		}
+		return nil, err
+	}
+	err := ErrInvalidStream
+	for _, privateKey := range privateKeys {
+		var decryptor *StreamDecryptor
+		decryptor, err = NewStreamDecryptor(io.MultiReader(bytes.NewReader(header), input), privateKey, context)
+		if err == nil {
+			return decryptor, nil
+		}
+	}
+	return nil, err
+}
+
+// Read returns decrypted data. Returns io.EOF after final chunk and ErrTruncatedStream if input ended before it
+func (decryptor *StreamDecryptor) Read(p []byte) (int, error) {
+	for len(decryptor.plaintext) == 0 {
+		if decryptor.err != nil {
+			return 0, decryptor.err
+		}
+		if decryptor.final {
+			return 0, io.EOF
+		}
+		decryptor.err = decryptor.readChunk()
+	}
+	n := copy(p, decryptor.plaintext)
+	decryptor.plaintext = decryptor.plaintext[n:]
+	return n, nil
+}
+
+func (decryptor *StreamDecryptor) readChunk() error {
+	lengthBlock := make([]byte, StreamChunkLengthSize)
+	if _, err := io.ReadFull(decryptor.input, lengthBlock); err != nil {
+		return streamReadError(err)
+	}
+	length := binary.LittleEndian.Uint32(lengthBlock)
+	if length == 0 || length > MaxStreamChunkSize+maxStreamChunkOverhead {
+		return ErrInvalidStream
+	}
+	encrypted := make([]byte, length)
+	if _, err := io.ReadFull(decryptor.input, encrypted); err != nil {
+		return streamReadError(err)
+	}
+	chunk, err := decryptor.scell.Unprotect(encrypted, nil, StreamChunkContext(decryptor.context, decryptor.index))
+	if err != nil || len(chunk) == 0 {
+		return ErrInvalidStream
+	}
+	decryptor.index++
+	switch chunk[0] {
+	case StreamChunkFlagFinal:
+		decryptor.final = true
+		// symmetric key isn't needed after final chunk
+		utils.FillSlice(byte(0), decryptor.symmetric)
+	case StreamChunkFlagNext:
+	default:
+		return ErrInvalidStream
+	}
+	decryptor.plaintext = chunk[1:]
+	return nil
+}
+
+// streamReadError returns ErrTruncatedStream if input ended on reading of chunk
+func streamReadError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncatedStream
+	}
+	return err
+}
@@ -20,7 +20,7 @@ import (
 	"bytes"
 	"encoding/binary"
 
-	"errors"
+	"github.com/cossacklabs/acra/acraerrors"
 	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/acra/utils"
 	"github.com/cossacklabs/themis/gothemis/cell"
@@ -43,8 +43,8 @@ func GetMinAcraStructLength() int {
 
 // Errors show incorrect AcraStruct length
 var (
-	ErrIncorrectAcraStructLength     = errors.New("AcraStruct has incorrect length")
-	ErrIncorrectAcraStructDataLength = errors.New("AcraStruct has incorrect data length value")
+	ErrIncorrectAcraStructLength     = acraerrors.New(acraerrors.CodeIncorrectAcraStructLength, "AcraStruct has incorrect length")
+	ErrIncorrectAcraStructDataLength = acraerrors.New(acraerrors.CodeIncorrectAcraStructDataLength, "AcraStruct has incorrect data length value")
 )
 
 // ValidateAcraStructLength check that data has minimal length for AcraStruct and data block equal to data length in AcraStruct
@@ -91,6 +91,28 @@ func DecryptAcrastruct(data []byte, privateKey *keys.PrivateKey, zone []byte) ([
 	return decrypted, nil
 }
 
+// DecryptRotatedAcrastruct returns plaintext data from AcraStruct trying all versions of rotated private key.
+// Returns error of decryption with first key if all keys failed.
+func DecryptRotatedAcrastruct(data []byte, privateKeys []*keys.PrivateKey, zone []byte) ([]byte, error) {
+	var firstErr error
+	for _, privateKey := range privateKeys {
+		decrypted, err := DecryptAcrastruct(data, privateKey, zone)
+		if err == nil {
+			return decrypted, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		return nil, ErrNoPrivateKeys
+	}
+	return nil, firstErr
+}
+
+// ErrNoPrivateKeys returned if AcraStruct is decrypted without private keys
+var ErrNoPrivateKeys = acraerrors.New(acraerrors.CodeNoPrivateKeys, "no private keys to decrypt AcraStruct")
+
 // CheckPoisonRecord checks if AcraStruct could be decrypted using Poison Record private key.
 // Returns true if AcraStruct is poison record, returns false otherwise.
 // Returns error if Poison record key is not found.
@@ -81,3 +81,32 @@ func TestDecryptAcrastruct(t *testing.T) {
 		t.Fatal("decrypted != test_data")
 	}
 }
+
+func TestDecryptRotatedAcrastruct(t *testing.T) {
+	testData := []byte("some data")
+	oldKeypair, err := keys.New(keys.KEYTYPE_EC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKeypair, err := keys.New(keys.KEYTYPE_EC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acrastruct, err := acrawriter.CreateAcrastruct(testData, oldKeypair.Public, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := base.DecryptRotatedAcrastruct(acrastruct, []*keys.PrivateKey{newKeypair.Private, oldKeypair.Private}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, testData) {
+		t.Fatal("decrypted != test_data")
+	}
+	if _, err := base.DecryptRotatedAcrastruct(acrastruct, []*keys.PrivateKey{newKeypair.Private}, nil); err == nil {
+		t.Fatal("Expected error with key of other version")
+	}
+	if _, err := base.DecryptRotatedAcrastruct(acrastruct, nil, nil); err != base.ErrNoPrivateKeys {
+		t.Fatalf("Expected ErrNoPrivateKeys, took %v", err)
+	}
+}
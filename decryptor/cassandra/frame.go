@@ -0,0 +1,127 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cassandra implements proxy of CQL native protocol (versions 3 and 4) which checks CQL statements with
+// AcraCensor and decrypts AcraStructs stored as column values in result frames of database.
+//
+// https://github.com/apache/cassandra/blob/trunk/doc/native_protocol_v4.spec
+package cassandra
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/cossacklabs/acra/acraerrors"
+)
+
+// Opcodes of frames
+const (
+	OpError         = 0x00
+	OpStartup       = 0x01
+	OpReady         = 0x02
+	OpAuthenticate  = 0x03
+	OpOptions       = 0x05
+	OpSupported     = 0x06
+	OpQuery         = 0x07
+	OpResult        = 0x08
+	OpPrepare       = 0x09
+	OpExecute       = 0x0A
+	OpRegister      = 0x0B
+	OpEvent         = 0x0C
+	OpBatch         = 0x0D
+	OpAuthChallenge = 0x0E
+	OpAuthResponse  = 0x0F
+	OpAuthSuccess   = 0x10
+)
+
+// Flags of frame header
+const (
+	FlagCompression = 0x01
+	FlagTracing     = 0x02
+	FlagPayload     = 0x04
+	FlagWarning     = 0x08
+)
+
+// Supported versions of protocol. Frames of database have direction bit set in version
+const (
+	MinVersion       = 3
+	MaxVersion       = 4
+	versionMask      = 0x7F
+	responseBit      = 0x80
+	HeaderLength     = 9
+	MaxFrameBodySize = 256 * 1024 * 1024
+)
+
+// Errors returned on processing of frames
+var (
+	ErrMalformedFrame = acraerrors.New(acraerrors.CodeCassandraMalformedFrame, "malformed frame of CQL native protocol")
+	ErrFrameTooLarge  = acraerrors.New(acraerrors.CodeCassandraFrameTooLarge, "frame of CQL native protocol exceeds max size")
+)
+
+// Frame of CQL native protocol
+type Frame struct {
+	// Version includes direction bit, use ProtocolVersion to get version itself
+	Version byte
+	Flags   byte
+	Stream  int16
+	Opcode  byte
+	Body    []byte
+}
+
+// ReadFrame reads one frame from reader
+func ReadFrame(reader io.Reader) (*Frame, error) {
+	header := make([]byte, HeaderLength)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[5:])
+	if length > MaxFrameBodySize {
+		return nil, ErrFrameTooLarge
+	}
+	frame := &Frame{
+		Version: header[0],
+		Flags:   header[1],
+		Stream:  int16(binary.BigEndian.Uint16(header[2:])),
+		Opcode:  header[4],
+		Body:    make([]byte, length),
+	}
+	if _, err := io.ReadFull(reader, frame.Body); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// ProtocolVersion returns version of protocol without direction bit
+func (frame *Frame) ProtocolVersion() byte {
+	return frame.Version & versionMask
+}
+
+// IsSupportedVersion returns true if proxy can process frames of version of frame
+func (frame *Frame) IsSupportedVersion() bool {
+	version := frame.ProtocolVersion()
+	return version >= MinVersion && version <= MaxVersion
+}
+
+// Dump returns frame with header
+func (frame *Frame) Dump() []byte {
+	output := make([]byte, HeaderLength, HeaderLength+len(frame.Body))
+	output[0] = frame.Version
+	output[1] = frame.Flags
+	binary.BigEndian.PutUint16(output[2:], uint16(frame.Stream))
+	output[4] = frame.Opcode
+	binary.BigEndian.PutUint32(output[5:], uint32(len(frame.Body)))
+	return append(output, frame.Body...)
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cassandra
+
+import (
+	"encoding/binary"
+)
+
+// reader reads values of notations used in frame bodies, every method returns ErrMalformedFrame if body is too short
+type reader struct {
+	data   []byte
+	offset int
+}
+
+func newReader(data []byte) *reader {
+	return &reader{data: data}
+}
+
+func (r *reader) next(length int) ([]byte, error) {
+	if length < 0 || len(r.data)-r.offset < length {
+		return nil, ErrMalformedFrame
+	}
+	value := r.data[r.offset : r.offset+length]
+	r.offset += length
+	return value, nil
+}
+
+// readByte reads [byte]
+func (r *reader) readByte() (byte, error) {
+	value, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return value[0], nil
+}
+
+// readShort reads [short] which is unsigned
+func (r *reader) readShort() (int, error) {
+	value, err := r.next(2)
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint16(value)), nil
+}
+
+// readInt reads [int]
+func (r *reader) readInt() (int32, error) {
+	value, err := r.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(binary.BigEndian.Uint32(value)), nil
+}
+
+// readString reads [string]
+func (r *reader) readString() (string, error) {
+	length, err := r.readShort()
+	if err != nil {
+		return "", err
+	}
+	value, err := r.next(length)
+	return string(value), err
+}
+
+// readLongString reads [long string]
+func (r *reader) readLongString() (string, error) {
+	length, err := r.readInt()
+	if err != nil {
+		return "", err
+	}
+	value, err := r.next(int(length))
+	return string(value), err
+}
+
+// readShortBytes reads [short bytes]
+func (r *reader) readShortBytes() ([]byte, error) {
+	length, err := r.readShort()
+	if err != nil {
+		return nil, err
+	}
+	return r.next(length)
+}
+
+// readBytes reads [bytes] or [value], returns nil for null and not set values
+func (r *reader) readBytes() ([]byte, error) {
+	length, err := r.readInt()
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		return nil, nil
+	}
+	return r.next(int(length))
+}
+
+// skipStringList skips [string list]
+func (r *reader) skipStringList() error {
+	count, err := r.readShort()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		if _, err := r.readString(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipBytesMap skips [bytes map]
+func (r *reader) skipBytesMap() error {
+	count, err := r.readShort()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		if _, err := r.readString(); err != nil {
+			return err
+		}
+		if _, err := r.readBytes(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipValues skips [short] n followed by n [value], values may be preceded by names
+func (r *reader) skipValues(withNames bool) error {
+	count, err := r.readShort()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		if withNames {
+			if _, err := r.readString(); err != nil {
+				return err
+			}
+		}
+		if _, err := r.readBytes(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendShort(output []byte, value int) []byte {
+	return append(output, byte(value>>8), byte(value))
+}
+
+func appendInt(output []byte, value int32) []byte {
+	return append(output, byte(value>>24), byte(value>>16), byte(value>>8), byte(value))
+}
+
+func appendString(output []byte, value string) []byte {
+	return append(appendShort(output, len(value)), value...)
+}
+
+// appendBytes appends [bytes], nil value is appended as null
+func appendBytes(output []byte, value []byte) []byte {
+	if value == nil {
+		return appendInt(output, -1)
+	}
+	return append(appendInt(output, int32(len(value))), value...)
+}
+
+// messageOffset returns offset of message in body of frame after tracing id, warnings and custom payload
+func messageOffset(frame *Frame) (int, error) {
+	r := newReader(frame.Body)
+	isResponse := frame.Version&responseBit != 0
+	if isResponse && frame.Flags&FlagTracing != 0 {
+		// tracing session id is [uuid]
+		if _, err := r.next(16); err != nil {
+			return 0, err
+		}
+	}
+	if isResponse && frame.Flags&FlagWarning != 0 {
+		if err := r.skipStringList(); err != nil {
+			return 0, err
+		}
+	}
+	if frame.Flags&FlagPayload != 0 {
+		if err := r.skipBytesMap(); err != nil {
+			return 0, err
+		}
+	}
+	return r.offset, nil
+}
@@ -0,0 +1,267 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cassandra
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/cossacklabs/acra/acra-censor"
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// Codes of ERROR messages sent by proxy
+const (
+	ErrorCodeProtocol     = 0x000A
+	ErrorCodeUnauthorized = 0x2100
+)
+
+// Proxy forwards frames of CQL native protocol between client and database, checks CQL statements of client with
+// AcraCensor and decrypts AcraStructs stored as values of columns in result frames of database
+type Proxy struct {
+	clientConnection net.Conn
+	dbConnection     net.Conn
+	decryptor        base.Decryptor
+	censor           acracensor.AcraCensorInterface
+	logger           *log.Entry
+	// connectionStats accumulates counters of client's connection, may be nil
+	connectionStats *base.ConnectionStats
+	// onStartupFinished is called once when database accepted STARTUP or authentication of client, may be nil
+	onStartupFinished func()
+	// censorConnection describes client's connection for AcraCensor, filled from STARTUP options and keyspace
+	// changed by USE statements
+	censorConnection acracensor.ConnectionInfo
+	censorLock       sync.Mutex
+}
+
+// NewProxy returns proxy of client's connection which checks statements with censor and decrypts results with
+// decryptor
+func NewProxy(clientConnection, dbConnection net.Conn, decryptor base.Decryptor, censor acracensor.AcraCensorInterface) *Proxy {
+	return &Proxy{clientConnection: clientConnection, dbConnection: dbConnection, decryptor: decryptor, censor: censor,
+		logger: log.NewEntry(log.StandardLogger())}
+}
+
+// SetLogger sets logger of client's connection used by proxy instead of standard logger
+func (proxy *Proxy) SetLogger(logger *log.Entry) {
+	proxy.logger = logger
+}
+
+// SetConnectionStats sets counters of client's connection updated by proxy
+func (proxy *Proxy) SetConnectionStats(stats *base.ConnectionStats) {
+	proxy.connectionStats = stats
+	if stats != nil {
+		proxy.censorConnection.ClientID = stats.ClientID
+	}
+}
+
+// SetStartupCallback sets function called once when database sent READY or AUTH_SUCCESS message
+func (proxy *Proxy) SetStartupCallback(callback func()) {
+	proxy.onStartupFinished = callback
+}
+
+func (proxy *Proxy) getCensorConnection() acracensor.ConnectionInfo {
+	proxy.censorLock.Lock()
+	defer proxy.censorLock.Unlock()
+	return proxy.censorConnection
+}
+
+func (proxy *Proxy) setKeyspace(keyspace string) {
+	proxy.censorLock.Lock()
+	proxy.censorConnection.Database = keyspace
+	proxy.censorLock.Unlock()
+}
+
+func (proxy *Proxy) setApplication(application string) {
+	proxy.censorLock.Lock()
+	proxy.censorConnection.Application = application
+	proxy.censorLock.Unlock()
+}
+
+// newErrorFrame returns ERROR message of database as answer on frame of client
+func newErrorFrame(version byte, stream int16, code int32, message string) *Frame {
+	body := appendString(appendInt(nil, code), message)
+	return &Frame{Version: version | responseBit, Stream: stream, Opcode: OpError, Body: body}
+}
+
+// writeError sends ERROR message to client as answer on frame
+func (proxy *Proxy) writeError(frame *Frame, version byte, code int32, message string) error {
+	_, err := proxy.clientConnection.Write(newErrorFrame(version, frame.Stream, code, message).Dump())
+	return err
+}
+
+// ProxyClientRequests checks CQL statements of QUERY, PREPARE and BATCH messages with AcraCensor and forwards frames
+// to database. Blocked statements are answered with Unauthorized error. Client is asked to downgrade to supported
+// version of protocol same way as database does it
+func (proxy *Proxy) ProxyClientRequests(errCh chan<- error) {
+	logger := proxy.logger.WithField("proxy", "client")
+	for {
+		frame, err := ReadFrame(proxy.clientConnection)
+		if err != nil {
+			logger.WithError(err).Debugln("Can't read frame from client")
+			errCh <- err
+			return
+		}
+		if !frame.IsSupportedVersion() {
+			logger.WithField("version", frame.ProtocolVersion()).Debugln("Client uses unsupported version of protocol, ask to downgrade")
+			message := fmt.Sprintf("Invalid or unsupported protocol version (%d); supported versions are (%d/v%d, %d/v%d)",
+				frame.ProtocolVersion(), MinVersion, MinVersion, MaxVersion, MaxVersion)
+			if err := proxy.writeError(frame, MaxVersion, ErrorCodeProtocol, message); err != nil {
+				errCh <- err
+				return
+			}
+			continue
+		}
+		if frame.Flags&FlagCompression != 0 {
+			logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorProtocolProcessing).
+				Warningln("Client sent compressed frame which can't be checked, forward it as is")
+		} else {
+			blocked, err := proxy.processClientFrame(frame, logger)
+			if err != nil {
+				logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorProtocolProcessing).
+					Errorln("Can't process frame from client")
+				errCh <- err
+				return
+			}
+			if blocked {
+				continue
+			}
+		}
+		if _, err := proxy.dbConnection.Write(frame.Dump()); err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorResponseConnectorCantWriteToDB).
+				Debugln("Can't forward frame to database")
+			errCh <- err
+			return
+		}
+	}
+}
+
+// processClientFrame removes compression from STARTUP message and checks statements with AcraCensor. Returns true if
+// frame was blocked and client was answered with error
+func (proxy *Proxy) processClientFrame(frame *Frame, logger *log.Entry) (bool, error) {
+	offset, err := messageOffset(frame)
+	if err != nil {
+		return false, err
+	}
+	message := frame.Body[offset:]
+	switch frame.Opcode {
+	case OpStartup:
+		startup, options, err := processStartup(message)
+		if err != nil {
+			return false, err
+		}
+		frame.Body = append(frame.Body[:offset:offset], startup...)
+		application := options[ApplicationNameOption]
+		proxy.connectionStats.SetTags(application, options)
+		proxy.setApplication(application)
+		return false, nil
+	case OpExecute:
+		proxy.connectionStats.AddQuery()
+		return false, nil
+	case OpQuery, OpPrepare, OpBatch:
+		proxy.connectionStats.AddQuery()
+		queries, err := statements(frame.Opcode, message)
+		if err != nil {
+			return false, err
+		}
+		if proxy.censor == nil {
+			return false, nil
+		}
+		connection := proxy.getCensorConnection()
+		for _, query := range queries {
+			if censorErr := proxy.censor.HandleConnectionQuery(connection, query); censorErr != nil {
+				logger.WithError(censorErr).Errorln("AcraCensor blocked query")
+				return true, proxy.writeError(frame, frame.ProtocolVersion(), ErrorCodeUnauthorized, "AcraCensor blocked this query")
+			}
+		}
+	}
+	return false, nil
+}
+
+// DecryptReplies decrypts values of rows in RESULT messages from database and forwards frames to client. Compression
+// is removed from SUPPORTED message, so database doesn't compress frames which would be forwarded encrypted
+func (proxy *Proxy) DecryptReplies(ctx context.Context, errCh chan<- error) {
+	logger := proxy.logger.WithField("proxy", "server")
+	for {
+		frame, err := ReadFrame(proxy.dbConnection)
+		if err != nil {
+			logger.WithError(err).Debugln("Can't read frame from database")
+			errCh <- err
+			return
+		}
+		if frame.Flags&FlagCompression != 0 {
+			logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorProtocolProcessing).
+				Warningln("Database sent compressed frame, forward it without decryption")
+		} else if err := proxy.processDBFrame(ctx, frame, logger); err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorProtocolProcessing).
+				Errorln("Can't process frame from database")
+			errCh <- err
+			return
+		}
+		if _, err := proxy.clientConnection.Write(frame.Dump()); err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorResponseConnectorCantWriteToClient).
+				Debugln("Can't forward frame to client")
+			errCh <- err
+			return
+		}
+	}
+}
+
+func (proxy *Proxy) processDBFrame(ctx context.Context, frame *Frame, logger *log.Entry) error {
+	switch frame.Opcode {
+	case OpReady, OpAuthSuccess:
+		if proxy.onStartupFinished != nil {
+			proxy.onStartupFinished()
+			proxy.onStartupFinished = nil
+		}
+		return nil
+	case OpSupported, OpResult:
+	default:
+		return nil
+	}
+	offset, err := messageOffset(frame)
+	if err != nil {
+		return err
+	}
+	message := frame.Body[offset:]
+	if frame.Opcode == OpSupported {
+		supported, err := processSupported(message)
+		if err != nil {
+			return err
+		}
+		frame.Body = append(frame.Body[:offset:offset], supported...)
+		return nil
+	}
+	process := func(column Column, value []byte) ([]byte, bool, error) {
+		return base.DecryptValue(ctx, proxy.decryptor, proxy.connectionStats, column.Table, column.Name, value, logger)
+	}
+	result, changed, keyspace, err := processResult(message, process)
+	proxy.decryptor.ResetZoneMatch()
+	if err != nil {
+		return err
+	}
+	if keyspace != "" {
+		proxy.setKeyspace(keyspace)
+	}
+	if changed {
+		frame.Body = append(frame.Body[:offset:offset], result...)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cassandra
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/cossacklabs/acra/acra-censor"
+	"github.com/cossacklabs/acra/decryptor/base"
+)
+
+var testBeginTag = bytes.Repeat([]byte{'"'}, 8)
+
+// testDecryptor decrypts values which start with testBeginTag and contain "valid" by removing tag
+type testDecryptor struct {
+	base.Decryptor
+}
+
+func (*testDecryptor) IsWithZone() bool            { return false }
+func (*testDecryptor) Reset()                      {}
+func (*testDecryptor) ResetZoneMatch()             {}
+func (*testDecryptor) IsPoisonRecordCheckOn() bool { return false }
+func (*testDecryptor) BeginTagIndex(block []byte) (int, int) {
+	return bytes.Index(block, testBeginTag), len(testBeginTag)
+}
+func (*testDecryptor) DecryptBlock(block []byte) ([]byte, error) {
+	if !bytes.Contains(block, []byte("valid")) {
+		return nil, errors.New("invalid AcraStruct")
+	}
+	return block[len(testBeginTag):], nil
+}
+
+// testCensor blocks queries which contain "DROP" and saves connection of last query
+type testCensor struct {
+	acracensor.AcraCensorInterface
+	connection acracensor.ConnectionInfo
+}
+
+func (censor *testCensor) HandleConnectionQuery(connection acracensor.ConnectionInfo, query string) error {
+	censor.connection = connection
+	if strings.Contains(query, "DROP") {
+		return errors.New("query blocked")
+	}
+	return nil
+}
+
+func newQueryFrame(stream int16, query string) *Frame {
+	body := append(appendInt(nil, int32(len(query))), query...)
+	return &Frame{Version: MaxVersion, Stream: stream, Opcode: OpQuery, Body: appendShort(body, 1)}
+}
+
+func TestProxyClientRequests(t *testing.T) {
+	client, proxyClient := net.Pipe()
+	db, proxyDB := net.Pipe()
+	defer client.Close()
+	defer db.Close()
+	censor := &testCensor{}
+	proxy := NewProxy(proxyClient, proxyDB, &testDecryptor{}, censor)
+	proxy.SetConnectionStats(base.NewConnectionStats(1, []byte("client"), ""))
+	errCh := make(chan error, 1)
+	go proxy.ProxyClientRequests(errCh)
+
+	startup := appendShort(nil, 2)
+	startup = appendString(appendString(startup, "COMPRESSION"), "snappy")
+	startup = appendString(appendString(startup, ApplicationNameOption), "reporting")
+	go client.Write((&Frame{Version: MaxVersion, Opcode: OpStartup, Body: startup}).Dump())
+	frame, err := ReadFrame(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := appendString(appendString(appendShort(nil, 1), ApplicationNameOption), "reporting")
+	if frame.Opcode != OpStartup || !bytes.Equal(frame.Body, expected) {
+		t.Fatalf("Unexpected STARTUP frame %q", frame.Body)
+	}
+
+	go client.Write(newQueryFrame(5, "SELECT * FROM users").Dump())
+	if frame, err = ReadFrame(db); err != nil {
+		t.Fatal(err)
+	}
+	if frame.Stream != 5 || frame.Opcode != OpQuery {
+		t.Fatalf("Unexpected QUERY frame %+v", frame)
+	}
+	if censor.connection.Application != "reporting" || string(censor.connection.ClientID) != "client" {
+		t.Fatalf("Unexpected censor connection %+v", censor.connection)
+	}
+
+	// blocked query is answered by proxy
+	go client.Write(newQueryFrame(6, "DROP TABLE users").Dump())
+	if frame, err = ReadFrame(client); err != nil {
+		t.Fatal(err)
+	}
+	if frame.Stream != 6 || frame.Opcode != OpError || frame.Version != MaxVersion|responseBit {
+		t.Fatalf("Unexpected answer on blocked query %+v", frame)
+	}
+	if code, err := newReader(frame.Body).readInt(); err != nil || code != ErrorCodeUnauthorized {
+		t.Fatalf("Unexpected error code %v", code)
+	}
+
+	// client is asked to downgrade unsupported version
+	go client.Write((&Frame{Version: 5, Stream: 1, Opcode: OpOptions}).Dump())
+	if frame, err = ReadFrame(client); err != nil {
+		t.Fatal(err)
+	}
+	if frame.Opcode != OpError || frame.Version != MaxVersion|responseBit {
+		t.Fatalf("Unexpected answer on unsupported version %+v", frame)
+	}
+	if code, err := newReader(frame.Body).readInt(); err != nil || code != ErrorCodeProtocol {
+		t.Fatalf("Unexpected error code %v", code)
+	}
+}
+
+func TestProxyDecryptReplies(t *testing.T) {
+	client, proxyClient := net.Pipe()
+	db, proxyDB := net.Pipe()
+	defer client.Close()
+	defer db.Close()
+	proxy := NewProxy(proxyClient, proxyDB, &testDecryptor{}, nil)
+	startupFinished := false
+	proxy.SetStartupCallback(func() { startupFinished = true })
+	errCh := make(chan error, 1)
+	go proxy.DecryptReplies(context.Background(), errCh)
+
+	go db.Write((&Frame{Version: MaxVersion | responseBit, Opcode: OpReady}).Dump())
+	if _, err := ReadFrame(client); err != nil {
+		t.Fatal(err)
+	}
+	if !startupFinished {
+		t.Fatal("Startup callback wasn't called on READY message")
+	}
+
+	acraStruct := append(append([]byte{}, testBeginTag...), bytes.Repeat([]byte("valid data "), 20)...)
+	invalidAcraStruct := append(append([]byte{}, testBeginTag...), bytes.Repeat([]byte("wrong data "), 20)...)
+	columns := []Column{{Name: "data", Type: TypeBlob}, {Name: "invalid", Type: TypeBlob}}
+	rows := newRows(metadataGlobalTablesSpec, columns, [][][]byte{{acraStruct, invalidAcraStruct}})
+	// tracing id precedes message
+	tracingID := bytes.Repeat([]byte{1}, 16)
+	go db.Write((&Frame{Version: MaxVersion | responseBit, Flags: FlagTracing, Stream: 3, Opcode: OpResult, Body: append(tracingID, rows...)}).Dump())
+	frame, err := ReadFrame(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := append(tracingID, newRows(metadataGlobalTablesSpec, columns, [][][]byte{{acraStruct[len(testBeginTag):], invalidAcraStruct}})...)
+	if frame.Stream != 3 || !bytes.Equal(frame.Body, expected) {
+		t.Fatalf("Unexpected decrypted frame %q", frame.Body)
+	}
+
+	// malformed message stops proxy
+	go db.Write((&Frame{Version: MaxVersion | responseBit, Opcode: OpResult, Body: rows[:10]}).Dump())
+	if err := <-errCh; err != ErrMalformedFrame {
+		t.Fatalf("Expected ErrMalformedFrame, took %v", err)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	frame := &Frame{Version: MaxVersion, Flags: FlagTracing, Stream: -2, Opcode: OpQuery, Body: []byte{1, 2, 3}}
+	read, err := ReadFrame(bytes.NewReader(frame.Dump()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Version != MaxVersion || read.Flags != FlagTracing || read.Stream != -2 || read.Opcode != OpQuery || !bytes.Equal(read.Body, frame.Body) {
+		t.Fatalf("Unexpected frame %+v", read)
+	}
+	tooLarge := frame.Dump()
+	tooLarge[5] = 0x7f
+	if _, err := ReadFrame(bytes.NewReader(tooLarge)); err != ErrFrameTooLarge {
+		t.Fatalf("Expected ErrFrameTooLarge, took %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cassandra
+
+// Kinds of RESULT messages
+const (
+	ResultVoid         = 0x0001
+	ResultRows         = 0x0002
+	ResultSetKeyspace  = 0x0003
+	ResultPrepared     = 0x0004
+	ResultSchemaChange = 0x0005
+)
+
+// Flags of metadata of rows
+const (
+	metadataGlobalTablesSpec = 0x0001
+	metadataHasMorePages     = 0x0002
+	metadataNoMetadata       = 0x0004
+)
+
+// Types of columns which values are stored as is and may contain AcraStructs
+const (
+	TypeCustom  = 0x0000
+	TypeASCII   = 0x0001
+	TypeBlob    = 0x0003
+	TypeVarchar = 0x000D
+	TypeList    = 0x0020
+	TypeMap     = 0x0021
+	TypeSet     = 0x0022
+	TypeUDT     = 0x0030
+	TypeTuple   = 0x0031
+	// TypeUnknown is used for columns of rows without metadata
+	TypeUnknown = -1
+)
+
+// Column describes column of rows from metadata of RESULT message
+type Column struct {
+	Keyspace string
+	Table    string
+	Name     string
+	Type     int
+}
+
+// MayContainAcraStruct returns true if values of column are stored as is, so AcraStruct may be found in them
+func (column Column) MayContainAcraStruct() bool {
+	switch column.Type {
+	case TypeASCII, TypeBlob, TypeVarchar, TypeUnknown:
+		return true
+	}
+	return false
+}
+
+// ValueProcessor returns processed value of column and true if value changed
+type ValueProcessor func(column Column, value []byte) ([]byte, bool, error)
+
+// skipOption skips [option] which describes type of column and returns id of type
+func skipOption(r *reader) (int, error) {
+	id, err := r.readShort()
+	if err != nil {
+		return 0, err
+	}
+	switch id {
+	case TypeCustom:
+		_, err = r.readString()
+	case TypeList, TypeSet:
+		_, err = skipOption(r)
+	case TypeMap:
+		if _, err = skipOption(r); err == nil {
+			_, err = skipOption(r)
+		}
+	case TypeUDT:
+		if _, err = r.readString(); err != nil {
+			return 0, err
+		}
+		if _, err = r.readString(); err != nil {
+			return 0, err
+		}
+		var count int
+		if count, err = r.readShort(); err != nil {
+			return 0, err
+		}
+		for i := 0; i < count && err == nil; i++ {
+			if _, err = r.readString(); err == nil {
+				_, err = skipOption(r)
+			}
+		}
+	case TypeTuple:
+		var count int
+		if count, err = r.readShort(); err != nil {
+			return 0, err
+		}
+		for i := 0; i < count && err == nil; i++ {
+			_, err = skipOption(r)
+		}
+	}
+	return id, err
+}
+
+// readRowsMetadata reads metadata of rows and returns description of columns. Columns have TypeUnknown if database
+// skipped metadata because client already has it from PREPARED message
+func readRowsMetadata(r *reader) ([]Column, error) {
+	flags, err := r.readInt()
+	if err != nil {
+		return nil, err
+	}
+	count, err := r.readInt()
+	if err != nil {
+		return nil, err
+	}
+	if count < 0 {
+		return nil, ErrMalformedFrame
+	}
+	if flags&metadataHasMorePages != 0 {
+		// paging state
+		if _, err := r.readBytes(); err != nil {
+			return nil, err
+		}
+	}
+	columns := make([]Column, count)
+	if flags&metadataNoMetadata != 0 {
+		for i := range columns {
+			columns[i].Type = TypeUnknown
+		}
+		return columns, nil
+	}
+	var keyspace, table string
+	if flags&metadataGlobalTablesSpec != 0 {
+		if keyspace, err = r.readString(); err != nil {
+			return nil, err
+		}
+		if table, err = r.readString(); err != nil {
+			return nil, err
+		}
+	}
+	for i := range columns {
+		columns[i].Keyspace, columns[i].Table = keyspace, table
+		if flags&metadataGlobalTablesSpec == 0 {
+			if columns[i].Keyspace, err = r.readString(); err != nil {
+				return nil, err
+			}
+			if columns[i].Table, err = r.readString(); err != nil {
+				return nil, err
+			}
+		}
+		if columns[i].Name, err = r.readString(); err != nil {
+			return nil, err
+		}
+		if columns[i].Type, err = skipOption(r); err != nil {
+			return nil, err
+		}
+	}
+	return columns, nil
+}
+
+// processRows processes values of columns which may contain AcraStructs in Rows message and returns message with
+// processed values and true if some value changed
+func processRows(message []byte, process ValueProcessor) ([]byte, bool, error) {
+	r := newReader(message)
+	// kind of result
+	if _, err := r.readInt(); err != nil {
+		return nil, false, err
+	}
+	columns, err := readRowsMetadata(r)
+	if err != nil {
+		return nil, false, err
+	}
+	rowsCount, err := r.readInt()
+	if err != nil {
+		return nil, false, err
+	}
+	if rowsCount < 0 {
+		return nil, false, ErrMalformedFrame
+	}
+	output := make([]byte, r.offset, len(message))
+	copy(output, message[:r.offset])
+	changed := false
+	for row := 0; row < int(rowsCount); row++ {
+		for _, column := range columns {
+			value, err := r.readBytes()
+			if err != nil {
+				return nil, false, err
+			}
+			if value != nil && column.MayContainAcraStruct() {
+				processed, valueChanged, err := process(column, value)
+				if err != nil {
+					return nil, false, err
+				}
+				if valueChanged {
+					value = processed
+					changed = true
+				}
+			}
+			output = appendBytes(output, value)
+		}
+	}
+	if r.offset != len(message) {
+		return nil, false, ErrMalformedFrame
+	}
+	if !changed {
+		return message, false, nil
+	}
+	return output, true, nil
+}
+
+// processResult processes values of Rows message and returns name of keyspace of Set_keyspace message
+func processResult(message []byte, process ValueProcessor) ([]byte, bool, string, error) {
+	kind, err := newReader(message).readInt()
+	if err != nil {
+		return nil, false, "", err
+	}
+	switch kind {
+	case ResultRows:
+		output, changed, err := processRows(message, process)
+		return output, changed, "", err
+	case ResultSetKeyspace:
+		r := newReader(message[4:])
+		keyspace, err := r.readString()
+		return message, false, keyspace, err
+	}
+	return message, false, "", nil
+}
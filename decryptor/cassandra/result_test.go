@@ -0,0 +1,118 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cassandra
+
+import (
+	"bytes"
+	"testing"
+)
+
+// newRows returns Rows message with columns of one table and rows of values
+func newRows(flags int32, columns []Column, rows [][][]byte) []byte {
+	message := appendInt(nil, ResultRows)
+	message = appendInt(message, flags)
+	message = appendInt(message, int32(len(columns)))
+	if flags&metadataNoMetadata == 0 {
+		message = appendString(appendString(message, "keyspace"), "table")
+		for _, column := range columns {
+			message = appendString(message, column.Name)
+			message = appendShort(message, column.Type)
+			if column.Type == TypeList {
+				message = appendShort(message, TypeBlob)
+			}
+		}
+	}
+	message = appendInt(message, int32(len(rows)))
+	for _, row := range rows {
+		for _, value := range row {
+			message = appendBytes(message, value)
+		}
+	}
+	return message
+}
+
+func TestProcessRows(t *testing.T) {
+	columns := []Column{
+		{Name: "id", Type: 0x0009},
+		{Name: "data", Type: TypeBlob},
+		{Name: "list", Type: TypeList},
+		{Name: "name", Type: TypeVarchar},
+	}
+	process := func(column Column, value []byte) ([]byte, bool, error) {
+		if !bytes.HasPrefix(value, []byte("encrypted ")) {
+			return value, false, nil
+		}
+		if column.Keyspace != "keyspace" || column.Table != "table" {
+			t.Fatalf("Unexpected column %+v", column)
+		}
+		return value[len("encrypted "):], true, nil
+	}
+	rows := [][][]byte{
+		{[]byte("encrypted 1"), []byte("encrypted data"), []byte("encrypted list"), nil},
+		{[]byte("encrypted 2"), nil, nil, []byte("encrypted name")},
+	}
+	expectedRows := [][][]byte{
+		{[]byte("encrypted 1"), []byte("data"), []byte("encrypted list"), nil},
+		{[]byte("encrypted 2"), nil, nil, []byte("name")},
+	}
+	output, changed, keyspace, err := processResult(newRows(metadataGlobalTablesSpec, columns, rows), process)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed || keyspace != "" {
+		t.Fatal("Expected changed rows")
+	}
+	if expected := newRows(metadataGlobalTablesSpec, columns, expectedRows); !bytes.Equal(output, expected) {
+		t.Fatalf("Unexpected rows %q, expected %q", output, expected)
+	}
+
+	// without metadata all values are processed
+	output, changed, _, err = processResult(newRows(metadataNoMetadata, columns, rows[:1]), func(column Column, value []byte) ([]byte, bool, error) {
+		if column.Type != TypeUnknown {
+			t.Fatalf("Unexpected type of column without metadata %+v", column)
+		}
+		return []byte("decrypted"), true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := newRows(metadataNoMetadata, columns, [][][]byte{{[]byte("decrypted"), []byte("decrypted"), []byte("decrypted"), nil}}); !changed || !bytes.Equal(output, expected) {
+		t.Fatalf("Unexpected rows without metadata %q", output)
+	}
+
+	// unchanged message is returned as is
+	message := newRows(metadataGlobalTablesSpec, columns, expectedRows[:1])
+	if output, changed, _, err = processResult(message, process); err != nil || changed || !bytes.Equal(output, message) {
+		t.Fatal("Expected unchanged rows")
+	}
+
+	// truncated rows
+	if _, _, _, err := processResult(message[:len(message)-1], process); err != ErrMalformedFrame {
+		t.Fatalf("Expected ErrMalformedFrame, took %v", err)
+	}
+}
+
+func TestProcessResultSetKeyspace(t *testing.T) {
+	message := appendString(appendInt(nil, ResultSetKeyspace), "analytics")
+	output, changed, keyspace, err := processResult(message, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed || keyspace != "analytics" || !bytes.Equal(output, message) {
+		t.Fatalf("Unexpected result of Set_keyspace message, keyspace %q", keyspace)
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cassandra
+
+import (
+	"strings"
+)
+
+// Options of STARTUP and SUPPORTED messages
+const (
+	compressionOption = "COMPRESSION"
+	// ApplicationNameOption is name of client's application sent by drivers which support it
+	ApplicationNameOption = "APPLICATION_NAME"
+)
+
+// processStartup returns body of STARTUP message without COMPRESSION option, so database doesn't compress frames
+// which should be decrypted, and other options
+func processStartup(message []byte) ([]byte, map[string]string, error) {
+	r := newReader(message)
+	count, err := r.readShort()
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]string, 0, count)
+	options := make(map[string]string, count)
+	for i := 0; i < count; i++ {
+		key, err := r.readString()
+		if err != nil {
+			return nil, nil, err
+		}
+		value, err := r.readString()
+		if err != nil {
+			return nil, nil, err
+		}
+		if strings.ToUpper(key) == compressionOption {
+			continue
+		}
+		keys = append(keys, key)
+		options[key] = value
+	}
+	output := appendShort(make([]byte, 0, len(message)), len(keys))
+	for _, key := range keys {
+		output = appendString(appendString(output, key), options[key])
+	}
+	return output, options, nil
+}
+
+// processSupported returns body of SUPPORTED message without COMPRESSION option, so client doesn't ask compression
+func processSupported(message []byte) ([]byte, error) {
+	r := newReader(message)
+	count, err := r.readShort()
+	if err != nil {
+		return nil, err
+	}
+	output := make([]byte, 2, len(message))
+	written := 0
+	for i := 0; i < count; i++ {
+		start := r.offset
+		key, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		if err := r.skipStringList(); err != nil {
+			return nil, err
+		}
+		if strings.ToUpper(key) == compressionOption {
+			continue
+		}
+		output = append(output, message[start:r.offset]...)
+		written++
+	}
+	appendShort(output[:0], written)
+	return output, nil
+}
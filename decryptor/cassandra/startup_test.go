@@ -0,0 +1,99 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cassandra
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestProcessStartup(t *testing.T) {
+	message := appendShort(nil, 3)
+	message = appendString(appendString(message, "CQL_VERSION"), "3.0.0")
+	message = appendString(appendString(message, "COMPRESSION"), "lz4")
+	message = appendString(appendString(message, ApplicationNameOption), "reporting")
+	output, options, err := processStartup(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := appendShort(nil, 2)
+	expected = appendString(appendString(expected, "CQL_VERSION"), "3.0.0")
+	expected = appendString(appendString(expected, ApplicationNameOption), "reporting")
+	if !bytes.Equal(output, expected) {
+		t.Fatalf("Unexpected STARTUP message %q", output)
+	}
+	if len(options) != 2 || options[ApplicationNameOption] != "reporting" {
+		t.Fatalf("Unexpected options %v", options)
+	}
+	if _, _, err := processStartup(message[:5]); err != ErrMalformedFrame {
+		t.Fatalf("Expected ErrMalformedFrame, took %v", err)
+	}
+}
+
+func TestProcessSupported(t *testing.T) {
+	message := appendShort(nil, 2)
+	message = appendShort(appendString(message, "COMPRESSION"), 2)
+	message = appendString(appendString(message, "snappy"), "lz4")
+	message = appendShort(appendString(message, "CQL_VERSION"), 1)
+	message = appendString(message, "3.4.5")
+	output, err := processSupported(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := appendShort(nil, 1)
+	expected = appendShort(appendString(expected, "CQL_VERSION"), 1)
+	expected = appendString(expected, "3.4.5")
+	if !bytes.Equal(output, expected) {
+		t.Fatalf("Unexpected SUPPORTED message %q", output)
+	}
+}
+
+func TestStatements(t *testing.T) {
+	query := append(appendInt(nil, 8), "SELECT 1"...)
+	queries, err := statements(OpQuery, append(query, 0, 1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 1 || queries[0] != "SELECT 1" {
+		t.Fatalf("Unexpected statements %v", queries)
+	}
+
+	batch := []byte{0}
+	batch = appendShort(batch, 3)
+	batch = append(batch, batchQueryKind)
+	batch = append(appendInt(batch, 13), "INSERT INTO a"...)
+	batch = appendBytes(appendShort(batch, 1), []byte("value"))
+	batch = append(batch, batchPreparedKind)
+	batch = append(appendShort(batch, 2), 1, 2)
+	batch = appendShort(batch, 0)
+	batch = append(batch, batchQueryKind)
+	batch = append(appendInt(batch, 13), "DELETE FROM b"...)
+	batch = appendBytes(appendShort(batch, 1), nil)
+	queries, err = statements(OpBatch, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 2 || queries[0] != "INSERT INTO a" || queries[1] != "DELETE FROM b" {
+		t.Fatalf("Unexpected statements of batch %v", queries)
+	}
+	if _, err := statements(OpBatch, batch[:len(batch)-2]); err != ErrMalformedFrame {
+		t.Fatalf("Expected ErrMalformedFrame, took %v", err)
+	}
+	if queries, err := statements(OpExecute, []byte{1}); err != nil || queries != nil {
+		t.Fatal("Expected no statements for EXECUTE message")
+	}
+}
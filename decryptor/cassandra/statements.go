@@ -0,0 +1,73 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cassandra
+
+// Kinds of statements in BATCH message
+const (
+	batchQueryKind    = 0
+	batchPreparedKind = 1
+)
+
+// statements returns CQL statements of QUERY, PREPARE and BATCH messages which should be checked with AcraCensor.
+// EXECUTE messages and prepared statements in batches are checked when they were prepared
+func statements(opcode byte, message []byte) ([]string, error) {
+	r := newReader(message)
+	switch opcode {
+	case OpQuery, OpPrepare:
+		query, err := r.readLongString()
+		if err != nil {
+			return nil, err
+		}
+		return []string{query}, nil
+	case OpBatch:
+		// type of batch
+		if _, err := r.readByte(); err != nil {
+			return nil, err
+		}
+		count, err := r.readShort()
+		if err != nil {
+			return nil, err
+		}
+		queries := make([]string, 0, count)
+		for i := 0; i < count; i++ {
+			kind, err := r.readByte()
+			if err != nil {
+				return nil, err
+			}
+			switch kind {
+			case batchQueryKind:
+				query, err := r.readLongString()
+				if err != nil {
+					return nil, err
+				}
+				queries = append(queries, query)
+			case batchPreparedKind:
+				if _, err := r.readShortBytes(); err != nil {
+					return nil, err
+				}
+			default:
+				return nil, ErrMalformedFrame
+			}
+			// names of values in batches aren't supported by protocol v3 and v4
+			if err := r.skipValues(false); err != nil {
+				return nil, err
+			}
+		}
+		return queries, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mongodb
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/cossacklabs/acra/acraerrors"
+)
+
+// ErrMalformedBSON returned if document can't be parsed
+var ErrMalformedBSON = acraerrors.New(acraerrors.CodeMalformedBSON, "malformed BSON document")
+
+// Types of BSON elements
+// https://bsonspec.org/spec.html
+const (
+	TypeDouble              = 0x01
+	TypeString              = 0x02
+	TypeDocument            = 0x03
+	TypeArray               = 0x04
+	TypeBinary              = 0x05
+	TypeUndefined           = 0x06
+	TypeObjectID            = 0x07
+	TypeBoolean             = 0x08
+	TypeDateTime            = 0x09
+	TypeNull                = 0x0a
+	TypeRegexp              = 0x0b
+	TypeDBPointer           = 0x0c
+	TypeJavaScript          = 0x0d
+	TypeSymbol              = 0x0e
+	TypeJavaScriptWithScope = 0x0f
+	TypeInt32               = 0x10
+	TypeTimestamp           = 0x11
+	TypeInt64               = 0x12
+	TypeDecimal128          = 0x13
+	TypeMinKey              = 0xff
+	TypeMaxKey              = 0x7f
+)
+
+// fixedSizes are sizes of values of types with fixed size
+var fixedSizes = map[byte]int{
+	TypeDouble: 8, TypeUndefined: 0, TypeObjectID: 12, TypeBoolean: 1, TypeDateTime: 8, TypeNull: 0, TypeInt32: 4,
+	TypeTimestamp: 8, TypeInt64: 8, TypeDecimal128: 16, TypeMinKey: 0, TypeMaxKey: 0,
+}
+
+// ValueProcessor returns new value of string or binary element with key and true if value was changed. Data of
+// binary value is passed without subtype. Error stops processing of document
+type ValueProcessor func(key string, value []byte) ([]byte, bool, error)
+
+// documentLength returns length of document in the beginning of data
+func documentLength(data []byte) (int, error) {
+	if len(data) < 5 {
+		return 0, ErrMalformedBSON
+	}
+	length := int(int32(binary.LittleEndian.Uint32(data)))
+	if length < 5 || length > len(data) || data[length-1] != 0 {
+		return 0, ErrMalformedBSON
+	}
+	return length, nil
+}
+
+// valueLength returns length of value of element with type in the beginning of data
+func valueLength(elementType byte, data []byte) (int, error) {
+	if size, ok := fixedSizes[elementType]; ok {
+		if size > len(data) {
+			return 0, ErrMalformedBSON
+		}
+		return size, nil
+	}
+	switch elementType {
+	case TypeString, TypeJavaScript, TypeSymbol, TypeDBPointer:
+		if len(data) < 4 {
+			return 0, ErrMalformedBSON
+		}
+		length := 4 + int(int32(binary.LittleEndian.Uint32(data)))
+		if elementType == TypeDBPointer {
+			length += 12
+		}
+		if length < 5 || length > len(data) {
+			return 0, ErrMalformedBSON
+		}
+		return length, nil
+	case TypeDocument, TypeArray, TypeJavaScriptWithScope:
+		return documentLength(data)
+	case TypeBinary:
+		if len(data) < 5 {
+			return 0, ErrMalformedBSON
+		}
+		length := 5 + int(int32(binary.LittleEndian.Uint32(data)))
+		if length < 5 || length > len(data) {
+			return 0, ErrMalformedBSON
+		}
+		return length, nil
+	case TypeRegexp:
+		// pattern and options as two null-terminated strings
+		pattern := bytes.IndexByte(data, 0)
+		if pattern < 0 {
+			return 0, ErrMalformedBSON
+		}
+		options := bytes.IndexByte(data[pattern+1:], 0)
+		if options < 0 {
+			return 0, ErrMalformedBSON
+		}
+		return pattern + options + 2, nil
+	}
+	return 0, ErrMalformedBSON
+}
+
+// ProcessDocument passes values of string and binary elements of document and its embedded documents and arrays to
+// process and returns document with changed values and true if any value was changed. skipKeys are removed from
+// top level of document
+func ProcessDocument(document []byte, process ValueProcessor, skipKeys ...string) ([]byte, bool, error) {
+	length, err := documentLength(document)
+	if err != nil {
+		return nil, false, err
+	}
+	var output []byte
+	changed := false
+	position := 4
+	for position < length-1 {
+		elementStart := position
+		elementType := document[position]
+		keyEnd := bytes.IndexByte(document[position+1:length], 0)
+		if keyEnd < 0 {
+			return nil, false, ErrMalformedBSON
+		}
+		key := string(document[position+1 : position+1+keyEnd])
+		position += keyEnd + 2
+		size, err := valueLength(elementType, document[position:length-1])
+		if err != nil {
+			return nil, false, err
+		}
+		value := document[position : position+size]
+		position += size
+		if isSkippedKey(key, skipKeys) {
+			if !changed {
+				output = append([]byte{}, document[:elementStart]...)
+				changed = true
+			}
+			continue
+		}
+		newValue, valueChanged, err := processValue(elementType, key, value, process)
+		if err != nil {
+			return nil, false, err
+		}
+		if valueChanged && !changed {
+			output = append([]byte{}, document[:elementStart]...)
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		output = append(output, document[elementStart:elementStart+keyEnd+2]...)
+		output = append(output, newValue...)
+	}
+	if !changed {
+		return document[:length], false, nil
+	}
+	output = append(output, 0)
+	binary.LittleEndian.PutUint32(output, uint32(len(output)))
+	return output, true, nil
+}
+
+func isSkippedKey(key string, skipKeys []string) bool {
+	for _, skipKey := range skipKeys {
+		if key == skipKey {
+			return true
+		}
+	}
+	return false
+}
+
+// processValue returns new value of element with type and true if value was changed
+func processValue(elementType byte, key string, value []byte, process ValueProcessor) ([]byte, bool, error) {
+	switch elementType {
+	case TypeDocument, TypeArray:
+		return ProcessDocument(value, process)
+	case TypeString:
+		// value without length and terminating null
+		newValue, changed, err := process(key, value[4:len(value)-1])
+		if err != nil || !changed {
+			return value, false, err
+		}
+		output := make([]byte, 4, 4+len(newValue)+1)
+		binary.LittleEndian.PutUint32(output, uint32(len(newValue)+1))
+		output = append(output, newValue...)
+		return append(output, 0), true, nil
+	case TypeBinary:
+		// value without length and subtype
+		newValue, changed, err := process(key, value[5:])
+		if err != nil || !changed {
+			return value, false, err
+		}
+		output := make([]byte, 5, 5+len(newValue))
+		binary.LittleEndian.PutUint32(output, uint32(len(newValue)))
+		output[4] = value[4]
+		return append(output, newValue...), true, nil
+	}
+	return value, false, nil
+}
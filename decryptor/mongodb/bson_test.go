@@ -0,0 +1,178 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mongodb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+type element struct {
+	elementType byte
+	key         string
+	value       []byte
+}
+
+func newDocument(elements ...element) []byte {
+	document := make([]byte, 4)
+	for _, element := range elements {
+		document = append(document, element.elementType)
+		document = append(document, element.key...)
+		document = append(document, 0)
+		document = append(document, element.value...)
+	}
+	document = append(document, 0)
+	binary.LittleEndian.PutUint32(document, uint32(len(document)))
+	return document
+}
+
+func newString(value string) []byte {
+	output := make([]byte, 4)
+	binary.LittleEndian.PutUint32(output, uint32(len(value)+1))
+	return append(append(output, value...), 0)
+}
+
+func newBinary(value []byte) []byte {
+	output := make([]byte, 5)
+	binary.LittleEndian.PutUint32(output, uint32(len(value)))
+	return append(output, value...)
+}
+
+// upperProcessor replaces values with "secret" prefix by upper-case values
+func upperProcessor(key string, value []byte) ([]byte, bool, error) {
+	if !bytes.HasPrefix(value, []byte("secret")) {
+		return value, false, nil
+	}
+	return bytes.ToUpper(value), true, nil
+}
+
+func TestProcessDocument(t *testing.T) {
+	int32Value := []byte{1, 0, 0, 0}
+	document := newDocument(
+		element{TypeInt32, "id", int32Value},
+		element{TypeString, "name", newString("secret name")},
+		element{TypeDocument, "address", newDocument(element{TypeBinary, "street", newBinary([]byte("secret street"))})},
+		element{TypeArray, "tags", newDocument(element{TypeString, "0", newString("public")}, element{TypeString, "1", newString("secret tag")})},
+		element{TypeRegexp, "pattern", []byte("^a\x00i\x00")},
+		element{TypeBoolean, "ok", []byte{1}},
+		element{TypeArray, "compression", newDocument(element{TypeString, "0", newString("zstd")})},
+	)
+	expected := newDocument(
+		element{TypeInt32, "id", int32Value},
+		element{TypeString, "name", newString("SECRET NAME")},
+		element{TypeDocument, "address", newDocument(element{TypeBinary, "street", newBinary([]byte("SECRET STREET"))})},
+		element{TypeArray, "tags", newDocument(element{TypeString, "0", newString("public")}, element{TypeString, "1", newString("SECRET TAG")})},
+		element{TypeRegexp, "pattern", []byte("^a\x00i\x00")},
+		element{TypeBoolean, "ok", []byte{1}},
+	)
+	output, changed, err := ProcessDocument(document, upperProcessor, compressionResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed || !bytes.Equal(output, expected) {
+		t.Fatalf("Unexpected processed document %q", output)
+	}
+
+	unchanged := newDocument(element{TypeString, "name", newString("public")}, element{TypeNull, "empty", nil})
+	output, changed, err = ProcessDocument(unchanged, upperProcessor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed || !bytes.Equal(output, unchanged) {
+		t.Fatal("Document without processed values was changed")
+	}
+
+	malformed := [][]byte{
+		{5, 0, 0},
+		{6, 0, 0, 0, 0, 1},
+		newDocument(element{TypeInt64, "value", []byte{1, 2}}),
+		newDocument(element{0x20, "unknown", nil}),
+		newDocument(element{TypeString, "name", []byte{100, 0, 0, 0, 'a', 0}}),
+	}
+	for i, document := range malformed {
+		if _, _, err := ProcessDocument(document, upperProcessor); err != ErrMalformedBSON {
+			t.Fatalf("%v. Expected ErrMalformedBSON, took %v", i, err)
+		}
+	}
+}
+
+func newMsg(flags uint32, sections ...[]byte) []byte {
+	body := make([]byte, 4)
+	binary.LittleEndian.PutUint32(body, flags)
+	for _, section := range sections {
+		body = append(body, section...)
+	}
+	return body
+}
+
+func newSequence(identifier string, documents ...[]byte) []byte {
+	sequence := []byte{msgSectionSequence, 0, 0, 0, 0}
+	sequence = append(sequence, identifier...)
+	sequence = append(sequence, 0)
+	for _, document := range documents {
+		sequence = append(sequence, document...)
+	}
+	binary.LittleEndian.PutUint32(sequence[1:], uint32(len(sequence)-1))
+	return sequence
+}
+
+func TestProcessMsg(t *testing.T) {
+	body := newDocument(element{TypeString, "name", newString("secret")})
+	sequenceDocument := newDocument(element{TypeBinary, "value", newBinary([]byte("secret value"))})
+	msg := newMsg(msgChecksumPresent, append([]byte{msgSectionBody}, body...), newSequence("documents", sequenceDocument), []byte{1, 2, 3, 4})
+	expected := newMsg(0,
+		append([]byte{msgSectionBody}, newDocument(element{TypeString, "name", newString("SECRET")})...),
+		newSequence("documents", newDocument(element{TypeBinary, "value", newBinary([]byte("SECRET VALUE"))})))
+	output, changed, err := processMsg(msg, upperProcessor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed || !bytes.Equal(output, expected) {
+		t.Fatalf("Unexpected processed message %q", output)
+	}
+
+	unchanged := newMsg(msgChecksumPresent, append([]byte{msgSectionBody}, newDocument(element{TypeString, "ok", newString("ok")})...), []byte{1, 2, 3, 4})
+	output, changed, err = processMsg(unchanged, upperProcessor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed || !bytes.Equal(output, unchanged) {
+		t.Fatal("Message without processed values was changed")
+	}
+	if _, _, err := processMsg(newMsg(0, []byte{2}), upperProcessor); err != ErrMalformedMessage {
+		t.Fatalf("Expected ErrMalformedMessage, took %v", err)
+	}
+}
+
+func TestProcessReply(t *testing.T) {
+	header := make([]byte, replyHeaderLength)
+	reply := append(append(append([]byte{}, header...), newDocument(element{TypeString, "a", newString("public")})...),
+		newDocument(element{TypeString, "b", newString("secret")})...)
+	expected := append(append(append([]byte{}, header...), newDocument(element{TypeString, "a", newString("public")})...),
+		newDocument(element{TypeString, "b", newString("SECRET")})...)
+	output, changed, err := processReply(reply, upperProcessor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed || !bytes.Equal(output, expected) {
+		t.Fatalf("Unexpected processed reply %q", output)
+	}
+	if _, _, err := processReply(header[:10], upperProcessor); err != ErrMalformedMessage {
+		t.Fatalf("Expected ErrMalformedMessage, took %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mongodb
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Flags and kinds of sections of OP_MSG
+// https://www.mongodb.com/docs/manual/reference/mongodb-wire-protocol/#op_msg
+const (
+	msgChecksumPresent  = 1 << 0
+	msgSectionBody      = 0
+	msgSectionSequence  = 1
+	msgFlagsLength      = 4
+	msgChecksumLength   = 4
+	replyHeaderLength   = 4 + 8 + 4 + 4
+	compressionResponse = "compression"
+)
+
+// processDocuments processes sequence of documents in data and returns them and true if any was changed
+func processDocuments(data []byte, process ValueProcessor, skipKeys ...string) ([]byte, bool, error) {
+	var output []byte
+	changed := false
+	for position := 0; position < len(data); {
+		length, err := documentLength(data[position:])
+		if err != nil {
+			return nil, false, err
+		}
+		document, documentChanged, err := ProcessDocument(data[position:position+length], process, skipKeys...)
+		if err != nil {
+			return nil, false, err
+		}
+		if documentChanged && !changed {
+			output = append([]byte{}, data[:position]...)
+			changed = true
+		}
+		if changed {
+			output = append(output, document...)
+		}
+		position += length
+	}
+	if !changed {
+		return data, false, nil
+	}
+	return output, true, nil
+}
+
+// processMsg returns body of OP_MSG with processed documents and true if any document was changed. Checksum of
+// changed message is removed because it's optional and would be invalid. skipKeys are removed from body documents
+func processMsg(body []byte, process ValueProcessor, skipKeys ...string) ([]byte, bool, error) {
+	if len(body) < msgFlagsLength {
+		return nil, false, ErrMalformedMessage
+	}
+	flags := binary.LittleEndian.Uint32(body)
+	end := len(body)
+	if flags&msgChecksumPresent != 0 {
+		end -= msgChecksumLength
+	}
+	output := bytes.NewBuffer(make([]byte, 0, len(body)))
+	output.Write(body[:msgFlagsLength])
+	changed := false
+	for position := msgFlagsLength; position < end; {
+		kind := body[position]
+		position++
+		switch kind {
+		case msgSectionBody:
+			length, err := documentLength(body[position:end])
+			if err != nil {
+				return nil, false, err
+			}
+			document, documentChanged, err := ProcessDocument(body[position:position+length], process, skipKeys...)
+			if err != nil {
+				return nil, false, err
+			}
+			changed = changed || documentChanged
+			output.WriteByte(kind)
+			output.Write(document)
+			position += length
+		case msgSectionSequence:
+			if end-position < 4 {
+				return nil, false, ErrMalformedMessage
+			}
+			size := int(int32(binary.LittleEndian.Uint32(body[position:])))
+			if size < 5 || position+size > end {
+				return nil, false, ErrMalformedMessage
+			}
+			identifierEnd := bytes.IndexByte(body[position+4:position+size], 0)
+			if identifierEnd < 0 {
+				return nil, false, ErrMalformedMessage
+			}
+			documentsStart := position + 4 + identifierEnd + 1
+			documents, documentsChanged, err := processDocuments(body[documentsStart:position+size], process)
+			if err != nil {
+				return nil, false, err
+			}
+			changed = changed || documentsChanged
+			sequenceSize := make([]byte, 4)
+			binary.LittleEndian.PutUint32(sequenceSize, uint32(documentsStart-position+len(documents)))
+			output.WriteByte(kind)
+			output.Write(sequenceSize)
+			output.Write(body[position+4 : documentsStart])
+			output.Write(documents)
+			position += size
+		default:
+			return nil, false, ErrMalformedMessage
+		}
+	}
+	if !changed {
+		return body, false, nil
+	}
+	result := output.Bytes()
+	binary.LittleEndian.PutUint32(result, flags&^msgChecksumPresent)
+	return result, true, nil
+}
+
+// processReply returns body of OP_REPLY with processed documents and true if any document was changed. skipKeys are
+// removed from documents
+func processReply(body []byte, process ValueProcessor, skipKeys ...string) ([]byte, bool, error) {
+	if len(body) < replyHeaderLength {
+		return nil, false, ErrMalformedMessage
+	}
+	documents, changed, err := processDocuments(body[replyHeaderLength:], process, skipKeys...)
+	if err != nil {
+		return nil, false, err
+	}
+	if !changed {
+		return body, false, nil
+	}
+	return append(append([]byte{}, body[:replyHeaderLength]...), documents...), true, nil
+}
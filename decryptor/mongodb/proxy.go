@@ -0,0 +1,132 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mongodb
+
+import (
+	"context"
+	"net"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// Proxy forwards messages of MongoDB wire protocol between client and database and decrypts AcraStructs stored as
+// whole string or binary values of documents in replies of database
+type Proxy struct {
+	clientConnection net.Conn
+	dbConnection     net.Conn
+	decryptor        base.Decryptor
+	logger           *log.Entry
+	// connectionStats accumulates counters of client's connection, may be nil
+	connectionStats *base.ConnectionStats
+	// onStartupFinished is called once on first reply of database, may be nil
+	onStartupFinished func()
+}
+
+// NewProxy returns proxy of client's connection which decrypts replies with decryptor
+func NewProxy(clientConnection, dbConnection net.Conn, decryptor base.Decryptor) *Proxy {
+	return &Proxy{clientConnection: clientConnection, dbConnection: dbConnection, decryptor: decryptor,
+		logger: log.NewEntry(log.StandardLogger())}
+}
+
+// SetLogger sets logger of client's connection used by proxy instead of standard logger
+func (proxy *Proxy) SetLogger(logger *log.Entry) {
+	proxy.logger = logger
+}
+
+// SetConnectionStats sets counters of client's connection updated by proxy
+func (proxy *Proxy) SetConnectionStats(stats *base.ConnectionStats) {
+	proxy.connectionStats = stats
+}
+
+// SetStartupCallback sets function called once on first reply of database. MongoDB has no startup phase, so
+// connection is considered started when database answered first request
+func (proxy *Proxy) SetStartupCallback(callback func()) {
+	proxy.onStartupFinished = callback
+}
+
+// ProxyClientRequests forwards messages from client to database as is
+func (proxy *Proxy) ProxyClientRequests(errCh chan<- error) {
+	logger := proxy.logger.WithField("proxy", "client")
+	for {
+		message, err := ReadMessage(proxy.clientConnection)
+		if err != nil {
+			logger.WithError(err).Debugln("Can't read message from client")
+			errCh <- err
+			return
+		}
+		switch message.OpCode {
+		case OpMsg, OpQuery, OpCompressed:
+			proxy.connectionStats.AddQuery()
+		}
+		if _, err := proxy.dbConnection.Write(message.Dump()); err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorResponseConnectorCantWriteToDB).
+				Debugln("Can't forward message to database")
+			errCh <- err
+			return
+		}
+	}
+}
+
+// DecryptReplies decrypts documents of OP_MSG and OP_REPLY messages from database and forwards them to client.
+// Compression isn't offered to client, so database doesn't compress replies which would be forwarded encrypted
+func (proxy *Proxy) DecryptReplies(ctx context.Context, errCh chan<- error) {
+	logger := proxy.logger.WithField("proxy", "server")
+	for {
+		message, err := ReadMessage(proxy.dbConnection)
+		if err != nil {
+			logger.WithError(err).Debugln("Can't read message from database")
+			errCh <- err
+			return
+		}
+		if proxy.onStartupFinished != nil {
+			proxy.onStartupFinished()
+			proxy.onStartupFinished = nil
+		}
+		process := func(key string, value []byte) ([]byte, bool, error) {
+			return base.DecryptValue(ctx, proxy.decryptor, proxy.connectionStats, "", key, value, logger)
+		}
+		var body []byte
+		changed := false
+		switch message.OpCode {
+		case OpMsg:
+			body, changed, err = processMsg(message.Body, process, compressionResponse)
+		case OpReply:
+			body, changed, err = processReply(message.Body, process, compressionResponse)
+		case OpCompressed:
+			logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorProtocolProcessing).
+				Warningln("Database sent compressed message, forward it without decryption")
+		}
+		proxy.decryptor.ResetZoneMatch()
+		if err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorProtocolProcessing).
+				Errorln("Can't process message from database")
+			errCh <- err
+			return
+		}
+		if changed {
+			message.Body = body
+		}
+		if _, err := proxy.clientConnection.Write(message.Dump()); err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorResponseConnectorCantWriteToClient).
+				Debugln("Can't forward message to client")
+			errCh <- err
+			return
+		}
+	}
+}
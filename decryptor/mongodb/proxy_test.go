@@ -0,0 +1,116 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mongodb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+)
+
+var testBeginTag = bytes.Repeat([]byte{'"'}, 8)
+
+// testDecryptor decrypts values which start with testBeginTag and contain "valid" by removing tag
+type testDecryptor struct {
+	base.Decryptor
+}
+
+func (*testDecryptor) IsWithZone() bool            { return false }
+func (*testDecryptor) Reset()                      {}
+func (*testDecryptor) ResetZoneMatch()             {}
+func (*testDecryptor) IsPoisonRecordCheckOn() bool { return false }
+func (*testDecryptor) BeginTagIndex(block []byte) (int, int) {
+	return bytes.Index(block, testBeginTag), len(testBeginTag)
+}
+func (*testDecryptor) DecryptBlock(block []byte) ([]byte, error) {
+	if !bytes.Contains(block, []byte("valid")) {
+		return nil, errors.New("invalid AcraStruct")
+	}
+	return block[len(testBeginTag):], nil
+}
+
+func TestProxyDecryptReplies(t *testing.T) {
+	client, proxyClient := net.Pipe()
+	db, proxyDB := net.Pipe()
+	defer client.Close()
+	defer db.Close()
+	proxy := NewProxy(proxyClient, proxyDB, &testDecryptor{})
+	startupFinished := false
+	proxy.SetStartupCallback(func() { startupFinished = true })
+	errCh := make(chan error, 1)
+	go proxy.DecryptReplies(context.Background(), errCh)
+
+	acraStruct := append(append([]byte{}, testBeginTag...), bytes.Repeat([]byte("valid data "), 20)...)
+	invalidAcraStruct := append(append([]byte{}, testBeginTag...), bytes.Repeat([]byte("wrong data "), 20)...)
+	shortValue := append(append([]byte{}, testBeginTag...), "valid"...)
+	newReply := func(data []byte) []byte {
+		row := newDocument(
+			element{TypeBinary, "data", newBinary(data)},
+			element{TypeBinary, "invalid", newBinary(invalidAcraStruct)},
+			element{TypeString, "short", newString(string(shortValue))},
+		)
+		batch := newDocument(element{TypeDocument, "0", row})
+		return newDocument(element{TypeDocument, "cursor", newDocument(element{TypeArray, "firstBatch", batch})})
+	}
+	reply := newReply(acraStruct)
+	expected := newReply(acraStruct[len(testBeginTag):])
+	message := &Message{RequestID: 2, ResponseTo: 1, OpCode: OpMsg, Body: newMsg(0, append([]byte{msgSectionBody}, reply...))}
+	go db.Write(message.Dump())
+	decrypted, err := ReadMessage(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.RequestID != 2 || decrypted.ResponseTo != 1 || decrypted.OpCode != OpMsg {
+		t.Fatalf("Unexpected header of message %+v", decrypted)
+	}
+	if !bytes.Equal(decrypted.Body, newMsg(0, append([]byte{msgSectionBody}, expected...))) {
+		t.Fatalf("Unexpected decrypted message %q", decrypted.Body)
+	}
+	if !startupFinished {
+		t.Fatal("Startup callback wasn't called on first reply")
+	}
+
+	// malformed message stops proxy
+	message.Body = newMsg(0, []byte{msgSectionBody, 1, 2})
+	go db.Write(message.Dump())
+	if err := <-errCh; err != ErrMalformedBSON {
+		t.Fatalf("Expected ErrMalformedBSON, took %v", err)
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	message := &Message{RequestID: 1, OpCode: OpQuery, Body: []byte{1, 2, 3}}
+	read, err := ReadMessage(bytes.NewReader(message.Dump()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.RequestID != 1 || read.OpCode != OpQuery || !bytes.Equal(read.Body, message.Body) {
+		t.Fatalf("Unexpected message %+v", read)
+	}
+	tooLarge := message.Dump()
+	tooLarge[3] = 0x7f
+	if _, err := ReadMessage(bytes.NewReader(tooLarge)); err != ErrMessageTooLarge {
+		t.Fatalf("Expected ErrMessageTooLarge, took %v", err)
+	}
+	if _, err := ReadMessage(bytes.NewReader([]byte{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})); err != ErrMalformedMessage {
+		t.Fatalf("Expected ErrMalformedMessage, took %v", err)
+	}
+}
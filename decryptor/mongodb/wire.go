@@ -0,0 +1,92 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package mongodb implements proxy of MongoDB wire protocol which decrypts AcraStructs stored as values of BSON
+// documents in replies of database.
+//
+// https://www.mongodb.com/docs/manual/reference/mongodb-wire-protocol/
+package mongodb
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/cossacklabs/acra/acraerrors"
+)
+
+// Operation codes of messages
+const (
+	OpReply      = 1
+	OpQuery      = 2004
+	OpCompressed = 2012
+	OpMsg        = 2013
+)
+
+// HeaderLength is length of header of each message
+const HeaderLength = 16
+
+// MaxMessageSize is max size of message accepted by MongoDB (maxMessageSizeBytes)
+const MaxMessageSize = 48000000
+
+// Errors returned on processing of messages
+var (
+	ErrMalformedMessage = acraerrors.New(acraerrors.CodeMongoDBMalformedMessage, "malformed message of MongoDB wire protocol")
+	ErrMessageTooLarge  = acraerrors.New(acraerrors.CodeMongoDBMessageTooLarge, "message of MongoDB wire protocol exceeds max size")
+)
+
+// Message of MongoDB wire protocol
+type Message struct {
+	RequestID  int32
+	ResponseTo int32
+	OpCode     int32
+	// Body is message without header
+	Body []byte
+}
+
+// ReadMessage reads one message from reader
+func ReadMessage(reader io.Reader) (*Message, error) {
+	header := make([]byte, HeaderLength)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	length := int32(binary.LittleEndian.Uint32(header))
+	if length < HeaderLength {
+		return nil, ErrMalformedMessage
+	}
+	if length > MaxMessageSize {
+		return nil, ErrMessageTooLarge
+	}
+	message := &Message{
+		RequestID:  int32(binary.LittleEndian.Uint32(header[4:])),
+		ResponseTo: int32(binary.LittleEndian.Uint32(header[8:])),
+		OpCode:     int32(binary.LittleEndian.Uint32(header[12:])),
+		Body:       make([]byte, length-HeaderLength),
+	}
+	if _, err := io.ReadFull(reader, message.Body); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// Dump returns message with header
+func (message *Message) Dump() []byte {
+	output := make([]byte, HeaderLength, HeaderLength+len(message.Body))
+	binary.LittleEndian.PutUint32(output, uint32(HeaderLength+len(message.Body)))
+	binary.LittleEndian.PutUint32(output[4:], uint32(message.RequestID))
+	binary.LittleEndian.PutUint32(output[8:], uint32(message.ResponseTo))
+	binary.LittleEndian.PutUint32(output[12:], uint32(message.OpCode))
+	return append(output, message.Body...)
+}
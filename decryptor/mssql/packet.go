@@ -0,0 +1,266 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package mssql implements proxy of TDS protocol (versions 7.2-7.4) of Microsoft SQL Server which negotiates TLS on
+// pre-login, checks SQL batches and parameterized statements with AcraCensor and decrypts AcraStructs stored in
+// varbinary and image columns of result sets.
+//
+// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-tds/b46a581a-39de-4745-b076-ec4dbb7d13ec
+package mssql
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/cossacklabs/acra/acraerrors"
+)
+
+// Types of packets
+const (
+	PacketSQLBatch      = 0x01
+	PacketRPC           = 0x03
+	PacketTabularResult = 0x04
+	PacketAttention     = 0x06
+	PacketBulkLoad      = 0x07
+	PacketLogin7        = 0x10
+	PacketSSPI          = 0x11
+	PacketPreLogin      = 0x12
+)
+
+// StatusEndOfMessage is set in status of last packet of message
+const StatusEndOfMessage = 0x01
+
+// Sizes of packets and messages
+const (
+	HeaderLength      = 8
+	DefaultPacketSize = 4096
+	// MaxMessageSize limits size of client's messages which are buffered for AcraCensor and of pre-login messages
+	MaxMessageSize = 256 * 1024 * 1024
+)
+
+// Errors returned on processing of packets
+var (
+	ErrMalformedPacket  = acraerrors.New(acraerrors.CodeMSSQLMalformedPacket, "malformed packet of TDS protocol")
+	ErrMessageTooLarge  = acraerrors.New(acraerrors.CodeMSSQLMessageTooLarge, "message of TDS protocol exceeds max size")
+	ErrUnexpectedPacket = acraerrors.New(acraerrors.CodeMSSQLUnexpectedPacket, "unexpected type of TDS packet")
+)
+
+// Packet of TDS protocol
+type Packet struct {
+	Type   byte
+	Status byte
+	SPID   uint16
+	ID     byte
+	Window byte
+	Data   []byte
+}
+
+// ReadPacket reads one packet from reader
+func ReadPacket(reader io.Reader) (*Packet, error) {
+	header := make([]byte, HeaderLength)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(header[2:]))
+	if length < HeaderLength {
+		return nil, ErrMalformedPacket
+	}
+	packet := &Packet{
+		Type:   header[0],
+		Status: header[1],
+		SPID:   binary.BigEndian.Uint16(header[4:]),
+		ID:     header[6],
+		Window: header[7],
+		Data:   make([]byte, length-HeaderLength),
+	}
+	if _, err := io.ReadFull(reader, packet.Data); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// IsLast returns true if packet is last packet of message
+func (packet *Packet) IsLast() bool {
+	return packet.Status&StatusEndOfMessage != 0
+}
+
+// Dump returns packet with header
+func (packet *Packet) Dump() []byte {
+	output := make([]byte, HeaderLength, HeaderLength+len(packet.Data))
+	output[0] = packet.Type
+	output[1] = packet.Status
+	binary.BigEndian.PutUint16(output[2:], uint16(HeaderLength+len(packet.Data)))
+	binary.BigEndian.PutUint16(output[4:], packet.SPID)
+	output[6] = packet.ID
+	output[7] = packet.Window
+	return append(output, packet.Data...)
+}
+
+// Message is data of packets from first packet to packet with end of message status
+type Message struct {
+	Packets []*Packet
+	Data    []byte
+}
+
+// ReadMessage reads packets of one message which data doesn't exceed maxSize
+func ReadMessage(reader io.Reader, maxSize int) (*Message, error) {
+	packet, err := ReadPacket(reader)
+	if err != nil {
+		return nil, err
+	}
+	return readMessage(reader, packet, maxSize)
+}
+
+// readMessage reads packets of message which starts with packet
+func readMessage(reader io.Reader, packet *Packet, maxSize int) (*Message, error) {
+	message := &Message{}
+	for {
+		if len(message.Packets) > 0 {
+			var err error
+			if packet, err = ReadPacket(reader); err != nil {
+				return nil, err
+			}
+		}
+		if len(message.Packets) > 0 && packet.Type != message.Type() {
+			return nil, ErrMalformedPacket
+		}
+		if len(message.Data)+len(packet.Data) > maxSize {
+			return nil, ErrMessageTooLarge
+		}
+		message.Packets = append(message.Packets, packet)
+		message.Data = append(message.Data, packet.Data...)
+		if packet.IsLast() {
+			return message, nil
+		}
+	}
+}
+
+// NewMessage returns message of packets with type and data split by packets of packetSize
+func NewMessage(packetType byte, spid uint16, data []byte, packetSize int) *Message {
+	message := &Message{Data: data}
+	writer := newMessageWriter(nil, &Packet{Type: packetType, SPID: spid}, packetSize)
+	writer.onPacket = func(packet *Packet) error {
+		message.Packets = append(message.Packets, packet)
+		return nil
+	}
+	writer.Write(data)
+	writer.Close()
+	return message
+}
+
+// Type returns type of packets of message
+func (message *Message) Type() byte {
+	return message.Packets[0].Type
+}
+
+// Dump returns packets of message
+func (message *Message) Dump() []byte {
+	output := make([]byte, 0, len(message.Data)+len(message.Packets)*HeaderLength)
+	for _, packet := range message.Packets {
+		output = append(output, packet.Dump()...)
+	}
+	return output
+}
+
+// messageReader reads data of packets of one message, io.EOF is returned after last packet. Error of underlying
+// reader is saved, so it can be distinguished from unexpected end of message
+type messageReader struct {
+	reader io.Reader
+	packet *Packet
+	offset int
+	err    error
+}
+
+// newMessageReader returns reader of message which starts with packet
+func newMessageReader(reader io.Reader, packet *Packet) *messageReader {
+	return &messageReader{reader: reader, packet: packet}
+}
+
+func (reader *messageReader) Read(data []byte) (int, error) {
+	for reader.offset == len(reader.packet.Data) {
+		if reader.packet.IsLast() {
+			return 0, io.EOF
+		}
+		packet, err := ReadPacket(reader.reader)
+		if err == nil && packet.Type != reader.packet.Type {
+			err = ErrMalformedPacket
+		}
+		if err != nil {
+			reader.err = err
+			return 0, err
+		}
+		reader.packet = packet
+		reader.offset = 0
+	}
+	n := copy(data, reader.packet.Data[reader.offset:])
+	reader.offset += n
+	return n, nil
+}
+
+// messageWriter splits written data by packets with header of first packet of message. Last packet is written with
+// end of message status on Close
+type messageWriter struct {
+	writer     io.Writer
+	header     Packet
+	packetSize int
+	buffer     []byte
+	// onPacket is called instead of writing of packet to writer if set
+	onPacket func(*Packet) error
+}
+
+// newMessageWriter returns writer of packets with header of packet and max size of packets
+func newMessageWriter(writer io.Writer, header *Packet, packetSize int) *messageWriter {
+	if packetSize <= HeaderLength {
+		packetSize = DefaultPacketSize
+	}
+	messageHeader := *header
+	messageHeader.Status &^= StatusEndOfMessage
+	messageHeader.ID = 1
+	messageHeader.Data = nil
+	return &messageWriter{writer: writer, header: messageHeader, packetSize: packetSize}
+}
+
+func (writer *messageWriter) Write(data []byte) (int, error) {
+	writer.buffer = append(writer.buffer, data...)
+	maxData := writer.packetSize - HeaderLength
+	// last data is kept until Close because it should be sent with end of message status
+	for len(writer.buffer) > maxData {
+		if err := writer.writePacket(writer.buffer[:maxData], 0); err != nil {
+			return 0, err
+		}
+		writer.buffer = writer.buffer[maxData:]
+	}
+	return len(data), nil
+}
+
+func (writer *messageWriter) writePacket(data []byte, status byte) error {
+	packet := writer.header
+	packet.Status |= status
+	packet.Data = append([]byte(nil), data...)
+	writer.header.ID++
+	if writer.onPacket != nil {
+		return writer.onPacket(&packet)
+	}
+	_, err := writer.writer.Write(packet.Dump())
+	return err
+}
+
+// Close writes last packet of message
+func (writer *messageWriter) Close() error {
+	err := writer.writePacket(writer.buffer, StatusEndOfMessage)
+	writer.buffer = nil
+	return err
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mssql
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestMessage(t *testing.T) {
+	data := bytes.Repeat([]byte{1, 2, 3}, 10)
+	message := NewMessage(PacketSQLBatch, 5, data, HeaderLength+8)
+	if len(message.Packets) != 4 {
+		t.Fatalf("Expected 4 packets, took %d", len(message.Packets))
+	}
+	for i, packet := range message.Packets {
+		if packet.Type != PacketSQLBatch || packet.SPID != 5 || packet.ID != byte(i+1) || packet.IsLast() != (i == 3) {
+			t.Fatalf("Unexpected packet %+v", packet)
+		}
+	}
+	read, err := ReadMessage(bytes.NewReader(message.Dump()), len(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read.Data, data) || !bytes.Equal(read.Dump(), message.Dump()) {
+		t.Fatalf("Unexpected message %v", read.Data)
+	}
+	if _, err := ReadMessage(bytes.NewReader(message.Dump()), len(data)-1); err != ErrMessageTooLarge {
+		t.Fatalf("Expected ErrMessageTooLarge, took %v", err)
+	}
+	malformed := message.Dump()
+	malformed[HeaderLength+8] = PacketRPC
+	if _, err := ReadMessage(bytes.NewReader(malformed), len(data)); err != ErrMalformedPacket {
+		t.Fatalf("Expected ErrMalformedPacket, took %v", err)
+	}
+
+	// message reader returns data of all packets
+	reader := newMessageReader(bytes.NewReader(message.Dump()[HeaderLength+8:]), message.Packets[0])
+	if read, err := ioutil.ReadAll(reader); err != nil || !bytes.Equal(read, data) {
+		t.Fatalf("Unexpected data of message reader %v: %v", read, err)
+	}
+}
@@ -0,0 +1,318 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mssql
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/network"
+	log "github.com/sirupsen/logrus"
+)
+
+// Options of PRELOGIN message
+const (
+	preLoginVersion    = 0x00
+	preLoginEncryption = 0x01
+	preLoginMARS       = 0x04
+	preLoginTerminator = 0xFF
+	// preLoginOptionLength is length of option token with offset and length of its data
+	preLoginOptionLength = 5
+)
+
+// Values of ENCRYPTION option
+const (
+	EncryptOff          = 0x00
+	EncryptOn           = 0x01
+	EncryptNotSupported = 0x02
+	EncryptRequired     = 0x03
+)
+
+// tlsRecordHandshake is the first byte of TLS connection which is sent instead of PRELOGIN by clients of TDS 8.0
+const tlsRecordHandshake = 0x16
+
+// Errors returned on pre-login
+var (
+	ErrStrictEncryption     = acraerrors.New(acraerrors.CodeMSSQLUnsupportedEncryption, "strict encryption of TDS 8.0 and encryption of login only aren't supported")
+	ErrDBRequiresEncryption = acraerrors.New(acraerrors.CodeMSSQLDBEncryptionRequired, "database requires encryption but TLS with database isn't configured")
+	ErrDBDeniedEncryption   = acraerrors.New(acraerrors.CodeMSSQLDBEncryptionDenied, "database doesn't support encryption required by db_tls_mode")
+)
+
+// preLoginOptions are options of PRELOGIN message in order they were sent
+type preLoginOptions struct {
+	tokens []byte
+	values map[byte][]byte
+}
+
+// parsePreLogin returns options of PRELOGIN message or its response
+func parsePreLogin(data []byte) (*preLoginOptions, error) {
+	options := &preLoginOptions{values: make(map[byte][]byte)}
+	for i := 0; ; i += preLoginOptionLength {
+		if i >= len(data) {
+			return nil, ErrMalformedPacket
+		}
+		token := data[i]
+		if token == preLoginTerminator {
+			return options, nil
+		}
+		if i+preLoginOptionLength > len(data) {
+			return nil, ErrMalformedPacket
+		}
+		offset := int(binary.BigEndian.Uint16(data[i+1:]))
+		end := offset + int(binary.BigEndian.Uint16(data[i+3:]))
+		if end > len(data) {
+			return nil, ErrMalformedPacket
+		}
+		options.tokens = append(options.tokens, token)
+		options.values[token] = data[offset:end]
+	}
+}
+
+// encryption returns value of ENCRYPTION option, absent option means that encryption isn't supported
+func (options *preLoginOptions) encryption() byte {
+	if value := options.values[preLoginEncryption]; len(value) > 0 {
+		return value[0]
+	}
+	return EncryptNotSupported
+}
+
+// set changes value of option which was sent
+func (options *preLoginOptions) set(token byte, value byte) {
+	if _, ok := options.values[token]; ok {
+		options.values[token] = []byte{value}
+	}
+}
+
+// dump returns PRELOGIN message with options
+func (options *preLoginOptions) dump() []byte {
+	offset := len(options.tokens)*preLoginOptionLength + 1
+	output := make([]byte, 0, offset)
+	var data []byte
+	for _, token := range options.tokens {
+		value := options.values[token]
+		output = append(output, token, byte((offset+len(data))>>8), byte(offset+len(data)), byte(len(value)>>8), byte(len(value)))
+		data = append(data, value...)
+	}
+	return append(append(output, preLoginTerminator), data...)
+}
+
+// NegotiateTLS exchanges PRELOGIN messages of client and database and switches connections to TLS. Client's
+// connection uses TLS if client supports encryption and clientConfig has certificate. Connection to database uses TLS
+// if dbConfig is set and client's connection uses TLS or requireDBTLS is set. MARS is turned off because multiplexed
+// sessions can't be parsed. Connections have deadline until negotiation finishes, zero deadline means no limit
+func NegotiateTLS(clientConnection, dbConnection net.Conn, clientConfig, dbConfig *tls.Config, requireDBTLS bool, deadline time.Time, logger *log.Entry) (net.Conn, net.Conn, error) {
+	for _, connection := range []net.Conn{clientConnection, dbConnection} {
+		if err := connection.SetDeadline(deadline); err != nil {
+			return nil, nil, err
+		}
+	}
+	request, err := readPreLogin(clientConnection)
+	if err != nil {
+		return nil, nil, err
+	}
+	clientEncryption := request.encryption()
+	clientTLS := clientEncryption != EncryptNotSupported && network.HasServerCertificate(clientConfig)
+	dbTLS := dbConfig != nil && (clientTLS || requireDBTLS)
+	if dbTLS {
+		request.set(preLoginEncryption, EncryptOn)
+	} else {
+		request.set(preLoginEncryption, EncryptNotSupported)
+	}
+	request.set(preLoginMARS, 0)
+	if _, err := dbConnection.Write(NewMessage(PacketPreLogin, 0, request.dump(), DefaultPacketSize).Dump()); err != nil {
+		return nil, nil, err
+	}
+	responseMessage, err := ReadMessage(dbConnection, MaxMessageSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	if responseMessage.Type() != PacketTabularResult {
+		return nil, nil, ErrUnexpectedPacket
+	}
+	response, err := parsePreLogin(responseMessage.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch dbEncryption := response.encryption(); {
+	case dbEncryption == EncryptOff:
+		return nil, nil, ErrStrictEncryption
+	case dbEncryption == EncryptNotSupported && dbTLS && requireDBTLS:
+		return nil, nil, ErrDBDeniedEncryption
+	case dbEncryption == EncryptNotSupported:
+		if dbTLS {
+			logger.Debugln("Database doesn't support encryption, continue without TLS")
+		}
+	case !dbTLS:
+		return nil, nil, ErrDBRequiresEncryption
+	default:
+		dbConnection, err = handshake(dbConnection, dbConfig, false)
+		if err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTLSHandshakeFailed).
+				Errorln("Can't initialize tls connection with db")
+			return nil, nil, err
+		}
+		logger.Debugln("Connection to database switched to TLS")
+	}
+	switch {
+	case !clientTLS:
+		response.set(preLoginEncryption, EncryptNotSupported)
+	case clientEncryption == EncryptOff:
+		// client which supports encryption of login only is asked to encrypt whole connection
+		response.set(preLoginEncryption, EncryptRequired)
+	default:
+		response.set(preLoginEncryption, EncryptOn)
+	}
+	response.set(preLoginMARS, 0)
+	responseMessage = NewMessage(PacketTabularResult, responseMessage.Packets[0].SPID, response.dump(), DefaultPacketSize)
+	if _, err := clientConnection.Write(responseMessage.Dump()); err != nil {
+		return nil, nil, err
+	}
+	if clientTLS {
+		clientConnection, err = handshake(clientConnection, clientConfig, true)
+		if err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTLSHandshakeFailed).
+				Errorln("Can't initialize tls connection with client")
+			return nil, nil, err
+		}
+		logger.Debugln("Connection with client switched to TLS")
+	} else if clientEncryption == EncryptOn || clientEncryption == EncryptRequired {
+		logger.Warningln("Client requires encryption but AcraServer has no TLS certificate, client will close connection")
+	}
+	for _, connection := range []net.Conn{clientConnection, dbConnection} {
+		if err := connection.SetDeadline(time.Time{}); err != nil {
+			return nil, nil, err
+		}
+	}
+	return clientConnection, dbConnection, nil
+}
+
+// readPreLogin reads PRELOGIN message of client
+func readPreLogin(connection net.Conn) (*preLoginOptions, error) {
+	packetType := make([]byte, 1)
+	if _, err := io.ReadFull(connection, packetType); err != nil {
+		return nil, err
+	}
+	if packetType[0] == tlsRecordHandshake {
+		return nil, ErrStrictEncryption
+	}
+	if packetType[0] != PacketPreLogin {
+		return nil, ErrUnexpectedPacket
+	}
+	message, err := ReadMessage(&prefixedReader{prefix: packetType, connection: connection}, MaxMessageSize)
+	if err != nil {
+		return nil, err
+	}
+	return parsePreLogin(message.Data)
+}
+
+// prefixedReader returns prefix before data of connection
+type prefixedReader struct {
+	prefix     []byte
+	connection net.Conn
+}
+
+func (reader *prefixedReader) Read(data []byte) (int, error) {
+	if len(reader.prefix) > 0 {
+		n := copy(data, reader.prefix)
+		reader.prefix = reader.prefix[n:]
+		return n, nil
+	}
+	return reader.connection.Read(data)
+}
+
+// handshake switches connection to TLS with records of handshake sent in PRELOGIN packets as TDS 7.x requires.
+// Versions above TLS 1.2 aren't used because records sent after handshake of TLS 1.3 can't be distinguished
+func handshake(connection net.Conn, config *tls.Config, server bool) (net.Conn, error) {
+	config = config.Clone()
+	if config.MaxVersion == 0 || config.MaxVersion > tls.VersionTLS12 {
+		config.MaxVersion = tls.VersionTLS12
+	}
+	wrapper := &preLoginConnection{Conn: connection}
+	var tlsConnection *tls.Conn
+	if server {
+		tlsConnection = tls.Server(wrapper, config)
+	} else {
+		tlsConnection = tls.Client(wrapper, config)
+	}
+	if err := tlsConnection.Handshake(); err != nil {
+		return nil, err
+	}
+	if err := wrapper.finishHandshake(); err != nil {
+		return nil, err
+	}
+	return tlsConnection, nil
+}
+
+// preLoginConnection sends records of TLS handshake in PRELOGIN packets, records after handshake are sent as is.
+// Written records are buffered until next read, so each flight of handshake is sent in one message
+type preLoginConnection struct {
+	net.Conn
+	handshakeFinished bool
+	input             []byte
+	output            []byte
+}
+
+func (conn *preLoginConnection) Read(data []byte) (int, error) {
+	if conn.handshakeFinished {
+		return conn.Conn.Read(data)
+	}
+	if err := conn.flush(); err != nil {
+		return 0, err
+	}
+	if len(conn.input) == 0 {
+		packet, err := ReadPacket(conn.Conn)
+		if err != nil {
+			return 0, err
+		}
+		if packet.Type != PacketPreLogin {
+			return 0, ErrUnexpectedPacket
+		}
+		conn.input = packet.Data
+	}
+	n := copy(data, conn.input)
+	conn.input = conn.input[n:]
+	return n, nil
+}
+
+func (conn *preLoginConnection) Write(data []byte) (int, error) {
+	if conn.handshakeFinished {
+		return conn.Conn.Write(data)
+	}
+	conn.output = append(conn.output, data...)
+	return len(data), nil
+}
+
+func (conn *preLoginConnection) flush() error {
+	if len(conn.output) == 0 {
+		return nil
+	}
+	_, err := conn.Conn.Write(NewMessage(PacketPreLogin, 0, conn.output, DefaultPacketSize).Dump())
+	conn.output = nil
+	return err
+}
+
+// finishHandshake sends last records of handshake and switches connection to sending of records as is
+func (conn *preLoginConnection) finishHandshake() error {
+	err := conn.flush()
+	conn.handshakeFinished = true
+	return err
+}
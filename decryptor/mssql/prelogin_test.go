@@ -0,0 +1,233 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mssql
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/utils/testutils"
+	log "github.com/sirupsen/logrus"
+)
+
+// newTestPreLogin returns PRELOGIN message with version, encryption and MARS options
+func newTestPreLogin(packetType, encryption byte) []byte {
+	options := &preLoginOptions{
+		tokens: []byte{preLoginVersion, preLoginEncryption, preLoginMARS},
+		values: map[byte][]byte{preLoginVersion: {15, 0, 7, 208, 0, 0}, preLoginEncryption: {encryption}, preLoginMARS: {1}},
+	}
+	return NewMessage(packetType, 0, options.dump(), DefaultPacketSize).Dump()
+}
+
+// readTestPreLogin reads PRELOGIN message and returns values of encryption and MARS options
+func readTestPreLogin(connection net.Conn) (byte, byte, error) {
+	message, err := ReadMessage(connection, MaxMessageSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	options, err := parsePreLogin(message.Data)
+	if err != nil {
+		return 0, 0, err
+	}
+	return options.encryption(), options.values[preLoginMARS][0], nil
+}
+
+// serveTestPreLogin answers PRELOGIN with encryption, switches to TLS if it's supported and reads login
+func serveTestPreLogin(connection net.Conn, encryption byte, config *tls.Config) ([]byte, byte, error) {
+	requested, _, err := readTestPreLogin(connection)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := connection.Write(newTestPreLogin(PacketTabularResult, encryption)); err != nil {
+		return nil, 0, err
+	}
+	if encryption == EncryptOn {
+		if connection, err = handshake(connection, config, true); err != nil {
+			return nil, 0, err
+		}
+	}
+	login := make([]byte, 5)
+	_, err = io.ReadFull(connection, login)
+	return login, requested, err
+}
+
+func TestPreLoginOptions(t *testing.T) {
+	message, err := ReadMessage(bytes.NewReader(newTestPreLogin(PacketPreLogin, EncryptOff)), MaxMessageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options, err := parsePreLogin(message.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.encryption() != EncryptOff || !bytes.Equal(options.dump(), message.Data) {
+		t.Fatalf("Unexpected options %+v", options)
+	}
+	options.set(preLoginMARS, 0)
+	options.set(preLoginTerminator-1, 1)
+	if parsed, err := parsePreLogin(options.dump()); err != nil || len(parsed.tokens) != 3 || parsed.values[preLoginMARS][0] != 0 {
+		t.Fatalf("Unexpected changed options %+v: %v", parsed, err)
+	}
+	for _, malformed := range [][]byte{{}, {preLoginVersion, 0, 6, 0}, {preLoginVersion, 0, 6, 0, 10, preLoginTerminator}} {
+		if _, err := parsePreLogin(malformed); err != ErrMalformedPacket {
+			t.Fatalf("Expected ErrMalformedPacket for %v, took %v", malformed, err)
+		}
+	}
+}
+
+func TestNegotiateTLS(t *testing.T) {
+	logger := log.NewEntry(log.StandardLogger())
+	certificate, pool := testutils.NewTLSCertificate(t)
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{certificate}}
+	login := []byte("login")
+	for _, clientEncryption := range []byte{EncryptOn, EncryptOff} {
+		client, clientSide := net.Pipe()
+		db, dbSide := net.Pipe()
+		type dbResult struct {
+			login     []byte
+			requested byte
+			err       error
+		}
+		dbResultCh := make(chan dbResult, 1)
+		go func() {
+			login, requested, err := serveTestPreLogin(db, EncryptOn, serverConfig)
+			dbResultCh <- dbResult{login, requested, err}
+		}()
+		clientResult := make(chan error, 1)
+		go func() {
+			client.Write(newTestPreLogin(PacketPreLogin, clientEncryption))
+			encryption, mars, err := readTestPreLogin(client)
+			if err != nil {
+				clientResult <- err
+				return
+			}
+			if encryption == EncryptNotSupported || mars != 0 {
+				clientResult <- ErrUnexpectedPacket
+				return
+			}
+			tlsClient, err := handshake(client, &tls.Config{RootCAs: pool, ServerName: "localhost"}, false)
+			if err != nil {
+				clientResult <- err
+				return
+			}
+			_, err = tlsClient.Write(login)
+			clientResult <- err
+		}()
+		dbConfig := &tls.Config{RootCAs: pool, ServerName: "localhost"}
+		clientConnection, dbConnection, err := NegotiateTLS(clientSide, dbSide, serverConfig, dbConfig, false, time.Now().Add(time.Second), logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := clientConnection.(*tls.Conn); !ok {
+			t.Fatal("Client's connection isn't switched to TLS")
+		}
+		// login sent by client is forwarded to database over TLS
+		received := make([]byte, len(login))
+		if _, err := io.ReadFull(clientConnection, received); err != nil || !bytes.Equal(received, login) {
+			t.Fatalf("Unexpected login from client %v: %v", received, err)
+		}
+		if err := <-clientResult; err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dbConnection.Write(received); err != nil {
+			t.Fatal(err)
+		}
+		result := <-dbResultCh
+		if result.err != nil || !bytes.Equal(result.login, login) || result.requested != EncryptOn {
+			t.Fatalf("Unexpected result on database side %+v", result)
+		}
+		// close peers first, otherwise close_notify blocks on unbuffered pipes
+		client.Close()
+		db.Close()
+		clientConnection.Close()
+		dbConnection.Close()
+	}
+}
+
+func TestNegotiateTLSWithoutEncryption(t *testing.T) {
+	logger := log.NewEntry(log.StandardLogger())
+	client, clientSide := net.Pipe()
+	db, dbSide := net.Pipe()
+	defer client.Close()
+	defer db.Close()
+	dbResult := make(chan byte, 1)
+	go func() {
+		_, requested, _ := serveTestPreLogin(db, EncryptNotSupported, nil)
+		dbResult <- requested
+	}()
+	go client.Write(newTestPreLogin(PacketPreLogin, EncryptOn))
+	clientResult := make(chan byte, 1)
+	go func() {
+		encryption, _, _ := readTestPreLogin(client)
+		clientResult <- encryption
+		client.Write([]byte("login"))
+	}()
+	// without certificate encryption isn't supported on both sides
+	clientConnection, dbConnection, err := NegotiateTLS(clientSide, dbSide, nil, &tls.Config{}, false, time.Time{}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientConnection != clientSide || dbConnection != dbSide {
+		t.Fatal("Connections were changed without encryption")
+	}
+	if encryption := <-clientResult; encryption != EncryptNotSupported {
+		t.Fatalf("Unexpected encryption answered to client %d", encryption)
+	}
+	go io.CopyN(dbConnection, clientConnection, 5)
+	if requested := <-dbResult; requested != EncryptNotSupported {
+		t.Fatalf("Unexpected encryption requested from database %d", requested)
+	}
+}
+
+func TestNegotiateTLSErrors(t *testing.T) {
+	logger := log.NewEntry(log.StandardLogger())
+	for _, testCase := range []struct {
+		dbEncryption byte
+		dbConfig     *tls.Config
+		requireDBTLS bool
+		expected     error
+	}{
+		{EncryptNotSupported, &tls.Config{}, true, ErrDBDeniedEncryption},
+		{EncryptRequired, nil, false, ErrDBRequiresEncryption},
+		{EncryptOff, nil, false, ErrStrictEncryption},
+	} {
+		client, clientSide := net.Pipe()
+		db, dbSide := net.Pipe()
+		go serveTestPreLogin(db, testCase.dbEncryption, nil)
+		go client.Write(newTestPreLogin(PacketPreLogin, EncryptOff))
+		_, _, err := NegotiateTLS(clientSide, dbSide, nil, testCase.dbConfig, testCase.requireDBTLS, time.Time{}, logger)
+		if err != testCase.expected {
+			t.Fatalf("Expected %v, took %v", testCase.expected, err)
+		}
+		client.Close()
+		db.Close()
+	}
+
+	// clients of TDS 8.0 start with TLS handshake
+	client, clientSide := net.Pipe()
+	_, dbSide := net.Pipe()
+	defer client.Close()
+	defer dbSide.Close()
+	go client.Write([]byte{tlsRecordHandshake, 3, 1, 0, 10})
+	if _, _, err := NegotiateTLS(clientSide, dbSide, nil, nil, false, time.Time{}, logger); err != ErrStrictEncryption {
+		t.Fatalf("Expected ErrStrictEncryption, took %v", err)
+	}
+}
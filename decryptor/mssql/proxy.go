@@ -0,0 +1,306 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mssql
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cossacklabs/acra/acra-censor"
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// Number and class of ERROR token sent by proxy when AcraCensor blocks statement, same as database sends when
+// permission is denied
+const (
+	ErrorNumberPermissionDenied = 229
+	ErrorClassPermission        = 14
+)
+
+// outputBufferSize is size of buffer of packets written to client, enough for packets of max size
+const outputBufferSize = 64 * 1024
+
+// Proxy forwards packets of TDS protocol between client and database after pre-login, checks SQL batches and
+// statements of RPC requests with AcraCensor and decrypts AcraStructs stored as values of varbinary and image columns
+// in tabular results of database
+type Proxy struct {
+	clientConnection net.Conn
+	dbConnection     net.Conn
+	decryptor        base.Decryptor
+	censor           acracensor.AcraCensorInterface
+	logger           *log.Entry
+	// connectionStats accumulates counters of client's connection, may be nil
+	connectionStats *base.ConnectionStats
+	// onStartupFinished is called once when database acknowledged login of client, may be nil
+	onStartupFinished func()
+	// censorConnection describes client's connection for AcraCensor, filled from LOGIN7 and changes of database
+	censorConnection acracensor.ConnectionInfo
+	censorLock       sync.Mutex
+	// clientLock serializes messages written to client by both directions of proxy
+	clientLock sync.Mutex
+	// spid is id of session from packets of database which is used in packets sent by proxy
+	spid uint32
+	// unsupportedVersion is set when client logged in with version of protocol older than 7.2
+	unsupportedVersion int32
+	// result is state of tabular results used only by DecryptReplies
+	result resultState
+}
+
+// NewProxy returns proxy of client's connection which checks statements with censor and decrypts results with
+// decryptor. Connections should be passed through NegotiateTLS before
+func NewProxy(clientConnection, dbConnection net.Conn, decryptor base.Decryptor, censor acracensor.AcraCensorInterface) *Proxy {
+	return &Proxy{clientConnection: clientConnection, dbConnection: dbConnection, decryptor: decryptor, censor: censor,
+		logger: log.NewEntry(log.StandardLogger())}
+}
+
+// SetLogger sets logger of client's connection used by proxy instead of standard logger
+func (proxy *Proxy) SetLogger(logger *log.Entry) {
+	proxy.logger = logger
+}
+
+// SetConnectionStats sets counters of client's connection updated by proxy
+func (proxy *Proxy) SetConnectionStats(stats *base.ConnectionStats) {
+	proxy.connectionStats = stats
+	if stats != nil {
+		proxy.censorConnection.ClientID = stats.ClientID
+	}
+}
+
+// SetStartupCallback sets function called once when database sent LOGINACK
+func (proxy *Proxy) SetStartupCallback(callback func()) {
+	proxy.onStartupFinished = callback
+}
+
+func (proxy *Proxy) getCensorConnection() acracensor.ConnectionInfo {
+	proxy.censorLock.Lock()
+	defer proxy.censorLock.Unlock()
+	return proxy.censorConnection
+}
+
+func (proxy *Proxy) setDatabase(database string) {
+	proxy.censorLock.Lock()
+	proxy.censorConnection.Database = database
+	proxy.censorLock.Unlock()
+}
+
+func (proxy *Proxy) setApplication(application string) {
+	proxy.censorLock.Lock()
+	proxy.censorConnection.Application = application
+	proxy.censorLock.Unlock()
+}
+
+// writeError sends tabular result with error to client as answer on request
+func (proxy *Proxy) writeError(number int32, class byte, message string) error {
+	response := NewMessage(PacketTabularResult, uint16(atomic.LoadUint32(&proxy.spid)), newErrorResponse(number, class, message), DefaultPacketSize)
+	proxy.clientLock.Lock()
+	defer proxy.clientLock.Unlock()
+	_, err := proxy.clientConnection.Write(response.Dump())
+	return err
+}
+
+// ProxyClientRequests reads LOGIN7 of client, checks statements of SQL batches and RPC requests with AcraCensor and
+// forwards packets to database. Blocked requests are answered with permission denied error
+func (proxy *Proxy) ProxyClientRequests(errCh chan<- error) {
+	logger := proxy.logger.WithField("proxy", "client")
+	// continuation is set when next packet belongs to message which first packet was forwarded
+	continuation := false
+	for {
+		packet, err := ReadPacket(proxy.clientConnection)
+		if err != nil {
+			logger.WithError(err).Debugln("Can't read packet from client")
+			errCh <- err
+			return
+		}
+		output := packet.Dump()
+		// whole message is read when it's processed
+		messageRead := false
+		if !continuation {
+			message, err := proxy.processClientMessage(packet, logger)
+			if err != nil {
+				logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorProtocolProcessing).
+					Errorln("Can't process message from client")
+				errCh <- err
+				return
+			}
+			if message != nil {
+				output = message.Dump()
+				messageRead = true
+			}
+		}
+		if len(output) > 0 {
+			if _, err := proxy.dbConnection.Write(output); err != nil {
+				logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorResponseConnectorCantWriteToDB).
+					Debugln("Can't forward packet to database")
+				errCh <- err
+				return
+			}
+		}
+		continuation = !messageRead && !packet.IsLast()
+	}
+}
+
+// processClientMessage processes message which starts with packet. Returns whole message if it was read to check it,
+// empty message if it was blocked or nil if only packet should be forwarded
+func (proxy *Proxy) processClientMessage(packet *Packet, logger *log.Entry) (*Message, error) {
+	switch packet.Type {
+	case PacketLogin7:
+		message, err := readMessage(proxy.clientConnection, packet, MaxMessageSize)
+		if err != nil {
+			return nil, err
+		}
+		return message, proxy.processLogin(message, logger)
+	case PacketSQLBatch, PacketRPC:
+		proxy.connectionStats.AddQuery()
+		if proxy.censor == nil {
+			return nil, nil
+		}
+		message, err := readMessage(proxy.clientConnection, packet, MaxMessageSize)
+		if err != nil {
+			return nil, err
+		}
+		statements, err := proxy.statements(message)
+		switch err {
+		case nil:
+		case ErrUnsupportedDataType, ErrColumnEncryption:
+			// statements after parameter which can't be parsed aren't checked, so whole request is blocked
+			logger.WithError(err).Errorln("Can't parse all parameters of RPC request, block it")
+			return &Message{}, proxy.writeError(ErrorNumberPermissionDenied, ErrorClassPermission, "AcraCensor can't check parameters of this request")
+		default:
+			return nil, err
+		}
+		connection := proxy.getCensorConnection()
+		for _, statement := range statements {
+			if censorErr := proxy.censor.HandleConnectionQuery(connection, statement); censorErr != nil {
+				logger.WithError(censorErr).Errorln("AcraCensor blocked query")
+				return &Message{}, proxy.writeError(ErrorNumberPermissionDenied, ErrorClassPermission, "AcraCensor blocked this query")
+			}
+		}
+		return message, nil
+	}
+	return nil, nil
+}
+
+// processLogin saves application and database of client from LOGIN7
+func (proxy *Proxy) processLogin(message *Message, logger *log.Entry) error {
+	login, err := ParseLogin(message.Data)
+	if err != nil {
+		return err
+	}
+	proxy.connectionStats.SetTags(login.AppName, login.Tags())
+	proxy.setApplication(login.AppName)
+	proxy.setDatabase(login.Database)
+	if login.TDSVersion < Version72 {
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorProtocolProcessing).
+			Warningln("Client uses version of TDS older than 7.2, forward results without decryption")
+		atomic.StoreInt32(&proxy.unsupportedVersion, 1)
+	}
+	return nil
+}
+
+// statements returns SQL statements of SQL batch or RPC request
+func (proxy *Proxy) statements(message *Message) ([]string, error) {
+	if message.Type() == PacketSQLBatch {
+		statement, err := batchStatement(message.Data)
+		if err != nil {
+			return nil, err
+		}
+		return []string{statement}, nil
+	}
+	return rpcStatements(message.Data)
+}
+
+// DecryptReplies decrypts values of rows in tabular results of database and forwards packets to client
+func (proxy *Proxy) DecryptReplies(ctx context.Context, errCh chan<- error) {
+	logger := proxy.logger.WithField("proxy", "server")
+	output := bufio.NewWriterSize(proxy.clientConnection, outputBufferSize)
+	for {
+		packet, err := ReadPacket(proxy.dbConnection)
+		if err != nil {
+			logger.WithError(err).Debugln("Can't read packet from database")
+			errCh <- err
+			return
+		}
+		atomic.StoreUint32(&proxy.spid, uint32(packet.SPID))
+		proxy.clientLock.Lock()
+		if packet.Type != PacketTabularResult || atomic.LoadInt32(&proxy.unsupportedVersion) != 0 {
+			_, err = output.Write(packet.Dump())
+		} else {
+			err = proxy.processDBMessage(ctx, packet, output, logger)
+		}
+		if err == nil {
+			err = output.Flush()
+		}
+		proxy.clientLock.Unlock()
+		if err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorResponseConnectorCantWriteToClient).
+				Debugln("Can't forward message to client")
+			errCh <- err
+			return
+		}
+	}
+}
+
+// processDBMessage processes tokens of tabular result which starts with packet and writes it to output split by
+// packets of the same size
+func (proxy *Proxy) processDBMessage(ctx context.Context, packet *Packet, output *bufio.Writer, logger *log.Entry) error {
+	reader := newMessageReader(proxy.dbConnection, packet)
+	writer := newMessageWriter(output, packet, HeaderLength+len(packet.Data))
+	err := processTokens(reader, writer, &proxy.result, &replyHandler{proxy: proxy, ctx: ctx, logger: logger})
+	proxy.decryptor.ResetZoneMatch()
+	if err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// replyHandler handles tokens of one tabular result
+type replyHandler struct {
+	proxy  *Proxy
+	ctx    context.Context
+	logger *log.Entry
+}
+
+// processValue decrypts value of column. Values of columns which can't contain AcraStruct are still matched as zone id
+// in zone mode
+func (handler *replyHandler) processValue(column Column, value []byte) ([]byte, bool, error) {
+	decryptor := handler.proxy.decryptor
+	if !column.MayContainAcraStruct() && (!decryptor.IsWithZone() || decryptor.IsMatchedZone()) {
+		return value, false, nil
+	}
+	return base.DecryptValue(handler.ctx, decryptor, handler.proxy.connectionStats, column.Table, column.Name, value, handler.logger)
+}
+
+func (handler *replyHandler) loginAcknowledged() {
+	if handler.proxy.onStartupFinished != nil {
+		handler.proxy.onStartupFinished()
+		handler.proxy.onStartupFinished = nil
+	}
+}
+
+func (handler *replyHandler) databaseChanged(database string) {
+	handler.proxy.setDatabase(database)
+}
+
+func (handler *replyHandler) tokensSkipped(err error) {
+	handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorProtocolProcessing).
+		Warningln("Can't parse tabular result, forward rest of message without decryption")
+}
@@ -0,0 +1,253 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mssql
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/cossacklabs/acra/acra-censor"
+	"github.com/cossacklabs/acra/decryptor/base"
+)
+
+var testBeginTag = bytes.Repeat([]byte{'"'}, 8)
+
+// testDecryptor decrypts values which start with testBeginTag and contain "valid" by removing tag
+type testDecryptor struct {
+	base.Decryptor
+}
+
+func (*testDecryptor) IsWithZone() bool            { return false }
+func (*testDecryptor) Reset()                      {}
+func (*testDecryptor) ResetZoneMatch()             {}
+func (*testDecryptor) IsPoisonRecordCheckOn() bool { return false }
+func (*testDecryptor) BeginTagIndex(block []byte) (int, int) {
+	return bytes.Index(block, testBeginTag), len(testBeginTag)
+}
+func (*testDecryptor) DecryptBlock(block []byte) ([]byte, error) {
+	if !bytes.Contains(block, []byte("valid")) {
+		return nil, errors.New("invalid AcraStruct")
+	}
+	return block[len(testBeginTag):], nil
+}
+
+// testCensor blocks queries which contain "DROP" and saves connection and checked queries
+type testCensor struct {
+	acracensor.AcraCensorInterface
+	connection acracensor.ConnectionInfo
+	queries    []string
+}
+
+func (censor *testCensor) HandleConnectionQuery(connection acracensor.ConnectionInfo, query string) error {
+	censor.connection = connection
+	censor.queries = append(censor.queries, query)
+	if strings.Contains(query, "DROP") {
+		return errors.New("query blocked")
+	}
+	return nil
+}
+
+// testHeaders is ALL_HEADERS with transaction descriptor
+var testHeaders = append(appendUint32(appendUint32(appendUint16(appendUint32(nil, 22), 2), 18), 0), make([]byte, 12)...)[:22]
+
+func newTestLogin(version uint32, appName, database string) []byte {
+	data := make([]byte, loginFixedLength)
+	binary.LittleEndian.PutUint32(data[4:], version)
+	for _, field := range []struct {
+		offset int
+		value  string
+	}{{loginAppName, appName}, {loginDatabase, database}} {
+		value := encodeUCS2(field.value)
+		binary.LittleEndian.PutUint16(data[field.offset:], uint16(len(data)))
+		binary.LittleEndian.PutUint16(data[field.offset+2:], uint16(len(value)/2))
+		data = append(data, value...)
+	}
+	binary.LittleEndian.PutUint32(data, uint32(len(data)))
+	return data
+}
+
+func newTestBatch(query string) []byte {
+	return append(append([]byte{}, testHeaders...), encodeUCS2(query)...)
+}
+
+// appendTestParameter appends unnamed nvarchar(max) parameter of RPC request
+func appendTestParameter(output []byte, value string) []byte {
+	output = append(append(output, 0, 0, TypeNVarChar, 0xFF, 0xFF), testCollation...)
+	return appendValue(output, Column{length: lengthPLP}, nil, encodeUCS2(value))
+}
+
+// newTestRPC returns RPC message which calls sp_executesql by id and sp_prepexec by name
+func newTestRPC(executeStatement, prepareStatement string) []byte {
+	data := append(append([]byte{}, testHeaders...), 0xFF, 0xFF, 10, 0, 0, 0)
+	data = appendTestParameter(data, executeStatement)
+	data = appendTestParameter(data, "@p int")
+	data = append(data, batchFlag)
+	// name of procedure and option flags
+	data = append(appendUSVarchar(data, "sys.SP_PREPEXEC"), 0, 0)
+	// output handle
+	data = append(appendBVarchar(data, "@handle"), 1, TypeIntN, 4, 0)
+	data = appendTestParameter(data, "")
+	return appendTestParameter(data, prepareStatement)
+}
+
+// newTestUnsupportedRPC returns RPC message which calls procedure with table-valued parameter and then sp_executesql
+func newTestUnsupportedRPC(executeStatement string) []byte {
+	data := append(appendUSVarchar(append([]byte{}, testHeaders...), "dbo.import_rows"), 0, 0)
+	data = append(appendBVarchar(data, "@rows"), 0, 0xF3)
+	data = append(data, batchFlag, 0xFF, 0xFF, 10, 0, 0, 0)
+	data = appendTestParameter(data, executeStatement)
+	return appendTestParameter(data, "")
+}
+
+func TestRequests(t *testing.T) {
+	login, err := ParseLogin(newTestLogin(0x74000004, "reporting", "sales"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if login.TDSVersion != 0x74000004 || login.AppName != "reporting" || login.Database != "sales" || login.UserName != "" {
+		t.Fatalf("Unexpected login %+v", login)
+	}
+	if tags := login.Tags(); len(tags) != 2 || tags["app_name"] != "reporting" {
+		t.Fatalf("Unexpected tags %v", tags)
+	}
+	if _, err := ParseLogin(newTestLogin(0x74000004, "reporting", "")[:100]); err != ErrMalformedPacket {
+		t.Fatalf("Expected ErrMalformedPacket, took %v", err)
+	}
+	if statement, err := batchStatement(newTestBatch("SELECT 1")); err != nil || statement != "SELECT 1" {
+		t.Fatalf("Unexpected statement %s: %v", statement, err)
+	}
+	statements, err := rpcStatements(newTestRPC("SELECT @p", "SELECT 2"))
+	if err != nil || len(statements) != 2 || statements[0] != "SELECT @p" || statements[1] != "SELECT 2" {
+		t.Fatalf("Unexpected statements %v: %v", statements, err)
+	}
+	if _, err := rpcStatements(newTestUnsupportedRPC("SELECT 1")); err != ErrUnsupportedDataType {
+		t.Fatalf("Expected ErrUnsupportedDataType, took %v", err)
+	}
+}
+
+func TestProxyClientRequests(t *testing.T) {
+	client, proxyClient := net.Pipe()
+	db, proxyDB := net.Pipe()
+	defer client.Close()
+	defer db.Close()
+	censor := &testCensor{}
+	proxy := NewProxy(proxyClient, proxyDB, &testDecryptor{}, censor)
+	proxy.SetConnectionStats(base.NewConnectionStats(1, []byte("client"), ""))
+	errCh := make(chan error, 1)
+	go proxy.ProxyClientRequests(errCh)
+
+	for _, request := range []*Message{
+		NewMessage(PacketLogin7, 0, newTestLogin(0x74000004, "reporting", "sales"), DefaultPacketSize),
+		NewMessage(PacketSQLBatch, 0, newTestBatch(strings.Repeat("SELECT 1;", 100)), 512),
+		NewMessage(PacketRPC, 0, newTestRPC("SELECT @p", "SELECT 2"), DefaultPacketSize),
+	} {
+		go client.Write(request.Dump())
+		forwarded, err := ReadMessage(db, MaxMessageSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(forwarded.Dump(), request.Dump()) {
+			t.Fatalf("Unexpected forwarded message of type %d", request.Type())
+		}
+	}
+	if censor.connection.Application != "reporting" || censor.connection.Database != "sales" || string(censor.connection.ClientID) != "client" {
+		t.Fatalf("Unexpected censor connection %+v", censor.connection)
+	}
+	if len(censor.queries) != 3 || censor.queries[1] != "SELECT @p" {
+		t.Fatalf("Unexpected checked queries %v", censor.queries)
+	}
+
+	// blocked requests are answered by proxy
+	for _, request := range []*Message{
+		NewMessage(PacketSQLBatch, 0, newTestBatch("DROP TABLE users"), DefaultPacketSize),
+		NewMessage(PacketRPC, 0, newTestRPC("SELECT @p", "DROP TABLE users"), DefaultPacketSize),
+		// sp_executesql after parameter which can't be parsed isn't checked, so request is blocked
+		NewMessage(PacketRPC, 0, newTestUnsupportedRPC("DROP TABLE users"), DefaultPacketSize),
+	} {
+		go client.Write(request.Dump())
+		response, err := ReadMessage(client, MaxMessageSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.Type() != PacketTabularResult || response.Data[0] != tokenError ||
+			binary.LittleEndian.Uint32(response.Data[3:]) != ErrorNumberPermissionDenied {
+			t.Fatalf("Unexpected answer on blocked request %v", response.Data)
+		}
+	}
+
+	// attention is forwarded as is
+	attention := NewMessage(PacketAttention, 0, nil, DefaultPacketSize)
+	go client.Write(attention.Dump())
+	if forwarded, err := ReadMessage(db, MaxMessageSize); err != nil || forwarded.Type() != PacketAttention {
+		t.Fatalf("Attention wasn't forwarded: %v", err)
+	}
+}
+
+func TestProxyDecryptReplies(t *testing.T) {
+	client, proxyClient := net.Pipe()
+	db, proxyDB := net.Pipe()
+	defer client.Close()
+	defer db.Close()
+	censor := &testCensor{}
+	proxy := NewProxy(proxyClient, proxyDB, &testDecryptor{}, censor)
+	startupFinished := false
+	proxy.SetStartupCallback(func() { startupFinished = true })
+	errCh := make(chan error, 1)
+	go proxy.DecryptReplies(context.Background(), errCh)
+
+	loginResponse := append(append([]byte{tokenLoginAck, 1, 0, 1}, newEnvChange("sales")...), newDone()...)
+	go db.Write(NewMessage(PacketTabularResult, 52, loginResponse, DefaultPacketSize).Dump())
+	response, err := ReadMessage(client, MaxMessageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(response.Data, loginResponse) || response.Packets[0].SPID != 52 {
+		t.Fatalf("Unexpected login response %v", response.Data)
+	}
+	if !startupFinished || proxy.getCensorConnection().Database != "sales" {
+		t.Fatal("LOGINACK and change of database weren't processed")
+	}
+
+	acraStruct := append(append([]byte{}, testBeginTag...), bytes.Repeat([]byte("valid data "), 20)...)
+	invalidAcraStruct := append(append([]byte{}, testBeginTag...), bytes.Repeat([]byte("wrong data "), 20)...)
+	columns := []testColumn{testIntColumn, testBinaryColumn, testBinaryColumn}
+	var result, expected []byte
+	result = newColumnMetadata(columns)
+	expected = newColumnMetadata(columns)
+	for i := 0; i < 20; i++ {
+		result = append(result, newRow(columns, [][]byte{{byte(i), 0, 0, 0}, acraStruct, invalidAcraStruct})...)
+		expected = append(expected, newRow(columns, [][]byte{{byte(i), 0, 0, 0}, acraStruct[len(testBeginTag):], invalidAcraStruct})...)
+	}
+	result = append(result, newDone()...)
+	expected = append(expected, newDone()...)
+	message := NewMessage(PacketTabularResult, 52, result, 512)
+	go db.Write(message.Dump())
+	if response, err = ReadMessage(client, MaxMessageSize); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(response.Data, expected) {
+		t.Fatalf("Unexpected decrypted result %q", response.Data)
+	}
+	if len(response.Packets) > len(message.Packets) || len(response.Packets[0].Data) != len(message.Packets[0].Data) {
+		t.Fatalf("Unexpected packets of decrypted result, took %d packets", len(response.Packets))
+	}
+}
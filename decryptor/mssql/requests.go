@@ -0,0 +1,228 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mssql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+// Version72 is the first version of TDS protocol which is supported by proxy (SQL Server 2005)
+const Version72 = 0x72090002
+
+const (
+	loginFixedLength = 94
+	// procIDMarker is sent instead of length of name of special stored procedure called by id
+	procIDMarker = 0xFFFF
+	// parameterEncrypted is set in status of RPC parameters encrypted by Always Encrypted
+	parameterEncrypted = 0x08
+	batchFlag          = 0xFF
+	noExecBatchFlag    = 0xFE
+)
+
+// Offsets of strings in LOGIN7 message
+const (
+	loginHostName   = 36
+	loginUserName   = 40
+	loginAppName    = 48
+	loginServerName = 52
+	loginLibrary    = 60
+	loginLanguage   = 64
+	loginDatabase   = 68
+)
+
+// Names of stored procedures which have SQL statement as parameter and index of this parameter
+var statementParameters = map[string]int{
+	"sp_cursoropen":     1,
+	"sp_cursorprepare":  2,
+	"sp_cursorprepexec": 3,
+	"sp_executesql":     0,
+	"sp_prepare":        2,
+	"sp_prepexec":       2,
+}
+
+// Names of special stored procedures which are called by id
+var procedureNames = map[uint16]string{
+	2:  "sp_cursoropen",
+	3:  "sp_cursorprepare",
+	5:  "sp_cursorprepexec",
+	10: "sp_executesql",
+	11: "sp_prepare",
+	13: "sp_prepexec",
+}
+
+// Login describes LOGIN7 message of client
+type Login struct {
+	TDSVersion uint32
+	HostName   string
+	UserName   string
+	AppName    string
+	ServerName string
+	Library    string
+	Language   string
+	Database   string
+}
+
+// ParseLogin returns fields of LOGIN7 message
+func ParseLogin(data []byte) (*Login, error) {
+	if len(data) < loginFixedLength {
+		return nil, ErrMalformedPacket
+	}
+	login := &Login{TDSVersion: binary.LittleEndian.Uint32(data[4:])}
+	for _, field := range []struct {
+		offset int
+		value  *string
+	}{
+		{loginHostName, &login.HostName},
+		{loginUserName, &login.UserName},
+		{loginAppName, &login.AppName},
+		{loginServerName, &login.ServerName},
+		{loginLibrary, &login.Library},
+		{loginLanguage, &login.Language},
+		{loginDatabase, &login.Database},
+	} {
+		start := int(binary.LittleEndian.Uint16(data[field.offset:]))
+		end := start + int(binary.LittleEndian.Uint16(data[field.offset+2:]))*2
+		if end > len(data) {
+			return nil, ErrMalformedPacket
+		}
+		*field.value = decodeUCS2(data[start:end])
+	}
+	return login, nil
+}
+
+// Tags returns non-empty fields of login which describe client's connection
+func (login *Login) Tags() map[string]string {
+	tags := make(map[string]string)
+	for name, value := range map[string]string{
+		"host_name":   login.HostName,
+		"user_name":   login.UserName,
+		"app_name":    login.AppName,
+		"server_name": login.ServerName,
+		"library":     login.Library,
+		"language":    login.Language,
+		"database":    login.Database,
+	} {
+		if value != "" {
+			tags[name] = value
+		}
+	}
+	return tags
+}
+
+// skipHeaders returns data of SQL batch or RPC request after ALL_HEADERS
+func skipHeaders(data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, ErrMalformedPacket
+	}
+	length := binary.LittleEndian.Uint32(data)
+	if length < 4 || uint64(length) > uint64(len(data)) {
+		return nil, ErrMalformedPacket
+	}
+	return data[length:], nil
+}
+
+// batchStatement returns SQL text of SQL batch message
+func batchStatement(data []byte) (string, error) {
+	text, err := skipHeaders(data)
+	if err != nil {
+		return "", err
+	}
+	return decodeUCS2(text), nil
+}
+
+// rpcStatements returns SQL statements which are parameters of calls of sp_executesql and stored procedures which
+// prepare statements in RPC message. Statements found before unsupported parameter are returned with error
+func rpcStatements(data []byte) ([]string, error) {
+	requests, err := skipHeaders(data)
+	if err != nil {
+		return nil, err
+	}
+	s := newStream(bytes.NewReader(requests), nil)
+	var statements []string
+	for {
+		nameLength, err := s.readUint16()
+		if err == io.EOF {
+			return statements, nil
+		}
+		if err != nil {
+			return statements, err
+		}
+		var name string
+		if nameLength == procIDMarker {
+			id, err := s.readUint16()
+			if err != nil {
+				return statements, err
+			}
+			name = procedureNames[id]
+		} else {
+			data, err := s.read(int(nameLength) * 2)
+			if err != nil {
+				return statements, err
+			}
+			name = decodeUCS2(data)
+			// names may be qualified like sys.sp_executesql
+			name = strings.ToLower(name[strings.LastIndex(name, ".")+1:])
+		}
+		statementIndex, hasStatement := statementParameters[name]
+		// option flags
+		if _, err := s.readUint16(); err != nil {
+			return statements, err
+		}
+		for i := 0; ; i++ {
+			next, err := s.input.Peek(1)
+			if err == io.EOF {
+				return statements, nil
+			}
+			if err != nil {
+				return statements, err
+			}
+			if next[0] == batchFlag || next[0] == noExecBatchFlag {
+				s.input.Discard(1)
+				break
+			}
+			if _, err := s.readBVarchar(); err != nil {
+				return statements, err
+			}
+			status, err := s.readByte()
+			if err != nil {
+				return statements, err
+			}
+			if status&parameterEncrypted != 0 {
+				return statements, ErrColumnEncryption
+			}
+			column, err := readTypeInfo(s, false)
+			if err != nil {
+				return statements, err
+			}
+			value, err := readValue(s, column)
+			if err != nil {
+				return statements, err
+			}
+			if !hasStatement || i != statementIndex || value == nil {
+				continue
+			}
+			if column.IsUnicode() {
+				statements = append(statements, decodeUCS2(value))
+			} else {
+				statements = append(statements, string(value))
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mssql
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"unicode/utf16"
+)
+
+// stream reads data of message. Read data is copied to output if it's set, so parts of message which aren't changed
+// are forwarded while they are parsed
+type stream struct {
+	input  *bufio.Reader
+	output io.Writer
+}
+
+func newStream(input io.Reader, output io.Writer) *stream {
+	return &stream{input: bufio.NewReader(input), output: output}
+}
+
+func (s *stream) read(n int) ([]byte, error) {
+	data := make([]byte, n)
+	if _, err := io.ReadFull(s.input, data); err != nil {
+		return nil, err
+	}
+	if s.output != nil {
+		if _, err := s.output.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func (s *stream) readByte() (byte, error) {
+	data, err := s.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+func (s *stream) readUint16() (uint16, error) {
+	data, err := s.read(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(data), nil
+}
+
+func (s *stream) readUint32() (uint32, error) {
+	data, err := s.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(data), nil
+}
+
+func (s *stream) readUint64() (uint64, error) {
+	data, err := s.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(data), nil
+}
+
+// skip copies n bytes to output without buffering them
+func (s *stream) skip(n int64) error {
+	output := s.output
+	if output == nil {
+		output = ioutil.Discard
+	}
+	copied, err := io.CopyN(output, s.input, n)
+	if err == io.EOF && copied < n {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readBVarchar reads UCS-2 string with length in characters in one byte
+func (s *stream) readBVarchar() (string, error) {
+	length, err := s.readByte()
+	if err != nil {
+		return "", err
+	}
+	data, err := s.read(int(length) * 2)
+	if err != nil {
+		return "", err
+	}
+	return decodeUCS2(data), nil
+}
+
+// readUSVarchar reads UCS-2 string with length in characters in two bytes
+func (s *stream) readUSVarchar() (string, error) {
+	length, err := s.readUint16()
+	if err != nil {
+		return "", err
+	}
+	data, err := s.read(int(length) * 2)
+	if err != nil {
+		return "", err
+	}
+	return decodeUCS2(data), nil
+}
+
+// decodeUCS2 returns string of UTF-16LE data used for all strings of protocol
+func decodeUCS2(data []byte) string {
+	chars := make([]uint16, len(data)/2)
+	for i := range chars {
+		chars[i] = binary.LittleEndian.Uint16(data[i*2:])
+	}
+	return string(utf16.Decode(chars))
+}
+
+// encodeUCS2 returns UTF-16LE data of string
+func encodeUCS2(value string) []byte {
+	chars := utf16.Encode([]rune(value))
+	data := make([]byte, len(chars)*2)
+	for i, char := range chars {
+		binary.LittleEndian.PutUint16(data[i*2:], char)
+	}
+	return data
+}
@@ -0,0 +1,337 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mssql
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/cossacklabs/acra/acraerrors"
+)
+
+// Tokens of tabular result
+const (
+	tokenColMetadata   = 0x81
+	tokenColInfo       = 0xA5
+	tokenDone          = 0xFD
+	tokenDoneProc      = 0xFE
+	tokenDoneInProc    = 0xFF
+	tokenEnvChange     = 0xE3
+	tokenError         = 0xAA
+	tokenFeatureExtAck = 0xAE
+	tokenFedAuthInfo   = 0xEE
+	tokenInfo          = 0xAB
+	tokenLoginAck      = 0xAD
+	tokenNBCRow        = 0xD2
+	tokenOffset        = 0x78
+	tokenOrder         = 0xA9
+	tokenReturnStatus  = 0x79
+	tokenReturnValue   = 0xAC
+	tokenRow           = 0xD1
+	tokenSessionState  = 0xE4
+	tokenSSPI          = 0xED
+	tokenTabName       = 0xA4
+)
+
+const (
+	envChangeDatabase       = 1
+	featureColumnEncryption = 0x04
+	featureTerminator       = 0xFF
+	// noMetadata is count of columns in COLMETADATA of cursor fetches which reuse previous metadata
+	noMetadata = 0xFFFF
+	doneLength = 12
+	doneError  = 0x0002
+)
+
+// Errors returned on processing of tabular results
+var (
+	ErrMalformedTokenStream = acraerrors.New(acraerrors.CodeMSSQLMalformedTokenStream, "malformed token stream of tabular result")
+	ErrColumnEncryption     = acraerrors.New(acraerrors.CodeMSSQLColumnEncryption, "column encryption of SQL Server isn't supported")
+)
+
+// tokenHandler processes values of rows and is notified about tokens which change state of connection
+type tokenHandler interface {
+	// processValue returns new value of column and true if value was changed
+	processValue(column Column, value []byte) ([]byte, bool, error)
+	loginAcknowledged()
+	databaseChanged(database string)
+	// tokensSkipped is called when rest of message is forwarded as is because it can't be parsed
+	tokensSkipped(err error)
+}
+
+// resultState is state of tabular results kept between messages
+type resultState struct {
+	// columns of last COLMETADATA, nil if it wasn't parsed
+	columns []Column
+	// columnEncryption is set when database acknowledged Always Encrypted feature which changes COLMETADATA
+	columnEncryption bool
+}
+
+// handlerError wraps errors of handler which stop processing of connection
+type handlerError struct {
+	err error
+}
+
+func (err handlerError) Error() string {
+	return err.err.Error()
+}
+
+// processTokens reads tokens of tabular result from reader and writes them to output with values of rows processed by
+// handler. The rest of message after unsupported token or data type is forwarded as is. Returned errors mean that
+// message can't be read, written or handled
+func processTokens(reader *messageReader, output io.Writer, state *resultState, handler tokenHandler) error {
+	s := newStream(reader, output)
+	err := processTokenStream(s, state, handler)
+	if err == nil {
+		return nil
+	}
+	if handlerErr, ok := err.(handlerError); ok {
+		return handlerErr.err
+	}
+	if reader.err != nil {
+		return reader.err
+	}
+	state.columns = nil
+	handler.tokensSkipped(err)
+	_, err = io.Copy(output, s.input)
+	return err
+}
+
+func processTokenStream(s *stream, state *resultState, handler tokenHandler) error {
+	for {
+		token, err := s.readByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch token {
+		case tokenColMetadata:
+			err = readColumnMetadata(s, state)
+		case tokenRow, tokenNBCRow:
+			err = processRow(s, state, handler, token == tokenNBCRow)
+		case tokenLoginAck:
+			if err = skipToken(s, 2); err == nil {
+				handler.loginAcknowledged()
+			}
+		case tokenEnvChange:
+			err = processEnvChange(s, handler)
+		case tokenFeatureExtAck:
+			err = processFeatureExtAck(s, state)
+		case tokenReturnValue:
+			err = skipReturnValue(s)
+		case tokenDone, tokenDoneProc, tokenDoneInProc:
+			err = s.skip(doneLength)
+		case tokenReturnStatus, tokenOffset:
+			err = s.skip(4)
+		case tokenOrder, tokenError, tokenInfo, tokenTabName, tokenColInfo, tokenSSPI:
+			err = skipToken(s, 2)
+		case tokenFedAuthInfo, tokenSessionState:
+			err = skipToken(s, 4)
+		default:
+			err = ErrMalformedTokenStream
+		}
+		if err == io.EOF {
+			// message ended inside of token
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// skipToken forwards token with length of lengthSize bytes
+func skipToken(s *stream, lengthSize int) error {
+	var length uint32
+	if lengthSize == 2 {
+		short, err := s.readUint16()
+		if err != nil {
+			return err
+		}
+		length = uint32(short)
+	} else {
+		var err error
+		if length, err = s.readUint32(); err != nil {
+			return err
+		}
+	}
+	return s.skip(int64(length))
+}
+
+// readColumnMetadata saves columns described by COLMETADATA token
+func readColumnMetadata(s *stream, state *resultState) error {
+	count, err := s.readUint16()
+	if err != nil {
+		return err
+	}
+	if count == noMetadata {
+		return nil
+	}
+	state.columns = nil
+	if state.columnEncryption {
+		return ErrColumnEncryption
+	}
+	columns := make([]Column, 0, count)
+	for i := 0; i < int(count); i++ {
+		// user type and flags
+		if _, err := s.read(6); err != nil {
+			return err
+		}
+		column, err := readTypeInfo(s, true)
+		if err != nil {
+			return err
+		}
+		if column.Name, err = s.readBVarchar(); err != nil {
+			return err
+		}
+		columns = append(columns, column)
+	}
+	state.columns = columns
+	return nil
+}
+
+// processRow processes values of ROW or NBCROW token which may contain AcraStructs or zone ids, other values are
+// forwarded as is
+func processRow(s *stream, state *resultState, handler tokenHandler, withNullBitmap bool) error {
+	if state.columns == nil {
+		return ErrMalformedTokenStream
+	}
+	var nullBitmap []byte
+	if withNullBitmap {
+		var err error
+		if nullBitmap, err = s.read((len(state.columns) + 7) / 8); err != nil {
+			return err
+		}
+	}
+	output := s.output
+	defer func() {
+		s.output = output
+	}()
+	encoded := &bytes.Buffer{}
+	for i, column := range state.columns {
+		if nullBitmap != nil && nullBitmap[i/8]&(1<<uint(i%8)) != 0 {
+			continue
+		}
+		if !column.MayContainZoneID() {
+			if err := skipValue(s, column); err != nil {
+				return err
+			}
+			continue
+		}
+		// encoded value is collected to forward it as is if it isn't changed
+		encoded.Reset()
+		s.output = encoded
+		value, err := readValue(s, column)
+		s.output = output
+		if err != nil {
+			output.Write(encoded.Bytes())
+			return err
+		}
+		if value != nil {
+			newValue, changed, err := handler.processValue(column, value)
+			if err != nil {
+				return handlerError{err}
+			}
+			if changed {
+				if _, err := output.Write(appendValue(nil, column, encoded.Bytes(), newValue)); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if _, err := output.Write(encoded.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processEnvChange notifies handler about change of current database
+func processEnvChange(s *stream, handler tokenHandler) error {
+	length, err := s.readUint16()
+	if err != nil {
+		return err
+	}
+	data, err := s.read(int(length))
+	if err != nil {
+		return err
+	}
+	if len(data) < 2 || data[0] != envChangeDatabase {
+		return nil
+	}
+	end := 2 + int(data[1])*2
+	if end > len(data) {
+		return ErrMalformedTokenStream
+	}
+	handler.databaseChanged(decodeUCS2(data[2:end]))
+	return nil
+}
+
+// processFeatureExtAck saves whether database acknowledged column encryption
+func processFeatureExtAck(s *stream, state *resultState) error {
+	for {
+		feature, err := s.readByte()
+		if err != nil || feature == featureTerminator {
+			return err
+		}
+		if feature == featureColumnEncryption {
+			state.columnEncryption = true
+		}
+		if err := skipToken(s, 4); err != nil {
+			return err
+		}
+	}
+}
+
+// skipReturnValue forwards output parameter of stored procedure
+func skipReturnValue(s *stream) error {
+	// ordinal
+	if _, err := s.readUint16(); err != nil {
+		return err
+	}
+	if _, err := s.readBVarchar(); err != nil {
+		return err
+	}
+	// status, user type and flags
+	if _, err := s.read(7); err != nil {
+		return err
+	}
+	column, err := readTypeInfo(s, false)
+	if err != nil {
+		return err
+	}
+	return skipValue(s, column)
+}
+
+// newErrorResponse returns data of tabular result with ERROR token and DONE token with error status
+func newErrorResponse(number int32, class byte, message string) []byte {
+	body := appendUint32(nil, uint32(number))
+	// state and class
+	body = append(body, 1, class)
+	body = appendUSVarchar(body, message)
+	// server and procedure names
+	body = appendBVarchar(appendBVarchar(body, ""), "")
+	// line number
+	body = appendUint32(body, 1)
+	output := appendUint16([]byte{tokenError}, uint16(len(body)))
+	output = append(output, body...)
+	output = appendUint16(append(output, tokenDone), doneError)
+	// current command and row count
+	return append(output, make([]byte, doneLength-2)...)
+}
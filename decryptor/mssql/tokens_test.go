@@ -0,0 +1,221 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mssql
+
+import (
+	"bytes"
+	"testing"
+)
+
+// testColumn describes column of result set built by tests with TYPE_INFO and encoded values
+type testColumn struct {
+	name     string
+	typeInfo []byte
+	encode   func(value []byte) []byte
+}
+
+var (
+	testCollation  = []byte{9, 4, 208, 0, 52}
+	testIntColumn  = testColumn{"id", []byte{TypeInt4}, func(value []byte) []byte { return value }}
+	testTextColumn = testColumn{"name", append([]byte{TypeNVarChar, 100, 0}, testCollation...), func(value []byte) []byte {
+		return append(appendUint16(nil, uint16(len(value))), value...)
+	}}
+	testBinaryColumn = testColumn{"data", []byte{TypeBigVarBinary, 0x40, 0x1F}, func(value []byte) []byte {
+		return append(appendUint16(nil, uint16(len(value))), value...)
+	}}
+	// varbinary(max) values are sent in chunks of 100 bytes
+	testMaxBinaryColumn = testColumn{"document", []byte{TypeBigVarBinary, 0xFF, 0xFF}, func(value []byte) []byte {
+		output := appendUint64(nil, uint64(len(value)))
+		for len(value) > 0 {
+			chunk := value
+			if len(chunk) > 100 {
+				chunk = chunk[:100]
+			}
+			output = append(appendUint32(output, uint32(len(chunk))), chunk...)
+			value = value[len(chunk):]
+		}
+		return appendUint32(output, 0)
+	}}
+	testImageColumn = testColumn{"photo", appendUSVarchar(append(appendUint32([]byte{TypeImage}, 0x7FFFFFFF), 1), "users"), func(value []byte) []byte {
+		output := append([]byte{16}, bytes.Repeat([]byte{7}, 16+timestampLength)...)
+		return append(appendUint32(output, uint32(len(value))), value...)
+	}}
+)
+
+// newColumnMetadata returns COLMETADATA token of columns
+func newColumnMetadata(columns []testColumn) []byte {
+	output := appendUint16([]byte{tokenColMetadata}, uint16(len(columns)))
+	for _, column := range columns {
+		// user type and flags
+		output = append(output, make([]byte, 6)...)
+		output = appendBVarchar(append(output, column.typeInfo...), column.name)
+	}
+	return output
+}
+
+// newRow returns ROW token of values or NBCROW token if some values are nil
+func newRow(columns []testColumn, values [][]byte) []byte {
+	output := []byte{tokenRow}
+	nullBitmap := make([]byte, (len(columns)+7)/8)
+	var data []byte
+	for i, value := range values {
+		if value == nil {
+			output[0] = tokenNBCRow
+			nullBitmap[i/8] |= 1 << uint(i%8)
+			continue
+		}
+		data = append(data, columns[i].encode(value)...)
+	}
+	if output[0] == tokenNBCRow {
+		output = append(output, nullBitmap...)
+	}
+	return append(output, data...)
+}
+
+// newEnvChange returns ENVCHANGE token of change of database from master
+func newEnvChange(database string) []byte {
+	body := appendBVarchar(appendBVarchar([]byte{envChangeDatabase}, database), "master")
+	return append(appendUint16([]byte{tokenEnvChange}, uint16(len(body))), body...)
+}
+
+func newDone() []byte {
+	return append([]byte{tokenDone}, make([]byte, doneLength)...)
+}
+
+// testHandler removes "enc:" prefix of values and saves notifications
+type testHandler struct {
+	columns    []Column
+	loginAck   bool
+	database   string
+	skippedErr error
+}
+
+func (handler *testHandler) processValue(column Column, value []byte) ([]byte, bool, error) {
+	handler.columns = append(handler.columns, column)
+	if !column.MayContainAcraStruct() || !bytes.HasPrefix(value, []byte("enc:")) {
+		return value, false, nil
+	}
+	return value[4:], true, nil
+}
+
+func (handler *testHandler) loginAcknowledged()              { handler.loginAck = true }
+func (handler *testHandler) databaseChanged(database string) { handler.database = database }
+func (handler *testHandler) tokensSkipped(err error)         { handler.skippedErr = err }
+
+// processTestMessage processes data sent in packets of packetSize
+func processTestMessage(data []byte, packetSize int, state *resultState, handler tokenHandler) ([]byte, error) {
+	message := NewMessage(PacketTabularResult, 0, data, packetSize)
+	var rest []byte
+	for _, packet := range message.Packets[1:] {
+		rest = append(rest, packet.Dump()...)
+	}
+	reader := newMessageReader(bytes.NewReader(rest), message.Packets[0])
+	output := &bytes.Buffer{}
+	err := processTokens(reader, output, state, handler)
+	return output.Bytes(), err
+}
+
+func TestProcessTokens(t *testing.T) {
+	columns := []testColumn{testIntColumn, testTextColumn, testBinaryColumn, testMaxBinaryColumn, testImageColumn}
+	id := []byte{1, 0, 0, 0}
+	name := encodeUCS2("enc:name")
+	data := []byte("enc:data")
+	document := append([]byte("enc:"), bytes.Repeat([]byte("document "), 50)...)
+	photo := []byte("enc:photo")
+	input := newColumnMetadata(columns)
+	input = append(input, newRow(columns, [][]byte{id, name, data, document, photo})...)
+	input = append(input, newRow(columns, [][]byte{id, nil, []byte("plain"), nil, []byte{}})...)
+	input = append(input, newDone()...)
+
+	// changed varbinary(max) value is sent in one chunk
+	expectedColumns := append([]testColumn{}, columns...)
+	expectedColumns[3].encode = func(value []byte) []byte {
+		return appendValue(nil, Column{length: lengthPLP}, nil, value)
+	}
+	expected := newColumnMetadata(columns)
+	expected = append(expected, newRow(expectedColumns, [][]byte{id, name, data[4:], document[4:], photo[4:]})...)
+	expected = append(expected, newRow(columns, [][]byte{id, nil, []byte("plain"), nil, []byte{}})...)
+	expected = append(expected, newDone()...)
+
+	for _, packetSize := range []int{DefaultPacketSize, HeaderLength + 7} {
+		handler := &testHandler{}
+		state := &resultState{}
+		output, err := processTestMessage(input, packetSize, state, handler)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(output, expected) {
+			t.Fatalf("Unexpected processed tokens with packets of %d bytes %v", packetSize, output)
+		}
+		if handler.skippedErr != nil {
+			t.Fatalf("Unexpected skipped tokens: %v", handler.skippedErr)
+		}
+		// int and nvarchar values aren't processed, NULL values are skipped
+		if len(handler.columns) != 5 || handler.columns[0].Name != "data" || handler.columns[1].Type != TypeBigVarBinary ||
+			handler.columns[2].Name != "photo" || handler.columns[2].Table != "users" {
+			t.Fatalf("Unexpected processed columns %+v", handler.columns)
+		}
+		if len(state.columns) != len(columns) {
+			t.Fatalf("Unexpected columns saved %+v", state.columns)
+		}
+	}
+}
+
+func TestProcessTokensState(t *testing.T) {
+	columns := []testColumn{testBinaryColumn}
+	state := &resultState{}
+	handler := &testHandler{}
+	loginAck := []byte{tokenLoginAck, 3, 0, 1, 2, 3}
+	input := append(append(append(loginAck, newEnvChange("sales")...), newColumnMetadata(columns)...), newDone()...)
+	output, err := processTestMessage(input, DefaultPacketSize, state, handler)
+	if err != nil || !bytes.Equal(output, input) {
+		t.Fatalf("Unexpected output %v: %v", output, err)
+	}
+	if !handler.loginAck || handler.database != "sales" {
+		t.Fatalf("Handler wasn't notified %+v", handler)
+	}
+
+	// rows of cursor fetch use previous metadata
+	input = appendUint16([]byte{tokenColMetadata}, noMetadata)
+	input = append(input, newRow(columns, [][]byte{[]byte("enc:data")})...)
+	expected := append(appendUint16([]byte{tokenColMetadata}, noMetadata), newRow(columns, [][]byte{[]byte("data")})...)
+	if output, err = processTestMessage(input, DefaultPacketSize, state, handler); err != nil || !bytes.Equal(output, expected) {
+		t.Fatalf("Unexpected output %v: %v", output, err)
+	}
+
+	// rest of message after unknown token is forwarded as is
+	row := newRow(columns, [][]byte{[]byte("enc:data")})
+	input = append(append(append([]byte{}, row...), 0x01, 0x02), row...)
+	expected = append(append(newRow(columns, [][]byte{[]byte("data")}), 0x01, 0x02), row...)
+	if output, err = processTestMessage(input, DefaultPacketSize, state, handler); err != nil || !bytes.Equal(output, expected) {
+		t.Fatalf("Unexpected output %v: %v", output, err)
+	}
+	if handler.skippedErr != ErrMalformedTokenStream || state.columns != nil {
+		t.Fatalf("Expected skipped tokens, took %v", handler.skippedErr)
+	}
+
+	// metadata isn't parsed after acknowledgement of column encryption
+	handler.skippedErr = nil
+	featureExtAck := append(appendUint32([]byte{tokenFeatureExtAck, featureColumnEncryption}, 1), 1, featureTerminator)
+	input = append(append(featureExtAck, newColumnMetadata(columns)...), row...)
+	if output, err = processTestMessage(input, DefaultPacketSize, state, handler); err != nil || !bytes.Equal(output, input) {
+		t.Fatalf("Unexpected output %v: %v", output, err)
+	}
+	if handler.skippedErr != ErrColumnEncryption {
+		t.Fatalf("Expected ErrColumnEncryption, took %v", handler.skippedErr)
+	}
+}
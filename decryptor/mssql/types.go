@@ -0,0 +1,432 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mssql
+
+import (
+	"encoding/binary"
+
+	"github.com/cossacklabs/acra/acraerrors"
+)
+
+// Data types of TYPE_INFO
+const (
+	TypeNull           = 0x1F
+	TypeInt1           = 0x30
+	TypeBit            = 0x32
+	TypeInt2           = 0x34
+	TypeInt4           = 0x38
+	TypeDateTime4      = 0x3A
+	TypeFloat4         = 0x3B
+	TypeMoney          = 0x3C
+	TypeDateTime       = 0x3D
+	TypeFloat8         = 0x3E
+	TypeMoney4         = 0x7A
+	TypeInt8           = 0x7F
+	TypeGUID           = 0x24
+	TypeIntN           = 0x26
+	TypeDecimal        = 0x37
+	TypeNumeric        = 0x3F
+	TypeBitN           = 0x68
+	TypeDecimalN       = 0x6A
+	TypeNumericN       = 0x6C
+	TypeFloatN         = 0x6D
+	TypeMoneyN         = 0x6E
+	TypeDateTimeN      = 0x6F
+	TypeDateN          = 0x28
+	TypeTimeN          = 0x29
+	TypeDateTime2N     = 0x2A
+	TypeDateTimeOffset = 0x2B
+	TypeChar           = 0x2F
+	TypeVarChar        = 0x27
+	TypeBinary         = 0x2D
+	TypeVarBinary      = 0x25
+	TypeBigVarBinary   = 0xA5
+	TypeBigVarChar     = 0xA7
+	TypeBigBinary      = 0xAD
+	TypeBigChar        = 0xAF
+	TypeNVarChar       = 0xE7
+	TypeNChar          = 0xEF
+	TypeXML            = 0xF1
+	TypeUDT            = 0xF0
+	TypeText           = 0x23
+	TypeImage          = 0x22
+	TypeNText          = 0x63
+	TypeVariant        = 0x62
+)
+
+// Encodings of length of values
+const (
+	lengthFixed = iota
+	lengthByte
+	lengthUShort
+	lengthTextPointer
+	lengthLong
+	lengthPLP
+)
+
+var fixedLengths = map[byte]uint32{
+	TypeNull: 0, TypeInt1: 1, TypeBit: 1, TypeInt2: 2, TypeInt4: 4, TypeDateTime4: 4, TypeFloat4: 4, TypeMoney: 8,
+	TypeDateTime: 8, TypeFloat8: 8, TypeMoney4: 4, TypeInt8: 8,
+}
+
+const (
+	// ushortNull is length of NULL value of types with two bytes length and max length of partially length-prefixed
+	// types like varbinary(max)
+	ushortNull       = 0xFFFF
+	plpNull          = 0xFFFFFFFFFFFFFFFF
+	plpUnknownLength = 0xFFFFFFFFFFFFFFFE
+	collationLength  = 5
+	timestampLength  = 8
+	// MaxValueSize limits size of values which are read into memory to decrypt them
+	MaxValueSize = 256 * 1024 * 1024
+)
+
+// Errors returned on processing of values
+var (
+	ErrUnsupportedDataType = acraerrors.New(acraerrors.CodeMSSQLUnsupportedDataType, "unsupported data type of TDS protocol")
+	ErrValueTooLarge       = acraerrors.New(acraerrors.CodeMSSQLValueTooLarge, "value exceeds max size of decrypted values")
+)
+
+// Column describes column of result set or parameter of RPC request
+type Column struct {
+	Name string
+	// Table is set only for text, ntext and image columns which metadata contains name of table
+	Table string
+	Type  byte
+	// Size is max length of values of column
+	Size   uint32
+	length int
+}
+
+// MayContainAcraStruct returns true if column is varbinary or image which values are decrypted
+func (column Column) MayContainAcraStruct() bool {
+	switch column.Type {
+	case TypeBigVarBinary, TypeVarBinary, TypeImage:
+		return true
+	}
+	return false
+}
+
+// MayContainZoneID returns true if values of column may be zone id, so they are matched in zone mode
+func (column Column) MayContainZoneID() bool {
+	switch column.Type {
+	case TypeBigVarChar, TypeBigChar, TypeVarChar, TypeChar, TypeText, TypeBigBinary, TypeBinary:
+		return true
+	}
+	return column.MayContainAcraStruct()
+}
+
+// IsUnicode returns true if values of column are UCS-2 strings
+func (column Column) IsUnicode() bool {
+	return column.Type == TypeNVarChar || column.Type == TypeNChar || column.Type == TypeNText
+}
+
+// readTypeInfo reads TYPE_INFO of column. Name of table of text, ntext and image columns is read if withTableName is
+// set because it's sent only in COLMETADATA
+func readTypeInfo(s *stream, withTableName bool) (Column, error) {
+	dataType, err := s.readByte()
+	if err != nil {
+		return Column{}, err
+	}
+	column := Column{Type: dataType}
+	if size, ok := fixedLengths[dataType]; ok {
+		column.Size = size
+		column.length = lengthFixed
+		return column, nil
+	}
+	switch dataType {
+	case TypeDateN:
+		column.Size = 3
+		column.length = lengthByte
+	case TypeGUID, TypeIntN, TypeBitN, TypeFloatN, TypeMoneyN, TypeDateTimeN, TypeChar, TypeVarChar, TypeBinary, TypeVarBinary:
+		size, err := s.readByte()
+		if err != nil {
+			return column, err
+		}
+		column.Size = uint32(size)
+		column.length = lengthByte
+	case TypeDecimal, TypeNumeric, TypeDecimalN, TypeNumericN:
+		size, err := s.readByte()
+		if err != nil {
+			return column, err
+		}
+		// precision and scale
+		if _, err := s.read(2); err != nil {
+			return column, err
+		}
+		column.Size = uint32(size)
+		column.length = lengthByte
+	case TypeTimeN, TypeDateTime2N, TypeDateTimeOffset:
+		// scale
+		if _, err := s.readByte(); err != nil {
+			return column, err
+		}
+		column.length = lengthByte
+	case TypeBigVarBinary, TypeBigBinary, TypeBigVarChar, TypeBigChar, TypeNVarChar, TypeNChar:
+		size, err := s.readUint16()
+		if err != nil {
+			return column, err
+		}
+		column.Size = uint32(size)
+		column.length = lengthUShort
+		if size == ushortNull {
+			column.length = lengthPLP
+		}
+		if dataType != TypeBigVarBinary && dataType != TypeBigBinary {
+			if _, err := s.read(collationLength); err != nil {
+				return column, err
+			}
+		}
+	case TypeImage, TypeText, TypeNText:
+		if column.Size, err = s.readUint32(); err != nil {
+			return column, err
+		}
+		column.length = lengthTextPointer
+		if dataType != TypeImage {
+			if _, err := s.read(collationLength); err != nil {
+				return column, err
+			}
+		}
+		if !withTableName {
+			return column, nil
+		}
+		parts, err := s.readByte()
+		if err != nil {
+			return column, err
+		}
+		for i := 0; i < int(parts); i++ {
+			if column.Table, err = s.readUSVarchar(); err != nil {
+				return column, err
+			}
+		}
+	case TypeVariant:
+		if column.Size, err = s.readUint32(); err != nil {
+			return column, err
+		}
+		column.length = lengthLong
+	case TypeXML:
+		schemaPresent, err := s.readByte()
+		if err != nil {
+			return column, err
+		}
+		if schemaPresent != 0 {
+			if err := skipTypeNames(s, 2); err != nil {
+				return column, err
+			}
+		}
+		column.length = lengthPLP
+	case TypeUDT:
+		if _, err := s.readUint16(); err != nil {
+			return column, err
+		}
+		if err := skipTypeNames(s, 3); err != nil {
+			return column, err
+		}
+		column.length = lengthPLP
+	default:
+		return column, ErrUnsupportedDataType
+	}
+	return column, nil
+}
+
+// skipTypeNames skips names of database, schema and type of xml and UDT columns with one-byte length followed by
+// name with two-byte length
+func skipTypeNames(s *stream, count int) error {
+	for i := 0; i < count; i++ {
+		if _, err := s.readBVarchar(); err != nil {
+			return err
+		}
+	}
+	_, err := s.readUSVarchar()
+	return err
+}
+
+// readValue returns value of column which data is read into memory, nil means NULL
+func readValue(s *stream, column Column) ([]byte, error) {
+	switch column.length {
+	case lengthFixed:
+		return s.read(int(column.Size))
+	case lengthByte:
+		length, err := s.readByte()
+		if err != nil || length == 0 {
+			return nil, err
+		}
+		return s.read(int(length))
+	case lengthUShort:
+		length, err := s.readUint16()
+		if err != nil || length == ushortNull {
+			return nil, err
+		}
+		return s.read(int(length))
+	case lengthTextPointer:
+		pointerLength, err := s.readByte()
+		if err != nil || pointerLength == 0 {
+			return nil, err
+		}
+		if _, err := s.read(int(pointerLength) + timestampLength); err != nil {
+			return nil, err
+		}
+		return readLongValue(s)
+	case lengthLong:
+		return readLongValue(s)
+	case lengthPLP:
+		return readPLPValue(s)
+	}
+	return nil, ErrUnsupportedDataType
+}
+
+func readLongValue(s *stream) ([]byte, error) {
+	length, err := s.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	if length > MaxValueSize {
+		return nil, ErrValueTooLarge
+	}
+	return s.read(int(length))
+}
+
+// readPLPValue reads chunks of partially length-prefixed value
+func readPLPValue(s *stream) ([]byte, error) {
+	total, err := s.readUint64()
+	if err != nil || total == plpNull {
+		return nil, err
+	}
+	if total != plpUnknownLength && total > MaxValueSize {
+		return nil, ErrValueTooLarge
+	}
+	value := []byte{}
+	for {
+		length, err := s.readUint32()
+		if err != nil {
+			return nil, err
+		}
+		if length == 0 {
+			return value, nil
+		}
+		if uint64(len(value))+uint64(length) > MaxValueSize {
+			return nil, ErrValueTooLarge
+		}
+		chunk, err := s.read(int(length))
+		if err != nil {
+			return nil, err
+		}
+		value = append(value, chunk...)
+	}
+}
+
+// skipValue forwards value of column without reading of data into memory
+func skipValue(s *stream, column Column) error {
+	switch column.length {
+	case lengthFixed:
+		return s.skip(int64(column.Size))
+	case lengthByte:
+		length, err := s.readByte()
+		if err != nil {
+			return err
+		}
+		return s.skip(int64(length))
+	case lengthUShort:
+		length, err := s.readUint16()
+		if err != nil || length == ushortNull {
+			return err
+		}
+		return s.skip(int64(length))
+	case lengthTextPointer:
+		pointerLength, err := s.readByte()
+		if err != nil || pointerLength == 0 {
+			return err
+		}
+		if err := s.skip(int64(pointerLength) + timestampLength); err != nil {
+			return err
+		}
+		fallthrough
+	case lengthLong:
+		length, err := s.readUint32()
+		if err != nil {
+			return err
+		}
+		return s.skip(int64(length))
+	case lengthPLP:
+		total, err := s.readUint64()
+		if err != nil || total == plpNull {
+			return err
+		}
+		for {
+			length, err := s.readUint32()
+			if err != nil || length == 0 {
+				return err
+			}
+			if err := s.skip(int64(length)); err != nil {
+				return err
+			}
+		}
+	}
+	return ErrUnsupportedDataType
+}
+
+// appendValue appends value of column with new data to output. Text pointer and timestamp of image values are
+// taken from original encoded value
+func appendValue(output []byte, column Column, original, data []byte) []byte {
+	switch column.length {
+	case lengthByte:
+		output = append(output, byte(len(data)))
+	case lengthUShort:
+		output = appendUint16(output, uint16(len(data)))
+	case lengthTextPointer:
+		output = append(output, original[:1+int(original[0])+timestampLength]...)
+		output = appendUint32(output, uint32(len(data)))
+	case lengthLong:
+		output = appendUint32(output, uint32(len(data)))
+	case lengthPLP:
+		output = appendUint64(output, uint64(len(data)))
+		if len(data) > 0 {
+			output = appendUint32(output, uint32(len(data)))
+		}
+		output = append(output, data...)
+		return appendUint32(output, 0)
+	}
+	return append(output, data...)
+}
+
+func appendUint16(output []byte, value uint16) []byte {
+	return append(output, byte(value), byte(value>>8))
+}
+
+func appendUint32(output []byte, value uint32) []byte {
+	var buffer [4]byte
+	binary.LittleEndian.PutUint32(buffer[:], value)
+	return append(output, buffer[:]...)
+}
+
+func appendUint64(output []byte, value uint64) []byte {
+	var buffer [8]byte
+	binary.LittleEndian.PutUint64(buffer[:], value)
+	return append(output, buffer[:]...)
+}
+
+// appendBVarchar appends UCS-2 string with length in characters in one byte
+func appendBVarchar(output []byte, value string) []byte {
+	data := encodeUCS2(value)
+	return append(append(output, byte(len(data)/2)), data...)
+}
+
+// appendUSVarchar appends UCS-2 string with length in characters in two bytes
+func appendUSVarchar(output []byte, value string) []byte {
+	data := encodeUCS2(value)
+	return append(appendUint16(output, uint16(len(data)/2)), data...)
+}
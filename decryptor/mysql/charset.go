@@ -0,0 +1,120 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// CollationBinary is id of collation of binary strings which values are sent without conversion
+// https://dev.mysql.com/doc/internals/en/character-set.html
+const CollationBinary = 63
+
+// Names of character sets significant for processing of results
+const (
+	CharsetBinary  = "binary"
+	CharsetLatin1  = "latin1"
+	CharsetUTF8    = "utf8"
+	CharsetUTF8MB4 = "utf8mb4"
+)
+
+// collationCharsets maps ids of commonly used collations to names of their character sets
+// https://dev.mysql.com/doc/refman/8.0/en/show-collation.html
+var collationCharsets = map[uint16]string{
+	1: "big5", 3: "dec8", 4: "cp850", 5: CharsetLatin1, 8: CharsetLatin1, 9: "latin2", 11: "ascii", 12: "ujis",
+	13: "sjis", 15: CharsetLatin1, 19: "euckr", 24: "gb2312", 28: "gbk", 31: CharsetLatin1, 33: CharsetUTF8,
+	35: "ucs2", 45: CharsetUTF8MB4, 46: CharsetUTF8MB4, 47: CharsetLatin1, 48: CharsetLatin1, 49: CharsetLatin1,
+	51: "cp1251", 54: "utf16", 56: "utf16le", 57: "cp1256", 60: "utf32", 63: CharsetBinary, 65: "ascii",
+	76: CharsetUTF8, 83: CharsetUTF8, 84: "big5", 87: "gbk", 94: CharsetLatin1, 95: "cp932", 248: "gb18030",
+}
+
+// CharsetName returns name of character set of collation or empty string if collation is unknown
+func CharsetName(collation uint16) string {
+	if name, ok := collationCharsets[collation]; ok {
+		return name
+	}
+	switch {
+	case collation >= 192 && collation <= 215:
+		return CharsetUTF8
+	case collation >= 224 && collation <= 247, collation >= 255 && collation <= 323:
+		return CharsetUTF8MB4
+	}
+	return ""
+}
+
+// isUTF8Charset returns true if values of character set are encoded with UTF-8
+func isUTF8Charset(name string) bool {
+	switch strings.ToLower(name) {
+	case CharsetUTF8, "utf8mb3", CharsetUTF8MB4:
+		return true
+	}
+	return false
+}
+
+// setCharsetRegexp matches queries which change character set of results: SET NAMES, SET CHARACTER SET and
+// SET character_set_results
+var setCharsetRegexp = regexp.MustCompile(`(?i)^\s*set\s+(?:session\s+|@@session\.|@@)?(?:names|character\s+set|charset|character_set_results\s*(?:=|:=|to))\s*['"]?([a-z0-9_]+)`)
+
+// parseSetCharset returns name of character set of results set by query and true if query changes it
+func parseSetCharset(query string) (string, bool) {
+	match := setCharsetRegexp.FindStringSubmatch(query)
+	if match == nil {
+		return "", false
+	}
+	name := strings.ToLower(match[1])
+	if name == "null" || name == "default" {
+		// character_set_results = NULL turns off conversion of results
+		name = CharsetBinary
+	}
+	return name, true
+}
+
+// latin1HighRunes maps runes to bytes 0x80-0x9F of MySQL's latin1 which is cp1252 with 5 undefined bytes mapped to
+// control characters
+var latin1HighRunes = map[rune]byte{
+	0x20AC: 0x80, 0x201A: 0x82, 0x0192: 0x83, 0x201E: 0x84, 0x2026: 0x85, 0x2020: 0x86, 0x2021: 0x87, 0x02C6: 0x88,
+	0x2030: 0x89, 0x0160: 0x8A, 0x2039: 0x8B, 0x0152: 0x8C, 0x017D: 0x8E, 0x2018: 0x91, 0x2019: 0x92, 0x201C: 0x93,
+	0x201D: 0x94, 0x2022: 0x95, 0x2013: 0x96, 0x2014: 0x97, 0x02DC: 0x98, 0x2122: 0x99, 0x0161: 0x9A, 0x203A: 0x9B,
+	0x0153: 0x9C, 0x017E: 0x9E, 0x0178: 0x9F,
+	0x81: 0x81, 0x8D: 0x8D, 0x8F: 0x8F, 0x90: 0x90, 0x9D: 0x9D,
+}
+
+// latin1FromUTF8 reverts conversion of latin1 value to UTF-8 made by MySQL for results. Returns false if value can't
+// be result of such conversion
+func latin1FromUTF8(value []byte) ([]byte, bool) {
+	output := make([]byte, 0, len(value))
+	for len(value) > 0 {
+		r, size := utf8.DecodeRune(value)
+		if r == utf8.RuneError && size <= 1 {
+			return nil, false
+		}
+		value = value[size:]
+		switch {
+		case r < 0x80, r >= 0xA0 && r <= 0xFF:
+			output = append(output, byte(r))
+		default:
+			b, ok := latin1HighRunes[r]
+			if !ok {
+				return nil, false
+			}
+			output = append(output, b)
+		}
+	}
+	return output, true
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCharsetName(t *testing.T) {
+	testCases := map[uint16]string{8: CharsetLatin1, 33: CharsetUTF8, 45: CharsetUTF8MB4, 63: CharsetBinary, 192: CharsetUTF8, 224: CharsetUTF8MB4, 255: CharsetUTF8MB4, 1000: ""}
+	for collation, expected := range testCases {
+		if name := CharsetName(collation); name != expected {
+			t.Errorf("Collation %v: expected %s, took %s", collation, expected, name)
+		}
+	}
+}
+
+func TestParseSetCharset(t *testing.T) {
+	testCases := map[string]string{
+		"SET NAMES utf8mb4":                        CharsetUTF8MB4,
+		"set names 'latin1' collate latin1_bin":    CharsetLatin1,
+		"SET CHARACTER SET utf8":                   CharsetUTF8,
+		"SET character_set_results = NULL":         CharsetBinary,
+		"SET SESSION character_set_results=latin1": CharsetLatin1,
+		"SET @@character_set_results = 'utf8mb4'":  CharsetUTF8MB4,
+	}
+	for query, expected := range testCases {
+		name, ok := parseSetCharset(query)
+		if !ok || name != expected {
+			t.Errorf("%s: expected %s, took %s", query, expected, name)
+		}
+	}
+	for _, query := range []string{"SELECT 'SET NAMES utf8'", "SET autocommit=1", "SET character_set_client = utf8"} {
+		if _, ok := parseSetCharset(query); ok {
+			t.Errorf("%s: unexpected change of charset", query)
+		}
+	}
+}
+
+func TestLatin1FromUTF8(t *testing.T) {
+	// latin1 bytes 0x22 0x80 0x81 0xE9 0xFF converted by MySQL to UTF-8
+	converted := []byte("\"€\u0081éÿ")
+	original, ok := latin1FromUTF8(converted)
+	if !ok {
+		t.Fatal("Expected converted value")
+	}
+	if !bytes.Equal(original, []byte{0x22, 0x80, 0x81, 0xE9, 0xFF}) {
+		t.Fatalf("Incorrect latin1 value: %v", original)
+	}
+	for _, value := range [][]byte{[]byte("Ā"), []byte("世"), {0xff, 0xfe}} {
+		if _, ok := latin1FromUTF8(value); ok {
+			t.Errorf("Value %v can't be converted from latin1", value)
+		}
+	}
+}
+
+func TestIsConvertedField(t *testing.T) {
+	handler := &MysqlHandler{resultsCharset: CharsetUTF8MB4}
+	testCases := []struct {
+		collation uint16
+		converted bool
+	}{
+		{CollationBinary, false},
+		{8, false},
+		{33, true},
+		{255, true},
+		// unknown collation uses charset of results
+		{1000, true},
+	}
+	for _, testCase := range testCases {
+		if handler.isConvertedField(&ColumnDescription{Charset: testCase.collation}) != testCase.converted {
+			t.Errorf("Collation %v: expected %v", testCase.collation, testCase.converted)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"bytes"
+
+	"github.com/cossacklabs/acra/acra-censor"
+	"github.com/sirupsen/logrus"
+)
+
+// Capability flags which change format of handshake response
+const (
+	// ClientConnectWithDB - https://dev.mysql.com/doc/internals/en/capability-flags.html#flag-CLIENT_CONNECT_WITH_DB
+	ClientConnectWithDB = 0x00000008
+	// ClientSecureConnection - https://dev.mysql.com/doc/internals/en/capability-flags.html#flag-CLIENT_SECURE_CONNECTION
+	ClientSecureConnection = 0x00008000
+	// ClientPluginAuth - https://dev.mysql.com/doc/internals/en/capability-flags.html#flag-CLIENT_PLUGIN_AUTH
+	ClientPluginAuth = 0x00080000
+	// ClientConnectAttrs - https://dev.mysql.com/doc/internals/en/capability-flags.html#flag-CLIENT_CONNECT_ATTRS
+	ClientConnectAttrs = 0x00100000
+	// ClientPluginAuthLenencClientData - https://dev.mysql.com/doc/internals/en/capability-flags.html#flag-CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA
+	ClientPluginAuthLenencClientData = 0x00200000
+)
+
+// Connection attributes and tags with name of client's application, user and database
+const (
+	// ProgramNameAttribute - https://dev.mysql.com/doc/refman/8.0/en/performance-schema-connection-attribute-tables.html
+	ProgramNameAttribute = "program_name"
+	UserTag              = "user"
+	DatabaseTag          = "database"
+)
+
+// sslRequestLength is length of SSLRequest which is truncated handshake response with capabilities, max packet size,
+// character set and reserved bytes
+const sslRequestLength = 32
+
+// readNullTerminated returns string until null byte and count of read bytes with null byte
+func readNullTerminated(data []byte) (string, int, error) {
+	end := bytes.IndexByte(data, 0)
+	if end < 0 {
+		return "", 0, ErrMalformPacket
+	}
+	return string(data[:end]), end + 1, nil
+}
+
+// GetConnectionAttributes returns connection attributes of handshake response with user and database as UserTag and
+// DatabaseTag if client didn't send attributes with same names. Returns nil if packet is SSLRequest or client
+// doesn't support protocol 4.1
+// https://dev.mysql.com/doc/internals/en/connection-phase-packets.html#packet-Protocol::HandshakeResponse41
+func (packet *MysqlPacket) GetConnectionAttributes() (map[string]string, error) {
+	attributes, _, err := packet.parseHandshakeResponse()
+	return attributes, err
+}
+
+// parseHandshakeResponse returns connection attributes like GetConnectionAttributes and database which client selected
+// on connection. Database tag may be overridden by client's attribute while returned database is one used by server
+func (packet *MysqlPacket) parseHandshakeResponse() (map[string]string, string, error) {
+	if len(packet.data) <= sslRequestLength || !packet.ClientSupportProtocol41() {
+		return nil, "", nil
+	}
+	capabilities := packet.getClientCapabilities()
+	data := packet.data[sslRequestLength:]
+	user, n, err := readNullTerminated(data)
+	if err != nil {
+		return nil, "", err
+	}
+	data = data[n:]
+	// auth response
+	switch {
+	case capabilities&ClientPluginAuthLenencClientData != 0:
+		n, err = SkipLengthEncodedString(data)
+		if err != nil {
+			return nil, "", ErrMalformPacket
+		}
+	case capabilities&ClientSecureConnection != 0:
+		if len(data) == 0 || len(data) < 1+int(data[0]) {
+			return nil, "", ErrMalformPacket
+		}
+		n = 1 + int(data[0])
+	default:
+		if _, n, err = readNullTerminated(data); err != nil {
+			return nil, "", err
+		}
+	}
+	data = data[n:]
+	var database string
+	if capabilities&ClientConnectWithDB != 0 {
+		if database, n, err = readNullTerminated(data); err != nil {
+			return nil, "", err
+		}
+		data = data[n:]
+	}
+	if capabilities&ClientPluginAuth != 0 {
+		if _, n, err = readNullTerminated(data); err != nil {
+			return nil, "", err
+		}
+		data = data[n:]
+	}
+	attributes := make(map[string]string)
+	if capabilities&ClientConnectAttrs != 0 && len(data) > 0 {
+		length, _, n, err := LengthEncodedInt(data)
+		if err != nil || uint64(len(data)-n) < length {
+			return nil, "", ErrMalformPacket
+		}
+		data = data[n : n+int(length)]
+		for len(data) > 0 {
+			name, _, n, err := LengthEncodedString(data)
+			if err != nil {
+				return nil, "", ErrMalformPacket
+			}
+			data = data[n:]
+			value, _, n, err := LengthEncodedString(data)
+			if err != nil {
+				return nil, "", ErrMalformPacket
+			}
+			data = data[n:]
+			attributes[string(name)] = string(value)
+		}
+	}
+	if _, ok := attributes[UserTag]; !ok {
+		attributes[UserTag] = user
+	}
+	if _, ok := attributes[DatabaseTag]; !ok && database != "" {
+		attributes[DatabaseTag] = database
+	}
+	return attributes, database, nil
+}
+
+// tagConnection saves connection attributes of client as tags of connection and returns logger with name of client's
+// application. Returns false if packet is SSLRequest and attributes will be sent in handshake response after TLS
+// handshake. Handshake response is forwarded to database as is, so malformed packet is only logged
+func (handler *MysqlHandler) tagConnection(packet *MysqlPacket, logger *logrus.Entry) (*logrus.Entry, bool) {
+	attributes, database, err := packet.parseHandshakeResponse()
+	if err != nil {
+		logger.WithError(err).Debugln("Can't parse connection attributes")
+		return logger, true
+	}
+	if attributes == nil {
+		return logger, !packet.IsSSLRequest()
+	}
+	application := attributes[ProgramNameAttribute]
+	handler.connectionStats.SetTags(application, attributes)
+	handler.censorConnection = acracensor.ConnectionInfo{ClientID: handler.clientID, Application: application}
+	handler.database.Set(database)
+	if application == "" {
+		return logger, true
+	}
+	logger = logger.WithField("application", application)
+	logger.Debugln("Client application identified by connection attributes")
+	return logger, true
+}
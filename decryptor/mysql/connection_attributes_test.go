@@ -0,0 +1,67 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestGetConnectionAttributes(t *testing.T) {
+	capabilities := ClientProtocol41 | ClientSecureConnection | ClientConnectWithDB | ClientPluginAuth | ClientConnectAttrs
+	data := make([]byte, sslRequestLength)
+	binary.LittleEndian.PutUint32(data, uint32(capabilities))
+	data = append(data, "test\x00"...)
+	// auth response with length
+	data = append(data, 3, 1, 2, 3)
+	data = append(data, "acra\x00"...)
+	data = append(data, "mysql_native_password\x00"...)
+	var attributes []byte
+	attributes = append(attributes, PutLengthEncodedString([]byte("_client_name"))...)
+	attributes = append(attributes, PutLengthEncodedString([]byte("libmysql"))...)
+	attributes = append(attributes, PutLengthEncodedString([]byte(ProgramNameAttribute))...)
+	attributes = append(attributes, PutLengthEncodedString([]byte("reporting-service"))...)
+	data = append(data, PutLengthEncodedInt(uint64(len(attributes)))...)
+	data = append(data, attributes...)
+
+	packet := NewMysqlPacket()
+	packet.SetData(data)
+	tags, err := packet.GetConnectionAttributes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"_client_name": "libmysql", ProgramNameAttribute: "reporting-service", UserTag: "test", DatabaseTag: "acra"}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Fatalf("Incorrect attributes %v", tags)
+	}
+
+	if _, database, err := packet.parseHandshakeResponse(); err != nil || database != "acra" {
+		t.Fatalf("Incorrect database %q, %v", database, err)
+	}
+
+	// SSLRequest doesn't contain attributes
+	packet.SetData(data[:sslRequestLength])
+	if tags, err := packet.GetConnectionAttributes(); tags != nil || err != nil {
+		t.Fatalf("Expected no attributes of SSLRequest, took %v, %v", tags, err)
+	}
+	// attributes truncated
+	packet.SetData(data[:len(data)-1])
+	if _, err := packet.GetConnectionAttributes(); err != ErrMalformPacket {
+		t.Fatalf("Expected ErrMalformPacket, took %v", err)
+	}
+}
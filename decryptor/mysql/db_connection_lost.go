@@ -0,0 +1,67 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"sync/atomic"
+
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/network"
+)
+
+// markClientPacket remembers sequence number of packet received from client
+func (handler *MysqlHandler) markClientPacket(sequence byte) {
+	count := atomic.LoadUint64(&handler.clientPacketMarker) >> 8
+	atomic.StoreUint64(&handler.clientPacketMarker, (count+1)<<8|uint64(sequence))
+}
+
+// markResponse remembers sequence number of response forwarded to client
+func (handler *MysqlHandler) markResponse(sequence byte) {
+	handler.responseMarker = atomic.LoadUint64(&handler.clientPacketMarker)
+	handler.responseSequence = sequence
+	handler.responseSent = true
+}
+
+// lostConnectionErrorSequence returns sequence number which client expects in next packet: the next one after its last
+// packet if it wasn't answered yet or after last forwarded response otherwise
+func (handler *MysqlHandler) lostConnectionErrorSequence() byte {
+	marker := atomic.LoadUint64(&handler.clientPacketMarker)
+	if marker != handler.responseMarker {
+		return byte(marker) + 1
+	}
+	if !handler.responseSent {
+		// database closed connection before handshake
+		return 0
+	}
+	return handler.responseSequence + 1
+}
+
+// notifyDBConnectionLost sends ERR packet to client if database closed connection, so client gets error of protocol
+// instead of abruptly closed connection and may retry on other connection
+func (handler *MysqlHandler) notifyDBConnectionLost(err error) {
+	if !network.IsConnectionLost(err) {
+		return
+	}
+	handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorDBConnectionLost).
+		Warningln("Database closed connection, send error to client")
+	packet := NewMysqlPacket()
+	packet.SetSequenceNumber(handler.lostConnectionErrorSequence())
+	packet.SetData(NewServerLostError(handler.clientProtocol41))
+	if _, err := handler.clientConnection.Write(packet.Dump()); err != nil {
+		handler.logger.WithError(err).Debugln("Can't send error about lost connection to client")
+	}
+}
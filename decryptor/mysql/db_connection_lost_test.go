@@ -0,0 +1,87 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestLostConnectionErrorSequence(t *testing.T) {
+	handler := &MysqlHandler{}
+	if sequence := handler.lostConnectionErrorSequence(); sequence != 0 {
+		t.Fatalf("Expected 0 before handshake, took %v", sequence)
+	}
+	// handshake from database
+	handler.markResponse(0)
+	// handshake response from client waits for OK
+	handler.markClientPacket(1)
+	if sequence := handler.lostConnectionErrorSequence(); sequence != 2 {
+		t.Fatalf("Expected 2 after client's packet, took %v", sequence)
+	}
+	handler.markResponse(2)
+	// new command
+	handler.markClientPacket(0)
+	if sequence := handler.lostConnectionErrorSequence(); sequence != 1 {
+		t.Fatalf("Expected 1 after new command, took %v", sequence)
+	}
+	// part of result forwarded
+	handler.markResponse(3)
+	if sequence := handler.lostConnectionErrorSequence(); sequence != 4 {
+		t.Fatalf("Expected 4 after forwarded response, took %v", sequence)
+	}
+}
+
+func TestNotifyDBConnectionLost(t *testing.T) {
+	client, proxy := net.Pipe()
+	defer client.Close()
+	handler := &MysqlHandler{clientConnection: proxy, clientProtocol41: true, logger: logrus.NewEntry(logrus.StandardLogger())}
+	handler.markClientPacket(0)
+	done := make(chan struct{})
+	go func() {
+		handler.notifyDBConnectionLost(io.EOF)
+		proxy.Close()
+		close(done)
+	}()
+	packet, err := ReadPacket(client)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !packet.IsErr() || packet.GetSequenceNumber() != 1 {
+		t.Fatalf("Expected ERR packet with sequence number 1, took %v", packet.Dump())
+	}
+	if !bytes.Equal(packet.GetData(), NewServerLostError(true)) {
+		t.Fatal("Unexpected error")
+	}
+
+	// connection closed by AcraServer isn't lost
+	client, proxy = net.Pipe()
+	defer client.Close()
+	handler.clientConnection = proxy
+	go func() {
+		handler.notifyDBConnectionLost(io.ErrClosedPipe)
+		proxy.Close()
+	}()
+	if _, err := ReadPacket(client); err == nil {
+		t.Fatal("Expected closed connection without error packet")
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+)
+
+type testReadAheadDecryptor struct {
+	base.Decryptor
+}
+
+func (testReadAheadDecryptor) IsWholeMatch() bool {
+	return true
+}
+
+func TestDBReadAhead(t *testing.T) {
+	db, dbSide := net.Pipe()
+	client, clientSide := net.Pipe()
+	defer db.Close()
+	defer client.Close()
+	handler, err := NewMysqlHandler(nil, testReadAheadDecryptor{}, dbSide, clientSide, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.SetDBReadPipelineSize(2)
+	errCh := make(chan error, 1)
+	go handler.DbToClientConnector(errCh)
+
+	greeting := newTestPacket(0, newServerHandshake("5.7.0", ClientProtocol41, 0))
+	ok := newTestPacket(2, []byte{OkPacket, 0, 0, 2, 0, 0, 0})
+	for _, packet := range []*MysqlPacket{greeting, ok} {
+		go db.Write(packet.Dump())
+		if _, err := ReadPacket(client); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// after connection phase responses are read from database while client doesn't read previous ones
+	var responses []*MysqlPacket
+	written := make(chan struct{})
+	go func() {
+		for i := byte(1); i <= 3; i++ {
+			response := newTestPacket(i, []byte{OkPacket, 0, 0, 2, 0, 0, i})
+			responses = append(responses, response)
+			db.Write(response.Dump())
+		}
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Responses weren't read ahead from database")
+	}
+	for _, response := range responses {
+		packet, err := ReadPacket(client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(packet.Dump(), response.Dump()) {
+			t.Fatalf("Expected %v, took %v", response.Dump(), packet.Dump())
+		}
+	}
+
+	// closed database connection stops proxy which notifies client about lost connection
+	go ioutil.ReadAll(client)
+	db.Close()
+	select {
+	case <-errCh:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Proxy wasn't stopped")
+	}
+}
+
+func TestDBReadAheadTurnedOff(t *testing.T) {
+	_, dbSide := net.Pipe()
+	handler, err := NewMysqlHandler(nil, testReadAheadDecryptor{}, dbSide, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.startDBReadAhead()
+	if handler.dbReadConnection() != dbSide {
+		t.Fatal("Expected database connection without reading ahead")
+	}
+	handler.SetDBReadPipelineSize(1)
+	handler.startDBReadAhead()
+	defer handler.dbReadConnection().Close()
+	if handler.dbReadConnection() == dbSide {
+		t.Fatal("Expected reading ahead")
+	}
+}
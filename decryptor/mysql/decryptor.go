@@ -19,6 +19,7 @@ package mysql
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"io/ioutil"
 
@@ -43,6 +44,8 @@ type MySQLDecryptor struct {
 	decryptFunc     decryptFunc
 	log             *log.Entry
 	clientID        []byte
+	// ctx of connection, decryptions are stopped when it's done
+	ctx context.Context
 }
 
 // Possible decryption modes: AcraStruct can start from beginning of cell, or be part of the cell
@@ -53,7 +56,7 @@ const (
 
 // NewMySQLDecryptor returns MySQLDecryptor with turned on poison record detection
 func NewMySQLDecryptor(clientID []byte, pgDecryptor *postgresql.PgDecryptor, keyStore keystore.KeyStore) *MySQLDecryptor {
-	decryptor := &MySQLDecryptor{keyStore: keyStore, binaryDecryptor: binary.NewBinaryDecryptor(), Decryptor: pgDecryptor}
+	decryptor := &MySQLDecryptor{keyStore: keyStore, binaryDecryptor: binary.NewBinaryDecryptor(), Decryptor: pgDecryptor, ctx: context.Background()}
 	// because we will use internal value of pgDecryptor then set it `true` as default on initialization
 	pgDecryptor.TurnOnPoisonRecordCheck(true)
 	decryptor.log = log.WithFields(log.Fields{"decryptor": "mysql", "client_id": string(clientID)})
@@ -61,6 +64,12 @@ func NewMySQLDecryptor(clientID []byte, pgDecryptor *postgresql.PgDecryptor, key
 	return decryptor
 }
 
+// SetContext sets context of connection, decryptions which wait for free slot or start after ctx is done fail with
+// error of ctx
+func (decryptor *MySQLDecryptor) SetContext(ctx context.Context) {
+	decryptor.ctx = ctx
+}
+
 // SkipBeginInBlock returns AcraStruct without BeginTag or error if BeginTag not found
 func (decryptor *MySQLDecryptor) SkipBeginInBlock(block []byte) ([]byte, error) {
 	n := 0
@@ -167,7 +176,7 @@ func (decryptor *MySQLDecryptor) checkPoisonRecord(block []byte) error {
 	decryptor.log.Debugln("Check block on poison")
 	_, err = decryptor.decryptBlock(bytes.NewReader(data), nil, decryptor.getPoisonPrivateKey)
 	if err == nil {
-		decryptor.log.Warningln("Recognized poison record")
+		decryptor.log.WithField(logging.FieldKeyEventCode, logging.EventCodePoisonRecordDetected).Warningln("Recognized poison record")
 		if decryptor.GetPoisonCallbackStorage().HasCallbacks() {
 			decryptor.log.Debugln("Check poison records")
 			if err := decryptor.GetPoisonCallbackStorage().Call(); err != nil {
@@ -211,14 +220,20 @@ func (decryptor *MySQLDecryptor) inlinePoisonRecordCheck(block []byte) error {
 type getKeyFunc func() (*keys.PrivateKey, error)
 
 // decryptBlock try to process data after BEGIN_TAG, decrypt and return result
-func (decryptor *MySQLDecryptor) decryptBlock(reader io.Reader, id []byte, keyFunc getKeyFunc) ([]byte, error) {
+func (decryptor *MySQLDecryptor) decryptBlock(reader *bytes.Reader, id []byte, keyFunc getKeyFunc) ([]byte, error) {
 	logger := decryptor.log.WithField("zone_id", string(id))
+	limiter := base.GetDecryptionLimiter()
+	if err := limiter.AcquireContext(decryptor.ctx); err != nil {
+		logger.WithError(err).Warningln("Can't decrypt AcraStruct, limit of simultaneous decryptions exceeded")
+		return []byte{}, err
+	}
+	defer limiter.Release()
 	privateKey, err := keyFunc()
 	if err != nil {
 		logger.Warningln("Can't read private key")
 		return []byte{}, err
 	}
-	key, _, err := decryptor.ReadSymmetricKey(privateKey, reader)
+	key, err := base.ReadSymmetricKeyRotated(decryptor, privateKey, reader)
 	if err != nil {
 		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorDecryptorCantDecryptSymmetricKey).Warningln("Can't unwrap symmetric key")
 		return []byte{}, err
@@ -314,7 +329,12 @@ func (decryptor *MySQLDecryptor) decryptInlineBlock(block []byte) ([]byte, error
 	return output.Bytes(), nil
 }
 
-// DecryptBlock calls decrypt function on binary block
+// DecryptBlock calls decrypt function on binary block. Block returned as is while decryption is suspended after
+// detection of poison record
 func (decryptor *MySQLDecryptor) DecryptBlock(block []byte) ([]byte, error) {
+	if base.GetPoisonContainment().IsActive() {
+		decryptor.log.Debugln("Decryption suspended after detection of poison record, leave data as is")
+		return block, nil
+	}
 	return decryptor.decryptFunc(block)
 }
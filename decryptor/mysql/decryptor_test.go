@@ -40,6 +40,15 @@ func (keystore *testKeystore) HasZonePrivateKey(id []byte) bool {
 func (keystore *testKeystore) GetServerDecryptionPrivateKey(id []byte) (*keys.PrivateKey, error) {
 	return nil, nil
 }
+func (keystore *testKeystore) GetZonePrivateKeys(id []byte) ([]*keys.PrivateKey, error) {
+	return nil, nil
+}
+func (keystore *testKeystore) GetServerDecryptionPrivateKeys(id []byte) ([]*keys.PrivateKey, error) {
+	return nil, nil
+}
+func (keystore *testKeystore) RotateStorageKeys(id []byte) ([]byte, error) {
+	return nil, nil
+}
 func (keystore *testKeystore) GenerateZoneKey() ([]byte, []byte, error) {
 	return nil, nil, nil
 }
@@ -58,6 +67,12 @@ func (keystore *testKeystore) GenerateDataEncryptionKeys(id []byte) error {
 func (keystore *testKeystore) GetAuthKey(remove bool) ([]byte, error) {
 	return nil, nil
 }
+func (keystore *testKeystore) GenerateHMACSecretKey(id []byte) error {
+	return nil
+}
+func (keystore *testKeystore) GetHMACSecretKey(id []byte) ([]byte, error) {
+	return nil, nil
+}
 func (keystore *testKeystore) Reset() {}
 
 func getDecryptor(keystore keystore.KeyStore) *MySQLDecryptor {
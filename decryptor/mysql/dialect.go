@@ -0,0 +1,102 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Dialects of servers which speak MySQL protocol, detected by initial handshake. Percona Server uses protocol of MySQL
+// without changes and is detected as MySQL
+const (
+	DialectMySQL   = "mysql"
+	DialectMariaDB = "mariadb"
+)
+
+// ClientMySQL is CLIENT_LONG_PASSWORD flag which MariaDB 10.2+ clears to mark that MariaDB capabilities are sent in
+// reserved bytes of handshake packets
+// https://mariadb.com/kb/en/connection/#capabilities
+const ClientMySQL = 0x00000001
+
+// mariaDBVersionPrefix is added by MariaDB to server version of initial handshake for compatibility with MySQL clients
+const mariaDBVersionPrefix = "5.5.5-"
+
+// Offsets of MariaDB capabilities in reserved bytes of handshake packets
+// https://mariadb.com/kb/en/connection/#initial-handshake-packet
+const (
+	// 2 bytes of upper capabilities + 1 byte length of auth plugin data + 6 bytes filler
+	mariaDBServerCapabilitiesOffset = 2 + 1 + 6
+	// 4 bytes capabilities + 4 bytes max packet size + 1 byte character set + 19 bytes filler
+	mariaDBClientCapabilitiesOffset = 4 + 4 + 1 + 19
+	mariaDBCapabilitiesLength       = 4
+)
+
+// ServerVersion describes database server from initial handshake
+type ServerVersion struct {
+	Dialect string
+	Version string
+}
+
+// ParseServerVersion returns dialect and version of server from version string of initial handshake
+func ParseServerVersion(version string) ServerVersion {
+	if strings.Contains(strings.ToLower(version), DialectMariaDB) {
+		return ServerVersion{Dialect: DialectMariaDB, Version: strings.TrimPrefix(version, mariaDBVersionPrefix)}
+	}
+	return ServerVersion{Dialect: DialectMySQL, Version: version}
+}
+
+// getServerVersion returns version string of server from initial handshake
+func (packet *MysqlPacket) getServerVersion() string {
+	if len(packet.data) == 0 || packet.data[0] != protocolVersion10 {
+		return ""
+	}
+	end := bytes.IndexByte(packet.data[1:], 0)
+	if end < 0 {
+		return ""
+	}
+	return string(packet.data[1 : end+1])
+}
+
+// stripMariaDBServerCapabilities removes MariaDB capabilities from initial handshake. All of them change format of
+// packets which AcraServer processes (progress reports as error packets, COM_MULTI, bulk statements, extended and
+// cached metadata of columns), so clients shouldn't request them
+func (packet *MysqlPacket) stripMariaDBServerCapabilities() {
+	if len(packet.data) == 0 || packet.data[0] != protocolVersion10 {
+		return
+	}
+	endOfServerVersion := bytes.Index(packet.data[1:], []byte{0}) + 2
+	if endOfServerVersion < 2 {
+		return
+	}
+	// same offsets of capabilities as in stripServerCapabilities
+	baseCapabilitiesOffset := endOfServerVersion + 13
+	offset := baseCapabilitiesOffset + 2 + 3 + mariaDBServerCapabilitiesOffset
+	if len(packet.data) < offset+mariaDBCapabilitiesLength || packet.data[baseCapabilitiesOffset]&ClientMySQL != 0 {
+		return
+	}
+	copy(packet.data[offset:offset+mariaDBCapabilitiesLength], make([]byte, mariaDBCapabilitiesLength))
+}
+
+// stripMariaDBClientCapabilities removes MariaDB capabilities from handshake response or SSL request of client which
+// talks with MariaDB, in case client requests them without server's offer
+func (packet *MysqlPacket) stripMariaDBClientCapabilities() {
+	if len(packet.data) < mariaDBClientCapabilitiesOffset+mariaDBCapabilitiesLength || packet.getClientCapabilities()&ClientMySQL != 0 {
+		return
+	}
+	copy(packet.data[mariaDBClientCapabilitiesOffset:mariaDBClientCapabilitiesOffset+mariaDBCapabilitiesLength], make([]byte, mariaDBCapabilitiesLength))
+}
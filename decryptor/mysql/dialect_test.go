@@ -0,0 +1,102 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseServerVersion(t *testing.T) {
+	testCases := []struct {
+		version  string
+		expected ServerVersion
+	}{
+		{"5.7.31-log", ServerVersion{DialectMySQL, "5.7.31-log"}},
+		{"8.0.32-24", ServerVersion{DialectMySQL, "8.0.32-24"}},
+		{"5.5.5-10.6.12-MariaDB-1:10.6.12+maria~ubu2004", ServerVersion{DialectMariaDB, "10.6.12-MariaDB-1:10.6.12+maria~ubu2004"}},
+		{"10.1.48-MariaDB", ServerVersion{DialectMariaDB, "10.1.48-MariaDB"}},
+	}
+	for _, testCase := range testCases {
+		if version := ParseServerVersion(testCase.version); version != testCase.expected {
+			t.Fatalf("Expected %v for %s, took %v", testCase.expected, testCase.version, version)
+		}
+	}
+}
+
+func newServerHandshake(version string, capabilities uint32, mariaDBCapabilities uint32) []byte {
+	data := []byte{protocolVersion10}
+	data = append(data, version...)
+	data = append(data, 0)
+	// connection id + auth plugin data part 1 + filler
+	data = append(data, make([]byte, 4+8+1)...)
+	data = appendUint16(data, uint16(capabilities))
+	// character set + status flags
+	data = append(data, 8, 2, 0)
+	data = appendUint16(data, uint16(capabilities>>16))
+	// length of auth plugin data + filler
+	data = append(data, 21)
+	data = append(data, make([]byte, 6)...)
+	data = append(data, make([]byte, 4)...)
+	binary.LittleEndian.PutUint32(data[len(data)-4:], mariaDBCapabilities)
+	// auth plugin data part 2
+	return append(data, make([]byte, 13)...)
+}
+
+func TestStripMariaDBServerCapabilities(t *testing.T) {
+	packet := NewMysqlPacket()
+	packet.SetData(newServerHandshake("5.5.5-10.6.12-MariaDB", ClientProtocol41, 0x1f))
+	if version := packet.getServerVersion(); version != "5.5.5-10.6.12-MariaDB" {
+		t.Fatalf("Unexpected server version %s", version)
+	}
+	packet.stripMariaDBServerCapabilities()
+	if !bytes.Equal(packet.GetData(), newServerHandshake("5.5.5-10.6.12-MariaDB", ClientProtocol41, 0)) {
+		t.Fatal("MariaDB capabilities weren't removed")
+	}
+
+	// reserved bytes of MySQL handshake aren't changed
+	mysqlHandshake := newServerHandshake("8.0.32", ClientProtocol41|ClientMySQL, 0x1f)
+	packet.SetData(append([]byte{}, mysqlHandshake...))
+	packet.stripMariaDBServerCapabilities()
+	if !bytes.Equal(packet.GetData(), mysqlHandshake) {
+		t.Fatal("Handshake of MySQL was changed")
+	}
+	// truncated handshake is left as is
+	packet.SetData([]byte{protocolVersion10, '5', 0, 1})
+	packet.stripMariaDBServerCapabilities()
+}
+
+func TestStripMariaDBClientCapabilities(t *testing.T) {
+	data := make([]byte, 32)
+	binary.LittleEndian.PutUint32(data, ClientProtocol41)
+	binary.LittleEndian.PutUint32(data[28:], 0x1f)
+	packet := NewMysqlPacket()
+	packet.SetData(data)
+	packet.stripClientCapabilities()
+	if mariaDBCapabilities := binary.LittleEndian.Uint32(packet.GetData()[28:]); mariaDBCapabilities != 0 {
+		t.Fatalf("MariaDB capabilities weren't removed: %x", mariaDBCapabilities)
+	}
+
+	binary.LittleEndian.PutUint32(data, ClientProtocol41|ClientMySQL)
+	binary.LittleEndian.PutUint32(data[28:], 0x1f)
+	packet.SetData(data)
+	packet.stripClientCapabilities()
+	if mariaDBCapabilities := binary.LittleEndian.Uint32(packet.GetData()[28:]); mariaDBCapabilities != 0x1f {
+		t.Fatal("Reserved bytes of MySQL client were changed")
+	}
+}
@@ -38,10 +38,46 @@ func newQueryInterruptedError() *SQLError {
 	return e
 }
 
+// Codes of errors sent to client if connection to database failed, same as reported by MySQL clients
+const (
+	// https://dev.mysql.com/doc/refman/5.5/en/error-messages-client.html#error_cr_server_lost
+	CR_SERVER_LOST_CODE = 2013
+	// https://dev.mysql.com/doc/refman/5.5/en/error-messages-client.html#error_cr_conn_host_error
+	CR_CONN_HOST_ERROR_CODE = 2003
+	// general error
+	CR_GENERAL_STATE = "HY000"
+)
+
 // NewQueryInterruptedError return packed QueryInterrupted error
 // https://dev.mysql.com/doc/internals/en/packet-ERR_Packet.html
 func NewQueryInterruptedError(isProtocol41 bool) []byte {
-	mysqlError := newQueryInterruptedError()
+	return newErrPacket(newQueryInterruptedError(), isProtocol41)
+}
+
+// NewServerLostError returns packed error about connection to database lost during query
+func NewServerLostError(isProtocol41 bool) []byte {
+	return newErrPacket(&SQLError{Code: CR_SERVER_LOST_CODE, State: CR_GENERAL_STATE, Message: "Lost connection to MySQL server during query"}, isProtocol41)
+}
+
+// NewCantConnectError returns packed error about failed connection to database
+func NewCantConnectError(isProtocol41 bool) []byte {
+	return newErrPacket(&SQLError{Code: CR_CONN_HOST_ERROR_CODE, State: CR_GENERAL_STATE, Message: "Can't connect to MySQL server"}, isProtocol41)
+}
+
+// Code of error sent to client if LOAD DATA LOCAL INFILE is denied, same as sent by MySQL with turned off local_infile
+const (
+	// https://dev.mysql.com/doc/refman/5.7/en/server-error-reference.html#error_er_not_allowed_command
+	ER_NOT_ALLOWED_COMMAND_CODE  = 1148
+	ER_NOT_ALLOWED_COMMAND_STATE = "42000"
+)
+
+// NewLocalInfileDeniedError returns packed error about denied upload of local file
+func NewLocalInfileDeniedError(isProtocol41 bool, message string) []byte {
+	return newErrPacket(&SQLError{Code: ER_NOT_ALLOWED_COMMAND_CODE, State: ER_NOT_ALLOWED_COMMAND_STATE, Message: message}, isProtocol41)
+}
+
+// newErrPacket returns payload of ERR packet with mysqlError
+func newErrPacket(mysqlError *SQLError, isProtocol41 bool) []byte {
 	var data []byte
 	if isProtocol41 {
 		// 1 byte ErrPacket flag + 2 bytes of error code = 3
@@ -0,0 +1,105 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"fmt"
+)
+
+// auditPayloadLength checks that rewritten payload fits into one packet because Dump writes one header for whole data
+func auditPayloadLength(data []byte) error {
+	if len(data) >= MaxPayloadLen {
+		return fmt.Errorf("payload length %d doesn't fit into one packet", len(data))
+	}
+	return nil
+}
+
+// auditTextRowLengths checks that rewritten row of text resultset consists of exactly fieldCount length encoded values
+// https://dev.mysql.com/doc/internals/en/com-query-response.html#packet-ProtocolText::ResultsetRow
+func auditTextRowLengths(data []byte, fieldCount int) error {
+	if err := auditPayloadLength(data); err != nil {
+		return err
+	}
+	pos := 0
+	for i := 0; i < fieldCount; i++ {
+		if pos >= len(data) {
+			return fmt.Errorf("value of field %d is out of packet", i)
+		}
+		n, err := SkipLengthEncodedString(data[pos:])
+		if err != nil {
+			return fmt.Errorf("can't read value of field %d at offset %d of %d bytes", i, pos, len(data))
+		}
+		pos += n
+	}
+	if pos != len(data) {
+		return fmt.Errorf("%d bytes left in packet after %d fields", len(data)-pos, fieldCount)
+	}
+	return nil
+}
+
+// auditBinaryRowLengths checks that rewritten row of binary resultset consists of values of fields with their types
+// https://dev.mysql.com/doc/internals/en/binary-protocol-resultset-row.html
+func auditBinaryRowLengths(data []byte, fields []*ColumnDescription) error {
+	if err := auditPayloadLength(data); err != nil {
+		return err
+	}
+	pos := 1 + ((len(fields) + 7 + 2) >> 3)
+	if len(data) < pos {
+		return fmt.Errorf("packet length %d is less than size of NULL bitmap", len(data))
+	}
+	nullBitmap := data[1:pos]
+	for i, field := range fields {
+		if nullBitmap[(i+2)/8]&(1<<(uint(i+2)%8)) > 0 {
+			continue
+		}
+		size := 0
+		switch field.Type {
+		case MYSQL_TYPE_NULL:
+		case MYSQL_TYPE_TINY:
+			size = 1
+		case MYSQL_TYPE_SHORT, MYSQL_TYPE_YEAR:
+			size = 2
+		case MYSQL_TYPE_INT24, MYSQL_TYPE_LONG, MYSQL_TYPE_FLOAT:
+			size = 4
+		case MYSQL_TYPE_LONGLONG, MYSQL_TYPE_DOUBLE:
+			size = 8
+		case MYSQL_TYPE_DATE, MYSQL_TYPE_NEWDATE, MYSQL_TYPE_TIMESTAMP, MYSQL_TYPE_DATETIME, MYSQL_TYPE_TIME:
+			// temporal values have length in first byte
+			if pos >= len(data) {
+				return fmt.Errorf("value of field %d is out of packet", i)
+			}
+			size = 1 + int(data[pos])
+		default:
+			if pos >= len(data) {
+				return fmt.Errorf("value of field %d is out of packet", i)
+			}
+			n, err := SkipLengthEncodedString(data[pos:])
+			if err != nil {
+				return fmt.Errorf("can't read value of field %d at offset %d of %d bytes", i, pos, len(data))
+			}
+			size = n
+		}
+		if size > len(data)-pos {
+			return fmt.Errorf("value of field %d has length %d but only %d bytes left in packet", i, size, len(data)-pos)
+		}
+		pos += size
+	}
+	if pos != len(data) {
+		return fmt.Errorf("%d bytes left in packet after %d fields", len(data)-pos, len(fields))
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"testing"
+)
+
+func TestAuditTextRowLengths(t *testing.T) {
+	row := append(PutLengthEncodedString([]byte("value")), 0xfb)
+	row = append(row, PutLengthEncodedString([]byte("1"))...)
+	if err := auditTextRowLengths(row, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := auditTextRowLengths(row, 2); err == nil {
+		t.Error("Expected error on bytes left after fields")
+	}
+	if err := auditTextRowLengths(row[:len(row)-1], 3); err == nil {
+		t.Error("Expected error on truncated value")
+	}
+	if err := auditTextRowLengths(row, 4); err == nil {
+		t.Error("Expected error on missing field")
+	}
+}
+
+func TestAuditBinaryRowLengths(t *testing.T) {
+	fields := []*ColumnDescription{{Type: MYSQL_TYPE_LONG}, {Type: MYSQL_TYPE_BLOB}, {Type: MYSQL_TYPE_DATETIME}, {Type: MYSQL_TYPE_BLOB}}
+	// header, NULL bitmap with last field as NULL
+	row := []byte{OkPacket, 1 << 5}
+	row = append(row, 1, 0, 0, 0)
+	row = append(row, PutLengthEncodedString([]byte("value"))...)
+	row = append(row, 4, 0xe4, 0x07, 1, 1)
+	if err := auditBinaryRowLengths(row, fields); err != nil {
+		t.Fatal(err)
+	}
+	if err := auditBinaryRowLengths(row[:len(row)-1], fields); err == nil {
+		t.Error("Expected error on truncated datetime value")
+	}
+	if err := auditBinaryRowLengths(append(row, 0), fields); err == nil {
+		t.Error("Expected error on bytes left after fields")
+	}
+}
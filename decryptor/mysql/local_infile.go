@@ -0,0 +1,180 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/sirupsen/logrus"
+)
+
+// LocalInfileRequest is first byte of response which requests content of local file for LOAD DATA LOCAL INFILE
+// https://dev.mysql.com/doc/internals/en/com-query-response.html#packet-Protocol::LOCAL_INFILE_Request
+const LocalInfileRequest = 0xfb
+
+// Modes of handling LOAD DATA LOCAL INFILE
+const (
+	// LocalInfileAllow forwards uploaded file to database as is
+	LocalInfileAllow = "allow"
+	// LocalInfileDeny refuses uploads, client receives error and database receives empty file
+	LocalInfileDeny = "deny"
+	// LocalInfileScan allows uploads only into tables without columns encrypted by AcraServer, because their
+	// values would be stored as is, and limits size of uploaded file
+	LocalInfileScan = "scan"
+)
+
+// LocalInfileModes lists supported modes of LOAD DATA LOCAL INFILE handling
+var LocalInfileModes = []string{LocalInfileAllow, LocalInfileDeny, LocalInfileScan}
+
+// Errors of LOAD DATA LOCAL INFILE handling
+var (
+	ErrUnknownLocalInfileMode = acraerrors.New(acraerrors.CodeUnknownLocalInfileMode, "unknown mode of LOAD DATA LOCAL INFILE handling")
+	ErrLocalInfileTooLarge    = acraerrors.New(acraerrors.CodeLocalInfileTooLarge, "uploaded local file exceeded max size")
+	ErrEmptyClientPacket      = acraerrors.New(acraerrors.CodeEmptyClientPacket, "empty packet from client outside of LOCAL INFILE upload")
+)
+
+// ValidateLocalInfileMode returns ErrUnknownLocalInfileMode if mode isn't supported
+func ValidateLocalInfileMode(mode string) error {
+	for _, supported := range LocalInfileModes {
+		if mode == supported {
+			return nil
+		}
+	}
+	return ErrUnknownLocalInfileMode
+}
+
+// EncryptedTables is implemented by configuration which knows tables with columns encrypted by AcraServer
+type EncryptedTables interface {
+	HasEncryptedColumns(table string) bool
+}
+
+// LocalInfilePolicy configures handling of LOAD DATA LOCAL INFILE
+type LocalInfilePolicy struct {
+	Mode string
+	// MaxSize limits size of uploaded file in bytes in scan mode, 0 - without limit
+	MaxSize int64
+	// EncryptedTables used in scan mode to deny uploads into tables with encrypted columns, may be nil
+	EncryptedTables EncryptedTables
+}
+
+// loadDataTableRegexp matches LOAD DATA LOCAL INFILE statement and name of target table
+var loadDataTableRegexp = regexp.MustCompile("(?is)^\\s*load\\s+data\\s+(?:low_priority\\s+|concurrent\\s+)?local\\s+infile\\s+.*?\\s+into\\s+table\\s+(`(?:[^`]|``)+`(?:\\.`(?:[^`]|``)+`)?|[a-z0-9_$.]+)")
+
+// parseLoadDataTable returns name of table without database and quotes which LOAD DATA LOCAL INFILE query fills
+func parseLoadDataTable(query string) (string, bool) {
+	match := loadDataTableRegexp.FindStringSubmatch(query)
+	if match == nil {
+		return "", false
+	}
+	name := match[1]
+	if strings.HasSuffix(name, "`") {
+		// `db`.`table` or `table`
+		if index := strings.LastIndex(name, "`.`"); index >= 0 {
+			name = name[index+2:]
+		}
+		name = strings.Replace(name[1:len(name)-1], "``", "`", -1)
+	} else if index := strings.LastIndex(name, "."); index >= 0 {
+		name = name[index+1:]
+	}
+	return name, true
+}
+
+// checkLocalInfile returns reason why upload requested in response to query is denied by policy or empty string if
+// it's allowed
+func (policy LocalInfilePolicy) checkLocalInfile(query string) string {
+	switch policy.Mode {
+	case LocalInfileAllow:
+		return ""
+	case LocalInfileScan:
+		table, ok := parseLoadDataTable(query)
+		if !ok {
+			return "LOAD DATA LOCAL INFILE denied by AcraServer: can't recognize target table"
+		}
+		if policy.EncryptedTables != nil && policy.EncryptedTables.HasEncryptedColumns(table) {
+			return "LOAD DATA LOCAL INFILE denied by AcraServer: table has encrypted columns"
+		}
+		return ""
+	}
+	return "LOAD DATA LOCAL INFILE denied by AcraServer"
+}
+
+// SetLocalInfilePolicy sets handling of LOAD DATA LOCAL INFILE
+func (handler *MysqlHandler) SetLocalInfilePolicy(policy LocalInfilePolicy) {
+	handler.localInfilePolicy = policy
+}
+
+// isLocalInfileRequest returns true if packet is first packet of response to COM_QUERY which requests local file
+func (handler *MysqlHandler) isLocalInfileRequest(packet *MysqlPacket) bool {
+	data := packet.GetData()
+	return handler.currentCommand == COM_QUERY && len(data) > 0 && data[0] == LocalInfileRequest
+}
+
+// handleLocalInfileRequest forwards request of local file to client if policy allows upload, then client's packets
+// are forwarded as content of file until empty packet. Otherwise sends empty file to database, drops its response
+// and sends error to client
+func (handler *MysqlHandler) handleLocalInfileRequest(packet *MysqlPacket) error {
+	logger := handler.logger.WithField("filename", string(packet.GetData()[1:]))
+	reason := handler.localInfilePolicy.checkLocalInfile(handler.lastQuery)
+	if reason == "" {
+		logger.Debugln("Forward request of local file to client")
+		atomic.StoreInt32(&handler.localInfileUpload, 1)
+		atomic.StoreInt64(&handler.localInfileSize, 0)
+		_, err := handler.clientConnection.Write(packet.Dump())
+		return err
+	}
+	logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorLocalInfileDenied).Warningln(reason)
+	emptyFile := NewMysqlPacket()
+	emptyFile.SetSequenceNumber(packet.GetSequenceNumber() + 1)
+	emptyFile.SetData(nil)
+	if _, err := handler.dbConnection.Write(emptyFile.Dump()); err != nil {
+		return err
+	}
+	if _, err := ReadPacket(handler.dbReadConnection()); err != nil {
+		return err
+	}
+	packet.SetData(NewLocalInfileDeniedError(handler.clientProtocol41, reason))
+	_, err := handler.clientConnection.Write(packet.Dump())
+	return err
+}
+
+// isLocalInfileUpload returns true if client uploads content of local file
+func (handler *MysqlHandler) isLocalInfileUpload() bool {
+	return atomic.LoadInt32(&handler.localInfileUpload) == 1
+}
+
+// handleLocalInfileUpload forwards packet with content of local file to database. Returns ErrLocalInfileTooLarge if
+// upload exceeds size limit, then connection should be closed, so database rolls back statement
+func (handler *MysqlHandler) handleLocalInfileUpload(packet *MysqlPacket, logger *logrus.Entry) error {
+	length := len(packet.GetData())
+	if length == 0 {
+		logger.Debugln("Upload of local file finished")
+		atomic.StoreInt32(&handler.localInfileUpload, 0)
+	}
+	size := atomic.AddInt64(&handler.localInfileSize, int64(length))
+	policy := handler.localInfilePolicy
+	if policy.Mode == LocalInfileScan && policy.MaxSize > 0 && size > policy.MaxSize {
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorLocalInfileDenied).
+			Warningln("LOAD DATA LOCAL INFILE exceeded max size, connection closed")
+		return ErrLocalInfileTooLarge
+	}
+	_, err := handler.dbConnection.Write(packet.Dump())
+	return err
+}
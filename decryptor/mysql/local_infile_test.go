@@ -0,0 +1,132 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"net"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+type testEncryptedTables map[string]bool
+
+func (tables testEncryptedTables) HasEncryptedColumns(table string) bool {
+	return tables[table]
+}
+
+func TestParseLoadDataTable(t *testing.T) {
+	testcases := []struct {
+		query string
+		table string
+		ok    bool
+	}{
+		{"LOAD DATA LOCAL INFILE '/tmp/users.csv' INTO TABLE users", "users", true},
+		{"load data low_priority local infile 'a b.csv' replace into table shop.orders fields terminated by ','", "orders", true},
+		{"LOAD DATA LOCAL INFILE 'x' IGNORE INTO TABLE `shop`.`order``s` (id)", "order`s", true},
+		{"LOAD DATA INFILE '/tmp/users.csv' INTO TABLE users", "", false},
+		{"SELECT * FROM users", "", false},
+	}
+	for _, testcase := range testcases {
+		table, ok := parseLoadDataTable(testcase.query)
+		if table != testcase.table || ok != testcase.ok {
+			t.Fatalf("Incorrect result for %q: %q, %v", testcase.query, table, ok)
+		}
+	}
+}
+
+func TestCheckLocalInfile(t *testing.T) {
+	query := "LOAD DATA LOCAL INFILE 'users.csv' INTO TABLE users"
+	if reason := (LocalInfilePolicy{}).checkLocalInfile(query); reason == "" {
+		t.Fatal("Uploads should be denied without policy")
+	}
+	if reason := (LocalInfilePolicy{Mode: LocalInfileAllow}).checkLocalInfile(query); reason != "" {
+		t.Fatalf("Expected allowed upload, took %v", reason)
+	}
+	scan := LocalInfilePolicy{Mode: LocalInfileScan, EncryptedTables: testEncryptedTables{"users": true}}
+	if reason := scan.checkLocalInfile(query); reason == "" {
+		t.Fatal("Upload into table with encrypted columns should be denied")
+	}
+	if reason := scan.checkLocalInfile("LOAD DATA LOCAL INFILE 'logs.csv' INTO TABLE logs"); reason != "" {
+		t.Fatalf("Expected allowed upload, took %v", reason)
+	}
+	if err := ValidateLocalInfileMode("unknown"); err != ErrUnknownLocalInfileMode {
+		t.Fatalf("Expected ErrUnknownLocalInfileMode, took %v", err)
+	}
+}
+
+func TestDenyLocalInfileRequest(t *testing.T) {
+	client, clientProxy := net.Pipe()
+	defer client.Close()
+	db, dbProxy := net.Pipe()
+	defer db.Close()
+	handler := &MysqlHandler{clientConnection: clientProxy, dbConnection: dbProxy, clientProtocol41: true, currentCommand: COM_QUERY,
+		lastQuery: "LOAD DATA LOCAL INFILE 'users.csv' INTO TABLE users", logger: logrus.NewEntry(logrus.StandardLogger())}
+	request := NewMysqlPacket()
+	request.SetSequenceNumber(1)
+	request.SetData(append([]byte{LocalInfileRequest}, "users.csv"...))
+	if !handler.isLocalInfileRequest(request) {
+		t.Fatal("Expected request of local file")
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.handleLocalInfileRequest(request)
+	}()
+	// database receives empty file and responds
+	emptyFile, err := readPacketAllowEmpty(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(emptyFile.GetData()) != 0 || emptyFile.GetSequenceNumber() != 2 {
+		t.Fatalf("Expected empty packet with sequence number 2, took %v", emptyFile.Dump())
+	}
+	ok := NewMysqlPacket()
+	ok.SetSequenceNumber(3)
+	ok.SetData([]byte{OkPacket, 0, 0, 2, 0, 0, 0})
+	if _, err := db.Write(ok.Dump()); err != nil {
+		t.Fatal(err)
+	}
+	response, err := ReadPacket(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !response.IsErr() || response.GetSequenceNumber() != 1 {
+		t.Fatalf("Expected ERR packet with sequence number 1, took %v", response.Dump())
+	}
+	if handler.isLocalInfileUpload() {
+		t.Fatal("Upload shouldn't be expected after denied request")
+	}
+}
+
+func TestLocalInfileUploadLimit(t *testing.T) {
+	db, dbProxy := net.Pipe()
+	defer db.Close()
+	handler := &MysqlHandler{dbConnection: dbProxy, localInfileUpload: 1, localInfilePolicy: LocalInfilePolicy{Mode: LocalInfileScan, MaxSize: 5}}
+	logger := logrus.NewEntry(logrus.StandardLogger())
+	packet := NewMysqlPacket()
+	packet.SetData([]byte("1,2\n"))
+	go ReadPacket(db)
+	if err := handler.handleLocalInfileUpload(packet, logger); err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.handleLocalInfileUpload(packet, logger); err != ErrLocalInfileTooLarge {
+		t.Fatalf("Expected ErrLocalInfileTooLarge, took %v", err)
+	}
+}
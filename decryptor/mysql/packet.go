@@ -19,10 +19,11 @@ package mysql
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"io"
 	"net"
+
+	"github.com/cossacklabs/acra/acraerrors"
 )
 
 // MySQL protocol capability flags https://dev.mysql.com/doc/internals/en/capability-flags.html
@@ -52,7 +53,7 @@ const (
 )
 
 // ErrPacketHasNotExtendedCapabilities if packet has capability flags
-var ErrPacketHasNotExtendedCapabilities = errors.New("packet hasn't extended capabilities")
+var ErrPacketHasNotExtendedCapabilities = acraerrors.New(acraerrors.CodePacketHasNotExtendedCapabilities, "packet hasn't extended capabilities")
 
 // Dumper dumps :)
 type Dumper interface {
@@ -92,6 +93,11 @@ func (packet *MysqlPacket) GetSequenceNumber() byte {
 	return packet.header[SequenceIdIndex]
 }
 
+// SetSequenceNumber replaces sequence number in header
+func (packet *MysqlPacket) SetSequenceNumber(number byte) {
+	packet.header[SequenceIdIndex] = number
+}
+
 // GetData returns packet payload
 func (packet *MysqlPacket) GetData() []byte {
 	return packet.data
@@ -108,13 +114,17 @@ func (packet *MysqlPacket) SetData(newData []byte) {
 	packet.header[2] = byte(newSize >> 16)
 }
 
-// readPacket read header to struct and return payload as return result or error
-func (packet *MysqlPacket) readPacket(connection net.Conn) ([]byte, error) {
+// readPacket read header to struct and return payload as return result or error. Empty payload is allowed only
+// if allowEmpty is true (terminating packet of LOCAL INFILE upload)
+func (packet *MysqlPacket) readPacket(connection net.Conn, allowEmpty bool) ([]byte, error) {
 	if _, err := connection.Read(packet.header); err != nil {
 		return nil, err
 	}
 
 	length := packet.GetPacketPayloadLength()
+	if length == 0 && allowEmpty {
+		return []byte{}, nil
+	}
 	if length < 1 {
 		return nil, fmt.Errorf("invalid payload length %d", length)
 	}
@@ -128,7 +138,7 @@ func (packet *MysqlPacket) readPacket(connection net.Conn) ([]byte, error) {
 	}
 
 	var buf []byte
-	buf, err := packet.readPacket(connection)
+	buf, err := packet.readPacket(connection, allowEmpty)
 	if err != nil {
 		return nil, err
 	}
@@ -142,7 +152,7 @@ func (packet *MysqlPacket) Dump() []byte {
 
 // ReadPacket header and payload from connection or return error
 func (packet *MysqlPacket) ReadPacket(connection net.Conn) error {
-	data, err := packet.readPacket(connection)
+	data, err := packet.readPacket(connection, false)
 	if err == nil {
 		packet.data = data
 	}
@@ -215,6 +225,17 @@ func (packet *MysqlPacket) IsClientDeprecateEOF() bool {
 	return (capabilities & ClientDeprecateEof) > 0
 }
 
+// GetClientCollation returns collation of connection requested by client in handshake response or 0 if client doesn't
+// support protocol 4.1
+// https://dev.mysql.com/doc/internals/en/connection-phase-packets.html#packet-Protocol::HandshakeResponse41
+func (packet *MysqlPacket) GetClientCollation() uint16 {
+	// 4 bytes of capabilities + 4 bytes of max packet size + 1 byte of character set
+	if len(packet.data) < 9 || !packet.ClientSupportProtocol41() {
+		return 0
+	}
+	return uint16(packet.data[8])
+}
+
 // ReadPacket from connection and return MysqlPacket struct with data or error
 func ReadPacket(connection net.Conn) (*MysqlPacket, error) {
 	packet := NewMysqlPacket()
@@ -224,3 +245,14 @@ func ReadPacket(connection net.Conn) (*MysqlPacket, error) {
 	}
 	return packet, nil
 }
+
+// readPacketAllowEmpty from connection like ReadPacket but accept packets with empty payload
+func readPacketAllowEmpty(connection net.Conn) (*MysqlPacket, error) {
+	packet := NewMysqlPacket()
+	data, err := packet.readPacket(connection, true)
+	if err != nil {
+		return nil, err
+	}
+	packet.data = data
+	return packet, nil
+}
@@ -0,0 +1,274 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// cursorTypeReadOnly is flag of COM_STMT_EXECUTE which opens cursor, rows of its result are read with COM_STMT_FETCH
+	// https://dev.mysql.com/doc/internals/en/com-stmt-execute.html
+	cursorTypeReadOnly = 0x01
+	// serverStatusCursorExists is status flag of EOF packet which follows column definitions of result with opened cursor
+	// https://dev.mysql.com/doc/internals/en/status-flags.html
+	serverStatusCursorExists = 0x0040
+	// prepareOKLength is length of COM_STMT_PREPARE_OK packet
+	// https://dev.mysql.com/doc/internals/en/com-stmt-prepare-response.html
+	prepareOKLength = 12
+)
+
+// preparedStatement is statement prepared by client. COM_STMT_EXECUTE contains only id of statement, so text of query
+// saved on preparation is used to apply passthrough tables and query directives to results
+type preparedStatement struct {
+	query      string
+	directives *base.QueryDirectives
+	// cursor is true if last execution requested cursor
+	cursor bool
+	// columns of result of last execution with opened cursor, used to decrypt rows read with COM_STMT_FETCH
+	columns []*ColumnDescription
+}
+
+// preparedStatements tracks statements prepared on connection by their ids. Statements are registered on responses
+// which are read in other goroutine, so it's safe for concurrent use
+type preparedStatements struct {
+	lock       sync.Mutex
+	statements map[uint32]*preparedStatement
+	// pending is query of last COM_STMT_PREPARE which waits for response with id of statement
+	pending string
+}
+
+// Prepare saves query of COM_STMT_PREPARE until response with id of statement is received
+func (statements *preparedStatements) Prepare(query string) {
+	statements.lock.Lock()
+	statements.pending = query
+	statements.lock.Unlock()
+}
+
+// OnPrepared registers statement of pending query with id returned by database
+func (statements *preparedStatements) OnPrepared(id uint32) {
+	statements.lock.Lock()
+	defer statements.lock.Unlock()
+	if statements.statements == nil {
+		statements.statements = make(map[uint32]*preparedStatement)
+	}
+	statements.statements[id] = &preparedStatement{query: statements.pending}
+	statements.pending = ""
+}
+
+// Get returns statement with id or nil if it's unknown
+func (statements *preparedStatements) Get(id uint32) *preparedStatement {
+	statements.lock.Lock()
+	defer statements.lock.Unlock()
+	return statements.statements[id]
+}
+
+// Close forgets statement with id deallocated by client
+func (statements *preparedStatements) Close(id uint32) {
+	statements.lock.Lock()
+	delete(statements.statements, id)
+	statements.lock.Unlock()
+}
+
+// Reset forgets all statements, database deallocates them when session is reset
+func (statements *preparedStatements) Reset() {
+	statements.lock.Lock()
+	statements.statements, statements.pending = nil, ""
+	statements.lock.Unlock()
+}
+
+// parseStatementID returns id of statement which is first field of COM_STMT_EXECUTE, COM_STMT_FETCH and COM_STMT_CLOSE
+// payloads after command byte
+func parseStatementID(data []byte) (uint32, error) {
+	if len(data) < 4 {
+		return 0, ErrMalformPacket
+	}
+	return binary.LittleEndian.Uint32(data[:4]), nil
+}
+
+// prepareOK is successful response on COM_STMT_PREPARE
+// https://dev.mysql.com/doc/internals/en/com-stmt-prepare-response.html
+type prepareOK struct {
+	statementID uint32
+	columnCount int
+	paramCount  int
+}
+
+// parsePrepareOK parses COM_STMT_PREPARE_OK packet: status, statement id, count of columns, count of params, filler
+// and count of warnings
+func parsePrepareOK(data []byte) (*prepareOK, error) {
+	if len(data) < prepareOKLength || data[0] != OkPacket {
+		return nil, ErrMalformPacket
+	}
+	return &prepareOK{
+		statementID: binary.LittleEndian.Uint32(data[1:5]),
+		columnCount: int(binary.LittleEndian.Uint16(data[5:7])),
+		paramCount:  int(binary.LittleEndian.Uint16(data[7:9])),
+	}, nil
+}
+
+// isCursorOpened returns true if EOF packet which terminates column definitions reports opened cursor
+func isCursorOpened(packet *MysqlPacket) bool {
+	// https://dev.mysql.com/doc/internals/en/packet-EOF_Packet.html
+	// header, 2 bytes of warnings and 2 bytes of status flags
+	data := packet.GetData()
+	if len(data) < 5 || data[0] != EOFPacket {
+		return false
+	}
+	return binary.LittleEndian.Uint16(data[3:5])&serverStatusCursorExists != 0
+}
+
+// isCursorResult returns true if result of executed statement opened cursor. Column definitions are terminated with
+// EOF packet which has status of cursor unless client deprecated EOF, then cursor is opened if execution requested it
+func (handler *MysqlHandler) isCursorResult(columnsEOF *MysqlPacket) bool {
+	if handler.statement == nil {
+		return false
+	}
+	if columnsEOF != nil {
+		return isCursorOpened(columnsEOF)
+	}
+	return handler.statement.cursor
+}
+
+// isBinaryRowsEnd returns true if packet terminates binary data rows. Binary rows always start with 0x00 header, so
+// EOF packet or OK packet with 0xfe header (CLIENT_DEPRECATE_EOF) and ERR packet finish result
+func isBinaryRowsEnd(packet *MysqlPacket) bool {
+	return packet.GetData()[0] == EOFPacket || packet.IsErr()
+}
+
+// executeStatement prepares handler to process result of COM_STMT_EXECUTE with payload data and returns executed
+// statement. Unknown statements are processed without query directives of query
+func (handler *MysqlHandler) executeStatement(data []byte, logger *logrus.Entry) *preparedStatement {
+	var statement *preparedStatement
+	if id, err := parseStatementID(data); err == nil {
+		statement = handler.preparedStatements.Get(id)
+	}
+	if statement == nil {
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorProtocolProcessing).
+			Warningln("Execution of unknown prepared statement, result will be processed without its query")
+		statement = &preparedStatement{}
+	}
+	// flags follow 4 bytes of statement id
+	statement.cursor = len(data) > 4 && data[4]&cursorTypeReadOnly != 0
+	statement.columns = nil
+	switch {
+	case handler.passthroughTables.IsPassthrough(statement.query):
+		statement.directives = &base.QueryDirectives{SkipDecryption: true}
+	case statement.query == "":
+		statement.directives = base.GetQueryDirectives("", false, handler.sessionZone, nil, logger)
+	default:
+		statement.directives = base.GetQueryDirectives(statement.query, handler.allowQueryDirectives, handler.sessionZone, handler.zoneResolver, logger)
+	}
+	handler.requests.push(pendingRequest{directives: statement.directives, statement: statement})
+	return statement
+}
+
+// fetchStatement prepares handler to process rows fetched by COM_STMT_FETCH with payload data from cursor of statement.
+// Returns false if statement has no opened cursor known by handler, so rows can't be parsed
+func (handler *MysqlHandler) fetchStatement(data []byte, logger *logrus.Entry) bool {
+	id, err := parseStatementID(data)
+	if err != nil {
+		return false
+	}
+	statement := handler.preparedStatements.Get(id)
+	if statement == nil || statement.columns == nil {
+		logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorProtocolProcessing).
+			Warningln("Fetch from unknown cursor, rows will be sent to client as is")
+		return false
+	}
+	handler.requests.push(pendingRequest{directives: statement.directives, statement: statement})
+	return true
+}
+
+// PrepareResponseHandler registers statement prepared by COM_STMT_PREPARE and proxies definitions of its parameters
+// and columns
+func (handler *MysqlHandler) PrepareResponseHandler(packet *MysqlPacket, dbConnection, clientConnection net.Conn) error {
+	handler.resetQueryHandler()
+	response, err := parsePrepareOK(packet.GetData())
+	if err != nil {
+		handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorProtocolProcessing).
+			Errorln("Can't parse response on statement preparation")
+		return err
+	}
+	handler.preparedStatements.OnPrepared(response.statementID)
+	handler.logger.WithField("statement_id", response.statementID).Debugln("Statement prepared")
+	output := []Dumper{packet}
+	for _, count := range []int{response.paramCount, response.columnCount} {
+		if count == 0 {
+			continue
+		}
+		if handler.expectEOFOnColumnDefinition() {
+			count++
+		}
+		for i := 0; i < count; i++ {
+			definitionPacket, err := ReadPacket(dbConnection)
+			if err != nil {
+				handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorResponseConnectorCantProcessColumn).
+					Errorln("Can't read packet with definition of statement")
+				return err
+			}
+			output = append(output, definitionPacket)
+		}
+	}
+	for _, dumper := range output {
+		if _, err := clientConnection.Write(dumper.Dump()); err != nil {
+			handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorResponseConnectorCantWriteToClient).
+				Errorln("Can't proxy output")
+			return err
+		}
+	}
+	return nil
+}
+
+// FetchResponseHandler decrypts binary data rows fetched with COM_STMT_FETCH from cursor of prepared statement
+func (handler *MysqlHandler) FetchResponseHandler(packet *MysqlPacket, dbConnection, clientConnection net.Conn) (err error) {
+	handler.resetQueryHandler()
+	handler.startResponse()
+	handler.decryptor.Reset()
+	handler.decryptor.ResetZoneMatch()
+	skipDecryption := handler.queryDirectives != nil && handler.queryDirectives.SkipDecryption
+	var output []Dumper
+	for !isBinaryRowsEnd(packet) {
+		output = append(output, packet)
+		handler.connectionStats.AddRow()
+		if !skipDecryption {
+			if err := handler.decryptBinaryRow(packet, handler.statement.columns); err != nil {
+				return err
+			}
+		}
+		packet, err = ReadPacket(dbConnection)
+		if err != nil {
+			handler.logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorProtocolProcessing).WithError(err).Errorln("Can't read data packet")
+			return err
+		}
+	}
+	output = append(output, packet)
+	for _, dumper := range output {
+		if _, err := clientConnection.Write(dumper.Dump()); err != nil {
+			handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorResponseConnectorCantWriteToClient).
+				Errorln("Can't proxy output")
+			return err
+		}
+	}
+	return nil
+}
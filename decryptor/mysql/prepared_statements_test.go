@@ -0,0 +1,178 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTestPacket(sequence byte, data []byte) *MysqlPacket {
+	packet := NewMysqlPacket()
+	packet.SetSequenceNumber(sequence)
+	packet.SetData(data)
+	return packet
+}
+
+func writeTestPackets(t *testing.T, connection net.Conn, packets ...*MysqlPacket) {
+	for _, packet := range packets {
+		if _, err := connection.Write(packet.Dump()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readTestPackets(t *testing.T, connection net.Conn, count int) []*MysqlPacket {
+	packets := make([]*MysqlPacket, 0, count)
+	for i := 0; i < count; i++ {
+		packet, err := ReadPacket(connection)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, packet)
+	}
+	return packets
+}
+
+func TestParsePrepareOK(t *testing.T) {
+	// status, statement id 7, 2 columns, 1 param, filler, 0 warnings
+	response, err := parsePrepareOK([]byte{OkPacket, 7, 0, 0, 0, 2, 0, 1, 0, 0, 0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.statementID != 7 || response.columnCount != 2 || response.paramCount != 1 {
+		t.Fatalf("Incorrect response: %+v", response)
+	}
+	if _, err := parsePrepareOK([]byte{OkPacket, 7, 0, 0, 0}); err != ErrMalformPacket {
+		t.Fatalf("Expected ErrMalformPacket, took %v", err)
+	}
+	if _, err := parsePrepareOK([]byte{ErrPacket, 7, 0, 0, 0, 2, 0, 1, 0, 0, 0, 0}); err != ErrMalformPacket {
+		t.Fatalf("Expected ErrMalformPacket, took %v", err)
+	}
+}
+
+func TestPrepareResponseHandler(t *testing.T) {
+	client, clientProxy := net.Pipe()
+	defer client.Close()
+	db, dbProxy := net.Pipe()
+	defer db.Close()
+	handler := &MysqlHandler{logger: logrus.NewEntry(logrus.StandardLogger())}
+	handler.preparedStatements.Prepare("SELECT data FROM users WHERE id = ?")
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.PrepareResponseHandler(newTestPacket(1, []byte{OkPacket, 3, 0, 0, 0, 1, 0, 1, 0, 0, 0, 0}), dbProxy, clientProxy)
+	}()
+	param := &ColumnDescription{Name: []byte("?"), Type: MYSQL_TYPE_LONGLONG}
+	column := &ColumnDescription{Table: []byte("users"), Name: []byte("data"), Type: MYSQL_TYPE_BLOB}
+	eof := []byte{EOFPacket, 0, 0, 2, 0}
+	writeTestPackets(t, db, newTestPacket(2, param.Dump()), newTestPacket(3, eof), newTestPacket(4, column.Dump()), newTestPacket(5, eof))
+	packets := readTestPackets(t, client, 5)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(packets[3].GetData(), column.Dump()) {
+		t.Fatal("Column definition should be sent as is")
+	}
+	statement := handler.preparedStatements.Get(3)
+	if statement == nil || statement.query != "SELECT data FROM users WHERE id = ?" {
+		t.Fatalf("Statement wasn't registered: %+v", statement)
+	}
+	handler.preparedStatements.Close(3)
+	if handler.preparedStatements.Get(3) != nil {
+		t.Fatal("Statement should be forgotten after close")
+	}
+}
+
+func TestFetchRowsFromCursor(t *testing.T) {
+	client, clientProxy := net.Pipe()
+	defer client.Close()
+	db, dbProxy := net.Pipe()
+	defer db.Close()
+	handler := &MysqlHandler{decryptor: getDecryptor(&testKeystore{}), logger: logrus.NewEntry(logrus.StandardLogger()),
+		currentCommand: COM_STMT_EXECUTE}
+	handler.preparedStatements.Prepare("SELECT name, created FROM users WHERE id = ?")
+	handler.preparedStatements.OnPrepared(1)
+	// statement id 1, flags with read only cursor, iteration count
+	statement := handler.executeStatement([]byte{1, 0, 0, 0, cursorTypeReadOnly, 1, 0, 0, 0}, handler.logger)
+	if !statement.cursor || statement.query != "SELECT name, created FROM users WHERE id = ?" {
+		t.Fatalf("Incorrect executed statement: %+v", statement)
+	}
+
+	columns := []*ColumnDescription{
+		{Table: []byte("users"), Name: []byte("name"), Type: MYSQL_TYPE_VAR_STRING, Charset: CollationBinary},
+		{Table: []byte("users"), Name: []byte("created"), Type: MYSQL_TYPE_DATETIME},
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.QueryResponseHandler(newTestPacket(1, []byte{2}), dbProxy, clientProxy)
+	}()
+	// EOF with status of opened cursor, no rows follow it
+	writeTestPackets(t, db, newTestPacket(2, columns[0].Dump()), newTestPacket(3, columns[1].Dump()),
+		newTestPacket(4, []byte{EOFPacket, 0, 0, serverStatusCursorExists | 2, 0}))
+	readTestPackets(t, client, 4)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(statement.columns) != 2 {
+		t.Fatal("Columns of cursor weren't saved")
+	}
+
+	handler.currentCommand = COM_STMT_FETCH
+	// statement id 1, 2 rows
+	if !handler.fetchStatement([]byte{1, 0, 0, 0, 2, 0, 0, 0}, handler.logger) {
+		t.Fatal("Expected fetch from known cursor")
+	}
+	// header, null bitmap, name and datetime with length byte, date and time
+	row := append([]byte{OkPacket, 0}, PutLengthEncodedString([]byte("alice"))...)
+	row = append(row, 7, 0xe2, 0x07, 1, 2, 3, 4, 5)
+	nullRow := []byte{OkPacket, 0x0c}
+	go func() {
+		done <- handler.FetchResponseHandler(newTestPacket(1, row), dbProxy, clientProxy)
+	}()
+	writeTestPackets(t, db, newTestPacket(2, nullRow), newTestPacket(3, []byte{EOFPacket, 0, 0, 0x82, 0}))
+	packets := readTestPackets(t, client, 3)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(packets[0].GetData(), row) || !bytes.Equal(packets[1].GetData(), nullRow) {
+		t.Fatal("Rows without AcraStructs should be sent as is")
+	}
+	if !isBinaryRowsEnd(packets[2]) {
+		t.Fatal("Expected EOF packet after rows")
+	}
+}
+
+func TestProcessBinaryDataRowTypes(t *testing.T) {
+	handler := &MysqlHandler{logger: logrus.NewEntry(logrus.StandardLogger())}
+	fields := []*ColumnDescription{{Type: MYSQL_TYPE_DATE}, {Type: MYSQL_TYPE_TIME}, {Type: MYSQL_TYPE_JSON}, {Type: MYSQL_TYPE_LONG}}
+	row := []byte{OkPacket, 0, 4, 0xe2, 0x07, 1, 2, 0}
+	row = append(row, PutLengthEncodedString([]byte(`{"a": 1}`))...)
+	row = append(row, 1, 0, 0, 0)
+	output, err := handler.processBinaryDataRow(row, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output, row) {
+		t.Fatalf("Row was changed: %v", output)
+	}
+	if _, err := handler.processBinaryDataRow([]byte{OkPacket, 0, 7, 0xe2}, fields[:1]); err != ErrMalformPacket {
+		t.Fatalf("Expected ErrMalformPacket, took %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/logging"
+)
+
+// Capability flags which change format of packets that AcraServer can't process
+const (
+	// ClientCompress - https://dev.mysql.com/doc/internals/en/capability-flags.html#flag-CLIENT_COMPRESS
+	ClientCompress = 0x00000020
+	// ClientZstdCompressionAlgorithm - https://dev.mysql.com/doc/dev/mysql-server/latest/group__group__cs__capabilities__flags.html
+	ClientZstdCompressionAlgorithm = 0x04000000
+	// ClientQueryAttributes - https://dev.mysql.com/doc/dev/mysql-server/latest/group__group__cs__capabilities__flags.html
+	ClientQueryAttributes = 0x08000000
+
+	unsupportedCapabilities = ClientCompress | ClientZstdCompressionAlgorithm | ClientQueryAttributes
+)
+
+// Handshake error sent to clients which don't support protocol 4.1
+const (
+	// https://dev.mysql.com/doc/refman/5.5/en/error-messages-server.html#error_er_not_supported_auth_mode
+	ER_NOT_SUPPORTED_AUTH_MODE_CODE  = 1251
+	ER_NOT_SUPPORTED_AUTH_MODE_STATE = "08004"
+)
+
+// protocolVersion10 is first byte of initial handshake packet
+// https://dev.mysql.com/doc/internals/en/connection-phase-packets.html#packet-Protocol::Handshake
+const protocolVersion10 = 0x0a
+
+// ErrUnsupportedClientProtocol returned when client doesn't support protocol 4.1
+var ErrUnsupportedClientProtocol = acraerrors.New(acraerrors.CodeMySQLUnsupportedClientProtocol, "client doesn't support protocol 4.1")
+
+// NewNotSupportedAuthModeError returns packed error for clients which use authentication of protocol older than 4.1
+func NewNotSupportedAuthModeError() []byte {
+	return newErrPacket(&SQLError{
+		Code:    ER_NOT_SUPPORTED_AUTH_MODE_CODE,
+		State:   ER_NOT_SUPPORTED_AUTH_MODE_STATE,
+		Message: "Client does not support authentication protocol requested by server; consider upgrading MySQL client",
+	}, false)
+}
+
+// stripServerCapabilities removes capabilities from initial handshake of server, so clients will not request them
+func (packet *MysqlPacket) stripServerCapabilities(capabilities uint32) {
+	if len(packet.data) == 0 || packet.data[0] != protocolVersion10 {
+		return
+	}
+	// https://dev.mysql.com/doc/internals/en/connection-phase-packets.html#idm140437490034448
+	endOfServerVersion := bytes.Index(packet.data[1:], []byte{0}) + 2
+	if endOfServerVersion < 2 {
+		return
+	}
+	// 4 bytes connection string + 8 bytes of auth plugin + 1 byte filler
+	baseCapabilitiesOffset := endOfServerVersion + 13
+	if len(packet.data) < baseCapabilitiesOffset+2 {
+		return
+	}
+	lower := binary.LittleEndian.Uint16(packet.data[baseCapabilitiesOffset:])
+	binary.LittleEndian.PutUint16(packet.data[baseCapabilitiesOffset:], lower&^uint16(capabilities&0xffff))
+	// 2 bytes of base capabilities + 1 byte character set + 2 bytes of status flags
+	capabilitiesOffset := baseCapabilitiesOffset + 2 + 3
+	if len(packet.data) < capabilitiesOffset+2 {
+		return
+	}
+	upper := binary.LittleEndian.Uint16(packet.data[capabilitiesOffset:])
+	binary.LittleEndian.PutUint16(packet.data[capabilitiesOffset:], upper&^uint16(capabilities>>16))
+}
+
+// stripClientCapabilities removes unsupported capabilities from handshake response of client which supports
+// protocol 4.1
+func (packet *MysqlPacket) stripClientCapabilities() {
+	if len(packet.data) < 4 {
+		return
+	}
+	capabilities := packet.getClientCapabilities()
+	binary.LittleEndian.PutUint32(packet.data[:4], capabilities&^unsupportedCapabilities)
+	packet.stripMariaDBClientCapabilities()
+}
+
+// checkClientProtocol refuses clients older than protocol 4.1 with error which they can read instead of dropping
+// connection and removes capabilities which AcraServer can't process from handshake of newer clients
+func (handler *MysqlHandler) checkClientProtocol(packet *MysqlPacket) error {
+	if len(packet.data) < 4 || packet.ClientSupportProtocol41() {
+		packet.stripClientCapabilities()
+		return nil
+	}
+	handler.logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorUnsupportedClientProtocol).
+		Errorln("Client uses protocol older than 4.1 which isn't supported, refuse connection")
+	handler.sendError(packet.GetSequenceNumber()+1, NewNotSupportedAuthModeError())
+	return ErrUnsupportedClientProtocol
+}
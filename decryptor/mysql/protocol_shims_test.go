@@ -0,0 +1,94 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func appendUint16(data []byte, value uint16) []byte {
+	return append(data, byte(value), byte(value>>8))
+}
+
+func TestStripServerCapabilities(t *testing.T) {
+	data := []byte{protocolVersion10}
+	data = append(data, "5.7.0\x00"...)
+	// connection id + auth plugin data part 1 + filler
+	data = append(data, make([]byte, 4+8+1)...)
+	data = appendUint16(data, uint16(ClientProtocol41|ClientCompress))
+	// character set + status flags
+	data = append(data, 8, 2, 0)
+	data = appendUint16(data, uint16((ClientDeprecateEof|ClientQueryAttributes|ClientZstdCompressionAlgorithm)>>16))
+	packet := NewMysqlPacket()
+	packet.SetData(data)
+	packet.stripServerCapabilities(unsupportedCapabilities)
+	if capabilities := packet.getServerCapabilities(); capabilities != ClientProtocol41 {
+		t.Fatalf("Unexpected base capabilities %x", capabilities)
+	}
+	capabilities, err := packet.getServerCapabilitiesExtended()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if capabilities != ClientDeprecateEof>>16 {
+		t.Fatalf("Unexpected extended capabilities %x", capabilities)
+	}
+}
+
+func TestCheckClientProtocol(t *testing.T) {
+	handler := &MysqlHandler{logger: logrus.NewEntry(logrus.StandardLogger())}
+	packet := NewMysqlPacket()
+	capabilities := make([]byte, 4)
+	binary.LittleEndian.PutUint32(capabilities, ClientProtocol41|ClientCompress|ClientQueryAttributes)
+	packet.SetData(capabilities)
+	if err := handler.checkClientProtocol(packet); err != nil {
+		t.Fatal(err)
+	}
+	if capabilities := packet.getClientCapabilities(); capabilities != ClientProtocol41 {
+		t.Fatalf("Unsupported capabilities weren't removed: %x", capabilities)
+	}
+
+	client, proxy := net.Pipe()
+	defer client.Close()
+	handler.clientConnection = proxy
+	// HandshakeResponse320 with 2 bytes of capabilities, 3 bytes of max packet size and user name
+	packet.SetData([]byte{0x05, 0x00, 0x00, 0x00, 0x01, 'u', 0})
+	packet.SetSequenceNumber(1)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- handler.checkClientProtocol(packet)
+		proxy.Close()
+	}()
+	response, err := ioutil.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != ErrUnsupportedClientProtocol {
+		t.Fatalf("Expected ErrUnsupportedClientProtocol, took %v", err)
+	}
+	expected := NewMysqlPacket()
+	expected.SetSequenceNumber(2)
+	expected.SetData(NewNotSupportedAuthModeError())
+	if !bytes.Equal(response, expected.Dump()) {
+		t.Fatalf("Unexpected response %q", response)
+	}
+}
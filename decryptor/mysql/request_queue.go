@@ -0,0 +1,59 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"sync"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+)
+
+// pendingRequest describes request forwarded to database which response isn't processed yet
+type pendingRequest struct {
+	// directives of request applied to its response, nil if request has nothing to override
+	directives *base.QueryDirectives
+	// statement executed or fetched by request, nil for text queries
+	statement *preparedStatement
+}
+
+// requestQueue keeps requests which responses are processed by QueryResponseHandler or FetchResponseHandler in order
+// they were forwarded to database. Requests are pushed by ClientToDbConnector and popped by DbToClientConnector when
+// their response starts
+type requestQueue struct {
+	lock     sync.Mutex
+	requests []pendingRequest
+}
+
+// push adds request forwarded to database
+func (queue *requestQueue) push(request pendingRequest) {
+	queue.lock.Lock()
+	queue.requests = append(queue.requests, request)
+	queue.lock.Unlock()
+}
+
+// pop removes and returns request which response is processed now or empty request
+func (queue *requestQueue) pop() pendingRequest {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	if len(queue.requests) == 0 {
+		return pendingRequest{}
+	}
+	request := queue.requests[0]
+	queue.requests[0] = pendingRequest{}
+	queue.requests = queue.requests[1:]
+	return request
+}
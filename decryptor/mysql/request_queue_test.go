@@ -0,0 +1,45 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"testing"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+)
+
+func TestRequestQueueOrder(t *testing.T) {
+	handler := &MysqlHandler{}
+	skip := &base.QueryDirectives{SkipDecryption: true}
+	statement := &preparedStatement{query: "SELECT 1", directives: &base.QueryDirectives{}}
+	// client sends next request before response of previous one is processed
+	handler.requests.push(pendingRequest{directives: skip})
+	handler.requests.push(pendingRequest{directives: statement.directives, statement: statement})
+
+	handler.startResponse()
+	if handler.queryDirectives != skip || handler.statement != nil {
+		t.Fatal("Response of text query should be processed with its directives")
+	}
+	handler.startResponse()
+	if handler.queryDirectives != statement.directives || handler.statement != statement {
+		t.Fatal("Response of executed statement should be processed with its directives and statement")
+	}
+	handler.startResponse()
+	if handler.queryDirectives != nil || handler.statement != nil {
+		t.Fatal("Response without forwarded request should be processed without directives")
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/network"
+)
+
+// Errors returned if SSL is required but connection can't be switched to it
+var (
+	ErrSSLRequired       = acraerrors.New(acraerrors.CodeMySQLSSLRequired, "client didn't request SSL connection which is required")
+	ErrDBSSLNotSupported = acraerrors.New(acraerrors.CodeMySQLDBSSLNotSupported, "database doesn't support SSL connection which is required")
+)
+
+// Codes of errors sent to client if SSL is required but connection can't be switched to it
+const (
+	// https://dev.mysql.com/doc/refman/5.7/en/server-error-reference.html#error_er_secure_transport_required
+	ER_SECURE_TRANSPORT_REQUIRED_CODE = 3159
+	// https://dev.mysql.com/doc/refman/5.7/en/client-error-reference.html#error_cr_ssl_connection_error
+	CR_SSL_CONNECTION_ERROR_CODE = 2026
+)
+
+// NewSecureTransportRequiredError returns packed error for clients which don't request SSL if it's required
+func NewSecureTransportRequiredError(isProtocol41 bool) []byte {
+	return newErrPacket(&SQLError{Code: ER_SECURE_TRANSPORT_REQUIRED_CODE, State: CR_GENERAL_STATE,
+		Message: "Connections using insecure transport are prohibited by AcraServer, enable SSL on client"}, isProtocol41)
+}
+
+// NewSSLNotSupportedError returns packed error sent instead of handshake of database which doesn't support SSL
+func NewSSLNotSupportedError() []byte {
+	// client doesn't know about protocol of server before handshake so error is sent without sql state
+	return newErrPacket(&SQLError{Code: CR_SSL_CONNECTION_ERROR_CODE, State: CR_GENERAL_STATE,
+		Message: "SSL connection error: SSL is required by AcraServer but the database doesn't support it"}, false)
+}
+
+// checkClientSSL refuses handshake response of client sent over plaintext connection if SSL is required
+func (handler *MysqlHandler) checkClientSSL(packet *MysqlPacket) error {
+	if !handler.requireSSL || packet.IsSSLRequest() {
+		return nil
+	}
+	handler.logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTLSRequired).
+		Errorln("Client didn't request SSL which is required, refuse connection")
+	handler.sendError(packet.GetSequenceNumber()+1, NewSecureTransportRequiredError(packet.ClientSupportProtocol41()))
+	return ErrSSLRequired
+}
+
+// checkServerSSL refuses handshake of database which doesn't support SSL if it's required, so client can't continue
+// in plaintext
+func (handler *MysqlHandler) checkServerSSL(packet *MysqlPacket) error {
+	if !handler.requireSSL || len(packet.data) == 0 || packet.data[0] != protocolVersion10 {
+		return nil
+	}
+	if packet.getServerCapabilities()&SslRequest != 0 {
+		return nil
+	}
+	handler.logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTLSRequired).
+		Errorln("Database doesn't support SSL which is required, refuse connection")
+	handler.sendError(packet.GetSequenceNumber(), NewSSLNotSupportedError())
+	return ErrDBSSLNotSupported
+}
+
+// strippedServerCapabilities returns capabilities removed from initial handshake of database. CLIENT_SSL is removed if
+// AcraServer has no certificate to accept TLS from client, so clients which prefer SSL continue without it instead of
+// failed handshake. Connection to database is switched to TLS only together with client's connection
+func (handler *MysqlHandler) strippedServerCapabilities() uint32 {
+	if network.HasServerCertificate(handler.tlsConfig) {
+		return unsupportedCapabilities
+	}
+	handler.logger.Debugln("Hide SSL support of database from client, TLS isn't configured")
+	return unsupportedCapabilities | SslRequest
+}
+
+// sendError sends ERR packet with sequence number to client
+func (handler *MysqlHandler) sendError(sequence byte, errPacket []byte) {
+	response := NewMysqlPacket()
+	response.SetSequenceNumber(sequence)
+	response.SetData(errPacket)
+	if _, err := handler.clientConnection.Write(response.Dump()); err != nil {
+		handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorResponseConnectorCantWriteToClient).
+			Errorln("Can't write response with error to client")
+	}
+}
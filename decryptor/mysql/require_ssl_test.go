@@ -0,0 +1,117 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func handshakeWithCapabilities(capabilities uint16) *MysqlPacket {
+	data := []byte{protocolVersion10}
+	data = append(data, "5.7.0\x00"...)
+	data = append(data, make([]byte, 4+8+1)...)
+	data = appendUint16(data, capabilities)
+	packet := NewMysqlPacket()
+	packet.SetData(data)
+	return packet
+}
+
+// readError runs check which sends error to client and returns sent data
+func readError(t *testing.T, handler *MysqlHandler, check func() error) ([]byte, error) {
+	client, proxy := net.Pipe()
+	defer client.Close()
+	handler.clientConnection = proxy
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- check()
+		proxy.Close()
+	}()
+	response, err := ioutil.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response, <-errCh
+}
+
+func TestCheckServerSSL(t *testing.T) {
+	handler := &MysqlHandler{logger: logrus.NewEntry(logrus.StandardLogger())}
+	withoutSSL := handshakeWithCapabilities(ClientProtocol41)
+	if err := handler.checkServerSSL(withoutSSL); err != nil {
+		t.Fatalf("Handshake refused when SSL isn't required: %v", err)
+	}
+	handler.SetRequireSSL(true)
+	if err := handler.checkServerSSL(handshakeWithCapabilities(ClientProtocol41 | SslRequest)); err != nil {
+		t.Fatal(err)
+	}
+	response, err := readError(t, handler, func() error { return handler.checkServerSSL(withoutSSL) })
+	if err != ErrDBSSLNotSupported {
+		t.Fatalf("Expected ErrDBSSLNotSupported, took %v", err)
+	}
+	expected := NewMysqlPacket()
+	expected.SetData(NewSSLNotSupportedError())
+	if !bytes.Equal(response, expected.Dump()) {
+		t.Fatalf("Unexpected response %q", response)
+	}
+}
+
+func TestStrippedServerCapabilities(t *testing.T) {
+	handler := &MysqlHandler{logger: logrus.NewEntry(logrus.StandardLogger())}
+	for _, config := range []*tls.Config{nil, {}} {
+		handler.tlsConfig = config
+		packet := handshakeWithCapabilities(ClientProtocol41 | SslRequest)
+		packet.stripServerCapabilities(handler.strippedServerCapabilities())
+		if capabilities := packet.getServerCapabilities(); capabilities != ClientProtocol41 {
+			t.Fatalf("SSL isn't hidden from client without TLS certificate, capabilities %x", capabilities)
+		}
+	}
+	handler.tlsConfig = &tls.Config{Certificates: []tls.Certificate{{}}}
+	packet := handshakeWithCapabilities(ClientProtocol41 | SslRequest)
+	packet.stripServerCapabilities(handler.strippedServerCapabilities())
+	if capabilities := packet.getServerCapabilities(); capabilities != ClientProtocol41|SslRequest {
+		t.Fatalf("SSL is hidden from client with TLS certificate, capabilities %x", capabilities)
+	}
+}
+
+func TestCheckClientSSL(t *testing.T) {
+	handler := &MysqlHandler{logger: logrus.NewEntry(logrus.StandardLogger()), requireSSL: true}
+	capabilities := make([]byte, 32)
+	binary.LittleEndian.PutUint32(capabilities, ClientProtocol41|SslRequest)
+	packet := NewMysqlPacket()
+	packet.SetData(capabilities)
+	packet.SetSequenceNumber(1)
+	if err := handler.checkClientSSL(packet); err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint32(capabilities, ClientProtocol41)
+	response, err := readError(t, handler, func() error { return handler.checkClientSSL(packet) })
+	if err != ErrSSLRequired {
+		t.Fatalf("Expected ErrSSLRequired, took %v", err)
+	}
+	expected := NewMysqlPacket()
+	expected.SetSequenceNumber(2)
+	expected.SetData(NewSecureTransportRequiredError(true))
+	if !bytes.Equal(response, expected.Dump()) {
+		t.Fatalf("Unexpected response %q", response)
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package mysql
 
 import (
+	"bytes"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -27,6 +28,7 @@ import (
 	"github.com/cossacklabs/acra/acra-censor"
 	"github.com/cossacklabs/acra/acra-censor/handlers"
 	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/encryptor"
 	"github.com/cossacklabs/acra/logging"
 	"github.com/cossacklabs/acra/network"
 	"github.com/prometheus/client_golang/prometheus"
@@ -99,6 +101,9 @@ const (
 	MYSQL_TYPE_BIT
 )
 
+// MYSQL_TYPE_JSON is type of JSON columns sent as length encoded string in binary protocol
+const MYSQL_TYPE_JSON byte = 0xf5
+
 // MySQL types
 const (
 	MYSQL_TYPE_NEWDECIMAL byte = iota + 0xf6
@@ -161,6 +166,7 @@ type MysqlHandler struct {
 	clientDeprecateEOF     bool
 	decryptor              base.Decryptor
 	acracensor             acracensor.AcraCensorInterface
+	queryEncryptor         encryptor.QueryEncryptor
 	isTLSHandshake         bool
 	dbTLSHandshakeFinished chan bool
 	clientConnection       net.Conn
@@ -168,10 +174,68 @@ type MysqlHandler struct {
 	tlsConfig              *tls.Config
 	clientID               []byte
 	logger                 *logrus.Entry
+	// allowQueryDirectives enables processing of SQL comment directives for trusted clients
+	allowQueryDirectives bool
+	// zoneResolver infers zone from query if zone ids aren't stored with data
+	zoneResolver base.QueryZoneResolver
+	// passthroughTables used to forward queries to tables without encrypted data as is
+	passthroughTables *base.PassthroughTables
+	// encryptedColumns restricts scanning for AcraStructs to configured columns if not nil
+	encryptedColumns base.EncryptedColumns
+	// connectionStats accumulates counters of client's connection, may be nil
+	connectionStats *base.ConnectionStats
+	// sessionZone is zone of client's session applied to queries without zone, may be nil
+	sessionZone *base.SessionZone
+	// requests are forwarded to database and wait for processing of their responses
+	requests requestQueue
+	// queryDirectives of request which response is processed, accessed only by DbToClientConnector
+	queryDirectives *base.QueryDirectives
+	// deterministic decrypts values of deterministic columns, may be nil
+	deterministic *encryptor.DeterministicEncryptor
+	// resultsCharset is character set of results negotiated in handshake or set by SET NAMES, used for columns without
+	// known collation
+	resultsCharset string
+	// lengthAudit enables check of lengths of rewritten data rows before they are sent to client
+	lengthAudit bool
+	// dlpSampler scans sampled rows for sensitive data stored in plaintext, may be nil
+	dlpSampler *base.DLPSampler
+	// requireSSL refuses connections which aren't switched to TLS on handshake
+	requireSSL bool
+	// censorConnection describes client's connection for AcraCensor, filled from connection attributes
+	censorConnection acracensor.ConnectionInfo
+	// database is current database of connection used to scope AcraCensor handlers
+	database sessionDatabase
+	// lastQuery is text of last COM_QUERY, used to check LOAD DATA LOCAL INFILE request in its response
+	lastQuery         string
+	localInfilePolicy LocalInfilePolicy
+	// localInfileUpload is 1 while client sends content of local file, localInfileSize is size of sent content,
+	// accessed atomically
+	localInfileUpload int32
+	localInfileSize   int64
+	// clientPacketMarker is count of packets received from client shifted by 8 bits with sequence number of last one,
+	// accessed atomically
+	clientPacketMarker uint64
+	// responseMarker is clientPacketMarker at moment of last response forwarded to client with responseSequence
+	responseMarker   uint64
+	responseSequence byte
+	responseSent     bool
+	// preparedStatements are statements prepared on connection, statement is one executed or fetched by request which
+	// response is processed, accessed only by DbToClientConnector
+	preparedStatements preparedStatements
+	statement          *preparedStatement
+	// onStartupFinished is called once when database authenticated client, may be nil
+	onStartupFinished func()
+	// dbReadPipelineSize is count of chunks read from database ahead while rows are decrypted, 0 turns off read ahead
+	dbReadPipelineSize int
+	// dbReadSpill configures temporary files for data read ahead after pipeline is full, nil turns off spilling
+	dbReadSpill *network.SpillConfig
+	// dbReader is dbConnection which data is read ahead after connection phase, nil until reading ahead started.
+	// Accessed only by DbToClientConnector
+	dbReader net.Conn
 }
 
-// NewMysqlHandler returns new MysqlHandler
-func NewMysqlHandler(clientID []byte, decryptor base.Decryptor, dbConnection, clientConnection net.Conn, tlsConfig *tls.Config, censor acracensor.AcraCensorInterface) (*MysqlHandler, error) {
+// NewMysqlHandler returns new MysqlHandler. queryEncryptor may be nil if queries shouldn't be changed
+func NewMysqlHandler(clientID []byte, decryptor base.Decryptor, dbConnection, clientConnection net.Conn, tlsConfig *tls.Config, censor acracensor.AcraCensorInterface, queryEncryptor encryptor.QueryEncryptor) (*MysqlHandler, error) {
 	return &MysqlHandler{
 		isTLSHandshake:         false,
 		dbTLSHandshakeFinished: make(chan bool),
@@ -179,12 +243,141 @@ func NewMysqlHandler(clientID []byte, decryptor base.Decryptor, dbConnection, cl
 		decryptor:              decryptor,
 		responseHandler:        defaultResponseHandler,
 		acracensor:             censor,
+		queryEncryptor:         queryEncryptor,
 		clientConnection:       clientConnection,
 		dbConnection:           dbConnection,
 		tlsConfig:              tlsConfig,
+		clientID:               clientID,
 		logger:                 logrus.WithField("client_id", string(clientID))}, nil
 }
 
+// SetLogger sets logger of client's connection used by handler
+func (handler *MysqlHandler) SetLogger(logger *logrus.Entry) {
+	handler.logger = logger
+}
+
+// AllowQueryDirectives turns on or off processing of SQL comment directives which override zone and decryption per query
+func (handler *MysqlHandler) AllowQueryDirectives(allow bool) {
+	handler.allowQueryDirectives = allow
+}
+
+// SetQueryZoneResolver sets resolver used to infer zone from query. nil turns off inferring
+func (handler *MysqlHandler) SetQueryZoneResolver(resolver base.QueryZoneResolver) {
+	handler.zoneResolver = resolver
+}
+
+// SetPassthroughTables sets tables which queries are forwarded without AcraCensor checks and which results aren't
+// decrypted. nil turns off passthrough
+func (handler *MysqlHandler) SetPassthroughTables(tables *base.PassthroughTables) {
+	handler.passthroughTables = tables
+}
+
+// SetEncryptedColumns sets configuration of columns which may contain AcraStructs, other columns of results won't be
+// scanned. nil turns off restriction
+func (handler *MysqlHandler) SetEncryptedColumns(columns base.EncryptedColumns) {
+	handler.encryptedColumns = columns
+}
+
+// SetConnectionStats sets counters of client's connection updated on queries, rows and decryptions
+func (handler *MysqlHandler) SetConnectionStats(stats *base.ConnectionStats) {
+	handler.connectionStats = stats
+}
+
+// SetSessionZone sets zone of client's session changed by session_zone directives and used for queries without zone
+func (handler *MysqlHandler) SetSessionZone(session *base.SessionZone) {
+	handler.sessionZone = session
+}
+
+// SetDeterministicEncryptor sets encryptor used to decrypt deterministically encrypted values of results. nil turns
+// off decryption of such values
+func (handler *MysqlHandler) SetDeterministicEncryptor(deterministic *encryptor.DeterministicEncryptor) {
+	handler.deterministic = deterministic
+}
+
+// SetDLPSampler sets sampler which scans fraction of data rows for sensitive data stored in plaintext. nil turns off
+// scanning
+func (handler *MysqlHandler) SetDLPSampler(sampler *base.DLPSampler) {
+	handler.dlpSampler = sampler
+}
+
+// SetLengthAudit enables check of lengths of each rewritten data row. Rows with mismatched lengths are logged and
+// sent to client as they were received from database
+func (handler *MysqlHandler) SetLengthAudit(enable bool) {
+	handler.lengthAudit = enable
+}
+
+// SetRequireSSL turns on refusing of clients which don't request SSL and databases which don't support it, so data
+// never goes to database in plaintext
+func (handler *MysqlHandler) SetRequireSSL(require bool) {
+	handler.requireSSL = require
+}
+
+// SetStartupCallback sets function called once when database authenticates client and connection phase is finished
+func (handler *MysqlHandler) SetStartupCallback(callback func()) {
+	handler.onStartupFinished = callback
+}
+
+// SetDBReadPipelineSize sets count of chunks which will be read from database in background while previous data
+// is decrypted. 0 turns off reading ahead
+func (handler *MysqlHandler) SetDBReadPipelineSize(size int) {
+	handler.dbReadPipelineSize = size
+}
+
+// SetDBReadSpill sets configuration of encrypted temporary files where data read ahead from database is kept after
+// pipeline is full. nil turns off spilling
+func (handler *MysqlHandler) SetDBReadSpill(config *network.SpillConfig) {
+	handler.dbReadSpill = config
+}
+
+// dbReadConnection returns connection from which responses of database should be read
+func (handler *MysqlHandler) dbReadConnection() net.Conn {
+	if handler.dbReader != nil {
+		return handler.dbReader
+	}
+	return handler.dbConnection
+}
+
+// startDBReadAhead starts reading of database responses in background if it's turned on. Should be called after
+// connection phase when connection can't be switched to TLS anymore
+func (handler *MysqlHandler) startDBReadAhead() {
+	if handler.dbReadPipelineSize <= 0 || handler.dbReader != nil {
+		return
+	}
+	handler.dbReader = network.NewPrefetchConnection(handler.dbConnection, handler.dbReadPipelineSize, network.DefaultPrefetchChunkSize, handler.dbReadSpill)
+}
+
+// notifyStartupFinished calls startup callback on first OK packet from database which finishes connection phase.
+// Server's handshake is the first packet, auth switch and auth more data packets have other headers
+// https://dev.mysql.com/doc/internals/en/connection-phase.html
+func (handler *MysqlHandler) notifyStartupFinished(packet *MysqlPacket) {
+	if handler.onStartupFinished == nil || len(packet.GetData()) == 0 || packet.GetData()[0] != OkPacket {
+		return
+	}
+	handler.onStartupFinished()
+	handler.onStartupFinished = nil
+}
+
+// auditRowLengths returns false and logs mismatch if audit of lengths is turned on and rewritten row is malformed
+func (handler *MysqlHandler) auditRowLengths(logger *logrus.Entry, originalData, newData []byte, fields []*ColumnDescription) bool {
+	if !handler.lengthAudit {
+		return true
+	}
+	var err error
+	if handler.isPreparedStatementResult() {
+		err = auditBinaryRowLengths(newData, fields)
+	} else {
+		err = auditTextRowLengths(newData, len(fields))
+	}
+	if err != nil {
+		logger.WithError(err).WithFields(logrus.Fields{logging.FieldKeyEventCode: logging.EventCodeErrorDecryptorLengthMismatch,
+			"original_length": len(originalData), "rewritten_length": len(newData), "field_count": len(fields),
+			"binary_protocol": handler.isPreparedStatementResult()}).
+			Errorln("Rewritten data row is malformed, send it as it was received from database")
+		return false
+	}
+	return true
+}
+
 func (handler *MysqlHandler) setQueryHandler(callback ResponseHandler) {
 	handler.responseHandler = callback
 }
@@ -192,6 +385,13 @@ func (handler *MysqlHandler) resetQueryHandler() {
 	handler.responseHandler = defaultResponseHandler
 }
 
+// startResponse takes directives and statement of request which response is processed from queue of requests
+func (handler *MysqlHandler) startResponse() {
+	request := handler.requests.pop()
+	handler.queryDirectives = request.directives
+	handler.statement = request.statement
+}
+
 func (handler *MysqlHandler) getResponseHandler() ResponseHandler {
 	return handler.responseHandler
 }
@@ -201,21 +401,40 @@ func (handler *MysqlHandler) ClientToDbConnector(errCh chan<- error) {
 	clientLog := handler.logger.WithField("proxy", "client")
 	clientLog.Debugln("Start proxy client's requests")
 	firstPacket := true
+	// handshake response with connection attributes is first packet or first packet after TLS handshake
+	tagged := false
 	prometheusLabels := []string{base.DecryptionDBMysql}
 	for {
 		timer := prometheus.NewTimer(prometheus.ObserverFunc(base.RequestProcessingTimeHistogram.WithLabelValues(prometheusLabels...).Observe))
-		packet, err := ReadPacket(handler.clientConnection)
+		// client finishes LOCAL INFILE upload with empty packet
+		packet, err := readPacketAllowEmpty(handler.clientConnection)
 		if err != nil {
 			handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorResponseConnectorCantReadFromClient).
 				Debugln("Can't read packet from client")
 			errCh <- err
 			return
 		}
+		if len(packet.GetData()) == 0 && !handler.isLocalInfileUpload() {
+			handler.logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorResponseConnectorCantReadFromClient).
+				Debugln("Empty packet from client outside of LOCAL INFILE upload")
+			errCh <- ErrEmptyClientPacket
+			return
+		}
 		if firstPacket {
 			firstPacket = false
+			if err := handler.checkClientProtocol(packet); err != nil {
+				errCh <- err
+				return
+			}
+			if err := handler.checkClientSSL(packet); err != nil {
+				errCh <- err
+				return
+			}
 			handler.clientProtocol41 = packet.ClientSupportProtocol41()
 			handler.clientDeprecateEOF = packet.IsClientDeprecateEOF()
-			clientLog = clientLog.WithField("deprecate_eof", handler.clientDeprecateEOF)
+			handler.resultsCharset = CharsetName(packet.GetClientCollation())
+			clientLog = clientLog.WithFields(logrus.Fields{"deprecate_eof": handler.clientDeprecateEOF, "charset": handler.resultsCharset})
+			clientLog, tagged = handler.tagConnection(packet, clientLog)
 			if packet.IsSSLRequest() {
 				if handler.tlsConfig == nil {
 					handler.logger.Errorln("To support TLS connections you must pass TLS key and certificate for AcraServer that will be used " +
@@ -233,7 +452,7 @@ func (handler *MysqlHandler) ClientToDbConnector(errCh chan<- error) {
 				}
 				tlsConnection := tls.Server(handler.clientConnection, handler.tlsConfig)
 				if err := tlsConnection.Handshake(); err != nil {
-					handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorDecryptorCantInitializeTLS).
+					handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTLSHandshakeFailed).
 						Errorln("Error in tls handshake with client")
 					errCh <- err
 					return
@@ -264,9 +483,24 @@ func (handler *MysqlHandler) ClientToDbConnector(errCh chan<- error) {
 				continue
 			}
 		}
+		if !tagged {
+			// handshake response sent over TLS after SSL request, it has same capabilities as SSL request
+			packet.stripClientCapabilities()
+			clientLog, _ = handler.tagConnection(packet, clientLog)
+			tagged = true
+		}
 		handler.clientSequenceNumber = int(packet.GetSequenceNumber())
+		handler.markClientPacket(packet.GetSequenceNumber())
 		clientLog = clientLog.WithField("sequence_number", handler.clientSequenceNumber)
 		clientLog.Debugln("New packet")
+		if handler.isLocalInfileUpload() {
+			if err := handler.handleLocalInfileUpload(packet, clientLog); err != nil {
+				errCh <- err
+				return
+			}
+			timer.ObserveDuration()
+			continue
+		}
 		inOutput := packet.Dump()
 		data := packet.GetData()
 		cmd := data[0]
@@ -279,8 +513,10 @@ func (handler *MysqlHandler) ClientToDbConnector(errCh chan<- error) {
 			handler.dbConnection.Close()
 			errCh <- io.EOF
 			return
-		case COM_QUERY, COM_STMT_EXECUTE:
+		case COM_QUERY:
+			handler.connectionStats.AddQuery()
 			query := string(data)
+			handler.lastQuery = query
 
 			// log query with hidden values for debug mode
 			if logging.GetLogLevel() == logging.LOG_DEBUG {
@@ -292,7 +528,21 @@ func (handler *MysqlHandler) ClientToDbConnector(errCh chan<- error) {
 				}
 			}
 
-			if err := handler.acracensor.HandleQuery(query); err != nil {
+			if charset, ok := parseSetCharset(query); ok {
+				clientLog.WithField("charset", charset).Debugln("Client changed character set of results")
+				handler.resultsCharset = charset
+			}
+
+			if handler.passthroughTables.IsPassthrough(query) {
+				handler.requests.push(pendingRequest{directives: &base.QueryDirectives{SkipDecryption: true}})
+				handler.connectionStats.StartStatement(query)
+				handler.setQueryHandler(handler.QueryResponseHandler)
+				break
+			}
+
+			censorConnection := handler.censorConnection
+			censorConnection.Database = handler.database.Get()
+			if err := handler.acracensor.HandleConnectionQuery(censorConnection, query); err != nil {
 				clientLog.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCensorQueryIsNotAllowed).Errorln("Error on AcraCensor check")
 				errPacket := NewQueryInterruptedError(handler.clientProtocol41)
 				packet.SetData(errPacket)
@@ -302,9 +552,84 @@ func (handler *MysqlHandler) ClientToDbConnector(errCh chan<- error) {
 				}
 				continue
 			}
+			if database, ok := parseUseStatement(query); ok {
+				clientLog.WithField("database", database).Debugln("Client changed database")
+				handler.database.Change(database)
+			}
+			directives := base.GetQueryDirectives(query, handler.allowQueryDirectives, handler.sessionZone, handler.zoneResolver, clientLog)
+			if handler.queryEncryptor != nil {
+				newQuery, changed, err := handler.queryEncryptor.OnQuery(query)
+				if err != nil {
+					// query which encryptor can't process would write or compare plaintext values of encrypted columns
+					if _, ok := err.(*encryptor.RejectedQueryError); ok || err == encryptor.ErrExpiredKeyQuery {
+						clientLog.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorEncryptorQueryRejected).
+							Errorln("Encryptor rejected query")
+					} else {
+						clientLog.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorEncryptorCantProcessQuery).
+							Errorln("Can't process query with encryptor, query rejected")
+					}
+					packet.SetData(NewQueryInterruptedError(handler.clientProtocol41))
+					if _, err := handler.clientConnection.Write(packet.Dump()); err != nil {
+						handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorResponseConnectorCantWriteToClient).
+							Errorln("Can't write response with error to client")
+					}
+					continue
+				}
+				if changed {
+					packet.SetData(append([]byte{COM_QUERY}, []byte(newQuery)...))
+					inOutput = packet.Dump()
+				}
+			}
+			handler.requests.push(pendingRequest{directives: directives})
+			handler.connectionStats.StartStatement(query)
 			handler.setQueryHandler(handler.QueryResponseHandler)
 			break
-		case COM_STMT_PREPARE, COM_STMT_CLOSE, COM_STMT_SEND_LONG_DATA, COM_STMT_RESET:
+		case COM_STMT_PREPARE:
+			// text of query is sent only on preparation, so AcraCensor checks it here and statement saves it for executions.
+			// Encryptor doesn't process prepared statements: values are sent separately in COM_STMT_EXECUTE
+			query := string(data)
+			if logging.GetLogLevel() == logging.LOG_DEBUG {
+				_, queryWithHiddenValues, err := handlers.NormalizeAndRedactSQLQuery(query)
+				if err == handlers.ErrQuerySyntaxError {
+					clientLog.WithError(err).Infof("Parsing error on query: %s", queryWithHiddenValues)
+				} else {
+					clientLog.WithField("sql", queryWithHiddenValues).Debugln("Com_stmt_prepare")
+				}
+			}
+			if !handler.passthroughTables.IsPassthrough(query) {
+				censorConnection := handler.censorConnection
+				censorConnection.Database = handler.database.Get()
+				if err := handler.acracensor.HandleConnectionQuery(censorConnection, query); err != nil {
+					clientLog.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCensorQueryIsNotAllowed).Errorln("Error on AcraCensor check")
+					packet.SetData(NewQueryInterruptedError(handler.clientProtocol41))
+					if _, err := handler.clientConnection.Write(packet.Dump()); err != nil {
+						handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorResponseConnectorCantWriteToClient).
+							Errorln("Can't write response with error to client")
+					}
+					continue
+				}
+			}
+			handler.preparedStatements.Prepare(query)
+			handler.setQueryHandler(handler.PrepareResponseHandler)
+		case COM_STMT_EXECUTE:
+			handler.connectionStats.AddQuery()
+			statement := handler.executeStatement(data, clientLog)
+			handler.connectionStats.StartStatement(statement.query)
+			handler.setQueryHandler(handler.QueryResponseHandler)
+		case COM_STMT_FETCH:
+			if handler.fetchStatement(data, clientLog) {
+				handler.setQueryHandler(handler.FetchResponseHandler)
+			}
+		case COM_STMT_CLOSE:
+			if id, err := parseStatementID(data); err == nil {
+				handler.preparedStatements.Close(id)
+			}
+		case COM_RESET_CONNECTION, COM_CHANGE_USER:
+			handler.preparedStatements.Reset()
+		case COM_INIT_DB:
+			clientLog.WithField("database", string(data)).Debugln("Client changed database")
+			handler.database.Change(string(data))
+		case COM_STMT_SEND_LONG_DATA, COM_STMT_RESET:
 			fallthrough
 		default:
 			clientLog.Debugf("Command %d not supported now", cmd)
@@ -329,6 +654,58 @@ func (handler *MysqlHandler) isFieldToDecrypt(field *ColumnDescription) bool {
 	}
 }
 
+// fieldTable returns original table of field or its alias if original name wasn't sent
+func fieldTable(field *ColumnDescription) string {
+	if len(field.OrgTable) == 0 {
+		return string(field.Table)
+	}
+	return string(field.OrgTable)
+}
+
+// isFieldToScan returns false if field isn't configured as encrypted and zone doesn't need to be matched in it
+func (handler *MysqlHandler) isFieldToScan(field *ColumnDescription) bool {
+	if handler.encryptedColumns == nil || (handler.decryptor.IsWithZone() && !handler.decryptor.IsMatchedZone()) {
+		return true
+	}
+	// fields without original name are expressions or aliases of expressions which may contain encrypted columns
+	if len(field.OrgName) == 0 {
+		return true
+	}
+	return handler.encryptedColumns.IsEncryptedColumn(fieldTable(field), string(field.OrgName))
+}
+
+// isConvertedField returns true if MySQL converts values of field from column's character set to UTF-8, so binary
+// values stored in latin1 columns come corrupted
+func (handler *MysqlHandler) isConvertedField(field *ColumnDescription) bool {
+	if field.Charset == CollationBinary {
+		return false
+	}
+	charset := CharsetName(field.Charset)
+	if charset == "" {
+		charset = handler.resultsCharset
+	}
+	return isUTF8Charset(charset)
+}
+
+// decryptField decrypts value of field. If value wasn't decrypted and MySQL converted it to UTF-8 then tries to decrypt
+// value converted back to latin1 because AcraStructs in latin1 columns are corrupted by such conversion
+func (handler *MysqlHandler) decryptField(value []byte, field *ColumnDescription) ([]byte, error) {
+	decryptedValue, err := handler.decryptor.DecryptBlock(value)
+	if (err == nil && len(decryptedValue) != len(value)) || !handler.isConvertedField(field) || !bytes.Contains(value, base.TAG_BEGIN) {
+		return decryptedValue, err
+	}
+	originalValue, ok := latin1FromUTF8(value)
+	if !ok || len(originalValue) == len(value) {
+		return decryptedValue, err
+	}
+	decryptedOriginal, originalErr := handler.decryptor.DecryptBlock(originalValue)
+	if originalErr != nil || len(decryptedOriginal) == len(originalValue) {
+		return decryptedValue, err
+	}
+	handler.logger.WithField("charset", CharsetName(field.Charset)).Debugln("Decrypted value converted from latin1")
+	return decryptedOriginal, nil
+}
+
 func (handler *MysqlHandler) processTextDataRow(rowData []byte, fields []*ColumnDescription) ([]byte, error) {
 	var err error
 	var value []byte
@@ -337,20 +714,45 @@ func (handler *MysqlHandler) processTextDataRow(rowData []byte, fields []*Column
 	var output []byte
 	var fieldLogger *logrus.Entry
 	handler.logger.Debugln("Process data rows in text protocol")
+	// values are scanned before decryption, so only data stored in plaintext is reported
+	sampled := handler.dlpSampler.Sample()
 	for i := range fields {
-		fieldLogger = handler.logger.WithField("field_index", i)
+		fieldLogger = handler.logger.WithFields(logrus.Fields{"field_index": i, "charset": CharsetName(fields[i].Charset)})
 		value, _, n, err = LengthEncodedString(rowData[pos:])
 		if err != nil {
 			return nil, err
 		}
+		if sampled {
+			handler.dlpSampler.Scan(value, fieldTable(fields[i]), string(fields[i].Name), fieldLogger)
+		}
 		if handler.isFieldToDecrypt(fields[i]) {
-			decryptedValue, err := handler.decryptor.DecryptBlock(value)
+			if decryptedValue, ok := handler.deterministic.DecryptValue(value); ok {
+				fieldLogger.Debugln("Update with decrypted deterministic value")
+				output = append(output, PutLengthEncodedString(decryptedValue)...)
+				pos += n
+				continue
+			}
+			handler.queryDirectives.ApplyZone(handler.decryptor)
+			if !handler.isFieldToScan(fields[i]) {
+				fieldLogger.Debugln("Field isn't configured as encrypted")
+				output = append(output, rowData[pos:pos+n]...)
+				pos += n
+				continue
+			}
+			if base.GetLatencyBudget().ServeCiphertext(fieldTable(fields[i]), string(fields[i].Name)) {
+				fieldLogger.Debugln("Leave value of low-sensitivity field encrypted, decryption latency budget exceeded")
+				output = append(output, rowData[pos:pos+n]...)
+				pos += n
+				continue
+			}
+			decryptedValue, err := handler.decryptField(value, fields[i])
 			if err != nil {
 				fieldLogger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorDecryptorCantDecryptBinary).
 					Errorln("Can't decrypt binary data")
 			}
 			if err == nil && len(decryptedValue) != len(value) {
 				fieldLogger.Debugln("Update with decrypted value")
+				handler.connectionStats.AddDecryptedPayload(fieldTable(fields[i]), len(value), len(decryptedValue))
 				output = append(output, PutLengthEncodedString(decryptedValue)...)
 			} else {
 				fieldLogger.Debugln("Leave value as is")
@@ -392,6 +794,8 @@ func (handler *MysqlHandler) processBinaryDataRow(rowData []byte, fields []*Colu
 	pos = 1 + ((len(fields) + 7 + 2) >> 3)
 	nullBitmap := rowData[1:pos]
 	output = append(output, rowData[:pos]...)
+	// only string values are scanned, numbers and dates are sent in binary format
+	sampled := handler.dlpSampler.Sample()
 
 	for i := range fields {
 		// https://dev.mysql.com/doc/internals/en/null-bitmap.html
@@ -407,13 +811,27 @@ func (handler *MysqlHandler) processBinaryDataRow(rowData []byte, fields []*Colu
 					Errorln("Can't handle length encoded string binary value")
 				return nil, err
 			}
-			decryptedValue, err := handler.decryptor.DecryptBlock(value)
+			if sampled {
+				handler.dlpSampler.Scan(value, fieldTable(fields[i]), string(fields[i].Name), handler.logger)
+			}
+			if decryptedValue, ok := handler.deterministic.DecryptValue(value); ok {
+				output = append(output, PutLengthEncodedString(decryptedValue)...)
+				pos += n
+				continue
+			}
+			handler.queryDirectives.ApplyZone(handler.decryptor)
+			if !handler.isFieldToScan(fields[i]) || base.GetLatencyBudget().ServeCiphertext(fieldTable(fields[i]), string(fields[i].Name)) {
+				output = append(output, rowData[pos:pos+n]...)
+				pos += n
+				continue
+			}
+			decryptedValue, err := handler.decryptField(value, fields[i])
 			if err != nil {
 				handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorDecryptorCantDecryptBinary).
 					Errorln("Can't decrypt binary data")
-				return nil, err
 			}
-			if len(value) != len(decryptedValue) {
+			if err == nil && len(value) != len(decryptedValue) {
+				handler.connectionStats.AddDecryptedPayload(fieldTable(fields[i]), len(value), len(decryptedValue))
 				output = append(output, PutLengthEncodedString(decryptedValue)...)
 			} else {
 				output = append(output, rowData[pos:pos+n]...)
@@ -458,7 +876,7 @@ func (handler *MysqlHandler) processBinaryDataRow(rowData []byte, fields []*Colu
 			continue
 
 		case MYSQL_TYPE_DECIMAL, MYSQL_TYPE_NEWDECIMAL,
-			MYSQL_TYPE_BIT, MYSQL_TYPE_ENUM, MYSQL_TYPE_SET, MYSQL_TYPE_GEOMETRY:
+			MYSQL_TYPE_BIT, MYSQL_TYPE_ENUM, MYSQL_TYPE_SET, MYSQL_TYPE_GEOMETRY, MYSQL_TYPE_JSON:
 			value, _, n, err = LengthEncodedString(rowData[pos:])
 			output = append(output, rowData[pos:pos+n]...)
 			pos += n
@@ -469,10 +887,11 @@ func (handler *MysqlHandler) processBinaryDataRow(rowData []byte, fields []*Colu
 			}
 			continue
 		case MYSQL_TYPE_DATE, MYSQL_TYPE_NEWDATE, MYSQL_TYPE_TIMESTAMP, MYSQL_TYPE_DATETIME, MYSQL_TYPE_TIME:
-			_, _, n, err = LengthEncodedInt(rowData[pos:])
-			if err != nil {
-				return nil, err
+			// 1 byte of length followed by 0, 4, 7 or 11 bytes of date and 0, 8 or 12 bytes of time
+			if pos >= len(rowData) || pos+1+int(rowData[pos]) > len(rowData) {
+				return nil, ErrMalformPacket
 			}
+			n = 1 + int(rowData[pos])
 			output = append(output, rowData[pos:pos+n]...)
 			pos += n
 			continue
@@ -488,12 +907,34 @@ func (handler *MysqlHandler) expectEOFOnColumnDefinition() bool {
 }
 
 func (handler *MysqlHandler) isPreparedStatementResult() bool {
-	return handler.currentCommand == COM_STMT_EXECUTE
+	return handler.currentCommand == COM_STMT_EXECUTE || handler.currentCommand == COM_STMT_FETCH
+}
+
+// decryptBinaryRow decrypts values of binary data row and replaces data of packet if they were changed
+func (handler *MysqlHandler) decryptBinaryRow(rowPacket *MysqlPacket, fields []*ColumnDescription) error {
+	newData, err := handler.processBinaryDataRow(rowPacket.GetData(), fields)
+	if err != nil {
+		handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorProtocolProcessing).
+			Debugln("Can't process binary data row")
+		return err
+	}
+	dataLength := rowPacket.GetPacketPayloadLength()
+	// decrypted data always less than ecrypted
+	if len(newData) < dataLength && handler.auditRowLengths(handler.logger, rowPacket.GetData(), newData, fields) {
+		handler.logger.WithFields(logrus.Fields{"oldLength": dataLength, "newLength": len(newData)}).Debugln("Update row data")
+		rowPacket.SetData(newData)
+	}
+	return nil
 }
 
 // QueryResponseHandler parses data from database response
 func (handler *MysqlHandler) QueryResponseHandler(packet *MysqlPacket, dbConnection, clientConnection net.Conn) (err error) {
+	defer handler.connectionStats.EndStatement()
 	handler.resetQueryHandler()
+	handler.startResponse()
+	if handler.isLocalInfileRequest(packet) {
+		return handler.handleLocalInfileRequest(packet)
+	}
 	handler.decryptor.Reset()
 	handler.decryptor.ResetZoneMatch()
 	// read fields
@@ -503,6 +944,9 @@ func (handler *MysqlHandler) QueryResponseHandler(packet *MysqlPacket, dbConnect
 	// https://dev.mysql.com/doc/internals/en/com-query-response.html#text-resultset
 	fieldCount := int(packet.GetData()[0])
 	output := []Dumper{packet}
+	skipDecryption := handler.queryDirectives != nil && handler.queryDirectives.SkipDecryption
+	// EOF packet after column definitions reports whether cursor was opened by COM_STMT_EXECUTE
+	var columnsEOF *MysqlPacket
 	if fieldCount != ErrPacket && fieldCount > 0 {
 		handler.logger.Debugln("Read column descriptions")
 		for i := 0; ; i++ {
@@ -520,6 +964,7 @@ func (handler *MysqlHandler) QueryResponseHandler(packet *MysqlPacket, dbConnect
 						handler.logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorProtocolProcessing).Errorln("EOF and field count != current row packet count")
 						return ErrMalformPacket
 					}
+					columnsEOF = fieldPacket
 					break
 				}
 			}
@@ -540,7 +985,11 @@ func (handler *MysqlHandler) QueryResponseHandler(packet *MysqlPacket, dbConnect
 
 		}
 		handler.logger.Debugln("Read data rows")
-		if handler.isPreparedStatementResult() {
+		if handler.isPreparedStatementResult() && handler.isCursorResult(columnsEOF) {
+			// rows of cursor are read later with COM_STMT_FETCH
+			handler.logger.Debugln("Cursor opened, save columns of result")
+			handler.statement.columns = fields
+		} else if handler.isPreparedStatementResult() {
 			for {
 				fieldDataPacket, err := ReadPacket(dbConnection)
 				if err != nil {
@@ -548,20 +997,15 @@ func (handler *MysqlHandler) QueryResponseHandler(packet *MysqlPacket, dbConnect
 					return err
 				}
 				output = append(output, fieldDataPacket)
-				if fieldDataPacket.data[0] == EOFPacket {
+				if isBinaryRowsEnd(fieldDataPacket) {
 					break
 				}
-				newData, err := handler.processBinaryDataRow(fieldDataPacket.GetData(), fields)
-				if err != nil {
-					handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorProtocolProcessing).
-						Debugln("Can't process binary data row")
-					return err
+				handler.connectionStats.AddRow()
+				if skipDecryption {
+					continue
 				}
-				dataLength := fieldDataPacket.GetPacketPayloadLength()
-				// decrypted data always less than ecrypted
-				if len(newData) < dataLength {
-					handler.logger.WithFields(logrus.Fields{"oldLength": dataLength, "newLength": len(newData)}).Debugln("Update row data")
-					fieldDataPacket.SetData(newData)
+				if err := handler.decryptBinaryRow(fieldDataPacket, fields); err != nil {
+					return err
 				}
 			}
 		} else {
@@ -580,8 +1024,9 @@ func (handler *MysqlHandler) QueryResponseHandler(packet *MysqlPacket, dbConnect
 					dataLog.Debugln("Empty result set")
 					break
 				}
-				// skip if no binary fields and nothing to decrypt
-				if len(fields) == 0 {
+				handler.connectionStats.AddRow()
+				// skip if no binary fields and nothing to decrypt or decryption turned off by query directive
+				if len(fields) == 0 || skipDecryption {
 					continue
 				}
 				dataLog.Debugln("Process data text row")
@@ -593,7 +1038,7 @@ func (handler *MysqlHandler) QueryResponseHandler(packet *MysqlPacket, dbConnect
 				}
 				dataLength := fieldDataPacket.GetPacketPayloadLength()
 				// decrypted data always less than ecrypted
-				if len(newData) < dataLength {
+				if len(newData) < dataLength && handler.auditRowLengths(dataLog, fieldDataPacket.GetData(), newData, fields) {
 					dataLog.WithFields(logrus.Fields{"oldLength": dataLength, "newLength": len(newData)}).Debugln("Update row data")
 					fieldDataPacket.SetData(newData)
 				}
@@ -622,6 +1067,13 @@ func (handler *MysqlHandler) DbToClientConnector(errCh chan<- error) {
 	serverLog := handler.logger.WithField("proxy", "server")
 	serverLog.Debugln("Start proxy db responses")
 	firstPacket := true
+	// connectionPhase is true until database authenticates client, connection may be switched to TLS only before it
+	connectionPhase := true
+	defer func() {
+		if handler.dbReader != nil {
+			handler.dbReader.Close()
+		}
+	}()
 	var responseHandler ResponseHandler
 	prometheusLabels := []string{base.DecryptionDBMysql}
 	if handler.decryptor.IsWholeMatch() {
@@ -631,7 +1083,7 @@ func (handler *MysqlHandler) DbToClientConnector(errCh chan<- error) {
 	}
 	for {
 		timer := prometheus.NewTimer(prometheus.ObserverFunc(base.ResponseProcessingTimeHistogram.WithLabelValues(prometheusLabels...).Observe))
-		packet, err := ReadPacket(handler.dbConnection)
+		packet, err := ReadPacket(handler.dbReadConnection())
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok {
 				if netErr.Timeout() && handler.isTLSHandshake {
@@ -639,7 +1091,7 @@ func (handler *MysqlHandler) DbToClientConnector(errCh chan<- error) {
 					handler.dbConnection.SetReadDeadline(time.Time{})
 					tlsConnection := tls.Client(handler.dbConnection, handler.tlsConfig)
 					if err := tlsConnection.Handshake(); err != nil {
-						handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorDecryptorCantInitializeTLS).
+						handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTLSHandshakeFailed).
 							Errorln("Error in tls handshake with db")
 						errCh <- err
 						return
@@ -652,6 +1104,7 @@ func (handler *MysqlHandler) DbToClientConnector(errCh chan<- error) {
 				}
 			}
 			handler.logger.Debugln("Can't read packet from server")
+			handler.notifyDBConnectionLost(err)
 			errCh <- err
 			return
 		}
@@ -659,20 +1112,41 @@ func (handler *MysqlHandler) DbToClientConnector(errCh chan<- error) {
 		if packet.IsErr() {
 			handler.resetQueryHandler()
 		}
+		handler.database.OnResponse(packet.IsErr())
+		startReadAhead := false
 		if firstPacket {
 			firstPacket = false
+			if err := handler.checkServerSSL(packet); err != nil {
+				errCh <- err
+				return
+			}
 			handler.serverProtocol41 = packet.ServerSupportProtocol41()
-			serverLog.Debugf("Set support protocol 41 %v", handler.serverProtocol41)
+			packet.stripServerCapabilities(handler.strippedServerCapabilities())
+			packet.stripMariaDBServerCapabilities()
+			serverVersion := ParseServerVersion(packet.getServerVersion())
+			serverLog.WithFields(logrus.Fields{"dialect": serverVersion.Dialect, "version": serverVersion.Version}).
+				Debugf("Set support protocol 41 %v", handler.serverProtocol41)
+		} else {
+			handler.notifyStartupFinished(packet)
+			if connectionPhase && len(packet.GetData()) > 0 && packet.GetData()[0] == OkPacket {
+				connectionPhase = false
+				startReadAhead = true
+			}
 		}
 		responseHandler = handler.getResponseHandler()
-		err = responseHandler(packet, handler.dbConnection, handler.clientConnection)
+		err = responseHandler(packet, handler.dbReadConnection(), handler.clientConnection)
 		if err != nil {
 			handler.resetQueryHandler()
 			handler.logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorResponseConnectorCantWriteToServer).
 				Errorln("Error in responseHandler")
+			handler.notifyDBConnectionLost(err)
 			errCh <- err
 			return
 		}
+		handler.markResponse(packet.GetSequenceNumber())
+		if startReadAhead {
+			handler.startDBReadAhead()
+		}
 		timer.ObserveDuration()
 	}
 }
@@ -0,0 +1,39 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"testing"
+)
+
+func TestNotifyStartupFinished(t *testing.T) {
+	calls := 0
+	handler := &MysqlHandler{}
+	handler.notifyStartupFinished(newTestPacket(2, []byte{OkPacket, 0, 0, 2, 0, 0, 0}))
+	handler.SetStartupCallback(func() { calls++ })
+	// auth switch request and auth more data don't finish connection phase
+	handler.notifyStartupFinished(newTestPacket(2, append([]byte{EOFPacket}, "mysql_native_password"...)))
+	handler.notifyStartupFinished(newTestPacket(4, []byte{0x01, 0x03}))
+	if calls != 0 {
+		t.Fatal("Callback shouldn't be called before OK packet")
+	}
+	handler.notifyStartupFinished(newTestPacket(6, []byte{OkPacket, 0, 0, 2, 0, 0, 0}))
+	handler.notifyStartupFinished(newTestPacket(1, []byte{OkPacket, 0, 0, 2, 0, 0, 0}))
+	if calls != 1 {
+		t.Fatalf("Expected one call of callback, took %d", calls)
+	}
+}
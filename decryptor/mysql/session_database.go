@@ -0,0 +1,85 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// useStatementRegexp matches USE statement with quoted or unquoted name of database
+var useStatementRegexp = regexp.MustCompile("(?i)^\\s*use\\s+(?:`((?:[^`]|``)+)`|([a-z0-9_$]+))\\s*;?\\s*$")
+
+// parseUseStatement returns name of database selected by query and true if query is USE statement
+func parseUseStatement(query string) (string, bool) {
+	match := useStatementRegexp.FindStringSubmatch(query)
+	if match == nil {
+		return "", false
+	}
+	if match[1] != "" {
+		return strings.Replace(match[1], "``", "`", -1), true
+	}
+	return match[2], true
+}
+
+// sessionDatabase tracks current database of connection which AcraCensor uses to choose handlers. Database is changed
+// when client sends USE statement or COM_INIT_DB, so queries sent before response are checked in scope of new database,
+// and change is reverted if database returns error. Responses are read in other goroutine, so it's safe for concurrent use
+type sessionDatabase struct {
+	lock     sync.Mutex
+	current  string
+	previous *string
+}
+
+// Set sets database selected on connection
+func (database *sessionDatabase) Set(name string) {
+	database.lock.Lock()
+	database.current, database.previous = name, nil
+	database.lock.Unlock()
+}
+
+// Get returns current database
+func (database *sessionDatabase) Get() string {
+	database.lock.Lock()
+	defer database.lock.Unlock()
+	return database.current
+}
+
+// Change switches to database name until response to command is received
+func (database *sessionDatabase) Change(name string) {
+	database.lock.Lock()
+	defer database.lock.Unlock()
+	if database.previous == nil {
+		previous := database.current
+		database.previous = &previous
+	}
+	database.current = name
+}
+
+// OnResponse confirms or reverts pending change of database after successful or failed response
+func (database *sessionDatabase) OnResponse(failed bool) {
+	database.lock.Lock()
+	defer database.lock.Unlock()
+	if database.previous == nil {
+		return
+	}
+	if failed {
+		database.current = *database.previous
+	}
+	database.previous = nil
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"testing"
+)
+
+func TestParseUseStatement(t *testing.T) {
+	testcases := []struct {
+		query    string
+		database string
+		ok       bool
+	}{
+		{"USE payments", "payments", true},
+		{"  use payments ; ", "payments", true},
+		{"use `risky db`", "risky db", true},
+		{"use `a``b`", "a`b", true},
+		{"SELECT * FROM users", "", false},
+		{"use payments; drop table users", "", false},
+		{"user_table", "", false},
+	}
+	for _, testcase := range testcases {
+		database, ok := parseUseStatement(testcase.query)
+		if database != testcase.database || ok != testcase.ok {
+			t.Fatalf("Incorrect result for %q: %q, %v", testcase.query, database, ok)
+		}
+	}
+}
+
+func TestSessionDatabase(t *testing.T) {
+	database := &sessionDatabase{}
+	database.Set("analytics")
+	// following queries use new database before response
+	database.Change("payments")
+	if database.Get() != "payments" {
+		t.Fatalf("Expected changed database, took %q", database.Get())
+	}
+	database.OnResponse(true)
+	if database.Get() != "analytics" {
+		t.Fatalf("Expected reverted database after error, took %q", database.Get())
+	}
+	database.Change("payments")
+	database.OnResponse(false)
+	// responses without pending change don't change database
+	database.OnResponse(true)
+	if database.Get() != "payments" {
+		t.Fatalf("Expected confirmed database, took %q", database.Get())
+	}
+}
@@ -17,14 +17,14 @@ limitations under the License.
 package mysql
 
 import (
-	"errors"
+	"github.com/cossacklabs/acra/acraerrors"
 	"github.com/cossacklabs/acra/logging"
 	log "github.com/sirupsen/logrus"
 	"io"
 )
 
 // ErrMalformPacket if packet parsing failed
-var ErrMalformPacket = errors.New("malform packet error")
+var ErrMalformPacket = acraerrors.New(acraerrors.CodeMySQLMalformedPacket, "malform packet error")
 
 // LengthEncodedInt https://dev.mysql.com/doc/internals/en/integer.html#packet-Protocol::LengthEncodedInteger
 func LengthEncodedInt(data []byte) (num uint64, isNull bool, n int, err error) {
@@ -0,0 +1,67 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgresql
+
+import (
+	"bufio"
+	"encoding/binary"
+
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/network"
+	log "github.com/sirupsen/logrus"
+)
+
+// SQLSTATE codes of errors sent to client if connection to database failed
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	// connection_failure
+	ConnectionFailureCode = "08006"
+	// sqlclient_unable_to_establish_sqlconnection
+	UnableToConnectCode = "08001"
+)
+
+// NewPgFatalError returns ErrorResponse with FATAL severity after which client treats session as terminated
+func NewPgFatalError(code, message string) []byte {
+	output := []byte{'E', 0, 0, 0, 0}
+	output = append(output, 'S')
+	output = append(output, "FATAL"...)
+	output = append(output, 0, 'C')
+	output = append(output, code...)
+	output = append(output, 0, 'M')
+	output = append(output, message...)
+	output = append(output, 0, 0)
+	// length excludes type of message
+	binary.BigEndian.PutUint32(output[1:5], uint32(len(output)-1))
+	return output
+}
+
+// notifyDBConnectionLost sends FATAL error to client if database closed connection, so client gets error of protocol
+// instead of abruptly closed connection and may retry on other connection
+func notifyDBConnectionLost(err error, writer *bufio.Writer, logger *log.Entry) {
+	if !network.IsConnectionLost(err) {
+		return
+	}
+	logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorDBConnectionLost).
+		Warningln("Database closed connection, send error to client")
+	if _, err := writer.Write(NewPgFatalError(ConnectionFailureCode, "connection to database was lost")); err != nil {
+		logger.WithError(err).Debugln("Can't send error about lost connection to client")
+		return
+	}
+	if err := writer.Flush(); err != nil {
+		logger.WithError(err).Debugln("Can't send error about lost connection to client")
+	}
+}
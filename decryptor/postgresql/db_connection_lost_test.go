@@ -0,0 +1,42 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgresql
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestNotifyDBConnectionLost(t *testing.T) {
+	output := &bytes.Buffer{}
+	writer := bufio.NewWriter(output)
+	logger := log.NewEntry(log.StandardLogger())
+	notifyDBConnectionLost(errors.New("some error"), writer, logger)
+	if output.Len() != 0 {
+		t.Fatal("Error sent to client on connection which wasn't lost")
+	}
+	notifyDBConnectionLost(io.EOF, writer, logger)
+	expected := append([]byte{'E', 0, 0, 0, 52}, []byte("SFATAL\x00C08006\x00Mconnection to database was lost\x00\x00")...)
+	if !bytes.Equal(output.Bytes(), expected) {
+		t.Fatalf("Unexpected error message %q", output.Bytes())
+	}
+}
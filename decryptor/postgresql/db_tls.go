@@ -0,0 +1,240 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgresql
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/network"
+	log "github.com/sirupsen/logrus"
+)
+
+// DBTLSMode sets how AcraServer protects connections to database, values mirror sslmode of libpq
+// https://www.postgresql.org/docs/current/libpq-ssl.html#LIBPQ-SSL-PROTECTION
+type DBTLSMode string
+
+// Modes of TLS between AcraServer and database
+const (
+	// DBTLSModeClient switches connection to database to TLS only if client requests SSL and forwards its SSLRequest
+	DBTLSModeClient DBTLSMode = ""
+	// DBTLSModeDisable never uses TLS with database
+	DBTLSModeDisable DBTLSMode = "disable"
+	// DBTLSModeRequire always uses TLS with database without verification of its certificate
+	DBTLSModeRequire DBTLSMode = "require"
+	// DBTLSModeVerifyCA always uses TLS with database and verifies that its certificate is signed by trusted CA
+	DBTLSModeVerifyCA DBTLSMode = "verify-ca"
+	// DBTLSModeVerifyFull always uses TLS with database, verifies its certificate and that it's issued for server name
+	DBTLSModeVerifyFull DBTLSMode = "verify-full"
+)
+
+// Errors returned on negotiation of TLS with database
+var (
+	ErrUnknownDBTLSMode      = acraerrors.New(acraerrors.CodePostgreSQLUnknownDBTLSMode, "unknown db_tls_mode, expected disable, require, verify-ca or verify-full")
+	ErrUnexpectedSSLResponse = acraerrors.New(acraerrors.CodePostgreSQLUnexpectedSSLResponse, "database sent unexpected response to SSLRequest")
+)
+
+// sslRequestLength is length of SSLRequest packet: length itself and request code
+const sslRequestLength = 8
+
+// SSLAllowResponse is answer to SSLRequest which allows encryption
+var SSLAllowResponse = []byte{'S'}
+
+// ParseDBTLSMode returns mode by its name
+func ParseDBTLSMode(value string) (DBTLSMode, error) {
+	switch mode := DBTLSMode(value); mode {
+	case DBTLSModeClient, DBTLSModeDisable, DBTLSModeRequire, DBTLSModeVerifyCA, DBTLSModeVerifyFull:
+		return mode, nil
+	}
+	return DBTLSModeClient, ErrUnknownDBTLSMode
+}
+
+// Negotiated returns true if AcraServer negotiates TLS with database itself instead of forwarding client's SSLRequest
+func (mode DBTLSMode) Negotiated() bool {
+	return mode != DBTLSModeClient
+}
+
+// RequiresTLS returns true if connection to database is refused when database doesn't support TLS
+func (mode DBTLSMode) RequiresTLS() bool {
+	return mode == DBTLSModeRequire || mode == DBTLSModeVerifyCA || mode == DBTLSModeVerifyFull
+}
+
+// NewDBTLSConfig returns copy of config which verifies certificate of database according to mode. Certificates of
+// config are presented to database if it requests client certificate
+func NewDBTLSConfig(mode DBTLSMode, config *tls.Config) *tls.Config {
+	dbConfig := config.Clone()
+	switch mode {
+	case DBTLSModeRequire:
+		dbConfig.InsecureSkipVerify = true
+	case DBTLSModeVerifyCA:
+		// standard verification always checks server name so chain is verified manually
+		dbConfig.InsecureSkipVerify = true
+		dbConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyCertificateChain(rawCerts, dbConfig.RootCAs)
+		}
+	case DBTLSModeVerifyFull:
+		dbConfig.InsecureSkipVerify = false
+	}
+	return dbConfig
+}
+
+// verifyCertificateChain verifies that first certificate is signed by one of roots through other certificates
+func verifyCertificateChain(rawCerts [][]byte, roots *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return x509.CertificateInvalidError{Reason: x509.NotAuthorizedToSign}
+	}
+	certificates := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		certificate, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certificates = append(certificates, certificate)
+	}
+	intermediates := x509.NewCertPool()
+	for _, certificate := range certificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+	_, err := certificates[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+	return err
+}
+
+// newSSLRequest returns SSLRequest packet
+func newSSLRequest() []byte {
+	packet := make([]byte, sslRequestLength)
+	binary.BigEndian.PutUint32(packet[:4], sslRequestLength)
+	binary.BigEndian.PutUint32(packet[4:], sslRequestCode)
+	return packet
+}
+
+// NegotiateTLS switches connections to TLS when AcraServer negotiates TLS with database itself: sends SSLRequest to
+// database and answers SSLRequest of client. Client gets error if database denies SSL required by mode. Connections
+// have deadline until negotiation finishes, zero deadline means no limit
+func NegotiateTLS(clientConnection, dbConnection net.Conn, mode DBTLSMode, clientConfig, dbConfig *tls.Config, deadline time.Time, logger *log.Entry) (net.Conn, net.Conn, error) {
+	for _, connection := range []net.Conn{clientConnection, dbConnection} {
+		if err := connection.SetDeadline(deadline); err != nil {
+			return nil, nil, err
+		}
+	}
+	dbTLSConnection, err := negotiateDBTLS(dbConnection, mode, dbConfig, logger)
+	if err != nil {
+		if err == ErrDBDeniedSSL {
+			refuseDeniedSSL(clientConnection, logger)
+		}
+		return nil, nil, err
+	}
+	clientTLSConnection, err := acceptClientSSL(clientConnection, clientConfig, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, connection := range []net.Conn{clientConnection, dbConnection} {
+		if err := connection.SetDeadline(time.Time{}); err != nil {
+			return nil, nil, err
+		}
+	}
+	return clientTLSConnection, dbTLSConnection, nil
+}
+
+// negotiateDBTLS sends SSLRequest to database and switches connection to TLS if database allows it. Connection is
+// returned as is if mode disables TLS or database denies it and mode doesn't require TLS
+func negotiateDBTLS(dbConnection net.Conn, mode DBTLSMode, config *tls.Config, logger *log.Entry) (net.Conn, error) {
+	if mode == DBTLSModeDisable {
+		return dbConnection, nil
+	}
+	if _, err := dbConnection.Write(newSSLRequest()); err != nil {
+		return nil, err
+	}
+	response := make([]byte, 1)
+	if _, err := dbConnection.Read(response); err != nil {
+		return nil, err
+	}
+	switch response[0] {
+	case SSLAllowResponse[0]:
+		tlsConnection := tls.Client(dbConnection, NewDBTLSConfig(mode, config))
+		if err := tlsConnection.Handshake(); err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTLSHandshakeFailed).
+				Errorln("Can't initialize tls connection with db")
+			return nil, err
+		}
+		logger.WithField("db_tls_mode", mode).Debugln("Connection to database switched to TLS")
+		return tlsConnection, nil
+	case SSLDenyResponse[0]:
+		if mode.RequiresTLS() {
+			return nil, ErrDBDeniedSSL
+		}
+		logger.Debugln("Database denied SSL, continue without TLS")
+		return dbConnection, nil
+	}
+	return nil, ErrUnexpectedSSLResponse
+}
+
+// acceptClientSSL answers SSLRequest of client when AcraServer negotiates TLS with database itself, so client's
+// request isn't forwarded. Client's connection is switched to TLS if config has certificate, otherwise SSL is denied.
+// GSSENCRequest is denied. Returned connection replays data read after SSLRequest, like StartupMessage
+func acceptClientSSL(clientConnection net.Conn, config *tls.Config, logger *log.Entry) (net.Conn, error) {
+	reader := bufio.NewReader(clientConnection)
+	for {
+		header, err := reader.Peek(sslRequestLength)
+		if err != nil {
+			return nil, err
+		}
+		if binary.BigEndian.Uint32(header[:4]) != sslRequestLength {
+			break
+		}
+		code := binary.BigEndian.Uint32(header[4:])
+		if code != sslRequestCode && code != gssEncryptRequestCode {
+			break
+		}
+		if _, err := reader.Discard(sslRequestLength); err != nil {
+			return nil, err
+		}
+		if code == gssEncryptRequestCode || !network.HasServerCertificate(config) {
+			logger.Debugln("Deny client's encryption request")
+			if _, err := clientConnection.Write(SSLDenyResponse); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if _, err := clientConnection.Write(SSLAllowResponse); err != nil {
+			return nil, err
+		}
+		tlsConnection := tls.Server(&bufferedConnection{Conn: clientConnection, reader: reader}, config)
+		if err := tlsConnection.Handshake(); err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTLSHandshakeFailed).
+				Errorln("Can't initialize tls connection with client")
+			return nil, err
+		}
+		return tlsConnection, nil
+	}
+	return &bufferedConnection{Conn: clientConnection, reader: reader}, nil
+}
+
+// bufferedConnection returns data buffered during negotiation before next data from connection
+type bufferedConnection struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (conn *bufferedConnection) Read(b []byte) (int, error) {
+	return conn.reader.Read(b)
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgresql
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/utils/testutils"
+	log "github.com/sirupsen/logrus"
+)
+
+// newTestRequest returns packet of 8 bytes with code like SSLRequest or StartupMessage without parameters
+func newTestRequest(code uint32) []byte {
+	packet := make([]byte, 8)
+	binary.BigEndian.PutUint32(packet, 8)
+	binary.BigEndian.PutUint32(packet[4:], code)
+	return packet
+}
+
+func TestParseDBTLSMode(t *testing.T) {
+	for _, value := range []string{"", "disable", "require", "verify-ca", "verify-full"} {
+		mode, err := ParseDBTLSMode(value)
+		if err != nil || string(mode) != value {
+			t.Fatalf("Can't parse '%s': %v", value, err)
+		}
+	}
+	for _, value := range []string{"prefer", "allow", "VERIFY-FULL"} {
+		if _, err := ParseDBTLSMode(value); err != ErrUnknownDBTLSMode {
+			t.Fatalf("Expected ErrUnknownDBTLSMode for '%s', took %v", value, err)
+		}
+	}
+}
+
+// serveTestSSLRequest answers SSLRequest with response and runs TLS handshake if SSL is allowed, then reads startup packet
+func serveTestSSLRequest(connection net.Conn, response byte, certificate tls.Certificate) ([]byte, error) {
+	request := make([]byte, sslRequestLength)
+	if _, err := io.ReadFull(connection, request); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(request, newSSLRequest()) {
+		return nil, ErrUnexpectedSSLResponse
+	}
+	if _, err := connection.Write([]byte{response}); err != nil {
+		return nil, err
+	}
+	if response == SSLAllowResponse[0] {
+		connection = tls.Server(connection, &tls.Config{Certificates: []tls.Certificate{certificate}})
+	}
+	startup := make([]byte, 8)
+	_, err := io.ReadFull(connection, startup)
+	return startup, err
+}
+
+func TestNegotiateTLS(t *testing.T) {
+	logger := log.NewEntry(log.StandardLogger())
+	certificate, pool := testutils.NewTLSCertificate(t)
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{certificate}}
+	startup := newTestRequest(3 << 16)
+	for _, testCase := range []struct {
+		mode       DBTLSMode
+		serverName string
+		success    bool
+	}{
+		{DBTLSModeRequire, "example.com", true},
+		{DBTLSModeVerifyCA, "example.com", true},
+		{DBTLSModeVerifyFull, "localhost", true},
+		{DBTLSModeVerifyFull, "example.com", false},
+	} {
+		client, clientSide := net.Pipe()
+		db, dbSide := net.Pipe()
+		dbResult := make(chan []byte, 1)
+		go func() {
+			data, _ := serveTestSSLRequest(db, SSLAllowResponse[0], certificate)
+			dbResult <- data
+		}()
+		clientResult := make(chan error, 1)
+		go func() {
+			// GSSAPI encryption is denied, then SSL is accepted
+			response := make([]byte, 1)
+			client.Write(newTestRequest(gssEncryptRequestCode))
+			if _, err := io.ReadFull(client, response); err != nil || response[0] != SSLDenyResponse[0] {
+				clientResult <- ErrUnexpectedSSLResponse
+				return
+			}
+			client.Write(newSSLRequest())
+			if _, err := io.ReadFull(client, response); err != nil || response[0] != SSLAllowResponse[0] {
+				clientResult <- ErrUnexpectedSSLResponse
+				return
+			}
+			tlsClient := tls.Client(client, &tls.Config{RootCAs: pool, ServerName: "localhost"})
+			_, err := tlsClient.Write(startup)
+			clientResult <- err
+		}()
+		dbConfig := &tls.Config{RootCAs: pool, ServerName: testCase.serverName}
+		clientConnection, dbConnection, err := NegotiateTLS(clientSide, dbSide, testCase.mode, serverConfig, dbConfig, time.Now().Add(time.Second), logger)
+		if !testCase.success {
+			if err == nil {
+				t.Fatalf("Expected error of verification for %s with server name %s", testCase.mode, testCase.serverName)
+			}
+			client.Close()
+			db.Close()
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Can't negotiate TLS in %s mode: %v", testCase.mode, err)
+		}
+		if _, ok := clientConnection.(*tls.Conn); !ok {
+			t.Fatal("Client's connection isn't switched to TLS")
+		}
+		// startup packet sent by client is forwarded to database over TLS
+		received := make([]byte, len(startup))
+		if _, err := io.ReadFull(clientConnection, received); err != nil || !bytes.Equal(received, startup) {
+			t.Fatalf("Unexpected startup packet from client %v: %v", received, err)
+		}
+		if err := <-clientResult; err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dbConnection.Write(received); err != nil {
+			t.Fatal(err)
+		}
+		if data := <-dbResult; !bytes.Equal(data, startup) {
+			t.Fatalf("Unexpected startup packet on database side %v", data)
+		}
+		// close peers first, otherwise close_notify blocks on unbuffered pipes
+		client.Close()
+		db.Close()
+		clientConnection.Close()
+		dbConnection.Close()
+	}
+}
+
+func TestNegotiateTLSDeniedByDB(t *testing.T) {
+	logger := log.NewEntry(log.StandardLogger())
+	for _, mode := range []DBTLSMode{DBTLSModeRequire, DBTLSModeVerifyFull} {
+		client, clientSide := net.Pipe()
+		db, dbSide := net.Pipe()
+		go serveTestSSLRequest(db, SSLDenyResponse[0], tls.Certificate{})
+		responseCh := make(chan []byte, 1)
+		go func() {
+			response, _ := ioutil.ReadAll(client)
+			responseCh <- response
+		}()
+		_, _, err := NegotiateTLS(clientSide, dbSide, mode, nil, &tls.Config{}, time.Time{}, logger)
+		if err != ErrDBDeniedSSL {
+			t.Fatalf("Expected ErrDBDeniedSSL in %s mode, took %v", mode, err)
+		}
+		clientSide.Close()
+		dbSide.Close()
+		if response := <-responseCh; len(response) == 0 || response[0] != 'E' {
+			t.Fatalf("Client didn't get error, took %q", response)
+		}
+	}
+}
+
+func TestNegotiateTLSDisabled(t *testing.T) {
+	logger := log.NewEntry(log.StandardLogger())
+	client, clientSide := net.Pipe()
+	_, dbSide := net.Pipe()
+	defer client.Close()
+	defer dbSide.Close()
+	startup := newTestRequest(3 << 16)
+	go func() {
+		response := make([]byte, 1)
+		client.Write(newSSLRequest())
+		io.ReadFull(client, response)
+		client.Write(startup)
+	}()
+	// without certificate client's SSLRequest is denied and nothing is sent to database
+	clientConnection, dbConnection, err := NegotiateTLS(clientSide, dbSide, DBTLSModeDisable, nil, nil, time.Time{}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dbConnection != dbSide {
+		t.Fatal("Connection to database was changed in disable mode")
+	}
+	received := make([]byte, len(startup))
+	if _, err := io.ReadFull(clientConnection, received); err != nil || !bytes.Equal(received, startup) {
+		t.Fatalf("Unexpected startup packet %v: %v", received, err)
+	}
+	if binary.BigEndian.Uint32(received[4:]) != 3<<16 {
+		t.Fatal("Unexpected protocol version")
+	}
+}
@@ -0,0 +1,33 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgresql
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// scanDataRow scans values of parsed data row for sensitive data before decryption, so only plaintext values are
+// reported. PostgreSQL doesn't send names of tables with result so detections are reported without table
+func (proxy *PgProxy) scanDataRow(packetHandler *PacketHandler, logger *log.Entry) {
+	for i := 0; i < packetHandler.columnCount; i++ {
+		column := ""
+		if i < len(proxy.resultColumns) {
+			column = proxy.resultColumns[i]
+		}
+		proxy.dlpSampler.Scan(packetHandler.Columns[i].Data, "", column, logger)
+	}
+}
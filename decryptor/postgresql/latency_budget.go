@@ -0,0 +1,72 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgresql
+
+import (
+	"encoding/binary"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	log "github.com/sirupsen/logrus"
+)
+
+// WarningCode is SQLSTATE of warning sent with NoticeResponse
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+const WarningCode = "01000"
+
+// LatencyBudgetNotice is message of notice sent to client before first row of result with encrypted values of
+// low-sensitivity columns
+const LatencyBudgetNotice = "decryption latency budget exceeded, values of low-sensitivity columns are returned encrypted"
+
+// NewPgNotice returns NoticeResponse packet with WARNING severity. Notices may be sent at any time, so clients accept
+// them between data rows of result
+func NewPgNotice(code, message string) []byte {
+	output := []byte{'N', 0, 0, 0, 0}
+	output = append(output, 'S')
+	output = append(output, "WARNING"...)
+	output = append(output, 0, 'C')
+	output = append(output, code...)
+	output = append(output, 0, 'M')
+	output = append(output, message...)
+	output = append(output, 0, 0)
+	// length excludes type of message
+	binary.BigEndian.PutUint32(output[1:5], uint32(len(output)-1))
+	return output
+}
+
+// serveCiphertext returns true if value of column with index should be left encrypted because decryption queue latency
+// exceeded budget and column is low-sensitivity. Client gets notice once per result before data row with such values
+func (proxy *PgProxy) serveCiphertext(packetHandler *PacketHandler, index int, logger *log.Entry) bool {
+	if index >= len(proxy.resultColumns) {
+		return false
+	}
+	column := proxy.resultColumns[index]
+	// PostgreSQL doesn't send names of tables with result so only columns configured without table match
+	if !base.GetLatencyBudget().ServeCiphertext("", column) {
+		return false
+	}
+	logger.WithField("column", column).Debugln("Leave value of low-sensitivity column encrypted, decryption latency budget exceeded")
+	if proxy.latencyBudgetNoticeSent {
+		return true
+	}
+	// notice is flushed together with data row
+	if _, err := packetHandler.writer.Write(NewPgNotice(WarningCode, LatencyBudgetNotice)); err != nil {
+		logger.WithError(err).Debugln("Can't send notice about encrypted values to client")
+		return true
+	}
+	proxy.latencyBudgetNoticeSent = true
+	return true
+}
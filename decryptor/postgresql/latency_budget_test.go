@@ -0,0 +1,68 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgresql
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestServeCiphertext(t *testing.T) {
+	output := &bytes.Buffer{}
+	writer := bufio.NewWriter(output)
+	packetHandler := &PacketHandler{writer: writer}
+	proxy := &PgProxy{resultColumns: []string{"id", "comment"}}
+	logger := log.NewEntry(log.StandardLogger())
+	if proxy.serveCiphertext(packetHandler, 1, logger) {
+		t.Fatal("Value left encrypted without latency budget")
+	}
+
+	budget, err := base.NewLatencyBudget(time.Millisecond, time.Minute, "users.id,comment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base.SetLatencyBudget(budget)
+	defer base.SetLatencyBudget(nil)
+	if proxy.serveCiphertext(packetHandler, 1, logger) {
+		t.Fatal("Value left encrypted by closed latency budget")
+	}
+	budget.Observe(time.Second)
+	// id configured with table which PostgreSQL doesn't send, column out of result
+	if proxy.serveCiphertext(packetHandler, 0, logger) || proxy.serveCiphertext(packetHandler, 2, logger) {
+		t.Fatal("Value of column which isn't low-sensitivity left encrypted")
+	}
+	for i := 0; i < 2; i++ {
+		if !proxy.serveCiphertext(packetHandler, 1, logger) {
+			t.Fatal("Value of low-sensitivity column decrypted by open latency budget")
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	expected := NewPgNotice(WarningCode, LatencyBudgetNotice)
+	if !bytes.Equal(output.Bytes(), expected) {
+		t.Fatalf("Expected one notice, took %q", output.Bytes())
+	}
+	if expected[0] != 'N' || int(expected[4])+1 != len(expected) {
+		t.Fatalf("Malformed notice %q", expected)
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgresql
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// auditDataRowLengths checks that packet length and lengths of columns of DataRow packet match its data
+// https://www.postgresql.org/docs/current/protocol-message-formats.html
+func auditDataRowLengths(packetLength, data []byte) error {
+	declared := int(binary.BigEndian.Uint32(packetLength)) - len(packetLength)
+	if declared != len(data) {
+		return fmt.Errorf("packet length %d doesn't match length of data %d", declared, len(data))
+	}
+	if len(data) < 2 {
+		return fmt.Errorf("packet length %d is less than size of column count", len(data))
+	}
+	columnCount := int(binary.BigEndian.Uint16(data[:2]))
+	pos := 2
+	for i := 0; i < columnCount; i++ {
+		if len(data)-pos < 4 {
+			return fmt.Errorf("length of column %d is out of packet", i)
+		}
+		length := int32(binary.BigEndian.Uint32(data[pos : pos+4]))
+		pos += 4
+		if length == NullColumnValue {
+			continue
+		}
+		if length < 0 || int(length) > len(data)-pos {
+			return fmt.Errorf("length of column %d is %d but only %d bytes left in packet", i, length, len(data)-pos)
+		}
+		pos += int(length)
+	}
+	if pos != len(data) {
+		return fmt.Errorf("%d bytes left in packet after %d columns", len(data)-pos, columnCount)
+	}
+	return nil
+}
+
+// dataRowSnapshot is copy of DataRow packet as it was received from database
+type dataRowSnapshot struct {
+	packetLength []byte
+	data         []byte
+}
+
+// snapshot returns copy of packet's length and data which may be restored if rewritten packet is malformed
+func (packet *PacketHandler) snapshot() *dataRowSnapshot {
+	return &dataRowSnapshot{
+		packetLength: append([]byte{}, packet.descriptionLengthBuf...),
+		data:         append([]byte{}, packet.descriptionBuf.Bytes()...),
+	}
+}
+
+// restore replaces packet's length and data with snapshot
+func (packet *PacketHandler) restore(snapshot *dataRowSnapshot) {
+	copy(packet.descriptionLengthBuf, snapshot.packetLength)
+	packet.descriptionBuf.Reset()
+	packet.descriptionBuf.Write(snapshot.data)
+	packet.dataLength = len(snapshot.data)
+}
+
+// auditLengths checks that lengths of rewritten DataRow packet match its data
+func (packet *PacketHandler) auditLengths() error {
+	return auditDataRowLengths(packet.descriptionLengthBuf, packet.descriptionBuf.Bytes())
+}
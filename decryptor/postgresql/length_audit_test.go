@@ -0,0 +1,83 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgresql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// dataRow returns length and data of DataRow packet with columns, nil column is NULL
+func dataRow(columns ...[]byte) ([]byte, []byte) {
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, uint16(len(columns)))
+	for _, column := range columns {
+		length := make([]byte, 4)
+		if column == nil {
+			binary.BigEndian.PutUint32(length, uint32(0xffffffff))
+		} else {
+			binary.BigEndian.PutUint32(length, uint32(len(column)))
+		}
+		data = append(append(data, length...), column...)
+	}
+	packetLength := make([]byte, 4)
+	binary.BigEndian.PutUint32(packetLength, uint32(len(data)+4))
+	return packetLength, data
+}
+
+func TestLengthAuditOfRewrittenDataRow(t *testing.T) {
+	packetLength, data := dataRow([]byte("encrypted value"), nil, []byte("id"))
+	packet := &PacketHandler{descriptionLengthBuf: packetLength, descriptionBuf: bytes.NewBuffer(data)}
+	if err := packet.auditLengths(); err != nil {
+		t.Fatalf("Unexpected mismatch in original packet: %s", err)
+	}
+	original := packet.snapshot()
+	if err := packet.parseColumns(); err != nil {
+		t.Fatal(err)
+	}
+	packet.Columns[0].SetData([]byte("value"))
+	packet.updateDataFromColumns()
+	if err := packet.auditLengths(); err != nil {
+		t.Fatalf("Unexpected mismatch in rewritten packet with NULL column: %s", err)
+	}
+
+	packet.Columns[2].LengthBuf[3]++
+	packet.Columns[0].changed = true
+	packet.updateDataFromColumns()
+	if err := packet.auditLengths(); err == nil {
+		t.Fatal("Expected mismatch of column length")
+	}
+	packet.restore(original)
+	if err := packet.auditLengths(); err != nil || !bytes.Equal(packet.descriptionBuf.Bytes(), data) {
+		t.Fatalf("Packet wasn't restored, %v", err)
+	}
+}
+
+func TestAuditDataRowLengths(t *testing.T) {
+	packetLength, data := dataRow([]byte("value"), nil)
+	if err := auditDataRowLengths(packetLength, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := auditDataRowLengths(packetLength, data[:len(data)-1]); err == nil {
+		t.Error("Expected mismatch of packet length")
+	}
+	binary.BigEndian.PutUint32(packetLength, uint32(len(data)+5))
+	if err := auditDataRowLengths(packetLength, append(data, 0)); err == nil {
+		t.Error("Expected error on bytes left after columns")
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
-	"errors"
+	"github.com/cossacklabs/acra/acraerrors"
 	"github.com/cossacklabs/acra/decryptor/base"
 	"github.com/sirupsen/logrus"
 	"io"
@@ -63,7 +63,10 @@ func (packet *PacketHandler) updateDataFromColumns() {
 		// + 2 is column count buffer
 		newDataLength := packet.columnCount*4 + 2
 		for i := 0; i < packet.columnCount; i++ {
-			newDataLength += packet.Columns[i].Length()
+			// NULL columns have only length buffer without data
+			if length := packet.Columns[i].Length(); int32(length) != NullColumnValue {
+				newDataLength += length
+			}
 		}
 		packet.descriptionBuf.Reset()
 		packet.descriptionBuf.Grow(newDataLength)
@@ -188,6 +191,51 @@ func (packet *PacketHandler) parseColumns() error {
 	return nil
 }
 
+// rowDescriptionFieldSize is size of field's description after its name: table oid[4] + column number[2] +
+// type oid[4] + type size[2] + type modifier[4] + format code[2]
+const rowDescriptionFieldSize = 18
+
+// parseColumnNames returns names of fields from RowDescription packet
+// https://www.postgresql.org/docs/9.3/static/protocol-message-formats.html
+func (packet *PacketHandler) parseColumnNames() ([]string, error) {
+	fields, err := packet.parseRowDescription()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(fields))
+	for _, field := range fields {
+		names = append(names, field.Name)
+	}
+	return names, nil
+}
+
+// resultField is description of column of result from RowDescription
+type resultField struct {
+	Name string
+	// TableOID is object id of table which column is selected, 0 if field isn't column of table (expression)
+	TableOID uint32
+}
+
+// parseRowDescription returns fields of RowDescription packet
+func (packet *PacketHandler) parseRowDescription() ([]resultField, error) {
+	data := packet.descriptionBuf.Bytes()
+	if len(data) < 2 {
+		return nil, ErrShortRead
+	}
+	fieldCount := int(binary.BigEndian.Uint16(data[:2]))
+	data = data[2:]
+	fields := make([]resultField, 0, fieldCount)
+	for i := 0; i < fieldCount; i++ {
+		nameEnd := bytes.IndexByte(data, 0)
+		if nameEnd == -1 || len(data) < nameEnd+1+rowDescriptionFieldSize {
+			return nil, ErrShortRead
+		}
+		fields = append(fields, resultField{Name: string(data[:nameEnd]), TableOID: binary.BigEndian.Uint32(data[nameEnd+1:])})
+		data = data[nameEnd+1+rowDescriptionFieldSize:]
+	}
+	return fields, nil
+}
+
 // Reset state of handler
 func (packet *PacketHandler) Reset() {
 	packet.descriptionBuf.Reset()
@@ -210,18 +258,82 @@ func (packet *PacketHandler) IsDataRow() bool {
 	return packet.messageType[0] == DataRowMessageType
 }
 
+// IsRowDescription return true if packet has RowDescription type
+func (packet *PacketHandler) IsRowDescription() bool {
+	return packet.messageType[0] == RowDescriptionMessageType
+}
+
+// IsCommandComplete return true if packet has CommandComplete type
+func (packet *PacketHandler) IsCommandComplete() bool {
+	return packet.messageType[0] == CommandCompleteMessageType
+}
+
+// IsReadyForQuery return true if packet has ReadyForQuery type
+func (packet *PacketHandler) IsReadyForQuery() bool {
+	return packet.messageType[0] == ReadyForQueryMessageType
+}
+
+// IsExecute return true if packet has Execute type of extended query protocol
+func (packet *PacketHandler) IsExecute() bool {
+	return packet.messageType[0] == ExecuteMessageType
+}
+
+// IsParse return true if packet has Parse type of extended query protocol
+func (packet *PacketHandler) IsParse() bool {
+	return packet.messageType[0] == ParseMessageType
+}
+
+// IsSync return true if packet has Sync type of extended query protocol
+func (packet *PacketHandler) IsSync() bool {
+	return packet.messageType[0] == SyncMessageType
+}
+
+// IsFunctionCall return true if packet has FunctionCall type
+func (packet *PacketHandler) IsFunctionCall() bool {
+	return packet.messageType[0] == FunctionCallMessageType
+}
+
+// GetParseQuery returns query of Parse packet
+func (packet *PacketHandler) GetParseQuery() (string, error) {
+	// name of prepared statement and query are null terminated strings
+	data := packet.descriptionBuf.Bytes()
+	nameEnd := bytes.IndexByte(data, 0)
+	if nameEnd < 0 {
+		return "", ErrMalformedPacket
+	}
+	queryEnd := bytes.IndexByte(data[nameEnd+1:], 0)
+	if queryEnd < 0 {
+		return "", ErrMalformedPacket
+	}
+	return string(data[nameEnd+1 : nameEnd+1+queryEnd]), nil
+}
+
 // IsSimpleQuery return true if packet has SimpleQuery type
 func (packet *PacketHandler) IsSimpleQuery() bool {
 	return packet.messageType[0] == QueryMessageType
 }
 
+// ReplaceQuery replaces query of SimpleQuery packet with newQuery and updates packet length
+func (packet *PacketHandler) ReplaceQuery(newQuery string) {
+	packet.descriptionBuf.Reset()
+	packet.descriptionBuf.WriteString(newQuery)
+	// query is null terminated string
+	packet.descriptionBuf.WriteByte(0)
+	packet.dataLength = packet.descriptionBuf.Len()
+	binary.BigEndian.PutUint32(packet.descriptionLengthBuf, uint32(packet.dataLength+len(packet.descriptionLengthBuf)))
+}
+
 // ErrShortRead error during reading
-var ErrShortRead = errors.New("read less bytes than expected")
+var ErrShortRead = acraerrors.New(acraerrors.CodePostgreSQLShortRead, "read less bytes than expected")
+
+// ErrMalformedPacket returned if packet doesn't match format of its type
+var ErrMalformedPacket = acraerrors.New(acraerrors.CodePostgreSQLMalformedPacket, "malformed packet")
 
 // readData part of packet
 func (packet *PacketHandler) readData() error {
 	packet.logger.Debugln("Read data length")
-	n, err := packet.reader.Read(packet.descriptionLengthBuf)
+	// read may return less bytes if length is split between network packets
+	n, err := io.ReadFull(packet.reader, packet.descriptionLengthBuf)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,114 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgresql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/cossacklabs/acra/encryptor"
+	log "github.com/sirupsen/logrus"
+)
+
+// newTestRowDescription returns data of RowDescription with fields of bytea type
+func newTestRowDescription(fields []resultField) []byte {
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, uint16(len(fields)))
+	for i, field := range fields {
+		data = append(append(data, field.Name...), 0)
+		description := make([]byte, rowDescriptionFieldSize)
+		binary.BigEndian.PutUint32(description, field.TableOID)
+		if field.TableOID != 0 {
+			binary.BigEndian.PutUint16(description[4:], uint16(i+1))
+		}
+		binary.BigEndian.PutUint32(description[6:], 17)
+		binary.BigEndian.PutUint16(description[10:], 0xFFFF)
+		data = append(data, description...)
+	}
+	return data
+}
+
+func TestParseRowDescription(t *testing.T) {
+	fields := []resultField{{Name: "id", TableOID: 16384}, {Name: "?column?", TableOID: 0}, {Name: "secret", TableOID: 16384}}
+	data := newTestRowDescription(fields)
+	packetHandler := &PacketHandler{descriptionBuf: bytes.NewBuffer(data)}
+	parsed, err := packetHandler.parseRowDescription()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != len(fields) {
+		t.Fatalf("Expected %d fields, took %d", len(fields), len(parsed))
+	}
+	for i := range fields {
+		if parsed[i] != fields[i] {
+			t.Fatalf("Expected field %+v, took %+v", fields[i], parsed[i])
+		}
+	}
+	names, err := packetHandler.parseColumnNames()
+	if err != nil || len(names) != 3 || names[0] != "id" || names[1] != "?column?" || names[2] != "secret" {
+		t.Fatalf("Unexpected names %v: %v", names, err)
+	}
+	for _, malformed := range [][]byte{nil, {0, 1}, {0, 1, 'i', 'd'}, data[:len(data)-1]} {
+		packetHandler = &PacketHandler{descriptionBuf: bytes.NewBuffer(malformed)}
+		if _, err := packetHandler.parseColumnNames(); err != ErrShortRead {
+			t.Fatalf("Expected ErrShortRead for %v, took %v", malformed, err)
+		}
+	}
+}
+
+func TestUpdateScanColumns(t *testing.T) {
+	config, err := encryptor.LoadConfig([]byte("schemas:\n  - table: users\n    encrypted: [secret]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &PgProxy{encryptedColumns: config}
+	logger := log.NewEntry(log.StandardLogger())
+	testcases := []struct {
+		query    string
+		fields   []resultField
+		expected []bool
+	}{
+		{"SELECT id, secret FROM users", []resultField{{Name: "id", TableOID: 16384}, {Name: "secret", TableOID: 16384}}, []bool{false, true}},
+		// field without table is scanned
+		{"SELECT id, secret FROM users", []resultField{{Name: "id", TableOID: 0}, {Name: "secret", TableOID: 16384}}, []bool{true, true}},
+		// alias of encrypted column, all columns are scanned
+		{"SELECT id, secret AS s FROM users", []resultField{{Name: "id", TableOID: 16384}, {Name: "s", TableOID: 16384}}, nil},
+		{"SELECT id, upper(secret) FROM users", []resultField{{Name: "id", TableOID: 16384}, {Name: "upper", TableOID: 0}}, nil},
+	}
+	for i, testcase := range testcases {
+		proxy.requests.push(pendingRequest{namedColumns: proxy.hasNamedColumns(testcase.query)})
+		packetHandler := &PacketHandler{descriptionBuf: bytes.NewBuffer(newTestRowDescription(testcase.fields))}
+		packetHandler.messageType[0] = RowDescriptionMessageType
+		scanColumns := proxy.updateScanColumns(packetHandler, nil, logger)
+		proxy.requests.pop()
+		if len(scanColumns) != len(testcase.expected) || (testcase.expected == nil) != (scanColumns == nil) {
+			t.Fatalf("[%d] Expected %v, took %v", i, testcase.expected, scanColumns)
+		}
+		for j := range scanColumns {
+			if scanColumns[j] != testcase.expected[j] {
+				t.Fatalf("[%d] Expected %v, took %v", i, testcase.expected, scanColumns)
+			}
+		}
+	}
+	// columns of response without known request are scanned
+	packetHandler := &PacketHandler{descriptionBuf: bytes.NewBuffer(newTestRowDescription([]resultField{{Name: "id", TableOID: 16384}}))}
+	packetHandler.messageType[0] = RowDescriptionMessageType
+	if scanColumns := proxy.updateScanColumns(packetHandler, nil, logger); scanColumns != nil {
+		t.Fatalf("Expected all columns scanned, took %v", scanColumns)
+	}
+}
@@ -20,6 +20,7 @@ package postgresql
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/binary"
 	"errors"
@@ -29,6 +30,7 @@ import (
 	"github.com/cossacklabs/acra/acra-censor"
 	"github.com/cossacklabs/acra/acra-censor/handlers"
 	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/encryptor"
 	"github.com/cossacklabs/acra/logging"
 	"github.com/cossacklabs/acra/network"
 	"github.com/cossacklabs/acra/utils"
@@ -74,9 +76,16 @@ const (
 	// random chosen
 	OutputDefaultSize = 1024
 	// https://www.postgresql.org/docs/9.4/static/protocol-message-formats.html
-	DataRowMessageType byte = 'D'
-	QueryMessageType   byte = 'Q'
-	TLSTimeout              = time.Second * 2
+	DataRowMessageType         byte = 'D'
+	QueryMessageType           byte = 'Q'
+	RowDescriptionMessageType  byte = 'T'
+	CommandCompleteMessageType byte = 'C'
+	ReadyForQueryMessageType   byte = 'Z'
+	ExecuteMessageType         byte = 'E'
+	ParseMessageType           byte = 'P'
+	SyncMessageType            byte = 'S'
+	FunctionCallMessageType    byte = 'F'
+	TLSTimeout                      = time.Second * 2
 )
 
 // CancelRequest indicates beginning tag of Cancel request.
@@ -86,18 +95,148 @@ var CancelRequest = []byte{0x04, 0xd2, 0x16, 0x2e}
 type PgProxy struct {
 	clientConnection net.Conn
 	dbConnection     net.Conn
+	queryEncryptor   encryptor.QueryEncryptor
 	TLSCh            chan bool
+	// allowQueryDirectives enables processing of SQL comment directives for trusted clients
+	allowQueryDirectives bool
+	// zoneResolver infers zone from query if zone ids aren't stored with data
+	zoneResolver base.QueryZoneResolver
+	// passthroughTables used to forward queries to tables without encrypted data as is
+	passthroughTables *base.PassthroughTables
+	// encryptedColumns restricts scanning for AcraStructs to configured columns if not nil
+	encryptedColumns base.EncryptedColumns
+	// connectionStats accumulates counters of client's connection, may be nil
+	connectionStats *base.ConnectionStats
+	// sessionZone is zone of client's session applied to queries without zone, may be nil
+	sessionZone *base.SessionZone
+	// dbReadPipelineSize is count of chunks read from database ahead while rows are decrypted, 0 turns off read ahead
+	dbReadPipelineSize int
+	// dbReadSpill configures temporary files for data read ahead after pipeline is full, nil turns off spilling
+	dbReadSpill *network.SpillConfig
+	// requests forwarded to database which directives and names of result columns are applied to their responses
+	requests requestQueue
+	// deterministic decrypts values of deterministic columns, may be nil
+	deterministic *encryptor.DeterministicEncryptor
+	// lengthAudit enables check of lengths of rewritten data rows before they are sent to client
+	lengthAudit bool
+	// dlpSampler scans sampled rows for sensitive data stored in plaintext, may be nil
+	dlpSampler *base.DLPSampler
+	// resultColumns are names of columns of current result from RowDescription, tracked only for DLP reports and
+	// latency budget
+	resultColumns []string
+	// latencyBudgetNoticeSent is true if client got notice about encrypted values of current result
+	latencyBudgetNoticeSent bool
+	// requireSSL refuses connections which aren't switched to TLS before startup
+	requireSSL bool
+	// extendedQuery is query of first Parse after last Sync, used for statistics of statements
+	extendedQuery string
+	// extendedNamedColumns is true if all queries parsed after last Sync have columns named as columns of tables
+	extendedNamedColumns bool
+	// censorConnection describes client's connection for AcraCensor, filled from startup parameters
+	censorConnection acracensor.ConnectionInfo
+	// onStartupFinished is called once when database is ready for first query after startup, may be nil
+	onStartupFinished func()
+	logger            *log.Entry
 }
 
-// NewPgProxy returns new PgProxy
-func NewPgProxy(clientConnection, dbConnection net.Conn) (*PgProxy, error) {
-	return &PgProxy{clientConnection: clientConnection, dbConnection: dbConnection, TLSCh: make(chan bool)}, nil
+// NewPgProxy returns new PgProxy. queryEncryptor may be nil if queries shouldn't be changed
+func NewPgProxy(clientConnection, dbConnection net.Conn, queryEncryptor encryptor.QueryEncryptor) (*PgProxy, error) {
+	return &PgProxy{clientConnection: clientConnection, dbConnection: dbConnection, queryEncryptor: queryEncryptor, TLSCh: make(chan bool),
+		logger: log.NewEntry(log.StandardLogger())}, nil
+}
+
+// SetLogger sets logger of client's connection used by proxy instead of standard logger
+func (proxy *PgProxy) SetLogger(logger *log.Entry) {
+	proxy.logger = logger
+}
+
+// AllowQueryDirectives turns on or off processing of SQL comment directives which override zone and decryption per query
+func (proxy *PgProxy) AllowQueryDirectives(allow bool) {
+	proxy.allowQueryDirectives = allow
+}
+
+// SetQueryZoneResolver sets resolver used to infer zone from query. nil turns off inferring
+func (proxy *PgProxy) SetQueryZoneResolver(resolver base.QueryZoneResolver) {
+	proxy.zoneResolver = resolver
+}
+
+// SetPassthroughTables sets tables which queries are forwarded without AcraCensor checks and which results aren't
+// decrypted. nil turns off passthrough
+func (proxy *PgProxy) SetPassthroughTables(tables *base.PassthroughTables) {
+	proxy.passthroughTables = tables
+}
+
+// SetEncryptedColumns sets configuration of columns which may contain AcraStructs, other columns of results won't be
+// scanned. nil turns off restriction
+func (proxy *PgProxy) SetEncryptedColumns(columns base.EncryptedColumns) {
+	proxy.encryptedColumns = columns
+}
+
+// SetDBReadPipelineSize sets count of chunks which will be read from database in background while previous data
+// is decrypted. 0 turns off reading ahead
+func (proxy *PgProxy) SetDBReadPipelineSize(size int) {
+	proxy.dbReadPipelineSize = size
+}
+
+// SetDBReadSpill sets configuration of encrypted temporary files where data read ahead from database is kept after
+// pipeline is full. nil turns off spilling
+func (proxy *PgProxy) SetDBReadSpill(config *network.SpillConfig) {
+	proxy.dbReadSpill = config
+}
+
+// SetConnectionStats sets counters of client's connection updated on queries, rows and decryptions
+func (proxy *PgProxy) SetConnectionStats(stats *base.ConnectionStats) {
+	proxy.connectionStats = stats
+}
+
+// SetSessionZone sets zone of client's session changed by session_zone directives and used for queries without zone
+func (proxy *PgProxy) SetSessionZone(session *base.SessionZone) {
+	proxy.sessionZone = session
+}
+
+// SetDeterministicEncryptor sets encryptor used to decrypt deterministically encrypted values of results. nil turns
+// off decryption of such values
+func (proxy *PgProxy) SetDeterministicEncryptor(deterministic *encryptor.DeterministicEncryptor) {
+	proxy.deterministic = deterministic
+}
+
+// SetLengthAudit enables check of lengths of each rewritten data row. Rows with mismatched lengths are logged and
+// sent to client as they were received from database
+func (proxy *PgProxy) SetLengthAudit(enable bool) {
+	proxy.lengthAudit = enable
+}
+
+// SetDLPSampler sets sampler which scans fraction of data rows for sensitive data stored in plaintext. nil turns off
+// scanning
+func (proxy *PgProxy) SetDLPSampler(sampler *base.DLPSampler) {
+	proxy.dlpSampler = sampler
+}
+
+// SetRequireSSL turns on refusing of clients which don't request SSL and databases which deny it, so data never goes
+// to database in plaintext
+func (proxy *PgProxy) SetRequireSSL(require bool) {
+	proxy.requireSSL = require
+}
+
+// SetStartupCallback sets function called once when database authenticates client and becomes ready for queries
+func (proxy *PgProxy) SetStartupCallback(callback func()) {
+	proxy.onStartupFinished = callback
+}
+
+// notifyStartupFinished calls startup callback on first ReadyForQuery which finishes startup phase
+// https://www.postgresql.org/docs/current/protocol-flow.html#PROTOCOL-FLOW-START-UP
+func (proxy *PgProxy) notifyStartupFinished(packetHandler *PacketHandler) {
+	if proxy.onStartupFinished == nil || !packetHandler.IsReadyForQuery() {
+		return
+	}
+	proxy.onStartupFinished()
+	proxy.onStartupFinished = nil
 }
 
 // PgProxyClientRequests checks every client request using AcraCensor,
 // if request is allowed, sends it to the Pg database
 func (proxy *PgProxy) PgProxyClientRequests(acraCensor acracensor.AcraCensorInterface, dbConnection, clientConnection net.Conn, errCh chan<- error) {
-	logger := log.WithField("proxy", "pg_client")
+	logger := proxy.logger.WithField("proxy", "pg_client")
 	logger.Debugln("Pg client proxy")
 	writer := bufio.NewWriter(dbConnection)
 
@@ -114,6 +253,7 @@ func (proxy *PgProxy) PgProxyClientRequests(acraCensor acracensor.AcraCensorInte
 	for {
 		timer := prometheus.NewTimer(prometheus.ObserverFunc(base.RequestProcessingTimeHistogram.WithLabelValues(prometheusLabels...).Observe))
 		packet.descriptionBuf.Reset()
+		startupPacket := firstPacket
 		if firstPacket {
 			// read only data block without message type
 			err = packet.readData()
@@ -127,7 +267,29 @@ func (proxy *PgProxy) PgProxyClientRequests(acraCensor acracensor.AcraCensorInte
 			errCh <- err
 			return
 		}
-		// we are interested only in requests that contains sql queries
+		if startupPacket {
+			answered, err := handleStartupPacket(packet.descriptionBuf.Bytes(), clientConnection, logger)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if answered {
+				firstPacket = true
+				timer.ObserveDuration()
+				continue
+			}
+			if err := proxy.checkSSLRequired(packet.descriptionBuf.Bytes(), clientConnection, logger); err != nil {
+				errCh <- err
+				return
+			}
+			logger = proxy.tagConnection(packet.descriptionBuf.Bytes(), logger)
+		}
+		if packet.IsSimpleQuery() || packet.IsExecute() {
+			proxy.connectionStats.AddQuery()
+		}
+		proxy.trackExtendedStatement(packet, logger)
+		// we are interested only in requests that contains sql queries. Encryptor doesn't process extended query protocol
+		// because parameters of statements are sent separately in Bind
 		if !packet.IsSimpleQuery() {
 			if err := packet.sendPacket(); err != nil {
 				logger.WithError(err).Errorln("Can't forward packet to db")
@@ -143,27 +305,27 @@ func (proxy *PgProxy) PgProxyClientRequests(acraCensor acracensor.AcraCensorInte
 		if logging.GetLogLevel() == logging.LOG_DEBUG {
 			_, queryWithHiddenValues, err := handlers.NormalizeAndRedactSQLQuery(query)
 			if err == handlers.ErrQuerySyntaxError {
-				log.WithError(err).Infof("Parsing error on query: %s", queryWithHiddenValues)
+				logger.WithError(err).Infof("Parsing error on query: %s", queryWithHiddenValues)
 			} else {
-				log.WithField("sql", queryWithHiddenValues).Debugln("New query")
+				logger.WithField("sql", queryWithHiddenValues).Debugln("New query")
 			}
 		}
 
-		if censorErr := acraCensor.HandleQuery(query); censorErr != nil {
-			logger.WithError(censorErr).Errorln("AcraCensor blocked query")
-			errorMessage, err := NewPgError("AcraCensor blocked this query")
-			if err != nil {
-				logger.WithError(err).Errorln("Can't create PostgreSQL error message")
-				errCh <- err
-				return
-			}
-			n, err := clientConnection.Write(errorMessage)
-			if err := base.CheckReadWrite(n, len(errorMessage), err); err != nil {
+		if proxy.passthroughTables.IsPassthrough(query) {
+			proxy.requests.push(pendingRequest{directives: &base.QueryDirectives{SkipDecryption: true}})
+			proxy.connectionStats.StartStatement(query)
+			if err := packet.sendPacket(); err != nil {
+				logger.WithError(err).Errorln("Can't send packet")
 				errCh <- err
 				return
 			}
-			n, err = clientConnection.Write(ReadyForQueryPacket)
-			if err := base.CheckReadWrite(n, len(ReadyForQueryPacket), err); err != nil {
+			timer.ObserveDuration()
+			continue
+		}
+
+		if censorErr := acraCensor.HandleConnectionQuery(proxy.censorConnection, query); censorErr != nil {
+			logger.WithError(censorErr).Errorln("AcraCensor blocked query")
+			if err := rejectQuery(clientConnection, "AcraCensor blocked this query", logger); err != nil {
 				errCh <- err
 				return
 			}
@@ -171,6 +333,40 @@ func (proxy *PgProxy) PgProxyClientRequests(acraCensor acracensor.AcraCensorInte
 			continue
 		}
 
+		directives := base.GetQueryDirectives(query, proxy.allowQueryDirectives, proxy.sessionZone, proxy.zoneResolver, logger)
+
+		if proxy.queryEncryptor != nil {
+			newQuery, changed, err := proxy.queryEncryptor.OnQuery(query)
+			if err != nil {
+				// query which encryptor can't process would write or compare plaintext values of encrypted columns
+				message := "AcraServer can't encrypt searchable columns in this query"
+				if err == encryptor.ErrExpiredKeyQuery {
+					logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorEncryptorQueryRejected).
+						Errorln("Encryptor rejected query")
+					message = "AcraServer refuses to encrypt with expired key, rotate key of client"
+				} else if _, ok := err.(*encryptor.RejectedQueryError); ok {
+					logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorEncryptorQueryRejected).
+						Errorln("Encryptor rejected query")
+					message = "AcraServer can't write encrypted columns consistently with this query"
+				} else {
+					logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorEncryptorCantProcessQuery).
+						Errorln("Can't process query with encryptor, query rejected")
+				}
+				if err := rejectQuery(clientConnection, message, logger); err != nil {
+					errCh <- err
+					return
+				}
+				timer.ObserveDuration()
+				continue
+			}
+			if changed {
+				packet.ReplaceQuery(newQuery)
+			}
+		}
+
+		proxy.connectionStats.StartStatement(query)
+		// response may be read before sendPacket returns
+		proxy.requests.push(pendingRequest{directives: directives, namedColumns: proxy.hasNamedColumns(query)})
 		if err := packet.sendPacket(); err != nil {
 			logger.WithError(err).Errorln("Can't send packet")
 			errCh <- err
@@ -180,16 +376,32 @@ func (proxy *PgProxy) PgProxyClientRequests(acraCensor acracensor.AcraCensorInte
 	}
 }
 
+// rejectQuery sends error with message and ReadyForQuery to client instead of forwarding query to the database
+func rejectQuery(clientConnection net.Conn, message string, logger *log.Entry) error {
+	errorMessage, err := NewPgError(message)
+	if err != nil {
+		logger.WithError(err).Errorln("Can't create PostgreSQL error message")
+		return err
+	}
+	n, err := clientConnection.Write(errorMessage)
+	if err := base.CheckReadWrite(n, len(errorMessage), err); err != nil {
+		return err
+	}
+	n, err = clientConnection.Write(ReadyForQueryPacket)
+	return base.CheckReadWrite(n, len(ReadyForQueryPacket), err)
+}
+
 // handlePoisonCheckResult return error err != nil, if can't check on poison record or any callback on poison record
 // return error
 func handlePoisonCheckResult(decryptor base.Decryptor, poisoned bool, err error) error {
 	if err != nil {
-		log.WithError(err).Errorln("Can't check on poison record")
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorDecryptorCantHandleRecognizedPoisonRecord).
+			Errorln("Can't check on poison record")
 		return err
 	}
 
 	if poisoned {
-		log.Warningln("Recognized poison record")
+		log.WithField(logging.FieldKeyEventCode, logging.EventCodePoisonRecordDetected).Warningln("Recognized poison record")
 		callbacks := decryptor.GetPoisonCallbackStorage()
 		if callbacks.HasCallbacks() {
 			return callbacks.Call()
@@ -238,9 +450,23 @@ func checkWholePoisonRecord(block []byte, decryptor base.Decryptor, logger *log.
 }
 
 // processWholeBlockDecryption try to decrypt data of column as whole AcraStruct and replace with decrypted data on success
-func (proxy *PgProxy) processWholeBlockDecryption(packet *PacketHandler, column *ColumnData, decryptor base.Decryptor, logger *log.Entry) error {
+func (proxy *PgProxy) processWholeBlockDecryption(ctx context.Context, packet *PacketHandler, column *ColumnData, decryptor base.Decryptor, logger *log.Entry) error {
+	if base.GetPoisonContainment().IsActive() {
+		logger.Debugln("Decryption suspended after detection of poison record, leave data as is")
+		return nil
+	}
+	limiter := base.GetDecryptionLimiter()
+	if err := limiter.AcquireContext(ctx); err != nil {
+		if err == ctx.Err() {
+			// connection canceled, stop processing
+			return err
+		}
+		logger.WithError(err).Warningln("Can't decrypt possible AcraStruct, limit of simultaneous decryptions exceeded")
+		return nil
+	}
 	decryptor.Reset()
 	decrypted, err := decryptor.DecryptBlock(column.Data)
+	limiter.Release()
 	if err != nil {
 		// check poison records on failed decryption
 		logger.WithError(err).Errorln("Can't decrypt possible AcraStruct")
@@ -299,7 +525,7 @@ func (proxy *PgProxy) handleSSLRequest(packet *PacketHandler, tlsConfig *tls.Con
 		return nil, nil, err
 	}
 	if err := tlsClientConnection.Handshake(); err != nil {
-		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorDecryptorCantInitializeTLS).
+		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTLSHandshakeFailed).
 			Errorln("Can't initialize tls connection with client")
 		return nil, nil, err
 	}
@@ -307,19 +533,24 @@ func (proxy *PgProxy) handleSSLRequest(packet *PacketHandler, tlsConfig *tls.Con
 	logger.Debugln("Init tls with db")
 	dbTLSConnection := tls.Client(dbConnection, tlsConfig)
 	if err := dbTLSConnection.Handshake(); err != nil {
-		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorDecryptorCantInitializeTLS).
+		logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTLSHandshakeFailed).
 			Errorln("Can't initialize tls connection with db")
 		return nil, nil, err
 	}
 	return tlsClientConnection, dbTLSConnection, nil
 }
 
-func (proxy *PgProxy) processInlineBlockDecryption(packet *PacketHandler, column *ColumnData, decryptor base.Decryptor, logger *log.Entry) error {
+func (proxy *PgProxy) processInlineBlockDecryption(ctx context.Context, packet *PacketHandler, column *ColumnData, decryptor base.Decryptor, logger *log.Entry) error {
+	if base.GetPoisonContainment().IsActive() {
+		logger.Debugln("Decryption suspended after detection of poison record, leave data as is")
+		return nil
+	}
 	// inline mode
 	currentIndex := 0
 	endIndex := column.Length()
 	outputBlock := bytes.NewBuffer(make([]byte, 0, column.Length()))
 	hasDecryptedData := false
+	limiter := base.GetDecryptionLimiter()
 	for {
 		// search AcraStruct's begin tags through all block of data and try to decrypt
 		beginTagIndex, tagLength := decryptor.BeginTagIndex(column.Data[currentIndex:endIndex])
@@ -337,7 +568,7 @@ func (proxy *PgProxy) processInlineBlockDecryption(packet *PacketHandler, column
 		if err != nil {
 			logger.WithError(err).Warningln("Can't read private key")
 			if decryptor.IsPoisonRecordCheckOn() {
-				log.Infoln("Check poison records")
+				logger.Infoln("Check poison records")
 				blockReader := bytes.NewReader(column.Data[beginTagIndex+tagLength:])
 				poisoned, err := decryptor.CheckPoisonRecord(blockReader)
 				err = handlePoisonCheckResult(decryptor, poisoned, err)
@@ -349,13 +580,24 @@ func (proxy *PgProxy) processInlineBlockDecryption(packet *PacketHandler, column
 			currentIndex++
 			continue
 		}
+		if err := limiter.AcquireContext(ctx); err != nil {
+			if err == ctx.Err() {
+				// connection canceled, stop processing
+				return err
+			}
+			logger.WithError(err).Warningln("Can't decrypt AcraStruct, limit of simultaneous decryptions exceeded")
+			// leave rest of data as is
+			outputBlock.Write(column.Data[currentIndex:])
+			break
+		}
 		blockReader := bytes.NewReader(column.Data[beginTagIndex+tagLength:])
-		symKey, _, err := decryptor.ReadSymmetricKey(key, blockReader)
+		symKey, err := base.ReadSymmetricKeyRotated(decryptor, key, blockReader)
 		if err != nil {
+			limiter.Release()
 			base.AcrastructDecryptionCounter.WithLabelValues(base.DecryptionTypeFail).Inc()
 			logger.WithError(err).Warningln("Can't unwrap symmetric key")
 			if decryptor.IsPoisonRecordCheckOn() {
-				log.Infoln("Check poison records")
+				logger.Infoln("Check poison records")
 				blockReader = bytes.NewReader(column.Data[beginTagIndex+tagLength:])
 				poisoned, err := decryptor.CheckPoisonRecord(blockReader)
 				err = handlePoisonCheckResult(decryptor, poisoned, err)
@@ -371,6 +613,7 @@ func (proxy *PgProxy) processInlineBlockDecryption(packet *PacketHandler, column
 			continue
 		}
 		decryptedData, err := decryptor.ReadData(symKey, decryptor.GetMatchedZoneID(), blockReader)
+		limiter.Release()
 		if err != nil {
 			base.AcrastructDecryptionCounter.WithLabelValues(base.DecryptionTypeFail).Inc()
 			logger.WithError(err).Warningln("Can't decrypt data with unwrapped symmetric key")
@@ -395,9 +638,57 @@ func (proxy *PgProxy) processInlineBlockDecryption(packet *PacketHandler, column
 	return nil
 }
 
-// PgDecryptStream process data rows from database
-func (proxy *PgProxy) PgDecryptStream(censor acracensor.AcraCensorInterface, decryptor base.Decryptor, tlsConfig *tls.Config, dbConnection net.Conn, clientConnection net.Conn, errCh chan<- error) {
-	logger := log.WithField("proxy", "db_side")
+// updateResultColumns remembers names of columns of result from RowDescription and forgets them after end of result
+func (proxy *PgProxy) updateResultColumns(packetHandler *PacketHandler, logger *log.Entry) {
+	switch {
+	case packetHandler.IsRowDescription():
+		names, err := packetHandler.parseColumnNames()
+		if err != nil {
+			logger.WithError(err).Debugln("Can't parse RowDescription, columns of result will be processed without names")
+			names = nil
+		}
+		proxy.resultColumns = names
+		proxy.latencyBudgetNoticeSent = false
+	case packetHandler.IsCommandComplete(), packetHandler.IsReadyForQuery():
+		proxy.resultColumns = nil
+		proxy.latencyBudgetNoticeSent = false
+	}
+}
+
+// hasNamedColumns returns true if columns of query's result may be skipped by their names
+func (proxy *PgProxy) hasNamedColumns(query string) bool {
+	return proxy.encryptedColumns != nil && base.HasNamedResultColumns(query)
+}
+
+// updateScanColumns returns columns of result which should be scanned for AcraStructs according to RowDescription
+// packet. Returns nil (scan all columns) after end of result, if packet can't be parsed or names of columns may differ
+// from names of table columns (aliases, expressions). Columns which don't belong to table are always scanned
+func (proxy *PgProxy) updateScanColumns(packetHandler *PacketHandler, scanColumns []bool, logger *log.Entry) []bool {
+	switch {
+	case packetHandler.IsRowDescription():
+		if !proxy.requests.current().namedColumns {
+			logger.Debugln("Names of result columns can't be matched with encrypted columns, all columns will be scanned")
+			return nil
+		}
+		fields, err := packetHandler.parseRowDescription()
+		if err != nil {
+			logger.WithError(err).Warningln("Can't parse RowDescription, all columns will be scanned")
+			return nil
+		}
+		scanColumns = make([]bool, len(fields))
+		for i, field := range fields {
+			scanColumns[i] = field.TableOID == 0 || proxy.encryptedColumns.IsEncryptedColumn("", field.Name)
+		}
+		return scanColumns
+	case packetHandler.IsCommandComplete(), packetHandler.IsReadyForQuery():
+		return nil
+	}
+	return scanColumns
+}
+
+// PgDecryptStream process data rows from database. Decryptions are stopped when ctx of connection is done
+func (proxy *PgProxy) PgDecryptStream(ctx context.Context, censor acracensor.AcraCensorInterface, decryptor base.Decryptor, tlsConfig *tls.Config, dbConnection net.Conn, clientConnection net.Conn, errCh chan<- error) {
+	logger := proxy.logger.WithField("proxy", "db_side")
 	if decryptor.IsWholeMatch() {
 		logger = logger.WithField("decrypt_mode", "wholecell")
 	} else {
@@ -420,6 +711,9 @@ func (proxy *PgProxy) PgDecryptStream(censor acracensor.AcraCensorInterface, dec
 	} else {
 		prometheusLabels = append(prometheusLabels, base.DecryptionModeInline)
 	}
+	// scanColumns marks columns of current result which may contain AcraStructs, nil means all columns
+	var scanColumns []bool
+	startupFinished := false
 	firstByte := true
 	for {
 		if firstByte {
@@ -430,10 +724,16 @@ func (proxy *PgProxy) PgDecryptStream(censor acracensor.AcraCensorInterface, dec
 			firstByte = false
 			if err := packetHandler.readMessageType(); err != nil {
 				logger.WithError(err).Errorln("Can't read first message type")
+				notifyDBConnectionLost(err, packetHandler.writer, logger)
 				errCh <- err
 				return
 			}
 			if packetHandler.IsSSLRequestDeny() {
+				if proxy.requireSSL {
+					refuseDeniedSSL(clientConnection, logger)
+					errCh <- ErrDBDeniedSSL
+					return
+				}
 				logger.Debugln("Deny ssl request")
 				if err := packetHandler.sendMessageType(); err != nil {
 					errCh <- err
@@ -463,6 +763,7 @@ func (proxy *PgProxy) PgDecryptStream(censor acracensor.AcraCensorInterface, dec
 			// if it is not ssl request than we just forward it to client
 			if err := packetHandler.readData(); err != nil {
 				logger.WithError(err).Errorln("Can't read data of packet")
+				notifyDBConnectionLost(err, packetHandler.writer, logger)
 				errCh <- err
 				return
 			}
@@ -471,17 +772,39 @@ func (proxy *PgProxy) PgDecryptStream(censor acracensor.AcraCensorInterface, dec
 				errCh <- err
 				return
 			}
+			// connection can't be switched to TLS after first packet so now database may be read in background
+			if proxy.dbReadPipelineSize > 0 {
+				prefetchReader := network.NewPrefetchReaderWithSpill(reader, proxy.dbReadPipelineSize, network.DefaultPrefetchChunkSize, proxy.dbReadSpill)
+				defer prefetchReader.Close()
+				packetHandler.reader = prefetchReader
+			}
 			timer.ObserveDuration()
 			continue
 		}
 		timer := prometheus.NewTimer(prometheus.ObserverFunc(base.ResponseProcessingTimeHistogram.WithLabelValues(prometheusLabels...).Observe))
 		if err := packetHandler.ReadPacket(); err != nil {
 			logger.WithError(err).Errorln("Can't read packet")
+			notifyDBConnectionLost(err, packetHandler.writer, logger)
 			errCh <- err
 			return
 		}
 
 		if !packetHandler.IsDataRow() {
+			if proxy.encryptedColumns != nil {
+				scanColumns = proxy.updateScanColumns(packetHandler, scanColumns, logger)
+			}
+			if proxy.dlpSampler != nil || base.GetLatencyBudget() != nil {
+				proxy.updateResultColumns(packetHandler, logger)
+			}
+			if packetHandler.IsReadyForQuery() {
+				proxy.connectionStats.EndStatement()
+				// first ReadyForQuery ends startup and doesn't answer any request
+				if startupFinished {
+					proxy.requests.pop()
+				}
+				startupFinished = true
+				proxy.notifyStartupFinished(packetHandler)
+			}
 			if err := packetHandler.sendPacket(); err != nil {
 				logger.WithError(err).Errorln("Can't forward packet")
 				errCh <- err
@@ -492,6 +815,18 @@ func (proxy *PgProxy) PgDecryptStream(censor acracensor.AcraCensorInterface, dec
 		}
 
 		logger.Debugln("Matched data row packet")
+		proxy.connectionStats.AddRow()
+		directives := proxy.requests.current().directives
+		if directives != nil && directives.SkipDecryption {
+			logger.Debugln("Skip decryption of data row by query directive")
+			if err := packetHandler.sendPacket(); err != nil {
+				logger.WithError(err).Errorln("Can't forward packet")
+				errCh <- err
+				return
+			}
+			timer.ObserveDuration()
+			continue
+		}
 		if err := packetHandler.parseColumns(); err != nil {
 			logger.WithError(err).Errorln("Can't parse columns in packet")
 			errCh <- err
@@ -508,13 +843,35 @@ func (proxy *PgProxy) PgDecryptStream(censor acracensor.AcraCensorInterface, dec
 			continue
 		}
 
+		if proxy.dlpSampler.Sample() {
+			proxy.scanDataRow(packetHandler, logger)
+		}
+		var original *dataRowSnapshot
+		if proxy.lengthAudit {
+			original = packetHandler.snapshot()
+		}
 		logger.Debugf("Process columns data")
 		for i := 0; i < packetHandler.columnCount; i++ {
 			column := packetHandler.Columns[i]
 
+			if decrypted, ok := proxy.deterministic.DecryptValue(column.Data); ok {
+				logger.Debugln("Update with decrypted deterministic value")
+				column.SetData(decrypted)
+				continue
+			}
 			// try to skip small piece of data that can't be valuable for us
 			if (decryptor.IsWithZone() && column.Length() >= zone.ZoneIDBlockLength) || column.Length() >= base.KeyBlockLength {
 				decryptor.Reset()
+				directives.ApplyZone(decryptor)
+
+				if scanColumns != nil && len(scanColumns) == packetHandler.columnCount && !scanColumns[i] &&
+					(!decryptor.IsWithZone() || decryptor.IsMatchedZone()) {
+					logger.Debugln("Skip decryption because column isn't configured as encrypted")
+					continue
+				}
+				if proxy.serveCiphertext(packetHandler, i, logger) {
+					continue
+				}
 
 				// Zone anyway should be passed as whole block
 				// so try to match before any operations if we process with ZoneMode on
@@ -537,26 +894,44 @@ func (proxy *PgProxy) PgDecryptStream(censor acracensor.AcraCensorInterface, dec
 					continue
 				}
 
+				// PostgreSQL doesn't send names of tables with result so payload accounted without table
+				encryptedSize := column.Length()
 				if decryptor.IsWholeMatch() {
-					err := proxy.processWholeBlockDecryption(packetHandler, column, decryptor, logger)
+					err := proxy.processWholeBlockDecryption(ctx, packetHandler, column, decryptor, logger)
 					if err != nil {
-						log.WithError(err).Errorln("Can't process whole block")
+						logger.WithError(err).Errorln("Can't process whole block")
 						errCh <- err
 						return
 					}
 				} else {
-					err := proxy.processInlineBlockDecryption(packetHandler, column, decryptor, logger)
+					err := proxy.processInlineBlockDecryption(ctx, packetHandler, column, decryptor, logger)
 					if err != nil {
-						log.WithError(err).Errorln("Can't process block with inline mode")
+						logger.WithError(err).Errorln("Can't process block with inline mode")
 						errCh <- err
 						return
 					}
 				}
+				if column.changed {
+					proxy.connectionStats.AddDecryptedPayload("", encryptedSize, column.Length())
+				}
 			} else {
 				logger.Debugln("Skip decryption because length of block too small for ZoneId or AcraStruct")
 			}
 		}
 		packetHandler.updateDataFromColumns()
+		if original != nil {
+			if err := packetHandler.auditLengths(); err != nil {
+				columnLengths := make([]int32, 0, packetHandler.columnCount)
+				for _, column := range packetHandler.Columns {
+					columnLengths = append(columnLengths, int32(column.Length()))
+				}
+				logger.WithError(err).WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeErrorDecryptorLengthMismatch,
+					"original_length": len(original.data), "rewritten_length": packetHandler.descriptionBuf.Len(),
+					"column_count": packetHandler.columnCount, "column_lengths": columnLengths}).
+					Errorln("Rewritten data row is malformed, send it as it was received from database")
+				packetHandler.restore(original)
+			}
+		}
 		logger.Debugln("send packet")
 		if err := packetHandler.sendPacket(); err != nil {
 			logger.WithError(err).Errorln("Can't send packet")
@@ -568,3 +943,34 @@ func (proxy *PgProxy) PgDecryptStream(censor acracensor.AcraCensorInterface, dec
 		timer.ObserveDuration()
 	}
 }
+
+// trackExtendedStatement starts time measurement of statements of extended query protocol on Sync which response ends
+// with ReadyForQuery. Query is taken from first Parse since previous Sync, requests without Parse are skipped. Directives
+// of query are queued for response on Sync, function calls are queued without directives
+func (proxy *PgProxy) trackExtendedStatement(packet *PacketHandler, logger *log.Entry) {
+	switch {
+	case packet.IsFunctionCall():
+		proxy.requests.push(pendingRequest{})
+	case packet.IsParse():
+		query, err := packet.GetParseQuery()
+		if err != nil {
+			proxy.extendedNamedColumns = false
+			return
+		}
+		if proxy.extendedQuery == "" {
+			proxy.extendedQuery = query
+			proxy.extendedNamedColumns = proxy.hasNamedColumns(query)
+		} else {
+			proxy.extendedNamedColumns = proxy.extendedNamedColumns && proxy.hasNamedColumns(query)
+		}
+	case packet.IsSync():
+		proxy.connectionStats.StartStatement(proxy.extendedQuery)
+		var directives *base.QueryDirectives
+		if proxy.extendedQuery != "" {
+			directives = base.GetQueryDirectives(proxy.extendedQuery, proxy.allowQueryDirectives, proxy.sessionZone, proxy.zoneResolver, logger)
+		}
+		proxy.requests.push(pendingRequest{directives: directives, namedColumns: proxy.extendedQuery != "" && proxy.extendedNamedColumns})
+		proxy.extendedQuery = ""
+		proxy.extendedNamedColumns = false
+	}
+}
@@ -0,0 +1,39 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgresql
+
+import (
+	"testing"
+)
+
+func TestNotifyStartupFinished(t *testing.T) {
+	calls := 0
+	proxy := &PgProxy{}
+	proxy.SetStartupCallback(func() { calls++ })
+	packetHandler := &PacketHandler{}
+	// AuthenticationOk and ParameterStatus precede ReadyForQuery
+	for _, messageType := range []byte{'R', 'S', ReadyForQueryMessageType, 'T', ReadyForQueryMessageType} {
+		packetHandler.messageType[0] = messageType
+		proxy.notifyStartupFinished(packetHandler)
+		if messageType == 'S' && calls != 0 {
+			t.Fatal("Callback shouldn't be called before ReadyForQuery")
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("Expected one call of callback, took %d", calls)
+	}
+}
@@ -219,6 +219,15 @@ func (decryptor *PgDecryptor) GetPrivateKey() (*keys.PrivateKey, error) {
 	return decryptor.keyStore.GetServerDecryptionPrivateKey(decryptor.clientID)
 }
 
+// GetPrivateKeys returns all versions of either Zone private key (if Zone mode enabled) or
+// Server Decryption private key otherwise
+func (decryptor *PgDecryptor) GetPrivateKeys() ([]*keys.PrivateKey, error) {
+	if decryptor.IsWithZone() {
+		return decryptor.keyStore.GetZonePrivateKeys(decryptor.GetMatchedZoneID())
+	}
+	return decryptor.keyStore.GetServerDecryptionPrivateKeys(decryptor.clientID)
+}
+
 // TurnOnPoisonRecordCheck turns on or off poison recods check
 func (decryptor *PgDecryptor) TurnOnPoisonRecordCheck(val bool) {
 	decryptor.logger.Debugf("Set poison record check: %v", val)
@@ -319,7 +328,7 @@ func (decryptor *PgDecryptor) DecryptBlock(block []byte) ([]byte, error) {
 		decryptor.logger.Warningln("Can't read private key")
 		return []byte{}, err
 	}
-	key, _, err := decryptor.ReadSymmetricKey(privateKey, reader)
+	key, err := base.ReadSymmetricKeyRotated(decryptor, privateKey, reader)
 	if err != nil {
 		decryptor.logger.Warningf("%v", utils.ErrorMessage("Can't unwrap symmetric key", err))
 		return []byte{}, err
@@ -0,0 +1,84 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgresql
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrUnsupportedProtocolVersion returned if client requested version of protocol which AcraServer can't process
+var ErrUnsupportedProtocolVersion = acraerrors.New(acraerrors.CodePostgreSQLUnsupportedProtocolVersion, "unsupported version of PostgreSQL protocol")
+
+// Codes of packets sent by client instead of StartupMessage
+// https://www.postgresql.org/docs/current/protocol-message-formats.html
+const (
+	cancelRequestCode     = 80877102
+	sslRequestCode        = 80877103
+	gssEncryptRequestCode = 80877104
+	// supportedProtocolMajor is major version of protocol 3.0 which AcraServer processes
+	supportedProtocolMajor = 3
+	// feature_not_supported
+	featureNotSupportedCode = "0A000"
+)
+
+// SSLDenyResponse is answer to SSLRequest or GSSENCRequest which denies encryption
+var SSLDenyResponse = []byte{'N'}
+
+// newProtocol2Error returns ErrorResponse in format of protocol 2.0 which has only null terminated message
+func newProtocol2Error(message string) []byte {
+	output := append([]byte{'E'}, "FATAL:  "+message+"\n"...)
+	return append(output, 0)
+}
+
+// handleStartupPacket answers first packet of client which can't be forwarded to database instead of opaque dropped
+// connection. Clients of protocol 2.0 and newer major versions get error in format which they can read, GSSAPI
+// encryption which can't be processed is denied, so client continues with SSLRequest or StartupMessage. Returns true
+// if packet was answered and client will send next packet without message type
+func handleStartupPacket(data []byte, clientConnection io.Writer, logger *log.Entry) (bool, error) {
+	if len(data) < 4 {
+		return false, nil
+	}
+	code := binary.BigEndian.Uint32(data[:4])
+	switch code {
+	case sslRequestCode, cancelRequestCode:
+		return false, nil
+	case gssEncryptRequestCode:
+		logger.Debugln("Deny GSSAPI encryption which isn't supported")
+		_, err := clientConnection.Write(SSLDenyResponse)
+		return true, err
+	}
+	major, minor := code>>16, code&0xffff
+	if major == supportedProtocolMajor {
+		return false, nil
+	}
+	message := fmt.Sprintf("unsupported frontend protocol %d.%d: AcraServer supports protocol 3.0, upgrade client library", major, minor)
+	logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorUnsupportedClientProtocol).Errorln(message)
+	errorMessage := NewPgFatalError(featureNotSupportedCode, message)
+	if major < supportedProtocolMajor {
+		errorMessage = newProtocol2Error(message)
+	}
+	if _, err := clientConnection.Write(errorMessage); err != nil {
+		logger.WithError(err).Debugln("Can't send error about unsupported protocol to client")
+	}
+	return false, ErrUnsupportedProtocolVersion
+}
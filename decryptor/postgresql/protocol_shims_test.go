@@ -0,0 +1,69 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgresql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func startupCode(code uint32) []byte {
+	output := make([]byte, 8)
+	binary.BigEndian.PutUint32(output, code)
+	return output
+}
+
+func TestHandleStartupPacket(t *testing.T) {
+	logger := log.NewEntry(log.StandardLogger())
+	// packets which should be forwarded to database as is
+	for _, code := range []uint32{3 << 16, sslRequestCode, cancelRequestCode} {
+		output := &bytes.Buffer{}
+		answered, err := handleStartupPacket(startupCode(code), output, logger)
+		if err != nil || answered || output.Len() != 0 {
+			t.Fatalf("Packet with code %d was processed: %v, %v, %q", code, answered, err, output.Bytes())
+		}
+	}
+
+	output := &bytes.Buffer{}
+	answered, err := handleStartupPacket(startupCode(gssEncryptRequestCode), output, logger)
+	if err != nil || !answered || !bytes.Equal(output.Bytes(), SSLDenyResponse) {
+		t.Fatalf("GSSENCRequest wasn't denied: %v, %v, %q", answered, err, output.Bytes())
+	}
+
+	output.Reset()
+	_, err = handleStartupPacket(startupCode(2<<16), output, logger)
+	if err != ErrUnsupportedProtocolVersion {
+		t.Fatalf("Expected ErrUnsupportedProtocolVersion, took %v", err)
+	}
+	response := output.String()
+	if !strings.HasPrefix(response, "EFATAL:  unsupported frontend protocol 2.0") || !strings.HasSuffix(response, "\n\x00") {
+		t.Fatalf("Unexpected protocol 2.0 error %q", response)
+	}
+
+	output.Reset()
+	_, err = handleStartupPacket(startupCode(4<<16), output, logger)
+	if err != ErrUnsupportedProtocolVersion {
+		t.Fatalf("Expected ErrUnsupportedProtocolVersion, took %v", err)
+	}
+	if output.Len() == 0 || output.Bytes()[0] != 'E' || !bytes.Contains(output.Bytes(), []byte("C0A000\x00")) {
+		t.Fatalf("Unexpected protocol 4.0 error %q", output.Bytes())
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgresql
+
+import (
+	"sync"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+)
+
+// pendingRequest describes request forwarded to database which response isn't finished yet
+type pendingRequest struct {
+	// directives of request applied to its response, nil if request has nothing to override
+	directives *base.QueryDirectives
+	// namedColumns is true if columns of result are named as columns of tables, so columns which aren't configured as
+	// encrypted may be skipped by name
+	namedColumns bool
+}
+
+// requestQueue keeps requests forwarded to database in order of their responses. Every simple query, function call
+// and Sync of extended query protocol is answered with ReadyForQuery, so request is applied to its response until
+// ReadyForQuery even if client pipelines requests
+type requestQueue struct {
+	lock     sync.Mutex
+	requests []pendingRequest
+}
+
+// push adds request forwarded to database
+func (queue *requestQueue) push(request pendingRequest) {
+	queue.lock.Lock()
+	queue.requests = append(queue.requests, request)
+	queue.lock.Unlock()
+}
+
+// current returns request which response is processed now or empty request
+func (queue *requestQueue) current() pendingRequest {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	if len(queue.requests) == 0 {
+		return pendingRequest{}
+	}
+	return queue.requests[0]
+}
+
+// pop removes request which response ended with ReadyForQuery
+func (queue *requestQueue) pop() {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	if len(queue.requests) == 0 {
+		return
+	}
+	queue.requests[0] = pendingRequest{}
+	queue.requests = queue.requests[1:]
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgresql
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/cossacklabs/acra/acra-censor"
+	"github.com/cossacklabs/acra/decryptor/base"
+)
+
+// testDecryptor replaces every value with "decrypted"
+type testDecryptor struct {
+	base.Decryptor
+}
+
+func (*testDecryptor) IsWholeMatch() bool          { return true }
+func (*testDecryptor) IsWithZone() bool            { return false }
+func (*testDecryptor) IsPoisonRecordCheckOn() bool { return false }
+func (*testDecryptor) Reset()                      {}
+func (*testDecryptor) ResetZoneMatch()             {}
+func (*testDecryptor) DecryptBlock([]byte) ([]byte, error) {
+	return []byte("decrypted"), nil
+}
+
+// testCensor allows all queries
+type testCensor struct {
+	acracensor.AcraCensorInterface
+}
+
+func (*testCensor) HandleConnectionQuery(acracensor.ConnectionInfo, string) error { return nil }
+
+// newTestMessage returns message of PostgreSQL protocol with type and data
+func newTestMessage(messageType byte, data []byte) []byte {
+	output := append([]byte{messageType}, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(output[1:], uint32(len(data)+4))
+	return append(output, data...)
+}
+
+// readTestMessage returns type and data of message of PostgreSQL protocol
+func readTestMessage(reader io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return 0, nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(header[1:])-4)
+	_, err := io.ReadFull(reader, data)
+	return header[0], data, err
+}
+
+// newTestDataRow returns DataRow with one column
+func newTestDataRow(value []byte) []byte {
+	data := []byte{0, 1, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(data[2:], uint32(len(value)))
+	return newTestMessage(DataRowMessageType, append(data, value...))
+}
+
+func TestQueryDirectivesOfPipelinedQueries(t *testing.T) {
+	client, proxyClient := net.Pipe()
+	db, proxyDB := net.Pipe()
+	defer client.Close()
+	defer db.Close()
+	proxy, err := NewPgProxy(proxyClient, proxyDB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy.AllowQueryDirectives(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 2)
+	go proxy.PgProxyClientRequests(&testCensor{}, proxyDB, proxyClient, errCh)
+	go proxy.PgDecryptStream(ctx, &testCensor{}, &testDecryptor{}, nil, proxyDB, proxyClient, errCh)
+
+	value := bytes.Repeat([]byte{'a'}, base.KeyBlockLength+10)
+	queries := []string{"/* acra: skip_decrypt */ SELECT data FROM test", "SELECT data FROM test"}
+	dbErrCh := make(chan error, 1)
+	go func() {
+		startup := make([]byte, 8)
+		binary.BigEndian.PutUint32(startup, 8)
+		binary.BigEndian.PutUint32(startup[4:], 3<<16)
+		if _, err := io.ReadFull(db, startup); err != nil {
+			dbErrCh <- err
+			return
+		}
+		if _, err := db.Write(append(newTestMessage('R', make([]byte, 4)), newTestMessage(ReadyForQueryMessageType, []byte{'I'})...)); err != nil {
+			dbErrCh <- err
+			return
+		}
+		// both queries are received before responses are sent
+		for range queries {
+			if _, _, err := readTestMessage(db); err != nil {
+				dbErrCh <- err
+				return
+			}
+		}
+		var responses []byte
+		for range queries {
+			responses = append(responses, newTestDataRow(value)...)
+			responses = append(responses, newTestMessage(CommandCompleteMessageType, []byte("SELECT 1\x00"))...)
+			responses = append(responses, newTestMessage(ReadyForQueryMessageType, []byte{'I'})...)
+		}
+		_, err := db.Write(responses)
+		dbErrCh <- err
+	}()
+
+	startup := make([]byte, 8)
+	binary.BigEndian.PutUint32(startup, 8)
+	binary.BigEndian.PutUint32(startup[4:], 3<<16)
+	if _, err := client.Write(startup); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []byte{'R', ReadyForQueryMessageType} {
+		if messageType, _, err := readTestMessage(client); err != nil || messageType != expected {
+			t.Fatalf("Expected message %c, took %c: %v", expected, messageType, err)
+		}
+	}
+	var pipelined []byte
+	for _, query := range queries {
+		pipelined = append(pipelined, newTestMessage(QueryMessageType, append([]byte(query), 0))...)
+	}
+	go client.Write(pipelined)
+
+	var rows [][]byte
+	for readyForQuery := 0; readyForQuery < len(queries); {
+		messageType, data, err := readTestMessage(client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch messageType {
+		case DataRowMessageType:
+			rows = append(rows, data[6:])
+		case ReadyForQueryMessageType:
+			readyForQuery++
+		}
+	}
+	if err := <-dbErrCh; err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, took %d", len(rows))
+	}
+	if !bytes.Equal(rows[0], value) {
+		t.Fatal("Result of query with skip_decrypt directive was decrypted")
+	}
+	if string(rows[1]) != "decrypted" {
+		t.Fatalf("Result of query without directives wasn't decrypted: %q", rows[1])
+	}
+}
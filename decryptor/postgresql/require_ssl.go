@@ -0,0 +1,70 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgresql
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// Errors returned if SSL is required but connection can't be switched to it
+var (
+	ErrSSLRequired = acraerrors.New(acraerrors.CodePostgreSQLSSLRequired, "client didn't request SSL connection which is required")
+	ErrDBDeniedSSL = acraerrors.New(acraerrors.CodePostgreSQLDBDeniedSSL, "database denied SSL connection which is required")
+)
+
+// invalid_authorization_specification, same as PostgreSQL sends if pg_hba.conf requires SSL
+const invalidAuthorizationCode = "28000"
+
+// checkSSLRequired refuses StartupMessage sent over plaintext connection if SSL is required. SSLRequest and
+// CancelRequest are allowed because first one switches connection to TLS and second one doesn't carry data
+func (proxy *PgProxy) checkSSLRequired(data []byte, clientConnection net.Conn, logger *log.Entry) error {
+	if !proxy.requireSSL || len(data) < 4 {
+		return nil
+	}
+	if _, ok := clientConnection.(*tls.Conn); ok {
+		return nil
+	}
+	switch binary.BigEndian.Uint32(data[:4]) {
+	case sslRequestCode, cancelRequestCode:
+		return nil
+	}
+	logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTLSRequired).
+		Errorln("Client started session without SSL which is required, refuse connection")
+	sendFatalError(clientConnection, invalidAuthorizationCode, "AcraServer requires SSL connection, enable SSL on client (sslmode=require)", logger)
+	return ErrSSLRequired
+}
+
+// refuseDeniedSSL sends error to client instead of forwarding denial of SSL from database, so client doesn't continue
+// in plaintext
+func refuseDeniedSSL(clientConnection io.Writer, logger *log.Entry) {
+	logger.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTLSRequired).
+		Errorln("Database denied SSL connection which is required, refuse connection")
+	sendFatalError(clientConnection, UnableToConnectCode, "database doesn't support SSL connection which is required by AcraServer", logger)
+}
+
+func sendFatalError(clientConnection io.Writer, code, message string, logger *log.Entry) {
+	if _, err := clientConnection.Write(NewPgFatalError(code, message)); err != nil {
+		logger.WithError(err).Debugln("Can't send error to client")
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgresql
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestCheckSSLRequired(t *testing.T) {
+	logger := log.NewEntry(log.StandardLogger())
+	proxy := &PgProxy{}
+	client, server := net.Pipe()
+	defer client.Close()
+	if err := proxy.checkSSLRequired(startupCode(3<<16), server, logger); err != nil {
+		t.Fatalf("Startup refused when SSL isn't required: %v", err)
+	}
+	proxy.SetRequireSSL(true)
+	for _, code := range []uint32{sslRequestCode, cancelRequestCode} {
+		if err := proxy.checkSSLRequired(startupCode(code), server, logger); err != nil {
+			t.Fatalf("Packet with code %d refused: %v", code, err)
+		}
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- proxy.checkSSLRequired(startupCode(3<<16), server, logger)
+		server.Close()
+	}()
+	response, err := ioutil.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != ErrSSLRequired {
+		t.Fatalf("Expected ErrSSLRequired, took %v", err)
+	}
+	if len(response) == 0 || response[0] != 'E' || !bytes.Contains(response, []byte("C28000\x00")) {
+		t.Fatalf("Unexpected error %q", response)
+	}
+}
+
+func TestRefuseDeniedSSL(t *testing.T) {
+	output := &bytes.Buffer{}
+	refuseDeniedSSL(output, log.NewEntry(log.StandardLogger()))
+	if output.Len() == 0 || output.Bytes()[0] != 'E' || !bytes.Contains(output.Bytes(), []byte("C08001\x00")) {
+		t.Fatalf("Unexpected error %q", output.Bytes())
+	}
+}
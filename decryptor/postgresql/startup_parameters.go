@@ -0,0 +1,92 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgresql
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/cossacklabs/acra/acra-censor"
+	log "github.com/sirupsen/logrus"
+)
+
+// ApplicationNameParameter is startup parameter with name of client's application
+// https://www.postgresql.org/docs/current/runtime-config-logging.html#GUC-APPLICATION-NAME
+const ApplicationNameParameter = "application_name"
+
+// Startup parameters with database and user of connection, database defaults to user name
+// https://www.postgresql.org/docs/current/protocol-message-formats.html#PROTOCOL-MESSAGE-FORMATS-STARTUPMESSAGE
+const (
+	DatabaseParameter = "database"
+	UserParameter     = "user"
+)
+
+// protocolVersion3 is version of protocol 3.0 sent in StartupMessage
+const protocolVersion3 = supportedProtocolMajor << 16
+
+// parseStartupParameters returns parameters of StartupMessage or nil if data isn't StartupMessage. Parameters are
+// pairs of null terminated names and values ended by empty name
+// https://www.postgresql.org/docs/current/protocol-message-formats.html#PROTOCOL-MESSAGE-FORMATS-STARTUPMESSAGE
+func parseStartupParameters(data []byte) (map[string]string, error) {
+	if len(data) < 4 || binary.BigEndian.Uint32(data[:4]) != protocolVersion3 {
+		return nil, nil
+	}
+	parameters := make(map[string]string)
+	data = data[4:]
+	for len(data) > 0 && data[0] != 0 {
+		nameEnd := bytes.IndexByte(data, 0)
+		if nameEnd < 0 {
+			return nil, ErrMalformedPacket
+		}
+		valueEnd := bytes.IndexByte(data[nameEnd+1:], 0)
+		if valueEnd < 0 {
+			return nil, ErrMalformedPacket
+		}
+		parameters[string(data[:nameEnd])] = string(data[nameEnd+1 : nameEnd+1+valueEnd])
+		data = data[nameEnd+1+valueEnd+1:]
+	}
+	return parameters, nil
+}
+
+// tagConnection saves startup parameters of client as tags of connection and returns logger with name of client's
+// application. Parameters are forwarded to database as is, so malformed packet is only logged
+func (proxy *PgProxy) tagConnection(data []byte, logger *log.Entry) *log.Entry {
+	parameters, err := parseStartupParameters(data)
+	if err != nil {
+		logger.WithError(err).Debugln("Can't parse startup parameters")
+		return logger
+	}
+	if parameters == nil {
+		return logger
+	}
+	application := parameters[ApplicationNameParameter]
+	proxy.connectionStats.SetTags(application, parameters)
+	database := parameters[DatabaseParameter]
+	if database == "" {
+		database = parameters[UserParameter]
+	}
+	proxy.censorConnection = acracensor.ConnectionInfo{Application: application, Database: database}
+	if proxy.connectionStats != nil {
+		proxy.censorConnection.ClientID = proxy.connectionStats.ClientID
+	}
+	if application == "" {
+		return logger
+	}
+	logger = logger.WithField("application", application)
+	logger.Debugln("Client application identified by startup parameters")
+	return logger
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgresql
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestParseStartupParameters(t *testing.T) {
+	data := startupCode(protocolVersion3)[:4]
+	data = append(data, "user\x00test\x00application_name\x00reporting-service\x00\x00"...)
+	parameters, err := parseStartupParameters(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"user": "test", ApplicationNameParameter: "reporting-service"}
+	if !reflect.DeepEqual(parameters, expected) {
+		t.Fatalf("Incorrect parameters %v", parameters)
+	}
+
+	if parameters, err := parseStartupParameters(startupCode(sslRequestCode)); parameters != nil || err != nil {
+		t.Fatalf("Expected no parameters of SSLRequest, took %v, %v", parameters, err)
+	}
+	if _, err := parseStartupParameters(append(startupCode(protocolVersion3)[:4], "user\x00test"...)); err != ErrMalformedPacket {
+		t.Fatalf("Expected ErrMalformedPacket, took %v", err)
+	}
+
+	proxy := &PgProxy{connectionStats: base.NewConnectionStats(1, []byte("client"), "127.0.0.1:1234")}
+	proxy.tagConnection(data, log.NewEntry(log.StandardLogger()))
+	if proxy.censorConnection.Application != "reporting-service" || string(proxy.censorConnection.ClientID) != "client" {
+		t.Fatalf("Incorrect censor connection %+v", proxy.censorConnection)
+	}
+	if snapshot := proxy.connectionStats.Snapshot(); snapshot.Application != "reporting-service" || !reflect.DeepEqual(snapshot.Tags, expected) {
+		t.Fatalf("Incorrect connection tags %+v", snapshot)
+	}
+}
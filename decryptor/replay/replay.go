@@ -0,0 +1,415 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package replay decrypts AcraStructs found in recorded database response streams and dump files without live
+// database connection. It is used by AcraReplay for incident forensics and data recovery: every detected AcraStruct
+// is replaced by its plaintext and all other bytes are written as is. AcraStructs which can't be decrypted stay in
+// output unchanged and are counted as failed.
+package replay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/utils"
+	"github.com/cossacklabs/acra/zone"
+	"github.com/cossacklabs/themis/gothemis/keys"
+	log "github.com/sirupsen/logrus"
+)
+
+// Supported formats of input data
+const (
+	// FormatRaw is any binary or text data with AcraStructs inlined as is
+	FormatRaw = "raw"
+	// FormatHex is text dump with hex encoded AcraStructs like pg_dump's \x... or mysqldump's 0x... values
+	FormatHex = "hex"
+	// FormatPostgreSQL is recorded stream of messages from PostgreSQL backend
+	FormatPostgreSQL = "postgresql"
+	// FormatMySQL is recorded stream of packets from MySQL server
+	FormatMySQL = "mysql"
+)
+
+// Errors returned by Replayer
+var (
+	ErrUnsupportedFormat = acraerrors.New(acraerrors.CodeUnsupportedReplayFormat, "unsupported format of replayed data")
+	ErrMalformedStream   = acraerrors.New(acraerrors.CodeMalformedReplayStream, "malformed protocol stream")
+	ErrNoZoneID          = acraerrors.New(acraerrors.CodeReplayNoZoneID, "AcraStruct isn't preceded by zone id")
+)
+
+// KeyStore provides all versions of private keys used to decrypt AcraStructs
+type KeyStore interface {
+	GetServerDecryptionPrivateKeys(id []byte) ([]*keys.PrivateKey, error)
+	GetZonePrivateKeys(id []byte) ([]*keys.PrivateKey, error)
+}
+
+// Stats counts AcraStructs processed by Replayer
+type Stats struct {
+	Decrypted int
+	Failed    int
+}
+
+// Replayer finds AcraStructs in data and replaces them by plaintext
+type Replayer struct {
+	keystore KeyStore
+	clientID []byte
+	withZone bool
+	// zoneID is last zone id met in data, used as zone of next AcraStructs like zone matcher of AcraServer does
+	zoneID []byte
+	stats  Stats
+}
+
+// NewReplayer returns Replayer which decrypts AcraStructs with keys of clientID
+func NewReplayer(keystore KeyStore, clientID []byte) *Replayer {
+	return &Replayer{keystore: keystore, clientID: clientID}
+}
+
+// SetWithZone turns on zone mode where AcraStructs are decrypted with keys of zone which id precedes them in data
+func (replayer *Replayer) SetWithZone(withZone bool) {
+	replayer.withZone = withZone
+}
+
+// SetZoneID turns on zone mode and sets zone of AcraStructs which aren't preceded by other zone id in data
+func (replayer *Replayer) SetZoneID(zoneID []byte) {
+	replayer.withZone = true
+	replayer.zoneID = zoneID
+}
+
+// Stats returns count of decrypted and failed AcraStructs
+func (replayer *Replayer) Stats() Stats {
+	return replayer.stats
+}
+
+// Replay processes data according to format and returns data with decrypted AcraStructs
+func (replayer *Replayer) Replay(format string, data []byte) ([]byte, error) {
+	switch format {
+	case FormatRaw:
+		return replayer.ReplaceRaw(data), nil
+	case FormatHex:
+		return replayer.ReplaceHex(data), nil
+	case FormatPostgreSQL:
+		return replayer.ReplacePostgreSQL(data)
+	case FormatMySQL:
+		return replayer.ReplaceMySQL(data)
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// matchZone remembers last valid zone id from data in zone mode
+func (replayer *Replayer) matchZone(data []byte) {
+	if !replayer.withZone {
+		return
+	}
+	for {
+		index := bytes.LastIndex(data, zone.ZoneIDBegin)
+		if index < 0 {
+			return
+		}
+		if len(data[index:]) >= zone.ZoneIDBlockLength {
+			replayer.zoneID = append([]byte{}, data[index:index+zone.ZoneIDBlockLength]...)
+			return
+		}
+		data = data[:index]
+	}
+}
+
+// acraStructLength returns length of AcraStruct which starts at beginning of data or 0 if data doesn't contain whole
+// AcraStruct
+func acraStructLength(data []byte) int {
+	minLength := base.GetMinAcraStructLength()
+	if len(data) < minLength || !bytes.HasPrefix(data, base.TAG_BEGIN) {
+		return 0
+	}
+	dataLength := binary.LittleEndian.Uint64(data[minLength-base.DataLengthSize : minLength])
+	if dataLength > uint64(len(data)-minLength) {
+		return 0
+	}
+	return minLength + int(dataLength)
+}
+
+// decrypt returns plaintext of AcraStruct or error if it can't be decrypted
+func (replayer *Replayer) decrypt(acraStruct []byte) ([]byte, error) {
+	var privateKeys []*keys.PrivateKey
+	var err error
+	var decryptionContext []byte
+	if replayer.withZone {
+		if replayer.zoneID == nil {
+			return nil, ErrNoZoneID
+		}
+		privateKeys, err = replayer.keystore.GetZonePrivateKeys(replayer.zoneID)
+		decryptionContext = replayer.zoneID
+	} else {
+		privateKeys, err = replayer.keystore.GetServerDecryptionPrivateKeys(replayer.clientID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := base.DecryptRotatedAcrastruct(acraStruct, privateKeys, decryptionContext)
+	for _, privateKey := range privateKeys {
+		utils.FillSlice(byte(0), privateKey.Value)
+	}
+	return plaintext, err
+}
+
+// replaceAcraStruct decrypts AcraStruct and updates stats. Returns false if AcraStruct can't be decrypted
+func (replayer *Replayer) replaceAcraStruct(acraStruct []byte) ([]byte, bool) {
+	plaintext, err := replayer.decrypt(acraStruct)
+	if err != nil {
+		log.WithError(err).WithField("zone_id", string(replayer.zoneID)).Warningln("Can't decrypt AcraStruct, leave it as is")
+		replayer.stats.Failed++
+		return nil, false
+	}
+	replayer.stats.Decrypted++
+	return plaintext, true
+}
+
+// ReplaceRaw returns data with all inlined AcraStructs replaced by their plaintexts
+func (replayer *Replayer) ReplaceRaw(data []byte) []byte {
+	output := make([]byte, 0, len(data))
+	for len(data) > 0 {
+		index := bytes.Index(data, base.TAG_BEGIN)
+		if index < 0 {
+			replayer.matchZone(data)
+			return append(output, data...)
+		}
+		replayer.matchZone(data[:index])
+		output = append(output, data[:index]...)
+		data = data[index:]
+		length := acraStructLength(data)
+		if length == 0 {
+			// only tag matched, move forward by one byte to find next one
+			output = append(output, data[0])
+			data = data[1:]
+			continue
+		}
+		if plaintext, ok := replayer.replaceAcraStruct(data[:length]); ok {
+			output = append(output, plaintext...)
+		} else {
+			output = append(output, data[:length]...)
+		}
+		data = data[length:]
+	}
+	return output
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// ReplaceHex returns text data with hex encoded AcraStructs replaced by hex encoded plaintexts. Each run of hex digits
+// is decoded separately, so zone ids may be stored hex encoded in same run or as plain text before it
+func (replayer *Replayer) ReplaceHex(data []byte) []byte {
+	output := make([]byte, 0, len(data))
+	for len(data) > 0 {
+		end := 0
+		for end < len(data) && !isHexDigit(data[end]) {
+			end++
+		}
+		replayer.matchZone(data[:end])
+		output = append(output, data[:end]...)
+		data = data[end:]
+		end = 0
+		for end < len(data) && isHexDigit(data[end]) {
+			end++
+		}
+		run := data[:end]
+		data = data[end:]
+		if len(run)%2 != 0 {
+			// odd run can't be hex encoded value, e.g. digit before x of 0x prefix
+			output = append(output, run...)
+			continue
+		}
+		decoded := make([]byte, len(run)/2)
+		if _, err := hex.Decode(decoded, run); err != nil {
+			output = append(output, run...)
+			continue
+		}
+		decrypted := replayer.ReplaceRaw(decoded)
+		if bytes.Equal(decrypted, decoded) {
+			output = append(output, run...)
+			continue
+		}
+		output = append(output, hex.EncodeToString(decrypted)...)
+	}
+	return output
+}
+
+// pgMessageHeaderLength is 1 byte of message type and 4 bytes of length which includes itself
+const pgMessageHeaderLength = 5
+
+// isPostgreSQLSSLAnswer returns true if stream starts with one byte answer on SSLRequest followed by authentication
+// or error message which are first messages of backend
+func isPostgreSQLSSLAnswer(stream []byte) bool {
+	return len(stream) > 1 && (stream[0] == 'N' || stream[0] == 'S') && (stream[1] == 'R' || stream[1] == 'E')
+}
+
+// ReplacePostgreSQL returns stream of PostgreSQL backend messages with AcraStructs in values of DataRow messages
+// replaced by plaintexts. Values in hex format (\x...) are decoded and encoded back after decryption
+func (replayer *Replayer) ReplacePostgreSQL(stream []byte) ([]byte, error) {
+	output := make([]byte, 0, len(stream))
+	if isPostgreSQLSSLAnswer(stream) {
+		output = append(output, stream[0])
+		stream = stream[1:]
+	}
+	for len(stream) > 0 {
+		if len(stream) < pgMessageHeaderLength {
+			return nil, ErrMalformedStream
+		}
+		length := int(binary.BigEndian.Uint32(stream[1:pgMessageHeaderLength]))
+		if length < 4 || len(stream) < 1+length {
+			return nil, ErrMalformedStream
+		}
+		message := stream[:1+length]
+		stream = stream[1+length:]
+		if message[0] != 'D' {
+			output = append(output, message...)
+			continue
+		}
+		dataRow, err := replayer.replacePostgreSQLDataRow(message)
+		if err != nil {
+			return nil, err
+		}
+		output = append(output, dataRow...)
+	}
+	return output, nil
+}
+
+// replacePostgreSQLDataRow returns DataRow message with replaced values and updated lengths
+// https://www.postgresql.org/docs/current/static/protocol-message-formats.html
+func (replayer *Replayer) replacePostgreSQLDataRow(message []byte) ([]byte, error) {
+	// type, length and count of columns
+	if len(message) < pgMessageHeaderLength+2 {
+		return nil, ErrMalformedStream
+	}
+	columnCount := int(binary.BigEndian.Uint16(message[pgMessageHeaderLength : pgMessageHeaderLength+2]))
+	output := append([]byte{}, message[:pgMessageHeaderLength+2]...)
+	pos := pgMessageHeaderLength + 2
+	for i := 0; i < columnCount; i++ {
+		if len(message) < pos+4 {
+			return nil, ErrMalformedStream
+		}
+		length := int32(binary.BigEndian.Uint32(message[pos : pos+4]))
+		pos += 4
+		if length < 0 {
+			// NULL
+			output = append(output, message[pos-4:pos]...)
+			continue
+		}
+		if len(message) < pos+int(length) {
+			return nil, ErrMalformedStream
+		}
+		value := replayer.replacePostgreSQLValue(message[pos : pos+int(length)])
+		output = append(output, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(output[len(output)-4:], uint32(len(value)))
+		output = append(output, value...)
+		pos += int(length)
+	}
+	binary.BigEndian.PutUint32(output[1:pgMessageHeaderLength], uint32(len(output)-1))
+	return output, nil
+}
+
+// pgHexPrefix is prefix of bytea values in hex output format
+var pgHexPrefix = []byte{'\\', 'x'}
+
+func (replayer *Replayer) replacePostgreSQLValue(value []byte) []byte {
+	if !bytes.HasPrefix(value, pgHexPrefix) {
+		return replayer.ReplaceRaw(value)
+	}
+	decoded := make([]byte, hex.DecodedLen(len(value)-len(pgHexPrefix)))
+	if _, err := hex.Decode(decoded, value[len(pgHexPrefix):]); err != nil {
+		return replayer.ReplaceRaw(value)
+	}
+	decrypted := replayer.ReplaceRaw(decoded)
+	if bytes.Equal(decrypted, decoded) {
+		return value
+	}
+	return append(append([]byte{}, pgHexPrefix...), hex.EncodeToString(decrypted)...)
+}
+
+// mysqlHeaderSize is 3 bytes of payload length and 1 byte of sequence id
+const mysqlHeaderSize = 4
+
+// ReplaceMySQL returns stream of MySQL packets with length encoded AcraStructs replaced by length encoded plaintexts
+// and updated lengths of packets
+func (replayer *Replayer) ReplaceMySQL(stream []byte) ([]byte, error) {
+	output := make([]byte, 0, len(stream))
+	for len(stream) > 0 {
+		if len(stream) < mysqlHeaderSize {
+			return nil, ErrMalformedStream
+		}
+		length := int(stream[0]) | int(stream[1])<<8 | int(stream[2])<<16
+		if len(stream) < mysqlHeaderSize+length {
+			return nil, ErrMalformedStream
+		}
+		sequenceID := stream[3]
+		payload := replayer.replaceMySQLPayload(stream[mysqlHeaderSize : mysqlHeaderSize+length])
+		stream = stream[mysqlHeaderSize+length:]
+		output = append(output, byte(len(payload)), byte(len(payload)>>8), byte(len(payload)>>16), sequenceID)
+		output = append(output, payload...)
+	}
+	return output, nil
+}
+
+// replaceMySQLPayload replaces AcraStructs which are whole length encoded values of packet. AcraStructs inlined in
+// longer values are left as is because their length prefix can't be located reliably
+func (replayer *Replayer) replaceMySQLPayload(payload []byte) []byte {
+	output := make([]byte, 0, len(payload))
+	for len(payload) > 0 {
+		index := bytes.Index(payload, base.TAG_BEGIN)
+		if index < 0 {
+			replayer.matchZone(payload)
+			return append(output, payload...)
+		}
+		replayer.matchZone(payload[:index])
+		length := acraStructLength(payload[index:])
+		prefix := mysqlLengthEncodedPrefix(length)
+		if length == 0 || !bytes.HasSuffix(payload[:index], prefix) {
+			output = append(output, payload[:index+1]...)
+			payload = payload[index+1:]
+			continue
+		}
+		acraStruct := payload[index : index+length]
+		plaintext, ok := replayer.replaceAcraStruct(acraStruct)
+		if ok {
+			output = append(output, payload[:index-len(prefix)]...)
+			output = append(output, mysqlLengthEncodedPrefix(len(plaintext))...)
+			output = append(output, plaintext...)
+		} else {
+			output = append(output, payload[:index+length]...)
+		}
+		payload = payload[index+length:]
+	}
+	return output
+}
+
+// mysqlLengthEncodedPrefix returns length prefix of length encoded string
+// https://dev.mysql.com/doc/internals/en/string.html#packet-Protocol::LengthEncodedString
+func mysqlLengthEncodedPrefix(length int) []byte {
+	switch {
+	case length < 251:
+		return []byte{byte(length)}
+	case length < 1<<16:
+		return []byte{0xfc, byte(length), byte(length >> 8)}
+	case length < 1<<24:
+		return []byte{0xfd, byte(length), byte(length >> 8), byte(length >> 16)}
+	}
+	prefix := make([]byte, 9)
+	prefix[0] = 0xfe
+	binary.LittleEndian.PutUint64(prefix[1:], uint64(length))
+	return prefix
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package replay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/cossacklabs/acra/acra-writer"
+	"github.com/cossacklabs/acra/zone"
+	"github.com/cossacklabs/themis/gothemis/keys"
+)
+
+type testKeystore struct {
+	serverKeypair *keys.Keypair
+	zoneKeypair   *keys.Keypair
+}
+
+func (keystore *testKeystore) GetServerDecryptionPrivateKeys(id []byte) ([]*keys.PrivateKey, error) {
+	return []*keys.PrivateKey{{Value: append([]byte{}, keystore.serverKeypair.Private.Value...)}}, nil
+}
+
+func (keystore *testKeystore) GetZonePrivateKeys(id []byte) ([]*keys.PrivateKey, error) {
+	return []*keys.PrivateKey{{Value: append([]byte{}, keystore.zoneKeypair.Private.Value...)}}, nil
+}
+
+func newTestKeystore(t *testing.T) *testKeystore {
+	serverKeypair, err := keys.New(keys.KEYTYPE_EC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zoneKeypair, err := keys.New(keys.KEYTYPE_EC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKeystore{serverKeypair: serverKeypair, zoneKeypair: zoneKeypair}
+}
+
+func createAcraStruct(t *testing.T, data []byte, publicKey *keys.PublicKey, context []byte) []byte {
+	acraStruct, err := acrawriter.CreateAcrastruct(data, publicKey, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return acraStruct
+}
+
+func TestReplaceRaw(t *testing.T) {
+	keystore := newTestKeystore(t)
+	first := createAcraStruct(t, []byte("first"), keystore.serverKeypair.Public, nil)
+	second := createAcraStruct(t, []byte("second"), keystore.serverKeypair.Public, nil)
+	foreign := createAcraStruct(t, []byte("foreign"), keystore.zoneKeypair.Public, nil)
+
+	data := bytes.Join([][]byte{[]byte("prefix "), first, []byte(` "" `), second, []byte(" "), foreign, []byte(" suffix")}, nil)
+	expected := bytes.Join([][]byte{[]byte("prefix first \"\" second "), foreign, []byte(" suffix")}, nil)
+
+	replayer := NewReplayer(keystore, []byte("client"))
+	output, err := replayer.Replay(FormatRaw, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output, expected) {
+		t.Fatalf("Unexpected output %q", output)
+	}
+	if stats := replayer.Stats(); stats.Decrypted != 2 || stats.Failed != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestReplaceRawWithZone(t *testing.T) {
+	keystore := newTestKeystore(t)
+	zoneID := zone.GenerateZoneID()
+	acraStruct := createAcraStruct(t, []byte("zone data"), keystore.zoneKeypair.Public, zoneID)
+
+	replayer := NewReplayer(keystore, []byte("client"))
+	replayer.SetWithZone(true)
+	// AcraStruct without preceding zone id can't be decrypted
+	output := replayer.ReplaceRaw(acraStruct)
+	if !bytes.Equal(output, acraStruct) {
+		t.Fatal("AcraStruct without zone id was changed")
+	}
+	data := append(append(append([]byte{}, zoneID...), ','), acraStruct...)
+	output = replayer.ReplaceRaw(data)
+	if expected := append(append(append([]byte{}, zoneID...), ','), "zone data"...); !bytes.Equal(output, expected) {
+		t.Fatalf("Unexpected output %q", output)
+	}
+	if stats := replayer.Stats(); stats.Decrypted != 1 || stats.Failed != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+
+	// zone set explicitly is used for AcraStructs without preceding zone id
+	replayer = NewReplayer(keystore, []byte("client"))
+	replayer.SetZoneID(zoneID)
+	if output := replayer.ReplaceRaw(acraStruct); !bytes.Equal(output, []byte("zone data")) {
+		t.Fatalf("Unexpected output with zone id %q", output)
+	}
+}
+
+func TestReplaceHex(t *testing.T) {
+	keystore := newTestKeystore(t)
+	acraStruct := createAcraStruct(t, []byte("dump"), keystore.serverKeypair.Public, nil)
+	testcases := []struct {
+		input    string
+		expected string
+	}{
+		// pg_dump
+		{"1\t\\x" + hex.EncodeToString(acraStruct) + "\n", "1\t\\x" + hex.EncodeToString([]byte("dump")) + "\n"},
+		// mysqldump
+		{"INSERT INTO t VALUES (1,0x" + hex.EncodeToString(acraStruct) + ");", "INSERT INTO t VALUES (1,0x" + hex.EncodeToString([]byte("dump")) + ");"},
+		// hex digits without AcraStructs
+		{"INSERT INTO t VALUES (10,0xabcd);", "INSERT INTO t VALUES (10,0xabcd);"},
+	}
+	replayer := NewReplayer(keystore, []byte("client"))
+	for i, testcase := range testcases {
+		output, err := replayer.Replay(FormatHex, []byte(testcase.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(output) != testcase.expected {
+			t.Errorf("%v. Unexpected output %q", i, output)
+		}
+	}
+}
+
+func pgMessage(messageType byte, payload []byte) []byte {
+	message := []byte{messageType, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(message[1:], uint32(len(payload)+4))
+	return append(message, payload...)
+}
+
+func pgDataRow(values ...[]byte) []byte {
+	payload := []byte{0, 0}
+	binary.BigEndian.PutUint16(payload, uint16(len(values)))
+	for _, value := range values {
+		length := make([]byte, 4)
+		if value == nil {
+			binary.BigEndian.PutUint32(length, 0xffffffff)
+		} else {
+			binary.BigEndian.PutUint32(length, uint32(len(value)))
+		}
+		payload = append(append(payload, length...), value...)
+	}
+	return pgMessage('D', payload)
+}
+
+func TestReplacePostgreSQL(t *testing.T) {
+	keystore := newTestKeystore(t)
+	acraStruct := createAcraStruct(t, []byte("binary"), keystore.serverKeypair.Public, nil)
+	hexAcraStruct := append([]byte(`\x`), hex.EncodeToString(acraStruct)...)
+	hexPlaintext := append([]byte(`\x`), hex.EncodeToString([]byte("binary"))...)
+	commandComplete := pgMessage('C', []byte("SELECT 1\x00"))
+
+	stream := bytes.Join([][]byte{{'N'}, pgMessage('R', []byte{0, 0, 0, 0}), pgDataRow([]byte("1"), acraStruct, nil, hexAcraStruct), commandComplete}, nil)
+	expected := bytes.Join([][]byte{{'N'}, pgMessage('R', []byte{0, 0, 0, 0}), pgDataRow([]byte("1"), []byte("binary"), nil, hexPlaintext), commandComplete}, nil)
+
+	replayer := NewReplayer(keystore, []byte("client"))
+	output, err := replayer.Replay(FormatPostgreSQL, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output, expected) {
+		t.Fatalf("Unexpected output %v", output)
+	}
+	if _, err := replayer.Replay(FormatPostgreSQL, stream[:len(stream)-1]); err != ErrMalformedStream {
+		t.Fatalf("Expected ErrMalformedStream, took %v", err)
+	}
+}
+
+func mysqlPacket(sequenceID byte, payload []byte) []byte {
+	return append([]byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), sequenceID}, payload...)
+}
+
+func TestReplaceMySQL(t *testing.T) {
+	keystore := newTestKeystore(t)
+	acraStruct := createAcraStruct(t, []byte("text row"), keystore.serverKeypair.Public, nil)
+	row := func(value []byte) []byte {
+		return append(append([]byte{1, '1'}, mysqlLengthEncodedPrefix(len(value))...), value...)
+	}
+	eof := mysqlPacket(4, []byte{0xfe, 0, 0, 2, 0})
+	stream := append(mysqlPacket(3, row(acraStruct)), eof...)
+	expected := append(mysqlPacket(3, row([]byte("text row"))), eof...)
+
+	replayer := NewReplayer(keystore, []byte("client"))
+	output, err := replayer.Replay(FormatMySQL, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output, expected) {
+		t.Fatalf("Unexpected output %v", output)
+	}
+	if _, err := replayer.Replay("unknown", stream); err != ErrUnsupportedFormat {
+		t.Fatalf("Expected ErrUnsupportedFormat, took %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package encryptor contains query processors that modify client's queries before AcraServer sends them to
+// the database. SearchableQueryEncryptor calculates salted hashes (HMAC) of configured columns in INSERT/UPDATE
+// queries and stores them in companion hash columns, and replaces equality comparisons of such columns with
+// comparisons of their hashes, so encrypted data can be searched by exact value. Range columns have companion index
+// columns with bucketed values which are used instead of encrypted values in range conditions.
+package encryptor
+
+import (
+	"errors"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ErrInvalidConfig returned when encryptor configuration has empty or duplicated values
+var ErrInvalidConfig = errors.New("invalid encryptor configuration")
+
+// SearchableColumn describes column which value's hash is stored in separate HashColumn to search by equality
+type SearchableColumn struct {
+	Column     string `yaml:"column"`
+	HashColumn string `yaml:"hash_column"`
+}
+
+// TableSchema describes table's columns which should be processed by encryptor. Encrypted lists columns which store
+// AcraStructs without searchable hash, searchable columns are encrypted too. Deterministic lists columns which values
+// AcraServer encrypts deterministically, so they may be used in JOIN and GROUP BY but leak equality of values (see
+// DeterministicEncryptor). Range columns are encrypted too and leak order of values with configured precision
+type TableSchema struct {
+	TableName     string              `yaml:"table"`
+	Encrypted     []string            `yaml:"encrypted,omitempty"`
+	Searchable    []*SearchableColumn `yaml:"searchable,omitempty"`
+	Deterministic []string            `yaml:"deterministic,omitempty"`
+	Range         []*RangeColumn      `yaml:"range,omitempty"`
+}
+
+// IsEncryptedColumn returns true if column is configured as encrypted, searchable or range
+func (schema *TableSchema) IsEncryptedColumn(column string) bool {
+	for _, encrypted := range schema.Encrypted {
+		if strings.EqualFold(encrypted, column) {
+			return true
+		}
+	}
+	return schema.GetSearchableColumn(column) != nil || schema.GetRangeColumn(column) != nil
+}
+
+// IsDeterministicColumn returns true if column is configured as deterministically encrypted
+func (schema *TableSchema) IsDeterministicColumn(column string) bool {
+	for _, deterministic := range schema.Deterministic {
+		if strings.EqualFold(deterministic, column) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSearchableColumn returns configuration of searchable column by name or nil if column isn't searchable
+func (schema *TableSchema) GetSearchableColumn(column string) *SearchableColumn {
+	for _, searchable := range schema.Searchable {
+		if strings.EqualFold(searchable.Column, column) {
+			return searchable
+		}
+	}
+	return nil
+}
+
+// GetRangeColumn returns configuration of range column by name or nil if column isn't range
+func (schema *TableSchema) GetRangeColumn(column string) *RangeColumn {
+	for _, rangeColumn := range schema.Range {
+		if strings.EqualFold(rangeColumn.Column, column) {
+			return rangeColumn
+		}
+	}
+	return nil
+}
+
+// GetCompanionSource returns name of searchable or range column which hash or index is stored in column or empty
+// string if column isn't companion column
+func (schema *TableSchema) GetCompanionSource(column string) string {
+	for _, searchable := range schema.Searchable {
+		if strings.EqualFold(searchable.HashColumn, column) {
+			return searchable.Column
+		}
+	}
+	for _, rangeColumn := range schema.Range {
+		if strings.EqualFold(rangeColumn.IndexColumn, column) {
+			return rangeColumn.Column
+		}
+	}
+	return ""
+}
+
+// Config describes tables and columns processed by encryptor and policy of NULL and empty values
+type Config struct {
+	Schemas []*TableSchema `yaml:"schemas"`
+	Values  *ValuesPolicy  `yaml:"values,omitempty"`
+}
+
+// LoadConfig parses encryptor configuration in YAML format and validates it
+func LoadConfig(data []byte) (*Config, error) {
+	config := &Config{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (config *Config) validate() error {
+	if err := config.Values.validate(); err != nil {
+		return err
+	}
+	tables := make(map[string]bool, len(config.Schemas))
+	for _, schema := range config.Schemas {
+		tableName := strings.ToLower(schema.TableName)
+		if tableName == "" || tables[tableName] {
+			return ErrInvalidConfig
+		}
+		tables[tableName] = true
+		columns := make(map[string]bool, len(schema.Searchable)*2)
+		for _, searchable := range schema.Searchable {
+			column := strings.ToLower(searchable.Column)
+			hashColumn := strings.ToLower(searchable.HashColumn)
+			if column == "" || hashColumn == "" || column == hashColumn || columns[column] || columns[hashColumn] {
+				return ErrInvalidConfig
+			}
+			columns[column] = true
+			columns[hashColumn] = true
+		}
+		for _, rangeColumn := range schema.Range {
+			column := strings.ToLower(rangeColumn.Column)
+			indexColumn := strings.ToLower(rangeColumn.IndexColumn)
+			if column == "" || indexColumn == "" || column == indexColumn || columns[column] || columns[indexColumn] {
+				return ErrInvalidConfig
+			}
+			if err := rangeColumn.validate(); err != nil {
+				return err
+			}
+			columns[column] = true
+			columns[indexColumn] = true
+		}
+		for _, encrypted := range append(append([]string{}, schema.Encrypted...), schema.Deterministic...) {
+			column := strings.ToLower(encrypted)
+			if column == "" || columns[column] {
+				return ErrInvalidConfig
+			}
+			columns[column] = true
+		}
+	}
+	return nil
+}
+
+// GetTableSchema returns configuration of table by name or nil if table isn't configured
+func (config *Config) GetTableSchema(tableName string) *TableSchema {
+	for _, schema := range config.Schemas {
+		if strings.EqualFold(schema.TableName, tableName) {
+			return schema
+		}
+	}
+	return nil
+}
+
+// HasEncryptedColumns returns true if table has columns encrypted by AcraServer in any way
+func (config *Config) HasEncryptedColumns(table string) bool {
+	schema := config.GetTableSchema(table)
+	return schema != nil && (len(schema.Encrypted) > 0 || len(schema.Searchable) > 0 || len(schema.Deterministic) > 0 || len(schema.Range) > 0)
+}
+
+// HasDeterministicColumns returns true if any table has deterministically encrypted columns
+func (config *Config) HasDeterministicColumns() bool {
+	for _, schema := range config.Schemas {
+		if len(schema.Deterministic) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// IsEncryptedColumn returns true if column of table may contain AcraStructs. If table is empty (database doesn't send
+// name of table with result's metadata) then column with such name in any configured table matches
+func (config *Config) IsEncryptedColumn(table, column string) bool {
+	if table != "" {
+		schema := config.GetTableSchema(table)
+		return schema != nil && schema.IsEncryptedColumn(column)
+	}
+	for _, schema := range config.Schemas {
+		if schema.IsEncryptedColumn(column) {
+			return true
+		}
+	}
+	return false
+}
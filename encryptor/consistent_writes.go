@@ -0,0 +1,95 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryptor
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// ErrCompanionWithoutColumn returned when query writes hash or index column without column which it's calculated from
+var ErrCompanionWithoutColumn = errors.New("hash or index column is written without its encrypted column")
+
+// RejectedQueryError returned instead of changed query when write can't update encrypted columns and their hash and
+// index columns in the same statement, so crash between separate writes or unprocessed query would leave them
+// inconsistent. Such queries shouldn't be sent to the database
+type RejectedQueryError struct {
+	Reason error
+}
+
+func (err *RejectedQueryError) Error() string {
+	return "query rejected to keep encrypted columns consistent with hash and index columns: " + err.Reason.Error()
+}
+
+// isWriteQuery returns true if query which can't be parsed starts as INSERT, UPDATE or REPLACE
+func isWriteQuery(query string) bool {
+	query = strings.ToLower(strings.TrimSpace(query))
+	for _, prefix := range []string{"insert", "update", "replace"} {
+		if strings.HasPrefix(query, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isWriteStatement returns true if statement may change encrypted columns and their companion columns
+func isWriteStatement(statement sqlparser.Statement) bool {
+	switch statement.(type) {
+	case *sqlparser.Insert, *sqlparser.Update:
+		return true
+	}
+	return false
+}
+
+// checkCompanionWrites returns ErrCompanionWithoutColumn if INSERT/UPDATE sets hash or index column but not column
+// which it's calculated from
+func (encryptor *SearchableQueryEncryptor) checkCompanionWrites(statement sqlparser.Statement) error {
+	switch statement := statement.(type) {
+	case *sqlparser.Insert:
+		schema := encryptor.config.GetTableSchema(statement.Table.Name.String())
+		if schema == nil {
+			return nil
+		}
+		for _, column := range statement.Columns {
+			source := schema.GetCompanionSource(column.String())
+			if source != "" && statement.Columns.FindColumn(sqlparser.NewColIdent(source)) == -1 {
+				return ErrCompanionWithoutColumn
+			}
+		}
+		schemas := map[string]*TableSchema{strings.ToLower(statement.Table.Name.String()): schema}
+		return checkUpdateCompanions(sqlparser.UpdateExprs(statement.OnDup), schemas)
+	case *sqlparser.Update:
+		return checkUpdateCompanions(statement.Exprs, encryptor.getTableSchemas(statement.TableExprs))
+	}
+	return nil
+}
+
+// checkUpdateCompanions returns ErrCompanionWithoutColumn if hash or index column is updated without its column
+func checkUpdateCompanions(exprs sqlparser.UpdateExprs, schemas map[string]*TableSchema) error {
+	for _, expr := range exprs {
+		source := getCompanionSource(schemas, expr.Name)
+		if source == "" {
+			continue
+		}
+		if findUpdateExpr(exprs, &sqlparser.ColName{Name: sqlparser.NewColIdent(source), Qualifier: expr.Name.Qualifier}) == -1 {
+			return ErrCompanionWithoutColumn
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryptor
+
+import (
+	"testing"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+func TestConsistentWrites(t *testing.T) {
+	config, err := LoadConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	queryEncryptor, err := NewSearchableQueryEncryptor(config, newTestKeystore(t), []byte("client"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected := []string{
+		"INSERT INTO users (id, email) SELECT id, email FROM old_users",
+		"INSERT INTO users VALUES (1, 'email')",
+		"INSERT INTO users (id, email_hash) VALUES (1, 'hash')",
+		"UPDATE users SET email_hash = 'hash' WHERE id = 1",
+		"UPDATE users SET email = concat('a', 'b') WHERE id = 1",
+		"INSERT INTO users (id, email) VALUES (1, 'email') ON DUPLICATE KEY UPDATE email_hash = 'hash'",
+		"INSERT INTO users (id, email_hash) VALUES (1, 'hash') RETURNING id, email",
+	}
+	allowed := []string{
+		"INSERT INTO users (id, email) VALUES (1, 'email')",
+		"UPDATE users SET email = 'email', email_hash = 'hash' WHERE id = 1",
+		"SELECT id FROM users WHERE email = concat('a', 'b')",
+		"SELECT id FROM users WHERE id = 1 RETURNING",
+		"DELETE FROM users WHERE id = 1",
+		// RETURNING of MariaDB is cut off before parsing
+		"INSERT INTO users (id, email) VALUES (1, 'email') RETURNING id, email",
+	}
+	for _, query := range rejected {
+		if _, _, err := queryEncryptor.OnQuery(query); err != nil {
+			if _, ok := err.(*RejectedQueryError); ok {
+				t.Errorf("Query rejected without consistent writes: %s", query)
+			}
+		}
+	}
+	queryEncryptor.SetConsistentWrites(true)
+	for _, query := range rejected {
+		if _, _, err := queryEncryptor.OnQuery(query); err == nil {
+			t.Errorf("Expected rejected query: %s", query)
+		} else if _, ok := err.(*RejectedQueryError); !ok {
+			t.Errorf("Expected RejectedQueryError, took %s", err)
+		}
+	}
+	for _, query := range allowed {
+		if _, _, err := queryEncryptor.OnQuery(query); err != nil {
+			if _, ok := err.(*RejectedQueryError); ok {
+				t.Errorf("Unexpected rejected query %s: %s", query, err)
+			}
+		}
+	}
+}
+
+type testKeyExpiryChecker map[string]bool
+
+func (checker testKeyExpiryChecker) IsKeyExpired(purpose string, id []byte) bool {
+	return checker[purpose+"/"+string(id)]
+}
+
+func TestExpiredKeyWrites(t *testing.T) {
+	config, err := LoadConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	queryEncryptor, err := NewSearchableQueryEncryptor(config, newTestKeystore(t), []byte("client"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checker := testKeyExpiryChecker{}
+	queryEncryptor.SetKeyExpiryChecker(checker)
+	insert := "INSERT INTO users (id, email) VALUES (1, 'email')"
+	if _, _, err := queryEncryptor.OnQuery(insert); err != nil {
+		t.Fatal(err)
+	}
+	checker[keystore.KeyPurposeHMAC+"/client"] = true
+	for _, query := range []string{insert, "UPDATE users SET email = 'email' WHERE id = 1"} {
+		if _, _, err := queryEncryptor.OnQuery(query); err != ErrExpiredKeyQuery {
+			t.Errorf("Expected rejection of %s with expired key, took %v", query, err)
+		}
+	}
+	for _, query := range []string{"SELECT id FROM users WHERE email = 'email'", "SELECT id FROM other WHERE id = 1"} {
+		if _, _, err := queryEncryptor.OnQuery(query); err != nil {
+			t.Errorf("Unexpected error of %s: %s", query, err)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryptor
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"encoding/base64"
+	"errors"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/utils"
+)
+
+/*
+Deterministic encryption makes equal plaintexts of client encrypted into equal values, so the database can compare
+encrypted values in JOINs, GROUP BY, DISTINCT and equality conditions without decryption.
+
+WARNING: deterministic encryption leaks equality of values. Anybody with access to the database sees which rows share
+the same value in any deterministic column of the same client (including columns of other tables) and how often each
+value occurs, so values from small sets (statuses, booleans, countries) may be recovered by frequency analysis. Use it
+only for columns which really need comparisons on the database side and prefer AcraStructs with searchable hashes
+otherwise.
+
+Construction is SIV-style: synthetic IV is HMAC-SHA256 of plaintext truncated to 16 bytes, plaintext is encrypted with
+AES-256 in CTR mode with this IV. On decryption IV is recalculated from decrypted plaintext and compared, so modified
+values are rejected. Both keys are derived from client's HMAC key. Encrypted value is stored as text:
+
+	DeterministicPrefix | base64url(IV | ciphertext)
+*/
+
+// DeterministicPrefix begins deterministically encrypted values
+var DeterministicPrefix = []byte("ACRADET1")
+
+// deterministicIVLength is length of synthetic IV
+const deterministicIVLength = aes.BlockSize
+
+// Labels used to derive keys of deterministic encryption from client's HMAC key
+var (
+	deterministicEncryptionKeyLabel     = []byte("acra deterministic encryption key")
+	deterministicAuthenticationKeyLabel = []byte("acra deterministic authentication key")
+)
+
+// Errors returned by DeterministicEncryptor
+var (
+	ErrNotDeterministicValue     = errors.New("value isn't deterministically encrypted")
+	ErrInvalidDeterministicValue = errors.New("deterministically encrypted value is corrupted or encrypted with other key")
+)
+
+// DeterministicEncryptor encrypts values of deterministic columns with keys of client
+type DeterministicEncryptor struct {
+	keystorage keystore.KeyStore
+	clientID   []byte
+}
+
+// NewDeterministicEncryptor returns DeterministicEncryptor which uses keys derived from clientID's HMAC key
+func NewDeterministicEncryptor(keystorage keystore.KeyStore, clientID []byte) *DeterministicEncryptor {
+	return &DeterministicEncryptor{keystorage: keystorage, clientID: clientID}
+}
+
+// getKeys returns encryption and authentication keys which should be zeroed after usage
+func (encryptor *DeterministicEncryptor) getKeys() ([]byte, []byte, error) {
+	key, err := encryptor.keystorage.GetHMACSecretKey(encryptor.clientID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer utils.FillSlice(byte(0), key)
+	return CalculateHash(key, deterministicEncryptionKeyLabel), CalculateHash(key, deterministicAuthenticationKeyLabel), nil
+}
+
+func deterministicXORKeyStream(encryptionKey, iv, input []byte) ([]byte, error) {
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	output := make([]byte, len(input))
+	cipher.NewCTR(block, iv).XORKeyStream(output, input)
+	return output, nil
+}
+
+// Encrypt returns encrypted value which is the same for equal data
+func (encryptor *DeterministicEncryptor) Encrypt(data []byte) ([]byte, error) {
+	encryptionKey, authenticationKey, err := encryptor.getKeys()
+	if err != nil {
+		return nil, err
+	}
+	defer utils.FillSlice(byte(0), encryptionKey)
+	defer utils.FillSlice(byte(0), authenticationKey)
+	iv := CalculateHash(authenticationKey, data)[:deterministicIVLength]
+	ciphertext, err := deterministicXORKeyStream(encryptionKey, iv, data)
+	if err != nil {
+		return nil, err
+	}
+	encoded := make([]byte, len(DeterministicPrefix)+base64.RawURLEncoding.EncodedLen(len(iv)+len(ciphertext)))
+	copy(encoded, DeterministicPrefix)
+	base64.RawURLEncoding.Encode(encoded[len(DeterministicPrefix):], append(iv, ciphertext...))
+	return encoded, nil
+}
+
+// IsDeterministicValue returns true if value has prefix of deterministically encrypted values
+func IsDeterministicValue(value []byte) bool {
+	return bytes.HasPrefix(value, DeterministicPrefix)
+}
+
+// Decrypt returns plaintext of deterministically encrypted value
+func (encryptor *DeterministicEncryptor) Decrypt(value []byte) ([]byte, error) {
+	if !IsDeterministicValue(value) {
+		return nil, ErrNotDeterministicValue
+	}
+	decoded := make([]byte, base64.RawURLEncoding.DecodedLen(len(value)-len(DeterministicPrefix)))
+	n, err := base64.RawURLEncoding.Decode(decoded, value[len(DeterministicPrefix):])
+	if err != nil || n < deterministicIVLength {
+		return nil, ErrInvalidDeterministicValue
+	}
+	iv, ciphertext := decoded[:deterministicIVLength], decoded[deterministicIVLength:n]
+	encryptionKey, authenticationKey, err := encryptor.getKeys()
+	if err != nil {
+		return nil, err
+	}
+	defer utils.FillSlice(byte(0), encryptionKey)
+	defer utils.FillSlice(byte(0), authenticationKey)
+	plaintext, err := deterministicXORKeyStream(encryptionKey, iv, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(iv, CalculateHash(authenticationKey, plaintext)[:deterministicIVLength]) {
+		utils.FillSlice(byte(0), plaintext)
+		return nil, ErrInvalidDeterministicValue
+	}
+	return plaintext, nil
+}
+
+// DecryptValue returns plaintext and true if value is deterministically encrypted and can be decrypted, otherwise
+// value should be left as is. Safe to call on nil DeterministicEncryptor
+func (encryptor *DeterministicEncryptor) DecryptValue(value []byte) ([]byte, bool) {
+	if encryptor == nil || !IsDeterministicValue(value) {
+		return nil, false
+	}
+	plaintext, err := encryptor.Decrypt(value)
+	if err != nil {
+		return nil, false
+	}
+	return plaintext, true
+}
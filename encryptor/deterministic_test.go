@@ -0,0 +1,163 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryptor
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDeterministicEncryptor(t *testing.T) {
+	keystorage := newTestKeystore(t)
+	deterministic := NewDeterministicEncryptor(keystorage, []byte("client"))
+	data := []byte("some data")
+
+	encrypted, err := deterministic.Encrypt(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsDeterministicValue(encrypted) || bytes.Contains(encrypted, data) {
+		t.Fatal("Incorrect encrypted value")
+	}
+	encryptedAgain, err := deterministic.Encrypt(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encrypted, encryptedAgain) {
+		t.Fatal("Encrypted values of equal data must be equal")
+	}
+	otherEncrypted, err := deterministic.Encrypt([]byte("other data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(encrypted, otherEncrypted) {
+		t.Fatal("Encrypted values of different data must be different")
+	}
+
+	decrypted, err := deterministic.Decrypt(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatal("Decrypted value isn't equal to data")
+	}
+	if _, err := deterministic.Decrypt(data); err != ErrNotDeterministicValue {
+		t.Fatalf("Expected ErrNotDeterministicValue, took %v", err)
+	}
+
+	tampered := append([]byte{}, encrypted...)
+	if tampered[len(tampered)-1] == 'A' {
+		tampered[len(tampered)-1] = 'B'
+	} else {
+		tampered[len(tampered)-1] = 'A'
+	}
+	if _, err := deterministic.Decrypt(tampered); err != ErrInvalidDeterministicValue {
+		t.Fatalf("Expected ErrInvalidDeterministicValue, took %v", err)
+	}
+	if _, ok := deterministic.DecryptValue(tampered); ok {
+		t.Fatal("Tampered value mustn't be decrypted")
+	}
+
+	keystorage.hmacKey = []byte("other hmac key")
+	if _, err := deterministic.Decrypt(encrypted); err != ErrInvalidDeterministicValue {
+		t.Fatalf("Expected ErrInvalidDeterministicValue with other key, took %v", err)
+	}
+
+	var nilEncryptor *DeterministicEncryptor
+	if _, ok := nilEncryptor.DecryptValue(encrypted); ok {
+		t.Fatal("nil encryptor mustn't decrypt values")
+	}
+}
+
+const testDeterministicConfig = `
+schemas:
+  - table: users
+    deterministic:
+      - city
+  - table: orders
+    deterministic:
+      - city
+`
+
+func TestDeterministicQueryEncryptor(t *testing.T) {
+	keystorage := newTestKeystore(t)
+	config, err := LoadConfig([]byte(testDeterministicConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.HasDeterministicColumns() {
+		t.Fatal("Expected deterministic columns")
+	}
+	clientID := []byte("client")
+	queryEncryptor, err := NewSearchableQueryEncryptor(config, keystorage, clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := NewDeterministicEncryptor(keystorage, clientID).Encrypt([]byte("Kyiv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changedQueries := []string{
+		"INSERT INTO users (id, city) VALUES (1, 'Kyiv')",
+		"UPDATE users SET city='Kyiv' WHERE id=1",
+		"SELECT id FROM users WHERE city='Kyiv'",
+		"SELECT id FROM users WHERE 'Kyiv'=city",
+		"SELECT id FROM users WHERE city IN ('Kyiv', 'Lviv')",
+		"SELECT u.id FROM users AS u JOIN orders AS o ON u.city=o.city WHERE o.city='Kyiv'",
+	}
+	for i, query := range changedQueries {
+		newQuery, changed, err := queryEncryptor.OnQuery(query)
+		if err != nil {
+			t.Fatalf("%v. Unexpected error: %v", i, err)
+		}
+		if !changed {
+			t.Fatalf("%v. Expected changed query", i)
+		}
+		if !strings.Contains(newQuery, string(encrypted)) || strings.Contains(newQuery, "'Kyiv'") {
+			t.Fatalf("%v. Expected encrypted value in query: %s", i, newQuery)
+		}
+	}
+
+	unchangedQueries := []string{
+		"SELECT id FROM users WHERE city=$1",
+		"SELECT id FROM users WHERE name='Kyiv'",
+		"INSERT INTO clients (id, city) VALUES (1, 'Kyiv')",
+	}
+	for i, query := range unchangedQueries {
+		newQuery, changed, err := queryEncryptor.OnQuery(query)
+		if err != nil {
+			t.Fatalf("%v. Unexpected error: %v", i, err)
+		}
+		if changed || newQuery != query {
+			t.Fatalf("%v. Query shouldn't be changed: %s", i, newQuery)
+		}
+	}
+
+	invalidConfigs := []string{
+		"schemas:\n  - table: users\n    deterministic: ['']\n",
+		"schemas:\n  - table: users\n    deterministic: [city, city]\n",
+		"schemas:\n  - table: users\n    encrypted: [city]\n    deterministic: [city]\n",
+		"schemas:\n  - table: users\n    deterministic: [email_hash]\n    searchable:\n      - column: email\n        hash_column: email_hash\n",
+	}
+	for i, invalidConfig := range invalidConfigs {
+		if _, err := LoadConfig([]byte(invalidConfig)); err != ErrInvalidConfig {
+			t.Errorf("%v. Expected ErrInvalidConfig, took %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryptor
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/cossacklabs/acra/decryptor/base"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/utils"
+)
+
+// CalculateHash returns HMAC-SHA256 of data with key as secret
+func CalculateHash(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// getPlaintext returns data as is or plaintext and true if data is AcraStruct which was decrypted with clientID's
+// storage key. Decrypted plaintext should be zeroed after usage
+func getPlaintext(data, clientID []byte, keystorage keystore.KeyStore) ([]byte, bool, error) {
+	if !bytes.HasPrefix(data, base.TAG_BEGIN) {
+		return data, false, nil
+	}
+	privateKey, err := keystorage.GetServerDecryptionPrivateKey(clientID)
+	if err != nil {
+		return nil, false, err
+	}
+	decrypted, err := base.DecryptAcrastruct(data, privateKey, nil)
+	utils.FillSlice(byte(0), privateKey.Value)
+	if err != nil {
+		// AcraStruct may be encrypted with previous version of rotated key
+		privateKeys, keysErr := keystorage.GetServerDecryptionPrivateKeys(clientID)
+		if keysErr != nil {
+			return nil, false, err
+		}
+		decrypted, err = base.DecryptRotatedAcrastruct(data, privateKeys, nil)
+		for _, privateKey := range privateKeys {
+			utils.FillSlice(byte(0), privateKey.Value)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	return decrypted, true, nil
+}
+
+// CalculateSearchableHash returns hex encoded hash of data which should be stored in hash column.
+// If data is AcraStruct then it will be decrypted with clientID's storage key and hash will be calculated
+// for plaintext value.
+func CalculateSearchableHash(data, clientID []byte, keystorage keystore.KeyStore) (string, error) {
+	data, decrypted, err := getPlaintext(data, clientID, keystorage)
+	if err != nil {
+		return "", err
+	}
+	if decrypted {
+		defer utils.FillSlice(byte(0), data)
+	}
+	key, err := keystorage.GetHMACSecretKey(clientID)
+	if err != nil {
+		return "", err
+	}
+	defer utils.FillSlice(byte(0), key)
+	return hex.EncodeToString(CalculateHash(key, data)), nil
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryptor
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Range columns are encrypted columns which have companion index column with bucketed plaintext value: integer number
+of bucket of configured precision. Bucket of value isn't encrypted, so order of values and approximate value are
+revealed with precision of bucket. Use it only when range queries over encrypted data are required and choose precision
+as coarse as possible.
+
+Comparisons <, <=, >, >= and BETWEEN of range column with value are replaced with comparisons of index column with
+bucket of value. Such condition matches all rows from buckets of bounds, so result may contain rows outside of
+requested range which application should filter after decryption. NOT BETWEEN and negated comparisons can't be
+rewritten in such way and are left as is.
+*/
+
+// Types of range columns
+const (
+	RangeTypeInteger = "integer"
+	RangeTypeDate    = "date"
+)
+
+// Precisions of date range columns
+const (
+	RangePrecisionDay   = "day"
+	RangePrecisionMonth = "month"
+	RangePrecisionYear  = "year"
+)
+
+// dateLayouts lists supported formats of date values
+var dateLayouts = []string{"2006-01-02", "2006-01-02 15:04:05", "2006-01-02T15:04:05", time.RFC3339, time.RFC3339Nano}
+
+// ErrInvalidRangeValue returned when value of range column can't be parsed according to column's type
+var ErrInvalidRangeValue = errors.New("invalid value of range column")
+
+// RangeColumn describes encrypted column which value's bucket is stored in separate IndexColumn to search by range.
+// Precision is width of bucket for integer columns and one of day, month, year for date columns
+type RangeColumn struct {
+	Column      string `yaml:"column"`
+	IndexColumn string `yaml:"index_column"`
+	Type        string `yaml:"type"`
+	Precision   string `yaml:"precision"`
+}
+
+// validate returns ErrInvalidConfig if type or precision of column is incorrect
+func (column *RangeColumn) validate() error {
+	switch strings.ToLower(column.Type) {
+	case RangeTypeInteger:
+		width, err := strconv.ParseInt(column.Precision, 10, 64)
+		if err != nil || width <= 0 {
+			return ErrInvalidConfig
+		}
+	case RangeTypeDate:
+		switch strings.ToLower(column.Precision) {
+		case RangePrecisionDay, RangePrecisionMonth, RangePrecisionYear:
+		default:
+			return ErrInvalidConfig
+		}
+	default:
+		return ErrInvalidConfig
+	}
+	return nil
+}
+
+// Bucket returns number of bucket of plaintext value
+func (column *RangeColumn) Bucket(value []byte) (int64, error) {
+	if strings.EqualFold(column.Type, RangeTypeDate) {
+		return column.dateBucket(string(value))
+	}
+	width, err := strconv.ParseInt(column.Precision, 10, 64)
+	if err != nil || width <= 0 {
+		return 0, ErrInvalidConfig
+	}
+	number, err := strconv.ParseInt(strings.TrimSpace(string(value)), 10, 64)
+	if err != nil {
+		return 0, ErrInvalidRangeValue
+	}
+	return floorDiv(number, width), nil
+}
+
+// floorDiv divides with rounding down to keep order of buckets for negative values
+func floorDiv(value, width int64) int64 {
+	bucket := value / width
+	if value%width != 0 && value < 0 {
+		bucket--
+	}
+	return bucket
+}
+
+func (column *RangeColumn) dateBucket(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		date, err := time.Parse(layout, value)
+		if err != nil {
+			continue
+		}
+		date = date.UTC()
+		switch strings.ToLower(column.Precision) {
+		case RangePrecisionDay:
+			return floorDiv(date.Unix(), 24*60*60), nil
+		case RangePrecisionMonth:
+			return int64(date.Year())*12 + int64(date.Month()) - 1, nil
+		case RangePrecisionYear:
+			return int64(date.Year()), nil
+		}
+		return 0, ErrInvalidConfig
+	}
+	return 0, ErrInvalidRangeValue
+}
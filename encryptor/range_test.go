@@ -0,0 +1,142 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryptor
+
+import (
+	"testing"
+)
+
+func TestRangeColumnBucket(t *testing.T) {
+	testCases := []struct {
+		column RangeColumn
+		value  string
+		bucket int64
+	}{
+		{RangeColumn{Type: RangeTypeInteger, Precision: "100"}, "0", 0},
+		{RangeColumn{Type: RangeTypeInteger, Precision: "100"}, "199", 1},
+		{RangeColumn{Type: RangeTypeInteger, Precision: "100"}, "200", 2},
+		{RangeColumn{Type: RangeTypeInteger, Precision: "100"}, "-1", -1},
+		{RangeColumn{Type: RangeTypeInteger, Precision: "100"}, "-100", -1},
+		{RangeColumn{Type: RangeTypeInteger, Precision: "100"}, "-101", -2},
+		{RangeColumn{Type: RangeTypeDate, Precision: RangePrecisionDay}, "1970-01-02", 1},
+		{RangeColumn{Type: RangeTypeDate, Precision: RangePrecisionDay}, "1969-12-31 23:00:00", -1},
+		{RangeColumn{Type: RangeTypeDate, Precision: RangePrecisionMonth}, "2018-03-15", 2018*12 + 2},
+		{RangeColumn{Type: RangeTypeDate, Precision: RangePrecisionYear}, "2018-03-15T10:00:00Z", 2018},
+	}
+	for i, testCase := range testCases {
+		bucket, err := testCase.column.Bucket([]byte(testCase.value))
+		if err != nil {
+			t.Fatalf("%v. Unexpected error: %v", i, err)
+		}
+		if bucket != testCase.bucket {
+			t.Fatalf("%v. Expected bucket %v, took %v", i, testCase.bucket, bucket)
+		}
+	}
+	integerColumn := RangeColumn{Type: RangeTypeInteger, Precision: "10"}
+	if _, err := integerColumn.Bucket([]byte("abc")); err != ErrInvalidRangeValue {
+		t.Fatalf("Expected ErrInvalidRangeValue, took %v", err)
+	}
+	dateColumn := RangeColumn{Type: RangeTypeDate, Precision: RangePrecisionDay}
+	if _, err := dateColumn.Bucket([]byte("15.03.2018")); err != ErrInvalidRangeValue {
+		t.Fatalf("Expected ErrInvalidRangeValue, took %v", err)
+	}
+}
+
+const testRangeConfig = `
+schemas:
+  - table: orders
+    range:
+      - column: amount
+        index_column: amount_index
+        type: integer
+        precision: 100
+      - column: created
+        index_column: created_index
+        type: date
+        precision: month
+`
+
+func TestRangeQueryEncryptor(t *testing.T) {
+	keystorage := newTestKeystore(t)
+	config, err := LoadConfig([]byte(testRangeConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.IsEncryptedColumn("orders", "amount") || config.IsEncryptedColumn("orders", "amount_index") {
+		t.Fatal("Range column must be encrypted and its index column mustn't")
+	}
+	encryptor, err := NewSearchableQueryEncryptor(config, keystorage, []byte("client"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		query    string
+		expected string
+	}{
+		{"INSERT INTO orders (id, amount) VALUES (1, 250)", "insert into orders(id, amount, amount_index) values (1, 250, 2)"},
+		{"INSERT INTO orders (id, amount, amount_index) VALUES (1, '250', 0), (2, NULL, 0)", "insert into orders(id, amount, amount_index) values (1, '250', 2), (2, null, null)"},
+		{"INSERT INTO orders (id, created) VALUES (1, '2018-03-15')", "insert into orders(id, created, created_index) values (1, '2018-03-15', 24218)"},
+		{"UPDATE orders SET amount=50 WHERE id=1", "update orders set amount = 50, amount_index = 0 where id = 1"},
+		{"SELECT id FROM orders WHERE amount > 250", "select id from orders where amount_index >= 2"},
+		{"SELECT id FROM orders WHERE amount <= 250", "select id from orders where amount_index <= 2"},
+		{"SELECT id FROM orders WHERE 250 < amount", "select id from orders where amount_index >= 2"},
+		{"SELECT id FROM orders AS o WHERE o.amount BETWEEN 150 AND 250 AND id > 1", "select id from orders as o where o.amount_index between 1 and 2 and id > 1"},
+		{"SELECT id FROM orders WHERE created >= '2018-03-15'", "select id from orders where created_index >= 24218"},
+	}
+	for i, testCase := range testCases {
+		newQuery, changed, err := encryptor.OnQuery(testCase.query)
+		if err != nil {
+			t.Fatalf("%v. Unexpected error: %v", i, err)
+		}
+		if !changed || newQuery != testCase.expected {
+			t.Fatalf("%v. Expected %s, took %s", i, testCase.expected, newQuery)
+		}
+	}
+
+	unchangedQueries := []string{
+		"SELECT id FROM orders WHERE amount > $1",
+		"SELECT id FROM orders WHERE amount NOT BETWEEN 150 AND 250",
+		"SELECT id FROM orders WHERE id > 10",
+	}
+	for i, query := range unchangedQueries {
+		newQuery, changed, err := encryptor.OnQuery(query)
+		if err != nil {
+			t.Fatalf("%v. Unexpected error: %v", i, err)
+		}
+		if changed || newQuery != query {
+			t.Fatalf("%v. Query shouldn't be changed: %s", i, newQuery)
+		}
+	}
+	if _, _, err := encryptor.OnQuery("SELECT id FROM orders WHERE amount > 'abc'"); err != ErrInvalidRangeValue {
+		t.Fatalf("Expected ErrInvalidRangeValue, took %v", err)
+	}
+
+	invalidConfigs := []string{
+		"schemas:\n  - table: orders\n    range:\n      - column: amount\n        type: integer\n        precision: 10\n",
+		"schemas:\n  - table: orders\n    range:\n      - column: amount\n        index_column: amount\n        type: integer\n        precision: 10\n",
+		"schemas:\n  - table: orders\n    range:\n      - column: amount\n        index_column: amount_index\n        type: integer\n        precision: 0\n",
+		"schemas:\n  - table: orders\n    range:\n      - column: amount\n        index_column: amount_index\n        type: date\n        precision: week\n",
+		"schemas:\n  - table: orders\n    range:\n      - column: amount\n        index_column: amount_index\n        type: float\n        precision: 10\n",
+		"schemas:\n  - table: orders\n    encrypted: [amount]\n    range:\n      - column: amount\n        index_column: amount_index\n        type: integer\n        precision: 10\n",
+	}
+	for i, invalidConfig := range invalidConfigs {
+		if _, err := LoadConfig([]byte(invalidConfig)); err != ErrInvalidConfig {
+			t.Errorf("%v. Expected ErrInvalidConfig, took %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryptor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SensitiveColumnPattern is substring of column name which marks column as probably sensitive. Values of searchable
+// columns are usually looked up by exact value so such columns are proposed as searchable
+type SensitiveColumnPattern struct {
+	Substring  string
+	Searchable bool
+}
+
+// DefaultSensitiveColumnPatterns are substrings of names of columns which usually contain personal data
+var DefaultSensitiveColumnPatterns = []SensitiveColumnPattern{
+	{"email", true}, {"phone", true}, {"ssn", true}, {"passport", true}, {"tax_id", true}, {"iban", true},
+	{"card", false}, {"account", false}, {"address", false}, {"birth", false}, {"dob", false}, {"salary", false},
+	{"first_name", false}, {"last_name", false}, {"full_name", false}, {"surname", false}, {"license", false},
+	{"medical", false}, {"diagnosis", false}, {"secret", false},
+}
+
+// suffixes of companion columns which shouldn't be proposed as sensitive
+var companionColumnSuffixes = []string{"_hash", "_index"}
+
+// SensitiveColumn is column of database proposed to be encrypted and reason why it was selected
+type SensitiveColumn struct {
+	Table      string
+	Column     string
+	Type       string
+	Reason     string
+	Searchable bool
+}
+
+// FindSensitiveColumns returns columns which names contain one of patterns or which already have binary type and may
+// store encrypted data. Columns are sorted by table and column
+func FindSensitiveColumns(schema DatabaseSchema, patterns []SensitiveColumnPattern) []SensitiveColumn {
+	sensitiveColumns := make([]SensitiveColumn, 0)
+	for table, columns := range schema {
+	columnLoop:
+		for column, columnType := range columns {
+			for _, suffix := range companionColumnSuffixes {
+				if strings.HasSuffix(column, suffix) {
+					continue columnLoop
+				}
+			}
+			for _, pattern := range patterns {
+				if strings.Contains(column, strings.ToLower(pattern.Substring)) {
+					sensitiveColumns = append(sensitiveColumns, SensitiveColumn{Table: table, Column: column, Type: columnType,
+						Reason: fmt.Sprintf("name contains %q", pattern.Substring), Searchable: pattern.Searchable})
+					continue columnLoop
+				}
+			}
+			if binaryColumnTypes[columnType] {
+				sensitiveColumns = append(sensitiveColumns, SensitiveColumn{Table: table, Column: column, Type: columnType,
+					Reason: "binary type may store encrypted data"})
+			}
+		}
+	}
+	sort.Slice(sensitiveColumns, func(i, j int) bool {
+		if sensitiveColumns[i].Table != sensitiveColumns[j].Table {
+			return sensitiveColumns[i].Table < sensitiveColumns[j].Table
+		}
+		return sensitiveColumns[i].Column < sensitiveColumns[j].Column
+	})
+	return sensitiveColumns
+}
+
+// ScaffoldConfig returns encryptor configuration which encrypts sensitive columns. Searchable columns get companion
+// hash columns named <column>_hash which should be added to database before configuration is used
+func ScaffoldConfig(sensitiveColumns []SensitiveColumn) *Config {
+	config := &Config{}
+	for _, sensitiveColumn := range sensitiveColumns {
+		schema := config.GetTableSchema(sensitiveColumn.Table)
+		if schema == nil {
+			schema = &TableSchema{TableName: sensitiveColumn.Table}
+			config.Schemas = append(config.Schemas, schema)
+		}
+		if sensitiveColumn.Searchable {
+			schema.Searchable = append(schema.Searchable, &SearchableColumn{Column: sensitiveColumn.Column, HashColumn: sensitiveColumn.Column + "_hash"})
+		} else {
+			schema.Encrypted = append(schema.Encrypted, sensitiveColumn.Column)
+		}
+	}
+	return config
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryptor
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestScaffoldConfig(t *testing.T) {
+	schema := DatabaseSchema{
+		"users": {"id": "integer", "email": "character varying", "email_hash": "text", "home_address": "text",
+			"photo": "bytea", "created_at": "timestamp"},
+		"logs": {"id": "integer", "message": "text"},
+	}
+	sensitiveColumns := FindSensitiveColumns(schema, DefaultSensitiveColumnPatterns)
+	expected := []SensitiveColumn{
+		{Table: "users", Column: "email", Type: "character varying", Reason: `name contains "email"`, Searchable: true},
+		{Table: "users", Column: "home_address", Type: "text", Reason: `name contains "address"`},
+		{Table: "users", Column: "photo", Type: "bytea", Reason: "binary type may store encrypted data"},
+	}
+	if len(sensitiveColumns) != len(expected) {
+		t.Fatalf("Expected %v, took %v", expected, sensitiveColumns)
+	}
+	for i := range expected {
+		if sensitiveColumns[i] != expected[i] {
+			t.Errorf("Expected %v, took %v", expected[i], sensitiveColumns[i])
+		}
+	}
+
+	data, err := yaml.Marshal(ScaffoldConfig(sensitiveColumns))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(data)
+	if err != nil {
+		t.Fatalf("Scaffolded config is invalid: %s\n%s", err, data)
+	}
+	users := config.GetTableSchema("users")
+	if users == nil || config.GetTableSchema("logs") != nil {
+		t.Fatalf("Incorrect tables in scaffolded config:\n%s", data)
+	}
+	if searchable := users.GetSearchableColumn("email"); searchable == nil || searchable.HashColumn != "email_hash" {
+		t.Errorf("Expected searchable email column:\n%s", data)
+	}
+	if !users.IsEncryptedColumn("home_address") || !users.IsEncryptedColumn("photo") || users.IsEncryptedColumn("id") {
+		t.Errorf("Incorrect encrypted columns:\n%s", data)
+	}
+}
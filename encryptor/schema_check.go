@@ -0,0 +1,140 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryptor
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Queries which return columns of tables from current schema of PostgreSQL and current database of MySQL
+const (
+	postgresqlSchemaQuery = "SELECT table_name, column_name, data_type FROM information_schema.columns WHERE table_schema = current_schema()"
+	mysqlSchemaQuery      = "SELECT table_name, column_name, data_type FROM information_schema.columns WHERE table_schema = DATABASE()"
+)
+
+// types of columns which may store values of configured columns, in lower case as returned by information_schema
+var (
+	binaryColumnTypes = map[string]bool{
+		"bytea": true, "binary": true, "varbinary": true,
+		"tinyblob": true, "blob": true, "mediumblob": true, "longblob": true,
+	}
+	textColumnTypes = map[string]bool{
+		"text": true, "character varying": true, "character": true,
+		"char": true, "varchar": true, "tinytext": true, "mediumtext": true, "longtext": true,
+	}
+	integerColumnTypes = map[string]bool{
+		"smallint": true, "integer": true, "bigint": true, "numeric": true,
+		"tinyint": true, "mediumint": true, "int": true, "decimal": true,
+	}
+)
+
+// DatabaseSchema maps lower cased names of tables to their columns and types of columns
+type DatabaseSchema map[string]map[string]string
+
+// SchemaWarning describes column from encryptor configuration which doesn't exist in database or has type which
+// can't store values AcraServer writes to it
+type SchemaWarning struct {
+	Table   string
+	Column  string
+	Message string
+}
+
+// String returns human readable description of warning
+func (warning SchemaWarning) String() string {
+	if warning.Column == "" {
+		return fmt.Sprintf("%s: %s", warning.Table, warning.Message)
+	}
+	return fmt.Sprintf("%s.%s: %s", warning.Table, warning.Column, warning.Message)
+}
+
+// IntrospectSchema reads tables and types of their columns from information_schema of database
+func IntrospectSchema(db *sql.DB, useMySQL bool) (DatabaseSchema, error) {
+	query := postgresqlSchemaQuery
+	if useMySQL {
+		query = mysqlSchemaQuery
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	schema := DatabaseSchema{}
+	for rows.Next() {
+		var table, column, columnType string
+		if err := rows.Scan(&table, &column, &columnType); err != nil {
+			return nil, err
+		}
+		table = strings.ToLower(table)
+		if schema[table] == nil {
+			schema[table] = map[string]string{}
+		}
+		schema[table][strings.ToLower(column)] = strings.ToLower(columnType)
+	}
+	return schema, rows.Err()
+}
+
+// ValidateSchema compares configured tables with database schema and returns warnings about tables and columns which
+// don't exist and about columns which types can't store encrypted values, hashes or range indexes. Warnings are
+// sorted by table and column
+func (config *Config) ValidateSchema(schema DatabaseSchema) []SchemaWarning {
+	warnings := make([]SchemaWarning, 0)
+	for _, tableSchema := range config.Schemas {
+		columns, ok := schema[strings.ToLower(tableSchema.TableName)]
+		if !ok {
+			warnings = append(warnings, SchemaWarning{Table: tableSchema.TableName, Message: "table doesn't exist"})
+			continue
+		}
+		check := func(column, expected string, allowedTypes ...map[string]bool) {
+			columnType, ok := columns[strings.ToLower(column)]
+			if !ok {
+				warnings = append(warnings, SchemaWarning{Table: tableSchema.TableName, Column: column, Message: "column doesn't exist"})
+				return
+			}
+			for _, types := range allowedTypes {
+				if types[columnType] {
+					return
+				}
+			}
+			warnings = append(warnings, SchemaWarning{Table: tableSchema.TableName, Column: column,
+				Message: fmt.Sprintf("column has type %s but %s is expected", columnType, expected)})
+		}
+		for _, column := range tableSchema.Encrypted {
+			check(column, "binary type", binaryColumnTypes)
+		}
+		for _, column := range tableSchema.Deterministic {
+			check(column, "binary type", binaryColumnTypes)
+		}
+		for _, searchable := range tableSchema.Searchable {
+			check(searchable.Column, "binary type", binaryColumnTypes)
+			check(searchable.HashColumn, "text or binary type", textColumnTypes, binaryColumnTypes)
+		}
+		for _, rangeColumn := range tableSchema.Range {
+			check(rangeColumn.Column, "binary type", binaryColumnTypes)
+			check(rangeColumn.IndexColumn, "integer type", integerColumnTypes)
+		}
+	}
+	sort.SliceStable(warnings, func(i, j int) bool {
+		if warnings[i].Table != warnings[j].Table {
+			return warnings[i].Table < warnings[j].Table
+		}
+		return warnings[i].Column < warnings[j].Column
+	})
+	return warnings
+}
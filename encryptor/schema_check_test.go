@@ -0,0 +1,69 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryptor
+
+import (
+	"testing"
+)
+
+func TestValidateSchema(t *testing.T) {
+	config, err := LoadConfig([]byte(`schemas:
+  - table: users
+    encrypted: [data, removed]
+    deterministic: [city]
+    searchable:
+      - column: email
+        hash_column: email_hash
+    range:
+      - column: age
+        index_column: age_index
+        type: integer
+        precision: "10"
+  - table: Orders
+    encrypted: [data]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := DatabaseSchema{
+		"users": {"id": "integer", "data": "bytea", "city": "text", "email": "bytea", "email_hash": "character varying",
+			"age": "bytea", "age_index": "text"},
+	}
+	expected := []string{
+		"Orders: table doesn't exist",
+		"users.age_index: column has type text but integer type is expected",
+		"users.city: column has type text but binary type is expected",
+		"users.removed: column doesn't exist",
+	}
+	warnings := config.ValidateSchema(schema)
+	if len(warnings) != len(expected) {
+		t.Fatalf("Expected %d warnings, took %v", len(expected), warnings)
+	}
+	for i, warning := range warnings {
+		if warning.String() != expected[i] {
+			t.Errorf("Expected warning %q, took %q", expected[i], warning.String())
+		}
+	}
+
+	schema["users"]["city"] = "varbinary"
+	schema["users"]["age_index"] = "bigint"
+	schema["users"]["removed"] = "longblob"
+	schema["orders"] = map[string]string{"data": "blob"}
+	if warnings := config.ValidateSchema(schema); len(warnings) != 0 {
+		t.Errorf("Expected no warnings, took %v", warnings)
+	}
+}
@@ -0,0 +1,740 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryptor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/sqldialect"
+	"github.com/cossacklabs/acra/utils"
+	"github.com/xwb1989/sqlparser"
+)
+
+// Errors returned when query can't be processed by encryptor
+var (
+	ErrUnsupportedExpression = errors.New("unsupported expression as value of searchable column")
+	ErrInsertWithoutColumns  = errors.New("INSERT query into table with searchable columns must have explicit column list")
+)
+
+// pgHexPrefix is prefix of PostgreSQL's bytea value in hex format
+var pgHexPrefix = []byte{'\\', 'x'}
+
+// QueryEncryptor processes client's queries before AcraServer sends them to the database
+type QueryEncryptor interface {
+	// OnQuery returns new query that should be sent to the database and true if query was changed. Query which
+	// returned error must not be sent to the database
+	OnQuery(query string) (string, bool, error)
+}
+
+// SearchableQueryEncryptor calculates hashes of searchable columns in INSERT/UPDATE queries and replaces
+// comparisons by equality of searchable columns with comparisons of their hashes. Values of deterministic columns
+// are encrypted deterministically in INSERT/UPDATE queries and in comparisons by equality. Buckets of range columns
+// are stored in index columns and used instead of range columns in range conditions
+type SearchableQueryEncryptor struct {
+	config        *Config
+	keystorage    keystore.KeyStore
+	clientID      []byte
+	deterministic *DeterministicEncryptor
+	// consistentWrites rejects writes which can't update encrypted columns and their companion columns together
+	consistentWrites bool
+	// keyExpiry rejects writes if clientID's HMAC key expired, nil turns off check
+	keyExpiry keystore.KeyExpiryChecker
+}
+
+// NewSearchableQueryEncryptor returns new SearchableQueryEncryptor which uses clientID's keys
+func NewSearchableQueryEncryptor(config *Config, keystorage keystore.KeyStore, clientID []byte) (*SearchableQueryEncryptor, error) {
+	return &SearchableQueryEncryptor{
+		config:        config,
+		keystorage:    keystorage,
+		clientID:      clientID,
+		deterministic: NewDeterministicEncryptor(keystorage, clientID),
+	}, nil
+}
+
+// SetConsistentWrites turns on rejection of INSERT/UPDATE queries to configured tables which can't be rewritten to
+// write encrypted columns and their hash and index columns in the same statement
+func (encryptor *SearchableQueryEncryptor) SetConsistentWrites(enable bool) {
+	encryptor.consistentWrites = enable
+}
+
+// ErrExpiredKeyQuery returned instead of changed INSERT/UPDATE query to configured tables if client's HMAC key expired,
+// so new values aren't encrypted with it. Such queries shouldn't be sent to the database
+var ErrExpiredKeyQuery = errors.New("query rejected to avoid writes encrypted with expired key")
+
+// SetKeyExpiryChecker turns on rejection of INSERT/UPDATE queries to configured tables if client's HMAC key, which
+// hashes and encrypts values of written columns, expired. nil turns off check
+func (encryptor *SearchableQueryEncryptor) SetKeyExpiryChecker(checker keystore.KeyExpiryChecker) {
+	encryptor.keyExpiry = checker
+}
+
+// OnQuery parses query and returns query with calculated hashes of searchable columns and true if query was changed.
+// Queries that can't be parsed or don't use configured tables are returned as is. With consistent writes turned on
+// INSERT/UPDATE queries which can't be processed return RejectedQueryError and shouldn't be sent to the database,
+// INSERT/UPDATE queries of client with expired key return ErrExpiredKeyQuery if key expiry checker is set.
+//
+// Only text queries are processed: prepared statements (PostgreSQL extended query protocol, MySQL COM_STMT_PREPARE)
+// are sent to the database as is and their parameters are written and compared as plaintext, so clients should use
+// text queries for configured tables.
+func (encryptor *SearchableQueryEncryptor) OnQuery(query string) (string, bool, error) {
+	if !encryptor.hasConfiguredTable(query) {
+		return query, false, nil
+	}
+	parsed, extensions, err := sqldialect.Parse(query)
+	if err != nil {
+		if encryptor.consistentWrites && isWriteQuery(query) {
+			return query, false, &RejectedQueryError{Reason: err}
+		}
+		return query, false, nil
+	}
+	if encryptor.keyExpiry != nil && isWriteStatement(parsed) && encryptor.keyExpiry.IsKeyExpired(keystore.KeyPurposeHMAC, encryptor.clientID) {
+		return query, false, ErrExpiredKeyQuery
+	}
+	if encryptor.consistentWrites {
+		if err := encryptor.checkCompanionWrites(parsed); err != nil {
+			return query, false, &RejectedQueryError{Reason: err}
+		}
+	}
+	changed := false
+	switch statement := parsed.(type) {
+	case *sqlparser.Insert:
+		changed, err = encryptor.encryptInsert(statement)
+	case *sqlparser.Update:
+		changed, err = encryptor.encryptUpdate(statement)
+	case *sqlparser.Select:
+		changed, err = encryptor.encryptWhere(statement.Where, encryptor.getTableSchemas(statement.From))
+	case *sqlparser.Delete:
+		changed, err = encryptor.encryptWhere(statement.Where, encryptor.getTableSchemas(statement.TableExprs))
+	}
+	if err != nil {
+		if encryptor.consistentWrites && isWriteStatement(parsed) {
+			return query, false, &RejectedQueryError{Reason: err}
+		}
+		return query, false, err
+	}
+	if !changed {
+		return query, false, nil
+	}
+	return extensions.Wrap(sqlparser.String(parsed)), true, nil
+}
+
+// hasConfiguredTable returns true if query contains name of any configured table to avoid parsing of other queries
+func (encryptor *SearchableQueryEncryptor) hasConfiguredTable(query string) bool {
+	lowerQuery := strings.ToLower(query)
+	for _, schema := range encryptor.config.Schemas {
+		if strings.Contains(lowerQuery, strings.ToLower(schema.TableName)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (encryptor *SearchableQueryEncryptor) encryptInsert(insert *sqlparser.Insert) (bool, error) {
+	schema := encryptor.config.GetTableSchema(insert.Table.Name.String())
+	if schema == nil || (len(schema.Searchable) == 0 && len(schema.Deterministic) == 0 && len(schema.Range) == 0) {
+		return false, nil
+	}
+	if len(insert.Columns) == 0 {
+		return false, ErrInsertWithoutColumns
+	}
+	changed := false
+	for _, searchable := range schema.Searchable {
+		searchableChanged, err := insertCompanionValues(insert, searchable.Column, searchable.HashColumn, encryptor.hashExpr)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || searchableChanged
+	}
+	for _, rangeColumn := range schema.Range {
+		rangeColumn := rangeColumn
+		bucketExpr := func(expr sqlparser.Expr) (sqlparser.Expr, error) {
+			return encryptor.bucketExpr(rangeColumn, expr)
+		}
+		rangeChanged, err := insertCompanionValues(insert, rangeColumn.Column, rangeColumn.IndexColumn, bucketExpr)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || rangeChanged
+	}
+	for _, column := range schema.Deterministic {
+		valueIndex := insert.Columns.FindColumn(sqlparser.NewColIdent(column))
+		if valueIndex == -1 {
+			continue
+		}
+		rows, ok := insert.Rows.(sqlparser.Values)
+		if !ok {
+			return false, ErrUnsupportedExpression
+		}
+		for _, row := range rows {
+			if valueIndex >= len(row) {
+				return false, ErrUnsupportedExpression
+			}
+			encrypted, err := encryptor.encryptExpr(row[valueIndex])
+			if err != nil {
+				return false, err
+			}
+			row[valueIndex] = encrypted
+		}
+		changed = true
+	}
+	if len(insert.OnDup) > 0 {
+		schemas := map[string]*TableSchema{strings.ToLower(insert.Table.Name.String()): schema}
+		onDup, onDupChanged, err := encryptor.encryptUpdateExprs(sqlparser.UpdateExprs(insert.OnDup), schemas)
+		if err != nil {
+			return false, err
+		}
+		insert.OnDup = sqlparser.OnDup(onDup)
+		changed = changed || onDupChanged
+	}
+	return changed, nil
+}
+
+// insertCompanionValues sets values of companionColumn calculated by companionExpr from values of column in each
+// inserted row and returns true if column is inserted
+func insertCompanionValues(insert *sqlparser.Insert, column, companionColumn string, companionExpr func(sqlparser.Expr) (sqlparser.Expr, error)) (bool, error) {
+	valueIndex := insert.Columns.FindColumn(sqlparser.NewColIdent(column))
+	if valueIndex == -1 {
+		return false, nil
+	}
+	rows, ok := insert.Rows.(sqlparser.Values)
+	if !ok {
+		// INSERT ... SELECT
+		return false, ErrUnsupportedExpression
+	}
+	companionIndex := insert.Columns.FindColumn(sqlparser.NewColIdent(companionColumn))
+	if companionIndex == -1 {
+		insert.Columns = append(insert.Columns, sqlparser.NewColIdent(companionColumn))
+		companionIndex = len(insert.Columns) - 1
+	}
+	for i, row := range rows {
+		if valueIndex >= len(row) {
+			return false, ErrUnsupportedExpression
+		}
+		companionValue, err := companionExpr(row[valueIndex])
+		if err != nil {
+			return false, err
+		}
+		if companionIndex < len(row) {
+			row[companionIndex] = companionValue
+		} else {
+			rows[i] = append(row, companionValue)
+		}
+	}
+	return true, nil
+}
+
+func (encryptor *SearchableQueryEncryptor) encryptUpdate(update *sqlparser.Update) (bool, error) {
+	schemas := encryptor.getTableSchemas(update.TableExprs)
+	if len(schemas) == 0 {
+		return false, nil
+	}
+	exprs, changed, err := encryptor.encryptUpdateExprs(update.Exprs, schemas)
+	if err != nil {
+		return false, err
+	}
+	update.Exprs = exprs
+	whereChanged, err := encryptor.encryptWhere(update.Where, schemas)
+	if err != nil {
+		return false, err
+	}
+	return changed || whereChanged, nil
+}
+
+// encryptUpdateExprs sets hash column for each updated searchable column and index column for each updated range
+// column and returns new list of expressions
+func (encryptor *SearchableQueryEncryptor) encryptUpdateExprs(exprs sqlparser.UpdateExprs, schemas map[string]*TableSchema) (sqlparser.UpdateExprs, bool, error) {
+	changed := false
+	// iterate only over original expressions because hash columns may be appended
+	originalExprs := exprs
+	for _, expr := range originalExprs {
+		if isDeterministicColumn(schemas, expr.Name) {
+			// VALUES(column) refers to already encrypted inserted value
+			if _, ok := expr.Expr.(*sqlparser.ValuesFuncExpr); ok {
+				continue
+			}
+			encrypted, err := encryptor.encryptExpr(expr.Expr)
+			if err != nil {
+				return nil, false, err
+			}
+			expr.Expr = encrypted
+			changed = true
+			continue
+		}
+		if rangeColumn := getRangeColumn(schemas, expr.Name); rangeColumn != nil {
+			bucketExpr := func(expr sqlparser.Expr) (sqlparser.Expr, error) {
+				return encryptor.bucketExpr(rangeColumn, expr)
+			}
+			var err error
+			exprs, err = updateCompanionColumn(exprs, expr, rangeColumn.IndexColumn, bucketExpr)
+			if err != nil {
+				return nil, false, err
+			}
+			changed = true
+			continue
+		}
+		searchable := getSearchableColumn(schemas, expr.Name)
+		if searchable == nil {
+			continue
+		}
+		var err error
+		exprs, err = updateCompanionColumn(exprs, expr, searchable.HashColumn, encryptor.hashExpr)
+		if err != nil {
+			return nil, false, err
+		}
+		changed = true
+	}
+	return exprs, changed, nil
+}
+
+// updateCompanionColumn sets companionColumn to value calculated by companionExpr from value of expr and returns new
+// list of expressions
+func updateCompanionColumn(exprs sqlparser.UpdateExprs, expr *sqlparser.UpdateExpr, companionColumn string, companionExpr func(sqlparser.Expr) (sqlparser.Expr, error)) (sqlparser.UpdateExprs, error) {
+	column := &sqlparser.ColName{Name: sqlparser.NewColIdent(companionColumn), Qualifier: expr.Name.Qualifier}
+	var companionValue sqlparser.Expr
+	if valuesFunc, ok := expr.Expr.(*sqlparser.ValuesFuncExpr); ok {
+		// ON DUPLICATE KEY UPDATE column = VALUES(column) should use companion value from inserted values
+		companionValue = &sqlparser.ValuesFuncExpr{Name: &sqlparser.ColName{Name: sqlparser.NewColIdent(companionColumn), Qualifier: valuesFunc.Name.Qualifier}}
+	} else {
+		var err error
+		companionValue, err = companionExpr(expr.Expr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if index := findUpdateExpr(exprs, column); index != -1 {
+		exprs[index].Expr = companionValue
+	} else {
+		exprs = append(exprs, &sqlparser.UpdateExpr{Name: column, Expr: companionValue})
+	}
+	return exprs, nil
+}
+
+// encryptWhere replaces comparisons by equality of searchable columns with values by comparisons of hashes and range
+// conditions of range columns by conditions of their index columns
+func (encryptor *SearchableQueryEncryptor) encryptWhere(where *sqlparser.Where, schemas map[string]*TableSchema) (bool, error) {
+	if where == nil || len(schemas) == 0 {
+		return false, nil
+	}
+	return encryptor.encryptWhereExpr(&where.Expr, schemas)
+}
+
+// encryptWhereExpr processes expression and replaces it by pointer if condition should have other type
+func (encryptor *SearchableQueryEncryptor) encryptWhereExpr(exprPtr *sqlparser.Expr, schemas map[string]*TableSchema) (bool, error) {
+	switch expr := (*exprPtr).(type) {
+	case *sqlparser.AndExpr:
+		return encryptor.encryptWhereExprs(schemas, &expr.Left, &expr.Right)
+	case *sqlparser.OrExpr:
+		return encryptor.encryptWhereExprs(schemas, &expr.Left, &expr.Right)
+	case *sqlparser.ParenExpr:
+		return encryptor.encryptWhereExpr(&expr.Expr, schemas)
+	case *sqlparser.NotExpr:
+		return encryptor.encryptWhereExpr(&expr.Expr, schemas)
+	case *sqlparser.RangeCond:
+		return encryptor.encryptRangeCond(expr, schemas)
+	case *sqlparser.ComparisonExpr:
+		switch expr.Operator {
+		case sqlparser.EqualStr, sqlparser.NotEqualStr, sqlparser.NullSafeEqualStr:
+		case sqlparser.InStr, sqlparser.NotInStr:
+			return encryptor.encryptDeterministicIn(expr, schemas)
+		case sqlparser.LessThanStr, sqlparser.LessEqualStr, sqlparser.GreaterThanStr, sqlparser.GreaterEqualStr:
+			return encryptor.encryptRangeComparison(expr, schemas)
+		default:
+			return false, nil
+		}
+		column, ok := expr.Left.(*sqlparser.ColName)
+		value := expr.Right
+		valueIsRight := true
+		if !ok {
+			column, ok = expr.Right.(*sqlparser.ColName)
+			value = expr.Left
+			valueIsRight = false
+		}
+		if !ok {
+			return false, nil
+		}
+		if isDeterministicColumn(schemas, column) {
+			encrypted, err := encryptor.encryptExpr(value)
+			if err == ErrUnsupportedExpression {
+				// comparison with other column (JOIN condition) or placeholder, leave as is
+				return false, nil
+			}
+			if err != nil {
+				return false, err
+			}
+			if valueIsRight {
+				expr.Right = encrypted
+			} else {
+				expr.Left = encrypted
+			}
+			*exprPtr = nullComparison(expr, column, value, encrypted)
+			return true, nil
+		}
+		searchable := getSearchableColumn(schemas, column)
+		if searchable == nil {
+			return false, nil
+		}
+		hash, err := encryptor.hashExpr(value)
+		if err == ErrUnsupportedExpression {
+			// comparison with other column or placeholder, leave as is
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		hashColumn := &sqlparser.ColName{Name: sqlparser.NewColIdent(searchable.HashColumn), Qualifier: column.Qualifier}
+		expr.Left = hashColumn
+		expr.Right = hash
+		*exprPtr = nullComparison(expr, hashColumn, value, hash)
+		return true, nil
+	}
+	return false, nil
+}
+
+// nullComparison returns "column IS [NOT] NULL" condition if value was replaced with NULL according to EmptyPolicy
+// because comparison by equality with NULL never matches. Otherwise returns expr as is
+func nullComparison(expr *sqlparser.ComparisonExpr, column *sqlparser.ColName, value, newValue sqlparser.Expr) sqlparser.Expr {
+	if _, ok := newValue.(*sqlparser.NullVal); !ok {
+		return expr
+	}
+	if _, ok := value.(*sqlparser.NullVal); ok {
+		return expr
+	}
+	switch expr.Operator {
+	case sqlparser.EqualStr:
+		return &sqlparser.IsExpr{Operator: sqlparser.IsNullStr, Expr: column}
+	case sqlparser.NotEqualStr:
+		return &sqlparser.IsExpr{Operator: sqlparser.IsNotNullStr, Expr: column}
+	}
+	return expr
+}
+
+// encryptRangeComparison replaces "column < value" like comparison of range column with comparison of index column
+// with bucket of value. Bucket of bound is included because it may contain matching values
+func (encryptor *SearchableQueryEncryptor) encryptRangeComparison(expr *sqlparser.ComparisonExpr, schemas map[string]*TableSchema) (bool, error) {
+	operator := expr.Operator
+	column, ok := expr.Left.(*sqlparser.ColName)
+	value := expr.Right
+	if !ok {
+		column, ok = expr.Right.(*sqlparser.ColName)
+		value = expr.Left
+		// "value < column" is the same as "column > value"
+		switch operator {
+		case sqlparser.LessThanStr, sqlparser.LessEqualStr:
+			operator = sqlparser.GreaterEqualStr
+		default:
+			operator = sqlparser.LessEqualStr
+		}
+	}
+	if !ok {
+		return false, nil
+	}
+	rangeColumn := getRangeColumn(schemas, column)
+	if rangeColumn == nil {
+		return false, nil
+	}
+	bucket, err := encryptor.bucketExpr(rangeColumn, value)
+	if err == ErrUnsupportedExpression {
+		// comparison with other column or placeholder, leave as is
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	switch operator {
+	case sqlparser.LessThanStr, sqlparser.LessEqualStr:
+		expr.Operator = sqlparser.LessEqualStr
+	default:
+		expr.Operator = sqlparser.GreaterEqualStr
+	}
+	expr.Left = &sqlparser.ColName{Name: sqlparser.NewColIdent(rangeColumn.IndexColumn), Qualifier: column.Qualifier}
+	expr.Right = bucket
+	return true, nil
+}
+
+// encryptRangeCond replaces "column BETWEEN from AND to" condition of range column with condition of index column with
+// buckets of bounds. NOT BETWEEN is left as is because it can't be rewritten without losing of matching rows
+func (encryptor *SearchableQueryEncryptor) encryptRangeCond(expr *sqlparser.RangeCond, schemas map[string]*TableSchema) (bool, error) {
+	if expr.Operator != sqlparser.BetweenStr {
+		return false, nil
+	}
+	column, ok := expr.Left.(*sqlparser.ColName)
+	if !ok {
+		return false, nil
+	}
+	rangeColumn := getRangeColumn(schemas, column)
+	if rangeColumn == nil {
+		return false, nil
+	}
+	from, err := encryptor.bucketExpr(rangeColumn, expr.From)
+	if err == ErrUnsupportedExpression {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	to, err := encryptor.bucketExpr(rangeColumn, expr.To)
+	if err == ErrUnsupportedExpression {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	expr.Left = &sqlparser.ColName{Name: sqlparser.NewColIdent(rangeColumn.IndexColumn), Qualifier: column.Qualifier}
+	expr.From = from
+	expr.To = to
+	return true, nil
+}
+
+// encryptDeterministicIn encrypts values of list in "column IN (...)" condition with deterministic column
+func (encryptor *SearchableQueryEncryptor) encryptDeterministicIn(expr *sqlparser.ComparisonExpr, schemas map[string]*TableSchema) (bool, error) {
+	column, ok := expr.Left.(*sqlparser.ColName)
+	if !ok || !isDeterministicColumn(schemas, column) {
+		return false, nil
+	}
+	values, ok := expr.Right.(sqlparser.ValTuple)
+	if !ok {
+		// subquery
+		return false, nil
+	}
+	encryptedValues := make(sqlparser.ValTuple, len(values))
+	for i, value := range values {
+		encrypted, err := encryptor.encryptExpr(value)
+		if err == ErrUnsupportedExpression {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		encryptedValues[i] = encrypted
+	}
+	expr.Right = encryptedValues
+	return true, nil
+}
+
+func (encryptor *SearchableQueryEncryptor) encryptWhereExprs(schemas map[string]*TableSchema, exprs ...*sqlparser.Expr) (bool, error) {
+	changed := false
+	for _, expr := range exprs {
+		exprChanged, err := encryptor.encryptWhereExpr(expr, schemas)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || exprChanged
+	}
+	return changed, nil
+}
+
+// hashExpr returns expression with hash of value which should be used instead of value's expression
+func (encryptor *SearchableQueryEncryptor) hashExpr(expr sqlparser.Expr) (sqlparser.Expr, error) {
+	if replacement, ok := encryptor.config.Values.replace(expr); ok {
+		return replacement, nil
+	}
+	value, err := getValue(expr)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := CalculateSearchableHash(value, encryptor.clientID, encryptor.keystorage)
+	if err != nil {
+		return nil, err
+	}
+	return sqlparser.NewStrVal([]byte(hash)), nil
+}
+
+// encryptExpr returns expression with deterministically encrypted value which should be used instead of value's
+// expression. AcraStructs are decrypted before encryption
+func (encryptor *SearchableQueryEncryptor) encryptExpr(expr sqlparser.Expr) (sqlparser.Expr, error) {
+	if replacement, ok := encryptor.config.Values.replace(expr); ok {
+		return replacement, nil
+	}
+	value, err := getValue(expr)
+	if err != nil {
+		return nil, err
+	}
+	value, decrypted, err := getPlaintext(value, encryptor.clientID, encryptor.keystorage)
+	if err != nil {
+		return nil, err
+	}
+	if decrypted {
+		defer utils.FillSlice(byte(0), value)
+	}
+	encrypted, err := encryptor.deterministic.Encrypt(value)
+	if err != nil {
+		return nil, err
+	}
+	return sqlparser.NewStrVal(encrypted), nil
+}
+
+// bucketExpr returns expression with bucket of range column's value which should be stored in index column
+func (encryptor *SearchableQueryEncryptor) bucketExpr(column *RangeColumn, expr sqlparser.Expr) (sqlparser.Expr, error) {
+	if _, ok := expr.(*sqlparser.NullVal); ok {
+		return &sqlparser.NullVal{}, nil
+	}
+	value, err := getValue(expr)
+	if err != nil {
+		return nil, err
+	}
+	value, decrypted, err := getPlaintext(value, encryptor.clientID, encryptor.keystorage)
+	if err != nil {
+		return nil, err
+	}
+	if decrypted {
+		defer utils.FillSlice(byte(0), value)
+	}
+	bucket, err := column.Bucket(value)
+	if err != nil {
+		return nil, err
+	}
+	return sqlparser.NewIntVal([]byte(strconv.FormatInt(bucket, 10))), nil
+}
+
+// getValue returns raw value of literal expression
+func getValue(expr sqlparser.Expr) ([]byte, error) {
+	value, ok := expr.(*sqlparser.SQLVal)
+	if !ok {
+		return nil, ErrUnsupportedExpression
+	}
+	switch value.Type {
+	case sqlparser.StrVal:
+		if bytes.HasPrefix(value.Val, pgHexPrefix) {
+			if decoded, err := hex.DecodeString(string(value.Val[len(pgHexPrefix):])); err == nil {
+				return decoded, nil
+			}
+		}
+		return value.Val, nil
+	case sqlparser.IntVal, sqlparser.FloatVal:
+		return value.Val, nil
+	case sqlparser.HexVal:
+		return value.HexDecode()
+	case sqlparser.HexNum:
+		// 0x prefix
+		return hex.DecodeString(string(value.Val[2:]))
+	}
+	return nil, ErrUnsupportedExpression
+}
+
+// getTableSchemas returns schemas of configured tables used in table expressions mapped by alias or table name
+func (encryptor *SearchableQueryEncryptor) getTableSchemas(exprs sqlparser.TableExprs) map[string]*TableSchema {
+	schemas := make(map[string]*TableSchema)
+	for _, expr := range exprs {
+		encryptor.collectTableSchemas(expr, schemas)
+	}
+	return schemas
+}
+
+func (encryptor *SearchableQueryEncryptor) collectTableSchemas(expr sqlparser.TableExpr, schemas map[string]*TableSchema) {
+	switch table := expr.(type) {
+	case *sqlparser.AliasedTableExpr:
+		tableName, ok := table.Expr.(sqlparser.TableName)
+		if !ok {
+			return
+		}
+		schema := encryptor.config.GetTableSchema(tableName.Name.String())
+		if schema == nil {
+			return
+		}
+		alias := tableName.Name.String()
+		if !table.As.IsEmpty() {
+			alias = table.As.String()
+		}
+		schemas[strings.ToLower(alias)] = schema
+	case *sqlparser.JoinTableExpr:
+		encryptor.collectTableSchemas(table.LeftExpr, schemas)
+		encryptor.collectTableSchemas(table.RightExpr, schemas)
+	case *sqlparser.ParenTableExpr:
+		for _, expr := range table.Exprs {
+			encryptor.collectTableSchemas(expr, schemas)
+		}
+	}
+}
+
+// getSearchableColumn returns configuration of searchable column used in query or nil
+func getSearchableColumn(schemas map[string]*TableSchema, column *sqlparser.ColName) *SearchableColumn {
+	if !column.Qualifier.IsEmpty() {
+		schema, ok := schemas[strings.ToLower(column.Qualifier.Name.String())]
+		if !ok {
+			return nil
+		}
+		return schema.GetSearchableColumn(column.Name.String())
+	}
+	for _, schema := range schemas {
+		if searchable := schema.GetSearchableColumn(column.Name.String()); searchable != nil {
+			return searchable
+		}
+	}
+	return nil
+}
+
+// getRangeColumn returns configuration of range column used in query or nil
+func getRangeColumn(schemas map[string]*TableSchema, column *sqlparser.ColName) *RangeColumn {
+	if !column.Qualifier.IsEmpty() {
+		schema, ok := schemas[strings.ToLower(column.Qualifier.Name.String())]
+		if !ok {
+			return nil
+		}
+		return schema.GetRangeColumn(column.Name.String())
+	}
+	for _, schema := range schemas {
+		if rangeColumn := schema.GetRangeColumn(column.Name.String()); rangeColumn != nil {
+			return rangeColumn
+		}
+	}
+	return nil
+}
+
+// getCompanionSource returns name of column which hash or index is stored in column used in query or empty string
+func getCompanionSource(schemas map[string]*TableSchema, column *sqlparser.ColName) string {
+	if !column.Qualifier.IsEmpty() {
+		schema, ok := schemas[strings.ToLower(column.Qualifier.Name.String())]
+		if !ok {
+			return ""
+		}
+		return schema.GetCompanionSource(column.Name.String())
+	}
+	for _, schema := range schemas {
+		if source := schema.GetCompanionSource(column.Name.String()); source != "" {
+			return source
+		}
+	}
+	return ""
+}
+
+// isDeterministicColumn returns true if column used in query is configured as deterministic
+func isDeterministicColumn(schemas map[string]*TableSchema, column *sqlparser.ColName) bool {
+	if !column.Qualifier.IsEmpty() {
+		schema, ok := schemas[strings.ToLower(column.Qualifier.Name.String())]
+		return ok && schema.IsDeterministicColumn(column.Name.String())
+	}
+	for _, schema := range schemas {
+		if schema.IsDeterministicColumn(column.Name.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// findUpdateExpr returns index of expression which updates column or -1
+func findUpdateExpr(exprs sqlparser.UpdateExprs, column *sqlparser.ColName) int {
+	for i, expr := range exprs {
+		if expr.Name.Name.Equal(column.Name) && strings.EqualFold(expr.Name.Qualifier.Name.String(), column.Qualifier.Name.String()) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryptor
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/cossacklabs/acra/acra-writer"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/themis/gothemis/keys"
+)
+
+type testKeystore struct {
+	keystore.KeyStore
+	storageKeyPair *keys.Keypair
+	hmacKey        []byte
+}
+
+func (store *testKeystore) GetServerDecryptionPrivateKey(id []byte) (*keys.PrivateKey, error) {
+	// return copy because key will be zeroed after usage
+	return &keys.PrivateKey{Value: append([]byte{}, store.storageKeyPair.Private.Value...)}, nil
+}
+
+func (store *testKeystore) GetServerDecryptionPrivateKeys(id []byte) ([]*keys.PrivateKey, error) {
+	return []*keys.PrivateKey{{Value: append([]byte{}, store.storageKeyPair.Private.Value...)}}, nil
+}
+
+func (store *testKeystore) GetHMACSecretKey(id []byte) ([]byte, error) {
+	return append([]byte{}, store.hmacKey...), nil
+}
+
+func newTestKeystore(t *testing.T) *testKeystore {
+	keypair, err := keys.New(keys.KEYTYPE_EC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKeystore{storageKeyPair: keypair, hmacKey: []byte("some hmac key")}
+}
+
+const testConfig = `
+schemas:
+  - table: users
+    encrypted:
+      - address
+    searchable:
+      - column: email
+        hash_column: email_hash
+`
+
+func TestLoadConfig(t *testing.T) {
+	config, err := LoadConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := config.GetTableSchema("USERS")
+	if schema == nil {
+		t.Fatal("Expected configured table")
+	}
+	searchable := schema.GetSearchableColumn("Email")
+	if searchable == nil || searchable.HashColumn != "email_hash" {
+		t.Fatal("Expected configured searchable column")
+	}
+	if config.GetTableSchema("orders") != nil {
+		t.Fatal("Unexpected configured table")
+	}
+	if !config.IsEncryptedColumn("users", "EMAIL") || !config.IsEncryptedColumn("", "email") || !config.IsEncryptedColumn("Users", "address") {
+		t.Fatal("Expected encrypted column")
+	}
+	if config.IsEncryptedColumn("users", "email_hash") || config.IsEncryptedColumn("orders", "email") {
+		t.Fatal("Unexpected encrypted column")
+	}
+
+	invalidConfigs := []string{
+		"schemas:\n  - searchable:\n      - column: email\n        hash_column: email_hash\n",
+		"schemas:\n  - table: users\n    searchable:\n      - column: email\n",
+		"schemas:\n  - table: users\n    searchable:\n      - column: email\n        hash_column: email\n",
+		"schemas:\n  - table: users\n    searchable:\n      - column: email\n        hash_column: email_hash\n      - column: email_hash\n        hash_column: hash\n",
+		"schemas:\n  - table: users\n  - table: users\n",
+		"schemas:\n  - table: users\n    encrypted: ['']\n",
+		"schemas:\n  - table: users\n    encrypted: [email, email]\n",
+		"schemas:\n  - table: users\n    encrypted: [email_hash]\n    searchable:\n      - column: email\n        hash_column: email_hash\n",
+	}
+	for i, invalidConfig := range invalidConfigs {
+		if _, err := LoadConfig([]byte(invalidConfig)); err != ErrInvalidConfig {
+			t.Errorf("%v. Expected ErrInvalidConfig, took %v", i, err)
+		}
+	}
+}
+
+func TestCalculateSearchableHash(t *testing.T) {
+	keystorage := newTestKeystore(t)
+	clientID := []byte("client")
+	data := []byte("some data")
+
+	plaintextHash, err := CalculateSearchableHash(data, clientID, keystorage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintextHash != hex.EncodeToString(CalculateHash(keystorage.hmacKey, data)) {
+		t.Fatal("Incorrect hash of plaintext")
+	}
+
+	acrastruct, err := acrawriter.CreateAcrastruct(data, keystorage.storageKeyPair.Public, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acrastructHash, err := CalculateSearchableHash(acrastruct, clientID, keystorage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acrastructHash != plaintextHash {
+		t.Fatal("Hash of AcraStruct must be equal to hash of plaintext")
+	}
+
+	keystorage.hmacKey = []byte("other hmac key")
+	otherHash, err := CalculateSearchableHash(data, clientID, keystorage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if otherHash == plaintextHash {
+		t.Fatal("Hashes with different keys must be different")
+	}
+}
+
+func TestSearchableQueryEncryptor(t *testing.T) {
+	keystorage := newTestKeystore(t)
+	config, err := LoadConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptor, err := NewSearchableQueryEncryptor(config, keystorage, []byte("client"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := hex.EncodeToString(CalculateHash(keystorage.hmacKey, []byte("user@example.com")))
+
+	changedQueries := []string{
+		"INSERT INTO users (id, email) VALUES (1, 'user@example.com')",
+		"INSERT INTO users (id, email, email_hash) VALUES (1, 'user@example.com', 'unknown')",
+		"UPDATE users SET email='user@example.com' WHERE id=1",
+		"SELECT id FROM users WHERE email='user@example.com'",
+		"SELECT u.id FROM users AS u JOIN orders AS o ON u.id=o.user_id WHERE u.email='user@example.com'",
+		"DELETE FROM users WHERE email='user@example.com'",
+	}
+	for i, query := range changedQueries {
+		newQuery, changed, err := encryptor.OnQuery(query)
+		if err != nil {
+			t.Fatalf("%v. Unexpected error: %v", i, err)
+		}
+		if !changed {
+			t.Fatalf("%v. Expected changed query", i)
+		}
+		if !strings.Contains(newQuery, "email_hash") || !strings.Contains(newQuery, hash) {
+			t.Fatalf("%v. Expected hash of searchable column in query: %s", i, newQuery)
+		}
+		if strings.Contains(newQuery, "unknown") {
+			t.Fatalf("%v. Hash column value wasn't replaced: %s", i, newQuery)
+		}
+	}
+
+	// clauses of MariaDB which sqlparser doesn't support are kept in rewritten queries
+	mariaDBQueries := map[string]string{
+		"INSERT INTO users (id, email) VALUES (NEXT VALUE FOR user_ids, 'user@example.com') RETURNING id":      "insert into users(id, email, email_hash) values (nextval(user_ids), 'user@example.com', ",
+		"SET STATEMENT max_statement_time=1 FOR DELETE FROM users WHERE email='user@example.com' RETURNING id": "SET STATEMENT max_statement_time=1 FOR delete from users where email_hash = ",
+	}
+	for query, prefix := range mariaDBQueries {
+		newQuery, changed, err := encryptor.OnQuery(query)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !changed || !strings.HasPrefix(newQuery, prefix) || !strings.HasSuffix(newQuery, " RETURNING id") || !strings.Contains(newQuery, hash) {
+			t.Fatalf("Unexpected rewritten query %s", newQuery)
+		}
+	}
+
+	unchangedQueries := []string{
+		"INSERT INTO orders (id, email) VALUES (1, 'user@example.com')",
+		"SELECT id FROM users WHERE id=1",
+		"SELECT id FROM users WHERE email=$1",
+		"UPDATE users SET name='name' WHERE id=1",
+		"invalid query with users table",
+	}
+	for i, query := range unchangedQueries {
+		newQuery, changed, err := encryptor.OnQuery(query)
+		if err != nil {
+			t.Fatalf("%v. Unexpected error: %v", i, err)
+		}
+		if changed || newQuery != query {
+			t.Fatalf("%v. Query shouldn't be changed: %s", i, newQuery)
+		}
+	}
+
+	if _, _, err := encryptor.OnQuery("INSERT INTO users VALUES (1, 'user@example.com')"); err != ErrInsertWithoutColumns {
+		t.Fatalf("Expected ErrInsertWithoutColumns, took %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryptor
+
+import (
+	"github.com/xwb1989/sqlparser"
+)
+
+// Policies of NULL values of searchable and deterministic columns
+const (
+	// NullPolicyKeep stores NULL as NULL in hash and deterministic columns
+	NullPolicyKeep = "keep"
+	// NullPolicyToken stores canonical token instead of NULL
+	NullPolicyToken = "token"
+)
+
+// Policies of empty values of searchable and deterministic columns
+const (
+	// EmptyPolicyEncrypt hashes and encrypts empty values as any other value
+	EmptyPolicyEncrypt = "encrypt"
+	// EmptyPolicyNull stores NULL instead of empty value, so empty values don't violate unique constraints. Isn't named
+	// "null" because YAML parses such value as empty
+	EmptyPolicyNull = "as_null"
+	// EmptyPolicyToken stores canonical token instead of empty value
+	EmptyPolicyToken = "token"
+)
+
+// ValuesPolicy describes how encryptor processes NULL and empty values of searchable and deterministic columns. Token is
+// stored as is instead of hash or encrypted value when policy is "token". Default policies are "keep" for NULL and
+// "encrypt" for empty values
+type ValuesPolicy struct {
+	Null  string `yaml:"on_null"`
+	Empty string `yaml:"on_empty"`
+	Token string `yaml:"token"`
+}
+
+// validate returns ErrInvalidConfig if policy is unknown or token isn't set for "token" policy
+func (policy *ValuesPolicy) validate() error {
+	if policy == nil {
+		return nil
+	}
+	switch policy.Null {
+	case "", NullPolicyKeep, NullPolicyToken:
+	default:
+		return ErrInvalidConfig
+	}
+	switch policy.Empty {
+	case "", EmptyPolicyEncrypt, EmptyPolicyNull, EmptyPolicyToken:
+	default:
+		return ErrInvalidConfig
+	}
+	if (policy.Null == NullPolicyToken || policy.Empty == EmptyPolicyToken) && policy.Token == "" {
+		return ErrInvalidConfig
+	}
+	return nil
+}
+
+// replace returns expression which should be stored instead of hash or encrypted value and true if expr is NULL or
+// empty value. Returns false if value should be processed as usual. Safe to call on nil ValuesPolicy
+func (policy *ValuesPolicy) replace(expr sqlparser.Expr) (sqlparser.Expr, bool) {
+	if _, ok := expr.(*sqlparser.NullVal); ok {
+		if policy != nil && policy.Null == NullPolicyToken {
+			return sqlparser.NewStrVal([]byte(policy.Token)), true
+		}
+		return &sqlparser.NullVal{}, true
+	}
+	if policy == nil {
+		return nil, false
+	}
+	value, err := getValue(expr)
+	if err != nil || len(value) != 0 {
+		return nil, false
+	}
+	switch policy.Empty {
+	case EmptyPolicyNull:
+		return &sqlparser.NullVal{}, true
+	case EmptyPolicyToken:
+		return sqlparser.NewStrVal([]byte(policy.Token)), true
+	}
+	return nil, false
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryptor
+
+import (
+	"testing"
+)
+
+func TestValuesPolicy(t *testing.T) {
+	keystorage := newTestKeystore(t)
+	testCases := []struct {
+		policy   string
+		query    string
+		expected string
+	}{
+		{"", "INSERT INTO users (id, email, city) VALUES (1, NULL, NULL)", "insert into users(id, email, city, email_hash) values (1, null, null, null)"},
+		{"values:\n  on_empty: as_null\n", "INSERT INTO users (id, email, city) VALUES (1, '', '')", "insert into users(id, email, city, email_hash) values (1, '', null, null)"},
+		{"values:\n  on_empty: as_null\n", "SELECT id FROM users WHERE email = ''", "select id from users where email_hash is null"},
+		{"values:\n  on_empty: as_null\n", "SELECT id FROM users WHERE city != ''", "select id from users where city is not null"},
+		{"values:\n  on_empty: token\n  token: EMPTY\n", "UPDATE users SET email='', city='' WHERE id=1", "update users set email = '', city = 'EMPTY', email_hash = 'EMPTY' where id = 1"},
+		{"values:\n  on_null: token\n  token: NULL_TOKEN\n", "INSERT INTO users (id, email, city) VALUES (1, NULL, NULL)", "insert into users(id, email, city, email_hash) values (1, null, 'NULL_TOKEN', 'NULL_TOKEN')"},
+		{"values:\n  on_null: keep\n  on_empty: encrypt\n", "SELECT id FROM users WHERE city = NULL", "select id from users where city = null"},
+	}
+	for i, testCase := range testCases {
+		config, err := LoadConfig([]byte(testCase.policy + "schemas:\n  - table: users\n    deterministic: [city]\n    searchable:\n      - column: email\n        hash_column: email_hash\n"))
+		if err != nil {
+			t.Fatalf("%v. Unexpected error: %v", i, err)
+		}
+		encryptor, err := NewSearchableQueryEncryptor(config, keystorage, []byte("client"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		newQuery, _, err := encryptor.OnQuery(testCase.query)
+		if err != nil {
+			t.Fatalf("%v. Unexpected error: %v", i, err)
+		}
+		if newQuery != testCase.expected {
+			t.Fatalf("%v. Expected %s, took %s", i, testCase.expected, newQuery)
+		}
+	}
+
+	invalidConfigs := []string{
+		"values:\n  on_null: encrypt\n",
+		"values:\n  on_empty: keep\n",
+		"values:\n  on_empty: token\n",
+		"values:\n  on_null: token\n",
+	}
+	for i, invalidConfig := range invalidConfigs {
+		if _, err := LoadConfig([]byte(invalidConfig)); err != ErrInvalidConfig {
+			t.Errorf("%v. Expected ErrInvalidConfig, took %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpauth
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// LDAPProviderType is type of provider which checks basic auth credentials with simple bind to LDAP server
+const LDAPProviderType = "ldap"
+
+// DefaultLDAPTimeout is timeout of connection and bind to LDAP server
+const DefaultLDAPTimeout = time.Second * 5
+
+// ldapResultSuccess is resultCode of successful bind
+const ldapResultSuccess = 0
+
+// maxLDAPResponseLength limits size of bind response
+const maxLDAPResponseLength = 64 * 1024
+
+// ErrInvalidLDAPResponse returned if LDAP server responded with unexpected message
+var ErrInvalidLDAPResponse = errors.New("invalid LDAP response")
+
+// LDAPProvider authenticates requests with basic auth credentials by simple bind to LDAP server with DN made from
+// template and username
+type LDAPProvider struct {
+	address        string
+	bindDNTemplate string
+	tlsConfig      *tls.Config
+	timeout        time.Duration
+	realm          string
+}
+
+type ldapProviderConfig struct {
+	Address        string `yaml:"address"`
+	BindDNTemplate string `yaml:"bind_dn_template"`
+	TLS            bool   `yaml:"tls"`
+	CAFile         string `yaml:"ca_file"`
+	Timeout        int    `yaml:"timeout"`
+	Realm          string `yaml:"realm"`
+}
+
+// NewLDAPProvider returns LDAPProvider. bindDNTemplate should contain one %s replaced with escaped username,
+// for example "uid=%s,ou=people,dc=example,dc=com". Connection uses TLS if tlsConfig isn't nil
+func NewLDAPProvider(address, bindDNTemplate string, tlsConfig *tls.Config, timeout time.Duration, realm string) (*LDAPProvider, error) {
+	if address == "" || strings.Count(bindDNTemplate, "%s") != 1 {
+		return nil, ErrInvalidProviderConfig
+	}
+	if timeout <= 0 {
+		timeout = DefaultLDAPTimeout
+	}
+	if realm == "" {
+		realm = DefaultRealm
+	}
+	return &LDAPProvider{address: address, bindDNTemplate: bindDNTemplate, tlsConfig: tlsConfig, timeout: timeout, realm: realm}, nil
+}
+
+// NewLDAPProviderFromConfig returns LDAPProvider from configuration
+func NewLDAPProviderFromConfig(data []byte) (Provider, error) {
+	config := &ldapProviderConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	var tlsConfig *tls.Config
+	if config.TLS {
+		host, _, err := net.SplitHostPort(config.Address)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig = &tls.Config{ServerName: host}
+		if config.CAFile != "" {
+			caData, err := ioutil.ReadFile(config.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(caData) {
+				return nil, fmt.Errorf("%v: no certificates in %s", ErrInvalidProviderConfig, config.CAFile)
+			}
+		}
+	}
+	return NewLDAPProvider(config.Address, config.BindDNTemplate, tlsConfig, time.Duration(config.Timeout)*time.Second, config.Realm)
+}
+
+// EscapeDNValue escapes special characters of attribute value in distinguished name according to RFC 4514
+func EscapeDNValue(value string) string {
+	var builder bytes.Buffer
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == 0:
+			builder.WriteString("\\00")
+			continue
+		case strings.IndexByte(",+\"\\<>;=", c) >= 0,
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(value)-1 && c == ' ':
+			builder.WriteByte('\\')
+		}
+		builder.WriteByte(c)
+	}
+	return builder.String()
+}
+
+// marshalBindRequest returns LDAPMessage with simple BindRequest of LDAP v3
+func marshalBindRequest(messageID int, dn, password string) ([]byte, error) {
+	var request []byte
+	for _, value := range []interface{}{
+		3,
+		[]byte(dn),
+		asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: []byte(password)},
+	} {
+		data, err := asn1.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		request = append(request, data...)
+	}
+	id, err := asn1.Marshal(messageID)
+	if err != nil {
+		return nil, err
+	}
+	op, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassApplication, Tag: 0, IsCompound: true, Bytes: request})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: append(id, op...)})
+}
+
+// readBERElement reads one BER element with definite length
+func readBERElement(reader io.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	length := int(header[1])
+	if length&0x80 != 0 {
+		lengthSize := length & 0x7f
+		if lengthSize == 0 || lengthSize > 3 {
+			return nil, ErrInvalidLDAPResponse
+		}
+		lengthBytes := make([]byte, lengthSize)
+		if _, err := io.ReadFull(reader, lengthBytes); err != nil {
+			return nil, err
+		}
+		header = append(header, lengthBytes...)
+		length = 0
+		for _, b := range lengthBytes {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxLDAPResponseLength {
+		return nil, ErrInvalidLDAPResponse
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+	return append(header, body...), nil
+}
+
+// parseBindResponse returns resultCode of BindResponse with expected message id
+func parseBindResponse(data []byte, messageID int) (int, error) {
+	message := asn1.RawValue{}
+	if _, err := asn1.Unmarshal(data, &message); err != nil || message.Tag != asn1.TagSequence {
+		return 0, ErrInvalidLDAPResponse
+	}
+	var id int
+	rest, err := asn1.Unmarshal(message.Bytes, &id)
+	if err != nil || id != messageID {
+		return 0, ErrInvalidLDAPResponse
+	}
+	op := asn1.RawValue{}
+	if _, err := asn1.Unmarshal(rest, &op); err != nil || op.Class != asn1.ClassApplication || op.Tag != 1 {
+		return 0, ErrInvalidLDAPResponse
+	}
+	var resultCode asn1.Enumerated
+	if _, err := asn1.Unmarshal(op.Bytes, &resultCode); err != nil {
+		return 0, ErrInvalidLDAPResponse
+	}
+	return int(resultCode), nil
+}
+
+// bind returns nil if LDAP server accepted simple bind with dn and password
+func (provider *LDAPProvider) bind(dn, password string) error {
+	dialer := &net.Dialer{Timeout: provider.timeout}
+	var conn net.Conn
+	var err error
+	if provider.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", provider.address, provider.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", provider.address)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(provider.timeout)); err != nil {
+		return err
+	}
+	const messageID = 1
+	request, err := marshalBindRequest(messageID, dn, password)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+	response, err := readBERElement(conn)
+	if err != nil {
+		return err
+	}
+	resultCode, err := parseBindResponse(response, messageID)
+	if err != nil {
+		return err
+	}
+	if resultCode != ldapResultSuccess {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// Authenticate checks basic auth credentials with bind to LDAP server
+func (provider *LDAPProvider) Authenticate(request *http.Request) (*Identity, error) {
+	username, password, ok := request.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	// bind with empty password is unauthenticated bind which servers accept for any DN
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	if err := provider.bind(fmt.Sprintf(provider.bindDNTemplate, EscapeDNValue(username)), password); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return &Identity{Name: username, Provider: LDAPProviderType}, nil
+}
+
+// Challenge returns basic auth challenge with realm
+func (provider *LDAPProvider) Challenge() string {
+	return fmt.Sprintf(`Basic realm="%s"`, provider.realm)
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpauth
+
+import (
+	"encoding/asn1"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// startFakeLDAPServer accepts simple binds with dn and password from accounts and returns its address
+func startFakeLDAPServer(t *testing.T, accounts map[string]string) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				data, err := readBERElement(conn)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				message := asn1.RawValue{}
+				var messageID, version int
+				var dn []byte
+				op, password := asn1.RawValue{}, asn1.RawValue{}
+				asn1.Unmarshal(data, &message)
+				rest, _ := asn1.Unmarshal(message.Bytes, &messageID)
+				asn1.Unmarshal(rest, &op)
+				rest, _ = asn1.Unmarshal(op.Bytes, &version)
+				rest, _ = asn1.Unmarshal(rest, &dn)
+				asn1.Unmarshal(rest, &password)
+				if op.Class != asn1.ClassApplication || op.Tag != 0 || version != 3 || password.Class != asn1.ClassContextSpecific {
+					t.Errorf("unexpected bind request %v", data)
+					return
+				}
+				// invalidCredentials
+				resultCode := asn1.Enumerated(49)
+				if expected, ok := accounts[string(dn)]; ok && expected == string(password.Bytes) {
+					resultCode = ldapResultSuccess
+				}
+				var response []byte
+				for _, value := range []interface{}{resultCode, []byte{}, []byte{}} {
+					encoded, _ := asn1.Marshal(value)
+					response = append(response, encoded...)
+				}
+				id, _ := asn1.Marshal(messageID)
+				encodedOp, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassApplication, Tag: 1, IsCompound: true, Bytes: response})
+				encoded, _ := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: append(id, encodedOp...)})
+				conn.Write(encoded)
+			}(conn)
+		}
+	}()
+	return listener.Addr().String(), func() { listener.Close() }
+}
+
+func TestLDAPProvider(t *testing.T) {
+	address, stop := startFakeLDAPServer(t, map[string]string{
+		"uid=user,ou=people,dc=example,dc=com":             "password",
+		`uid=user\,ou\=admins,ou=people,dc=example,dc=com`: "password",
+	})
+	defer stop()
+	provider, err := NewLDAPProviderFromConfig([]byte("address: " + address + "\nbind_dn_template: uid=%s,ou=people,dc=example,dc=com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, err := provider.Authenticate(request); err != ErrNoCredentials {
+		t.Fatalf("expected ErrNoCredentials, took %v", err)
+	}
+	request.SetBasicAuth("user", "password")
+	identity, err := provider.Authenticate(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Name != "user" || identity.Provider != LDAPProviderType {
+		t.Fatalf("incorrect identity %v", identity)
+	}
+	for _, credentials := range [][2]string{{"user", "incorrect"}, {"user", ""}, {"unknown", "password"}} {
+		request.SetBasicAuth(credentials[0], credentials[1])
+		if _, err := provider.Authenticate(request); err != ErrInvalidCredentials {
+			t.Fatalf("expected ErrInvalidCredentials for %v, took %v", credentials, err)
+		}
+	}
+	// username with special characters is escaped and can't change DN
+	request.SetBasicAuth("user,ou=admins", "password")
+	if _, err := provider.Authenticate(request); err != nil {
+		t.Fatalf("expected escaped username to be accepted, took %v", err)
+	}
+}
+
+func TestEscapeDNValue(t *testing.T) {
+	for value, expected := range map[string]string{
+		"user":     "user",
+		"a,b+c":    `a\,b\+c`,
+		`"<x>";=\`: `\"\<x\>\"\;\=\\`,
+		" #user ":  `\ #user\ `,
+		"#user":    `\#user`,
+		"nul\x00":  `nul\00`,
+	} {
+		if escaped := EscapeDNValue(value); escaped != expected {
+			t.Errorf("incorrect escaping of %q: %s, expected %s", value, escaped, expected)
+		}
+	}
+}
+
+func TestNewLDAPProviderInvalidConfig(t *testing.T) {
+	for _, config := range []string{"bind_dn_template: uid=%s", "address: localhost:389", "address: localhost:389\nbind_dn_template: uid=%s,cn=%s"} {
+		if _, err := NewLDAPProviderFromConfig([]byte(config)); err == nil {
+			t.Fatalf("expected error for config %s", config)
+		}
+	}
+}
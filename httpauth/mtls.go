@@ -0,0 +1,71 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpauth
+
+import (
+	"net/http"
+
+	"gopkg.in/yaml.v2"
+)
+
+// MTLSProviderType is type of provider which authenticates requests by verified client certificates
+const MTLSProviderType = "mtls"
+
+// MTLSProvider authenticates requests received over TLS with client certificate verified by server. Identity is
+// common name of certificate. If allowed names are set, other certificates are rejected
+type MTLSProvider struct {
+	allowedNames map[string]bool
+}
+
+type mtlsProviderConfig struct {
+	AllowedCommonNames []string `yaml:"allowed_common_names"`
+}
+
+// NewMTLSProvider returns provider which accepts certificates with allowedNames or any verified certificate if
+// allowedNames is empty
+func NewMTLSProvider(allowedNames []string) *MTLSProvider {
+	provider := &MTLSProvider{}
+	if len(allowedNames) > 0 {
+		provider.allowedNames = make(map[string]bool, len(allowedNames))
+		for _, name := range allowedNames {
+			provider.allowedNames[name] = true
+		}
+	}
+	return provider
+}
+
+// NewMTLSProviderFromConfig returns MTLSProvider from configuration
+func NewMTLSProviderFromConfig(data []byte) (Provider, error) {
+	config := &mtlsProviderConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	return NewMTLSProvider(config.AllowedCommonNames), nil
+}
+
+// Authenticate returns common name of verified client certificate. Certificate should be verified by TLS server,
+// so provider checks only chains which server verified
+func (provider *MTLSProvider) Authenticate(request *http.Request) (*Identity, error) {
+	if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 || len(request.TLS.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredentials
+	}
+	name := request.TLS.VerifiedChains[0][0].Subject.CommonName
+	if provider.allowedNames != nil && !provider.allowedNames[name] {
+		return nil, ErrInvalidCredentials
+	}
+	return &Identity{Name: name, Provider: MTLSProviderType}, nil
+}
@@ -0,0 +1,291 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// OIDCProviderType is type of provider which authenticates requests by bearer ID tokens of OpenID Connect provider
+const OIDCProviderType = "oidc"
+
+// minJWKSRefreshInterval limits how often keys are fetched when token has unknown key id
+const minJWKSRefreshInterval = time.Minute
+
+// jwksFetchTimeout is timeout of request to JWKS URL
+const jwksFetchTimeout = time.Second * 5
+
+// ErrInvalidJWKS returned if JWKS can't be parsed
+var ErrInvalidJWKS = errors.New("invalid JSON web key set")
+
+// OIDCProvider authenticates requests with "Authorization: Bearer <token>" header where token is JWT signed with RS256
+// or ES256 by key from JSON web key set of issuer. Token should be issued by configured issuer for configured audience
+// and not expired. Identity is value of identity claim ("sub" by default)
+type OIDCProvider struct {
+	issuer        string
+	audience      string
+	identityClaim string
+	jwksURL       string
+	mutex         sync.Mutex
+	keys          map[string]crypto.PublicKey
+	lastFetch     time.Time
+	now           func() time.Time
+}
+
+type oidcProviderConfig struct {
+	Issuer        string `yaml:"issuer"`
+	Audience      string `yaml:"audience"`
+	IdentityClaim string `yaml:"identity_claim"`
+	JWKSURL       string `yaml:"jwks_url"`
+	JWKSFile      string `yaml:"jwks_file"`
+}
+
+// NewOIDCProvider returns provider which verifies tokens with keys from jwks. If jwksURL set, keys are fetched from
+// it on start and when token has unknown key id
+func NewOIDCProvider(issuer, audience, identityClaim, jwksURL string, jwks []byte) (*OIDCProvider, error) {
+	if issuer == "" || audience == "" || (jwksURL == "" && jwks == nil) {
+		return nil, ErrInvalidProviderConfig
+	}
+	if identityClaim == "" {
+		identityClaim = "sub"
+	}
+	provider := &OIDCProvider{issuer: issuer, audience: audience, identityClaim: identityClaim, jwksURL: jwksURL, now: time.Now}
+	if jwks == nil {
+		var err error
+		if jwks, err = provider.fetchJWKS(); err != nil {
+			return nil, err
+		}
+		provider.lastFetch = time.Now()
+	}
+	keys, err := ParseJWKS(jwks)
+	if err != nil {
+		return nil, err
+	}
+	provider.keys = keys
+	return provider, nil
+}
+
+// NewOIDCProviderFromConfig returns OIDCProvider from configuration
+func NewOIDCProviderFromConfig(data []byte) (Provider, error) {
+	config := &oidcProviderConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	var jwks []byte
+	if config.JWKSFile != "" {
+		var err error
+		if jwks, err = ioutil.ReadFile(config.JWKSFile); err != nil {
+			return nil, err
+		}
+	}
+	return NewOIDCProvider(config.Issuer, config.Audience, config.IdentityClaim, config.JWKSURL, jwks)
+}
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Curve   string `json:"crv"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, ErrInvalidJWKS
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// ParseJWKS returns RSA and P-256 EC public keys from JSON web key set by their key ids. Keys of other types are skipped
+func ParseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	jwks := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, ErrInvalidJWKS
+	}
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, key := range jwks.Keys {
+		switch {
+		case key.KeyType == "RSA":
+			n, err := decodeBigInt(key.N)
+			if err != nil {
+				return nil, err
+			}
+			e, err := decodeBigInt(key.E)
+			if err != nil || !e.IsInt64() {
+				return nil, ErrInvalidJWKS
+			}
+			keys[key.KeyID] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case key.KeyType == "EC" && key.Curve == "P-256":
+			x, err := decodeBigInt(key.X)
+			if err != nil {
+				return nil, err
+			}
+			y, err := decodeBigInt(key.Y)
+			if err != nil {
+				return nil, err
+			}
+			if !elliptic.P256().IsOnCurve(x, y) {
+				return nil, ErrInvalidJWKS
+			}
+			keys[key.KeyID] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		}
+	}
+	return keys, nil
+}
+
+func (provider *OIDCProvider) fetchJWKS() ([]byte, error) {
+	client := &http.Client{Timeout: jwksFetchTimeout}
+	response, err := client.Get(provider.jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, ErrInvalidJWKS
+	}
+	return ioutil.ReadAll(response.Body)
+}
+
+// getKey returns key by id, keys are refetched if key is unknown and they weren't fetched recently
+func (provider *OIDCProvider) getKey(keyID string) (crypto.PublicKey, bool) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	if key, ok := provider.keys[keyID]; ok {
+		return key, true
+	}
+	if provider.jwksURL == "" || provider.now().Sub(provider.lastFetch) < minJWKSRefreshInterval {
+		return nil, false
+	}
+	provider.lastFetch = provider.now()
+	data, err := provider.fetchJWKS()
+	if err != nil {
+		return nil, false
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return nil, false
+	}
+	provider.keys = keys
+	key, ok := keys[keyID]
+	return key, ok
+}
+
+func verifySignature(algorithm string, key crypto.PublicKey, signed, signature []byte) bool {
+	hash := sha256.Sum256(signed)
+	switch algorithm {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, hash[:], signature) == nil
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(ecKey, hash[:], r, s)
+	}
+	return false
+}
+
+// hasAudience returns true if aud claim, string or list of strings, contains audience
+func hasAudience(claim interface{}, audience string) bool {
+	switch value := claim.(type) {
+	case string:
+		return value == audience
+	case []interface{}:
+		for _, item := range value {
+			if item == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Authenticate verifies bearer token from Authorization header
+func (provider *OIDCProvider) Authenticate(request *http.Request) (*Identity, error) {
+	authorization := request.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return nil, ErrNoCredentials
+	}
+	parts := strings.Split(strings.TrimPrefix(authorization, "Bearer "), ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidCredentials
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	var claims map[string]interface{}
+	headerData, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(headerData, &header) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	claimsData, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(claimsData, &claims) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	key, ok := provider.getKey(header.KeyID)
+	if !ok || !verifySignature(header.Algorithm, key, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidCredentials
+	}
+	now := float64(provider.now().Unix())
+	expiresAt, ok := claims["exp"].(float64)
+	if !ok || now >= expiresAt {
+		return nil, ErrInvalidCredentials
+	}
+	if notBefore, ok := claims["nbf"].(float64); ok && now < notBefore {
+		return nil, ErrInvalidCredentials
+	}
+	if claims["iss"] != provider.issuer || !hasAudience(claims["aud"], provider.audience) {
+		return nil, ErrInvalidCredentials
+	}
+	name, ok := claims[provider.identityClaim].(string)
+	if !ok || name == "" {
+		return nil, ErrInvalidCredentials
+	}
+	return &Identity{Name: name, Provider: OIDCProviderType}, nil
+}
+
+// Challenge returns bearer challenge
+func (provider *OIDCProvider) Challenge() string {
+	return "Bearer"
+}
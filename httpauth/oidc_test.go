@@ -0,0 +1,188 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func encodeSegment(t *testing.T, value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signToken(t *testing.T, algorithm, keyID string, key crypto.Signer, claims map[string]interface{}) string {
+	signed := encodeSegment(t, map[string]string{"alg": algorithm, "kid": keyID, "typ": "JWT"}) + "." + encodeSegment(t, claims)
+	hash := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch signer := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, signer, crypto.SHA256, hash[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, signer, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func testJWKS(rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey) []byte {
+	encode := func(value *big.Int) string { return base64.RawURLEncoding.EncodeToString(value.Bytes()) }
+	return []byte(fmt.Sprintf(`{"keys": [
+{"kty": "RSA", "kid": "rsa", "n": "%s", "e": "%s"},
+{"kty": "EC", "kid": "ec", "crv": "P-256", "x": "%s", "y": "%s"},
+{"kty": "oct", "kid": "symmetric", "k": "c2VjcmV0"}]}`,
+		encode(rsaKey.N), encode(big.NewInt(int64(rsaKey.E))), encode(ecKey.X), encode(ecKey.Y)))
+}
+
+func TestOIDCProvider(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := NewOIDCProvider("https://issuer", "acra", "email", "", testJWKS(rsaKey, ecKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	provider.now = func() time.Time { return now }
+	validClaims := func() map[string]interface{} {
+		return map[string]interface{}{"iss": "https://issuer", "aud": []string{"other", "acra"}, "email": "user@example.com",
+			"exp": now.Add(time.Minute).Unix(), "nbf": now.Add(-time.Minute).Unix()}
+	}
+	authenticate := func(token string) (*Identity, error) {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		return provider.Authenticate(request)
+	}
+	if _, err := authenticate(""); err != ErrNoCredentials {
+		t.Fatalf("expected ErrNoCredentials, took %v", err)
+	}
+	for _, token := range []string{signToken(t, "RS256", "rsa", rsaKey, validClaims()), signToken(t, "ES256", "ec", ecKey, validClaims())} {
+		identity, err := authenticate(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if identity.Name != "user@example.com" || identity.Provider != OIDCProviderType {
+			t.Fatalf("incorrect identity %v", identity)
+		}
+	}
+
+	invalidTokens := map[string]string{
+		"wrong key":       signToken(t, "RS256", "ec", rsaKey, validClaims()),
+		"unknown key":     signToken(t, "RS256", "unknown", rsaKey, validClaims()),
+		"wrong algorithm": signToken(t, "HS256", "symmetric", rsaKey, validClaims()),
+		"malformed":       "a.b",
+		"unsigned":        encodeSegment(t, map[string]string{"alg": "none"}) + "." + encodeSegment(t, validClaims()) + ".",
+	}
+	for name, modify := range map[string]func(map[string]interface{}){
+		"expired":        func(claims map[string]interface{}) { claims["exp"] = now.Add(-time.Second).Unix() },
+		"without exp":    func(claims map[string]interface{}) { delete(claims, "exp") },
+		"not yet valid":  func(claims map[string]interface{}) { claims["nbf"] = now.Add(time.Minute).Unix() },
+		"wrong issuer":   func(claims map[string]interface{}) { claims["iss"] = "https://other" },
+		"wrong audience": func(claims map[string]interface{}) { claims["aud"] = "other" },
+		"no identity":    func(claims map[string]interface{}) { delete(claims, "email") },
+	} {
+		claims := validClaims()
+		modify(claims)
+		invalidTokens[name] = signToken(t, "RS256", "rsa", rsaKey, claims)
+	}
+	parts := strings.Split(signToken(t, "RS256", "rsa", rsaKey, validClaims()), ".")
+	changedClaims := validClaims()
+	changedClaims["email"] = "admin@example.com"
+	invalidTokens["changed claims"] = parts[0] + "." + encodeSegment(t, changedClaims) + "." + parts[2]
+	for name, token := range invalidTokens {
+		if _, err := authenticate(token); err != ErrInvalidCredentials {
+			t.Errorf("%s: expected ErrInvalidCredentials, took %v", name, err)
+		}
+	}
+}
+
+func TestOIDCProviderFetchesJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := []byte(`{"keys": []}`)
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		fetches++
+		writer.Write(jwks)
+	}))
+	defer server.Close()
+
+	provider, err := NewOIDCProvider("issuer", "acra", "", server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	provider.now = func() time.Time { return now }
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("Authorization", "Bearer "+signToken(t, "RS256", "rsa", rsaKey, map[string]interface{}{
+		"iss": "issuer", "aud": "acra", "sub": "user", "exp": now.Add(time.Hour).Unix()}))
+
+	// keys rotated after start, but were fetched recently
+	jwks = testJWKS(rsaKey, ecKey)
+	if _, err := provider.Authenticate(request); err != ErrInvalidCredentials {
+		t.Fatalf("expected ErrInvalidCredentials, took %v", err)
+	}
+	now = now.Add(minJWKSRefreshInterval)
+	identity, err := provider.Authenticate(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Name != "user" {
+		t.Fatalf("incorrect identity %v", identity)
+	}
+	if fetches != 2 {
+		t.Fatalf("expected 2 fetches of JWKS, took %d", fetches)
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package httpauth implements pluggable authentication of HTTP requests to AcraServer HTTP API, AcraWebConfig and
+// AcraTranslator. Deployments choose providers (static users, mTLS, OIDC, LDAP) in configuration file and requests are
+// authenticated by first provider which accepts their credentials.
+package httpauth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/cossacklabs/acra/logging"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Errors returned by providers
+var (
+	// ErrNoCredentials returned if request has no credentials which provider can check
+	ErrNoCredentials = errors.New("request has no credentials")
+	// ErrInvalidCredentials returned if request has credentials which provider rejected
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrUnknownProviderType returned if configuration uses type without registered factory
+	ErrUnknownProviderType = errors.New("unknown type of authentication provider")
+	// ErrInvalidProviderConfig returned if provider's configuration is incomplete
+	ErrInvalidProviderConfig = errors.New("invalid configuration of authentication provider")
+)
+
+// Identity describes authenticated author of request
+type Identity struct {
+	Name     string
+	Provider string
+}
+
+// Provider authenticates HTTP requests. Authenticate returns ErrNoCredentials if request has no credentials of type
+// which provider checks, so next provider may try
+type Provider interface {
+	Authenticate(request *http.Request) (*Identity, error)
+}
+
+// Challenger is implemented by providers which tell client how to authenticate with WWW-Authenticate header
+type Challenger interface {
+	Challenge() string
+}
+
+// ProviderFactory creates provider from its configuration in YAML format
+type ProviderFactory func(config []byte) (Provider, error)
+
+var (
+	factoriesMutex sync.RWMutex
+	factories      = make(map[string]ProviderFactory)
+)
+
+// RegisterProvider registers factory of providers with type used in configuration. Allows to add own providers
+// without changes in services
+func RegisterProvider(providerType string, factory ProviderFactory) {
+	factoriesMutex.Lock()
+	factories[providerType] = factory
+	factoriesMutex.Unlock()
+}
+
+func init() {
+	RegisterProvider(StaticProviderType, NewStaticProviderFromConfig)
+	RegisterProvider(MTLSProviderType, NewMTLSProviderFromConfig)
+	RegisterProvider(OIDCProviderType, NewOIDCProviderFromConfig)
+	RegisterProvider(LDAPProviderType, NewLDAPProviderFromConfig)
+}
+
+// Config lists providers, each item has "type" and options of provider
+type Config struct {
+	Providers []map[string]interface{} `yaml:"providers"`
+}
+
+// LoadProviders creates providers from configuration in YAML format and returns them as one Chain
+func LoadProviders(data []byte) (*Chain, error) {
+	config := &Config{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	if len(config.Providers) == 0 {
+		return nil, ErrInvalidProviderConfig
+	}
+	chain := &Chain{}
+	for i, providerConfig := range config.Providers {
+		providerType, _ := providerConfig["type"].(string)
+		factoriesMutex.RLock()
+		factory, ok := factories[providerType]
+		factoriesMutex.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("provider %d: %s: %v", i, ErrUnknownProviderType, providerType)
+		}
+		providerData, err := yaml.Marshal(providerConfig)
+		if err != nil {
+			return nil, err
+		}
+		provider, err := factory(providerData)
+		if err != nil {
+			return nil, fmt.Errorf("provider %d (%s): %v", i, providerType, err)
+		}
+		chain.providers = append(chain.providers, provider)
+	}
+	return chain, nil
+}
+
+// Chain authenticates request with first provider which finds credentials in it
+type Chain struct {
+	providers []Provider
+}
+
+// NewChain returns Chain of providers
+func NewChain(providers ...Provider) *Chain {
+	return &Chain{providers: providers}
+}
+
+// Authenticate returns identity from first provider which accepted request. Returns ErrInvalidCredentials if some
+// provider rejected credentials and ErrNoCredentials if no provider found credentials
+func (chain *Chain) Authenticate(request *http.Request) (*Identity, error) {
+	result := ErrNoCredentials
+	for _, provider := range chain.providers {
+		identity, err := provider.Authenticate(request)
+		if err == nil {
+			return identity, nil
+		}
+		if err != ErrNoCredentials {
+			result = ErrInvalidCredentials
+		}
+	}
+	return nil, result
+}
+
+// Challenge returns challenge of first provider which has it
+func (chain *Chain) Challenge() string {
+	for _, provider := range chain.providers {
+		if challenger, ok := provider.(Challenger); ok {
+			return challenger.Challenge()
+		}
+	}
+	return ""
+}
+
+// Handler returns handler which calls next handler only for requests authenticated by provider
+func Handler(provider Provider, next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if _, err := provider.Authenticate(request); err != nil {
+			log.WithError(err).WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeErrorAuthenticationFailed, "remote_address": request.RemoteAddr}).
+				Warningf("Rejected unauthenticated request to %v", request.URL.Path)
+			if challenger, ok := provider.(Challenger); ok && challenger.Challenge() != "" {
+				writer.Header().Set("WWW-Authenticate", challenger.Challenge())
+			}
+			http.Error(writer, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next(writer, request)
+	}
+}
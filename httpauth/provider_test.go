@@ -0,0 +1,186 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpauth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/cossacklabs/acra/cmd"
+)
+
+func testArgon2User(t *testing.T, user, password string) string {
+	params := cmd.Argon2Params{Time: 1, Memory: 1024, Threads: 1, Length: 32}
+	salt := "somesalt"
+	hash, err := cmd.HashArgon2(password, salt, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("%s:%s:%d,%d,%d,%d:%s", user, salt, params.Time, params.Memory, params.Threads, params.Length,
+		base64.StdEncoding.EncodeToString(hash))
+}
+
+func TestStaticProvider(t *testing.T) {
+	users, err := ParseArgon2Users([]byte(testArgon2User(t, "user1", "password1") + "\n\n" + testArgon2User(t, "user2", "password2") + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := NewStaticProvider(users, "")
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, err := provider.Authenticate(request); err != ErrNoCredentials {
+		t.Fatalf("expected ErrNoCredentials, took %v", err)
+	}
+	request.SetBasicAuth("user2", "password2")
+	identity, err := provider.Authenticate(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Name != "user2" || identity.Provider != StaticProviderType {
+		t.Fatalf("incorrect identity %v", identity)
+	}
+	for _, credentials := range [][2]string{{"user2", "password1"}, {"unknown", "password1"}} {
+		request.SetBasicAuth(credentials[0], credentials[1])
+		if _, err := provider.Authenticate(request); err != ErrInvalidCredentials {
+			t.Fatalf("expected ErrInvalidCredentials for %v, took %v", credentials, err)
+		}
+	}
+	if provider.Challenge() != `Basic realm="Acra"` {
+		t.Fatalf("incorrect challenge %s", provider.Challenge())
+	}
+}
+
+func TestParseArgon2UsersInvalid(t *testing.T) {
+	for _, data := range []string{"user:salt:1,2,3,4", "user:salt:1,2,3:aGFzaA==", "user:salt:1,2,300,4:aGFzaA==", "user:salt:1,2,3,4:#"} {
+		if _, err := ParseArgon2Users([]byte(data)); err == nil {
+			t.Fatalf("expected error for %s", data)
+		}
+	}
+}
+
+func TestMTLSProvider(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	provider := NewMTLSProvider([]string{"client1"})
+	if _, err := provider.Authenticate(request); err != ErrNoCredentials {
+		t.Fatalf("expected ErrNoCredentials, took %v", err)
+	}
+	request.TLS = &tls.ConnectionState{}
+	if _, err := provider.Authenticate(request); err != ErrNoCredentials {
+		t.Fatalf("expected ErrNoCredentials for unverified connection, took %v", err)
+	}
+	request.TLS.VerifiedChains = [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "client1"}}}}
+	identity, err := provider.Authenticate(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Name != "client1" {
+		t.Fatalf("incorrect identity %v", identity)
+	}
+	request.TLS.VerifiedChains[0][0].Subject.CommonName = "client2"
+	if _, err := provider.Authenticate(request); err != ErrInvalidCredentials {
+		t.Fatalf("expected ErrInvalidCredentials, took %v", err)
+	}
+	if _, err := NewMTLSProvider(nil).Authenticate(request); err != nil {
+		t.Fatalf("provider without allowed names should accept any verified certificate, took %v", err)
+	}
+}
+
+func TestChain(t *testing.T) {
+	users, err := ParseArgon2Users([]byte(testArgon2User(t, "user", "password")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := NewChain(NewMTLSProvider(nil), NewStaticProvider(users, "test"))
+	if chain.Challenge() != `Basic realm="test"` {
+		t.Fatalf("incorrect challenge %s", chain.Challenge())
+	}
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, err := chain.Authenticate(request); err != ErrNoCredentials {
+		t.Fatalf("expected ErrNoCredentials, took %v", err)
+	}
+	request.SetBasicAuth("user", "incorrect")
+	if _, err := chain.Authenticate(request); err != ErrInvalidCredentials {
+		t.Fatalf("expected ErrInvalidCredentials, took %v", err)
+	}
+	request.SetBasicAuth("user", "password")
+	identity, err := chain.Authenticate(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Provider != StaticProviderType {
+		t.Fatalf("incorrect identity %v", identity)
+	}
+
+	handler := Handler(chain, func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusNoContent)
+	})
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("expected authenticated request to pass, took status %d", recorder.Code)
+	}
+	recorder = httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if recorder.Code != http.StatusUnauthorized || recorder.Header().Get("WWW-Authenticate") != `Basic realm="test"` {
+		t.Fatalf("expected 401 with challenge, took %d %v", recorder.Code, recorder.Header())
+	}
+}
+
+func TestLoadProviders(t *testing.T) {
+	usersFile, err := ioutil.TempFile("", "httpauth_users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(usersFile.Name())
+	if _, err := usersFile.WriteString(testArgon2User(t, "user", "password")); err != nil {
+		t.Fatal(err)
+	}
+	usersFile.Close()
+
+	chain, err := LoadProviders([]byte(fmt.Sprintf(`
+providers:
+  - type: mtls
+    allowed_common_names: [client]
+  - type: static
+    file: %s
+    realm: test
+`, usersFile.Name())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain.providers) != 2 {
+		t.Fatalf("expected 2 providers, took %d", len(chain.providers))
+	}
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.SetBasicAuth("user", "password")
+	if _, err := chain.Authenticate(request); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, config := range []string{"providers: []", "providers:\n  - type: unknown", "providers:\n  - type: static", "providers:\n  - type: ldap\n    address: localhost:389"} {
+		if _, err := LoadProviders([]byte(config)); err == nil {
+			t.Fatalf("expected error for config %s", config)
+		}
+	}
+}
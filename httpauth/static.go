@@ -0,0 +1,138 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpauth
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cossacklabs/acra/cmd"
+	"gopkg.in/yaml.v2"
+)
+
+// StaticProviderType is type of provider which checks basic auth credentials against static list of users
+const StaticProviderType = "static"
+
+// DefaultRealm used in basic auth challenge if realm isn't configured
+const DefaultRealm = "Acra"
+
+// ErrInvalidUsersData returned if users data has line in wrong format
+var ErrInvalidUsersData = errors.New("invalid users data, expected lines <user>:<salt>:<time>,<memory>,<threads>,<length>:<base64 hash>")
+
+// StaticProvider authenticates requests with basic auth credentials of users which passwords are hashed with Argon2
+type StaticProvider struct {
+	users map[string]cmd.UserAuth
+	realm string
+}
+
+// NewStaticProvider returns provider which accepts users from map
+func NewStaticProvider(users map[string]cmd.UserAuth, realm string) *StaticProvider {
+	if realm == "" {
+		realm = DefaultRealm
+	}
+	return &StaticProvider{users: users, realm: realm}
+}
+
+// staticProviderConfig is configuration of StaticProvider. File has same format as decrypted file of acra-authmanager
+type staticProviderConfig struct {
+	File  string `yaml:"file"`
+	Realm string `yaml:"realm"`
+}
+
+// NewStaticProviderFromConfig returns StaticProvider with users from file set in configuration
+func NewStaticProviderFromConfig(data []byte) (Provider, error) {
+	config := &staticProviderConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	if config.File == "" {
+		return nil, ErrInvalidProviderConfig
+	}
+	usersData, err := ioutil.ReadFile(config.File)
+	if err != nil {
+		return nil, err
+	}
+	users, err := ParseArgon2Users(usersData)
+	if err != nil {
+		return nil, err
+	}
+	return NewStaticProvider(users, config.Realm), nil
+}
+
+// ParseArgon2Users parses users in format of acra-authmanager, one user per line
+func ParseArgon2Users(data []byte) (map[string]cmd.UserAuth, error) {
+	users := make(map[string]cmd.UserAuth)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		parts := strings.Split(line, ":")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("line %d: %v", i+1, ErrInvalidUsersData)
+		}
+		params := strings.Split(parts[2], ",")
+		if len(params) != 4 {
+			return nil, fmt.Errorf("line %d: %v", i+1, ErrInvalidUsersData)
+		}
+		var values [4]uint64
+		bitSizes := [4]int{32, 32, 8, 32}
+		for j, param := range params {
+			value, err := strconv.ParseUint(param, 10, bitSizes[j])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+1, ErrInvalidUsersData)
+			}
+			values[j] = value
+		}
+		hash, err := base64.StdEncoding.DecodeString(parts[3])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, ErrInvalidUsersData)
+		}
+		users[parts[0]] = cmd.UserAuth{Salt: parts[1], Hash: hash, Argon2Params: cmd.Argon2Params{
+			Time: uint32(values[0]), Memory: uint32(values[1]), Threads: uint8(values[2]), Length: uint32(values[3]),
+		}}
+	}
+	return users, nil
+}
+
+// Authenticate checks user and password from basic auth header
+func (provider *StaticProvider) Authenticate(request *http.Request) (*Identity, error) {
+	user, password, ok := request.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	userAuth, ok := provider.users[user]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	hash, err := cmd.HashArgon2(password, userAuth.Salt, userAuth.Argon2Params)
+	if err != nil || subtle.ConstantTimeCompare(hash, userAuth.Hash) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	return &Identity{Name: user, Provider: StaticProviderType}, nil
+}
+
+// Challenge returns basic auth challenge with realm
+func (provider *StaticProvider) Challenge() string {
+	return fmt.Sprintf(`Basic realm="%s"`, provider.realm)
+}
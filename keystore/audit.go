@@ -0,0 +1,198 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/themis/gothemis/keys"
+	log "github.com/sirupsen/logrus"
+)
+
+// KeyAccessRecord describes one load of private key with purpose from KeyInfo. Audit log contains records in JSON format, one per line
+type KeyAccessRecord struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Purpose    string    `json:"purpose"`
+	ClientID   string    `json:"client_id,omitempty"`
+	ZoneID     string    `json:"zone_id,omitempty"`
+	Connection string    `json:"connection,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// KeyAccessAuditLog appends records of key loads to file. Existing records are never rewritten, so investigation of
+// incidents may rely on the file as history of all key usages
+type KeyAccessAuditLog struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// NewKeyAccessAuditLog opens file at path for appending or creates it with permissions only for owner
+func NewKeyAccessAuditLog(path string) (*KeyAccessAuditLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyAccessAuditLog{file: file}, nil
+}
+
+// Write appends record as one line, so records of concurrent loads don't interleave
+func (auditLog *KeyAccessAuditLog) Write(record *KeyAccessRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	auditLog.mutex.Lock()
+	defer auditLog.mutex.Unlock()
+	_, err = auditLog.file.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes file of audit log
+func (auditLog *KeyAccessAuditLog) Close() error {
+	auditLog.mutex.Lock()
+	defer auditLog.mutex.Unlock()
+	return auditLog.file.Close()
+}
+
+// AuditKeyStore wraps KeyStore and records every load of private keys to KeyAccessAuditLog. Failed loads are recorded
+// too with error, e.g. attempts to use keys of revoked ids
+type AuditKeyStore struct {
+	KeyStore
+	auditLog *KeyAccessAuditLog
+	// clientID and connection identify connection on behalf of which keys are loaded, empty for keystore shared by all
+	// connections
+	clientID   []byte
+	connection string
+}
+
+// listingAuditKeyStore is AuditKeyStore of keystore which can list keys
+type listingAuditKeyStore struct {
+	*AuditKeyStore
+	KeyLister
+}
+
+// NewAuditKeyStore returns store which records loads of private keys to auditLog. Returned store implements KeyLister
+// if store does
+func NewAuditKeyStore(store KeyStore, auditLog *KeyAccessAuditLog) KeyStore {
+	return newAuditKeyStore(&AuditKeyStore{KeyStore: store, auditLog: auditLog})
+}
+
+func newAuditKeyStore(auditStore *AuditKeyStore) KeyStore {
+	if lister, ok := auditStore.KeyStore.(KeyLister); ok {
+		return &listingAuditKeyStore{AuditKeyStore: auditStore, KeyLister: lister}
+	}
+	return auditStore
+}
+
+// ConnectionAuditKeyStore is keystore which records key loads on behalf of connections
+type ConnectionAuditKeyStore interface {
+	WithConnection(clientID []byte, connection string) KeyStore
+}
+
+// WithConnection returns store which shares wrapped keystore and audit log but records loads on behalf of connection
+// from client with clientID
+func (store *AuditKeyStore) WithConnection(clientID []byte, connection string) KeyStore {
+	return newAuditKeyStore(&AuditKeyStore{KeyStore: store.KeyStore, auditLog: store.auditLog, clientID: clientID, connection: connection})
+}
+
+func (store *AuditKeyStore) record(purpose string, clientID, zoneID []byte, err error) {
+	if clientID == nil {
+		clientID = store.clientID
+	}
+	record := &KeyAccessRecord{
+		Timestamp:  time.Now().UTC(),
+		Purpose:    purpose,
+		ClientID:   string(clientID),
+		ZoneID:     string(zoneID),
+		Connection: store.connection,
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if writeErr := store.auditLog.Write(record); writeErr != nil {
+		log.WithError(writeErr).WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeErrorCantWriteKeyAccessAudit, "purpose": purpose}).
+			Errorln("Can't write record to key access audit log")
+	}
+}
+
+// GetPrivateKey returns transport private key and records its load
+func (store *AuditKeyStore) GetPrivateKey(id []byte) (*keys.PrivateKey, error) {
+	key, err := store.KeyStore.GetPrivateKey(id)
+	store.record(KeyPurposeServerTransport, id, nil, err)
+	return key, err
+}
+
+// GetZonePrivateKey returns private key of zone and records its load
+func (store *AuditKeyStore) GetZonePrivateKey(id []byte) (*keys.PrivateKey, error) {
+	key, err := store.KeyStore.GetZonePrivateKey(id)
+	store.record(KeyPurposeZone, nil, id, err)
+	return key, err
+}
+
+// GetZonePrivateKeys returns all versions of private key of zone and records their load
+func (store *AuditKeyStore) GetZonePrivateKeys(id []byte) ([]*keys.PrivateKey, error) {
+	privateKeys, err := store.KeyStore.GetZonePrivateKeys(id)
+	store.record(KeyPurposeZone, nil, id, err)
+	return privateKeys, err
+}
+
+// GetServerDecryptionPrivateKey returns private key of client for decryption and records its load
+func (store *AuditKeyStore) GetServerDecryptionPrivateKey(id []byte) (*keys.PrivateKey, error) {
+	key, err := store.KeyStore.GetServerDecryptionPrivateKey(id)
+	store.record(KeyPurposeStorage, id, nil, err)
+	return key, err
+}
+
+// GetServerDecryptionPrivateKeys returns all versions of private key of client for decryption and records their load
+func (store *AuditKeyStore) GetServerDecryptionPrivateKeys(id []byte) ([]*keys.PrivateKey, error) {
+	privateKeys, err := store.KeyStore.GetServerDecryptionPrivateKeys(id)
+	store.record(KeyPurposeStorage, id, nil, err)
+	return privateKeys, err
+}
+
+// GetHMACSecretKey returns key for searchable hashes of client and records its load
+func (store *AuditKeyStore) GetHMACSecretKey(id []byte) ([]byte, error) {
+	key, err := store.KeyStore.GetHMACSecretKey(id)
+	store.record(KeyPurposeHMAC, id, nil, err)
+	return key, err
+}
+
+// GetPoisonKeyPair returns key pair of poison records and records its load
+func (store *AuditKeyStore) GetPoisonKeyPair() (*keys.Keypair, error) {
+	keypair, err := store.KeyStore.GetPoisonKeyPair()
+	store.record(KeyPurposePoison, nil, nil, err)
+	return keypair, err
+}
+
+// GetAuthKey returns key of HTTP API authentication and records its load
+func (store *AuditKeyStore) GetAuthKey(remove bool) ([]byte, error) {
+	key, err := store.KeyStore.GetAuthKey(remove)
+	store.record(KeyPurposeAuth, nil, nil, err)
+	return key, err
+}
+
+// GetComparatorSecret returns secret shared with AcraConnector of client if wrapped keystore stores them and records
+// its load
+func (store *AuditKeyStore) GetComparatorSecret(id []byte) ([]byte, error) {
+	secret, err := getComparatorSecret(store.KeyStore, id)
+	store.record(KeyPurposeComparatorSecret, id, nil, err)
+	return secret, err
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cossacklabs/themis/gothemis/keys"
+)
+
+// testAuditKeyStore returns keys for any client id and fails loads of zone keys
+type testAuditKeyStore struct {
+	KeyStore
+}
+
+func (testAuditKeyStore) GetServerDecryptionPrivateKeys(id []byte) ([]*keys.PrivateKey, error) {
+	return []*keys.PrivateKey{{Value: id}}, nil
+}
+
+func (testAuditKeyStore) GetZonePrivateKeys(id []byte) ([]*keys.PrivateKey, error) {
+	return nil, errors.New("zone key not found")
+}
+
+func readKeyAccessRecords(t *testing.T, path string) []KeyAccessRecord {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var records []KeyAccessRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		record := KeyAccessRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAuditKeyStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	auditLog, err := NewKeyAccessAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewAuditKeyStore(testAuditKeyStore{}, auditLog)
+	if _, ok := store.(KeyLister); ok {
+		t.Fatal("Store shouldn't implement KeyLister if wrapped keystore doesn't")
+	}
+	if _, err := store.GetServerDecryptionPrivateKeys([]byte("client")); err != nil {
+		t.Fatal(err)
+	}
+	connectionStore := store.(ConnectionAuditKeyStore).WithConnection([]byte("connection_client"), "127.0.0.1:5432")
+	if _, err := connectionStore.GetZonePrivateKeys([]byte("DDDDDDDDzone")); err == nil {
+		t.Fatal("Expected error of wrapped keystore")
+	}
+	if err := auditLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// records are appended to existing file
+	auditLog, err = NewKeyAccessAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewAuditKeyStore(testAuditKeyStore{}, auditLog).GetServerDecryptionPrivateKeys([]byte("other_client")); err != nil {
+		t.Fatal(err)
+	}
+	auditLog.Close()
+
+	records := readKeyAccessRecords(t, path)
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, took %v", len(records))
+	}
+	if records[0].Purpose != KeyPurposeStorage || records[0].ClientID != "client" || records[0].Connection != "" || records[0].Error != "" {
+		t.Fatalf("Incorrect record of decryption key: %+v", records[0])
+	}
+	if records[1].Purpose != KeyPurposeZone || records[1].ZoneID != "DDDDDDDDzone" || records[1].ClientID != "connection_client" ||
+		records[1].Connection != "127.0.0.1:5432" || records[1].Error == "" {
+		t.Fatalf("Incorrect record of zone key: %+v", records[1])
+	}
+	if records[2].ClientID != "other_client" || records[2].Timestamp.Before(records[0].Timestamp) {
+		t.Fatalf("Incorrect appended record: %+v", records[2])
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("Audit log should be accessible only by owner, took %v", info.Mode().Perm())
+	}
+}
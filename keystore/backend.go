@@ -0,0 +1,110 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cossacklabs/acra/acraerrors"
+)
+
+// DefaultBackendType is type of keystore used when other isn't selected
+const DefaultBackendType = "filesystem"
+
+// ErrUnknownBackendType returned if keystore of requested type wasn't registered
+var ErrUnknownBackendType = acraerrors.New(acraerrors.CodeUnknownBackendType, "unknown keystore type")
+
+// Backend is keystore implementation which stores keys of AcraServer. Backends may additionally implement optional
+// interfaces like KeyLister or KeyExporter which are checked with type assertions by features that need them
+type Backend interface {
+	KeyStore
+}
+
+// BackendParams are settings passed to factory of keystore backend
+type BackendParams struct {
+	// PrivateKeysDir and PublicKeysDir are folders with keys of file based backends
+	PrivateKeysDir string
+	PublicKeysDir  string
+	Encryptor      KeyEncryptor
+	CacheSize      int
+	// EphemeralCacheKey turns on encryption of cached keys with key generated on start of process
+	EphemeralCacheKey bool
+	// Options are backend specific settings like addresses of remote storages
+	Options map[string]string
+}
+
+// BackendFactory creates keystore backend with params
+type BackendFactory func(params BackendParams) (Backend, error)
+
+var (
+	backendsLock sync.RWMutex
+	backends     = make(map[string]BackendFactory)
+)
+
+// RegisterBackend registers factory of keystore backend with type name. Backends register themselves in init of their
+// packages so services select them by name without knowing implementations
+func RegisterBackend(backendType string, factory BackendFactory) {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+	if _, ok := backends[backendType]; ok {
+		panic(fmt.Sprintf("keystore backend %s registered twice", backendType))
+	}
+	backends[backendType] = factory
+}
+
+// BackendTypes returns sorted names of registered keystore backends
+func BackendTypes() []string {
+	backendsLock.RLock()
+	defer backendsLock.RUnlock()
+	types := make([]string, 0, len(backends))
+	for backendType := range backends {
+		types = append(types, backendType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// NewBackend creates keystore backend of registered type
+func NewBackend(backendType string, params BackendParams) (Backend, error) {
+	backendsLock.RLock()
+	factory, ok := backends[backendType]
+	backendsLock.RUnlock()
+	if !ok {
+		return nil, ErrUnknownBackendType
+	}
+	return factory(params)
+}
+
+// ParseBackendOptions parses comma separated backend options like "address=127.0.0.1:6379,db=1"
+func ParseBackendOptions(value string) (map[string]string, error) {
+	options := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		pair := strings.SplitN(part, "=", 2)
+		if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" {
+			return nil, fmt.Errorf("invalid keystore option %q, expected key=value", part)
+		}
+		options[strings.TrimSpace(pair[0])] = strings.TrimSpace(pair[1])
+	}
+	return options, nil
+}
@@ -35,13 +35,31 @@ func (NoCache) Get(keyID string) ([]byte, bool) {
 	return nil, false
 }
 
+// Remove empty implementation
+func (NoCache) Remove(keyID string) {
+}
+
 // Clear empty implementation
 func (NoCache) Clear() {
 }
 
+// Keys empty implementation
+func (NoCache) Keys() []string {
+	return nil
+}
+
 // Cache that used by FilesystemKeystore to cache loaded keys from filesystem
 type Cache interface {
 	Add(keyID string, keyValue []byte)
 	Get(keyID string) ([]byte, bool)
+	Remove(keyID string)
 	Clear()
+	Keys() []string
+}
+
+// CacheWarmer is keystore which can list cached keys and load keys to cache in advance, so warm standby service
+// doesn't read keys from storage after failover
+type CacheWarmer interface {
+	CachedKeys() []string
+	WarmUpCache(names []string) int
 }
@@ -0,0 +1,100 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"crypto/rand"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/utils"
+	"github.com/cossacklabs/themis/gothemis/cell"
+	log "github.com/sirupsen/logrus"
+)
+
+// EphemeralCacheKeyLength is length of random key which encrypts cached keys
+const EphemeralCacheKeyLength = 32
+
+// ErrMemoryLockNotSupported returned if memory of ephemeral key can't be locked on current platform
+var ErrMemoryLockNotSupported = acraerrors.New(acraerrors.CodeMemoryLockNotSupported, "locking memory isn't supported on this platform")
+
+// EphemeralKeyCache wraps Cache and additionally encrypts cached values with key generated on start of process. The key
+// is kept only in memory locked from swapping, so cached keys can't be decrypted from memory dumps with master key only
+type EphemeralKeyCache struct {
+	cache Cache
+	key   []byte
+	scell *cell.SecureCell
+}
+
+// NewEphemeralKeyCache returns cache which encrypts values with new random key before adding them to cache
+func NewEphemeralKeyCache(cache Cache) (*EphemeralKeyCache, error) {
+	key := make([]byte, EphemeralCacheKeyLength)
+	if err := lockMemory(key); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &EphemeralKeyCache{cache: cache, key: key, scell: cell.New(key, cell.CELL_MODE_SEAL)}, nil
+}
+
+// Add encrypts value with ephemeral key and keyID as context and adds it to cache. Previous value is removed from
+// cache if encryption failed
+func (cache *EphemeralKeyCache) Add(keyID string, keyValue []byte) {
+	encrypted, _, err := cache.scell.Protect(keyValue, []byte(keyID))
+	if err != nil {
+		log.WithError(err).WithField("key", keyID).Warningln("Can't encrypt key with ephemeral key, key isn't cached")
+		cache.cache.Remove(keyID)
+		return
+	}
+	cache.cache.Add(keyID, encrypted)
+}
+
+// Get returns value decrypted with ephemeral key
+func (cache *EphemeralKeyCache) Get(keyID string) ([]byte, bool) {
+	encrypted, ok := cache.cache.Get(keyID)
+	if !ok {
+		return nil, false
+	}
+	value, err := cache.scell.Unprotect(encrypted, nil, []byte(keyID))
+	if err != nil {
+		log.WithError(err).WithField("key", keyID).Warningln("Can't decrypt cached key with ephemeral key")
+		return nil, false
+	}
+	return value, true
+}
+
+// Remove removes value from cache
+func (cache *EphemeralKeyCache) Remove(keyID string) {
+	cache.cache.Remove(keyID)
+}
+
+// Clear removes all values from cache
+func (cache *EphemeralKeyCache) Clear() {
+	cache.cache.Clear()
+}
+
+// Keys returns ids of all cached values
+func (cache *EphemeralKeyCache) Keys() []string {
+	return cache.cache.Keys()
+}
+
+// Close clears cache and wipes ephemeral key, cache can't be used after that
+func (cache *EphemeralKeyCache) Close() error {
+	cache.cache.Clear()
+	utils.FillSlice(byte(0), cache.key)
+	return unlockMemory(cache.key)
+}
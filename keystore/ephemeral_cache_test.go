@@ -0,0 +1,95 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"bytes"
+	"testing"
+)
+
+// testMapCache is Cache which stores values in map
+type testMapCache map[string][]byte
+
+func (cache testMapCache) Add(keyID string, keyValue []byte) {
+	cache[keyID] = keyValue
+}
+
+func (cache testMapCache) Get(keyID string) ([]byte, bool) {
+	value, ok := cache[keyID]
+	return value, ok
+}
+
+func (cache testMapCache) Remove(keyID string) {
+	delete(cache, keyID)
+}
+
+func (cache testMapCache) Clear() {
+	for keyID := range cache {
+		delete(cache, keyID)
+	}
+}
+
+func (cache testMapCache) Keys() []string {
+	keys := make([]string, 0, len(cache))
+	for keyID := range cache {
+		keys = append(keys, keyID)
+	}
+	return keys
+}
+
+func TestEphemeralKeyCache(t *testing.T) {
+	storage := testMapCache{}
+	cache, err := NewEphemeralKeyCache(storage)
+	if err == ErrMemoryLockNotSupported {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := []byte("encrypted with master key")
+	cache.Add("key", value)
+	if bytes.Contains(storage["key"], value) {
+		t.Fatal("Cached value should be encrypted with ephemeral key")
+	}
+	cached, ok := cache.Get("key")
+	if !ok || !bytes.Equal(cached, value) {
+		t.Fatal("Incorrect cached value")
+	}
+	// value encrypted for other key id can't be used
+	storage["other"] = storage["key"]
+	if _, ok := cache.Get("other"); ok {
+		t.Fatal("Value of other key id shouldn't be decrypted")
+	}
+
+	otherCache, err := NewEphemeralKeyCache(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := otherCache.Get("key"); ok {
+		t.Fatal("Value shouldn't be decrypted with other ephemeral key")
+	}
+	cache.Remove("key")
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("Removed value returned")
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(storage) != 0 {
+		t.Fatal("Cache should be cleared on close")
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package escrow exports private keys for regulatory escrow with split knowledge. Key is divided by Shamir's secret
+// sharing into shares, one per custodian, and each share is wrapped with Themis Secure Message to custodian's public
+// key. Nobody handles plaintext key: reconstruction requires threshold of custodians to unwrap their shares.
+package escrow
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/utils"
+	"github.com/cossacklabs/themis/gothemis/keys"
+	"github.com/cossacklabs/themis/gothemis/message"
+)
+
+// PackageVersion is version of escrow package format
+const PackageVersion = 1
+
+// Errors returned by escrow packages
+var (
+	ErrNoCustodians          = acraerrors.New(acraerrors.CodeNoCustodians, "no custodians for escrow")
+	ErrDuplicatedCustodian   = acraerrors.New(acraerrors.CodeDuplicatedCustodian, "custodian's name is duplicated")
+	ErrUnknownCustodian      = acraerrors.New(acraerrors.CodeUnknownCustodian, "escrow package has no share for custodian")
+	ErrNotEnoughShares       = acraerrors.New(acraerrors.CodeNotEnoughShares, "count of shares is less than threshold")
+	ErrKeyFingerprintInvalid = acraerrors.New(acraerrors.CodeKeyFingerprintInvalid, "recovered key doesn't match fingerprint of escrowed key")
+	ErrUnsupportedVersion    = acraerrors.New(acraerrors.CodeUnsupportedEscrowVersion, "unsupported version of escrow package")
+	ErrEnvironmentMismatch   = acraerrors.New(acraerrors.CodeEscrowEnvironmentMismatch, "key was exported from another environment")
+)
+
+// Custodian is holder of one share of escrowed key
+type Custodian struct {
+	Name      string
+	PublicKey *keys.PublicKey
+}
+
+// Share is share of key wrapped to custodian's public key
+type Share struct {
+	Custodian string `json:"custodian"`
+	// Fingerprint of custodian's public key used to wrap share
+	Fingerprint string `json:"fingerprint"`
+	Data        []byte `json:"data"`
+}
+
+// Package is escrowed key which may be stored outside of keystore
+type Package struct {
+	Version   int    `json:"version"`
+	Purpose   string `json:"purpose"`
+	ID        string `json:"id"`
+	Threshold int    `json:"threshold"`
+	// Environment is label of keystore environment which key was exported from
+	Environment string `json:"environment,omitempty"`
+	// KeyFingerprint is hex encoded SHA-256 hash of key used to verify recovered key
+	KeyFingerprint string `json:"key_fingerprint"`
+	// EphemeralPublicKey is public part of key pair used only to wrap shares of this package
+	EphemeralPublicKey []byte    `json:"ephemeral_public_key"`
+	Shares             []Share   `json:"shares"`
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+func keyFingerprint(key []byte) string {
+	hash := sha256.Sum256(key)
+	return hex.EncodeToString(hash[:])
+}
+
+// Export splits key with purpose and id into shares for each custodian, threshold of which are needed to recover key
+func Export(purpose string, id, key []byte, custodians []Custodian, threshold int) (*Package, error) {
+	if len(custodians) == 0 {
+		return nil, ErrNoCustodians
+	}
+	names := make(map[string]bool, len(custodians))
+	for _, custodian := range custodians {
+		if names[custodian.Name] {
+			return nil, ErrDuplicatedCustodian
+		}
+		names[custodian.Name] = true
+	}
+	shares, err := Split(key, len(custodians), threshold)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, share := range shares {
+			utils.FillSlice(byte(0), share)
+		}
+	}()
+	ephemeralKeypair, err := keys.New(keys.KEYTYPE_EC)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.FillSlice(byte(0), ephemeralKeypair.Private.Value)
+	pkg := &Package{
+		Version:            PackageVersion,
+		Purpose:            purpose,
+		ID:                 string(id),
+		Threshold:          threshold,
+		KeyFingerprint:     keyFingerprint(key),
+		EphemeralPublicKey: ephemeralKeypair.Public.Value,
+		Shares:             make([]Share, 0, len(custodians)),
+		CreatedAt:          time.Now().UTC(),
+	}
+	for i, custodian := range custodians {
+		wrapped, err := message.New(ephemeralKeypair.Private, custodian.PublicKey).Wrap(shares[i])
+		if err != nil {
+			return nil, err
+		}
+		pkg.Shares = append(pkg.Shares, Share{
+			Custodian:   custodian.Name,
+			Fingerprint: keystore.PublicKeyFingerprint(custodian.PublicKey.Value),
+			Data:        wrapped,
+		})
+	}
+	return pkg, nil
+}
+
+// Load parses escrow package from JSON
+func Load(data []byte) (*Package, error) {
+	pkg := &Package{}
+	if err := json.Unmarshal(data, pkg); err != nil {
+		return nil, err
+	}
+	if pkg.Version != PackageVersion {
+		return nil, ErrUnsupportedVersion
+	}
+	return pkg, nil
+}
+
+// Marshal returns JSON representation of escrow package
+func (pkg *Package) Marshal() ([]byte, error) {
+	return json.MarshalIndent(pkg, "", "  ")
+}
+
+// Custodians returns names of custodians holding shares of package
+func (pkg *Package) Custodians() []string {
+	names := make([]string, 0, len(pkg.Shares))
+	for _, share := range pkg.Shares {
+		names = append(names, share.Custodian)
+	}
+	return names
+}
+
+// UnwrapShare returns share of custodian unwrapped with custodian's private key
+func (pkg *Package) UnwrapShare(custodian string, privateKey *keys.PrivateKey) ([]byte, error) {
+	for _, share := range pkg.Shares {
+		if share.Custodian == custodian {
+			return message.New(privateKey, &keys.PublicKey{Value: pkg.EphemeralPublicKey}).Unwrap(share.Data)
+		}
+	}
+	return nil, ErrUnknownCustodian
+}
+
+// Recover combines unwrapped shares into key and verifies it with fingerprint of escrowed key
+func (pkg *Package) Recover(shares [][]byte) ([]byte, error) {
+	if len(shares) < pkg.Threshold {
+		return nil, ErrNotEnoughShares
+	}
+	key, err := Combine(shares)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(keyFingerprint(key)), []byte(pkg.KeyFingerprint)) != 1 {
+		utils.FillSlice(byte(0), key)
+		return nil, ErrKeyFingerprintInvalid
+	}
+	return key, nil
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package escrow
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/themis/gothemis/keys"
+)
+
+func TestSplitCombine(t *testing.T) {
+	secret := []byte("some secret key of 32 bytes size")
+	shares, err := Split(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testcases := [][]int{{0, 1, 2}, {4, 2, 0}, {1, 2, 3, 4}, {0, 1, 2, 3, 4}}
+	for _, indexes := range testcases {
+		var subset [][]byte
+		for _, index := range indexes {
+			subset = append(subset, shares[index])
+		}
+		combined, err := Combine(subset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(combined, secret) {
+			t.Errorf("Shares %v combined into incorrect secret", indexes)
+		}
+	}
+	combined, err := Combine(shares[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(combined, secret) {
+		t.Error("Secret combined from fewer shares than threshold")
+	}
+	if _, err := Combine([][]byte{shares[0], shares[0]}); err != ErrInvalidShares {
+		t.Errorf("Expected ErrInvalidShares, took %v", err)
+	}
+	for _, params := range [][2]int{{3, 1}, {3, 4}, {256, 2}} {
+		if _, err := Split(secret, params[0], params[1]); err != ErrInvalidThreshold {
+			t.Errorf("Expected ErrInvalidThreshold for %v, took %v", params, err)
+		}
+	}
+}
+
+func TestExportRecover(t *testing.T) {
+	var custodians []Custodian
+	privateKeys := map[string]*keys.PrivateKey{}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		keypair, err := keys.New(keys.KEYTYPE_EC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		custodians = append(custodians, Custodian{Name: name, PublicKey: keypair.Public})
+		privateKeys[name] = keypair.Private
+	}
+	key := []byte("private key which will be escrowed")
+	pkg, err := Export(keystore.KeyPurposeStorage, []byte("client"), key, custodians, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := pkg.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, key) {
+		t.Fatal("Escrow package contains plaintext key")
+	}
+	pkg, err = Load(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bobShare, err := pkg.UnwrapShare("bob", privateKeys["bob"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pkg.Recover([][]byte{bobShare}); err != ErrNotEnoughShares {
+		t.Fatalf("Expected ErrNotEnoughShares, took %v", err)
+	}
+	if _, err := pkg.UnwrapShare("carol", privateKeys["alice"]); err == nil {
+		t.Fatal("Share was unwrapped with key of another custodian")
+	}
+	if _, err := pkg.UnwrapShare("dave", privateKeys["alice"]); err != ErrUnknownCustodian {
+		t.Fatalf("Expected ErrUnknownCustodian, took %v", err)
+	}
+	carolShare, err := pkg.UnwrapShare("carol", privateKeys["carol"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovered, err := pkg.Recover([][]byte{carolShare, bobShare})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(recovered, key) {
+		t.Fatal("Recovered key differs from escrowed key")
+	}
+	// corrupted share doesn't match fingerprint
+	bobShare[1] ^= 0xff
+	if _, err := pkg.Recover([][]byte{carolShare, bobShare}); err != ErrKeyFingerprintInvalid {
+		t.Fatalf("Expected ErrKeyFingerprintInvalid, took %v", err)
+	}
+
+	if _, err := Export(keystore.KeyPurposeStorage, []byte("client"), key, append(custodians, custodians[0]), 2); err != ErrDuplicatedCustodian {
+		t.Fatalf("Expected ErrDuplicatedCustodian, took %v", err)
+	}
+}
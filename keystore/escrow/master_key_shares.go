@@ -0,0 +1,186 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package escrow
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+	"sync"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/utils"
+)
+
+// Errors returned by Unsealer
+var (
+	ErrInvalidMasterKeyShare  = acraerrors.New(acraerrors.CodeInvalidMasterKeyShare, "invalid share of master key")
+	ErrMasterKeyShareMismatch = acraerrors.New(acraerrors.CodeMasterKeyShareMismatch, "share belongs to another master key or was already added")
+	ErrAlreadyUnsealed        = acraerrors.New(acraerrors.CodeAlreadyUnsealed, "master key is already unsealed")
+)
+
+// MasterKeyShareVersion is version of encoded share of master key
+const MasterKeyShareVersion = 1
+
+// masterKeyFingerprintLength is length of truncated SHA-256 hash of master key stored in each share, it's enough to
+// detect mixed shares of different keys and reveals nothing useful about key
+const masterKeyFingerprintLength = 8
+
+// masterKeyShareHeaderLength is length of version, threshold and fingerprint which precede Shamir's share
+const masterKeyShareHeaderLength = 2 + masterKeyFingerprintLength
+
+func masterKeyFingerprint(key []byte) []byte {
+	hash := sha256.Sum256(key)
+	return hash[:masterKeyFingerprintLength]
+}
+
+// SplitMasterKey divides master key into count base64 encoded shares, any threshold of which are accepted by
+// Unsealer to reconstruct master key. Each share keeps threshold and fingerprint of key to verify result
+func SplitMasterKey(key []byte, count, threshold int) ([]string, error) {
+	if err := keystore.ValidateMasterKey(key); err != nil {
+		return nil, err
+	}
+	shares, err := Split(key, count, threshold)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := masterKeyFingerprint(key)
+	encoded := make([]string, 0, count)
+	for _, share := range shares {
+		data := make([]byte, 0, masterKeyShareHeaderLength+len(share))
+		data = append(data, MasterKeyShareVersion, byte(threshold))
+		data = append(data, fingerprint...)
+		data = append(data, share...)
+		encoded = append(encoded, base64.StdEncoding.EncodeToString(data))
+		utils.FillSlice(byte(0), data)
+		utils.FillSlice(byte(0), share)
+	}
+	return encoded, nil
+}
+
+// UnsealStatus is progress of master key reconstruction
+type UnsealStatus struct {
+	Sealed bool
+	// Threshold is count of shares required to unseal, 0 until first share is added
+	Threshold int
+	// Progress is count of shares added since last reset
+	Progress int
+}
+
+// Unsealer collects shares of master key supplied by operators one by one and reconstructs master key when threshold
+// of them is reached. Shares of another key, duplicated shares and shares which don't reconstruct key with expected
+// fingerprint reset progress, so operators have to start over
+type Unsealer struct {
+	lock        sync.Mutex
+	threshold   int
+	fingerprint []byte
+	shares      [][]byte
+	key         []byte
+	unsealed    chan struct{}
+}
+
+// NewUnsealer returns sealed Unsealer without shares
+func NewUnsealer() *Unsealer {
+	return &Unsealer{unsealed: make(chan struct{})}
+}
+
+// reset forgets added shares
+func (unsealer *Unsealer) reset() {
+	for _, share := range unsealer.shares {
+		utils.FillSlice(byte(0), share)
+	}
+	unsealer.shares = nil
+	unsealer.threshold = 0
+	unsealer.fingerprint = nil
+}
+
+// AddShare adds base64 encoded share returned by SplitMasterKey and reconstructs master key if threshold of shares
+// is reached
+func (unsealer *Unsealer) AddShare(encoded string) error {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(data) < masterKeyShareHeaderLength+2 || data[0] != MasterKeyShareVersion || data[1] < 2 {
+		return ErrInvalidMasterKeyShare
+	}
+	defer utils.FillSlice(byte(0), data)
+	threshold, fingerprint := int(data[1]), data[2:masterKeyShareHeaderLength]
+	share := append([]byte{}, data[masterKeyShareHeaderLength:]...)
+
+	unsealer.lock.Lock()
+	defer unsealer.lock.Unlock()
+	if unsealer.key != nil {
+		utils.FillSlice(byte(0), share)
+		return ErrAlreadyUnsealed
+	}
+	if len(unsealer.shares) == 0 {
+		unsealer.threshold = threshold
+		unsealer.fingerprint = append([]byte{}, fingerprint...)
+	} else {
+		mismatch := threshold != unsealer.threshold || subtle.ConstantTimeCompare(fingerprint, unsealer.fingerprint) != 1 ||
+			len(share) != len(unsealer.shares[0])
+		for _, added := range unsealer.shares {
+			mismatch = mismatch || added[0] == share[0]
+		}
+		if mismatch {
+			utils.FillSlice(byte(0), share)
+			unsealer.reset()
+			return ErrMasterKeyShareMismatch
+		}
+	}
+	unsealer.shares = append(unsealer.shares, share)
+	if len(unsealer.shares) < unsealer.threshold {
+		return nil
+	}
+	key, err := Combine(unsealer.shares)
+	if err == nil && subtle.ConstantTimeCompare(masterKeyFingerprint(key), unsealer.fingerprint) != 1 {
+		utils.FillSlice(byte(0), key)
+		err = ErrKeyFingerprintInvalid
+	}
+	unsealer.reset()
+	if err != nil {
+		return err
+	}
+	unsealer.key = key
+	close(unsealer.unsealed)
+	return nil
+}
+
+// Status returns progress of unsealing
+func (unsealer *Unsealer) Status() UnsealStatus {
+	unsealer.lock.Lock()
+	defer unsealer.lock.Unlock()
+	return UnsealStatus{Sealed: unsealer.key == nil, Threshold: unsealer.threshold, Progress: len(unsealer.shares)}
+}
+
+// Unsealed returns channel closed when master key is reconstructed
+func (unsealer *Unsealer) Unsealed() <-chan struct{} {
+	return unsealer.unsealed
+}
+
+// LoadMasterKey waits until master key is reconstructed and returns it separated by key environment like
+// keystore.EnvironmentMasterKeyLoader does
+func (unsealer *Unsealer) LoadMasterKey() ([]byte, error) {
+	<-unsealer.unsealed
+	unsealer.lock.Lock()
+	key := append([]byte{}, unsealer.key...)
+	unsealer.lock.Unlock()
+	if err := keystore.ValidateMasterKey(key); err != nil {
+		return nil, err
+	}
+	return keystore.DeriveEnvironmentMasterKey(key, keystore.GetKeyEnvironment())
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package escrow
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+func TestUnsealer(t *testing.T) {
+	os.Unsetenv(keystore.AcraKeyEnvironmentVarName)
+	masterKey, err := keystore.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, err := SplitMasterKey(masterKey, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := keystore.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherShares, err := SplitMasterKey(otherKey, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unsealer := NewUnsealer()
+	if status := unsealer.Status(); !status.Sealed || status.Progress != 0 {
+		t.Fatalf("Unexpected initial status %+v", status)
+	}
+	if err := unsealer.AddShare("not a share"); err != ErrInvalidMasterKeyShare {
+		t.Errorf("Expected ErrInvalidMasterKeyShare, took %v", err)
+	}
+	if err := unsealer.AddShare(shares[4]); err != nil {
+		t.Fatal(err)
+	}
+	// duplicated share resets progress
+	if err := unsealer.AddShare(shares[4]); err != ErrMasterKeyShareMismatch {
+		t.Errorf("Expected ErrMasterKeyShareMismatch for duplicated share, took %v", err)
+	}
+	if status := unsealer.Status(); status.Progress != 0 || status.Threshold != 0 {
+		t.Fatalf("Progress isn't reset after duplicated share: %+v", status)
+	}
+	// share of another key added first doesn't block shares of right key after reset
+	if err := unsealer.AddShare(otherShares[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := unsealer.AddShare(shares[4]); err != ErrMasterKeyShareMismatch {
+		t.Errorf("Expected ErrMasterKeyShareMismatch for share of another key, took %v", err)
+	}
+	if status := unsealer.Status(); status.Progress != 0 {
+		t.Fatalf("Progress isn't reset after share of another key: %+v", status)
+	}
+	for _, share := range []string{shares[4], shares[1]} {
+		if err := unsealer.AddShare(share); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if status := unsealer.Status(); !status.Sealed || status.Threshold != 3 || status.Progress != 2 {
+		t.Fatalf("Unexpected status %+v", status)
+	}
+	select {
+	case <-unsealer.Unsealed():
+		t.Fatal("Unsealed before threshold of shares")
+	default:
+	}
+	if err := unsealer.AddShare(shares[2]); err != nil {
+		t.Fatal(err)
+	}
+	if status := unsealer.Status(); status.Sealed {
+		t.Fatalf("Unexpected status %+v", status)
+	}
+	loaded, err := unsealer.LoadMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded, masterKey) {
+		t.Error("Reconstructed master key doesn't match original")
+	}
+	if err := unsealer.AddShare(shares[0]); err != ErrAlreadyUnsealed {
+		t.Errorf("Expected ErrAlreadyUnsealed, took %v", err)
+	}
+
+	if _, err := SplitMasterKey([]byte("short"), 3, 2); err != keystore.ErrMasterKeyIncorrectLength {
+		t.Errorf("Expected ErrMasterKeyIncorrectLength, took %v", err)
+	}
+}
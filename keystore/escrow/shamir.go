@@ -0,0 +1,136 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package escrow
+
+import (
+	"crypto/rand"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/utils"
+)
+
+// Errors returned by Split and Combine
+var (
+	ErrInvalidThreshold = acraerrors.New(acraerrors.CodeInvalidThreshold, "threshold must be between 2 and count of shares, count of shares must be less than 256")
+	ErrEmptySecret      = acraerrors.New(acraerrors.CodeEmptySecret, "secret is empty")
+	ErrInvalidShares    = acraerrors.New(acraerrors.CodeInvalidShares, "shares have different length or duplicated indexes")
+)
+
+// maxShares is limited by size of GF(2^8) where each share takes its own non zero x coordinate
+const maxShares = 255
+
+// expTable and logTable are exponents and logarithms of generator 3 in GF(2^8) with AES polynomial x^8+x^4+x^3+x+1
+var (
+	expTable [510]byte
+	logTable [256]byte
+)
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		expTable[i] = x
+		expTable[i+255] = x
+		logTable[x] = byte(i)
+		// multiply by generator 3 = x + 1
+		high := x & 0x80
+		doubled := x << 1
+		if high != 0 {
+			doubled ^= 0x1b
+		}
+		x ^= doubled
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+int(logTable[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+255-int(logTable[b])]
+}
+
+// Split divides secret into count shares using Shamir's secret sharing so that any threshold of them reconstruct
+// secret and fewer reveal nothing. First byte of each share is its x coordinate, other bytes are values of random
+// polynomials for each byte of secret
+func Split(secret []byte, count, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, ErrEmptySecret
+	}
+	if threshold < 2 || threshold > count || count > maxShares {
+		return nil, ErrInvalidThreshold
+	}
+	shares := make([][]byte, count)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][0] = byte(i + 1)
+	}
+	coefficients := make([]byte, threshold)
+	defer utils.FillSlice(byte(0), coefficients)
+	for position, secretByte := range secret {
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+		coefficients[0] = secretByte
+		for _, share := range shares {
+			// Horner's method
+			x := share[0]
+			var y byte
+			for i := threshold - 1; i >= 0; i-- {
+				y = gfMul(y, x) ^ coefficients[i]
+			}
+			share[position+1] = y
+		}
+	}
+	return shares, nil
+}
+
+// Combine reconstructs secret from shares returned by Split. Result is meaningless if shares are fewer than threshold
+// or belong to different secrets
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, ErrInvalidShares
+	}
+	length := len(shares[0])
+	seen := make(map[byte]bool, len(shares))
+	for _, share := range shares {
+		if len(share) != length || length < 2 || share[0] == 0 || seen[share[0]] {
+			return nil, ErrInvalidShares
+		}
+		seen[share[0]] = true
+	}
+	secret := make([]byte, length-1)
+	for i, share := range shares {
+		// Lagrange basis polynomial of share evaluated at x = 0
+		basis := byte(1)
+		for j, other := range shares {
+			if i == j {
+				continue
+			}
+			basis = gfMul(basis, gfDiv(other[0], other[0]^share[0]))
+		}
+		for position := range secret {
+			secret[position] ^= gfMul(basis, share[position+1])
+		}
+	}
+	return secret, nil
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CachedKeys returns sorted names of keys in cache
+func (store *FilesystemKeyStore) CachedKeys() []string {
+	store.lock.RLock()
+	names := store.cache.Keys()
+	store.lock.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// isKeyFilename returns true if name is relative path inside of keys directory
+func isKeyFilename(name string) bool {
+	return name != "" && filepath.Clean(name) == name && !filepath.IsAbs(name) && !strings.HasPrefix(name, "..")
+}
+
+// WarmUpCache reads keys with names from storage to cache as they are stored, encrypted with master key. Keys which
+// are cached already or can't be read are skipped. Returns count of loaded keys
+func (store *FilesystemKeyStore) WarmUpCache(names []string) int {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	loaded := 0
+	for _, name := range names {
+		if !isKeyFilename(name) {
+			log.WithField("key", name).Warningln("Skip warm up of key with invalid name")
+			continue
+		}
+		if _, ok := store.cache.Get(name); ok {
+			continue
+		}
+		var data []byte
+		if strings.HasSuffix(name, ".pub") {
+			publicKey, err := store.storage.ReadFile(store.getPublicKeyFilePath(name))
+			if err != nil {
+				log.WithError(err).WithField("key", name).Debugln("Can't warm up public key")
+				continue
+			}
+			data = publicKey
+		} else {
+			privateKey, err := store.loadPrivateKey(store.getPrivateKeyFilePath(name))
+			if err != nil {
+				log.WithError(err).WithField("key", name).Debugln("Can't warm up private key")
+				continue
+			}
+			data = privateKey.Value
+		}
+		store.cache.Add(name, data)
+		loaded++
+	}
+	return loaded
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+func TestWarmUpCache(t *testing.T) {
+	keyDirectory, err := ioutil.TempDir("", "cache_warmup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keyDirectory)
+	encryptor, err := keystore.NewSCellKeyEncryptor([]byte("some key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, err := NewFileSystemKeyStoreWithCacheSize(keyDirectory, encryptor, keystore.INFINITE_CACHE_SIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientID := []byte("client")
+	if err := active.GenerateDataEncryptionKeys(clientID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := active.GetPeerPublicKey(clientID); err == nil {
+		t.Fatal("Expected error for missing transport public key")
+	}
+	cachedKeys := active.CachedKeys()
+	if !reflect.DeepEqual(cachedKeys, []string{getServerDecryptionKeyFilename(clientID)}) {
+		t.Fatalf("Incorrect cached keys %v", cachedKeys)
+	}
+
+	standby, err := NewFileSystemKeyStoreWithCacheSize(keyDirectory, encryptor, keystore.INFINITE_CACHE_SIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := standby.WarmUpCache(append(cachedKeys, "../outside", "/etc/passwd", "missing"))
+	if loaded != 1 || !reflect.DeepEqual(standby.CachedKeys(), cachedKeys) {
+		t.Fatalf("Incorrect warmed up keys %v", standby.CachedKeys())
+	}
+	// cached key is used without reading storage
+	if err := os.Remove(standby.getPrivateKeyFilePath(cachedKeys[0])); err != nil {
+		t.Fatal(err)
+	}
+	activeKey, err := active.GetServerDecryptionPrivateKey(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	standbyKey, err := standby.GetServerDecryptionPrivateKey(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(activeKey.Value, standbyKey.Value) {
+		t.Fatal("Standby loaded other key")
+	}
+	if standby.WarmUpCache(cachedKeys) != 0 {
+		t.Fatal("Cached keys shouldn't be loaded again")
+	}
+}
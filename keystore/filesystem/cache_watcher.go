@@ -0,0 +1,191 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cossacklabs/acra/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// keyFileState is what watcher compares to detect changed key file
+type keyFileState struct {
+	modifiedAt time.Time
+	size       int64
+}
+
+// CacheWatcher periodically compares files of keystore storage with previous state and removes from cache keys which
+// were rotated, replaced or destroyed by other services or tools, so they take effect without restart. Polling is
+// used instead of file system notifications because storage may be remote (e.g. redis)
+type CacheWatcher struct {
+	store *FilesystemKeyStore
+	lock  sync.Mutex
+	// files maps cache key (filename relative to keys directory) to last seen state of file
+	files map[string]keyFileState
+	stop  chan struct{}
+}
+
+// NewCacheWatcher returns watcher of keys of store with current state of files as initial
+func NewCacheWatcher(store *FilesystemKeyStore) (*CacheWatcher, error) {
+	files, err := store.keyFilesState()
+	if err != nil {
+		return nil, err
+	}
+	return &CacheWatcher{store: store, files: files}, nil
+}
+
+// keyFilesState returns state of all key files from private and public keys directories including subdirectories
+// like poison key directory. Historical versions are skipped because they are never cached
+func (store *FilesystemKeyStore) keyFilesState() (map[string]keyFileState, error) {
+	files := make(map[string]keyFileState)
+	directories := []string{store.privateKeyDirectory}
+	if store.publicKeyDirectory != store.privateKeyDirectory {
+		directories = append(directories, store.publicKeyDirectory)
+	}
+	for _, directory := range directories {
+		if err := store.readKeyFilesState(directory, "", files); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func (store *FilesystemKeyStore) readKeyFilesState(directory, prefix string, files map[string]keyFileState) error {
+	infos, err := store.storage.ReadDir(filepath.Join(directory, prefix))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, info := range infos {
+		name := filepath.Join(prefix, info.Name())
+		if info.IsDir() {
+			if prefix != "" || strings.HasSuffix(info.Name(), getHistoricalKeysFilename("")) {
+				continue
+			}
+			if err := store.readKeyFilesState(directory, name, files); err != nil {
+				return err
+			}
+			continue
+		}
+		if isKeyMetadataFilename(name) {
+			// metadata isn't cached, key file changes with it on rotation
+			continue
+		}
+		files[name] = keyFileState{modifiedAt: info.ModTime(), size: info.Size()}
+	}
+	return nil
+}
+
+// Check compares files with state from previous check, removes changed, created and deleted keys from cache and
+// returns their names
+func (watcher *CacheWatcher) Check() ([]string, error) {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+	files, err := watcher.store.keyFilesState()
+	if err != nil {
+		return nil, err
+	}
+	var changed []string
+	for name, state := range files {
+		if previous, ok := watcher.files[name]; !ok || previous != state {
+			changed = append(changed, name)
+		}
+	}
+	for name := range watcher.files {
+		if _, ok := files[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	watcher.files = files
+	if len(changed) == 0 {
+		return nil, nil
+	}
+	sort.Strings(changed)
+	watcher.store.lock.Lock()
+	for _, name := range changed {
+		watcher.store.cache.Remove(name)
+	}
+	watcher.store.lock.Unlock()
+	log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeKeyCacheInvalidated, "keys": changed}).
+		Infoln("Removed changed keys from cache")
+	return changed, nil
+}
+
+// Start checks keys every interval in background until Stop called
+func (watcher *CacheWatcher) Start(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+	if watcher.stop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	watcher.stop = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := watcher.Check(); err != nil {
+					log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantReadKeys).
+						Warningln("Can't check keys for changes")
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops periodic checks
+func (watcher *CacheWatcher) Stop() {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+	if watcher.stop != nil {
+		close(watcher.stop)
+		watcher.stop = nil
+	}
+}
+
+// removeCachedFile removes from cache key stored in file of private or public keys directory. Storages notify about
+// changes with paths of files, which are compared without leading separator because it's dropped by remote storages
+func (store *FilesystemKeyStore) removeCachedFile(filePath string) {
+	filePath = path.Clean("/" + filepath.ToSlash(filePath))
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for _, directory := range []string{store.privateKeyDirectory, store.publicKeyDirectory} {
+		name, err := filepath.Rel(path.Clean("/"+filepath.ToSlash(directory)), filePath)
+		if err != nil || name == "." || strings.HasPrefix(name, "..") {
+			continue
+		}
+		store.cache.Remove(name)
+		log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeKeyCacheInvalidated, "keys": []string{name}}).
+			Debugln("Removed changed key from cache")
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+func TestCacheWatcher(t *testing.T) {
+	keyDirectory, err := ioutil.TempDir("", "cache_watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keyDirectory)
+	encryptor, err := keystore.NewSCellKeyEncryptor([]byte("some key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileSystemKeyStoreWithCacheSize(keyDirectory, encryptor, keystore.INFINITE_CACHE_SIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// other tool which changes keys in same directory
+	otherStore, err := NewFileSystemKeyStoreWithCacheSize(keyDirectory, encryptor, keystore.NO_CACHE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientID := []byte("client")
+	if err := store.GenerateHMACSecretKey(clientID); err != nil {
+		t.Fatal(err)
+	}
+	oldKey, err := store.GetHMACSecretKey(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher, err := NewCacheWatcher(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := watcher.Check(); err != nil || len(changed) != 0 {
+		t.Fatalf("Expected no changes, took %v, %v", changed, err)
+	}
+
+	if err := otherStore.GenerateHMACSecretKey(clientID); err != nil {
+		t.Fatal(err)
+	}
+	filename := getHMACKeyFilename(clientID)
+	// file system may have coarse modification time
+	if err := os.Chtimes(store.getPrivateKeyFilePath(filename), time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	cachedKey, err := store.GetHMACSecretKey(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cachedKey, oldKey) {
+		t.Fatal("Expected old key from cache before check")
+	}
+	changed, err := watcher.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changed, []string{filename}) {
+		t.Fatalf("Expected changed %s, took %v", filename, changed)
+	}
+	newKey, err := store.GetHMACSecretKey(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(newKey, oldKey) {
+		t.Fatal("Expected new key after check")
+	}
+
+	if err := os.Remove(store.getPrivateKeyFilePath(filename)); err != nil {
+		t.Fatal(err)
+	}
+	changed, err = watcher.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changed, []string{filename}) {
+		t.Fatalf("Expected removed %s, took %v", filename, changed)
+	}
+	if _, err := store.GetHMACSecretKey(clientID); !os.IsNotExist(err) {
+		t.Fatalf("Expected not exist error, took %v", err)
+	}
+}
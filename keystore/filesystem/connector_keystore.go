@@ -62,6 +62,15 @@ func (store *ConnectorFileSystemKeyStore) GetPrivateKey(id []byte) (*keys.Privat
 	return &keys.PrivateKey{Value: privateKey}, nil
 }
 
+// GetComparatorSecret reads and decrypts secret shared with AcraServer to authenticate with Secure Comparator
+func (store *ConnectorFileSystemKeyStore) GetComparatorSecret(id []byte) ([]byte, error) {
+	secret, err := ioutil.ReadFile(filepath.Join(store.directory, getComparatorSecretFilename(id)))
+	if err != nil {
+		return nil, err
+	}
+	return store.encryptor.Decrypt(secret, id)
+}
+
 // GetPeerPublicKey returns other party transport public key depending on AcraConnector mode:
 // returns AcraServer transport public key for AcraServerMode, and
 // returns  AcraTranslator transport public key for AcraTranslatorMode.
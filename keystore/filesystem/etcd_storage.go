@@ -0,0 +1,651 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// EtcdBackendType is type of keystore backend which stores keys in etcd
+const EtcdBackendType = "etcd"
+
+// Options of etcd keystore backend
+const (
+	EtcdEndpointsOption = "endpoints"
+	EtcdUsernameOption  = "username"
+	EtcdPasswordOption  = "password"
+	EtcdPrefixOption    = "prefix"
+	EtcdTimeoutOption   = "timeout"
+)
+
+// Default settings of etcd storage
+const (
+	DefaultEtcdPrefix  = "acra/keystore"
+	DefaultEtcdTimeout = time.Second * 5
+	// etcdLockTTL is time in seconds after which lock of key generation is released if its holder died
+	etcdLockTTL = 10
+	// etcdLockWaitTimeout is max time of waiting for lock of key generation held by other service
+	etcdLockWaitTimeout = time.Second * 30
+	// etcdLockRetryInterval is interval between attempts to take lock of key generation
+	etcdLockRetryInterval = time.Millisecond * 100
+	// etcdWatchRetryInterval is interval between attempts to restore broken watch
+	etcdWatchRetryInterval = time.Second
+)
+
+// Errors returned by etcd keystore backend
+var (
+	ErrEtcdEndpointsRequired = acraerrors.New(acraerrors.CodeEtcdEndpointsRequired, "etcd keystore requires endpoints option")
+	ErrEtcdInvalidResponse   = acraerrors.New(acraerrors.CodeEtcdInvalidResponse, "invalid response from etcd")
+	ErrEtcdLockTimeout       = acraerrors.New(acraerrors.CodeEtcdLockTimeout, "timeout on waiting for lock of key generation in etcd")
+)
+
+func init() {
+	keystore.RegisterBackend(EtcdBackendType, NewEtcdBackend)
+}
+
+// NewEtcdBackend creates FilesystemKeyStore which keeps keys in etcd, so several services share same keys. Keys
+// changed by other services are removed from cache as soon as etcd notifies about them, and keys are generated
+// under lock in etcd, so concurrent services don't overwrite each other's keys
+func NewEtcdBackend(params keystore.BackendParams) (keystore.Backend, error) {
+	storage, err := NewEtcdStorage(params.Options)
+	if err != nil {
+		return nil, err
+	}
+	store, err := newBackendKeyStore(params, storage)
+	if err != nil {
+		return nil, err
+	}
+	if params.CacheSize != keystore.NO_CACHE {
+		storage.Watch(store.removeCachedFile, store.Reset)
+	}
+	return store, nil
+}
+
+// EtcdStorage is Storage which keeps files in etcd v3 using its JSON gRPC gateway. Each file is stored in one key
+// with prefix, directories exist only as parts of keys
+type EtcdStorage struct {
+	endpoints []string
+	username  string
+	password  string
+	prefix    string
+	timeout   time.Duration
+	client    *http.Client
+	// watchClient has no timeout because watch response is streamed while watch is active
+	watchClient *http.Client
+
+	lock     sync.Mutex
+	endpoint int
+	token    string
+	stop     chan struct{}
+}
+
+// NewEtcdStorage creates EtcdStorage configured with keystore options, endpoints are semicolon separated URLs of etcd
+// members like http://10.0.0.1:2379;http://10.0.0.2:2379 because options themselves are separated by comma
+func NewEtcdStorage(options map[string]string) (*EtcdStorage, error) {
+	storage := &EtcdStorage{prefix: DefaultEtcdPrefix, timeout: DefaultEtcdTimeout}
+	for name, value := range options {
+		switch name {
+		case EtcdEndpointsOption:
+			for _, endpoint := range strings.Split(value, ";") {
+				if endpoint = strings.TrimRight(strings.TrimSpace(endpoint), "/"); endpoint != "" {
+					storage.endpoints = append(storage.endpoints, endpoint)
+				}
+			}
+		case EtcdUsernameOption:
+			storage.username = value
+		case EtcdPasswordOption:
+			storage.password = value
+		case EtcdPrefixOption:
+			storage.prefix = value
+		case EtcdTimeoutOption:
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("invalid etcd timeout %q", value)
+			}
+			storage.timeout = timeout
+		default:
+			return nil, fmt.Errorf("unknown etcd keystore option %q", name)
+		}
+	}
+	if len(storage.endpoints) == 0 {
+		return nil, ErrEtcdEndpointsRequired
+	}
+	storage.client = &http.Client{Timeout: storage.timeout}
+	storage.watchClient = &http.Client{}
+	return storage, nil
+}
+
+// etcdInt64 is int64 which etcd gateway encodes in JSON as string
+type etcdInt64 int64
+
+func (value *etcdInt64) UnmarshalJSON(data []byte) error {
+	parsed, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return err
+	}
+	*value = etcdInt64(parsed)
+	return nil
+}
+
+func (value etcdInt64) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + strconv.FormatInt(int64(value), 10) + `"`), nil
+}
+
+// etcdKeyValue is key with value stored in etcd
+type etcdKeyValue struct {
+	Key            []byte    `json:"key"`
+	Value          []byte    `json:"value,omitempty"`
+	CreateRevision etcdInt64 `json:"create_revision,omitempty"`
+	ModRevision    etcdInt64 `json:"mod_revision,omitempty"`
+}
+
+type etcdRangeRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end,omitempty"`
+}
+
+type etcdRangeResponse struct {
+	Kvs []etcdKeyValue `json:"kvs"`
+}
+
+type etcdPutRequest struct {
+	Key   []byte    `json:"key"`
+	Value []byte    `json:"value"`
+	Lease etcdInt64 `json:"lease,omitempty"`
+}
+
+type etcdCompare struct {
+	Key            []byte    `json:"key"`
+	Target         string    `json:"target"`
+	Result         string    `json:"result"`
+	CreateRevision etcdInt64 `json:"create_revision"`
+}
+
+type etcdRequestOp struct {
+	RequestPut *etcdPutRequest `json:"request_put,omitempty"`
+}
+
+type etcdTxnRequest struct {
+	Compare []etcdCompare   `json:"compare"`
+	Success []etcdRequestOp `json:"success"`
+}
+
+type etcdTxnResponse struct {
+	Succeeded bool `json:"succeeded"`
+}
+
+type etcdLeaseResponse struct {
+	ID etcdInt64 `json:"ID"`
+}
+
+type etcdWatchCreateRequest struct {
+	Key           []byte    `json:"key"`
+	RangeEnd      []byte    `json:"range_end"`
+	StartRevision etcdInt64 `json:"start_revision,omitempty"`
+}
+
+type etcdWatchRequest struct {
+	CreateRequest etcdWatchCreateRequest `json:"create_request"`
+}
+
+type etcdWatchResponse struct {
+	Result *struct {
+		CompactRevision etcdInt64 `json:"compact_revision"`
+		Canceled        bool      `json:"canceled"`
+		Events          []struct {
+			Kv etcdKeyValue `json:"kv"`
+		} `json:"events"`
+	} `json:"result"`
+	Error *etcdError `json:"error"`
+}
+
+// etcdError is error response of etcd gateway
+type etcdError struct {
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+func (err *etcdError) Error() string {
+	return "etcd: " + err.Message
+}
+
+// etcdFile is value of key which stores file
+type etcdFile struct {
+	Data     []byte `json:"data"`
+	Mode     uint32 `json:"mode"`
+	Modified int64  `json:"modified"`
+}
+
+// prefixRangeEnd returns end of range of all keys with prefix
+func prefixRangeEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// all keys
+	return []byte{0}
+}
+
+// key returns name of etcd key which stores file
+func (storage *EtcdStorage) key(filePath string) []byte {
+	return []byte(storage.prefix + path.Clean("/"+filePath))
+}
+
+// filePath returns path of file stored in key
+func (storage *EtcdStorage) filePath(key []byte) string {
+	return strings.TrimPrefix(string(key), storage.prefix)
+}
+
+// post sends request to etcd gateway and decodes response, next endpoint is used if current one is unavailable
+func (storage *EtcdStorage) post(method string, request, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	var lastErr error
+	for attempt := 0; attempt < len(storage.endpoints); attempt++ {
+		if storage.username != "" && storage.token == "" {
+			if lastErr = storage.authenticate(); lastErr != nil {
+				if _, ok := lastErr.(*etcdError); ok {
+					return lastErr
+				}
+				storage.endpoint = (storage.endpoint + 1) % len(storage.endpoints)
+				continue
+			}
+		}
+		httpResponse, err := storage.send(storage.client, method, body)
+		if err != nil {
+			lastErr = err
+			storage.endpoint = (storage.endpoint + 1) % len(storage.endpoints)
+			continue
+		}
+		err = decodeEtcdResponse(httpResponse, response)
+		if etcdErr, ok := err.(*etcdError); ok && etcdErr.Code == etcdUnauthenticatedCode && storage.username != "" {
+			// token expired, authenticate again
+			storage.token = ""
+			lastErr = err
+			continue
+		}
+		return err
+	}
+	return lastErr
+}
+
+// etcdUnauthenticatedCode is gRPC code of requests with invalid token
+const etcdUnauthenticatedCode = 16
+
+// send sends body to method of current endpoint
+func (storage *EtcdStorage) send(client *http.Client, method string, body []byte) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodPost, storage.endpoints[storage.endpoint]+method, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if storage.token != "" {
+		request.Header.Set("Authorization", storage.token)
+	}
+	return client.Do(request)
+}
+
+// authenticate requests token of user from current endpoint
+func (storage *EtcdStorage) authenticate() error {
+	body, err := json.Marshal(map[string]string{"name": storage.username, "password": storage.password})
+	if err != nil {
+		return err
+	}
+	httpResponse, err := storage.send(storage.client, "/v3/auth/authenticate", body)
+	if err != nil {
+		return err
+	}
+	var response struct {
+		Token string `json:"token"`
+	}
+	if err := decodeEtcdResponse(httpResponse, &response); err != nil {
+		return err
+	}
+	if response.Token == "" {
+		return ErrEtcdInvalidResponse
+	}
+	storage.token = response.Token
+	return nil
+}
+
+// decodeEtcdResponse decodes response to value or returns error of etcd
+func decodeEtcdResponse(httpResponse *http.Response, value interface{}) error {
+	defer httpResponse.Body.Close()
+	if httpResponse.StatusCode != http.StatusOK {
+		etcdErr := &etcdError{}
+		if err := json.NewDecoder(httpResponse.Body).Decode(etcdErr); err != nil || etcdErr.Message == "" {
+			return fmt.Errorf("etcd: unexpected status %d", httpResponse.StatusCode)
+		}
+		return etcdErr
+	}
+	if err := json.NewDecoder(httpResponse.Body).Decode(value); err != nil {
+		return ErrEtcdInvalidResponse
+	}
+	return nil
+}
+
+// parseEtcdFile returns info and content of file stored in key
+func parseEtcdFile(filePath string, kv etcdKeyValue) (etcdFileInfo, []byte, error) {
+	file := etcdFile{}
+	if err := json.Unmarshal(kv.Value, &file); err != nil {
+		return etcdFileInfo{}, nil, ErrEtcdInvalidResponse
+	}
+	info := etcdFileInfo{name: path.Base(filePath), size: int64(len(file.Data)), mode: os.FileMode(file.Mode).Perm(), modTime: time.Unix(0, file.Modified)}
+	return info, file.Data, nil
+}
+
+// readFile returns info and content of file
+func (storage *EtcdStorage) readFile(filePath string) (etcdFileInfo, []byte, error) {
+	response := etcdRangeResponse{}
+	if err := storage.post("/v3/kv/range", etcdRangeRequest{Key: storage.key(filePath)}, &response); err != nil {
+		return etcdFileInfo{}, nil, err
+	}
+	if len(response.Kvs) == 0 {
+		return etcdFileInfo{}, nil, &os.PathError{Op: "open", Path: filePath, Err: os.ErrNotExist}
+	}
+	return parseEtcdFile(filePath, response.Kvs[0])
+}
+
+// Stat returns info of file
+func (storage *EtcdStorage) Stat(filePath string) (os.FileInfo, error) {
+	info, _, err := storage.readFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// ReadFile returns content of file
+func (storage *EtcdStorage) ReadFile(filePath string) ([]byte, error) {
+	_, data, err := storage.readFile(filePath)
+	return data, err
+}
+
+// ReadDir returns files and subdirectories of directory ordered by name, directories exist only while they contain
+// files, so missing directory is empty
+func (storage *EtcdStorage) ReadDir(dirPath string) ([]os.FileInfo, error) {
+	dirKey := storage.key(dirPath)
+	if !bytes.HasSuffix(dirKey, []byte("/")) {
+		dirKey = append(dirKey, '/')
+	}
+	response := etcdRangeResponse{}
+	if err := storage.post("/v3/kv/range", etcdRangeRequest{Key: dirKey, RangeEnd: prefixRangeEnd(dirKey)}, &response); err != nil {
+		return nil, err
+	}
+	sort.Slice(response.Kvs, func(i, j int) bool { return bytes.Compare(response.Kvs[i].Key, response.Kvs[j].Key) < 0 })
+	var files []os.FileInfo
+	var lastDir string
+	for _, kv := range response.Kvs {
+		name := strings.TrimPrefix(string(kv.Key), string(dirKey))
+		if index := strings.Index(name, "/"); index >= 0 {
+			if dir := name[:index]; dir != lastDir {
+				lastDir = dir
+				files = append(files, etcdFileInfo{name: dir, mode: os.ModeDir | 0700})
+			}
+			continue
+		}
+		info, _, err := parseEtcdFile(name, kv)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, info)
+	}
+	return files, nil
+}
+
+// RemoveAll removes file or directory with all its files, missing path isn't error
+func (storage *EtcdStorage) RemoveAll(filePath string) error {
+	key := storage.key(filePath)
+	var response interface{}
+	if err := storage.post("/v3/kv/deleterange", etcdRangeRequest{Key: key}, &response); err != nil {
+		return err
+	}
+	dirKey := append(bytes.TrimSuffix(key, []byte("/")), '/')
+	return storage.post("/v3/kv/deleterange", etcdRangeRequest{Key: dirKey, RangeEnd: prefixRangeEnd(dirKey)}, &response)
+}
+
+// MkdirAll does nothing because directories are parts of etcd keys
+func (storage *EtcdStorage) MkdirAll(path string, perm os.FileMode) error {
+	return nil
+}
+
+// WriteFile writes data to file creating it if it doesn't exist
+func (storage *EtcdStorage) WriteFile(filePath string, data []byte, perm os.FileMode) error {
+	return storage.ReplaceFile(filePath, data, perm, time.Now())
+}
+
+// etcdFileValue returns value of key which stores file
+func etcdFileValue(data []byte, perm os.FileMode, modifiedAt time.Time) ([]byte, error) {
+	return json.Marshal(etcdFile{Data: data, Mode: uint32(perm.Perm()), Modified: modifiedAt.UnixNano()})
+}
+
+// createKey puts value to key in transaction which fails if key exists, returns false if key exists
+func (storage *EtcdStorage) createKey(key, value []byte, lease etcdInt64) (bool, error) {
+	request := etcdTxnRequest{
+		Compare: []etcdCompare{{Key: key, Target: "CREATE", Result: "EQUAL", CreateRevision: 0}},
+		Success: []etcdRequestOp{{RequestPut: &etcdPutRequest{Key: key, Value: value, Lease: lease}}},
+	}
+	response := etcdTxnResponse{}
+	if err := storage.post("/v3/kv/txn", request, &response); err != nil {
+		return false, err
+	}
+	return response.Succeeded, nil
+}
+
+// CreateFile writes data to new file, returns error if file exists. File is created in transaction which checks
+// that key doesn't exist, so only one of concurrent services creates file
+func (storage *EtcdStorage) CreateFile(filePath string, data []byte, perm os.FileMode) error {
+	value, err := etcdFileValue(data, perm, time.Now())
+	if err != nil {
+		return err
+	}
+	created, err := storage.createKey(storage.key(filePath), value, 0)
+	if err != nil {
+		return err
+	}
+	if !created {
+		return &os.PathError{Op: "create", Path: filePath, Err: os.ErrExist}
+	}
+	return nil
+}
+
+// ReplaceFile atomically replaces file with data which has modification time modifiedAt
+func (storage *EtcdStorage) ReplaceFile(filePath string, data []byte, perm os.FileMode, modifiedAt time.Time) error {
+	value, err := etcdFileValue(data, perm, modifiedAt)
+	if err != nil {
+		return err
+	}
+	var response interface{}
+	return storage.post("/v3/kv/put", etcdPutRequest{Key: storage.key(filePath), Value: value}, &response)
+}
+
+// LockGeneration takes lock in etcd which serializes generation of keys between services. Lock is bound to lease,
+// so it's released by etcd if service died before unlock
+func (storage *EtcdStorage) LockGeneration() (func(), error) {
+	lease := etcdLeaseResponse{}
+	if err := storage.post("/v3/lease/grant", map[string]etcdInt64{"TTL": etcdLockTTL}, &lease); err != nil {
+		return nil, err
+	}
+	unlock := func() {
+		var response interface{}
+		if err := storage.post("/v3/lease/revoke", map[string]etcdInt64{"ID": lease.ID}, &response); err != nil {
+			log.WithError(err).Warningln("Can't release lock of key generation in etcd, it will expire with lease")
+		}
+	}
+	// lock is kept outside of prefix of files, so it is not watched and listed as file
+	lockKey := []byte(storage.prefix + ".lock/generation")
+	deadline := time.Now().Add(etcdLockWaitTimeout)
+	for {
+		locked, err := storage.createKey(lockKey, []byte(strconv.FormatInt(int64(lease.ID), 10)), lease.ID)
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+		if locked {
+			return unlock, nil
+		}
+		if time.Now().After(deadline) {
+			unlock()
+			return nil, ErrEtcdLockTimeout
+		}
+		time.Sleep(etcdLockRetryInterval)
+	}
+}
+
+// Watch calls onChange with path of each file changed in etcd until Close. Watch is restored if it's broken and
+// onReset is called then because changes could be missed
+func (storage *EtcdStorage) Watch(onChange func(filePath string), onReset func()) {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	if storage.stop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	storage.stop = stop
+	go func() {
+		var revision etcdInt64
+		for {
+			var err error
+			revision, err = storage.watch(revision, onChange, stop)
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorCantReadKeys).
+				Warningln("Watch of keys in etcd broken, cached keys will be reloaded")
+			onReset()
+			select {
+			case <-stop:
+				return
+			case <-time.After(etcdWatchRetryInterval):
+			}
+		}
+	}()
+}
+
+// watch streams changes of keys since revision and returns last seen revision when stream is broken
+func (storage *EtcdStorage) watch(revision etcdInt64, onChange func(filePath string), stop chan struct{}) (etcdInt64, error) {
+	prefix := []byte(storage.prefix + "/")
+	request := etcdWatchRequest{CreateRequest: etcdWatchCreateRequest{Key: prefix, RangeEnd: prefixRangeEnd(prefix)}}
+	if revision > 0 {
+		request.CreateRequest.StartRevision = revision + 1
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return revision, err
+	}
+	storage.lock.Lock()
+	httpResponse, err := storage.send(storage.watchClient, "/v3/watch", body)
+	if err != nil {
+		storage.endpoint = (storage.endpoint + 1) % len(storage.endpoints)
+	}
+	storage.lock.Unlock()
+	if err != nil {
+		return revision, err
+	}
+	defer httpResponse.Body.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// interrupt reading of stream on Close
+		select {
+		case <-stop:
+			httpResponse.Body.Close()
+		case <-done:
+		}
+	}()
+	if httpResponse.StatusCode != http.StatusOK {
+		return revision, fmt.Errorf("etcd: unexpected status %d of watch", httpResponse.StatusCode)
+	}
+	decoder := json.NewDecoder(httpResponse.Body)
+	for {
+		response := etcdWatchResponse{}
+		if err := decoder.Decode(&response); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return revision, err
+		}
+		if response.Error != nil {
+			return revision, response.Error
+		}
+		if response.Result == nil {
+			return revision, ErrEtcdInvalidResponse
+		}
+		if response.Result.Canceled || response.Result.CompactRevision > 0 {
+			// changes since revision were compacted, restart watch from current state
+			return 0, errors.New("etcd: watch canceled")
+		}
+		for _, event := range response.Result.Events {
+			onChange(storage.filePath(event.Kv.Key))
+			if event.Kv.ModRevision > revision {
+				revision = event.Kv.ModRevision
+			}
+		}
+	}
+}
+
+// Close stops watch of changes
+func (storage *EtcdStorage) Close() error {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	if storage.stop != nil {
+		close(storage.stop)
+		storage.stop = nil
+	}
+	return nil
+}
+
+// etcdFileInfo is os.FileInfo of file stored in etcd
+type etcdFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (info etcdFileInfo) Name() string       { return info.name }
+func (info etcdFileInfo) Size() int64        { return info.size }
+func (info etcdFileInfo) Mode() os.FileMode  { return info.mode }
+func (info etcdFileInfo) ModTime() time.Time { return info.modTime }
+func (info etcdFileInfo) IsDir() bool        { return info.mode.IsDir() }
+func (info etcdFileInfo) Sys() interface{}   { return nil }
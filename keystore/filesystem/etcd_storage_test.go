@@ -0,0 +1,407 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+// fakeEtcd is in-memory etcd JSON gateway which supports requests used by EtcdStorage
+type fakeEtcd struct {
+	server   *httptest.Server
+	password string
+	lock     sync.Mutex
+	revision int64
+	kvs      map[string]etcdKeyValue
+	leases   map[string]int64
+	nextID   int64
+	watchers []chan etcdKeyValue
+}
+
+func newFakeEtcd(password string) *fakeEtcd {
+	etcd := &fakeEtcd{password: password, kvs: make(map[string]etcdKeyValue), leases: make(map[string]int64)}
+	etcd.server = httptest.NewServer(http.HandlerFunc(etcd.serve))
+	return etcd
+}
+
+func (etcd *fakeEtcd) Close() {
+	etcd.server.CloseClientConnections()
+	etcd.server.Close()
+}
+
+func (etcd *fakeEtcd) URL() string {
+	return etcd.server.URL
+}
+
+func (etcd *fakeEtcd) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v3/auth/authenticate" {
+		credentials := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&credentials)
+		if credentials["password"] != etcd.password {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(etcdError{Message: "authentication failed", Code: 3})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "token"})
+		return
+	}
+	if etcd.password != "" && r.Header.Get("Authorization") != "token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(etcdError{Message: "invalid auth token", Code: etcdUnauthenticatedCode})
+		return
+	}
+	if r.URL.Path == "/v3/watch" {
+		etcd.watch(w, r)
+		return
+	}
+	etcd.lock.Lock()
+	defer etcd.lock.Unlock()
+	var response interface{}
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		request := etcdRangeRequest{}
+		json.NewDecoder(r.Body).Decode(&request)
+		response = etcdRangeResponse{Kvs: etcd.rangeKeys(request)}
+	case "/v3/kv/put":
+		request := etcdPutRequest{}
+		json.NewDecoder(r.Body).Decode(&request)
+		etcd.put(request)
+		response = struct{}{}
+	case "/v3/kv/deleterange":
+		request := etcdRangeRequest{}
+		json.NewDecoder(r.Body).Decode(&request)
+		for _, kv := range etcd.rangeKeys(request) {
+			etcd.remove(string(kv.Key))
+		}
+		response = struct{}{}
+	case "/v3/kv/txn":
+		request := etcdTxnRequest{}
+		json.NewDecoder(r.Body).Decode(&request)
+		_, exists := etcd.kvs[string(request.Compare[0].Key)]
+		if !exists {
+			etcd.put(*request.Success[0].RequestPut)
+		}
+		response = etcdTxnResponse{Succeeded: !exists}
+	case "/v3/lease/grant":
+		etcd.nextID++
+		response = etcdLeaseResponse{ID: etcdInt64(etcd.nextID)}
+	case "/v3/lease/revoke":
+		request := map[string]etcdInt64{}
+		json.NewDecoder(r.Body).Decode(&request)
+		for key, lease := range etcd.leases {
+			if lease == int64(request["ID"]) {
+				etcd.remove(key)
+			}
+		}
+		response = struct{}{}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func (etcd *fakeEtcd) rangeKeys(request etcdRangeRequest) []etcdKeyValue {
+	var kvs []etcdKeyValue
+	for key, kv := range etcd.kvs {
+		if (len(request.RangeEnd) == 0 && key == string(request.Key)) ||
+			(len(request.RangeEnd) != 0 && key >= string(request.Key) && key < string(request.RangeEnd)) {
+			kvs = append(kvs, kv)
+		}
+	}
+	return kvs
+}
+
+func (etcd *fakeEtcd) put(request etcdPutRequest) {
+	etcd.revision++
+	kv := etcdKeyValue{Key: request.Key, Value: request.Value, ModRevision: etcdInt64(etcd.revision)}
+	kv.CreateRevision = kv.ModRevision
+	if previous, ok := etcd.kvs[string(request.Key)]; ok {
+		kv.CreateRevision = previous.CreateRevision
+	}
+	etcd.kvs[string(request.Key)] = kv
+	if request.Lease != 0 {
+		etcd.leases[string(request.Key)] = int64(request.Lease)
+	}
+	etcd.notify(kv)
+}
+
+func (etcd *fakeEtcd) remove(key string) {
+	etcd.revision++
+	delete(etcd.kvs, key)
+	delete(etcd.leases, key)
+	etcd.notify(etcdKeyValue{Key: []byte(key), ModRevision: etcdInt64(etcd.revision)})
+}
+
+func (etcd *fakeEtcd) notify(kv etcdKeyValue) {
+	for _, watcher := range etcd.watchers {
+		watcher <- kv
+	}
+}
+
+func (etcd *fakeEtcd) watch(w http.ResponseWriter, r *http.Request) {
+	request := etcdWatchRequest{}
+	json.NewDecoder(r.Body).Decode(&request)
+	events := make(chan etcdKeyValue, 100)
+	etcd.lock.Lock()
+	etcd.watchers = append(etcd.watchers, events)
+	etcd.lock.Unlock()
+	defer func() {
+		etcd.lock.Lock()
+		for i, watcher := range etcd.watchers {
+			if watcher == events {
+				etcd.watchers = append(etcd.watchers[:i], etcd.watchers[i+1:]...)
+				break
+			}
+		}
+		etcd.lock.Unlock()
+	}()
+	fmt.Fprintln(w, `{"result":{"created":true}}`)
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case kv := <-events:
+			key := string(kv.Key)
+			if key < string(request.CreateRequest.Key) || key >= string(request.CreateRequest.RangeEnd) {
+				continue
+			}
+			response := map[string]interface{}{"result": map[string]interface{}{
+				"events": []map[string]interface{}{{"kv": kv}}}}
+			if err := json.NewEncoder(w).Encode(response); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func TestNewEtcdStorage(t *testing.T) {
+	if _, err := NewEtcdStorage(map[string]string{}); err != ErrEtcdEndpointsRequired {
+		t.Fatalf("Expected ErrEtcdEndpointsRequired, took %v", err)
+	}
+	for _, options := range []map[string]string{
+		{"endpoints": "http://127.0.0.1:2379", "timeout": "invalid"},
+		{"endpoints": "http://127.0.0.1:2379", "unknown": "value"},
+	} {
+		if _, err := NewEtcdStorage(options); err == nil {
+			t.Fatalf("Expected error with options %v", options)
+		}
+	}
+	storage, err := NewEtcdStorage(map[string]string{"endpoints": "http://10.0.0.1:2379/; http://10.0.0.2:2379", "prefix": "acra", "timeout": "1s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(storage.endpoints, ",") != "http://10.0.0.1:2379,http://10.0.0.2:2379" || storage.prefix != "acra" || storage.timeout != time.Second {
+		t.Fatalf("Incorrect storage settings %+v", storage)
+	}
+}
+
+func TestEtcdStorage(t *testing.T) {
+	etcd := newFakeEtcd("secret")
+	defer etcd.Close()
+	// first endpoint is unavailable, so storage switches to second one
+	storage, err := NewEtcdStorage(map[string]string{"endpoints": "http://127.0.0.1:1;" + etcd.URL(), "username": "root", "password": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	if _, err := storage.Stat("keys/file"); !os.IsNotExist(err) {
+		t.Fatalf("Expected not exist error, took %v", err)
+	}
+	if _, err := storage.ReadFile("keys/file"); !os.IsNotExist(err) {
+		t.Fatalf("Expected not exist error, took %v", err)
+	}
+	if err := storage.CreateFile("keys/file", []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.CreateFile("keys/file", []byte("other data"), 0600); !os.IsExist(err) {
+		t.Fatalf("Expected exist error, took %v", err)
+	}
+	data, err := storage.ReadFile("keys/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte("data")) {
+		t.Fatalf("Incorrect data %s", data)
+	}
+
+	modifiedAt := time.Unix(1500000000, 0)
+	if err := storage.ReplaceFile("keys/file", []byte("new data"), 0400, modifiedAt); err != nil {
+		t.Fatal(err)
+	}
+	info, err := storage.Stat("keys/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name() != "file" || info.Size() != int64(len("new data")) || info.Mode() != 0400 || !info.ModTime().Equal(modifiedAt) || !info.Mode().IsRegular() {
+		t.Fatalf("Incorrect file info %v %v %v %v", info.Name(), info.Size(), info.Mode(), info.ModTime())
+	}
+
+	if err := storage.WriteFile("keys/sub/file", []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.WriteFile("keys/another", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.WriteFile("keys_other/file", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files, err := storage.ReadDir("keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, fmt.Sprintf("%s:%v", file.Name(), file.IsDir()))
+	}
+	if strings.Join(names, ",") != "another:false,file:false,sub:true" {
+		t.Fatalf("Incorrect directory content %v", names)
+	}
+	if files, err := storage.ReadDir("missing"); err != nil || len(files) != 0 {
+		t.Fatalf("Expected empty directory, took %v, %v", files, err)
+	}
+
+	if err := storage.RemoveAll("keys"); err != nil {
+		t.Fatal(err)
+	}
+	if files, err := storage.ReadDir("keys"); err != nil || len(files) != 0 {
+		t.Fatalf("Expected empty directory, took %v, %v", files, err)
+	}
+	if _, err := storage.Stat("keys_other/file"); err != nil {
+		t.Fatalf("File outside of removed directory was removed, %v", err)
+	}
+
+	// expired token is refreshed
+	storage.token = "expired"
+	if _, err := storage.ReadFile("keys_other/file"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEtcdStorageLockGeneration(t *testing.T) {
+	etcd := newFakeEtcd("")
+	defer etcd.Close()
+	var storages []*EtcdStorage
+	for i := 0; i < 2; i++ {
+		storage, err := NewEtcdStorage(map[string]string{"endpoints": etcd.URL()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer storage.Close()
+		storages = append(storages, storage)
+	}
+	unlock, err := storages[0].LockGeneration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked := make(chan error, 1)
+	go func() {
+		otherUnlock, err := storages[1].LockGeneration()
+		if err == nil {
+			otherUnlock()
+		}
+		locked <- err
+	}()
+	select {
+	case err := <-locked:
+		t.Fatalf("Lock taken by two services, %v", err)
+	case <-time.After(etcdLockRetryInterval * 3):
+	}
+	unlock()
+	select {
+	case err := <-locked:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Released lock wasn't taken")
+	}
+}
+
+func TestEtcdBackend(t *testing.T) {
+	etcd := newFakeEtcd("")
+	defer etcd.Close()
+	encryptor, err := keystore.NewSCellKeyEncryptor([]byte("some key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := keystore.BackendParams{PrivateKeysDir: ".acrakeys", Encryptor: encryptor, CacheSize: keystore.INFINITE_CACHE_SIZE,
+		Options: map[string]string{"endpoints": etcd.URL()}}
+	// two services share keys stored in etcd
+	var stores []*FilesystemKeyStore
+	for i := 0; i < 2; i++ {
+		backend, err := keystore.NewBackend(EtcdBackendType, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store, ok := backend.(*FilesystemKeyStore)
+		if !ok {
+			t.Fatalf("Expected FilesystemKeyStore, took %T", backend)
+		}
+		defer store.storage.(*EtcdStorage).Close()
+		stores = append(stores, store)
+	}
+	testGeneral(stores[0], t)
+
+	clientID := []byte("client")
+	if err := stores[0].GenerateDataEncryptionKeys(clientID); err != nil {
+		t.Fatal(err)
+	}
+	private, err := stores[1].GetServerDecryptionPrivateKey(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// key generated by other service replaces cached one as soon as etcd notifies about it
+	if err := stores[0].GenerateDataEncryptionKeys(clientID); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := stores[0].GetServerDecryptionPrivateKey(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(private.Value, expected.Value) {
+		t.Fatal("Key wasn't regenerated")
+	}
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		private, err = stores[1].GetServerDecryptionPrivateKey(clientID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(private.Value, expected.Value) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Cached key wasn't replaced with key generated by other service")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
@@ -63,3 +63,31 @@ func getServerDecryptionKeyFilename(id []byte) string {
 func getConnectorKeyFilename(id []byte) string {
 	return string(id)
 }
+
+// getHMACKeyFilename
+func getHMACKeyFilename(id []byte) string {
+	return fmt.Sprintf("%s_hmac", string(id))
+}
+
+// getHistoricalKeysFilename returns name of folder with previous versions of private key
+func getHistoricalKeysFilename(filename string) string {
+	return fmt.Sprintf("%s.old", filename)
+}
+
+// keyMetadataSuffix is suffix of file with creation time and lifetime of private key
+const keyMetadataSuffix = ".meta"
+
+// getKeyMetadataFilename returns name of file with metadata of private key
+func getKeyMetadataFilename(filename string) string {
+	return filename + keyMetadataSuffix
+}
+
+// getSymmetricKeyFilename
+func getSymmetricKeyFilename(id []byte) string {
+	return fmt.Sprintf("%s_sym", string(id))
+}
+
+// getComparatorSecretFilename returns name of file with secret shared with AcraConnector for Secure Comparator
+func getComparatorSecretFilename(id []byte) string {
+	return fmt.Sprintf("%s_comparator", string(id))
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// getPrivateKeyFilenameByPurpose returns filename of private key and context used to encrypt it with master key
+func getPrivateKeyFilenameByPurpose(purpose string, id []byte) (string, []byte, error) {
+	if purpose == keystore.KeyPurposePoison {
+		return POISON_KEY_FILENAME, []byte(POISON_KEY_FILENAME), nil
+	}
+	if !keystore.ValidateID(id) {
+		return "", nil, keystore.ErrInvalidClientID
+	}
+	switch purpose {
+	case keystore.KeyPurposeZone:
+		return getZoneKeyFilename(id), id, nil
+	case keystore.KeyPurposeStorage:
+		return getServerDecryptionKeyFilename(id), id, nil
+	case keystore.KeyPurposeServerTransport:
+		return getServerKeyFilename(id), id, nil
+	case keystore.KeyPurposeTranslatorTransport:
+		return getTranslatorKeyFilename(id), id, nil
+	case keystore.KeyPurposeConnectorTransport:
+		return getConnectorKeyFilename(id), id, nil
+	case keystore.KeyPurposeHMAC:
+		return getHMACKeyFilename(id), id, nil
+	case keystore.KeyPurposeSymmetric:
+		return getSymmetricKeyFilename(id), id, nil
+	case keystore.KeyPurposeComparatorSecret:
+		return getComparatorSecretFilename(id), id, nil
+	}
+	return "", nil, keystore.ErrUnsupportedKeyPurpose
+}
+
+// ExportPrivateKey reads private or symmetric key with purpose of client or zone id from fs and returns it decrypted
+// with master key
+func (store *FilesystemKeyStore) ExportPrivateKey(purpose string, id []byte) ([]byte, error) {
+	filename, context, err := getPrivateKeyFilenameByPurpose(purpose, id)
+	if err != nil {
+		return nil, err
+	}
+	encryptedKey, err := store.storage.ReadFile(store.getPrivateKeyFilePath(filename))
+	if err != nil {
+		return nil, err
+	}
+	key, err := store.encryptor.Decrypt(encryptedKey, context)
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeKeyExported, "key": filename}).Infoln("Exported private key")
+	return key, nil
+}
+
+// ImportPrivateKey encrypts private or symmetric key with purpose of client or zone id with master key and writes it
+// to fs overwriting existing key
+func (store *FilesystemKeyStore) ImportPrivateKey(purpose string, id, key []byte) error {
+	filename, context, err := getPrivateKeyFilenameByPurpose(purpose, id)
+	if err != nil {
+		return err
+	}
+	encryptedKey, err := store.encryptor.Encrypt(key, context)
+	if err != nil {
+		return err
+	}
+	if err := store.storage.MkdirAll(filepath.Dir(store.getPrivateKeyFilePath(filename)), 0700); err != nil {
+		return err
+	}
+	if err := store.storage.WriteFile(store.getPrivateKeyFilePath(filename), encryptedKey, 0600); err != nil {
+		return err
+	}
+	if err := store.updateKeyCreation(filename); err != nil {
+		return err
+	}
+	store.lock.Lock()
+	store.cache.Add(filename, encryptedKey)
+	store.lock.Unlock()
+	log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeKeyImported, "key": filename}).Infoln("Imported private key")
+	return nil
+}
+
+// DestroyKey removes private or symmetric key with purpose of client or zone id, its previous versions and public key.
+// Returns error satisfying os.IsNotExist if there is no such key
+func (store *FilesystemKeyStore) DestroyKey(purpose string, id []byte) error {
+	filename, _, err := getPrivateKeyFilenameByPurpose(purpose, id)
+	if err != nil {
+		return err
+	}
+	privatePath := store.getPrivateKeyFilePath(filename)
+	publicPath := store.getPublicKeyFilePath(filename + ".pub")
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	privateExists, err := fileExists(store.storage, privatePath)
+	if err != nil {
+		return err
+	}
+	publicExists, err := fileExists(store.storage, publicPath)
+	if err != nil {
+		return err
+	}
+	if !privateExists && !publicExists {
+		return &os.PathError{Op: "destroy", Path: filename, Err: os.ErrNotExist}
+	}
+	for _, path := range []string{privatePath, store.getHistoricalKeysDirectory(filename), publicPath,
+		store.getPrivateKeyFilePath(getKeyMetadataFilename(filename))} {
+		if err := store.storage.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	store.cache.Remove(filename)
+	store.cache.Remove(filename + ".pub")
+	log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeKeyDestroyed, "key": filename}).Infoln("Destroyed key")
+	return nil
+}
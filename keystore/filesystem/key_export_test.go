@@ -0,0 +1,131 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/cossacklabs/acra/cmd/acra-connector/connector-mode"
+	"github.com/cossacklabs/acra/keystore"
+)
+
+// testDestroyKey checks that destroyed key, its previous versions and public key are removed
+func testDestroyKey(store *FilesystemKeyStore, t *testing.T) {
+	clientID := []byte("destroyed")
+	if err := store.GenerateDataEncryptionKeys(clientID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.RotateStorageKeys(clientID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.GenerateHMACSecretKey(clientID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DestroyKey(keystore.KeyPurposeStorage, clientID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetServerDecryptionPrivateKeys(clientID); !os.IsNotExist(err) {
+		t.Fatalf("Expected not exist error, took %v", err)
+	}
+	if _, err := store.GetPublicKeyByName("destroyed_storage.pub"); !os.IsNotExist(err) {
+		t.Fatalf("Expected removed public key, took %v", err)
+	}
+	// other keys of client are kept
+	if _, err := store.GetHMACSecretKey(clientID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DestroyKey(keystore.KeyPurposeStorage, clientID); !os.IsNotExist(err) {
+		t.Fatalf("Expected not exist error, took %v", err)
+	}
+	if err := store.DestroyKey(keystore.KeyPurposeAuth, clientID); err != keystore.ErrUnsupportedKeyPurpose {
+		t.Fatalf("Expected ErrUnsupportedKeyPurpose, took %v", err)
+	}
+}
+
+func TestFilesystemKeyStore_DestroyKey(t *testing.T) {
+	keyDirectory, err := ioutil.TempDir("", "destroy_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keyDirectory)
+	encryptor, err := keystore.NewSCellKeyEncryptor([]byte("some key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFilesystemKeyStore(keyDirectory, encryptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDestroyKey(store, t)
+	if _, err := os.Stat(store.getHistoricalKeysDirectory(getServerDecryptionKeyFilename([]byte("destroyed")))); !os.IsNotExist(err) {
+		t.Fatalf("Expected removed previous versions, took %v", err)
+	}
+}
+
+// TestComparatorSecretExport checks that secret generated in AcraServer's keystore can be moved to AcraConnector's
+// keystore encrypted with another master key
+func TestComparatorSecretExport(t *testing.T) {
+	clientID := []byte("client")
+	var stores [2]*FilesystemKeyStore
+	var encryptors [2]keystore.KeyEncryptor
+	for i, masterKey := range []string{"server master key", "connector master key"} {
+		keyDirectory, err := ioutil.TempDir("", "comparator_secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(keyDirectory)
+		encryptors[i], err = keystore.NewSCellKeyEncryptor([]byte(masterKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stores[i], err = NewFilesystemKeyStore(keyDirectory, encryptors[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stores[0].GenerateComparatorSecret(clientID); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := stores[0].GetComparatorSecret(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := stores[0].ExportPrivateKey(keystore.KeyPurposeComparatorSecret, clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(secret, exported) {
+		t.Fatal("Exported secret doesn't match generated")
+	}
+	if err := stores[1].ImportPrivateKey(keystore.KeyPurposeComparatorSecret, clientID, exported); err != nil {
+		t.Fatal(err)
+	}
+	connectorStore, err := NewConnectorFileSystemKeyStore(stores[1].privateKeyDirectory, clientID, encryptors[1], connector_mode.AcraServerMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := connectorStore.GetComparatorSecret(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(secret, imported) {
+		t.Fatal("Connector's secret doesn't match server's")
+	}
+}
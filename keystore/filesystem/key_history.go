@@ -0,0 +1,115 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/themis/gothemis/keys"
+	log "github.com/sirupsen/logrus"
+)
+
+// HistoricalKeyTimeFormat is format of filenames of previous key versions, lexical order of names matches order of
+// rotations
+const HistoricalKeyTimeFormat = "2006-01-02T15-04-05.000000000"
+
+// getHistoricalKeysDirectory returns folder with previous versions of private key
+func (store *FilesystemKeyStore) getHistoricalKeysDirectory(filename string) string {
+	return store.getPrivateKeyFilePath(getHistoricalKeysFilename(filename))
+}
+
+// backupHistoricalKey saves current private key as previous version before it's overwritten by new key, so data
+// encrypted with it still may be decrypted. Does nothing if key doesn't exist yet
+func (store *FilesystemKeyStore) backupHistoricalKey(filename string) error {
+	encryptedKey, err := store.storage.ReadFile(store.getPrivateKeyFilePath(filename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	directory := store.getHistoricalKeysDirectory(filename)
+	if err := store.storage.MkdirAll(directory, 0700); err != nil {
+		return err
+	}
+	version := time.Now().UTC().Format(HistoricalKeyTimeFormat)
+	if err := store.storage.WriteFile(filepath.Join(directory, version), encryptedKey, 0600); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeKeyRotated, "key": filename, "version": version}).Infoln("Saved previous version of private key")
+	return nil
+}
+
+// getPrivateKeyVersions returns current private key read from storage bypassing cache and its previous versions
+// from newest to oldest
+func (store *FilesystemKeyStore) getPrivateKeyVersions(id []byte, filename string) ([]*keys.PrivateKey, error) {
+	if !keystore.ValidateID(id) {
+		return nil, keystore.ErrInvalidClientID
+	}
+	var privateKeys []*keys.PrivateKey
+	current, err := store.loadPrivateKey(store.getPrivateKeyFilePath(filename))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if current.Value, _, err = store.decryptStoredKey(store.getPrivateKeyFilePath(filename), current.Value, id); err != nil {
+			return nil, err
+		}
+		privateKeys = append(privateKeys, current)
+	}
+	directory := store.getHistoricalKeysDirectory(filename)
+	files, err := store.storage.ReadDir(directory)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var versions []string
+	for _, file := range files {
+		if file.Mode().IsRegular() {
+			versions = append(versions, file.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(versions)))
+	for _, version := range versions {
+		privateKey, err := store.loadPrivateKey(filepath.Join(directory, version))
+		if err != nil {
+			return nil, err
+		}
+		if privateKey.Value, _, err = store.decryptStoredKey(filepath.Join(directory, version), privateKey.Value, id); err != nil {
+			return nil, err
+		}
+		privateKeys = append(privateKeys, privateKey)
+	}
+	if len(privateKeys) == 0 {
+		return nil, &os.PathError{Op: "open", Path: store.getPrivateKeyFilePath(filename), Err: os.ErrNotExist}
+	}
+	return privateKeys, nil
+}
+
+// GetZonePrivateKeys returns all versions of zone private key from current to oldest
+func (store *FilesystemKeyStore) GetZonePrivateKeys(id []byte) ([]*keys.PrivateKey, error) {
+	return store.getPrivateKeyVersions(id, getZoneKeyFilename(id))
+}
+
+// GetServerDecryptionPrivateKeys returns all versions of server storage private key from current to oldest
+func (store *FilesystemKeyStore) GetServerDecryptionPrivateKeys(id []byte) ([]*keys.PrivateKey, error) {
+	return store.getPrivateKeyVersions(id, getServerDecryptionKeyFilename(id))
+}
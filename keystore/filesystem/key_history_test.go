@@ -0,0 +1,111 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+func TestFilesystemKeyStore_KeyVersions(t *testing.T) {
+	keyDirectory, err := ioutil.TempDir("", "key_versions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keyDirectory)
+	encryptor, err := keystore.NewSCellKeyEncryptor([]byte("some key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFilesystemKeyStore(keyDirectory, encryptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientID := []byte("client")
+	if _, err := store.GetServerDecryptionPrivateKeys(clientID); !os.IsNotExist(err) {
+		t.Fatalf("Expected not exist error, took %v", err)
+	}
+	if err := store.GenerateDataEncryptionKeys(clientID); err != nil {
+		t.Fatal(err)
+	}
+	firstKey, err := store.GetServerDecryptionPrivateKey(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var publicKeys [][]byte
+	for i := 0; i < 2; i++ {
+		publicKey, err := store.RotateStorageKeys(clientID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+	currentKey, err := store.GetServerDecryptionPrivateKey(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKeys, err := store.GetServerDecryptionPrivateKeys(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(privateKeys) != 3 {
+		t.Fatalf("Expected 3 versions of key, took %v", len(privateKeys))
+	}
+	if !bytes.Equal(privateKeys[0].Value, currentKey.Value) {
+		t.Fatal("Current key should be first")
+	}
+	if !bytes.Equal(privateKeys[2].Value, firstKey.Value) {
+		t.Fatal("First version of key should be last")
+	}
+	if bytes.Equal(privateKeys[1].Value, firstKey.Value) || bytes.Equal(privateKeys[1].Value, currentKey.Value) {
+		t.Fatal("Versions of key should differ")
+	}
+	publicKey, err := store.GetPublicKeyByName("client_storage.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(publicKey, publicKeys[1]) {
+		t.Fatal("Public key wasn't rotated")
+	}
+	// historical versions aren't listed as keys
+	keys, err := store.ListKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("Expected only current key pair, took %v", keys)
+	}
+
+	zoneID, _, err := store.GenerateZoneKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.RotateZoneKey(zoneID); err != nil {
+		t.Fatal(err)
+	}
+	zoneKeys, err := store.GetZonePrivateKeys(zoneID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zoneKeys) != 2 {
+		t.Fatalf("Expected 2 versions of zone key, took %v", len(zoneKeys))
+	}
+}
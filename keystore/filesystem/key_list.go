@@ -0,0 +1,152 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+// keyFilenameSuffixes maps suffixes of key filenames to purposes of keys, filenames without suffix are transport
+// keys of AcraConnector
+var keyFilenameSuffixes = []struct {
+	suffix  string
+	purpose string
+}{
+	{"_zone", keystore.KeyPurposeZone},
+	{"_storage", keystore.KeyPurposeStorage},
+	{"_server", keystore.KeyPurposeServerTransport},
+	{"_translator", keystore.KeyPurposeTranslatorTransport},
+	{"_hmac", keystore.KeyPurposeHMAC},
+	{"_sym", keystore.KeyPurposeSymmetric},
+	{"_comparator", keystore.KeyPurposeComparatorSecret},
+}
+
+// parseKeyFilename returns purpose of key, id of client or zone and whether key is public
+func parseKeyFilename(filename string) (purpose, id string, public bool) {
+	if filename == BASIC_AUTH_KEY_FILENAME {
+		return keystore.KeyPurposeAuth, "", false
+	}
+	name := filename
+	if strings.HasSuffix(name, ".pub") {
+		name, public = strings.TrimSuffix(name, ".pub"), true
+	}
+	for _, item := range keyFilenameSuffixes {
+		if strings.HasSuffix(name, item.suffix) {
+			return item.purpose, strings.TrimSuffix(name, item.suffix), public
+		}
+	}
+	return keystore.KeyPurposeConnectorTransport, name, public
+}
+
+// listKeysInDirectory returns keys stored in directory, private keys are ignored if onlyPublic is true
+func (store *FilesystemKeyStore) listKeysInDirectory(directory, prefix string, onlyPublic bool) ([]keystore.KeyInfo, error) {
+	files, err := store.storage.ReadDir(directory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var keys []keystore.KeyInfo
+	for _, file := range files {
+		if !file.Mode().IsRegular() || isKeyMetadataFilename(file.Name()) {
+			continue
+		}
+		purpose, id, public := parseKeyFilename(file.Name())
+		if prefix != "" {
+			purpose, id = keystore.KeyPurposePoison, ""
+		}
+		if onlyPublic && !public {
+			continue
+		}
+		if purpose != keystore.KeyPurposePoison && purpose != keystore.KeyPurposeAuth && !keystore.ValidateID([]byte(id)) {
+			continue
+		}
+		info := keystore.KeyInfo{Name: prefix + file.Name(), Purpose: purpose, ID: id, Public: public, ModifiedAt: file.ModTime()}
+		// public key shares metadata with its private key
+		metadata, err := store.readKeyMetadata(prefix + strings.TrimSuffix(file.Name(), ".pub"))
+		if err != nil {
+			return nil, err
+		}
+		if metadata != nil {
+			info.CreatedAt, info.ExpiresAt = metadata.CreatedAt, metadata.ExpiresAt()
+		}
+		if public {
+			publicKey, err := store.storage.ReadFile(filepath.Join(directory, file.Name()))
+			if err != nil {
+				return nil, err
+			}
+			info.Fingerprint = keystore.PublicKeyFingerprint(publicKey)
+		}
+		keys = append(keys, info)
+	}
+	return keys, nil
+}
+
+// keyDirectory is folder with keys, names of its keys have prefix. Folders of public keys contain only public keys
+type keyDirectory struct {
+	path       string
+	prefix     string
+	onlyPublic bool
+}
+
+// ListKeys returns keys stored in folders of private and public keys ordered by name. Files with names which don't
+// follow naming of keys are skipped
+func (store *FilesystemKeyStore) ListKeys() ([]keystore.KeyInfo, error) {
+	poisonDirectory := filepath.Dir(POISON_KEY_FILENAME)
+	directories := []keyDirectory{
+		{store.privateKeyDirectory, "", false},
+		{filepath.Join(store.privateKeyDirectory, poisonDirectory), poisonDirectory + "/", false},
+	}
+	if store.publicKeyDirectory != store.privateKeyDirectory {
+		directories = append(directories,
+			keyDirectory{store.publicKeyDirectory, "", true},
+			keyDirectory{filepath.Join(store.publicKeyDirectory, poisonDirectory), poisonDirectory + "/", true})
+	}
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	var keys []keystore.KeyInfo
+	for _, directory := range directories {
+		directoryKeys, err := store.listKeysInDirectory(directory.path, directory.prefix, directory.onlyPublic)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, directoryKeys...)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys, nil
+}
+
+// GetPublicKeyByName returns public key with name from ListKeys
+func (store *FilesystemKeyStore) GetPublicKeyByName(name string) ([]byte, error) {
+	if name != POISON_KEY_FILENAME+".pub" {
+		if !strings.HasSuffix(name, ".pub") || name != filepath.Base(name) {
+			return nil, keystore.ErrInvalidKeyName
+		}
+		if _, id, _ := parseKeyFilename(name); !keystore.ValidateID([]byte(id)) {
+			return nil, keystore.ErrInvalidKeyName
+		}
+	}
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return store.storage.ReadFile(store.getPublicKeyFilePath(name))
+}
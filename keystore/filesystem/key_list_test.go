@@ -0,0 +1,109 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+func TestListKeys(t *testing.T) {
+	privateDirectory, err := ioutil.TempDir("", "list_keys_private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(privateDirectory)
+	publicDirectory, err := ioutil.TempDir("", "list_keys_public")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(publicDirectory)
+	if err := os.Mkdir(filepath.Join(privateDirectory, ".poison_key"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		filepath.Join(privateDirectory, "DDDDDDDDzone_zone"):        "private",
+		filepath.Join(publicDirectory, "DDDDDDDDzone_zone.pub"):     "public zone",
+		filepath.Join(privateDirectory, "client_storage"):           "private",
+		filepath.Join(publicDirectory, "client_storage.pub"):        "public storage",
+		filepath.Join(privateDirectory, "client_server"):            "private",
+		filepath.Join(privateDirectory, "client_hmac"):              "symmetric",
+		filepath.Join(publicDirectory, "client.pub"):                "public connector",
+		filepath.Join(privateDirectory, "auth_key"):                 "symmetric",
+		filepath.Join(privateDirectory, ".poison_key/poison_key"):   "private",
+		filepath.Join(privateDirectory, "bad/name"):                 "",
+		filepath.Join(privateDirectory, "x_zone"):                   "too short id",
+		filepath.Join(publicDirectory, "private_in_public_storage"): "ignored",
+	}
+	for path, content := range files {
+		if content == "" {
+			os.MkdirAll(path, 0700)
+			continue
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store, err := NewFilesystemKeyStoreTwoPath(privateDirectory, publicDirectory, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := store.ListKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []keystore.KeyInfo{
+		{Name: ".poison_key/poison_key", Purpose: keystore.KeyPurposePoison},
+		{Name: "DDDDDDDDzone_zone", Purpose: keystore.KeyPurposeZone, ID: "DDDDDDDDzone"},
+		{Name: "DDDDDDDDzone_zone.pub", Purpose: keystore.KeyPurposeZone, ID: "DDDDDDDDzone", Public: true, Fingerprint: keystore.PublicKeyFingerprint([]byte("public zone"))},
+		{Name: "auth_key", Purpose: keystore.KeyPurposeAuth},
+		{Name: "client.pub", Purpose: keystore.KeyPurposeConnectorTransport, ID: "client", Public: true, Fingerprint: keystore.PublicKeyFingerprint([]byte("public connector"))},
+		{Name: "client_hmac", Purpose: keystore.KeyPurposeHMAC, ID: "client"},
+		{Name: "client_server", Purpose: keystore.KeyPurposeServerTransport, ID: "client"},
+		{Name: "client_storage", Purpose: keystore.KeyPurposeStorage, ID: "client"},
+		{Name: "client_storage.pub", Purpose: keystore.KeyPurposeStorage, ID: "client", Public: true, Fingerprint: keystore.PublicKeyFingerprint([]byte("public storage"))},
+	}
+	if len(keys) != len(expected) {
+		t.Fatalf("expected %d keys, took %v", len(expected), keys)
+	}
+	for i, key := range keys {
+		if key.ModifiedAt.IsZero() {
+			t.Errorf("key %s has no modification time", key.Name)
+		}
+		key.ModifiedAt = expected[i].ModifiedAt
+		if key != expected[i] {
+			t.Errorf("expected key %v, took %v", expected[i], key)
+		}
+	}
+
+	publicKey, err := store.GetPublicKeyByName("client_storage.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(publicKey) != "public storage" {
+		t.Fatalf("incorrect public key %s", publicKey)
+	}
+	for _, name := range []string{"client_storage", "../client_storage.pub", "x.pub", "auth_key", ".poison_key/poison_key"} {
+		if _, err := store.GetPublicKeyByName(name); err != keystore.ErrInvalidKeyName {
+			t.Errorf("expected ErrInvalidKeyName for %s, took %v", name, err)
+		}
+	}
+}
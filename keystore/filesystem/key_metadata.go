@@ -0,0 +1,111 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+// keyMetadata is stored in file next to private key and describes current version of key
+type keyMetadata struct {
+	CreatedAt time.Time `json:"created_at"`
+	// TTLSeconds is lifetime of key since creation, 0 if key doesn't expire
+	TTLSeconds int64 `json:"ttl_seconds,omitempty"`
+}
+
+// ExpiresAt returns end of key's lifetime or zero time if key doesn't expire
+func (metadata *keyMetadata) ExpiresAt() time.Time {
+	if metadata.TTLSeconds <= 0 {
+		return time.Time{}
+	}
+	return metadata.CreatedAt.Add(time.Duration(metadata.TTLSeconds) * time.Second)
+}
+
+// isKeyMetadataFilename returns true if file stores metadata of key and isn't key itself
+func isKeyMetadataFilename(filename string) bool {
+	return strings.HasSuffix(filename, keyMetadataSuffix)
+}
+
+// readKeyMetadata returns metadata of private key with filename or nil if key has no metadata, e.g. it was generated
+// by older version
+func (store *FilesystemKeyStore) readKeyMetadata(filename string) (*keyMetadata, error) {
+	data, err := store.storage.ReadFile(store.getPrivateKeyFilePath(getKeyMetadataFilename(filename)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	metadata := &keyMetadata{}
+	if err := json.Unmarshal(data, metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+func (store *FilesystemKeyStore) writeKeyMetadata(filename string, metadata *keyMetadata) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	return store.storage.WriteFile(store.getPrivateKeyFilePath(getKeyMetadataFilename(filename)), data, 0600)
+}
+
+// updateKeyCreation saves creation time of new version of key with filename keeping lifetime of previous version
+func (store *FilesystemKeyStore) updateKeyCreation(filename string) error {
+	metadata, err := store.readKeyMetadata(filename)
+	if err != nil || metadata == nil {
+		// metadata of previous version is broken or absent, new version doesn't expire
+		metadata = &keyMetadata{}
+	}
+	metadata.CreatedAt = time.Now().UTC()
+	return store.writeKeyMetadata(filename, metadata)
+}
+
+// SetKeyTTL sets lifetime of private or symmetric key with purpose of client or zone id counted from its creation.
+// Keys generated before metadata was stored are counted as created now
+func (store *FilesystemKeyStore) SetKeyTTL(purpose string, id []byte, ttl time.Duration) error {
+	filename, _, err := getPrivateKeyFilenameByPurpose(purpose, id)
+	if err != nil {
+		return err
+	}
+	if ttl < 0 {
+		return keystore.ErrInvalidKeyTTL
+	}
+	if _, err := store.storage.Stat(store.getPrivateKeyFilePath(filename)); err != nil {
+		return err
+	}
+	unlock, err := store.lockGeneration()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	metadata, err := store.readKeyMetadata(filename)
+	if err != nil {
+		return err
+	}
+	if metadata == nil {
+		metadata = &keyMetadata{CreatedAt: time.Now().UTC()}
+	}
+	metadata.TTLSeconds = int64(ttl / time.Second)
+	return store.writeKeyMetadata(filename, metadata)
+}
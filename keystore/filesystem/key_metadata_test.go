@@ -0,0 +1,104 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+func findKey(t *testing.T, store *FilesystemKeyStore, name string) keystore.KeyInfo {
+	keys, err := store.ListKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if key.Name == name {
+			return key
+		}
+	}
+	t.Fatalf("key %s not found in %v", name, keys)
+	return keystore.KeyInfo{}
+}
+
+func TestKeyMetadata(t *testing.T) {
+	keyDirectory, err := ioutil.TempDir("", "key_metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keyDirectory)
+	encryptor, err := keystore.NewSCellKeyEncryptor([]byte("some key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFilesystemKeyStore(keyDirectory, encryptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := []byte("client")
+	if err := store.SetKeyTTL(keystore.KeyPurposeStorage, id, time.Hour); !os.IsNotExist(err) {
+		t.Fatalf("Expected error of missing key, took %v", err)
+	}
+	if err := store.GenerateDataEncryptionKeys(id); err != nil {
+		t.Fatal(err)
+	}
+	key := findKey(t, store, "client_storage")
+	if key.CreatedAt.IsZero() || !key.ExpiresAt.IsZero() {
+		t.Fatalf("Unexpected metadata of new key %+v", key)
+	}
+	if err := store.SetKeyTTL(keystore.KeyPurposeStorage, id, -time.Hour); err != keystore.ErrInvalidKeyTTL {
+		t.Fatalf("Expected ErrInvalidKeyTTL, took %v", err)
+	}
+	if err := store.SetKeyTTL(keystore.KeyPurposeStorage, id, time.Hour*24); err != nil {
+		t.Fatal(err)
+	}
+	key = findKey(t, store, "client_storage")
+	if !key.ExpiresAt.Equal(key.CreatedAt.Add(time.Hour * 24)) {
+		t.Fatalf("Unexpected expiration %+v", key)
+	}
+	if publicKey := findKey(t, store, "client_storage.pub"); !publicKey.ExpiresAt.Equal(key.ExpiresAt) {
+		t.Fatalf("Public key doesn't share metadata of private key %+v", publicKey)
+	}
+
+	// rotation keeps lifetime and restarts it
+	createdAt := key.CreatedAt
+	time.Sleep(time.Millisecond)
+	if _, err := store.RotateStorageKeys(id); err != nil {
+		t.Fatal(err)
+	}
+	key = findKey(t, store, "client_storage")
+	if !key.CreatedAt.After(createdAt) || !key.ExpiresAt.Equal(key.CreatedAt.Add(time.Hour*24)) {
+		t.Fatalf("Unexpected metadata of rotated key %+v", key)
+	}
+
+	if err := store.SetKeyTTL(keystore.KeyPurposeStorage, id, 0); err != nil {
+		t.Fatal(err)
+	}
+	if key = findKey(t, store, "client_storage"); !key.ExpiresAt.IsZero() {
+		t.Fatalf("Expiration wasn't removed %+v", key)
+	}
+	if err := store.DestroyKey(keystore.KeyPurposeStorage, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(store.getPrivateKeyFilePath(getKeyMetadataFilename("client_storage"))); !os.IsNotExist(err) {
+		t.Fatalf("Expected removed metadata, took %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// decryptStoredKey decrypts key read from path and replaces stored key with key encrypted with current master key if
+// it was encrypted with previous one. Returns decrypted key and encrypted key as it's stored now
+func (store *FilesystemKeyStore) decryptStoredKey(path string, encryptedKey, context []byte) ([]byte, []byte, error) {
+	rewrapper, ok := store.encryptor.(keystore.KeyRewrapper)
+	if !ok {
+		key, err := store.encryptor.Decrypt(encryptedKey, context)
+		return key, encryptedKey, err
+	}
+	key, rewrapped, err := rewrapper.DecryptAndRewrap(encryptedKey, context)
+	if err != nil || rewrapped == nil {
+		return key, encryptedKey, err
+	}
+	if err := store.storage.WriteFile(path, rewrapped, 0600); err != nil {
+		// key is still usable with previous master key, so read-only keystore doesn't break services
+		log.WithError(err).WithField("key", path).Warningln("Can't save key rewrapped with current master key")
+		return key, encryptedKey, nil
+	}
+	log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeKeyRewrapped, "key": path}).Infoln("Rewrapped key with current master key")
+	return key, rewrapped, nil
+}
+
+// rewrapStoredKey replaces key stored in path with key encrypted with current master key and returns true if it was
+// encrypted with previous master key
+func (store *FilesystemKeyStore) rewrapStoredKey(rewrapper keystore.KeyRewrapper, path string, context []byte) (bool, error) {
+	encryptedKey, err := store.loadPrivateKey(path)
+	if err != nil {
+		return false, err
+	}
+	if checker, ok := rewrapper.(keystore.RewrapChecker); ok && !checker.NeedsRewrap(encryptedKey.Value) {
+		return false, nil
+	}
+	key, rewrapped, err := rewrapper.DecryptAndRewrap(encryptedKey.Value, context)
+	if err != nil {
+		return false, err
+	}
+	utils.FillSlice(byte(0), key)
+	if rewrapped == nil {
+		return false, nil
+	}
+	if err := store.storage.WriteFile(path, rewrapped, 0600); err != nil {
+		return false, err
+	}
+	log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeKeyRewrapped, "key": path}).Infoln("Rewrapped key with current master key")
+	return true, nil
+}
+
+// storedPrivateKey is path of stored private or symmetric key with context of its encryption
+type storedPrivateKey struct {
+	path    string
+	context []byte
+}
+
+// listStoredPrivateKeys returns paths of all private and symmetric keys and their previous versions encrypted with
+// master key
+func (store *FilesystemKeyStore) listStoredPrivateKeys() ([]storedPrivateKey, error) {
+	keyList, err := store.ListKeys()
+	if err != nil {
+		return nil, err
+	}
+	var storedKeys []storedPrivateKey
+	for _, key := range keyList {
+		if key.Public || key.Purpose == keystore.KeyPurposeAuth {
+			continue
+		}
+		filename, context, err := getPrivateKeyFilenameByPurpose(key.Purpose, []byte(key.ID))
+		if err != nil {
+			return nil, err
+		}
+		storedKeys = append(storedKeys, storedPrivateKey{path: store.getPrivateKeyFilePath(filename), context: context})
+		directory := store.getHistoricalKeysDirectory(filename)
+		versions, err := store.storage.ReadDir(directory)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, version := range versions {
+			if version.Mode().IsRegular() {
+				storedKeys = append(storedKeys, storedPrivateKey{path: filepath.Join(directory, version.Name()), context: context})
+			}
+		}
+	}
+	return storedKeys, nil
+}
+
+// RewrapKeys encrypts with current master key all private and symmetric keys and their previous versions which are
+// encrypted with previous master key. Returns count of rewrapped keys
+func (store *FilesystemKeyStore) RewrapKeys() (int, error) {
+	return store.RewrapKeysLimit(0)
+}
+
+// RewrapKeysLimit encrypts with current master key at most limit keys which are encrypted with other master keys, so
+// huge keystore is rewrapped in batches. All keys are rewrapped if limit is 0. Returns count of rewrapped keys
+func (store *FilesystemKeyStore) RewrapKeysLimit(limit int) (int, error) {
+	rewrapper, ok := store.encryptor.(keystore.KeyRewrapper)
+	if !ok {
+		return 0, keystore.ErrNoPreviousMasterKey
+	}
+	storedKeys, err := store.listStoredPrivateKeys()
+	if err != nil {
+		return 0, err
+	}
+	unlock, err := store.lockGeneration()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	// cache stores encrypted keys, so rewrapped keys are loaded again
+	defer store.cache.Clear()
+	rewrapped := 0
+	for _, storedKey := range storedKeys {
+		if limit > 0 && rewrapped >= limit {
+			break
+		}
+		changed, err := store.rewrapStoredKey(rewrapper, storedKey.path, storedKey.context)
+		if err != nil {
+			return rewrapped, err
+		}
+		if changed {
+			rewrapped++
+		}
+	}
+	return rewrapped, nil
+}
+
+// CountKeysByMasterKey returns count of private and symmetric keys and their previous versions by ID of master key
+// recorded in them, keys stored without ID are counted with keystore.LegacyMasterKeyID
+func (store *FilesystemKeyStore) CountKeysByMasterKey() (map[string]int, error) {
+	storedKeys, err := store.listStoredPrivateKeys()
+	if err != nil {
+		return nil, err
+	}
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	counts := make(map[string]int)
+	for _, storedKey := range storedKeys {
+		encryptedKey, err := store.loadPrivateKey(storedKey.path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		id, _, err := keystore.ParseMasterKeyID(encryptedKey.Value)
+		if err != nil {
+			return nil, err
+		}
+		counts[id]++
+	}
+	return counts, nil
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+func TestRewrapKeys(t *testing.T) {
+	keyDirectory, err := ioutil.TempDir("", "rewrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keyDirectory)
+	previous, err := keystore.NewSCellKeyEncryptor([]byte("previous master key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := keystore.NewSCellKeyEncryptor([]byte("current master key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousStore, err := NewFilesystemKeyStore(keyDirectory, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := previousStore.RewrapKeys(); err != keystore.ErrNoPreviousMasterKey {
+		t.Fatalf("Expected ErrNoPreviousMasterKey, took %v", err)
+	}
+	if err := previousStore.GenerateDataEncryptionKeys([]byte("client")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := previousStore.RotateStorageKeys([]byte("client")); err != nil {
+		t.Fatal(err)
+	}
+	if err := previousStore.GenerateHMACSecretKey([]byte("client")); err != nil {
+		t.Fatal(err)
+	}
+	hmacKey, err := previousStore.GetHMACSecretKey([]byte("client"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotatingStore, err := NewFilesystemKeyStore(keyDirectory, keystore.NewRotatingKeyEncryptor(current, previous))
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentStore, err := NewFilesystemKeyStore(keyDirectory, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := currentStore.GetHMACSecretKey([]byte("client")); err == nil {
+		t.Fatal("Key decrypted with current master key before rewrap")
+	}
+	// key is rewrapped on access
+	if key, err := rotatingStore.GetHMACSecretKey([]byte("client")); err != nil || !bytes.Equal(key, hmacKey) {
+		t.Fatalf("Can't read key encrypted with previous master key: %v", err)
+	}
+	if key, err := currentStore.GetHMACSecretKey([]byte("client")); err != nil || !bytes.Equal(key, hmacKey) {
+		t.Fatalf("Key isn't rewrapped on access: %v", err)
+	}
+
+	// current and previous versions of storage key
+	rewrapped, err := rotatingStore.RewrapKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewrapped != 2 {
+		t.Fatalf("Expected 2 rewrapped keys, took %v", rewrapped)
+	}
+	if rewrapped, err := rotatingStore.RewrapKeys(); err != nil || rewrapped != 0 {
+		t.Fatalf("Expected no rewrapped keys on second run, took %v, %v", rewrapped, err)
+	}
+	privateKeys, err := currentStore.GetServerDecryptionPrivateKeys([]byte("client"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(privateKeys) != 2 {
+		t.Fatalf("Expected 2 versions of storage key, took %v", len(privateKeys))
+	}
+}
+
+func TestRewrapKeysByMasterKeyID(t *testing.T) {
+	keyDirectory, err := ioutil.TempDir("", "rewrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keyDirectory)
+	first, err := keystore.NewSCellKeyEncryptor([]byte("first master key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := keystore.NewSCellKeyEncryptor([]byte("second master key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyStore, err := NewFilesystemKeyStore(keyDirectory, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := legacyStore.GenerateHMACSecretKey([]byte("legacy")); err != nil {
+		t.Fatal(err)
+	}
+	firstEncryptor, err := keystore.NewMasterKeyIDEncryptor("first", map[string]keystore.KeyEncryptor{"first": first, keystore.LegacyMasterKeyID: first})
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstStore, err := NewFilesystemKeyStore(keyDirectory, firstEncryptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"client1", "client2", "client3"} {
+		if err := firstStore.GenerateDataEncryptionKeys([]byte(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkCounts := func(store *FilesystemKeyStore, expected map[string]int) {
+		t.Helper()
+		counts, err := store.CountKeysByMasterKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(counts) != len(expected) {
+			t.Fatalf("Expected %v, took %v", expected, counts)
+		}
+		for id, count := range expected {
+			if counts[id] != count {
+				t.Fatalf("Expected %v, took %v", expected, counts)
+			}
+		}
+	}
+	checkCounts(firstStore, map[string]int{keystore.LegacyMasterKeyID: 1, "first": 3})
+	// key without master key ID is rewrapped on access
+	if _, err := firstStore.GetHMACSecretKey([]byte("legacy")); err != nil {
+		t.Fatal(err)
+	}
+	checkCounts(firstStore, map[string]int{"first": 4})
+
+	secondEncryptor, err := keystore.NewMasterKeyIDEncryptor("second", map[string]keystore.KeyEncryptor{"first": first, "second": second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondStore, err := NewFilesystemKeyStore(keyDirectory, secondEncryptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewrapped, err := secondStore.RewrapKeysLimit(3); err != nil || rewrapped != 3 {
+		t.Fatalf("Expected 3 rewrapped keys, took %v, %v", rewrapped, err)
+	}
+	checkCounts(secondStore, map[string]int{"first": 1, "second": 3})
+	if rewrapped, err := secondStore.RewrapKeysLimit(3); err != nil || rewrapped != 1 {
+		t.Fatalf("Expected 1 rewrapped key, took %v, %v", rewrapped, err)
+	}
+	checkCounts(secondStore, map[string]int{"second": 4})
+	if rewrapped, err := secondStore.RewrapKeys(); err != nil || rewrapped != 0 {
+		t.Fatalf("Expected no rewrapped keys, took %v, %v", rewrapped, err)
+	}
+
+	// first master key may be removed after all keys were rewrapped
+	onlySecond, err := keystore.NewMasterKeyIDEncryptor("second", map[string]keystore.KeyEncryptor{"second": second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	onlySecondStore, err := NewFilesystemKeyStore(keyDirectory, onlySecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := onlySecondStore.GetHMACSecretKey([]byte("legacy")); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"client1", "client2", "client3"} {
+		if _, err := onlySecondStore.GetServerDecryptionPrivateKey([]byte(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,588 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/keystore"
+)
+
+// RedisBackendType is type of keystore backend which stores keys in Redis
+const RedisBackendType = "redis"
+
+// Options of redis keystore backend
+const (
+	RedisAddressOption  = "address"
+	RedisDBOption       = "db"
+	RedisPasswordOption = "password"
+	RedisPrefixOption   = "prefix"
+	RedisTimeoutOption  = "timeout"
+)
+
+// Default settings of redis storage
+const (
+	DefaultRedisPrefix  = "acra/keystore"
+	DefaultRedisTimeout = time.Second * 5
+	// redisScanCount is amount of keys requested by one SCAN call
+	redisScanCount = 100
+)
+
+// Redis hash fields of stored file
+const (
+	redisDataField     = "data"
+	redisModeField     = "mode"
+	redisModifiedField = "modified"
+)
+
+// Errors returned by redis keystore backend
+var (
+	ErrRedisAddressRequired = acraerrors.New(acraerrors.CodeRedisAddressRequired, "redis keystore requires address option")
+	ErrRedisInvalidReply    = acraerrors.New(acraerrors.CodeRedisInvalidReply, "invalid reply from redis")
+)
+
+func init() {
+	keystore.RegisterBackend(RedisBackendType, NewRedisBackend)
+}
+
+// NewRedisBackend creates FilesystemKeyStore which keeps keys in Redis, so several services share same keys. Keys are
+// stored by paths of PrivateKeysDir and PublicKeysDir, so all services should be configured with same folders
+func NewRedisBackend(params keystore.BackendParams) (keystore.Backend, error) {
+	storage, err := NewRedisStorage(params.Options)
+	if err != nil {
+		return nil, err
+	}
+	return newBackendKeyStore(params, storage)
+}
+
+// RedisStorage is Storage which keeps files in Redis hashes without expiration. Files are written in transactions and
+// new files are created with optimistic locking, so concurrent services don't overwrite keys generated by each other
+type RedisStorage struct {
+	address  string
+	password string
+	db       int
+	prefix   string
+	timeout  time.Duration
+
+	lock sync.Mutex
+	conn *redisConn
+}
+
+// NewRedisStorage creates RedisStorage configured with keystore options, connection is opened on first request
+func NewRedisStorage(options map[string]string) (*RedisStorage, error) {
+	storage := &RedisStorage{prefix: DefaultRedisPrefix, timeout: DefaultRedisTimeout}
+	for name, value := range options {
+		switch name {
+		case RedisAddressOption:
+			storage.address = value
+		case RedisPasswordOption:
+			storage.password = value
+		case RedisPrefixOption:
+			storage.prefix = value
+		case RedisDBOption:
+			db, err := strconv.Atoi(value)
+			if err != nil || db < 0 {
+				return nil, fmt.Errorf("invalid redis db %q", value)
+			}
+			storage.db = db
+		case RedisTimeoutOption:
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("invalid redis timeout %q", value)
+			}
+			storage.timeout = timeout
+		default:
+			return nil, fmt.Errorf("unknown redis keystore option %q", name)
+		}
+	}
+	if storage.address == "" {
+		return nil, ErrRedisAddressRequired
+	}
+	return storage, nil
+}
+
+// key returns name of redis key which stores file
+func (storage *RedisStorage) key(filePath string) string {
+	return storage.prefix + ":" + path.Clean("/"+filePath)
+}
+
+// do runs callback with opened connection and closes broken connection so next call reconnects
+func (storage *RedisStorage) do(callback func(conn *redisConn) error) error {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	if storage.conn == nil {
+		conn, err := dialRedis(storage.address, storage.password, storage.db, storage.timeout)
+		if err != nil {
+			return err
+		}
+		storage.conn = conn
+	}
+	err := callback(storage.conn)
+	if storage.conn.broken {
+		storage.conn.Close()
+		storage.conn = nil
+	}
+	return err
+}
+
+// Close closes connection to redis
+func (storage *RedisStorage) Close() error {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	if storage.conn == nil {
+		return nil
+	}
+	err := storage.conn.Close()
+	storage.conn = nil
+	return err
+}
+
+// Stat returns info of file
+func (storage *RedisStorage) Stat(filePath string) (os.FileInfo, error) {
+	var info os.FileInfo
+	err := storage.do(func(conn *redisConn) error {
+		reply, err := conn.command("HGETALL", storage.key(filePath))
+		if err != nil {
+			return err
+		}
+		info, err = parseRedisFileInfo(filePath, reply)
+		return err
+	})
+	return info, err
+}
+
+// ReadFile returns content of file
+func (storage *RedisStorage) ReadFile(filePath string) ([]byte, error) {
+	var data []byte
+	err := storage.do(func(conn *redisConn) error {
+		reply, err := conn.command("HGET", storage.key(filePath), redisDataField)
+		if err != nil {
+			return err
+		}
+		if reply == nil {
+			return &os.PathError{Op: "open", Path: filePath, Err: os.ErrNotExist}
+		}
+		var ok bool
+		if data, ok = reply.([]byte); !ok {
+			return ErrRedisInvalidReply
+		}
+		return nil
+	})
+	return data, err
+}
+
+// ReadDir returns files and subdirectories of directory ordered by name, directories exist only while they contain
+// files, so missing directory is empty
+func (storage *RedisStorage) ReadDir(dirPath string) ([]os.FileInfo, error) {
+	dirKey := storage.key(dirPath)
+	if !strings.HasSuffix(dirKey, "/") {
+		dirKey += "/"
+	}
+	var names []string
+	err := storage.do(func(conn *redisConn) error {
+		keys, err := conn.scanKeys(dirKey)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			names = append(names, strings.TrimPrefix(key, dirKey))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	var files []os.FileInfo
+	var lastDir string
+	for _, name := range names {
+		if index := strings.Index(name, "/"); index >= 0 {
+			if dir := name[:index]; dir != lastDir {
+				lastDir = dir
+				files = append(files, redisFileInfo{name: dir, mode: os.ModeDir | 0700})
+			}
+			continue
+		}
+		info, err := storage.Stat(path.Join(dirPath, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				// removed after scan
+				continue
+			}
+			return nil, err
+		}
+		files = append(files, info)
+	}
+	return files, nil
+}
+
+// RemoveAll removes file or directory with all its files, missing path isn't error
+func (storage *RedisStorage) RemoveAll(filePath string) error {
+	key := storage.key(filePath)
+	return storage.do(func(conn *redisConn) error {
+		keys, err := conn.scanKeys(strings.TrimSuffix(key, "/") + "/")
+		if err != nil {
+			return err
+		}
+		_, err = conn.command(append([]string{"DEL", key}, keys...)...)
+		return err
+	})
+}
+
+// MkdirAll does nothing because directories are parts of redis keys
+func (storage *RedisStorage) MkdirAll(path string, perm os.FileMode) error {
+	return nil
+}
+
+// WriteFile writes data to file creating it if it doesn't exist
+func (storage *RedisStorage) WriteFile(filePath string, data []byte, perm os.FileMode) error {
+	return storage.ReplaceFile(filePath, data, perm, time.Now())
+}
+
+// CreateFile writes data to new file, returns error if file exists. Key is watched while checked, so transaction
+// fails if other service created file concurrently
+func (storage *RedisStorage) CreateFile(filePath string, data []byte, perm os.FileMode) error {
+	key := storage.key(filePath)
+	existsErr := &os.PathError{Op: "create", Path: filePath, Err: os.ErrExist}
+	return storage.do(func(conn *redisConn) error {
+		if _, err := conn.command("WATCH", key); err != nil {
+			return err
+		}
+		reply, err := conn.command("EXISTS", key)
+		if err != nil {
+			return err
+		}
+		if exists, ok := reply.(int64); !ok || exists != 0 {
+			if _, err := conn.command("UNWATCH"); err != nil {
+				return err
+			}
+			if !ok {
+				return ErrRedisInvalidReply
+			}
+			return existsErr
+		}
+		committed, err := conn.transaction(
+			redisFileCommand(key, data, perm, time.Now()),
+		)
+		if err != nil {
+			return err
+		}
+		if !committed {
+			return existsErr
+		}
+		return nil
+	})
+}
+
+// ReplaceFile atomically replaces file with data which has modification time modifiedAt
+func (storage *RedisStorage) ReplaceFile(filePath string, data []byte, perm os.FileMode, modifiedAt time.Time) error {
+	key := storage.key(filePath)
+	return storage.do(func(conn *redisConn) error {
+		_, err := conn.transaction(
+			[]string{"DEL", key},
+			redisFileCommand(key, data, perm, modifiedAt),
+		)
+		return err
+	})
+}
+
+// redisFileCommand returns command which stores file in hash
+func redisFileCommand(key string, data []byte, perm os.FileMode, modifiedAt time.Time) []string {
+	return []string{"HSET", key,
+		redisDataField, string(data),
+		redisModeField, strconv.FormatUint(uint64(perm.Perm()), 8),
+		redisModifiedField, strconv.FormatInt(modifiedAt.UnixNano(), 10),
+	}
+}
+
+// parseRedisFileInfo parses HGETALL reply with fields of file
+func parseRedisFileInfo(filePath string, reply interface{}) (os.FileInfo, error) {
+	fields, ok := reply.([]interface{})
+	if !ok || len(fields)%2 != 0 {
+		return nil, ErrRedisInvalidReply
+	}
+	if len(fields) == 0 {
+		return nil, &os.PathError{Op: "stat", Path: filePath, Err: os.ErrNotExist}
+	}
+	info := redisFileInfo{name: path.Base(filePath)}
+	for i := 0; i < len(fields); i += 2 {
+		name, nameOk := fields[i].([]byte)
+		value, valueOk := fields[i+1].([]byte)
+		if !nameOk || !valueOk {
+			return nil, ErrRedisInvalidReply
+		}
+		switch string(name) {
+		case redisDataField:
+			info.size = int64(len(value))
+		case redisModeField:
+			mode, err := strconv.ParseUint(string(value), 8, 32)
+			if err != nil {
+				return nil, ErrRedisInvalidReply
+			}
+			info.mode = os.FileMode(mode).Perm()
+		case redisModifiedField:
+			modified, err := strconv.ParseInt(string(value), 10, 64)
+			if err != nil {
+				return nil, ErrRedisInvalidReply
+			}
+			info.modTime = time.Unix(0, modified)
+		}
+	}
+	return info, nil
+}
+
+// escapeRedisPattern escapes special characters of glob-style pattern used by SCAN
+func escapeRedisPattern(value string) string {
+	var escaped bytes.Buffer
+	for _, c := range value {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(c)
+	}
+	return escaped.String()
+}
+
+// redisFileInfo is os.FileInfo of file stored in redis
+type redisFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (info redisFileInfo) Name() string       { return info.name }
+func (info redisFileInfo) Size() int64        { return info.size }
+func (info redisFileInfo) Mode() os.FileMode  { return info.mode }
+func (info redisFileInfo) ModTime() time.Time { return info.modTime }
+func (info redisFileInfo) IsDir() bool        { return info.mode.IsDir() }
+func (info redisFileInfo) Sys() interface{}   { return nil }
+
+// redisError is error reply of redis, connection stays usable after it
+type redisError string
+
+func (err redisError) Error() string {
+	return "redis: " + string(err)
+}
+
+// redisConn is connection to redis which sends commands in RESP protocol
+type redisConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+	// broken is true after network or protocol error, such connection should be closed
+	broken bool
+}
+
+// dialRedis opens connection to redis, authenticates and selects database
+func dialRedis(address, password string, db int, timeout time.Duration) (*redisConn, error) {
+	netConn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{conn: netConn, reader: bufio.NewReader(netConn), timeout: timeout}
+	if password != "" {
+		if _, err := conn.command("AUTH", password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if db != 0 {
+		if _, err := conn.command("SELECT", strconv.Itoa(db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// Close closes connection
+func (conn *redisConn) Close() error {
+	return conn.conn.Close()
+}
+
+// command sends command and returns its reply: string for status, int64 for integer, []byte for bulk string,
+// []interface{} for array and nil for null
+func (conn *redisConn) command(args ...string) (interface{}, error) {
+	reply, err := conn.roundTrip(args)
+	if _, ok := err.(redisError); err != nil && !ok {
+		conn.broken = true
+	}
+	return reply, err
+}
+
+func (conn *redisConn) roundTrip(args []string) (interface{}, error) {
+	if err := conn.conn.SetDeadline(time.Now().Add(conn.timeout)); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := conn.conn.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return conn.readReply()
+}
+
+// scanKeys returns unique names of keys which start with prefix
+func (conn *redisConn) scanKeys(prefix string) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	cursor := "0"
+	for {
+		reply, err := conn.command("SCAN", cursor, "MATCH", escapeRedisPattern(prefix)+"*", "COUNT", strconv.Itoa(redisScanCount))
+		if err != nil {
+			return nil, err
+		}
+		items, ok := reply.([]interface{})
+		if !ok || len(items) != 2 {
+			return nil, ErrRedisInvalidReply
+		}
+		nextCursor, ok := items[0].([]byte)
+		if !ok {
+			return nil, ErrRedisInvalidReply
+		}
+		keys, ok := items[1].([]interface{})
+		if !ok {
+			return nil, ErrRedisInvalidReply
+		}
+		for _, key := range keys {
+			keyName, ok := key.([]byte)
+			if !ok {
+				return nil, ErrRedisInvalidReply
+			}
+			if !seen[string(keyName)] {
+				seen[string(keyName)] = true
+				names = append(names, string(keyName))
+			}
+		}
+		cursor = string(nextCursor)
+		if cursor == "0" {
+			return names, nil
+		}
+	}
+}
+
+// transaction runs commands in MULTI/EXEC block, returns false if transaction was aborted because of watched keys
+func (conn *redisConn) transaction(commands ...[]string) (bool, error) {
+	if _, err := conn.command("MULTI"); err != nil {
+		return false, err
+	}
+	for _, command := range commands {
+		if _, err := conn.command(command...); err != nil {
+			conn.command("DISCARD")
+			return false, err
+		}
+	}
+	reply, err := conn.command("EXEC")
+	if err != nil {
+		return false, err
+	}
+	results, ok := reply.([]interface{})
+	if reply != nil && !ok {
+		return false, ErrRedisInvalidReply
+	}
+	for _, result := range results {
+		if err, ok := result.(redisError); ok {
+			return false, err
+		}
+	}
+	return reply != nil, nil
+}
+
+func (conn *redisConn) readLine() (string, error) {
+	line, err := conn.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", ErrRedisInvalidReply
+	}
+	return line[:len(line)-2], nil
+}
+
+func (conn *redisConn) readReply() (interface{}, error) {
+	line, err := conn.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, ErrRedisInvalidReply
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		value, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, ErrRedisInvalidReply
+		}
+		return value, nil
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil || length < -1 {
+			return nil, ErrRedisInvalidReply
+		}
+		if length == -1 {
+			return nil, nil
+		}
+		data := make([]byte, length+2)
+		if _, err := io.ReadFull(conn.reader, data); err != nil {
+			return nil, err
+		}
+		return data[:length], nil
+	case '*':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil || length < -1 {
+			return nil, ErrRedisInvalidReply
+		}
+		if length == -1 {
+			return nil, nil
+		}
+		items := make([]interface{}, length)
+		for i := range items {
+			item, err := conn.readReply()
+			// errors of commands in EXEC reply are returned as items
+			if redisErr, ok := err.(redisError); ok {
+				item, err = redisErr, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, ErrRedisInvalidReply
+}
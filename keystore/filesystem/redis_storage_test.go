@@ -0,0 +1,477 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+// fakeRedis is in-memory redis server which supports commands used by RedisStorage
+type fakeRedis struct {
+	listener net.Listener
+	password string
+	lock     sync.Mutex
+	hashes   map[string]map[string][]byte
+	versions map[string]int
+	// beforeExec is called before EXEC of transaction
+	beforeExec func()
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeRedis{listener: listener, password: password, hashes: make(map[string]map[string][]byte), versions: make(map[string]int)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (server *fakeRedis) Close() {
+	server.listener.Close()
+}
+
+func (server *fakeRedis) Address() string {
+	return server.listener.Addr().String()
+}
+
+func (server *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authenticated := server.password == ""
+	var watched map[string]int
+	var queue [][]string
+	inTransaction := false
+	for {
+		args, err := readFakeRedisCommand(reader)
+		if err != nil {
+			return
+		}
+		var buf bytes.Buffer
+		command := strings.ToUpper(args[0])
+		switch {
+		case command == "AUTH":
+			if args[1] != server.password {
+				buf.WriteString("-ERR invalid password\r\n")
+				break
+			}
+			authenticated = true
+			buf.WriteString("+OK\r\n")
+		case !authenticated:
+			buf.WriteString("-NOAUTH Authentication required.\r\n")
+		case command == "MULTI":
+			inTransaction = true
+			buf.WriteString("+OK\r\n")
+		case command == "DISCARD":
+			inTransaction, queue, watched = false, nil, nil
+			buf.WriteString("+OK\r\n")
+		case command == "EXEC":
+			if server.beforeExec != nil {
+				server.beforeExec()
+			}
+			server.lock.Lock()
+			aborted := false
+			for key, version := range watched {
+				if server.versions[key] != version {
+					aborted = true
+				}
+			}
+			if aborted {
+				buf.WriteString("*-1\r\n")
+			} else {
+				fmt.Fprintf(&buf, "*%d\r\n", len(queue))
+				for _, queued := range queue {
+					server.execute(&buf, queued)
+				}
+			}
+			server.lock.Unlock()
+			inTransaction, queue, watched = false, nil, nil
+		case inTransaction:
+			queue = append(queue, args)
+			buf.WriteString("+QUEUED\r\n")
+		case command == "WATCH":
+			server.lock.Lock()
+			if watched == nil {
+				watched = make(map[string]int)
+			}
+			for _, key := range args[1:] {
+				watched[key] = server.versions[key]
+			}
+			server.lock.Unlock()
+			buf.WriteString("+OK\r\n")
+		case command == "UNWATCH":
+			watched = nil
+			buf.WriteString("+OK\r\n")
+		default:
+			server.lock.Lock()
+			server.execute(&buf, args)
+			server.lock.Unlock()
+		}
+		if _, err := conn.Write(buf.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+// execute runs data command, server should be locked
+func (server *fakeRedis) execute(buf *bytes.Buffer, args []string) {
+	writeBulk := func(value []byte) {
+		fmt.Fprintf(buf, "$%d\r\n%s\r\n", len(value), value)
+	}
+	switch strings.ToUpper(args[0]) {
+	case "PING", "SELECT":
+		buf.WriteString("+OK\r\n")
+	case "HSET":
+		hash, ok := server.hashes[args[1]]
+		if !ok {
+			hash = make(map[string][]byte)
+			server.hashes[args[1]] = hash
+		}
+		for i := 2; i+1 < len(args); i += 2 {
+			hash[args[i]] = []byte(args[i+1])
+		}
+		server.versions[args[1]]++
+		fmt.Fprintf(buf, ":%d\r\n", (len(args)-2)/2)
+	case "HGET":
+		value, ok := server.hashes[args[1]][args[2]]
+		if !ok {
+			buf.WriteString("$-1\r\n")
+			return
+		}
+		writeBulk(value)
+	case "HGETALL":
+		hash := server.hashes[args[1]]
+		fmt.Fprintf(buf, "*%d\r\n", len(hash)*2)
+		for field, value := range hash {
+			writeBulk([]byte(field))
+			writeBulk(value)
+		}
+	case "EXISTS":
+		_, ok := server.hashes[args[1]]
+		if ok {
+			buf.WriteString(":1\r\n")
+		} else {
+			buf.WriteString(":0\r\n")
+		}
+	case "DEL":
+		deleted := 0
+		for _, key := range args[1:] {
+			if _, ok := server.hashes[key]; ok {
+				delete(server.hashes, key)
+				server.versions[key]++
+				deleted++
+			}
+		}
+		fmt.Fprintf(buf, ":%d\r\n", deleted)
+	case "SCAN":
+		// returns keys in pages of one key to check iteration by cursor
+		prefix := strings.Replace(strings.TrimSuffix(args[3], "*"), "\\", "", -1)
+		var keys []string
+		for key := range server.hashes {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		cursor, _ := strconv.Atoi(args[1])
+		if cursor >= len(keys) {
+			buf.WriteString("*2\r\n$1\r\n0\r\n*0\r\n")
+			return
+		}
+		next := strconv.Itoa(cursor + 1)
+		if cursor+1 >= len(keys) {
+			next = "0"
+		}
+		buf.WriteString("*2\r\n")
+		writeBulk([]byte(next))
+		buf.WriteString("*1\r\n")
+		writeBulk([]byte(keys[cursor]))
+	default:
+		fmt.Fprintf(buf, "-ERR unknown command '%s'\r\n", args[0])
+	}
+}
+
+func readFakeRedisCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, count)
+	for i := range args {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, length+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:length])
+	}
+	return args, nil
+}
+
+func TestNewRedisStorage(t *testing.T) {
+	if _, err := NewRedisStorage(map[string]string{}); err != ErrRedisAddressRequired {
+		t.Fatalf("Expected ErrRedisAddressRequired, took %v", err)
+	}
+	for _, options := range []map[string]string{
+		{"address": "127.0.0.1:6379", "db": "-1"},
+		{"address": "127.0.0.1:6379", "timeout": "second"},
+		{"address": "127.0.0.1:6379", "unknown": "value"},
+	} {
+		if _, err := NewRedisStorage(options); err == nil {
+			t.Fatalf("Expected error with options %v", options)
+		}
+	}
+	storage, err := NewRedisStorage(map[string]string{"address": "127.0.0.1:6379", "db": "2", "prefix": "keys", "timeout": "1s", "password": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storage.db != 2 || storage.prefix != "keys" || storage.timeout != time.Second || storage.password != "secret" {
+		t.Fatalf("Incorrect storage settings %+v", storage)
+	}
+}
+
+func TestRedisStorage(t *testing.T) {
+	server := newFakeRedis(t, "secret")
+	defer server.Close()
+	storage, err := NewRedisStorage(map[string]string{"address": server.Address(), "password": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	if _, err := storage.Stat("keys/file"); !os.IsNotExist(err) {
+		t.Fatalf("Expected not exist error, took %v", err)
+	}
+	if _, err := storage.ReadFile("keys/file"); !os.IsNotExist(err) {
+		t.Fatalf("Expected not exist error, took %v", err)
+	}
+	if err := storage.CreateFile("keys/file", []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.CreateFile("keys/file", []byte("other data"), 0600); !os.IsExist(err) {
+		t.Fatalf("Expected exist error, took %v", err)
+	}
+	data, err := storage.ReadFile("keys/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte("data")) {
+		t.Fatalf("Incorrect data %s", data)
+	}
+
+	modifiedAt := time.Unix(1500000000, 0)
+	if err := storage.ReplaceFile("keys/file", []byte("new data"), 0400, modifiedAt); err != nil {
+		t.Fatal(err)
+	}
+	info, err := storage.Stat("keys/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name() != "file" || info.Size() != int64(len("new data")) || info.Mode() != 0400 || !info.ModTime().Equal(modifiedAt) || !info.Mode().IsRegular() {
+		t.Fatalf("Incorrect file info %v %v %v %v", info.Name(), info.Size(), info.Mode(), info.ModTime())
+	}
+
+	if err := storage.WriteFile("keys/sub/file", []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.WriteFile("keys/another", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.WriteFile("keys_other/file", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files, err := storage.ReadDir("keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, fmt.Sprintf("%s:%v", file.Name(), file.IsDir()))
+	}
+	if strings.Join(names, ",") != "another:false,file:false,sub:true" {
+		t.Fatalf("Incorrect directory content %v", names)
+	}
+	if files, err := storage.ReadDir("missing"); err != nil || len(files) != 0 {
+		t.Fatalf("Expected empty directory, took %v, %v", files, err)
+	}
+
+	// other service creates same file between check and transaction
+	server.beforeExec = func() {
+		server.lock.Lock()
+		server.hashes[storage.key("keys/concurrent")] = map[string][]byte{redisDataField: []byte("other")}
+		server.versions[storage.key("keys/concurrent")]++
+		server.lock.Unlock()
+	}
+	if err := storage.CreateFile("keys/concurrent", []byte("data"), 0600); !os.IsExist(err) {
+		t.Fatalf("Expected exist error, took %v", err)
+	}
+	server.beforeExec = nil
+	data, err = storage.ReadFile("keys/concurrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte("other")) {
+		t.Fatal("File created by other service was overwritten")
+	}
+}
+
+func TestRedisStorageReconnect(t *testing.T) {
+	server := newFakeRedis(t, "")
+	defer server.Close()
+	storage, err := NewRedisStorage(map[string]string{"address": server.Address()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	if err := storage.WriteFile("file", []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// break opened connection
+	storage.conn.conn.Close()
+	if _, err := storage.ReadFile("file"); err == nil {
+		t.Fatal("Expected error on closed connection")
+	}
+	if storage.conn != nil {
+		t.Fatal("Broken connection wasn't dropped")
+	}
+	data, err := storage.ReadFile("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte("data")) {
+		t.Fatalf("Incorrect data %s", data)
+	}
+}
+
+func TestRedisBackend(t *testing.T) {
+	server := newFakeRedis(t, "")
+	defer server.Close()
+	encryptor, err := keystore.NewSCellKeyEncryptor([]byte("some key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := keystore.BackendParams{PrivateKeysDir: ".acrakeys", Encryptor: encryptor, CacheSize: keystore.NO_CACHE,
+		Options: map[string]string{"address": server.Address()}}
+	// two services share keys stored in redis
+	var stores []*FilesystemKeyStore
+	for i := 0; i < 2; i++ {
+		backend, err := keystore.NewBackend(RedisBackendType, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store, ok := backend.(*FilesystemKeyStore)
+		if !ok {
+			t.Fatalf("Expected FilesystemKeyStore, took %T", backend)
+		}
+		defer store.storage.(*RedisStorage).Close()
+		stores = append(stores, store)
+	}
+	testGeneral(stores[0], t)
+
+	clientID := []byte("client")
+	if err := stores[0].GenerateServerKeys(clientID); err != nil {
+		t.Fatal(err)
+	}
+	if err := stores[0].GenerateDataEncryptionKeys(clientID); err != nil {
+		t.Fatal(err)
+	}
+	private, err := stores[1].GetServerDecryptionPrivateKey(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := stores[1].GetPublicKeyByName("client_storage.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(private.Value) == 0 || len(public) == 0 {
+		t.Fatal("Empty keys")
+	}
+
+	poisonKeypair, err := stores[0].GetPoisonKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPoisonKeypair, err := stores[1].GetPoisonKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(poisonKeypair.Private.Value, otherPoisonKeypair.Private.Value) || !bytes.Equal(poisonKeypair.Public.Value, otherPoisonKeypair.Public.Value) {
+		t.Fatal("Services use different poison keys")
+	}
+
+	authKey, err := stores[0].GetAuthKey(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherAuthKey, err := stores[1].GetAuthKey(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(authKey, otherAuthKey) {
+		t.Fatal("Services use different auth keys")
+	}
+
+	keys, err := stores[1].ListKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, key := range keys {
+		// skip zone key generated by testGeneral
+		if key.Purpose != keystore.KeyPurposeZone {
+			names = append(names, key.Name)
+		}
+	}
+	sort.Strings(names)
+	expected := []string{".poison_key/poison_key", ".poison_key/poison_key.pub", "auth_key", "client_server", "client_server.pub", "client_storage", "client_storage.pub"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Incorrect keys %v", names)
+	}
+	testDestroyKey(stores[1], t)
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// Errors returned when replication log can't be applied to keystore
+var (
+	ErrInvalidReplicationSignature = acraerrors.New(acraerrors.CodeInvalidReplicationSignature, "replication log isn't signed with master key of keystore")
+	ErrInvalidReplicatedKey        = acraerrors.New(acraerrors.CodeInvalidReplicatedKey, "replication log contains key which can't be used by keystore")
+)
+
+// replicationSignatureContext is context of signature of replication log encrypted with master key
+var replicationSignatureContext = []byte("acra keystore replication")
+
+// ReplicationChange is key file created or changed in primary keystore. Private keys stay encrypted with master key
+type ReplicationChange struct {
+	Name       string    `json:"name"`
+	Public     bool      `json:"public"`
+	ModifiedAt time.Time `json:"modified_at"`
+	Data       []byte    `json:"data"`
+}
+
+// ReplicationLog is list of keys changed in primary keystore since some moment. Replicas accept log only if its
+// signature is verified with their master key, so keystores in all regions should share master key
+type ReplicationLog struct {
+	Since     time.Time           `json:"since"`
+	CreatedAt time.Time           `json:"created_at"`
+	Changes   []ReplicationChange `json:"changes"`
+	// Signature is SHA-256 hash of log encrypted with master key
+	Signature []byte `json:"signature"`
+}
+
+// ReplicationResult lists names of keys written to replica and skipped because replica has same or newer key
+type ReplicationResult struct {
+	Applied []string
+	Skipped []string
+}
+
+// digest returns SHA-256 hash of log without signature
+func (replicationLog *ReplicationLog) digest() ([]byte, error) {
+	unsigned := *replicationLog
+	unsigned.Signature = nil
+	data, err := json.Marshal(unsigned)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(data)
+	return hash[:], nil
+}
+
+// keyFilePath returns path of key file from replication log
+func (store *FilesystemKeyStore) keyFilePath(change *ReplicationChange) string {
+	if change.Public {
+		return store.getPublicKeyFilePath(change.Name)
+	}
+	return store.getPrivateKeyFilePath(change.Name)
+}
+
+// ExportReplicationLog returns signed log of zone, client and poison keys modified after since. Zero since exports
+// all keys
+func (store *FilesystemKeyStore) ExportReplicationLog(since time.Time) (*ReplicationLog, error) {
+	keyList, err := store.ListKeys()
+	if err != nil {
+		return nil, err
+	}
+	replicationLog := &ReplicationLog{Since: since, CreatedAt: time.Now().UTC(), Changes: make([]ReplicationChange, 0)}
+	store.lock.RLock()
+	for _, key := range keyList {
+		if key.Purpose == keystore.KeyPurposeAuth || !key.ModifiedAt.After(since) {
+			continue
+		}
+		change := ReplicationChange{Name: key.Name, Public: key.Public, ModifiedAt: key.ModifiedAt.UTC()}
+		change.Data, err = store.storage.ReadFile(store.keyFilePath(&change))
+		if err != nil {
+			store.lock.RUnlock()
+			return nil, err
+		}
+		replicationLog.Changes = append(replicationLog.Changes, change)
+	}
+	store.lock.RUnlock()
+	digest, err := replicationLog.digest()
+	if err != nil {
+		return nil, err
+	}
+	replicationLog.Signature, err = store.encryptor.Encrypt(digest, replicationSignatureContext)
+	if err != nil {
+		return nil, err
+	}
+	return replicationLog, nil
+}
+
+// verifyReplicatedKey checks that name of key follows naming of keystore and private key can be decrypted with
+// master key of keystore
+func (store *FilesystemKeyStore) verifyReplicatedKey(change *ReplicationChange) error {
+	name := change.Name
+	if change.Public {
+		if !strings.HasSuffix(name, ".pub") || len(change.Data) == 0 {
+			return ErrInvalidReplicatedKey
+		}
+		name = strings.TrimSuffix(name, ".pub")
+	}
+	var context []byte
+	if name == POISON_KEY_FILENAME {
+		context = []byte(POISON_KEY_FILENAME)
+	} else {
+		if name != filepath.Base(name) {
+			return ErrInvalidReplicatedKey
+		}
+		purpose, id, _ := parseKeyFilename(name)
+		filename, keyContext, err := getPrivateKeyFilenameByPurpose(purpose, []byte(id))
+		if err != nil || filename != name {
+			return ErrInvalidReplicatedKey
+		}
+		context = keyContext
+	}
+	if change.Public {
+		return nil
+	}
+	key, err := store.encryptor.Decrypt(change.Data, context)
+	if err != nil {
+		return ErrInvalidReplicatedKey
+	}
+	utils.FillSlice(byte(0), key)
+	return nil
+}
+
+// ApplyReplicationLog verifies signature and keys of replication log and writes keys which are missing in keystore
+// or older than in log. Keys are verified before any of them is written, so invalid log doesn't change keystore.
+// Each key file is replaced atomically and keeps modification time from primary keystore
+func (store *FilesystemKeyStore) ApplyReplicationLog(replicationLog *ReplicationLog) (*ReplicationResult, error) {
+	digest, err := replicationLog.digest()
+	if err != nil {
+		return nil, err
+	}
+	signedDigest, err := store.encryptor.Decrypt(replicationLog.Signature, replicationSignatureContext)
+	if err != nil || subtle.ConstantTimeCompare(signedDigest, digest) != 1 {
+		return nil, ErrInvalidReplicationSignature
+	}
+	for i := range replicationLog.Changes {
+		if err := store.verifyReplicatedKey(&replicationLog.Changes[i]); err != nil {
+			log.WithField("key", replicationLog.Changes[i].Name).WithError(err).Errorln("Invalid key in replication log")
+			return nil, err
+		}
+	}
+	result := &ReplicationResult{}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for i := range replicationLog.Changes {
+		change := &replicationLog.Changes[i]
+		path := store.keyFilePath(change)
+		if info, err := store.storage.Stat(path); err == nil {
+			current, err := store.storage.ReadFile(path)
+			if err != nil {
+				return result, err
+			}
+			if bytes.Equal(current, change.Data) {
+				result.Skipped = append(result.Skipped, change.Name)
+				continue
+			}
+			if info.ModTime().After(change.ModifiedAt) {
+				log.WithField("key", change.Name).Warningln("Keystore has newer version of replicated key, key skipped")
+				result.Skipped = append(result.Skipped, change.Name)
+				continue
+			}
+		} else if !os.IsNotExist(err) {
+			return result, err
+		}
+		mode := os.FileMode(0600)
+		if change.Public {
+			mode = 0644
+		}
+		if err := store.storage.ReplaceFile(path, change.Data, mode, change.ModifiedAt); err != nil {
+			return result, err
+		}
+		result.Applied = append(result.Applied, change.Name)
+		log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeKeyReplicated, "key": change.Name}).Infoln("Replicated key")
+	}
+	if len(result.Applied) > 0 {
+		store.cache.Clear()
+	}
+	return result, nil
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+func newTestReplicationKeyStore(t *testing.T, masterKey string) (*FilesystemKeyStore, func()) {
+	keyDirectory, err := ioutil.TempDir("", "test_filesystem_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptor, err := keystore.NewSCellKeyEncryptor([]byte(masterKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyStore, err := NewFilesystemKeyStore(keyDirectory, encryptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyStore, func() { os.RemoveAll(keyDirectory) }
+}
+
+func TestFilesystemKeyStore_Replication(t *testing.T) {
+	primary, removePrimary := newTestReplicationKeyStore(t, "some key")
+	defer removePrimary()
+	replica, removeReplica := newTestReplicationKeyStore(t, "some key")
+	defer removeReplica()
+	clientID := []byte("client")
+	if err := primary.GenerateDataEncryptionKeys(clientID); err != nil {
+		t.Fatal(err)
+	}
+	zoneID, _, err := primary.GenerateZoneKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replicationLog, err := primary.ExportReplicationLog(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := replica.ApplyReplicationLog(replicationLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Applied) != 4 || len(result.Skipped) != 0 {
+		t.Fatalf("Expected 4 applied keys, took %v", result)
+	}
+	primaryKey, err := primary.GetServerDecryptionPrivateKey(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicaKey, err := replica.GetServerDecryptionPrivateKey(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(primaryKey.Value, replicaKey.Value) {
+		t.Fatal("Replicated key differs from primary key")
+	}
+	if result, err = replica.ApplyReplicationLog(replicationLog); err != nil || len(result.Applied) != 0 {
+		t.Fatalf("Expected all keys skipped on second apply, took %v, %v", result, err)
+	}
+
+	// only rotated zone key is replicated
+	since := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	if _, err := primary.RotateZoneKey(zoneID); err != nil {
+		t.Fatal(err)
+	}
+	replicationLog, err = primary.ExportReplicationLog(since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replicationLog.Changes) != 2 {
+		t.Fatalf("Expected 2 changed zone key files, took %d", len(replicationLog.Changes))
+	}
+	if _, err := replica.GetZonePrivateKey(zoneID); err != nil {
+		t.Fatal(err)
+	}
+	if result, err = replica.ApplyReplicationLog(replicationLog); err != nil || len(result.Applied) != 2 {
+		t.Fatalf("Expected rotated zone key applied, took %v, %v", result, err)
+	}
+	primaryZoneKey, err := primary.GetZonePrivateKey(zoneID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicaZoneKey, err := replica.GetZonePrivateKey(zoneID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(primaryZoneKey.Value, replicaZoneKey.Value) {
+		t.Fatal("Replica returned cached zone key after replication")
+	}
+
+	// tampered log and log signed with other master key are rejected
+	replicationLog.Changes[0].Data = append([]byte{}, replicationLog.Changes[1].Data...)
+	if _, err := replica.ApplyReplicationLog(replicationLog); err != ErrInvalidReplicationSignature {
+		t.Fatalf("Expected ErrInvalidReplicationSignature, took %v", err)
+	}
+	otherPrimary, removeOtherPrimary := newTestReplicationKeyStore(t, "other key")
+	defer removeOtherPrimary()
+	if err := otherPrimary.GenerateDataEncryptionKeys(clientID); err != nil {
+		t.Fatal(err)
+	}
+	replicationLog, err = otherPrimary.ExportReplicationLog(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replica.ApplyReplicationLog(replicationLog); err != ErrInvalidReplicationSignature {
+		t.Fatalf("Expected ErrInvalidReplicationSignature, took %v", err)
+	}
+}
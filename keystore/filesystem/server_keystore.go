@@ -28,17 +28,19 @@ import (
 	"crypto/rand"
 	"errors"
 	"fmt"
+	"github.com/cossacklabs/acra/acraerrors"
 	"github.com/cossacklabs/acra/keystore"
 	"github.com/cossacklabs/acra/keystore/lru_cache"
+	"github.com/cossacklabs/acra/logging"
 	"github.com/cossacklabs/acra/utils"
 	"github.com/cossacklabs/acra/zone"
 	"github.com/cossacklabs/themis/gothemis/keys"
 	log "github.com/sirupsen/logrus"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
 	"sync"
+	"time"
 )
 
 // FilesystemKeyStore represents keystore that reads keys from key folders, and stores them in memory.
@@ -49,30 +51,31 @@ type FilesystemKeyStore struct {
 	directory           string
 	lock                *sync.RWMutex
 	encryptor           keystore.KeyEncryptor
+	storage             Storage
 }
 
 // NewFileSystemKeyStoreWithCacheSize represents keystore that reads keys from key folders, and stores them in cache.
 func NewFileSystemKeyStoreWithCacheSize(directory string, encryptor keystore.KeyEncryptor, cacheSize int) (*FilesystemKeyStore, error) {
-	return newFilesystemKeyStore(directory, directory, encryptor, cacheSize)
+	return newFilesystemKeyStore(directory, directory, encryptor, cacheSize, fileStorage{})
 }
 
 // NewFilesystemKeyStore represents keystore that reads keys from key folders, and stores them in memory.
 func NewFilesystemKeyStore(directory string, encryptor keystore.KeyEncryptor) (*FilesystemKeyStore, error) {
-	return newFilesystemKeyStore(directory, directory, encryptor, keystore.INFINITE_CACHE_SIZE)
+	return newFilesystemKeyStore(directory, directory, encryptor, keystore.INFINITE_CACHE_SIZE, fileStorage{})
 }
 
 // NewFilesystemKeyStoreTwoPath creates new FilesystemKeyStore using separate folders for private and public keys.
 func NewFilesystemKeyStoreTwoPath(privateKeyFolder, publicKeyFolder string, encryptor keystore.KeyEncryptor) (*FilesystemKeyStore, error) {
-	return newFilesystemKeyStore(privateKeyFolder, publicKeyFolder, encryptor, keystore.INFINITE_CACHE_SIZE)
+	return newFilesystemKeyStore(privateKeyFolder, publicKeyFolder, encryptor, keystore.INFINITE_CACHE_SIZE, fileStorage{})
 }
 
-func newFilesystemKeyStore(privateKeyFolder, publicKeyFolder string, encryptor keystore.KeyEncryptor, cacheSize int) (*FilesystemKeyStore, error) {
+func newFilesystemKeyStore(privateKeyFolder, publicKeyFolder string, encryptor keystore.KeyEncryptor, cacheSize int, storage Storage) (*FilesystemKeyStore, error) {
 	// check folder for private key
 	directory, err := utils.AbsPath(privateKeyFolder)
 	if err != nil {
 		return nil, err
 	}
-	fi, err := os.Stat(directory)
+	fi, err := storage.Stat(directory)
 	if nil == err && runtime.GOOS == "linux" && fi.Mode().Perm().String() != "-rwx------" {
 		log.Errorln(" key store folder has an incorrect permissions")
 		return nil, errors.New("key store folder has an incorrect permissions")
@@ -83,7 +86,7 @@ func newFilesystemKeyStore(privateKeyFolder, publicKeyFolder string, encryptor k
 		if err != nil {
 			return nil, err
 		}
-		fi, err = os.Stat(directory)
+		fi, err = storage.Stat(directory)
 		if nil != err && !os.IsNotExist(err) {
 			return nil, err
 		}
@@ -98,25 +101,74 @@ func newFilesystemKeyStore(privateKeyFolder, publicKeyFolder string, encryptor k
 		}
 	}
 	store := &FilesystemKeyStore{privateKeyDirectory: privateKeyFolder, publicKeyDirectory: publicKeyFolder,
-		cache: cache, lock: &sync.RWMutex{}, encryptor: encryptor}
+		cache: cache, lock: &sync.RWMutex{}, encryptor: encryptor, storage: storage}
 	// set callback on cache value removing
 
 	return store, nil
 }
 
+// ErrUnsupportedOptions returned if filesystem keystore backend created with backend specific options
+var ErrUnsupportedOptions = acraerrors.New(acraerrors.CodeUnsupportedFilesystemOptions, "filesystem keystore doesn't support options")
+
+func init() {
+	keystore.RegisterBackend(keystore.DefaultBackendType, NewFilesystemBackend)
+}
+
+// NewFilesystemBackend creates FilesystemKeyStore as keystore backend, public keys are stored with private ones if
+// PublicKeysDir is empty
+func NewFilesystemBackend(params keystore.BackendParams) (keystore.Backend, error) {
+	if len(params.Options) != 0 {
+		return nil, ErrUnsupportedOptions
+	}
+	return newBackendKeyStore(params, fileStorage{})
+}
+
+// newBackendKeyStore creates FilesystemKeyStore with storage configured by params of keystore backend
+func newBackendKeyStore(params keystore.BackendParams, storage Storage) (*FilesystemKeyStore, error) {
+	publicKeysDir := params.PublicKeysDir
+	if publicKeysDir == "" {
+		publicKeysDir = params.PrivateKeysDir
+	}
+	store, err := newFilesystemKeyStore(params.PrivateKeysDir, publicKeysDir, params.Encryptor, params.CacheSize, storage)
+	if err != nil {
+		return nil, err
+	}
+	if params.EphemeralCacheKey && params.CacheSize != keystore.NO_CACHE {
+		store.cache, err = keystore.NewEphemeralKeyCache(store.cache)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// lockGeneration takes lock of key generation if storage is shared with other services and returns function which
+// releases it
+func (store *FilesystemKeyStore) lockGeneration() (func(), error) {
+	if locker, ok := store.storage.(generationLocker); ok {
+		return locker.LockGeneration()
+	}
+	return func() {}, nil
+}
+
 func (store *FilesystemKeyStore) generateKeyPair(filename string, clientID []byte) (*keys.Keypair, error) {
 	keypair, err := keys.New(keys.KEYTYPE_EC)
 	if err != nil {
 		return nil, err
 	}
+	unlock, err := store.lockGeneration()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 	privateKeysFolder := filepath.Dir(store.getPrivateKeyFilePath(filename))
-	err = os.MkdirAll(privateKeysFolder, 0700)
+	err = store.storage.MkdirAll(privateKeysFolder, 0700)
 	if err != nil {
 		return nil, err
 	}
 
 	publicKeysFolder := filepath.Dir(store.getPublicKeyFilePath(filename))
-	err = os.MkdirAll(publicKeysFolder, 0700)
+	err = store.storage.MkdirAll(publicKeysFolder, 0700)
 	if err != nil {
 		return nil, err
 	}
@@ -125,18 +177,30 @@ func (store *FilesystemKeyStore) generateKeyPair(filename string, clientID []byt
 	if err != nil {
 		return nil, err
 	}
-	err = ioutil.WriteFile(store.getPrivateKeyFilePath(filename), encryptedPrivate, 0600)
+	if err := store.backupHistoricalKey(filename); err != nil {
+		return nil, err
+	}
+	err = store.storage.WriteFile(store.getPrivateKeyFilePath(filename), encryptedPrivate, 0600)
 	if err != nil {
 		return nil, err
 	}
-	err = ioutil.WriteFile(store.getPublicKeyFilePath(fmt.Sprintf("%s.pub", filename)), keypair.Public.Value, 0644)
+	err = store.storage.WriteFile(store.getPublicKeyFilePath(fmt.Sprintf("%s.pub", filename)), keypair.Public.Value, 0644)
 	if err != nil {
 		return nil, err
 	}
+	if err := store.updateKeyCreation(filename); err != nil {
+		return nil, err
+	}
+	// replace cached previous version of key
+	store.lock.Lock()
+	store.cache.Add(filename, encryptedPrivate)
+	store.lock.Unlock()
+	log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeKeyGenerated, "key": filename}).Infoln("Generated new key pair")
 	return keypair, nil
 }
 
-func (store *FilesystemKeyStore) generateKey(filename string, length uint8) ([]byte, error) {
+// generateKey writes random key, existing key is overwritten if exclusive is false
+func (store *FilesystemKeyStore) generateKey(filename string, length uint8, exclusive bool) ([]byte, error) {
 	randomBytes := make([]byte, length)
 	_, err := rand.Read(randomBytes)
 	// Note that err == nil only if we read len(b) bytes.
@@ -144,17 +208,28 @@ func (store *FilesystemKeyStore) generateKey(filename string, length uint8) ([]b
 		log.Error(err)
 		return nil, err
 	}
+	unlock, err := store.lockGeneration()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	defer unlock()
 	dirpath := filepath.Dir(store.getPrivateKeyFilePath(filename))
-	err = os.MkdirAll(dirpath, 0700)
+	err = store.storage.MkdirAll(dirpath, 0700)
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
-	err = ioutil.WriteFile(store.getPrivateKeyFilePath(filename), randomBytes, 0600)
+	if exclusive {
+		err = store.storage.CreateFile(store.getPrivateKeyFilePath(filename), randomBytes, 0600)
+	} else {
+		err = store.storage.WriteFile(store.getPrivateKeyFilePath(filename), randomBytes, 0600)
+	}
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
+	log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeKeyGenerated, "key": filename}).Infoln("Generated new symmetric key")
 	return randomBytes, nil
 }
 
@@ -207,15 +282,18 @@ func (store *FilesystemKeyStore) getPrivateKeyByFilename(id []byte, filename str
 	store.lock.Lock()
 	defer store.lock.Unlock()
 	encryptedKey, ok := store.cache.Get(filename)
-	if !ok {
-		encryptedPrivateKey, err := utils.LoadPrivateKey(store.getPrivateKeyFilePath(filename))
+	var decryptedKey []byte
+	var err error
+	if ok {
+		decryptedKey, err = store.encryptor.Decrypt(encryptedKey, id)
+	} else {
+		var encryptedPrivateKey *keys.PrivateKey
+		encryptedPrivateKey, err = store.loadPrivateKey(store.getPrivateKeyFilePath(filename))
 		if err != nil {
 			return nil, err
 		}
-		encryptedKey = encryptedPrivateKey.Value
+		decryptedKey, encryptedKey, err = store.decryptStoredKey(store.getPrivateKeyFilePath(filename), encryptedPrivateKey.Value, id)
 	}
-
-	decryptedKey, err := store.encryptor.Decrypt(encryptedKey, id)
 	if err != nil {
 		return nil, err
 	}
@@ -248,7 +326,7 @@ func (store *FilesystemKeyStore) HasZonePrivateKey(id []byte) bool {
 	if ok {
 		return true
 	}
-	exists, _ := utils.FileExists(store.getPrivateKeyFilePath(fname))
+	exists, _ := fileExists(store.storage, store.getPrivateKeyFilePath(fname))
 	return exists
 }
 
@@ -265,13 +343,13 @@ func (store *FilesystemKeyStore) GetPeerPublicKey(id []byte) (*keys.PublicKey, e
 		log.Debugf("load cached key: %s", fname)
 		return &keys.PublicKey{Value: key}, nil
 	}
-	publicKey, err := utils.LoadPublicKey(store.getPublicKeyFilePath(fname))
+	publicKey, err := store.storage.ReadFile(store.getPublicKeyFilePath(fname))
 	if err != nil {
 		return nil, err
 	}
 	log.Debugf("load key from fs: %s", fname)
-	store.cache.Add(fname, publicKey.Value)
-	return publicKey, nil
+	store.cache.Add(fname, publicKey)
+	return &keys.PublicKey{Value: publicKey}, nil
 }
 
 // GetPrivateKey reads encrypted client private key from fs, decrypts it with master key and clientID,
@@ -350,6 +428,110 @@ func (store *FilesystemKeyStore) GenerateDataEncryptionKeys(id []byte) error {
 	return nil
 }
 
+// RotateStorageKeys generates new storage key pair of client, overwrites private key with new one keeping previous
+// as historical version and returns new public key
+func (store *FilesystemKeyStore) RotateStorageKeys(id []byte) ([]byte, error) {
+	if !keystore.ValidateID(id) {
+		return nil, keystore.ErrInvalidClientID
+	}
+	keypair, err := store.generateKeyPair(getServerDecryptionKeyFilename(id), id)
+	if err != nil {
+		return nil, err
+	}
+	utils.FillSlice(byte(0), keypair.Private.Value)
+	log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeKeyRotated, "client_id": string(id)}).Infoln("Rotated storage keys")
+	return keypair.Public.Value, nil
+}
+
+// GenerateHMACSecretKey generates symmetric key used to calculate searchable hashes of data
+// using clientID as part of key name.
+// Writes encrypted key to fs.
+// Returns error if generation/encryption failed.
+func (store *FilesystemKeyStore) GenerateHMACSecretKey(id []byte) error {
+	return store.generateEncryptedSymmetricKey(getHMACKeyFilename(id), id, keystore.HMACKeyLength)
+}
+
+// generateEncryptedSymmetricKey generates random key, encrypts it with master key and id as context and writes it to fs
+func (store *FilesystemKeyStore) generateEncryptedSymmetricKey(filename string, id []byte, length int) error {
+	if !keystore.ValidateID(id) {
+		return keystore.ErrInvalidClientID
+	}
+	key := make([]byte, length)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	encryptedKey, err := store.encryptor.Encrypt(key, id)
+	utils.FillSlice(byte(0), key)
+	if err != nil {
+		return err
+	}
+	unlock, err := store.lockGeneration()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	err = store.storage.MkdirAll(filepath.Dir(store.getPrivateKeyFilePath(filename)), 0700)
+	if err != nil {
+		return err
+	}
+	err = store.storage.WriteFile(store.getPrivateKeyFilePath(filename), encryptedKey, 0600)
+	if err != nil {
+		return err
+	}
+	if err := store.updateKeyCreation(filename); err != nil {
+		return err
+	}
+	store.lock.Lock()
+	store.cache.Add(filename, encryptedKey)
+	store.lock.Unlock()
+	log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeKeyGenerated, "key": filename}).Infoln("Generated new symmetric key")
+	return nil
+}
+
+// GetHMACSecretKey reads encrypted symmetric key used to calculate searchable hashes from fs,
+// decrypts it with master key and clientID,
+// and returns plaintext key, or reading/decryption error.
+func (store *FilesystemKeyStore) GetHMACSecretKey(id []byte) ([]byte, error) {
+	key, err := store.getPrivateKeyByFilename(id, getHMACKeyFilename(id))
+	if err != nil {
+		return nil, err
+	}
+	return key.Value, nil
+}
+
+// GenerateSymmetricKey generates symmetric key used to encrypt data with Themis Secure Cell using clientID as part of
+// key name. Writes encrypted key to fs.
+// Returns error if generation/encryption failed.
+func (store *FilesystemKeyStore) GenerateSymmetricKey(id []byte) error {
+	return store.generateEncryptedSymmetricKey(getSymmetricKeyFilename(id), id, keystore.SymmetricKeyLength)
+}
+
+// GetSymmetricKey reads encrypted symmetric key used with Themis Secure Cell from fs, decrypts it with master key and
+// clientID, and returns plaintext key, or reading/decryption error.
+func (store *FilesystemKeyStore) GetSymmetricKey(id []byte) ([]byte, error) {
+	key, err := store.getPrivateKeyByFilename(id, getSymmetricKeyFilename(id))
+	if err != nil {
+		return nil, err
+	}
+	return key.Value, nil
+}
+
+// GenerateComparatorSecret generates secret shared with AcraConnector of client id to authenticate it with Secure
+// Comparator, encrypts it with master key and writes to fs
+func (store *FilesystemKeyStore) GenerateComparatorSecret(id []byte) error {
+	return store.generateEncryptedSymmetricKey(getComparatorSecretFilename(id), id, keystore.SymmetricKeyLength)
+}
+
+// GetComparatorSecret reads secret shared with AcraConnector of client id from fs and returns it decrypted with
+// master key
+func (store *FilesystemKeyStore) GetComparatorSecret(id []byte) ([]byte, error) {
+	key, err := store.getPrivateKeyByFilename(id, getComparatorSecretFilename(id))
+	if err != nil {
+		return nil, err
+	}
+	return key.Value, nil
+}
+
 // Reset clears all cached keys
 func (store *FilesystemKeyStore) Reset() {
 	store.cache.Clear()
@@ -359,32 +541,89 @@ func (store *FilesystemKeyStore) Reset() {
 // encrypting private key or reads existing keypair from fs.
 // Returns keypair or error if generation/decryption failed.
 func (store *FilesystemKeyStore) GetPoisonKeyPair() (*keys.Keypair, error) {
+	keypair, err := store.loadPoisonKeyPair()
+	if !os.IsNotExist(err) {
+		return keypair, err
+	}
+	log.Infoln("Generate poison key pair")
+	keypair, err = store.createPoisonKeyPair()
+	if !os.IsExist(err) {
+		return keypair, err
+	}
+	// other service which shares storage generated key pair concurrently, wait until it writes public key
+	for i := 0; i < poisonKeyWaitRetries; i++ {
+		time.Sleep(poisonKeyWaitInterval)
+		if keypair, err := store.loadPoisonKeyPair(); !os.IsNotExist(err) {
+			return keypair, err
+		}
+	}
+	log.Warningln("Poison private key exists without public key, generate new poison key pair")
+	return store.generateKeyPair(POISON_KEY_FILENAME, []byte(POISON_KEY_FILENAME))
+}
+
+// Wait of public poison key written by other service which shares storage
+const (
+	poisonKeyWaitRetries  = 10
+	poisonKeyWaitInterval = time.Millisecond * 100
+)
+
+// loadPoisonKeyPair reads poison key pair, returns error satisfying os.IsNotExist if private or public key is missing
+func (store *FilesystemKeyStore) loadPoisonKeyPair() (*keys.Keypair, error) {
+	private, err := store.loadPrivateKey(store.getPrivateKeyFilePath(POISON_KEY_FILENAME))
+	if err != nil {
+		return nil, err
+	}
+	public, err := store.storage.ReadFile(store.getPublicKeyFilePath(fmt.Sprintf("%s.pub", POISON_KEY_FILENAME)))
+	if err != nil {
+		return nil, err
+	}
+	if private.Value, _, err = store.decryptStoredKey(store.getPrivateKeyFilePath(POISON_KEY_FILENAME), private.Value, []byte(POISON_KEY_FILENAME)); err != nil {
+		return nil, err
+	}
+	return &keys.Keypair{Public: &keys.PublicKey{Value: public}, Private: private}, nil
+}
+
+// createPoisonKeyPair generates and writes poison key pair if private key doesn't exist, returns error satisfying
+// os.IsExist if it was created by other service
+func (store *FilesystemKeyStore) createPoisonKeyPair() (*keys.Keypair, error) {
+	keypair, err := keys.New(keys.KEYTYPE_EC)
+	if err != nil {
+		return nil, err
+	}
 	privatePath := store.getPrivateKeyFilePath(POISON_KEY_FILENAME)
 	publicPath := store.getPublicKeyFilePath(fmt.Sprintf("%s.pub", POISON_KEY_FILENAME))
-	privateExists, err := utils.FileExists(privatePath)
-	if err != nil {
+	if err := store.storage.MkdirAll(filepath.Dir(privatePath), 0700); err != nil {
+		return nil, err
+	}
+	if err := store.storage.MkdirAll(filepath.Dir(publicPath), 0700); err != nil {
 		return nil, err
 	}
-	publicExists, err := utils.FileExists(publicPath)
+	encryptedPrivate, err := store.encryptor.Encrypt(keypair.Private.Value, []byte(POISON_KEY_FILENAME))
 	if err != nil {
 		return nil, err
 	}
-	if privateExists && publicExists {
-		private, err := utils.LoadPrivateKey(privatePath)
-		if err != nil {
-			return nil, err
-		}
-		if private.Value, err = store.encryptor.Decrypt(private.Value, []byte(POISON_KEY_FILENAME)); err != nil {
-			return nil, err
-		}
-		public, err := utils.LoadPublicKey(publicPath)
-		if err != nil {
-			return nil, err
-		}
-		return &keys.Keypair{Public: public, Private: private}, nil
+	if err := store.storage.CreateFile(privatePath, encryptedPrivate, 0600); err != nil {
+		return nil, err
 	}
-	log.Infoln("Generate poison key pair")
-	return store.generateKeyPair(POISON_KEY_FILENAME, []byte(POISON_KEY_FILENAME))
+	if err := store.storage.WriteFile(publicPath, keypair.Public.Value, 0644); err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeKeyGenerated, "key": POISON_KEY_FILENAME}).Infoln("Generated new key pair")
+	return keypair, nil
+}
+
+// loadPrivateKey reads encrypted private key, key file should be readable only by owner
+func (store *FilesystemKeyStore) loadPrivateKey(path string) (*keys.PrivateKey, error) {
+	fi, err := store.storage.Stat(path)
+	if nil == err && runtime.GOOS == "linux" && fi.Mode().Perm().String() != "-rw-------" && fi.Mode().Perm().String() != "-r--------" {
+		log.Errorf("private key file %v has incorrect permissions", path)
+		return nil, fmt.Errorf("error: private key file %v has incorrect permissions", path)
+	}
+	key, err := store.storage.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &keys.PrivateKey{Value: key}, nil
 }
 
 // GetAuthKey generates basic auth key for acraWebconfig, and writes it encrypted to fs,
@@ -392,13 +631,13 @@ func (store *FilesystemKeyStore) GetPoisonKeyPair() (*keys.Keypair, error) {
 // Returns key or error of generation/decryption failed.
 func (store *FilesystemKeyStore) GetAuthKey(remove bool) ([]byte, error) {
 	keyPath := store.getPrivateKeyFilePath(BASIC_AUTH_KEY_FILENAME)
-	keyExists, err := utils.FileExists(keyPath)
+	keyExists, err := fileExists(store.storage, keyPath)
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
 	if keyExists && !remove {
-		key, err := utils.ReadFile(keyPath)
+		key, err := store.storage.ReadFile(keyPath)
 		if err != nil {
 			log.Error(err)
 			return nil, err
@@ -406,11 +645,39 @@ func (store *FilesystemKeyStore) GetAuthKey(remove bool) ([]byte, error) {
 		return key, nil
 	}
 	log.Infof("Generate basic auth key for AcraWebconfig to %v", keyPath)
-	return store.generateKey(BASIC_AUTH_KEY_FILENAME, keystore.BasicAuthKeyLength)
+	key, err := store.generateKey(BASIC_AUTH_KEY_FILENAME, keystore.BasicAuthKeyLength, !remove)
+	if os.IsExist(err) {
+		// other service which shares storage generated key concurrently
+		return store.storage.ReadFile(keyPath)
+	}
+	return key, err
 }
 
-// RotateZoneKey generate new key pair for ZoneId, overwrite private key with new and return new public key
+// SaveAuthKey overwrites key of auth file with key, which data of auth file already encrypted with
+func (store *FilesystemKeyStore) SaveAuthKey(key []byte) error {
+	unlock, err := store.lockGeneration()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	keyPath := store.getPrivateKeyFilePath(BASIC_AUTH_KEY_FILENAME)
+	if err := store.storage.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return err
+	}
+	if err := store.storage.WriteFile(keyPath, key, 0600); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeKeyGenerated, "key": BASIC_AUTH_KEY_FILENAME}).Infoln("Saved new symmetric key")
+	return nil
+}
+
+// RotateZoneKey generate new key pair for ZoneId, overwrite private key with new and return new public key. Previous
+// private key is kept as historical version
 func (store *FilesystemKeyStore) RotateZoneKey(zoneID []byte) ([]byte, error) {
 	_, public, err := store.generateZoneKey(zoneID)
-	return public, err
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeKeyRotated, "zone_id": string(zoneID)}).Infoln("Rotated zone key")
+	return public, nil
 }
@@ -149,6 +149,37 @@ func testGenerateTranslatorKeys(store *FilesystemKeyStore, t *testing.T) {
 	}
 }
 
+func testGenerateHMACSecretKey(store *FilesystemKeyStore, t *testing.T) {
+	testID := []byte("test hmac id")
+	err := store.GenerateHMACSecretKey(testID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	absPath := store.getPrivateKeyFilePath(getHMACKeyFilename(testID))
+	exists, err := utils.FileExists(absPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal(fmt.Sprintf("File <%s> doesn't exists", absPath))
+	}
+	key, err := store.GetHMACSecretKey(testID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != keystore.HMACKeyLength {
+		t.Fatal("Incorrect length of HMAC key")
+	}
+	// key must be stored in encrypted form
+	rawKey, err := ioutil.ReadFile(absPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(rawKey, key) {
+		t.Fatal("HMAC key stored in plaintext")
+	}
+}
+
 func testGenerateConnectorKeys(store *FilesystemKeyStore, t *testing.T) {
 	testID := []byte("test id")
 	err := store.GenerateConnectorKeys(testID)
@@ -232,6 +263,7 @@ func TestFilesystemKeyStore(t *testing.T) {
 		testGenerateConnectorKeys(store, t)
 		testGenerateServerKeys(store, t)
 		testGenerateTranslatorKeys(store, t)
+		testGenerateHMACSecretKey(store, t)
 		testReset(store, t)
 		testGenerateKeyPair(store, t)
 	}
@@ -352,3 +384,89 @@ func TestFilesystemKeyStore_RotateZoneKey(t *testing.T) {
 		t.Fatal("Private key the same as rotated")
 	}
 }
+
+func TestFilesystemKeyStore_ExportImportPrivateKey(t *testing.T) {
+	keyDirectory, err := ioutil.TempDir("", "test_filesystem_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keyDirectory)
+	encryptor, err := keystore.NewSCellKeyEncryptor([]byte("some key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyStore, err := NewFilesystemKeyStore(keyDirectory, encryptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientID := []byte("some client id")
+	if err := keyStore.GenerateDataEncryptionKeys(clientID); err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := keyStore.GetServerDecryptionPrivateKey(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := keyStore.ExportPrivateKey(keystore.KeyPurposeStorage, clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(exported, privateKey.Value) {
+		t.Fatal("Exported key differs from stored key")
+	}
+	if _, err := keyStore.ExportPrivateKey(keystore.KeyPurposeAuth, clientID); err != keystore.ErrUnsupportedKeyPurpose {
+		t.Fatalf("Expected ErrUnsupportedKeyPurpose, took %v", err)
+	}
+
+	// import into another keystore with same master key
+	importDirectory, err := ioutil.TempDir("", "test_filesystem_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(importDirectory)
+	importKeyStore, err := NewFilesystemKeyStore(importDirectory, encryptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := importKeyStore.ImportPrivateKey(keystore.KeyPurposeStorage, clientID, exported); err != nil {
+		t.Fatal(err)
+	}
+	importKeyStore.Reset()
+	importedKey, err := importKeyStore.GetServerDecryptionPrivateKey(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(importedKey.Value, privateKey.Value) {
+		t.Fatal("Imported key differs from exported key")
+	}
+}
+
+func TestFilesystemBackend(t *testing.T) {
+	privateKeyDirectory, err := ioutil.TempDir("", "backend_keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(privateKeyDirectory)
+	encryptor, err := keystore.NewSCellKeyEncryptor([]byte("some key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := keystore.BackendParams{PrivateKeysDir: privateKeyDirectory, Encryptor: encryptor, CacheSize: keystore.NO_CACHE}
+	backend, err := keystore.NewBackend(keystore.DefaultBackendType, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, ok := backend.(*FilesystemKeyStore)
+	if !ok {
+		t.Fatalf("Expected FilesystemKeyStore, took %T", backend)
+	}
+	if store.publicKeyDirectory != privateKeyDirectory {
+		t.Fatal("Public keys should be stored with private keys by default")
+	}
+	testGeneral(store, t)
+
+	params.Options = map[string]string{"address": "127.0.0.1"}
+	if _, err := keystore.NewBackend(keystore.DefaultBackendType, params); err != ErrUnsupportedOptions {
+		t.Fatalf("Expected ErrUnsupportedOptions, took %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cossacklabs/acra/utils"
+)
+
+// Storage is place where FilesystemKeyStore keeps files of keys. Keys are stored in local file system by default,
+// other storages let several services share same keys without syncing folders
+type Storage interface {
+	// Stat returns info of file, error satisfies os.IsNotExist if file doesn't exist
+	Stat(path string) (os.FileInfo, error)
+	ReadFile(path string) ([]byte, error)
+	// ReadDir returns files of directory ordered by name, error satisfies os.IsNotExist if directory doesn't exist
+	ReadDir(path string) ([]os.FileInfo, error)
+	MkdirAll(path string, perm os.FileMode) error
+	WriteFile(path string, data []byte, perm os.FileMode) error
+	// CreateFile writes file only if it doesn't exist yet and returns error satisfying os.IsExist otherwise, so only
+	// one of concurrent writers creates file
+	CreateFile(path string, data []byte, perm os.FileMode) error
+	// ReplaceFile atomically replaces file with data which has modification time modifiedAt
+	ReplaceFile(path string, data []byte, perm os.FileMode, modifiedAt time.Time) error
+	// RemoveAll removes file or directory with all its files, missing path isn't error
+	RemoveAll(path string) error
+}
+
+// fileExists returns true if file exists in storage
+func fileExists(storage Storage, path string) (bool, error) {
+	if _, err := storage.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// fileStorage is Storage which keeps keys in local file system, paths may start with ~
+type fileStorage struct{}
+
+// Stat returns info of file
+func (fileStorage) Stat(path string) (os.FileInfo, error) {
+	absPath, err := utils.AbsPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(absPath)
+}
+
+// ReadFile returns content of file
+func (fileStorage) ReadFile(path string) ([]byte, error) {
+	return utils.ReadFile(path)
+}
+
+// ReadDir returns files of directory ordered by name
+func (fileStorage) ReadDir(path string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(path)
+}
+
+// MkdirAll creates directory with all parents
+func (fileStorage) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+// WriteFile writes data to file creating it if it doesn't exist
+func (fileStorage) WriteFile(path string, data []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(path, data, perm)
+}
+
+// RemoveAll removes file or directory with all its files
+func (fileStorage) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+// CreateFile writes data to new file, returns error if file exists
+func (fileStorage) CreateFile(path string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// ReplaceFile writes data to temporary file in same folder and renames it to path
+func (fileStorage) ReplaceFile(path string, data []byte, perm os.FileMode, modifiedAt time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	_, err = tmpFile.Write(data)
+	if syncErr := tmpFile.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, perm)
+	}
+	if err == nil {
+		err = os.Chtimes(tmpPath, modifiedAt, modifiedAt)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
+
+// generationLocker is implemented by storages shared by several services which serialize generation of keys between
+// services, so concurrent services don't overwrite keys generated by each other
+type generationLocker interface {
+	// LockGeneration blocks until lock of key generation taken and returns function which releases it
+	LockGeneration() (func(), error)
+}
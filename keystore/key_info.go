@@ -0,0 +1,105 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/cossacklabs/acra/acraerrors"
+)
+
+// Purposes of keys stored in keystore
+const (
+	KeyPurposeZone                = "zone"
+	KeyPurposeStorage             = "storage"
+	KeyPurposeServerTransport     = "server_transport"
+	KeyPurposeTranslatorTransport = "translator_transport"
+	KeyPurposeConnectorTransport  = "connector_transport"
+	KeyPurposeHMAC                = "hmac"
+	KeyPurposeSymmetric           = "symmetric"
+	KeyPurposePoison              = "poison"
+	KeyPurposeAuth                = "auth"
+	KeyPurposeComparatorSecret    = "comparator_secret"
+)
+
+// ErrInvalidKeyName returned if requested key name isn't name of public key stored in keystore
+var ErrInvalidKeyName = acraerrors.New(acraerrors.CodeInvalidKeyName, "invalid name of public key")
+
+// KeyInfo describes stored key without its value
+type KeyInfo struct {
+	// Name of key in keystore
+	Name    string
+	Purpose string
+	// ID is client id or zone id which key belongs to
+	ID     string
+	Public bool
+	// Fingerprint is hex encoded SHA-256 hash of public key, empty for private and symmetric keys
+	Fingerprint string
+	ModifiedAt  time.Time
+	// CreatedAt is time of generation or import of key, zero if keystore doesn't store metadata of key
+	CreatedAt time.Time
+	// ExpiresAt is end of lifetime set for key, zero if key doesn't expire
+	ExpiresAt time.Time
+}
+
+// KeyLister is implemented by keystores which can list stored keys and return public keys by name
+type KeyLister interface {
+	ListKeys() ([]KeyInfo, error)
+	GetPublicKeyByName(name string) ([]byte, error)
+}
+
+// PublicKeyFingerprint returns hex encoded SHA-256 hash of public key
+func PublicKeyFingerprint(publicKey []byte) string {
+	hash := sha256.Sum256(publicKey)
+	return hex.EncodeToString(hash[:])
+}
+
+// ErrKeyExpired returned if expired key is used to encrypt new data
+var ErrKeyExpired = acraerrors.New(acraerrors.CodeKeyExpired, "key is expired and should be rotated")
+
+// ErrInvalidKeyTTL returned if lifetime of key is negative
+var ErrInvalidKeyTTL = acraerrors.New(acraerrors.CodeInvalidKeyTTL, "lifetime of key can't be negative")
+
+// KeyTTLSetter is implemented by keystores which store lifetime of keys. Lifetime is counted from creation of key and
+// is kept for new versions of key after rotation
+type KeyTTLSetter interface {
+	// SetKeyTTL sets lifetime of key with purpose of client or zone id, zero ttl removes expiration
+	SetKeyTTL(purpose string, id []byte, ttl time.Duration) error
+}
+
+// KeyExpiryChecker answers whether key with purpose of client or zone id is expired
+type KeyExpiryChecker interface {
+	IsKeyExpired(purpose string, id []byte) bool
+}
+
+// ErrUnsupportedKeyPurpose returned if keystore can't export or import keys with requested purpose
+var ErrUnsupportedKeyPurpose = acraerrors.New(acraerrors.CodeUnsupportedKeyPurpose, "unsupported purpose of key")
+
+// KeyExporter is implemented by keystores which can export plaintext private keys and import them back, e.g. for
+// key escrow
+type KeyExporter interface {
+	ExportPrivateKey(purpose string, id []byte) ([]byte, error)
+	ImportPrivateKey(purpose string, id, key []byte) error
+}
+
+// KeyDestroyer is implemented by keystores which can remove keys with all their previous versions, so data encrypted
+// with them can't be decrypted anymore
+type KeyDestroyer interface {
+	DestroyKey(purpose string, id []byte) error
+}
@@ -20,13 +20,15 @@ limitations under the License.
 package keystore
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
-	"errors"
 	"fmt"
 	"os"
 	"strings"
 
+	"github.com/cossacklabs/acra/acraerrors"
 	"github.com/cossacklabs/themis/gothemis/cell"
 	"github.com/cossacklabs/themis/gothemis/keys"
 )
@@ -40,15 +42,31 @@ const (
 	MinClientIdLength    = 5
 	BasicAuthKeyLength   = 32
 	AcraMasterKeyVarName = "ACRA_MASTER_KEY"
+	// AcraPreviousMasterKeyVarName is name of environment variable with master key replaced by AcraMasterKeyVarName
+	// which still decrypts keys not rewrapped yet during rotation of master key
+	AcraPreviousMasterKeyVarName = "ACRA_PREVIOUS_MASTER_KEY"
+	// AcraMasterKeyIDVarName is name of environment variable with ID of master key from AcraMasterKeyVarName recorded
+	// in every key encrypted with it
+	AcraMasterKeyIDVarName = "ACRA_MASTER_KEY_ID"
+	// AcraMasterKeysVarName is name of environment variable with comma separated 'id:base64 key' pairs of other master
+	// keys which still decrypt keys recorded as encrypted with them
+	AcraMasterKeysVarName = "ACRA_MASTER_KEYS"
+	// AcraKeyEnvironmentVarName is name of environment variable with label of environment mixed into master key
+	AcraKeyEnvironmentVarName = "ACRA_KEY_ENVIRONMENT"
+	// MaxKeyEnvironmentLength is max length of environment label
+	MaxKeyEnvironmentLength = 64
 	// SymmetricKeyLength in bytes for master key
 	SymmetricKeyLength = 32
+	// HMACKeyLength in bytes for keys used to calculate searchable hashes
+	HMACKeyLength = 32
 )
 
 // Errors returned during accessing to client id or master key.
 var (
-	ErrInvalidClientID          = errors.New("invalid client ID")
-	ErrEmptyMasterKey           = errors.New("master key is empty")
-	ErrMasterKeyIncorrectLength = fmt.Errorf("master key must have %v length in bytes", SymmetricKeyLength)
+	ErrInvalidClientID          = acraerrors.New(acraerrors.CodeInvalidClientID, "invalid client ID")
+	ErrEmptyMasterKey           = acraerrors.New(acraerrors.CodeEmptyMasterKey, "master key is empty")
+	ErrMasterKeyIncorrectLength = acraerrors.New(acraerrors.CodeMasterKeyIncorrectLength, fmt.Sprintf("master key must have %v length in bytes", SymmetricKeyLength))
+	ErrInvalidKeyEnvironment    = acraerrors.New(acraerrors.CodeInvalidKeyEnvironment, fmt.Sprintf("environment label must contain only letters, digits, '-', '_', '.' and be not longer than %v", MaxKeyEnvironmentLength))
 )
 
 // GenerateSymmetricKey return new generated symmetric key that must used in keystore as master key and will comply
@@ -90,7 +108,20 @@ func ValidateMasterKey(key []byte) error {
 
 // GetMasterKeyFromEnvironment return master key from environment variable with name AcraMasterKeyVarName
 func GetMasterKeyFromEnvironment() (key []byte, err error) {
-	b64value := os.Getenv(AcraMasterKeyVarName)
+	return getMasterKeyFromVariable(AcraMasterKeyVarName)
+}
+
+// GetPreviousMasterKeyFromEnvironment returns previous master key from environment variable with name
+// AcraPreviousMasterKeyVarName or nil if master key isn't rotated
+func GetPreviousMasterKeyFromEnvironment() ([]byte, error) {
+	if os.Getenv(AcraPreviousMasterKeyVarName) == "" {
+		return nil, nil
+	}
+	return getMasterKeyFromVariable(AcraPreviousMasterKeyVarName)
+}
+
+func getMasterKeyFromVariable(name string) (key []byte, err error) {
+	b64value := os.Getenv(name)
 	if len(b64value) == 0 {
 		return nil, ErrEmptyMasterKey
 	}
@@ -101,7 +132,85 @@ func GetMasterKeyFromEnvironment() (key []byte, err error) {
 	if err = ValidateMasterKey(key); err != nil {
 		return
 	}
-	return
+	return DeriveEnvironmentMasterKey(key, GetKeyEnvironment())
+}
+
+// MasterKeyLoader returns master key used to encrypt keys of keystore from some source
+type MasterKeyLoader interface {
+	LoadMasterKey() ([]byte, error)
+}
+
+// EnvironmentMasterKeyLoader loads master key from AcraMasterKeyVarName environment variable
+type EnvironmentMasterKeyLoader struct{}
+
+// LoadMasterKey returns master key from environment variable
+func (EnvironmentMasterKeyLoader) LoadMasterKey() ([]byte, error) {
+	return GetMasterKeyFromEnvironment()
+}
+
+// KMSMasterKeyLoader loads master key encrypted with key of key management service
+type KMSMasterKeyLoader struct {
+	decrypter    KMSDecrypter
+	keyID        string
+	encryptedKey []byte
+}
+
+// NewKMSMasterKeyLoader returns loader which decrypts encryptedKey with key keyID of key management service
+func NewKMSMasterKeyLoader(decrypter KMSDecrypter, keyID string, encryptedKey []byte) *KMSMasterKeyLoader {
+	return &KMSMasterKeyLoader{decrypter: decrypter, keyID: keyID, encryptedKey: encryptedKey}
+}
+
+// LoadMasterKey returns master key decrypted with key management service
+func (loader *KMSMasterKeyLoader) LoadMasterKey() ([]byte, error) {
+	return GetMasterKeyFromKMS(loader.decrypter, loader.keyID, loader.encryptedKey)
+}
+
+// KMSDecrypter decrypts data encrypted with key of external key management service
+type KMSDecrypter interface {
+	Decrypt(keyID string, ciphertext []byte) ([]byte, error)
+}
+
+// GetMasterKeyFromKMS returns master key encrypted with key keyID of key management service (envelope encryption), so
+// plaintext master key isn't stored in environment variables which leak into process listings and crash dumps
+func GetMasterKeyFromKMS(decrypter KMSDecrypter, keyID string, encryptedKey []byte) ([]byte, error) {
+	if len(encryptedKey) == 0 {
+		return nil, ErrEmptyMasterKey
+	}
+	key, err := decrypter.Decrypt(keyID, encryptedKey)
+	if err != nil {
+		return nil, err
+	}
+	if err = ValidateMasterKey(key); err != nil {
+		return nil, err
+	}
+	return DeriveEnvironmentMasterKey(key, GetKeyEnvironment())
+}
+
+// GetKeyEnvironment returns label of environment from environment variable with name AcraKeyEnvironmentVarName
+func GetKeyEnvironment() string {
+	return os.Getenv(AcraKeyEnvironmentVarName)
+}
+
+// keyEnvironmentDomain separates derivation of environment master keys from other usages of HMAC with master key
+const keyEnvironmentDomain = "acra key environment\x00"
+
+// DeriveEnvironmentMasterKey returns master key separated by environment label, so keys encrypted in one environment
+// (e.g. staging) can't be decrypted in another one (e.g. production) even if same master key is reused. Returns master
+// key as is if environment is empty to be compatible with existing keystores.
+func DeriveEnvironmentMasterKey(masterKey []byte, environment string) ([]byte, error) {
+	if environment == "" {
+		return masterKey, nil
+	}
+	if len(environment) > MaxKeyEnvironmentLength {
+		return nil, ErrInvalidKeyEnvironment
+	}
+	if !isLabel(environment) {
+		return nil, ErrInvalidKeyEnvironment
+	}
+	mac := hmac.New(sha256.New, masterKey)
+	mac.Write([]byte(keyEnvironmentDomain))
+	mac.Write([]byte(environment))
+	return mac.Sum(nil), nil
 }
 
 // KeyEncryptor describes Encrypt and Decrypt interfaces.
@@ -146,10 +255,15 @@ type KeyStore interface {
 	GetZonePrivateKey(id []byte) (*keys.PrivateKey, error)
 	HasZonePrivateKey(id []byte) bool
 	GetServerDecryptionPrivateKey(id []byte) (*keys.PrivateKey, error)
+	// return all versions of private keys from current to oldest to decrypt data encrypted before key rotation
+	GetZonePrivateKeys(id []byte) ([]*keys.PrivateKey, error)
+	GetServerDecryptionPrivateKeys(id []byte) ([]*keys.PrivateKey, error)
 	// return id, public key, error
 	GenerateZoneKey() ([]byte, []byte, error)
 	// return new_public_key, error
 	RotateZoneKey(zoneID []byte) ([]byte, error)
+	// return new_public_key, error
+	RotateStorageKeys(id []byte) ([]byte, error)
 
 	GenerateConnectorKeys(id []byte) error
 	GenerateServerKeys(id []byte) error
@@ -160,5 +274,33 @@ type KeyStore interface {
 	GetPoisonKeyPair() (*keys.Keypair, error)
 
 	GetAuthKey(remove bool) ([]byte, error)
+
+	// generate and return symmetric key used to calculate searchable hashes of data
+	GenerateHMACSecretKey(id []byte) error
+	GetHMACSecretKey(id []byte) ([]byte, error)
 	Reset()
 }
+
+// SymmetricKeyStore describes KeyStore which stores symmetric keys of clients used to encrypt data with Themis
+// Secure Cell by applications which use Themis without AcraStructs
+type SymmetricKeyStore interface {
+	GenerateSymmetricKey(id []byte) error
+	GetSymmetricKey(id []byte) ([]byte, error)
+}
+
+// ComparatorSecretKeyStore describes KeyStore which stores secrets shared by AcraServer and AcraConnectors to
+// authenticate connectors with Themis Secure Comparator
+type ComparatorSecretKeyStore interface {
+	GenerateComparatorSecret(id []byte) error
+	GetComparatorSecret(id []byte) ([]byte, error)
+}
+
+// getComparatorSecret returns secret from store wrapped by RevocationKeyStore or AuditKeyStore or
+// ErrUnsupportedKeyPurpose if store doesn't keep comparator secrets
+func getComparatorSecret(store KeyStore, id []byte) ([]byte, error) {
+	comparatorStore, ok := store.(ComparatorSecretKeyStore)
+	if !ok {
+		return nil, ErrUnsupportedKeyPurpose
+	}
+	return comparatorStore.GetComparatorSecret(id)
+}
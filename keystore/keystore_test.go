@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"encoding/base64"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -114,3 +115,146 @@ func TestGetMasterKeyFromEnvironment(t *testing.T) {
 		}
 	}
 }
+
+func TestDeriveEnvironmentMasterKey(t *testing.T) {
+	key, err := GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameKey, err := DeriveEnvironmentMasterKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sameKey, key) {
+		t.Fatal("Master key without environment was changed")
+	}
+	stagingKey, err := DeriveEnvironmentMasterKey(key, "staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	productionKey, err := DeriveEnvironmentMasterKey(key, "production")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(stagingKey, key) || bytes.Equal(stagingKey, productionKey) {
+		t.Fatal("Master keys of environments aren't separated")
+	}
+	if len(stagingKey) != SymmetricKeyLength {
+		t.Fatalf("Incorrect length of derived key %v", len(stagingKey))
+	}
+	stagingEncryptor, err := NewSCellKeyEncryptor(stagingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	productionEncryptor, err := NewSCellKeyEncryptor(productionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := stagingEncryptor.Encrypt([]byte("private key"), []byte("client"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := productionEncryptor.Decrypt(encrypted, []byte("client")); err == nil {
+		t.Fatal("Key of staging was decrypted in production")
+	}
+	for _, environment := range []string{"prod env", "prod/1", strings.Repeat("a", MaxKeyEnvironmentLength+1)} {
+		if _, err := DeriveEnvironmentMasterKey(key, environment); err != ErrInvalidKeyEnvironment {
+			t.Errorf("Expected ErrInvalidKeyEnvironment for '%s', took %v", environment, err)
+		}
+	}
+
+	if err := os.Setenv(AcraMasterKeyVarName, base64.StdEncoding.EncodeToString(key)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Setenv(AcraKeyEnvironmentVarName, "staging"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv(AcraKeyEnvironmentVarName)
+	envKey, err := GetMasterKeyFromEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(envKey, stagingKey) {
+		t.Fatal("Master key from environment isn't separated by environment label")
+	}
+}
+
+func TestParseBackendOptions(t *testing.T) {
+	options, err := ParseBackendOptions(" address=127.0.0.1:6379, db=1,,prefix= ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(options) != 3 || options["address"] != "127.0.0.1:6379" || options["db"] != "1" || options["prefix"] != "" {
+		t.Fatalf("Unexpected options %v", options)
+	}
+	for _, value := range []string{"address", "=1"} {
+		if _, err := ParseBackendOptions(value); err == nil {
+			t.Fatalf("Expected error for %q", value)
+		}
+	}
+}
+
+func TestNewBackend(t *testing.T) {
+	if _, err := NewBackend("unknown", BackendParams{}); err != ErrUnknownBackendType {
+		t.Fatalf("Expected ErrUnknownBackendType, took %v", err)
+	}
+	RegisterBackend("test", func(params BackendParams) (Backend, error) {
+		return nil, ErrEmptyMasterKey
+	})
+	if _, err := NewBackend("test", BackendParams{}); err != ErrEmptyMasterKey {
+		t.Fatalf("Expected error of factory, took %v", err)
+	}
+	found := false
+	for _, backendType := range BackendTypes() {
+		found = found || backendType == "test"
+	}
+	if !found {
+		t.Fatal("Registered backend isn't listed")
+	}
+}
+
+type testKMSDecrypter struct {
+	keyID string
+	key   []byte
+}
+
+func (decrypter testKMSDecrypter) Decrypt(keyID string, ciphertext []byte) ([]byte, error) {
+	if keyID != decrypter.keyID || !bytes.Equal(ciphertext, []byte("encrypted")) {
+		return nil, ErrInvalidClientID
+	}
+	return decrypter.key, nil
+}
+
+func TestGetMasterKeyFromKMS(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, SymmetricKeyLength)
+	decrypter := testKMSDecrypter{keyID: "alias/acra", key: key}
+	masterKey, err := GetMasterKeyFromKMS(decrypter, "alias/acra", []byte("encrypted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(masterKey, key) {
+		t.Fatal("Unexpected master key")
+	}
+	if _, err := GetMasterKeyFromKMS(decrypter, "alias/acra", nil); err != ErrEmptyMasterKey {
+		t.Fatalf("Expected ErrEmptyMasterKey, took %v", err)
+	}
+	if _, err := GetMasterKeyFromKMS(decrypter, "alias/other", []byte("encrypted")); err == nil {
+		t.Fatal("Expected error of KMS")
+	}
+	decrypter.key = key[:SymmetricKeyLength-1]
+	if _, err := GetMasterKeyFromKMS(decrypter, "alias/acra", []byte("encrypted")); err != ErrMasterKeyIncorrectLength {
+		t.Fatalf("Expected ErrMasterKeyIncorrectLength, took %v", err)
+	}
+}
+
+func TestKMSMasterKeyLoader(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, SymmetricKeyLength)
+	var loader MasterKeyLoader = NewKMSMasterKeyLoader(testKMSDecrypter{keyID: "alias/acra", key: key}, "alias/acra", []byte("encrypted"))
+	masterKey, err := loader.LoadMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(masterKey, key) {
+		t.Fatal("Unexpected master key")
+	}
+}
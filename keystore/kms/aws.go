@@ -0,0 +1,251 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package kms implements clients of key management services used to decrypt master key encrypted with KMS key
+// (envelope encryption), so plaintext master key isn't passed to Acra services.
+package kms
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cossacklabs/acra/acraerrors"
+)
+
+// requestTimeout limits time of requests to key management services
+const requestTimeout = 30 * time.Second
+
+// Environment variables with AWS credentials and region, same as used by AWS CLI
+const (
+	AWSAccessKeyIDVarName     = "AWS_ACCESS_KEY_ID"
+	AWSSecretAccessKeyVarName = "AWS_SECRET_ACCESS_KEY"
+	AWSSessionTokenVarName    = "AWS_SESSION_TOKEN"
+	AWSRegionVarName          = "AWS_REGION"
+	AWSDefaultRegionVarName   = "AWS_DEFAULT_REGION"
+)
+
+// Errors returned by AWS KMS client
+var (
+	ErrNoAWSCredentials = acraerrors.New(acraerrors.CodeNoAWSCredentials, "AWS credentials not found in AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	ErrNoAWSRegion      = acraerrors.New(acraerrors.CodeNoAWSRegion, "AWS region not found in AWS_REGION or AWS_DEFAULT_REGION")
+)
+
+const (
+	awsKMSService      = "kms"
+	awsKMSTargetPrefix = "TrentService."
+	awsJSONContentType = "application/x-amz-json-1.1"
+	awsSigningAlgo     = "AWS4-HMAC-SHA256"
+	awsTimeFormat      = "20060102T150405Z"
+	awsDateFormat      = "20060102"
+)
+
+// AWSCredentials are keys used to sign requests to AWS
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// AWSClient decrypts data with AWS KMS. Requests are signed with Signature Version 4
+type AWSClient struct {
+	region      string
+	service     string
+	endpoint    string
+	credentials AWSCredentials
+	httpClient  *http.Client
+	now         func() time.Time
+}
+
+// NewAWSClient returns client of AWS KMS in region. Endpoint may be empty to use regional endpoint of AWS
+func NewAWSClient(region, endpoint string, credentials AWSCredentials) (*AWSClient, error) {
+	if region == "" {
+		return nil, ErrNoAWSRegion
+	}
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		return nil, ErrNoAWSCredentials
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://kms.%s.amazonaws.com/", region)
+	}
+	return &AWSClient{region: region, service: awsKMSService, endpoint: endpoint, credentials: credentials,
+		httpClient: &http.Client{Timeout: requestTimeout}, now: time.Now}, nil
+}
+
+// NewAWSClientFromEnvironment returns client of AWS KMS with credentials and region from environment variables. Region
+// overrides region from environment if not empty
+func NewAWSClientFromEnvironment(region, endpoint string) (*AWSClient, error) {
+	return NewAWSClient(AWSRegionFromEnvironment(region), endpoint, AWSCredentialsFromEnvironment())
+}
+
+// AWSRegionFromEnvironment returns region if it isn't empty, otherwise region from AWS_REGION or AWS_DEFAULT_REGION
+func AWSRegionFromEnvironment(region string) string {
+	if region == "" {
+		region = os.Getenv(AWSRegionVarName)
+	}
+	if region == "" {
+		region = os.Getenv(AWSDefaultRegionVarName)
+	}
+	return region
+}
+
+// AWSCredentialsFromEnvironment returns credentials from environment variables used by AWS CLI
+func AWSCredentialsFromEnvironment() AWSCredentials {
+	return AWSCredentials{
+		AccessKeyID:     os.Getenv(AWSAccessKeyIDVarName),
+		SecretAccessKey: os.Getenv(AWSSecretAccessKeyVarName),
+		SessionToken:    os.Getenv(AWSSessionTokenVarName),
+	}
+}
+
+type awsDecryptRequest struct {
+	CiphertextBlob []byte
+	KeyId          string `json:",omitempty"`
+}
+
+type awsDecryptResponse struct {
+	Plaintext []byte
+	KeyId     string
+}
+
+type awsErrorResponse struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+// Decrypt implements keystore.KMSDecrypter with Decrypt action of AWS KMS. keyID may be id, ARN or alias of key
+func (client *AWSClient) Decrypt(keyID string, ciphertext []byte) ([]byte, error) {
+	body, err := json.Marshal(awsDecryptRequest{CiphertextBlob: ciphertext, KeyId: keyID})
+	if err != nil {
+		return nil, err
+	}
+	var response awsDecryptResponse
+	if err := client.call("Decrypt", body, &response); err != nil {
+		return nil, err
+	}
+	return response.Plaintext, nil
+}
+
+// call sends signed request with action of KMS API and unmarshals response to output
+func (client *AWSClient) call(action string, body []byte, output interface{}) error {
+	request, err := http.NewRequest(http.MethodPost, client.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", awsJSONContentType)
+	request.Header.Set("X-Amz-Target", awsKMSTargetPrefix+action)
+	client.sign(request, body)
+	response, err := client.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		var kmsError awsErrorResponse
+		if err := json.Unmarshal(responseBody, &kmsError); err == nil && kmsError.Type != "" {
+			return fmt.Errorf("AWS KMS %s failed: %s: %s", action, kmsError.Type, kmsError.Message)
+		}
+		return fmt.Errorf("AWS KMS %s failed with status %d", action, response.StatusCode)
+	}
+	return json.Unmarshal(responseBody, output)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// sign adds headers of AWS Signature Version 4 to request
+func (client *AWSClient) sign(request *http.Request, body []byte) {
+	SignAWSRequest(request, body, client.region, client.service, client.credentials, client.now())
+}
+
+// SignAWSRequest adds headers of AWS Signature Version 4 to request to service in region with body signed at now
+// https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html
+func SignAWSRequest(request *http.Request, body []byte, region, service string, credentials AWSCredentials, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(awsTimeFormat)
+	date := now.Format(awsDateFormat)
+	request.Header.Set("X-Amz-Date", amzDate)
+	if credentials.SessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders bytes.Buffer
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	path := request.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{request.Method, path, canonicalQuery(request.URL.Query()),
+		canonicalHeaders.String(), signedHeaders, sha256Hex(body)}, "\n")
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{awsSigningAlgo, amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+	signingKey := hmacSHA256([]byte("AWS4"+credentials.SecretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, service)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	request.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		awsSigningAlgo, credentials.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalQuery returns query sorted by names with values escaped as required by Signature Version 4
+func canonicalQuery(query url.Values) string {
+	var parts []string
+	for name, values := range query {
+		for _, value := range values {
+			parts = append(parts, awsEscape(name)+"="+awsEscape(value))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "&")
+}
+
+func awsEscape(value string) string {
+	return strings.Replace(url.QueryEscape(value), "+", "%20", -1)
+}
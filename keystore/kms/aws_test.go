@@ -0,0 +1,94 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kms
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAWSSignature checks signing with get-vanilla case of AWS Signature Version 4 test suite
+func TestAWSSignature(t *testing.T) {
+	client, err := NewAWSClient("us-east-1", "https://example.amazonaws.com/", AWSCredentials{
+		AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.service = "service"
+	client.now = func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) }
+	request, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.sign(request, nil)
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if authorization := request.Header.Get("Authorization"); authorization != expected {
+		t.Fatalf("Unexpected signature %s", authorization)
+	}
+}
+
+func TestAWSClientDecrypt(t *testing.T) {
+	plaintext := []byte("some master key")
+	ciphertext := []byte("encrypted master key")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != "TrentService.Decrypt" || r.Header.Get("Content-Type") != awsJSONContentType {
+			t.Errorf("Unexpected headers %v", r.Header)
+		}
+		if r.Header.Get("X-Amz-Security-Token") != "token" || !strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/kms/aws4_request") {
+			t.Errorf("Unexpected authorization %v", r.Header)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		var request awsDecryptRequest
+		if err := json.Unmarshal(body, &request); err != nil {
+			t.Error(err)
+		}
+		if !bytes.Equal(request.CiphertextBlob, ciphertext) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"InvalidCiphertextException","message":"bad ciphertext"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(awsDecryptResponse{Plaintext: plaintext, KeyId: request.KeyId})
+	}))
+	defer server.Close()
+	client, err := NewAWSClient("eu-west-1", server.URL, AWSCredentials{AccessKeyID: "id", SecretAccessKey: "secret", SessionToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := client.Decrypt("alias/acra", ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatal("Decrypted data differs from plaintext")
+	}
+	if _, err := client.Decrypt("alias/acra", []byte("invalid")); err == nil || !strings.Contains(err.Error(), "InvalidCiphertextException") {
+		t.Fatalf("Expected error of KMS, took %v", err)
+	}
+	if _, err := NewAWSClient("", "", AWSCredentials{AccessKeyID: "id", SecretAccessKey: "secret"}); err != ErrNoAWSRegion {
+		t.Fatalf("Expected ErrNoAWSRegion, took %v", err)
+	}
+	if _, err := NewAWSClient("eu-west-1", "", AWSCredentials{}); err != ErrNoAWSCredentials {
+		t.Fatalf("Expected ErrNoAWSCredentials, took %v", err)
+	}
+}
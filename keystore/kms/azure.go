@@ -0,0 +1,164 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kms
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/cossacklabs/acra/acraerrors"
+)
+
+// Environment variables with Azure credentials, same as used by Azure SDKs
+const (
+	AzureTenantIDVarName     = "AZURE_TENANT_ID"
+	AzureClientIDVarName     = "AZURE_CLIENT_ID"
+	AzureClientSecretVarName = "AZURE_CLIENT_SECRET"
+)
+
+// Azure endpoints and defaults
+const (
+	// AzureDefaultAlgorithm is algorithm of Key Vault RSA key used to encrypt master key if not specified
+	AzureDefaultAlgorithm = "RSA-OAEP-256"
+	// AzureIMDSTokenURL returns tokens of managed identity of Azure VM, AKS pod or App Service
+	AzureIMDSTokenURL     = "http://169.254.169.254/metadata/identity/oauth2/token"
+	azureLoginEndpoint    = "https://login.microsoftonline.com/"
+	azureKeyVaultResource = "https://vault.azure.net"
+	azureKeyVaultAPI      = "7.4"
+)
+
+// ErrInvalidAzureKeyID returned if key id isn't URL of Key Vault key
+var ErrInvalidAzureKeyID = acraerrors.New(acraerrors.CodeInvalidAzureKeyID, "Azure Key Vault key id should be URL like https://<vault>.vault.azure.net/keys/<key>/<version>")
+
+// AzureClient decrypts data with key of Azure Key Vault
+type AzureClient struct {
+	algorithm  string
+	tokens     TokenSource
+	httpClient *http.Client
+}
+
+// NewAzureClient returns client of Key Vault which decrypts with algorithm and authorized with tokens from tokens.
+// Algorithm may be empty to use AzureDefaultAlgorithm
+func NewAzureClient(algorithm string, tokens TokenSource) *AzureClient {
+	if algorithm == "" {
+		algorithm = AzureDefaultAlgorithm
+	}
+	return &AzureClient{algorithm: algorithm, tokens: tokens, httpClient: &http.Client{Timeout: requestTimeout}}
+}
+
+// NewAzureClientFromEnvironment returns client of Key Vault authorized as service principal with secret from
+// environment variables or as managed identity if secret isn't set
+func NewAzureClientFromEnvironment(algorithm string) *AzureClient {
+	tenantID, clientID, secret := os.Getenv(AzureTenantIDVarName), os.Getenv(AzureClientIDVarName), os.Getenv(AzureClientSecretVarName)
+	if tenantID != "" && clientID != "" && secret != "" {
+		return NewAzureClient(algorithm, NewAzureClientSecretTokenSource(azureLoginEndpoint, tenantID, clientID, secret))
+	}
+	return NewAzureClient(algorithm, NewAzureManagedIdentityTokenSource(AzureIMDSTokenURL, clientID))
+}
+
+// NewAzureManagedIdentityTokenSource returns source of Key Vault tokens of managed identity from instance metadata
+// service with url. clientID selects user-assigned identity and may be empty for system-assigned one
+func NewAzureManagedIdentityTokenSource(tokenURL, clientID string) TokenSource {
+	query := url.Values{"api-version": {"2018-02-01"}, "resource": {azureKeyVaultResource}}
+	if clientID != "" {
+		query.Set("client_id", clientID)
+	}
+	return newEndpointTokenSource(func() (*http.Request, error) {
+		request, err := http.NewRequest(http.MethodGet, tokenURL+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Metadata", "true")
+		return request, nil
+	})
+}
+
+// NewAzureClientSecretTokenSource returns source of Key Vault tokens of service principal authenticated with secret
+// by Microsoft identity platform with loginEndpoint
+func NewAzureClientSecretTokenSource(loginEndpoint, tenantID, clientID, secret string) TokenSource {
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {clientID},
+		"client_secret": {secret},
+		"scope":         {azureKeyVaultResource + "/.default"},
+	}.Encode()
+	tokenURL := strings.TrimSuffix(loginEndpoint, "/") + "/" + url.PathEscape(tenantID) + "/oauth2/v2.0/token"
+	return newEndpointTokenSource(func() (*http.Request, error) {
+		request, err := http.NewRequest(http.MethodPost, tokenURL, strings.NewReader(form))
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return request, nil
+	})
+}
+
+type azureDecryptRequest struct {
+	Algorithm string `json:"alg"`
+	Value     string `json:"value"`
+}
+
+type azureDecryptResponse struct {
+	Value string `json:"value"`
+}
+
+type azureErrorResponse struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Decrypt implements keystore.KMSDecrypter with decrypt operation of Key Vault. keyID is URL of key with optional
+// version: https://<vault>.vault.azure.net/keys/<key>/<version>
+func (client *AzureClient) Decrypt(keyID string, ciphertext []byte) ([]byte, error) {
+	keyURL, err := url.Parse(keyID)
+	if err != nil || keyURL.Host == "" || !strings.HasPrefix(keyURL.Path, "/keys/") {
+		return nil, ErrInvalidAzureKeyID
+	}
+	body, err := json.Marshal(azureDecryptRequest{Algorithm: client.algorithm, Value: base64.RawURLEncoding.EncodeToString(ciphertext)})
+	if err != nil {
+		return nil, err
+	}
+	keyURL.Path = strings.TrimSuffix(keyURL.Path, "/") + "/decrypt"
+	keyURL.RawQuery = url.Values{"api-version": {azureKeyVaultAPI}}.Encode()
+	request, err := newBearerRequest(keyURL.String(), body, client.tokens)
+	if err != nil {
+		return nil, err
+	}
+	responseBody, status, err := doRequest(client.httpClient, request)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		var vaultError azureErrorResponse
+		if err := json.Unmarshal(responseBody, &vaultError); err == nil && vaultError.Error.Code != "" {
+			return nil, fmt.Errorf("Azure Key Vault decrypt failed: %s: %s", vaultError.Error.Code, vaultError.Error.Message)
+		}
+		return nil, fmt.Errorf("Azure Key Vault decrypt failed with status %d", status)
+	}
+	var response azureDecryptResponse
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		return nil, err
+	}
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(response.Value, "="))
+}
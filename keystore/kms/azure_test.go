@@ -0,0 +1,74 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kms
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAzureClientDecrypt(t *testing.T) {
+	plaintext := []byte("some master key")
+	ciphertext := []byte("encrypted master key")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/tenant/oauth2/v2.0/token" {
+			if r.FormValue("client_id") != "client" || r.FormValue("client_secret") != "secret" || r.FormValue("grant_type") != "client_credentials" {
+				t.Errorf("Unexpected token request %v", r.Form)
+			}
+			// expires_in is string in responses of some endpoints
+			w.Write([]byte(`{"access_token":"token","expires_in":"3599","token_type":"Bearer"}`))
+			return
+		}
+		if r.URL.Path != "/keys/master/1/decrypt" || r.URL.Query().Get("api-version") != azureKeyVaultAPI {
+			t.Errorf("Unexpected URL %v", r.URL)
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("Unexpected authorization %v", r.Header)
+		}
+		var request azureDecryptRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Error(err)
+		}
+		if request.Algorithm != AzureDefaultAlgorithm || request.Value != base64.RawURLEncoding.EncodeToString(ciphertext) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"code":"BadParameter","message":"Decryption failed"}}`))
+			return
+		}
+		json.NewEncoder(w).Encode(azureDecryptResponse{Value: base64.RawURLEncoding.EncodeToString(plaintext)})
+	}))
+	defer server.Close()
+	client := NewAzureClient("", NewAzureClientSecretTokenSource(server.URL, "tenant", "client", "secret"))
+	keyID := server.URL + "/keys/master/1"
+	decrypted, err := client.Decrypt(keyID, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatal("Decrypted data differs from plaintext")
+	}
+	if _, err := client.Decrypt(keyID, []byte("invalid")); err == nil || !strings.Contains(err.Error(), "BadParameter") {
+		t.Fatalf("Expected error of Key Vault, took %v", err)
+	}
+	if _, err := client.Decrypt("master", ciphertext); err != ErrInvalidAzureKeyID {
+		t.Fatalf("Expected ErrInvalidAzureKeyID, took %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kms
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// GCP endpoints and environment variables
+const (
+	// GCPAccessTokenVarName is environment variable with OAuth 2.0 access token, e.g. from `gcloud auth print-access-token`
+	GCPAccessTokenVarName = "GOOGLE_OAUTH_ACCESS_TOKEN"
+	// GCPMetadataTokenURL returns tokens of service account attached to GCE instance, GKE node or Cloud Run service
+	GCPMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+	// GCPKMSEndpoint is URL of Cloud KMS API
+	GCPKMSEndpoint = "https://cloudkms.googleapis.com/v1/"
+)
+
+// GCPClient decrypts data with Google Cloud KMS
+type GCPClient struct {
+	endpoint   string
+	tokens     TokenSource
+	httpClient *http.Client
+}
+
+// NewGCPClient returns client of Cloud KMS authorized with tokens from tokens. Endpoint may be empty to use
+// GCPKMSEndpoint
+func NewGCPClient(endpoint string, tokens TokenSource) *GCPClient {
+	if endpoint == "" {
+		endpoint = GCPKMSEndpoint
+	}
+	if !strings.HasSuffix(endpoint, "/") {
+		endpoint += "/"
+	}
+	return &GCPClient{endpoint: endpoint, tokens: tokens, httpClient: &http.Client{Timeout: requestTimeout}}
+}
+
+// NewGCPClientFromEnvironment returns client of Cloud KMS authorized with token from GCPAccessTokenVarName
+// environment variable or with token of service account from metadata server if variable is empty
+func NewGCPClientFromEnvironment(endpoint string) *GCPClient {
+	return NewGCPClient(endpoint, NewGCPTokenSourceFromEnvironment())
+}
+
+// NewGCPTokenSourceFromEnvironment returns source of token from GCPAccessTokenVarName environment variable or of
+// tokens of service account from metadata server if variable is empty
+func NewGCPTokenSourceFromEnvironment() TokenSource {
+	if token := os.Getenv(GCPAccessTokenVarName); token != "" {
+		return StaticTokenSource(token)
+	}
+	return NewGCPMetadataTokenSource(GCPMetadataTokenURL)
+}
+
+// NewGCPMetadataTokenSource returns source of tokens of service account from metadata server with url
+func NewGCPMetadataTokenSource(url string) TokenSource {
+	return newEndpointTokenSource(func() (*http.Request, error) {
+		request, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Metadata-Flavor", "Google")
+		return request, nil
+	})
+}
+
+type gcpDecryptRequest struct {
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+type gcpDecryptResponse struct {
+	Plaintext []byte `json:"plaintext"`
+}
+
+type gcpErrorResponse struct {
+	Error struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Decrypt implements keystore.KMSDecrypter with decrypt method of Cloud KMS. keyID is resource name of key:
+// projects/<project>/locations/<location>/keyRings/<key ring>/cryptoKeys/<key>
+func (client *GCPClient) Decrypt(keyID string, ciphertext []byte) ([]byte, error) {
+	body, err := json.Marshal(gcpDecryptRequest{Ciphertext: ciphertext})
+	if err != nil {
+		return nil, err
+	}
+	request, err := newBearerRequest(client.endpoint+strings.TrimPrefix(keyID, "/")+":decrypt", body, client.tokens)
+	if err != nil {
+		return nil, err
+	}
+	responseBody, status, err := doRequest(client.httpClient, request)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		var kmsError gcpErrorResponse
+		if err := json.Unmarshal(responseBody, &kmsError); err == nil && kmsError.Error.Status != "" {
+			return nil, fmt.Errorf("GCP KMS decrypt failed: %s: %s", kmsError.Error.Status, kmsError.Error.Message)
+		}
+		return nil, fmt.Errorf("GCP KMS decrypt failed with status %d", status)
+	}
+	var response gcpDecryptResponse
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		return nil, err
+	}
+	return response.Plaintext, nil
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kms
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGCPClientDecrypt(t *testing.T) {
+	plaintext := []byte("some master key")
+	ciphertext := []byte("encrypted master key")
+	tokenRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			tokenRequests++
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				t.Errorf("Unexpected headers of metadata request %v", r.Header)
+			}
+			w.Write([]byte(`{"access_token":"token","expires_in":3599,"token_type":"Bearer"}`))
+			return
+		}
+		if r.URL.Path != "/v1/projects/acra/locations/global/keyRings/ring/cryptoKeys/master:decrypt" {
+			t.Errorf("Unexpected path %v", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("Unexpected authorization %v", r.Header)
+		}
+		var request gcpDecryptRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Error(err)
+		}
+		if !bytes.Equal(request.Ciphertext, ciphertext) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"code":400,"message":"Decryption failed","status":"INVALID_ARGUMENT"}}`))
+			return
+		}
+		json.NewEncoder(w).Encode(gcpDecryptResponse{Plaintext: plaintext})
+	}))
+	defer server.Close()
+	client := NewGCPClient(server.URL+"/v1", NewGCPMetadataTokenSource(server.URL+"/token"))
+	keyID := "projects/acra/locations/global/keyRings/ring/cryptoKeys/master"
+	decrypted, err := client.Decrypt(keyID, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatal("Decrypted data differs from plaintext")
+	}
+	if _, err := client.Decrypt(keyID, []byte("invalid")); err == nil || !strings.Contains(err.Error(), "INVALID_ARGUMENT") {
+		t.Fatalf("Expected error of KMS, took %v", err)
+	}
+	if tokenRequests != 1 {
+		t.Fatalf("Token wasn't cached, requested %d times", tokenRequests)
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kms
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cossacklabs/acra/acraerrors"
+)
+
+// ErrEmptyAccessToken returned if token endpoint responded without access token
+var ErrEmptyAccessToken = acraerrors.New(acraerrors.CodeEmptyAccessToken, "token endpoint returned empty access token")
+
+// tokenExpirationMargin is time before expiration when cached token is refreshed
+const tokenExpirationMargin = time.Minute
+
+// TokenSource returns OAuth 2.0 access token used as bearer token in requests to cloud key management services
+type TokenSource interface {
+	Token() (string, error)
+}
+
+// StaticTokenSource returns same token on each call, used with tokens issued outside of Acra
+type StaticTokenSource string
+
+// Token returns token as is
+func (source StaticTokenSource) Token() (string, error) {
+	if source == "" {
+		return "", ErrEmptyAccessToken
+	}
+	return string(source), nil
+}
+
+// expiresIn is lifetime of token in seconds, some endpoints encode it as string
+type expiresIn int64
+
+func (value *expiresIn) UnmarshalJSON(data []byte) error {
+	seconds, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return err
+	}
+	*value = expiresIn(seconds)
+	return nil
+}
+
+type tokenResponse struct {
+	AccessToken string    `json:"access_token"`
+	ExpiresIn   expiresIn `json:"expires_in"`
+}
+
+// endpointTokenSource requests tokens from metadata server of cloud instance or OAuth 2.0 token endpoint and caches
+// them until expiration
+type endpointTokenSource struct {
+	newRequest func() (*http.Request, error)
+	httpClient *http.Client
+	now        func() time.Time
+
+	mutex     sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func newEndpointTokenSource(newRequest func() (*http.Request, error)) *endpointTokenSource {
+	return &endpointTokenSource{newRequest: newRequest, httpClient: &http.Client{Timeout: requestTimeout}, now: time.Now}
+}
+
+// Token returns cached token or requests new one if cached token expires soon
+func (source *endpointTokenSource) Token() (string, error) {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	now := source.now()
+	if source.token != "" && now.Add(tokenExpirationMargin).Before(source.expiresAt) {
+		return source.token, nil
+	}
+	request, err := source.newRequest()
+	if err != nil {
+		return "", err
+	}
+	body, status, err := doRequest(source.httpClient, request)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("token endpoint responded with status %d", status)
+	}
+	var response tokenResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", err
+	}
+	if response.AccessToken == "" {
+		return "", ErrEmptyAccessToken
+	}
+	source.token = response.AccessToken
+	source.expiresAt = now.Add(time.Duration(response.ExpiresIn) * time.Second)
+	return source.token, nil
+}
+
+// doRequest sends request and returns body and status of response
+func doRequest(httpClient *http.Client, request *http.Request) ([]byte, int, error) {
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return body, response.StatusCode, nil
+}
+
+// newBearerRequest returns POST request with JSON body authorized with token from tokens
+func newBearerRequest(url string, body []byte, tokens TokenSource) (*http.Request, error) {
+	token, err := tokens.Token()
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest(http.MethodPost, url, strings.NewReader(string(body)))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+token)
+	return request, nil
+}
@@ -27,6 +27,8 @@ import (
 // LRUCache implement keystore.Cache
 type LRUCache struct {
 	lru *lru.Cache
+	// keys tracks ids of cached values because lru.Cache can't list them
+	keys map[string]struct{}
 }
 
 // clearCacheValue callback for lru.Cache that called on value remove operation
@@ -41,13 +43,17 @@ func clearCacheValue(key lru.Key, value interface{}) {
 
 // NewLRUCacheKeystoreWrapper return new *LRUCache
 func NewLRUCacheKeystoreWrapper(size int) (*LRUCache, error) {
-	cache := &LRUCache{lru: lru.New(size)}
-	cache.lru.OnEvicted = clearCacheValue
+	cache := &LRUCache{lru: lru.New(size), keys: make(map[string]struct{})}
+	cache.lru.OnEvicted = func(key lru.Key, value interface{}) {
+		delete(cache.keys, key.(string))
+		clearCacheValue(key, value)
+	}
 	return cache, nil
 }
 
 // Add value by keyID
 func (cache *LRUCache) Add(keyID string, keyValue []byte) {
+	cache.keys[keyID] = struct{}{}
 	cache.lru.Add(keyID, keyValue)
 }
 
@@ -60,7 +66,21 @@ func (cache *LRUCache) Get(keyID string) ([]byte, bool) {
 	return nil, ok
 }
 
+// Remove value by keyID with zeroing
+func (cache *LRUCache) Remove(keyID string) {
+	cache.lru.Remove(keyID)
+}
+
 // Clear cache and remove all values with zeroing
 func (cache *LRUCache) Clear() {
 	cache.lru.Clear()
 }
+
+// Keys returns ids of all cached values
+func (cache *LRUCache) Keys() []string {
+	keys := make([]string, 0, len(cache.keys))
+	for keyID := range cache.keys {
+		keys = append(keys, keyID)
+	}
+	return keys
+}
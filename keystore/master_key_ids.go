@@ -0,0 +1,245 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/utils"
+)
+
+// MaxMasterKeyIDLength is max length of ID of master key
+const MaxMasterKeyIDLength = 64
+
+// LegacyMasterKeyID is ID of master key which encrypted keys stored without recorded ID, before master key IDs were
+// configured
+const LegacyMasterKeyID = ""
+
+// Errors returned by MasterKeyIDEncryptor and during parsing of master keys
+var (
+	ErrInvalidMasterKeyID   = acraerrors.New(acraerrors.CodeInvalidMasterKeyID, fmt.Sprintf("master key ID must contain only letters, digits, '-', '_', '.' and be not longer than %v", MaxMasterKeyIDLength))
+	ErrUnknownMasterKeyID   = acraerrors.New(acraerrors.CodeUnknownMasterKeyID, "key is encrypted with master key which isn't configured")
+	ErrInvalidMasterKeyList = acraerrors.New(acraerrors.CodeInvalidMasterKeyList, AcraMasterKeysVarName+" must contain comma separated 'id:base64 key' pairs with unique IDs")
+)
+
+// masterKeyIDMagic starts header of stored key which records ID of master key. Secure Cell and HSM wrapped keys never
+// start with it, so keys without header are read as encrypted with LegacyMasterKeyID
+var masterKeyIDMagic = []byte("AMK1")
+
+// ValidateMasterKeyID returns ErrInvalidMasterKeyID if id is empty, too long or contains unsupported characters
+func ValidateMasterKeyID(id string) error {
+	if id == "" || len(id) > MaxMasterKeyIDLength || !isLabel(id) {
+		return ErrInvalidMasterKeyID
+	}
+	return nil
+}
+
+// isLabel returns true if value contains only letters, digits, '-', '_' and '.'
+func isLabel(value string) bool {
+	for _, c := range value {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && !strings.ContainsRune("-_.", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseMasterKeyID returns ID of master key recorded in stored key and key encrypted with it. Keys without recorded ID
+// are returned as is with LegacyMasterKeyID
+func ParseMasterKeyID(storedKey []byte) (string, []byte, error) {
+	if !bytes.HasPrefix(storedKey, masterKeyIDMagic) {
+		return LegacyMasterKeyID, storedKey, nil
+	}
+	header := storedKey[len(masterKeyIDMagic):]
+	if len(header) < 1 || len(header) < 1+int(header[0]) {
+		return "", nil, ErrInvalidMasterKeyID
+	}
+	id := string(header[1 : 1+int(header[0])])
+	if err := ValidateMasterKeyID(id); err != nil {
+		return "", nil, err
+	}
+	return id, header[1+int(header[0]):], nil
+}
+
+// addMasterKeyID returns encrypted key prefixed with header which records ID of master key
+func addMasterKeyID(id string, encryptedKey []byte) []byte {
+	output := make([]byte, 0, len(masterKeyIDMagic)+1+len(id)+len(encryptedKey))
+	output = append(output, masterKeyIDMagic...)
+	output = append(output, byte(len(id)))
+	output = append(output, id...)
+	return append(output, encryptedKey...)
+}
+
+// MasterKeyIDEncryptor encrypts keys with current master key and records its ID in every stored key, so keys are
+// decrypted with master key they were encrypted with. Master key is rotated gradually: new master key becomes current,
+// old ones are kept until all keys recorded as encrypted with them are rewrapped on access or in batches
+type MasterKeyIDEncryptor struct {
+	currentID  string
+	encryptors map[string]KeyEncryptor
+}
+
+// NewMasterKeyIDEncryptor returns encryptor which encrypts keys with encryptor of currentID and decrypts them with
+// encryptor of recorded ID. Encryptor with LegacyMasterKeyID decrypts keys stored without recorded ID
+func NewMasterKeyIDEncryptor(currentID string, encryptors map[string]KeyEncryptor) (*MasterKeyIDEncryptor, error) {
+	if err := ValidateMasterKeyID(currentID); err != nil {
+		return nil, err
+	}
+	if _, ok := encryptors[currentID]; !ok {
+		return nil, ErrUnknownMasterKeyID
+	}
+	for id := range encryptors {
+		if id == LegacyMasterKeyID {
+			continue
+		}
+		if err := ValidateMasterKeyID(id); err != nil {
+			return nil, err
+		}
+	}
+	return &MasterKeyIDEncryptor{currentID: currentID, encryptors: encryptors}, nil
+}
+
+// CurrentMasterKeyID returns ID of master key which encrypts new keys
+func (encryptor *MasterKeyIDEncryptor) CurrentMasterKeyID() string {
+	return encryptor.currentID
+}
+
+// Encrypt returns key encrypted with current master key and prefixed with its ID
+func (encryptor *MasterKeyIDEncryptor) Encrypt(key, context []byte) ([]byte, error) {
+	encrypted, err := encryptor.encryptors[encryptor.currentID].Encrypt(key, context)
+	if err != nil {
+		return nil, err
+	}
+	return addMasterKeyID(encryptor.currentID, encrypted), nil
+}
+
+// Decrypt returns key decrypted with master key recorded in it
+func (encryptor *MasterKeyIDEncryptor) Decrypt(key, context []byte) ([]byte, error) {
+	decrypted, _, err := encryptor.DecryptAndRewrap(key, context)
+	return decrypted, err
+}
+
+// DecryptAndRewrap returns decrypted key and key encrypted with current master key if it was encrypted with another
+func (encryptor *MasterKeyIDEncryptor) DecryptAndRewrap(encryptedKey, context []byte) ([]byte, []byte, error) {
+	id, body, err := ParseMasterKeyID(encryptedKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyEncryptor, ok := encryptor.encryptors[id]
+	if !ok {
+		return nil, nil, ErrUnknownMasterKeyID
+	}
+	key, err := keyEncryptor.Decrypt(body, context)
+	if err != nil {
+		return nil, nil, err
+	}
+	if id == encryptor.currentID {
+		return key, nil, nil
+	}
+	rewrapped, err := encryptor.Encrypt(key, context)
+	if err != nil {
+		utils.FillSlice(byte(0), key)
+		return nil, nil, err
+	}
+	return key, rewrapped, nil
+}
+
+// NeedsRewrap returns true if stored key isn't recorded as encrypted with current master key, so keystores skip
+// decryption of keys which are already rewrapped
+func (encryptor *MasterKeyIDEncryptor) NeedsRewrap(encryptedKey []byte) bool {
+	id, _, err := ParseMasterKeyID(encryptedKey)
+	return err != nil || id != encryptor.currentID
+}
+
+// RewrapChecker is implemented by KeyRewrapper which can tell whether stored key should be rewrapped without its
+// decryption
+type RewrapChecker interface {
+	NeedsRewrap(encryptedKey []byte) bool
+}
+
+// LimitedMasterKeyRewrapper is implemented by keystores which can rewrap stored keys in batches, so rotation of
+// master key in huge keystore doesn't block access to keys for long
+type LimitedMasterKeyRewrapper interface {
+	// RewrapKeysLimit encrypts with current master key at most limit keys encrypted with other master keys and returns
+	// their count, all keys are rewrapped if limit is 0
+	RewrapKeysLimit(limit int) (int, error)
+}
+
+// MasterKeyUsageCounter is implemented by keystores which can count stored keys by ID of master key which encrypted
+// them, so operators see when old master key isn't used anymore and may be removed
+type MasterKeyUsageCounter interface {
+	// CountKeysByMasterKey returns count of stored private and symmetric keys with their previous versions by ID of
+	// master key, LegacyMasterKeyID counts keys stored without recorded ID
+	CountKeysByMasterKey() (map[string]int, error)
+}
+
+// GetMasterKeyIDFromEnvironment returns ID of current master key from environment variable with name
+// AcraMasterKeyIDVarName or empty string if master key IDs aren't used
+func GetMasterKeyIDFromEnvironment() (string, error) {
+	id := os.Getenv(AcraMasterKeyIDVarName)
+	if id == "" {
+		return "", nil
+	}
+	if err := ValidateMasterKeyID(id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// GetMasterKeysFromEnvironment returns master keys by their IDs from environment variable with name
+// AcraMasterKeysVarName separated by key environment like GetMasterKeyFromEnvironment does
+func GetMasterKeysFromEnvironment() (map[string][]byte, error) {
+	return ParseMasterKeys(os.Getenv(AcraMasterKeysVarName), GetKeyEnvironment())
+}
+
+// ParseMasterKeys parses comma separated 'id:base64 key' pairs and returns master keys by their IDs separated by
+// environment label
+func ParseMasterKeys(value, environment string) (map[string][]byte, error) {
+	masterKeys := make(map[string][]byte)
+	if strings.TrimSpace(value) == "" {
+		return masterKeys, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+		if len(parts) != 2 {
+			return nil, ErrInvalidMasterKeyList
+		}
+		if err := ValidateMasterKeyID(parts[0]); err != nil {
+			return nil, err
+		}
+		if _, ok := masterKeys[parts[0]]; ok {
+			return nil, ErrInvalidMasterKeyList
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, ErrInvalidMasterKeyList
+		}
+		if err := ValidateMasterKey(key); err != nil {
+			return nil, err
+		}
+		key, err = DeriveEnvironmentMasterKey(key, environment)
+		if err != nil {
+			return nil, err
+		}
+		masterKeys[parts[0]] = key
+	}
+	return masterKeys, nil
+}
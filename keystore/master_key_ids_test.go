@@ -0,0 +1,146 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestMasterKeyIDEncryptor(t *testing.T) {
+	legacy, err := NewSCellKeyEncryptor([]byte("legacy master key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := NewSCellKeyEncryptor([]byte("first master key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewSCellKeyEncryptor([]byte("second master key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewMasterKeyIDEncryptor("second", map[string]KeyEncryptor{"first": first}); err != ErrUnknownMasterKeyID {
+		t.Fatalf("Expected ErrUnknownMasterKeyID, took %v", err)
+	}
+	if _, err := NewMasterKeyIDEncryptor("", map[string]KeyEncryptor{"": first}); err != ErrInvalidMasterKeyID {
+		t.Fatalf("Expected ErrInvalidMasterKeyID, took %v", err)
+	}
+	firstEncryptor, err := NewMasterKeyIDEncryptor("first", map[string]KeyEncryptor{"first": first, LegacyMasterKeyID: legacy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondEncryptor, err := NewMasterKeyIDEncryptor("second", map[string]KeyEncryptor{"first": first, "second": second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, context := []byte("some key"), []byte("client")
+
+	legacyEncrypted, err := legacy.Encrypt(key, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, firstEncrypted, err := firstEncryptor.DecryptAndRewrap(legacyEncrypted, context)
+	if err != nil || !bytes.Equal(decrypted, key) || firstEncrypted == nil {
+		t.Fatalf("Key stored without master key ID should be decrypted and rewrapped: %v", err)
+	}
+	if id, _, err := ParseMasterKeyID(firstEncrypted); err != nil || id != "first" {
+		t.Fatalf("Expected master key ID 'first', took '%v', %v", id, err)
+	}
+	if firstEncryptor.NeedsRewrap(firstEncrypted) || !firstEncryptor.NeedsRewrap(legacyEncrypted) {
+		t.Fatal("Incorrect NeedsRewrap result")
+	}
+	if decrypted, rewrapped, err := firstEncryptor.DecryptAndRewrap(firstEncrypted, context); err != nil || !bytes.Equal(decrypted, key) || rewrapped != nil {
+		t.Fatalf("Key encrypted with current master key shouldn't be rewrapped: %v", err)
+	}
+
+	// second master key is current, first is kept for keys not rewrapped yet
+	decrypted, secondEncrypted, err := secondEncryptor.DecryptAndRewrap(firstEncrypted, context)
+	if err != nil || !bytes.Equal(decrypted, key) || secondEncrypted == nil {
+		t.Fatalf("Key encrypted with first master key should be decrypted and rewrapped: %v", err)
+	}
+	if id, body, err := ParseMasterKeyID(secondEncrypted); err != nil || id != "second" {
+		t.Fatalf("Expected master key ID 'second', took '%v', %v", id, err)
+	} else if decrypted, err := second.Decrypt(body, context); err != nil || !bytes.Equal(decrypted, key) {
+		t.Fatalf("Rewrapped key isn't encrypted with second master key: %v", err)
+	}
+	if _, err := secondEncryptor.Decrypt(legacyEncrypted, context); err != ErrUnknownMasterKeyID {
+		t.Fatalf("Expected ErrUnknownMasterKeyID for key without master key ID, took %v", err)
+	}
+	if _, err := firstEncryptor.Decrypt(secondEncrypted, context); err != ErrUnknownMasterKeyID {
+		t.Fatalf("Expected ErrUnknownMasterKeyID, took %v", err)
+	}
+	if _, err := secondEncryptor.Decrypt(secondEncrypted, []byte("other")); err == nil {
+		t.Fatal("Key decrypted with incorrect context")
+	}
+	// master key ID isn't accepted by encryptor with different master key
+	forged := addMasterKeyID("first", secondEncrypted[len(masterKeyIDMagic)+1+len("second"):])
+	if _, err := secondEncryptor.Decrypt(forged, context); err == nil {
+		t.Fatal("Key decrypted with master key from forged ID")
+	}
+	if _, _, err := ParseMasterKeyID(secondEncrypted[:len(masterKeyIDMagic)+3]); err != ErrInvalidMasterKeyID {
+		t.Fatalf("Expected ErrInvalidMasterKeyID for truncated header, took %v", err)
+	}
+}
+
+func TestParseMasterKeys(t *testing.T) {
+	firstKey := bytes.Repeat([]byte{1}, SymmetricKeyLength)
+	secondKey := bytes.Repeat([]byte{2}, SymmetricKeyLength)
+	value := "first:" + base64.StdEncoding.EncodeToString(firstKey) + ", second.v2:" + base64.StdEncoding.EncodeToString(secondKey)
+	masterKeys, err := ParseMasterKeys(value, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(masterKeys) != 2 || !bytes.Equal(masterKeys["first"], firstKey) || !bytes.Equal(masterKeys["second.v2"], secondKey) {
+		t.Fatalf("Incorrect parsed master keys: %v", masterKeys)
+	}
+	masterKeys, err = ParseMasterKeys(value, "staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := DeriveEnvironmentMasterKey(firstKey, "staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(masterKeys["first"], expected) {
+		t.Fatal("Master key isn't separated by environment")
+	}
+	if masterKeys, err := ParseMasterKeys(" ", ""); err != nil || len(masterKeys) != 0 {
+		t.Fatalf("Expected no master keys, took %v, %v", masterKeys, err)
+	}
+
+	encodedKey := base64.StdEncoding.EncodeToString(firstKey)
+	testcases := []struct {
+		value string
+		err   error
+	}{
+		{encodedKey, ErrInvalidMasterKeyList},
+		{"first:" + encodedKey + ",first:" + encodedKey, ErrInvalidMasterKeyList},
+		{"first:not base64", ErrInvalidMasterKeyList},
+		{"first:" + base64.StdEncoding.EncodeToString([]byte("short")), ErrMasterKeyIncorrectLength},
+		{":" + encodedKey, ErrInvalidMasterKeyID},
+		{"first/key:" + encodedKey, ErrInvalidMasterKeyID},
+		{strings.Repeat("a", MaxMasterKeyIDLength+1) + ":" + encodedKey, ErrInvalidMasterKeyID},
+	}
+	for i, testcase := range testcases {
+		if _, err := ParseMasterKeys(testcase.value, ""); err != testcase.err {
+			t.Errorf("[%d] Expected %v, took %v", i, testcase.err, err)
+		}
+	}
+}
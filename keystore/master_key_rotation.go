@@ -0,0 +1,82 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/utils"
+)
+
+// ErrNoPreviousMasterKey returned if keys are rewrapped while previous master key isn't configured
+var ErrNoPreviousMasterKey = acraerrors.New(acraerrors.CodeNoPreviousMasterKey, "previous master key isn't configured, set "+AcraPreviousMasterKeyVarName+" or "+AcraMasterKeysVarName+" to rewrap keys")
+
+// KeyRewrapper is implemented by KeyEncryptor which decrypts keys encrypted with previous master key during its
+// rotation and encrypts them with current master key
+type KeyRewrapper interface {
+	// DecryptAndRewrap returns decrypted key and, if it was encrypted with previous master key, key encrypted with
+	// current master key which should replace stored key. rewrapped is nil for keys encrypted with current master key
+	DecryptAndRewrap(encryptedKey, context []byte) (key, rewrapped []byte, err error)
+}
+
+// MasterKeyRewrapper is implemented by keystores which can encrypt all stored keys with current master key
+type MasterKeyRewrapper interface {
+	// RewrapKeys encrypts with current master key all keys encrypted with previous master key and returns their count
+	RewrapKeys() (int, error)
+}
+
+// RotatingKeyEncryptor encrypts keys with current encryptor and decrypts them with current or previous encryptor, so
+// master key may be rotated without downtime: keys are rewrapped with new master key on access or in bulk and the
+// previous master key is removed from configuration after all keys were rewrapped
+type RotatingKeyEncryptor struct {
+	current  KeyEncryptor
+	previous KeyEncryptor
+}
+
+// NewRotatingKeyEncryptor returns encryptor which uses previous encryptor only for keys not rewrapped yet
+func NewRotatingKeyEncryptor(current, previous KeyEncryptor) *RotatingKeyEncryptor {
+	return &RotatingKeyEncryptor{current: current, previous: previous}
+}
+
+// Encrypt returns key encrypted with current master key
+func (encryptor *RotatingKeyEncryptor) Encrypt(key, context []byte) ([]byte, error) {
+	return encryptor.current.Encrypt(key, context)
+}
+
+// Decrypt returns key encrypted with current or previous master key
+func (encryptor *RotatingKeyEncryptor) Decrypt(key, context []byte) ([]byte, error) {
+	decrypted, _, err := encryptor.DecryptAndRewrap(key, context)
+	return decrypted, err
+}
+
+// DecryptAndRewrap returns decrypted key and key encrypted with current master key if it was encrypted with previous
+func (encryptor *RotatingKeyEncryptor) DecryptAndRewrap(encryptedKey, context []byte) ([]byte, []byte, error) {
+	key, err := encryptor.current.Decrypt(encryptedKey, context)
+	if err == nil {
+		return key, nil, nil
+	}
+	key, previousErr := encryptor.previous.Decrypt(encryptedKey, context)
+	if previousErr != nil {
+		// error of current master key is more relevant after rotation
+		return nil, nil, err
+	}
+	rewrapped, err := encryptor.current.Encrypt(key, context)
+	if err != nil {
+		utils.FillSlice(byte(0), key)
+		return nil, nil, err
+	}
+	return key, rewrapped, nil
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRotatingKeyEncryptor(t *testing.T) {
+	previous, err := NewSCellKeyEncryptor([]byte("previous master key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := NewSCellKeyEncryptor([]byte("current master key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptor := NewRotatingKeyEncryptor(current, previous)
+	key, context := []byte("some key"), []byte("client")
+
+	oldEncrypted, err := previous.Encrypt(key, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, rewrapped, err := encryptor.DecryptAndRewrap(oldEncrypted, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, key) || rewrapped == nil {
+		t.Fatal("Key encrypted with previous master key should be decrypted and rewrapped")
+	}
+	if decrypted, err := current.Decrypt(rewrapped, context); err != nil || !bytes.Equal(decrypted, key) {
+		t.Fatalf("Rewrapped key isn't encrypted with current master key: %v", err)
+	}
+
+	newEncrypted, err := encryptor.Encrypt(key, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, rewrapped, err = encryptor.DecryptAndRewrap(newEncrypted, context)
+	if err != nil || !bytes.Equal(decrypted, key) || rewrapped != nil {
+		t.Fatalf("Key encrypted with current master key shouldn't be rewrapped: %v", err)
+	}
+	if decrypted, err := encryptor.Decrypt(oldEncrypted, context); err != nil || !bytes.Equal(decrypted, key) {
+		t.Fatalf("Can't decrypt key encrypted with previous master key: %v", err)
+	}
+	if _, err := encryptor.Decrypt(oldEncrypted, []byte("other")); err == nil {
+		t.Fatal("Key decrypted with incorrect context")
+	}
+}
@@ -0,0 +1,34 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"syscall"
+)
+
+// lockMemory locks memory of data from swapping to disk
+func lockMemory(data []byte) error {
+	return syscall.Mlock(data)
+}
+
+// unlockMemory unlocks memory locked by lockMemory
+func unlockMemory(data []byte) error {
+	return syscall.Munlock(data)
+}
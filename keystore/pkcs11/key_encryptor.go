@@ -0,0 +1,95 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package pkcs11 implements keystore.KeyEncryptor which wraps keys with AES key stored in HSM and accessed with
+// PKCS#11 module, so master key never leaves HSM and isn't present in memory of Acra services.
+package pkcs11
+
+import (
+	"crypto/rand"
+	"sync"
+
+	"github.com/cossacklabs/acra/acraerrors"
+)
+
+const (
+	gcmIVSize  = 12
+	gcmTagSize = 16
+	// wrappedKeyVersion is first byte of keys wrapped by KeyEncryptor
+	wrappedKeyVersion = 1
+)
+
+// ErrInvalidWrappedKey returned if encrypted key has unknown format or too short
+var ErrInvalidWrappedKey = acraerrors.New(acraerrors.CodeInvalidWrappedKey, "invalid key wrapped with HSM")
+
+// gcmCipher encrypts and decrypts data with AES-GCM
+type gcmCipher interface {
+	Seal(iv, aad, plaintext []byte) ([]byte, error)
+	Open(iv, aad, ciphertext []byte) ([]byte, error)
+	Close() error
+}
+
+// KeyEncryptor implements keystore.KeyEncryptor with AES-GCM performed by HSM. Context of key is used as additional
+// authenticated data, output is version byte, random IV and ciphertext with tag
+type KeyEncryptor struct {
+	mutex  sync.Mutex
+	cipher gcmCipher
+}
+
+// NewKeyEncryptor returns KeyEncryptor which uses secret key with keyLabel from token in slot of PKCS#11 module
+func NewKeyEncryptor(modulePath string, slot uint, pin []byte, keyLabel string) (*KeyEncryptor, error) {
+	session, err := OpenSession(modulePath, slot, pin, keyLabel)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyEncryptor{cipher: session}, nil
+}
+
+// Encrypt wraps key in HSM
+func (encryptor *KeyEncryptor) Encrypt(key, context []byte) ([]byte, error) {
+	iv := make([]byte, gcmIVSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	// sessions of PKCS#11 can't process several operations at once
+	encryptor.mutex.Lock()
+	ciphertext, err := encryptor.cipher.Seal(iv, context, key)
+	encryptor.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	output := make([]byte, 0, 1+len(iv)+len(ciphertext))
+	output = append(output, wrappedKeyVersion)
+	output = append(output, iv...)
+	return append(output, ciphertext...), nil
+}
+
+// Decrypt unwraps key in HSM
+func (encryptor *KeyEncryptor) Decrypt(key, context []byte) ([]byte, error) {
+	if len(key) < 1+gcmIVSize+gcmTagSize || key[0] != wrappedKeyVersion {
+		return nil, ErrInvalidWrappedKey
+	}
+	encryptor.mutex.Lock()
+	defer encryptor.mutex.Unlock()
+	return encryptor.cipher.Open(key[1:1+gcmIVSize], context, key[1+gcmIVSize:])
+}
+
+// Close releases session of HSM
+func (encryptor *KeyEncryptor) Close() error {
+	encryptor.mutex.Lock()
+	defer encryptor.mutex.Unlock()
+	return encryptor.cipher.Close()
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkcs11
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+)
+
+// softwareCipher is AES-GCM in memory used instead of HSM
+type softwareCipher struct {
+	aead cipher.AEAD
+}
+
+func newSoftwareCipher(t *testing.T) *softwareCipher {
+	block, err := aes.NewCipher(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &softwareCipher{aead: aead}
+}
+
+func (c *softwareCipher) Seal(iv, aad, plaintext []byte) ([]byte, error) {
+	return c.aead.Seal(nil, iv, plaintext, aad), nil
+}
+
+func (c *softwareCipher) Open(iv, aad, ciphertext []byte) ([]byte, error) {
+	return c.aead.Open(nil, iv, ciphertext, aad)
+}
+
+func (c *softwareCipher) Close() error {
+	return nil
+}
+
+func TestKeyEncryptor(t *testing.T) {
+	encryptor := &KeyEncryptor{cipher: newSoftwareCipher(t)}
+	key := []byte("some private key")
+	encrypted, err := encryptor.Encrypt(key, []byte("client"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encrypted) != 1+gcmIVSize+len(key)+gcmTagSize || encrypted[0] != wrappedKeyVersion {
+		t.Fatalf("Unexpected format of wrapped key %x", encrypted)
+	}
+	decrypted, err := encryptor.Decrypt(encrypted, []byte("client"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, key) {
+		t.Fatal("Decrypted key differs")
+	}
+	if _, err := encryptor.Decrypt(encrypted, []byte("other client")); err == nil {
+		t.Fatal("Key decrypted with other context")
+	}
+	if _, err := encryptor.Decrypt(encrypted[:gcmIVSize], []byte("client")); err != ErrInvalidWrappedKey {
+		t.Fatalf("Expected ErrInvalidWrappedKey, took %v", err)
+	}
+}
+
+func TestOpenSessionWithoutModule(t *testing.T) {
+	if _, err := OpenSession("/nonexistent/libpkcs11.so", 0, []byte("1234"), "acra"); err != ErrCantLoadModule {
+		t.Fatalf("Expected ErrCantLoadModule, took %v", err)
+	}
+}
@@ -0,0 +1,289 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkcs11
+
+/*
+#cgo linux LDFLAGS: -ldl
+#include <dlfcn.h>
+#include <stdlib.h>
+
+// Subset of PKCS#11 v2.40 types. Modules are loaded with dlopen, so headers of vendor aren't required at build time
+typedef unsigned long CK_ULONG;
+typedef unsigned char CK_BYTE;
+typedef CK_ULONG CK_RV;
+typedef CK_ULONG CK_SESSION_HANDLE;
+typedef CK_ULONG CK_OBJECT_HANDLE;
+
+#define CKR_OK 0x0UL
+#define CKR_ARGUMENTS_BAD 0x7UL
+#define CKF_RW_SESSION 0x2UL
+#define CKF_SERIAL_SESSION 0x4UL
+#define CKU_USER 1UL
+#define CKA_CLASS 0x0UL
+#define CKA_LABEL 0x3UL
+#define CKO_SECRET_KEY 0x4UL
+#define CKM_AES_GCM 0x1087UL
+
+typedef struct { CK_BYTE major; CK_BYTE minor; } CK_VERSION;
+typedef struct { CK_ULONG type; void *pValue; CK_ULONG ulValueLen; } CK_ATTRIBUTE;
+typedef struct { CK_ULONG mechanism; void *pParameter; CK_ULONG ulParameterLen; } CK_MECHANISM;
+typedef struct { CK_BYTE *pIv; CK_ULONG ulIvLen; CK_ULONG ulIvBits; CK_BYTE *pAAD; CK_ULONG ulAADLen; CK_ULONG ulTagBits; } CK_GCM_PARAMS;
+typedef void *unused_function;
+
+// prefix of CK_FUNCTION_LIST up to C_Decrypt, order of functions is defined by specification
+typedef struct function_list {
+	CK_VERSION version;
+	CK_RV (*C_Initialize)(void *);
+	CK_RV (*C_Finalize)(void *);
+	unused_function C_GetInfo;
+	CK_RV (*C_GetFunctionList)(struct function_list **);
+	unused_function C_GetSlotList, C_GetSlotInfo, C_GetTokenInfo, C_GetMechanismList, C_GetMechanismInfo,
+		C_InitToken, C_InitPIN, C_SetPIN;
+	CK_RV (*C_OpenSession)(CK_ULONG, CK_ULONG, void *, void *, CK_SESSION_HANDLE *);
+	CK_RV (*C_CloseSession)(CK_SESSION_HANDLE);
+	unused_function C_CloseAllSessions, C_GetSessionInfo, C_GetOperationState, C_SetOperationState;
+	CK_RV (*C_Login)(CK_SESSION_HANDLE, CK_ULONG, CK_BYTE *, CK_ULONG);
+	CK_RV (*C_Logout)(CK_SESSION_HANDLE);
+	unused_function C_CreateObject, C_CopyObject, C_DestroyObject, C_GetObjectSize, C_GetAttributeValue,
+		C_SetAttributeValue;
+	CK_RV (*C_FindObjectsInit)(CK_SESSION_HANDLE, CK_ATTRIBUTE *, CK_ULONG);
+	CK_RV (*C_FindObjects)(CK_SESSION_HANDLE, CK_OBJECT_HANDLE *, CK_ULONG, CK_ULONG *);
+	CK_RV (*C_FindObjectsFinal)(CK_SESSION_HANDLE);
+	CK_RV (*C_EncryptInit)(CK_SESSION_HANDLE, CK_MECHANISM *, CK_OBJECT_HANDLE);
+	CK_RV (*C_Encrypt)(CK_SESSION_HANDLE, CK_BYTE *, CK_ULONG, CK_BYTE *, CK_ULONG *);
+	unused_function C_EncryptUpdate, C_EncryptFinal;
+	CK_RV (*C_DecryptInit)(CK_SESSION_HANDLE, CK_MECHANISM *, CK_OBJECT_HANDLE);
+	CK_RV (*C_Decrypt)(CK_SESSION_HANDLE, CK_BYTE *, CK_ULONG, CK_BYTE *, CK_ULONG *);
+} function_list;
+
+typedef CK_RV (*get_function_list)(function_list **);
+
+static void *load_module(const char *path, function_list **functions) {
+	void *handle = dlopen(path, RTLD_NOW | RTLD_LOCAL);
+	if (!handle) {
+		return NULL;
+	}
+	get_function_list get = (get_function_list)dlsym(handle, "C_GetFunctionList");
+	if (!get || get(functions) != CKR_OK || !*functions) {
+		dlclose(handle);
+		return NULL;
+	}
+	return handle;
+}
+
+static void unload_module(void *handle) {
+	dlclose(handle);
+}
+
+static CK_RV initialize(function_list *f) {
+	return f->C_Initialize(NULL);
+}
+
+static CK_RV finalize(function_list *f) {
+	return f->C_Finalize(NULL);
+}
+
+static CK_RV open_session(function_list *f, CK_ULONG slot, CK_SESSION_HANDLE *session) {
+	return f->C_OpenSession(slot, CKF_SERIAL_SESSION | CKF_RW_SESSION, NULL, NULL, session);
+}
+
+static CK_RV close_session(function_list *f, CK_SESSION_HANDLE session) {
+	return f->C_CloseSession(session);
+}
+
+static CK_RV login(function_list *f, CK_SESSION_HANDLE session, CK_BYTE *pin, CK_ULONG pinLen) {
+	return f->C_Login(session, CKU_USER, pin, pinLen);
+}
+
+static CK_RV find_secret_keys(function_list *f, CK_SESSION_HANDLE session, CK_BYTE *label, CK_ULONG labelLen,
+		CK_OBJECT_HANDLE *keys, CK_ULONG maxKeys, CK_ULONG *count) {
+	CK_ULONG keyClass = CKO_SECRET_KEY;
+	CK_ATTRIBUTE template[2] = {{CKA_CLASS, &keyClass, sizeof(keyClass)}, {CKA_LABEL, label, labelLen}};
+	CK_RV rv = f->C_FindObjectsInit(session, template, 2);
+	if (rv != CKR_OK) {
+		return rv;
+	}
+	rv = f->C_FindObjects(session, keys, maxKeys, count);
+	CK_RV finalRV = f->C_FindObjectsFinal(session);
+	return rv != CKR_OK ? rv : finalRV;
+}
+
+// aes_gcm encrypts or decrypts input with AES-GCM, parameters are built in C memory as required by cgo rules
+static CK_RV aes_gcm(function_list *f, CK_SESSION_HANDLE session, CK_OBJECT_HANDLE key, int decrypt,
+		CK_BYTE *iv, CK_ULONG ivLen, CK_BYTE *aad, CK_ULONG aadLen,
+		CK_BYTE *input, CK_ULONG inputLen, CK_BYTE *output, CK_ULONG *outputLen) {
+	CK_GCM_PARAMS params = {iv, ivLen, ivLen * 8, aad, aadLen, 128};
+	CK_MECHANISM mechanism = {CKM_AES_GCM, &params, sizeof(params)};
+	CK_RV rv;
+	if (decrypt) {
+		rv = f->C_DecryptInit(session, &mechanism, key);
+		if (rv != CKR_OK) {
+			return rv;
+		}
+		return f->C_Decrypt(session, input, inputLen, output, outputLen);
+	}
+	rv = f->C_EncryptInit(session, &mechanism, key);
+	if (rv != CKR_OK) {
+		return rv;
+	}
+	return f->C_Encrypt(session, input, inputLen, output, outputLen);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/cossacklabs/acra/acraerrors"
+)
+
+// Return values of PKCS#11 functions processed by module
+const (
+	ckrUserAlreadyLoggedIn        = 0x100
+	ckrCryptokiAlreadyInitialized = 0x191
+)
+
+// Errors returned by PKCS#11 module
+var (
+	ErrCantLoadModule  = acraerrors.New(acraerrors.CodeCantLoadHSMModule, "can't load PKCS#11 module")
+	ErrKeyNotFound     = acraerrors.New(acraerrors.CodeHSMKeyNotFound, "secret key with label not found in HSM")
+	ErrKeyNotUnique    = acraerrors.New(acraerrors.CodeHSMKeyNotUnique, "more than one secret key with label found in HSM")
+	ErrModuleFinalized = acraerrors.New(acraerrors.CodeHSMModuleFinalized, "PKCS#11 module is closed")
+)
+
+// Error is error code returned by function of PKCS#11 module
+type Error struct {
+	Function string
+	Code     uint64
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("PKCS#11 %s failed with CKR 0x%X", err.Function, err.Code)
+}
+
+func check(function string, rv C.CK_RV) error {
+	if rv == C.CKR_OK {
+		return nil
+	}
+	return &Error{Function: function, Code: uint64(rv)}
+}
+
+// bytesPointer returns pointer to first byte of data or nil for empty data
+func bytesPointer(data []byte) *C.CK_BYTE {
+	if len(data) == 0 {
+		return nil
+	}
+	return (*C.CK_BYTE)(unsafe.Pointer(&data[0]))
+}
+
+// Session is logged in session of PKCS#11 module with secret key used for AES-GCM. Session isn't safe for
+// concurrent use
+type Session struct {
+	handle    unsafe.Pointer
+	functions *C.function_list
+	session   C.CK_SESSION_HANDLE
+	key       C.CK_OBJECT_HANDLE
+}
+
+// OpenSession loads PKCS#11 module from modulePath, logs in to token in slot with pin as user and finds secret key
+// with keyLabel
+func OpenSession(modulePath string, slot uint, pin []byte, keyLabel string) (*Session, error) {
+	path := C.CString(modulePath)
+	defer C.free(unsafe.Pointer(path))
+	var functions *C.function_list
+	handle := C.load_module(path, &functions)
+	if handle == nil {
+		return nil, ErrCantLoadModule
+	}
+	session := &Session{handle: handle, functions: functions}
+	if rv := C.initialize(functions); rv != C.CKR_OK && rv != ckrCryptokiAlreadyInitialized {
+		C.unload_module(handle)
+		return nil, check("C_Initialize", rv)
+	}
+	if err := check("C_OpenSession", C.open_session(functions, C.CK_ULONG(slot), &session.session)); err != nil {
+		C.finalize(functions)
+		C.unload_module(handle)
+		return nil, err
+	}
+	if rv := C.login(functions, session.session, bytesPointer(pin), C.CK_ULONG(len(pin))); rv != C.CKR_OK && rv != ckrUserAlreadyLoggedIn {
+		session.Close()
+		return nil, check("C_Login", rv)
+	}
+	label := []byte(keyLabel)
+	keys := make([]C.CK_OBJECT_HANDLE, 2)
+	var count C.CK_ULONG
+	if err := check("C_FindObjects", C.find_secret_keys(functions, session.session, bytesPointer(label), C.CK_ULONG(len(label)), &keys[0], C.CK_ULONG(len(keys)), &count)); err != nil {
+		session.Close()
+		return nil, err
+	}
+	switch count {
+	case 0:
+		session.Close()
+		return nil, ErrKeyNotFound
+	case 1:
+		session.key = keys[0]
+		return session, nil
+	default:
+		session.Close()
+		return nil, ErrKeyNotUnique
+	}
+}
+
+func (session *Session) aesGCM(decrypt bool, iv, aad, input []byte, outputSize int) ([]byte, error) {
+	if session.handle == nil {
+		return nil, ErrModuleFinalized
+	}
+	decryptFlag := C.int(0)
+	function := "C_Encrypt"
+	if decrypt {
+		decryptFlag = 1
+		function = "C_Decrypt"
+	}
+	output := make([]byte, outputSize)
+	outputLength := C.CK_ULONG(len(output))
+	rv := C.aes_gcm(session.functions, session.session, session.key, decryptFlag,
+		bytesPointer(iv), C.CK_ULONG(len(iv)), bytesPointer(aad), C.CK_ULONG(len(aad)),
+		bytesPointer(input), C.CK_ULONG(len(input)), bytesPointer(output), &outputLength)
+	if err := check(function, rv); err != nil {
+		return nil, err
+	}
+	return output[:outputLength], nil
+}
+
+// Seal encrypts plaintext with AES-GCM in HSM and returns ciphertext with authentication tag
+func (session *Session) Seal(iv, aad, plaintext []byte) ([]byte, error) {
+	return session.aesGCM(false, iv, aad, plaintext, len(plaintext)+gcmTagSize)
+}
+
+// Open decrypts ciphertext with authentication tag with AES-GCM in HSM
+func (session *Session) Open(iv, aad, ciphertext []byte) ([]byte, error) {
+	// some modules require output buffer not less than input
+	return session.aesGCM(true, iv, aad, ciphertext, len(ciphertext))
+}
+
+// Close closes session, which logs out of token, and unloads module
+func (session *Session) Close() error {
+	if session.handle == nil {
+		return nil
+	}
+	err := check("C_CloseSession", C.close_session(session.functions, session.session))
+	C.finalize(session.functions)
+	C.unload_module(session.handle)
+	session.handle = nil
+	return err
+}
@@ -0,0 +1,293 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/themis/gothemis/keys"
+	"github.com/cossacklabs/themis/gothemis/message"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Errors of revocation list
+var (
+	ErrKeyRevoked                     = acraerrors.New(acraerrors.CodeKeyRevoked, "keys of revoked client or zone id can't be used")
+	ErrInvalidRevocationListSignature = acraerrors.New(acraerrors.CodeInvalidRevocationListSignature, "revocation list isn't signed with trusted key")
+	ErrInvalidRevocationList          = acraerrors.New(acraerrors.CodeInvalidRevocationList, "revocation list should contain valid client and zone ids")
+)
+
+// RevocationConfig lists revoked client ids and zone ids. Revocation list file contains it in YAML format signed with
+// Themis Secure Message
+type RevocationConfig struct {
+	Clients []string `yaml:"clients"`
+	Zones   []string `yaml:"zones"`
+}
+
+// RevocationList answers whether client or zone id is revoked. List may be reloaded from file at runtime, so
+// RevocationList is safe for concurrent use
+type RevocationList struct {
+	mutex     sync.RWMutex
+	publicKey *keys.PublicKey
+	clients   map[string]bool
+	zones     map[string]bool
+}
+
+// SignRevocationConfig returns config in YAML format signed with privateKey
+func SignRevocationConfig(config *RevocationConfig, privateKey *keys.PrivateKey) ([]byte, error) {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	return message.New(privateKey, nil).Sign(data)
+}
+
+// VerifyRevocationConfig verifies signature of revocation list with publicKey and returns its config
+func VerifyRevocationConfig(signed []byte, publicKey *keys.PublicKey) (*RevocationConfig, error) {
+	data, err := message.New(nil, publicKey).Verify(signed)
+	if err != nil {
+		return nil, ErrInvalidRevocationListSignature
+	}
+	config := &RevocationConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	for _, ids := range [][]string{config.Clients, config.Zones} {
+		for _, id := range ids {
+			if !ValidateID([]byte(id)) {
+				return nil, ErrInvalidRevocationList
+			}
+		}
+	}
+	return config, nil
+}
+
+// NewRevocationList returns RevocationList from signed data which is verified with publicKey
+func NewRevocationList(signed []byte, publicKey *keys.PublicKey) (*RevocationList, error) {
+	list := &RevocationList{publicKey: publicKey}
+	if err := list.Load(signed); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Load replaces revoked ids with ids from signed data. Old ids are kept if data is invalid or isn't signed with trusted key
+func (list *RevocationList) Load(signed []byte) error {
+	config, err := VerifyRevocationConfig(signed, list.publicKey)
+	if err != nil {
+		return err
+	}
+	clients := make(map[string]bool, len(config.Clients))
+	for _, id := range config.Clients {
+		clients[id] = true
+	}
+	zones := make(map[string]bool, len(config.Zones))
+	for _, id := range config.Zones {
+		zones[id] = true
+	}
+	list.mutex.Lock()
+	list.clients, list.zones = clients, zones
+	list.mutex.Unlock()
+	return nil
+}
+
+// IsClientRevoked returns true if client id is revoked. nil list doesn't revoke anything
+func (list *RevocationList) IsClientRevoked(id []byte) bool {
+	if list == nil {
+		return false
+	}
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	return list.clients[string(id)]
+}
+
+// IsZoneRevoked returns true if zone id is revoked. nil list doesn't revoke anything
+func (list *RevocationList) IsZoneRevoked(id []byte) bool {
+	if list == nil {
+		return false
+	}
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	return list.zones[string(id)]
+}
+
+// NewRevocationListFromFile loads signed list from file and reloads it when modification time of file changes. File is
+// checked every reloadInterval, 0 turns off reloading
+func NewRevocationListFromFile(path string, publicKey *keys.PublicKey, reloadInterval time.Duration) (*RevocationList, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	list, err := NewRevocationList(data, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if reloadInterval > 0 {
+		go list.watchFile(path, info.ModTime(), reloadInterval)
+	}
+	return list, nil
+}
+
+func (list *RevocationList) watchFile(path string, modTime time.Time, interval time.Duration) {
+	logger := log.WithField("revocation_list_file", path)
+	for range time.Tick(interval) {
+		info, err := os.Stat(path)
+		if err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Warningln("Can't check revocation list, previous list is used")
+			continue
+		}
+		if info.ModTime().Equal(modTime) {
+			continue
+		}
+		modTime = info.ModTime()
+		data, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = list.Load(data)
+		}
+		if err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't reload revocation list, previous list is used")
+			continue
+		}
+		logger.Infoln("Reloaded revocation list")
+	}
+}
+
+// RevocationKeyStore wraps KeyStore and refuses to return keys of revoked client and zone ids, so their data can't be
+// decrypted and Secure Session handshakes with revoked clients fail
+type RevocationKeyStore struct {
+	KeyStore
+	list *RevocationList
+}
+
+// listingRevocationKeyStore is RevocationKeyStore of keystore which can list keys
+type listingRevocationKeyStore struct {
+	*RevocationKeyStore
+	KeyLister
+}
+
+// NewRevocationKeyStore returns store which checks ids in list before access to keys. Returned store implements
+// KeyLister if store does
+func NewRevocationKeyStore(store KeyStore, list *RevocationList) KeyStore {
+	revocationStore := &RevocationKeyStore{KeyStore: store, list: list}
+	if lister, ok := store.(KeyLister); ok {
+		return &listingRevocationKeyStore{RevocationKeyStore: revocationStore, KeyLister: lister}
+	}
+	return revocationStore
+}
+
+func (store *RevocationKeyStore) checkClient(id []byte) error {
+	if store.list.IsClientRevoked(id) {
+		log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeErrorKeyRevoked, "client_id": string(id)}).
+			Warningln("Refused access to keys of revoked client id")
+		return ErrKeyRevoked
+	}
+	return nil
+}
+
+func (store *RevocationKeyStore) checkZone(id []byte) error {
+	if store.list.IsZoneRevoked(id) {
+		log.WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeErrorKeyRevoked, "zone_id": string(id)}).
+			Warningln("Refused access to keys of revoked zone id")
+		return ErrKeyRevoked
+	}
+	return nil
+}
+
+// GetPrivateKey returns transport private key if client id isn't revoked
+func (store *RevocationKeyStore) GetPrivateKey(id []byte) (*keys.PrivateKey, error) {
+	if err := store.checkClient(id); err != nil {
+		return nil, err
+	}
+	return store.KeyStore.GetPrivateKey(id)
+}
+
+// GetPeerPublicKey returns transport public key of peer if client id isn't revoked
+func (store *RevocationKeyStore) GetPeerPublicKey(id []byte) (*keys.PublicKey, error) {
+	if err := store.checkClient(id); err != nil {
+		return nil, err
+	}
+	return store.KeyStore.GetPeerPublicKey(id)
+}
+
+// GetZonePrivateKey returns private key of zone if zone id isn't revoked
+func (store *RevocationKeyStore) GetZonePrivateKey(id []byte) (*keys.PrivateKey, error) {
+	if err := store.checkZone(id); err != nil {
+		return nil, err
+	}
+	return store.KeyStore.GetZonePrivateKey(id)
+}
+
+// HasZonePrivateKey returns false for revoked zone id, so its data isn't recognized as zone data
+func (store *RevocationKeyStore) HasZonePrivateKey(id []byte) bool {
+	if store.checkZone(id) != nil {
+		return false
+	}
+	return store.KeyStore.HasZonePrivateKey(id)
+}
+
+// GetZonePrivateKeys returns all versions of private key of zone if zone id isn't revoked
+func (store *RevocationKeyStore) GetZonePrivateKeys(id []byte) ([]*keys.PrivateKey, error) {
+	if err := store.checkZone(id); err != nil {
+		return nil, err
+	}
+	return store.KeyStore.GetZonePrivateKeys(id)
+}
+
+// GetServerDecryptionPrivateKey returns private key of client for decryption if client id isn't revoked
+func (store *RevocationKeyStore) GetServerDecryptionPrivateKey(id []byte) (*keys.PrivateKey, error) {
+	if err := store.checkClient(id); err != nil {
+		return nil, err
+	}
+	return store.KeyStore.GetServerDecryptionPrivateKey(id)
+}
+
+// GetServerDecryptionPrivateKeys returns all versions of private key of client for decryption if client id isn't revoked
+func (store *RevocationKeyStore) GetServerDecryptionPrivateKeys(id []byte) ([]*keys.PrivateKey, error) {
+	if err := store.checkClient(id); err != nil {
+		return nil, err
+	}
+	return store.KeyStore.GetServerDecryptionPrivateKeys(id)
+}
+
+// GetHMACSecretKey returns key for searchable hashes of client if client id isn't revoked
+func (store *RevocationKeyStore) GetHMACSecretKey(id []byte) ([]byte, error) {
+	if err := store.checkClient(id); err != nil {
+		return nil, err
+	}
+	return store.KeyStore.GetHMACSecretKey(id)
+}
+
+// GetComparatorSecret returns secret shared with AcraConnector of client if client id isn't revoked, so revoked
+// connectors can't authenticate with Secure Comparator
+func (store *RevocationKeyStore) GetComparatorSecret(id []byte) ([]byte, error) {
+	if err := store.checkClient(id); err != nil {
+		return nil, err
+	}
+	return getComparatorSecret(store.KeyStore, id)
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"testing"
+
+	"github.com/cossacklabs/themis/gothemis/keys"
+)
+
+// testRevocationKeyStore returns keys for any id, other methods of KeyStore aren't implemented
+type testRevocationKeyStore struct {
+	KeyStore
+}
+
+func (testRevocationKeyStore) GetPeerPublicKey(id []byte) (*keys.PublicKey, error) {
+	return &keys.PublicKey{Value: id}, nil
+}
+
+func (testRevocationKeyStore) GetServerDecryptionPrivateKeys(id []byte) ([]*keys.PrivateKey, error) {
+	return []*keys.PrivateKey{{Value: id}}, nil
+}
+
+func (testRevocationKeyStore) HasZonePrivateKey(id []byte) bool {
+	return true
+}
+
+func TestRevocationList(t *testing.T) {
+	keypair, err := keys.New(keys.KEYTYPE_EC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := SignRevocationConfig(&RevocationConfig{Clients: []string{"revoked_client"}, Zones: []string{"DDDDDDDDrevokedzone"}}, keypair.Private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := NewRevocationList(signed, keypair.Public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !list.IsClientRevoked([]byte("revoked_client")) || list.IsClientRevoked([]byte("client")) {
+		t.Fatal("Unexpected revocation of clients")
+	}
+	if !list.IsZoneRevoked([]byte("DDDDDDDDrevokedzone")) || list.IsZoneRevoked([]byte("revoked_client")) {
+		t.Fatal("Unexpected revocation of zones")
+	}
+
+	invalid, err := SignRevocationConfig(&RevocationConfig{Clients: []string{"!"}}, keypair.Private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := list.Load(invalid); err != ErrInvalidRevocationList {
+		t.Fatalf("Expected ErrInvalidRevocationList, took %v", err)
+	}
+	if !list.IsClientRevoked([]byte("revoked_client")) {
+		t.Fatal("Previous list should be kept after invalid one")
+	}
+
+	var nilList *RevocationList
+	if nilList.IsClientRevoked([]byte("revoked_client")) || nilList.IsZoneRevoked([]byte("DDDDDDDDrevokedzone")) {
+		t.Fatal("nil list shouldn't revoke anything")
+	}
+
+	store := NewRevocationKeyStore(testRevocationKeyStore{}, list)
+	if _, ok := store.(KeyLister); ok {
+		t.Fatal("Store shouldn't implement KeyLister if wrapped keystore doesn't")
+	}
+	if _, err := store.GetPeerPublicKey([]byte("revoked_client")); err != ErrKeyRevoked {
+		t.Fatalf("Expected ErrKeyRevoked, took %v", err)
+	}
+	if _, err := store.GetServerDecryptionPrivateKeys([]byte("revoked_client")); err != ErrKeyRevoked {
+		t.Fatalf("Expected ErrKeyRevoked, took %v", err)
+	}
+	if _, err := store.GetPeerPublicKey([]byte("client")); err != nil {
+		t.Fatal(err)
+	}
+	comparatorStore, ok := store.(interface {
+		GetComparatorSecret(id []byte) ([]byte, error)
+	})
+	if !ok {
+		t.Fatal("Store should return comparator secrets of wrapped keystore")
+	}
+	if _, err := comparatorStore.GetComparatorSecret([]byte("revoked_client")); err != ErrKeyRevoked {
+		t.Fatalf("Expected ErrKeyRevoked, took %v", err)
+	}
+	if _, err := comparatorStore.GetComparatorSecret([]byte("client")); err != ErrUnsupportedKeyPurpose {
+		t.Fatalf("Expected ErrUnsupportedKeyPurpose, took %v", err)
+	}
+	if store.HasZonePrivateKey([]byte("DDDDDDDDrevokedzone")) || !store.HasZonePrivateKey([]byte("DDDDDDDDotherzone")) {
+		t.Fatal("Unexpected zone keys")
+	}
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"crypto/sha256"
+	"io"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/utils"
+	"golang.org/x/crypto/hkdf"
+)
+
+// RowKeyLength is length of keys derived for rows
+const RowKeyLength = 32
+
+// rowKeyInfo is prefix of HKDF info which separates row keys from other keys derived from the same symmetric key
+var rowKeyInfo = []byte("acra row key\x00")
+
+// ErrEmptyRowContext returned if row key requested without context of row
+var ErrEmptyRowContext = acraerrors.New(acraerrors.CodeEmptyRowContext, "row context is empty")
+
+// DeriveRowKey derives key of one row from symmetric key of client or zone with HKDF-SHA256. rowContext identifies
+// row, for example table name and primary key, so compromise of one row's key doesn't reveal keys of other rows while
+// keystore stores only one key per client or zone. Returned key should be zeroed after usage
+func DeriveRowKey(masterKey, rowContext []byte) ([]byte, error) {
+	if len(rowContext) == 0 {
+		return nil, ErrEmptyRowContext
+	}
+	info := make([]byte, 0, len(rowKeyInfo)+len(rowContext))
+	info = append(append(info, rowKeyInfo...), rowContext...)
+	rowKey := make([]byte, RowKeyLength)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, masterKey, nil, info), rowKey); err != nil {
+		return nil, err
+	}
+	return rowKey, nil
+}
+
+// GetRowKey returns key of row derived from symmetric key of client or zone id
+func GetRowKey(store SymmetricKeyStore, id, rowContext []byte) ([]byte, error) {
+	if len(rowContext) == 0 {
+		return nil, ErrEmptyRowContext
+	}
+	masterKey, err := store.GetSymmetricKey(id)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.FillSlice(byte(0), masterKey)
+	return DeriveRowKey(masterKey, rowContext)
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+type testSymmetricKeyStore map[string][]byte
+
+func (store testSymmetricKeyStore) GenerateSymmetricKey(id []byte) error {
+	return nil
+}
+
+func (store testSymmetricKeyStore) GetSymmetricKey(id []byte) ([]byte, error) {
+	return append([]byte{}, store[string(id)]...), nil
+}
+
+func TestDeriveRowKey(t *testing.T) {
+	masterKey := []byte("master key")
+	rowKey, err := DeriveRowKey(masterKey, []byte("users:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// HKDF-SHA256 without salt and with info "acra row key\x00users:1"
+	if hex.EncodeToString(rowKey) != "1c880d1465434bb81a0ccff0851b079ccf8a7e0af74b237ee3cb636af5904fca" {
+		t.Fatalf("Unexpected row key %x", rowKey)
+	}
+	otherRowKey, err := DeriveRowKey(masterKey, []byte("users:2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(rowKey, otherRowKey) {
+		t.Fatal("Rows have equal keys")
+	}
+	otherMasterRowKey, err := DeriveRowKey([]byte("other master key"), []byte("users:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(rowKey, otherMasterRowKey) {
+		t.Fatal("Row keys of different master keys are equal")
+	}
+	if _, err := DeriveRowKey(masterKey, nil); err != ErrEmptyRowContext {
+		t.Fatalf("Expected ErrEmptyRowContext, took %v", err)
+	}
+
+	store := testSymmetricKeyStore{"client": masterKey}
+	storedRowKey, err := GetRowKey(store, []byte("client"), []byte("users:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(storedRowKey, rowKey) {
+		t.Fatal("Row key of keystore differs from derived key")
+	}
+	if !bytes.Equal(store["client"], []byte("master key")) {
+		t.Fatal("Stored key was changed")
+	}
+}
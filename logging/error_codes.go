@@ -0,0 +1,45 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/sirupsen/logrus"
+)
+
+// FieldKeyErrorCode is key of field with stable code of error logged with WithError
+const FieldKeyErrorCode = "error_code"
+
+// getErrorCode returns stable code of error logged with entry data
+func getErrorCode(data logrus.Fields) (int, bool) {
+	err, ok := data[logrus.ErrorKey].(error)
+	if !ok {
+		return 0, false
+	}
+	code := acraerrors.CodeOf(err)
+	if code == acraerrors.CodeUnknown {
+		return 0, false
+	}
+	return int(code), true
+}
+
+// addErrorCode adds stable code of error logged with entry data, so logs processing may rely on code instead of message
+func addErrorCode(data logrus.Fields) {
+	if code, ok := getErrorCode(data); ok {
+		data[FieldKeyErrorCode] = code
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/sirupsen/logrus"
+)
+
+func TestErrorCodeField(t *testing.T) {
+	testErr := acraerrors.New(acraerrors.Code(9900), "test error")
+	for _, err := range []error{testErr, acraerrors.Wrap(testErr, "context")} {
+		entry := logrus.NewEntry(logrus.New()).WithError(err)
+		entry.Message = "failed"
+		entry.Level = logrus.ErrorLevel
+
+		data, err := JSONFormatter(logrus.Fields{FieldKeyProduct: "acra-test"}).Format(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), `"error_code":9900`) {
+			t.Fatalf("JSON log doesn't contain error code: %s", data)
+		}
+		data, err = CEFFormatter(logrus.Fields{FieldKeyProduct: "acra-test"}).Format(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "error_code=9900") {
+			t.Fatalf("CEF log doesn't contain error code: %s", data)
+		}
+		data, err = NewGELFFormatter(logrus.Fields{FieldKeyProduct: "acra-test"}).Format(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), `"_error_code":9900`) {
+			t.Fatalf("GELF log doesn't contain error code: %s", data)
+		}
+	}
+
+	entry := logrus.NewEntry(logrus.New()).WithError(errors.New("unregistered"))
+	data, err := JSONFormatter(logrus.Fields{FieldKeyProduct: "acra-test"}).Format(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), FieldKeyErrorCode) {
+		t.Fatalf("JSON log contains code of unregistered error: %s", data)
+	}
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Field keys used when event code of entry has mapping to custom vendor event code
+const (
+	FieldKeyVendorEventCode = "vendor_code"
+	FieldKeyAcraEventCode   = "acra_code"
+)
+
+// ErrInvalidEventCodeMapping returned if mapping has empty vendor event codes
+var ErrInvalidEventCodeMapping = errors.New("invalid event code mapping, expected non empty vendor event codes")
+
+// eventCodeMapping maps Acra event codes to event codes of SIEM taxonomy used by deployment
+var eventCodeMapping = struct {
+	lock  sync.RWMutex
+	codes map[string]string
+}{codes: make(map[string]string)}
+
+// RegisterEventCodeMapping maps Acra event code to custom vendor event code. Mapped code is used as signature ID of
+// CEF logs and added as vendor_code field to JSON and GELF logs
+func RegisterEventCodeMapping(code int, vendorCode string) error {
+	if vendorCode == "" {
+		return ErrInvalidEventCodeMapping
+	}
+	eventCodeMapping.lock.Lock()
+	eventCodeMapping.codes[fmt.Sprint(code)] = vendorCode
+	eventCodeMapping.lock.Unlock()
+	return nil
+}
+
+// LoadEventCodeMapping replaces registered mappings with mappings from configuration in YAML format like
+// "584: TLS-FAILURE". Old mappings are kept if configuration is invalid
+func LoadEventCodeMapping(data []byte) error {
+	config := map[int]string{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return err
+	}
+	codes := make(map[string]string, len(config))
+	for code, vendorCode := range config {
+		if vendorCode == "" {
+			return ErrInvalidEventCodeMapping
+		}
+		codes[fmt.Sprint(code)] = vendorCode
+	}
+	eventCodeMapping.lock.Lock()
+	eventCodeMapping.codes = codes
+	eventCodeMapping.lock.Unlock()
+	return nil
+}
+
+// LoadEventCodeMappingFromFile replaces registered mappings with mappings from file in YAML format
+func LoadEventCodeMappingFromFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return LoadEventCodeMapping(data)
+}
+
+// GetVendorEventCode returns vendor event code mapped to Acra event code
+func GetVendorEventCode(code interface{}) (string, bool) {
+	eventCodeMapping.lock.RLock()
+	defer eventCodeMapping.lock.RUnlock()
+	if len(eventCodeMapping.codes) == 0 {
+		return "", false
+	}
+	vendorCode, ok := eventCodeMapping.codes[fmt.Sprint(code)]
+	return vendorCode, ok
+}
+
+// addVendorEventCode adds vendor event code mapped to event code of entry data
+func addVendorEventCode(data logrus.Fields) {
+	code, ok := data[FieldKeyEventCode]
+	if !ok {
+		return
+	}
+	if vendorCode, ok := GetVendorEventCode(code); ok {
+		data[FieldKeyVendorEventCode] = vendorCode
+	}
+}
+
+// replaceEventCodeWithVendor replaces event code of entry data with mapped vendor event code and keeps Acra event
+// code in separate field
+func replaceEventCodeWithVendor(data logrus.Fields) {
+	code, ok := data[FieldKeyEventCode]
+	if !ok {
+		return
+	}
+	if vendorCode, ok := GetVendorEventCode(code); ok {
+		data[FieldKeyAcraEventCode] = code
+		data[FieldKeyEventCode] = vendorCode
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestEventCodeMapping(t *testing.T) {
+	if err := LoadEventCodeMapping([]byte("584: \"\"")); err != ErrInvalidEventCodeMapping {
+		t.Fatalf("Expected ErrInvalidEventCodeMapping, took %v", err)
+	}
+	if err := LoadEventCodeMapping([]byte("584: TLS-FAILURE\n620: ACCESS-DENIED")); err != nil {
+		t.Fatal(err)
+	}
+	defer LoadEventCodeMapping(nil)
+	if err := RegisterEventCodeMapping(EventCodeErrorAuthenticationFailed, "AUTH-FAILURE"); err != nil {
+		t.Fatal(err)
+	}
+	if code, ok := GetVendorEventCode(584); !ok || code != "TLS-FAILURE" {
+		t.Fatalf("Unexpected vendor code %v", code)
+	}
+	if _, ok := GetVendorEventCode(EventCodeGeneral); ok {
+		t.Fatal("Unexpected vendor code of unmapped event code")
+	}
+
+	entry := logrus.NewEntry(logrus.New()).WithField(FieldKeyEventCode, EventCodeErrorAuthenticationFailed)
+	entry.Message = "unauthorized"
+	entry.Level = logrus.WarnLevel
+
+	data, err := CEFFormatter(logrus.Fields{FieldKeyProduct: "acra-test"}).Format(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "|AUTH-FAILURE|unauthorized|") || !strings.Contains(string(data), "acra_code=623") {
+		t.Fatalf("CEF log doesn't use vendor code: %s", data)
+	}
+	data, err = JSONFormatter(logrus.Fields{FieldKeyProduct: "acra-test"}).Format(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"code":623`) || !strings.Contains(string(data), `"vendor_code":"AUTH-FAILURE"`) {
+		t.Fatalf("JSON log doesn't contain vendor code: %s", data)
+	}
+	data, err = NewGELFFormatter(logrus.Fields{FieldKeyProduct: "acra-test"}).Format(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"_vendor_code":"AUTH-FAILURE"`) {
+		t.Fatalf("GELF log doesn't contain vendor code: %s", data)
+	}
+}
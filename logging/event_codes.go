@@ -21,6 +21,33 @@ const (
 	// 100 .. 200 some events
 	EventCodeGeneral = 100
 
+	// connection terminated by operator with HTTP API
+	EventCodeConnectionTerminated = 101
+
+	// key escrow
+	EventCodeKeyEscrowExport  = 110
+	EventCodeKeyEscrowRecover = 111
+
+	// key operations
+	EventCodeKeyGenerated        = 112
+	EventCodeKeyRotated          = 113
+	EventCodeKeyExported         = 114
+	EventCodeKeyImported         = 115
+	EventCodeKeyReplicated       = 116
+	EventCodeKeyDestroyed        = 117
+	EventCodeKeyCacheInvalidated = 118
+	EventCodeKeyRevoked          = 119
+
+	// poison records
+	EventCodePoisonRecordDetected = 120
+	EventCodePoisonRecordCallback = 121
+
+	// key encrypted with previous master key is rewrapped with current master key
+	EventCodeKeyRewrapped = 130
+
+	// option is set with deprecated name which is mapped to new name
+	EventCodeWarningDeprecatedOption = 140
+
 	// 500 .. 600 errors
 	EventCodeErrorGeneral    = 500
 	EventCodeErrorWrongParam = 501
@@ -33,14 +60,19 @@ const (
 	EventCodeErrorCantCloseConnectionToService = 509
 
 	// keys
-	EventCodeErrorCantInitKeyStore = 510
-	EventCodeErrorCantReadKeys     = 511
+	EventCodeErrorCantInitKeyStore        = 510
+	EventCodeErrorCantReadKeys            = 511
+	EventCodeWarningKeyExpiresSoon        = 512
+	EventCodeErrorKeyRevoked              = 513
+	EventCodeErrorCantWriteKeyAccessAudit = 514
+	EventCodeErrorCantSyncStandbyState    = 515
 
 	// system events
 	EventCodeErrorCantGetFileDescriptor     = 520
 	EventCodeErrorCantOpenFileByDescriptor  = 521
 	EventCodeErrorFileDescriptionIsNotValid = 522
 	EventCodeErrorCantRegisterSignalHandler = 523
+	EventCodeErrorLifecycleHookFailed       = 524
 
 	// transport / networks
 	EventCodeErrorCantStartListenConnections = 530
@@ -57,6 +89,7 @@ const (
 	// database
 	EventCodeErrorCantConnectToDB       = 540
 	EventCodeErrorCantCloseConnectionDB = 541
+	EventCodeErrorDBConnectionLost      = 542
 
 	// AcraWebconfig
 	EventCodeErrorCantReadTemplate        = 550
@@ -68,6 +101,7 @@ const (
 	EventCodeErrorCantGetAuthData         = 556
 	EventCodeErrorCantParseAuthData       = 557
 	EventCodeErrorCantDumpConfig          = 558
+	EventCodeErrorCantManageKeys          = 559
 
 	// acracensor
 	EventCodeErrorCensorQueryIsNotAllowed   = 560
@@ -94,12 +128,50 @@ const (
 	EventCodeErrorDecryptorCantInitializeTLS                 = 584
 	EventCodeErrorDecryptorCantSetDeadlineToClientConnection = 585
 	EventCodeErrorDecryptorCantDecryptSymmetricKey           = 586
+	EventCodeErrorDecryptorInvalidQueryDirectives            = 587
+	EventCodeErrorDecryptorCantInferZone                     = 588
+	EventCodeErrorDecryptorLengthMismatch                    = 589
 
 	// api
-	EventCodeErrorCantGenerateZone = 590
+	EventCodeErrorCantGenerateZone    = 590
+	EventCodeErrorHTTPAPIUnauthorized = 591
+	EventCodeErrorZoneQuotaExceeded   = 592
 
 	// mysql processing
-	EventCodeErrorProtocolProcessing = 600
+	EventCodeErrorProtocolProcessing        = 600
+	EventCodeErrorUnsupportedClientProtocol = 601
+	EventCodeErrorLocalInfileDenied         = 602
+
+	// encryptor
+	EventCodeErrorEncryptorSetupError       = 610
+	EventCodeErrorEncryptorCantProcessQuery = 611
+	EventCodeWarningEncryptorSchemaMismatch = 612
+	EventCodeErrorEncryptorCantReadSchema   = 613
+	EventCodeErrorEncryptorQueryRejected    = 614
+
+	// access control
+	EventCodeErrorConnectionDenied     = 620
+	EventCodeErrorHandshakeBanned      = 621
+	EventCodeWarningHandshakeBanning   = 622
+	EventCodeErrorAuthenticationFailed = 623
+	EventCodeErrorHandshakeTimeout     = 624
+	EventCodeErrorDBStartupTimeout     = 625
+
+	// tls
+	EventCodeErrorTLSHandshakeFailed       = 630
+	EventCodeErrorTLSCantLoadCertificates  = 631
+	EventCodeWarningCertificateExpiresSoon = 632
+	EventCodeErrorTLSRequired              = 633
+
+	// startup self-check
+	EventCodeErrorSelfCheckFailed = 640
+	EventCodeWarningSelfCheck     = 641
+
+	// data loss prevention
+	EventCodeWarningDLPSensitiveDataDetected = 650
+
+	// decryption latency budget
+	EventCodeWarningDecryptionLatencyBudgetExceeded = 660
 
 	// AcraTranslator
 	EventCodeErrorTranslatorCantHandleHTTPRequest       = 700
@@ -116,4 +188,9 @@ const (
 	EventCodeErrorTranslatorCantWrapConnectionToSS      = 711
 	EventCodeErrorTranslatorCantAcceptNewHTTPConnection = 712
 	EventCodeErrorTranslatorCantHandleGRPCConnection    = 713
+	EventCodeErrorTranslatorCantWriteAuditLog           = 714
+	EventCodeErrorTranslatorUnauthorizedRequest         = 715
+	EventCodeErrorTranslatorCantUnwrapThemisPayload     = 716
+	EventCodeErrorTranslatorCantFetchObject             = 717
+	EventCodeErrorTranslatorCantDecryptObject           = 718
 )
@@ -0,0 +1,105 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// GELFVersion is version of Graylog Extended Log Format
+// http://docs.graylog.org/en/latest/pages/gelf.html
+const GELFVersion = "1.1"
+
+// gelfSyslogLevels maps logrus levels to syslog severity used by GELF
+var gelfSyslogLevels = map[logrus.Level]int{
+	logrus.PanicLevel: 0,
+	logrus.FatalLevel: 2,
+	logrus.ErrorLevel: 3,
+	logrus.WarnLevel:  4,
+	logrus.InfoLevel:  6,
+	logrus.DebugLevel: 7,
+	logrus.TraceLevel: 7,
+}
+
+// GELFFormatter formats entries as GELF messages, fields of entry are added as additional fields with underscore prefix
+type GELFFormatter struct {
+	Host   string
+	Fields logrus.Fields
+}
+
+// NewGELFFormatter returns GELFFormatter with hostname of current host and default Acra fields
+func NewGELFFormatter(fields logrus.Fields) *GELFFormatter {
+	for k, v := range extraJSONFields {
+		if _, ok := fields[k]; !ok {
+			fields[k] = v
+		}
+	}
+	delete(fields, FieldKeyUnixTime)
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return &GELFFormatter{Host: host, Fields: fields}
+}
+
+// gelfFieldValue returns value which may be serialized to JSON
+func gelfFieldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return v
+	}
+	return fmt.Sprint(value)
+}
+
+// Format returns GELF message of entry ended with new line
+func (formatter *GELFFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	message := make(map[string]interface{}, len(formatter.Fields)+len(entry.Data)+5)
+	for k, v := range formatter.Fields {
+		message["_"+k] = gelfFieldValue(v)
+	}
+	for k, v := range entry.Data {
+		// _id is reserved by GELF
+		if k == "id" {
+			k = "id_"
+		}
+		message["_"+k] = gelfFieldValue(v)
+	}
+	if code, ok := entry.Data[FieldKeyEventCode]; ok {
+		if vendorCode, ok := GetVendorEventCode(code); ok {
+			message["_"+FieldKeyVendorEventCode] = vendorCode
+		}
+	}
+	if code, ok := getErrorCode(entry.Data); ok {
+		message["_"+FieldKeyErrorCode] = code
+	}
+	message["version"] = GELFVersion
+	message["host"] = formatter.Host
+	message["short_message"] = entry.Message
+	message["timestamp"] = float64(entry.Time.UnixNano()/int64(1000000)) / 1000
+	message["level"] = gelfSyslogLevels[entry.Level]
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
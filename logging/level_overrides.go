@@ -0,0 +1,151 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"net"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Fields of client loggers
+const (
+	FieldKeyClientID      = "client_id"
+	FieldKeyRemoteAddress = "remote_address"
+)
+
+// clientLogger is logger of one client's connection with its level override keys
+type clientLogger struct {
+	clientID string
+	ip       string
+}
+
+// levelOverrides stores log levels raised for client ids and ips and loggers of active connections they apply to
+type levelOverrides struct {
+	lock    sync.Mutex
+	clients map[string]logrus.Level
+	ips     map[string]logrus.Level
+	loggers map[*logrus.Logger]clientLogger
+}
+
+var overrides = &levelOverrides{
+	clients: make(map[string]logrus.Level),
+	ips:     make(map[string]logrus.Level),
+	loggers: make(map[*logrus.Logger]clientLogger),
+}
+
+// level returns most verbose of global level and overrides of client. Must be called under lock
+func (overrides *levelOverrides) level(client clientLogger) logrus.Level {
+	level := logrus.GetLevel()
+	if clientLevel, ok := overrides.clients[client.clientID]; ok && clientLevel > level {
+		level = clientLevel
+	}
+	if ipLevel, ok := overrides.ips[client.ip]; ok && ipLevel > level {
+		level = ipLevel
+	}
+	return level
+}
+
+// update applies overrides to loggers of active connections. Must be called under lock
+func (overrides *levelOverrides) update() {
+	for logger, client := range overrides.loggers {
+		logger.SetLevel(overrides.level(client))
+	}
+}
+
+// hostFromAddress returns ip of address like ip:port or address as is
+func hostFromAddress(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
+
+// NewClientLogger returns logger for connection of client from remote address. Logger writes like standard logger but
+// its level is raised at runtime if log level is overridden for client id or ip of remote address. Logger must be
+// released with ReleaseClientLogger when connection is closed
+func NewClientLogger(clientID []byte, remoteAddress string) *logrus.Entry {
+	standard := logrus.StandardLogger()
+	logger := &logrus.Logger{
+		Out:          standard.Out,
+		Formatter:    standard.Formatter,
+		Hooks:        standard.Hooks,
+		ReportCaller: standard.ReportCaller,
+		ExitFunc:     standard.ExitFunc,
+	}
+	client := clientLogger{clientID: string(clientID), ip: hostFromAddress(remoteAddress)}
+	overrides.lock.Lock()
+	logger.SetLevel(overrides.level(client))
+	overrides.loggers[logger] = client
+	overrides.lock.Unlock()
+	return logger.WithFields(logrus.Fields{FieldKeyClientID: string(clientID), FieldKeyRemoteAddress: remoteAddress})
+}
+
+// ReleaseClientLogger stops applying level overrides to logger returned by NewClientLogger
+func ReleaseClientLogger(entry *logrus.Entry) {
+	overrides.lock.Lock()
+	delete(overrides.loggers, entry.Logger)
+	overrides.lock.Unlock()
+}
+
+// SetClientLogLevel raises log level of connections of client id, level less verbose than global doesn't change anything
+func SetClientLogLevel(clientID string, level logrus.Level) {
+	overrides.lock.Lock()
+	defer overrides.lock.Unlock()
+	overrides.clients[clientID] = level
+	overrides.update()
+}
+
+// SetIPLogLevel raises log level of connections from ip, level less verbose than global doesn't change anything
+func SetIPLogLevel(ip string, level logrus.Level) {
+	overrides.lock.Lock()
+	defer overrides.lock.Unlock()
+	overrides.ips[ip] = level
+	overrides.update()
+}
+
+// RemoveClientLogLevel returns log level of connections of client id to global level
+func RemoveClientLogLevel(clientID string) {
+	overrides.lock.Lock()
+	defer overrides.lock.Unlock()
+	delete(overrides.clients, clientID)
+	overrides.update()
+}
+
+// RemoveIPLogLevel returns log level of connections from ip to global level
+func RemoveIPLogLevel(ip string) {
+	overrides.lock.Lock()
+	defer overrides.lock.Unlock()
+	delete(overrides.ips, ip)
+	overrides.update()
+}
+
+// GetLogLevelOverrides returns copies of log levels overridden for client ids and ips
+func GetLogLevelOverrides() (clients, ips map[string]logrus.Level) {
+	overrides.lock.Lock()
+	defer overrides.lock.Unlock()
+	clients = make(map[string]logrus.Level, len(overrides.clients))
+	for clientID, level := range overrides.clients {
+		clients[clientID] = level
+	}
+	ips = make(map[string]logrus.Level, len(overrides.ips))
+	for ip, level := range overrides.ips {
+		ips[ip] = level
+	}
+	return clients, ips
+}
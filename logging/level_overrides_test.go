@@ -0,0 +1,71 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestClientLoggerLevelOverrides(t *testing.T) {
+	output := &bytes.Buffer{}
+	logrus.SetOutput(output)
+	defer logrus.SetOutput(nil)
+	SetLogLevel(LOG_VERBOSE)
+
+	quiet := NewClientLogger([]byte("quiet client"), "10.0.0.1:5432")
+	defer ReleaseClientLogger(quiet)
+	noisy := NewClientLogger([]byte("noisy client"), "10.0.0.2:5432")
+	defer ReleaseClientLogger(noisy)
+
+	SetClientLogLevel("noisy client", logrus.DebugLevel)
+	quiet.Debugln("quiet debug")
+	noisy.Debugln("noisy debug")
+	if strings.Contains(output.String(), "quiet debug") || !strings.Contains(output.String(), "noisy debug") {
+		t.Fatalf("Log level of client wasn't overridden: %s", output.String())
+	}
+	if !strings.Contains(output.String(), `client_id="noisy client"`) {
+		t.Fatalf("Client logger doesn't log client id: %s", output.String())
+	}
+
+	SetIPLogLevel("10.0.0.1", logrus.DebugLevel)
+	quiet.Debugln("ip debug")
+	if !strings.Contains(output.String(), "ip debug") {
+		t.Fatal("Log level of ip wasn't overridden")
+	}
+	clients, ips := GetLogLevelOverrides()
+	if clients["noisy client"] != logrus.DebugLevel || ips["10.0.0.1"] != logrus.DebugLevel {
+		t.Fatalf("Unexpected overrides %v %v", clients, ips)
+	}
+
+	RemoveClientLogLevel("noisy client")
+	RemoveIPLogLevel("10.0.0.1")
+	noisy.Debugln("removed debug")
+	if strings.Contains(output.String(), "removed debug") {
+		t.Fatal("Log level override wasn't removed")
+	}
+	// override less verbose than global level doesn't lower it
+	SetClientLogLevel("noisy client", logrus.WarnLevel)
+	defer RemoveClientLogLevel("noisy client")
+	noisy.Infoln("info of client")
+	if !strings.Contains(output.String(), "info of client") {
+		t.Fatal("Override lowered log level of client")
+	}
+}
@@ -155,6 +155,8 @@ var (
 func (f AcraJSONFormatter) Format(e *logrus.Entry) ([]byte, error) {
 	ne := copyEntry(e, f.Fields)
 	ne.Data[FieldKeyUnixTime] = unixTimeWithMilliseconds(e)
+	addVendorEventCode(ne.Data)
+	addErrorCode(ne.Data)
 	dataBytes, err := f.Formatter.Format(ne)
 	releaseEntry(ne)
 	return dataBytes, err
@@ -166,6 +168,8 @@ func (f AcraJSONFormatter) Format(e *logrus.Entry) ([]byte, error) {
 func (f AcraCEFFormatter) Format(e *logrus.Entry) ([]byte, error) {
 	ne := copyEntry(e, f.Fields)
 	ne.Data[FieldKeyUnixTime] = unixTimeWithMilliseconds(e)
+	replaceEventCodeWithVendor(ne.Data)
+	addErrorCode(ne.Data)
 	dataBytes, err := f.CEFTextFormatter.Format(ne)
 	releaseEntry(ne)
 	return dataBytes, err
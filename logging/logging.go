@@ -14,7 +14,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-// Package logging contains custom log formatters (plaintext, JSON and CEF) to use through Acra components.
+// Package logging contains custom log formatters (plaintext, JSON, CEF and GELF) to use through Acra components.
 // Logging mode and verbosity level can be configured for AcraServer, AcraConnector, and AcraWebConfig in the
 // corresponding yaml files or passed as CLI parameter.
 //
@@ -49,6 +49,10 @@ func SetLogLevel(level int) {
 	} else {
 		panic(fmt.Sprintf("Incorrect log level - %v", level))
 	}
+	// loggers of clients follow global level unless it's overridden
+	overrides.lock.Lock()
+	overrides.update()
+	overrides.lock.Unlock()
 }
 
 // GetLogLevel gets logrus log level and returns int Acra log level
@@ -78,11 +82,18 @@ func logFormatterFor(loggingFormat string, serviceName string) log.Formatter {
 
 	} else if loggingFormat == "cef" {
 		return CEFFormatter(log.Fields{FieldKeyProduct: serviceName})
+
+	} else if isGELFFormat(loggingFormat) {
+		return NewGELFFormatter(log.Fields{FieldKeyProduct: serviceName})
 	}
 
 	return TextFormatter()
 }
 
+func isGELFFormat(loggingFormat string) bool {
+	return strings.ToLower(loggingFormat) == "gelf"
+}
+
 // SetLoggerToContext sets logger to corresponded context
 func SetLoggerToContext(ctx context.Context, logger *log.Entry) context.Context {
 	return context.WithValue(ctx, loggerKey, logger)
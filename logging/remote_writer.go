@@ -0,0 +1,193 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Defaults of RemoteWriter
+const (
+	DefaultRemoteBufferSize        = 10000
+	DefaultRemoteReconnectDelay    = time.Second
+	DefaultRemoteMaxReconnectDelay = time.Second * 30
+	remoteDialTimeout              = time.Second * 5
+)
+
+// ErrUnsupportedRemoteAddress returned if address of remote log output has scheme other than tcp or udp
+var ErrUnsupportedRemoteAddress = errors.New("address of remote log output must be like tcp://host:port or udp://host:port")
+
+// RemoteWriter sends log messages to remote collector like Graylog or Logstash over TCP or UDP. Messages are buffered
+// and sent in background with reconnects, so logging never blocks on network. Messages are dropped if buffer is full.
+type RemoteWriter struct {
+	network   string
+	address   string
+	delimiter []byte
+	messages  chan []byte
+	dropped   uint64
+	// reconnectDelay grows twice after each failed connection up to maxReconnectDelay
+	reconnectDelay    time.Duration
+	maxReconnectDelay time.Duration
+	done              chan struct{}
+	closeOnce         sync.Once
+	stopped           chan struct{}
+}
+
+// NewRemoteWriter returns RemoteWriter which sends each message ended with delimiter instead of new line. Nil
+// delimiter keeps message as is which is used for datagrams
+func NewRemoteWriter(network, address string, delimiter []byte, bufferSize int) *RemoteWriter {
+	if bufferSize <= 0 {
+		bufferSize = DefaultRemoteBufferSize
+	}
+	writer := &RemoteWriter{
+		network:           network,
+		address:           address,
+		delimiter:         delimiter,
+		messages:          make(chan []byte, bufferSize),
+		reconnectDelay:    DefaultRemoteReconnectDelay,
+		maxReconnectDelay: DefaultRemoteMaxReconnectDelay,
+		done:              make(chan struct{}),
+		stopped:           make(chan struct{}),
+	}
+	go writer.run()
+	return writer
+}
+
+// Write queues copy of message to be sent. It never blocks and never returns error because logger can't log its own
+// failures
+func (writer *RemoteWriter) Write(data []byte) (int, error) {
+	message := data
+	if writer.delimiter != nil {
+		message = bytes.TrimRight(message, "\n")
+	}
+	// logrus reuses buffer of formatted entry so message must be copied
+	message = append(append(make([]byte, 0, len(message)+len(writer.delimiter)), message...), writer.delimiter...)
+	select {
+	case writer.messages <- message:
+	default:
+		atomic.AddUint64(&writer.dropped, 1)
+	}
+	return len(data), nil
+}
+
+// Dropped returns count of messages dropped because buffer was full
+func (writer *RemoteWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&writer.dropped)
+}
+
+// Close stops sending after all buffered messages are sent or timeout expired
+func (writer *RemoteWriter) Close(timeout time.Duration) {
+	writer.closeOnce.Do(func() {
+		close(writer.done)
+		select {
+		case <-writer.stopped:
+		case <-time.After(timeout):
+		}
+	})
+}
+
+// connect dials remote address until success or close of writer
+func (writer *RemoteWriter) connect() net.Conn {
+	delay := writer.reconnectDelay
+	for {
+		connection, err := net.DialTimeout(writer.network, writer.address, remoteDialTimeout)
+		if err == nil {
+			return connection
+		}
+		// can't use logger because it writes to this writer
+		fmt.Fprintf(os.Stderr, "Can't connect to remote log output %s: %v\n", writer.address, err)
+		select {
+		case <-writer.done:
+			return nil
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > writer.maxReconnectDelay {
+			delay = writer.maxReconnectDelay
+		}
+	}
+}
+
+// run sends buffered messages reconnecting on errors. Message which failed to be sent is retried after reconnect
+func (writer *RemoteWriter) run() {
+	defer close(writer.stopped)
+	var connection net.Conn
+	defer func() {
+		if connection != nil {
+			connection.Close()
+		}
+	}()
+	for {
+		var message []byte
+		select {
+		case message = <-writer.messages:
+		case <-writer.done:
+			// flush what was buffered before close
+			select {
+			case message = <-writer.messages:
+			default:
+				return
+			}
+		}
+		for {
+			if connection == nil {
+				if connection = writer.connect(); connection == nil {
+					return
+				}
+			}
+			if _, err := connection.Write(message); err == nil {
+				break
+			}
+			connection.Close()
+			connection = nil
+		}
+	}
+}
+
+// SetLogRemoteOutput duplicates logs written to stderr to remote collector by address like tcp://host:port or
+// udp://host:port. Messages of GELF format are delimited by null byte over TCP as Graylog expects, others by new line
+// as Logstash's json_lines codec expects
+func SetLogRemoteOutput(address, loggingFormat string, bufferSize int) (*RemoteWriter, error) {
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if (parsed.Scheme != "tcp" && parsed.Scheme != "udp") || parsed.Host == "" {
+		return nil, ErrUnsupportedRemoteAddress
+	}
+	var delimiter []byte
+	if parsed.Scheme == "tcp" {
+		delimiter = []byte{'\n'}
+		if isGELFFormat(loggingFormat) {
+			delimiter = []byte{0}
+		}
+	}
+	writer := NewRemoteWriter(parsed.Scheme, parsed.Host, delimiter, bufferSize)
+	logrus.SetOutput(io.MultiWriter(os.Stderr, writer))
+	return writer, nil
+}
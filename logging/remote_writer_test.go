@@ -0,0 +1,137 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestGELFFormatter(t *testing.T) {
+	formatter := NewGELFFormatter(logrus.Fields{FieldKeyProduct: "acra-server"})
+	entry := logrus.NewEntry(logrus.New()).WithFields(logrus.Fields{FieldKeyEventCode: 500, "id": "client", "error": errors.New("some error")})
+	entry.Message = "message"
+	entry.Level = logrus.ErrorLevel
+	entry.Time = time.Unix(1500000000, 123000000)
+	data, err := formatter.Format(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := map[string]interface{}{}
+	if err := json.Unmarshal(data, &message); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"version":       GELFVersion,
+		"host":          formatter.Host,
+		"short_message": "message",
+		"timestamp":     1500000000.123,
+		"level":         float64(3),
+		"_code":         float64(500),
+		"_id_":          "client",
+		"_error":        "some error",
+		"_product":      "acra-server",
+	}
+	for key, value := range expected {
+		if message[key] != value {
+			t.Errorf("Incorrect value of %s: %v", key, message[key])
+		}
+	}
+	if _, ok := message["_"+FieldKeyUnixTime]; ok {
+		t.Error("GELF message contains duplicated timestamp")
+	}
+}
+
+func TestRemoteWriterReconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := NewRemoteWriter("tcp", listener.Addr().String(), []byte{0}, 10)
+	writer.reconnectDelay = time.Millisecond * 10
+	defer writer.Close(time.Second)
+
+	readMessage := func(connection net.Conn) string {
+		connection.SetReadDeadline(time.Now().Add(time.Second * 5))
+		message, err := bufio.NewReader(connection).ReadBytes(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(message)
+	}
+
+	writer.Write([]byte("first\n"))
+	connection, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message := readMessage(connection); message != "first\x00" {
+		t.Fatalf("Unexpected message %q", message)
+	}
+	// collector restarted, first writes after close may be lost in socket's buffer so write until reconnect
+	connection.Close()
+	accepted := make(chan net.Conn)
+	go func() {
+		connection, err := listener.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- connection
+	}()
+	connection = nil
+	for i := 0; connection == nil && i < 500; i++ {
+		writer.Write([]byte("second\n"))
+		select {
+		case connection = <-accepted:
+		case <-time.After(time.Millisecond * 10):
+		}
+	}
+	if connection == nil {
+		t.Fatal("Writer didn't reconnect")
+	}
+	defer connection.Close()
+	if message := readMessage(connection); message != "second\x00" {
+		t.Fatalf("Unexpected message after reconnect %q", message)
+	}
+}
+
+func TestRemoteWriterDropsOnFullBuffer(t *testing.T) {
+	// nobody listens so messages stay in buffer
+	writer := &RemoteWriter{messages: make(chan []byte, 2), delimiter: []byte{'\n'}}
+	for i := 0; i < 5; i++ {
+		if n, err := writer.Write([]byte("message\n")); err != nil || n != len("message\n") {
+			t.Fatalf("Unexpected result of write %v, %v", n, err)
+		}
+	}
+	if writer.Dropped() != 3 {
+		t.Fatalf("Expected 3 dropped messages, took %v", writer.Dropped())
+	}
+	if message := <-writer.messages; !bytes.Equal(message, []byte("message\n")) {
+		t.Fatalf("Unexpected buffered message %q", message)
+	}
+	if _, err := SetLogRemoteOutput("http://localhost:12201", "gelf", 0); err != ErrUnsupportedRemoteAddress {
+		t.Fatalf("Expected ErrUnsupportedRemoteAddress, took %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FieldKeySampledOut is field with count of events of same category dropped by sampler before this one
+const FieldKeySampledOut = "sampled_out"
+
+// maxSamplingCategories limits count of tracked categories because messages formatted with variable values produce
+// new category on each event
+const maxSamplingCategories = 4096
+
+// samplingCategory is state of one category of events
+type samplingCategory struct {
+	count       uint64
+	windowStart time.Time
+	inWindow    int
+	sampledOut  int
+}
+
+// SamplingFormatter wraps formatter and drops part of high-volume debug and info events so that debug level may be
+// used in production. Events are grouped by category which is event code if set or message otherwise. Warnings and
+// errors are never dropped.
+type SamplingFormatter struct {
+	logrus.Formatter
+	// every is count of events of category from which only first one is logged, 0 or 1 logs all
+	every uint64
+	// perSecond is max count of logged events of category per second, 0 means unlimited
+	perSecond  int
+	lock       sync.Mutex
+	categories map[interface{}]*samplingCategory
+	now        func() time.Time
+}
+
+// NewSamplingFormatter returns formatter which logs every Nth event of category and no more than perSecond events of
+// category per second
+func NewSamplingFormatter(formatter logrus.Formatter, every, perSecond int) *SamplingFormatter {
+	if every < 1 {
+		every = 1
+	}
+	if perSecond < 0 {
+		perSecond = 0
+	}
+	return &SamplingFormatter{Formatter: formatter, every: uint64(every), perSecond: perSecond,
+		categories: make(map[interface{}]*samplingCategory), now: time.Now}
+}
+
+// samplingCategoryKey returns category of event
+func samplingCategoryKey(entry *logrus.Entry) interface{} {
+	if code, ok := entry.Data[FieldKeyEventCode]; ok {
+		return code
+	}
+	return entry.Message
+}
+
+// sample returns whether event should be logged and count of dropped events of its category since last logged one
+func (formatter *SamplingFormatter) sample(entry *logrus.Entry) (bool, int) {
+	formatter.lock.Lock()
+	defer formatter.lock.Unlock()
+	key := samplingCategoryKey(entry)
+	category, ok := formatter.categories[key]
+	if !ok {
+		if len(formatter.categories) >= maxSamplingCategories {
+			formatter.categories = make(map[interface{}]*samplingCategory)
+		}
+		category = &samplingCategory{}
+		formatter.categories[key] = category
+	}
+	category.count++
+	if (category.count-1)%formatter.every != 0 {
+		category.sampledOut++
+		return false, 0
+	}
+	if formatter.perSecond > 0 {
+		now := formatter.now()
+		if now.Sub(category.windowStart) >= time.Second {
+			category.windowStart = now
+			category.inWindow = 0
+		}
+		if category.inWindow >= formatter.perSecond {
+			category.sampledOut++
+			return false, 0
+		}
+		category.inWindow++
+	}
+	sampledOut := category.sampledOut
+	category.sampledOut = 0
+	return true, sampledOut
+}
+
+// Format formats event with wrapped formatter or returns empty output if event was dropped
+func (formatter *SamplingFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	if entry.Level < logrus.InfoLevel {
+		return formatter.Formatter.Format(entry)
+	}
+	logged, sampledOut := formatter.sample(entry)
+	if !logged {
+		return nil, nil
+	}
+	if sampledOut > 0 {
+		sampledEntry := entry.WithField(FieldKeySampledOut, sampledOut)
+		sampledEntry.Level = entry.Level
+		sampledEntry.Message = entry.Message
+		return formatter.Formatter.Format(sampledEntry)
+	}
+	return formatter.Formatter.Format(entry)
+}
+
+// SetLogSampling wraps current formatter of standard logger by SamplingFormatter. Does nothing if every and perSecond
+// don't limit events
+func SetLogSampling(every, perSecond int) {
+	if every <= 1 && perSecond <= 0 {
+		return
+	}
+	logger := logrus.StandardLogger()
+	logger.SetFormatter(NewSamplingFormatter(logger.Formatter, every, perSecond))
+	logrus.Debugf("Enabled sampling of debug and info logs: every %v event, max %v events per second", every, perSecond)
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newSamplingLogger(every, perSecond int) (*logrus.Logger, *SamplingFormatter, *bytes.Buffer) {
+	output := &bytes.Buffer{}
+	logger := logrus.New()
+	logger.SetOutput(output)
+	logger.SetLevel(logrus.DebugLevel)
+	formatter := NewSamplingFormatter(&logrus.TextFormatter{DisableTimestamp: true}, every, perSecond)
+	logger.SetFormatter(formatter)
+	return logger, formatter, output
+}
+
+func TestSamplingFormatterEvery(t *testing.T) {
+	logger, _, output := newSamplingLogger(3, 0)
+	for i := 0; i < 7; i++ {
+		logger.Debugln("decrypted")
+		logger.WithField(FieldKeyEventCode, 1).Debugf("event %v", i)
+		logger.Warningln("warning")
+	}
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	counts := map[string]int{}
+	for _, line := range lines {
+		switch {
+		case strings.Contains(line, "decrypted"):
+			counts["decrypted"]++
+		case strings.Contains(line, "event"):
+			counts["event"]++
+		case strings.Contains(line, "warning"):
+			counts["warning"]++
+		}
+	}
+	// 1st, 4th and 7th events of each category, warnings aren't sampled
+	if counts["decrypted"] != 3 || counts["event"] != 3 || counts["warning"] != 7 {
+		t.Fatalf("Unexpected counts of logged events %v", counts)
+	}
+	if !strings.Contains(output.String(), `msg="event 3" code=1 sampled_out=2`) {
+		t.Fatalf("Count of sampled out events wasn't logged: %s", output.String())
+	}
+}
+
+func TestSamplingFormatterPerSecond(t *testing.T) {
+	logger, formatter, output := newSamplingLogger(1, 2)
+	now := time.Now()
+	formatter.now = func() time.Time { return now }
+	for i := 0; i < 5; i++ {
+		logger.Infoln("connection")
+	}
+	if count := strings.Count(output.String(), "connection"); count != 2 {
+		t.Fatalf("Expected 2 events in second, took %v", count)
+	}
+	now = now.Add(time.Second)
+	logger.Infoln("connection")
+	if count := strings.Count(output.String(), "connection"); count != 3 {
+		t.Fatalf("Expected event in next second, took %v", count)
+	}
+	if !strings.Contains(output.String(), "sampled_out=3") {
+		t.Fatalf("Count of sampled out events wasn't logged: %s", output.String())
+	}
+}
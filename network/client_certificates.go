@@ -0,0 +1,87 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"crypto/tls"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/cossacklabs/acra/acraerrors"
+)
+
+// ErrInvalidClientCertificatesConfig returned if configuration contains item without client id, certificate or key
+var ErrInvalidClientCertificatesConfig = acraerrors.New(acraerrors.CodeInvalidClientCertificatesConfig, "invalid client certificates configuration, expected client_id, cert and key for each client")
+
+// ClientCertificateConfig is path to certificate and private key used in TLS connections to database for client id
+type ClientCertificateConfig struct {
+	ClientID string `yaml:"client_id"`
+	Cert     string `yaml:"cert"`
+	Key      string `yaml:"key"`
+}
+
+// ClientCertificatesConfig lists certificates used in TLS connections to database for different client ids
+type ClientCertificatesConfig struct {
+	Clients []ClientCertificateConfig `yaml:"clients"`
+}
+
+// ClientCertificates stores certificates which AcraServer presents to database instead of default one when it
+// connects on behalf of specific client id. It allows database to map clients to different users by certificate
+type ClientCertificates struct {
+	certificates map[string]*tls.Certificate
+}
+
+// LoadClientCertificates parses configuration in YAML format and loads all certificates and keys from it
+func LoadClientCertificates(data []byte) (*ClientCertificates, error) {
+	config := &ClientCertificatesConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	certificates := &ClientCertificates{certificates: make(map[string]*tls.Certificate, len(config.Clients))}
+	for _, client := range config.Clients {
+		if client.ClientID == "" || client.Cert == "" || client.Key == "" {
+			return nil, ErrInvalidClientCertificatesConfig
+		}
+		if _, ok := certificates.certificates[client.ClientID]; ok {
+			return nil, ErrInvalidClientCertificatesConfig
+		}
+		certificate, err := tls.LoadX509KeyPair(client.Cert, client.Key)
+		if err != nil {
+			return nil, err
+		}
+		certificates.certificates[client.ClientID] = &certificate
+	}
+	return certificates, nil
+}
+
+// ConfigForClientID returns copy of config which presents certificate of clientID when used for client side of TLS
+// connection. Server side of connection still uses certificates of config. Returns config as is if clientID has no
+// own certificate. Safe to call on nil ClientCertificates
+func (certificates *ClientCertificates) ConfigForClientID(config *tls.Config, clientID []byte) *tls.Config {
+	if certificates == nil || config == nil {
+		return config
+	}
+	certificate, ok := certificates.certificates[string(clientID)]
+	if !ok {
+		return config
+	}
+	clientConfig := config.Clone()
+	clientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return certificate, nil
+	}
+	return clientConfig
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClientCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "client_certificates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	if err := ioutil.WriteFile(certPath, testTLSCert, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyPath, testTLSKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+	certificates, err := LoadClientCertificates([]byte(fmt.Sprintf("clients:\n  - client_id: client1\n    cert: %s\n    key: %s\n", certPath, keyPath)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &tls.Config{ServerName: "db"}
+	if certificates.ConfigForClientID(config, []byte("client2")) != config {
+		t.Fatal("Config changed for client without own certificate")
+	}
+	clientConfig := certificates.ConfigForClientID(config, []byte("client1"))
+	if clientConfig == config || clientConfig.GetClientCertificate == nil || config.GetClientCertificate != nil {
+		t.Fatal("Expected copy of config with client certificate")
+	}
+	certificate, err := clientConfig.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := tls.X509KeyPair(testTLSCert, testTLSKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(certificate.Certificate[0], expected.Certificate[0]) {
+		t.Fatal("Incorrect client certificate")
+	}
+
+	var nilCertificates *ClientCertificates
+	if nilCertificates.ConfigForClientID(config, []byte("client1")) != config {
+		t.Fatal("nil certificates should return same config")
+	}
+
+	for _, data := range []string{"clients:\n  - client_id: client1\n", "clients:\n  - cert: a\n    key: b\n"} {
+		if _, err := LoadClientCertificates([]byte(data)); err != ErrInvalidClientCertificatesConfig {
+			t.Fatalf("Expected ErrInvalidClientCertificatesConfig, took %v", err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// IsConnectionLost returns true if err means that peer closed or reset connection. Errors of connections closed
+// locally aren't treated as lost connections
+func IsConnectionLost(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	opErr, ok := err.(*net.OpError)
+	if !ok {
+		return false
+	}
+	sysErr, ok := opErr.Err.(*os.SyscallError)
+	if !ok {
+		return false
+	}
+	switch sysErr.Err {
+	case syscall.ECONNRESET, syscall.ECONNABORTED, syscall.EPIPE:
+		return true
+	}
+	return false
+}
+
+// DialWithRetries dials address and retries failed attempts retries times, waiting interval before first retry and
+// doubling it before each next one
+func DialWithRetries(network, address string, retries int, interval time.Duration) (net.Conn, error) {
+	return DialContextWithRetries(context.Background(), network, address, retries, interval)
+}
+
+// DialContextWithRetries is DialWithRetries which stops dialing and waiting for next attempt when ctx is done
+func DialContextWithRetries(ctx context.Context, network, address string, retries int, interval time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, network, address)
+	for attempt := 0; err != nil && attempt < retries; attempt++ {
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		interval *= 2
+		conn, err = dialer.DialContext(ctx, network, address)
+	}
+	return conn, err
+}
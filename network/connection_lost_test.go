@@ -0,0 +1,103 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsConnectionLost(t *testing.T) {
+	lost := []error{
+		io.EOF,
+		io.ErrUnexpectedEOF,
+		&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)},
+		&net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPIPE)},
+	}
+	for _, err := range lost {
+		if !IsConnectionLost(err) {
+			t.Errorf("Expected lost connection on %v", err)
+		}
+	}
+	notLost := []error{
+		errors.New("some error"),
+		&net.OpError{Op: "read", Err: errors.New("use of closed network connection")},
+		&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+	}
+	for _, err := range notLost {
+		if IsConnectionLost(err) {
+			t.Errorf("Unexpected lost connection on %v", err)
+		}
+	}
+}
+
+func TestDialWithRetries(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	if _, err := DialWithRetries("tcp", address, 1, time.Millisecond); err == nil {
+		t.Fatal("Expected error on closed port")
+	}
+	// start listening after the first attempt failed
+	listenerCh := make(chan net.Listener, 1)
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			t.Error(err)
+		}
+		listenerCh <- listener
+	}()
+	conn, err := DialWithRetries("tcp", address, 5, time.Millisecond*20)
+	listener = <-listenerCh
+	if listener == nil {
+		t.FailNow()
+	}
+	defer listener.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestDialContextWithRetries(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	start := time.Now()
+	// retries would take more than 10 seconds without cancellation
+	if _, err := DialContextWithRetries(ctx, "tcp", address, 10, time.Millisecond*10); err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded, took %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("Dialing wasn't stopped by context")
+	}
+}
@@ -174,12 +174,11 @@ func TestSessionWrapper(t *testing.T) {
 	testWrapper(clientWrapper, serverWrapper, t)
 }
 
-func TestTLSWRapper(t *testing.T) {
-	// openssl ecparam -genkey -name secp384r1 -out server.key
-	// openssl req -new -x509 -sha256 -key server.key -out server.crt -days 3650
-	// cat server.key
-	// cat server.cert
-	key := []byte(`
+// openssl ecparam -genkey -name secp384r1 -out server.key
+// openssl req -new -x509 -sha256 -key server.key -out server.crt -days 3650
+// cat server.key
+// cat server.cert
+var testTLSKey = []byte(`
 -----BEGIN EC PARAMETERS-----
 BgUrgQQAIg==
 -----END EC PARAMETERS-----
@@ -190,7 +189,7 @@ MIGkAgEBBDAr0pCdkWNZrTUj+ps6Geykc+9XbMvJvt9SwLcZ4GlmUtF2d1bFSVE3
 Om/FTC3VwwAePSjKCOpVLh2FUyXcIxE=
 -----END EC PRIVATE KEY-----
 `)
-	cert := []byte(`
+var testTLSCert = []byte(`
 -----BEGIN CERTIFICATE-----
 MIICMDCCAbWgAwIBAgIJAIF7CJa9LIURMAoGCCqGSM49BAMCMFQxCzAJBgNVBAYT
 AkFVMRMwEQYDVQQIDApTb21lLVN0YXRlMSEwHwYDVQQKDBhJbnRlcm5ldCBXaWRn
@@ -206,12 +205,14 @@ E0B2xKAzGuMumud6IbYpoIk3uj7bjfeejSyZPgxIOkEPAjEA+adYfhHGieUnnC26
 Mmsz2rgkLFqKpYS30+CYbzwIXMfHImhBX2kO9HkodBWvNApu
 -----END CERTIFICATE-----
 `)
+
+func TestTLSWRapper(t *testing.T) {
 	clientWrapper, err := NewTLSConnectionWrapper(nil, &tls.Config{InsecureSkipVerify: true})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	cer, err := tls.X509KeyPair(cert, key)
+	cer, err := tls.X509KeyPair(testTLSCert, testTLSKey)
 	if err != nil {
 		t.Fatal(err)
 		return
@@ -22,6 +22,7 @@ package network
 import (
 	"net"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -31,14 +32,14 @@ type ConnectionManager struct {
 	*sync.WaitGroup
 	mutex       *sync.Mutex
 	Counter     int
-	connections map[net.Conn]bool
+	connections map[net.Conn]time.Time
 }
 
 // NewConnectionManager returns new ConnectionManager
 func NewConnectionManager() *ConnectionManager {
 	cm := &ConnectionManager{}
 	cm.WaitGroup = &sync.WaitGroup{}
-	cm.connections = make(map[net.Conn]bool)
+	cm.connections = make(map[net.Conn]time.Time)
 	cm.mutex = &sync.Mutex{}
 	return cm
 }
@@ -60,7 +61,7 @@ func (cm *ConnectionManager) Done() {
 func (cm *ConnectionManager) AddConnection(conn net.Conn) error {
 	cm.mutex.Lock()
 	cm.Incr()
-	cm.connections[conn] = true
+	cm.connections[conn] = time.Now()
 	cm.mutex.Unlock()
 	return nil
 }
@@ -74,6 +75,24 @@ func (cm *ConnectionManager) RemoveConnection(conn net.Conn) error {
 	return nil
 }
 
+// Count returns number of added connections which aren't removed yet
+func (cm *ConnectionManager) Count() int {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	return cm.Counter
+}
+
+// GetConnectionsAges returns how long each added connection lives
+func (cm *ConnectionManager) GetConnectionsAges() []time.Duration {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	ages := make([]time.Duration, 0, len(cm.connections))
+	for _, startedAt := range cm.connections {
+		ages = append(ages, time.Since(startedAt))
+	}
+	return ages
+}
+
 // CloseConnections close all available connections and return first occurred error
 func (cm *ConnectionManager) CloseConnections() error {
 	// lock for map read
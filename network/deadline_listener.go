@@ -17,13 +17,13 @@ limitations under the License.
 package network
 
 import (
-	"github.com/cossacklabs/themis/gothemis/errors"
+	"github.com/cossacklabs/acra/acraerrors"
 	"net"
 	"time"
 )
 
 // ErrUnsupportedListener represents net.Listener type unknown to Acra.
-var ErrUnsupportedListener = errors.New("unsupported network Listener type")
+var ErrUnsupportedListener = acraerrors.New(acraerrors.CodeUnsupportedListener, "unsupported network Listener type")
 
 // DeadlineListener is extended net.Listener interface with SetDeadline method that added for abstraction of calling
 // SetDeadline between two listener types (TcpListener and UnixListener) that support this method
@@ -0,0 +1,159 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"sync"
+	"time"
+)
+
+// HandshakeLimiter counts failed handshakes per key (source IP) and bans key after threshold of
+// consecutive failures. Each next failure of banned key doubles ban duration up to maxBanDuration, successful
+// handshake resets counter. Safe for concurrent use
+type HandshakeLimiter struct {
+	mutex          sync.Mutex
+	threshold      int
+	banDuration    time.Duration
+	maxBanDuration time.Duration
+	failures       map[string]*handshakeFailures
+	lastCleanup    time.Time
+	now            func() time.Time
+}
+
+type handshakeFailures struct {
+	count       int
+	lastFailure time.Time
+	bannedUntil time.Time
+}
+
+// NewHandshakeLimiter returns limiter which bans key on banDuration after threshold failed handshakes
+func NewHandshakeLimiter(threshold int, banDuration, maxBanDuration time.Duration) *HandshakeLimiter {
+	if maxBanDuration < banDuration {
+		maxBanDuration = banDuration
+	}
+	return &HandshakeLimiter{
+		threshold:      threshold,
+		banDuration:    banDuration,
+		maxBanDuration: maxBanDuration,
+		failures:       make(map[string]*handshakeFailures),
+		lastCleanup:    time.Now(),
+		now:            time.Now,
+	}
+}
+
+// IsBanned returns true if key is banned now. Safe to call on nil HandshakeLimiter
+func (limiter *HandshakeLimiter) IsBanned(key string) bool {
+	if limiter == nil {
+		return false
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	failures, ok := limiter.failures[key]
+	return ok && limiter.now().Before(failures.bannedUntil)
+}
+
+// AddFailure registers failed handshake of key and returns duration of ban or 0 if key isn't banned
+func (limiter *HandshakeLimiter) AddFailure(key string) time.Duration {
+	if limiter == nil {
+		return 0
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	now := limiter.now()
+	limiter.cleanup(now)
+	failures, ok := limiter.failures[key]
+	if !ok {
+		failures = &handshakeFailures{}
+		limiter.failures[key] = failures
+	}
+	failures.count++
+	failures.lastFailure = now
+	if failures.count < limiter.threshold {
+		return 0
+	}
+	ban := limiter.banDuration
+	for i := limiter.threshold; i < failures.count && ban < limiter.maxBanDuration; i++ {
+		ban *= 2
+	}
+	if ban > limiter.maxBanDuration {
+		ban = limiter.maxBanDuration
+	}
+	failures.bannedUntil = now.Add(ban)
+	return ban
+}
+
+// AddSuccess resets failures of key after successful handshake
+func (limiter *HandshakeLimiter) AddSuccess(key string) {
+	if limiter == nil {
+		return
+	}
+	limiter.mutex.Lock()
+	delete(limiter.failures, key)
+	limiter.mutex.Unlock()
+}
+
+// cleanup removes keys which didn't fail during maxBanDuration to keep memory bounded when many hosts fail once
+func (limiter *HandshakeLimiter) cleanup(now time.Time) {
+	if now.Sub(limiter.lastCleanup) < limiter.maxBanDuration {
+		return
+	}
+	limiter.lastCleanup = now
+	for key, failures := range limiter.failures {
+		if now.Sub(failures.lastFailure) >= limiter.maxBanDuration && !now.Before(failures.bannedUntil) {
+			delete(limiter.failures, key)
+		}
+	}
+}
+
+// HandshakeFailureState is state of failed handshakes of key shared with warm standby service
+type HandshakeFailureState struct {
+	Key         string
+	Count       int
+	LastFailure time.Time
+	BannedUntil time.Time
+}
+
+// State returns failures of all tracked keys. Safe to call on nil HandshakeLimiter
+func (limiter *HandshakeLimiter) State() []HandshakeFailureState {
+	if limiter == nil {
+		return nil
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	states := make([]HandshakeFailureState, 0, len(limiter.failures))
+	for key, failures := range limiter.failures {
+		states = append(states, HandshakeFailureState{Key: key, Count: failures.count, LastFailure: failures.lastFailure, BannedUntil: failures.bannedUntil})
+	}
+	return states
+}
+
+// Merge adds failures of other limiter, e.g. of active service to standby one. Failures of key are replaced only
+// with more recent ones, so state doesn't go back if both limiters saw same key
+func (limiter *HandshakeLimiter) Merge(states []HandshakeFailureState) {
+	if limiter == nil {
+		return
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	for _, state := range states {
+		failures, ok := limiter.failures[state.Key]
+		if ok && !state.LastFailure.After(failures.lastFailure) {
+			continue
+		}
+		limiter.failures[state.Key] = &handshakeFailures{count: state.Count, lastFailure: state.LastFailure, bannedUntil: state.BannedUntil}
+	}
+}
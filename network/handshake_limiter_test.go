@@ -0,0 +1,92 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHandshakeLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := NewHandshakeLimiter(3, time.Second, time.Second*5)
+	limiter.now = func() time.Time { return now }
+	const key = "127.0.0.1"
+	for i := 0; i < 2; i++ {
+		if ban := limiter.AddFailure(key); ban != 0 {
+			t.Fatalf("Unexpected ban after %d failures", i+1)
+		}
+	}
+	if limiter.IsBanned(key) {
+		t.Fatal("Key banned before threshold")
+	}
+	expectedBans := []time.Duration{time.Second, time.Second * 2, time.Second * 4, time.Second * 5, time.Second * 5}
+	for i, expected := range expectedBans {
+		if ban := limiter.AddFailure(key); ban != expected {
+			t.Fatalf("%d. Incorrect ban duration %v, expected %v", i, ban, expected)
+		}
+	}
+	if !limiter.IsBanned(key) || limiter.IsBanned("other") {
+		t.Fatal("Incorrect banned keys")
+	}
+	now = now.Add(time.Second * 5)
+	if limiter.IsBanned(key) {
+		t.Fatal("Ban not expired")
+	}
+	limiter.AddSuccess(key)
+	if ban := limiter.AddFailure(key); ban != 0 {
+		t.Fatal("Failures weren't reset after success")
+	}
+
+	// keys without recent failures removed
+	now = now.Add(time.Second * 10)
+	limiter.AddFailure("other")
+	if _, ok := limiter.failures[key]; ok {
+		t.Fatal("Old failures weren't cleaned")
+	}
+
+	var nilLimiter *HandshakeLimiter
+	if nilLimiter.IsBanned(key) || nilLimiter.AddFailure(key) != 0 {
+		t.Fatal("nil limiter shouldn't ban")
+	}
+}
+
+func TestHandshakeLimiterMerge(t *testing.T) {
+	active := NewHandshakeLimiter(2, time.Minute, time.Minute*5)
+	standby := NewHandshakeLimiter(2, time.Minute, time.Minute*5)
+	const key = "127.0.0.1"
+	active.AddFailure(key)
+	active.AddFailure(key)
+	standby.Merge(active.State())
+	if !standby.IsBanned(key) {
+		t.Fatal("Ban wasn't merged")
+	}
+	// more recent local failures aren't replaced by older state
+	older := active.State()
+	standby.AddSuccess(key)
+	standby.AddFailure(key)
+	older[0].LastFailure = older[0].LastFailure.Add(-time.Second)
+	standby.Merge(older)
+	if standby.IsBanned(key) {
+		t.Fatal("Recent failures replaced by older state")
+	}
+	var nilLimiter *HandshakeLimiter
+	nilLimiter.Merge(active.State())
+	if nilLimiter.State() != nil {
+		t.Fatal("nil limiter shouldn't have state")
+	}
+}
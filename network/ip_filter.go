@@ -0,0 +1,191 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/logging"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// ErrInvalidIPFilterConfig returned if configuration contains malformed addresses or networks
+var ErrInvalidIPFilterConfig = acraerrors.New(acraerrors.CodeInvalidIPFilterConfig, "invalid ip filter configuration, expected IP addresses or CIDR networks")
+
+// IPFilterConfig lists IP addresses or CIDR networks which are allowed or denied to connect. Deny has priority,
+// empty allow list allows all addresses which aren't denied
+type IPFilterConfig struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// IPFilter decides whether connections from remote address are accepted. Rules may be reloaded from file
+// at runtime, so IPFilter is safe for concurrent use
+type IPFilter struct {
+	mutex sync.RWMutex
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+func parseNetworks(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, ErrInvalidIPFilterConfig
+			}
+			if ip.To4() != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, ErrInvalidIPFilterConfig
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// NewIPFilter returns IPFilter with rules from configuration in YAML format
+func NewIPFilter(data []byte) (*IPFilter, error) {
+	filter := &IPFilter{}
+	if err := filter.Load(data); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// Load replaces rules with rules from configuration in YAML format. Old rules are kept if configuration is invalid
+func (filter *IPFilter) Load(data []byte) error {
+	config := &IPFilterConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return err
+	}
+	allow, err := parseNetworks(config.Allow)
+	if err != nil {
+		return err
+	}
+	deny, err := parseNetworks(config.Deny)
+	if err != nil {
+		return err
+	}
+	filter.mutex.Lock()
+	filter.allow, filter.deny = allow, deny
+	filter.mutex.Unlock()
+	return nil
+}
+
+// IsAllowed returns true if connections from address are allowed. Addresses without IP (unix sockets) are always
+// allowed
+func (filter *IPFilter) IsAllowed(address net.Addr) bool {
+	var ip net.IP
+	switch addr := address.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	default:
+		return true
+	}
+	filter.mutex.RLock()
+	defer filter.mutex.RUnlock()
+	for _, network := range filter.deny {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	if len(filter.allow) == 0 {
+		return true
+	}
+	for _, network := range filter.allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewIPFilterFromFile loads rules from file and reloads them when modification time of file changes. File is checked
+// every reloadInterval, 0 turns off reloading
+func NewIPFilterFromFile(path string, reloadInterval time.Duration) (*IPFilter, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := NewIPFilter(data)
+	if err != nil {
+		return nil, err
+	}
+	if reloadInterval > 0 {
+		go filter.watchFile(path, info.ModTime(), reloadInterval)
+	}
+	return filter, nil
+}
+
+func (filter *IPFilter) watchFile(path string, modTime time.Time, interval time.Duration) {
+	logger := log.WithField("ip_filter_file", path)
+	for range time.Tick(interval) {
+		info, err := os.Stat(path)
+		if err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Warningln("Can't check ip filter configuration, previous rules are used")
+			continue
+		}
+		if info.ModTime().Equal(modTime) {
+			continue
+		}
+		modTime = info.ModTime()
+		data, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = filter.Load(data)
+		}
+		if err != nil {
+			logger.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorWrongConfiguration).
+				Errorln("Can't reload ip filter configuration, previous rules are used")
+			continue
+		}
+		logger.Infoln("Reloaded ip filter configuration")
+	}
+}
+
+// AcceptConnection returns true if connection is allowed by filter, otherwise logs and closes it before any
+// handshake. nil filter allows all connections
+func (filter *IPFilter) AcceptConnection(connection net.Conn) bool {
+	if filter == nil || filter.IsAllowed(connection.RemoteAddr()) {
+		return true
+	}
+	log.WithField("remote_address", connection.RemoteAddr().String()).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorConnectionDenied).
+		Warningln("Connection from denied address was closed")
+	connection.Close()
+	return false
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIPFilter(t *testing.T) {
+	filter, err := NewIPFilter([]byte(`
+allow:
+  - 10.0.0.0/8
+  - 192.168.1.1
+  - fd00::/8
+deny:
+  - 10.1.0.0/16
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		address net.Addr
+		allowed bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("10.2.3.4")}, true},
+		{&net.TCPAddr{IP: net.ParseIP("10.1.3.4")}, false},
+		{&net.TCPAddr{IP: net.ParseIP("192.168.1.1")}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.168.1.2")}, false},
+		{&net.TCPAddr{IP: net.ParseIP("fd00::1")}, true},
+		{&net.TCPAddr{IP: net.ParseIP("::1")}, false},
+		{&net.UnixAddr{Name: "/tmp/socket", Net: "unix"}, true},
+	}
+	for _, testCase := range testCases {
+		if filter.IsAllowed(testCase.address) != testCase.allowed {
+			t.Errorf("Incorrect result for %s, expected %v", testCase.address, testCase.allowed)
+		}
+	}
+
+	// empty allow list allows all not denied addresses
+	if err := filter.Load([]byte("deny: [127.0.0.1]")); err != nil {
+		t.Fatal(err)
+	}
+	if !filter.IsAllowed(&net.TCPAddr{IP: net.ParseIP("192.168.1.2")}) {
+		t.Fatal("Expected allowed address")
+	}
+	if filter.IsAllowed(&net.TCPAddr{IP: net.ParseIP("127.0.0.1")}) {
+		t.Fatal("Expected denied address")
+	}
+
+	// invalid config keeps previous rules
+	if err := filter.Load([]byte("allow: [not_ip]")); err != ErrInvalidIPFilterConfig {
+		t.Fatalf("Expected ErrInvalidIPFilterConfig, took %v", err)
+	}
+	if filter.IsAllowed(&net.TCPAddr{IP: net.ParseIP("127.0.0.1")}) {
+		t.Fatal("Rules changed after invalid config")
+	}
+}
+
+func TestNilIPFilterAcceptConnection(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	var filter *IPFilter
+	if !filter.AcceptConnection(server) {
+		t.Fatal("nil filter should accept all connections")
+	}
+}
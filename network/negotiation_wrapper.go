@@ -0,0 +1,143 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/keystore"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultNegotiationTimeout is time to wait for first bytes from client before choosing raw transport
+const DefaultNegotiationTimeout = time.Second
+
+// tlsHandshakeRecordType is first byte of TLS record with ClientHello
+const tlsHandshakeRecordType = 0x16
+
+// tlsMajorVersion is first byte of version of TLS record for SSL 3.0 and all TLS versions
+const tlsMajorVersion = 0x03
+
+// negotiationHeaderLength is count of bytes enough to distinguish transports
+const negotiationHeaderLength = 4
+
+// Errors returned by NegotiationConnectionWrapper
+var (
+	ErrNoNegotiationTransports = acraerrors.New(acraerrors.CodeNoNegotiationTransports, "no transports configured for negotiation")
+	ErrUnknownTransport        = acraerrors.New(acraerrors.CodeUnknownTransport, "can't detect transport of connection")
+	ErrNegotiationOnClientSide = acraerrors.New(acraerrors.CodeNegotiationOnClientSide, "transport negotiation isn't supported for client connections")
+)
+
+// NegotiationConnectionWrapper detects transport used by client from first bytes of connection and wraps connection
+// with TLS, Secure Session or raw wrapper. Allows one port to serve clients which use different transports. TLS is
+// detected by header of ClientHello record, Secure Session by length of client id sent before handshake. Raw transport
+// is chosen if client doesn't send anything in timeout (client waits greeting from database) or sends something else
+type NegotiationConnectionWrapper struct {
+	tlsWrapper           ConnectionWrapper
+	secureSessionWrapper ConnectionWrapper
+	rawWrapper           ConnectionWrapper
+	timeout              time.Duration
+}
+
+// NewNegotiationConnectionWrapper returns wrapper which negotiates transports with non-nil wrappers
+func NewNegotiationConnectionWrapper(tlsWrapper, secureSessionWrapper, rawWrapper ConnectionWrapper) (*NegotiationConnectionWrapper, error) {
+	if tlsWrapper == nil && secureSessionWrapper == nil && rawWrapper == nil {
+		return nil, ErrNoNegotiationTransports
+	}
+	return &NegotiationConnectionWrapper{
+		tlsWrapper:           tlsWrapper,
+		secureSessionWrapper: secureSessionWrapper,
+		rawWrapper:           rawWrapper,
+		timeout:              DefaultNegotiationTimeout,
+	}, nil
+}
+
+// SetTimeout sets time to wait for first bytes from client
+func (wrapper *NegotiationConnectionWrapper) SetTimeout(timeout time.Duration) {
+	wrapper.timeout = timeout
+}
+
+// WrapClient returns error because client should know transport it uses
+func (wrapper *NegotiationConnectionWrapper) WrapClient(id []byte, conn net.Conn) (net.Conn, error) {
+	return conn, ErrNegotiationOnClientSide
+}
+
+// isTLSHeader returns true if header is start of TLS handshake record
+func isTLSHeader(header []byte) bool {
+	return len(header) >= 2 && header[0] == tlsHandshakeRecordType && header[1] == tlsMajorVersion
+}
+
+// isSecureSessionHeader returns true if header is little endian length of client id which AcraConnector sends before
+// Secure Session handshake
+func isSecureSessionHeader(header []byte) bool {
+	if len(header) < negotiationHeaderLength {
+		return false
+	}
+	length := binary.LittleEndian.Uint32(header)
+	return length >= keystore.MinClientIdLength && length <= keystore.MaxClientIdLength
+}
+
+// detectWrapper returns wrapper for transport which header belongs to or nil if transport unknown or not allowed
+func (wrapper *NegotiationConnectionWrapper) detectWrapper(header []byte) (ConnectionWrapper, string) {
+	switch {
+	case len(header) == 0:
+		return wrapper.rawWrapper, "raw"
+	case wrapper.tlsWrapper != nil && isTLSHeader(header):
+		return wrapper.tlsWrapper, "tls"
+	case wrapper.secureSessionWrapper != nil && isSecureSessionHeader(header):
+		return wrapper.secureSessionWrapper, "secure_session"
+	}
+	return wrapper.rawWrapper, "raw"
+}
+
+// WrapServer reads first bytes of connection, detects transport and wraps connection with suitable wrapper
+func (wrapper *NegotiationConnectionWrapper) WrapServer(conn net.Conn) (net.Conn, []byte, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(wrapper.timeout)); err != nil {
+		return conn, nil, err
+	}
+	reader := bufio.NewReader(conn)
+	header, err := reader.Peek(negotiationHeaderLength)
+	if err != nil {
+		// not timeout errors mean that connection broken and there is nothing to negotiate
+		if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+			return conn, nil, err
+		}
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return conn, nil, err
+	}
+	transportWrapper, transport := wrapper.detectWrapper(header)
+	if transportWrapper == nil {
+		return conn, nil, ErrUnknownTransport
+	}
+	log.WithField("transport", transport).Debugln("Negotiated transport of connection")
+	return transportWrapper.WrapServer(&peekedConnection{Conn: conn, reader: reader})
+}
+
+// peekedConnection returns data buffered during negotiation before next data from connection
+type peekedConnection struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (conn *peekedConnection) Read(b []byte) (int, error) {
+	return conn.reader.Read(b)
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+)
+
+func getTestNegotiationWrapper(t *testing.T, withRaw bool) *NegotiationConnectionWrapper {
+	cer, err := tls.X509KeyPair(testTLSCert, testTLSKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsWrapper, err := NewTLSConnectionWrapper(TEST_CLIENT_ID, &tls.Config{Certificates: []tls.Certificate{cer}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rawWrapper ConnectionWrapper
+	if withRaw {
+		rawWrapper = &RawConnectionWrapper{ClientID: TEST_CLIENT_ID}
+	}
+	wrapper, err := NewNegotiationConnectionWrapper(tlsWrapper, nil, rawWrapper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wrapper
+}
+
+func TestNegotiationConnectionWrapper(t *testing.T) {
+	wrapper := getTestNegotiationWrapper(t, true)
+	tlsClientWrapper, err := NewTLSConnectionWrapper(nil, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testWrapper(tlsClientWrapper, wrapper, t)
+	testWrapper(&RawConnectionWrapper{}, wrapper, t)
+}
+
+func TestNegotiationRawByTimeout(t *testing.T) {
+	wrapper := getTestNegotiationWrapper(t, true)
+	wrapper.SetTimeout(time.Millisecond * 50)
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		// client waits greeting from server and then answers
+		buf := make([]byte, 5)
+		if _, err := client.Read(buf); err != nil {
+			return
+		}
+		client.Write([]byte("answer"))
+	}()
+	conn, clientID, err := wrapper.WrapServer(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if !bytes.Equal(clientID, TEST_CLIENT_ID) {
+		t.Fatal("Incorrect client id")
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 6)
+	if _, err := conn.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "answer" {
+		t.Fatal("Incorrect data after negotiation")
+	}
+}
+
+func TestNegotiationWithoutRaw(t *testing.T) {
+	wrapper := getTestNegotiationWrapper(t, false)
+	client, server := net.Pipe()
+	defer client.Close()
+	go client.Write([]byte("some data"))
+	if _, _, err := wrapper.WrapServer(server); err != ErrUnknownTransport {
+		t.Fatalf("Expected ErrUnknownTransport, took %v", err)
+	}
+}
+
+func TestDetectTransportHeaders(t *testing.T) {
+	if !isTLSHeader([]byte{0x16, 0x03, 0x01, 0x02}) {
+		t.Fatal("TLS header wasn't detected")
+	}
+	if !isSecureSessionHeader([]byte{14, 0, 0, 0}) {
+		t.Fatal("Secure Session header wasn't detected")
+	}
+	for _, header := range [][]byte{{0, 0, 0, 8}, {1, 0, 0, 0}, {0x16, 0x03, 0x01, 0x02}, {14, 0}} {
+		if isSecureSessionHeader(header) {
+			t.Fatalf("Incorrectly detected Secure Session header %v", header)
+		}
+	}
+	if _, err := NewNegotiationConnectionWrapper(nil, nil, nil); err != ErrNoNegotiationTransports {
+		t.Fatal("Expected ErrNoNegotiationTransports")
+	}
+}
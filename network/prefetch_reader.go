@@ -0,0 +1,223 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"io"
+	"net"
+	"sync"
+
+	"github.com/cossacklabs/acra/acraerrors"
+)
+
+// DefaultPrefetchChunkSize is size of buffer used for one read from source by PrefetchReader
+const DefaultPrefetchChunkSize = 32 * 1024
+
+// ErrPrefetchReaderClosed returned by Read after Close
+var ErrPrefetchReaderClosed = acraerrors.New(acraerrors.CodePrefetchReaderClosed, "prefetch reader closed")
+
+type prefetchedChunk struct {
+	buffer []byte
+	data   []byte
+}
+
+// PrefetchReader reads data from source in background goroutine into bounded queue of chunks, so next data is read
+// from network while previous data is processed. If spill configured, data read after queue filled up is kept in
+// encrypted temporary file until it reaches its max size, so slow processing of huge results doesn't stall source.
+// Source shouldn't be read by anyone else after PrefetchReader created
+type PrefetchReader struct {
+	lock      sync.Mutex
+	cond      *sync.Cond
+	chunks    []*prefetchedChunk
+	maxChunks int
+	// buffers are free buffers of chunkSize bytes
+	buffers   [][]byte
+	chunkSize int
+	spill     *spillFile
+	// err of source returned after all read data consumed
+	err     error
+	closed  bool
+	current *prefetchedChunk
+}
+
+// NewPrefetchReader starts reading from source into queue of maxChunks chunks with chunkSize bytes each
+func NewPrefetchReader(source io.Reader, maxChunks, chunkSize int) *PrefetchReader {
+	return NewPrefetchReaderWithSpill(source, maxChunks, chunkSize, nil)
+}
+
+// NewPrefetchReaderWithSpill starts reading from source into queue of maxChunks chunks with chunkSize bytes each and
+// into temporary file configured by spill after queue is full. nil spill turns off spilling
+func NewPrefetchReaderWithSpill(source io.Reader, maxChunks, chunkSize int, spill *SpillConfig) *PrefetchReader {
+	reader := &PrefetchReader{maxChunks: maxChunks, chunkSize: chunkSize}
+	reader.cond = sync.NewCond(&reader.lock)
+	if spill != nil && spill.MaxSize > 0 {
+		reader.spill = newSpillFile(spill)
+	}
+	go reader.prefetch(source)
+	return reader
+}
+
+// getBuffer returns free buffer, should be called with locked reader.lock
+func (reader *PrefetchReader) getBuffer() []byte {
+	if count := len(reader.buffers); count > 0 {
+		buffer := reader.buffers[count-1]
+		reader.buffers = reader.buffers[:count-1]
+		return buffer
+	}
+	return make([]byte, reader.chunkSize)
+}
+
+// waitRoom waits until next chunk may be queued in memory or spilled and returns true if chunk should be spilled,
+// should be called with locked reader.lock
+func (reader *PrefetchReader) waitRoom() (spill bool) {
+	for !reader.closed {
+		// spilled data is older than any chunk read later, so memory isn't used until spill is drained
+		if reader.spill.Empty() && len(reader.chunks) < reader.maxChunks {
+			return false
+		}
+		if reader.spill.HasRoom(reader.chunkSize) {
+			return true
+		}
+		reader.cond.Wait()
+	}
+	return false
+}
+
+func (reader *PrefetchReader) prefetch(source io.Reader) {
+	for {
+		reader.lock.Lock()
+		spill := reader.waitRoom()
+		if reader.closed {
+			reader.lock.Unlock()
+			return
+		}
+		buffer := reader.getBuffer()
+		reader.lock.Unlock()
+
+		n, err := source.Read(buffer)
+		if spill && n > 0 {
+			if spillErr := reader.spill.Write(buffer[:n]); spillErr != nil {
+				err = spillErr
+			}
+		}
+
+		reader.lock.Lock()
+		if spill || n == 0 {
+			reader.buffers = append(reader.buffers, buffer)
+		} else {
+			reader.chunks = append(reader.chunks, &prefetchedChunk{buffer: buffer, data: buffer[:n]})
+		}
+		if err != nil {
+			reader.err = err
+		}
+		reader.cond.Broadcast()
+		reader.lock.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// nextChunk returns next chunk from memory or spill, waits if there is no read data yet. Should be called with
+// locked reader.lock
+func (reader *PrefetchReader) nextChunk() (*prefetchedChunk, error) {
+	for {
+		if reader.closed {
+			return nil, ErrPrefetchReaderClosed
+		}
+		if len(reader.chunks) > 0 {
+			chunk := reader.chunks[0]
+			reader.chunks[0] = nil
+			reader.chunks = reader.chunks[1:]
+			return chunk, nil
+		}
+		if !reader.spill.Empty() {
+			buffer := reader.getBuffer()
+			data, err := reader.spill.Read(buffer)
+			if err != nil {
+				return nil, err
+			}
+			return &prefetchedChunk{buffer: buffer, data: data}, nil
+		}
+		if reader.err != nil {
+			return nil, reader.err
+		}
+		reader.cond.Wait()
+	}
+}
+
+// Read implements io.Reader and returns data in same order as it was read from source. Returns
+// ErrPrefetchReaderClosed after Close even if read data wasn't consumed, and wakes up Read waiting for data on Close
+func (reader *PrefetchReader) Read(p []byte) (int, error) {
+	reader.lock.Lock()
+	defer reader.lock.Unlock()
+	if reader.closed {
+		return 0, ErrPrefetchReaderClosed
+	}
+	for reader.current == nil || len(reader.current.data) == 0 {
+		if reader.current != nil {
+			reader.buffers = append(reader.buffers, reader.current.buffer)
+			reader.current = nil
+		}
+		chunk, err := reader.nextChunk()
+		if err != nil {
+			return 0, err
+		}
+		reader.current = chunk
+		// memory or spill has room for next chunk
+		reader.cond.Broadcast()
+	}
+	n := copy(p, reader.current.data)
+	reader.current.data = reader.current.data[n:]
+	return n, nil
+}
+
+// Close stops background reading and removes spilled data. Source isn't closed and goroutine exits after current
+// read from source returns
+func (reader *PrefetchReader) Close() error {
+	reader.lock.Lock()
+	defer reader.lock.Unlock()
+	reader.closed = true
+	reader.chunks = nil
+	reader.current = nil
+	reader.cond.Broadcast()
+	return reader.spill.Close()
+}
+
+// prefetchConnection reads data of connection with PrefetchReader, other methods are called on connection
+type prefetchConnection struct {
+	net.Conn
+	reader *PrefetchReader
+}
+
+// NewPrefetchConnection returns connection which data is read ahead in background like NewPrefetchReaderWithSpill.
+// Connection shouldn't be read by anyone else after that, Close of returned connection stops reading ahead and closes
+// connection
+func NewPrefetchConnection(connection net.Conn, maxChunks, chunkSize int, spill *SpillConfig) net.Conn {
+	return &prefetchConnection{Conn: connection, reader: NewPrefetchReaderWithSpill(connection, maxChunks, chunkSize, spill)}
+}
+
+// Read reads data prefetched from connection
+func (connection *prefetchConnection) Read(p []byte) (int, error) {
+	return connection.reader.Read(p)
+}
+
+// Close stops reading ahead and closes connection
+func (connection *prefetchConnection) Close() error {
+	connection.reader.Close()
+	return connection.Conn.Close()
+}
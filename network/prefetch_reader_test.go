@@ -0,0 +1,191 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPrefetchReader(t *testing.T) {
+	data := make([]byte, 100*1024+7)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	reader := NewPrefetchReader(bytes.NewReader(data), 2, 1000)
+	defer reader.Close()
+	result, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, result) {
+		t.Fatal("Read data not equal to source")
+	}
+	if _, err := reader.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Expected io.EOF, took %v", err)
+	}
+}
+
+func TestPrefetchReaderClose(t *testing.T) {
+	source, writer := io.Pipe()
+	defer writer.Close()
+	reader := NewPrefetchReader(source, 1, 10)
+	go writer.Write(make([]byte, 100))
+	if _, err := io.ReadFull(reader, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	// background goroutine blocked on full queue must exit
+	reader.Close()
+	reader.Close()
+}
+
+func TestPrefetchReaderReadAfterClose(t *testing.T) {
+	source, writer := io.Pipe()
+	defer writer.Close()
+	reader := NewPrefetchReader(source, 1, 10)
+	go writer.Write(make([]byte, 10))
+	if _, err := io.ReadFull(reader, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	reader.Close()
+	// rest of read chunk isn't returned after close
+	if n, err := reader.Read(make([]byte, 5)); n != 0 || err != ErrPrefetchReaderClosed {
+		t.Fatalf("Expected ErrPrefetchReaderClosed, took %v, %v", n, err)
+	}
+
+	// read waiting for data returns on close
+	emptySource, emptyWriter := io.Pipe()
+	defer emptyWriter.Close()
+	reader = NewPrefetchReader(emptySource, 1, 10)
+	result := make(chan error, 1)
+	go func() {
+		_, err := reader.Read(make([]byte, 1))
+		result <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	reader.Close()
+	select {
+	case err := <-result:
+		if err != ErrPrefetchReaderClosed {
+			t.Fatalf("Expected ErrPrefetchReaderClosed, took %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read wasn't stopped by close")
+	}
+}
+
+func TestPrefetchConnection(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	connection := NewPrefetchConnection(server, 2, 10, nil)
+	data := []byte("some data read ahead")
+	go client.Write(data)
+	result := make([]byte, len(data))
+	if _, err := io.ReadFull(connection, result); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, result) {
+		t.Fatal("Read data not equal to source")
+	}
+	// writes go to connection as is
+	go connection.Write([]byte("response"))
+	response := make([]byte, 8)
+	if _, err := io.ReadFull(client, response); err != nil || string(response) != "response" {
+		t.Fatalf("Unexpected response %s, %v", response, err)
+	}
+	if err := connection.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := connection.Read(make([]byte, 1)); err != ErrPrefetchReaderClosed {
+		t.Fatalf("Expected ErrPrefetchReaderClosed, took %v", err)
+	}
+	// wrapped connection is closed too
+	if _, err := client.Write([]byte("data")); err != io.ErrClosedPipe {
+		t.Fatalf("Expected io.ErrClosedPipe, took %v", err)
+	}
+}
+
+func TestPrefetchReaderSpill(t *testing.T) {
+	directory, err := ioutil.TempDir("", "spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	data := make([]byte, 20*1024+7)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	source, writer := io.Pipe()
+	reader := NewPrefetchReaderWithSpill(source, 1, 1000, &SpillConfig{Directory: directory, MaxSize: 64 * 1024})
+	defer reader.Close()
+	written := make(chan error, 1)
+	go func() {
+		_, err := writer.Write(data)
+		writer.Close()
+		written <- err
+	}()
+	// whole source is read in background without consumer
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Source wasn't read to temporary file")
+	}
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatal("Temporary file should be removed after creation")
+	}
+	result, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, result) {
+		t.Fatal("Read data not equal to source")
+	}
+}
+
+func TestPrefetchReaderSpillLimit(t *testing.T) {
+	data := make([]byte, 100*1024+7)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	// spill smaller than source is reused after it's drained
+	reader := NewPrefetchReaderWithSpill(bytes.NewReader(data), 2, 1000, &SpillConfig{MaxSize: 3000})
+	defer reader.Close()
+	result, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, result) {
+		t.Fatal("Read data not equal to source")
+	}
+	reader.Close()
+	if _, err := reader.Read(make([]byte, 1)); err != ErrPrefetchReaderClosed {
+		t.Fatalf("Expected ErrPrefetchReaderClosed, took %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/cossacklabs/acra/keystore"
+	"github.com/cossacklabs/acra/utils"
+	"github.com/cossacklabs/themis/gothemis/cell"
+	log "github.com/sirupsen/logrus"
+)
+
+// SpillConfig configures temporary files used by PrefetchReader when its queue in memory is full
+type SpillConfig struct {
+	// Directory where temporary files are created, default directory for temporary files if empty
+	Directory string
+	// MaxSize is max size in bytes of data kept in temporary file by one reader, reading from source waits after it
+	MaxSize int64
+}
+
+type spilledChunk struct {
+	offset int64
+	length int64
+	index  uint64
+}
+
+// spillFile is queue of chunks written to temporary file encrypted with random key known only to this process.
+// File is removed right after creation, so it disappears with process even after crash. Write is called by one
+// goroutine while other one calls Read, all methods can be called on nil spillFile which is always empty
+type spillFile struct {
+	config *SpillConfig
+	lock   sync.Mutex
+	file   *os.File
+	scell  *cell.SecureCell
+	key    []byte
+	// writeOffset is used only by writer
+	writeOffset int64
+	chunks      []spilledChunk
+	nextIndex   uint64
+	// size of not read data
+	size int64
+	err  error
+}
+
+func newSpillFile(config *SpillConfig) *spillFile {
+	return &spillFile{config: config}
+}
+
+// Empty returns true if there is no spilled data
+func (spill *spillFile) Empty() bool {
+	if spill == nil {
+		return true
+	}
+	spill.lock.Lock()
+	defer spill.lock.Unlock()
+	return len(spill.chunks) == 0
+}
+
+// HasRoom returns true if length bytes more may be spilled
+func (spill *spillFile) HasRoom(length int) bool {
+	if spill == nil {
+		return false
+	}
+	spill.lock.Lock()
+	defer spill.lock.Unlock()
+	return spill.err == nil && spill.size+int64(length) <= spill.config.MaxSize
+}
+
+// open creates temporary file on first spill
+func (spill *spillFile) open() error {
+	if spill.file != nil {
+		return nil
+	}
+	key := make([]byte, keystore.SymmetricKeyLength)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(spill.config.Directory, "acra-spill-")
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(file.Name()); err != nil {
+		log.WithError(err).WithField("file", file.Name()).Warningln("Can't remove temporary file of spilled data, it will be removed on close")
+	}
+	log.WithField("file", file.Name()).Debugln("Spill read data to temporary file")
+	spill.file = file
+	spill.key = key
+	spill.scell = cell.New(key, cell.CELL_MODE_SEAL)
+	return nil
+}
+
+// Write encrypts data and appends it to file
+func (spill *spillFile) Write(data []byte) error {
+	spill.lock.Lock()
+	if spill.err != nil {
+		spill.lock.Unlock()
+		return spill.err
+	}
+	if err := spill.open(); err != nil {
+		spill.err = err
+		spill.lock.Unlock()
+		return err
+	}
+	if len(spill.chunks) == 0 && spill.writeOffset > 0 {
+		// all spilled data read, so file is reused from start
+		spill.writeOffset = 0
+		if err := spill.file.Truncate(0); err != nil {
+			spill.err = err
+			spill.lock.Unlock()
+			return err
+		}
+	}
+	index := spill.nextIndex
+	spill.nextIndex++
+	file, scell, offset := spill.file, spill.scell, spill.writeOffset
+	spill.lock.Unlock()
+
+	// index as context binds encrypted data to its position in queue
+	context := make([]byte, 8)
+	binary.BigEndian.PutUint64(context, index)
+	encrypted, _, err := scell.Protect(data, context)
+	if err == nil {
+		_, err = file.WriteAt(encrypted, offset)
+	}
+
+	spill.lock.Lock()
+	defer spill.lock.Unlock()
+	if err != nil {
+		spill.err = err
+		return err
+	}
+	if spill.err != nil {
+		// closed while data was written
+		return spill.err
+	}
+	spill.chunks = append(spill.chunks, spilledChunk{offset: offset, length: int64(len(encrypted)), index: index})
+	spill.writeOffset = offset + int64(len(encrypted))
+	spill.size += int64(len(data))
+	return nil
+}
+
+// Read decrypts oldest spilled chunk into buffer and returns its data
+func (spill *spillFile) Read(buffer []byte) ([]byte, error) {
+	spill.lock.Lock()
+	if spill.err != nil {
+		spill.lock.Unlock()
+		return nil, spill.err
+	}
+	chunk := spill.chunks[0]
+	file, scell := spill.file, spill.scell
+	spill.lock.Unlock()
+
+	encrypted := make([]byte, chunk.length)
+	_, err := file.ReadAt(encrypted, chunk.offset)
+	var data []byte
+	if err == nil {
+		context := make([]byte, 8)
+		binary.BigEndian.PutUint64(context, chunk.index)
+		data, err = scell.Unprotect(encrypted, nil, context)
+	}
+
+	spill.lock.Lock()
+	defer spill.lock.Unlock()
+	if err == nil {
+		err = spill.err
+	}
+	if err != nil {
+		spill.err = err
+		return nil, err
+	}
+	spill.chunks = spill.chunks[1:]
+	spill.size -= int64(len(data))
+	n := copy(buffer, data)
+	utils.FillSlice(byte(0), data)
+	return buffer[:n], nil
+}
+
+// Close removes temporary file and forgets key
+func (spill *spillFile) Close() error {
+	if spill == nil {
+		return nil
+	}
+	spill.lock.Lock()
+	defer spill.lock.Unlock()
+	if spill.file == nil {
+		return nil
+	}
+	utils.FillSlice(byte(0), spill.key)
+	name := spill.file.Name()
+	err := spill.file.Close()
+	os.Remove(name)
+	spill.file = nil
+	spill.chunks = nil
+	spill.size = 0
+	if spill.err == nil {
+		spill.err = os.ErrClosed
+	}
+	return err
+}
@@ -0,0 +1,288 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/logging"
+	"github.com/cossacklabs/acra/utils"
+	"github.com/cossacklabs/themis/gothemis/compare"
+	log "github.com/sirupsen/logrus"
+)
+
+// Errors returned by SecureComparatorConnectionWrapper
+var (
+	ErrInvalidComparatorSecrets = acraerrors.New(acraerrors.CodeInvalidComparatorSecrets, "invalid secure comparator secret, expected non-empty secret")
+	ErrComparatorRequiresTLS    = acraerrors.New(acraerrors.CodeComparatorRequiresTLS, "secure comparator requires TLS transport")
+	ErrComparatorNoMatch        = acraerrors.New(acraerrors.CodeComparatorNoMatch, "secure comparator: secrets don't match")
+	ErrComparatorProtocol       = acraerrors.New(acraerrors.CodeComparatorProtocol, "secure comparator: unexpected message from peer")
+)
+
+// Types of messages exchanged during authentication with Secure Comparator
+const (
+	comparatorDataMessage   byte = 1
+	comparatorResultMessage byte = 2
+)
+
+// comparatorMaxMessageLength limits messages read from unauthenticated peer. Secure Comparator messages are a few
+// hundred bytes, client id is limited by keystore
+const comparatorMaxMessageLength = 4096
+
+// fakeComparatorSecretLength is length of random secret compared for unknown client ids, so peer can't distinguish
+// unknown client id from wrong secret
+const fakeComparatorSecretLength = 32
+
+// ComparatorSecretStore returns secret shared with client with client id. Implemented by keystore.ComparatorSecretKeyStore
+// which keeps secrets encrypted with master key
+type ComparatorSecretStore interface {
+	GetComparatorSecret(clientID []byte) ([]byte, error)
+}
+
+// SecureComparatorConnectionWrapper authenticates AcraConnector by secret shared with AcraServer using Themis Secure
+// Comparator, a zero-knowledge proof protocol which never sends secret itself, so connectors don't need own keypairs
+// or certificates. Secure Comparator doesn't encrypt data and doesn't protect from man-in-the-middle which relays
+// comparison messages, so comparison always runs inside TLS connection
+type SecureComparatorConnectionWrapper struct {
+	transport        *TLSConnectionWrapper
+	secret           []byte
+	secrets          ComparatorSecretStore
+	handshakeTimeout time.Duration
+}
+
+// NewSecureComparatorClientWrapper returns wrapper which proves knowledge of secret to server over TLS transport
+func NewSecureComparatorClientWrapper(transport *TLSConnectionWrapper, secret []byte) (*SecureComparatorConnectionWrapper, error) {
+	if transport == nil {
+		return nil, ErrComparatorRequiresTLS
+	}
+	if len(secret) == 0 {
+		return nil, ErrInvalidComparatorSecrets
+	}
+	return &SecureComparatorConnectionWrapper{transport: transport, secret: secret, handshakeTimeout: SECURE_SESSION_ESTABLISHING_TIMEOUT}, nil
+}
+
+// NewSecureComparatorServerWrapper returns wrapper which accepts clients over TLS transport which know secret of their
+// client id
+func NewSecureComparatorServerWrapper(transport *TLSConnectionWrapper, secrets ComparatorSecretStore) (*SecureComparatorConnectionWrapper, error) {
+	if transport == nil {
+		return nil, ErrComparatorRequiresTLS
+	}
+	return &SecureComparatorConnectionWrapper{transport: transport, secrets: secrets, handshakeTimeout: SECURE_SESSION_ESTABLISHING_TIMEOUT}, nil
+}
+
+// SetHandshakeTimeout set time to complete comparison of secrets. 0 - without timeout
+func (wrapper *SecureComparatorConnectionWrapper) SetHandshakeTimeout(timeout time.Duration) {
+	wrapper.handshakeTimeout = timeout
+}
+
+func readComparatorMessage(reader io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(reader, length[:]); err != nil {
+		return nil, err
+	}
+	dataSize := binary.LittleEndian.Uint32(length[:])
+	if dataSize == 0 || dataSize > comparatorMaxMessageLength {
+		return nil, ErrComparatorProtocol
+	}
+	buf := make([]byte, dataSize)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func sendComparatorMessage(messageType byte, data []byte, conn io.Writer) error {
+	return utils.SendData(append([]byte{messageType}, data...), conn)
+}
+
+// newComparator returns Secure Comparator for secret bound to client id, so secret of one client can't be used
+// with id of another
+func newComparator(clientID, secret []byte) (*compare.SecureCompare, error) {
+	comparator, err := compare.New()
+	if err != nil {
+		return nil, err
+	}
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(clientID)))
+	for _, part := range [][]byte{length[:], clientID, secret} {
+		if err := comparator.Append(part); err != nil {
+			return nil, err
+		}
+	}
+	return comparator, nil
+}
+
+func (wrapper *SecureComparatorConnectionWrapper) withDeadline(conn net.Conn, handshake func() error) error {
+	if wrapper.handshakeTimeout != 0 {
+		if err := conn.SetDeadline(time.Now().Add(wrapper.handshakeTimeout)); err != nil {
+			return err
+		}
+	}
+	err := handshake()
+	if wrapper.handshakeTimeout != 0 {
+		// reset deadline
+		if resetErr := conn.SetDeadline(time.Time{}); err == nil {
+			err = resetErr
+		}
+	}
+	return err
+}
+
+func (wrapper *SecureComparatorConnectionWrapper) compareAsClient(id []byte, conn net.Conn) error {
+	comparator, err := newComparator(id, wrapper.secret)
+	if err != nil {
+		return err
+	}
+	if err := utils.SendData(id, conn); err != nil {
+		return err
+	}
+	data, err := comparator.Begin()
+	if err != nil {
+		return err
+	}
+	if err := sendComparatorMessage(comparatorDataMessage, data, conn); err != nil {
+		return err
+	}
+	for {
+		message, err := readComparatorMessage(conn)
+		if err != nil {
+			return err
+		}
+		switch message[0] {
+		case comparatorDataMessage:
+			data, err := comparator.Proceed(message[1:])
+			if err != nil {
+				return err
+			}
+			if len(data) > 0 {
+				if err := sendComparatorMessage(comparatorDataMessage, data, conn); err != nil {
+					return err
+				}
+			}
+		case comparatorResultMessage:
+			// server reports own result, but client trusts server only if client compared secrets too
+			result, err := comparator.Result()
+			if err != nil {
+				return err
+			}
+			if result != compare.COMPARE_MATCH || !bytes.Equal(message[1:], []byte{compare.COMPARE_MATCH}) {
+				return ErrComparatorNoMatch
+			}
+			return nil
+		default:
+			return ErrComparatorProtocol
+		}
+	}
+}
+
+func (wrapper *SecureComparatorConnectionWrapper) compareAsServer(conn net.Conn) ([]byte, error) {
+	clientID, err := readComparatorMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := wrapper.secrets.GetComparatorSecret(clientID)
+	if err != nil {
+		log.WithField("client_id", string(clientID)).Debugln("No secure comparator secret for client id, compare with random secret")
+		secret = make([]byte, fakeComparatorSecretLength)
+		if _, err := rand.Read(secret); err != nil {
+			return clientID, err
+		}
+	}
+	comparator, err := newComparator(clientID, secret)
+	if err != nil {
+		return clientID, err
+	}
+	for {
+		message, err := readComparatorMessage(conn)
+		if err != nil {
+			return clientID, err
+		}
+		if message[0] != comparatorDataMessage {
+			return clientID, ErrComparatorProtocol
+		}
+		data, err := comparator.Proceed(message[1:])
+		if err != nil {
+			return clientID, err
+		}
+		if len(data) > 0 {
+			if err := sendComparatorMessage(comparatorDataMessage, data, conn); err != nil {
+				return clientID, err
+			}
+		}
+		result, err := comparator.Result()
+		if err != nil {
+			return clientID, err
+		}
+		if result == compare.COMPARE_NOT_READY {
+			continue
+		}
+		if err := sendComparatorMessage(comparatorResultMessage, []byte{byte(result)}, conn); err != nil {
+			return clientID, err
+		}
+		if result != compare.COMPARE_MATCH {
+			return clientID, ErrComparatorNoMatch
+		}
+		return clientID, nil
+	}
+}
+
+// WrapClient wraps connection with transport and proves knowledge of secret shared with server
+func (wrapper *SecureComparatorConnectionWrapper) WrapClient(id []byte, conn net.Conn) (net.Conn, error) {
+	log.Debugln("wrap client connection with secure comparator")
+	wrappedConn, err := wrapper.transport.WrapClient(id, conn)
+	if err != nil {
+		return wrappedConn, err
+	}
+	err = wrapper.withDeadline(wrappedConn, func() error {
+		return wrapper.compareAsClient(id, wrappedConn)
+	})
+	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorAuthenticationFailed).
+			Warningln("Authentication with secure comparator failed")
+		return wrappedConn, NewConnectionWrapError(err)
+	}
+	log.Debugln("wrap client connection with secure comparator finished")
+	return wrappedConn, nil
+}
+
+// WrapServer wraps connection with transport and checks that client knows secret of client id it sent. Client id is
+// returned only if comparison succeeded
+func (wrapper *SecureComparatorConnectionWrapper) WrapServer(conn net.Conn) (net.Conn, []byte, error) {
+	log.Debugln("wrap server connection with secure comparator")
+	wrappedConn, _, err := wrapper.transport.WrapServer(conn)
+	if err != nil {
+		return wrappedConn, nil, err
+	}
+	var clientID []byte
+	err = wrapper.withDeadline(wrappedConn, func() error {
+		clientID, err = wrapper.compareAsServer(wrappedConn)
+		return err
+	})
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{logging.FieldKeyEventCode: logging.EventCodeErrorAuthenticationFailed, "claimed_client_id": string(clientID)}).
+			Warningln("Authentication of client with secure comparator failed")
+		return wrappedConn, nil, NewConnectionWrapError(err)
+	}
+	log.WithField("client_id", string(clientID)).Debugln("wrap server connection with secure comparator finished")
+	return wrappedConn, clientID, nil
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+)
+
+// testComparatorSecrets is ComparatorSecretStore with secrets from map
+type testComparatorSecrets map[string][]byte
+
+func (secrets testComparatorSecrets) GetComparatorSecret(clientID []byte) ([]byte, error) {
+	secret, ok := secrets[string(clientID)]
+	if !ok {
+		return nil, errors.New("unknown client id")
+	}
+	return secret, nil
+}
+
+var testSecrets = testComparatorSecrets{string(TEST_CLIENT_ID): []byte("shared secret"), "another": []byte("another secret")}
+
+func getTestComparatorTLSWrappers(t *testing.T) (client, server *TLSConnectionWrapper) {
+	client, err := NewTLSConnectionWrapper(nil, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := tls.X509KeyPair(testTLSCert, testTLSKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = NewTLSConnectionWrapper(nil, &tls.Config{Certificates: []tls.Certificate{certificate}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func TestSecureComparatorConnectionWrapper(t *testing.T) {
+	clientTLS, serverTLS := getTestComparatorTLSWrappers(t)
+	serverWrapper, err := NewSecureComparatorServerWrapper(serverTLS, testSecrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientWrapper, err := NewSecureComparatorClientWrapper(clientTLS, []byte("shared secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testWrapper(clientWrapper, serverWrapper, t)
+}
+
+func TestSecureComparatorConnectionWrapperNoMatch(t *testing.T) {
+	clientTLS, serverTLS := getTestComparatorTLSWrappers(t)
+	serverWrapper, err := NewSecureComparatorServerWrapper(serverTLS, testSecrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		clientID []byte
+		secret   []byte
+	}{
+		{TEST_CLIENT_ID, []byte("wrong secret")},
+		// secret of another client
+		{TEST_CLIENT_ID, []byte("another secret")},
+		{[]byte("unknown"), []byte("shared secret")},
+	}
+	for i, testCase := range testCases {
+		clientWrapper, err := NewSecureComparatorClientWrapper(clientTLS, testCase.secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, server := net.Pipe()
+		clientErrCh := make(chan error, 1)
+		go func() {
+			_, err := clientWrapper.WrapClient(testCase.clientID, client)
+			clientErrCh <- err
+		}()
+		_, clientID, err := serverWrapper.WrapServer(server)
+		if err == nil {
+			t.Fatalf("[%d] Expected error on server side", i)
+		}
+		if clientID != nil {
+			t.Fatalf("[%d] Expected no client id with error", i)
+		}
+		if err := <-clientErrCh; err == nil {
+			t.Fatalf("[%d] Expected error on client side", i)
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestSecureComparatorEmptySecret(t *testing.T) {
+	clientTLS, _ := getTestComparatorTLSWrappers(t)
+	if _, err := NewSecureComparatorClientWrapper(clientTLS, nil); err != ErrInvalidComparatorSecrets {
+		t.Fatalf("Expected ErrInvalidComparatorSecrets, took %v", err)
+	}
+}
+
+func TestSecureComparatorRequiresTLS(t *testing.T) {
+	if _, err := NewSecureComparatorClientWrapper(nil, []byte("shared secret")); err != ErrComparatorRequiresTLS {
+		t.Fatalf("Expected ErrComparatorRequiresTLS, took %v", err)
+	}
+	if _, err := NewSecureComparatorServerWrapper(nil, testSecrets); err != ErrComparatorRequiresTLS {
+		t.Fatalf("Expected ErrComparatorRequiresTLS, took %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cossacklabs/acra/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrUnknownCipherSuite returned for name of cipher suite which isn't implemented by crypto/tls
+var ErrUnknownCipherSuite = errors.New("unknown TLS cipher suite")
+
+// ParseCipherSuites returns ids of comma-separated cipher suites named like TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.
+// Empty value returns nil, so default suites of crypto/tls are used
+func ParseCipherSuites(value string) ([]uint16, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, suites := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, suite := range suites {
+			known[suite.Name] = suite.ID
+		}
+	}
+	var ids []uint16
+	for _, name := range strings.Split(value, ",") {
+		id, ok := known[strings.TrimSpace(name)]
+		if !ok {
+			log.WithField("cipher_suite", name).Errorln("Unknown TLS cipher suite")
+			return nil, ErrUnknownCipherSuite
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// HasServerCertificate returns true if config has certificate to accept TLS connections
+func HasServerCertificate(config *tls.Config) bool {
+	return config != nil && (len(config.Certificates) > 0 || config.GetCertificate != nil)
+}
+
+// CertificateReloader loads certificate and key from files and reloads them on handshake of new connection when
+// modification time of any file changes, so rotated certificate is used without restart. Established connections
+// keep certificate which they were started with
+type CertificateReloader struct {
+	certPath    string
+	keyPath     string
+	lock        sync.Mutex
+	certificate *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+// NewCertificateReloader returns reloader with certificate and key loaded from files
+func NewCertificateReloader(certPath, keyPath string) (*CertificateReloader, error) {
+	reloader := &CertificateReloader{certPath: certPath, keyPath: keyPath}
+	if _, err := reloader.Certificate(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// Certificate returns current certificate, reloaded if files were changed. If changed files can't be loaded (e.g.
+// key is replaced before certificate), previous certificate is returned
+func (reloader *CertificateReloader) Certificate() (*tls.Certificate, error) {
+	reloader.lock.Lock()
+	defer reloader.lock.Unlock()
+	err := reloader.reload()
+	if err == nil {
+		return reloader.certificate, nil
+	}
+	if reloader.certificate == nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTLSCantLoadCertificates).
+			Errorln("Can't load TLS certificate and key")
+		return nil, err
+	}
+	log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTLSCantLoadCertificates).
+		Warningln("Can't reload TLS certificate and key, previous certificate is used")
+	return reloader.certificate, nil
+}
+
+// reload loads certificate and key if modification time of files changed since previous attempt
+func (reloader *CertificateReloader) reload() error {
+	certInfo, err := os.Stat(reloader.certPath)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(reloader.keyPath)
+	if err != nil {
+		return err
+	}
+	if reloader.certificate != nil && certInfo.ModTime().Equal(reloader.certModTime) && keyInfo.ModTime().Equal(reloader.keyModTime) {
+		return nil
+	}
+	// files which can't be loaded aren't retried until they change again
+	reloader.certModTime = certInfo.ModTime()
+	reloader.keyModTime = keyInfo.ModTime()
+	certificate, err := tls.LoadX509KeyPair(reloader.certPath, reloader.keyPath)
+	if err != nil {
+		return err
+	}
+	reloader.certificate = &certificate
+	log.WithField("certificate", reloader.certPath).Infoln("Loaded TLS certificate")
+	return nil
+}
+
+// Apply replaces static certificates of config with reloaded certificate which is presented both as server's
+// certificate and as client's certificate
+func (reloader *CertificateReloader) Apply(config *tls.Config) {
+	config.Certificates = nil
+	config.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return reloader.Certificate()
+	}
+	config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return reloader.Certificate()
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseCipherSuites(t *testing.T) {
+	if suites, err := ParseCipherSuites(""); err != nil || suites != nil {
+		t.Fatalf("Expected default suites, took %v, %v", suites, err)
+	}
+	suites, err := ParseCipherSuites("TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256}
+	if len(suites) != len(expected) || suites[0] != expected[0] || suites[1] != expected[1] {
+		t.Fatalf("Unexpected suites %v", suites)
+	}
+	if _, err := ParseCipherSuites("TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,TLS_UNKNOWN"); err != ErrUnknownCipherSuite {
+		t.Fatalf("Expected ErrUnknownCipherSuite, took %v", err)
+	}
+}
+
+// newTestCertificatePEM returns PEM encoded self-signed certificate and key
+func newTestCertificatePEM(t *testing.T, commonName string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey})
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certificate_reloader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	write := func(cert, key []byte, modTime time.Time) {
+		for path, data := range map[string][]byte{certPath: cert, keyPath: key} {
+			if err := ioutil.WriteFile(path, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := NewCertificateReloader(certPath, keyPath); err == nil {
+		t.Fatal("Expected error for missing files")
+	}
+	firstCert, firstKey := newTestCertificatePEM(t, "first")
+	write(firstCert, firstKey, time.Now().Add(-time.Minute))
+	reloader, err := NewCertificateReloader(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{{}}}
+	reloader.Apply(config)
+	if len(config.Certificates) != 0 || !HasServerCertificate(config) {
+		t.Fatal("Static certificates weren't replaced")
+	}
+	first, err := config.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstPEM, _ := pem.Decode(firstCert)
+	if !bytes.Equal(first.Certificate[0], firstPEM.Bytes) {
+		t.Fatal("Unexpected certificate")
+	}
+
+	// rotated certificate is used by next handshake
+	secondCert, secondKey := newTestCertificatePEM(t, "second")
+	write(secondCert, secondKey, time.Now())
+	second, err := config.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondPEM, _ := pem.Decode(secondCert)
+	if !bytes.Equal(second.Certificate[0], secondPEM.Bytes) {
+		t.Fatal("Certificate wasn't reloaded")
+	}
+
+	// key which doesn't match certificate keeps previous certificate
+	write(firstCert, secondKey, time.Now().Add(time.Minute))
+	current, err := reloader.Certificate()
+	if err != nil || current != second {
+		t.Fatalf("Expected previous certificate, took error %v", err)
+	}
+}
+
+func TestHasServerCertificate(t *testing.T) {
+	if HasServerCertificate(nil) || HasServerCertificate(&tls.Config{}) {
+		t.Fatal("Config without certificates accepted")
+	}
+	if !HasServerCertificate(&tls.Config{Certificates: []tls.Certificate{{}}}) {
+		t.Fatal("Config with certificate isn't accepted")
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"net"
 
 	"errors"
+	"github.com/cossacklabs/acra/acraerrors"
 	"github.com/cossacklabs/acra/logging"
 	log "github.com/sirupsen/logrus"
 )
@@ -34,7 +35,7 @@ type TLSConnectionWrapper struct {
 }
 
 // ErrEmptyTLSConfig if not TLS config found
-var ErrEmptyTLSConfig = errors.New("empty TLS config")
+var ErrEmptyTLSConfig = acraerrors.New(acraerrors.CodeEmptyTLSConfig, "empty TLS config")
 
 // NewTLSConnectionWrapper returns new TLSConnectionWrapper
 func NewTLSConnectionWrapper(clientID []byte, config *tls.Config) (*TLSConnectionWrapper, error) {
@@ -46,6 +47,8 @@ func (wrapper *TLSConnectionWrapper) WrapClient(id []byte, conn net.Conn) (net.C
 	tlsConn := tls.Client(conn, wrapper.config)
 	err := tlsConn.Handshake()
 	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTLSHandshakeFailed).
+			WithField("remote_address", conn.RemoteAddr().String()).Warningln("TLS handshake with server failed")
 		return conn, err
 	}
 	return tlsConn, nil
@@ -56,6 +59,8 @@ func (wrapper *TLSConnectionWrapper) WrapServer(conn net.Conn) (net.Conn, []byte
 	tlsConn := tls.Server(conn, wrapper.config)
 	err := tlsConn.Handshake()
 	if err != nil {
+		log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTLSHandshakeFailed).
+			WithField("remote_address", conn.RemoteAddr().String()).Warningln("TLS handshake with client failed")
 		return conn, nil, err
 	}
 	return tlsConn, wrapper.clientID, nil
@@ -77,12 +82,12 @@ func NewTLSConfig(serverName string, caPath, keyPath, crtPath string, authType t
 	if caPath != "" {
 		caPem, err := ioutil.ReadFile(caPath)
 		if err != nil {
-			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorGeneral).Errorln("Can't read root CA certificate")
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTLSCantLoadCertificates).Errorln("Can't read root CA certificate")
 			return nil, err
 		}
 		log.Debugln("Adding CA root certificate")
 		if ok := roots.AppendCertsFromPEM(caPem); !ok {
-			log.Errorln("Can't add CA certificate from PEM")
+			log.WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTLSCantLoadCertificates).Errorln("Can't add CA certificate from PEM")
 			return nil, errors.New("can't add CA certificate")
 		}
 	}
@@ -91,6 +96,7 @@ func NewTLSConfig(serverName string, caPath, keyPath, crtPath string, authType t
 	if crtPath != "" && keyPath != "" {
 		cer, err := tls.LoadX509KeyPair(crtPath, keyPath)
 		if err != nil {
+			log.WithError(err).WithField(logging.FieldKeyEventCode, logging.EventCodeErrorTLSCantLoadCertificates).Errorln("Can't load TLS certificate and key")
 			return nil, err
 		}
 		certificates = append(certificates, cer)
@@ -0,0 +1,66 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objectstore
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/cossacklabs/acra/keystore/kms"
+)
+
+// GCSEndpoint is URL of Google Cloud Storage JSON API
+const GCSEndpoint = "https://storage.googleapis.com/"
+
+// GCSClient reads objects from Google Cloud Storage authorized with OAuth 2.0 tokens
+type GCSClient struct {
+	endpoint   string
+	tokens     kms.TokenSource
+	httpClient *http.Client
+}
+
+// NewGCSClient returns client of Cloud Storage authorized with tokens from tokens. Endpoint may be empty to use
+// GCSEndpoint
+func NewGCSClient(endpoint string, tokens kms.TokenSource) *GCSClient {
+	if endpoint == "" {
+		endpoint = GCSEndpoint
+	}
+	if !strings.HasSuffix(endpoint, "/") {
+		endpoint += "/"
+	}
+	return &GCSClient{endpoint: endpoint, tokens: tokens, httpClient: newHTTPClient()}
+}
+
+// GetObject implements Storage with media download of objects.get method of Cloud Storage
+func (client *GCSClient) GetObject(ctx context.Context, bucket, key string) (*Object, error) {
+	if bucket == "" || key == "" {
+		return nil, ErrInvalidObjectName
+	}
+	token, err := client.tokens.Token()
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest(http.MethodGet, client.endpoint+"storage/v1/b/"+url.PathEscape(bucket)+"/o/"+url.PathEscape(key)+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(ctx)
+	request.Header.Set("Authorization", "Bearer "+token)
+	return getObject(client.httpClient, request, "GCS")
+}
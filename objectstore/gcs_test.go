@@ -0,0 +1,61 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objectstore
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cossacklabs/acra/keystore/kms"
+)
+
+func TestGCSClientGetObject(t *testing.T) {
+	content := []byte("encrypted object")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.URL.Query().Get("alt") != "media" {
+			t.Errorf("Unexpected request %v %v", r.URL, r.Header)
+		}
+		if r.URL.EscapedPath() != "/storage/v1/b/bucket/o/dir%2Ffile.bin" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(content)
+	}))
+	defer server.Close()
+	client := NewGCSClient(server.URL, kms.StaticTokenSource("token"))
+	object, err := client.GetObject(context.Background(), "bucket", "dir/file.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer object.Body.Close()
+	data, err := ioutil.ReadAll(object.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(content) {
+		t.Fatalf("Unexpected object %q", data)
+	}
+	if _, err := client.GetObject(context.Background(), "bucket", "missing"); err != ErrObjectNotFound {
+		t.Fatalf("Expected ErrObjectNotFound, took %v", err)
+	}
+	if _, err := NewGCSClient(server.URL, kms.StaticTokenSource("")).GetObject(context.Background(), "bucket", "dir/file.bin"); err != kms.ErrEmptyAccessToken {
+		t.Fatalf("Expected ErrEmptyAccessToken, took %v", err)
+	}
+}
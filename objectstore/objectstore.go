@@ -0,0 +1,98 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package objectstore reads objects from cloud object storages like Amazon S3 and Google Cloud Storage, so
+// AcraTranslator decrypts blobs stored there with the same keys as data from databases. Objects are returned as
+// streams and aren't loaded into memory by clients.
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/cossacklabs/acra/acraerrors"
+	"github.com/cossacklabs/acra/keystore/kms"
+)
+
+// Supported types of object storages
+const (
+	TypeS3  = "s3"
+	TypeGCS = "gcs"
+)
+
+// responseHeaderTimeout limits time to wait for response of storage. Whole request isn't limited because large objects
+// are streamed as long as caller reads them
+const responseHeaderTimeout = 30 * time.Second
+
+// maxErrorBodySize limits size of error response read to build error message
+const maxErrorBodySize = 4096
+
+// Errors returned by object storages
+var (
+	ErrUnsupportedStorageType = acraerrors.New(acraerrors.CodeUnsupportedObjectStorage, "unsupported type of object storage")
+	ErrObjectNotFound         = acraerrors.New(acraerrors.CodeObjectNotFound, "object not found")
+	ErrInvalidObjectName      = acraerrors.New(acraerrors.CodeInvalidObjectName, "bucket and key of object should be non-empty")
+)
+
+// Object is stored object which content is read from Body
+type Object struct {
+	Body io.ReadCloser
+	// Size is length of content, -1 if storage didn't return it
+	Size        int64
+	ContentType string
+}
+
+// Storage returns objects by bucket and key
+type Storage interface {
+	GetObject(ctx context.Context, bucket, key string) (*Object, error)
+}
+
+// NewStorage returns storage of storageType with credentials from environment variables. Endpoint may be empty to use
+// endpoint of cloud, region is used only by S3 and overrides region from environment if not empty
+func NewStorage(storageType, endpoint, region string) (Storage, error) {
+	switch storageType {
+	case TypeS3:
+		return NewS3Client(kms.AWSRegionFromEnvironment(region), endpoint, kms.AWSCredentialsFromEnvironment())
+	case TypeGCS:
+		return NewGCSClient(endpoint, kms.NewGCPTokenSourceFromEnvironment()), nil
+	}
+	return nil, ErrUnsupportedStorageType
+}
+
+func newHTTPClient() *http.Client {
+	return &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, ResponseHeaderTimeout: responseHeaderTimeout}}
+}
+
+// getObject sends request and returns object from response. Body of returned object should be closed by caller
+func getObject(httpClient *http.Client, request *http.Request, storage string) (*Object, error) {
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusOK {
+		return &Object{Body: response.Body, Size: response.ContentLength, ContentType: response.Header.Get("Content-Type")}, nil
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, ErrObjectNotFound
+	}
+	message, _ := ioutil.ReadAll(io.LimitReader(response.Body, maxErrorBodySize))
+	return nil, fmt.Errorf("%s GET object failed with status %d: %s", storage, response.StatusCode, message)
+}
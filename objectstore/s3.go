@@ -0,0 +1,83 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cossacklabs/acra/keystore/kms"
+)
+
+const (
+	s3Service = "s3"
+	// s3EmptyPayloadHash is SHA-256 of empty body of GET request
+	s3EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3Client reads objects from Amazon S3 or compatible storage with path-style requests signed with Signature Version 4
+type S3Client struct {
+	region      string
+	endpoint    string
+	credentials kms.AWSCredentials
+	httpClient  *http.Client
+	now         func() time.Time
+}
+
+// NewS3Client returns client of S3 in region. Endpoint may be empty to use regional endpoint of AWS or URL of
+// compatible storage like MinIO
+func NewS3Client(region, endpoint string, credentials kms.AWSCredentials) (*S3Client, error) {
+	if region == "" {
+		return nil, kms.ErrNoAWSRegion
+	}
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		return nil, kms.ErrNoAWSCredentials
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	return &S3Client{region: region, endpoint: strings.TrimSuffix(endpoint, "/"), credentials: credentials,
+		httpClient: newHTTPClient(), now: time.Now}, nil
+}
+
+// GetObject implements Storage with GetObject action of S3
+func (client *S3Client) GetObject(ctx context.Context, bucket, key string) (*Object, error) {
+	if bucket == "" || key == "" {
+		return nil, ErrInvalidObjectName
+	}
+	request, err := http.NewRequest(http.MethodGet, client.endpoint+"/"+s3EscapePath(bucket)+"/"+s3EscapePath(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(ctx)
+	request.Header.Set("X-Amz-Content-Sha256", s3EmptyPayloadHash)
+	kms.SignAWSRequest(request, nil, client.region, s3Service, client.credentials, client.now())
+	return getObject(client.httpClient, request, "S3")
+}
+
+// s3EscapePath escapes each segment of path as required by canonical URI of Signature Version 4 for S3
+func s3EscapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = strings.Replace(url.QueryEscape(segment), "+", "%20", -1)
+	}
+	return strings.Join(segments, "/")
+}
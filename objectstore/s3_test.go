@@ -0,0 +1,79 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objectstore
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cossacklabs/acra/keystore/kms"
+)
+
+func TestS3ClientGetObject(t *testing.T) {
+	content := []byte("encrypted object")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.Header.Get("X-Amz-Content-Sha256") != s3EmptyPayloadHash {
+			t.Errorf("Unexpected request %v %v", r.Method, r.Header)
+		}
+		if !strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/s3/aws4_request") {
+			t.Errorf("Unexpected authorization %v", r.Header)
+		}
+		switch r.URL.EscapedPath() {
+		case "/bucket/dir/some%20file%2B1.bin":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(content)
+		case "/bucket/forbidden":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("<Error><Code>AccessDenied</Code></Error>"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client, err := NewS3Client("eu-west-1", server.URL+"/", kms.AWSCredentials{AccessKeyID: "id", SecretAccessKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	object, err := client.GetObject(context.Background(), "bucket", "dir/some file+1.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer object.Body.Close()
+	data, err := ioutil.ReadAll(object.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(content) || object.Size != int64(len(content)) || object.ContentType != "application/octet-stream" {
+		t.Fatalf("Unexpected object %q, size %v, type %v", data, object.Size, object.ContentType)
+	}
+	if _, err := client.GetObject(context.Background(), "bucket", "missing"); err != ErrObjectNotFound {
+		t.Fatalf("Expected ErrObjectNotFound, took %v", err)
+	}
+	if _, err := client.GetObject(context.Background(), "bucket", "forbidden"); err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Fatalf("Expected error of S3, took %v", err)
+	}
+	if _, err := client.GetObject(context.Background(), "bucket", ""); err != ErrInvalidObjectName {
+		t.Fatalf("Expected ErrInvalidObjectName, took %v", err)
+	}
+	if _, err := NewStorage("ftp", "", ""); err != ErrUnsupportedStorageType {
+		t.Fatalf("Expected ErrUnsupportedStorageType, took %v", err)
+	}
+}
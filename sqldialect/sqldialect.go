@@ -0,0 +1,233 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sqldialect parses queries of MariaDB and Percona Server with sqlparser which supports only syntax of
+// MySQL. Dialect-specific clauses are cut off before parsing and returned as Extensions to restore them in rewritten
+// queries, dialect-specific statements are returned as equivalent statements of sqlparser.
+package sqldialect
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// Extensions are dialect-specific clauses of query which sqlparser doesn't support
+type Extensions struct {
+	// Prefix is "SET STATEMENT ... FOR" clause of MariaDB and Percona Server
+	Prefix string
+	// Suffix is "RETURNING ..." clause of INSERT, REPLACE and DELETE of MariaDB
+	Suffix string
+}
+
+// Wrap returns query with dialect-specific clauses
+func (extensions Extensions) Wrap(query string) string {
+	if extensions.Prefix != "" {
+		query = extensions.Prefix + " " + query
+	}
+	if extensions.Suffix != "" {
+		query = query + " " + extensions.Suffix
+	}
+	return query
+}
+
+// dialectKeywords are words of dialect-specific syntax, queries without them are parsed by sqlparser as is
+var dialectKeywords = []string{"returning", "statement", "next", "previous", "sequence", "backup", "binlog"}
+
+// Parse returns parsed statement and dialect-specific clauses of query. Clauses are cut off before parsing, so they
+// aren't part of statement and should be restored with Extensions.Wrap if statement is serialized as new query.
+// Sequence functions NEXT VALUE FOR and PREVIOUS VALUE FOR are parsed as NEXTVAL() and LASTVAL() functions,
+// statements of sequences as DDL statements of tables and backup locks of Percona Server as OtherAdmin
+func Parse(query string) (sqlparser.Statement, Extensions, error) {
+	if !hasDialectKeyword(query) {
+		statement, err := sqlparser.Parse(query)
+		return statement, Extensions{}, err
+	}
+	tokens := tokenize(query)
+	if statement := parseDialectStatement(tokens); statement != nil {
+		return statement, Extensions{}, nil
+	}
+	extensions := Extensions{}
+	bodyStart, bodyEnd := 0, len(query)
+	if len(tokens) > 2 && tokens[0].is("set") && tokens[1].is("statement") {
+		if index := findToken(tokens, 2, "for"); index != -1 {
+			extensions.Prefix = strings.TrimSpace(query[:tokens[index].end])
+			bodyStart = tokens[index].end
+			tokens = tokens[index+1:]
+		}
+	}
+	if len(tokens) > 0 && (tokens[0].is("insert") || tokens[0].is("replace") || tokens[0].is("delete")) {
+		if index := findToken(tokens, 1, "returning"); index != -1 {
+			extensions.Suffix = strings.TrimSpace(query[tokens[index].start:])
+			bodyEnd = tokens[index].start
+			tokens = tokens[:index]
+		}
+	}
+	statement, err := sqlparser.Parse(rewriteSequenceFunctions(query, tokens, bodyStart, bodyEnd))
+	return statement, extensions, err
+}
+
+func hasDialectKeyword(query string) bool {
+	lowerQuery := strings.ToLower(query)
+	for _, keyword := range dialectKeywords {
+		if strings.Contains(lowerQuery, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// token is lexeme of query with its position, value of identifiers and strings is unquoted
+type token struct {
+	tokenType  int
+	value      string
+	start, end int
+	// unquoted is true if token is written in query as is without quotes, so it may be keyword
+	unquoted bool
+}
+
+// is returns true if token is unquoted word
+func (t token) is(word string) bool {
+	return t.unquoted && strings.EqualFold(t.value, word)
+}
+
+func tokenize(query string) []token {
+	tokenizer := sqlparser.NewStringTokenizer(query)
+	var tokens []token
+	previousEnd := 0
+	for {
+		tokenType, value := tokenizer.Scan()
+		if tokenType == 0 || tokenType == sqlparser.LEX_ERROR {
+			return tokens
+		}
+		// position of tokenizer points to next byte after lookahead character
+		end := tokenizer.Position - 1
+		if end > len(query) {
+			end = len(query)
+		}
+		start := previousEnd
+		for start < end && strings.IndexByte(" \t\r\n", query[start]) != -1 {
+			start++
+		}
+		tokens = append(tokens, token{tokenType: tokenType, value: string(value), start: start, end: end,
+			unquoted: strings.EqualFold(query[start:end], string(value))})
+		previousEnd = end
+	}
+}
+
+// findToken returns index of word which isn't enclosed in parentheses starting from index from or -1
+func findToken(tokens []token, from int, word string) int {
+	depth := 0
+	for i := from; i < len(tokens); i++ {
+		switch {
+		case tokens[i].tokenType == '(':
+			depth++
+		case tokens[i].tokenType == ')':
+			depth--
+		case depth == 0 && tokens[i].is(word):
+			return i
+		}
+	}
+	return -1
+}
+
+// sequenceFunctions maps first word of sequence expressions of MariaDB to equivalent functions
+var sequenceFunctions = map[string]string{"next": "nextval", "previous": "lastval"}
+
+// rewriteSequenceFunctions returns query[start:end] where NEXT VALUE FOR seq and PREVIOUS VALUE FOR seq are replaced
+// with NEXTVAL(seq) and LASTVAL(seq). sqlparser parses NEXT VALUE FOR as sequence syntax of Vitess with other meaning
+func rewriteSequenceFunctions(query string, tokens []token, start, end int) string {
+	var output bytes.Buffer
+	position := start
+	for i := 0; i+3 < len(tokens); i++ {
+		function, ok := sequenceFunctions[strings.ToLower(tokens[i].value)]
+		if !ok || !tokens[i].unquoted || !tokens[i+1].is("value") || !tokens[i+2].is("for") {
+			continue
+		}
+		nameEnd := i + 3
+		if nameEnd+2 < len(tokens) && tokens[nameEnd+1].tokenType == '.' {
+			nameEnd += 2
+		}
+		output.WriteString(query[position:tokens[i].start])
+		output.WriteString(function + "(" + query[tokens[i+3].start:tokens[nameEnd].end] + ")")
+		position = tokens[nameEnd].end
+		i = nameEnd
+	}
+	output.WriteString(query[position:end])
+	return output.String()
+}
+
+// parseDialectStatement returns equivalent statement of sqlparser for statements of sequences and backup locks of
+// Percona Server or nil if query isn't such statement
+func parseDialectStatement(tokens []token) sqlparser.Statement {
+	words := make([]string, 0, 4)
+	for i := 0; i < len(tokens) && i < 4; i++ {
+		if !tokens[i].unquoted {
+			break
+		}
+		words = append(words, strings.ToLower(tokens[i].value))
+	}
+	switch strings.Join(words, " ") {
+	case "lock tables for backup", "lock binlog for backup":
+		return &sqlparser.OtherAdmin{}
+	}
+	if len(words) >= 2 && words[0] == "unlock" && words[1] == "binlog" {
+		return &sqlparser.OtherAdmin{}
+	}
+	if len(tokens) < 3 {
+		return nil
+	}
+	action := ""
+	switch {
+	case tokens[0].is("create"):
+		action = sqlparser.CreateStr
+	case tokens[0].is("alter"):
+		action = sqlparser.AlterStr
+	case tokens[0].is("drop"):
+		action = sqlparser.DropStr
+	default:
+		return nil
+	}
+	index := 1
+	for index < len(tokens) && (tokens[index].is("or") || tokens[index].is("replace") || tokens[index].is("temporary")) {
+		index++
+	}
+	if index >= len(tokens) || !tokens[index].is("sequence") {
+		return nil
+	}
+	index++
+	// IF EXISTS of ALTER and DROP, IF NOT EXISTS of CREATE
+	ifExists := false
+	for index < len(tokens) && (tokens[index].is("if") || tokens[index].is("not") || tokens[index].is("exists")) {
+		ifExists = tokens[index].is("exists") && !tokens[index-1].is("not")
+		index++
+	}
+	if index >= len(tokens) {
+		return nil
+	}
+	name := sqlparser.TableName{Name: sqlparser.NewTableIdent(tokens[index].value)}
+	if index+2 < len(tokens) && tokens[index+1].tokenType == '.' {
+		name = sqlparser.TableName{Qualifier: sqlparser.NewTableIdent(tokens[index].value), Name: sqlparser.NewTableIdent(tokens[index+2].value)}
+	}
+	switch action {
+	case sqlparser.CreateStr:
+		return &sqlparser.DDL{Action: action, NewName: name}
+	case sqlparser.AlterStr:
+		return &sqlparser.DDL{Action: action, Table: name, NewName: name, IfExists: ifExists}
+	}
+	return &sqlparser.DDL{Action: action, Table: name, IfExists: ifExists}
+}
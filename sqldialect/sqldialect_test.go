@@ -0,0 +1,96 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sqldialect
+
+import (
+	"testing"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		query      string
+		parsed     string
+		extensions Extensions
+	}{
+		{"select * from t where a = 1", "select * from t where a = 1", Extensions{}},
+		{"insert into t(a) values (1) returning id, a", "insert into t(a) values (1)", Extensions{Suffix: "returning id, a"}},
+		{"INSERT INTO t(a) VALUES ('returning') RETURNING `id`", "insert into t(a) values ('returning')", Extensions{Suffix: "RETURNING `id`"}},
+		{"replace into t(a) select a from s returning *", "replace into t(a) select a from s", Extensions{Suffix: "returning *"}},
+		{"delete from t where id in (select id from s) returning id", "delete from t where id in (select id from s)", Extensions{Suffix: "returning id"}},
+		{"select `returning` from t", "select returning from t", Extensions{}},
+		{"SET STATEMENT max_statement_time=1, sql_mode='' FOR select a from t", "select a from t",
+			Extensions{Prefix: "SET STATEMENT max_statement_time=1, sql_mode='' FOR"}},
+		{"set statement max_statement_time=1 for delete from t returning id", "delete from t",
+			Extensions{Prefix: "set statement max_statement_time=1 for", Suffix: "returning id"}},
+		{"select next value for s", "select nextval(s) from dual", Extensions{}},
+		{"insert into t(id, a) values (NEXT VALUE FOR db.s, 'next value for s')", "insert into t(id, a) values (nextval(db.s), 'next value for s')", Extensions{}},
+		{"select previous value for s, a from t", "select lastval(s), a from t", Extensions{}},
+	}
+	for _, testCase := range testCases {
+		statement, extensions, err := Parse(testCase.query)
+		if err != nil {
+			t.Fatalf("Can't parse %s: %v", testCase.query, err)
+		}
+		if parsed := sqlparser.String(statement); parsed != testCase.parsed {
+			t.Fatalf("Expected %s for %s, took %s", testCase.parsed, testCase.query, parsed)
+		}
+		if extensions != testCase.extensions {
+			t.Fatalf("Expected %+v for %s, took %+v", testCase.extensions, testCase.query, extensions)
+		}
+	}
+	if _, _, err := Parse("insert into t values returning"); err == nil {
+		t.Fatal("Expected error for invalid query")
+	}
+}
+
+func TestParseDialectStatements(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected sqlparser.Statement
+	}{
+		{"create sequence s start with 1 increment by 1", &sqlparser.DDL{Action: sqlparser.CreateStr, NewName: sqlparser.TableName{Name: sqlparser.NewTableIdent("s")}}},
+		{"CREATE OR REPLACE SEQUENCE IF NOT EXISTS db.s", &sqlparser.DDL{Action: sqlparser.CreateStr,
+			NewName: sqlparser.TableName{Qualifier: sqlparser.NewTableIdent("db"), Name: sqlparser.NewTableIdent("s")}}},
+		{"alter sequence if exists s restart 10", &sqlparser.DDL{Action: sqlparser.AlterStr, IfExists: true,
+			Table: sqlparser.TableName{Name: sqlparser.NewTableIdent("s")}, NewName: sqlparser.TableName{Name: sqlparser.NewTableIdent("s")}}},
+		{"drop sequence `s`", &sqlparser.DDL{Action: sqlparser.DropStr, Table: sqlparser.TableName{Name: sqlparser.NewTableIdent("s")}}},
+		{"LOCK TABLES FOR BACKUP", &sqlparser.OtherAdmin{}},
+		{"lock binlog for backup", &sqlparser.OtherAdmin{}},
+		{"unlock binlog", &sqlparser.OtherAdmin{}},
+	}
+	for _, testCase := range testCases {
+		statement, extensions, err := Parse(testCase.query)
+		if err != nil {
+			t.Fatalf("Can't parse %s: %v", testCase.query, err)
+		}
+		if sqlparser.String(statement) != sqlparser.String(testCase.expected) || extensions != (Extensions{}) {
+			t.Fatalf("Expected %s for %s, took %s", sqlparser.String(testCase.expected), testCase.query, sqlparser.String(statement))
+		}
+	}
+}
+
+func TestExtensionsWrap(t *testing.T) {
+	extensions := Extensions{Prefix: "set statement max_statement_time=1 for", Suffix: "returning id"}
+	if query := extensions.Wrap("delete from t"); query != "set statement max_statement_time=1 for delete from t returning id" {
+		t.Fatalf("Unexpected query %s", query)
+	}
+	if query := (Extensions{}).Wrap("delete from t"); query != "delete from t" {
+		t.Fatalf("Unexpected query %s", query)
+	}
+}
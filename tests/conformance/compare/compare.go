@@ -0,0 +1,70 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package main compares recorded streams of clients and database and exits with code 1 if AcraServer changed anything
+// except values of AcraStructs
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cossacklabs/acra/tests/conformance"
+	log "github.com/sirupsen/logrus"
+)
+
+func main() {
+	protocol := flag.String("protocol", conformance.ProtocolPostgreSQL, "Protocol of recorded streams: postgresql or mysql")
+	dir := flag.String("dir", "records", "Directory with recorded streams")
+	clientPrefix := flag.String("client_prefix", "", "Prefix of recordings between clients and AcraServer")
+	dbPrefix := flag.String("db_prefix", "", "Prefix of recordings between AcraServer and database")
+	fixturesPath := flag.String("fixtures", "fixtures.json", "Path to fixtures used by drivers")
+	flag.Parse()
+
+	fixtures, err := conformance.LoadFixtures(*fixturesPath)
+	if err != nil {
+		log.WithError(err).Errorln("Can't load fixtures")
+		os.Exit(1)
+	}
+	clientRecordings, err := conformance.LoadRecordings(*dir, *clientPrefix)
+	if err != nil {
+		log.WithError(err).Errorln("Can't load client's recordings")
+		os.Exit(1)
+	}
+	dbRecordings, err := conformance.LoadRecordings(*dir, *dbPrefix)
+	if err != nil {
+		log.WithError(err).Errorln("Can't load database's recordings")
+		os.Exit(1)
+	}
+	if len(clientRecordings) == 0 {
+		log.Errorln("No recordings found")
+		os.Exit(1)
+	}
+	mismatches, err := conformance.Compare(*protocol, clientRecordings, dbRecordings, fixtures)
+	if err != nil {
+		log.WithError(err).Errorln("Can't compare recordings")
+		os.Exit(1)
+	}
+	for _, mismatch := range mismatches {
+		fmt.Println(mismatch.Error())
+	}
+	if len(mismatches) > 0 {
+		log.Errorf("%d of %d connections don't conform", len(mismatches), len(clientRecordings))
+		os.Exit(1)
+	}
+	log.Infof("%d connections conform", len(clientRecordings))
+}
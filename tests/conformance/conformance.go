@@ -0,0 +1,333 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package conformance checks that AcraServer changes nothing in database protocol streams except values of decrypted
+// AcraStructs. Recorder saves streams between client driver and AcraServer and between AcraServer and database, then
+// Compare checks that client's messages reach database byte by byte and that database's messages reach client byte
+// by byte after replacing AcraStructs from fixtures with their plaintexts.
+package conformance
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Supported protocols
+const (
+	ProtocolPostgreSQL = "postgresql"
+	ProtocolMySQL      = "mysql"
+)
+
+// Suffixes of files with recorded streams
+const (
+	UpstreamSuffix   = ".upstream"
+	DownstreamSuffix = ".downstream"
+)
+
+// Errors returned on comparison of streams
+var (
+	ErrMalformedStream     = errors.New("malformed protocol stream")
+	ErrUnsupportedProtocol = errors.New("unsupported protocol")
+	ErrUpstreamChanged     = errors.New("client's messages were changed before reaching database")
+)
+
+// Fixture is AcraStruct with known plaintext which drivers insert into database
+type Fixture struct {
+	ID         int    `json:"id"`
+	Plaintext  []byte `json:"plaintext"`
+	AcraStruct []byte `json:"acrastruct"`
+}
+
+// LoadFixtures reads fixtures from JSON file
+func LoadFixtures(path string) ([]*Fixture, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fixtures []*Fixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, err
+	}
+	return fixtures, nil
+}
+
+// SaveFixtures writes fixtures to JSON file
+func SaveFixtures(path string, fixtures []*Fixture) error {
+	data, err := json.MarshalIndent(fixtures, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Recording is both directions of one recorded connection
+type Recording struct {
+	Name string
+	// Upstream is sent from client to server
+	Upstream []byte
+	// Downstream is sent from server to client
+	Downstream []byte
+}
+
+// LoadRecordings reads recordings with names starting with prefix from directory sorted by name
+func LoadRecordings(dir, prefix string) ([]*Recording, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, prefix+"*"+UpstreamSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	recordings := make([]*Recording, 0, len(paths))
+	for _, path := range paths {
+		base := strings.TrimSuffix(path, UpstreamSuffix)
+		upstream, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		downstream, err := ioutil.ReadFile(base + DownstreamSuffix)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		recordings = append(recordings, &Recording{Name: filepath.Base(base), Upstream: upstream, Downstream: downstream})
+	}
+	return recordings, nil
+}
+
+// Mismatch describes difference between expected and actual message of client's recording
+type Mismatch struct {
+	Recording string
+	// Index of message in stream or -1 if recording doesn't have matching database recording
+	Index    int
+	Expected []byte
+	Actual   []byte
+	Reason   string
+}
+
+func (mismatch *Mismatch) Error() string {
+	if mismatch.Index < 0 {
+		return fmt.Sprintf("%s: %s", mismatch.Recording, mismatch.Reason)
+	}
+	return fmt.Sprintf("%s: message %d: %s\nexpected: %s\nactual:   %s", mismatch.Recording, mismatch.Index,
+		mismatch.Reason, hex.EncodeToString(mismatch.Expected), hex.EncodeToString(mismatch.Actual))
+}
+
+// Compare matches each client's recording with database's recording by equal upstream and checks that downstream
+// received by client is downstream of database with AcraStructs replaced by plaintexts. Returns all found mismatches
+func Compare(protocol string, clientRecordings, dbRecordings []*Recording, fixtures []*Fixture) ([]*Mismatch, error) {
+	var splitter func(stream []byte, sslRequest bool) ([][]byte, error)
+	var replacer func(message []byte, fixtures []*Fixture) []byte
+	switch protocol {
+	case ProtocolPostgreSQL:
+		splitter, replacer = SplitPostgreSQLMessages, ReplacePostgreSQLAcraStructs
+	case ProtocolMySQL:
+		splitter, replacer = SplitMySQLPackets, ReplaceMySQLAcraStructs
+	default:
+		return nil, ErrUnsupportedProtocol
+	}
+	var mismatches []*Mismatch
+	used := make([]bool, len(dbRecordings))
+	for _, client := range clientRecordings {
+		dbIndex := -1
+		for i, db := range dbRecordings {
+			if !used[i] && bytes.Equal(client.Upstream, db.Upstream) {
+				dbIndex = i
+				break
+			}
+		}
+		if dbIndex == -1 {
+			mismatches = append(mismatches, &Mismatch{Recording: client.Name, Index: -1, Reason: ErrUpstreamChanged.Error()})
+			continue
+		}
+		used[dbIndex] = true
+		sslRequest := protocol == ProtocolPostgreSQL && isPostgreSQLSSLRequest(client.Upstream)
+		expected, err := splitter(dbRecordings[dbIndex].Downstream, sslRequest)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", dbRecordings[dbIndex].Name, err)
+		}
+		actual, err := splitter(client.Downstream, sslRequest)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", client.Name, err)
+		}
+		if mismatch := compareMessages(client.Name, expected, actual, fixtures, replacer); mismatch != nil {
+			mismatches = append(mismatches, mismatch)
+		}
+	}
+	return mismatches, nil
+}
+
+func compareMessages(name string, expected, actual [][]byte, fixtures []*Fixture, replacer func([]byte, []*Fixture) []byte) *Mismatch {
+	for i, message := range expected {
+		message = replacer(message, fixtures)
+		if i >= len(actual) {
+			return &Mismatch{Recording: name, Index: i, Expected: message, Reason: "client didn't receive message"}
+		}
+		if !bytes.Equal(message, actual[i]) {
+			return &Mismatch{Recording: name, Index: i, Expected: message, Actual: actual[i], Reason: "message was changed"}
+		}
+	}
+	if len(actual) > len(expected) {
+		return &Mismatch{Recording: name, Index: len(expected), Actual: actual[len(expected)], Reason: "client received unexpected message"}
+	}
+	return nil
+}
+
+// postgreSQLSSLRequestCode is code of SSLRequest message
+// https://www.postgresql.org/docs/current/static/protocol-message-formats.html
+const postgreSQLSSLRequestCode = 80877103
+
+func isPostgreSQLSSLRequest(upstream []byte) bool {
+	return len(upstream) >= 8 && binary.BigEndian.Uint32(upstream[:4]) == 8 && binary.BigEndian.Uint32(upstream[4:8]) == postgreSQLSSLRequestCode
+}
+
+// SplitPostgreSQLMessages splits stream of PostgreSQL backend into messages. If client sent SSLRequest then first
+// message is one byte answer
+func SplitPostgreSQLMessages(stream []byte, sslRequest bool) ([][]byte, error) {
+	var messages [][]byte
+	if sslRequest && len(stream) > 0 {
+		messages = append(messages, stream[:1])
+		stream = stream[1:]
+	}
+	for len(stream) > 0 {
+		// 1 byte of type and 4 bytes of length which includes itself
+		if len(stream) < 5 {
+			return nil, ErrMalformedStream
+		}
+		length := int(binary.BigEndian.Uint32(stream[1:5]))
+		if length < 4 || len(stream) < 1+length {
+			return nil, ErrMalformedStream
+		}
+		messages = append(messages, stream[:1+length])
+		stream = stream[1+length:]
+	}
+	return messages, nil
+}
+
+// ReplacePostgreSQLAcraStructs returns DataRow message with values equal to AcraStructs (in binary or hex format)
+// replaced by their plaintexts. Other messages are returned as is
+func ReplacePostgreSQLAcraStructs(message []byte, fixtures []*Fixture) []byte {
+	// type, length and count of columns
+	if len(message) < 7 || message[0] != 'D' {
+		return message
+	}
+	columnCount := int(binary.BigEndian.Uint16(message[5:7]))
+	output := append([]byte{}, message[:7]...)
+	pos := 7
+	for i := 0; i < columnCount; i++ {
+		if len(message) < pos+4 {
+			return message
+		}
+		length := int32(binary.BigEndian.Uint32(message[pos : pos+4]))
+		pos += 4
+		if length < 0 {
+			// NULL
+			output = append(output, message[pos-4:pos]...)
+			continue
+		}
+		if len(message) < pos+int(length) {
+			return message
+		}
+		value := replacePostgreSQLValue(message[pos:pos+int(length)], fixtures)
+		output = append(output, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(output[len(output)-4:], uint32(len(value)))
+		output = append(output, value...)
+		pos += int(length)
+	}
+	binary.BigEndian.PutUint32(output[1:5], uint32(len(output)-1))
+	return output
+}
+
+func replacePostgreSQLValue(value []byte, fixtures []*Fixture) []byte {
+	for _, fixture := range fixtures {
+		if bytes.Equal(value, fixture.AcraStruct) {
+			return fixture.Plaintext
+		}
+		if len(value) > 2 && value[0] == '\\' && value[1] == 'x' && string(value[2:]) == hex.EncodeToString(fixture.AcraStruct) {
+			return append([]byte(`\x`), hex.EncodeToString(fixture.Plaintext)...)
+		}
+	}
+	return value
+}
+
+// mysqlHeaderSize is 3 bytes of payload length and 1 byte of sequence id
+const mysqlHeaderSize = 4
+
+// SplitMySQLPackets splits MySQL stream into packets
+func SplitMySQLPackets(stream []byte, _ bool) ([][]byte, error) {
+	var packets [][]byte
+	for len(stream) > 0 {
+		if len(stream) < mysqlHeaderSize {
+			return nil, ErrMalformedStream
+		}
+		length := int(stream[0]) | int(stream[1])<<8 | int(stream[2])<<16
+		if len(stream) < mysqlHeaderSize+length {
+			return nil, ErrMalformedStream
+		}
+		packets = append(packets, stream[:mysqlHeaderSize+length])
+		stream = stream[mysqlHeaderSize+length:]
+	}
+	return packets, nil
+}
+
+// ReplaceMySQLAcraStructs returns packet with length encoded AcraStructs replaced by length encoded plaintexts
+func ReplaceMySQLAcraStructs(packet []byte, fixtures []*Fixture) []byte {
+	if len(packet) < mysqlHeaderSize {
+		return packet
+	}
+	payload := packet[mysqlHeaderSize:]
+	changed := false
+	for _, fixture := range fixtures {
+		encoded := mysqlLengthEncoded(fixture.AcraStruct)
+		if bytes.Contains(payload, encoded) {
+			payload = bytes.Replace(payload, encoded, mysqlLengthEncoded(fixture.Plaintext), -1)
+			changed = true
+		}
+	}
+	if !changed {
+		return packet
+	}
+	output := make([]byte, mysqlHeaderSize, mysqlHeaderSize+len(payload))
+	output[0], output[1], output[2] = byte(len(payload)), byte(len(payload)>>8), byte(len(payload)>>16)
+	output[3] = packet[3]
+	return append(output, payload...)
+}
+
+// mysqlLengthEncoded returns value with length encoded prefix
+// https://dev.mysql.com/doc/internals/en/string.html#packet-Protocol::LengthEncodedString
+func mysqlLengthEncoded(value []byte) []byte {
+	length := uint64(len(value))
+	var prefix []byte
+	switch {
+	case length < 251:
+		prefix = []byte{byte(length)}
+	case length < 1<<16:
+		prefix = []byte{0xfc, byte(length), byte(length >> 8)}
+	case length < 1<<24:
+		prefix = []byte{0xfd, byte(length), byte(length >> 8), byte(length >> 16)}
+	default:
+		prefix = make([]byte, 9)
+		prefix[0] = 0xfe
+		binary.LittleEndian.PutUint64(prefix[1:], length)
+	}
+	return append(prefix, value...)
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package conformance
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+func postgreSQLMessage(messageType byte, payload []byte) []byte {
+	message := []byte{messageType, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(message[1:], uint32(len(payload)+4))
+	return append(message, payload...)
+}
+
+func postgreSQLDataRow(values ...[]byte) []byte {
+	payload := []byte{0, byte(len(values))}
+	for _, value := range values {
+		length := make([]byte, 4)
+		if value == nil {
+			binary.BigEndian.PutUint32(length, 0xffffffff)
+		} else {
+			binary.BigEndian.PutUint32(length, uint32(len(value)))
+		}
+		payload = append(append(payload, length...), value...)
+	}
+	return postgreSQLMessage('D', payload)
+}
+
+func mysqlPacket(sequence byte, payload []byte) []byte {
+	return append([]byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), sequence}, payload...)
+}
+
+func concat(messages ...[]byte) []byte {
+	var output []byte
+	for _, message := range messages {
+		output = append(output, message...)
+	}
+	return output
+}
+
+func TestComparePostgreSQL(t *testing.T) {
+	fixtures := []*Fixture{{ID: 1, Plaintext: []byte("plaintext"), AcraStruct: []byte("\"\"\"\"\"\"\"\"acrastruct")}}
+	upstream := postgreSQLMessage('Q', []byte("select data from test\x00"))
+	hexAcraStruct := append([]byte(`\x`), hex.EncodeToString(fixtures[0].AcraStruct)...)
+	hexPlaintext := append([]byte(`\x`), hex.EncodeToString(fixtures[0].Plaintext)...)
+	dbDownstream := concat(
+		postgreSQLMessage('T', []byte("description")),
+		postgreSQLDataRow(fixtures[0].AcraStruct, nil, []byte("1")),
+		postgreSQLDataRow(hexAcraStruct),
+		postgreSQLMessage('Z', []byte("I")))
+	clientDownstream := concat(
+		postgreSQLMessage('T', []byte("description")),
+		postgreSQLDataRow(fixtures[0].Plaintext, nil, []byte("1")),
+		postgreSQLDataRow(hexPlaintext),
+		postgreSQLMessage('Z', []byte("I")))
+	db := []*Recording{{Name: "db", Upstream: upstream, Downstream: dbDownstream}}
+
+	mismatches, err := Compare(ProtocolPostgreSQL, []*Recording{{Name: "client", Upstream: upstream, Downstream: clientDownstream}}, db, fixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 0 {
+		t.Fatalf("Unexpected mismatches: %v", mismatches[0])
+	}
+
+	changedDownstream := concat(
+		postgreSQLMessage('T', []byte("description")),
+		postgreSQLDataRow(fixtures[0].Plaintext, []byte(""), []byte("1")),
+		postgreSQLDataRow(hexPlaintext),
+		postgreSQLMessage('Z', []byte("I")))
+	mismatches, err = Compare(ProtocolPostgreSQL, []*Recording{{Name: "client", Upstream: upstream, Downstream: changedDownstream}}, db, fixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 || mismatches[0].Index != 1 {
+		t.Fatalf("Expected mismatch of second message, took %v", mismatches)
+	}
+
+	mismatches, err = Compare(ProtocolPostgreSQL, []*Recording{{Name: "client", Upstream: append(upstream, 0), Downstream: clientDownstream}}, db, fixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 || mismatches[0].Index != -1 {
+		t.Fatalf("Expected mismatch of upstream, took %v", mismatches)
+	}
+
+	if _, err := Compare(ProtocolPostgreSQL, []*Recording{{Name: "client", Upstream: upstream, Downstream: clientDownstream[:3]}}, db, fixtures); err == nil {
+		t.Fatal("Expected error on malformed stream")
+	}
+}
+
+func TestCompareMySQL(t *testing.T) {
+	plaintext := make([]byte, 300)
+	fixtures := []*Fixture{{ID: 1, Plaintext: plaintext, AcraStruct: make([]byte, 400)}}
+	upstream := mysqlPacket(0, []byte("\x03select data from test"))
+	dbDownstream := concat(mysqlPacket(1, []byte{1}), mysqlPacket(2, mysqlLengthEncoded(fixtures[0].AcraStruct)), mysqlPacket(3, []byte{0xfe, 0, 0, 2, 0}))
+	clientDownstream := concat(mysqlPacket(1, []byte{1}), mysqlPacket(2, mysqlLengthEncoded(plaintext)), mysqlPacket(3, []byte{0xfe, 0, 0, 2, 0}))
+	db := []*Recording{{Name: "db", Upstream: upstream, Downstream: dbDownstream}}
+
+	mismatches, err := Compare(ProtocolMySQL, []*Recording{{Name: "client", Upstream: upstream, Downstream: clientDownstream}}, db, fixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 0 {
+		t.Fatalf("Unexpected mismatches: %v", mismatches[0])
+	}
+
+	wrongSequence := concat(mysqlPacket(1, []byte{1}), mysqlPacket(3, mysqlLengthEncoded(plaintext)), mysqlPacket(3, []byte{0xfe, 0, 0, 2, 0}))
+	mismatches, err = Compare(ProtocolMySQL, []*Recording{{Name: "client", Upstream: upstream, Downstream: wrongSequence}}, db, fixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 || mismatches[0].Index != 1 {
+		t.Fatalf("Expected mismatch of second packet, took %v", mismatches)
+	}
+
+	truncated := concat(mysqlPacket(1, []byte{1}), mysqlPacket(2, mysqlLengthEncoded(plaintext)))
+	mismatches, err = Compare(ProtocolMySQL, []*Recording{{Name: "client", Upstream: upstream, Downstream: truncated}}, db, fixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 || mismatches[0].Index != 2 {
+		t.Fatalf("Expected missing third packet, took %v", mismatches)
+	}
+}
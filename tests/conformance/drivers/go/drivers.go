@@ -0,0 +1,151 @@
+//go:build conformance
+// +build conformance
+
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package main runs conformance scenarios through AcraServer with Go drivers: lib/pq and pgx for PostgreSQL and
+// go-sql-driver/mysql for MySQL. Each scenario uses text and binary (prepared statements) protocols
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cossacklabs/acra/tests/conformance"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/stdlib"
+	_ "github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+// scenario describes connection of one driver
+type scenario struct {
+	driver string
+	dsn    string
+	table  string
+	mysql  bool
+}
+
+func main() {
+	fixturesPath := flag.String("fixtures", "fixtures.json", "Path to fixtures")
+	postgresqlHost := flag.String("postgresql", "", "host:port of AcraServer connected to PostgreSQL, empty to skip")
+	mysqlHost := flag.String("mysql", "", "host:port of AcraServer connected to MySQL, empty to skip")
+	flag.Parse()
+	fixtures, err := conformance.LoadFixtures(*fixturesPath)
+	if err != nil {
+		log.WithError(err).Errorln("Can't load fixtures")
+		os.Exit(1)
+	}
+	var scenarios []scenario
+	if *postgresqlHost != "" {
+		scenarios = append(scenarios,
+			scenario{driver: "postgres", dsn: fmt.Sprintf("postgres://test:test@%s/test?sslmode=disable", *postgresqlHost), table: "conformance_lib_pq"},
+			scenario{driver: "pgx", dsn: fmt.Sprintf("postgres://test:test@%s/test?sslmode=disable", *postgresqlHost), table: "conformance_pgx"})
+	}
+	if *mysqlHost != "" {
+		scenarios = append(scenarios,
+			// interpolateParams sends queries with values in text protocol, otherwise prepared statements are used
+			scenario{driver: "mysql", dsn: fmt.Sprintf("test:test@tcp(%s)/test?interpolateParams=true", *mysqlHost), table: "conformance_go_mysql_text", mysql: true},
+			scenario{driver: "mysql", dsn: fmt.Sprintf("test:test@tcp(%s)/test", *mysqlHost), table: "conformance_go_mysql_binary", mysql: true})
+	}
+	failed := false
+	for _, s := range scenarios {
+		logger := log.WithField("table", s.table)
+		if err := run(s, fixtures); err != nil {
+			logger.WithError(err).Errorln("Scenario failed")
+			failed = true
+			continue
+		}
+		logger.Infoln("Scenario passed")
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func run(s scenario, fixtures []*conformance.Fixture) error {
+	db, err := sql.Open(s.driver, s.dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	blobType, placeholder := "bytea", func(i int) string { return fmt.Sprintf("$%d", i) }
+	if s.mysql {
+		blobType, placeholder = "longblob", func(int) string { return "?" }
+	}
+	if _, err := db.Exec("DROP TABLE IF EXISTS " + s.table); err != nil {
+		return err
+	}
+	if _, err := db.Exec(fmt.Sprintf("CREATE TABLE %s (id INTEGER PRIMARY KEY, data %s, note VARCHAR(64), amount NUMERIC(10, 2), created TIMESTAMP NULL, empty VARCHAR(8))", s.table, blobType)); err != nil {
+		return err
+	}
+	created := time.Date(2018, 12, 31, 23, 59, 59, 0, time.UTC)
+	insert := fmt.Sprintf("INSERT INTO %s (id, data, note, amount, created, empty) VALUES (%s, %s, %s, %s, %s, NULL)", s.table, placeholder(1), placeholder(2), placeholder(3), placeholder(4), placeholder(5))
+	for _, fixture := range fixtures {
+		if _, err := db.Exec(insert, fixture.ID, fixture.AcraStruct, fmt.Sprintf("note %d", fixture.ID), 10.5, created); err != nil {
+			return err
+		}
+	}
+	if err := checkRows(db, "SELECT id, data, note, amount, empty FROM "+s.table+" ORDER BY id", nil, fixtures); err != nil {
+		return fmt.Errorf("query without parameters: %v", err)
+	}
+	query := fmt.Sprintf("SELECT id, data, note, amount, empty FROM %s WHERE id >= %s ORDER BY id", s.table, placeholder(1))
+	if err := checkRows(db, query, []interface{}{0}, fixtures); err != nil {
+		return fmt.Errorf("query with parameters: %v", err)
+	}
+	return nil
+}
+
+func checkRows(db *sql.DB, query string, args []interface{}, fixtures []*conformance.Fixture) error {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	i := 0
+	for rows.Next() {
+		var id int
+		var data []byte
+		var note, amount string
+		var empty sql.NullString
+		if err := rows.Scan(&id, &data, &note, &amount, &empty); err != nil {
+			return err
+		}
+		if i >= len(fixtures) {
+			return fmt.Errorf("unexpected row %d", id)
+		}
+		fixture := fixtures[i]
+		if id != fixture.ID || !bytes.Equal(data, fixture.Plaintext) {
+			return fmt.Errorf("row %d: decrypted value isn't equal to plaintext", id)
+		}
+		if note != fmt.Sprintf("note %d", fixture.ID) || amount != "10.50" || empty.Valid {
+			return fmt.Errorf("row %d: unencrypted values were changed", id)
+		}
+		i++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if i != len(fixtures) {
+		return fmt.Errorf("expected %d rows, took %d", len(fixtures), i)
+	}
+	return nil
+}
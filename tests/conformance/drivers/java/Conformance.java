@@ -0,0 +1,132 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import java.nio.charset.StandardCharsets;
+import java.nio.file.Files;
+import java.nio.file.Paths;
+import java.sql.Connection;
+import java.sql.DriverManager;
+import java.sql.PreparedStatement;
+import java.sql.ResultSet;
+import java.sql.Statement;
+import java.util.ArrayList;
+import java.util.Arrays;
+import java.util.Base64;
+import java.util.List;
+import java.util.regex.Matcher;
+import java.util.regex.Pattern;
+
+/**
+ * Conformance scenarios through AcraServer with JDBC drivers of PostgreSQL and MySQL. Queries are executed as plain
+ * statements and as server side prepared statements.
+ * Usage: java Conformance fixtures.json [postgresql host:port] [mysql host:port]
+ */
+public class Conformance {
+    static class Fixture {
+        int id;
+        byte[] plaintext;
+        byte[] acrastruct;
+    }
+
+    // fixtures are generated by tests/conformance/fixtures, so simple regexp is enough to parse them without
+    // additional dependencies
+    static List<Fixture> loadFixtures(String path) throws Exception {
+        String data = new String(Files.readAllBytes(Paths.get(path)), StandardCharsets.UTF_8);
+        Pattern pattern = Pattern.compile(
+                "\"id\":\\s*(\\d+),\\s*\"plaintext\":\\s*\"([^\"]*)\",\\s*\"acrastruct\":\\s*\"([^\"]*)\"");
+        Matcher matcher = pattern.matcher(data);
+        List<Fixture> fixtures = new ArrayList<>();
+        while (matcher.find()) {
+            Fixture fixture = new Fixture();
+            fixture.id = Integer.parseInt(matcher.group(1));
+            fixture.plaintext = Base64.getDecoder().decode(matcher.group(2));
+            fixture.acrastruct = Base64.getDecoder().decode(matcher.group(3));
+            fixtures.add(fixture);
+        }
+        return fixtures;
+    }
+
+    static void checkRows(ResultSet rows, List<Fixture> fixtures) throws Exception {
+        int i = 0;
+        while (rows.next()) {
+            if (i >= fixtures.size()) {
+                throw new AssertionError("unexpected row " + rows.getInt(1));
+            }
+            Fixture fixture = fixtures.get(i);
+            if (rows.getInt(1) != fixture.id || !Arrays.equals(rows.getBytes(2), fixture.plaintext)) {
+                throw new AssertionError("row " + fixture.id + ": decrypted value isn't equal to plaintext");
+            }
+            if (!("note " + fixture.id).equals(rows.getString(3))) {
+                throw new AssertionError("row " + fixture.id + ": unencrypted value was changed");
+            }
+            i++;
+        }
+        if (i != fixtures.size()) {
+            throw new AssertionError("expected " + fixtures.size() + " rows, took " + i);
+        }
+    }
+
+    static void run(String url, String table, String blobType, List<Fixture> fixtures) throws Exception {
+        try (Connection connection = DriverManager.getConnection(url, "test", "test")) {
+            try (Statement statement = connection.createStatement()) {
+                statement.execute("DROP TABLE IF EXISTS " + table);
+                statement.execute("CREATE TABLE " + table + " (id INTEGER PRIMARY KEY, data " + blobType + ", note VARCHAR(64))");
+            }
+            try (PreparedStatement insert = connection.prepareStatement("INSERT INTO " + table + " (id, data, note) VALUES (?, ?, ?)")) {
+                for (Fixture fixture : fixtures) {
+                    insert.setInt(1, fixture.id);
+                    insert.setBytes(2, fixture.acrastruct);
+                    insert.setString(3, "note " + fixture.id);
+                    insert.executeUpdate();
+                }
+            }
+            try (Statement statement = connection.createStatement();
+                 ResultSet rows = statement.executeQuery("SELECT id, data, note FROM " + table + " ORDER BY id")) {
+                checkRows(rows, fixtures);
+            }
+            try (PreparedStatement select = connection.prepareStatement("SELECT id, data, note FROM " + table + " WHERE id >= ? ORDER BY id")) {
+                select.setInt(1, 0);
+                try (ResultSet rows = select.executeQuery()) {
+                    checkRows(rows, fixtures);
+                }
+            }
+        }
+    }
+
+    public static void main(String[] args) throws Exception {
+        List<Fixture> fixtures = loadFixtures(args[0]);
+        boolean failed = false;
+        List<String[]> scenarios = new ArrayList<>();
+        if (args.length > 1 && !args[1].isEmpty()) {
+            // prepareThreshold=1 uses named server side prepared statements from first execution
+            scenarios.add(new String[]{"jdbc:postgresql://" + args[1] + "/test?sslmode=disable&prepareThreshold=1", "conformance_jdbc_postgresql", "bytea"});
+        }
+        if (args.length > 2 && !args[2].isEmpty()) {
+            scenarios.add(new String[]{"jdbc:mysql://" + args[2] + "/test?useSSL=false", "conformance_jdbc_mysql_text", "longblob"});
+            scenarios.add(new String[]{"jdbc:mysql://" + args[2] + "/test?useSSL=false&useServerPrepStmts=true", "conformance_jdbc_mysql_binary", "longblob"});
+        }
+        for (String[] scenario : scenarios) {
+            try {
+                run(scenario[0], scenario[1], scenario[2], fixtures);
+                System.out.println(scenario[1] + ": passed");
+            } catch (Exception | AssertionError e) {
+                System.out.println(scenario[1] + ": failed: " + e);
+                failed = true;
+            }
+        }
+        System.exit(failed ? 1 : 0);
+    }
+}
@@ -0,0 +1,105 @@
+# Copyright 2018, Cossack Labs Limited
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Conformance scenarios through AcraServer with python drivers: psycopg2 for
+PostgreSQL and mysql-connector-python for MySQL (text protocol and binary
+prepared statements)."""
+
+import argparse
+import base64
+import json
+import sys
+
+import mysql.connector
+import psycopg2
+
+
+def load_fixtures(path):
+    with open(path) as f:
+        return [
+            {
+                'id': fixture['id'],
+                'plaintext': base64.b64decode(fixture['plaintext']),
+                'acrastruct': base64.b64decode(fixture['acrastruct']),
+            }
+            for fixture in json.load(f)
+        ]
+
+
+def check_rows(rows, fixtures):
+    if len(rows) != len(fixtures):
+        raise AssertionError('expected {} rows, took {}'.format(len(fixtures), len(rows)))
+    for row, fixture in zip(rows, fixtures):
+        row_id, data, note = row[0], bytes(row[1]), row[2]
+        if row_id != fixture['id'] or data != fixture['plaintext']:
+            raise AssertionError('row {}: decrypted value isn\'t equal to plaintext'.format(row_id))
+        if note != 'note {}'.format(fixture['id']):
+            raise AssertionError('row {}: unencrypted value was changed'.format(row_id))
+
+
+def run(connection, table, blob_type, placeholder, cursor_factory, fixtures):
+    cursor = connection.cursor()
+    cursor.execute('DROP TABLE IF EXISTS {}'.format(table))
+    cursor.execute('CREATE TABLE {} (id INTEGER PRIMARY KEY, data {}, note VARCHAR(64))'.format(table, blob_type))
+    for fixture in fixtures:
+        cursor.execute(
+            'INSERT INTO {} (id, data, note) VALUES ({p}, {p}, {p})'.format(table, p=placeholder),
+            (fixture['id'], fixture['acrastruct'], 'note {}'.format(fixture['id'])))
+    connection.commit()
+    cursor = cursor_factory()
+    cursor.execute('SELECT id, data, note FROM {} ORDER BY id'.format(table))
+    check_rows(cursor.fetchall(), fixtures)
+    cursor.execute('SELECT id, data, note FROM {} WHERE id >= {} ORDER BY id'.format(table, placeholder), (0,))
+    check_rows(cursor.fetchall(), fixtures)
+
+
+def main():
+    parser = argparse.ArgumentParser()
+    parser.add_argument('--fixtures', default='fixtures.json')
+    parser.add_argument('--postgresql', default='', help='host:port of AcraServer connected to PostgreSQL')
+    parser.add_argument('--mysql', default='', help='host:port of AcraServer connected to MySQL')
+    args = parser.parse_args()
+    fixtures = load_fixtures(args.fixtures)
+    failed = False
+    scenarios = []
+    if args.postgresql:
+        host, port = args.postgresql.split(':')
+        scenarios.append(('conformance_psycopg2', lambda: psycopg2.connect(
+            host=host, port=int(port), user='test', password='test', dbname='test', sslmode='disable'),
+            'bytea', '%s', None))
+    if args.mysql:
+        host, port = args.mysql.split(':')
+        connect = lambda: mysql.connector.connect(
+            host=host, port=int(port), user='test', password='test', database='test', use_pure=True)
+        scenarios.append(('conformance_mysql_connector_text', connect, 'longblob', '%s', None))
+        scenarios.append(('conformance_mysql_connector_binary', connect, 'longblob', '%s', 'prepared'))
+    for table, connect, blob_type, placeholder, cursor_type in scenarios:
+        connection = connect()
+        if cursor_type == 'prepared':
+            cursor_factory = lambda: connection.cursor(prepared=True)
+        else:
+            cursor_factory = connection.cursor
+        try:
+            run(connection, table, blob_type, placeholder, cursor_factory, fixtures)
+            print('{}: passed'.format(table))
+        except Exception as e:
+            print('{}: failed: {}'.format(table, e))
+            failed = True
+        finally:
+            connection.close()
+    sys.exit(1 if failed else 0)
+
+
+if __name__ == '__main__':
+    main()
@@ -0,0 +1,63 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package main generates AcraStructs of plaintexts of different sizes which conformance drivers insert into database
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+
+	"github.com/cossacklabs/acra/acra-writer"
+	"github.com/cossacklabs/acra/tests/conformance"
+	"github.com/cossacklabs/themis/gothemis/keys"
+	log "github.com/sirupsen/logrus"
+)
+
+// plaintextSizes covers length encoding boundaries of MySQL (251, 65536) and values bigger than network buffers
+var plaintextSizes = []int{1, 16, 250, 251, 4096, 65535, 65536, 1 << 20}
+
+func main() {
+	publicKeyPath := flag.String("public_key", "", "Path to public key of AcraWriter (<client_id>_storage.pub)")
+	output := flag.String("output", "fixtures.json", "Path to output file")
+	flag.Parse()
+	publicKeyData, err := ioutil.ReadFile(*publicKeyPath)
+	if err != nil {
+		log.WithError(err).Errorln("Can't read public key")
+		os.Exit(1)
+	}
+	publicKey := &keys.PublicKey{Value: publicKeyData}
+	fixtures := make([]*conformance.Fixture, 0, len(plaintextSizes))
+	for i, size := range plaintextSizes {
+		plaintext := make([]byte, size)
+		for j := range plaintext {
+			// printable characters to compare values returned in text format
+			plaintext[j] = byte('a' + (i+j)%26)
+		}
+		acrastruct, err := acrawriter.CreateAcrastruct(plaintext, publicKey, nil)
+		if err != nil {
+			log.WithError(err).Errorln("Can't create AcraStruct")
+			os.Exit(1)
+		}
+		fixtures = append(fixtures, &conformance.Fixture{ID: i + 1, Plaintext: plaintext, AcraStruct: acrastruct})
+	}
+	if err := conformance.SaveFixtures(*output, fixtures); err != nil {
+		log.WithError(err).Errorln("Can't save fixtures")
+		os.Exit(1)
+	}
+	log.Infof("Saved %d fixtures to %s", len(fixtures), *output)
+}
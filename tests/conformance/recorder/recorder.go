@@ -0,0 +1,105 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package main is TCP proxy which saves both directions of each proxied connection to files for conformance checks.
+// Upstream (client to server) is saved to <dir>/<name>-<connection number>.upstream and downstream to .downstream
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cossacklabs/acra/tests/conformance"
+	log "github.com/sirupsen/logrus"
+)
+
+func main() {
+	listen := flag.String("listen", "0.0.0.0:5432", "Address to listen for clients")
+	target := flag.String("target", "", "Address of server to which connections are proxied")
+	dir := flag.String("dir", "records", "Directory for recorded streams")
+	name := flag.String("name", "", "Prefix of names of recorded files")
+	flag.Parse()
+	if *target == "" || *name == "" {
+		log.Errorln("target and name must be set")
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(*dir, 0700); err != nil {
+		log.WithError(err).Errorln("Can't create directory for records")
+		os.Exit(1)
+	}
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.WithError(err).Errorln("Can't listen")
+		os.Exit(1)
+	}
+	log.Infof("Record connections %s -> %s", *listen, *target)
+	var counter int32
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			log.WithError(err).Errorln("Can't accept connection")
+			os.Exit(1)
+		}
+		number := atomic.AddInt32(&counter, 1)
+		go record(client, *target, filepath.Join(*dir, fmt.Sprintf("%s-%04d", *name, number)))
+	}
+}
+
+func record(client net.Conn, target, path string) {
+	logger := log.WithField("record", path)
+	defer client.Close()
+	server, err := net.Dial("tcp", target)
+	if err != nil {
+		logger.WithError(err).Errorln("Can't connect to target")
+		return
+	}
+	defer server.Close()
+	upstream, err := os.Create(path + conformance.UpstreamSuffix)
+	if err != nil {
+		logger.WithError(err).Errorln("Can't create file")
+		return
+	}
+	defer upstream.Close()
+	downstream, err := os.Create(path + conformance.DownstreamSuffix)
+	if err != nil {
+		logger.WithError(err).Errorln("Can't create file")
+		return
+	}
+	defer downstream.Close()
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go proxy(server, client, upstream, wg)
+	go proxy(client, server, downstream, wg)
+	wg.Wait()
+	logger.Debugln("Connection recorded")
+}
+
+// proxy copies data from source to destination and to file until source is closed, then closes destination
+func proxy(destination, source net.Conn, file io.Writer, wg *sync.WaitGroup) {
+	defer wg.Done()
+	io.Copy(io.MultiWriter(destination, file), source)
+	if tcpConnection, ok := destination.(*net.TCPConn); ok {
+		tcpConnection.CloseWrite()
+	} else {
+		destination.Close()
+	}
+}
@@ -0,0 +1,47 @@
+#!/usr/bin/env bash
+# Runs conformance harness: real drivers through AcraServer against real databases with byte level checks of
+# recorded protocol streams. Requires docker-compose and AcraServer/AcraKeymaker images (make docker).
+# Usage: tests/conformance/run.sh [postgresql] [mysql]
+set -e
+
+cd "$(dirname "$0")"
+PROTOCOLS=${@:-postgresql mysql}
+COMPOSE="docker-compose -p acra-conformance"
+POSTGRESQL=recorder-postgresql-client:5432
+MYSQL=recorder-mysql-client:3306
+
+cleanup() {
+    $COMPOSE down -v >/dev/null 2>&1 || true
+}
+trap cleanup EXIT
+
+rm -rf .acrakeys .records
+mkdir -p .records
+$COMPOSE build
+$COMPOSE run --rm acra-keymaker
+$COMPOSE run --rm --entrypoint fixtures tools \
+    --public_key=/keys/acra-writer/conformance_storage.pub --output=records/fixtures.json
+
+postgresql_address=""
+mysql_address=""
+for protocol in $PROTOCOLS; do
+    $COMPOSE up -d "recorder-${protocol}-client"
+    if [ "$protocol" = "postgresql" ]; then postgresql_address=$POSTGRESQL; fi
+    if [ "$protocol" = "mysql" ]; then mysql_address=$MYSQL; fi
+done
+# wait databases initialization
+sleep ${CONFORMANCE_DB_STARTUP_TIMEOUT:-30}
+
+status=0
+$COMPOSE run --rm driver-go --postgresql="$postgresql_address" --mysql="$mysql_address" || status=1
+$COMPOSE run --rm driver-python --postgresql="$postgresql_address" --mysql="$mysql_address" || status=1
+$COMPOSE run --rm driver-jdbc "$postgresql_address" "$mysql_address" || status=1
+
+# stop proxies to flush recorded streams
+$COMPOSE stop
+for protocol in $PROTOCOLS; do
+    echo "-------------------- Compare ${protocol} streams"
+    $COMPOSE run --rm --entrypoint compare tools --protocol="$protocol" --dir=records \
+        --client_prefix="${protocol}-client" --db_prefix="${protocol}-db" --fixtures=records/fixtures.json || status=1
+done
+exit $status
@@ -189,41 +189,30 @@ func Min(x, y int) int {
 	return y
 }
 
-// FindTag returns first index of symbol in block
+// FindTag returns index of first sequence of count symbols in block
 // return -1 if not found
+// Candidates are found with bytes.IndexByte which is implemented with SIMD instructions on most platforms. Then
+// window of count bytes is checked from the end, so any byte which isn't symbol allows to skip whole window.
 func FindTag(symbol byte, count int, block []byte) int {
-	if len(block) < count {
-		return NotFound
-	}
-	halfCount := count / 2
-	tag := make([]byte, halfCount)
-
-	for i := 0; i < halfCount; i++ {
-		tag[i] = symbol
-	}
-
-	for i := 0; i+halfCount <= len(block); i += halfCount {
-		if bytes.Equal(tag, block[i:i+halfCount]) {
-			start := i
-			if i != 0 {
-				for ; start > i-halfCount; start-- {
-					if block[start-1] != symbol {
-						break
-					}
-				}
-			}
-			end := i + halfCount - 1
-			rightRange := Min(end+halfCount, len(block)-1)
-			for ; end < rightRange; end++ {
-				if block[end+1] != symbol {
-					break
-				}
-			}
-
-			if count <= (end-start)+1 {
-				return start
-			}
+	position := 0
+	for len(block)-position >= count {
+		index := bytes.IndexByte(block[position:], symbol)
+		if index == NotFound {
+			return NotFound
+		}
+		start := position + index
+		if len(block)-start < count {
+			return NotFound
+		}
+		end := start + count - 1
+		for end > start && block[end] == symbol {
+			end--
+		}
+		if end <= start {
+			return start
 		}
+		// sequence can't start before mismatched byte
+		position = end + 1
 	}
 	return NotFound
 }
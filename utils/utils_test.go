@@ -16,6 +16,7 @@ limitations under the License.
 package utils_test
 
 import (
+	"bytes"
 	"github.com/cossacklabs/acra/utils"
 	"os"
 	"testing"
@@ -110,4 +111,32 @@ func TestFindTag(t *testing.T) {
 	if utils.FindTag(symbol, count, testData) != utils.NotFound {
 		t.Fatal("Incorrectly found tag")
 	}
+
+	testData = []byte("0111111101111111111")
+	if utils.FindTag(symbol, count, testData) != 9 {
+		t.Fatal("Incorrectly found tag")
+	}
+}
+
+// benchmarkFindTag measures search of tag placed at the end of block
+func benchmarkFindTag(b *testing.B, block []byte) {
+	const tagLength = 8
+	block = append(block, bytes.Repeat([]byte{'1'}, tagLength)...)
+	b.SetBytes(int64(len(block)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if utils.FindTag('1', tagLength, block) != len(block)-tagLength {
+			b.Fatal("Incorrectly found tag")
+		}
+	}
+}
+
+// BenchmarkFindTag measures search in data without tag symbols
+func BenchmarkFindTag(b *testing.B) {
+	benchmarkFindTag(b, bytes.Repeat([]byte{'0'}, 64*1024))
+}
+
+// BenchmarkFindTagFrequentSymbol measures search in data where every other byte is tag symbol
+func BenchmarkFindTagFrequentSymbol(b *testing.B) {
+	benchmarkFindTag(b, bytes.Repeat([]byte("10"), 32*1024))
 }
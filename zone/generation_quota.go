@@ -0,0 +1,148 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zone
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Errors returned by GenerationQuota
+var (
+	ErrZoneQuotaExceeded = errors.New("quota of generated zones exceeded")
+	ErrZoneRateLimited   = errors.New("too many zone generation requests")
+)
+
+// GenerationQuota limits count of zones generated by each caller per period and rate of caller's generation
+// requests. Zero limits turn off corresponding check. Safe for concurrent use
+type GenerationQuota struct {
+	mutex             sync.Mutex
+	maxZones          int
+	period            time.Duration
+	requestsPerSecond float64
+	callers           map[string]*callerUsage
+	lastCleanup       time.Time
+	now               func() time.Time
+}
+
+type callerUsage struct {
+	periodStart time.Time
+	zones       int
+	// tokens of requests bucket refilled with requestsPerSecond up to burst of one second
+	tokens      float64
+	lastRequest time.Time
+}
+
+// NewGenerationQuota returns quota which allows maxZones zones per period and requestsPerSecond generation requests
+// for each caller
+func NewGenerationQuota(maxZones int, period time.Duration, requestsPerSecond float64) *GenerationQuota {
+	return &GenerationQuota{
+		maxZones:          maxZones,
+		period:            period,
+		requestsPerSecond: requestsPerSecond,
+		callers:           make(map[string]*callerUsage),
+		lastCleanup:       time.Now(),
+		now:               time.Now,
+	}
+}
+
+// burst returns max count of requests allowed at once
+func (quota *GenerationQuota) burst() float64 {
+	if quota.requestsPerSecond < 1 {
+		return 1
+	}
+	return quota.requestsPerSecond
+}
+
+// Reserve registers request of caller to generate count zones. Returns ErrZoneRateLimited if caller sends requests
+// too often and ErrZoneQuotaExceeded if zones would exceed quota of period, rejected requests don't use quota.
+// Returns left count of zones in current period or -1 if count isn't limited. Safe to call on nil GenerationQuota
+func (quota *GenerationQuota) Reserve(caller string, count int) (int, error) {
+	if quota == nil {
+		return -1, nil
+	}
+	quota.mutex.Lock()
+	defer quota.mutex.Unlock()
+	now := quota.now()
+	quota.cleanup(now)
+	usage, ok := quota.callers[caller]
+	if !ok {
+		usage = &callerUsage{periodStart: now, tokens: quota.burst(), lastRequest: now}
+		quota.callers[caller] = usage
+	}
+	if quota.requestsPerSecond > 0 {
+		usage.tokens += now.Sub(usage.lastRequest).Seconds() * quota.requestsPerSecond
+		if usage.tokens > quota.burst() {
+			usage.tokens = quota.burst()
+		}
+		usage.lastRequest = now
+		if usage.tokens < 1 {
+			return quota.left(usage), ErrZoneRateLimited
+		}
+	}
+	if quota.maxZones > 0 {
+		if now.Sub(usage.periodStart) >= quota.period {
+			usage.periodStart, usage.zones = now, 0
+		}
+		if usage.zones+count > quota.maxZones {
+			return quota.left(usage), ErrZoneQuotaExceeded
+		}
+		usage.zones += count
+	}
+	if quota.requestsPerSecond > 0 {
+		usage.tokens--
+	}
+	return quota.left(usage), nil
+}
+
+// Release returns to quota of caller zones which were reserved but weren't generated
+func (quota *GenerationQuota) Release(caller string, count int) {
+	if quota == nil || quota.maxZones <= 0 {
+		return
+	}
+	quota.mutex.Lock()
+	defer quota.mutex.Unlock()
+	if usage, ok := quota.callers[caller]; ok {
+		usage.zones -= count
+		if usage.zones < 0 {
+			usage.zones = 0
+		}
+	}
+}
+
+func (quota *GenerationQuota) left(usage *callerUsage) int {
+	if quota.maxZones <= 0 {
+		return -1
+	}
+	return quota.maxZones - usage.zones
+}
+
+// cleanup removes callers whose period ended and bucket of requests is full, not more often than once per minute
+func (quota *GenerationQuota) cleanup(now time.Time) {
+	if now.Sub(quota.lastCleanup) < time.Minute {
+		return
+	}
+	quota.lastCleanup = now
+	for caller, usage := range quota.callers {
+		periodEnded := quota.maxZones <= 0 || now.Sub(usage.periodStart) >= quota.period
+		bucketFull := quota.requestsPerSecond <= 0 || now.Sub(usage.lastRequest).Seconds()*quota.requestsPerSecond+usage.tokens >= quota.burst()
+		if periodEnded && bucketFull {
+			delete(quota.callers, caller)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zone
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGenerationQuota(t *testing.T) {
+	now := time.Now()
+	quota := NewGenerationQuota(10, time.Hour, 1)
+	quota.now = func() time.Time { return now }
+
+	if left, err := quota.Reserve("caller", 8); err != nil || left != 2 {
+		t.Fatalf("Unexpected result of reservation: %v, %v", left, err)
+	}
+	// second request in the same second
+	if _, err := quota.Reserve("caller", 1); err != ErrZoneRateLimited {
+		t.Fatalf("Expected ErrZoneRateLimited, took %v", err)
+	}
+	// other caller has own quota
+	if _, err := quota.Reserve("other caller", 10); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Second)
+	if _, err := quota.Reserve("caller", 3); err != ErrZoneQuotaExceeded {
+		t.Fatalf("Expected ErrZoneQuotaExceeded, took %v", err)
+	}
+	now = now.Add(time.Second)
+	quota.Release("caller", 2)
+	if left, err := quota.Reserve("caller", 4); err != nil || left != 0 {
+		t.Fatalf("Unexpected result of reservation: %v, %v", left, err)
+	}
+	// quota restored in next period
+	now = now.Add(time.Hour)
+	if left, err := quota.Reserve("caller", 10); err != nil || left != 0 {
+		t.Fatalf("Unexpected result of reservation: %v, %v", left, err)
+	}
+	if len(quota.callers) != 1 {
+		t.Fatal("Callers with ended period weren't cleaned up")
+	}
+
+	var nilQuota *GenerationQuota
+	if left, err := nilQuota.Reserve("caller", 1000); err != nil || left != -1 {
+		t.Fatal("Nil quota should allow everything")
+	}
+}
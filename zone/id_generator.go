@@ -0,0 +1,185 @@
+/*
+Copyright 2016, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zone
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Formats of zone ids supported by registered generators
+const (
+	// IDFormatRandom is random letters, default format of zone ids
+	IDFormatRandom = "random"
+	// IDFormatULID is time ordered id in ULID alphabet
+	IDFormatULID = "ulid"
+	// IDFormatPrefixed is tenant prefix followed by random letters
+	IDFormatPrefixed = "prefixed"
+)
+
+// DefaultIDFormat used if format of zone ids isn't configured
+const DefaultIDFormat = IDFormatRandom
+
+// minPrefixedRandomLength is count of random letters which left after tenant prefix to avoid collisions of zone ids
+const minPrefixedRandomLength = 6
+
+// Errors returned by zone id generators
+var (
+	ErrUnknownIDFormat = errors.New("unknown format of zone id")
+	ErrInvalidIDPrefix = fmt.Errorf("prefix of zone id must be 1-%d letters, digits, '-' or '_'", ZoneIDLength-minPrefixedRandomLength)
+)
+
+// IDGenerator generates ids of new zones. Generated id is body which follows ZoneIDBegin tag and must be ZoneIDLength
+// chars long because zones are matched in data by fixed length. Chars must be valid for key ids and file names
+type IDGenerator interface {
+	GenerateID() []byte
+}
+
+// IDGeneratorParams are settings of generators
+type IDGeneratorParams struct {
+	// Prefix is tenant specific beginning of ids used by IDFormatPrefixed
+	Prefix string
+}
+
+// IDGeneratorFactory creates generator of zone ids with params
+type IDGeneratorFactory func(params IDGeneratorParams) (IDGenerator, error)
+
+var (
+	idGeneratorsLock   sync.RWMutex
+	idGenerators                   = make(map[string]IDGeneratorFactory)
+	currentIDGenerator IDGenerator = randomIDGenerator{}
+)
+
+func init() {
+	RegisterIDGenerator(IDFormatRandom, func(IDGeneratorParams) (IDGenerator, error) { return randomIDGenerator{}, nil })
+	RegisterIDGenerator(IDFormatULID, func(IDGeneratorParams) (IDGenerator, error) { return ulidIDGenerator{}, nil })
+	RegisterIDGenerator(IDFormatPrefixed, newPrefixedIDGenerator)
+}
+
+// RegisterIDGenerator registers factory of zone id generator with format name
+func RegisterIDGenerator(format string, factory IDGeneratorFactory) {
+	idGeneratorsLock.Lock()
+	defer idGeneratorsLock.Unlock()
+	if _, ok := idGenerators[format]; ok {
+		panic(fmt.Sprintf("zone id generator %s registered twice", format))
+	}
+	idGenerators[format] = factory
+}
+
+// IDFormats returns sorted names of registered formats of zone ids
+func IDFormats() []string {
+	idGeneratorsLock.RLock()
+	defer idGeneratorsLock.RUnlock()
+	formats := make([]string, 0, len(idGenerators))
+	for format := range idGenerators {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// NewIDGenerator creates generator of registered format
+func NewIDGenerator(format string, params IDGeneratorParams) (IDGenerator, error) {
+	idGeneratorsLock.RLock()
+	factory, ok := idGenerators[format]
+	idGeneratorsLock.RUnlock()
+	if !ok {
+		return nil, ErrUnknownIDFormat
+	}
+	return factory(params)
+}
+
+// SetIDGenerator sets generator used by GenerateZoneID
+func SetIDGenerator(generator IDGenerator) {
+	idGeneratorsLock.Lock()
+	currentIDGenerator = generator
+	idGeneratorsLock.Unlock()
+}
+
+func getIDGenerator() IDGenerator {
+	idGeneratorsLock.RLock()
+	defer idGeneratorsLock.RUnlock()
+	return currentIDGenerator
+}
+
+func randomLetters(alphabet string, length int) []byte {
+	rand.Seed(time.Now().UnixNano())
+	b := make([]byte, length)
+	for i := range b {
+		b[i] = alphabet[rand.Int63()%int64(len(alphabet))]
+	}
+	return b
+}
+
+// randomIDGenerator generates ids from random letters
+type randomIDGenerator struct{}
+
+// GenerateID returns ZoneIDLength random letters
+func (randomIDGenerator) GenerateID() []byte {
+	return randomLetters(letterBytes, ZoneIDLength)
+}
+
+// crockfordAlphabet is base32 alphabet of ULID without I, L, O, U
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulidTimeLength is count of chars which encode 48 bit timestamp of ULID in milliseconds
+const ulidTimeLength = 10
+
+// ulidIDGenerator generates ids in ULID alphabet ordered by creation time. Canonical ULID is 26 chars, so id keeps
+// 48 bit timestamp of ULID and only 30 random bits instead of 80 to fit ZoneIDLength
+type ulidIDGenerator struct{}
+
+// GenerateID returns timestamp in milliseconds encoded with ulidTimeLength chars followed by random chars
+func (ulidIDGenerator) GenerateID() []byte {
+	return append(encodeULIDTime(time.Now()), randomLetters(crockfordAlphabet, ZoneIDLength-ulidTimeLength)...)
+}
+
+func encodeULIDTime(t time.Time) []byte {
+	milliseconds := uint64(t.UnixNano() / int64(time.Millisecond))
+	encoded := make([]byte, ulidTimeLength)
+	for i := ulidTimeLength - 1; i >= 0; i-- {
+		encoded[i] = crockfordAlphabet[milliseconds%32]
+		milliseconds /= 32
+	}
+	return encoded
+}
+
+// prefixedIDGenerator generates ids which start with tenant prefix so zones may be associated with tenants by id
+type prefixedIDGenerator struct {
+	prefix string
+}
+
+func newPrefixedIDGenerator(params IDGeneratorParams) (IDGenerator, error) {
+	if len(params.Prefix) == 0 || len(params.Prefix) > ZoneIDLength-minPrefixedRandomLength {
+		return nil, ErrInvalidIDPrefix
+	}
+	for _, c := range params.Prefix {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+			return nil, ErrInvalidIDPrefix
+		}
+	}
+	return prefixedIDGenerator{prefix: params.Prefix}, nil
+}
+
+// GenerateID returns prefix followed by random letters up to ZoneIDLength
+func (generator prefixedIDGenerator) GenerateID() []byte {
+	return append([]byte(generator.prefix), randomLetters(letterBytes, ZoneIDLength-len(generator.prefix))...)
+}
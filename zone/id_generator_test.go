@@ -0,0 +1,64 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zone
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cossacklabs/acra/keystore"
+)
+
+func TestIDGenerators(t *testing.T) {
+	defer SetIDGenerator(randomIDGenerator{})
+	for _, format := range IDFormats() {
+		generator, err := NewIDGenerator(format, IDGeneratorParams{Prefix: "tenant42"})
+		if err != nil {
+			t.Fatalf("Can't create generator %s: %v", format, err)
+		}
+		SetIDGenerator(generator)
+		id := GenerateZoneID()
+		if !ValidateZoneID(id) || !keystore.ValidateID(id) {
+			t.Fatalf("Generator %s returned invalid zone id %s", format, id)
+		}
+		if format == IDFormatPrefixed && !bytes.HasPrefix(id, append(append([]byte{}, ZoneIDBegin...), "tenant42"...)) {
+			t.Fatalf("Zone id %s doesn't have prefix", id)
+		}
+	}
+	if _, err := NewIDGenerator("unknown", IDGeneratorParams{}); err != ErrUnknownIDFormat {
+		t.Fatalf("Expected ErrUnknownIDFormat, took %v", err)
+	}
+	for _, prefix := range []string{"", "tenant 42", "tenant/42", strings.Repeat("a", ZoneIDLength-minPrefixedRandomLength+1)} {
+		if _, err := NewIDGenerator(IDFormatPrefixed, IDGeneratorParams{Prefix: prefix}); err != ErrInvalidIDPrefix {
+			t.Fatalf("Expected ErrInvalidIDPrefix for prefix %q, took %v", prefix, err)
+		}
+	}
+}
+
+func TestULIDIDGeneratorOrder(t *testing.T) {
+	first := encodeULIDTime(time.Unix(1500000000, 0))
+	second := encodeULIDTime(time.Unix(1500000000, int64(time.Millisecond)))
+	if bytes.Compare(first, second) >= 0 {
+		t.Fatalf("Ids aren't ordered by time: %s, %s", first, second)
+	}
+	// timestamp of ULID specification example 01ARZ3NDEKTSV4RRFFQ69G5FAV
+	if encoded := encodeULIDTime(time.Unix(0, 1469922850259*int64(time.Millisecond))); string(encoded) != "01ARZ3NDEK" {
+		t.Fatalf("Incorrect encoded time %s", encoded)
+	}
+}
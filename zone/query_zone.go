@@ -0,0 +1,134 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zone
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/cossacklabs/acra/sqldialect"
+	"github.com/xwb1989/sqlparser"
+	"gopkg.in/yaml.v2"
+)
+
+// Errors returned by QueryZoneResolver
+var (
+	ErrInvalidQueryZoneConfig = errors.New("invalid query zone configuration")
+	ErrAmbiguousTenant        = errors.New("query contains comparisons with different tenants")
+)
+
+// TenantZone maps value of tenant column to zone id
+type TenantZone struct {
+	Value  string `yaml:"value"`
+	ZoneID string `yaml:"zone_id"`
+}
+
+// QueryZoneConfig describes tenant column which value in WHERE clause of query defines zone of query's result
+type QueryZoneConfig struct {
+	Column  string        `yaml:"column"`
+	Tenants []*TenantZone `yaml:"tenants"`
+}
+
+// LoadQueryZoneConfig parses configuration of zones inferred from queries in YAML format and validates it
+func LoadQueryZoneConfig(data []byte) (*QueryZoneConfig, error) {
+	config := &QueryZoneConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	if config.Column == "" {
+		return nil, ErrInvalidQueryZoneConfig
+	}
+	values := make(map[string]bool, len(config.Tenants))
+	for _, tenant := range config.Tenants {
+		if values[tenant.Value] || !ValidateZoneID([]byte(tenant.ZoneID)) {
+			return nil, ErrInvalidQueryZoneConfig
+		}
+		values[tenant.Value] = true
+	}
+	return config, nil
+}
+
+// QueryZoneResolver infers zone id from tenant column compared with constant in query (tenant_id = 1) for
+// schemas where zone ids aren't stored with encrypted data
+type QueryZoneResolver struct {
+	column string
+	zones  map[string][]byte
+}
+
+// NewQueryZoneResolver returns new QueryZoneResolver which uses tenants from config
+func NewQueryZoneResolver(config *QueryZoneConfig) *QueryZoneResolver {
+	zones := make(map[string][]byte, len(config.Tenants))
+	for _, tenant := range config.Tenants {
+		zones[tenant.Value] = []byte(tenant.ZoneID)
+	}
+	return &QueryZoneResolver{column: config.Column, zones: zones}
+}
+
+// GetZoneID returns zone id of tenant compared with tenant column in query or nil if query doesn't have such
+// comparisons, tenant isn't configured or query can't be parsed
+func (resolver *QueryZoneResolver) GetZoneID(query string) ([]byte, error) {
+	if !strings.Contains(strings.ToLower(query), strings.ToLower(resolver.column)) {
+		return nil, nil
+	}
+	statement, _, err := sqldialect.Parse(query)
+	if err != nil {
+		return nil, nil
+	}
+	var tenant *string
+	err = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		comparison, ok := node.(*sqlparser.ComparisonExpr)
+		if !ok || comparison.Operator != sqlparser.EqualStr {
+			return true, nil
+		}
+		value, ok := resolver.getTenantValue(comparison.Left, comparison.Right)
+		if !ok {
+			value, ok = resolver.getTenantValue(comparison.Right, comparison.Left)
+		}
+		if !ok {
+			return true, nil
+		}
+		if tenant != nil && *tenant != value {
+			return false, ErrAmbiguousTenant
+		}
+		tenant = &value
+		return true, nil
+	}, statement)
+	if err != nil {
+		return nil, err
+	}
+	if tenant == nil {
+		return nil, nil
+	}
+	return resolver.zones[*tenant], nil
+}
+
+// getTenantValue returns constant value if column is tenant column
+func (resolver *QueryZoneResolver) getTenantValue(column, value sqlparser.Expr) (string, bool) {
+	colName, ok := column.(*sqlparser.ColName)
+	if !ok || !colName.Name.EqualString(resolver.column) {
+		return "", false
+	}
+	sqlVal, ok := value.(*sqlparser.SQLVal)
+	if !ok {
+		return "", false
+	}
+	switch sqlVal.Type {
+	case sqlparser.StrVal, sqlparser.IntVal:
+		return string(sqlVal.Val), true
+	}
+	return "", false
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2018, Cossack Labs Limited
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package zone_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cossacklabs/acra/zone"
+)
+
+const testQueryZoneConfig = `
+column: tenant_id
+tenants:
+  - value: 1
+    zone_id: DDDDDDDDHCzqZAZNbBvybWLR
+  - value: second
+    zone_id: DDDDDDDDMatNOMYjqVOuhACC
+`
+
+func TestQueryZoneResolver(t *testing.T) {
+	config, err := zone.LoadQueryZoneConfig([]byte(testQueryZoneConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := zone.NewQueryZoneResolver(config)
+	firstZone := []byte("DDDDDDDDHCzqZAZNbBvybWLR")
+	secondZone := []byte("DDDDDDDDMatNOMYjqVOuhACC")
+
+	queries := []struct {
+		Query  string
+		ZoneID []byte
+	}{
+		{"SELECT data FROM test WHERE tenant_id=1", firstZone},
+		{"SELECT data FROM test AS t WHERE t.TENANT_ID=1 AND id > 10", firstZone},
+		{"UPDATE test SET data='data' WHERE 'second'=tenant_id", secondZone},
+		{"DELETE FROM test WHERE tenant_id='second'", secondZone},
+		{"SELECT data FROM test WHERE tenant_id=3", nil},
+		{"SELECT data FROM test WHERE tenant_id>1", nil},
+		{"SELECT data FROM test WHERE id=1", nil},
+		{"invalid query with tenant_id", nil},
+	}
+	for i, testCase := range queries {
+		zoneID, err := resolver.GetZoneID(testCase.Query)
+		if err != nil {
+			t.Fatalf("%v. Unexpected error: %v", i, err)
+		}
+		if !bytes.Equal(zoneID, testCase.ZoneID) {
+			t.Errorf("%v. Incorrect zone id %s, expected %s", i, zoneID, testCase.ZoneID)
+		}
+	}
+
+	if _, err := resolver.GetZoneID("SELECT data FROM test WHERE tenant_id=1 OR tenant_id='second'"); err != zone.ErrAmbiguousTenant {
+		t.Fatalf("Expected ErrAmbiguousTenant, took %v", err)
+	}
+
+	invalidConfigs := []string{
+		"tenants:\n  - value: 1\n    zone_id: DDDDDDDDHCzqZAZNbBvybWLR\n",
+		"column: tenant_id\ntenants:\n  - value: 1\n    zone_id: invalid\n",
+		"column: tenant_id\ntenants:\n  - value: 1\n    zone_id: DDDDDDDDHCzqZAZNbBvybWLR\n  - value: 1\n    zone_id: DDDDDDDDMatNOMYjqVOuhACC\n",
+	}
+	for i, invalidConfig := range invalidConfigs {
+		if _, err := zone.LoadQueryZoneConfig([]byte(invalidConfig)); err != zone.ErrInvalidQueryZoneConfig {
+			t.Errorf("%v. Expected ErrInvalidQueryZoneConfig, took %v", i, err)
+		}
+	}
+}
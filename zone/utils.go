@@ -17,23 +17,23 @@ limitations under the License.
 package zone
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"github.com/cossacklabs/themis/gothemis/keys"
-	"math/rand"
-	"time"
 )
 
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
-// GenerateZoneID returns generated random zone id with length == ZoneIDLength bytes.
+// GenerateZoneID returns zone id with ZoneIDBegin tag and ZoneIDLength bytes generated by generator set with
+// SetIDGenerator, random letters by default.
 func GenerateZoneID() []byte {
-	rand.Seed(time.Now().UnixNano())
-	b := make([]byte, ZoneIDLength)
-	for i := range b {
-		b[i] = letterBytes[rand.Int63()%int64(len(letterBytes))]
-	}
-	return append(ZoneIDBegin, b...)
+	return append(append([]byte{}, ZoneIDBegin...), getIDGenerator().GenerateID()...)
+}
+
+// ValidateZoneID checks that id has length and begin tag of zone id generated by GenerateZoneID
+func ValidateZoneID(id []byte) bool {
+	return len(id) == ZoneIDBlockLength && bytes.HasPrefix(id, ZoneIDBegin)
 }
 
 // ZoneDataToJSON creates JSON representation of Zone with zone id and public key as fields.
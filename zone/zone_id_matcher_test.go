@@ -66,10 +66,23 @@ func (storage *TestKeyStore) GenerateDataEncryptionKeys(id []byte) error { retur
 func (storage *TestKeyStore) GetServerDecryptionPrivateKey(id []byte) (*keys.PrivateKey, error) {
 	return nil, nil
 }
+func (storage *TestKeyStore) GetZonePrivateKeys(id []byte) ([]*keys.PrivateKey, error) {
+	return []*keys.PrivateKey{{Value: []byte{}}}, nil
+}
+func (storage *TestKeyStore) GetServerDecryptionPrivateKeys(id []byte) ([]*keys.PrivateKey, error) {
+	return nil, nil
+}
+func (storage *TestKeyStore) RotateStorageKeys(id []byte) ([]byte, error) {
+	return nil, nil
+}
 func (keystore *TestKeyStore) GetAuthKey(remove bool) ([]byte, error) {
 	return nil, nil
 }
 func (storage *TestKeyStore) GetPoisonKeyPair() (*keys.Keypair, error) { return nil, nil }
+func (storage *TestKeyStore) GenerateHMACSecretKey(id []byte) error    { return nil }
+func (storage *TestKeyStore) GetHMACSecretKey(id []byte) ([]byte, error) {
+	return nil, nil
+}
 
 func testZoneIDMatcher(t *testing.T) {
 	var keystorage keystore.KeyStore = &TestKeyStore{}